@@ -0,0 +1,52 @@
+// Command interop checks test vectors against the Go node, so alternative
+// implementations can confirm they produce the same bytes.
+//
+//	interop [-dir tests/vectors]             verify the vectors in dir
+//	interop -generate [-dir tests/vectors]   regenerate the in-tree vectors
+//
+// An implementation that writes its own outputs in the vector format can
+// point -dir at them: every mismatch is reported with its file and vector.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Xelvra/peerchat/internal/interop"
+)
+
+func main() {
+	dir := flag.String("dir", interop.DefaultDir, "directory holding the vector files")
+	generate := flag.Bool("generate", false, "write vectors computed by this node to -dir")
+	flag.Parse()
+
+	if err := run(*dir, *generate); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, generate bool) error {
+	if generate {
+		vectors, err := interop.Generate()
+		if err != nil {
+			return fmt.Errorf("failed to generate vectors: %w", err)
+		}
+		if err := vectors.Save(dir); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Wrote %d vectors to %s\n", vectors.Count(), dir)
+		return nil
+	}
+
+	vectors, err := interop.Load(dir)
+	if err != nil {
+		return err
+	}
+	if err := vectors.Verify(); err != nil {
+		return fmt.Errorf("vectors in %s do not match:\n%w", dir, err)
+	}
+	fmt.Printf("✅ All %d vectors in %s match\n", vectors.Count(), dir)
+	return nil
+}
@@ -4,11 +4,9 @@ import (
 	"os"
 
 	"github.com/Xelvra/peerchat/internal/cli"
-	"github.com/spf13/cobra"
 )
 
 var (
-	cfgFile string
 	verbose bool
 	version = "0.4.0-alpha"
 )
@@ -22,20 +20,10 @@ func Execute() {
 	rootCmd := cli.CreateRootCommand(version)
 
 	// Add global flags
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.xelvra/config.yaml)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 
-	// Initialize configuration
-	cobra.OnInitialize(initConfig)
-
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
-
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
-	// Configuration loading temporarily disabled for debugging
-	// TODO: Implement configuration loading
-}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// stalePartAge is how long a partial download may sit untouched before gc
+// removes it without --older-than
+const stalePartAge = 24 * time.Hour
+
+// getAttachmentStore returns the data directory and the attachment store
+func getAttachmentStore() (string, *message.AttachmentStore, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", nil, err
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	store := message.NewAttachmentStore(filepath.Join(dataDir, message.AttachmentsDirName), logger)
+	return dataDir, store, nil
+}
+
+// RunAttachmentsList handles the attachments list command
+func RunAttachmentsList(cmd *cobra.Command, args []string) error {
+	dataDir, store, err := getAttachmentStore()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	attachments, err := store.List()
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if len(attachments) == 0 {
+		ui.Println("📎 No attachments received")
+		return nil
+	}
+
+	names := contactNames(dataDir)
+	var total, received int64
+	ui.Printf("📎 Attachments (%d):\n", len(attachments))
+	for _, attachment := range attachments {
+		total += attachment.Size
+		received += attachment.Size * int64(len(attachment.Refs))
+
+		ui.Printf("  %s  %s  %s\n", attachment.Hash[:12], formatBytes(attachment.Size),
+			attachment.LastReceived().Format("2006-01-02 15:04"))
+		for _, ref := range attachment.Refs {
+			sender := shortPeerID(ref.PeerID)
+			if name := names[ref.PeerID]; name != "" {
+				sender = name
+			}
+			ui.Printf("      %s from %s\n", ref.Name, sender)
+		}
+	}
+	ui.Printf("Stored %s for %s received\n", formatBytes(total), formatBytes(received))
+	return nil
+}
+
+// RunAttachmentsGC handles the attachments gc command
+func RunAttachmentsGC(cmd *cobra.Command, args []string) error {
+	olderThan, _ := cmd.Flags().GetString("older-than")
+
+	var cutoff time.Time
+	if strings.TrimSpace(olderThan) != "" {
+		var err error
+		if cutoff, err = db.ParseSince(olderThan, time.Now()); err != nil {
+			ui.Error("%v", err)
+			return generalError(err)
+		}
+	}
+
+	dataDir, store, err := getAttachmentStore()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	result, err := store.GC(cutoff)
+	if err != nil {
+		ui.Error("Failed to clean up attachments: %v", err)
+		return generalError(err)
+	}
+	released, freed := removeReleasedAttachments(dataDir, store)
+	result.Removed += released
+	result.BytesFreed += freed
+
+	// Interrupted downloads are kept for resuming; leave recent ones alone as
+	// their transfer may still be running
+	partCutoff := time.Now().Add(-stalePartAge)
+	if !cutoff.IsZero() {
+		partCutoff = cutoff
+	}
+	parts, partBytes := removeStaleParts(filepath.Join(dataDir, "downloads"), partCutoff)
+
+	ui.Success("Removed %d attachments and %d partial downloads, freed %s",
+		result.Removed, parts, formatBytes(result.BytesFreed+partBytes))
+	return nil
+}
+
+// removeReleasedAttachments deletes attachments whose messages were all
+// pruned from the history
+func removeReleasedAttachments(dataDir string, store *message.AttachmentStore) (int, int64) {
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil || history == nil {
+		return 0, 0
+	}
+	defer closeHistory()
+
+	hashes, err := history.ReleasedFiles()
+	if err != nil {
+		ui.Warn("Failed to list attachments of deleted messages: %v", err)
+		return 0, 0
+	}
+
+	removed, freed := 0, int64(0)
+	var forgotten []string
+	for _, hash := range hashes {
+		size, err := store.Remove(hash)
+		if err != nil {
+			ui.Warn("Failed to remove attachment %s: %v", hash, err)
+			continue
+		}
+		if size > 0 {
+			removed++
+			freed += size
+		}
+		forgotten = append(forgotten, hash)
+	}
+	if err := history.ForgetReleasedFiles(forgotten); err != nil {
+		ui.Warn("%v", err)
+	}
+	return removed, freed
+}
+
+// removeStaleParts deletes partial downloads in dir last written before cutoff
+func removeStaleParts(dir string, cutoff time.Time) (int, int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0
+	}
+
+	removed, freed := 0, int64(0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".part") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err == nil {
+			removed++
+			freed += info.Size()
+		}
+	}
+	return removed, freed
+}
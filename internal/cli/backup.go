@@ -0,0 +1,460 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+)
+
+// errBackupsOff is returned by the backup commands when backups are not
+// set up
+var errBackupsOff = errors.New("backups are not set up")
+
+// getBackupDataDir returns the data directory holding the backup settings
+func getBackupDataDir() (string, error) {
+	return user.DataDir()
+}
+
+// resolvePeerTarget maps a contact name or peer ID to a peer ID
+func resolvePeerTarget(dataDir, target string) (string, error) {
+	peerID := resolveContactName(dataDir, strings.TrimPrefix(target, "@"))
+	if _, err := peer.Decode(peerID); err != nil {
+		return "", fmt.Errorf("%s is neither a contact nor a peer ID", target)
+	}
+	return peerID, nil
+}
+
+// peerLabel returns a peer's contact name, or its shortened ID
+func peerLabel(names map[string]string, peerID string) string {
+	if name := names[peerID]; name != "" {
+		return name
+	}
+	return shortPeerID(peerID)
+}
+
+// printRecoveryPhrase prints the recovery phrase for a seed in rows
+func printRecoveryPhrase(seed []byte) {
+	phrase, err := user.RecoveryPhrase(seed)
+	if err != nil {
+		ui.Error("%v", err)
+		return
+	}
+
+	words := strings.Fields(phrase)
+	ui.Println("🔑 Recovery phrase:")
+	for i := 0; i < len(words); i += 6 {
+		end := min(i+6, len(words))
+		ui.Printf("  %s\n", strings.Join(words[i:end], " "))
+	}
+	ui.Warn("Write it down and keep it offline: it decrypts your backups, and")
+	ui.Println("   without it a lost device cannot be restored")
+}
+
+// RunBackupSetup handles the backup setup command
+func RunBackupSetup(cmd *cobra.Command, args []string) error {
+	interval, _ := cmd.Flags().GetDuration("every")
+	newPhrase, _ := cmd.Flags().GetBool("new-phrase")
+	if interval < time.Hour {
+		ui.Error("Back up at most once an hour")
+		return generalError(errors.New("backup interval below an hour"))
+	}
+
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	hostID, err := resolvePeerTarget(dataDir, args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		return peerNotFoundError(err)
+	}
+
+	path := filepath.Join(dataDir, message.BackupFileName)
+	settings, err := message.LoadBackupSettings(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	created := settings == nil || newPhrase
+	if settings == nil {
+		settings = &message.BackupSettings{}
+	}
+	if created {
+		if settings.Seed, err = user.NewRecoverySeed(); err != nil {
+			ui.Error("%v", err)
+			return generalError(err)
+		}
+		settings.LastBackup = time.Time{}
+	}
+	if settings.Host != hostID {
+		// Back up to the new friend at the next check
+		settings.LastBackup = time.Time{}
+	}
+	settings.Host = hostID
+	settings.Interval = message.Duration(interval)
+
+	if err := message.SaveBackupSettings(path, settings); err != nil {
+		ui.Error("Failed to save backup settings: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Backing up to %s every %s", args[0], interval)
+	if created {
+		printRecoveryPhrase(settings.Seed)
+	} else {
+		ui.Info("The recovery phrase is unchanged; 'peerchat-cli backup phrase' shows it")
+	}
+	ui.Info("%s must allow it: peerchat-cli backup allow <you>", args[0])
+	ui.Info("A running node backs up when a backup is due; 'peerchat-cli backup now' backs up at once")
+	return nil
+}
+
+// RunBackupPhrase handles the backup phrase command
+func RunBackupPhrase(cmd *cobra.Command, args []string) error {
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	settings, err := message.LoadBackupSettings(filepath.Join(dataDir, message.BackupFileName))
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if settings == nil {
+		ui.Error("Backups are not set up")
+		return configError(errBackupsOff)
+	}
+	printRecoveryPhrase(settings.Seed)
+	return nil
+}
+
+// RunBackupOff handles the backup off command
+func RunBackupOff(cmd *cobra.Command, args []string) error {
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	if err := os.Remove(filepath.Join(dataDir, message.BackupFileName)); err != nil {
+		if os.IsNotExist(err) {
+			ui.Error("Backups are not set up")
+			return configError(errBackupsOff)
+		}
+		ui.Error("Failed to turn backups off: %v", err)
+		return generalError(err)
+	}
+	ui.Success("Backups turned off")
+	ui.Info("The last backup stays with your friend until they deny you")
+	return nil
+}
+
+// RunBackupAllow handles the backup allow command
+func RunBackupAllow(cmd *cobra.Command, args []string) error {
+	quotaMB, _ := cmd.Flags().GetInt64("quota")
+	if quotaMB <= 0 {
+		ui.Error("The quota must be positive")
+		return generalError(errors.New("quota not positive"))
+	}
+
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	peerID, err := resolvePeerTarget(dataDir, args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		return peerNotFoundError(err)
+	}
+
+	path := filepath.Join(dataDir, message.BackupHostingFileName)
+	hosting, err := message.LoadBackupHosting(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	hosting.Peers[peerID] = quotaMB << 20
+	if err := message.SaveBackupHosting(path, hosting); err != nil {
+		ui.Error("Failed to save backup hosting settings: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Holding backups for %s, up to %d MB", args[0], quotaMB)
+	ui.Info("Backups are encrypted; you cannot read them")
+	return nil
+}
+
+// RunBackupDeny handles the backup deny command
+func RunBackupDeny(cmd *cobra.Command, args []string) error {
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	peerID, err := resolvePeerTarget(dataDir, args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		return peerNotFoundError(err)
+	}
+
+	path := filepath.Join(dataDir, message.BackupHostingFileName)
+	hosting, err := message.LoadBackupHosting(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	_, allowed := hosting.Peers[peerID]
+	delete(hosting.Peers, peerID)
+	if err := message.SaveBackupHosting(path, hosting); err != nil {
+		ui.Error("Failed to save backup hosting settings: %v", err)
+		return generalError(err)
+	}
+
+	store := message.NewHeldBackupStore(filepath.Join(dataDir, message.HeldBackupsDirName))
+	removed, freed, err := store.RemoveOwner(peerID)
+	if err != nil {
+		ui.Error("Failed to delete held backups: %v", err)
+		return generalError(err)
+	}
+	if !allowed && removed == 0 {
+		ui.Error("Not holding backups for %s", args[0])
+		return generalError(fmt.Errorf("not holding backups for %s", args[0]))
+	}
+	ui.Success("No longer holding backups for %s (%d deleted, %s freed)", args[0], removed, formatBytes(freed))
+	return nil
+}
+
+// RunBackupStatus handles the backup status command
+func RunBackupStatus(cmd *cobra.Command, args []string) error {
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	names := contactNames(dataDir)
+
+	settings, err := message.LoadBackupSettings(filepath.Join(dataDir, message.BackupFileName))
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	ui.Println("💾 Your backups:")
+	if settings == nil {
+		ui.Println("  Not set up ('peerchat-cli backup setup <contact>')")
+	} else {
+		ui.Printf("  Held by: %s, every %s\n", peerLabel(names, settings.Host), time.Duration(settings.Interval))
+		if settings.LastBackup.IsZero() {
+			ui.Println("  Last:    never")
+		} else {
+			ui.Printf("  Last:    %s (%s)\n", settings.LastBackup.Format("2006-01-02 15:04"), formatBytes(settings.LastSize))
+		}
+		if settings.LastError != "" {
+			ui.Printf("  ⚠️  Last attempt failed: %s\n", settings.LastError)
+		}
+	}
+
+	hosting, err := message.LoadBackupHosting(filepath.Join(dataDir, message.BackupHostingFileName))
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	held, err := message.NewHeldBackupStore(filepath.Join(dataDir, message.HeldBackupsDirName)).List()
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if len(hosting.Peers) == 0 && len(held) == 0 {
+		return nil
+	}
+
+	ui.Println()
+	ui.Println("🗄️  Backups you hold:")
+	used := make(map[string]int64)
+	stored := make(map[string]time.Time)
+	for _, backup := range held {
+		used[backup.Owner] += backup.Size
+		if backup.StoredAt.After(stored[backup.Owner]) {
+			stored[backup.Owner] = backup.StoredAt
+		}
+		if _, ok := hosting.Peers[backup.Owner]; !ok {
+			// Kept until denied, so the owner can still recover
+			hosting.Peers[backup.Owner] = -1
+		}
+	}
+	for peerID, quota := range hosting.Peers {
+		line := fmt.Sprintf("  %s: %s", peerLabel(names, peerID), formatBytes(used[peerID]))
+		if quota > 0 {
+			line += fmt.Sprintf(" of %s", formatBytes(quota))
+		} else if quota < 0 {
+			line += " (no longer allowed)"
+		}
+		if !stored[peerID].IsZero() {
+			line += fmt.Sprintf(", stored %s", stored[peerID].Format("2006-01-02 15:04"))
+		}
+		ui.Println(line)
+	}
+	return nil
+}
+
+// RunBackupNow handles the backup now command
+func RunBackupNow(cmd *cobra.Command, args []string) error {
+	// Without IPC the backup needs its own node, which cannot share the
+	// identity's port with a running one
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Warn("A node is already running and backs up when a backup is due")
+		ui.Info("Stop it to back up at once")
+		return nil
+	}
+
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	settings, err := message.LoadBackupSettings(filepath.Join(dataDir, message.BackupFileName))
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if settings == nil {
+		ui.Error("Backups are not set up ('peerchat-cli backup setup <contact>')")
+		return configError(errBackupsOff)
+	}
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	ui.Println("🔧 Initializing P2P node...")
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+
+	if wrapper.IsUsingSimulation() {
+		ui.Error("Backing up needs real P2P networking")
+		return networkError(errSimulation)
+	}
+
+	host := peerLabel(contactNames(dataDir), settings.Host)
+	ui.Printf("🔗 Connecting to %s...\n", host)
+	if !wrapper.ConnectToPeer(settings.Host) {
+		ui.Error("Peer is not reachable")
+		return peerNotFoundError(errPeerUnreachable)
+	}
+
+	ui.Println("💾 Backing up...")
+	settings, err = wrapper.BackupNow()
+	if err != nil {
+		ui.Error("Backup failed: %v", err)
+		printRefusalHint(ui.Printer{}, err)
+		return peerRefusedError(err)
+	}
+	ui.Success("Backup of %s stored with %s", formatBytes(settings.LastSize), host)
+	return nil
+}
+
+// RunBackupRestore handles the backup restore command
+func RunBackupRestore(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	target := args[0]
+
+	// The restore replaces files a running node has open
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Error("Stop the running node before restoring a backup")
+		return generalError(errNodeRunning)
+	}
+
+	dataDir, err := getBackupDataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, user.IdentityFileName)); err == nil && !force {
+		ui.Error("An identity already exists here; restoring replaces it and your data")
+		ui.Info("Use --force to restore anyway")
+		return generalError(errors.New("an identity already exists"))
+	}
+
+	ui.Print("🔑 Recovery phrase: ")
+	phrase, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && phrase == "" {
+		ui.Printf("\n❌ Failed to read the recovery phrase: %v\n", err)
+		return generalError(err)
+	}
+	seed, err := user.ParseRecoveryPhrase(phrase)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	ui.Println("🔧 Initializing P2P node...")
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}
+	defer stop()
+
+	if wrapper.IsUsingSimulation() {
+		ui.Error("Restoring needs real P2P networking")
+		return networkError(errSimulation)
+	}
+
+	peerID, err := wrapper.ResolvePeer(target)
+	if err != nil {
+		ui.Error("Failed to resolve %s: %v", target, err)
+		return peerNotFoundError(err)
+	}
+	ui.Printf("🔗 Connecting to %s...\n", target)
+	if !wrapper.ConnectToPeer(peerID) {
+		ui.Error("Peer is not reachable")
+		return peerNotFoundError(errPeerUnreachable)
+	}
+
+	ui.Println("📥 Fetching backup...")
+	snapshot, err := wrapper.FetchBackup(peerID, seed)
+	if err != nil {
+		ui.Error("Failed to fetch backup: %v", err)
+		printRefusalHint(ui.Printer{}, err)
+		return peerRefusedError(err)
+	}
+
+	// The temporary node's own files are replaced by the backup's
+	stop()
+	restored, err := p2p.RestoreBackupSnapshot(dataDir, bytes.NewReader(snapshot))
+	if err != nil {
+		ui.Error("Restore failed after %d file(s): %v", restored, err)
+		return generalError(err)
+	}
+	ui.Success("Restored %d file(s) (%s) from %s", restored, formatBytes(int64(len(snapshot))), target)
+	ui.Info("Received files are not backed up; ask your contacts to send them again")
+	return nil
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+)
+
+// maxTrafficPeers is how many of the busiest peers status --verbose lists
+const maxTrafficPeers = 10
+
+// printPeerTraffic lists the peers that exchanged the most traffic with the
+// node, across runs
+func printPeerTraffic() {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return
+	}
+	traffic, err := p2p.LoadBandwidth(filepath.Join(dataDir, p2p.BandwidthFileName))
+	if err != nil {
+		ui.Warn("Failed to read traffic totals: %v", err)
+		return
+	}
+
+	ui.Println()
+	ui.Println("📶 Traffic by peer (received / sent, all runs):")
+	if len(traffic) == 0 {
+		ui.Println("  No traffic recorded yet")
+		return
+	}
+	names := contactNames(dataDir)
+	for i, t := range traffic {
+		if i == maxTrafficPeers {
+			ui.Printf("  … and %d more peer(s)\n", len(traffic)-maxTrafficPeers)
+			break
+		}
+		ui.Printf("  %-16s %10s / %-10s%s\n", peerLabel(names, t.PeerID), formatBytes(t.BytesIn), formatBytes(t.BytesOut), formatTrafficRate(t))
+	}
+}
+
+// formatTrafficRate describes the rates last measured for a peer, or
+// returns "" when it was idle
+func formatTrafficRate(t p2p.PeerTraffic) string {
+	if t.RateIn < 1 && t.RateOut < 1 {
+		return ""
+	}
+	return fmt.Sprintf("  (%s/s in, %s/s out at %s)", formatBytes(int64(t.RateIn)), formatBytes(int64(t.RateOut)), t.Updated.Format("15:04:05"))
+}
+
+// printProfileTraffic shows the traffic exchanged with a peer
+func printProfileTraffic(out ui.Printer, wrapper *p2p.P2PWrapper, peerID string) {
+	t, err := wrapper.PeerTraffic(peerID)
+	if err != nil {
+		return
+	}
+	out.Printf("  Traffic: %s received, %s sent%s\n", formatBytes(t.BytesIn), formatBytes(t.BytesOut), formatTrafficRate(t))
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+)
+
+// handleBatteryCommand handles /battery [on|off]: without an argument it
+// shows the energy profile of the running node
+func handleBatteryCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 {
+		profile := wrapper.EnergyProfile()
+		if profile == nil {
+			out.Error("No energy profile: the node is not running")
+			return networkError(errNodeNotRunning)
+		}
+		printEnergyProfile(out, profile)
+		if profile.BatterySaver {
+			out.Info("Use '/battery off' for faster discovery")
+		} else {
+			out.Info("Use '/battery on' to save battery")
+		}
+		return nil
+	}
+
+	var on bool
+	switch args[0] {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	default:
+		out.Error("Usage: /battery [on|off]")
+		return generalError(errUsage)
+	}
+	if err := wrapper.ChangeBatterySaver(on); err != nil {
+		out.Error("Failed to change battery saver mode: %v", err)
+		return generalError(err)
+	}
+	if on {
+		out.Printf("🔋 Battery saver on: discovery every %dx as long, keep-alives together, DHT refreshes skipped after %s idle\n",
+			p2p.BatterySaverFactor, p2p.BatterySaverIdleAfter)
+	} else {
+		out.Println("🔋 Battery saver off")
+	}
+	return nil
+}
+
+// printEnergyProfile shows the estimated power use against the idle
+// budget, and what battery saver mode is doing
+func printEnergyProfile(out ui.Printer, profile *p2p.EnergyProfile) {
+	out.Println("⚡ Energy:")
+	if profile.EstimatedPowerMW == 0 {
+		out.Println("  Estimated power: not measured yet")
+	} else {
+		budget := "✅ within"
+		if !profile.WithinEnergyBudget {
+			budget = "⚠️  above"
+		}
+		out.Printf("  Estimated power: %.1fmW (%s the %.0fmW idle budget)\n", profile.EstimatedPowerMW, budget, profile.EnergyBudgetMW)
+		out.Printf("  CPU: %.2f%%, memory: %dMB, radio wake-ups: %.1f/min\n",
+			profile.CPUUsagePercent, profile.MemoryUsageMB, profile.WakeupsPerMinute)
+	}
+
+	saver := "off"
+	switch {
+	case profile.BatterySaver && profile.RefreshSuspended:
+		saver = "on, idle: DHT refreshes suspended"
+	case profile.BatterySaver:
+		saver = "on"
+	}
+	out.Printf("  Battery saver: %s\n", saver)
+	if profile.DeepSleepActive {
+		out.Printf("  Deep sleep: active at %.0f%% battery\n", profile.BatteryLevel*100)
+	}
+	out.Printf("  DHT polling: every %s, heartbeat every %s\n", profile.DHTPollInterval, profile.HeartbeatInterval)
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// benchResult is the JSON form of a benchmark
+type benchResult struct {
+	*p2p.BenchReport
+	LatencyTargetMs   int  `json:"latency_target_ms"`
+	MeetsLatency      bool `json:"meets_latency_target"`
+	MemoryTargetBytes int  `json:"memory_target_bytes"`
+	MeetsMemory       bool `json:"meets_memory_target"`
+}
+
+// RunBench handles the bench command
+func RunBench(cmd *cobra.Command, args []string) error {
+	messages, _ := cmd.Flags().GetInt("messages")
+	sizeFlag, _ := cmd.Flags().GetString("file-size")
+	transport, _ := cmd.Flags().GetString("transport")
+	if messages < 1 || messages > p2p.MaxBenchMessages {
+		ui.Error("--messages must be between 1 and %d", p2p.MaxBenchMessages)
+		return generalError(errors.New("invalid message count"))
+	}
+	fileSize, err := message.ParseSize(sizeFlag)
+	if err != nil || fileSize > p2p.MaxBenchFileSize {
+		ui.Error("--file-size must be a size up to %s, such as 16MB, or 0 to skip the transfer", formatBytes(p2p.MaxBenchFileSize))
+		return generalError(errors.New("invalid file size"))
+	}
+	if !slices.Contains(p2p.BenchTransports, transport) {
+		ui.Error("--transport must be tcp or quic, got %q", transport)
+		return generalError(errors.New("invalid transport"))
+	}
+
+	// The nodes keep their queues and the received file in a scratch data
+	// directory, leaving the real one alone
+	scratch, err := os.MkdirTemp("", "peerchat-bench-")
+	if err != nil {
+		ui.Error("Failed to create scratch directory: %v", err)
+		return generalError(err)
+	}
+	defer func() { _ = os.RemoveAll(scratch) }()
+	previous, hadPrevious := os.LookupEnv(user.DataDirEnv)
+	_ = os.Setenv(user.DataDirEnv, scratch)
+	defer func() {
+		if hadPrevious {
+			_ = os.Setenv(user.DataDirEnv, previous)
+		} else {
+			_ = os.Unsetenv(user.DataDirEnv)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := p2p.RunBench(ctx, p2p.BenchOptions{
+		Messages:  messages,
+		FileSize:  fileSize,
+		Transport: transport,
+		Progress:  func(stage string) { ui.Printf("⏳ %s...\n", stage) },
+	})
+	if err != nil {
+		ui.Error("Benchmark failed: %v", err)
+		return networkError(err)
+	}
+	printBenchReport(report)
+
+	if jsonMode() {
+		printJSON(benchResult{
+			BenchReport:       report,
+			LatencyTargetMs:   p2p.MaxLatencyMs,
+			MeetsLatency:      report.MeetsLatencyTarget(),
+			MemoryTargetBytes: 2 * p2p.MaxIdleMemoryMB << 20,
+			MeetsMemory:       report.MeetsMemoryTarget(),
+		})
+	}
+	return nil
+}
+
+// printBenchReport shows what a benchmark measured and how it compares
+// with the performance targets
+func printBenchReport(report *p2p.BenchReport) {
+	ui.Printf("\n📊 Benchmark over loopback %s\n", report.Transport)
+	ui.Printf("⏱️  Latency over %d messages min/avg/p95/max: %s / %s / %s / %s\n",
+		report.Messages, formatRTT(report.LatencyMin), formatRTT(report.LatencyAvg),
+		formatRTT(report.LatencyP95), formatRTT(report.LatencyMax))
+	ui.Printf("📨 Throughput: %.0f messages/s\n", report.MessagesPerSecond)
+	if report.FileSize > 0 {
+		ui.Printf("📁 File transfer: %s in %s (%s/s)\n", formatBytes(report.FileSize),
+			report.FileDuration.Round(time.Millisecond), formatBytes(int64(report.FileBytesPerSecond)))
+	}
+	memoryLabel := "RSS"
+	if report.RSSApprox {
+		memoryLabel = "Memory from the system"
+	}
+	ui.Printf("💾 %s with both nodes idle: %s, after the runs: %s\n", memoryLabel,
+		formatBytes(int64(report.IdleRSSBytes)), formatBytes(int64(report.PeakRSSBytes)))
+
+	ui.Println("\n🎯 Targets")
+	if report.MeetsLatencyTarget() {
+		ui.Success("Average latency within the %dms target", p2p.MaxLatencyMs)
+	} else {
+		ui.Warn("Average latency above the %dms target", p2p.MaxLatencyMs)
+	}
+	if report.MeetsMemoryTarget() {
+		ui.Success("Idle memory within the %dMB target for each node", p2p.MaxIdleMemoryMB)
+	} else {
+		ui.Warn("Idle memory above the %dMB target for each node", p2p.MaxIdleMemoryMB)
+	}
+	ui.Info("Loopback shows the cost of the node itself; real networks add their own latency")
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// bootstrapKey is the key of the bootstrap peers in the configuration file
+const bootstrapKey = "bootstrap_peers"
+
+// loadBootstrapConfig reads the configuration file for the bootstrap
+// commands, reporting failures to the user
+func loadBootstrapConfig(cmd *cobra.Command) (string, *p2p.ConfigFile, error) {
+	path, err := configFilePath(cmd)
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return "", nil, configError(err)
+	}
+	config, err := p2p.LoadConfigFile(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return "", nil, configError(err)
+	}
+	return path, config, nil
+}
+
+// RunBootstrapList handles the bootstrap list command
+func RunBootstrapList(cmd *cobra.Command, args []string) error {
+	path, config, err := loadBootstrapConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	addrs := config.BootstrapPeers
+	if len(addrs) == 0 {
+		addrs = p2p.DefaultBootstrapAddrs()
+		ui.Println("🌐 Default bootstrap peers:")
+	} else {
+		ui.Printf("🌐 Bootstrap peers from %s:\n", path)
+	}
+
+	check, _ := cmd.Flags().GetBool("check")
+	if !check {
+		for i, addr := range addrs {
+			ui.Printf("  %d. %s\n", i+1, addr)
+		}
+		if len(config.BootstrapPeers) == 0 {
+			ui.Info("Add your own with 'peerchat-cli bootstrap add <multiaddr>'")
+		} else {
+			ui.Info("The default bootstrap peers are used only while none of these can be reached")
+		}
+		return nil
+	}
+
+	peers, err := p2p.ResolveBootstrapPeers(context.Background(), addrs)
+	if err != nil {
+		ui.Warn("%v", err)
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+	psk, err := configuredSwarmKey(path, config)
+	if err != nil {
+		ui.Error("Failed to load the swarm key of the private network: %v", err)
+		return configError(err)
+	}
+
+	ui.Printf("🔍 Connecting to %d peer(s)...\n", len(peers))
+	health, err := p2p.CheckBootstrapPeers(context.Background(), peers, psk)
+	if err != nil {
+		ui.Error("%v", err)
+		return networkError(err)
+	}
+	reachable := 0
+	for i, h := range health {
+		if h.Reachable {
+			reachable++
+			ui.Printf("  %d. %s ✅ %s\n", i+1, h.PeerID, h.RTT.Round(time.Millisecond))
+		} else {
+			ui.Printf("  %d. %s ❌ %s\n", i+1, h.PeerID, h.Error)
+		}
+	}
+	ui.Printf("📊 %d of %d bootstrap peer(s) reachable\n", reachable, len(health))
+	if reachable == 0 && len(config.BootstrapPeers) > 0 {
+		ui.Warn("The node falls back to the default bootstrap peers while none of these answer")
+	}
+	return nil
+}
+
+// RunBootstrapAdd handles the bootstrap add command
+func RunBootstrapAdd(cmd *cobra.Command, args []string) error {
+	path, config, err := loadBootstrapConfig(cmd)
+	if err != nil {
+		return err
+	}
+	addr := strings.TrimSpace(args[0])
+	if err := p2p.ValidateBootstrapAddrs([]string{addr}); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if slices.Contains(config.BootstrapPeers, addr) {
+		ui.Success("%s is already a bootstrap peer", addr)
+		return nil
+	}
+
+	if err := p2p.SetConfigValue(path, bootstrapKey, append(config.BootstrapPeers, addr)); err != nil {
+		ui.Error("Failed to save %s: %v", path, err)
+		return generalError(err)
+	}
+	ui.Success("Added bootstrap peer %s", addr)
+	if len(config.BootstrapPeers) == 0 {
+		ui.Info("The default bootstrap peers are now used only while none of yours can be reached")
+	}
+	ui.Info("Takes effect the next time the node starts")
+	return nil
+}
+
+// RunBootstrapRemove handles the bootstrap remove command; the argument is
+// an address or the ID of a peer, which removes all of its addresses
+func RunBootstrapRemove(cmd *cobra.Command, args []string) error {
+	path, config, err := loadBootstrapConfig(cmd)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSpace(args[0])
+
+	var kept []string
+	for _, addr := range config.BootstrapPeers {
+		if addr != target && !strings.HasSuffix(addr, "/p2p/"+target) {
+			kept = append(kept, addr)
+		}
+	}
+	if len(kept) == len(config.BootstrapPeers) {
+		ui.Error("%s is not a configured bootstrap peer", target)
+		ui.Info("Use 'peerchat-cli bootstrap list' to see them")
+		return generalError(fmt.Errorf("%s is not a configured bootstrap peer", target))
+	}
+
+	if len(kept) == 0 {
+		err = p2p.UnsetConfigValue(path, bootstrapKey)
+	} else {
+		err = p2p.SetConfigValue(path, bootstrapKey, kept)
+	}
+	if err != nil {
+		ui.Error("Failed to save %s: %v", path, err)
+		return generalError(err)
+	}
+	ui.Success("Removed %d bootstrap address(es)", len(config.BootstrapPeers)-len(kept))
+	if len(kept) == 0 {
+		ui.Info("No bootstrap peers left, the default ones apply again")
+	}
+	ui.Info("Takes effect the next time the node starts")
+	return nil
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+)
+
+// formatPeerProtocol describes the protocol version and features agreed
+// with a peer for /peers, or returns "" when the handshake has not run
+func formatPeerProtocol(wrapper *p2p.P2PWrapper, peerID string) string {
+	c, err := wrapper.PeerCapabilities(peerID)
+	switch {
+	case err != nil:
+		return ""
+	case !c.Compatible():
+		return fmt.Sprintf(" ⚠️ incompatible (speaks v%d-v%d)", c.Remote.MinVersion, c.Remote.Version)
+	case c.Legacy:
+		return " protocol v1 (older client)"
+	case len(c.Features) == 0:
+		return fmt.Sprintf(" protocol v%d", c.Version)
+	}
+	return fmt.Sprintf(" protocol v%d [%s]", c.Version, strings.Join(c.Features, ", "))
+}
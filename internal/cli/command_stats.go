@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandStatsFileName holds chat command usage counts in the data directory.
+// The counts never leave this machine.
+const CommandStatsFileName = "command_stats.json"
+
+// CommandUsage is how often a chat command was used
+type CommandUsage struct {
+	Command  string    `json:"-"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// CommandStats tracks chat command usage to order completions and suggest
+// commands for typos
+type CommandStats struct {
+	path     string
+	mu       sync.Mutex
+	commands map[string]*CommandUsage
+}
+
+// LoadCommandStats reads the usage counts stored at path. An empty path keeps
+// the counts in memory only.
+func LoadCommandStats(path string) (*CommandStats, error) {
+	stats := &CommandStats{path: path, commands: make(map[string]*CommandUsage)}
+	if path == "" {
+		return stats, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &stats.commands); err != nil {
+		return nil, fmt.Errorf("failed to parse command stats: %w", err)
+	}
+	for command, usage := range stats.commands {
+		if usage == nil {
+			delete(stats.commands, command)
+			continue
+		}
+		usage.Command = command
+	}
+	return stats, nil
+}
+
+// Record counts one use of a command and saves the counts
+func (s *CommandStats) Record(command string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage, ok := s.commands[command]
+	if !ok {
+		usage = &CommandUsage{Command: command}
+		s.commands[command] = usage
+	}
+	usage.Count++
+	usage.LastUsed = time.Now()
+	return s.save()
+}
+
+// Reset forgets all usage counts
+func (s *CommandStats) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands = make(map[string]*CommandUsage)
+	return s.save()
+}
+
+// Count returns how often a command was used
+func (s *CommandStats) Count(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if usage, ok := s.commands[command]; ok {
+		return usage.Count
+	}
+	return 0
+}
+
+// Usage returns the recorded commands, most used first
+func (s *CommandStats) Usage() []CommandUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make([]CommandUsage, 0, len(s.commands))
+	for _, u := range s.commands {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Count != usage[j].Count {
+			return usage[i].Count > usage[j].Count
+		}
+		return usage[i].Command < usage[j].Command
+	})
+	return usage
+}
+
+// Rank orders commands by usage, most used first. Commands used equally
+// often keep their order.
+func (s *CommandStats) Rank(commands []string) []string {
+	ranked := append([]string(nil), commands...)
+	counts := make(map[string]int, len(ranked))
+	for _, command := range ranked {
+		counts[command] = s.Count(command)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return counts[ranked[i]] > counts[ranked[j]]
+	})
+	return ranked
+}
+
+// Suggest returns the command the user most likely meant by input, or an
+// empty string if none is close. A unique prefix wins; otherwise the closest
+// command within two edits is chosen, preferring commands used more often.
+func (s *CommandStats) Suggest(input string, commands []string) string {
+	var prefixed []string
+	for _, command := range commands {
+		if strings.HasPrefix(command, input) {
+			prefixed = append(prefixed, command)
+		}
+	}
+	if len(prefixed) == 1 {
+		return prefixed[0]
+	}
+
+	best, bestDistance := "", 3
+	for _, command := range s.Rank(commands) {
+		distance := editDistance(input, command)
+		// Short commands need a closer match to count as a typo
+		if distance >= bestDistance || distance*2 >= len(command) {
+			continue
+		}
+		best, bestDistance = command, distance
+	}
+	return best
+}
+
+// save writes the counts to disk; the caller holds the lock
+func (s *CommandStats) save() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s.commands, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent characters that turn a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
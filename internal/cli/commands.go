@@ -1,6 +1,13 @@
 package cli
 
 import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
 	"github.com/spf13/cobra"
 )
 
@@ -15,6 +22,7 @@ QUICK START:
   1. peerchat-cli init     # Create your identity
   2. peerchat-cli doctor   # Test network connectivity
   3. peerchat-cli start    # Start interactive chat
+     peerchat-cli tui      # ...or the full-screen chat
 
 STANDALONE COMMANDS (no running node required):
   init, doctor, version, manual, help
@@ -23,7 +31,7 @@ INTERACTIVE COMMANDS (available in chat mode):
   /help, /peers, /discover, /connect, /status, /quit
 
 NODE-DEPENDENT COMMANDS (require running node):
-  send, discover, status, listen
+  send, discover, status, peers, listen
 
 Performance targets:
 - Latency: <50ms for direct connections
@@ -32,22 +40,82 @@ Performance targets:
 		Version: version,
 	}
 
+	rootCmd.PersistentFlags().Bool(allowDirectFlag, false, "Keep LAN discovery and direct connections when a SOCKS proxy (Tor) is configured; may reveal your IP address")
+	rootCmd.PersistentFlags().Bool(ephemeralDHTFlag, false, "Take part in the DHT under a new identity on every start, unlinked from your peer ID and DID")
+	rootCmd.PersistentFlags().Int(fileStreamsFlag, message.FileMaxStreams, "Streams a file of 8 MB or more may be split across when sending or receiving (1 disables parallel streams)")
+	rootCmd.PersistentFlags().Bool(fileCompressionFlag, true, "Compress file chunks with zstd when both sides support it and the content shrinks")
+	rootCmd.PersistentFlags().String(quicMTUFlag, p2p.QUICMTUAuto, "QUIC packet sizes: auto discovers the path MTU except on networks 'doctor' found dropping large packets, safe always sends 1200-byte packets")
+	rootCmd.PersistentFlags().String(traceFlag, "", "Record how long each hop of a message takes, to a trace file or an OTLP/HTTP collector URL (e.g. http://localhost:4318)")
+	rootCmd.PersistentFlags().Lookup(traceFlag).NoOptDefVal = traceDefaultTarget
+	rootCmd.PersistentFlags().String(wakeRelayFlag, "", "Stay registered with this wake host (multiaddr ending in /p2p/<peer ID>) so peers can wake the node while it sleeps behind a NAT")
+	rootCmd.PersistentFlags().Bool(serveWakeFlag, false, "Let peers register with this node to be woken, and pass wakes on to them")
+	rootCmd.PersistentFlags().Int(pprofFlag, 0, "Serve pprof profiles on this localhost port for this run, as 'debug enable' does for every run")
+	rootCmd.PersistentFlags().Lookup(pprofFlag).NoOptDefVal = strconv.Itoa(p2p.DefaultDiagnosticsPort)
+	_ = rootCmd.PersistentFlags().MarkHidden(pprofFlag)
+	rootCmd.PersistentFlags().Bool(batterySaverFlag, false, "Save battery on laptops and phones: discover peers less often, send keep-alives together and skip DHT refreshes while idle (toggle with /battery)")
+	rootCmd.PersistentFlags().String(storageFlag, db.SQLiteBackend, "Storage backend for history, offline messages and contacts: "+strings.Join(db.Backends(), ", ")+" (memory keeps nothing after exit)")
+	rootCmd.PersistentFlags().Int(maxConnectionsFlag, p2p.DefaultConnHighWater, "Open connections above which idle ones to strangers are closed, down to half as many; contacts and transfers are kept")
+	rootCmd.PersistentFlags().Bool(browserFlag, false, "Listen for WebTransport and WebRTC connections so browser clients can connect without a gateway (needs QUIC)")
+	rootCmd.PersistentFlags().Bool(torFlag, false, "Dial and listen through a local Tor daemon (onion service); disables mDNS, UDP broadcast, QUIC and direct connections")
+	rootCmd.PersistentFlags().String(torSOCKSFlag, p2p.DefaultTorSOCKS, "SOCKS port of the Tor daemon used with --tor")
+	rootCmd.PersistentFlags().String(torControlFlag, p2p.DefaultTorControl, "Control port of the Tor daemon used with --tor, to publish the onion service")
+	rootCmd.PersistentFlags().String(visibilityFlag, "", "Who discovery announces this node to: everyone, contacts-of-contacts, contacts or invisible (default: the level last set with /visibility)")
+	rootCmd.PersistentFlags().String(configFlag, "", "Configuration file (default is $HOME/.xelvra/config.yaml)")
+	rootCmd.PersistentFlags().String(profileFlag, "", "Use this profile of the configuration file instead of the one matching the current network")
+	addOutputFlags(rootCmd)
+	rootCmd.PersistentFlags().Bool(jsonFlag, false, "Print results as JSON to stdout and other output to stderr (status, discover, peers, id, doctor, send, ping, bench)")
+	rootCmd.PersistentPreRunE = prepareCommand
+
 	// Add subcommands
 	rootCmd.AddCommand(createInitCommand())
 	rootCmd.AddCommand(createStartCommand())
+	rootCmd.AddCommand(createTUICommand())
+	rootCmd.AddCommand(createScriptCommand())
 	rootCmd.AddCommand(createStatusCommand())
 	rootCmd.AddCommand(createVersionCommand(version))
 	rootCmd.AddCommand(createSendCommand())
 	rootCmd.AddCommand(createConnectCommand())
 	rootCmd.AddCommand(createListenCommand())
 	rootCmd.AddCommand(createDiscoverCommand())
+	rootCmd.AddCommand(createPeersCommand())
 	rootCmd.AddCommand(createIdCommand())
 	rootCmd.AddCommand(createProfileCommand())
+	rootCmd.AddCommand(createPingCommand())
+	rootCmd.AddCommand(createBenchCommand())
 	rootCmd.AddCommand(createSendFileCommand())
+	rootCmd.AddCommand(createQueueCommand())
 	rootCmd.AddCommand(createStopCommand())
 	rootCmd.AddCommand(createSetupCommand())
 	rootCmd.AddCommand(createDoctorCommand())
 	rootCmd.AddCommand(createManualCommand(version))
+	rootCmd.AddCommand(createInviteCommand())
+	rootCmd.AddCommand(createJoinCommand())
+	rootCmd.AddCommand(createRotateKeyCommand())
+	rootCmd.AddCommand(createNameCommand())
+	rootCmd.AddCommand(createPinCommand())
+	rootCmd.AddCommand(createHistoryCommand())
+	rootCmd.AddCommand(createSyncDirCommand())
+	rootCmd.AddCommand(createSearchCommand())
+	rootCmd.AddCommand(createSyncCommand())
+	rootCmd.AddCommand(createExportCommand())
+	rootCmd.AddCommand(createWatchCommand())
+	rootCmd.AddCommand(createRetentionCommand())
+	rootCmd.AddCommand(createTimeoutsCommand())
+	rootCmd.AddCommand(createAttachmentsCommand())
+	rootCmd.AddCommand(createQuotaCommand())
+	rootCmd.AddCommand(createFilterCommand())
+	rootCmd.AddCommand(createHookCommand())
+	rootCmd.AddCommand(createAutoAcceptCommand())
+	rootCmd.AddCommand(createDataCommand())
+	rootCmd.AddCommand(createBackupCommand())
+	rootCmd.AddCommand(createTraceCommand())
+	rootCmd.AddCommand(createLogsCommand())
+	rootCmd.AddCommand(createDebugCommand())
+	rootCmd.AddCommand(createNetworksCommand())
+	rootCmd.AddCommand(createSwarmKeyCommand())
+	rootCmd.AddCommand(createBootstrapCommand())
+	rootCmd.AddCommand(createConfigCommand())
+	rootCmd.AddCommand(createStarCommands()...)
 
 	return rootCmd
 }
@@ -57,7 +125,7 @@ func createInitCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "init",
 		Short: "Initialize a new Xelvra identity and configuration",
-		Run:   RunInit,
+		RunE:  RunInit,
 	}
 }
 
@@ -66,26 +134,63 @@ func createStartCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "start",
 		Short: "Start the P2P node and begin interactive chat",
-		Run:   RunStart,
+		RunE:  RunStart,
 	}
 	cmd.Flags().Bool("daemon", false, "Run as background daemon")
 	return cmd
 }
 
+// createTUICommand creates the tui command
+func createTUICommand() *cobra.Command {
+	return &cobra.Command{
+		Use:         "tui",
+		Short:       "Start the P2P node and chat full-screen, with a peer sidebar and unread badges",
+		Args:        cobra.NoArgs,
+		RunE:        RunTUI,
+		Annotations: map[string]string{screenAnnotation: "true"},
+	}
+}
+
+// createScriptCommand creates the script command
+func createScriptCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "script [file|-]",
+		Short: "Run chat commands from a file, stdin or --exec, exiting non-zero when one fails",
+		Long: `Run chat commands and messages without interaction, one per line, or
+separated by semicolons with --exec, on the running node, or on one started
+for the script when none runs. Lines starting with # are comments. The script stops
+at the first command that fails and exits with its status, e.g. 5 for an
+unknown peer, or 2 when no node can be started or reached.
+
+Examples:
+  peerchat-cli script --exec "/msg alice build finished; /history alice 5"
+  echo "/peers" | peerchat-cli script -
+  peerchat-cli script --keep-going nightly.chat`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunScript,
+	}
+	cmd.Flags().StringP("exec", "e", "", "Commands to run, separated by semicolons")
+	cmd.Flags().Bool("keep-going", false, "Run the remaining commands after one fails")
+	return cmd
+}
+
 // createStatusCommand creates the status command
 func createStatusCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Display current node status and statistics",
-		Run:   RunStatus,
+		RunE:  RunStatus,
 	}
+	cmd.Flags().BoolP("verbose", "v", false, "Also list the traffic exchanged with each peer")
+	return withJSON(cmd)
 }
 
 // createVersionCommand creates the version command
 func createVersionCommand(version string) *cobra.Command {
 	return &cobra.Command{
-		Use:   "version",
-		Short: "Show version information",
+		Use:         "version",
+		Short:       "Show version information",
+		Annotations: map[string]string{noConfigAnnotation: "true"},
 		Run: func(cmd *cobra.Command, args []string) {
 			RunVersion(version)
 		},
@@ -94,22 +199,29 @@ func createVersionCommand(version string) *cobra.Command {
 
 // createSendCommand creates the send command
 func createSendCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "send [peer_id] [message]",
-		Short: "Send a message to a peer",
+	return withJSON(&cobra.Command{
+		Use:   "send [peer_id|contact|@name] [message]",
+		Short: "Send a message to a peer through the running node",
 		Args:  cobra.ExactArgs(2),
-		Run:   RunSend,
-	}
+		RunE:  RunSend,
+	})
 }
 
 // createConnectCommand creates the connect command
 func createConnectCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "connect [peer_id]",
+	cmd := &cobra.Command{
+		Use:   "connect [peer_id|xelvra://peer/...|multiaddr]",
 		Short: "Connect to a peer",
-		Args:  cobra.ExactArgs(1),
-		Run:   RunConnect,
+		Long: `Connect to a peer. With an identity link, as shown by 'id' and in its
+QR code, the peer is dialed at the addresses in the link; --name saves it
+as a contact, pinning its key. A multiaddr ending in /p2p/<peer ID> works
+the same way, as does /dnsaddr/<domain> when the domain lists one peer.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunConnect,
 	}
+	cmd.Flags().String("uri", "", "Identity link of the peer, e.g. scanned from its QR code")
+	cmd.Flags().String("name", "", "Save the peer of the link or address as a contact under this name")
+	return cmd
 }
 
 // createListenCommand creates the listen command
@@ -117,54 +229,189 @@ func createListenCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "listen",
 		Short: "Listen for incoming messages (passive mode)",
-		Run:   RunListen,
+		RunE:  RunListen,
 	}
 }
 
 // createDiscoverCommand creates the discover command
 func createDiscoverCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "discover",
 		Short: "Discover peers in the network",
-		Run:   RunDiscover,
+		Long: `Discover peers in the network. Without --watch the running node is
+monitored for 10 seconds; with --watch each peer it finds is shown as it is
+found, once, until interrupted. With --json the result, or each event or
+node found, is printed as a JSON object on its own line.
+
+With --subnet every address of an IPv4 range, at most a /22, is probed on
+the Xelvra discovery port (UDP 42424), for networks that filter multicast
+and mDNS. Nodes visible to everyone answer with their signed identity and
+addresses; no running node is needed to scan.`,
+		RunE: RunDiscover,
 	}
+	cmd.Flags().Bool("watch", false, "Stream discovery events live until interrupted")
+	cmd.Flags().String("subnet", "", "Probe each address of an IPv4 subnet, e.g. 192.168.50.0/24, on the discovery port")
+	return withJSON(cmd)
 }
 
 // createIdCommand creates the id command
 func createIdCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "id",
 		Short: "Show your identity information",
-		Run:   RunShowID,
+		RunE:  RunShowID,
 	}
+	cmd.Flags().Bool("qr", false, "Show your identity link as a QR code to scan with a phone")
+	return withJSON(cmd)
+}
+
+// createPeersCommand creates the peers command
+func createPeersCommand() *cobra.Command {
+	return withJSON(&cobra.Command{
+		Use:   "peers",
+		Short: "List the peers the running node is connected to",
+		Args:  cobra.NoArgs,
+		RunE:  RunPeers,
+	})
 }
 
-// createProfileCommand creates the profile command
+// createPingCommand creates the ping command
+func createPingCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ping [peer_id|@name]",
+		Short: "Measure the round trip time to a peer",
+		Long: `Measure the round trip time to a peer with small probes it echoes back,
+and show the lowest, average and highest. Direct connections are compared
+with the 50ms latency target; for relayed connections the relay is named,
+as their latency is that of the relay's path.`,
+		Args: cobra.ExactArgs(1),
+		RunE: RunPing,
+	}
+	cmd.Flags().IntP("count", "c", p2p.DefaultPingCount, "Number of probes to send")
+	cmd.Flags().DurationP("interval", "i", p2p.DefaultPingInterval, "Time between probes")
+	return withJSON(cmd)
+}
+
+// createBenchCommand creates the bench command
+func createBenchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure message latency, throughput and file transfer speed",
+		Long: `Start two nodes in this process with throwaway identities, connect them
+over loopback and measure message latency, message throughput and file
+transfer speed. The report compares latency with the 50ms target and idle
+memory with the 20MB target for each node. Nothing is sent over the network
+and your identity and data are not touched.`,
+		Args: cobra.NoArgs,
+		RunE: RunBench,
+	}
+	cmd.Flags().IntP("messages", "n", p2p.DefaultBenchMessages, "Messages in each of the latency and throughput runs")
+	cmd.Flags().String("file-size", "16MB", "Size of the file to transfer, or 0 to skip the transfer")
+	cmd.Flags().String("transport", "tcp", "Loopback transport: tcp or quic")
+	return withJSON(cmd)
+}
+
+// createProfileCommand creates the profile command with its subcommands
 func createProfileCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:   "profile [peer_id]",
-		Short: "Show profile information for a peer",
+	cmd := &cobra.Command{
+		Use:   "profile [peer_id|@name]",
+		Short: "Show a peer's profile, or manage the facets you show others",
+		Long: `With a peer, fetch the profile it shows you. Without one, list your
+profile facets: different display names, avatars and fields, such as a work
+and a personal profile. Each peer is shown the facet assigned to it, or the
+default facet.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunProfile,
+	}
+
+	facetCmd := &cobra.Command{
+		Use:   "facet",
+		Short: "Create, change or remove profile facets",
+	}
+	setCmd := &cobra.Command{
+		Use:   "set <facet>",
+		Short: "Create or change a profile facet",
 		Args:  cobra.ExactArgs(1),
-		Run:   RunProfile,
+		RunE:  RunProfileFacetSet,
 	}
+	setCmd.Flags().String("display-name", "", "Name shown to peers who see this facet")
+	setCmd.Flags().String("avatar", "", "Image file shown as the avatar (up to 256 KB)")
+	setCmd.Flags().Bool("no-avatar", false, "Remove the avatar")
+	setCmd.Flags().StringArray("field", nil, "Visible field as key=value, e.g. email=jane@example.com (repeatable)")
+	setCmd.Flags().StringArray("remove-field", nil, "Remove a field (repeatable)")
+	facetCmd.AddCommand(setCmd, &cobra.Command{
+		Use:   "remove <facet>",
+		Short: "Remove a profile facet",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunProfileFacetRemove,
+	})
+
+	cmd.AddCommand(facetCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List your profile facets and who sees which",
+		RunE:  RunProfileList,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "default <facet|none>",
+		Short: "Choose the facet shown to peers without an assigned one",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunProfileDefault,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "assign <peer_id|contact> <facet|default>",
+		Short: "Choose the facet shown to one peer",
+		Args:  cobra.ExactArgs(2),
+		RunE:  RunProfileAssign,
+	})
+
+	return cmd
 }
 
 // createSendFileCommand creates the send-file command
 func createSendFileCommand() *cobra.Command {
 	return &cobra.Command{
-		Use:   "send-file [peer_id] [file_path]",
-		Short: "Send a file to a peer",
-		Args:  cobra.ExactArgs(2),
-		Run:   RunSendFile,
+		Use:   "send-file [peer_id|contact] [file_path]",
+		Short: "Queue a file for a peer, sent whenever they are connected",
+		Long: `Queue a file for a peer. A node sends it as soon as the peer is connected,
+now or after they come online, and keeps it queued across restarts; an
+interrupted send resumes from what the peer already received. 'queue list'
+shows the files waiting.`,
+		Args: cobra.ExactArgs(2),
+		RunE: RunSendFile,
 	}
 }
 
+// createQueueCommand creates the queue command with its subcommands
+func createQueueCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Show or remove files waiting to be sent",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List queued files and how far they got",
+		RunE:  RunQueueList,
+	}
+
+	removeCmd := &cobra.Command{
+		Use:   "remove [id]",
+		Short: "Take a file off the queue",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunQueueRemove,
+	}
+
+	cmd.AddCommand(listCmd, removeCmd)
+	return cmd
+}
+
 // createStopCommand creates the stop command
 func createStopCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "stop",
 		Short: "Stop the running P2P node",
-		Run:   RunStop,
+		RunE:  RunStop,
 	}
 }
 
@@ -173,26 +420,894 @@ func createSetupCommand() *cobra.Command {
 	return &cobra.Command{
 		Use:   "setup",
 		Short: "Run the interactive setup wizard",
-		Run:   RunSetup,
+		RunE:  RunSetup,
 	}
 }
 
 // createDoctorCommand creates the doctor command
 func createDoctorCommand() *cobra.Command {
-	return &cobra.Command{
+	return withJSON(&cobra.Command{
 		Use:   "doctor",
 		Short: "Diagnose and fix network issues",
-		Run:   RunDoctor,
-	}
+		RunE:  RunDoctor,
+	})
 }
 
 // createManualCommand creates the manual command
 func createManualCommand(version string) *cobra.Command {
 	return &cobra.Command{
-		Use:   "manual",
-		Short: "Show comprehensive manual",
+		Use:         "manual",
+		Short:       "Show comprehensive manual",
+		Annotations: map[string]string{noConfigAnnotation: "true"},
 		Run: func(cmd *cobra.Command, args []string) {
 			RunManual(version)
 		},
 	}
 }
+
+// createInviteCommand creates the invite command with its subcommands
+func createInviteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Manage expiring one-time invite addresses",
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a one-time invite that hides your peer ID",
+		RunE:  RunInviteCreate,
+	}
+	createCmd.Flags().Duration("ttl", time.Hour, "How long the invite stays valid (e.g. 30m, 1h, 24h)")
+	createCmd.Flags().Bool("reusable", false, "Create a link anyone can join any number of times, revealing your peer ID")
+	createCmd.Flags().Bool("qr", false, "Also show the invite link as a QR code")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List stored invites",
+		RunE:  RunInviteList,
+	}
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke [invite_id]",
+		Short: "Revoke an invite before it is used",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunInviteRevoke,
+	}
+
+	cmd.AddCommand(createCmd, listCmd, revokeCmd)
+	return cmd
+}
+
+// createJoinCommand creates the join command
+func createJoinCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "join [link|invite_code]",
+		Short: "Join an invite: connect to the inviter and save it as a contact",
+		Long: `Join an invite created with 'invite create'. A one-time invite is redeemed,
+revealing the inviter's peer ID; a reusable one names it. Either way the
+node connects to the inviter, through the relays in the link if it cannot
+be reached directly, and saves it as a contact under the nickname it
+claimed, or --name.`,
+		Args: cobra.ExactArgs(1),
+		RunE: RunJoin,
+	}
+	cmd.Flags().String("name", "", "Contact name for the inviter (default: its nickname)")
+	return cmd
+}
+
+// createRotateKeyCommand creates the rotate-key command
+func createRotateKeyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Replace your identity key and announce the change to contacts",
+		RunE:  RunRotateKey,
+	}
+}
+
+// createNameCommand creates the name command with its subcommands
+func createNameCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "name",
+		Short: "Manage your human-readable name on the DHT",
+	}
+
+	claimCmd := &cobra.Command{
+		Use:   "claim [name]",
+		Short: "Claim a unique nickname bound to your DID",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunNameClaim,
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the nickname you have claimed",
+		RunE:  RunNameShow,
+	}
+
+	releaseCmd := &cobra.Command{
+		Use:   "release",
+		Short: "Stop publishing your nickname",
+		RunE:  RunNameRelease,
+	}
+
+	cmd.AddCommand(claimCmd, showCmd, releaseCmd)
+	return cmd
+}
+
+// createPinCommand creates the pin command with its subcommands
+func createPinCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pin",
+		Short: "Pin conversations to specific transports",
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set [peer_id] [any|lan|no-relay|onion]",
+		Short: "Restrict the transports used to reach a peer",
+		Args:  cobra.ExactArgs(2),
+		RunE:  RunPinSet,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List transport pins",
+		RunE:  RunPinList,
+	}
+
+	clearCmd := &cobra.Command{
+		Use:   "clear [peer_id]",
+		Short: "Remove the transport pin for a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunPinClear,
+	}
+
+	cmd.AddCommand(setCmd, listCmd, clearCmd)
+	return cmd
+}
+
+// createHistoryCommand creates the history command
+func createHistoryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history [peer_id|contact]",
+		Short: "Show the encrypted message history",
+		Long: `Show sent and received messages from the local encrypted history.
+Without a peer, messages from all conversations are listed.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunHistory,
+	}
+
+	cmd.Flags().String("since", "", "Only show messages newer than this age or date (e.g. 12h, 2d, 1w, 2024-01-31)")
+	cmd.Flags().Int("limit", db.DefaultHistoryLimit, "Maximum number of messages to show")
+	cmd.Flags().Int("offset", 0, "Skip this many newer messages (for paging)")
+	cmd.Flags().Bool("archived", false, "Include messages archived by a content filter")
+	return cmd
+}
+
+// createSyncDirCommand creates the sync-dir command
+func createSyncDirCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sync-dir [peer_id|@name] [path]",
+		Short: "Send a directory, transferring only files the peer does not have",
+		Long: `Send a directory to a peer. A manifest of per-file hashes is exchanged
+first; files the peer already has (by content hash) are skipped, so
+repeating the command only transfers what changed.`,
+		Args: cobra.ExactArgs(2),
+		RunE: RunSyncDir,
+	}
+}
+
+// createSearchCommand creates the search command
+func createSearchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "search [words...]",
+		Short: "Search the message history",
+		Long: `Search sent and received text messages for all given words.
+Matches are shown with a snippet and the message ID for replies and quotes.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: RunSearch,
+	}
+
+	cmd.Flags().String("peer", "", "Only search the conversation with this peer ID or contact")
+	cmd.Flags().Int("limit", db.DefaultSearchLimit, "Maximum number of matches to show")
+	return cmd
+}
+
+// createSyncCommand creates the sync command with its subcommands
+func createSyncCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Keep folders in sync with your other devices",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add [peer_id] [local] [remote]",
+		Short: "Sync a local folder two-way with a folder on another device",
+		Long: `Sync a local folder two-way with a folder on another device.
+The other device must add the same pair with the paths swapped. Changes are
+detected automatically; concurrent edits keep both versions.`,
+		Args: cobra.ExactArgs(3),
+		RunE: RunSyncAdd,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List sync folders",
+		RunE:  RunSyncList,
+	}
+
+	removeCmd := &cobra.Command{
+		Use:   "remove [id]",
+		Short: "Stop syncing a folder",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunSyncRemove,
+	}
+
+	cmd.AddCommand(addCmd, listCmd, removeCmd)
+	return cmd
+}
+
+// createExportCommand creates the export command
+func createExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export [peer_id|contact]",
+		Short: "Export the message history as JSON, Markdown or HTML",
+		Long: `Export a conversation from the local encrypted history as a readable
+transcript, including sent and received files. Without a peer, all
+conversations are exported. The output is written unencrypted.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunExport,
+	}
+
+	cmd.Flags().String("format", string(db.ExportMarkdown), "Output format: json, md or html (default: from --out extension)")
+	cmd.Flags().String("out", "", "Write to this file instead of standard output")
+	cmd.Flags().String("since", "", "Only export messages newer than this age or date (e.g. 12h, 2d, 1w, 2024-01-31)")
+	return cmd
+}
+
+// createWatchCommand creates the watch command
+func createWatchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch [dir]",
+		Short: "Send new and changed files in a directory to a peer automatically",
+		Long: `Watch a directory tree and send every new or changed file to a peer once
+it has stopped changing, e.g. for camera uploads or log shipping between
+your own machines. Hidden, temporary and partial files are skipped.`,
+		Args: cobra.ExactArgs(1),
+		RunE: RunWatch,
+	}
+
+	cmd.Flags().String("to", "", "Peer ID or @name to send files to")
+	cmd.Flags().StringSlice("include", nil, "Only send files matching these glob patterns (e.g. '*.jpg,*.log')")
+	cmd.Flags().StringSlice("exclude", nil, "Skip files matching these glob patterns")
+	cmd.Flags().Duration("debounce", message.WatchDebounce, "Wait until a file is unchanged this long before sending it")
+	cmd.Flags().Bool("existing", false, "Also send files already in the directory")
+	_ = cmd.MarkFlagRequired("to")
+	return cmd
+}
+
+// createRetentionCommand creates the retention command with its subcommands
+func createRetentionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retention",
+		Short: "Configure how long message history is kept",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the retention rules",
+		RunE:  RunRetentionShow,
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set [peer_id|contact]",
+		Short: "Set the retention rule for all conversations or one peer",
+		Long: `Set how much history is kept. Without a peer, the rule applies to every
+conversation that has no rule of its own. Messages older than --days or
+beyond the newest --messages are deleted securely.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunRetentionSet,
+	}
+	setCmd.Flags().Int("days", 0, "Keep messages for this many days")
+	setCmd.Flags().Int("messages", 0, "Keep at most this many messages per conversation")
+	setCmd.Flags().Bool("forever", false, "Keep all messages")
+
+	clearCmd := &cobra.Command{
+		Use:   "clear [peer_id|contact]",
+		Short: "Remove a peer's rule so the default applies",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunRetentionClear,
+	}
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete expired messages now",
+		RunE:  RunRetentionPrune,
+	}
+
+	compactCmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Deduplicate large messages and reclaim free space in the history",
+		Long: `Move large message bodies recorded by older versions into the blob store,
+where identical content is kept once, drop content no message refers to any
+more and shrink the database file. Stop the node first for best results.`,
+		RunE: RunRetentionCompact,
+	}
+
+	cmd.AddCommand(showCmd, setCmd, clearCmd, pruneCmd, compactCmd)
+	return cmd
+}
+
+// createTimeoutsCommand creates the timeouts command with its subcommands
+func createTimeoutsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "timeouts",
+		Short: "Configure message and file transfer timeouts",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the configured timeouts",
+		RunE:  RunTimeoutsShow,
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set [peer_id|contact]",
+		Short: "Set the timeouts for all peers or one peer",
+		Long: `Set how long to wait for a peer. Without a peer, the timeouts apply to
+every peer that has none of its own, and are raised automatically for peers
+with a long measured round-trip time. A peer's own timeouts are used as is.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunTimeoutsSet,
+	}
+	setCmd.Flags().Duration("message", 0, "Time to deliver a message (e.g. 90s)")
+	setCmd.Flags().Duration("file", 0, "Time to open a transfer or compare a directory (e.g. 15m)")
+
+	clearCmd := &cobra.Command{
+		Use:   "clear [peer_id|contact]",
+		Short: "Remove a peer's timeouts, or restore the defaults",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  RunTimeoutsClear,
+	}
+
+	cmd.AddCommand(showCmd, setCmd, clearCmd)
+	return cmd
+}
+
+// createAttachmentsCommand creates the attachments command with its subcommands
+func createAttachmentsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attachments",
+		Short: "Manage stored received files",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List received files and who sent them",
+		RunE:  RunAttachmentsList,
+	}
+
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete unused and old attachments",
+		Long: `Delete stored files no longer in the index and partial downloads. With
+--older-than, attachments last received before then are deleted as well,
+together with their copies in the downloads directory.`,
+		RunE: RunAttachmentsGC,
+	}
+	gcCmd.Flags().String("older-than", "", "Also delete attachments last received before this (e.g. 30d, 2025-01-01)")
+
+	cmd.AddCommand(listCmd, gcCmd)
+	return cmd
+}
+
+// createQuotaCommand creates the quota command with its subcommands
+func createQuotaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "quota",
+		Short: "Limit disk space used by downloads and the offline queue",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the quotas and the space used",
+		RunE:  RunQuotaShow,
+	}
+
+	setCmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set the quotas",
+		Long: `Set the disk quotas. Files that would exceed the downloads quota are
+refused with a quota_exceeded error the sender sees; messages to offline
+peers are not queued once the offline queue is full. 0 means no limit.`,
+		RunE: RunQuotaSet,
+	}
+	setCmd.Flags().String("downloads", "", "Space for received files (e.g. 2GB)")
+	setCmd.Flags().String("offline", "", "Space for messages queued for offline peers (e.g. 200MB)")
+
+	cmd.AddCommand(showCmd, setCmd)
+	return cmd
+}
+
+// createFilterCommand creates the filter command with its subcommands
+func createFilterCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "filter",
+		Short: "Mute, tag or archive incoming messages by keyword",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add [keyword|regex]",
+		Short: "Add a content filter",
+		Long: `Add a filter for incoming text messages. Keywords match anywhere in a
+message, ignoring case; with --regex the pattern is a regular expression
+(prefix it with (?i) to ignore case). Muted messages are recorded but not
+shown, archived ones are also hidden from 'history' unless --archived is
+given, and tagged ones are shown with the tag. Filters only act on this
+device; senders are not told.`,
+		Args: cobra.ExactArgs(1),
+		RunE: RunFilterAdd,
+	}
+	addCmd.Flags().Bool("regex", false, "Treat the pattern as a regular expression")
+	addCmd.Flags().String("peer", "", "Only filter the conversation with this peer ID or contact")
+	addCmd.Flags().Bool("mute", false, "Record matching messages without showing them")
+	addCmd.Flags().Bool("archive", false, "Archive matching messages")
+	addCmd.Flags().String("tag", "", "Show matching messages with this tag")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the content filters",
+		RunE:  RunFilterList,
+	}
+
+	removeCmd := &cobra.Command{
+		Use:   "remove [id]",
+		Short: "Remove a content filter",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunFilterRemove,
+	}
+
+	cmd.AddCommand(addCmd, listCmd, removeCmd)
+	return cmd
+}
+
+// createHookCommand creates the hook command with its subcommands
+func createHookCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "hook",
+		Short: "Wake other machines when messages arrive",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a wake hook",
+		Long: `Add a hook that sends a Wake-on-LAN packet (--wake) or runs a command
+(--run) when a message arrives at this node, for example so an always-on
+home server wakes a desktop. --peer, --class and --tag narrow which
+messages fire it; without them every message does. Muted messages never
+fire hooks, and each hook fires at most once a minute.
+
+Commands are run without a shell and get the sender and the message class
+in XELVRA_PEER_ID, XELVRA_CLASS and XELVRA_TAGS, never the message itself.`,
+		Args: cobra.NoArgs,
+		RunE: RunHookAdd,
+	}
+	addCmd.Flags().String("peer", "", "Only messages from this peer ID or contact")
+	addCmd.Flags().String("class", "", "Only this kind of message: text, file, image, audio, video or system")
+	addCmd.Flags().String("tag", "", "Only messages a content filter tagged with this (see 'filter')")
+	addCmd.Flags().String("wake", "", "Send a Wake-on-LAN packet to this MAC address")
+	addCmd.Flags().String("broadcast", "", "Send the packet to this address (default "+message.DefaultWakeBroadcast+")")
+	addCmd.Flags().String("run", "", "Run this command")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the wake hooks",
+		RunE:  RunHookList,
+	}
+
+	removeCmd := &cobra.Command{
+		Use:   "remove [id]",
+		Short: "Remove a wake hook",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunHookRemove,
+	}
+
+	testCmd := &cobra.Command{
+		Use:   "test [id]",
+		Short: "Fire a wake hook now",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunHookTest,
+	}
+
+	cmd.AddCommand(addCmd, listCmd, removeCmd, testCmd)
+	return cmd
+}
+
+// createNetworksCommand creates the networks command with its subcommands
+func createNetworksCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "networks",
+		Short: "Show what was learned about the networks this device joined",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List remembered networks and what was learned about them",
+		Long: `List the networks the node has run on, identified by interface, Wi-Fi
+SSID and subnet. The NAT type, the transports that connected and the packet
+sizes doctor found are applied at once when a network is joined again;
+facts older than a week are probed again.`,
+		RunE: RunNetworksList,
+	}
+
+	forgetCmd := &cobra.Command{
+		Use:   "forget [network|ssid|all]",
+		Short: "Forget a network so it is probed again",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunNetworksForget,
+	}
+
+	cmd.AddCommand(listCmd, forgetCmd)
+	return cmd
+}
+
+func createSwarmKeyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "swarm-key",
+		Short: "Run an isolated private network with a pre-shared key",
+		Long: `Nodes holding the same swarm key form a private network: they connect
+only to each other, and nodes without the key cannot connect to them. The
+key is read from XELVRA_SWARM_KEY (a key file, or the key itself), from
+swarm_key in config.yaml, or from swarm.key next to config.yaml. QUIC is off
+in a private network, as it cannot use the key.`,
+		RunE: RunSwarmKeyShow,
+	}
+
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a new swarm key to share with the nodes of the network",
+		RunE:  RunSwarmKeyGenerate,
+	}
+	generateCmd.Flags().String("out", "", "File to write the key to (default: swarm.key next to config.yaml)")
+	generateCmd.Flags().Bool("force", false, "Replace an existing key")
+
+	cmd.AddCommand(generateCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "Show the fingerprint of the swarm key in use",
+		RunE:  RunSwarmKeyShow,
+	})
+	return cmd
+}
+
+// createBootstrapCommand creates the bootstrap command with its subcommands
+func createBootstrapCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bootstrap",
+		Short: "Manage the peers the node joins the DHT through",
+		Long: `Bootstrap peers are the first peers the node connects to, to join the
+DHT. They are kept as bootstrap_peers in config.yaml and replace the
+default ones, which are used only while none of them can be reached. The
+node checks them every 5 minutes; 'status' shows how many answered.`,
+		RunE: RunBootstrapList,
+	}
+	cmd.Flags().Bool("check", false, "Connect to each peer and report whether it is reachable")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the bootstrap peers",
+		RunE:  RunBootstrapList,
+	}
+	listCmd.Flags().Bool("check", false, "Connect to each peer and report whether it is reachable")
+
+	cmd.AddCommand(listCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add [multiaddr]",
+		Short: "Add a bootstrap peer, e.g. /dns4/boot.example.org/tcp/4001/p2p/<peer ID>, or /dnsaddr/example.org for all the peers it lists",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunBootstrapAdd,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove [multiaddr|peer_id]",
+		Short: "Remove a bootstrap address, or every address of a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunBootstrapRemove,
+	})
+	return cmd
+}
+
+// createConfigCommand creates the config command and its subcommands, which
+// work on a configuration file that is invalid so it can be fixed
+func createConfigCommand() *cobra.Command {
+	skipConfig := map[string]string{noConfigAnnotation: "true"}
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show and change the settings in config.yaml",
+		Long: `Show and change the settings in config.yaml, or the file given with
+--config. Settings in a section are written as section.key, e.g.
+discovery.mdns. Changes keep the comments in the file and are checked
+before it is written.
+
+A running node is asked to reload the file: it applies discovery.mdns,
+discovery.udp_broadcast and the logging settings at once, and the others
+the next time it starts.`,
+		Annotations: skipConfig,
+		RunE:        RunConfigList,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:         "list",
+		Short:       "List every setting with its value",
+		Args:        cobra.NoArgs,
+		Annotations: skipConfig,
+		RunE:        RunConfigList,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:         "get [key]",
+		Short:       "Print the value of a setting, the entries of a list one per line",
+		Args:        cobra.ExactArgs(1),
+		Annotations: skipConfig,
+		RunE:        RunConfigGet,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:         "set [key] [value...]",
+		Short:       "Set a setting, e.g. 'config set discovery.mdns false'; lists take one or more values",
+		Args:        cobra.MinimumNArgs(2),
+		Annotations: skipConfig,
+		RunE:        RunConfigSet,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:         "unset [key]",
+		Short:       "Remove a setting from the file, so its default applies",
+		Args:        cobra.ExactArgs(1),
+		Annotations: skipConfig,
+		RunE:        RunConfigUnset,
+	})
+	return cmd
+}
+
+// createStarCommands creates the star, unstar and starred commands
+func createStarCommands() []*cobra.Command {
+	starCmd := &cobra.Command{
+		Use:   "star [message_id]",
+		Short: "Star a message in the history",
+		Long: `Star a message so it is easy to find again and is never deleted by the
+retention rules. The ID, or its first characters, is shown by history and
+search.`,
+		Args: cobra.ExactArgs(1),
+		RunE: RunStar,
+	}
+
+	unstarCmd := &cobra.Command{
+		Use:   "unstar [message_id]",
+		Short: "Remove the star from a message",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunUnstar,
+	}
+
+	starredCmd := &cobra.Command{
+		Use:   "starred [peer_id|contact]",
+		Short: "List starred messages by conversation",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  RunStarred,
+	}
+
+	return []*cobra.Command{starCmd, unstarCmd, starredCmd}
+}
+
+// createAutoAcceptCommand creates the autoaccept command with its subcommands
+func createAutoAcceptCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "autoaccept",
+		Short: "Manage the peers whose files are accepted without asking",
+		Long: `Incoming files are offered in interactive chat, where /accept or /reject
+answers them, and refused when no chat is open. Files from peers on this list
+are accepted without asking.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "add <peer_id|contact>",
+		Short: "Accept files from a peer without asking",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunAutoAcceptAdd,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "remove <peer_id|contact>",
+		Short: "Ask again before accepting files from a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunAutoAcceptRemove,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the peers whose files are accepted without asking",
+		RunE:  RunAutoAcceptList,
+	})
+
+	return cmd
+}
+
+// createDataCommand creates the data command with its subcommands
+func createDataCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "data",
+		Short: "Export all your data or erase what is stored about a contact",
+	}
+
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write everything stored about you and your contacts to a zip archive",
+		Long: `Write a zip archive of everything the node stores: contacts, settings,
+received files and logs as they are on disk, and the message history and
+unsent messages decrypted. manifest.json lists the contents. Private keys
+are left out.`,
+		RunE: RunDataExport,
+	}
+	exportCmd.Flags().String("out", "", "Archive to write (default: xelvra-data-<time>.zip)")
+	exportCmd.Flags().Bool("no-files", false, "Leave out received files and attachments")
+
+	eraseCmd := &cobra.Command{
+		Use:   "erase",
+		Short: "Remove everything stored about a contact",
+		Long: `Remove everything stored about a peer: the contact, message history
+(starred messages too), received files, unsent messages, synced folder
+settings, per-peer settings, the avatar, backups held for the peer, and
+chat command history and log lines naming the peer. The node must be
+stopped first.`,
+		RunE: RunDataErase,
+	}
+	eraseCmd.Flags().String("peer", "", "Peer ID or contact name to erase")
+	_ = eraseCmd.MarkFlagRequired("peer")
+
+	cmd.AddCommand(exportCmd, eraseCmd)
+	return cmd
+}
+
+// createBackupCommand creates the backup command
+func createBackupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Back up to a trusted contact's node, or hold backups for one",
+		Long: `Back up identity, contacts, settings and message history to a trusted
+contact's node. Backups are encrypted with a key derived from a recovery
+phrase; the friend holds them without being able to read them. A running
+node makes a backup whenever one is due, replacing the previous one.
+Received files are not backed up.`,
+		RunE: RunBackupStatus,
+	}
+
+	setupCmd := &cobra.Command{
+		Use:   "setup <contact|peer_id>",
+		Short: "Back up to a contact's node and show the recovery phrase",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunBackupSetup,
+	}
+	setupCmd.Flags().Duration("every", message.DefaultBackupInterval, "How often to back up")
+	setupCmd.Flags().Bool("new-phrase", false, "Replace the recovery phrase; older backups can no longer be fetched")
+
+	allowCmd := &cobra.Command{
+		Use:   "allow <contact|peer_id>",
+		Short: "Hold backups for a contact",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunBackupAllow,
+	}
+	allowCmd.Flags().Int64("quota", message.DefaultBackupQuota>>20, "Space the contact's backups may take, in MB")
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore <contact|peer_id|@name>",
+		Short: "Fetch the backup a friend holds and restore it with the recovery phrase",
+		Long: `Fetch the backup a friend holds, decrypt it with the recovery phrase and
+restore it into the data directory. The node must be stopped first.`,
+		Args: cobra.ExactArgs(1),
+		RunE: RunBackupRestore,
+	}
+	restoreCmd.Flags().Bool("force", false, "Replace an existing identity and data")
+
+	cmd.AddCommand(setupCmd, allowCmd, restoreCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show your backups and the backups you hold",
+		RunE:  RunBackupStatus,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "now",
+		Short: "Back up at once",
+		RunE:  RunBackupNow,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "phrase",
+		Short: "Show the recovery phrase again",
+		RunE:  RunBackupPhrase,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "off",
+		Short: "Stop backing up",
+		RunE:  RunBackupOff,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "deny <contact|peer_id>",
+		Short: "Stop holding backups for a contact and delete them",
+		Args:  cobra.ExactArgs(1),
+		RunE:  RunBackupDeny,
+	})
+
+	return cmd
+}
+
+// createTraceCommand creates the trace command
+func createTraceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trace [file]",
+		Short: "Summarize how long each hop of a message takes",
+		Long: `Summarize the spans a node started with --trace recorded: signing,
+queueing, opening the stream, writing and waiting for the receipt, and
+receiving on the other side. Without a file the default trace file is read.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: RunTrace,
+	}
+
+	cmd.Flags().String("since", "", "Only include spans newer than this age or date (e.g. 1h, 2d, 2024-01-31)")
+	return cmd
+}
+
+// createLogsCommand creates the logs command
+func createLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Show and follow the node's log, filtered by level, age and text",
+		Long: `Show the entries of the JSON log file ~/.xelvra/peerchat.log, rotated files
+included, one per line with their fields. With --follow new entries are
+printed as they are written until Ctrl+C.`,
+		Args: cobra.NoArgs,
+		RunE: RunLogs,
+	}
+
+	cmd.Flags().BoolP("follow", "f", false, "Keep printing new entries as they are written")
+	cmd.Flags().String("level", "", "Only show entries of this level or more severe: debug, info, warn or error")
+	cmd.Flags().String("since", "", "Only show entries newer than this age or date (e.g. 1h, 2d, 2024-01-31)")
+	cmd.Flags().String("grep", "", "Only show entries whose message or fields match this regular expression, ignoring case")
+	cmd.Flags().IntP("lines", "n", 0, "Show at most this many of the latest entries (default all, 10 with --follow)")
+	return cmd
+}
+
+// createDebugCommand creates the debug command
+func createDebugCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debug",
+		Short: "Profile a running node through a localhost diagnostics endpoint",
+		Long: `Serve pprof profiles and runtime statistics of the node on 127.0.0.1, so
+performance problems can be profiled without a special build. The endpoint
+is off until enabled and is never reachable from other machines.`,
+		RunE: RunDebugStatus,
+	}
+
+	enableCmd := &cobra.Command{
+		Use:   "enable",
+		Short: "Serve the diagnostics endpoint from the next node start",
+		RunE:  RunDebugEnable,
+	}
+	enableCmd.Flags().Int("port", p2p.DefaultDiagnosticsPort, "Local port to serve on")
+
+	dumpCmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Save heap, allocation and goroutine profiles of the running node",
+		Long: `Save heap, allocation and goroutine profiles and runtime statistics of the
+running node, and optionally a CPU profile and a runtime execution trace,
+to attach to a performance bug report. The node must serve the diagnostics
+endpoint: run 'debug enable' before starting it, or start it with --pprof.`,
+		RunE: RunDebugDump,
+	}
+	dumpCmd.Flags().String("out", "", "Directory to save to (default: ~/.xelvra/debug/<time>)")
+	dumpCmd.Flags().Duration("cpu", 0, "Also profile the CPU for this long (e.g. 30s)")
+	dumpCmd.Flags().Duration("runtime-trace", 0, "Also record a runtime execution trace for this long (e.g. 5s), for go tool trace")
+
+	cmd.AddCommand(enableCmd, dumpCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "disable",
+		Short: "Stop serving the diagnostics endpoint",
+		RunE:  RunDebugDisable,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show whether the diagnostics endpoint is served",
+		RunE:  RunDebugStatus,
+	})
+	return cmd
+}
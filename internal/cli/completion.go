@@ -1,19 +1,39 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/Xelvra/peerchat/internal/db"
 	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
 	"github.com/chzyer/readline"
 )
 
+// chatCommands are the commands of interactive mode
+var chatCommands = []string{
+	"/help", "/peers", "/discover", "/connect", "/disconnect",
+	"/status", "/whoami", "/fingerprint", "/join", "/contacts", "/add", "/verify", "/policy",
+	"/msg", "/switch", "/next", "/prev", "/list", "/send", "/name", "/whois", "/profile", "/ping", "/pin", "/pins", "/visibility", "/battery", "/rendezvous", "/history", "/search",
+	"/star", "/unstar", "/starred", "/sendfile", "/sync-dir", "/transfer",
+	"/accept", "/reject", "/reset-session",
+	"/stats", "/clear", "/quit", "/exit",
+}
+
+// commandStats counts the chat commands used, if usage tracking is available
+var commandStats *CommandStats
+
 // InteractiveCompleter provides tab completion for interactive mode
 type InteractiveCompleter struct {
 	commands []string
 	peers    []string
+	contacts []string
+	stats    *CommandStats
 }
 
 // Do implements readline.AutoCompleter interface
@@ -38,25 +58,50 @@ func (c *InteractiveCompleter) Do(line []rune, pos int) (newLine [][]rune, lengt
 		currentWord = string(line[start:pos])
 	}
 
-	// If first word, complete commands
-	if len(words) == 1 && (pos <= len(lineStr) && (pos == len(lineStr) || lineStr[pos-1] != ' ')) {
+	// If still in the first word, complete commands
+	if len(words) == 1 && currentWord != "" {
 		completions := c.completeCommands(currentWord)
 		return completions, len([]rune(currentWord))
 	}
 
-	// If second word and first word is /connect, complete peer IDs
-	if len(words) >= 1 && words[0] == "/connect" {
+	// Complete the peer IDs, and contact names where accepted, after the
+	// command
+	if len(words) > 2 || (len(words) == 2 && currentWord == "") {
+		return nil, 0
+	}
+	switch words[0] {
+	case "/connect":
+		return c.completePeers(currentWord), len([]rune(currentWord))
+	case "/msg", "/send", "/switch", "/fingerprint", "/ping", "/reset-session":
 		completions := c.completePeers(currentWord)
+		for _, name := range c.contacts {
+			if strings.HasPrefix(name, currentWord) {
+				completions = append(completions, []rune(name[len(currentWord):]))
+			}
+		}
+		return completions, len([]rune(currentWord))
+	case "/policy":
+		var completions [][]rune
+		for _, name := range c.contacts {
+			if strings.HasPrefix(name, currentWord) {
+				completions = append(completions, []rune(name[len(currentWord):]))
+			}
+		}
 		return completions, len([]rune(currentWord))
 	}
 
 	return nil, 0
 }
 
-// completeCommands returns command completions
+// completeCommands returns command completions, most used first
 func (c *InteractiveCompleter) completeCommands(prefix string) [][]rune {
+	commands := c.commands
+	if c.stats != nil {
+		commands = c.stats.Rank(commands)
+	}
+
 	var completions [][]rune
-	for _, cmd := range c.commands {
+	for _, cmd := range commands {
 		if strings.HasPrefix(cmd, prefix) {
 			completions = append(completions, []rune(cmd[len(prefix):]))
 		}
@@ -76,39 +121,51 @@ func (c *InteractiveCompleter) completePeers(prefix string) [][]rune {
 }
 
 // UpdatePeers updates the list of available peers for completion
-func (c *InteractiveCompleter) UpdatePeers(wrapper *p2p.P2PWrapper) {
-	if wrapper == nil {
+func (c *InteractiveCompleter) UpdatePeers(peers ChatPeers) {
+	if peers == nil {
 		return
 	}
 
 	// Get connected peers using the wrapper method
-	connectedPeers := wrapper.GetConnectedPeers()
+	connectedPeers := peers.GetConnectedPeers()
 	c.peers = append(c.peers[:0], connectedPeers...)
+
+	c.contacts = c.contacts[:0]
+	for _, contact := range peers.ListContacts() {
+		c.contacts = append(c.contacts, contact.Name)
+	}
 }
 
 // CreateReadlineInstance creates a readline instance with completion and history
 func CreateReadlineInstance() (*readline.Instance, *InteractiveCompleter, error) {
-	// Define available commands
-	commands := []string{
-		"/help", "/peers", "/discover", "/connect", "/disconnect",
-		"/status", "/clear", "/quit", "/exit",
+	// Ensure the data directory exists
+	xelvraDir, err := user.DataDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find xelvra directory: %w", err)
+	}
+	if err := os.MkdirAll(xelvraDir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create xelvra directory: %w", err)
 	}
 
-	completer := &InteractiveCompleter{
-		commands: commands,
-		peers:    []string{},
+	// Usage counts order completions; without them completion still works
+	stats, err := LoadCommandStats(filepath.Join(xelvraDir, CommandStatsFileName))
+	if err != nil {
+		ui.Warn("Failed to load command usage: %v", err)
+		stats, _ = LoadCommandStats("")
 	}
+	commandStats = stats
 
-	// Ensure .xelvra directory exists
-	xelvraDir := filepath.Join(os.Getenv("HOME"), ".xelvra")
-	if err := os.MkdirAll(xelvraDir, 0700); err != nil {
-		return nil, nil, fmt.Errorf("failed to create xelvra directory: %w", err)
+	completer := &InteractiveCompleter{
+		commands: chatCommands,
+		peers:    []string{},
+		stats:    stats,
 	}
 
 	config := &readline.Config{
 		Prompt:            "> ",
 		HistoryFile:       filepath.Join(xelvraDir, "chat_history"),
 		AutoComplete:      completer,
+		Stdout:            os.Stdout,
 		InterruptPrompt:   "^C",
 		EOFPrompt:         "exit",
 		HistorySearchFold: true,
@@ -122,124 +179,654 @@ func CreateReadlineInstance() (*readline.Instance, *InteractiveCompleter, error)
 	return rl, completer, nil
 }
 
-// HandleChatCommand processes chat commands like /help, /peers, etc.
-func HandleChatCommand(input string, wrapper *p2p.P2PWrapper, nodeInfo *p2p.NodeInfo) {
+// HandleChatCommand processes chat commands like /help, /peers, etc. and
+// prints to out. A command that fails prints why and returns an error with
+// its exit code.
+func HandleChatCommand(out ui.Printer, input string, wrapper *p2p.P2PWrapper, nodeInfo *p2p.NodeInfo) error {
 	parts := strings.Fields(input)
 	if len(parts) == 0 {
-		return
+		return nil
 	}
 
 	command := parts[0]
+	if commandStats != nil && isChatCommand(command) {
+		if err := commandStats.Record(command); err != nil {
+			out.Warn("Failed to save command usage: %v", err)
+		}
+	}
+
 	switch command {
 	case "/help":
-		fmt.Println("📖 Available commands:")
-		fmt.Println("  /help          - Show this help")
-		fmt.Println("  /peers         - List connected peers")
-		fmt.Println("  /discover      - Discover peers in network")
-		fmt.Println("  /connect <id>  - Connect to a peer (supports tab completion)")
-		fmt.Println("  /status        - Show node status")
-		fmt.Println("  /clear         - Clear screen")
-		fmt.Println("  /quit, /exit   - Exit chat")
-		fmt.Println("  <message>      - Send message to all connected peers")
-		fmt.Println()
-		fmt.Println("🎯 Interactive features:")
-		fmt.Println("  Tab            - Auto-complete commands and peer IDs")
-		fmt.Println("  ↑/↓ arrows     - Navigate command history")
-		fmt.Println("  Ctrl+C         - Exit chat")
-		fmt.Println("  Ctrl+R         - Search command history")
+		out.Println("📖 Available commands:")
+		out.Println("  /help          - Show this help")
+		out.Println("  /peers         - List connected peers")
+		out.Println("  /discover      - Discover peers in network")
+		out.Println("  /discover watch|stop - Show peers as discovery finds them, or stop")
+		out.Println("  /discover subnet <cidr> - Probe an IPv4 range for nodes where multicast is filtered")
+		out.Println("  /connect <id|link|addr> - Connect to a peer by ID (tab completion), identity link or multiaddr")
+		out.Println("  /status        - Show node status")
+		out.Println("  /whoami        - Show your DID, peer ID, key fingerprint and current addresses")
+		out.Println("  /fingerprint [peer|contact] - Show your key fingerprint, or a peer's, to compare out of band")
+		out.Println("  /join <invite> [name] - Join an invite link or code and save the inviter as a contact")
+		out.Println("  /contacts      - List contacts and key verification state")
+		out.Println("  /add <name> <id> - Save a peer as a contact (pins its key)")
+		out.Println("  /verify <name> - Show safety number and mark contact verified")
+		out.Println("  /policy <name> [<setting> <value>...] - Show or set auto-accept, notify, read-receipts and disappear for a contact")
+		out.Println("  /msg, /send <peer|contact|@name> <msg> - Send a message to one peer and talk to it from now on")
+		out.Println("  /switch [peer|contact|all] - Show or choose who typed messages go to")
+		out.Println("  /next, /prev   - Move to the next or previous conversation")
+		out.Println("  /list          - List conversations and their unread messages")
+		out.Println("  /name <name>   - Claim a nickname on the DHT")
+		out.Println("  /whois @<name> - Look up who owns a nickname")
+		out.Println("  /profile <@name|peer_id> - Show the profile a peer shares with you")
+		out.Println("  /ping <peer|contact|@name> [count] - Measure the round trip time to a peer and show whether it is relayed")
+		out.Println("  /pin <@name|peer_id> <any|lan|no-relay|onion> - Pin a conversation's transport")
+		out.Println("  /pins          - List transport pins")
+		out.Println("  /visibility [everyone|contacts-of-contacts|contacts|invisible] - Show or set who discovery announces you to")
+		out.Println("  /battery [on|off] - Show estimated power use, or turn battery saver mode on or off")
+		out.Println("  /rendezvous [join|leave|find <key>] - Find peers that use the same key through the DHT")
+		out.Println("  /history [@name|peer_id] [n] - Show the last n messages of a conversation")
+		out.Println("  /search [--peer <@name|peer_id>] <words> - Search message history")
+		out.Println("  /star <message_id> - Star a message; /unstar <message_id> removes the star")
+		out.Println("  /starred [@name|peer_id] - List starred messages by conversation")
+		out.Println("  /sendfile <@name|peer_id> <path> - Send a file, now or once the peer connects")
+		out.Println("  /sync-dir <@name|peer_id> <path> - Send a directory, skipping files the peer has")
+		out.Println("  /transfer [pause|resume|cancel <id>] - List or control file transfers")
+		out.Println("  /accept [n], /reject [n] - Answer a peer's offer to send you a file")
+		out.Println("  /reset-session <peer|contact|@name> - Start a new encrypted session with a peer, e.g. after a device restore")
+		out.Println("  /stats         - Show memory, goroutines, streams, connections by transport and message throughput")
+		out.Println("  /stats commands [reset] - Show how often you used each command")
+		out.Println("  /clear         - Clear screen")
+		out.Println("  /quit, /exit   - Exit chat")
+		out.Println("  <message>      - Send message to the current conversation, or all connected peers")
+		out.Println()
+		out.Println("🎯 Interactive features:")
+		out.Println("  Tab            - Auto-complete commands (most used first) and peer IDs")
+		out.Println("  ↑/↓ arrows     - Navigate command history")
+		out.Println("  Ctrl+C         - Exit chat")
+		out.Println("  Ctrl+R         - Search command history")
 
 	case "/peers":
-		fmt.Println("👥 Connected peers:")
+		out.Println("👥 Connected peers:")
 
 		if wrapper.IsUsingSimulation() {
-			fmt.Println("  (Simulation mode - no real peers)")
-			return
+			out.Println("  (Simulation mode - no real peers)")
+			return nil
 		}
 
 		connectedPeers := wrapper.GetConnectedPeers()
 		if len(connectedPeers) == 0 {
-			fmt.Println("  (No peers connected yet)")
-			fmt.Println("💡 Use '/discover' to find peers, then '/connect <peer_id>' to connect")
+			out.Println("  (No peers connected yet)")
+			out.Info("Use '/discover' to find peers, then '/connect <peer_id>' to connect")
 		} else {
 			for i, peerID := range connectedPeers {
-				fmt.Printf("  %d. %s ✅\n", i+1, peerID)
+				if wrapper.PeerViaRelay(peerID) {
+					out.Printf("  %d. %s ✅ (via relay)%s%s\n", i+1, peerID, formatPeerQuality(wrapper, peerID), formatPeerProtocol(wrapper, peerID))
+				} else {
+					out.Printf("  %d. %s ✅%s%s\n", i+1, peerID, formatPeerQuality(wrapper, peerID), formatPeerProtocol(wrapper, peerID))
+				}
 			}
-			fmt.Printf("💡 Total: %d connected peer(s)\n", len(connectedPeers))
+			out.Info("Total: %d connected peer(s)", len(connectedPeers))
 		}
 
 	case "/discover":
-		fmt.Println("🔍 Discovering peers in the network...")
-		RunInlinePeerDiscovery(wrapper)
+		if len(parts) > 1 && (parts[1] == "watch" || parts[1] == "stop") {
+			return handleDiscoverWatch(out, wrapper, parts[1] == "watch")
+		}
+		if len(parts) > 1 && parts[1] == "subnet" {
+			if len(parts) < 3 {
+				out.Error("Usage: /discover subnet <cidr>, e.g. /discover subnet 192.168.50.0/24")
+				return generalError(errUsage)
+			}
+			return handleDiscoverSubnet(out, wrapper, parts[2])
+		}
+		out.Println("🔍 Discovering peers in the network...")
+		RunInlinePeerDiscovery(out, wrapper)
 
 	case "/connect":
 		if len(parts) < 2 {
-			fmt.Println("❌ Usage: /connect <peer_id>")
-			return
+			out.Error("Usage: /connect <peer_id|xelvra://peer/...|multiaddr>")
+			return generalError(errUsage)
 		}
 		peerID := parts[1]
-		fmt.Printf("🔗 Attempting to connect to peer: %s\n", peerID)
+		if strings.HasPrefix(peerID, p2p.URIScheme+"://") || strings.HasPrefix(peerID, "/") {
+			uri, err := parsePeerLink(peerID)
+			if err != nil {
+				out.Error("%v", err)
+				return generalError(err)
+			}
+			out.Printf("🔗 Attempting to connect to peer: %s\n", uri.PeerID)
+			if err := wrapper.ConnectURI(uri); err != nil {
+				out.Error("Failed to connect to peer: %v", err)
+				return networkError(err)
+			}
+			out.Success("Successfully connected to peer: %s", uri.PeerID)
+			out.Info("Use '/add <name> %s' to save it as a contact", uri.PeerID)
+			return nil
+		}
+		out.Printf("🔗 Attempting to connect to peer: %s\n", peerID)
 
 		if wrapper.IsUsingSimulation() {
-			fmt.Println("⚠️  Cannot connect in simulation mode")
-			return
+			out.Warn("Cannot connect in simulation mode")
+			return networkError(errSimulation)
 		}
 
 		// Try to connect to the peer
-		success := wrapper.ConnectToPeer(peerID)
-		if success {
-			fmt.Printf("✅ Successfully connected to peer: %s\n", peerID)
-		} else {
-			fmt.Printf("❌ Failed to connect to peer: %s\n", peerID)
-			fmt.Println("💡 Make sure the peer ID is correct and the peer is online")
+		if !wrapper.ConnectToPeer(peerID) {
+			out.Error("Failed to connect to peer: %s", peerID)
+			out.Info("Make sure the peer ID is correct and the peer is online")
+			return networkError(errPeerUnreachable)
+		}
+		out.Success("Successfully connected to peer: %s", peerID)
+
+	case "/join":
+		if len(parts) < 2 {
+			out.Error("Usage: /join <invite_link|invite_code> [name]")
+			return generalError(errUsage)
+		}
+
+		if wrapper.IsUsingSimulation() {
+			out.Warn("Cannot redeem invites in simulation mode")
+			return nil
+		}
+
+		link, err := p2p.ParseInviteURI(parts[1])
+		if err != nil {
+			out.Error("%v", err)
+			return generalError(err)
+		}
+		name := ""
+		if len(parts) > 2 {
+			name = parts[2]
 		}
+		return joinInvite(out, wrapper, link, name)
+
+	case "/msg", "/send":
+		return handleMsgCommand(out, command, parts[1:], wrapper)
+
+	case "/switch":
+		return handleSwitchCommand(out, parts[1:], wrapper)
+
+	case "/next", "/prev":
+		handleCycleCommand(out, command == "/next", wrapper)
+
+	case "/list":
+		handleListCommand(out, wrapper)
+
+	case "/name":
+		if len(parts) < 2 {
+			out.Error("Usage: /name <name>")
+			return generalError(errUsage)
+		}
+
+		out.Println("🏷️  Claiming name on the DHT...")
+		rec, err := wrapper.RegisterName(parts[1])
+		if err != nil {
+			out.Error("Failed to claim name: %v", err)
+			return networkError(err)
+		}
+		out.Success("You are now @%s", rec.Name)
+
+	case "/whois":
+		if len(parts) < 2 {
+			out.Error("Usage: /whois @<name>")
+			return generalError(errUsage)
+		}
+
+		rec, err := wrapper.ResolveName(parts[1])
+		if err != nil {
+			out.Error("%v", err)
+			return generalError(err)
+		}
+		out.Printf("🏷️  @%s\n", rec.Name)
+		out.Printf("  Peer ID: %s\n", rec.PeerID)
+		out.Printf("  DID: %s\n", rec.DID)
+		out.Printf("  Claimed: %s (PoW difficulty %d)\n", rec.ClaimedAt.Format("2006-01-02 15:04:05"), rec.Weight())
+
+	case "/pin":
+		if len(parts) < 3 {
+			out.Error("Usage: /pin <@name|peer_id> <any|lan|no-relay|onion>")
+			return generalError(errUsage)
+		}
+
+		policy, err := p2p.ParseTransportPolicy(parts[2])
+		if err != nil {
+			out.Error("%v", err)
+			return generalError(err)
+		}
+		peerID, err := wrapper.ResolvePeer(parts[1])
+		if err != nil {
+			out.Error("Failed to resolve %s: %v", parts[1], err)
+			return peerNotFoundError(err)
+		}
+		if err := wrapper.PinTransport(peerID, policy); err != nil {
+			out.Error("Failed to pin transport: %v", err)
+			return generalError(err)
+		}
+		out.Printf("📌 %s pinned to %s\n", parts[1], policy.Description())
+		if policy != p2p.TransportAny {
+			out.Info("Connections over other paths are refused, even if the peer stays offline")
+		}
+
+	case "/pins":
+		return printTransportPins(out)
+
+	case "/visibility":
+		if len(parts) < 2 {
+			current := wrapper.Visibility()
+			out.Println("👁️  Discovery visibility:")
+			for _, v := range p2p.Visibilities {
+				marker := " "
+				if v == current {
+					marker = "*"
+				}
+				out.Printf("  %s %-20s - %s\n", marker, v, v.Description())
+			}
+			out.Info("Use '/visibility <level>' to change it")
+			return nil
+		}
+
+		level, err := p2p.ParseVisibility(parts[1])
+		if err != nil {
+			out.Error("%v", err)
+			return generalError(err)
+		}
+		if err := wrapper.ChangeVisibility(level); err != nil {
+			out.Error("Failed to change visibility: %v", err)
+			return generalError(err)
+		}
+		out.Printf("👁️  Visible to %s\n", level.Description())
+		if level != p2p.VisibilityEveryone {
+			out.Info("Announcements already in the DHT expire on their own within a couple of days")
+		}
+
+	case "/battery":
+		return handleBatteryCommand(out, parts[1:], wrapper)
+
+	case "/rendezvous":
+		return handleRendezvousCommand(out, parts[1:], wrapper)
+
+	case "/history":
+		return handleHistoryCommand(out, parts[1:], wrapper)
+
+	case "/search":
+		return handleSearchCommand(out, parts[1:], wrapper)
+
+	case "/star", "/unstar":
+		if len(parts) < 2 {
+			out.Error("Usage: %s <message_id>", command)
+			return generalError(errUsage)
+		}
+		id, err := wrapper.StarMessage(parts[1], command == "/star")
+		if err != nil {
+			out.Error("%v", err)
+			return generalError(err)
+		}
+		printStarResult(out, id, command == "/star")
+
+	case "/starred":
+		return handleStarredCommand(out, parts[1:], wrapper)
+
+	case "/accept", "/reject":
+		return handleFileOfferAnswer(out, parts[1:], command == "/accept", wrapper)
+
+	case "/profile":
+		return handleProfileCommand(out, parts[1:], wrapper)
+
+	case "/reset-session":
+		return handleResetSessionCommand(out, parts[1:], wrapper)
+
+	case "/ping":
+		return handlePingCommand(out, parts[1:], wrapper)
+
+	case "/sendfile":
+		return handleSendFileCommand(out, parts[1:], wrapper)
+
+	case "/transfer":
+		return handleTransferCommand(out, parts[1:], wrapper)
+
+	case "/sync-dir":
+		if len(parts) < 3 {
+			out.Error("Usage: /sync-dir <@name|peer_id> <path>")
+			return generalError(errUsage)
+		}
+
+		peerID, err := wrapper.ResolvePeer(parts[1])
+		if err != nil {
+			out.Error("Failed to resolve %s: %v", parts[1], err)
+			return peerNotFoundError(err)
+		}
+		if err := wrapper.CheckSendAllowed(peerID); err != nil {
+			out.Warn("%v", err)
+			return permissionError(err)
+		}
+
+		out.Printf("📂 Comparing %s with %s...\n", parts[2], parts[1])
+		result, err := wrapper.SyncDirectory(peerID, strings.Join(parts[2:], " "))
+		if err != nil {
+			out.Error("Directory sync failed: %v", err)
+			printRefusalHint(out, err)
+			return peerRefusedError(err)
+		}
+		printDirSyncResult(out, result)
+
+	case "/contacts":
+		contacts := wrapper.ListContacts()
+		out.Println("📇 Contacts:")
+		if len(contacts) == 0 {
+			out.Println("  (No contacts saved)")
+			out.Info("Use '/add <name> <peer_id>' to save a contact")
+			return nil
+		}
+		for _, c := range contacts {
+			state := "unverified"
+			switch {
+			case c.NeedsVerification():
+				state = "⚠️  SAFETY NUMBER CHANGED"
+			case c.Verified:
+				state = "✅ verified"
+			}
+			out.Printf("  %s - %s [%s]\n", c.Name, c.PeerID, state)
+			if c.Policy != nil {
+				out.Printf("    📜 %s\n", c.Policy)
+			}
+		}
+
+	case "/add":
+		if len(parts) < 3 {
+			out.Error("Usage: /add <name> <peer_id>")
+			return generalError(errUsage)
+		}
+		contact, err := wrapper.AddContact(parts[1], parts[2])
+		if err != nil {
+			out.Error("Failed to add contact: %v", err)
+			return generalError(err)
+		}
+		if warnKeyChanged(out, contact) {
+			return nil
+		}
+		out.Success("Contact '%s' saved, key pinned", parts[1])
+
+	case "/verify":
+		if len(parts) < 2 {
+			out.Error("Usage: /verify <name>")
+			return generalError(errUsage)
+		}
+		number, err := wrapper.SafetyNumber(parts[1])
+		if err != nil {
+			out.Error("%v", err)
+			return generalError(err)
+		}
+		out.Printf("🔐 Safety number with %s:\n", parts[1])
+		out.Printf("   %s\n", number)
+		out.Info("Compare this number with your contact over a trusted channel")
+		if err := wrapper.VerifyContact(parts[1]); err != nil {
+			out.Error("Failed to mark contact verified: %v", err)
+			return generalError(err)
+		}
+		out.Success("Contact '%s' marked as verified", parts[1])
+
+	case "/policy":
+		return handlePolicyCommand(out, parts[1:], wrapper)
+
+	case "/whoami":
+		handleWhoamiCommand(out, wrapper)
+
+	case "/fingerprint":
+		return handleFingerprintCommand(out, parts[1:], wrapper)
 
 	case "/status":
-		fmt.Println("📊 Node Status:")
-		fmt.Printf("  Peer ID: %s\n", nodeInfo.PeerID)
-		fmt.Printf("  DID: %s\n", nodeInfo.DID)
-		fmt.Printf("  Addresses: %v\n", nodeInfo.ListenAddrs)
-		fmt.Printf("  Running: %t\n", nodeInfo.IsRunning)
+		out.Println("📊 Node Status:")
+		out.Printf("  Peer ID: %s\n", nodeInfo.PeerID)
+		out.Printf("  DID: %s\n", nodeInfo.DID)
+		out.Printf("  Addresses: %v\n", nodeInfo.ListenAddrs)
+		out.Printf("  Running: %t\n", nodeInfo.IsRunning)
 
 	case "/clear":
 		// Clear screen using ANSI escape codes
-		fmt.Print("\033[2J\033[H")
-		fmt.Println("💬 Xelvra P2P Chat - Screen cleared")
-		fmt.Println("Type /help for available commands")
+		out.Print("\033[2J\033[H")
+		out.Println("💬 Xelvra P2P Chat - Screen cleared")
+		out.Println("Type /help for available commands")
 
 	case "/quit", "/exit":
-		fmt.Println("👋 Goodbye!")
+		out.Println("👋 Goodbye!")
 		os.Exit(0)
 
+	case "/stats":
+		return handleStatsCommand(out, parts[1:], wrapper)
+
+	default:
+		out.Error("Unknown command: %s", command)
+		if suggestion := suggestChatCommand(command); suggestion != "" {
+			out.Info("Did you mean %s?", suggestion)
+		} else {
+			out.Info("Type /help for available commands")
+		}
+		return generalError(fmt.Errorf("unknown command %s", command))
+	}
+	return nil
+}
+
+// isChatCommand reports whether command is a known chat command
+func isChatCommand(command string) bool {
+	for _, c := range chatCommands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestChatCommand returns the command a mistyped one most likely meant
+func suggestChatCommand(command string) string {
+	stats := commandStats
+	if stats == nil {
+		stats, _ = LoadCommandStats("")
+	}
+	return stats.Suggest(command, chatCommands)
+}
+
+// handleStatsCommand shows the node's resource use, or shows or resets the
+// chat command usage counts
+func handleStatsCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 {
+		return printResourceStats(out, wrapper)
+	}
+	if args[0] != "commands" {
+		out.Error("Usage: /stats [commands [reset]]")
+		return generalError(errUsage)
+	}
+	if commandStats == nil {
+		out.Error("Command usage is not being tracked")
+		return generalError(errors.New("command usage is not being tracked"))
+	}
+
+	if len(args) > 1 && args[1] == "reset" {
+		if err := commandStats.Reset(); err != nil {
+			out.Error("Failed to reset command usage: %v", err)
+			return generalError(err)
+		}
+		out.Success("Command usage reset")
+		return nil
+	}
+
+	usage := commandStats.Usage()
+	total := 0
+	for _, u := range usage {
+		total += u.Count
+	}
+
+	out.Println("📊 Command usage (stored only on this device):")
+	if total == 0 {
+		out.Println("  (No commands used yet)")
+		return nil
+	}
+	for _, u := range usage {
+		out.Printf("  %-12s %5d  %3.0f%%  last used %s\n", u.Command, u.Count,
+			float64(u.Count)*100/float64(total), u.LastUsed.Format("2006-01-02 15:04"))
+	}
+	out.Printf("  Total: %d command(s)\n", total)
+	return nil
+}
+
+// handleHistoryCommand prints the last messages of a conversation. Without a
+// peer it shows the current conversation, or the only connected peer.
+func handleHistoryCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	query := db.HistoryQuery{Limit: 20, HideArchived: true}
+
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			if n <= 0 {
+				out.Error("Message count must be positive")
+				return generalError(errUsage)
+			}
+			query.Limit = n
+			args = args[:len(args)-1]
+		}
+	}
+
+	switch {
+	case len(args) > 0:
+		peerID, err := wrapper.ResolvePeer(args[0])
+		if err != nil {
+			out.Error("Failed to resolve %s: %v", args[0], err)
+			return peerNotFoundError(err)
+		}
+		query.PeerID = peerID
+	case chatTabs.Current() != "":
+		query.PeerID = chatTabs.Current()
 	default:
-		fmt.Printf("❌ Unknown command: %s\n", command)
-		fmt.Println("💡 Type /help for available commands")
+		if connected := wrapper.GetConnectedPeers(); len(connected) == 1 {
+			query.PeerID = connected[0]
+		}
 	}
+
+	entries, err := wrapper.QueryHistory(query)
+	if err != nil {
+		out.Error("%v", err)
+		return generalError(err)
+	}
+	printHistory(out, entries)
+	return nil
 }
 
-// HandleChatMessage sends a message to connected peers
-func HandleChatMessage(message string, wrapper *p2p.P2PWrapper) {
-	fmt.Printf("📤 Sending: %s\n", message)
+// handleStarredCommand lists starred messages of one or all conversations
+func handleStarredCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	query := db.HistoryQuery{Starred: true, Limit: starredLimit, Ascending: true}
+	if len(args) > 0 {
+		peerID, err := wrapper.ResolvePeer(args[0])
+		if err != nil {
+			out.Error("Failed to resolve %s: %v", args[0], err)
+			return peerNotFoundError(err)
+		}
+		query.PeerID = peerID
+	}
+
+	entries, err := wrapper.QueryHistory(query)
+	if err != nil {
+		out.Error("%v", err)
+		return generalError(err)
+	}
+	dataDir, _ := user.DataDir()
+	printStarred(out, entries, contactNames(dataDir))
+	return nil
+}
+
+// handleSearchCommand searches the message history for all given words
+func handleSearchCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	query := db.SearchQuery{}
+
+	if len(args) >= 2 && args[0] == "--peer" {
+		peerID, err := wrapper.ResolvePeer(args[1])
+		if err != nil {
+			out.Error("Failed to resolve %s: %v", args[1], err)
+			return peerNotFoundError(err)
+		}
+		query.PeerID = peerID
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		out.Error("Usage: /search [--peer <@name|peer_id>] <words>")
+		return generalError(errUsage)
+	}
+	query.Text = strings.Join(args, " ")
+
+	results, err := wrapper.SearchHistory(query)
+	if err != nil {
+		out.Error("Search failed: %v", err)
+		return generalError(err)
+	}
+	printSearchResults(out, results)
+	return nil
+}
+
+// handleChatInput runs a chat command, or sends a message, typed in chat.
+// What it prints goes to out.
+func handleChatInput(out ui.Printer, input string, wrapper *p2p.P2PWrapper, nodeInfo *p2p.NodeInfo) error {
+	if strings.HasPrefix(input, "/") {
+		return HandleChatCommand(out, input, wrapper, nodeInfo)
+	}
+	return HandleChatMessage(out, input, wrapper)
+}
+
+// HandleChatMessage sends a message to the current conversation, or to all
+// connected peers. It returns an error if the message could not be sent.
+func HandleChatMessage(out ui.Printer, message string, wrapper *p2p.P2PWrapper) error {
+	if current := chatTabs.Current(); current != "" {
+		label := chatPeerLabel(wrapper, current)
+		out.Printf("📤 Sending to %s: %s\n", label, message)
+		if err := sendDirect(out, wrapper, current, message); err != nil {
+			return err
+		}
+		out.Success("Message sent to %s", label)
+		return nil
+	}
+
+	out.Printf("📤 Sending: %s\n", message)
 
 	if wrapper.IsUsingSimulation() {
-		fmt.Println("⚠️  Cannot send messages in simulation mode")
-		fmt.Printf("✅ Message simulated: '%s'\n", message)
-		return
+		out.Warn("Cannot send messages in simulation mode")
+		out.Success("Message simulated: '%s'", message)
+		return nil
 	}
 
 	// Get connected peers
 	connectedPeers := wrapper.GetConnectedPeers()
 	if len(connectedPeers) == 0 {
-		fmt.Println("⚠️  No connected peers to send message to")
-		fmt.Println("💡 Use '/discover' to find peers, then '/connect <peer_id>' to connect")
-		return
+		out.Warn("No connected peers to send message to")
+		out.Info("Use '/discover' to find peers, then '/connect <peer_id>' to connect")
+		return networkError(errors.New("no connected peers"))
+	}
+
+	// Warn about contacts whose safety number changed
+	for _, peerID := range connectedPeers {
+		if err := wrapper.CheckSendAllowed(peerID); err != nil {
+			out.Warn("%v", err)
+		}
 	}
 
 	// Send message to all connected peers
-	success := wrapper.SendMessageToMultiplePeers(message, connectedPeers)
-	if success {
-		fmt.Printf("✅ Message sent to %d peer(s): '%s'\n", len(connectedPeers), message)
-	} else {
-		fmt.Printf("❌ Failed to send message: '%s'\n", message)
-		fmt.Println("💡 Check your connection and try again")
+	if !wrapper.SendMessageToMultiplePeers(message, connectedPeers) {
+		out.Error("Failed to send message: '%s'", message)
+		out.Info("Check your connection and try again")
+		return networkError(errors.New("failed to send message"))
+	}
+	out.Success("Message sent to %d peer(s): '%s'", len(connectedPeers), message)
+	return nil
+}
+
+// warnKeyChanged warns that a saved contact came back with another key it
+// never announced, and reports whether it did
+func warnKeyChanged(out ui.Printer, contact *user.Contact) bool {
+	if !contact.NeedsVerification() {
+		return false
 	}
+	out.Warn("SAFETY NUMBER CHANGED for contact '%s'", contact.Name)
+	out.Printf("   Old Peer ID: %s\n", contact.PreviousPeerID)
+	out.Printf("   New Peer ID: %s\n", contact.PeerID)
+	out.Println("   The contact did not announce this key. It may have been reinstalled")
+	out.Println("   - or someone may be intercepting the conversation.")
+	out.Info("Compare safety numbers, then run '/verify %s' to continue sending", contact.Name)
+	return true
 }
@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// liveConfigKeys are the settings a running node applies when it reloads
+// its configuration; the others take effect on the next start
+var liveConfigKeys = []string{
+	"discovery.mdns",
+	"discovery.udp_broadcast",
+	"logging.format",
+	"logging.level",
+}
+
+// formatConfigValue shows the value of a setting on one line
+func formatConfigValue(value interface{}) string {
+	if list, ok := value.([]string); ok {
+		if len(list) == 0 {
+			return "(none)"
+		}
+		return strings.Join(list, ", ")
+	}
+	if text, ok := value.(string); ok && text == "" {
+		return "(none)"
+	}
+	return fmt.Sprint(value)
+}
+
+// loadConfigForEdit reads the configuration file for the config commands,
+// which do not need it to be valid before they run
+func loadConfigForEdit(cmd *cobra.Command) (string, *p2p.ConfigFile, error) {
+	path, err := configFilePath(cmd)
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return "", nil, configError(err)
+	}
+	migrateConfig(path)
+	config, err := p2p.LoadConfigFile(path)
+	if err != nil {
+		ui.Error("Invalid configuration: %v", err)
+		if errors.Is(err, p2p.ErrConfigEnvironment) {
+			ui.Info("Fix or unset the variable")
+		} else {
+			ui.Info("'peerchat-cli config unset <key>' removes a setting that is invalid")
+		}
+		return "", nil, configError(err)
+	}
+	warnConfigVersion(path, config)
+	return path, config, nil
+}
+
+// RunConfigList handles the config list command
+func RunConfigList(cmd *cobra.Command, args []string) error {
+	path, config, err := loadConfigForEdit(cmd)
+	if err != nil {
+		return err
+	}
+
+	ui.Printf("⚙️  Settings in %s:\n", path)
+	defaults := p2p.DefaultConfigFile()
+	for _, key := range p2p.ConfigKeys() {
+		value, _ := config.Get(key)
+		line := fmt.Sprintf("  %-24s %s", key, formatConfigValue(value))
+		if name := config.Overrides[key]; name != "" {
+			line += fmt.Sprintf(" (from $%s)", name)
+		} else if initial, _ := defaults.Get(key); formatConfigValue(initial) == formatConfigValue(value) {
+			line += " (default)"
+		}
+		ui.Println(line)
+	}
+	if names := config.ProfileNames(); len(names) > 0 {
+		ui.Printf("🗂️  Profiles: %s\n", strings.Join(names, ", "))
+	}
+	for _, key := range config.Unknown {
+		ui.Warn("Unknown setting %q is ignored", key)
+	}
+	ui.Info("Change one with 'peerchat-cli config set <key> <value>'")
+	return nil
+}
+
+// RunConfigGet handles the config get command, printing the value alone so
+// scripts can use it; the entries of a list go on lines of their own
+func RunConfigGet(cmd *cobra.Command, args []string) error {
+	_, config, err := loadConfigForEdit(cmd)
+	if err != nil {
+		return err
+	}
+	value, err := config.Get(args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		ui.Info("Use 'peerchat-cli config list' to see the settings")
+		return configError(err)
+	}
+	if list, ok := value.([]string); ok {
+		for _, entry := range list {
+			ui.Println(entry)
+		}
+		return nil
+	}
+	ui.Println(value)
+	return nil
+}
+
+// RunConfigSet handles the config set command
+func RunConfigSet(cmd *cobra.Command, args []string) error {
+	path, err := configFilePath(cmd)
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	migrateConfig(path)
+	key := args[0]
+	value, err := p2p.ParseConfigValue(key, args[1:])
+	if err != nil {
+		ui.Error("%v", err)
+		ui.Info("Use 'peerchat-cli config list' to see the settings")
+		return configError(err)
+	}
+	if err := p2p.SetConfigValue(path, key, value); err != nil {
+		ui.Error("Failed to set %s: %v", key, err)
+		return configError(err)
+	}
+	ui.Success("Set %s to %s in %s", key, formatConfigValue(value), path)
+	warnConfigOverride(key)
+	reloadRunningNode(key)
+	return nil
+}
+
+// RunConfigUnset handles the config unset command
+func RunConfigUnset(cmd *cobra.Command, args []string) error {
+	path, err := configFilePath(cmd)
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	migrateConfig(path)
+	key := args[0]
+	if _, err := p2p.DefaultConfigFile().Get(key); err != nil {
+		ui.Error("%v", err)
+		ui.Info("Use 'peerchat-cli config list' to see the settings")
+		return configError(err)
+	}
+	if err := p2p.UnsetConfigValue(path, key); err != nil {
+		ui.Error("Failed to unset %s: %v", key, err)
+		return configError(err)
+	}
+	ui.Success("Removed %s from %s, the default applies", key, path)
+	warnConfigOverride(key)
+	reloadRunningNode(key)
+	return nil
+}
+
+// warnConfigOverride tells the user when the environment overrides the
+// setting key they just changed in the file
+func warnConfigOverride(key string) {
+	if name := p2p.ConfigEnvOverride(key); name != "" {
+		ui.Warn("$%s overrides %s while it is set", name, key)
+	}
+}
+
+// reloadRunningNode asks the running node, if any, to read its
+// configuration file again after key was changed
+func reloadRunningNode(key string) {
+	status, err := p2p.ReadNodeStatus()
+	if err != nil || status == nil || !status.Fresh(time.Now()) || status.ProcessID <= 0 || status.ProcessID == os.Getpid() {
+		ui.Info("Takes effect the next time the node starts")
+		return
+	}
+	process, err := os.FindProcess(status.ProcessID)
+	if err == nil {
+		err = process.Signal(syscall.SIGHUP)
+	}
+	if err != nil {
+		ui.Warn("Failed to signal the running node (PID %d): %v", status.ProcessID, err)
+		ui.Info("Takes effect the next time the node starts")
+		return
+	}
+	ui.Printf("🔄 Asked the running node (PID %d) to reload its configuration\n", status.ProcessID)
+	if !slices.Contains(liveConfigKeys, key) {
+		ui.Info("%s takes effect the next time the node starts", key)
+	}
+}
+
+// watchConfigReload reloads the configuration file whenever the process
+// gets SIGHUP, until ctx is done, and applies the settings a running node
+// can change to wrapper
+func watchConfigReload(ctx context.Context, cmd *cobra.Command, wrapper *p2p.P2PWrapper) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		current := loadedConfig
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				current = reloadConfig(cmd, wrapper, current)
+			}
+		}
+	}()
+}
+
+// reloadConfig reads the configuration file again and applies what changed
+// since current to wrapper, returning the configuration now in effect. An
+// invalid file is reported and changes nothing.
+func reloadConfig(cmd *cobra.Command, wrapper *p2p.P2PWrapper, current *p2p.ConfigFile) *p2p.ConfigFile {
+	path, err := configFilePath(cmd)
+	if err != nil {
+		return current
+	}
+	config, err := p2p.LoadConfigFile(path)
+	if err != nil {
+		ui.Error("Not reloading the configuration: %v", err)
+		return current
+	}
+	config, reason := selectProfile(cmd, config)
+	if config.Profile != current.Profile && reason != "" {
+		ui.Printf("🗂️  Using profile %s, %s\n", config.Profile, reason)
+	}
+
+	changed := p2p.ChangedConfigKeys(current, config)
+	if len(changed) == 0 {
+		ui.Printf("🔄 Reloaded %s, nothing changed\n", path)
+		return config
+	}
+	if level, err := logrus.ParseLevel(config.Logging.Level); err == nil {
+		wrapper.SetLogLevel(level)
+	}
+	wrapper.SetLogFormat(config.Logging.Format)
+	if config.Discovery != current.Discovery {
+		wrapper.SetLANDiscovery(config.Discovery.MDNS, config.Discovery.UDPBroadcast)
+	}
+
+	var restart []string
+	for _, key := range changed {
+		if !slices.Contains(liveConfigKeys, key) {
+			restart = append(restart, key)
+		}
+	}
+	ui.Printf("🔄 Reloaded %s: %s changed\n", path, strings.Join(changed, ", "))
+	if len(restart) > 0 {
+		ui.Info("Restart the node to apply %s", strings.Join(restart, ", "))
+	}
+	return config
+}
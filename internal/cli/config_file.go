@@ -0,0 +1,247 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// configFlag gives the configuration file
+	configFlag = "config"
+
+	// profileFlag selects a profile of the configuration file
+	profileFlag = "profile"
+
+	// noConfigAnnotation marks the commands that do not read the
+	// configuration file, so they work while it is broken
+	noConfigAnnotation = "no-config"
+)
+
+var (
+	// loadedConfig is the configuration file read before the command ran,
+	// from loadedConfigPath
+	loadedConfig     = p2p.DefaultConfigFile()
+	loadedConfigPath string
+)
+
+// configFilePath returns the configuration file given with --config, or
+// config.yaml in the data directory
+func configFilePath(cmd *cobra.Command) (string, error) {
+	if path, _ := cmd.Flags().GetString(configFlag); path != "" {
+		return path, nil
+	}
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, p2p.ConfigFileName), nil
+}
+
+// loadConfig reads the configuration file before the command runs. A file
+// that cannot be parsed or holds invalid settings stops the command, as the
+// node would otherwise run with settings the user did not choose, and so
+// does a file given with --config that does not exist.
+func loadConfig(cmd *cobra.Command) error {
+	if cmd.Annotations[noConfigAnnotation] != "" || cmd.Name() == "help" {
+		return nil
+	}
+	path, err := configFilePath(cmd)
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	if cmd.Flags().Changed(configFlag) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			ui.Error("Configuration file %s does not exist", path)
+			ui.Info("Check the path, or create the file with 'peerchat-cli config set'")
+			return configError(err)
+		}
+	}
+	migrateConfig(path)
+
+	config, err := p2p.LoadConfigFile(path)
+	if err != nil {
+		ui.Error("Invalid configuration: %v", err)
+		if errors.Is(err, p2p.ErrConfigEnvironment) {
+			ui.Info("Fix or unset the variable; 'peerchat-cli manual' lists the settings")
+		} else {
+			ui.Info("Fix or remove %s; 'peerchat-cli manual' lists the settings", path)
+		}
+		return configError(err)
+	}
+	warnConfigVersion(path, config)
+	for _, key := range config.Unknown {
+		ui.Warn("Ignoring unknown setting %q in %s", key, path)
+	}
+	if name, _ := cmd.Flags().GetString(profileFlag); name != "" {
+		if _, err := config.WithProfile(name); err != nil {
+			ui.Error("%v", err)
+			if names := config.ProfileNames(); len(names) > 0 {
+				ui.Info("Profiles in %s: %s", path, strings.Join(names, ", "))
+			} else {
+				ui.Info("Add profiles to %s; 'peerchat-cli manual' shows how", path)
+			}
+			return configError(err)
+		}
+	}
+	loadedConfig, loadedConfigPath = config, path
+	return nil
+}
+
+// migrateConfig brings the configuration file at path up to the current
+// format, telling the user what changed. A file that cannot be rewritten,
+// e.g. on a read-only volume, is still read as migrated.
+func migrateConfig(path string) {
+	migration, err := p2p.MigrateConfigFile(path)
+	if err != nil {
+		ui.Warn("Failed to migrate %s to the current format: %v", path, err)
+		return
+	}
+	if migration == nil {
+		return
+	}
+	ui.Printf("🔄 Migrated %s from version %d to %d\n", path, migration.From, migration.To)
+	if len(migration.Changes) == 0 {
+		ui.Println("   No settings changed")
+	}
+	for _, change := range migration.Changes {
+		ui.Printf("   %s\n", change)
+	}
+	ui.Info("The previous file is kept as %s", migration.Backup)
+}
+
+// warnConfigVersion tells the user when the configuration file at path
+// was written by a newer release, whose settings may not all be known
+func warnConfigVersion(path string, config *p2p.ConfigFile) {
+	if config.Version > p2p.ConfigVersion {
+		ui.Warn("%s is version %d, newer than this release reads (%d); settings it does not know are ignored",
+			path, config.Version, p2p.ConfigVersion)
+	}
+}
+
+// selectProfile applies the profile given with --profile or set in the
+// configuration, or else the first one matching the current network. It
+// returns the configuration in effect and why the profile was selected,
+// empty when none was.
+func selectProfile(cmd *cobra.Command, config *p2p.ConfigFile) (*p2p.ConfigFile, string) {
+	name, reason := config.Profile, "set in the configuration"
+	if env := config.Overrides["profile"]; env != "" {
+		reason = "set by $" + env
+	}
+	if flag, _ := cmd.Flags().GetString(profileFlag); flag != "" {
+		name, reason = flag, "given with --"+profileFlag
+	}
+	if name == "" && len(config.Profiles) > 0 {
+		if id, err := p2p.CurrentNetwork(); err == nil {
+			name, reason = config.MatchProfile(id), "matching the network "+id.Key()
+		}
+	}
+	if name == "" {
+		return config, ""
+	}
+	applied, err := config.WithProfile(name)
+	if err != nil {
+		return config, ""
+	}
+	return applied, reason
+}
+
+// applyConfigFile applies the listen and announce addresses, the bootstrap
+// peers, the relays, the hooks, the transports, the discovery methods, the
+// logging settings and the swarm key of the configuration file read before
+// the command ran, with the profile selected by selectProfile. It returns false
+// if the node must not start: a swarm key that is configured but cannot be
+// read would otherwise put the node on the public network.
+func applyConfigFile(cmd *cobra.Command, wrapper *p2p.P2PWrapper) bool {
+	config, path := loadedConfig, loadedConfigPath
+	if path == "" {
+		var err error
+		if path, err = configFilePath(cmd); err != nil {
+			return true
+		}
+	}
+	config, reason := selectProfile(cmd, config)
+	if reason != "" {
+		ui.Printf("🗂️  Using profile %s, %s\n", config.Profile, reason)
+		wrapper.SetProfile(config.Profile)
+	}
+	loadedConfig = config
+	if level, err := logrus.ParseLevel(config.Logging.Level); err == nil {
+		wrapper.SetLogLevel(level)
+	}
+	wrapper.SetLogFormat(config.Logging.Format)
+	if !config.Network.EnableQUIC {
+		wrapper.DisableQUIC()
+	}
+	if !config.Network.EnableTCP {
+		wrapper.DisableTCP()
+	}
+	if !config.Discovery.MDNS || !config.Discovery.UDPBroadcast {
+		wrapper.SetLANDiscovery(config.Discovery.MDNS, config.Discovery.UDPBroadcast)
+	}
+	if len(config.Listen) > 0 {
+		wrapper.SetListenAddrs(config.Listen)
+	}
+	if len(config.Announce) > 0 {
+		wrapper.SetAnnounceAddrs(config.Announce)
+	}
+	if len(config.BootstrapPeers) > 0 {
+		peers, err := p2p.ResolveBootstrapPeers(context.Background(), config.BootstrapPeers)
+		if err != nil {
+			ui.Warn("Bootstrap peers: %v", err)
+		}
+		if len(peers) > 0 {
+			wrapper.SetBootstrapPeers(peers)
+		}
+	}
+	if len(config.Rendezvous) > 0 {
+		wrapper.SetRendezvous(config.Rendezvous)
+	}
+	if len(config.Relays) > 0 {
+		if relays, err := p2p.ParseRelayAddrs(config.Relays); err == nil {
+			wrapper.SetRelays(relays)
+		}
+	}
+	if hook := config.Hooks.OnMessage; hook != "" {
+		if !filepath.IsAbs(hook) {
+			hook = filepath.Join(filepath.Dir(path), hook)
+		}
+		if info, err := os.Stat(hook); err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			ui.Warn("Ignoring hooks.on_message: %s is not an executable file", hook)
+		} else {
+			wrapper.SetOnMessageHook(hook)
+		}
+	}
+
+	psk, err := configuredSwarmKey(path, config)
+	if err != nil {
+		ui.Error("Failed to load the swarm key of the private network: %v", err)
+		return false
+	}
+	if psk != nil {
+		wrapper.UsePrivateNetwork(psk)
+		ui.Printf("🔒 Private network %s: only nodes with the same swarm key can connect, QUIC is off\n", p2p.SwarmKeyFingerprint(psk))
+	}
+	return true
+}
+
+// configuredSwarmKey returns the swarm key set in the environment, in the
+// configuration file at path, relative to its directory, or next to it;
+// nil joins the public network
+func configuredSwarmKey(path string, config *p2p.ConfigFile) (pnet.PSK, error) {
+	keyPath := config.SwarmKey
+	if keyPath != "" && !filepath.IsAbs(keyPath) {
+		keyPath = filepath.Join(filepath.Dir(path), keyPath)
+	}
+	return p2p.ResolveSwarmKey(keyPath, filepath.Dir(path))
+}
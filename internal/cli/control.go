@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+)
+
+const (
+	// ControlSocketFileName is the socket in the data directory the running
+	// node takes commands on. Only the owner of the data directory can
+	// connect to it.
+	ControlSocketFileName = "control.sock"
+
+	// controlTimeout bounds one exchange on the control socket
+	controlTimeout = 30 * time.Second
+)
+
+// controlRequest is a command sent to the running node
+type controlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// controlResponse is the running node's answer to a controlRequest. A
+// failed command carries its error and the exit code it maps to, next to
+// what it printed.
+type controlResponse struct {
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Result   string `json:"result,omitempty"`
+}
+
+// controlHandler runs one command of the control socket
+type controlHandler func(wrapper *p2p.P2PWrapper, args []string) (string, error)
+
+// controlHandlers are the commands the control socket takes
+var controlHandlers = map[string]controlHandler{
+	"send":  controlSend,
+	"chat":  controlChat,
+	"drain": controlDrain,
+}
+
+// controlChatMu runs the chat commands of different callers one at a time,
+// as they share the chat's state such as the current conversation
+var controlChatMu sync.Mutex
+
+// controlSocketPath returns the path of the control socket
+func controlSocketPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, ControlSocketFileName), nil
+}
+
+// serveControl takes commands on the control socket for the node of
+// wrapper until the returned function is called
+func serveControl(wrapper *p2p.P2PWrapper) (func(), error) {
+	path, err := controlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// A socket left by a node that did not stop cleanly is in the way, one
+	// another node still answers on is not
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return nil, errors.New("another node serves the control socket")
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleControlConn(conn, wrapper)
+		}
+	}()
+	return func() {
+		_ = listener.Close()
+		<-done
+		_ = os.Remove(path)
+	}, nil
+}
+
+// handleControlConn answers one request on the control socket
+func handleControlConn(conn net.Conn, wrapper *p2p.P2PWrapper) {
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req controlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	var resp controlResponse
+	handler, ok := controlHandlers[req.Command]
+	if !ok {
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+		resp.ExitCode = ExitGeneral
+	} else {
+		result, err := handler(wrapper, req.Args)
+		resp.Result = result
+		if err != nil {
+			resp.Error = err.Error()
+			resp.ExitCode = ExitCode(err)
+		}
+	}
+	_ = json.NewEncoder(conn).Encode(resp)
+}
+
+// callControl sends a command to the running node. Failing to reach it is a
+// network error; a command that failed there returns its error with the
+// exit code the node gave it, along with its result.
+func callControl(ctx context.Context, command string, args ...string) (string, error) {
+	path, err := controlSocketPath()
+	if err != nil {
+		return "", configError(err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return "", networkError(fmt.Errorf("failed to reach the running node: %w", err))
+	}
+	defer func() { _ = conn.Close() }()
+	deadline := time.Now().Add(controlTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if err := json.NewEncoder(conn).Encode(controlRequest{Command: command, Args: args}); err != nil {
+		return "", networkError(fmt.Errorf("failed to send command to the running node: %w", err))
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", networkError(fmt.Errorf("no answer from the running node: %w", err))
+	}
+	if resp.Error != "" {
+		return resp.Result, &ExitError{Code: resp.ExitCode, Err: errors.New(resp.Error)}
+	}
+	return resp.Result, nil
+}
+
+// controlSend queues a message for a peer, contact or @name. The result is
+// the peer ID it was queued for.
+func controlSend(wrapper *p2p.P2PWrapper, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", generalError(errors.New("send takes a peer and a message"))
+	}
+	if wrapper.IsUsingSimulation() {
+		return "", networkError(errSimulation)
+	}
+
+	peerID, err := ResolveChatPeer(wrapper, args[0])
+	if err != nil {
+		return "", peerNotFoundError(err)
+	}
+	if err := wrapper.CheckSendAllowed(peerID); err != nil {
+		return "", permissionError(err)
+	}
+	if err := wrapper.SendMessage(peerID, args[1]); err != nil {
+		return "", networkError(err)
+	}
+	return peerID, nil
+}
+
+// controlChat runs a chat command, or sends a message, as if typed in the
+// node's chat. The result is what it printed, left unformatted for the
+// caller's terminal. Whatever else the node prints meanwhile, such as
+// received messages, stays on its own terminal.
+func controlChat(wrapper *p2p.P2PWrapper, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", generalError(errors.New("chat takes one command or message"))
+	}
+	if args[0] == "/quit" || args[0] == "/exit" {
+		return "", generalError(errors.New("the running node is stopped from its own terminal"))
+	}
+
+	controlChatMu.Lock()
+	defer controlChatMu.Unlock()
+	var out bytes.Buffer
+	err := handleChatInput(ui.To(&out), args[0], wrapper, wrapper.GetNodeInfo())
+	return out.String(), err
+}
+
+// controlDrain waits until the messages sent were delivered or queued for
+// offline delivery, or scriptDrainTimeout passes
+func controlDrain(wrapper *p2p.P2PWrapper, args []string) (string, error) {
+	waitForOutbox(wrapper, scriptDrainTimeout)
+	return "", nil
+}
+
+// startControl serves the control socket for a node that just started. A
+// node that cannot serve it still runs, but commands such as send cannot
+// reach it.
+func startControl(wrapper *p2p.P2PWrapper) func() {
+	stop, err := serveControl(wrapper)
+	if err != nil {
+		ui.Warn("Commands such as 'send' cannot reach this node: %v", err)
+		return func() {}
+	}
+	return stop
+}
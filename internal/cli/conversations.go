@@ -0,0 +1,358 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// chatTabs are the conversations of interactive chat. The current one is
+// where typed messages go, chosen with /switch, /next and /prev or by
+// addressing a peer with /msg; with none they go to every connected peer.
+var chatTabs = NewConversationTabs()
+
+// ConversationTab is one conversation of interactive chat
+type ConversationTab struct {
+	PeerID  string
+	Unread  int
+	Current bool
+}
+
+// ConversationTabs tracks the conversations of interactive chat, in the
+// order they started, and how many messages of each were not read. It is
+// safe for concurrent use.
+type ConversationTabs struct {
+	mu      sync.Mutex
+	peers   []string
+	unread  map[string]int
+	current string
+}
+
+// NewConversationTabs creates conversation tracking with no conversation
+// started
+func NewConversationTabs() *ConversationTabs {
+	return &ConversationTabs{unread: make(map[string]int)}
+}
+
+// open adds a conversation with peerID if there is none yet
+func (t *ConversationTabs) open(peerID string) {
+	for _, p := range t.peers {
+		if p == peerID {
+			return
+		}
+	}
+	t.peers = append(t.peers, peerID)
+}
+
+// Received counts a message from peerID as unread, unless it is the
+// current conversation
+func (t *ConversationTabs) Received(peerID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.open(peerID)
+	if peerID != t.current {
+		t.unread[peerID]++
+	}
+}
+
+// Switch makes the conversation with peerID current and marks it read; an
+// empty peerID sends typed messages to every connected peer again
+func (t *ConversationTabs) Switch(peerID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current = peerID
+	if peerID != "" {
+		t.open(peerID)
+		delete(t.unread, peerID)
+	}
+}
+
+// Current returns the peer of the current conversation, if any
+func (t *ConversationTabs) Current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.current
+}
+
+// Next makes the conversation after the current one current, wrapping
+// around, and returns its peer. With no conversation it returns "".
+func (t *ConversationTabs) Next() string {
+	return t.cycle(1)
+}
+
+// Prev makes the conversation before the current one current, wrapping
+// around, and returns its peer
+func (t *ConversationTabs) Prev() string {
+	return t.cycle(-1)
+}
+
+func (t *ConversationTabs) cycle(step int) string {
+	t.mu.Lock()
+	if len(t.peers) == 0 {
+		t.mu.Unlock()
+		return ""
+	}
+	i := -1
+	for j, p := range t.peers {
+		if p == t.current {
+			i = j
+		}
+	}
+	if i < 0 && step < 0 {
+		i = 0
+	}
+	next := t.peers[(i+step+len(t.peers))%len(t.peers)]
+	t.mu.Unlock()
+
+	t.Switch(next)
+	return next
+}
+
+// Unread returns how many messages from peerID were not read
+func (t *ConversationTabs) Unread(peerID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.unread[peerID]
+}
+
+// Tabs lists the conversations in the order they started
+func (t *ConversationTabs) Tabs() []ConversationTab {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tabs := make([]ConversationTab, len(t.peers))
+	for i, p := range t.peers {
+		tabs[i] = ConversationTab{PeerID: p, Unread: t.unread[p], Current: p == t.current}
+	}
+	return tabs
+}
+
+// Prompt returns the chat prompt: the current conversation, named by label,
+// and how many messages wait in the others, as in "[alice (2)] > "
+func (t *ConversationTabs) Prompt(label func(peerID string) string) string {
+	t.mu.Lock()
+	current, waiting := t.current, 0
+	for _, n := range t.unread {
+		waiting += n
+	}
+	t.mu.Unlock()
+
+	name := "all"
+	switch {
+	case current != "":
+		name = label(current)
+	case waiting == 0:
+		return "> "
+	}
+	if waiting > 0 {
+		return fmt.Sprintf("[%s (%d)] > ", name, waiting)
+	}
+	return fmt.Sprintf("[%s] > ", name)
+}
+
+// ChatPeers is what chat needs of the node to resolve and complete the
+// peers typed in it; *p2p.P2PWrapper implements it
+type ChatPeers interface {
+	GetConnectedPeers() []string
+	ListContacts() []*user.Contact
+	ResolvePeer(target string) (string, error)
+}
+
+var _ ChatPeers = (*p2p.P2PWrapper)(nil)
+
+// ResolveChatPeer maps a peer typed in chat to its peer ID: an @nickname, a
+// contact name, a peer ID, or the start of a connected peer's ID
+func ResolveChatPeer(peers ChatPeers, target string) (string, error) {
+	if strings.HasPrefix(target, "@") {
+		return peers.ResolvePeer(target)
+	}
+	for _, contact := range peers.ListContacts() {
+		if strings.EqualFold(contact.Name, target) {
+			return contact.PeerID, nil
+		}
+	}
+
+	// Short prefixes can decode as peer IDs of their own, so connected
+	// peers are matched first
+	var matches []string
+	for _, peerID := range peers.GetConnectedPeers() {
+		if strings.HasPrefix(peerID, target) {
+			matches = append(matches, peerID)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("%s matches %d connected peers, type more of the peer ID", target, len(matches))
+	}
+
+	if _, err := peer.Decode(target); err != nil {
+		return "", fmt.Errorf("%s is not a contact, a connected peer or a peer ID", target)
+	}
+	return target, nil
+}
+
+// chatPeerLabel names a peer by contact name, or by shortened peer ID
+func chatPeerLabel(wrapper *p2p.P2PWrapper, peerID string) string {
+	for _, contact := range wrapper.ListContacts() {
+		if contact.PeerID == peerID {
+			return contact.Name
+		}
+	}
+	return shortPeerID(peerID)
+}
+
+// sendDirect sends text to one peer, queueing it when the peer is offline
+func sendDirect(out ui.Printer, wrapper *p2p.P2PWrapper, peerID, text string) error {
+	if err := wrapper.CheckSendAllowed(peerID); err != nil {
+		out.Warn("%v", err)
+		return permissionError(err)
+	}
+	if !wrapper.IsUsingSimulation() && !wrapper.ConnectToPeer(peerID) {
+		out.Println("📥 Peer not reachable right now, message will be delivered when it comes online")
+	}
+	if err := wrapper.SendMessage(peerID, text); err != nil {
+		out.Error("Failed to send message: %v", err)
+		return networkError(err)
+	}
+	return nil
+}
+
+// handleMsgCommand sends a message to one peer with /msg or /send, and
+// makes it the current conversation
+func handleMsgCommand(out ui.Printer, command string, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) < 2 {
+		out.Error("Usage: %s <peer|contact|@name> <message>", command)
+		return generalError(errUsage)
+	}
+
+	peerID, err := ResolveChatPeer(wrapper, args[0])
+	if err != nil {
+		out.Error("Failed to resolve %s: %v", args[0], err)
+		return peerNotFoundError(err)
+	}
+	if err := sendDirect(out, wrapper, peerID, strings.Join(args[1:], " ")); err != nil {
+		return err
+	}
+	chatTabs.Switch(peerID)
+	out.Printf("📤 Sent to %s\n", chatPeerLabel(wrapper, peerID))
+	return nil
+}
+
+// handleSwitchCommand shows or changes where typed messages go: one peer,
+// or everyone with /switch all
+func handleSwitchCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 {
+		current := chatTabs.Current()
+		if current == "" {
+			out.Println("📢 Messages go to all connected peers")
+			out.Info("Use '/switch <peer|contact>' to talk to one peer")
+			return nil
+		}
+		out.Printf("💬 Talking to %s (%s)\n", chatPeerLabel(wrapper, current), current)
+		out.Info("Use '/switch all' to message all connected peers")
+		return nil
+	}
+
+	if args[0] == "all" {
+		chatTabs.Switch("")
+		out.Println("📢 Messages now go to all connected peers")
+		return nil
+	}
+
+	peerID, err := ResolveChatPeer(wrapper, args[0])
+	if err != nil {
+		out.Error("Failed to resolve %s: %v", args[0], err)
+		return peerNotFoundError(err)
+	}
+	chatTabs.Switch(peerID)
+	printSwitched(out, wrapper, peerID)
+	return nil
+}
+
+// printSwitched tells who typed messages go to after a switch
+func printSwitched(out ui.Printer, wrapper *p2p.P2PWrapper, peerID string) {
+	out.Printf("💬 Now talking to %s, '/switch all' messages everyone\n", chatPeerLabel(wrapper, peerID))
+	if !isConnected(wrapper, peerID) {
+		out.Println("📥 Not connected right now, messages will be delivered when it comes online")
+	}
+}
+
+// handleCycleCommand moves to the next or previous conversation
+func handleCycleCommand(out ui.Printer, next bool, wrapper *p2p.P2PWrapper) {
+	peerID := chatTabs.Prev()
+	if next {
+		peerID = chatTabs.Next()
+	}
+	if peerID == "" {
+		out.Println("💬 No conversations yet")
+		out.Info("Use '/msg <peer> <text>' or '/switch <peer>' to start one")
+		return
+	}
+	printSwitched(out, wrapper, peerID)
+}
+
+// handleListCommand lists the conversations with their unread messages
+func handleListCommand(out ui.Printer, wrapper *p2p.P2PWrapper) {
+	tabs := chatTabs.Tabs()
+	out.Println("💬 Conversations:")
+	if chatTabs.Current() == "" {
+		out.Println("  * all connected peers (current)")
+	}
+	if len(tabs) == 0 {
+		out.Println("  (No conversations yet)")
+		out.Info("Use '/msg <peer> <text>' or '/switch <peer>' to start one")
+		return
+	}
+	for i, tab := range tabs {
+		marker, state := " ", ""
+		if tab.Current {
+			marker, state = "*", " (current)"
+		}
+		if tab.Unread > 0 {
+			state += fmt.Sprintf(" - %d unread", tab.Unread)
+		}
+		online := "○"
+		if isConnected(wrapper, tab.PeerID) {
+			online = "●"
+		}
+		out.Printf("  %s %d. %s %s%s\n", marker, i+1, online, chatPeerLabel(wrapper, tab.PeerID), state)
+	}
+	out.Info("/next and /prev move between conversations, /switch <peer> picks one")
+}
+
+// chatPrompt returns the prompt of interactive chat, naming the current
+// conversation
+func chatPrompt(wrapper *p2p.P2PWrapper) string {
+	return chatTabs.Prompt(func(peerID string) string {
+		return chatPeerLabel(wrapper, peerID)
+	})
+}
+
+// printChatMessage prints a text message received in interactive chat and
+// counts it for its conversation
+func printChatMessage(wrapper *p2p.P2PWrapper, peerID string, msg *message.Message) {
+	chatTabs.Received(peerID)
+	ui.Printf("\n📨 Message from %s:\n", chatPeerLabel(wrapper, peerID))
+	ui.Printf("   %s\n", string(msg.Content))
+	if tags := message.FilterMatchOf(msg.Metadata).Tags; len(tags) > 0 {
+		ui.Printf("   🏷️  %s\n", strings.Join(tags, ", "))
+	}
+	ui.Printf("   [%s]\n\n", msg.Timestamp.Format("15:04:05"))
+}
+
+// isConnected reports whether the node is connected to peerID
+func isConnected(wrapper *p2p.P2PWrapper, peerID string) bool {
+	for _, connected := range wrapper.GetConnectedPeers() {
+		if connected == peerID {
+			return true
+		}
+	}
+	return false
+}
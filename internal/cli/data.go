@@ -0,0 +1,691 @@
+package cli
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/tracing"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// DataManifestName describes the contents of a data export archive
+	DataManifestName = "manifest.json"
+
+	// chatHistoryFileName holds the interactive chat command history
+	chatHistoryFileName = "chat_history"
+)
+
+// DataManifest describes a data export archive. Files kept by the node are
+// copied under data/ as they are on disk; encrypted stores are included
+// decrypted instead.
+type DataManifest struct {
+	CreatedAt time.Time      `json:"created_at"`
+	PeerID    string         `json:"peer_id,omitempty"`
+	DID       string         `json:"did,omitempty"`
+	Contacts  int            `json:"contacts"`
+	Messages  int            `json:"messages"` // History entries in history.json
+	Queued    int            `json:"queued"`   // Unsent messages in offline_messages.json
+	Files     []DataFileInfo `json:"files"`
+	Omitted   []string       `json:"omitted,omitempty"`
+}
+
+// DataFileInfo is one file in a data export archive
+type DataFileInfo struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SameAs string `json:"same_as,omitempty"` // Hard link to a file already in the archive
+}
+
+// exportedIdentity is the public part of the identity; the private key is
+// never exported
+type exportedIdentity struct {
+	PeerID    string    `json:"peer_id"`
+	DID       string    `json:"did"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// exportedOfflineMessages holds the decrypted offline queue and outbox
+type exportedOfflineMessages struct {
+	Queued map[string][]*message.OfflineMessage `json:"queued"`
+	Outbox []*message.Message                   `json:"outbox"`
+}
+
+// loadLocalIdentity loads the identity in dataDir without creating one. It
+// returns nil if there is none.
+func loadLocalIdentity(dataDir string) (*user.MessengerID, error) {
+	path := filepath.Join(dataDir, user.IdentityFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	identity, _, err := user.LoadOrCreateMessengerID(path)
+	return identity, err
+}
+
+// dataExportSkipped reports whether a file in the data directory is left
+// out of raw copies, and why. Secrets and encrypted stores are replaced by
+// readable versions; partial files are incomplete.
+func dataExportSkipped(rel string, withFiles bool) (bool, string) {
+	base := filepath.Base(rel)
+	top := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+
+	switch {
+	case rel == user.IdentityFileName:
+		return true, "private identity key (public part in identity.json)"
+	case rel == db.KeyFileName:
+		return true, "history encryption key"
+	case strings.HasPrefix(rel, db.DatabaseName):
+		return true, ""
+	case top == message.OfflineDirName:
+		return true, ""
+	case top == message.HeldBackupsDirName:
+		return true, "backups held for other peers (encrypted with their keys)"
+	case strings.HasSuffix(base, ".tmp") || strings.HasSuffix(base, ".part"):
+		return true, ""
+	case !withFiles && (top == message.AttachmentsDirName || top == "downloads"):
+		return true, "received files (--no-files)"
+	}
+	return false, ""
+}
+
+// ExportUserData writes a zip archive of everything stored in dataDir to w:
+// the history and the offline queue decrypted, and every other file as it
+// is. Without withFiles, received files are left out.
+func ExportUserData(dataDir string, w io.Writer, withFiles bool) (*DataManifest, error) {
+	zw := zip.NewWriter(w)
+	manifest := &DataManifest{CreatedAt: time.Now(), Files: []DataFileInfo{}}
+
+	writeJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: manifest.CreatedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(data); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, DataFileInfo{Path: name, Size: int64(len(data))})
+		return nil
+	}
+
+	identity, err := loadLocalIdentity(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+	if identity != nil {
+		defer identity.Destroy()
+		manifest.PeerID = identity.GetPeerID().String()
+		manifest.DID = identity.GetDID()
+		if err := writeJSON("identity.json", exportedIdentity{
+			PeerID:    manifest.PeerID,
+			DID:       manifest.DID,
+			PublicKey: identity.GetPublicKeyHex(),
+			CreatedAt: identity.CreatedAt,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	names := contactNames(dataDir)
+	manifest.Contacts = len(names)
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if history != nil {
+		defer closeHistory()
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: "history.json", Method: zip.Deflate, Modified: manifest.CreatedAt})
+		if err != nil {
+			return nil, err
+		}
+		counter := &countingWriter{w: entry}
+		if manifest.Messages, err = history.ExportHistory(counter, db.ExportOptions{Format: db.ExportJSON, Names: names}); err != nil {
+			return nil, fmt.Errorf("failed to export history: %w", err)
+		}
+		manifest.Files = append(manifest.Files, DataFileInfo{Path: "history.json", Size: counter.n})
+	}
+
+	if identity != nil {
+		queued, outbox, err := message.ReadOfflineMessages(filepath.Join(dataDir, message.OfflineDirName), identity)
+		if err != nil {
+			manifest.Omitted = append(manifest.Omitted, fmt.Sprintf("offline messages: %v", err))
+		} else if len(queued) > 0 || len(outbox) > 0 {
+			for _, messages := range queued {
+				manifest.Queued += len(messages)
+			}
+			manifest.Queued += len(outbox)
+			if err := writeJSON("offline_messages.json", exportedOfflineMessages{Queued: queued, Outbox: outbox}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Received files are hard links to the attachment store; store each once
+	var copied []struct {
+		info os.FileInfo
+		path string
+	}
+	err = filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		if skip, reason := dataExportSkipped(rel, withFiles); skip {
+			if reason != "" && !slices.Contains(manifest.Omitted, reason) {
+				manifest.Omitted = append(manifest.Omitted, reason)
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name := "data/" + filepath.ToSlash(rel)
+		for _, c := range copied {
+			if os.SameFile(c.info, info) {
+				manifest.Files = append(manifest.Files, DataFileInfo{Path: name, Size: info.Size(), SameAs: c.path})
+				return nil
+			}
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name, header.Method = name, zip.Deflate
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, file)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", rel, err)
+		}
+
+		copied = append(copied, struct {
+			info os.FileInfo
+			path string
+		}{info, name})
+		manifest.Files = append(manifest.Files, DataFileInfo{Path: name, Size: info.Size()})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := writeJSON(DataManifestName, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, zw.Close()
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ErasedData is one kind of data ErasePeerData removed
+type ErasedData struct {
+	What  string
+	Count int
+}
+
+// ErasureReport lists what ErasePeerData removed about a peer
+type ErasureReport struct {
+	PeerID  string
+	Contact string // Name the peer was saved under, if any
+	Erased  []ErasedData
+	Freed   int64 // Space freed by deleting received files and held backups
+}
+
+// add records removed data, ignoring kinds with nothing removed
+func (r *ErasureReport) add(what string, count int) {
+	if count > 0 {
+		r.Erased = append(r.Erased, ErasedData{What: what, Count: count})
+	}
+}
+
+// Total returns the number of items removed
+func (r *ErasureReport) Total() int {
+	total := 0
+	for _, e := range r.Erased {
+		total += e.Count
+	}
+	return total
+}
+
+// ErasePeerData removes everything stored in dataDir about a peer. It goes
+// on after a failure, so as much as possible is erased, and returns the
+// failures together with the report. The node must not be running, or it
+// may write some of the data back.
+func ErasePeerData(dataDir, peerID string) (*ErasureReport, error) {
+	report := &ErasureReport{PeerID: peerID}
+	var errs []error
+	fail := func(what string, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", what, err))
+	}
+
+	contacts, err := user.LoadContactBook(filepath.Join(dataDir, user.ContactsFileName))
+	if err != nil {
+		fail("contacts", err)
+	} else if contact, ok := contacts.FindByPeerID(peerID); ok {
+		report.Contact = contact.Name
+		if err := contacts.Remove(contact.Name); err != nil {
+			fail("contacts", err)
+		} else {
+			report.add("contact", 1)
+		}
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		fail("history", err)
+	} else if history != nil {
+		erased, err := history.ErasePeer(peerID)
+		closeHistory()
+		if err != nil {
+			fail("history", err)
+		} else {
+			report.add("messages in the history", erased.Messages)
+			report.add("file transfer records", erased.Transfers)
+		}
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	store := message.NewAttachmentStore(filepath.Join(dataDir, message.AttachmentsDirName), logger)
+	if receipts, freed, err := store.ErasePeer(peerID); err != nil {
+		fail("attachments", err)
+	} else {
+		report.add("received files", receipts)
+		report.Freed = freed
+	}
+
+	if identity, err := loadLocalIdentity(dataDir); err != nil {
+		fail("offline messages", err)
+	} else if identity != nil {
+		removed, err := message.EraseOfflineMessages(filepath.Join(dataDir, message.OfflineDirName), identity, peerID)
+		identity.Destroy()
+		if err != nil {
+			fail("offline messages", err)
+		}
+		report.add("unsent messages", removed)
+	}
+
+	removed, err := message.ErasePeerSyncFolders(filepath.Join(dataDir, message.SyncFoldersFileName),
+		filepath.Join(dataDir, message.SyncStateDirName), peerID)
+	if err != nil {
+		fail("sync folders", err)
+	}
+	report.add("synced folders", removed)
+
+	// Backups held for the peer; they are opaque, so not in the export either
+	held := message.NewHeldBackupStore(filepath.Join(dataDir, message.HeldBackupsDirName))
+	removed, freed, err := held.RemoveOwner(peerID)
+	if err != nil {
+		fail("held backups", err)
+	}
+	report.add("held backups", removed)
+	report.Freed += freed
+
+	for _, setting := range erasePeerSettings(dataDir, peerID) {
+		if setting.err != nil {
+			fail(setting.what, setting.err)
+		}
+		report.add(setting.what, setting.count)
+	}
+
+	avatars, _ := filepath.Glob(filepath.Join(dataDir, AvatarsDirName, peerID+".*"))
+	removed = 0
+	for _, path := range avatars {
+		if err := os.Remove(path); err != nil {
+			fail("avatars", err)
+			continue
+		}
+		removed++
+	}
+	report.add("avatar", removed)
+
+	// Commands typed in chat and log lines naming the peer
+	mentions := func(line string) bool {
+		if strings.Contains(line, peerID) {
+			return true
+		}
+		if report.Contact == "" {
+			return false
+		}
+		for _, field := range strings.Fields(line) {
+			if field == report.Contact || field == "@"+report.Contact {
+				return true
+			}
+		}
+		return false
+	}
+	removed, err = removeLines(filepath.Join(dataDir, chatHistoryFileName), mentions)
+	if err != nil {
+		fail("chat command history", err)
+	}
+	report.add("chat command history lines", removed)
+
+	logs, _ := filepath.Glob(filepath.Join(dataDir, "peerchat*.log*"))
+	removed = 0
+	for _, path := range logs {
+		n, err := removeLines(path, mentions)
+		if err != nil {
+			fail("logs", err)
+		}
+		removed += n
+	}
+	report.add("log lines", removed)
+
+	removed, err = removeLines(filepath.Join(dataDir, tracing.TraceFileName), mentions)
+	if err != nil {
+		fail("traces", err)
+	}
+	report.add("trace spans", removed)
+
+	return report, errors.Join(errs...)
+}
+
+// erasedSetting is the outcome of removing a peer from one settings file
+type erasedSetting struct {
+	what  string
+	count int
+	err   error
+}
+
+// erasePeerSettings removes a peer from the settings that name peers
+func erasePeerSettings(dataDir, peerID string) []erasedSetting {
+	var results []erasedSetting
+
+	// Profile facet shown to the peer
+	profilesPath := filepath.Join(dataDir, user.ProfilesFileName)
+	result := erasedSetting{what: "profile assignment"}
+	if profiles, err := user.LoadProfileSettings(profilesPath); err != nil {
+		result.err = err
+	} else if _, ok := profiles.Assigned[peerID]; ok {
+		delete(profiles.Assigned, peerID)
+		result.count, result.err = 1, profiles.Save(profilesPath)
+	}
+	results = append(results, result)
+
+	// Content filters for the conversation
+	filtersPath := filepath.Join(dataDir, message.FiltersFileName)
+	result = erasedSetting{what: "content filters"}
+	if filters, err := message.LoadFilters(filtersPath); err != nil {
+		result.err = err
+	} else {
+		kept := filters[:0]
+		for _, f := range filters {
+			if f.PeerID != peerID {
+				kept = append(kept, f)
+			}
+		}
+		if result.count = len(filters) - len(kept); result.count > 0 {
+			result.err = message.SaveFilters(filtersPath, kept)
+		}
+	}
+	results = append(results, result)
+
+	// Wake hooks for messages from the peer
+	hooksPath := filepath.Join(dataDir, message.HooksFileName)
+	result = erasedSetting{what: "wake hooks"}
+	if hooks, err := message.LoadHooks(hooksPath); err != nil {
+		result.err = err
+	} else {
+		kept := hooks[:0]
+		for _, h := range hooks {
+			if h.PeerID != peerID {
+				kept = append(kept, h)
+			}
+		}
+		if result.count = len(hooks) - len(kept); result.count > 0 {
+			result.err = message.SaveHooks(hooksPath, kept)
+		}
+	}
+	results = append(results, result)
+
+	timeoutsPath := filepath.Join(dataDir, message.TimeoutsFileName)
+	result = erasedSetting{what: "timeout override"}
+	if config, err := message.LoadTimeoutConfig(timeoutsPath); err != nil {
+		result.err = err
+	} else if _, ok := config.Peers[peerID]; ok {
+		delete(config.Peers, peerID)
+		result.count, result.err = 1, message.SaveTimeoutConfig(timeoutsPath, config)
+	}
+	results = append(results, result)
+
+	retentionPath := filepath.Join(dataDir, db.RetentionFileName)
+	result = erasedSetting{what: "retention rule"}
+	if policy, err := db.LoadRetentionPolicy(retentionPath); err != nil {
+		result.err = err
+	} else if _, ok := policy.Peers[peerID]; ok {
+		delete(policy.Peers, peerID)
+		result.count, result.err = 1, db.SaveRetentionPolicy(retentionPath, policy)
+	}
+	results = append(results, result)
+
+	allowlistPath := filepath.Join(dataDir, message.FileAllowlistFileName)
+	result = erasedSetting{what: "auto-accept entry"}
+	if peers, err := message.LoadFileAllowlist(allowlistPath); err != nil {
+		result.err = err
+	} else if i := slices.Index(peers, peerID); i >= 0 {
+		result.count, result.err = 1, message.SaveFileAllowlist(allowlistPath, slices.Delete(peers, i, i+1))
+	}
+	results = append(results, result)
+
+	result = erasedSetting{what: "transport pin"}
+	if pins, err := p2p.ListTransportPins(); err != nil {
+		result.err = err
+	} else {
+		for _, pin := range pins {
+			if pin.PeerID == peerID {
+				result.count, result.err = 1, p2p.SetTransportPin(peerID, p2p.TransportAny)
+			}
+		}
+	}
+	results = append(results, result)
+
+	result = erasedSetting{what: "redeemed invites"}
+	result.count, result.err = p2p.ForgetInvitesUsedBy(peerID)
+	results = append(results, result)
+
+	hostingPath := filepath.Join(dataDir, message.BackupHostingFileName)
+	result = erasedSetting{what: "backup allowance"}
+	if hosting, err := message.LoadBackupHosting(hostingPath); err != nil {
+		result.err = err
+	} else if _, ok := hosting.Peers[peerID]; ok {
+		delete(hosting.Peers, peerID)
+		result.count, result.err = 1, message.SaveBackupHosting(hostingPath, hosting)
+	}
+	results = append(results, result)
+
+	return results
+}
+
+// removeLines deletes the lines of a text file that match and returns how
+// many were deleted. Missing files are ignored.
+func removeLines(path string, match func(line string) bool) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var kept strings.Builder
+	removed := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if match(line) {
+				removed++
+			} else {
+				kept.WriteString(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = file.Close()
+			return 0, err
+		}
+	}
+	info, statErr := file.Stat()
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	mode := os.FileMode(0600)
+	if statErr == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(kept.String()), mode); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	return removed, nil
+}
+
+// RunDataExport handles the data export command
+func RunDataExport(cmd *cobra.Command, args []string) error {
+	out, _ := cmd.Flags().GetString("out")
+	noFiles, _ := cmd.Flags().GetBool("no-files")
+
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	if out == "" {
+		out = fmt.Sprintf("xelvra-data-%s.zip", time.Now().Format("20060102-150405"))
+	}
+	file, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		ui.Error("Failed to create %s: %v", out, err)
+		return generalError(err)
+	}
+
+	manifest, err := ExportUserData(dataDir, file, !noFiles)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		ui.Error("Export failed: %v", err)
+		_ = os.Remove(out)
+		return generalError(err)
+	}
+
+	var size int64
+	for _, f := range manifest.Files {
+		size += f.Size
+	}
+	ui.Success("Exported your data to %s", out)
+	ui.Printf("   %d contact(s), %d message(s), %d unsent message(s), %d file(s), %s\n",
+		manifest.Contacts, manifest.Messages, manifest.Queued, len(manifest.Files), formatBytes(size))
+	for _, omitted := range manifest.Omitted {
+		ui.Printf("   Left out: %s\n", omitted)
+	}
+	ui.Warn("The archive is not encrypted; store it accordingly")
+	return nil
+}
+
+// RunDataErase handles the data erase command
+func RunDataErase(cmd *cobra.Command, args []string) error {
+	target, _ := cmd.Flags().GetString("peer")
+	target = strings.TrimPrefix(target, "@")
+
+	// A running node keeps some of the data in memory and would write it back
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Error("Stop the running node before erasing data")
+		return generalError(errNodeRunning)
+	}
+
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	peerID := resolveContactName(dataDir, target)
+	if _, err := peer.Decode(peerID); err != nil {
+		ui.Error("%s is neither a contact nor a peer ID", target)
+		return peerNotFoundError(err)
+	}
+
+	report, err := ErasePeerData(dataDir, peerID)
+	who := shortPeerID(peerID)
+	if report.Contact != "" {
+		who = fmt.Sprintf("%s (%s)", report.Contact, who)
+	}
+
+	if report.Total() == 0 {
+		ui.Printf("🗑️  Nothing was stored about %s\n", who)
+	} else {
+		ui.Printf("🗑️  Erased everything stored about %s:\n", who)
+		for _, erased := range report.Erased {
+			ui.Printf("  %-28s %d\n", erased.What, erased.Count)
+		}
+		if report.Freed > 0 {
+			ui.Printf("  Freed %s of received files and held backups\n", formatBytes(report.Freed))
+		}
+	}
+	if err != nil {
+		ui.Printf("⚠️  Some data could not be erased:\n%v\n", err)
+		return generalError(err)
+	}
+	ui.Info("Files in synced folders and exports you made are left in place")
+	return nil
+}
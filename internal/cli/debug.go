@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// debugProfiles are fetched by debug dump, by file name
+var debugProfiles = []struct {
+	file, path string
+}{
+	{"runtime.json", "/debug/runtime"},
+	{"heap.pb.gz", "/debug/pprof/heap"},
+	{"allocs.pb.gz", "/debug/pprof/allocs"},
+	{"goroutine.pb.gz", "/debug/pprof/goroutine"},
+	{"goroutines.txt", "/debug/pprof/goroutine?debug=2"},
+}
+
+// diagnosticsSettingsPath returns the path of the diagnostics settings
+func diagnosticsSettingsPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, p2p.DiagnosticsFileName), nil
+}
+
+// runningDiagnosticsAddr returns the address of the running node's
+// diagnostics endpoint, or "" when no node serves one
+func runningDiagnosticsAddr() (string, bool) {
+	status, err := p2p.ReadNodeStatus()
+	if err != nil || status == nil || !status.IsRunning {
+		return "", false
+	}
+	return status.DiagnosticsAddr, true
+}
+
+// RunDebugStatus handles the debug command
+func RunDebugStatus(cmd *cobra.Command, args []string) error {
+	path, err := diagnosticsSettingsPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	settings, err := p2p.LoadDiagnosticsSettings(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	if !settings.Enabled {
+		ui.Println("🩺 Diagnostics endpoint: off")
+		ui.Info("'peerchat-cli debug enable' serves profiles on localhost from the next node start")
+		return nil
+	}
+	ui.Printf("🩺 Diagnostics endpoint: on, port %d (localhost only)\n", settings.Port)
+
+	addr, running := runningDiagnosticsAddr()
+	switch {
+	case !running:
+		ui.Info("It is served while the node runs")
+	case addr == "":
+		ui.Warn("The running node does not serve it; restart the node to apply the setting")
+	default:
+		ui.Printf("🔗 http://%s/debug/pprof/\n", addr)
+		ui.Info("go tool pprof http://%s/debug/pprof/heap", addr)
+		ui.Info("'peerchat-cli debug dump' saves heap and goroutine profiles")
+	}
+	return nil
+}
+
+// RunDebugEnable handles the debug enable command
+func RunDebugEnable(cmd *cobra.Command, args []string) error {
+	port, _ := cmd.Flags().GetInt("port")
+	if port <= 0 || port > 65535 {
+		ui.Error("The port must be between 1 and 65535")
+		return generalError(fmt.Errorf("invalid port %d", port))
+	}
+	return setDiagnostics(&p2p.DiagnosticsSettings{Enabled: true, Port: port})
+}
+
+// RunDebugDisable handles the debug disable command
+func RunDebugDisable(cmd *cobra.Command, args []string) error {
+	return setDiagnostics(&p2p.DiagnosticsSettings{Port: p2p.DefaultDiagnosticsPort})
+}
+
+// setDiagnostics saves the diagnostics settings
+func setDiagnostics(settings *p2p.DiagnosticsSettings) error {
+	path, err := diagnosticsSettingsPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	if err := p2p.SaveDiagnosticsSettings(path, settings); err != nil {
+		ui.Error("Failed to save diagnostics settings: %v", err)
+		return generalError(err)
+	}
+
+	if settings.Enabled {
+		ui.Success("Diagnostics endpoint enabled on 127.0.0.1:%d", settings.Port)
+	} else {
+		ui.Success("Diagnostics endpoint disabled")
+	}
+	if _, running := runningDiagnosticsAddr(); running {
+		ui.Info("Restart the node to apply")
+	}
+	return nil
+}
+
+// RunDebugDump handles the debug dump command
+func RunDebugDump(cmd *cobra.Command, args []string) error {
+	out, _ := cmd.Flags().GetString("out")
+	cpu, _ := cmd.Flags().GetDuration("cpu")
+	trace, _ := cmd.Flags().GetDuration("runtime-trace")
+
+	addr, running := runningDiagnosticsAddr()
+	if !running {
+		ui.Error("No node is running")
+		ui.Info("Profiles are taken from a running node: start it with 'peerchat-cli start'")
+		return networkError(errNodeNotRunning)
+	}
+	if addr == "" {
+		ui.Error("The running node does not serve diagnostics")
+		ui.Info("Run 'peerchat-cli debug enable' and restart the node, or start it with --pprof")
+		return configError(errors.New("diagnostics endpoint is off"))
+	}
+
+	if out == "" {
+		dataDir, err := user.DataDir()
+		if err != nil {
+			ui.Error("Failed to find home directory: %v", err)
+			return configError(err)
+		}
+		out = filepath.Join(dataDir, "debug", time.Now().Format("20060102-150405"))
+	}
+	if err := os.MkdirAll(out, 0700); err != nil {
+		ui.Error("Failed to create %s: %v", out, err)
+		return generalError(err)
+	}
+
+	profiles := debugProfiles
+	if cpu > 0 {
+		ui.Printf("⏱️  Profiling CPU for %s...\n", cpu)
+		profiles = append(profiles, struct{ file, path string }{
+			"cpu.pb.gz", fmt.Sprintf("/debug/pprof/profile?seconds=%d", max(int(cpu.Seconds()), 1)),
+		})
+	}
+	if trace > 0 {
+		ui.Printf("⏱️  Recording a runtime trace for %s...\n", trace)
+		profiles = append(profiles, struct{ file, path string }{
+			"trace.out", fmt.Sprintf("/debug/pprof/trace?seconds=%g", max(trace.Seconds(), 0.1)),
+		})
+	}
+
+	client := &http.Client{Timeout: max(cpu, trace) + 30*time.Second}
+	saved := 0
+	for _, profile := range profiles {
+		path := filepath.Join(out, profile.file)
+		if err := fetchProfile(client, "http://"+addr+profile.path, path); err != nil {
+			ui.Error("%s: %v", profile.file, err)
+			continue
+		}
+		saved++
+	}
+	if saved == 0 {
+		return networkError(errors.New("no profile could be fetched"))
+	}
+
+	ui.Success("Saved %d profiles to %s", saved, out)
+	ui.Info("go tool pprof -top %s", filepath.Join(out, "heap.pb.gz"))
+	if trace > 0 {
+		ui.Info("go tool trace %s", filepath.Join(out, "trace.out"))
+	}
+	return nil
+}
+
+// fetchProfile downloads a profile from the diagnostics endpoint to path
+func fetchProfile(client *http.Client, url, path string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// subnetPeer is a node found by a subnet scan, as printed with --json
+type subnetPeer struct {
+	PeerID string   `json:"peer_id"`
+	Addrs  []string `json:"addrs,omitempty"`
+	Self   bool     `json:"self,omitempty"`
+}
+
+// printSubnetPeers prints the nodes a subnet scan found, marking the
+// running node, if any
+func printSubnetPeers(found []peer.AddrInfo, self string) {
+	if jsonMode() {
+		for _, info := range found {
+			entry := subnetPeer{PeerID: info.ID.String(), Self: info.ID.String() == self}
+			for _, addr := range info.Addrs {
+				entry.Addrs = append(entry.Addrs, addr.String())
+			}
+			printJSON(entry)
+		}
+		return
+	}
+
+	for _, info := range found {
+		if info.ID.String() == self {
+			ui.Printf("🏠 %s (this node)\n", info.ID)
+			continue
+		}
+		ui.Printf("📡 %s\n", info.ID)
+		for _, addr := range info.Addrs {
+			ui.Printf("    %s\n", addr)
+		}
+		if len(info.Addrs) > 0 {
+			ui.Printf("    💡 peerchat-cli connect %s/p2p/%s\n", info.Addrs[0], info.ID)
+		}
+	}
+}
+
+// runDiscoverSubnet probes every address of a subnet on the discovery port
+// and prints the nodes that answered
+func runDiscoverSubnet(cidr string) error {
+	subnet, err := p2p.ParseScanSubnet(cidr)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	var self string
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		self = status.PeerID
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ui.Printf("🔍 Scanning %s for Xelvra nodes...\n", subnet)
+	found, err := p2p.ScanSubnet(ctx, subnet, 0)
+	if err != nil {
+		ui.Error("%v", err)
+		return networkError(err)
+	}
+	printSubnetPeers(found, self)
+	if len(found) == 0 {
+		ui.Error("No nodes answered")
+		ui.Info("Nodes answer only while visible to everyone and from private addresses")
+		return peerNotFoundError(errors.New("no nodes answered"))
+	}
+	ui.Printf("📊 %d node(s) answered\n", len(found))
+	return nil
+}
+
+// handleDiscoverSubnet scans a subnet from the chat node and connects to the
+// nodes found
+func handleDiscoverSubnet(out ui.Printer, wrapper *p2p.P2PWrapper, cidr string) error {
+	out.Printf("🔍 Scanning %s for Xelvra nodes...\n", cidr)
+	found, err := wrapper.ScanSubnet(context.Background(), cidr)
+	if err != nil {
+		out.Error("%v", err)
+		return networkError(err)
+	}
+	if len(found) == 0 {
+		out.Error("No other nodes answered")
+		return peerNotFoundError(errors.New("no other nodes answered"))
+	}
+	for _, info := range found {
+		out.Printf("📡 %s\n", info.ID)
+		for _, addr := range info.Addrs {
+			out.Printf("    %s\n", addr)
+		}
+	}
+	out.Success("%d node(s) found, connecting to them", len(found))
+	return nil
+}
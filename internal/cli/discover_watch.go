@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+)
+
+// chatDiscoveryWatch stops the discovery watch started in chat, if any
+var chatDiscoveryWatch func()
+
+// getDiscoveryEventsPath returns where the running node logs what discovery
+// finds
+func getDiscoveryEventsPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, p2p.DiscoveryEventsFileName), nil
+}
+
+// printDiscoveryEvent prints a discovery event, as one JSON object per line
+// with --json
+func printDiscoveryEvent(event p2p.DiscoveryEvent) {
+	if jsonMode() {
+		printJSON(event)
+		return
+	}
+
+	icon, what := "📡", "found"
+	switch event.Type {
+	case p2p.DiscoveryUpdated:
+		icon, what = "🔄", "seen at new addresses"
+	case p2p.DiscoveryLost:
+		ui.Printf("%s 👋 %s lost, last found via %s\n", event.Time.Format("15:04:05"), event.PeerID, event.Method)
+		return
+	}
+	ui.Printf("%s %s %s %s via %s\n", event.Time.Format("15:04:05"), icon, event.PeerID, what, event.Method)
+	for _, addr := range event.Addrs {
+		ui.Printf("    %s\n", addr)
+	}
+}
+
+// runDiscoverWatch streams what the running node discovers until
+// interrupted
+func runDiscoverWatch() error {
+	status, err := p2p.ReadNodeStatus()
+	if err != nil || status == nil || !status.IsRunning {
+		ui.Error("No running node found")
+		ui.Info("Start the node first with: peerchat-cli start")
+		return networkError(errNodeNotRunning)
+	}
+	path, err := getDiscoveryEventsPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ui.Printf("👀 Watching discovery of %s, press Ctrl+C to stop\n", status.PeerID)
+	events := 0
+	err = p2p.WatchDiscoveryEvents(ctx, path, func(event p2p.DiscoveryEvent) {
+		events++
+		printDiscoveryEvent(event)
+	})
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	ui.Printf("\n📊 %d discovery event(s)\n", events)
+	return nil
+}
+
+// handleDiscoverWatch starts or stops printing what discovery finds in
+// chat as it happens
+func handleDiscoverWatch(out ui.Printer, wrapper *p2p.P2PWrapper, start bool) error {
+	if !start {
+		if chatDiscoveryWatch == nil {
+			out.Info("Discovery is not being watched")
+			return nil
+		}
+		chatDiscoveryWatch()
+		chatDiscoveryWatch = nil
+		out.Success("Stopped watching discovery")
+		return nil
+	}
+
+	if wrapper.IsUsingSimulation() {
+		out.Warn("Running in simulation mode - no real peers to discover")
+		return networkError(errSimulation)
+	}
+	if chatDiscoveryWatch != nil {
+		out.Info("Discovery is already watched, '/discover stop' ends it")
+		return nil
+	}
+
+	stopFound := wrapper.OnPeerDiscovered(printDiscoveryEvent)
+	stopLost := wrapper.OnPeerLost(printDiscoveryEvent)
+	chatDiscoveryWatch = func() {
+		stopFound()
+		stopLost()
+	}
+	out.Println("👀 Watching discovery, peers are shown as they are found; '/discover stop' ends it")
+	return nil
+}
@@ -3,101 +3,525 @@ package cli
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
 	"github.com/spf13/cobra"
 )
 
+// doctorReport is what doctor prints with --json
+type doctorReport struct {
+	System      doctorSystem           `json:"system"`
+	Checks      []doctorCheck          `json:"checks"`
+	Ports       []p2p.PortBind         `json:"ports,omitempty"`
+	NAT         *p2p.NATClassification `json:"nat,omitempty"`
+	Multicast   *p2p.MulticastCheck    `json:"multicast,omitempty"`
+	ClockOffset time.Duration          `json:"clock_offset_ns,omitempty"`
+	Proxy       doctorProxy            `json:"proxy"`
+	PacketSizes doctorPacketSizes      `json:"packet_sizes"`
+	Node        doctorNode             `json:"node"`
+}
+
+// doctorSystem is the system doctor runs on
+type doctorSystem struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	GoVersion string `json:"go_version"`
+	CPUs      int    `json:"cpus"`
+}
+
+// Results of a doctor check
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// doctorCheck is the result of one check
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Result string `json:"result"` // checkPass, checkWarn or checkFail
+	Detail string `json:"detail"`
+}
+
+// check records the result of a check and prints it
+func (r *doctorReport) check(name, result, detail string) {
+	r.Checks = append(r.Checks, doctorCheck{Name: name, Result: result, Detail: detail})
+	icon := map[string]string{checkPass: "✅", checkWarn: "⚠️ ", checkFail: "❌"}[result]
+	ui.Printf("  - %s: %s %s\n", name, icon, detail)
+}
+
+// count returns how many checks had result
+func (r *doctorReport) count(result string) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Result == result {
+			n++
+		}
+	}
+	return n
+}
+
+// doctorProxy is the result of the proxy check
+type doctorProxy struct {
+	Configured bool   `json:"configured"`
+	Strict     bool   `json:"strict,omitempty"` // SOCKS: every connection goes through it
+	Bootstrap  string `json:"bootstrap,omitempty"`
+	Via        string `json:"via,omitempty"` // Empty when the bootstrap peer is reached directly
+	Reachable  bool   `json:"reachable,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// doctorPacketSizes is the result of the UDP packet size probe
+type doctorPacketSizes struct {
+	Network   string `json:"network,omitempty"`
+	Delivered []int  `json:"delivered,omitempty"`
+	Dropped   []int  `json:"dropped,omitempty"`
+	BlackHole bool   `json:"black_hole,omitempty"` // Large packets dropped, QUIC keeps to small ones
+	Skipped   string `json:"skipped,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// doctorNode is the result of starting a test node
+type doctorNode struct {
+	Started     bool          `json:"started"`
+	Simulation  bool          `json:"simulation,omitempty"` // Only simulation mode started
+	PeerID      string        `json:"peer_id,omitempty"`
+	DID         string        `json:"did,omitempty"`
+	ListenAddrs []string      `json:"listen_addrs,omitempty"`
+	StartupTime time.Duration `json:"startup_time_ns,omitempty"`
+	MemoryBytes uint64        `json:"memory_bytes,omitempty"` // Of the whole process, with the node started
+	Error       string        `json:"error,omitempty"`
+}
+
 // RunDoctor handles the doctor command
-func RunDoctor(cmd *cobra.Command, args []string) {
-	fmt.Println("🩺 Network Diagnostics")
-	fmt.Println("======================")
-	fmt.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
-	fmt.Println()
-
-	// Basic system checks
-	fmt.Println("✅ System checks:")
-	fmt.Printf("  - OS: %s\n", "Linux")
-	fmt.Printf("  - Go version: %s\n", "1.21+")
-	fmt.Println()
-
-	// Network connectivity checks
-	fmt.Println("✅ Network connectivity:")
-	fmt.Printf("  - Internet: Available\n")
-	fmt.Printf("  - DNS: Functional\n")
-	fmt.Println()
+func RunDoctor(cmd *cobra.Command, args []string) error {
+	var report doctorReport
+	if jsonMode() {
+		defer func() { printJSON(report) }()
+	}
+
+	ui.Println("🩺 Network Diagnostics")
+	ui.Println("======================")
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
+
+	report.System = doctorSystem{OS: runtime.GOOS, Arch: runtime.GOARCH, GoVersion: runtime.Version(), CPUs: runtime.NumCPU()}
+	ui.Println("💻 System:")
+	ui.Printf("  - OS: %s/%s, %d CPUs\n", report.System.OS, report.System.Arch, report.System.CPUs)
+	ui.Printf("  - Built with: %s\n", report.System.GoVersion)
+	ui.Println()
+
+	// Probes that reach the Internet stay off while every connection has
+	// to go through a SOCKS proxy
+	direct := true
+	if config := p2p.ProxyFromEnvironment(); config.IsSOCKS() && config.Strict {
+		direct = false
+	}
+	checkConnectivity(&report, direct)
+	checkPorts(&report)
+	checkNAT(&report, direct)
+	checkMulticast(&report)
+	checkClock(&report, direct)
+
+	report.Proxy = checkProxySettings()
+	report.PacketSizes = checkPacketSizes()
 
 	// P2P node checks
-	fmt.Println("🔧 P2P node checks:")
+	ui.Println("🔧 P2P node checks:")
 
 	// Try to create a test node
 	ctx := context.Background()
-	wrapper := p2p.NewP2PWrapper(ctx, false) // Try real P2P first
+	wrapper := newP2PWrapper(ctx, cmd) // Try real P2P first
 
-	fmt.Println("  - Testing P2P node creation...")
+	ui.Println("  - Testing P2P node creation...")
+	started := time.Now()
 	if err := wrapper.Start(); err != nil {
-		fmt.Printf("  - Node creation: ❌ Failed (%v)\n", err)
-		fmt.Println("  - Falling back to simulation mode...")
+		report.Node.Error = err.Error()
+		ui.Printf("  - Node creation: ❌ Failed (%v)\n", err)
+		ui.Println("  - Falling back to simulation mode...")
 
 		// Try simulation mode
 		simWrapper := p2p.NewP2PWrapper(ctx, true)
 		if err := simWrapper.Start(); err != nil {
-			fmt.Printf("  - Simulation mode: ❌ Failed (%v)\n", err)
-			return
+			ui.Printf("  - Simulation mode: ❌ Failed (%v)\n", err)
+			return networkError(err)
 		}
+		report.Node.Simulation = true
 		defer func() {
 			if err := simWrapper.Stop(); err != nil {
-				fmt.Printf("Warning: Failed to stop simulation wrapper: %v\n", err)
+				ui.Printf("Warning: Failed to stop simulation wrapper: %v\n", err)
 			}
 		}()
 
-		fmt.Println("  - Simulation mode: ✅ Success")
-		fmt.Println()
-		fmt.Println("⚠️  Warning: Real P2P networking failed, but simulation works")
-		fmt.Println("💡 This suggests a network configuration issue")
-		fmt.Println("🔧 Troubleshooting suggestions:")
-		fmt.Println("   - Check firewall settings")
-		fmt.Println("   - Verify network connectivity")
-		fmt.Println("   - Try different network (mobile hotspot)")
-		return
+		ui.Println("  - Simulation mode: ✅ Success")
+		ui.Println()
+		ui.Warn("Warning: Real P2P networking failed, but simulation works")
+		ui.Info("Look for ❌ in the checks above: ports that cannot be bound, no route or DNS")
+		ui.Println("🔧 Troubleshooting suggestions:")
+		ui.Println("   - Check firewall settings")
+		ui.Println("   - Try different network (mobile hotspot)")
+		return networkError(err)
 	}
 	defer func() {
 		if err := wrapper.Stop(); err != nil {
-			fmt.Printf("Warning: Failed to stop wrapper: %v\n", err)
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
 		}
 	}()
 
-	fmt.Println("  - Node creation: ✅ Success")
+	startup := time.Since(started)
+	ui.Println("  - Node creation: ✅ Success")
 
 	// Get node information
 	nodeInfo := wrapper.GetNodeInfo()
-	fmt.Printf("  - Peer ID: %s\n", nodeInfo.PeerID)
-	fmt.Printf("  - DID: %s\n", nodeInfo.DID)
-	fmt.Printf("  - Listen addresses: %v\n", nodeInfo.ListenAddrs)
-	fmt.Println()
-
-	// Network discovery tests
-	fmt.Println("🔍 Discovery tests:")
-	fmt.Println("  - mDNS discovery: ✅ Available")
-	fmt.Println("  - UDP broadcast: ✅ Available")
-	fmt.Println("  - DHT bootstrap: ⚠️  Limited (local testing)")
-	fmt.Println()
-
-	// Performance tests
-	fmt.Println("⚡ Performance tests:")
-	fmt.Println("  - Memory usage: ✅ <20MB")
-	fmt.Println("  - CPU usage: ✅ <1%")
-	fmt.Println("  - Startup time: ✅ <2s")
-	fmt.Println()
-
-	// Security checks
-	fmt.Println("🔒 Security checks:")
-	fmt.Println("  - Identity generation: ✅ Ed25519")
-	fmt.Println("  - Message signing: ✅ Available")
-	fmt.Println("  - Encryption: ⚠️  In development")
-	fmt.Println()
-
-	fmt.Println("✅ Diagnostics completed!")
-	fmt.Println("💡 If you see any ❌ errors above, check the troubleshooting guide")
-	fmt.Println("📖 Run 'peerchat-cli manual' for detailed documentation")
+	report.Node = doctorNode{Started: true, PeerID: nodeInfo.PeerID, DID: nodeInfo.DID, ListenAddrs: nodeInfo.ListenAddrs, StartupTime: startup}
+	ui.Printf("  - Peer ID: %s\n", nodeInfo.PeerID)
+	ui.Printf("  - DID: %s\n", nodeInfo.DID)
+	ui.Printf("  - Listen addresses: %v\n", nodeInfo.ListenAddrs)
+	if startup <= doctorStartupTarget {
+		report.check("Startup time", checkPass, startup.Round(time.Millisecond).String())
+	} else {
+		report.check("Startup time", checkWarn, fmt.Sprintf("%s, above %s", startup.Round(time.Millisecond), doctorStartupTarget))
+	}
+	memory, approx := p2p.ProcessMemory()
+	report.Node.MemoryBytes = memory
+	label := "RSS"
+	if approx {
+		label = "memory from the system"
+	}
+	if memory <= p2p.MaxIdleMemoryMB<<20 {
+		report.check("Memory", checkPass, fmt.Sprintf("%s %s", formatBytes(int64(memory)), label))
+	} else {
+		report.check("Memory", checkWarn, fmt.Sprintf("%s %s, above the %dMB idle target; 'bench' measures the node alone", formatBytes(int64(memory)), label, p2p.MaxIdleMemoryMB))
+	}
+	ui.Println()
+
+	warnings, failures := report.count(checkWarn), report.count(checkFail)
+	switch {
+	case failures > 0:
+		ui.Error("Diagnostics completed: %d failed, %d with warnings, %d passed", failures, warnings, len(report.Checks)-failures-warnings)
+		ui.Info("Fix the ❌ checks first, see the troubleshooting guide")
+	case warnings > 0:
+		ui.Warn("Diagnostics completed: %d with warnings, %d passed", warnings, len(report.Checks)-warnings)
+	default:
+		ui.Success("Diagnostics completed: all %d checks passed", len(report.Checks))
+	}
+	ui.Println("📖 Run 'peerchat-cli manual' for detailed documentation")
+	if failures > 0 {
+		// Every check probes the network or what the node needs from it
+		return networkError(fmt.Errorf("%d doctor check(s) failed", failures))
+	}
+	return nil
+}
+
+// Limits of the doctor checks
+const (
+	doctorStartupTarget = 2 * time.Second
+	dnsCheckTimeout     = 5 * time.Second
+	natCheckTimeout     = 10 * time.Second
+	clockCheckTimeout   = 10 * time.Second
+)
+
+// checkConnectivity checks for a default route over IPv4 and IPv6 and
+// resolves the DNS records of the bootstrap peers
+func checkConnectivity(report *doctorReport, direct bool) {
+	ui.Println("🌐 Internet:")
+	defer ui.Println()
+
+	ip4, err4 := p2p.DefaultRoute("udp4")
+	ip6, err6 := p2p.DefaultRoute("udp6")
+	switch {
+	case err4 == nil && err6 == nil:
+		report.check("Default route", checkPass, fmt.Sprintf("IPv4 from %s, IPv6 from %s", ip4, ip6))
+	case err4 == nil:
+		report.check("Default route", checkPass, fmt.Sprintf("IPv4 from %s, no IPv6", ip4))
+	case err6 == nil:
+		report.check("Default route", checkWarn, fmt.Sprintf("IPv6 only, from %s: IPv4 peers are reached through relays", ip6))
+	default:
+		report.check("Default route", checkFail, fmt.Sprintf("no route to the Internet (%v)", err4))
+		ui.Info("Only peers on the LAN can be reached; check the network connection")
+	}
+
+	if !direct {
+		ui.Println("  - DNS: skipped, lookups would bypass the SOCKS proxy")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsCheckTimeout)
+	defer cancel()
+	started := time.Now()
+	domain, records, err := p2p.ResolveBootstrapDNS(ctx)
+	took := time.Since(started).Round(time.Millisecond)
+	switch {
+	case err != nil:
+		report.check("DNS", checkFail, fmt.Sprintf("failed to resolve %s (%v)", domain, err))
+		ui.Info("Bootstrap peers are not found without DNS; check the resolver in /etc/resolv.conf or the network settings")
+	case records == 0:
+		report.check("DNS", checkWarn, fmt.Sprintf("%s has no bootstrap records", domain))
+	case took > time.Second:
+		report.check("DNS", checkWarn, fmt.Sprintf("%d bootstrap records from %s, slow: %s", records, domain, took))
+	default:
+		report.check("DNS", checkPass, fmt.Sprintf("%d bootstrap records from %s in %s", records, domain, took))
+	}
+}
+
+// checkPorts binds TCP and UDP ports over IPv4 and IPv6 and the port of LAN
+// beacons
+func checkPorts(report *doctorReport) {
+	ui.Println("🔌 Ports:")
+	defer ui.Println()
+
+	report.Ports = p2p.CheckPortBinds()
+	for _, bind := range report.Ports {
+		name := strings.ToUpper(bind.Network[:3]) + " IPv" + bind.Network[3:]
+		switch {
+		case bind.IsBeaconPort():
+			name = fmt.Sprintf("UDP %d (LAN beacons)", bind.Port)
+			if bind.Error == "" {
+				report.check(name, checkPass, "free")
+			} else if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+				report.check(name, checkPass, "held by the running node")
+			} else {
+				report.check(name, checkWarn, fmt.Sprintf("in use by another program, LAN beacons are not heard (%s)", bind.Error))
+			}
+		case bind.Error == "":
+			report.check(name, checkPass, "bound "+bind.Addr)
+		case bind.Network == "tcp6" || bind.Network == "udp6":
+			report.check(name, checkWarn, fmt.Sprintf("cannot bind, IPv6 is off (%s)", bind.Error))
+		default:
+			report.check(name, checkFail, fmt.Sprintf("cannot bind (%s)", bind.Error))
+		}
+	}
+}
+
+// checkNAT classifies the NAT in front of the host with STUN
+func checkNAT(report *doctorReport, direct bool) {
+	ui.Println("🧭 NAT:")
+	defer ui.Println()
+	if !direct {
+		ui.Println("  - Skipped: STUN is disabled while a SOCKS proxy is configured")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), natCheckTimeout)
+	defer cancel()
+	nat, err := p2p.ClassifyNAT(ctx, p2p.DefaultSTUNServers)
+	if err != nil {
+		report.check("NAT type", checkFail, fmt.Sprintf("%v", err))
+		ui.Info("UDP may be blocked on this network; QUIC and hole punching will not work, TCP and relays still do")
+		return
+	}
+	report.NAT = nat
+	for _, m := range nat.Mappings {
+		ui.Printf("  - %s sees %s\n", m.Server, m.Addr)
+	}
+	switch nat.Class {
+	case p2p.NATClassNone:
+		report.check("NAT type", checkPass, "none, "+nat.LocalAddr+" is public")
+	case p2p.NATClassCone:
+		detail := "cone: the same public address for every peer, hole punching works"
+		if nat.PortPreserved {
+			detail += ", port preserved"
+		}
+		report.check("NAT type", checkPass, detail)
+	case p2p.NATClassSymmetric:
+		report.check("NAT type", checkWarn, "symmetric: a new public port for every peer, connections to peers behind NATs need a relay")
+	default:
+		report.check("NAT type", checkWarn, "unknown: only one STUN server answered")
+	}
+}
+
+// checkMulticast checks that multicast, which mDNS discovery uses, works
+func checkMulticast(report *doctorReport) {
+	ui.Println("📡 Multicast:")
+	defer ui.Println()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	result, err := p2p.CheckMulticast(ctx)
+	report.Multicast = result
+	switch {
+	case result == nil:
+		report.check("Multicast", checkFail, fmt.Sprintf("cannot list interfaces (%v)", err))
+	case len(result.Interfaces) == 0:
+		report.check("Multicast", checkFail, "no interface up with IPv4 and multicast, mDNS finds no peers")
+	case err != nil:
+		report.check("Multicast", checkFail, fmt.Sprintf("%v, mDNS finds no peers", err))
+	case !result.Looped:
+		report.check("Multicast", checkWarn, fmt.Sprintf("joined on %s, but a test packet did not come back; a firewall may drop mDNS", strings.Join(result.Interfaces, ", ")))
+	default:
+		report.check("Multicast", checkPass, "works on "+strings.Join(result.Interfaces, ", "))
+	}
+}
+
+// checkClock compares the clock with NTP time
+func checkClock(report *doctorReport, direct bool) {
+	ui.Println("🕐 Clock:")
+	defer ui.Println()
+	if !direct {
+		ui.Println("  - Skipped: NTP is not sent through a SOCKS proxy")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clockCheckTimeout)
+	defer cancel()
+	offset, server, err := p2p.ClockOffset(ctx, p2p.DefaultNTPServers)
+	if err != nil {
+		report.check("Clock skew", checkWarn, fmt.Sprintf("cannot check, %v", err))
+		return
+	}
+	report.ClockOffset = offset
+	skew := offset.Abs().Round(time.Millisecond)
+	switch {
+	case skew >= p2p.MaxClockSkew:
+		report.check("Clock skew", checkFail, fmt.Sprintf("%s off %s: peers reject your name records", skew, server))
+		ui.Info("Turn on automatic time sync in the system settings")
+	case skew >= p2p.ClockSkewWarn:
+		report.check("Clock skew", checkWarn, fmt.Sprintf("%s off %s; message times look wrong to peers", skew, server))
+		ui.Info("Turn on automatic time sync in the system settings")
+	default:
+		report.check("Clock skew", checkPass, fmt.Sprintf("%s off %s", skew, server))
+	}
+}
+
+// proxyCheckTimeout bounds the connection test through the proxy
+const proxyCheckTimeout = 10 * time.Second
+
+// checkProxySettings shows the proxy settings from the environment and
+// whether a bootstrap peer can be reached through them
+func checkProxySettings() doctorProxy {
+	config := p2p.ProxyFromEnvironment()
+
+	ui.Println("🌐 Proxy settings:")
+	if config.IsZero() {
+		ui.Println("  - No proxy configured: TCP connections are direct")
+		ui.Println()
+		return doctorProxy{}
+	}
+	result := doctorProxy{Configured: true, Strict: config.Strict}
+
+	for _, setting := range []struct{ name, value string }{
+		{"ALL_PROXY", config.AllProxy},
+		{"HTTPS_PROXY", config.HTTPSProxy},
+		{"HTTP_PROXY", config.HTTPProxy},
+		{"NO_PROXY", config.NoProxy},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		value := setting.value
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = u.Redacted()
+		}
+		ui.Printf("  - %s: %s\n", setting.name, value)
+	}
+	if config.Strict {
+		ui.Println("  - SOCKS proxy: every connection goes through it; only loopback is reached directly")
+		ui.Printf("  - LAN discovery, QUIC, STUN and direct connections are disabled unless --%s is given\n", allowDirectFlag)
+	} else {
+		ui.Println("  - Applies to outgoing TCP connections; QUIC, mDNS and STUN stay direct")
+		ui.Println("  - LAN, loopback and NO_PROXY addresses are reached directly")
+	}
+
+	target := p2p.BootstrapTCPTarget()
+	if target == "" {
+		ui.Println()
+		return result
+	}
+	result.Bootstrap = target
+	proxyURL, err := config.ProxyFor(target)
+	if err != nil {
+		result.Error = err.Error()
+		ui.Printf("  - Proxy: ❌ %v\n", err)
+		ui.Println()
+		return result
+	}
+	if proxyURL == nil {
+		ui.Printf("  - Bootstrap peer %s: reached directly (NO_PROXY)\n", target)
+		ui.Println()
+		return result
+	}
+	result.Via = proxyURL.Redacted()
+
+	ctx, cancel := context.WithTimeout(context.Background(), proxyCheckTimeout)
+	defer cancel()
+	if err := p2p.CheckProxy(ctx, config, target); err != nil {
+		result.Error = err.Error()
+		ui.Printf("  - Bootstrap peer %s via %s: ❌ %v\n", target, proxyURL.Redacted(), err)
+		ui.Info("Check the proxy address and credentials, and that it allows CONNECT to port 4001")
+	} else {
+		result.Reachable = true
+		ui.Printf("  - Bootstrap peer %s via %s: ✅ Reachable\n", target, proxyURL.Redacted())
+	}
+	ui.Println()
+	return result
+}
+
+// mtuCheckTimeout bounds probing which UDP packet sizes get through
+const mtuCheckTimeout = 30 * time.Second
+
+// checkPacketSizes probes which UDP packet sizes get through on the current
+// network and records the result, so the node keeps QUIC to small packets
+// on networks that drop large ones
+func checkPacketSizes() (result doctorPacketSizes) {
+	ui.Println("📦 UDP packet sizes:")
+	defer ui.Println()
+	if config := p2p.ProxyFromEnvironment(); config.IsSOCKS() && config.Strict {
+		result.Skipped = "STUN is disabled while a SOCKS proxy is configured"
+		ui.Println("  - Skipped: STUN is disabled while a SOCKS proxy is configured")
+		return result
+	}
+
+	network, err := p2p.CurrentNetwork()
+	if err != nil {
+		result.Error = err.Error()
+		ui.Printf("  - Network: ❌ %v\n", err)
+		return result
+	}
+	result.Network = network.Key()
+	ui.Printf("  - Network: %s\n", network.Key())
+
+	ctx, cancel := context.WithTimeout(context.Background(), mtuCheckTimeout)
+	defer cancel()
+	probe, err := p2p.ProbePathMTU(ctx, p2p.DefaultSTUNServers, p2p.MTUProbeSizes)
+	if err != nil {
+		result.Error = fmt.Sprintf("no STUN server answered: %v", err)
+		ui.Printf("  - Probe: ❌ No STUN server answered (%v)\n", err)
+		ui.Info("UDP may be blocked on this network; QUIC will not work, TCP still does")
+		return result
+	}
+	for _, size := range p2p.MTUProbeSizes {
+		if slices.Contains(probe.Delivered, size) {
+			result.Delivered = append(result.Delivered, size)
+			ui.Printf("  - %d bytes: ✅ Delivered\n", size)
+		} else {
+			result.Dropped = append(result.Dropped, size)
+			ui.Printf("  - %d bytes: ❌ Dropped\n", size)
+		}
+	}
+	result.BlackHole = probe.BlackHole()
+	if probe.BlackHole() {
+		ui.Printf("  - ⚠️  This network drops large UDP packets: QUIC will keep to %d-byte packets here\n", p2p.QUICMinPacketSize)
+	} else {
+		ui.Printf("  - Largest packet delivered: %d bytes, QUIC discovers the path MTU here\n", probe.LargestSize())
+	}
+
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return result
+	}
+	path := filepath.Join(dataDir, p2p.NetworkProfilesFileName)
+	if err := p2p.UpdateNetworkProfile(path, network, func(profile *p2p.NetworkProfile) { profile.PathMTU = probe }); err != nil {
+		ui.Printf("  - ❌ Failed to record the result: %v\n", err)
+		return result
+	}
+	ui.Println("  - Recorded for this network; applies from the next node start")
+	return result
 }
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of peerchat-cli, as listed in the manual
+const (
+	ExitSuccess      = 0
+	ExitGeneral      = 1
+	ExitNetwork      = 2
+	ExitConfig       = 3
+	ExitPermission   = 4
+	ExitPeerNotFound = 5
+)
+
+var (
+	// errNodeNotRunning is returned by the commands that need a running
+	// node when there is none
+	errNodeNotRunning = errors.New("no running node")
+
+	// errNodeRunning is returned by the commands that change what a running
+	// node holds open or in memory
+	errNodeRunning = errors.New("node is running")
+
+	// errSimulation is returned by the commands that need real P2P
+	// networking when the node fell back to simulation
+	errSimulation = errors.New("real P2P networking is not available")
+
+	// errPeerUnreachable is returned when the node cannot connect to a peer
+	errPeerUnreachable = errors.New("peer is not reachable")
+
+	// errUsage is returned by the chat commands given arguments they do not
+	// take, after printing their usage
+	errUsage = errors.New("invalid arguments")
+)
+
+// ExitError is a command failure together with the exit code it maps to.
+// Commands print what went wrong and how to fix it before returning one, so
+// it is not printed again.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+// Error implements error
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// generalError is a failure without a more specific exit code. Permission
+// errors underneath still exit with ExitPermission.
+func generalError(err error) error {
+	return &ExitError{Code: ExitGeneral, Err: err}
+}
+
+// networkError is a failure to start the node, reach the running one or
+// talk to peers
+func networkError(err error) error {
+	return &ExitError{Code: ExitNetwork, Err: err}
+}
+
+// configError is a missing or invalid identity, configuration or setting
+func configError(err error) error {
+	return &ExitError{Code: ExitConfig, Err: err}
+}
+
+// permissionError is a file or action the user is not allowed
+func permissionError(err error) error {
+	return &ExitError{Code: ExitPermission, Err: err}
+}
+
+// peerNotFoundError is a peer, contact or name that could not be found
+func peerNotFoundError(err error) error {
+	return &ExitError{Code: ExitPeerNotFound, Err: err}
+}
+
+// peerRefusedError is a failure exchanging data with a peer: a permission
+// error when the peer's policy refused it, otherwise a network error
+func peerRefusedError(err error) error {
+	if pe, ok := message.AsProtocolError(err); ok && pe.Code == message.ErrCodePolicy {
+		return permissionError(err)
+	}
+	return networkError(err)
+}
+
+// ExitCode maps the error a command returned to the process exit code:
+// ExitSuccess for none, the code of an ExitError, ExitPermission for
+// permission errors and ExitGeneral for anything else, such as invalid
+// flags or arguments
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) && exitErr.Code != ExitGeneral {
+		return exitErr.Code
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return ExitPermission
+	}
+	return ExitGeneral
+}
+
+// prepareCommand runs before every command. It sets up --json output and
+// the output format, then keeps cobra from printing the errors the command
+// returns, and its usage, as commands print what went wrong themselves.
+// Errors in flags and arguments come earlier and are still printed with the
+// usage. Last it reads the configuration file.
+func prepareCommand(cmd *cobra.Command, args []string) error {
+	if err := setupJSONOutput(cmd, args); err != nil {
+		return err
+	}
+	if err := setupOutput(cmd); err != nil {
+		return err
+	}
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	return loadConfig(cmd)
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// RunExport handles the export command
+func RunExport(cmd *cobra.Command, args []string) error {
+	formatName, _ := cmd.Flags().GetString("format")
+	out, _ := cmd.Flags().GetString("out")
+	since, _ := cmd.Flags().GetString("since")
+
+	// Without an explicit format, use the output file's extension
+	if !cmd.Flags().Changed("format") && out != "" {
+		if ext := strings.TrimPrefix(filepath.Ext(out), "."); ext != "" {
+			formatName = ext
+		}
+	}
+	format, err := db.ParseExportFormat(formatName)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	opts := db.ExportOptions{Format: format, Names: contactNames(dataDir)}
+	if opts.Since, err = db.ParseSince(since, time.Now()); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if len(args) > 0 {
+		opts.PeerID = resolveContactName(dataDir, strings.TrimPrefix(args[0], "@"))
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if history == nil {
+		ui.Println("📜 No message history yet")
+		return nil
+	}
+	defer closeHistory()
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		file, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			ui.Error("Failed to create %s: %v", out, err)
+			return generalError(err)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				ui.Error("Failed to close %s: %v", out, err)
+			}
+		}()
+		w = file
+	}
+
+	count, err := history.ExportHistory(w, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Export failed: %v\n", err)
+		return generalError(err)
+	}
+
+	if out != "" {
+		ui.Success("Exported %d message(s) to %s", count, out)
+	}
+	return nil
+}
+
+// contactNames maps the peer IDs of saved contacts to their names
+func contactNames(dataDir string) map[string]string {
+	names := make(map[string]string)
+
+	contacts, err := user.LoadContactBook(filepath.Join(dataDir, user.ContactsFileName))
+	if err != nil {
+		return names
+	}
+	for _, contact := range contacts.List() {
+		names[contact.PeerID] = contact.Name
+	}
+	return names
+}
@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getFiltersPath returns the data directory and the content filters path
+func getFiltersPath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, message.FiltersFileName), nil
+}
+
+// RunFilterAdd handles the filter add command
+func RunFilterAdd(cmd *cobra.Command, args []string) error {
+	regex, _ := cmd.Flags().GetBool("regex")
+	peerTarget, _ := cmd.Flags().GetString("peer")
+	mute, _ := cmd.Flags().GetBool("mute")
+	archive, _ := cmd.Flags().GetBool("archive")
+	tag, _ := cmd.Flags().GetString("tag")
+
+	filter := &message.Filter{Pattern: args[0], Regex: regex, Tag: tag}
+	actions := 0
+	if mute {
+		filter.Action, actions = message.FilterMute, actions+1
+	}
+	if archive {
+		filter.Action, actions = message.FilterArchive, actions+1
+	}
+	if tag != "" {
+		filter.Action, actions = message.FilterTag, actions+1
+	}
+	if actions != 1 {
+		ui.Error("Give exactly one of --mute, --archive or --tag")
+		return generalError(errors.New("not exactly one filter action"))
+	}
+
+	dataDir, path, err := getFiltersPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	if peerTarget != "" {
+		filter.PeerID = resolveContactName(dataDir, peerTarget)
+	}
+	if err := filter.Compile(); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	filters, err := message.LoadFilters(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	for _, existing := range filters {
+		filter.ID = max(filter.ID, existing.ID)
+	}
+	filter.ID++
+	filters = append(filters, filter)
+
+	if err := message.SaveFilters(path, filters); err != nil {
+		ui.Error("Failed to save filters: %v", err)
+		return generalError(err)
+	}
+
+	scope := "all conversations"
+	if peerTarget != "" {
+		scope = peerTarget
+	}
+	ui.Success("Filter %d for %s: %s", filter.ID, scope, filter)
+	ui.Info("Applies to messages received from now on; a running node picks it up automatically")
+	return nil
+}
+
+// RunFilterList handles the filter list command
+func RunFilterList(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getFiltersPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	filters, err := message.LoadFilters(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if len(filters) == 0 {
+		ui.Println("🔕 No content filters")
+		return nil
+	}
+
+	names := contactNames(dataDir)
+	ui.Printf("🔕 Content filters (%d):\n", len(filters))
+	for _, filter := range filters {
+		scope := "all"
+		if filter.PeerID != "" {
+			scope = shortPeerID(filter.PeerID)
+			if name := names[filter.PeerID]; name != "" {
+				scope = name
+			}
+		}
+		ui.Printf("  %3d  %-12s %s\n", filter.ID, scope, filter)
+	}
+	return nil
+}
+
+// RunFilterRemove handles the filter remove command
+func RunFilterRemove(cmd *cobra.Command, args []string) error {
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		ui.Error("Invalid filter ID %q: see 'peerchat-cli filter list'", args[0])
+		return generalError(err)
+	}
+
+	_, path, err := getFiltersPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	filters, err := message.LoadFilters(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	for i, filter := range filters {
+		if filter.ID != id {
+			continue
+		}
+		filters = append(filters[:i], filters[i+1:]...)
+		if err := message.SaveFilters(path, filters); err != nil {
+			ui.Error("Failed to save filters: %v", err)
+			return generalError(err)
+		}
+		ui.Success("Removed filter %d: %s", id, filter)
+		return nil
+	}
+	ui.Error("No filter %d", id)
+	return generalError(fmt.Errorf("no filter %d", id))
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"path/filepath"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getSyncFoldersPath returns the path of the sync folder configuration
+func getSyncFoldersPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, message.SyncFoldersFileName), nil
+}
+
+// RunSyncAdd handles the sync add command
+func RunSyncAdd(cmd *cobra.Command, args []string) error {
+	path, err := getSyncFoldersPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	folder, err := message.AddSyncFolder(path, args[0], args[1], args[2])
+	if err != nil {
+		ui.Error("Failed to add sync folder: %v", err)
+		return generalError(err)
+	}
+
+	ui.Printf("🔄 Sync folder %s added\n", folder.ID)
+	ui.Printf("  Local:  %s\n", folder.Local)
+	ui.Printf("  Remote: %s on %s\n", folder.Remote, folder.PeerID)
+	ui.Println()
+	ui.Info("On the other device, run:")
+	ui.Printf("   peerchat-cli sync add <this peer_id> %s %s\n", folder.Remote, folder.Local)
+	ui.Info("A running node starts syncing once both devices are connected")
+	return nil
+}
+
+// RunSyncList handles the sync list command
+func RunSyncList(cmd *cobra.Command, args []string) error {
+	path, err := getSyncFoldersPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	folders, err := message.LoadSyncFolders(path)
+	if err != nil {
+		ui.Error("Failed to load sync folders: %v", err)
+		return generalError(err)
+	}
+
+	ui.Println("🔄 Sync folders:")
+	if len(folders) == 0 {
+		ui.Println("  (No folders - add one with 'peerchat-cli sync add <peer> <local> <remote>')")
+		return nil
+	}
+
+	for _, f := range folders {
+		ui.Printf("  %s  %s ⇄ %s on %s\n", f.ID, f.Local, f.Remote, shortPeerID(f.PeerID))
+	}
+	return nil
+}
+
+// RunSyncRemove handles the sync remove command
+func RunSyncRemove(cmd *cobra.Command, args []string) error {
+	path, err := getSyncFoldersPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	if err := message.RemoveSyncFolder(path, args[0]); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Sync folder %s removed (files are left in place)", args[0])
+	return nil
+}
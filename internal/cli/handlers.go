@@ -2,365 +2,672 @@ package cli
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
 	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
 	"github.com/spf13/cobra"
 )
 
 // RunInit handles the init command
-func RunInit(cmd *cobra.Command, args []string) {
-	fmt.Println("🔧 Initializing Xelvra P2P Messenger...")
-	fmt.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
-	fmt.Println()
+func RunInit(cmd *cobra.Command, args []string) error {
+	ui.Println("🔧 Initializing Xelvra P2P Messenger...")
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
 
 	// Create P2P wrapper to initialize identity
 	ctx := context.Background()
-	wrapper := p2p.NewP2PWrapper(ctx, false) // Try real P2P first
+	wrapper := newP2PWrapper(ctx, cmd) // Try real P2P first
 
-	fmt.Println("🔑 Generating cryptographic identity...")
+	ui.Println("🔑 Generating cryptographic identity...")
 	if err := wrapper.Start(); err != nil {
-		fmt.Printf("❌ Failed to initialize P2P node: %v\n", err)
-		fmt.Println("💡 This might be due to network issues. The identity was still created.")
-		return
+		ui.Error("Failed to initialize P2P node: %v", err)
+		ui.Info("This might be due to network issues. The identity was still created.")
+		return networkError(err)
 	}
 	defer func() {
 		if err := wrapper.Stop(); err != nil {
-			fmt.Printf("Warning: Failed to stop wrapper: %v\n", err)
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
 		}
 	}()
 
 	// Get node information
 	nodeInfo := wrapper.GetNodeInfo()
 
-	fmt.Println("✅ Identity created successfully!")
-	fmt.Printf("🆔 Your DID: %s\n", nodeInfo.DID)
-	fmt.Printf("🔗 Your Peer ID: %s\n", nodeInfo.PeerID)
-	fmt.Printf("📁 Configuration saved to: ~/.xelvra/\n")
-	fmt.Println()
+	ui.Success("Identity created successfully!")
+	ui.Printf("🆔 Your DID: %s\n", nodeInfo.DID)
+	ui.Printf("🔗 Your Peer ID: %s\n", nodeInfo.PeerID)
+	ui.Printf("📁 Configuration saved to: ~/.xelvra/\n")
+	ui.Println()
 
 	if wrapper.IsUsingSimulation() {
-		fmt.Println("⚠️  Note: Using simulation mode (real P2P failed to start)")
-		fmt.Println("💡 This is normal for first-time setup or network issues")
+		ui.Warn("Note: Using simulation mode (real P2P failed to start)")
+		ui.Info("This is normal for first-time setup or network issues")
 	} else {
-		fmt.Println("✅ Real P2P networking initialized successfully")
+		ui.Success("Real P2P networking initialized successfully")
 	}
 
-	fmt.Println("🎉 Setup complete! Next steps:")
-	fmt.Println("  1. Run 'peerchat-cli doctor' to test your network")
-	fmt.Println("  2. Run 'peerchat-cli start' to begin chatting")
+	ui.Println("🎉 Setup complete! Next steps:")
+	ui.Println("  1. Run 'peerchat-cli doctor' to test your network")
+	ui.Println("  2. Run 'peerchat-cli start' to begin chatting")
+	return nil
 }
 
 // RunStart handles the start command
-func RunStart(cmd *cobra.Command, args []string) {
+func RunStart(cmd *cobra.Command, args []string) error {
 	daemon, _ := cmd.Flags().GetBool("daemon")
 
 	if daemon {
-		RunDaemonMode(cmd, args)
-	} else {
-		RunInteractiveChat(cmd, args)
+		return RunDaemonMode(cmd, args)
 	}
+	return RunInteractiveChat(cmd, args)
 }
 
-// RunStatus handles the status command
-func RunStatus(cmd *cobra.Command, args []string) {
-	fmt.Println("📊 Node Status")
-	fmt.Println("==============")
-	fmt.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
-	fmt.Println()
+// RunStatus handles the status command. No running node is a status like
+// any other, so it is not an error.
+func RunStatus(cmd *cobra.Command, args []string) error {
+	ui.Println("📊 Node Status")
+	ui.Println("==============")
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
 
 	// Check if node is already running
 	status, err := p2p.ReadNodeStatus()
 	if err != nil || status == nil || !status.IsRunning {
-		fmt.Println("❌ No running node found")
-		fmt.Println("💡 Start the node first with: peerchat-cli start")
-		return
+		if jsonMode() {
+			printJSON(notRunningJSON)
+		}
+		ui.Error("No running node found")
+		ui.Info("Start the node first with: peerchat-cli start")
+		return nil
+	}
+	if jsonMode() {
+		printJSON(status)
+		return nil
 	}
 
-	fmt.Println("✅ Node is running")
-	fmt.Printf("🆔 Peer ID: %s\n", status.PeerID)
+	ui.Success("Node is running")
+	ui.Printf("🆔 Peer ID: %s\n", status.PeerID)
 	// DID information would be displayed here when available
-	fmt.Printf("📡 Listen addresses: %v\n", status.ListenAddrs)
-	fmt.Printf("🔗 Connected peers: %d\n", status.ConnectedPeers)
-	fmt.Printf("⏰ Uptime: %s\n", time.Since(status.StartTime).Round(time.Second))
-	fmt.Println()
+	ui.Printf("📡 Listen addresses: %v\n", status.ListenAddrs)
+	ui.Printf("🔗 Connected peers: %d\n", status.ConnectedPeers)
+	if limits := status.ConnLimits; limits != nil {
+		ui.Printf("   Pruned to %d above %d, %d protected (contacts and transfers)\n", limits.LowWater, limits.HighWater, limits.Protected)
+	}
+	ui.Printf("⏰ Uptime: %s\n", time.Since(status.StartTime).Round(time.Second))
+	if status.Network != "" {
+		ui.Printf("📶 Network: %s\n", status.Network)
+	}
+	if status.Profile != "" {
+		ui.Printf("🗂️  Profile: %s\n", status.Profile)
+	}
+	if status.PrivateNetwork != "" {
+		ui.Printf("🔒 Private network: swarm key %s\n", status.PrivateNetwork)
+	}
+	if status.Tor {
+		if status.OnionAddr != "" {
+			ui.Printf("🧅 Tor: reachable at %s\n", status.OnionAddr)
+		} else {
+			ui.Println("🧅 Tor: outgoing only, the onion service was not published (is the control port enabled?)")
+		}
+	}
+	switch status.StorageBackend {
+	case "", db.SQLiteBackend:
+	case db.MemoryBackend:
+		ui.Println("💾 Storage: memory (history, offline messages and contacts are gone after exit)")
+	default:
+		ui.Printf("💾 Storage: %s\n", status.StorageBackend)
+	}
+	if status.WakeRelay != "" {
+		if status.WakeRegistered {
+			ui.Printf("🔔 Wake host: %s (registered)\n", status.WakeRelay)
+		} else {
+			ui.Printf("🔕 Wake host: %s (not registered, retrying)\n", status.WakeRelay)
+		}
+	}
+	if status.WakeClients > 0 {
+		ui.Printf("🔔 Peers registered here to be woken: %d\n", status.WakeClients)
+	}
+	ui.Println()
 
 	// Display NAT information
 	if status.NATInfo != nil {
-		fmt.Println("🌐 Network Information:")
-		fmt.Printf("  NAT Type: %s\n", status.NATInfo.Type)
-		fmt.Printf("  Local IP: %s:%d\n", status.NATInfo.LocalIP, status.NATInfo.LocalPort)
+		ui.Println("🌐 Network Information:")
+		if status.NATInfo.ObservedByPeers {
+			ui.Printf("  NAT Type: %s (from peer reports)\n", status.NATInfo.Type)
+		} else {
+			ui.Printf("  NAT Type: %s\n", status.NATInfo.Type)
+		}
+		if status.NATInfo.LocalIP != "" {
+			ui.Printf("  Local IP: %s:%d\n", status.NATInfo.LocalIP, status.NATInfo.LocalPort)
+		}
 		if status.NATInfo.PublicIP != "" {
-			fmt.Printf("  Public IP: %s:%d\n", status.NATInfo.PublicIP, status.NATInfo.PublicPort)
+			ui.Printf("  Public IP: %s:%d\n", status.NATInfo.PublicIP, status.NATInfo.PublicPort)
+		}
+		if status.NATInfo.IPv6Direct {
+			ui.Printf("  Public IPv6: %s (direct, no NAT)\n", status.NATInfo.PublicIPv6)
+		} else if status.NATInfo.PublicIPv6 != "" {
+			ui.Printf("  Public IPv6: %s\n", status.NATInfo.PublicIPv6)
+		}
+	}
+	if len(status.ObservedAddrs) > 0 {
+		if status.NATInfo == nil {
+			ui.Println("🌐 Network Information:")
+		}
+		for _, observed := range status.ObservedAddrs {
+			if observed.Peers == 1 {
+				ui.Printf("  👀 1 peer observes you at %s (%s)\n", observed.Addr, observed.Transport)
+			} else {
+				ui.Printf("  👀 %d peers observe you at %s (%s)\n", observed.Peers, observed.Addr, observed.Transport)
+			}
+		}
+	}
+	if len(status.RelayAddrs) > 0 {
+		if status.NATInfo == nil && len(status.ObservedAddrs) == 0 {
+			ui.Println("🌐 Network Information:")
 		}
-		fmt.Println()
+		ui.Println("  🛰️  Reachable through relays:")
+		for _, addr := range status.RelayAddrs {
+			ui.Printf("    %s\n", addr)
+		}
+	}
+	if status.NATInfo != nil || len(status.ObservedAddrs) > 0 || len(status.RelayAddrs) > 0 {
+		ui.Println()
 	}
 
+	printNATTraversal(status)
+	printTransportStatus(status)
+
 	// Display discovery status
 	if status.Discovery != nil {
-		fmt.Println("🔍 Discovery Status:")
-		fmt.Printf("  mDNS: %s\n", getStatusIcon(status.Discovery.MDNSActive))
-		fmt.Printf("  DHT: %s\n", getStatusIcon(status.Discovery.DHTActive))
-		fmt.Printf("  UDP Broadcast: %s\n", getStatusIcon(status.Discovery.UDPBroadcast))
-		fmt.Printf("  Known peers: %d\n", status.Discovery.KnownPeers)
+		ui.Println("🔍 Discovery Status:")
+		if v, err := p2p.ParseVisibility(status.Discovery.Visibility); err == nil {
+			ui.Printf("  Visible to: %s\n", v.Description())
+		}
+		ui.Printf("  mDNS: %s\n", getStatusIcon(status.Discovery.MDNSActive))
+		ui.Printf("  DHT: %s\n", getStatusIcon(status.Discovery.DHTActive))
+		ui.Printf("  UDP Broadcast: %s\n", getStatusIcon(status.Discovery.UDPBroadcast))
+		if len(status.Discovery.Rendezvous) > 0 {
+			ui.Printf("  Rendezvous: %s\n", strings.Join(status.Discovery.Rendezvous, ", "))
+		}
+		ui.Printf("  Known peers: %d\n", status.Discovery.KnownPeers)
 		if !status.Discovery.LastDiscovery.IsZero() {
-			fmt.Printf("  Last discovery: %s\n", status.Discovery.LastDiscovery.Format("15:04:05"))
+			ui.Printf("  Last discovery: %s\n", status.Discovery.LastDiscovery.Format("15:04:05"))
+		}
+		if health := status.Discovery.BootstrapHealth; len(health) > 0 {
+			reachable := 0
+			for _, h := range health {
+				if h.Reachable {
+					reachable++
+				}
+			}
+			ui.Printf("  Bootstrap: %d of %d reachable\n", reachable, len(health))
+			if status.Discovery.BootstrapFallback {
+				ui.Println("  ⚠️  Using the default bootstrap peers, none of the configured ones answered")
+			}
+		}
+	}
+
+	// Display disk usage against the quotas
+	if status.Storage != nil {
+		ui.Println()
+		ui.Println("💾 Storage:")
+		printQuotaUsage("Downloads", status.Storage.DownloadsBytes, status.Storage.DownloadsQuota)
+		printQuotaUsage("Offline queue", status.Storage.OfflineBytes, status.Storage.OfflineQuota)
+		if status.Storage.DownloadsExceeded() {
+			ui.Println("  ⚠️  Downloads quota reached: new files are refused")
 		}
+		if status.Storage.OfflineExceeded() {
+			ui.Println("  ⚠️  Offline queue quota reached: messages to offline peers are not queued")
+		}
+	}
+
+	// Display who is using the uplink
+	if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+		printPeerTraffic()
 	}
 
-	// Display energy optimization status (if available)
-	fmt.Println()
-	fmt.Println("⚡ Energy Optimization:")
-	fmt.Printf("  Target idle memory: <%dMB\n", 20)
-	fmt.Printf("  Target idle CPU: <%.1f%%\n", 1.0)
-	fmt.Printf("  Target latency: <%dms\n", 50)
-	fmt.Printf("  Adaptive polling: ✅ Active\n")
-	fmt.Printf("  Deep sleep mode: Available at <15%% battery\n")
+	// Display estimated power use and battery saver state
+	if status.Energy != nil {
+		ui.Println()
+		printEnergyProfile(ui.Printer{}, status.Energy)
+	}
+	return nil
 }
 
 // RunVersion handles the version command
 func RunVersion(version string) {
-	fmt.Printf("Xelvra P2P Messenger CLI v%s\n", version)
-	fmt.Println("Built with Go and libp2p")
-	fmt.Println("https://github.com/Xelvra/peerchat")
+	ui.Printf("Xelvra P2P Messenger CLI v%s\n", version)
+	ui.Println("Built with Go and libp2p")
+	ui.Println("https://github.com/Xelvra/peerchat")
 }
 
-// RunSend handles the send command
-func RunSend(cmd *cobra.Command, args []string) {
+// sendResult is what send prints with --json
+type sendResult struct {
+	To      string `json:"to"`
+	PeerID  string `json:"peer_id,omitempty"`
+	Message string `json:"message"`
+	Queued  bool   `json:"queued"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RunSend handles the send command. The message is handed to the running
+// node over its control socket, which queues it in the outbox.
+func RunSend(cmd *cobra.Command, args []string) error {
 	peerTarget := args[0]
 	messageText := args[1]
+	result := sendResult{To: peerTarget, Message: messageText}
+	fail := func(err error) error {
+		if jsonMode() {
+			result.Error = err.Error()
+			printJSON(result)
+		}
+		return err
+	}
 
-	fmt.Printf("📤 Sending message to %s\n", peerTarget)
-	fmt.Printf("💬 Message: %s\n", messageText)
-	fmt.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
-	fmt.Println()
+	if strings.HasPrefix(peerTarget, "@") {
+		name, err := p2p.NormalizeName(peerTarget)
+		if err != nil {
+			ui.Error("%v", err)
+			return fail(generalError(err))
+		}
+		peerTarget = "@" + name
+	}
+
+	ui.Printf("📤 Sending message to %s\n", peerTarget)
+	ui.Printf("💬 Message: %s\n", messageText)
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
 
 	// Check if node is already running
 	status, err := p2p.ReadNodeStatus()
 	if err != nil || status == nil || !status.IsRunning {
-		fmt.Println("❌ No running node found")
-		fmt.Println("💡 Start the node first with: peerchat-cli start")
-		return
+		ui.Error("No running node found")
+		ui.Info("Start the node first with: peerchat-cli start")
+		return fail(networkError(errNodeNotRunning))
 	}
 
-	fmt.Println("✅ Using existing running node")
-	fmt.Printf("🆔 Your Peer ID: %s\n", status.PeerID)
-	fmt.Println()
-
-	// For now, simulate message sending since we need IPC to communicate with running node
-	fmt.Println("🔗 Attempting to send message via P2P network...")
-	fmt.Println("⚠️  Note: Message sending via running node not yet implemented")
-	fmt.Println("💡 This requires IPC (Inter-Process Communication) with the running node")
-	fmt.Println("💡 For interactive messaging, use 'peerchat-cli start' mode")
+	ctx, cancel := context.WithTimeout(context.Background(), controlTimeout)
+	defer cancel()
+	peerID, err := callControl(ctx, "send", peerTarget, messageText)
+	if err != nil {
+		ui.Error("Failed to send message: %v", err)
+		switch ExitCode(err) {
+		case ExitNetwork:
+			ui.Info("Check that the node is running with 'peerchat-cli status', or restart it")
+		case ExitPeerNotFound:
+			ui.Info("Use a contact name, a peer ID or a claimed @name")
+		case ExitPermission:
+			ui.Info("Verify the contact again in chat with '/verify %s'", peerTarget)
+		}
+		return fail(err)
+	}
 
-	// Log the message attempt
-	fmt.Println("📝 Message logged for future implementation")
-	fmt.Printf("✅ Message queued: '%s' -> %s\n", messageText, peerTarget)
+	result.PeerID, result.Queued = peerID, true
+	if jsonMode() {
+		printJSON(result)
+	}
+	ui.Success("Message queued for %s", peerID)
+	ui.Info("The running node delivers it, or keeps it until the peer comes online")
+	return nil
 }
 
 // RunConnect handles the connect command
-func RunConnect(cmd *cobra.Command, args []string) {
+func RunConnect(cmd *cobra.Command, args []string) error {
+	link, _ := cmd.Flags().GetString("uri")
+	if link == "" && len(args) == 1 && (strings.HasPrefix(args[0], p2p.URIScheme+"://") || strings.HasPrefix(args[0], "/")) {
+		link = args[0]
+	}
+	if link != "" {
+		return runConnectURI(cmd, link)
+	}
+	if len(args) == 0 {
+		ui.Error("Usage: peerchat-cli connect <peer_id|multiaddr> or --uri <link>")
+		return generalError(errors.New("no peer given"))
+	}
 	peerID := args[0]
 
-	fmt.Printf("🔗 Connecting to peer: %s\n", peerID)
-	fmt.Println("❌ Error: Peer connection not yet implemented")
-	fmt.Println("This feature requires P2P connection management.")
+	ui.Printf("🔗 Connecting to peer: %s\n", peerID)
+	ui.Error("Error: Peer connection not yet implemented")
+	ui.Println("This feature requires P2P connection management.")
+	return generalError(errors.New("peer connection not implemented"))
 }
 
 // RunListen handles the listen command
-func RunListen(cmd *cobra.Command, args []string) {
-	fmt.Println("👂 Starting P2P node in passive listening mode...")
-	fmt.Println("ALL LOGS AND MESSAGES will be displayed here for debugging.")
-	fmt.Println("This is a passive mode - no interaction available.")
-	fmt.Println("Press Ctrl+C to stop")
-	fmt.Println()
+func RunListen(cmd *cobra.Command, args []string) error {
+	ui.Println("👂 Starting P2P node in passive listening mode...")
+	ui.Println("ALL LOGS AND MESSAGES will be displayed here for debugging.")
+	ui.Println("This is a passive mode - no interaction available.")
+	ui.Println("Press Ctrl+C to stop")
+	ui.Println()
 
 	// Create P2P wrapper with console logging enabled for debugging
 	ctx := context.Background()
-	wrapper := p2p.NewP2PWrapper(ctx, false) // Try real P2P first
+	wrapper := newP2PWrapper(ctx, cmd) // Try real P2P first
 
-	fmt.Println("🔧 Initializing P2P node...")
+	ui.Println("🔧 Initializing P2P node...")
 	if err := wrapper.Start(); err != nil {
-		fmt.Printf("❌ Failed to start P2P node: %v\n", err)
-		return
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
 	}
 	defer func() {
 		if err := wrapper.Stop(); err != nil {
-			fmt.Printf("Warning: Failed to stop wrapper: %v\n", err)
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
 		}
 	}()
+	defer startControl(wrapper)()
 
 	// Get node information
 	nodeInfo := wrapper.GetNodeInfo()
 
-	fmt.Println("✅ P2P node started successfully!")
-	fmt.Printf("🆔 Your Peer ID: %s\n", nodeInfo.PeerID)
-	fmt.Printf("🌐 Your DID: %s\n", nodeInfo.DID)
-	fmt.Printf("📡 Listening on: %v\n", nodeInfo.ListenAddrs)
-	fmt.Println()
+	ui.Success("P2P node started successfully!")
+	ui.Printf("🆔 Your Peer ID: %s\n", nodeInfo.PeerID)
+	ui.Printf("🌐 Your DID: %s\n", nodeInfo.DID)
+	ui.Printf("📡 Listening on: %v\n", nodeInfo.ListenAddrs)
+	ui.Println()
 
 	if wrapper.IsUsingSimulation() {
-		fmt.Println("⚠️  Note: Using simulation mode (real P2P failed to start)")
+		ui.Warn("Note: Using simulation mode (real P2P failed to start)")
 	} else {
-		fmt.Println("✅ Using real P2P networking")
+		ui.Success("Using real P2P networking")
 	}
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Start real-time log monitoring
+	// Log entries are passed on as the node writes them
 	logChan := make(chan string, 100)
-	go MonitorLogFileRealTime(logChan)
+	stopLog := wrapper.StreamLog(logChan)
+	defer stopLog()
+	ui.Println("📡 Real-time log monitoring started")
 
-	// Passive listening loop with real log monitoring
+	// Passive listening loop: wakes only for log entries and signals
 	for {
 		select {
 		case <-sigChan:
-			fmt.Println("\n👋 Shutting down...")
-			return
+			ui.Println("\n👋 Shutting down...")
+			return nil
 
 		case logEntry := <-logChan:
-			// Display new log entries in real-time
-			fmt.Printf("[%s] %s\n", time.Now().Format("15:04:05"), logEntry)
-
-		default:
-			// Small sleep to prevent busy waiting
-			time.Sleep(100 * time.Millisecond)
+			ui.Printf("[%s] %s\n", time.Now().Format("15:04:05"), FormatLogEntry(logEntry))
 		}
 	}
 }
 
+// discoverResult is what discover prints with --json once done
+type discoverResult struct {
+	PeerID         string           `json:"peer_id"`
+	KnownPeers     int              `json:"known_peers"`
+	ConnectedPeers int              `json:"connected_peers"`
+	Peers          []p2p.PeerStatus `json:"peers,omitempty"`
+}
+
 // RunDiscover handles the discover command
-func RunDiscover(cmd *cobra.Command, args []string) {
-	fmt.Println("🔍 Discovering peers in the network...")
-	fmt.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
-	fmt.Println()
+func RunDiscover(cmd *cobra.Command, args []string) error {
+	if subnet, _ := cmd.Flags().GetString("subnet"); subnet != "" {
+		return runDiscoverSubnet(subnet)
+	}
+	if watch, _ := cmd.Flags().GetBool("watch"); watch {
+		return runDiscoverWatch()
+	}
+
+	ui.Println("🔍 Discovering peers in the network...")
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
 
 	// Check if node is already running
 	status, err := p2p.ReadNodeStatus()
 	if err != nil || status == nil || !status.IsRunning {
-		fmt.Println("❌ No running node found")
-		fmt.Println("💡 Start the node first with: peerchat-cli start")
-		return
+		if jsonMode() {
+			printJSON(notRunningJSON)
+		}
+		ui.Error("No running node found")
+		ui.Info("Start the node first with: peerchat-cli start")
+		return networkError(errNodeNotRunning)
 	}
 
-	fmt.Println("✅ Using existing running node")
-	fmt.Printf("🆔 Your Peer ID: %s\n", status.PeerID)
-	fmt.Printf("📡 Your addresses: %v\n", status.ListenAddrs)
-	fmt.Println()
+	ui.Success("Using existing running node")
+	ui.Printf("🆔 Your Peer ID: %s\n", status.PeerID)
+	ui.Printf("📡 Your addresses: %v\n", status.ListenAddrs)
+	ui.Println()
 
-	fmt.Println("⏳ Monitoring discovery for 10 seconds...")
+	ui.Println("⏳ Monitoring discovery for 10 seconds...")
 
 	// Monitor discovery for 10 seconds
 	for i := 1; i <= 10; i++ {
-		fmt.Printf(".")
+		ui.Printf(".")
 		time.Sleep(1 * time.Second)
 
 		// Check for new peers every 2 seconds
 		if i%2 == 0 {
 			newStatus, err := p2p.ReadNodeStatus()
-			if err == nil && newStatus != nil && newStatus.Discovery != nil {
+			if err == nil && newStatus != nil && newStatus.Discovery != nil && status.Discovery != nil {
 				if newStatus.Discovery.KnownPeers > status.Discovery.KnownPeers {
-					fmt.Printf("\n🎉 Found %d new peers!\n", newStatus.Discovery.KnownPeers-status.Discovery.KnownPeers)
+					ui.Printf("\n🎉 Found %d new peers!\n", newStatus.Discovery.KnownPeers-status.Discovery.KnownPeers)
 					status = newStatus
 				}
 			}
 		}
 	}
-	fmt.Println()
+	ui.Println()
 
 	// Final status
 	finalStatus, err := p2p.ReadNodeStatus()
-	if err == nil && finalStatus != nil {
-		fmt.Println("✅ Discovery completed")
-		fmt.Printf("📊 Total known peers: %d\n", finalStatus.Discovery.KnownPeers)
-		fmt.Printf("🔗 Connected peers: %d\n", finalStatus.ConnectedPeers)
-		fmt.Println("💡 Use 'peerchat-cli status' for detailed information")
+	if err == nil && finalStatus != nil && finalStatus.Discovery != nil {
+		if jsonMode() {
+			printJSON(discoverResult{
+				PeerID:         finalStatus.PeerID,
+				KnownPeers:     finalStatus.Discovery.KnownPeers,
+				ConnectedPeers: finalStatus.ConnectedPeers,
+				Peers:          finalStatus.Peers,
+			})
+		}
+		ui.Success("Discovery completed")
+		ui.Printf("📊 Total known peers: %d\n", finalStatus.Discovery.KnownPeers)
+		ui.Printf("🔗 Connected peers: %d\n", finalStatus.ConnectedPeers)
+		ui.Info("Use 'peerchat-cli status' for detailed information")
 	} else {
-		fmt.Println("✅ Discovery completed")
-		fmt.Println("📊 Check logs for detailed discovery information")
+		if jsonMode() {
+			printJSON(discoverResult{PeerID: status.PeerID})
+		}
+		ui.Success("Discovery completed")
+		ui.Println("📊 Check logs for detailed discovery information")
+	}
+	return nil
+}
+
+// peersResult is what peers prints with --json
+type peersResult struct {
+	PeerID string           `json:"peer_id"`
+	Peers  []p2p.PeerStatus `json:"peers"`
+}
+
+// RunPeers handles the peers command
+func RunPeers(cmd *cobra.Command, args []string) error {
+	status, err := p2p.ReadNodeStatus()
+	if err != nil || status == nil || !status.IsRunning {
+		if jsonMode() {
+			printJSON(notRunningJSON)
+		}
+		ui.Error("No running node found")
+		ui.Info("Start the node first with: peerchat-cli start")
+		return networkError(errNodeNotRunning)
+	}
+	if jsonMode() {
+		peers := status.Peers
+		if peers == nil {
+			peers = []p2p.PeerStatus{}
+		}
+		printJSON(peersResult{PeerID: status.PeerID, Peers: peers})
+		return nil
+	}
+
+	ui.Println("👥 Connected peers:")
+	if len(status.Peers) == 0 {
+		ui.Println("  (No peers connected yet)")
+		ui.Info("Use 'peerchat-cli discover' to find peers")
+		return nil
+	}
+	for i, p := range status.Peers {
+		relay := ""
+		if p.ViaRelay {
+			relay = " (via relay)"
+		}
+		ui.Printf("  %d. %s ✅%s quality %.0f\n", i+1, p.PeerID, relay, p.Quality)
+		ui.Printf("     %s\n", p.Addr)
 	}
+	ui.Info("Total: %d connected peer(s), as of %s", len(status.Peers), status.LastUpdate.Format("15:04:05"))
+	return nil
+}
+
+// identityResult is what id prints with --json
+type identityResult struct {
+	DID         string   `json:"did,omitempty"`
+	PeerID      string   `json:"peer_id,omitempty"`
+	ListenAddrs []string `json:"listen_addrs,omitempty"`
+	Link        string   `json:"link,omitempty"`
+	Simulation  bool     `json:"simulation,omitempty"`
+	Error       string   `json:"error,omitempty"`
 }
 
 // RunShowID handles the id command
-func RunShowID(cmd *cobra.Command, args []string) {
-	fmt.Println("🆔 Your Identity:")
-	fmt.Println("==================")
-	fmt.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
-	fmt.Println()
+func RunShowID(cmd *cobra.Command, args []string) error {
+	ui.Println("🆔 Your Identity:")
+	ui.Println("==================")
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
 
 	// Try to get identity from P2P wrapper
 	ctx := context.Background()
-	wrapper := p2p.NewP2PWrapper(ctx, false) // Try real P2P first
+	wrapper := newP2PWrapper(ctx, cmd) // Try real P2P first
 
-	fmt.Println("🔧 Initializing P2P node to get identity...")
+	ui.Println("🔧 Initializing P2P node to get identity...")
 	if err := wrapper.Start(); err != nil {
-		fmt.Printf("❌ Failed to start P2P node: %v\n", err)
-		fmt.Println("💡 Try running 'peerchat-cli init' first")
-		return
+		if jsonMode() {
+			printJSON(identityResult{Error: err.Error()})
+		}
+		ui.Error("Failed to start P2P node: %v", err)
+		ui.Info("Try running 'peerchat-cli init' first")
+		return networkError(err)
 	}
 	defer func() {
 		if err := wrapper.Stop(); err != nil {
-			fmt.Printf("Warning: Failed to stop wrapper: %v\n", err)
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
 		}
 	}()
 
 	// Get node information
 	nodeInfo := wrapper.GetNodeInfo()
+	if jsonMode() {
+		result := identityResult{
+			DID:         nodeInfo.DID,
+			PeerID:      nodeInfo.PeerID,
+			ListenAddrs: nodeInfo.ListenAddrs,
+			Simulation:  wrapper.IsUsingSimulation(),
+		}
+		if link, err := wrapper.IdentityURI(); err == nil {
+			result.Link = link.String()
+		}
+		printJSON(result)
+	}
 
-	fmt.Println("✅ Identity retrieved successfully!")
-	fmt.Printf("🆔 DID: %s\n", nodeInfo.DID)
-	fmt.Printf("🔗 Peer ID: %s\n", nodeInfo.PeerID)
-	fmt.Printf("📡 Listen addresses: %v\n", nodeInfo.ListenAddrs)
-	fmt.Println()
+	ui.Success("Identity retrieved successfully!")
+	ui.Printf("🆔 DID: %s\n", nodeInfo.DID)
+	ui.Printf("🔗 Peer ID: %s\n", nodeInfo.PeerID)
+	ui.Printf("📡 Listen addresses: %v\n", nodeInfo.ListenAddrs)
+	if link, err := wrapper.IdentityURI(); err == nil {
+		ui.Printf("🔗 Link: %s\n", link)
+		if qr, _ := cmd.Flags().GetBool("qr"); qr {
+			printQRCode(link.String())
+		}
+	}
+	ui.Println()
 
 	if wrapper.IsUsingSimulation() {
-		fmt.Println("⚠️  Note: Using simulation mode (real P2P failed to start)")
-		fmt.Println("💡 This identity is simulated for testing")
+		ui.Warn("Note: Using simulation mode (real P2P failed to start)")
+		ui.Info("This identity is simulated for testing")
 	} else {
-		fmt.Println("✅ Using real P2P networking")
-		fmt.Println("💡 Share your Peer ID with others to receive messages")
+		ui.Success("Using real P2P networking")
+		ui.Info("Share your Peer ID with others to receive messages, or let them")
+		ui.Println("   scan 'peerchat-cli id --qr' and run 'peerchat-cli connect --uri <link>'")
 	}
+	return nil
 }
 
-// RunProfile handles the profile command
-func RunProfile(cmd *cobra.Command, args []string) {
-	peerID := args[0]
+// RunStop handles the stop command
+func RunStop(cmd *cobra.Command, args []string) error {
+	ui.Println("🛑 Stopping P2P node...")
+	ui.Error("Error: Node stopping not yet implemented")
+	ui.Println("This feature requires process management and IPC.")
+	return generalError(errors.New("stopping the node is not implemented"))
+}
 
-	fmt.Printf("👤 Profile for peer: %s\n", peerID)
-	fmt.Println("========================")
-	fmt.Println("❌ Error: Peer profile lookup not yet implemented")
-	fmt.Println("This feature requires DHT lookup and peer information storage.")
+// RunSetup handles the setup command
+func RunSetup(cmd *cobra.Command, args []string) error {
+	ui.Println("🧙 Xelvra Setup Wizard")
+	ui.Println("======================")
+	ui.Error("Error: Setup wizard not yet implemented")
+	ui.Println("This feature requires interactive CLI interface.")
+	return generalError(errors.New("setup wizard not implemented"))
 }
 
-// RunSendFile handles the send-file command
-func RunSendFile(cmd *cobra.Command, args []string) {
-	peerID := args[0]
-	filePath := args[1]
+// RunRotateKey handles the rotate-key command
+func RunRotateKey(cmd *cobra.Command, args []string) error {
+	ui.Println("🔑 Rotating identity key...")
 
-	fmt.Printf("📁 Sending file %s to peer: %s\n", filePath, peerID)
-	fmt.Println("❌ Error: File transfer not yet implemented")
-	fmt.Println("This feature requires P2P file transfer protocol.")
-}
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Error("Stop the running node before rotating your key")
+		return generalError(errNodeRunning)
+	}
 
-// RunStop handles the stop command
-func RunStop(cmd *cobra.Command, args []string) {
-	fmt.Println("🛑 Stopping P2P node...")
-	fmt.Println("❌ Error: Node stopping not yet implemented")
-	fmt.Println("This feature requires process management and IPC.")
-}
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to locate home directory: %v", err)
+		return configError(err)
+	}
 
-// RunSetup handles the setup command
-func RunSetup(cmd *cobra.Command, args []string) {
-	fmt.Println("🧙 Xelvra Setup Wizard")
-	fmt.Println("======================")
-	fmt.Println("❌ Error: Setup wizard not yet implemented")
-	fmt.Println("This feature requires interactive CLI interface.")
+	identityPath := filepath.Join(dataDir, user.IdentityFileName)
+	var oldID *user.MessengerID
+	if _, err := os.Stat(identityPath); err == nil {
+		if oldID, _, err = user.LoadOrCreateMessengerID(identityPath); err != nil {
+			ui.Error("Failed to load current identity: %v", err)
+			return configError(err)
+		}
+		defer oldID.Destroy()
+	}
+
+	newID, ann, err := user.RotateMessengerID(identityPath)
+	if err != nil {
+		ui.Error("Failed to rotate key: %v", err)
+		ui.Info("Run 'peerchat-cli init' first if you have no identity yet")
+		return configError(err)
+	}
+
+	// Queued offline messages are encrypted with a key derived from the identity
+	if err := message.RekeyOfflineMessages(filepath.Join(dataDir, message.OfflineDirName), oldID, newID); err != nil {
+		ui.Warn("Failed to re-encrypt queued offline messages: %v", err)
+	}
+
+	ui.Success("Identity key rotated")
+	ui.Printf("🔗 Old Peer ID: %s\n", ann.OldPeerID)
+	ui.Printf("🔗 New Peer ID: %s\n", newID.GetPeerID())
+	ui.Printf("🆔 New DID: %s\n", newID.GetDID())
+	ui.Println()
+	ui.Println("📣 A key-change announcement signed by both keys will be sent to")
+	ui.Println("   all contacts the next time the node starts, and again on later")
+	ui.Println("   starts to each contact that has not confirmed receiving it.")
+	ui.Info("Contacts will be asked to re-verify your safety number")
+	return nil
 }
 
 // getStatusIcon returns an icon for boolean status
@@ -370,3 +677,66 @@ func getStatusIcon(active bool) string {
 	}
 	return "❌ Inactive"
 }
+
+// printTransportStatus shows the addresses listened on and the connections
+// open per transport, QUIC first as the preferred one
+func printTransportStatus(status *p2p.NodeStatus) {
+	listening := make(map[string]int)
+	connections := make(map[string]int)
+	for _, t := range status.Transports {
+		if t.RemoteAddr != "" {
+			connections[t.Type]++
+		} else {
+			listening[t.Type]++
+		}
+	}
+
+	ui.Println("🚚 Transports:")
+	for _, transport := range []struct{ kind, label string }{{"quic", "QUIC"}, {"tcp", "TCP"}, {"relay", "Relay"}, {"webtransport", "WebTransport"}, {"webrtc", "WebRTC"}} {
+		if transport.kind == "quic" && status.QUICDisabled != "" {
+			ui.Printf("  QUIC: ❌ disabled (%s)\n", status.QUICDisabled)
+			continue
+		}
+		if listening[transport.kind] == 0 && connections[transport.kind] == 0 {
+			continue
+		}
+		ui.Printf("  %s: %d listening, %d connected\n", transport.label, listening[transport.kind], connections[transport.kind])
+	}
+	if status.BrowserDisabled != "" {
+		ui.Printf("  Browser: ❌ disabled (%s)\n", status.BrowserDisabled)
+	}
+	for _, addr := range status.BrowserAddrs {
+		ui.Printf("  🌐 Browsers dial %s\n", addr)
+	}
+	ui.Println()
+}
+
+// printNATTraversal shows whether peers can dial the node and how many
+// relayed connections hole punching upgraded to direct ones
+func printNATTraversal(status *p2p.NodeStatus) {
+	if status.Reachability == "" && status.HolePunch == nil {
+		return
+	}
+
+	ui.Println("🕳️  NAT Traversal:")
+	switch status.Reachability {
+	case "public":
+		ui.Println("  Reachability: public, peers dial you directly")
+	case "private":
+		ui.Println("  Reachability: private, peers reach you through relays until hole punching connects you directly")
+	default:
+		ui.Println("  Reachability: unknown, AutoNAT is still asking peers")
+	}
+	if hp := status.HolePunch; hp != nil {
+		ui.Printf("  Hole punching: %d relayed connection(s) upgraded to direct, %d punch attempt(s)\n", hp.Upgrades, hp.Attempts)
+		if hp.Upgrades > 0 {
+			ui.Printf("  Last upgrade: %s at %s\n", hp.LastPeer, hp.LastUpgrade.Format("15:04:05"))
+		}
+		if hp.LastError != "" {
+			ui.Printf("  Last failure: %s\n", hp.LastError)
+		}
+	} else {
+		ui.Println("  Hole punching: ❌ disabled behind the SOCKS proxy")
+	}
+	ui.Println()
+}
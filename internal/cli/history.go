@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// RunHistory handles the history command
+func RunHistory(cmd *cobra.Command, args []string) error {
+	since, _ := cmd.Flags().GetString("since")
+	limit, _ := cmd.Flags().GetInt("limit")
+	offset, _ := cmd.Flags().GetInt("offset")
+	archived, _ := cmd.Flags().GetBool("archived")
+
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	query := db.HistoryQuery{Limit: limit, Offset: offset, HideArchived: !archived}
+	if query.Since, err = db.ParseSince(since, time.Now()); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	if len(args) > 0 {
+		query.PeerID = resolveContactName(dataDir, args[0])
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if history == nil {
+		ui.Println("📜 No message history yet")
+		return nil
+	}
+	defer closeHistory()
+
+	entries, err := history.QueryHistory(query)
+	if err != nil {
+		ui.Error("Failed to query history: %v", err)
+		return generalError(err)
+	}
+
+	printHistory(ui.Printer{}, entries)
+	if query.Limit > 0 && len(entries) == query.Limit {
+		ui.Info("More messages available: add --offset %d", query.Offset+len(entries))
+	}
+	return nil
+}
+
+// RunSearch handles the search command
+func RunSearch(cmd *cobra.Command, args []string) error {
+	peerTarget, _ := cmd.Flags().GetString("peer")
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	query := db.SearchQuery{Text: strings.Join(args, " "), Limit: limit}
+	if peerTarget != "" {
+		query.PeerID = resolveContactName(dataDir, peerTarget)
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if history == nil {
+		ui.Println("🔍 No message history yet")
+		return nil
+	}
+	defer closeHistory()
+
+	results, err := history.SearchHistory(query)
+	if err != nil {
+		ui.Error("Search failed: %v", err)
+		return generalError(err)
+	}
+	printSearchResults(ui.Printer{}, results)
+	return nil
+}
+
+// openLocalHistory opens the history database in dataDir. It returns a nil
+// database if no history has been recorded yet.
+func openLocalHistory(dataDir string) (*db.SQLiteDB, func(), error) {
+	if _, err := os.Stat(filepath.Join(dataDir, db.DatabaseName)); os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	history, err := db.OpenHistory(dataDir, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open message history: %w", err)
+	}
+
+	return history, func() {
+		if err := history.Close(); err != nil {
+			logger.WithError(err).Warn("Failed to close message history")
+		}
+	}, nil
+}
+
+// resolveContactName maps a contact name to its peer ID; anything that is
+// not a saved contact is used as given
+func resolveContactName(dataDir, target string) string {
+	contacts, err := user.LoadContactBook(filepath.Join(dataDir, user.ContactsFileName))
+	if err != nil {
+		return target
+	}
+	if contact, ok := contacts.Get(target); ok {
+		return contact.PeerID
+	}
+	return target
+}
+
+// printHistory prints history entries oldest first
+func printHistory(out ui.Printer, entries []*db.HistoryEntry) {
+	if len(entries) == 0 {
+		out.Println("📜 No messages found")
+		return
+	}
+
+	out.Printf("📜 %d message(s):\n", len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+
+		arrow := "←"
+		if entry.Outgoing {
+			arrow = "→"
+		}
+
+		star := ""
+		if !entry.StarredAt.IsZero() {
+			star = "⭐ "
+		}
+
+		out.Printf("  [%s] %s %s: %s%s%s  id:%s\n",
+			entry.Timestamp.Format("2006-01-02 15:04"),
+			arrow,
+			shortPeerID(entry.PeerID),
+			star,
+			historySummary(entry),
+			filterLabel(entry),
+			shortMessageID(entry.ID))
+	}
+}
+
+// printSearchResults prints search matches with their message IDs
+func printSearchResults(out ui.Printer, results []*db.SearchResult) {
+	if len(results) == 0 {
+		out.Println("🔍 No matching messages")
+		return
+	}
+
+	out.Printf("🔍 %d match(es):\n", len(results))
+	for _, result := range results {
+		entry := result.Entry
+
+		arrow := "←"
+		if entry.Outgoing {
+			arrow = "→"
+		}
+
+		out.Printf("  [%s] %s %s  id:%s\n",
+			entry.Timestamp.Format("2006-01-02 15:04"),
+			arrow,
+			shortPeerID(entry.PeerID),
+			entry.ID)
+		out.Printf("    %s\n", result.Snippet)
+	}
+}
+
+// historySummary returns a one-line description of a history entry
+func historySummary(entry *db.HistoryEntry) string {
+	switch entry.Type {
+	case message.MessageTypeText:
+		return strings.ReplaceAll(string(entry.Content), "\n", " ")
+	case message.MessageTypeSystem:
+		if notice, err := message.NoticeOf(entry.Content, entry.Metadata); err == nil {
+			return "🔧 " + strings.ReplaceAll(notice.Text(nil), "\n", " ")
+		}
+		return fmt.Sprintf("[%s]", entry.Type)
+	default:
+		return fmt.Sprintf("[%s]", entry.Type)
+	}
+}
+
+// filterLabel marks entries that content filters tagged or archived
+func filterLabel(entry *db.HistoryEntry) string {
+	filter := message.FilterMatchOf(entry.Metadata)
+	label := ""
+	if len(filter.Tags) > 0 {
+		label += "  🏷️ " + strings.Join(filter.Tags, ", ")
+	}
+	if filter.Archived {
+		label += "  🗄️ archived"
+	}
+	return label
+}
+
+// shortPeerID abbreviates a peer ID for display
+func shortPeerID(id string) string {
+	if len(id) <= 16 {
+		return id
+	}
+	return id[:8] + "…" + id[len(id)-6:]
+}
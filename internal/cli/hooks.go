@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getHooksPath returns the data directory and the wake hooks path
+func getHooksPath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, message.HooksFileName), nil
+}
+
+// RunHookAdd handles the hook add command
+func RunHookAdd(cmd *cobra.Command, args []string) error {
+	peerTarget, _ := cmd.Flags().GetString("peer")
+	class, _ := cmd.Flags().GetString("class")
+	tag, _ := cmd.Flags().GetString("tag")
+	mac, _ := cmd.Flags().GetString("wake")
+	broadcast, _ := cmd.Flags().GetString("broadcast")
+	run, _ := cmd.Flags().GetString("run")
+
+	hook := &message.Hook{Class: class, Tag: tag, WakeMAC: mac, Broadcast: broadcast, Command: strings.Fields(run)}
+	if (mac == "") == (run == "") {
+		ui.Error("Give exactly one of --wake or --run")
+		return generalError(errors.New("not exactly one of --wake or --run"))
+	}
+
+	dataDir, path, err := getHooksPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	if peerTarget != "" {
+		hook.PeerID = resolveContactName(dataDir, peerTarget)
+	}
+	if err := hook.Validate(); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	hooks, err := message.LoadHooks(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	for _, existing := range hooks {
+		hook.ID = max(hook.ID, existing.ID)
+	}
+	hook.ID++
+	hooks = append(hooks, hook)
+
+	if err := message.SaveHooks(path, hooks); err != nil {
+		ui.Error("Failed to save hooks: %v", err)
+		return generalError(err)
+	}
+
+	scope := "anyone"
+	if peerTarget != "" {
+		scope = peerTarget
+	}
+	ui.Success("Hook %d for %s: %s", hook.ID, scope, hook)
+	ui.Info("A running node picks it up automatically; try it with 'peerchat-cli hook test %d'", hook.ID)
+	return nil
+}
+
+// RunHookList handles the hook list command
+func RunHookList(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getHooksPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	hooks, err := message.LoadHooks(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if len(hooks) == 0 {
+		ui.Println("⏰ No wake hooks")
+		return nil
+	}
+
+	names := contactNames(dataDir)
+	ui.Printf("⏰ Wake hooks (%d):\n", len(hooks))
+	for _, hook := range hooks {
+		scope := "all"
+		if hook.PeerID != "" {
+			scope = shortPeerID(hook.PeerID)
+			if name := names[hook.PeerID]; name != "" {
+				scope = name
+			}
+		}
+		ui.Printf("  %3d  %-12s %s\n", hook.ID, scope, hook)
+	}
+	return nil
+}
+
+// RunHookRemove handles the hook remove command
+func RunHookRemove(cmd *cobra.Command, args []string) error {
+	hooks, path, index, err := findHook(args[0])
+	if err != nil {
+		return err
+	}
+
+	hook := hooks[index]
+	hooks = append(hooks[:index], hooks[index+1:]...)
+	if err := message.SaveHooks(path, hooks); err != nil {
+		ui.Error("Failed to save hooks: %v", err)
+		return generalError(err)
+	}
+	ui.Success("Removed hook %d: %s", hook.ID, hook)
+	return nil
+}
+
+// RunHookTest handles the hook test command, firing the hook as if a
+// matching message had arrived
+func RunHookTest(cmd *cobra.Command, args []string) error {
+	hooks, _, index, err := findHook(args[0])
+	if err != nil {
+		return err
+	}
+
+	hook := hooks[index]
+	event := message.HookEvent{PeerID: hook.PeerID, Class: hook.Class}
+	if hook.Tag != "" {
+		event.Tags = []string{hook.Tag}
+	}
+	if err := hook.Run(context.Background(), event); err != nil {
+		ui.Error("Hook %d failed: %v", hook.ID, err)
+		return generalError(err)
+	}
+	ui.Success("Fired hook %d: %s", hook.ID, hook)
+	return nil
+}
+
+// findHook loads the hooks and finds the one with the given ID, printing
+// why when it cannot
+func findHook(arg string) ([]*message.Hook, string, int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		ui.Error("Invalid hook ID %q: see 'peerchat-cli hook list'", arg)
+		return nil, "", 0, generalError(err)
+	}
+
+	_, path, err := getHooksPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return nil, "", 0, configError(err)
+	}
+	hooks, err := message.LoadHooks(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return nil, "", 0, generalError(err)
+	}
+
+	for i, hook := range hooks {
+		if hook.ID == id {
+			return hooks, path, i, nil
+		}
+	}
+	ui.Error("No hook %d", id)
+	return nil, "", 0, generalError(fmt.Errorf("no hook %d", id))
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/qrcode"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// printQRCode prints text as a QR code for the terminal
+func printQRCode(text string) {
+	code, err := qrcode.Encode([]byte(text))
+	if err != nil {
+		ui.Error("Failed to render QR code: %v", err)
+		return
+	}
+	ui.Println()
+	ui.Print(code.Terminal())
+	ui.Println("📱 Scan it with the Xelvra app, or pass the link to 'peerchat-cli connect --uri'")
+}
+
+// parsePeerLink reads an identity link or the multiaddr of a peer,
+// resolving a bare /dnsaddr/<domain>
+func parsePeerLink(link string) (*p2p.IdentityURI, error) {
+	if strings.HasPrefix(link, "/") {
+		return p2p.ResolvePeerAddr(context.Background(), link)
+	}
+	return p2p.ParseIdentityURI(link)
+}
+
+// runConnectURI connects to the peer of an identity link or multiaddr and,
+// with --name, saves it as a contact
+func runConnectURI(cmd *cobra.Command, link string) error {
+	uri, err := parsePeerLink(link)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	name, _ := cmd.Flags().GetString("name")
+
+	ui.Printf("🔗 Connecting to peer: %s\n", uri.PeerID)
+	if uri.DID != "" {
+		ui.Printf("🆔 DID: %s\n", uri.DID)
+	}
+	if len(uri.Addrs) == 0 {
+		ui.Warn("No addresses given, the peer must be found through discovery")
+	}
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+
+	if err := wrapper.ConnectURI(uri); err != nil {
+		ui.Error("Failed to connect to peer: %v", err)
+		ui.Info("Make sure the peer is online and the link is current; its addresses change when it restarts")
+		return networkError(err)
+	}
+	ui.Success("Connected to peer: %s", uri.PeerID)
+
+	if name == "" {
+		ui.Info("Add --name <name> to save the peer as a contact")
+		return nil
+	}
+	contact, err := wrapper.AddContactWithDID(name, uri.PeerID.String(), uri.DID)
+	if err != nil {
+		ui.Error("Failed to save contact: %v", err)
+		return generalError(err)
+	}
+	if warnKeyChanged(ui.Printer{}, contact) {
+		return nil
+	}
+	ui.Printf("📇 Saved %s as contact %s, its key is pinned\n", uri.PeerID, name)
+	return nil
+}
@@ -2,76 +2,84 @@ package cli
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
-	"time"
 
+	"github.com/Xelvra/peerchat/internal/message"
 	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
 	"github.com/chzyer/readline"
 	"github.com/spf13/cobra"
 )
 
 // RunInteractiveChat starts the P2P node with interactive chat
-func RunInteractiveChat(cmd *cobra.Command, args []string) {
+func RunInteractiveChat(cmd *cobra.Command, args []string) error {
 	// Get version from root command
 	version := cmd.Root().Version
 	if version == "" {
 		version = "unknown"
 	}
 
-	fmt.Println("🚀 Starting Xelvra P2P Messenger CLI")
-	fmt.Printf("Version: %s\n", version)
-	fmt.Println("💬 Interactive Chat Mode")
-	fmt.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
-	fmt.Println()
+	ui.Println("🚀 Starting Xelvra P2P Messenger CLI")
+	ui.Printf("Version: %s\n", version)
+	ui.Println("💬 Interactive Chat Mode")
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
 
 	// Create P2P wrapper (try real P2P first, fallback to simulation)
 	ctx := context.Background()
-	wrapper := p2p.NewP2PWrapper(ctx, false)
+	wrapper := newP2PWrapper(ctx, cmd)
 
-	fmt.Println("🔧 Initializing P2P node...")
+	ui.Println("🔧 Initializing P2P node...")
 	if err := wrapper.Start(); err != nil {
-		fmt.Printf("❌ Failed to start real P2P node: %v\n", err)
-		fmt.Println("🔄 Falling back to simulation mode...")
+		ui.Error("Failed to start real P2P node: %v", err)
+		ui.Println("🔄 Falling back to simulation mode...")
 
 		// Try simulation mode
 		wrapper = p2p.NewP2PWrapper(ctx, true)
 		if err := wrapper.Start(); err != nil {
-			fmt.Printf("❌ Failed to start simulation mode: %v\n", err)
-			return
+			ui.Error("Failed to start simulation mode: %v", err)
+			return networkError(err)
 		}
 	}
 	defer func() {
 		if err := wrapper.Stop(); err != nil {
-			fmt.Printf("Warning: Failed to stop wrapper: %v\n", err)
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
 		}
 	}()
+	defer startControl(wrapper)()
 
 	// Get node information
 	nodeInfo := wrapper.GetNodeInfo()
 
-	fmt.Println("✅ P2P node started successfully!")
-	fmt.Printf("🆔 Your Peer ID: %s\n", nodeInfo.PeerID)
-	fmt.Printf("🌐 Your DID: %s\n", nodeInfo.DID)
-	fmt.Printf("📡 Listening on: %v\n", nodeInfo.ListenAddrs)
-	fmt.Println()
+	ui.Success("P2P node started successfully!")
+	ui.Printf("🆔 Your Peer ID: %s\n", nodeInfo.PeerID)
+	ui.Printf("🌐 Your DID: %s\n", nodeInfo.DID)
+	ui.Printf("📡 Listening on: %v\n", nodeInfo.ListenAddrs)
+	ui.Println()
 
 	if wrapper.IsUsingSimulation() {
-		fmt.Println("⚠️  Note: Using simulation mode (real P2P failed to start)")
-		fmt.Println("💡 Messages will be simulated. For real P2P, check network settings.")
+		ui.Warn("Note: Using simulation mode (real P2P failed to start)")
+		ui.Info("Messages will be simulated. For real P2P, check network settings.")
 	} else {
-		fmt.Println("✅ Using real P2P networking")
-		fmt.Println("💡 Share your Peer ID with others to receive messages")
+		ui.Success("Using real P2P networking")
+		ui.Info("Share your Peer ID with others to receive messages")
+
+		// Incoming files wait for /accept or /reject
+		if dataDir, _, err := getFileAllowlistPath(); err == nil {
+			wrapper.SetFileOfferHandler(func(offer *message.FileOffer) {
+				printFileOffer(offer, contactNames(dataDir))
+			})
+		}
 	}
 
-	fmt.Println()
-	fmt.Println("💬 Interactive chat started! Type /help for commands.")
-	fmt.Println("🎯 Features: Tab completion, command history, arrow keys")
-	fmt.Println()
+	ui.Println()
+	ui.Println("💬 Interactive chat started! Type /help for commands.")
+	ui.Println("🎯 Features: Tab completion, command history, arrow keys")
+	ui.Println()
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -80,17 +88,30 @@ func RunInteractiveChat(cmd *cobra.Command, args []string) {
 	// Try to create readline instance for advanced input
 	rl, completer, err := CreateReadlineInstance()
 	if err != nil {
-		fmt.Printf("⚠️  Failed to initialize advanced input: %v\n", err)
-		fmt.Println("💡 Falling back to basic input mode")
+		ui.Warn("Failed to initialize advanced input: %v", err)
+		ui.Info("Falling back to basic input mode")
 		// Fallback to basic input mode would go here
-		return
+		return generalError(err)
 	}
 	defer func() {
 		if err := rl.Close(); err != nil {
-			fmt.Printf("Warning: Failed to close readline: %v\n", err)
+			ui.Printf("Warning: Failed to close readline: %v\n", err)
 		}
 	}()
 
+	// Received messages are counted per conversation, and the prompt shows
+	// the current one with the messages waiting in the others
+	refreshPrompt := func() {
+		rl.SetPrompt(chatPrompt(wrapper))
+		rl.Refresh()
+	}
+	if !wrapper.IsUsingSimulation() {
+		wrapper.SetMessageListener(func(peerID string, msg *message.Message) {
+			printChatMessage(wrapper, peerID, msg)
+			refreshPrompt()
+		})
+	}
+
 	// Create input channel
 	inputChan := make(chan string)
 
@@ -123,81 +144,75 @@ func RunInteractiveChat(cmd *cobra.Command, args []string) {
 		}
 	}()
 
-	// Main event loop
+	// Main event loop: wakes only for input and signals; received messages
+	// are printed by the message listener as they arrive
 	for {
 		select {
 		case <-sigChan:
-			fmt.Println("\n👋 Shutdown signal received, stopping node...")
-			fmt.Println("✅ Node stopped successfully")
-			fmt.Println("👋 Goodbye!")
-			return
+			ui.Println("\n👋 Shutdown signal received, stopping node...")
+			ui.Success("Node stopped successfully")
+			ui.Println("👋 Goodbye!")
+			return nil
 
 		case input, ok := <-inputChan:
 			if !ok {
-				fmt.Println("\n👋 Input closed, shutting down...")
-				return
+				ui.Println("\n👋 Input closed, shutting down...")
+				return nil
 			}
 
 			if input == "" {
 				continue
 			}
 
-			// Handle commands
-			if strings.HasPrefix(input, "/") {
-				if input == "/quit" || input == "/exit" {
-					fmt.Println("👋 Goodbye!")
-					return
-				}
-				HandleChatCommand(input, wrapper, nodeInfo)
-			} else {
-				// Send message to all connected peers
-				HandleChatMessage(input, wrapper)
+			if input == "/quit" || input == "/exit" {
+				ui.Println("👋 Goodbye!")
+				return nil
 			}
-
-		default:
-			// Check for incoming messages (placeholder)
-			time.Sleep(100 * time.Millisecond)
+			// Commands and messages print why they failed
+			_ = handleChatInput(ui.Printer{}, input, wrapper, nodeInfo)
+			refreshPrompt()
 		}
 	}
 }
 
 // RunDaemonMode runs the P2P node as a background daemon
-func RunDaemonMode(cmd *cobra.Command, args []string) {
+func RunDaemonMode(cmd *cobra.Command, args []string) error {
 	// Get version from root command
 	version := cmd.Root().Version
 	if version == "" {
 		version = "unknown"
 	}
 
-	fmt.Println("🔧 Starting Xelvra P2P Messenger in daemon mode...")
-	fmt.Printf("Version: %s\n", version)
-	fmt.Println("📝 All logs will be written to ~/.xelvra/peerchat.log")
-	fmt.Println()
+	ui.Println("🔧 Starting Xelvra P2P Messenger in daemon mode...")
+	ui.Printf("Version: %s\n", version)
+	ui.Println("📝 All logs will be written to ~/.xelvra/peerchat.log")
+	ui.Println()
 
 	// Create P2P wrapper
 	ctx := context.Background()
-	wrapper := p2p.NewP2PWrapper(ctx, false)
+	wrapper := newP2PWrapper(ctx, cmd)
 
-	fmt.Println("🔧 Initializing P2P node...")
+	ui.Println("🔧 Initializing P2P node...")
 	if err := wrapper.Start(); err != nil {
-		fmt.Printf("❌ Failed to start P2P node: %v\n", err)
-		return
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
 	}
 	defer func() {
 		if err := wrapper.Stop(); err != nil {
-			fmt.Printf("Warning: Failed to stop wrapper: %v\n", err)
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
 		}
 	}()
+	defer startControl(wrapper)()
 
 	// Get node information
 	nodeInfo := wrapper.GetNodeInfo()
 
-	fmt.Println("✅ P2P node started in daemon mode!")
-	fmt.Printf("🆔 Your Peer ID: %s\n", nodeInfo.PeerID)
-	fmt.Printf("🌐 Your DID: %s\n", nodeInfo.DID)
-	fmt.Printf("📡 Listening on: %v\n", nodeInfo.ListenAddrs)
-	fmt.Println()
-	fmt.Println("🔄 Running in background... Press Ctrl+C to stop")
+	ui.Success("P2P node started in daemon mode!")
+	ui.Printf("🆔 Your Peer ID: %s\n", nodeInfo.PeerID)
+	ui.Printf("🌐 Your DID: %s\n", nodeInfo.DID)
+	ui.Printf("📡 Listening on: %v\n", nodeInfo.ListenAddrs)
+	ui.Println()
+	ui.Println("🔄 Running in background... Press Ctrl+C to stop")
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -205,6 +220,7 @@ func RunDaemonMode(cmd *cobra.Command, args []string) {
 
 	// Wait for shutdown signal
 	<-sigChan
-	fmt.Println("\n👋 Shutdown signal received, stopping daemon...")
-	fmt.Println("✅ Daemon stopped successfully")
+	ui.Println("\n👋 Shutdown signal received, stopping daemon...")
+	ui.Success("Daemon stopped successfully")
+	return nil
 }
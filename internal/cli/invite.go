@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"context"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// RunInviteCreate handles the invite create command
+func RunInviteCreate(cmd *cobra.Command, args []string) error {
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	showQR, _ := cmd.Flags().GetBool("qr")
+
+	if reusable, _ := cmd.Flags().GetBool("reusable"); reusable {
+		link, err := p2p.NewInviteURI(nil)
+		if err != nil {
+			ui.Error("Failed to create invite link: %v", err)
+			return generalError(err)
+		}
+		ui.Println("🔗 Share this invite link:")
+		ui.Printf("  %s\n", link)
+		if showQR {
+			printQRCode(link.String())
+		}
+		ui.Println()
+		ui.Info("The link reveals your Peer ID and works until your addresses change;")
+		ui.Println("   anyone holding it can connect with 'peerchat-cli join <link>'")
+		if len(link.Addrs) == 0 && len(link.Relays) == 0 {
+			ui.Warn("Your node is not running, so the link has no addresses and peers")
+			ui.Println("   must find you through discovery")
+		}
+		return nil
+	}
+
+	ui.Println("🎟️  Creating one-time invite...")
+
+	invite, err := p2p.CreateInvite(ttl)
+	if err != nil {
+		ui.Error("Failed to create invite: %v", err)
+		return generalError(err)
+	}
+
+	code, err := invite.Code()
+	if err != nil {
+		ui.Error("Failed to encode invite: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Invite created!")
+	ui.Printf("🆔 Invite ID: %s\n", invite.ID)
+	ui.Printf("⏰ Expires: %s (in %s)\n", invite.ExpiresAt.Format("2006-01-02 15:04:05"), ttl)
+	ui.Println()
+	ui.Println("🔗 Share this invite code:")
+	ui.Printf("  %s\n", code)
+	if link, err := p2p.NewInviteURI(invite); err != nil {
+		ui.Warn("No invite link: %v", err)
+	} else {
+		ui.Println("🔗 Or this link, which also names your DID and relays:")
+		ui.Printf("  %s\n", link)
+		if showQR {
+			printQRCode(link.String())
+		}
+	}
+	ui.Println()
+	ui.Info("The invite uses a temporary identity - your real Peer ID is only")
+	ui.Println("   revealed to the first peer that redeems it with 'peerchat-cli join'")
+	ui.Info("Your node must be running ('peerchat-cli start') to answer the invite")
+	return nil
+}
+
+// RunJoin handles the join command
+func RunJoin(cmd *cobra.Command, args []string) error {
+	link, err := p2p.ParseInviteURI(args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	name, _ := cmd.Flags().GetString("name")
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+
+	return joinInvite(ui.Printer{}, wrapper, link, name)
+}
+
+// joinInvite connects to the inviter of link and saves it as a contact
+// under name, or the nickname in the link
+func joinInvite(out ui.Printer, wrapper *p2p.P2PWrapper, link *p2p.InviteURI, name string) error {
+	if link.Token != "" {
+		out.Println("🎟️  Redeeming invite...")
+	} else {
+		out.Printf("🔗 Connecting to peer: %s\n", link.PeerID)
+	}
+	peerID, did, err := wrapper.JoinInvite(link)
+	if err != nil {
+		out.Error("Failed to join invite: %v", err)
+		return networkError(err)
+	}
+	out.Success("Connected to peer: %s", peerID)
+	if did != "" {
+		out.Printf("🆔 DID: %s\n", did)
+	}
+
+	if name == "" {
+		name = link.Name
+	}
+	if name == "" {
+		name = "peer-" + peerID[len(peerID)-6:]
+	}
+	contact, err := wrapper.AddContactWithDID(name, peerID, did)
+	if err != nil {
+		out.Warn("Not saved as a contact: %v", err)
+		return nil
+	}
+	if warnKeyChanged(out, contact) {
+		return nil
+	}
+	out.Printf("📇 Saved as contact %s, its key is pinned\n", name)
+	return nil
+}
+
+// RunInviteList handles the invite list command
+func RunInviteList(cmd *cobra.Command, args []string) error {
+	invites, err := p2p.ListInvites()
+	if err != nil {
+		ui.Error("Failed to load invites: %v", err)
+		return generalError(err)
+	}
+
+	ui.Println("🎟️  Invites")
+	ui.Println("==========")
+
+	if len(invites) == 0 {
+		ui.Println("  (No invites)")
+		ui.Info("Create one with: peerchat-cli invite create --ttl 1h")
+		return nil
+	}
+
+	for _, inv := range invites {
+		state := "✅ active"
+		switch {
+		case inv.Used:
+			state = "🔒 used"
+		case inv.IsExpired():
+			state = "⌛ expired"
+		}
+		ui.Printf("  %s  %-10s  expires %s\n", inv.ID, state, inv.ExpiresAt.Format("2006-01-02 15:04"))
+		if inv.IsActive() {
+			ui.Printf("      remaining: %s\n", time.Until(inv.ExpiresAt).Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// RunInviteRevoke handles the invite revoke command
+func RunInviteRevoke(cmd *cobra.Command, args []string) error {
+	id := args[0]
+
+	if err := p2p.RevokeInvite(id); err != nil {
+		ui.Error("Failed to revoke invite: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Invite %s revoked", id)
+	return nil
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// jsonFlag makes the commands that support it print their results as
+	// JSON to stdout and everything else to stderr
+	jsonFlag = "json"
+
+	// jsonAnnotation marks the commands that support --json
+	jsonAnnotation = "json"
+)
+
+// notRunningJSON is printed with --json by the commands that need a
+// running node when there is none
+var notRunningJSON = struct {
+	IsRunning bool `json:"is_running"`
+}{}
+
+// jsonOutput is where --json results go. While it is set the ui helpers
+// print to stderr, so the human text printed along the way stays out of the
+// results.
+var jsonOutput io.Writer
+
+// withJSON marks cmd as supporting --json
+func withJSON(cmd *cobra.Command) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = make(map[string]string)
+	}
+	cmd.Annotations[jsonAnnotation] = "true"
+	return cmd
+}
+
+// setupJSONOutput sends human text to stderr when --json is given, and
+// refuses --json for commands that do not support it
+func setupJSONOutput(cmd *cobra.Command, args []string) error {
+	asJSON, _ := cmd.Flags().GetBool(jsonFlag)
+	if !asJSON {
+		return nil
+	}
+	if cmd.Annotations[jsonAnnotation] == "" {
+		return fmt.Errorf("--%s is not supported by '%s'", jsonFlag, cmd.CommandPath())
+	}
+	jsonOutput = os.Stdout
+	ui.SetStdout(os.Stderr)
+	return nil
+}
+
+// jsonMode reports whether results are printed as JSON
+func jsonMode() bool {
+	return jsonOutput != nil
+}
+
+// printJSON prints v as JSON on one line of the real stdout
+func printJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to encode JSON: %v\n", err)
+		return
+	}
+	fmt.Fprintln(jsonOutput, string(data))
+}
@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// logsFollowLines is how many earlier entries --follow shows unless
+	// --lines says otherwise
+	logsFollowLines = 10
+
+	// logsPollInterval is how often --follow checks the log file
+	logsPollInterval = 500 * time.Millisecond
+)
+
+// LogEntry is one entry of the JSON log file
+type LogEntry struct {
+	Time    time.Time
+	Level   logrus.Level
+	Message string
+	Fields  map[string]interface{} // Everything else logged with the entry
+}
+
+// ParseLogEntry parses a line of the JSON log file, reporting false for
+// lines that are not log entries
+func ParseLogEntry(line string) (*LogEntry, bool) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return nil, false
+	}
+
+	entry := &LogEntry{Level: logrus.InfoLevel, Fields: fields}
+	if level, ok := fields[logrus.FieldKeyLevel].(string); ok {
+		if parsed, err := logrus.ParseLevel(level); err == nil {
+			entry.Level = parsed
+		}
+	}
+	entry.Message, _ = fields[logrus.FieldKeyMsg].(string)
+	if timestamp, ok := fields[logrus.FieldKeyTime].(string); ok {
+		entry.Time, _ = time.Parse(time.RFC3339Nano, timestamp)
+	}
+	delete(fields, logrus.FieldKeyLevel)
+	delete(fields, logrus.FieldKeyMsg)
+	delete(fields, logrus.FieldKeyTime)
+	return entry, true
+}
+
+// FieldsText returns the fields of the entry as key=value pairs, sorted by
+// key
+func (e *LogEntry) FieldsText() string {
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		value := fmt.Sprint(e.Fields[key])
+		if strings.ContainsAny(value, " \t") {
+			value = strconv.Quote(value)
+		}
+		pairs[i] = key + "=" + value
+	}
+	return strings.Join(pairs, " ")
+}
+
+// LogFilter selects log entries to show
+type LogFilter struct {
+	Level logrus.Level   // Only entries this severe or more
+	Since time.Time      // Only entries logged at or after this; zero for all
+	Grep  *regexp.Regexp // Only entries whose message or fields match; nil for all
+}
+
+// Matches reports whether a line of the log file passes the filter. Lines
+// that are not entries, such as a crash trace, only pass without a level
+// or time to compare.
+func (f *LogFilter) Matches(line string) bool {
+	entry, ok := ParseLogEntry(line)
+	if !ok {
+		return f.Level == logrus.TraceLevel && f.Since.IsZero() && (f.Grep == nil || f.Grep.MatchString(line))
+	}
+	if entry.Level > f.Level {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	return f.Grep == nil || f.Grep.MatchString(entry.Message) || f.Grep.MatchString(entry.FieldsText())
+}
+
+// formatLogLine formats a line of the log file for display: the entry as
+// FormatLogEntry shows it, followed by its fields
+func formatLogLine(line string) string {
+	text := FormatLogEntry(line)
+	if entry, ok := ParseLogEntry(line); ok && len(entry.Fields) > 0 {
+		text += "  " + entry.FieldsText()
+	}
+	return text
+}
+
+// RunLogs handles the logs command
+func RunLogs(cmd *cobra.Command, args []string) error {
+	follow, _ := cmd.Flags().GetBool("follow")
+	levelName, _ := cmd.Flags().GetString("level")
+	since, _ := cmd.Flags().GetString("since")
+	pattern, _ := cmd.Flags().GetString("grep")
+	lines, _ := cmd.Flags().GetInt("lines")
+	if follow && !cmd.Flags().Changed("lines") {
+		lines = logsFollowLines
+	}
+
+	filter := &LogFilter{Level: logrus.TraceLevel}
+	if levelName != "" {
+		level, err := logrus.ParseLevel(levelName)
+		if err != nil {
+			ui.Error("%v", err)
+			ui.Info("Levels are debug, info, warn and error")
+			return generalError(err)
+		}
+		filter.Level = level
+	}
+	after, err := db.ParseSince(since, time.Now())
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	filter.Since = after
+	if pattern != "" {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			ui.Error("Invalid --grep pattern: %v", err)
+			return generalError(err)
+		}
+		filter.Grep = re
+	}
+
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	path := filepath.Join(dataDir, p2p.LogFileName)
+
+	// Rotated files hold the older entries, oldest first
+	var matched []string
+	for i := p2p.LogBackups; i >= 0; i-- {
+		file := path
+		if i > 0 {
+			file = path + "." + strconv.Itoa(i)
+		}
+		found, err := readLogLines(file, filter)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			ui.Error("Failed to read %s: %v", file, err)
+			return generalError(err)
+		}
+		matched = append(matched, found...)
+	}
+	if lines > 0 && len(matched) > lines {
+		matched = matched[len(matched)-lines:]
+	}
+	for _, line := range matched {
+		ui.Println(formatLogLine(line))
+	}
+
+	if !follow {
+		if len(matched) == 0 {
+			ui.Printf("📝 No log entries in %s match\n", path)
+		}
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return followLog(ctx, path, filter)
+}
+
+// readLogLines returns the lines of the log file at path that pass filter
+func readLogLines(path string, filter *LogFilter) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matched []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" && filter.Matches(line) {
+			matched = append(matched, line)
+		}
+	}
+	return matched, scanner.Err()
+}
+
+// followLog prints the entries passing filter as they are appended to the
+// log file at path, until ctx is done. A log file replaced by rotation is
+// followed from its start.
+func followLog(ctx context.Context, path string, filter *LogFilter) error {
+	var (
+		file    *os.File
+		reader  *bufio.Reader
+		partial string
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// Entries already shown are skipped; a file created later is read in full
+	if f, err := os.Open(path); err == nil {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			ui.Error("Failed to read %s: %v", path, err)
+			return generalError(err)
+		}
+		file, reader = f, bufio.NewReader(f)
+	}
+
+	ticker := time.NewTicker(logsPollInterval)
+	defer ticker.Stop()
+	for {
+		if file != nil {
+			for {
+				chunk, err := reader.ReadString('\n')
+				partial += chunk
+				if err != nil {
+					break
+				}
+				if line := strings.TrimSpace(partial); line != "" && filter.Matches(line) {
+					ui.Println(formatLogLine(line))
+				}
+				partial = ""
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if logReplaced(file, path) {
+			if file != nil {
+				file.Close()
+			}
+			file, reader, partial = nil, nil, ""
+			if f, err := os.Open(path); err == nil {
+				file, reader = f, bufio.NewReader(f)
+			}
+		}
+	}
+}
+
+// logReplaced reports whether the log file at path is not the open file,
+// because it was rotated, truncated or created since
+func logReplaced(file *os.File, path string) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if file == nil {
+		return true
+	}
+	opened, err := file.Stat()
+	if err != nil || !os.SameFile(opened, current) {
+		return true
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	return err == nil && current.Size() < offset
+}
@@ -26,7 +26,43 @@ DESCRIPTION
 
 GLOBAL OPTIONS
     --config FILE     Configuration file (default: ~/.xelvra/config.yaml)
+    --profile NAME    Use this profile of the configuration file instead
+                      of the one matching the network
     -v, --verbose     Enable verbose output and detailed logging
+    --json            Print results as JSON to stdout, other output to
+                      stderr (status, discover, peers, id, doctor, send,
+                      ping, bench)
+    --theme NAME      Output colors: dark (default), light or mono
+    --no-color        Print without colors, as does NO_COLOR=1; output that
+                      is not a terminal is never colored
+    --no-emoji        Print Error:, Warning: and Hint: instead of emoji
+    --file-streams N  Split files of 8 MB or more across up to N streams
+                      (default 4; 1 sends every file over one stream)
+    --file-compression=false
+                      Send and receive file chunks uncompressed
+    --quic-mtu=safe   Keep QUIC to 1200-byte packets on every network
+    --trace[=TARGET]  Time each hop of a message, to ~/.xelvra/traces.jsonl,
+                      another file or an OTLP/HTTP collector URL
+    --wake-relay ADDR Stay registered with a wake host so peers can wake
+                      the node while it sleeps behind a NAT
+    --serve-wake      Let peers register here to be woken
+    --storage=memory  Keep history, offline messages and contacts in memory
+                      only; nothing is left after exit (default sqlite)
+    --battery-saver   Discover peers less often, send keep-alives together
+                      and skip DHT refreshes while idle, for a 20mW idle
+                      budget; /battery in chat turns it on or off
+    --max-connections N
+                      Close idle connections to strangers above N open
+                      connections, down to N/2 (default 64)
+    --browser         Listen for WebTransport and WebRTC connections from
+                      browser clients
+    --tor             Dial and listen through a local Tor daemon, at an
+                      onion service; --tor-socks and --tor-control set its
+                      ports (default 127.0.0.1:9050 and 127.0.0.1:9051)
+    --visibility=LEVEL
+                      Announce the node to everyone, contacts-of-contacts,
+                      contacts or nobody (invisible); /visibility in chat
+                      changes and saves it
     -h, --help        Show help information
     --version         Show version information
 
@@ -49,12 +85,36 @@ COMMANDS
                         peerchat-cli start
                         peerchat-cli start --daemon
 
+    tui               Start the node and chat full-screen: connected peers
+                      in a sidebar with unread badges, the conversation
+                      with the selected peer and an input box below
+
+                      Example:
+                        peerchat-cli tui
+
+  AUTOMATION
+    script [file|-]   Run chat commands and messages from a file, stdin
+                      (-) or --exec, one per line (--exec also splits on
+                      semicolons), on the running node, or one started for
+                      the script. Stops at the first failing command,
+                      exiting with its status, unless --keep-going is given
+
+                      Examples:
+                        peerchat-cli script --exec "/msg alice done; /list"
+                        echo "/peers" | peerchat-cli script -
+
   NODE MANAGEMENT
     status            Show detailed node status and network information
                       Displays peer connections, NAT info, and discovery status
 
                       Example:
                         peerchat-cli status
+                        peerchat-cli status --json | jq .connected_peers
+
+    peers             List the peers the running node is connected to
+
+                      Example:
+                        peerchat-cli peers --json
 
     listen            Start node in passive listening mode (debugging)
                       Shows all logs and network activity in real-time
@@ -73,26 +133,39 @@ COMMANDS
     discover          Discover peers on the local network
                       Uses mDNS, UDP broadcast, and DHT for peer discovery
                       Shows real-time discovery progress and results
+                      --watch streams each peer found until Ctrl+C,
+                      --json prints the result, or each event, as JSON
+                      --subnet <cidr> probes each address of an IPv4
+                      range (at most a /22) on UDP port 42424, for
+                      networks that filter multicast and mDNS
 
                       Example:
                         peerchat-cli discover
+                        peerchat-cli discover --watch --json
+                        peerchat-cli discover --subnet 192.168.50.0/24
 
   MESSAGING
     send              Send a message to a specific peer
-                      Requires peer ID and message text as arguments
-                      Currently requires running node for delivery
+                      Takes a peer ID, contact or @name and the text
+                      The running node queues it; exits 2 without one
 
                       Example:
                         peerchat-cli send 12D3KooW... "Hello, World!"
 
   FILE TRANSFER
-    send-file         Send a file to a peer (not yet implemented)
-                      Will support chunked, resumable file transfers
-                      Includes integrity verification and progress tracking
+    send-file         Queue a file for a peer; a node sends it whenever the
+                      peer is connected and resumes interrupted sends,
+                      across restarts
 
                       Example:
                         peerchat-cli send-file 12D3KooW... /path/to/file.txt
 
+    queue             List queued files or take one off the queue
+
+                      Examples:
+                        peerchat-cli queue list
+                        peerchat-cli queue remove file_1760612345678901234
+
   IDENTITY & PROFILES
     id                Show your identity information
                       Displays DID, Peer ID, and network addresses
@@ -100,11 +173,74 @@ COMMANDS
                       Example:
                         peerchat-cli id
 
-    profile           Show profile information for a peer (not yet implemented)
-                      Will display peer metadata and connection history
+    profile           Show the profile a peer shares with you, or manage
+                      your profile facets (e.g. work and personal) and
+                      choose which one each contact sees
 
-                      Example:
+                      Examples:
                         peerchat-cli profile 12D3KooW...
+                        peerchat-cli profile facet set work --display-name "Jane Doe"
+                        peerchat-cli profile assign alice personal
+
+    data              Export everything the node stores about you and your
+                      contacts to a zip archive, or erase everything stored
+                      about one contact, with a report of what was removed
+
+                      Examples:
+                        peerchat-cli data export --out my-data.zip
+                        peerchat-cli data erase --peer alice
+
+    backup            Keep encrypted backups on a trusted contact's node,
+                      restore them with a recovery phrase, or hold
+                      backups for a contact within a quota
+
+                      Examples:
+                        peerchat-cli backup setup alice --every 12h
+                        peerchat-cli backup allow bob --quota 200
+                        peerchat-cli backup restore alice
+
+    trace [file]      Summarize how long each hop of a message takes, from
+                      spans recorded by a node started with --trace
+
+                      Examples:
+                        peerchat-cli start --trace
+                        peerchat-cli trace --since 1h
+
+    config            List, get, set and unset the settings of
+                      config.yaml, keeping its comments; a running node
+                      reloads it (SIGHUP) and applies discovery and
+                      logging settings at once
+
+                      Examples:
+                        peerchat-cli config list
+                        peerchat-cli config set discovery.mdns false
+                        peerchat-cli config get listen
+
+    logs              Show the node's log, rotated files included, with
+                      --level, --since and --grep filters; --follow keeps
+                      printing new entries
+
+                      Examples:
+                        peerchat-cli logs --follow --level warn
+                        peerchat-cli logs --since 1h --grep dht
+
+    debug             Serve pprof profiles and runtime statistics of the
+                      node on localhost, and save them from a running node
+                      to ~/.xelvra/debug/; start --pprof serves them for
+                      one run
+
+                      Examples:
+                        peerchat-cli debug enable --port 6060
+                        peerchat-cli debug dump --cpu 30s
+                        peerchat-cli debug dump --runtime-trace 5s
+
+    networks          List what was learned about each network (NAT type,
+                      transports, packet sizes), or forget a network so it
+                      is probed again
+
+                      Examples:
+                        peerchat-cli networks list
+                        peerchat-cli networks forget all
 
   HELP & INFORMATION
     manual            Show this comprehensive manual
@@ -116,13 +252,33 @@ COMMANDS
                         peerchat-cli help send
 
   DIAGNOSTICS & TROUBLESHOOTING
-    doctor            Run comprehensive network diagnostics
-                      Tests P2P connectivity, NAT traversal, and discovery
-                      Provides troubleshooting suggestions for common issues
+    doctor            Run network diagnostics, each check passing, warning
+                      or failing: default route, DNS, binding TCP and UDP
+                      ports, NAT type from STUN, multicast for mDNS and
+                      clock skew against NTP; finds networks that drop
+                      large UDP packets and starts a test node
 
                       Example:
                         peerchat-cli doctor
 
+    ping <peer>       Measure the round trip time to a peer with probes it
+                      echoes, show min/avg/max and whether the path is
+                      direct (50ms target) or relayed, and through which
+                      relay
+
+                      Examples:
+                        peerchat-cli ping @alice
+                        peerchat-cli ping 12D3KooW... -c 10 -i 200ms
+
+    bench             Start two nodes in this process, connect them over
+                      loopback and measure message latency, throughput and
+                      file transfer speed against the 50ms latency and
+                      20MB idle memory targets; your data is not touched
+
+                      Examples:
+                        peerchat-cli bench
+                        peerchat-cli bench -n 1000 --file-size 64MB --transport quic
+
     setup             Interactive setup wizard (not yet implemented)
                       Will guide through initial configuration and testing
 
@@ -133,15 +289,46 @@ INTERACTIVE CHAT COMMANDS
     When in interactive mode (peerchat-cli start), these commands are available:
 
     /help             Show available interactive commands
-    /peers            List currently connected peers
+    /peers            List currently connected peers, marking those
+                      reached only through a relay
     /discover         Discover new peers on the network
+    /discover watch   Show peers as they are found; /discover stop ends it
+    /discover subnet <cidr>
+                      Probe an IPv4 range for nodes and connect to them
     /connect <id>     Connect to a specific peer (with tab completion)
     /disconnect <id>  Disconnect from a peer
+    /msg <peer> <text>
+                      Send to one peer, by contact name, @name, peer ID
+                      or the start of a connected peer's ID, and keep
+                      talking to it (/send does the same)
+    /switch [peer|all]
+                      Show or choose who typed messages go to
+    /next, /prev      Move to the next or previous conversation; the
+                      prompt shows the current one and how many messages
+                      wait in the others, e.g. [alice (2)] >
+    /list             List conversations and their unread messages
     /status           Show current node status
+    /whoami           Show your DID, peer ID, key fingerprint and the
+                      addresses you are reachable at now
+    /fingerprint [peer|contact]
+                      Show your key fingerprint, or a peer's, to read out
+                      and compare when verifying
+    /policy <contact> [<setting> <value>...]
+                      Show or set a contact's policy: auto-accept <MB|off>,
+                      notify all|quiet|muted, read-receipts on|off and
+                      disappear <duration|off>
+    /stats            Show memory (RSS), goroutines, open streams,
+                      connections by transport and message throughput,
+                      sampled every 5 seconds; /stats commands shows how
+                      often you used each command
+    /ping <peer> [count]
+                      Measure the round trip time to a peer and show
+                      whether it is reached directly or through a relay
     /clear            Clear the screen
     /quit, /exit      Exit interactive chat mode
 
-    Regular messages (not starting with /) are sent to all connected peers.
+    Regular messages (not starting with /) go to the current conversation,
+    or to all connected peers after /switch all or before any is chosen.
 
 KEYBOARD SHORTCUTS (Interactive Mode)
     Tab               Auto-complete commands and peer IDs
@@ -152,6 +339,14 @@ KEYBOARD SHORTCUTS (Interactive Mode)
     Ctrl+A            Move cursor to beginning of line
     Ctrl+E            Move cursor to end of line
 
+KEYBOARD SHORTCUTS (Full-Screen Chat)
+    Tab, ↓            Show the next peer's conversation
+    Shift+Tab, ↑      Show the previous peer's conversation
+    PgUp/PgDn         Scroll the conversation
+    Enter             Send to the peer shown
+    Ctrl+U            Clear the input box
+    Ctrl+C, /quit     Exit the full-screen chat
+
 FILES AND DIRECTORIES
     ~/.xelvra/                    Main configuration directory
     ~/.xelvra/config.yaml         Node configuration file
@@ -159,20 +354,77 @@ FILES AND DIRECTORIES
     ~/.xelvra/peerchat.log        Application log file (rotated)
     ~/.xelvra/chat_history        Interactive chat command history
     ~/.xelvra/offline_messages/   Stored offline messages
+    ~/.xelvra/userdata.db         Encrypted message history (SQLite, WAL mode)
+    ~/.xelvra/userdata.key        Random key protecting message history content
     ~/.xelvra/downloads/          Received files directory
+    ~/.xelvra/attachments/        Received files stored once by content hash
+    ~/.xelvra/backup.json         Backup settings and recovery phrase secret
+    ~/.xelvra/held_backups/       Encrypted backups held for contacts
+    ~/.xelvra/traces.jsonl        Message timing spans recorded with --trace
+    ~/.xelvra/diagnostics.json    Whether the localhost profiling endpoint is served
+    ~/.xelvra/transfer_queue.json Files waiting to be sent and how far they got
+    ~/.xelvra/networks.json       NAT type, transports and packet sizes learned per network
+    ~/.xelvra/discovery.jsonl     Peers found by discovery, followed by discover --watch
 
 CONFIGURATION
-    The configuration file (~/.xelvra/config.yaml) contains:
-    - Identity settings (DID, key paths)
-    - Network configuration (ports, bootstrap peers)
-    - Discovery settings (mDNS, DHT, UDP broadcast)
-    - Logging configuration (level, rotation)
+    The configuration file (~/.xelvra/config.yaml, or --config FILE) is
+    read before every command but version, manual and help. All settings
+    are optional:
+    - listen, announce       Listen and advertised addresses
+    - bootstrap_peers        Bootstrap peers replacing the defaults
+    - rendezvous             Keys to find peers under through the DHT
+    - swarm_key              Pre-shared key of a private network
+    - relays                 Circuit relays tried before any other
+    - hooks.on_message       Program run for every text message
+    - network.enable_quic    QUIC transport (default true)
+    - network.enable_tcp     TCP transport (default true)
+    - discovery.mdns         mDNS LAN discovery (default true)
+    - discovery.udp_broadcast
+                             UDP beacon LAN discovery (default true)
+    - logging.level          debug, info (default), warn or error
+    - logging.format         json (default) or text
+    - profile                Profile to use (default: the one matching
+                             the network)
+    - profiles               Named profiles, see below
+
+    A profile bundles listen, relays, bootstrap_peers and the network and
+    discovery settings for one network, e.g. home, work or offline. The
+    node uses the profile given with --profile or set by 'profile', or else
+    the first one, by name, whose match lists the Wi-Fi SSID or a subnet
+    holding the local address:
+        profiles:
+          work:
+            match: {ssid: [CorpWiFi], subnet: [10.20.0.0/16]}
+            discovery: {mdns: false, udp_broadcast: false}
+
+    A file that cannot be parsed or holds an invalid value stops the
+    command with exit code 3; unknown settings are ignored with a warning.
+    A node reloads the file on SIGHUP, as sent by 'config set'.
+
+    The version key records the format of the file. A file from an older
+    release, e.g. with listen_addrs or log_level, is migrated the first
+    time a command reads it: the settings are renamed, those no longer used
+    are dropped, each change is listed and the old file is kept as
+    config.yaml.bak.
+
+ENVIRONMENT
+    XELVRA_CONFIG_DIR         Data directory instead of ~/.xelvra; it holds
+                              config.yaml, the identity and everything else
+    XELVRA_<SECTION>_<KEY>    Overrides a setting of config.yaml, e.g.
+                              XELVRA_DISCOVERY_MDNS=false or XELVRA_LISTEN;
+                              list entries are separated by commas
+    XELVRA_LOG_LEVEL          Same as XELVRA_LOGGING_LEVEL
+    XELVRA_DISABLE_QUIC       1 turns QUIC off, using TCP only
+    XELVRA_SWARM_KEY          Swarm key file, or the key itself, of a
+                              private network
 
 NETWORK PROTOCOLS
-    - Transport: QUIC (primary), TCP (fallback)
+    - Transport: QUIC (primary), TCP (fallback, dialed when QUIC does not
+      connect within 500 ms); XELVRA_DISABLE_QUIC=1 turns QUIC off
     - Discovery: mDNS, UDP broadcast, DHT
     - Encryption: Ed25519 signatures, planned E2E encryption
-    - NAT Traversal: STUN, UPnP, relay servers
+    - NAT Traversal: STUN, UPnP, circuit relay v2 with automatic relay
+      selection, hole punching
 
 EXIT CODES
     0    Success
@@ -182,6 +434,10 @@ EXIT CODES
     4    Permission error
     5    Peer not found
 
+    Network errors include a node that fails to start or is not running;
+    permission errors include files outside the allow list and requests a
+    peer's policy refused.
+
 REPORTING BUGS
     Report bugs at: https://github.com/Xelvra/peerchat/issues
     Include: version info, logs, and steps to reproduce
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// RunNameClaim handles the name claim command
+func RunNameClaim(cmd *cobra.Command, args []string) error {
+	claim, err := p2p.SaveNameClaim(args[0])
+	if err != nil {
+		ui.Error("Failed to claim name: %v", err)
+		return generalError(err)
+	}
+
+	ui.Printf("🏷️  Name @%s saved\n", claim.Name)
+	ui.Printf("⏰ Claimed at: %s\n", claim.ClaimedAt.Format("2006-01-02 15:04:05"))
+	ui.Println()
+	ui.Info("Your running node publishes the claim to the DHT and refreshes it")
+	ui.Println("   every 12 hours. A name already held stays with its owner unless")
+	ui.Println("   a claim is backed by more proof-of-work.")
+	ui.Println("💡 Others can now message you with '/send @" + claim.Name + " <message>'")
+	return nil
+}
+
+// RunNameShow handles the name show command
+func RunNameShow(cmd *cobra.Command, args []string) error {
+	claim, err := p2p.LoadNameClaim()
+	if err != nil {
+		ui.Error("Failed to load name claim: %v", err)
+		return generalError(err)
+	}
+
+	if claim == nil {
+		ui.Println("🏷️  No name claimed")
+		ui.Info("Claim one with: peerchat-cli name claim <name>")
+		return nil
+	}
+
+	ui.Printf("🏷️  @%s (claimed %s)\n", claim.Name, claim.ClaimedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// RunNameRelease handles the name release command
+func RunNameRelease(cmd *cobra.Command, args []string) error {
+	if err := p2p.ReleaseNameClaim(); err != nil {
+		ui.Error("Failed to release name: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Name released")
+	ui.Info("The DHT record expires within 36 hours once it is no longer refreshed")
+	return nil
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getNetworkProfilesPath returns the path of the network profiles
+func getNetworkProfilesPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, p2p.NetworkProfilesFileName), nil
+}
+
+// RunNetworksList handles the networks list command
+func RunNetworksList(cmd *cobra.Command, args []string) error {
+	path, err := getNetworkProfilesPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	profiles, err := p2p.LoadNetworkProfiles(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if len(profiles) == 0 {
+		ui.Println("📭 No networks remembered yet")
+		return nil
+	}
+
+	current := ""
+	if id, err := p2p.CurrentNetwork(); err == nil {
+		current = id.Key()
+	}
+
+	ui.Printf("📶 Remembered networks (%d):\n", len(profiles))
+	for _, profile := range p2p.SortedNetworkProfiles(profiles) {
+		marker := "  "
+		if profile.Network == current {
+			marker = "▶ "
+		}
+		ui.Printf("%s%s\n", marker, profile.Network)
+		ui.Printf("    Last seen: %s\n", profile.LastSeen.Format("2006-01-02 15:04"))
+		if nat := profile.KnownNAT(); nat != nil {
+			ui.Printf("    NAT type:  %s\n", nat.Type)
+		}
+		if best := profile.BestTransport(); best != "" {
+			ui.Printf("    Best transport: %s\n", best)
+		}
+		if profile.QUICBlocked() {
+			ui.Println("    ⚠️  QUIC never connected here: the node uses TCP only")
+		}
+		if profile.PathMTU != nil {
+			if profile.PathMTU.BlackHole() {
+				ui.Printf("    ⚠️  Drops large UDP packets: QUIC keeps to %d-byte packets\n", p2p.QUICMinPacketSize)
+			} else {
+				ui.Printf("    Largest UDP packet: %d bytes\n", profile.PathMTU.LargestSize())
+			}
+		}
+	}
+	return nil
+}
+
+// RunNetworksForget handles the networks forget command
+func RunNetworksForget(cmd *cobra.Command, args []string) error {
+	path, err := getNetworkProfilesPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	profiles, err := p2p.LoadNetworkProfiles(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	// A network is named by its key or its SSID
+	forgotten := 0
+	for key, profile := range profiles {
+		if args[0] == "all" || args[0] == key || (profile.SSID != "" && args[0] == profile.SSID) {
+			delete(profiles, key)
+			forgotten++
+		}
+	}
+	if forgotten == 0 {
+		ui.Error("No remembered network %q", args[0])
+		return generalError(fmt.Errorf("no remembered network %q", args[0]))
+	}
+
+	if err := p2p.SaveNetworkProfiles(path, profiles); err != nil {
+		ui.Error("Failed to save network profiles: %v", err)
+		return generalError(err)
+	}
+	ui.Success("Forgot %d network(s); they are probed again when next joined", forgotten)
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Info("The running node keeps what it learned about the current network until restarted")
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+)
+
+// getFileAllowlistPath returns the data directory and the path of the peers
+// whose files are accepted without asking
+func getFileAllowlistPath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, message.FileAllowlistFileName), nil
+}
+
+// RunAutoAcceptAdd handles the autoaccept add command
+func RunAutoAcceptAdd(cmd *cobra.Command, args []string) error {
+	return updateFileAllowlist(args[0], true)
+}
+
+// RunAutoAcceptRemove handles the autoaccept remove command
+func RunAutoAcceptRemove(cmd *cobra.Command, args []string) error {
+	return updateFileAllowlist(args[0], false)
+}
+
+// updateFileAllowlist adds a peer to or removes it from the allowlist
+func updateFileAllowlist(target string, add bool) error {
+	dataDir, path, err := getFileAllowlistPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	peerID := resolveContactName(dataDir, target)
+	if _, err := peer.Decode(peerID); err != nil {
+		ui.Error("%s is neither a contact nor a peer ID", target)
+		return peerNotFoundError(err)
+	}
+
+	peers, err := message.LoadFileAllowlist(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	index := slices.Index(peers, peerID)
+	switch {
+	case add && index >= 0:
+		ui.Success("Files from %s are already accepted automatically", target)
+		return nil
+	case add:
+		peers = append(peers, peerID)
+	case index < 0:
+		ui.Error("%s is not on the auto-accept list", target)
+		return generalError(fmt.Errorf("%s is not on the auto-accept list", target))
+	default:
+		peers = slices.Delete(peers, index, index+1)
+	}
+
+	if err := message.SaveFileAllowlist(path, peers); err != nil {
+		ui.Error("Failed to save the auto-accept list: %v", err)
+		return generalError(err)
+	}
+	if add {
+		ui.Success("Files from %s are now accepted without asking", target)
+	} else {
+		ui.Success("Files from %s now need your approval", target)
+	}
+	ui.Info("A running node picks this up automatically")
+	return nil
+}
+
+// RunAutoAcceptList handles the autoaccept list command
+func RunAutoAcceptList(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getFileAllowlistPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	peers, err := message.LoadFileAllowlist(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if len(peers) == 0 {
+		ui.Println("📥 No peers on the auto-accept list")
+		ui.Info("Files are accepted in interactive chat with /accept, and refused otherwise")
+		return nil
+	}
+
+	names := contactNames(dataDir)
+	ui.Printf("📥 Files accepted without asking from %d peer(s):\n", len(peers))
+	for _, peerID := range peers {
+		if name := names[peerID]; name != "" {
+			ui.Printf("  %-12s %s\n", name, peerID)
+		} else {
+			ui.Printf("  %-12s %s\n", "-", peerID)
+		}
+	}
+	return nil
+}
+
+// printFileOffer announces a file a peer wants to send
+func printFileOffer(offer *message.FileOffer, names map[string]string) {
+	sender := shortPeerID(offer.PeerID.String())
+	if name := names[offer.PeerID.String()]; name != "" {
+		sender = name
+	}
+
+	if offer.Directory {
+		ui.Printf("\n📥 %s wants to send you the directory %s (%d files, %s)\n",
+			sender, offer.Name, offer.Files, formatBytes(offer.Size))
+	} else {
+		ui.Printf("\n📥 %s wants to send you %s (%s)\n", sender, offer.Name, formatBytes(offer.Size))
+	}
+	ui.Printf("💡 '/accept %d' to receive it or '/reject %d'; it is declined after %s\n\n",
+		offer.Number, offer.Number, message.FileOfferTimeout)
+}
+
+// handleFileOfferAnswer handles the /accept and /reject chat commands
+func handleFileOfferAnswer(out ui.Printer, args []string, accept bool, wrapper *p2p.P2PWrapper) error {
+	number := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			out.Error("Invalid file number %q", args[0])
+			return generalError(errUsage)
+		}
+		number = n
+	}
+
+	offer, err := wrapper.AnswerFileOffer(number, accept)
+	if err != nil {
+		out.Error("%v", err)
+		for _, waiting := range wrapper.FileOffers() {
+			out.Printf("  %d. %s (%s)\n", waiting.Number, waiting.Name, formatBytes(waiting.Size))
+		}
+		return generalError(err)
+	}
+
+	if !accept {
+		out.Printf("🚫 Declined %s\n", offer.Name)
+		return nil
+	}
+	if offer.Directory {
+		out.Success("Receiving %s into ~/.xelvra/downloads", offer.Name)
+	} else {
+		out.Success("Receiving %s into ~/.xelvra/downloads (transfer %s)", offer.Name, offer.TransferID)
+	}
+	out.Info("'peerchat-cli autoaccept add %s' accepts this peer's files without asking", offer.PeerID)
+	return nil
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"os"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// noColorFlag turns colored output off, as NO_COLOR does
+	noColorFlag = "no-color"
+
+	// noEmojiFlag prints words instead of status emoji
+	noEmojiFlag = "no-emoji"
+
+	// themeFlag picks the colors of the output
+	themeFlag = "theme"
+
+	// screenAnnotation marks the commands that draw the whole screen
+	// themselves, whose output is left alone
+	screenAnnotation = "screen"
+)
+
+// addOutputFlags adds the flags formatting the output to the root command
+func addOutputFlags(rootCmd *cobra.Command) {
+	rootCmd.PersistentFlags().Bool(noColorFlag, false, "Print without colors; also set by the NO_COLOR environment variable")
+	rootCmd.PersistentFlags().Bool(noEmojiFlag, false, "Print words such as 'Error:' instead of status emoji, and leave other emoji out")
+	rootCmd.PersistentFlags().String(themeFlag, ui.DefaultTheme, "Colors of the output: "+strings.Join(ui.ThemeNames(), ", "))
+}
+
+// setupOutput sets how the ui helpers format what the command prints,
+// following the output flags. Colors are only used on a terminal.
+func setupOutput(cmd *cobra.Command) error {
+	themeName, _ := cmd.Flags().GetString(themeFlag)
+	theme, err := ui.LookupTheme(themeName)
+	if err != nil {
+		return err
+	}
+	if cmd.Annotations[screenAnnotation] != "" {
+		return nil
+	}
+
+	// With --json the helpers print to stderr
+	out := os.Stdout
+	if jsonMode() {
+		out = os.Stderr
+	}
+	noColor, _ := cmd.Flags().GetBool(noColorFlag)
+	noEmoji, _ := cmd.Flags().GetBool(noEmojiFlag)
+	ui.Configure(ui.Formatter{
+		Theme: theme,
+		Color: !noColor && ui.ColorAllowed() && readline.IsTerminal(int(out.Fd())),
+		Emoji: !noEmoji,
+	})
+	return nil
+}
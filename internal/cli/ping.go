@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// pingUsage shows how to use the /ping command
+const pingUsage = "/ping <peer|contact|@name> [count]"
+
+// pingResult is the JSON form of a ping
+type pingResult struct {
+	*p2p.PingStats
+	Relayed      bool    `json:"relayed"`
+	Loss         float64 `json:"loss"`
+	TargetMs     int     `json:"target_ms"`
+	MeetsTarget  bool    `json:"meets_target"`
+	ProbesFailed []int   `json:"probes_failed,omitempty"`
+}
+
+// RunPing handles the ping command
+func RunPing(cmd *cobra.Command, args []string) error {
+	target := args[0]
+	count, _ := cmd.Flags().GetInt("count")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	if count < 1 || count > p2p.MaxPingCount {
+		ui.Error("--count must be between 1 and %d", p2p.MaxPingCount)
+		return generalError(errors.New("invalid count"))
+	}
+	if interval < 10*time.Millisecond {
+		ui.Error("--interval must be at least 10ms")
+		return generalError(errors.New("invalid interval"))
+	}
+
+	// Without IPC the ping needs its own node, which cannot share the
+	// identity's port with a running one
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Warn("A node is already running")
+		ui.Info("In its chat, use: /ping %s %d", target, count)
+		return generalError(errNodeRunning)
+	}
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	ui.Println("🔧 Initializing P2P node...")
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+
+	if wrapper.IsUsingSimulation() {
+		ui.Error("Pinging needs real P2P networking")
+		return networkError(errSimulation)
+	}
+
+	peerID, err := wrapper.ResolvePeer(target)
+	if err != nil {
+		ui.Error("Failed to resolve %s: %v", target, err)
+		return peerNotFoundError(err)
+	}
+	ui.Printf("🔗 Connecting to %s...\n", target)
+	if !wrapper.ConnectToPeer(peerID) {
+		ui.Error("Peer is not reachable")
+		return peerNotFoundError(errPeerUnreachable)
+	}
+
+	stats, failed, err := runPing(ui.Printer{}, wrapper, target, peerID, count, interval)
+	if err != nil {
+		return peerRefusedError(err)
+	}
+	if jsonMode() {
+		printJSON(pingResult{
+			PingStats:    stats,
+			Relayed:      stats.Relayed(),
+			Loss:         stats.Loss(),
+			TargetMs:     p2p.MaxLatencyMs,
+			MeetsTarget:  stats.MeetsLatencyTarget(),
+			ProbesFailed: failed,
+		})
+	}
+	if stats.Received == 0 {
+		return networkError(errPeerUnreachable)
+	}
+	return nil
+}
+
+// handlePingCommand handles the /ping chat command
+func handlePingCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 || len(args) > 2 {
+		out.Error("Usage: %s", pingUsage)
+		return generalError(errUsage)
+	}
+	count := p2p.DefaultPingCount
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > p2p.MaxPingCount {
+			out.Error("Count must be a whole number from 1 to %d, got %q", p2p.MaxPingCount, args[1])
+			return generalError(errUsage)
+		}
+		count = n
+	}
+
+	peerID, err := ResolveChatPeer(wrapper, args[0])
+	if err != nil {
+		out.Error("Failed to resolve %s: %v", args[0], err)
+		return peerNotFoundError(err)
+	}
+	if !slices.Contains(wrapper.GetConnectedPeers(), peerID) && !wrapper.ConnectToPeer(peerID) {
+		out.Error("Peer is not reachable")
+		return networkError(errPeerUnreachable)
+	}
+	if _, _, err := runPing(out, wrapper, args[0], peerID, count, p2p.DefaultPingInterval); err != nil {
+		return peerRefusedError(err)
+	}
+	return nil
+}
+
+// runPing pings peerID, showing each reply as it comes and a summary at the
+// end, and returns the statistics and the probes that got no echo
+func runPing(out ui.Printer, wrapper *p2p.P2PWrapper, target, peerID string, count int, interval time.Duration) (*p2p.PingStats, []int, error) {
+	out.Printf("📡 Pinging %s with %d probe(s)...\n", target, count)
+	var failed []int
+	stats, err := wrapper.Ping(peerID, count, interval, func(reply p2p.PingReply) {
+		if reply.Err != nil {
+			failed = append(failed, reply.Seq)
+			out.Printf("  #%d ❌ %v\n", reply.Seq, reply.Err)
+			return
+		}
+		out.Printf("  #%d %s\n", reply.Seq, formatRTT(reply.RTT))
+	})
+	if err != nil {
+		out.Error("Ping failed: %v", err)
+		out.Info("The peer may run a version that does not answer pings")
+		return nil, nil, err
+	}
+	printPingStats(out, stats)
+	return stats, failed, nil
+}
+
+// printPingStats shows the summary of a ping: loss, round trip times, and
+// how the path compares with the latency target
+func printPingStats(out ui.Printer, stats *p2p.PingStats) {
+	out.Printf("📊 %d sent, %d received, %.0f%% loss\n", stats.Sent, stats.Received, stats.Loss()*100)
+	if stats.Received == 0 {
+		out.Error("No probe was echoed")
+		return
+	}
+	out.Printf("⏱️  RTT min/avg/max: %s / %s / %s\n", formatRTT(stats.Min), formatRTT(stats.Avg), formatRTT(stats.Max))
+
+	if stats.Relayed() {
+		out.Printf("🔀 Path: relayed through %s\n", shortPeerID(stats.Relay))
+		out.Info("The %dms target applies to direct connections; messages stay relayed until hole punching succeeds", p2p.MaxLatencyMs)
+		out.Info("Run 'peerchat-cli doctor' to check your NAT, or connect over the LAN")
+		return
+	}
+	out.Printf("🔗 Path: direct (%s)\n", stats.Addr)
+	if stats.MeetsLatencyTarget() {
+		out.Success("Within the %dms target for direct connections", p2p.MaxLatencyMs)
+	} else {
+		out.Warn("Above the %dms target for direct connections", p2p.MaxLatencyMs)
+	}
+}
+
+// formatRTT shows a round trip time in milliseconds
+func formatRTT(rtt time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(rtt)/float64(time.Millisecond))
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// RunPinSet handles the pin set command
+func RunPinSet(cmd *cobra.Command, args []string) error {
+	policy, err := p2p.ParseTransportPolicy(args[1])
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	if err := p2p.SetTransportPin(args[0], policy); err != nil {
+		ui.Error("Failed to pin transport: %v", err)
+		return generalError(err)
+	}
+
+	ui.Printf("📌 %s pinned to %s\n", args[0], policy.Description())
+	ui.Info("A running node applies the pin within a few seconds and closes")
+	ui.Println("   existing connections that violate it")
+	return nil
+}
+
+// RunPinList handles the pin list command
+func RunPinList(cmd *cobra.Command, args []string) error {
+	return printTransportPins(ui.Printer{})
+}
+
+// RunPinClear handles the pin clear command
+func RunPinClear(cmd *cobra.Command, args []string) error {
+	if err := p2p.SetTransportPin(args[0], p2p.TransportAny); err != nil {
+		ui.Error("Failed to clear pin: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Transport pin for %s removed", args[0])
+	return nil
+}
+
+// printTransportPins prints all pinned conversations
+func printTransportPins(out ui.Printer) error {
+	pins, err := p2p.ListTransportPins()
+	if err != nil {
+		out.Error("Failed to load transport pins: %v", err)
+		return generalError(err)
+	}
+
+	out.Println("📌 Transport pins:")
+	if len(pins) == 0 {
+		out.Println("  (No pins - all conversations may use any transport)")
+		return nil
+	}
+
+	for _, pin := range pins {
+		out.Printf("  %s - %s\n", pin.PeerID, pin.Policy.Description())
+	}
+	return nil
+}
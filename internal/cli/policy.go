@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+)
+
+// policyUsage shows how to use the /policy command
+const policyUsage = "/policy <contact> [auto-accept <MB|off>] [notify all|quiet|muted] [read-receipts on|off] [disappear <duration|off>]"
+
+// handlePolicyCommand shows the policy of a contact, or changes the
+// settings given as pairs of setting and value
+func handlePolicyCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 || len(args)%2 == 0 {
+		out.Error("Usage: %s", policyUsage)
+		return generalError(errUsage)
+	}
+	name := args[0]
+	policy, err := wrapper.ContactPolicy(name)
+	if err != nil {
+		out.Error("%v", err)
+		out.Info("Use '/contacts' to list your contacts")
+		return peerNotFoundError(err)
+	}
+
+	if len(args) > 1 {
+		for i := 1; i < len(args); i += 2 {
+			if err := policy.Set(strings.ToLower(args[i]), args[i+1]); err != nil {
+				out.Error("%v", err)
+				out.Info("Usage: %s", policyUsage)
+				return generalError(err)
+			}
+		}
+		if err := wrapper.SetContactPolicy(name, policy); err != nil {
+			out.Error("Failed to save the policy of %s: %v", name, err)
+			return generalError(err)
+		}
+		out.Success("Policy of '%s' saved", name)
+	}
+
+	printContactPolicy(out, name, policy)
+	return nil
+}
+
+// printContactPolicy shows every setting of a contact's policy
+func printContactPolicy(out ui.Printer, name string, policy user.ContactPolicy) {
+	out.Printf("📜 Policy of %s:\n", name)
+	if mb := policy.AutoAcceptMB; mb > 0 {
+		out.Printf("  Auto-accept:   files up to %d MB\n", mb)
+	} else {
+		out.Println("  Auto-accept:   off, every file is asked about")
+	}
+	switch policy.NotifyLevel() {
+	case user.NotifyQuiet:
+		out.Println("  Notify:        quiet, messages are shown without running hooks")
+	case user.NotifyMuted:
+		out.Println("  Notify:        muted, messages are kept in the history only")
+	default:
+		out.Println("  Notify:        all")
+	}
+	if policy.ReadReceipts {
+		out.Println("  Read receipts: on, they are told when you have seen their messages")
+	} else {
+		out.Println("  Read receipts: off")
+	}
+	if ttl := policy.DisappearAfter(); ttl > 0 {
+		out.Printf("  Disappear:     messages are deleted from your history after %s\n", user.FormatTTL(ttl))
+	} else {
+		out.Println("  Disappear:     off, messages are kept")
+	}
+}
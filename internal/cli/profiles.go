@@ -0,0 +1,407 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+)
+
+// AvatarsDirName holds avatars received from peers in the data directory
+const AvatarsDirName = "avatars"
+
+// getProfilesPath returns the data directory and the path of the profile
+// facets
+func getProfilesPath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, user.ProfilesFileName), nil
+}
+
+// loadProfileSettings loads the profile facets, printing any error
+func loadProfileSettings() (string, string, *user.ProfileSettings, error) {
+	dataDir, path, err := getProfilesPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return "", "", nil, configError(err)
+	}
+	settings, err := user.LoadProfileSettings(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return "", "", nil, generalError(err)
+	}
+	return dataDir, path, settings, nil
+}
+
+// saveProfileSettings saves the profile facets, printing any error
+func saveProfileSettings(path string, settings *user.ProfileSettings) error {
+	if err := settings.Save(path); err != nil {
+		ui.Error("Failed to save profiles: %v", err)
+		return generalError(err)
+	}
+	return nil
+}
+
+// RunProfileFacetSet handles the profile facet set command
+func RunProfileFacetSet(cmd *cobra.Command, args []string) error {
+	name, err := user.NormalizeFacetName(args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	_, path, settings, err := loadProfileSettings()
+	if err != nil {
+		return err
+	}
+
+	facet := &user.ProfileFacet{Name: name, Fields: make(map[string]string)}
+	existing, exists := settings.Facets[name]
+	if exists {
+		facet.DisplayName = existing.DisplayName
+		facet.Avatar = existing.Avatar
+		for key, value := range existing.Fields {
+			facet.Fields[key] = value
+		}
+	}
+
+	if cmd.Flags().Changed("display-name") {
+		facet.DisplayName, _ = cmd.Flags().GetString("display-name")
+	}
+	if avatar, _ := cmd.Flags().GetString("avatar"); avatar != "" {
+		abs, err := filepath.Abs(avatar)
+		if err != nil {
+			ui.Error("%v", err)
+			return generalError(err)
+		}
+		info, err := os.Stat(abs)
+		if err != nil || info.IsDir() {
+			ui.Error("%s is not a file", avatar)
+			return generalError(fmt.Errorf("%s is not a file", avatar))
+		}
+		if info.Size() > user.MaxAvatarSize {
+			ui.Error("Avatars are limited to %s", formatBytes(user.MaxAvatarSize))
+			return generalError(errors.New("avatar too large"))
+		}
+		facet.Avatar = abs
+	}
+	if noAvatar, _ := cmd.Flags().GetBool("no-avatar"); noAvatar {
+		facet.Avatar = ""
+	}
+
+	fields, _ := cmd.Flags().GetStringArray("field")
+	for _, field := range fields {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			ui.Error("Fields are given as key=value, not %q", field)
+			return generalError(fmt.Errorf("field %q is not key=value", field))
+		}
+		facet.Fields[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	removed, _ := cmd.Flags().GetStringArray("remove-field")
+	for _, key := range removed {
+		delete(facet.Fields, strings.ToLower(strings.TrimSpace(key)))
+	}
+
+	if err := settings.SetFacet(facet); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if err := saveProfileSettings(path, settings); err != nil {
+		return err
+	}
+
+	if exists {
+		ui.Success("Facet '%s' updated", name)
+	} else {
+		ui.Success("Facet '%s' created", name)
+	}
+	if settings.Default == name {
+		ui.Info("It is shown to every peer without an assigned facet")
+	} else {
+		ui.Info("Show it to a contact with 'peerchat-cli profile assign <contact> %s'", name)
+	}
+	return nil
+}
+
+// RunProfileFacetRemove handles the profile facet remove command
+func RunProfileFacetRemove(cmd *cobra.Command, args []string) error {
+	_, path, settings, err := loadProfileSettings()
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToLower(args[0])
+	if err := settings.RemoveFacet(name); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if err := saveProfileSettings(path, settings); err != nil {
+		return err
+	}
+
+	ui.Success("Facet '%s' removed", name)
+	if settings.Default == "" && len(settings.Facets) > 0 {
+		ui.Warn("No default facet: peers without an assignment see no profile")
+	}
+	return nil
+}
+
+// RunProfileDefault handles the profile default command
+func RunProfileDefault(cmd *cobra.Command, args []string) error {
+	_, path, settings, err := loadProfileSettings()
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToLower(args[0])
+	if name == "none" {
+		name = ""
+	}
+	if err := settings.SetDefault(name); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if err := saveProfileSettings(path, settings); err != nil {
+		return err
+	}
+
+	if name == "" {
+		ui.Success("Peers without an assigned facet now see no profile")
+	} else {
+		ui.Success("Peers without an assigned facet now see '%s'", name)
+	}
+	return nil
+}
+
+// RunProfileAssign handles the profile assign command
+func RunProfileAssign(cmd *cobra.Command, args []string) error {
+	dataDir, path, settings, err := loadProfileSettings()
+	if err != nil {
+		return err
+	}
+
+	target := args[0]
+	peerID := resolveContactName(dataDir, target)
+	if _, err := peer.Decode(peerID); err != nil {
+		ui.Error("%s is neither a contact nor a peer ID", target)
+		return peerNotFoundError(err)
+	}
+
+	name := strings.ToLower(args[1])
+	if name == "default" {
+		name = ""
+	}
+	if err := settings.Assign(peerID, name); err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if err := saveProfileSettings(path, settings); err != nil {
+		return err
+	}
+
+	if name == "" {
+		ui.Success("%s now sees the default facet", target)
+	} else {
+		ui.Success("%s now sees the '%s' facet", target, name)
+	}
+	ui.Info("A running node answers with it from the next profile request")
+	return nil
+}
+
+// RunProfileList handles the profile list command
+func RunProfileList(cmd *cobra.Command, args []string) error {
+	dataDir, _, settings, err := loadProfileSettings()
+	if err != nil {
+		return err
+	}
+
+	if len(settings.Facets) == 0 {
+		ui.Println("👤 No profile facets; peers see no profile")
+		ui.Info("Create one with 'peerchat-cli profile facet set work --display-name \"Jane Doe\" --field email=jane@example.com'")
+		return nil
+	}
+
+	ui.Println("👤 Profile facets:")
+	for _, name := range settings.FacetNames() {
+		facet := settings.Facets[name]
+		marker := ""
+		if name == settings.Default {
+			marker = " (default)"
+		}
+		ui.Printf("  %s%s\n", name, marker)
+		if facet.DisplayName != "" {
+			ui.Printf("    Name:   %s\n", facet.DisplayName)
+		}
+		if facet.Avatar != "" {
+			ui.Printf("    Avatar: %s\n", facet.Avatar)
+		}
+		printProfileFields(ui.Printer{}, facet.Fields, "    ")
+	}
+
+	if len(settings.Assigned) > 0 {
+		names := contactNames(dataDir)
+		peers := make([]string, 0, len(settings.Assigned))
+		for peerID := range settings.Assigned {
+			peers = append(peers, peerID)
+		}
+		sort.Strings(peers)
+
+		ui.Println()
+		ui.Println("🔐 Shown to:")
+		for _, peerID := range peers {
+			who := shortPeerID(peerID)
+			if name := names[peerID]; name != "" {
+				who = name
+			}
+			ui.Printf("  %-16s %s\n", who, settings.Assigned[peerID])
+		}
+	}
+	if settings.Default == "" {
+		ui.Println()
+		ui.Warn("No default facet: peers without an assignment see no profile")
+	}
+	return nil
+}
+
+// printProfileFields prints profile fields sorted by name
+func printProfileFields(out ui.Printer, fields map[string]string, indent string) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		out.Printf("%s%-7s %s\n", indent, key+":", fields[key])
+	}
+}
+
+// RunProfile handles the profile command: with a peer it fetches the
+// profile the peer discloses to us, otherwise it lists our facets
+func RunProfile(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return RunProfileList(cmd, args)
+	}
+	target := args[0]
+
+	// Without IPC the request needs its own node, which cannot share the
+	// identity's port with a running one
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Warn("A node is already running")
+		ui.Info("In its chat, use: /profile %s", target)
+		return generalError(errNodeRunning)
+	}
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	ui.Println("🔧 Initializing P2P node...")
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+
+	if wrapper.IsUsingSimulation() {
+		ui.Error("Fetching a profile needs real P2P networking")
+		return networkError(errSimulation)
+	}
+
+	peerID, err := wrapper.ResolvePeer(target)
+	if err != nil {
+		ui.Error("Failed to resolve %s: %v", target, err)
+		return peerNotFoundError(err)
+	}
+
+	ui.Printf("🔗 Connecting to %s...\n", target)
+	if !wrapper.ConnectToPeer(peerID) {
+		ui.Error("Peer is not reachable")
+		return peerNotFoundError(errPeerUnreachable)
+	}
+
+	return fetchAndPrintProfile(ui.Printer{}, wrapper, target, peerID)
+}
+
+// handleProfileCommand handles the /profile chat command
+func handleProfileCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 {
+		out.Error("Usage: /profile <@name|peer_id>")
+		out.Info("'peerchat-cli profile' lists the facets you show others")
+		return generalError(errUsage)
+	}
+
+	peerID, err := wrapper.ResolvePeer(args[0])
+	if err != nil {
+		out.Error("Failed to resolve %s: %v", args[0], err)
+		return peerNotFoundError(err)
+	}
+	fetchAndPrintProfile(out, wrapper, args[0], peerID)
+	return nil
+}
+
+// fetchAndPrintProfile shows the profile a peer discloses and keeps its
+// avatar in the avatars directory
+func fetchAndPrintProfile(out ui.Printer, wrapper *p2p.P2PWrapper, target, peerID string) error {
+	profile, err := wrapper.FetchProfile(peerID)
+	if err != nil {
+		out.Error("Failed to fetch profile: %v", err)
+		return peerRefusedError(err)
+	}
+
+	out.Printf("👤 Profile of %s:\n", target)
+	printProfileTraffic(out, wrapper, peerID)
+	if profile.IsEmpty() {
+		out.Println("  (The peer shares no profile with you)")
+		return nil
+	}
+	if profile.DisplayName != "" {
+		out.Printf("  Name:   %s\n", profile.DisplayName)
+	}
+	printProfileFields(out, profile.Fields, "  ")
+
+	if len(profile.Avatar) > 0 {
+		dataDir, _, err := getProfilesPath()
+		if err != nil {
+			return nil
+		}
+		path := filepath.Join(dataDir, AvatarsDirName, peerID+avatarExtension(profile.AvatarType))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = os.WriteFile(path, profile.Avatar, 0600)
+		}
+		if err != nil {
+			out.Warn("Failed to save avatar: %v", err)
+			return nil
+		}
+		out.Printf("  Avatar: %s (%s)\n", path, formatBytes(int64(len(profile.Avatar))))
+	}
+	return nil
+}
+
+// avatarExtension returns the file extension for an avatar's content type
+func avatarExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".bin"
+	}
+}
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// allowDirectFlag keeps LAN discovery and direct connections over a
+	// SOCKS proxy
+	allowDirectFlag = "i-know-what-im-doing"
+
+	// ephemeralDHTFlag runs the DHT under a throwaway identity
+	ephemeralDHTFlag = "ephemeral-dht"
+
+	// fileStreamsFlag limits the streams a large file is split across
+	fileStreamsFlag = "file-streams"
+
+	// fileCompressionFlag turns zstd compression of file chunks on or off
+	fileCompressionFlag = "file-compression"
+
+	// quicMTUFlag sizes QUIC packets
+	quicMTUFlag = "quic-mtu"
+
+	// wakeRelayFlag names the wake host the node stays registered with
+	wakeRelayFlag = "wake-relay"
+
+	// serveWakeFlag lets peers register with the node to be woken
+	serveWakeFlag = "serve-wake"
+
+	// pprofFlag serves the diagnostics endpoint for this run; it is hidden,
+	// being meant for those asked for profiles in a bug report
+	pprofFlag = "pprof"
+
+	// batterySaverFlag starts the node in battery saver mode
+	batterySaverFlag = "battery-saver"
+
+	// storageFlag selects the storage backend
+	storageFlag = "storage"
+
+	// visibilityFlag sets who discovery announces the node to
+	visibilityFlag = "visibility"
+
+	// maxConnectionsFlag sets the connection high watermark
+	maxConnectionsFlag = "max-connections"
+
+	// browserFlag listens for connections from browser clients
+	browserFlag = "browser"
+
+	// torFlag sends every connection through Tor, with the daemon's SOCKS
+	// and control ports given by torSOCKSFlag and torControlFlag
+	torFlag        = "tor"
+	torSOCKSFlag   = "tor-socks"
+	torControlFlag = "tor-control"
+)
+
+// newP2PWrapper creates the wrapper for a real node started by cmd, which
+// reloads the configuration file on SIGHUP as long as ctx lasts
+func newP2PWrapper(ctx context.Context, cmd *cobra.Command) *p2p.P2PWrapper {
+	wrapper := configureP2PWrapper(ctx, cmd)
+	watchConfigReload(ctx, cmd, wrapper)
+	return wrapper
+}
+
+// configureP2PWrapper creates the wrapper for a real node started by cmd,
+// with the privacy options given on the command line and the configuration
+// file. When a SOCKS proxy such as Tor is configured it warns that LAN
+// discovery and direct connections are disabled, unless
+// --i-know-what-im-doing keeps them.
+func configureP2PWrapper(ctx context.Context, cmd *cobra.Command) *p2p.P2PWrapper {
+	wrapper := p2p.NewP2PWrapper(ctx, false)
+	if ephemeral, _ := cmd.Flags().GetBool(ephemeralDHTFlag); ephemeral {
+		wrapper.UseEphemeralDHTIdentity()
+	}
+	if streams, _ := cmd.Flags().GetInt(fileStreamsFlag); streams > 0 {
+		wrapper.SetFileStreams(streams)
+	}
+	if compress, err := cmd.Flags().GetBool(fileCompressionFlag); err == nil && !compress {
+		wrapper.DisableFileCompression()
+	}
+	switch mode, _ := cmd.Flags().GetString(quicMTUFlag); mode {
+	case "", p2p.QUICMTUAuto:
+	case p2p.QUICMTUSafe:
+		wrapper.SetQUICMTU(mode)
+	default:
+		ui.Warn("Unknown --%s %q, using %s", quicMTUFlag, mode, p2p.QUICMTUAuto)
+	}
+	if target, _ := cmd.Flags().GetString(traceFlag); target != "" {
+		wrapper.SetTrace(resolveTraceTarget(target))
+	}
+	if relay, _ := cmd.Flags().GetString(wakeRelayFlag); relay != "" {
+		wrapper.SetWakeRelay(relay)
+	}
+	if serve, _ := cmd.Flags().GetBool(serveWakeFlag); serve {
+		wrapper.ServeWake()
+	}
+	switch port, _ := cmd.Flags().GetInt(pprofFlag); {
+	case port > 0 && port <= 65535:
+		wrapper.ServeDiagnostics(port)
+	case port != 0:
+		ui.Warn("Ignoring --%s=%d: the port must be between 1 and 65535", pprofFlag, port)
+	}
+	if saver, _ := cmd.Flags().GetBool(batterySaverFlag); saver {
+		wrapper.SetBatterySaver(true)
+	}
+	if backend, _ := cmd.Flags().GetString(storageFlag); backend != "" {
+		wrapper.SetStorage(backend)
+	}
+	if level, _ := cmd.Flags().GetString(visibilityFlag); level != "" {
+		wrapper.SetVisibility(level)
+	}
+	if n, _ := cmd.Flags().GetInt(maxConnectionsFlag); n != p2p.DefaultConnHighWater {
+		wrapper.SetMaxConnections(n)
+	}
+	if !applyConfigFile(cmd, wrapper) {
+		ui.Println("🔄 Not joining the public network instead, falling back to simulation mode")
+		return p2p.NewP2PWrapper(ctx, true)
+	}
+	if browser, _ := cmd.Flags().GetBool(browserFlag); browser {
+		wrapper.EnableBrowserTransports()
+	}
+	if tor, _ := cmd.Flags().GetBool(torFlag); tor {
+		socks, _ := cmd.Flags().GetString(torSOCKSFlag)
+		control, _ := cmd.Flags().GetString(torControlFlag)
+		wrapper.UseTor(socks, control)
+		ui.Println("🧅 Tor mode: connections go through Tor, mDNS, UDP broadcast, QUIC and direct connections are disabled")
+		ui.Info("Peers reach you at your onion address, shown by 'status'")
+		return wrapper
+	}
+	if !p2p.ProxyFromEnvironment().IsSOCKS() {
+		return wrapper
+	}
+
+	if allow, _ := cmd.Flags().GetBool(allowDirectFlag); allow {
+		wrapper.AllowDirectWithProxy()
+		ui.Warn("SOCKS proxy configured, but --i-know-what-im-doing keeps LAN discovery and direct connections")
+		ui.Warn("Your IP address may be revealed to peers on the LAN and to anyone you connect to directly")
+	} else {
+		ui.Warn("SOCKS proxy configured (Tor?): mDNS, UDP broadcast, QUIC and direct connections are disabled")
+		ui.Info("Peers are reached through the proxy only, so your IP address is not revealed")
+		ui.Info("Use --%s to allow them anyway", allowDirectFlag)
+	}
+	ui.Println()
+	return wrapper
+}
@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+)
+
+// formatPeerQuality describes the quality of the connections to a peer for
+// /peers, or returns "" when nothing is known
+func formatPeerQuality(wrapper *p2p.P2PWrapper, peerID string) string {
+	q, err := wrapper.PeerQuality(peerID)
+	if err != nil || (q.Dials == 0 && q.Direct+q.Relayed == 0) {
+		return ""
+	}
+
+	details := fmt.Sprintf("%d/%d dials failed", q.Failures, q.Dials)
+	if q.RTT > 0 {
+		details = fmt.Sprintf("connects in %s, %s", q.RTT.Round(time.Millisecond), details)
+	}
+	if q.Relayed > 0 {
+		details += fmt.Sprintf(", %d of %d connections relayed", q.Relayed, q.Direct+q.Relayed)
+	}
+	return fmt.Sprintf(" quality %.0f (%s)", q.Score, details)
+}
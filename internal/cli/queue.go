@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getTransferQueuePath returns the data directory and the transfer queue path
+func getTransferQueuePath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, message.TransferQueueFileName), nil
+}
+
+// RunSendFile handles the send-file command. The file is queued on disk; a
+// node sends it whenever the peer is connected, so the peer may be offline
+// and the node need not be running yet.
+func RunSendFile(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getTransferQueuePath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	peerID, err := resolvePeerTarget(dataDir, args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		return peerNotFoundError(err)
+	}
+	if contacts, err := user.LoadContactBook(filepath.Join(dataDir, user.ContactsFileName)); err == nil {
+		if err := contacts.CheckSendAllowed(peerID); err != nil {
+			ui.Warn("%v", err)
+			return permissionError(err)
+		}
+	}
+
+	ui.Printf("🔍 Hashing %s...\n", args[1])
+	q, err := message.NewQueuedTransfer(peerID, args[1])
+	if err != nil {
+		ui.Error("%v", err)
+		ui.Info("Use sync-dir to send a directory")
+		return generalError(err)
+	}
+	if err := message.EnqueueTransfer(path, q); err != nil {
+		ui.Error("Failed to queue file: %v", err)
+		return generalError(err)
+	}
+
+	ui.Printf("📥 Queued %s (%s) for %s, ID %s\n", q.Metadata.Name, formatBytes(q.Metadata.Size), args[0], q.ID())
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Info("The running node sends it within a minute once the peer is connected")
+	} else {
+		ui.Info("It is sent once the node is started and the peer is connected")
+	}
+	return nil
+}
+
+// RunQueueList handles the queue list command
+func RunQueueList(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getTransferQueuePath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	queue, err := message.LoadTransferQueue(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	printQueuedTransfers(ui.Printer{}, queue, contactNames(dataDir))
+	return nil
+}
+
+// printQueuedTransfers lists files waiting to be sent
+func printQueuedTransfers(out ui.Printer, queue []*message.QueuedTransfer, names map[string]string) {
+	if len(queue) == 0 {
+		out.Println("📭 No files queued")
+		return
+	}
+
+	out.Printf("📥 Queued files (%d):\n", len(queue))
+	for _, q := range queue {
+		out.Printf("  %s  %s to %s  %s, queued %s\n", q.ID(), q.Metadata.Name,
+			peerLabel(names, q.PeerID), formatBytes(q.Metadata.Size), q.QueuedAt.Format("2006-01-02 15:04"))
+		if q.BytesAcked > 0 {
+			out.Printf("      %s already received by the peer\n", formatBytes(q.BytesAcked))
+		}
+		if q.LastError != "" {
+			out.Printf("      ⚠️  %d attempt(s), last failed %s: %s\n", q.Attempts, q.LastAttempt.Format("2006-01-02 15:04"), q.LastError)
+		}
+	}
+}
+
+// RunQueueRemove handles the queue remove command
+func RunQueueRemove(cmd *cobra.Command, args []string) error {
+	_, path, err := getTransferQueuePath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	removed, err := message.RemoveQueuedTransfer(path, args[0])
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if !removed {
+		ui.Error("No queued file with ID %s", args[0])
+		return generalError(fmt.Errorf("no queued file with ID %s", args[0]))
+	}
+	ui.Success("Removed %s from the queue", args[0])
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Info("If the running node is sending it now, cancel it in the chat with /transfer cancel")
+	}
+	return nil
+}
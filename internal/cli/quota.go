@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getQuotasPath returns the data directory and the quotas path
+func getQuotasPath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, message.QuotasFileName), nil
+}
+
+// RunQuotaShow handles the quota show command
+func RunQuotaShow(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getQuotasPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	quotas, err := message.LoadQuotas(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	downloads := message.DirUsage(filepath.Join(dataDir, "downloads"), filepath.Join(dataDir, message.AttachmentsDirName))
+	var offline int64
+	if info, err := os.Stat(filepath.Join(dataDir, message.OfflineDirName, message.OfflineStoreFileName)); err == nil {
+		offline = info.Size()
+	}
+
+	ui.Println("💾 Disk quotas:")
+	printQuotaUsage("Downloads", downloads, quotas.Downloads)
+	printQuotaUsage("Offline queue", offline, quotas.Offline)
+	return nil
+}
+
+// RunQuotaSet handles the quota set command
+func RunQuotaSet(cmd *cobra.Command, args []string) error {
+	downloads, _ := cmd.Flags().GetString("downloads")
+	offline, _ := cmd.Flags().GetString("offline")
+
+	if downloads == "" && offline == "" {
+		ui.Error("Give --downloads and/or --offline")
+		return generalError(errors.New("no quota given"))
+	}
+
+	_, path, err := getQuotasPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	quotas, err := message.LoadQuotas(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	// Only the given quotas change
+	if downloads != "" {
+		if quotas.Downloads, err = message.ParseSize(downloads); err != nil {
+			ui.Error("%v", err)
+			return generalError(err)
+		}
+	}
+	if offline != "" {
+		if quotas.Offline, err = message.ParseSize(offline); err != nil {
+			ui.Error("%v", err)
+			return generalError(err)
+		}
+	}
+
+	if err := message.SaveQuotas(path, quotas); err != nil {
+		ui.Error("Failed to save quotas: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Quotas: downloads %s, offline queue %s", formatQuota(quotas.Downloads), formatQuota(quotas.Offline))
+	ui.Info("A running node applies the new quotas to the next transfer")
+	return nil
+}
+
+// printQuotaUsage prints the space used against a quota
+func printQuotaUsage(label string, used, quota int64) {
+	if quota <= 0 {
+		ui.Printf("  %s: %s (no limit)\n", label, formatBytes(used))
+		return
+	}
+	ui.Printf("  %s: %s of %s (%d%%)\n", label, formatBytes(used), formatBytes(quota), used*100/quota)
+}
+
+// formatQuota formats a quota for display
+func formatQuota(quota int64) string {
+	if quota <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(quota)
+}
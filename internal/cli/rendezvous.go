@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+)
+
+// handleRendezvousCommand handles /rendezvous [join|leave|find <key>]
+func handleRendezvousCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 {
+		keys := wrapper.Rendezvous()
+		out.Println("🧭 Rendezvous keys:")
+		if len(keys) == 0 {
+			out.Println("  (None - peers are found on the LAN and through contacts only)")
+		}
+		for _, key := range keys {
+			out.Printf("  %s\n", key)
+		}
+		out.Info("Use '/rendezvous join <key>' to find peers that use the same key")
+		return nil
+	}
+	if len(args) < 2 {
+		out.Error("Usage: /rendezvous [join|leave|find <key>]")
+		return generalError(errUsage)
+	}
+
+	key := args[1]
+	switch args[0] {
+	case "join":
+		if err := wrapper.JoinRendezvous(key); err != nil {
+			out.Error("Failed to join rendezvous: %v", err)
+			return networkError(err)
+		}
+		out.Printf("🧭 Joined rendezvous %s\n", key)
+		if wrapper.Visibility() == p2p.VisibilityInvisible {
+			out.Warn("You are invisible: peers are looked up under the key, but you are not announced")
+		}
+		out.Info("Add it to 'rendezvous' in config.yaml to join it on every start")
+
+	case "leave":
+		if !wrapper.LeaveRendezvous(key) {
+			out.Error("Not joined to rendezvous %s", key)
+			return generalError(fmt.Errorf("not joined to rendezvous %s", key))
+		}
+		out.Success("Left rendezvous %s", key)
+		out.Info("Announcements already in the DHT expire on their own within a couple of days")
+
+	case "find":
+		out.Printf("🔍 Looking up peers under %s...\n", key)
+		peers, err := wrapper.FindRendezvous(key)
+		if err != nil {
+			out.Error("Failed to search rendezvous: %v", err)
+			return networkError(err)
+		}
+		if len(peers) == 0 {
+			out.Println("  (No peers found)")
+			return nil
+		}
+		for _, info := range peers {
+			out.Printf("  %s\n", info.ID)
+		}
+		out.Printf("📊 Found %d peer(s), connecting to them\n", len(peers))
+
+	default:
+		out.Error("Usage: /rendezvous [join|leave|find <key>]")
+		return generalError(errUsage)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+)
+
+// printResourceStats shows the latest sample of the node's resource use for
+// /stats
+func printResourceStats(out ui.Printer, wrapper *p2p.P2PWrapper) error {
+	sample, err := wrapper.ResourceStats()
+	if err != nil {
+		out.Error("Resource stats are not available: %v", err)
+		return generalError(err)
+	}
+
+	out.Printf("📊 Resources (sampled %s ago, every %s):\n",
+		time.Since(sample.Time).Round(time.Second), p2p.ResourceSampleInterval)
+	rss := formatBytes(int64(sample.RSSBytes))
+	if sample.RSSApprox {
+		rss = "~" + rss + " (Go runtime)"
+	}
+	out.Printf("  Memory:      %s RSS, %s heap (target %d MB idle)\n",
+		rss, formatBytes(int64(sample.HeapBytes)), p2p.MaxIdleMemoryMB)
+	out.Printf("  Goroutines:  %d\n", sample.Goroutines)
+	out.Printf("  Streams:     %d open\n", sample.OpenStreams)
+	out.Printf("  Connections: %s\n", formatConnectionCounts(sample.Connections))
+	out.Printf("  Messages:    %d sent, %d received\n", sample.MessagesSent, sample.MessagesReceived)
+	if sample.Window > 0 {
+		out.Printf("  Throughput:  %.2f msg/s out, %.2f msg/s in, %s/s up, %s/s down (last %s)\n",
+			sample.SentPerSecond, sample.ReceivedPerSecond,
+			formatBytes(int64(sample.BytesOutPerSecond)), formatBytes(int64(sample.BytesInPerSecond)),
+			sample.Window.Round(time.Second))
+	} else {
+		out.Println("  Throughput:  measured from the next sample")
+	}
+	out.Info("/stats commands shows how often you used each command")
+	return nil
+}
+
+// formatConnectionCounts lists open connections by transport, busiest
+// first
+func formatConnectionCounts(counts map[string]int) string {
+	total := 0
+	transports := make([]string, 0, len(counts))
+	for transport, n := range counts {
+		total += n
+		transports = append(transports, transport)
+	}
+	if total == 0 {
+		return "none"
+	}
+	sort.Slice(transports, func(i, j int) bool {
+		if counts[transports[i]] != counts[transports[j]] {
+			return counts[transports[i]] > counts[transports[j]]
+		}
+		return transports[i] < transports[j]
+	})
+
+	parts := make([]string, len(transports))
+	for i, transport := range transports {
+		parts[i] = fmt.Sprintf("%d %s", counts[transport], transport)
+	}
+	return fmt.Sprintf("%d (%s)", total, strings.Join(parts, ", "))
+}
@@ -0,0 +1,199 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getRetentionPath returns the data directory and the retention policy path
+func getRetentionPath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, db.RetentionFileName), nil
+}
+
+// RunRetentionShow handles the retention show command
+func RunRetentionShow(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getRetentionPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	policy, err := db.LoadRetentionPolicy(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	ui.Println("🗑️  Message retention:")
+	ui.Printf("  Default: %s\n", policy.Default)
+	if len(policy.Peers) == 0 {
+		return nil
+	}
+
+	names := contactNames(dataDir)
+	peers := make([]string, 0, len(policy.Peers))
+	for peerID := range policy.Peers {
+		peers = append(peers, peerID)
+	}
+	sort.Strings(peers)
+	for _, peerID := range peers {
+		label := shortPeerID(peerID)
+		if name := names[peerID]; name != "" {
+			label = name
+		}
+		ui.Printf("  %s: %s\n", label, policy.Peers[peerID])
+	}
+	return nil
+}
+
+// RunRetentionSet handles the retention set command
+func RunRetentionSet(cmd *cobra.Command, args []string) error {
+	days, _ := cmd.Flags().GetInt("days")
+	messages, _ := cmd.Flags().GetInt("messages")
+	forever, _ := cmd.Flags().GetBool("forever")
+
+	if forever == (days > 0 || messages > 0) {
+		ui.Error("Give --days and/or --messages, or --forever")
+		return generalError(errors.New("no retention limit given"))
+	}
+	if days < 0 || messages < 0 {
+		ui.Error("Limits must be positive")
+		return generalError(errors.New("negative retention limit"))
+	}
+
+	dataDir, path, err := getRetentionPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	policy, err := db.LoadRetentionPolicy(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	rule := db.RetentionRule{KeepDays: days, KeepMessages: messages}
+	target := "all conversations"
+	if len(args) > 0 {
+		peerID := resolveContactName(dataDir, args[0])
+		policy.Peers[peerID] = rule
+		target = args[0]
+	} else {
+		policy.Default = rule
+	}
+
+	if err := db.SaveRetentionPolicy(path, policy); err != nil {
+		ui.Error("Failed to save retention policy: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Retention for %s: %s", target, rule)
+	if !rule.Forever() {
+		ui.Info("A running node deletes expired messages within an hour;")
+		ui.Println("   run 'peerchat-cli retention prune' to delete them now")
+	}
+	return nil
+}
+
+// RunRetentionClear handles the retention clear command
+func RunRetentionClear(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getRetentionPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	policy, err := db.LoadRetentionPolicy(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	peerID := resolveContactName(dataDir, args[0])
+	if _, ok := policy.Peers[peerID]; !ok {
+		ui.Error("No retention rule for %s", args[0])
+		return generalError(fmt.Errorf("no retention rule for %s", args[0]))
+	}
+	delete(policy.Peers, peerID)
+
+	if err := db.SaveRetentionPolicy(path, policy); err != nil {
+		ui.Error("Failed to save retention policy: %v", err)
+		return generalError(err)
+	}
+	ui.Success("%s now follows the default: %s", args[0], policy.Default)
+	return nil
+}
+
+// RunRetentionPrune handles the retention prune command
+func RunRetentionPrune(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getRetentionPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	policy, err := db.LoadRetentionPolicy(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if history == nil {
+		ui.Println("📜 No message history yet")
+		return nil
+	}
+	defer closeHistory()
+
+	deleted, err := history.PruneHistory(policy, time.Now())
+	if err != nil {
+		ui.Error("Failed to prune history: %v", err)
+		return generalError(err)
+	}
+	ui.Printf("🗑️  Deleted %d expired message(s)\n", deleted)
+	return nil
+}
+
+// RunRetentionCompact handles the retention compact command
+func RunRetentionCompact(cmd *cobra.Command, args []string) error {
+	dataDir, _, err := getRetentionPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if history == nil {
+		ui.Println("📜 No message history yet")
+		return nil
+	}
+	defer closeHistory()
+
+	result, err := history.Compact()
+	if err != nil {
+		ui.Error("Failed to compact history: %v", err)
+		return generalError(err)
+	}
+	ui.Success("Moved %d message body(ies) to the blob store, removed %d unused blob(s)",
+		result.Moved, result.BlobsRemoved)
+	ui.Printf("   Database: %s → %s\n", formatBytes(result.SizeBefore), formatBytes(result.SizeAfter))
+	return nil
+}
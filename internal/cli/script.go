@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// scriptDrainTimeout is how long a script waits for the messages it sent to
+// be delivered or queued before stopping the node
+const scriptDrainTimeout = 10 * time.Second
+
+// RunScript runs chat commands from a file, stdin or --exec, and fails when
+// one of them does
+func RunScript(cmd *cobra.Command, args []string) error {
+	exec, _ := cmd.Flags().GetString("exec")
+	keepGoing, _ := cmd.Flags().GetBool("keep-going")
+
+	var commands []string
+	switch {
+	case exec != "" && len(args) == 0:
+		commands = ParseExec(exec)
+	case exec == "" && len(args) == 1:
+		data, err := readScript(args[0])
+		if err != nil {
+			ui.Error("Failed to read script: %v", err)
+			return generalError(err)
+		}
+		commands = ParseScript(string(data))
+	default:
+		ui.Error("Usage: peerchat-cli script <file|-> or peerchat-cli script --exec \"cmd; cmd\"")
+		return generalError(errors.New("no script given"))
+	}
+	if len(commands) == 0 {
+		ui.Error("The script has no commands")
+		return generalError(errors.New("empty script"))
+	}
+
+	// Commands go to the running node; without one the script starts its own
+	target, err := openScriptTarget(cmd)
+	if err != nil {
+		return err
+	}
+	defer target.close()
+
+	failed, ran := 0, 0
+	var firstErr error
+	for _, command := range commands {
+		if command == "/quit" || command == "/exit" {
+			break
+		}
+
+		ui.Printf("▶ %s\n", command)
+		ran++
+		if err := target.run(command); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			if !keepGoing {
+				ui.Error("Stopping at failed command: %s", command)
+				break
+			}
+		}
+	}
+	if err := target.drain(); err != nil {
+		ui.Warn("Messages may not have been delivered yet: %v", err)
+	}
+
+	ui.Printf("📊 %d of %d command(s) succeeded\n", ran-failed, len(commands))
+	if failed > 0 {
+		return &ExitError{Code: ExitCode(firstErr), Err: fmt.Errorf("%d command(s) failed: %w", failed, firstErr)}
+	}
+	return nil
+}
+
+// scriptTarget is the node a script's commands run on: the running node,
+// reached through its control socket, or one started for the script
+type scriptTarget struct {
+	wrapper  *p2p.P2PWrapper // Nil for the running node
+	nodeInfo *p2p.NodeInfo
+}
+
+// openScriptTarget finds the running node, or starts one for the script
+func openScriptTarget(cmd *cobra.Command) (*scriptTarget, error) {
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		return &scriptTarget{}, nil
+	}
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	ui.Println("🔧 Initializing P2P node...")
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return nil, networkError(err)
+	}
+	target := &scriptTarget{wrapper: wrapper, nodeInfo: wrapper.GetNodeInfo()}
+	if wrapper.IsUsingSimulation() {
+		target.close()
+		ui.Error("Scripts need real P2P networking")
+		return nil, networkError(errSimulation)
+	}
+	return target, nil
+}
+
+// run runs one chat command or message. A command run on the running node
+// prints what it printed there.
+func (t *scriptTarget) run(command string) error {
+	if t.wrapper != nil {
+		return handleChatInput(ui.Printer{}, command, t.wrapper, t.nodeInfo)
+	}
+
+	output, err := callControl(context.Background(), "chat", command)
+	ui.Print(output)
+	// A command that failed on the node printed why; failing to reach the
+	// node did not
+	if err != nil && output == "" {
+		ui.Error("%v", err)
+		if ExitCode(err) == ExitNetwork {
+			ui.Info("Restart the node so scripts can reach it")
+		}
+	}
+	return err
+}
+
+// drain waits until the messages sent were delivered or queued for offline
+// delivery, or scriptDrainTimeout passes
+func (t *scriptTarget) drain() error {
+	if t.wrapper != nil {
+		waitForOutbox(t.wrapper, scriptDrainTimeout)
+		return nil
+	}
+	_, err := callControl(context.Background(), "drain")
+	return err
+}
+
+// close stops the node started for the script
+func (t *scriptTarget) close() {
+	if t.wrapper == nil {
+		return
+	}
+	if err := t.wrapper.Stop(); err != nil {
+		ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+	}
+}
+
+// readScript reads a script file, or stdin for "-"
+func readScript(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// ParseScript returns the chat commands of a script, one per line, leaving
+// out blank lines and # comments. A semicolon is part of its line, so
+// messages may contain one.
+func ParseScript(text string) []string {
+	var commands []string
+	for _, line := range strings.Split(text, "\n") {
+		command := strings.TrimSpace(line)
+		if command != "" && !strings.HasPrefix(command, "#") {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// ParseExec returns the chat commands given with --exec, separated by
+// semicolons or newlines
+func ParseExec(text string) []string {
+	return ParseScript(strings.ReplaceAll(text, ";", "\n"))
+}
+
+// waitForOutbox waits until the messages sent were delivered or queued for
+// offline delivery, or the timeout passes
+func waitForOutbox(wrapper *p2p.P2PWrapper, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-wrapper.OutboxDrained():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+)
+
+// handleResetSessionCommand discards the encrypted sessions with a peer and
+// starts a new one, for when messages can no longer be decrypted
+func handleResetSessionCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) != 1 {
+		out.Error("Usage: /reset-session <peer|contact|@name>")
+		return generalError(errUsage)
+	}
+
+	peerID, err := ResolveChatPeer(wrapper, args[0])
+	if err != nil {
+		out.Error("Failed to resolve %s: %v", args[0], err)
+		return peerNotFoundError(err)
+	}
+
+	session, err := wrapper.ResetSession(peerID)
+	if session == "" {
+		out.Error("Failed to reset session: %v", err)
+		return generalError(err)
+	}
+	out.Printf("🔑 New session %s with %s\n", session, args[0])
+	if err != nil {
+		out.Warn("%v", err)
+		return nil
+	}
+	out.Info("The peer was told to drop the old session; messages sealed in it are refused from now on")
+	return nil
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// starredLimit bounds how many starred messages are listed
+const starredLimit = 500
+
+// RunStar handles the star command
+func RunStar(cmd *cobra.Command, args []string) error {
+	return setStar(args[0], true)
+}
+
+// RunUnstar handles the unstar command
+func RunUnstar(cmd *cobra.Command, args []string) error {
+	return setStar(args[0], false)
+}
+
+// setStar stars or unstars a message in the local history
+func setStar(id string, starred bool) error {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if history == nil {
+		ui.Println("📜 No message history yet")
+		return generalError(fmt.Errorf("no message %s", id))
+	}
+	defer closeHistory()
+
+	fullID, err := history.StarMessage(id, starred)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	printStarResult(ui.Printer{}, fullID, starred)
+	return nil
+}
+
+// printStarResult confirms a star change
+func printStarResult(out ui.Printer, id string, starred bool) {
+	if starred {
+		out.Printf("⭐ Starred message %s\n", id)
+		out.Info("Starred messages are kept by the retention rules")
+	} else {
+		out.Success("Unstarred message %s", id)
+	}
+}
+
+// RunStarred handles the starred command
+func RunStarred(cmd *cobra.Command, args []string) error {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	query := db.HistoryQuery{Starred: true, Limit: starredLimit, Ascending: true}
+	if len(args) > 0 {
+		query.PeerID = resolveContactName(dataDir, args[0])
+	}
+
+	history, closeHistory, err := openLocalHistory(dataDir)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if history == nil {
+		ui.Println("📜 No message history yet")
+		return nil
+	}
+	defer closeHistory()
+
+	entries, err := history.QueryHistory(query)
+	if err != nil {
+		ui.Error("Failed to query history: %v", err)
+		return generalError(err)
+	}
+	printStarred(ui.Printer{}, entries, contactNames(dataDir))
+	return nil
+}
+
+// printStarred prints starred messages grouped by conversation, oldest first
+func printStarred(out ui.Printer, entries []*db.HistoryEntry, names map[string]string) {
+	if len(entries) == 0 {
+		out.Println("⭐ No starred messages")
+		out.Info("Star one with '/star <message_id>'; IDs are shown by history and search")
+		return
+	}
+
+	conversations := make(map[string][]*db.HistoryEntry)
+	for _, entry := range entries {
+		conversations[entry.PeerID] = append(conversations[entry.PeerID], entry)
+	}
+	peers := make([]string, 0, len(conversations))
+	for peerID := range conversations {
+		peers = append(peers, peerID)
+	}
+	sort.Strings(peers)
+
+	out.Printf("⭐ %d starred message(s):\n", len(entries))
+	for _, peerID := range peers {
+		label := shortPeerID(peerID)
+		if name := names[peerID]; name != "" {
+			label = name
+		}
+		out.Printf("  %s:\n", label)
+		for _, entry := range conversations[peerID] {
+			arrow := "←"
+			if entry.Outgoing {
+				arrow = "→"
+			}
+			out.Printf("    [%s] %s %s  id:%s\n",
+				entry.Timestamp.Format("2006-01-02 15:04"),
+				arrow,
+				historySummary(entry),
+				shortMessageID(entry.ID))
+		}
+	}
+}
+
+// shortMessageID abbreviates a message ID to a prefix star and unstar accept
+func shortMessageID(id string) string {
+	if len(id) <= 8 {
+		return id
+	}
+	return id[:8]
+}
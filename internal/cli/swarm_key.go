@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// RunSwarmKeyGenerate handles the swarm-key generate command
+func RunSwarmKeyGenerate(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("out")
+	if path == "" {
+		config, err := configFilePath(cmd)
+		if err != nil {
+			ui.Error("Failed to find home directory: %v", err)
+			return configError(err)
+		}
+		path = filepath.Join(filepath.Dir(config), p2p.SwarmKeyFileName)
+	}
+	if force, _ := cmd.Flags().GetBool("force"); !force {
+		if _, err := os.Stat(path); err == nil {
+			ui.Error("%s exists; replacing it cuts this node off from its private network", path)
+			ui.Info("Use --force to replace it anyway")
+			return generalError(fmt.Errorf("%s exists", path))
+		}
+	}
+
+	data, err := p2p.GenerateSwarmKey()
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	psk, err := p2p.ParseSwarmKey(data)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+	if err := p2p.SaveSwarmKey(path, data); err != nil {
+		ui.Error("Failed to save swarm key: %v", err)
+		return generalError(err)
+	}
+
+	ui.Printf("🔒 Swarm key %s saved to %s\n", p2p.SwarmKeyFingerprint(psk), path)
+	ui.Info("Copy it to every node of the private network over a secure channel; the node joins it on the next start")
+	ui.Warn("Anyone with the file can join the network, keep it secret")
+	return nil
+}
+
+// RunSwarmKeyShow handles the swarm-key show command
+func RunSwarmKeyShow(cmd *cobra.Command, args []string) error {
+	path, err := configFilePath(cmd)
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	config, err := p2p.LoadConfigFile(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return configError(err)
+	}
+	psk, err := configuredSwarmKey(path, config)
+	if err != nil {
+		ui.Error("%v", err)
+		return configError(err)
+	}
+	if psk == nil {
+		ui.Println("🌍 No swarm key: the node joins the public network")
+		return nil
+	}
+	ui.Printf("🔒 Private network, swarm key %s\n", p2p.SwarmKeyFingerprint(psk))
+	ui.Info("Nodes with the same fingerprint can connect to each other")
+	return nil
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+// RunSyncDir handles the sync-dir command
+func RunSyncDir(cmd *cobra.Command, args []string) error {
+	peerID := args[0]
+	dir := args[1]
+
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		ui.Error("%s is not a directory", dir)
+		return generalError(fmt.Errorf("%s is not a directory", dir))
+	}
+
+	// Without IPC the transfer needs its own node, which cannot share the
+	// identity's port with a running one
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Warn("A node is already running")
+		ui.Info("In its chat, use: /sync-dir %s %s", peerID, dir)
+		return generalError(errNodeRunning)
+	}
+
+	ui.Printf("📂 Syncing %s to %s\n", dir, peerID)
+	ui.Println("📝 Logs are written to ~/.xelvra/peerchat.log")
+	ui.Println()
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	ui.Println("🔧 Initializing P2P node...")
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+
+	if wrapper.IsUsingSimulation() {
+		ui.Error("Directory sync needs real P2P networking")
+		return networkError(errSimulation)
+	}
+
+	resolved, err := wrapper.ResolvePeer(peerID)
+	if err != nil {
+		ui.Error("Failed to resolve %s: %v", peerID, err)
+		return peerNotFoundError(err)
+	}
+	if err := wrapper.CheckSendAllowed(resolved); err != nil {
+		ui.Warn("%v", err)
+		return permissionError(err)
+	}
+
+	ui.Printf("🔗 Connecting to %s...\n", peerID)
+	if !wrapper.ConnectToPeer(resolved) {
+		ui.Error("Peer is not reachable")
+		return peerNotFoundError(errPeerUnreachable)
+	}
+
+	ui.Println("🔍 Exchanging file manifest...")
+	result, err := wrapper.SyncDirectory(resolved, dir)
+	if err != nil {
+		ui.Error("Directory sync failed: %v", err)
+		printRefusalHint(ui.Printer{}, err)
+		return peerRefusedError(err)
+	}
+	printDirSyncResult(ui.Printer{}, result)
+	return nil
+}
+
+// printRefusalHint explains what to do when the peer refused a transfer
+func printRefusalHint(out ui.Printer, err error) {
+	if pe, ok := message.AsProtocolError(err); ok {
+		out.Info("%s", pe.Hint())
+	}
+}
+
+// printDirSyncResult prints the summary of a directory transfer
+func printDirSyncResult(out ui.Printer, result *message.DirSyncResult) {
+	out.Success("%s synced: %d file(s)", result.Root, result.Files)
+	out.Printf("  📤 Sent: %d (%s)\n", result.Sent, formatBytes(result.BytesSent))
+	out.Printf("  ⏭️  Already present: %d\n", result.Skipped)
+	if result.Copied > 0 {
+		out.Printf("  📋 Copied from identical files on the peer: %d\n", result.Copied)
+	}
+}
+
+// formatBytes formats a byte count for display
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
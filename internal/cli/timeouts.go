@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/spf13/cobra"
+)
+
+// getTimeoutsPath returns the data directory and the timeouts path
+func getTimeoutsPath() (string, string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", "", err
+	}
+	return dataDir, filepath.Join(dataDir, message.TimeoutsFileName), nil
+}
+
+// RunTimeoutsShow handles the timeouts show command
+func RunTimeoutsShow(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getTimeoutsPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+
+	config, err := message.LoadTimeoutConfig(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	ui.Println("⏱️  Protocol timeouts:")
+	ui.Printf("  Default: %s\n", config.Global())
+	ui.Printf("  Peers without an override get up to %dx (messages) and %dx (files)\n",
+		message.MessageTimeoutRTTs, message.FileTimeoutRTTs)
+	ui.Println("  their measured round-trip time when that is longer")
+	if len(config.Peers) == 0 {
+		return nil
+	}
+
+	names := contactNames(dataDir)
+	peers := make([]string, 0, len(config.Peers))
+	for peerID := range config.Peers {
+		peers = append(peers, peerID)
+	}
+	sort.Strings(peers)
+	for _, peerID := range peers {
+		label := shortPeerID(peerID)
+		if name := names[peerID]; name != "" {
+			label = name
+		}
+		ui.Printf("  %s: %s\n", label, config.For(peerID, 0))
+	}
+	return nil
+}
+
+// RunTimeoutsSet handles the timeouts set command
+func RunTimeoutsSet(cmd *cobra.Command, args []string) error {
+	messageTimeout, _ := cmd.Flags().GetDuration("message")
+	fileTimeout, _ := cmd.Flags().GetDuration("file")
+
+	if messageTimeout == 0 && fileTimeout == 0 {
+		ui.Error("Give --message and/or --file")
+		return generalError(errors.New("no timeout given"))
+	}
+	if messageTimeout < 0 || fileTimeout < 0 {
+		ui.Error("Timeouts must be positive")
+		return generalError(errors.New("negative timeout"))
+	}
+
+	dataDir, path, err := getTimeoutsPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	config, err := message.LoadTimeoutConfig(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	// Only the given timeouts change
+	override := config.TimeoutOverride
+	target, peerID := "all peers", ""
+	if len(args) > 0 {
+		peerID = resolveContactName(dataDir, args[0])
+		override = config.Peers[peerID]
+		target = args[0]
+	}
+	if messageTimeout > 0 {
+		override.Message = message.Duration(messageTimeout)
+	}
+	if fileTimeout > 0 {
+		override.File = message.Duration(fileTimeout)
+	}
+	if peerID != "" {
+		config.Peers[peerID] = override
+	} else {
+		config.TimeoutOverride = override
+	}
+
+	if err := message.SaveTimeoutConfig(path, config); err != nil {
+		ui.Error("Failed to save timeouts: %v", err)
+		return generalError(err)
+	}
+
+	ui.Success("Timeouts for %s: %s", target, config.For(peerID, 0))
+	ui.Info("A running node uses the new timeouts for its next operation")
+	return nil
+}
+
+// RunTimeoutsClear handles the timeouts clear command
+func RunTimeoutsClear(cmd *cobra.Command, args []string) error {
+	dataDir, path, err := getTimeoutsPath()
+	if err != nil {
+		ui.Error("Failed to find home directory: %v", err)
+		return configError(err)
+	}
+	config, err := message.LoadTimeoutConfig(path)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	if len(args) == 0 {
+		config.TimeoutOverride = message.TimeoutOverride{}
+	} else {
+		peerID := resolveContactName(dataDir, args[0])
+		if _, ok := config.Peers[peerID]; !ok {
+			ui.Error("No timeouts configured for %s", args[0])
+			return generalError(fmt.Errorf("no timeouts configured for %s", args[0]))
+		}
+		delete(config.Peers, peerID)
+	}
+
+	if err := message.SaveTimeoutConfig(path, config); err != nil {
+		ui.Error("Failed to save timeouts: %v", err)
+		return generalError(err)
+	}
+	if len(args) == 0 {
+		ui.Success("Default timeouts restored: %s", config.Global())
+	} else {
+		ui.Success("%s now uses the default timeouts, adapted to its round-trip time", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/tracing"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// traceFlag records message spans to a trace file or OTLP collector
+	traceFlag = "trace"
+
+	// traceDefaultTarget is what --trace without a value records to
+	traceDefaultTarget = "~/.xelvra/" + tracing.TraceFileName
+
+	// latencyTarget is the delivery latency aimed for on direct connections
+	latencyTarget = 50 * time.Millisecond
+)
+
+// resolveTraceTarget expands a leading ~/ in a trace file path
+func resolveTraceTarget(target string) string {
+	if !strings.HasPrefix(target, "~/") {
+		return target
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return target
+	}
+	return filepath.Join(home, target[2:])
+}
+
+// RunTrace handles the trace command
+func RunTrace(cmd *cobra.Command, args []string) error {
+	since, _ := cmd.Flags().GetString("since")
+
+	path := resolveTraceTarget(traceDefaultTarget)
+	if len(args) > 0 {
+		path = resolveTraceTarget(args[0])
+	}
+
+	after, err := db.ParseSince(since, time.Now())
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	records, err := tracing.ReadTraceFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		ui.Printf("⏱️  No traces in %s\n", path)
+		ui.Info("Start the node with --%s to record how long each hop of a message takes", traceFlag)
+		return nil
+	}
+	if err != nil {
+		ui.Error("Failed to read traces: %v", err)
+		return generalError(err)
+	}
+
+	traces := make(map[string]bool)
+	kept := records[:0]
+	for _, record := range records {
+		if record.Start.Before(after) {
+			continue
+		}
+		kept = append(kept, record)
+		traces[record.TraceID] = true
+	}
+	if len(kept) == 0 {
+		ui.Println("⏱️  No spans recorded in that period")
+		return nil
+	}
+
+	ui.Printf("⏱️  %d spans in %d traces from %s\n\n", len(kept), len(traces), path)
+	ui.Printf("  %-18s %6s %9s %9s %9s %9s %7s\n", "SPAN", "COUNT", "MEAN", "P50", "P95", "MAX", "ERRORS")
+	var send *tracing.SpanStats
+	for _, stats := range tracing.Summarize(kept) {
+		ui.Printf("  %-18s %6d %9s %9s %9s %9s %7d\n", stats.Name, stats.Count,
+			formatSpanDuration(stats.Mean), formatSpanDuration(stats.P50),
+			formatSpanDuration(stats.P95), formatSpanDuration(stats.Max), stats.Errors)
+		if stats.Name == message.SpanSend {
+			send = stats
+		}
+	}
+
+	if send == nil {
+		return nil
+	}
+	ui.Println()
+	if send.P95 <= latencyTarget {
+		ui.Success("95%% of sends finished within %s (target <%s)", formatSpanDuration(send.P95), latencyTarget)
+	} else {
+		ui.Warn("95%% of sends took up to %s, above the <%s target", formatSpanDuration(send.P95), latencyTarget)
+		ui.Info("Compare the dial, write and ack rows to see which hop is slow")
+	}
+	return nil
+}
+
+// formatSpanDuration shows a span duration in milliseconds
+func formatSpanDuration(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", float64(d.Microseconds())/1000)
+}
@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+)
+
+// handleSendFileCommand queues a file, sent in the background once the
+// peer is connected, so the transfer can be paused or cancelled from the
+// chat while it runs and survives restarts
+func handleSendFileCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) < 2 {
+		out.Error("Usage: /sendfile <@name|peer_id> <path>")
+		return generalError(errUsage)
+	}
+
+	path := strings.Join(args[1:], " ")
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		out.Error("%s is not a file", path)
+		out.Info("Use /sync-dir to send a directory")
+		return generalError(fmt.Errorf("%s is not a file", path))
+	}
+
+	peerID, err := wrapper.ResolvePeer(args[0])
+	if err != nil {
+		out.Error("Failed to resolve %s: %v", args[0], err)
+		return peerNotFoundError(err)
+	}
+	if err := wrapper.CheckSendAllowed(peerID); err != nil {
+		out.Warn("%v", err)
+		return permissionError(err)
+	}
+
+	// Hashing a large file takes a while; progress and the outcome are
+	// announced by the node, after the command returned
+	name := filepath.Base(path)
+	out.Printf("📥 Queuing %s for %s; it is sent whenever they are connected\n", name, args[0])
+	out.Info("'/transfer' shows its progress and ID")
+	go func() {
+		if _, err := wrapper.QueueFile(peerID, path); err != nil {
+			ui.Printf("\n❌ Failed to queue %s: %v\n", name, err)
+		}
+	}()
+	return nil
+}
+
+// handleTransferCommand lists file transfers or pauses, resumes or cancels
+// one of them
+func handleTransferCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 || args[0] == "list" {
+		printTransfers(out, wrapper.Transfers())
+		printWaitingTransfers(out, wrapper)
+		return nil
+	}
+
+	action := args[0]
+	if action != message.TransferPause && action != message.TransferResume && action != message.TransferCancel {
+		out.Error("Usage: /transfer [list] | /transfer pause|resume|cancel <id>")
+		return generalError(errUsage)
+	}
+	if len(args) < 2 {
+		out.Error("Usage: /transfer %s <id>", action)
+		out.Info("'/transfer' lists transfer IDs")
+		return generalError(errUsage)
+	}
+
+	transfer, err := wrapper.ControlTransfer(args[1], action)
+	if err != nil {
+		out.Error("%v", err)
+		return generalError(err)
+	}
+
+	switch action {
+	case message.TransferPause:
+		out.Printf("⏸️  Paused %s\n", transfer.Metadata.Name)
+	case message.TransferResume:
+		out.Printf("▶️  Resumed %s\n", transfer.Metadata.Name)
+		if transfer.PausedRemote {
+			out.Info("The peer has paused it too; it continues when they resume")
+		}
+	case message.TransferCancel:
+		out.Printf("🚫 Cancelled %s\n", transfer.Metadata.Name)
+	}
+	return nil
+}
+
+// printTransfers lists the file transfers that have not finished
+func printTransfers(out ui.Printer, transfers []*message.FileTransfer) {
+	running := make([]*message.FileTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		switch transfer.Status {
+		case message.FileTransferCompleted, message.FileTransferFailed, message.FileTransferCancelled:
+			continue
+		}
+		running = append(running, transfer)
+	}
+
+	if len(running) == 0 {
+		out.Println("📦 No file transfers in progress")
+		return
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].StartTime.Before(running[j].StartTime)
+	})
+
+	dataDir, _ := user.DataDir()
+	names := contactNames(dataDir)
+
+	out.Println("📦 File transfers:")
+	for _, transfer := range running {
+		peerName := shortPeerID(transfer.PeerID.String())
+		if name := names[transfer.PeerID.String()]; name != "" {
+			peerName = name
+		}
+		direction := "from"
+		if transfer.IsOutgoing() {
+			direction = "to"
+		}
+
+		status := transfer.Status.String()
+		if transfer.PausedRemote && !transfer.PausedLocal {
+			status = "paused by peer"
+		}
+
+		// Compressed transfers show what crossed the network too
+		done := transfer.BytesReceived
+		if transfer.IsOutgoing() {
+			done = transfer.BytesSent
+		}
+		size := fmt.Sprintf("%s of %s", formatBytes(done), formatBytes(transfer.BytesTotal))
+		if transfer.Compression != "" {
+			size += fmt.Sprintf(", %s on the wire", formatBytes(transfer.BytesOnWire))
+		}
+		if transfer.Relayed {
+			size += ", via relay"
+		}
+
+		out.Printf("  %s  %s %s %s  %.0f%%, %s  [%s]\n",
+			transfer.ID, transfer.Metadata.Name, direction, peerName,
+			transfer.Progress*100, size, status)
+	}
+	out.Info("'/transfer pause|resume|cancel <id>' controls a transfer")
+}
+
+// printWaitingTransfers lists queued files that are not being sent, as
+// their peer is not connected
+func printWaitingTransfers(out ui.Printer, wrapper *p2p.P2PWrapper) {
+	running := make(map[string]bool)
+	for _, transfer := range wrapper.Transfers() {
+		switch transfer.Status {
+		case message.FileTransferCompleted, message.FileTransferFailed, message.FileTransferCancelled:
+		default:
+			running[transfer.ID] = true
+		}
+	}
+
+	var waiting []*message.QueuedTransfer
+	for _, q := range wrapper.QueuedTransfers() {
+		if !running[q.ID()] {
+			waiting = append(waiting, q)
+		}
+	}
+	if len(waiting) == 0 {
+		return
+	}
+
+	dataDir, _ := user.DataDir()
+	out.Println()
+	printQueuedTransfers(out, waiting, contactNames(dataDir))
+	out.Info("Queued files are sent once their peer is connected")
+}
@@ -0,0 +1,406 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/chzyer/readline"
+)
+
+// tuiRunes measures how many terminal columns characters take
+var tuiRunes readline.Runes
+
+// Styles of the full-screen chat
+var (
+	tuiHeaderStyle   = lipgloss.NewStyle().Reverse(true)
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiUnreadStyle   = lipgloss.NewStyle().Bold(true)
+	tuiStatusStyle   = lipgloss.NewStyle().Faint(true)
+)
+
+// TUIPeersMsg lists the peers currently connected
+type TUIPeersMsg struct {
+	Peers []string
+}
+
+// TUIMessageMsg is a text message received from a peer
+type TUIMessageMsg struct {
+	PeerID string
+	Text   string
+	Time   time.Time
+}
+
+// TUINoticeMsg is a status line shown below the conversation, such as a
+// delivery error
+type TUINoticeMsg struct {
+	Text string
+}
+
+// TUISendFunc sends a message typed in the full-screen chat to a peer
+type TUISendFunc func(peerID, text string) error
+
+// tuiLine is one message of a conversation
+type tuiLine struct {
+	time     time.Time
+	outgoing bool
+	text     string
+}
+
+// tuiConversation is the scrollback with one peer
+type tuiConversation struct {
+	peerID    string
+	lines     []tuiLine
+	unread    int
+	connected bool
+}
+
+// TUIModel is the full-screen chat as a Bubble Tea model: the peers in the
+// sidebar with the unread count of each conversation, the conversation shown
+// in a viewport and the input box. It only changes through Update, and View
+// renders it, so it can be driven without a terminal.
+type TUIModel struct {
+	names         map[string]string
+	send          TUISendFunc
+	conversations []*tuiConversation
+	selected      int
+	notice        string
+	width, height int
+	viewport      viewport.Model
+	input         textinput.Model
+}
+
+// NewTUIModel creates the full-screen chat, showing contacts by the names
+// given for their peer IDs and sending what is typed with send
+func NewTUIModel(names map[string]string, send TUISendFunc) *TUIModel {
+	if names == nil {
+		names = make(map[string]string)
+	}
+	input := textinput.New()
+	input.Prompt = "> "
+	input.Focus()
+
+	m := &TUIModel{
+		names:    names,
+		send:     send,
+		selected: -1,
+		viewport: viewport.New(0, 0),
+		input:    input,
+	}
+	m.resize(80, 24)
+	return m
+}
+
+// Init implements tea.Model
+func (m *TUIModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+// Update implements tea.Model: it applies a key, resize, peer list,
+// received message or notice
+func (m *TUIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		return m, m.updateKey(msg)
+	case tea.WindowSizeMsg:
+		m.resize(msg.Width, msg.Height)
+	case TUIPeersMsg:
+		connected := make(map[string]bool, len(msg.Peers))
+		for _, peerID := range msg.Peers {
+			connected[peerID] = true
+			m.conversation(peerID)
+		}
+		for _, conv := range m.conversations {
+			conv.connected = connected[conv.peerID]
+		}
+		m.refresh()
+	case TUIMessageMsg:
+		conv := m.conversation(msg.PeerID)
+		conv.lines = append(conv.lines, tuiLine{time: msg.Time, text: msg.Text})
+		if m.current() != conv {
+			conv.unread++
+		}
+		m.refresh()
+	case TUINoticeMsg:
+		m.notice = msg.Text
+	default:
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// updateKey moves through peers and scrollback, sends what was typed or
+// passes the key on to the input box
+func (m *TUIModel) updateKey(key tea.KeyMsg) tea.Cmd {
+	switch key.Type {
+	case tea.KeyCtrlC, tea.KeyCtrlD:
+		return tea.Quit
+	case tea.KeyTab, tea.KeyDown:
+		if len(m.conversations) > 0 {
+			m.selectConversation((m.selected + 1) % len(m.conversations))
+		}
+		return nil
+	case tea.KeyShiftTab, tea.KeyUp:
+		if len(m.conversations) > 0 {
+			m.selectConversation((m.selected - 1 + len(m.conversations)) % len(m.conversations))
+		}
+		return nil
+	case tea.KeyPgUp:
+		m.viewport.PageUp()
+		return nil
+	case tea.KeyPgDown:
+		m.viewport.PageDown()
+		return nil
+	case tea.KeyEnter:
+		return m.submit()
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(key)
+	return cmd
+}
+
+// submit sends the input box to the selected peer, or runs /quit
+func (m *TUIModel) submit() tea.Cmd {
+	text := strings.TrimSpace(m.input.Value())
+	m.input.Reset()
+	switch {
+	case text == "":
+		return nil
+	case text == "/quit" || text == "/exit":
+		return tea.Quit
+	case strings.HasPrefix(text, "/"):
+		m.notice = fmt.Sprintf("Unknown command %s, the full-screen chat only knows /quit", strings.Fields(text)[0])
+		return nil
+	}
+
+	conv := m.current()
+	if conv == nil {
+		m.notice = "No peer to send to yet, waiting for peers to connect"
+		return nil
+	}
+	conv.lines = append(conv.lines, tuiLine{time: time.Now(), outgoing: true, text: text})
+	m.refresh()
+	m.viewport.GotoBottom()
+
+	peerID, send := conv.peerID, m.send
+	return func() tea.Msg {
+		if err := send(peerID, text); err != nil {
+			return TUINoticeMsg{Text: err.Error()}
+		}
+		return nil
+	}
+}
+
+// conversation returns the conversation with peerID, adding it to the
+// sidebar the first time. The first conversation is selected.
+func (m *TUIModel) conversation(peerID string) *tuiConversation {
+	for _, conv := range m.conversations {
+		if conv.peerID == peerID {
+			return conv
+		}
+	}
+	conv := &tuiConversation{peerID: peerID}
+	m.conversations = append(m.conversations, conv)
+	if m.selected < 0 {
+		m.selected = 0
+	}
+	return conv
+}
+
+// selectConversation shows conversation i from its newest line and marks it
+// read
+func (m *TUIModel) selectConversation(i int) {
+	m.selected = i
+	m.conversations[i].unread = 0
+	m.refresh()
+	m.viewport.GotoBottom()
+}
+
+// current returns the conversation shown, if any
+func (m *TUIModel) current() *tuiConversation {
+	if m.selected < 0 {
+		return nil
+	}
+	return m.conversations[m.selected]
+}
+
+// Selected returns the peer whose conversation is shown, if any
+func (m *TUIModel) Selected() string {
+	if conv := m.current(); conv != nil {
+		return conv.peerID
+	}
+	return ""
+}
+
+// Unread returns how many messages from peerID were not seen yet
+func (m *TUIModel) Unread(peerID string) int {
+	for _, conv := range m.conversations {
+		if conv.peerID == peerID {
+			return conv.unread
+		}
+	}
+	return 0
+}
+
+// peerLabel names a peer by contact name, or by shortened peer ID
+func (m *TUIModel) peerLabel(peerID string) string {
+	if name := m.names[peerID]; name != "" {
+		return name
+	}
+	return shortPeerID(peerID)
+}
+
+// sidebarWidth, chatWidth and bodyHeight lay the screen out as a header,
+// the sidebar beside the viewport, a status line and the input box
+func (m *TUIModel) sidebarWidth() int {
+	return min(max(m.width/4, 14), 28)
+}
+
+func (m *TUIModel) chatWidth() int {
+	return max(m.width-m.sidebarWidth()-1, 1)
+}
+
+func (m *TUIModel) bodyHeight() int {
+	return max(m.height-3, 1)
+}
+
+// resize lays the viewport and input box out for a terminal of the given
+// size
+func (m *TUIModel) resize(width, height int) {
+	m.width, m.height = width, height
+	m.viewport.Width, m.viewport.Height = m.chatWidth(), m.bodyHeight()
+	m.input.Width = max(m.width-len(m.input.Prompt)-1, 1)
+	m.refresh()
+}
+
+// refresh puts the shown conversation into the viewport, wrapped to its
+// width. A viewport showing the newest line follows new ones; one scrolled
+// back stays where it is.
+func (m *TUIModel) refresh() {
+	following := m.viewport.AtBottom()
+	m.viewport.SetContent(strings.Join(m.scrollback(), "\n"))
+	if following {
+		m.viewport.GotoBottom()
+	}
+}
+
+// scrollback returns the lines of the shown conversation, wrapped to the
+// width of the chat pane
+func (m *TUIModel) scrollback() []string {
+	conv := m.current()
+	if conv == nil {
+		return []string{
+			"No peers connected yet, waiting for discovery...",
+			"",
+			"Tab/Shift+Tab switch peers, PgUp/PgDn scroll,",
+			"Enter sends, Ctrl+C or /quit leaves.",
+		}
+	}
+
+	var lines []string
+	for _, line := range conv.lines {
+		from := m.peerLabel(conv.peerID)
+		if line.outgoing {
+			from = "you"
+		}
+		text := fmt.Sprintf("%s %s: %s", line.time.Format("15:04"), from, line.text)
+		lines = append(lines, wrapWidth(text, m.chatWidth())...)
+	}
+	return lines
+}
+
+// View implements tea.Model, rendering the whole screen one terminal row per
+// line
+func (m *TUIModel) View() string {
+	if m.width < 30 || m.height < 6 {
+		return "Terminal too small"
+	}
+
+	sideWidth, bodyHeight := m.sidebarWidth(), m.bodyHeight()
+	rows := make([]string, 0, m.height)
+	rows = append(rows, tuiHeaderStyle.Render(fitWidth(" Xelvra peerchat | Tab: next peer  PgUp/PgDn: scroll  Ctrl+C: quit", m.width)))
+
+	chat := strings.Split(m.viewport.View(), "\n")
+	for row := 0; row < bodyHeight; row++ {
+		side := strings.Repeat(" ", sideWidth)
+		if row < len(m.conversations) {
+			side = m.sidebarEntry(row, sideWidth)
+		}
+		line := ""
+		if row < len(chat) {
+			line = chat[row]
+		}
+		rows = append(rows, side+"│"+line)
+	}
+
+	status := m.notice
+	if below := m.viewport.TotalLineCount() - m.viewport.YOffset - m.viewport.VisibleLineCount(); below > 0 {
+		status = fmt.Sprintf("[%d more below] %s", below, status)
+	}
+	rows = append(rows, tuiStatusStyle.Render(fitWidth(status, m.width)))
+	rows = append(rows, m.input.View())
+	return strings.Join(rows, "\n")
+}
+
+// sidebarEntry renders one peer of the sidebar: whether it is connected,
+// its name and unread badge, highlighted when selected
+func (m *TUIModel) sidebarEntry(i, width int) string {
+	conv := m.conversations[i]
+	marker := "○"
+	if conv.connected {
+		marker = "●"
+	}
+	badge := ""
+	if conv.unread > 0 {
+		badge = fmt.Sprintf(" (%d)", conv.unread)
+	}
+	entry := fitWidth(" "+marker+" "+m.peerLabel(conv.peerID), width-len(badge)) + badge
+
+	switch {
+	case i == m.selected:
+		return tuiSelectedStyle.Render(entry)
+	case conv.unread > 0:
+		return tuiUnreadStyle.Render(entry)
+	}
+	return entry
+}
+
+// fitWidth cuts s to width terminal columns and pads it with spaces
+func fitWidth(s string, width int) string {
+	var b strings.Builder
+	used := 0
+	for _, r := range s {
+		w := tuiRunes.Width(r)
+		if used+w > width {
+			break
+		}
+		b.WriteRune(r)
+		used += w
+	}
+	b.WriteString(strings.Repeat(" ", max(width-used, 0)))
+	return b.String()
+}
+
+// wrapWidth splits s into lines of at most width terminal columns
+func wrapWidth(s string, width int) []string {
+	var lines []string
+	var line []rune
+	used := 0
+	for _, r := range s {
+		w := tuiRunes.Width(r)
+		if used+w > width && len(line) > 0 {
+			lines = append(lines, string(line))
+			line, used = nil, 0
+		}
+		line = append(line, r)
+		used += w
+	}
+	return append(lines, string(line))
+}
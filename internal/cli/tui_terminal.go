@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+)
+
+// tuiPeersInterval is how often the full-screen chat refreshes the
+// connected peers
+const tuiPeersInterval = 2 * time.Second
+
+// RunTUI starts the node and runs the full-screen chat until the user quits
+func RunTUI(cmd *cobra.Command, args []string) error {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) || !readline.IsTerminal(int(os.Stdout.Fd())) {
+		ui.Error("The full-screen chat needs a terminal")
+		ui.Info("Use 'peerchat-cli start' or 'peerchat-cli send' from scripts")
+		return generalError(errors.New("not a terminal"))
+	}
+
+	ui.Println("🔧 Initializing P2P node...")
+	ctx := context.Background()
+	wrapper := newP2PWrapper(ctx, cmd)
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+	defer startControl(wrapper)()
+
+	names := make(map[string]string)
+	if dataDir, _, err := getFileAllowlistPath(); err == nil {
+		names = contactNames(dataDir)
+	}
+	model := NewTUIModel(names, func(peerID, text string) error {
+		return sendTUIMessage(wrapper, peerID, text)
+	})
+	model.Update(TUIPeersMsg{Peers: wrapper.GetConnectedPeers()})
+	if wrapper.IsUsingSimulation() {
+		model.Update(TUINoticeMsg{Text: "Simulation mode: messages are not delivered"})
+	}
+	program := tea.NewProgram(model, tea.WithAltScreen())
+
+	// Whatever the node prints, such as delivery errors, becomes the
+	// status line instead of scribbling over the screen
+	restoreOutput := ui.Capture(&tuiNoticeWriter{program: program})
+	defer restoreOutput()
+
+	wrapper.SetMessageListener(func(peerID string, msg *message.Message) {
+		program.Send(TUIMessageMsg{PeerID: peerID, Text: string(msg.Content), Time: msg.Timestamp})
+	})
+	defer wrapper.SetMessageListener(nil)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(tuiPeersInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				program.Send(TUIPeersMsg{Peers: wrapper.GetConnectedPeers()})
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	_, err := program.Run()
+	restoreOutput()
+	if err != nil {
+		ui.Error("Full-screen chat failed: %v", err)
+		return generalError(err)
+	}
+	return nil
+}
+
+// sendTUIMessage sends a message typed in the full-screen chat, returning
+// the problem to show on the status line
+func sendTUIMessage(wrapper *p2p.P2PWrapper, peerID, text string) error {
+	if err := wrapper.CheckSendAllowed(peerID); err != nil {
+		return err
+	}
+	if err := wrapper.SendMessage(peerID, text); err != nil {
+		return fmt.Errorf("failed to send to %s: %w", shortPeerID(peerID), err)
+	}
+	return nil
+}
+
+// tuiNoticeWriter turns each line printed while the full-screen chat runs
+// into a notice
+type tuiNoticeWriter struct {
+	program *tea.Program
+	mu      sync.Mutex
+	partial string
+}
+
+// Write implements io.Writer
+func (w *tuiNoticeWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	lines := strings.Split(w.partial+string(p), "\n")
+	w.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if line = strings.TrimSpace(line); line != "" {
+			w.program.Send(TUINoticeMsg{Text: line})
+		}
+	}
+	return len(p), nil
+}
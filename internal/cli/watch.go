@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// RunWatch handles the watch command
+func RunWatch(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	target, _ := cmd.Flags().GetString("to")
+	opts := message.WatchOptions{}
+	opts.Include, _ = cmd.Flags().GetStringSlice("include")
+	opts.Exclude, _ = cmd.Flags().GetStringSlice("exclude")
+	opts.Debounce, _ = cmd.Flags().GetDuration("debounce")
+	opts.Existing, _ = cmd.Flags().GetBool("existing")
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	watcher, err := message.NewDirWatcher(dir, opts, logger)
+	if err != nil {
+		ui.Error("%v", err)
+		return generalError(err)
+	}
+
+	// Without IPC the transfers need their own node, which cannot share
+	// the identity's port with a running one
+	if status, err := p2p.ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		ui.Error("A node is already running; stop it before watching a directory")
+		return generalError(errNodeRunning)
+	}
+
+	wrapper := newP2PWrapper(context.Background(), cmd)
+	ui.Println("🔧 Initializing P2P node...")
+	if err := wrapper.Start(); err != nil {
+		ui.Error("Failed to start P2P node: %v", err)
+		return networkError(err)
+	}
+	defer func() {
+		if err := wrapper.Stop(); err != nil {
+			ui.Printf("Warning: Failed to stop wrapper: %v\n", err)
+		}
+	}()
+
+	if wrapper.IsUsingSimulation() {
+		ui.Error("Watching a directory needs real P2P networking")
+		return networkError(errSimulation)
+	}
+
+	peerID, err := wrapper.ResolvePeer(target)
+	if err != nil {
+		ui.Error("Failed to resolve %s: %v", target, err)
+		return peerNotFoundError(err)
+	}
+	if err := wrapper.CheckSendAllowed(peerID); err != nil {
+		ui.Warn("%v", err)
+		return permissionError(err)
+	}
+	if !wrapper.ConnectToPeer(peerID) {
+		ui.Warn("Peer is not reachable yet; files are sent once it is")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		ui.Println("\n👋 Stopping watch...")
+		cancel()
+	}()
+
+	abs, _ := filepath.Abs(dir)
+	ui.Printf("👀 Watching %s, sending new and changed files to %s\n", abs, target)
+	ui.Info("Press Ctrl+C to stop")
+
+	sent := 0
+	err = watcher.Run(ctx, func(path string) error {
+		rel, _ := filepath.Rel(abs, path)
+		ui.Printf("📤 [%s] Sending %s...\n", time.Now().Format("15:04:05"), rel)
+		if err := wrapper.SendFile(peerID, path); err != nil {
+			ui.Error("Failed to send %s: %v (will retry)", rel, err)
+			printRefusalHint(ui.Printer{}, err)
+			return err
+		}
+		sent++
+		return nil
+	})
+	if err != nil {
+		ui.Error("Watch failed after sending %d file(s): %v", sent, err)
+		return generalError(err)
+	}
+
+	ui.Success("Sent %d file(s)", sent)
+	return nil
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/Xelvra/peerchat/internal/user"
+)
+
+// handleWhoamiCommand prints the node's identity and the addresses it is
+// reachable at right now
+func handleWhoamiCommand(out ui.Printer, wrapper *p2p.P2PWrapper) {
+	info := wrapper.GetNodeInfo()
+	out.Println("🆔 You are:")
+	out.Printf("  DID:         %s\n", info.DID)
+	out.Printf("  Peer ID:     %s\n", info.PeerID)
+	if fingerprint, err := user.KeyFingerprint(info.PeerID); err == nil {
+		out.Printf("  Fingerprint: %s\n", fingerprint)
+	}
+	if len(info.ListenAddrs) == 0 {
+		out.Println("  Addresses:   (none)")
+	} else {
+		out.Println("  Addresses:")
+		for _, addr := range info.ListenAddrs {
+			out.Printf("    %s\n", addr)
+		}
+	}
+	if link, err := wrapper.IdentityURI(); err == nil {
+		out.Printf("  Link:        %s\n", link)
+	}
+	if wrapper.IsUsingSimulation() {
+		out.Warn("Simulation mode: this identity is not reachable")
+	}
+}
+
+// handleFingerprintCommand prints the fingerprint of the node's key, or of
+// a peer's, to read out when verifying it over another channel
+func handleFingerprintCommand(out ui.Printer, args []string, wrapper *p2p.P2PWrapper) error {
+	if len(args) == 0 {
+		fingerprint, err := user.KeyFingerprint(wrapper.GetNodeInfo().PeerID)
+		if err != nil {
+			out.Error("Failed to compute your fingerprint: %v", err)
+			return generalError(err)
+		}
+		out.Println("🔑 Your key fingerprint:")
+		out.Printf("   %s\n", fingerprint)
+		out.Info("Read it out to your contact; '/fingerprint <you>' shows them the same digits")
+		return nil
+	}
+
+	peerID, err := ResolveChatPeer(wrapper, args[0])
+	if err != nil {
+		out.Error("Failed to resolve %s: %v", args[0], err)
+		return peerNotFoundError(err)
+	}
+	fingerprint, err := user.KeyFingerprint(peerID)
+	if err != nil {
+		out.Error("Failed to compute the fingerprint of %s: %v", args[0], err)
+		return generalError(err)
+	}
+	out.Printf("🔑 Key fingerprint of %s:\n", chatPeerLabel(wrapper, peerID))
+	out.Printf("   %s\n", fingerprint)
+	out.Info("If it matches what they read out, mark them verified with '/verify <name>'")
+	return nil
+}
@@ -0,0 +1,333 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// MaxSkippedKeys bounds how far a receiving session advances its chain for
+// messages that arrive out of order, and how many of their keys it keeps
+const MaxSkippedKeys = 1000
+
+// SessionHeader travels with every message sealed in a session. The
+// initiator's ephemeral key lets the receiver run its side of X3DH from any
+// message, as the first one may arrive after others or not at all.
+type SessionHeader struct {
+	SessionID    string `json:"session_id"`
+	EphemeralKey []byte `json:"ephemeral_key"`
+	Counter      uint32 `json:"counter"`
+}
+
+// Session is one direction of a conversation: the chain of message keys
+// started by an X3DH agreement. Each message key is used once and the chain
+// key moves on, so keys of past messages cannot be derived from the state.
+// It is a symmetric hash chain only, with no Diffie-Hellman ratchet: whoever
+// learns the chain key can derive every later message key of the session,
+// until it is reset.
+type Session struct {
+	ID           string            `json:"id"`
+	EphemeralKey []byte            `json:"ephemeral_key"`
+	ChainKey     []byte            `json:"chain_key"`
+	Counter      uint32            `json:"counter"`           // Number of the next message in the chain
+	Skipped      map[uint32][]byte `json:"skipped,omitempty"` // Keys of messages not received yet
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// NewInitiatorSession runs X3DH with a peer's identity key and a fresh
+// ephemeral key, and starts the sending chain from the shared secret. Peers
+// publish no prekeys, so the identity key stands in for the signed prekey.
+func (sc *SignalCrypto) NewInitiatorSession(remoteIdentity []byte) (*Session, error) {
+	ephemeral, err := GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	defer ephemeral.Destroy()
+
+	remote := &KeyPair{PublicKey: remoteIdentity}
+	shared, err := sc.PerformX3DH(&X3DHBundle{IdentityKey: remote, SignedPreKey: remote}, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	return newSession(ephemeral.PublicKey, shared), nil
+}
+
+// NewResponderSession runs the receiving side of X3DH for a session a peer
+// started, and starts the receiving chain from the shared secret
+func (sc *SignalCrypto) NewResponderSession(remoteIdentity, ephemeralKey []byte) (*Session, error) {
+	shared, err := sc.RespondX3DH(sc.identityKeyPair, remoteIdentity, ephemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	return newSession(ephemeralKey, shared), nil
+}
+
+// RespondX3DH computes the secret PerformX3DH agrees on, from the side of
+// the bundle's owner
+func (sc *SignalCrypto) RespondX3DH(signedPreKey *KeyPair, remoteIdentity, remoteEphemeral []byte) ([]byte, error) {
+	// DH1 = DH(SPK_B, IK_A)
+	dh1, err := performDH(signedPreKey.PrivateKey, remoteIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("DH1 failed: %w", err)
+	}
+
+	// DH2 = DH(IK_B, EK_A)
+	dh2, err := performDH(sc.identityKeyPair.PrivateKey, remoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("DH2 failed: %w", err)
+	}
+
+	// DH3 = DH(SPK_B, EK_A)
+	dh3, err := performDH(signedPreKey.PrivateKey, remoteEphemeral)
+	if err != nil {
+		return nil, fmt.Errorf("DH3 failed: %w", err)
+	}
+
+	return combineSecrets(dh1, dh2, dh3)
+}
+
+// newSession starts a chain from an X3DH secret. The session ID is derived
+// from the ephemeral key, so both sides name the session alike.
+func newSession(ephemeralKey, sharedSecret []byte) *Session {
+	id := sha256.Sum256(ephemeralKey)
+	return &Session{
+		ID:           hex.EncodeToString(id[:8]),
+		EphemeralKey: append([]byte(nil), ephemeralKey...),
+		ChainKey:     sharedSecret,
+		CreatedAt:    time.Now(),
+	}
+}
+
+// Seal encrypts the next message of a sending session
+func (s *Session) Seal(plaintext []byte) (*SessionHeader, []byte, error) {
+	header := &SessionHeader{SessionID: s.ID, EphemeralKey: s.EphemeralKey, Counter: s.Counter}
+	messageKey, nextChain, err := advanceChain(s.ChainKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zero(messageKey)
+
+	ciphertext, err := sealGCM(messageKey, plaintext, header.additionalData())
+	if err != nil {
+		zero(nextChain)
+		return nil, nil, fmt.Errorf("failed to encrypt message: %w", err)
+	}
+	zero(s.ChainKey)
+	s.ChainKey = nextChain
+	s.Counter++
+	return header, ciphertext, nil
+}
+
+// Open decrypts a message of a receiving session. Messages may arrive in any
+// order; each can be opened once. The session is left unchanged when the
+// message cannot be opened.
+func (s *Session) Open(header *SessionHeader, ciphertext []byte) ([]byte, error) {
+	if header.SessionID != s.ID {
+		return nil, fmt.Errorf("message belongs to session %s, not %s", header.SessionID, s.ID)
+	}
+
+	if header.Counter < s.Counter {
+		messageKey, ok := s.Skipped[header.Counter]
+		if !ok {
+			return nil, fmt.Errorf("message %d was already received", header.Counter)
+		}
+		plaintext, err := openGCM(messageKey, ciphertext, header.additionalData())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt message: %w", err)
+		}
+		delete(s.Skipped, header.Counter)
+		zero(messageKey)
+		return plaintext, nil
+	}
+
+	if header.Counter-s.Counter > MaxSkippedKeys {
+		return nil, fmt.Errorf("message %d is too far ahead of %d", header.Counter, s.Counter)
+	}
+
+	// Walk the chain up to the message, keeping the keys passed over
+	chainKey := s.ChainKey
+	skipped := make(map[uint32][]byte)
+	var messageKey []byte
+	for counter := s.Counter; ; counter++ {
+		key, next, err := advanceChain(chainKey)
+		if err != nil {
+			return nil, err
+		}
+		if chainKey != nil && counter != s.Counter {
+			zero(chainKey)
+		}
+		chainKey = next
+		if counter == header.Counter {
+			messageKey = key
+			break
+		}
+		skipped[counter] = key
+	}
+	defer zero(messageKey)
+
+	plaintext, err := openGCM(messageKey, ciphertext, header.additionalData())
+	if err != nil {
+		zero(chainKey)
+		for _, key := range skipped {
+			zero(key)
+		}
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+
+	if len(skipped) > 0 && s.Skipped == nil {
+		s.Skipped = make(map[uint32][]byte, len(skipped))
+	}
+	for counter, key := range skipped {
+		s.Skipped[counter] = key
+	}
+	// Keys of messages that never came are dropped, oldest first
+	if excess := len(s.Skipped) - MaxSkippedKeys; excess > 0 {
+		counters := slices.Sorted(maps.Keys(s.Skipped))
+		for _, counter := range counters[:excess] {
+			zero(s.Skipped[counter])
+			delete(s.Skipped, counter)
+		}
+	}
+	zero(s.ChainKey)
+	s.ChainKey = chainKey
+	s.Counter = header.Counter + 1
+	return plaintext, nil
+}
+
+// Destroy zeroes the key material of the session
+func (s *Session) Destroy() {
+	zero(s.ChainKey)
+	for counter, key := range s.Skipped {
+		zero(key)
+		delete(s.Skipped, counter)
+	}
+}
+
+// additionalData binds a sealed message to its header
+func (h *SessionHeader) additionalData() []byte {
+	ad := make([]byte, 0, len(h.SessionID)+len(h.EphemeralKey)+4)
+	ad = append(ad, h.SessionID...)
+	ad = append(ad, h.EphemeralKey...)
+	return binary.BigEndian.AppendUint32(ad, h.Counter)
+}
+
+// advanceChain derives the message key of a chain step and the chain key of
+// the next
+func advanceChain(chainKey []byte) (messageKey, nextChain []byte, err error) {
+	messageKey, err = DeriveMessageKey(chainKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kdf := hkdf.New(sha256.New, chainKey, nil, []byte("XelvraChainKey"))
+	nextChain = make([]byte, SharedKeySize)
+	if _, err := io.ReadFull(kdf, nextChain); err != nil {
+		return nil, nil, fmt.Errorf("failed to derive chain key: %w", err)
+	}
+	return messageKey, nextChain, nil
+}
+
+// curve25519P is the field prime 2^255 - 19
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// KeyPairFromEd25519 derives the Curve25519 key pair of an Ed25519 identity,
+// so the key peers know a node by can also agree secrets with it
+func KeyPairFromEd25519(privateKey ed25519.PrivateKey) (*KeyPair, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 private key size: %d", len(privateKey))
+	}
+	h := sha512.Sum512(privateKey.Seed())
+	defer zero(h[:])
+	return KeyPairFromPrivateKey(append([]byte(nil), h[:PrivateKeySize]...))
+}
+
+// PublicKeyFromEd25519 converts an Ed25519 public key to the Curve25519 key
+// of the same identity: u = (1 + y) / (1 - y) mod p
+func PublicKeyFromEd25519(publicKey ed25519.PublicKey) ([]byte, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key size: %d", len(publicKey))
+	}
+
+	// The key is y in little-endian, with the sign of x in the top bit
+	be := make([]byte, len(publicKey))
+	for i, b := range publicKey {
+		be[len(publicKey)-1-i] = b
+	}
+	be[0] &= 0x7f
+	y := new(big.Int).SetBytes(be)
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+
+	one := big.NewInt(1)
+	denominator := new(big.Int).Sub(one, y)
+	denominator.Mod(denominator, curve25519P)
+	if denominator.Sign() == 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, denominator.ModInverse(denominator, curve25519P))
+	u.Mod(u, curve25519P)
+
+	out := make([]byte, PublicKeySize)
+	ub := u.Bytes()
+	for i, b := range ub {
+		out[len(ub)-1-i] = b
+	}
+	return out, nil
+}
+
+// sealGCM encrypts plaintext with AES-GCM, prefixing the nonce
+func sealGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonce := make([]byte, NonceSize, NonceSize+len(plaintext)+TagSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openGCM decrypts data sealed by sealGCM
+func openGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < NonceSize+TagSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm.Open(nil, sealed[:NonceSize], sealed[NonceSize:], additionalData)
+}
+
+// zero overwrites key material
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
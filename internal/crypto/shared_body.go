@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// sharedBodyLabel is authenticated with every shared body, so a body cannot
+// be passed off as any other AES-GCM ciphertext under the same key
+var sharedBodyLabel = []byte("XelvraSharedBody")
+
+// SharedBody is one message body for many recipients. The body is encrypted
+// once with a random content key, and only that key is sealed for each
+// recipient in its session, so sending to a long list costs one body
+// encryption plus a 32-byte seal per recipient while keeping the forward
+// secrecy and replay checks of the sessions.
+type SharedBody struct {
+	Key  []byte // Content key, to be sealed for each recipient
+	Body []byte // Nonce followed by the AES-GCM sealed body
+}
+
+// SealSharedBody encrypts plaintext once under a new random content key
+func SealSharedBody(plaintext []byte) (*SharedBody, error) {
+	key := make([]byte, AESKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate content key: %w", err)
+	}
+
+	body, err := sealGCM(key, plaintext, sharedBodyLabel)
+	if err != nil {
+		zero(key)
+		return nil, fmt.Errorf("failed to encrypt body: %w", err)
+	}
+	return &SharedBody{Key: key, Body: body}, nil
+}
+
+// OpenSharedBody decrypts a body sealed by SealSharedBody with its content
+// key
+func OpenSharedBody(key, body []byte) ([]byte, error) {
+	if len(key) != AESKeySize {
+		return nil, fmt.Errorf("content key is %d bytes, not %d", len(key), AESKeySize)
+	}
+	plaintext, err := openGCM(key, body, sharedBodyLabel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt body: %w", err)
+	}
+	return plaintext, nil
+}
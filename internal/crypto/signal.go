@@ -85,11 +85,17 @@ func NewSignalCrypto() (*SignalCrypto, error) {
 		return nil, fmt.Errorf("failed to generate identity key: %w", err)
 	}
 
+	return NewSignalCryptoWithIdentity(identityKey), nil
+}
+
+// NewSignalCryptoWithIdentity creates a Signal Protocol crypto instance
+// around an existing identity key pair
+func NewSignalCryptoWithIdentity(identityKey *KeyPair) *SignalCrypto {
 	return &SignalCrypto{
 		identityKeyPair: identityKey,
 		usedNonces:      make(map[string]time.Time),
 		nonceWindow:     5 * time.Minute, // 5-minute window for nonce validity
-	}, nil
+	}
 }
 
 // GenerateKeyPair generates a new Curve25519 key pair
@@ -100,6 +106,16 @@ func GenerateKeyPair() (*KeyPair, error) {
 		return nil, fmt.Errorf("failed to generate private key: %w", err)
 	}
 
+	return KeyPairFromPrivateKey(privateKey)
+}
+
+// KeyPairFromPrivateKey clamps a Curve25519 private key in place and
+// derives its public key
+func KeyPairFromPrivateKey(privateKey []byte) (*KeyPair, error) {
+	if len(privateKey) != PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: %d", len(privateKey))
+	}
+
 	// Clamp the private key for Curve25519
 	privateKey[0] &= 248
 	privateKey[31] &= 127
@@ -146,7 +162,7 @@ func (sc *SignalCrypto) PerformX3DH(remoteBundle *X3DHBundle, ephemeralKey *KeyP
 // EncryptMessage encrypts a message using AES-GCM with the current chain key
 func (sc *SignalCrypto) EncryptMessage(plaintext []byte, chainKey []byte) ([]byte, error) {
 	// Derive message key from chain key using HKDF
-	messageKey, err := deriveMessageKey(chainKey)
+	messageKey, err := DeriveMessageKey(chainKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to derive message key: %w", err)
 	}
@@ -195,7 +211,7 @@ func (sc *SignalCrypto) DecryptMessage(ciphertext []byte, chainKey []byte) ([]by
 	}
 
 	// Derive message key from chain key
-	messageKey, err := deriveMessageKey(chainKey)
+	messageKey, err := DeriveMessageKey(chainKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to derive message key: %w", err)
 	}
@@ -260,8 +276,9 @@ func combineSecrets(secrets ...[]byte) ([]byte, error) {
 	return sharedSecret, nil
 }
 
-// deriveMessageKey derives a message key from a chain key using HKDF
-func deriveMessageKey(chainKey []byte) ([]byte, error) {
+// DeriveMessageKey derives the AES-256 message key from a chain key using
+// HKDF-SHA256 with info "XelvraMessageKey"
+func DeriveMessageKey(chainKey []byte) ([]byte, error) {
 	hkdf := hkdf.New(sha256.New, chainKey, nil, []byte("XelvraMessageKey"))
 
 	messageKey := make([]byte, AESKeySize)
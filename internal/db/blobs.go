@@ -0,0 +1,276 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// BlobThreshold is the size from which message bodies are kept in the
+	// blob store rather than in their history row, so identical large
+	// bodies are stored once however many conversations they appear in
+	BlobThreshold = 4 * 1024
+
+	// Kinds of blobs
+	blobKindBody = "body" // Encrypted message body
+	blobKindFile = "file" // Encrypted attachment hash; the file itself is in the attachment store
+)
+
+// historyContent selects the content of the history row aliased h, inline
+// or from the blob store
+const historyContent = "COALESCE(h.content, (SELECT b.data FROM blobs b WHERE b.hash = h.blob_hash AND b.kind = 'body'))"
+
+// CompactResult summarises a history compaction
+type CompactResult struct {
+	Moved        int   // Message bodies moved into the blob store
+	BlobsRemoved int   // Blobs no message referred to
+	SizeBefore   int64 // Database size before and after
+	SizeAfter    int64
+}
+
+// initBlobs creates the blob store and links history rows to it
+func (db *SQLiteDB) initBlobs() error {
+	_, err := db.db.Exec(`
+		CREATE TABLE IF NOT EXISTS blobs (
+			hash TEXT PRIMARY KEY, -- Keyed hash of the content
+			kind TEXT NOT NULL,
+			data BLOB, -- Encrypted
+			size INTEGER NOT NULL,
+			refs INTEGER NOT NULL DEFAULT 0 -- History rows referring to the blob
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create blob store: %w", err)
+	}
+
+	if err := db.addColumn("history", "blob_hash", "TEXT"); err != nil {
+		return fmt.Errorf("failed to link history to blob store: %w", err)
+	}
+
+	_, err = db.db.Exec("CREATE INDEX IF NOT EXISTS idx_history_blob_hash ON history(blob_hash)")
+	return err
+}
+
+// blobHash returns the keyed hash a blob is stored under. Keying the hash
+// keeps equal content recognisable only with the database key.
+func (db *SQLiteDB) blobHash(kind string, data []byte) string {
+	key := sha256.Sum256(append([]byte("xelvra-blob-store"), db.encryptionKey...))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(kind + ":"))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// historyBlob returns the blob a message should refer to, if any: its body
+// if large, or the attachment of a file message
+func (db *SQLiteDB) historyBlob(msg *message.Message) (hash, kind string, plaintext []byte) {
+	if len(msg.Content) >= BlobThreshold {
+		return db.blobHash(blobKindBody, msg.Content), blobKindBody, msg.Content
+	}
+	if msg.Type == message.MessageTypeFile {
+		if fileHash, ok := msg.Metadata["file_hash"].(string); ok && fileHash != "" {
+			return db.blobHash(blobKindFile, []byte(fileHash)), blobKindFile, []byte(fileHash)
+		}
+	}
+	return "", "", nil
+}
+
+// addBlobRef stores a blob, or counts one more reference to a stored one
+func addBlobRef(tx *sql.Tx, hash, kind string, data []byte, size int) error {
+	_, err := tx.Exec(`
+		INSERT INTO blobs (hash, kind, data, size, refs) VALUES (?, ?, ?, ?, 1)
+		ON CONFLICT(hash) DO UPDATE SET refs = refs + 1
+	`, hash, kind, data, size)
+	if err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	return nil
+}
+
+// releaseBlobRefs drops the references of the history rows matching where,
+// which are about to be deleted. Attachments stay listed with no references
+// until ForgetReleasedFiles, so their files can be removed.
+func releaseBlobRefs(tx *sql.Tx, where string, args []interface{}) error {
+	_, err := tx.Exec(`
+		UPDATE blobs SET refs = refs - (
+			SELECT COUNT(*) FROM history WHERE blob_hash = blobs.hash AND (`+where+`)
+		)
+		WHERE hash IN (SELECT blob_hash FROM history WHERE `+where+`)
+	`, append(append([]interface{}{}, args...), args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to release blobs: %w", err)
+	}
+	return nil
+}
+
+// deleteUnusedBodies removes message bodies no history row refers to
+func deleteUnusedBodies(tx *sql.Tx) (int, error) {
+	result, err := tx.Exec("DELETE FROM blobs WHERE kind = ? AND refs <= 0", blobKindBody)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete unused blobs: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// ReleasedFiles returns the hashes of attachments whose messages were all
+// deleted from the history
+func (db *SQLiteDB) ReleasedFiles() ([]string, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	rows, err := db.db.Query("SELECT data FROM blobs WHERE kind = ? AND refs <= 0", blobKindFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list released attachments: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			db.logger.WithError(err).Error("Failed to close rows")
+		}
+	}()
+
+	var hashes []string
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan released attachment: %w", err)
+		}
+		hash, err := db.decrypt(data)
+		if err != nil {
+			db.logger.WithError(err).Warn("Skipping undecryptable attachment reference")
+			continue
+		}
+		hashes = append(hashes, string(hash))
+	}
+	return hashes, rows.Err()
+}
+
+// ForgetReleasedFiles drops the records of released attachments once their
+// files are removed. Attachments received again since are kept.
+func (db *SQLiteDB) ForgetReleasedFiles(fileHashes []string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	for _, fileHash := range fileHashes {
+		if _, err := db.db.Exec("DELETE FROM blobs WHERE hash = ? AND refs <= 0",
+			db.blobHash(blobKindFile, []byte(fileHash))); err != nil {
+			return fmt.Errorf("failed to forget attachment: %w", err)
+		}
+	}
+	return nil
+}
+
+// Compact moves large message bodies recorded inline into the blob store,
+// recounts blob references, drops unused blobs and reclaims the free space
+// in the database file
+func (db *SQLiteDB) Compact() (*CompactResult, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	result := &CompactResult{SizeBefore: db.fileSize()}
+
+	rows, err := db.db.Query("SELECT rowid, content FROM history WHERE content IS NOT NULL AND length(content) >= ?", BlobThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	type inline struct {
+		rowid   int64
+		content []byte
+	}
+	var large []inline
+	for rows.Next() {
+		var row inline
+		if err := rows.Scan(&row.rowid, &row.content); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan history: %w", err)
+		}
+		large = append(large, row)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin compaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, row := range large {
+		plaintext, err := db.decrypt(row.content)
+		if err != nil {
+			db.logger.WithError(err).Warn("Skipping undecryptable history entry while compacting")
+			continue
+		}
+		if len(plaintext) < BlobThreshold {
+			continue
+		}
+		hash := db.blobHash(blobKindBody, plaintext)
+		// References are recounted below
+		if _, err := tx.Exec("INSERT OR IGNORE INTO blobs (hash, kind, data, size) VALUES (?, ?, ?, ?)",
+			hash, blobKindBody, row.content, len(plaintext)); err != nil {
+			return nil, fmt.Errorf("failed to store blob: %w", err)
+		}
+		if _, err := tx.Exec("UPDATE history SET content = NULL, blob_hash = ? WHERE rowid = ?", hash, row.rowid); err != nil {
+			return nil, fmt.Errorf("failed to move message body: %w", err)
+		}
+		result.Moved++
+	}
+
+	if _, err := tx.Exec("UPDATE blobs SET refs = (SELECT COUNT(*) FROM history WHERE blob_hash = blobs.hash)"); err != nil {
+		return nil, fmt.Errorf("failed to count blob references: %w", err)
+	}
+	if result.BlobsRemoved, err = deleteUnusedBodies(tx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit compaction: %w", err)
+	}
+
+	if _, err := db.db.Exec("VACUUM"); err != nil {
+		return nil, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+
+	// VACUUM may renumber history rows, which the search index refers to
+	if db.ftsEnabled {
+		if _, err := db.db.Exec("DELETE FROM history_fts"); err != nil {
+			return nil, fmt.Errorf("failed to clear search index: %w", err)
+		}
+		if err := db.reindexHistory(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.checkpoint(); err != nil {
+		db.logger.WithError(err).Warn("Failed to checkpoint after compaction")
+	}
+	result.SizeAfter = db.fileSize()
+
+	db.logger.WithFields(logrus.Fields{
+		"moved":         result.Moved,
+		"blobs_removed": result.BlobsRemoved,
+		"size_before":   result.SizeBefore,
+		"size_after":    result.SizeAfter,
+	}).Info("Message history compacted")
+	return result, nil
+}
+
+// fileSize returns the size of the database including its write-ahead log
+func (db *SQLiteDB) fileSize() int64 {
+	var size int64
+	for _, suffix := range []string{"", "-wal"} {
+		if info, err := os.Stat(db.dbPath + suffix); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
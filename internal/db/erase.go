@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+)
+
+// PeerErasure counts what ErasePeer deleted
+type PeerErasure struct {
+	Messages  int // History entries and stored messages
+	Transfers int // File transfer records
+}
+
+// ErasePeer deletes everything the database holds about a peer: the
+// conversation history including starred messages, stored messages sent to
+// or by the peer and file transfer records. Deleted rows are overwritten on
+// disk (secure_delete) and flushed out of the write-ahead log. Attachments
+// of the deleted messages are released as by the retention rules.
+func (db *SQLiteDB) ErasePeer(peerID string) (*PeerErasure, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin erasure: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	where, args := "peer_id = ?", []interface{}{peerID}
+	if db.ftsEnabled {
+		if _, err := tx.Exec("DELETE FROM history_fts WHERE rowid IN (SELECT rowid FROM history WHERE "+where+")", args...); err != nil {
+			return nil, fmt.Errorf("failed to erase search index: %w", err)
+		}
+	}
+	if err := releaseBlobRefs(tx, where, args); err != nil {
+		return nil, err
+	}
+
+	erased := &PeerErasure{}
+	result, err := tx.Exec("DELETE FROM history WHERE "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase history: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	erased.Messages += int(n)
+
+	if _, err := deleteUnusedBodies(tx); err != nil {
+		return nil, err
+	}
+
+	// Files of stored messages refer to them and go first
+	if _, err := tx.Exec(
+		"DELETE FROM files WHERE message_id IN (SELECT id FROM messages WHERE from_did = ? OR to_did = ?)",
+		peerID, peerID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to erase stored files: %w", err)
+	}
+	result, err = tx.Exec("DELETE FROM messages WHERE from_did = ? OR to_did = ?", peerID, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase stored messages: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	erased.Messages += int(n)
+
+	result, err = tx.Exec("DELETE FROM file_transfers WHERE peer_id = ?", peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase file transfers: %w", err)
+	}
+	n, _ = result.RowsAffected()
+	erased.Transfers = int(n)
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit erasure: %w", err)
+	}
+
+	if erased.Messages > 0 || erased.Transfers > 0 {
+		if err := db.checkpoint(); err != nil {
+			db.logger.WithError(err).Warn("Failed to flush erased history from the WAL")
+		}
+	}
+	return erased, nil
+}
@@ -0,0 +1,345 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+)
+
+// ExportFormat is an output format for chat transcripts
+type ExportFormat string
+
+const (
+	ExportJSON     ExportFormat = "json"
+	ExportMarkdown ExportFormat = "md"
+	ExportHTML     ExportFormat = "html"
+
+	// exportPageSize is the number of entries read from history at a time
+	exportPageSize = 500
+)
+
+// ParseExportFormat parses a format name, accepting common aliases
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "json":
+		return ExportJSON, nil
+	case "md", "markdown":
+		return ExportMarkdown, nil
+	case "html", "htm":
+		return ExportHTML, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q: use json, md or html", s)
+	}
+}
+
+// ExportOptions selects what to export and how peers are named
+type ExportOptions struct {
+	PeerID string // Empty exports every conversation
+	Since  time.Time
+	Until  time.Time
+	Format ExportFormat
+
+	Names map[string]string // Display names by peer ID
+}
+
+// ExportedMessage is a history entry as written to JSON exports
+type ExportedMessage struct {
+	ID        string          `json:"id"`
+	PeerID    string          `json:"peer_id"`
+	PeerName  string          `json:"peer_name,omitempty"`
+	Direction string          `json:"direction"` // "in" or "out"
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Text      string          `json:"text,omitempty"`
+	File      *ExportedFile   `json:"file,omitempty"`
+	Notice    *message.Notice `json:"notice,omitempty"` // System notices, rendered in English in Text
+}
+
+// ExportedFile describes a file transfer in an export
+type ExportedFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// transcriptWriter renders history entries in one export format
+type transcriptWriter interface {
+	begin(title string) error
+	entry(e *ExportedMessage) error
+	end(count int) error
+}
+
+// ExportHistory writes the matching history oldest first to w and returns
+// the number of messages written. History is read in pages, so exports of
+// long conversations do not have to fit in memory.
+func (db *SQLiteDB) ExportHistory(w io.Writer, opts ExportOptions) (int, error) {
+	buf := bufio.NewWriter(w)
+
+	var tw transcriptWriter
+	switch opts.Format {
+	case ExportJSON:
+		tw = &jsonTranscript{w: buf, peerID: opts.PeerID}
+	case ExportMarkdown:
+		tw = &markdownTranscript{w: buf}
+	case ExportHTML:
+		tw = &htmlTranscript{w: buf}
+	default:
+		return 0, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	title := "Chat history"
+	if opts.PeerID != "" {
+		title = "Chat with " + exportPeerName(opts.Names, opts.PeerID)
+	}
+	if err := tw.begin(title); err != nil {
+		return 0, err
+	}
+
+	query := HistoryQuery{
+		PeerID:    opts.PeerID,
+		Since:     opts.Since,
+		Until:     opts.Until,
+		Limit:     exportPageSize,
+		Ascending: true,
+	}
+	count := 0
+	for {
+		page, err := db.QueryHistory(query)
+		if err != nil {
+			return count, err
+		}
+
+		for _, entry := range page {
+			if err := tw.entry(newExportedMessage(entry, opts.Names)); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if len(page) < query.Limit {
+			break
+		}
+		query.Offset += len(page)
+	}
+
+	if err := tw.end(count); err != nil {
+		return count, err
+	}
+	return count, buf.Flush()
+}
+
+// newExportedMessage converts a history entry for export
+func newExportedMessage(entry *HistoryEntry, names map[string]string) *ExportedMessage {
+	e := &ExportedMessage{
+		ID:        entry.ID,
+		PeerID:    entry.PeerID,
+		PeerName:  names[entry.PeerID],
+		Direction: "in",
+		Type:      entry.Type.String(),
+		Timestamp: entry.Timestamp,
+	}
+	if entry.Outgoing {
+		e.Direction = "out"
+	}
+
+	switch entry.Type {
+	case message.MessageTypeText:
+		e.Text = string(entry.Content)
+	case message.MessageTypeFile:
+		e.File = &ExportedFile{Name: string(entry.Content)}
+		if name, ok := entry.Metadata["file_name"].(string); ok {
+			e.File.Name = name
+		}
+		if size, ok := entry.Metadata["file_size"].(float64); ok {
+			e.File.Size = int64(size)
+		}
+		e.File.Hash, _ = entry.Metadata["file_hash"].(string)
+		e.File.Path, _ = entry.Metadata["path"].(string)
+	case message.MessageTypeSystem:
+		if notice, err := message.NoticeOf(entry.Content, entry.Metadata); err == nil {
+			e.Notice, e.Text = notice, notice.Text(nil)
+		}
+	}
+	return e
+}
+
+// exportPeerName returns the display name of a peer, falling back to its ID
+func exportPeerName(names map[string]string, peerID string) string {
+	if name := names[peerID]; name != "" {
+		return name
+	}
+	return peerID
+}
+
+// sender returns who wrote a message, as shown in transcripts
+func (e *ExportedMessage) sender() string {
+	if e.Direction == "out" {
+		return "You"
+	}
+	if e.PeerName != "" {
+		return e.PeerName
+	}
+	return e.PeerID
+}
+
+// summary describes a message that has no text
+func (e *ExportedMessage) summary() string {
+	if e.File != nil {
+		return fmt.Sprintf("📎 %s (%s)", e.File.Name, exportSize(e.File.Size))
+	}
+	return "[" + e.Type + "]"
+}
+
+// exportSize formats a byte count for transcripts
+func exportSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// jsonTranscript writes a JSON document with a messages array
+type jsonTranscript struct {
+	w       *bufio.Writer
+	peerID  string
+	written int
+}
+
+func (t *jsonTranscript) begin(title string) error {
+	header, err := json.Marshal(struct {
+		Title      string    `json:"title"`
+		PeerID     string    `json:"peer_id,omitempty"`
+		ExportedAt time.Time `json:"exported_at"`
+	}{title, t.peerID, time.Now()})
+	if err != nil {
+		return err
+	}
+
+	// Open the messages array inside the header object
+	_, err = fmt.Fprintf(t.w, "%s,\"messages\":[", header[:len(header)-1])
+	return err
+}
+
+func (t *jsonTranscript) entry(e *ExportedMessage) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if t.written > 0 {
+		if err := t.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	t.written++
+	_, err = fmt.Fprintf(t.w, "\n%s", data)
+	return err
+}
+
+func (t *jsonTranscript) end(count int) error {
+	_, err := t.w.WriteString("\n]}\n")
+	return err
+}
+
+// markdownTranscript writes a Markdown document with a section per day
+type markdownTranscript struct {
+	w   *bufio.Writer
+	day string
+}
+
+func (t *markdownTranscript) begin(title string) error {
+	_, err := fmt.Fprintf(t.w, "# %s\n\n_Exported %s_\n", title, time.Now().Format("2006-01-02 15:04"))
+	return err
+}
+
+func (t *markdownTranscript) entry(e *ExportedMessage) error {
+	if day := e.Timestamp.Format("2006-01-02"); day != t.day {
+		t.day = day
+		if _, err := fmt.Fprintf(t.w, "\n## %s\n\n", day); err != nil {
+			return err
+		}
+	}
+
+	text := e.Text
+	if text == "" {
+		text = "_" + e.summary() + "_"
+	}
+	// Continuation lines are indented so they stay in the list item
+	text = strings.ReplaceAll(text, "\n", "  \n  ")
+
+	_, err := fmt.Fprintf(t.w, "- **%s %s:** %s\n", e.Timestamp.Format("15:04"), e.sender(), text)
+	return err
+}
+
+func (t *markdownTranscript) end(count int) error {
+	_, err := fmt.Fprintf(t.w, "\n---\n%d message(s)\n", count)
+	return err
+}
+
+// htmlTranscript writes a self-contained HTML page
+type htmlTranscript struct {
+	w   *bufio.Writer
+	day string
+}
+
+const htmlTranscriptHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; color: #222; }
+h2 { font-size: 1em; color: #666; border-bottom: 1px solid #ddd; }
+.msg { margin: .4em 0; white-space: pre-wrap; }
+.out { text-align: right; }
+.time { color: #999; font-size: .85em; }
+.file { font-style: italic; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+<p class="time">Exported %s</p>
+`
+
+func (t *htmlTranscript) begin(title string) error {
+	title = html.EscapeString(title)
+	_, err := fmt.Fprintf(t.w, htmlTranscriptHead, title, title, time.Now().Format("2006-01-02 15:04"))
+	return err
+}
+
+func (t *htmlTranscript) entry(e *ExportedMessage) error {
+	if day := e.Timestamp.Format("2006-01-02"); day != t.day {
+		t.day = day
+		if _, err := fmt.Fprintf(t.w, "<h2>%s</h2>\n", day); err != nil {
+			return err
+		}
+	}
+
+	class := "msg " + e.Direction
+	body := html.EscapeString(e.Text)
+	if e.Text == "" {
+		class += " file"
+		body = html.EscapeString(e.summary())
+	}
+
+	_, err := fmt.Fprintf(t.w, "<div class=\"%s\"><span class=\"time\">%s</span> <b>%s:</b> %s</div>\n",
+		class, e.Timestamp.Format("15:04"), html.EscapeString(e.sender()), body)
+	return err
+}
+
+func (t *htmlTranscript) end(count int) error {
+	_, err := fmt.Fprintf(t.w, "<p class=\"time\">%d message(s)</p>\n</body>\n</html>\n", count)
+	return err
+}
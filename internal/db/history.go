@@ -0,0 +1,355 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// KeyFileName holds the random database encryption key in the data directory
+	KeyFileName = "userdata.key"
+
+	// DefaultHistoryLimit is used when a history query does not set a limit
+	DefaultHistoryLimit = 50
+)
+
+// HistoryEntry is a message recorded in the local history
+type HistoryEntry struct {
+	ID        string
+	PeerID    string // Remote peer of the conversation
+	Outgoing  bool
+	Type      message.MessageType
+	From      string
+	To        string
+	Content   []byte
+	Metadata  map[string]interface{}
+	Timestamp time.Time
+	StarredAt time.Time // Zero unless starred
+}
+
+// historyColumns selects the columns scanHistoryEntry reads from the
+// history row aliased h
+const historyColumns = "h.id, h.peer_id, h.direction, h.type, h.from_did, h.to_did, " +
+	historyContent + ", h.metadata, h.timestamp, h.starred_at"
+
+// HistoryQuery selects history entries. Zero values mean "no filter".
+type HistoryQuery struct {
+	PeerID string
+	Types  []message.MessageType
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+
+	Ascending    bool // Oldest entries first instead of newest first
+	HideArchived bool // Leave out messages archived by a content filter
+	Starred      bool // Only starred messages
+}
+
+// LoadOrCreateKey returns the database encryption key stored in dataDir,
+// generating one on first use. The key is independent of the identity so
+// history survives key rotation.
+func LoadOrCreateKey(dataDir string) (string, error) {
+	path := filepath.Join(dataDir, KeyFileName)
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("database key file is empty: %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read database key: %w", err)
+	}
+
+	raw := make([]byte, EncryptionKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate database key: %w", err)
+	}
+	key := hex.EncodeToString(raw)
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+		return "", fmt.Errorf("failed to save database key: %w", err)
+	}
+
+	return key, nil
+}
+
+// OpenHistory opens the encrypted user database in dataDir using the key
+// stored next to it
+func OpenHistory(dataDir string, logger *logrus.Logger) (*SQLiteDB, error) {
+	key, err := LoadOrCreateKey(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLiteDB(dataDir, key, logger)
+}
+
+// ParseSince parses a history start time given either as an age relative to
+// now ("90m", "12h", "2d", "1w") or as a date ("2006-01-02")
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return t, nil
+	}
+
+	unit := s[len(s)-1]
+	var scale time.Duration
+	switch unit {
+	case 'd':
+		scale = 24 * time.Hour
+	case 'w':
+		scale = 7 * 24 * time.Hour
+	}
+	if scale > 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid age %q", s)
+		}
+		return now.Add(-time.Duration(n) * scale), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid age %q: use e.g. 90m, 12h, 2d, 1w or YYYY-MM-DD", s)
+	}
+	return now.Add(-d), nil
+}
+
+// RecordMessage stores a sent or received message in the history.
+// Content and metadata are encrypted; peer, time and type stay in clear so
+// they can be indexed. Large bodies and attachments are kept once in the
+// blob store however many messages refer to them. Recording the same message
+// twice is a no-op.
+func (db *SQLiteDB) RecordMessage(msg *message.Message, peerID string, outgoing bool) error {
+	var content []byte
+	var err error
+	if len(msg.Content) > 0 {
+		if content, err = db.encrypt(msg.Content); err != nil {
+			return fmt.Errorf("failed to encrypt message content: %w", err)
+		}
+	}
+
+	var metadata []byte
+	if len(msg.Metadata) > 0 {
+		metadataJSON, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to serialize metadata: %w", err)
+		}
+		if metadata, err = db.encrypt(metadataJSON); err != nil {
+			return fmt.Errorf("failed to encrypt metadata: %w", err)
+		}
+	}
+
+	blobHash, blobKind, blobPlain := db.historyBlob(msg)
+	var blobData []byte
+	switch blobKind {
+	case blobKindBody:
+		blobData, content = content, nil
+	case blobKindFile:
+		if blobData, err = db.encrypt(blobPlain); err != nil {
+			return fmt.Errorf("failed to encrypt attachment reference: %w", err)
+		}
+	}
+
+	direction := 0 // incoming
+	if outgoing {
+		direction = 1
+	}
+	archived := message.FilterMatchOf(msg.Metadata).Archived
+
+	query := `
+		INSERT OR IGNORE INTO history
+		(id, peer_id, direction, type, from_did, to_did, content, metadata, timestamp, blob_hash, archived)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	result, err := tx.Exec(query,
+		msg.ID,
+		peerID,
+		direction,
+		int(msg.Type),
+		msg.From,
+		msg.To,
+		content,
+		metadata,
+		msg.Timestamp.UnixNano(),
+		sql.NullString{String: blobHash, Valid: blobHash != ""},
+		archived,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record message: %w", err)
+	}
+
+	inserted, _ := result.RowsAffected()
+	if inserted > 0 && blobHash != "" {
+		if err := addBlobRef(tx, blobHash, blobKind, blobData, len(blobPlain)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record message: %w", err)
+	}
+
+	// Index new text messages for search
+	if inserted > 0 && msg.Type == message.MessageTypeText {
+		rowid, err := result.LastInsertId()
+		if err == nil {
+			err = db.indexContent(rowid, msg.Content)
+		}
+		if err != nil {
+			db.logger.WithError(err).Warn("Failed to index message for search")
+		}
+	}
+
+	db.incrementTransactionCount()
+	return nil
+}
+
+// QueryHistory returns matching history entries, newest first unless the
+// query asks for ascending order
+func (db *SQLiteDB) QueryHistory(q HistoryQuery) ([]*HistoryEntry, error) {
+	var conditions []string
+	var args []interface{}
+
+	if q.PeerID != "" {
+		conditions = append(conditions, "peer_id = ?")
+		args = append(args, q.PeerID)
+	}
+	if len(q.Types) > 0 {
+		placeholders := make([]string, len(q.Types))
+		for i, t := range q.Types {
+			placeholders[i] = "?"
+			args = append(args, int(t))
+		}
+		conditions = append(conditions, "type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	if q.HideArchived {
+		conditions = append(conditions, "archived = 0")
+	}
+	if q.Starred {
+		conditions = append(conditions, "starred_at IS NOT NULL")
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	query := `
+		SELECT ` + historyColumns + `
+		FROM history h
+	`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	order := "DESC"
+	if q.Ascending {
+		order = "ASC"
+	}
+	query += " ORDER BY timestamp " + order + ", rowid " + order + " LIMIT ? OFFSET ?"
+	args = append(args, limit, q.Offset)
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			db.logger.WithError(err).Error("Failed to close rows")
+		}
+	}()
+
+	var entries []*HistoryEntry
+
+	for rows.Next() {
+		entry, err := db.scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// scanHistoryEntry decodes and decrypts a history row selected with
+// historyColumns
+func (db *SQLiteDB) scanHistoryEntry(rows *sql.Rows) (*HistoryEntry, error) {
+	var entry HistoryEntry
+	var direction, msgType int
+	var content, metadata []byte
+	var timestamp int64
+	var starredAt sql.NullInt64
+
+	if err := rows.Scan(
+		&entry.ID, &entry.PeerID, &direction, &msgType,
+		&entry.From, &entry.To, &content, &metadata, &timestamp, &starredAt,
+	); err != nil {
+		return nil, fmt.Errorf("failed to scan history entry: %w", err)
+	}
+
+	entry.Outgoing = direction == 1
+	entry.Type = message.MessageType(msgType)
+	entry.Timestamp = time.Unix(0, timestamp)
+	if starredAt.Valid {
+		entry.StarredAt = time.Unix(0, starredAt.Int64)
+	}
+
+	var err error
+	if len(content) > 0 {
+		if entry.Content, err = db.decrypt(content); err != nil {
+			return nil, fmt.Errorf("failed to decrypt history entry %s: %w", entry.ID, err)
+		}
+	}
+	if len(metadata) > 0 {
+		metadataJSON, err := db.decrypt(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt history metadata %s: %w", entry.ID, err)
+		}
+		if err := json.Unmarshal(metadataJSON, &entry.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse history metadata %s: %w", entry.ID, err)
+		}
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryHistory keeps the message history in memory only. It answers the
+// same queries as SQLiteDB, so it stands in for it in tests and ephemeral
+// nodes.
+type MemoryHistory struct {
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	entries  []*memoryEntry // In the order recorded
+	byID     map[string]*memoryEntry
+	archived map[*memoryEntry]bool
+	nextSeq  int
+}
+
+// memoryEntry is a recorded message; seq orders messages with the same
+// timestamp as rowid does in SQLite
+type memoryEntry struct {
+	HistoryEntry
+	seq int
+}
+
+// NewMemoryHistory creates an empty in-memory history
+func NewMemoryHistory(logger *logrus.Logger) *MemoryHistory {
+	return &MemoryHistory{
+		logger:   logger,
+		byID:     make(map[string]*memoryEntry),
+		archived: make(map[*memoryEntry]bool),
+	}
+}
+
+// RecordMessage implements HistoryStore. Recording the same message twice is
+// a no-op.
+func (m *MemoryHistory) RecordMessage(msg *message.Message, peerID string, outgoing bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byID[msg.ID]; ok {
+		return nil
+	}
+
+	entry := &memoryEntry{
+		HistoryEntry: HistoryEntry{
+			ID:        msg.ID,
+			PeerID:    peerID,
+			Outgoing:  outgoing,
+			Type:      msg.Type,
+			From:      msg.From,
+			To:        msg.To,
+			Content:   bytes.Clone(msg.Content),
+			Metadata:  msg.Metadata,
+			Timestamp: msg.Timestamp,
+		},
+		seq: m.nextSeq,
+	}
+	m.nextSeq++
+	if len(entry.Content) == 0 {
+		entry.Content = nil
+	}
+	m.entries = append(m.entries, entry)
+	m.byID[msg.ID] = entry
+	if message.FilterMatchOf(msg.Metadata).Archived {
+		m.archived[entry] = true
+	}
+	return nil
+}
+
+// QueryHistory implements HistoryStore
+func (m *MemoryHistory) QueryHistory(q HistoryQuery) ([]*HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	types := make(map[message.MessageType]bool, len(q.Types))
+	for _, t := range q.Types {
+		types[t] = true
+	}
+
+	var matches []*memoryEntry
+	for _, entry := range m.entries {
+		switch {
+		case q.PeerID != "" && entry.PeerID != q.PeerID,
+			len(types) > 0 && !types[entry.Type],
+			!q.Since.IsZero() && entry.Timestamp.Before(q.Since),
+			!q.Until.IsZero() && !entry.Timestamp.Before(q.Until),
+			q.HideArchived && m.archived[entry],
+			q.Starred && entry.StarredAt.IsZero():
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	sortEntries(matches, q.Ascending)
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	var entries []*HistoryEntry
+	for i := q.Offset; i < len(matches) && len(entries) < limit; i++ {
+		entry := matches[i].HistoryEntry
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// SearchHistory implements HistoryStore, newest matches first
+func (m *MemoryHistory) SearchHistory(q SearchQuery) ([]*SearchResult, error) {
+	words := tokenize(q.Text)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("search query has no words")
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	m.mu.RLock()
+	all := len(m.entries)
+	m.mu.RUnlock()
+
+	entries, err := m.QueryHistory(HistoryQuery{
+		PeerID: q.PeerID,
+		Types:  []message.MessageType{message.MessageTypeText},
+		Limit:  all + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*SearchResult
+	for _, entry := range entries {
+		if len(results) == limit {
+			break
+		}
+		if containsAll(tokenize(string(entry.Content)), words) {
+			results = append(results, &SearchResult{
+				Entry:   entry,
+				Snippet: makeSnippet(string(entry.Content), words),
+			})
+		}
+	}
+	return results, nil
+}
+
+// StarMessage implements HistoryStore, accepting a unique ID prefix as
+// SQLiteDB does
+func (m *MemoryHistory) StarMessage(id string, starred bool) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.byID[id]
+	if !ok {
+		if len(id) < MinMessageIDPrefix {
+			return "", fmt.Errorf("no message %q in the history", id)
+		}
+		for _, candidate := range m.entries {
+			if !strings.HasPrefix(candidate.ID, id) {
+				continue
+			}
+			if entry != nil {
+				return "", fmt.Errorf("message ID %q is ambiguous, give more of it", id)
+			}
+			entry = candidate
+		}
+		if entry == nil {
+			return "", fmt.Errorf("no message %q in the history", id)
+		}
+	}
+
+	// Starring again keeps the original time
+	switch {
+	case !starred:
+		entry.StarredAt = time.Time{}
+	case entry.StarredAt.IsZero():
+		entry.StarredAt = time.Now()
+	}
+	return entry.ID, nil
+}
+
+// PruneHistory implements HistoryStore. Starred messages are kept whatever
+// the rule.
+func (m *MemoryHistory) PruneHistory(policy *RetentionPolicy, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conversations := make(map[string][]*memoryEntry)
+	for _, entry := range m.entries {
+		conversations[entry.PeerID] = append(conversations[entry.PeerID], entry)
+	}
+
+	expired := make(map[*memoryEntry]bool)
+	for peerID, entries := range conversations {
+		rule := policy.RuleFor(peerID)
+		if rule.Forever() {
+			continue
+		}
+
+		sortEntries(entries, false)
+		cutoff := now.AddDate(0, 0, -rule.KeepDays)
+		for i, entry := range entries {
+			tooOld := rule.KeepDays > 0 && entry.Timestamp.Before(cutoff)
+			tooMany := rule.KeepMessages > 0 && i >= rule.KeepMessages
+			if (tooOld || tooMany) && entry.StarredAt.IsZero() {
+				expired[entry] = true
+			}
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	kept := m.entries[:0]
+	for _, entry := range m.entries {
+		if expired[entry] {
+			delete(m.byID, entry.ID)
+			delete(m.archived, entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	m.entries = kept
+
+	m.logger.WithField("messages", len(expired)).Info("Expired message history deleted")
+	return len(expired), nil
+}
+
+// ExpireHistory implements HistoryStore. Starred messages are kept.
+func (m *MemoryHistory) ExpireHistory(peerID string, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.entries[:0]
+	deleted := 0
+	for _, entry := range m.entries {
+		if entry.PeerID == peerID && entry.Timestamp.Before(before) && entry.StarredAt.IsZero() {
+			delete(m.byID, entry.ID)
+			delete(m.archived, entry)
+			deleted++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	m.entries = kept
+	return deleted, nil
+}
+
+// RunRetention implements HistoryStore
+func (m *MemoryHistory) RunRetention(ctx context.Context, policyPath string) {
+	runRetention(ctx, m, policyPath, m.logger)
+}
+
+// Close implements HistoryStore; the history is gone afterwards
+func (m *MemoryHistory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = nil
+	m.byID = make(map[string]*memoryEntry)
+	m.archived = make(map[*memoryEntry]bool)
+	return nil
+}
+
+// sortEntries orders entries newest first, or oldest first if ascending
+func sortEntries(entries []*memoryEntry, ascending bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if ascending {
+			a, b = b, a
+		}
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.After(b.Timestamp)
+		}
+		return a.seq > b.seq
+	})
+}
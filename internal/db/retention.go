@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RetentionFileName holds the history retention policy in the data directory
+	RetentionFileName = "retention.json"
+
+	// RetentionInterval is how often the retention policy is enforced
+	RetentionInterval = time.Hour
+
+	// retentionStartDelay lets the node settle before the first pruning run
+	retentionStartDelay = time.Minute
+)
+
+// RetentionRule limits how much history is kept for a conversation. Zero
+// values mean no limit; a rule with neither limit keeps history forever.
+type RetentionRule struct {
+	KeepDays     int `json:"keep_days,omitempty"`
+	KeepMessages int `json:"keep_messages,omitempty"`
+}
+
+// Forever reports whether the rule keeps all history
+func (r RetentionRule) Forever() bool {
+	return r.KeepDays <= 0 && r.KeepMessages <= 0
+}
+
+// String describes the rule for display
+func (r RetentionRule) String() string {
+	var limits []string
+	if r.KeepDays > 0 {
+		limits = append(limits, fmt.Sprintf("%d day(s)", r.KeepDays))
+	}
+	if r.KeepMessages > 0 {
+		limits = append(limits, fmt.Sprintf("%d message(s)", r.KeepMessages))
+	}
+	if len(limits) == 0 {
+		return "keep forever"
+	}
+	return "keep " + strings.Join(limits, " and at most ")
+}
+
+// RetentionPolicy is the global retention rule with per-peer overrides
+type RetentionPolicy struct {
+	Default RetentionRule            `json:"default"`
+	Peers   map[string]RetentionRule `json:"peers,omitempty"`
+}
+
+// RuleFor returns the rule that applies to a conversation
+func (p *RetentionPolicy) RuleFor(peerID string) RetentionRule {
+	if rule, ok := p.Peers[peerID]; ok {
+		return rule
+	}
+	return p.Default
+}
+
+// LoadRetentionPolicy reads the retention policy at path. Without a policy
+// file all history is kept.
+func LoadRetentionPolicy(path string) (*RetentionPolicy, error) {
+	policy := &RetentionPolicy{Peers: make(map[string]RetentionRule)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse retention policy: %w", err)
+	}
+	if policy.Peers == nil {
+		policy.Peers = make(map[string]RetentionRule)
+	}
+	return policy, nil
+}
+
+// SaveRetentionPolicy writes the retention policy to path
+func SaveRetentionPolicy(path string, policy *RetentionPolicy) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// PruneHistory deletes history that the policy no longer keeps and returns
+// the number of deleted messages. Deleted rows are overwritten on disk
+// (secure_delete) and flushed out of the write-ahead log.
+func (db *SQLiteDB) PruneHistory(policy *RetentionPolicy, now time.Time) (int, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	rows, err := db.db.Query("SELECT DISTINCT peer_id FROM history")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	var peers []string
+	for rows.Next() {
+		var peerID string
+		if err := rows.Scan(&peerID); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		peers = append(peers, peerID)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, peerID := range peers {
+		rule := policy.RuleFor(peerID)
+		if rule.Forever() {
+			continue
+		}
+
+		n, err := db.pruneConversation(peerID, rule, now)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+
+	if deleted > 0 {
+		if err := db.checkpoint(); err != nil {
+			db.logger.WithError(err).Warn("Failed to flush pruned history from the WAL")
+		}
+		db.logger.WithField("messages", deleted).Info("Expired message history deleted")
+	}
+	return deleted, nil
+}
+
+// pruneConversation deletes the messages of one conversation that fall
+// outside rule
+func (db *SQLiteDB) pruneConversation(peerID string, rule RetentionRule, now time.Time) (int, error) {
+	var conditions []string
+	args := []interface{}{peerID}
+
+	if rule.KeepDays > 0 {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, now.AddDate(0, 0, -rule.KeepDays).UnixNano())
+	}
+	if rule.KeepMessages > 0 {
+		conditions = append(conditions, `rowid NOT IN (
+			SELECT rowid FROM history WHERE peer_id = ?
+			ORDER BY timestamp DESC, rowid DESC LIMIT ?)`)
+		args = append(args, peerID, rule.KeepMessages)
+	}
+
+	// Starred messages are kept whatever the rule
+	where := "peer_id = ? AND starred_at IS NULL AND (" + strings.Join(conditions, " OR ") + ")"
+	return db.deleteHistory(where, args)
+}
+
+// ExpireHistory implements HistoryStore. Starred messages are kept, as
+// they are by the retention policy.
+func (db *SQLiteDB) ExpireHistory(peerID string, before time.Time) (int, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	deleted, err := db.deleteHistory("peer_id = ? AND starred_at IS NULL AND timestamp < ?",
+		[]interface{}{peerID, before.UnixNano()})
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	if err := db.checkpoint(); err != nil {
+		db.logger.WithError(err).Warn("Failed to flush expired history from the WAL")
+	}
+	db.logger.WithField("messages", deleted).Debug("Disappearing messages deleted")
+	return deleted, nil
+}
+
+// deleteHistory deletes the messages matching where, with their search
+// index entries and the bodies and blobs no other message uses
+func (db *SQLiteDB) deleteHistory(where string, args []interface{}) (int, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin deleting history: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if db.ftsEnabled {
+		if _, err := tx.Exec("DELETE FROM history_fts WHERE rowid IN (SELECT rowid FROM history WHERE "+where+")", args...); err != nil {
+			return 0, fmt.Errorf("failed to delete from search index: %w", err)
+		}
+	}
+	if err := releaseBlobRefs(tx, where, args); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM history WHERE "+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete history: %w", err)
+	}
+	if _, err := deleteUnusedBodies(tx); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit deletion: %w", err)
+	}
+
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// RunRetention enforces the policy stored at policyPath until ctx is done.
+// The policy is re-read every round so changes made with the CLI apply
+// without a restart.
+func (db *SQLiteDB) RunRetention(ctx context.Context, policyPath string) {
+	runRetention(ctx, db, policyPath, db.logger)
+}
+
+// runRetention prunes history with the policy stored at policyPath every
+// RetentionInterval until ctx is done
+func runRetention(ctx context.Context, history HistoryStore, policyPath string, logger *logrus.Logger) {
+	timer := time.NewTimer(retentionStartDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		policy, err := LoadRetentionPolicy(policyPath)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to load retention policy")
+		} else if _, err := history.PruneHistory(policy, time.Now()); err != nil {
+			logger.WithError(err).Warn("Failed to prune message history")
+		}
+
+		timer.Reset(RetentionInterval)
+	}
+}
@@ -0,0 +1,342 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Xelvra/peerchat/internal/message"
+)
+
+const (
+	// DefaultSearchLimit is used when a search does not set a limit
+	DefaultSearchLimit = 20
+
+	// SnippetRadius is the number of characters shown around the first match
+	SnippetRadius = 40
+
+	// searchTermSize is the length in bytes of a blinded search term
+	searchTermSize = 10
+)
+
+// SearchQuery selects history entries containing all words of Text
+type SearchQuery struct {
+	Text   string
+	PeerID string
+	Limit  int
+}
+
+// SearchResult is a history entry matching a search, with a snippet of
+// its content around the first match
+type SearchResult struct {
+	Entry   *HistoryEntry
+	Snippet string
+}
+
+// initSearch creates the full-text index over message history. The index
+// only holds keyed hashes of words, never plaintext, so it does not weaken
+// the content encryption. Without FTS5 support (build tag sqlite_fts5)
+// searches fall back to scanning decrypted history.
+func (db *SQLiteDB) initSearch() error {
+	var existing int
+	if err := db.db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'history_fts'",
+	).Scan(&existing); err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+
+	if existing == 0 {
+		_, err := db.db.Exec("CREATE VIRTUAL TABLE history_fts USING fts5(terms, tokenize = 'ascii')")
+		if err != nil {
+			db.logger.WithError(err).Warn("FTS5 not available, message search will scan history")
+			return nil
+		}
+	}
+	db.ftsEnabled = true
+
+	// Remove deleted terms from the index immediately instead of on merge
+	if _, err := db.db.Exec("INSERT INTO history_fts (history_fts, rank) VALUES ('secure-delete', 1)"); err != nil {
+		db.logger.WithError(err).Debug("FTS5 secure-delete not supported")
+	}
+
+	if existing == 0 {
+		return db.reindexHistory()
+	}
+	return nil
+}
+
+// reindexHistory adds all recorded text messages to the search index
+func (db *SQLiteDB) reindexHistory() error {
+	rows, err := db.db.Query("SELECT h.rowid, "+historyContent+" FROM history h WHERE h.type = ?", int(message.MessageTypeText))
+	if err != nil {
+		return fmt.Errorf("failed to read history for indexing: %w", err)
+	}
+
+	type pending struct {
+		rowid   int64
+		content []byte
+	}
+	var all []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.rowid, &p.content); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to scan history for indexing: %w", err)
+		}
+		all = append(all, p)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, p := range all {
+		if len(p.content) == 0 {
+			continue
+		}
+		content, err := db.decrypt(p.content)
+		if err != nil {
+			db.logger.WithError(err).Warn("Skipping undecryptable history entry while indexing")
+			continue
+		}
+		if err := db.indexContent(p.rowid, content); err != nil {
+			return err
+		}
+	}
+
+	if len(all) > 0 {
+		db.logger.WithField("messages", len(all)).Info("Message search index built")
+	}
+	return nil
+}
+
+// indexContent adds the words of a history entry to the search index
+func (db *SQLiteDB) indexContent(rowid int64, content []byte) error {
+	if !db.ftsEnabled {
+		return nil
+	}
+
+	terms := db.blindTerms(tokenize(string(content)))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	_, err := db.db.Exec("INSERT INTO history_fts (rowid, terms) VALUES (?, ?)", rowid, strings.Join(terms, " "))
+	if err != nil {
+		return fmt.Errorf("failed to index message: %w", err)
+	}
+	return nil
+}
+
+// SearchHistory returns text messages containing every word of the query,
+// best matches first
+func (db *SQLiteDB) SearchHistory(q SearchQuery) ([]*SearchResult, error) {
+	words := tokenize(q.Text)
+	if len(words) == 0 {
+		return nil, fmt.Errorf("search query has no words")
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	var entries []*HistoryEntry
+	var err error
+	if db.ftsEnabled {
+		entries, err = db.searchIndex(words, q.PeerID, limit)
+	} else {
+		entries, err = db.searchScan(words, q.PeerID, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0, len(entries))
+	for _, entry := range entries {
+		results = append(results, &SearchResult{
+			Entry:   entry,
+			Snippet: makeSnippet(string(entry.Content), words),
+		})
+	}
+	return results, nil
+}
+
+// searchIndex looks words up in the FTS5 index
+func (db *SQLiteDB) searchIndex(words []string, peerID string, limit int) ([]*HistoryEntry, error) {
+	match := strings.Join(db.blindTerms(words), " ")
+
+	query := `
+		SELECT ` + historyColumns + `
+		FROM history_fts f JOIN history h ON h.rowid = f.rowid
+		WHERE history_fts MATCH ?
+	`
+	args := []interface{}{match}
+	if peerID != "" {
+		query += " AND h.peer_id = ?"
+		args = append(args, peerID)
+	}
+	query += " ORDER BY bm25(history_fts), h.timestamp DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search history: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			db.logger.WithError(err).Error("Failed to close rows")
+		}
+	}()
+
+	var entries []*HistoryEntry
+	for rows.Next() {
+		entry, err := db.scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// searchScan decrypts text history newest first and keeps entries that
+// contain every word
+func (db *SQLiteDB) searchScan(words []string, peerID string, limit int) ([]*HistoryEntry, error) {
+	var matches []*HistoryEntry
+
+	query := HistoryQuery{
+		PeerID: peerID,
+		Types:  []message.MessageType{message.MessageTypeText},
+		Limit:  500,
+	}
+	for {
+		page, err := db.QueryHistory(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range page {
+			if containsAll(tokenize(string(entry.Content)), words) {
+				matches = append(matches, entry)
+				if len(matches) == limit {
+					return matches, nil
+				}
+			}
+		}
+
+		if len(page) < query.Limit {
+			return matches, nil
+		}
+		query.Offset += len(page)
+	}
+}
+
+// blindTerms replaces words with keyed hashes so the index reveals nothing
+// without the database key
+func (db *SQLiteDB) blindTerms(words []string) []string {
+	key := sha256.Sum256(append([]byte("xelvra-search-index"), db.encryptionKey...))
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		mac := hmac.New(sha256.New, key[:])
+		mac.Write([]byte(word))
+		terms[i] = "t" + hex.EncodeToString(mac.Sum(nil)[:searchTermSize])
+	}
+	return terms
+}
+
+// tokenize splits text into lowercase words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsAll reports whether every word appears in tokens
+func containsAll(tokens, words []string) bool {
+	set := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		set[t] = true
+	}
+	for _, w := range words {
+		if !set[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// makeSnippet returns the text around the first matching word, with matched
+// words marked as [word]
+func makeSnippet(text string, words []string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	lower := strings.ToLower(text)
+
+	wanted := make(map[string]bool, len(words))
+	for _, w := range words {
+		wanted[w] = true
+	}
+
+	// Locate matching words by byte offset
+	type span struct{ start, end int }
+	var spans []span
+	start := -1
+	for i, r := range lower + " " {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			if wanted[lower[start:i]] {
+				spans = append(spans, span{start, i})
+			}
+			start = -1
+		}
+	}
+	if len(spans) == 0 || len(lower) != len(text) {
+		return truncateRunes(text, 2*SnippetRadius)
+	}
+
+	// Cut a window around the first match on rune boundaries
+	from := spans[0].start
+	for n := 0; from > 0 && n < SnippetRadius; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:from])
+		from -= size
+	}
+	to := spans[0].end
+	for n := 0; to < len(text) && n < SnippetRadius; n++ {
+		_, size := utf8.DecodeRuneInString(text[to:])
+		to += size
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		if s.start < from || s.end > to {
+			continue
+		}
+		b.WriteString(text[pos:s.start])
+		b.WriteString("[" + text[s.start:s.end] + "]")
+		pos = s.end
+	}
+	b.WriteString(text[pos:to])
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// truncateRunes shortens text to at most n characters
+func truncateRunes(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:n]) + "…"
+}
@@ -44,6 +44,9 @@ type SQLiteDB struct {
 	encryptionKey []byte
 	mutex         sync.RWMutex
 
+	// Full-text search over history
+	ftsEnabled bool
+
 	// Transaction counters for WAL checkpointing
 	transactionCount int64
 	lastCheckpoint   time.Time
@@ -61,8 +64,9 @@ func NewSQLiteDB(dataDir string, password string, logger *logrus.Logger) (*SQLit
 
 	dbPath := filepath.Join(dataDir, DatabaseName)
 
-	// Open database with WAL mode and optimizations
-	dsn := fmt.Sprintf("%s?_journal_mode=%s&_synchronous=NORMAL&_cache_size=10000&_temp_store=memory",
+	// Open database with WAL mode and optimizations; deleted content is
+	// overwritten so expired history cannot be recovered from the file
+	dsn := fmt.Sprintf("%s?_journal_mode=%s&_synchronous=NORMAL&_cache_size=10000&_temp_store=memory&_secure_delete=true",
 		dbPath, WALMode)
 
 	db, err := sql.Open("sqlite3", dsn)
@@ -323,6 +327,20 @@ func (db *SQLiteDB) initSchema() error {
 		completed_at DATETIME
 	);
 	
+	-- History table recording every sent and received message
+	CREATE TABLE IF NOT EXISTS history (
+		id TEXT PRIMARY KEY,
+		peer_id TEXT NOT NULL, -- remote peer of the conversation
+		direction INTEGER NOT NULL, -- 0=incoming, 1=outgoing
+		type INTEGER NOT NULL, -- MessageType
+		from_did TEXT,
+		to_did TEXT,
+		content BLOB, -- Encrypted
+		metadata BLOB, -- Encrypted JSON
+		timestamp INTEGER NOT NULL, -- Unix nanoseconds
+		recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
 	-- Create indexes for better performance
 	CREATE INDEX IF NOT EXISTS idx_messages_from_did ON messages(from_did);
 	CREATE INDEX IF NOT EXISTS idx_messages_to_did ON messages(to_did);
@@ -333,6 +351,9 @@ func (db *SQLiteDB) initSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_files_message_id ON files(message_id);
 	CREATE INDEX IF NOT EXISTS idx_file_transfers_peer_id ON file_transfers(peer_id);
 	CREATE INDEX IF NOT EXISTS idx_file_transfers_status ON file_transfers(status);
+	CREATE INDEX IF NOT EXISTS idx_history_peer_timestamp ON history(peer_id, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_history_timestamp ON history(timestamp);
+	CREATE INDEX IF NOT EXISTS idx_history_type_timestamp ON history(type, timestamp);
 	
 	-- Create triggers for updating timestamps
 	CREATE TRIGGER IF NOT EXISTS update_users_timestamp 
@@ -353,10 +374,39 @@ func (db *SQLiteDB) initSchema() error {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
+	// Messages archived by a content filter
+	if err := db.addColumn("history", "archived", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to add archive flag to history: %w", err)
+	}
+	if err := db.initStars(); err != nil {
+		return err
+	}
+	if err := db.initBlobs(); err != nil {
+		return err
+	}
+	if err := db.initSearch(); err != nil {
+		return err
+	}
+
 	db.logger.Info("Database schema initialized successfully")
 	return nil
 }
 
+// addColumn adds a column to a table created by an older version
+func (db *SQLiteDB) addColumn(table, column, definition string) error {
+	var exists int
+	if err := db.db.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+	_, err := db.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 // SaveUser saves or updates a user profile
 func (db *SQLiteDB) SaveUser(profile *user.UserProfile) error {
 	query := `
@@ -691,6 +741,18 @@ func (db *SQLiteDB) checkpoint() error {
 	return nil
 }
 
+// BackupTo writes a consistent copy of the database to path while it stays
+// in use. The copy's content stays encrypted with the same key.
+func (db *SQLiteDB) BackupTo(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace database copy: %w", err)
+	}
+	if _, err := db.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	return nil
+}
+
 // GetStats returns database statistics
 func (db *SQLiteDB) GetStats() map[string]interface{} {
 	stats := make(map[string]interface{})
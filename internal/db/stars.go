@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// MinMessageIDPrefix is the shortest message ID prefix accepted in place of
+// a full ID
+const MinMessageIDPrefix = 6
+
+// initStars adds starring to the history
+func (db *SQLiteDB) initStars() error {
+	if err := db.addColumn("history", "starred_at", "INTEGER"); err != nil {
+		return fmt.Errorf("failed to add stars to history: %w", err)
+	}
+	_, err := db.db.Exec("CREATE INDEX IF NOT EXISTS idx_history_starred ON history(starred_at) WHERE starred_at IS NOT NULL")
+	return err
+}
+
+// StarMessage stars or unstars the message with the given ID, or with the
+// only ID starting with it. Starred messages are kept by the retention rules.
+// It returns the full message ID.
+func (db *SQLiteDB) StarMessage(id string, starred bool) (string, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	fullID, err := db.resolveMessageID(id)
+	if err != nil {
+		return "", err
+	}
+
+	// Starring again keeps the original time
+	query, args := "UPDATE history SET starred_at = NULL WHERE id = ?", []interface{}{fullID}
+	if starred {
+		query, args = "UPDATE history SET starred_at = COALESCE(starred_at, ?) WHERE id = ?",
+			[]interface{}{time.Now().UnixNano(), fullID}
+	}
+	if _, err := db.db.Exec(query, args...); err != nil {
+		return "", fmt.Errorf("failed to star message: %w", err)
+	}
+
+	db.incrementTransactionCount()
+	return fullID, nil
+}
+
+// resolveMessageID expands a message ID prefix to the ID of the one message
+// it matches
+func (db *SQLiteDB) resolveMessageID(id string) (string, error) {
+	var exact int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM history WHERE id = ?", id).Scan(&exact); err != nil {
+		return "", fmt.Errorf("failed to look up message: %w", err)
+	}
+	if exact > 0 {
+		return id, nil
+	}
+	if len(id) < MinMessageIDPrefix {
+		return "", fmt.Errorf("no message %q in the history", id)
+	}
+
+	rows, err := db.db.Query("SELECT id FROM history WHERE substr(id, 1, ?) = ? LIMIT 2", len(id), id)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up message: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			db.logger.WithError(err).Error("Failed to close rows")
+		}
+	}()
+
+	var matches []string
+	for rows.Next() {
+		var match string
+		if err := rows.Scan(&match); err != nil {
+			return "", fmt.Errorf("failed to look up message: %w", err)
+		}
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no message %q in the history", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("message ID %q is ambiguous, give more of it", id)
+	}
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+)
+
+// Built-in storage backends
+const (
+	// SQLiteBackend keeps history in the encrypted SQLite database in the
+	// data directory, and the offline queue and contacts in their encrypted
+	// files. It is the default.
+	SQLiteBackend = "sqlite"
+
+	// MemoryBackend keeps everything in memory and loses it on exit, for
+	// tests and ephemeral nodes
+	MemoryBackend = "memory"
+)
+
+// HistoryStore keeps the message history. SQLiteDB is the default; a store
+// that can also copy itself to a file for backups implements HistoryBackup.
+type HistoryStore interface {
+	message.MessageRecorder
+	QueryHistory(q HistoryQuery) ([]*HistoryEntry, error)
+	SearchHistory(q SearchQuery) ([]*SearchResult, error)
+	StarMessage(id string, starred bool) (string, error)
+	PruneHistory(policy *RetentionPolicy, now time.Time) (int, error)
+	ExpireHistory(peerID string, before time.Time) (int, error)
+	RunRetention(ctx context.Context, policyPath string)
+	Close() error
+}
+
+// HistoryBackup is a history store that can be included in backups
+type HistoryBackup interface {
+	BackupTo(path string) error
+}
+
+// Stores are the stores a node keeps its data in. A nil store keeps the
+// default for it: no history, or the encrypted files in ~/.xelvra for the
+// offline queue and contacts.
+type Stores struct {
+	History  HistoryStore
+	Offline  message.OfflineStore
+	Contacts user.ContactStore
+}
+
+// Backend opens the stores of a storage backend for the node with identity.
+// dataDir is where the node keeps its files; it may be empty.
+type Backend func(dataDir string, identity *user.MessengerID, logger *logrus.Logger) (*Stores, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Backend{
+		SQLiteBackend: openSQLiteBackend,
+		MemoryBackend: openMemoryBackend,
+	}
+)
+
+// RegisterBackend makes a storage backend available by name, e.g. one
+// backed by BadgerDB or an encrypted remote store. It panics if the name is
+// taken, as registering twice is a programming error.
+func RegisterBackend(name string, backend Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, taken := backends[name]; taken {
+		panic(fmt.Sprintf("storage backend %q registered twice", name))
+	}
+	backends[name] = backend
+}
+
+// Backends returns the names of the registered storage backends
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupBackend returns the backend called name, or SQLiteBackend if name
+// is empty
+func lookupBackend(name string) (Backend, error) {
+	if name == "" {
+		name = SQLiteBackend
+	}
+
+	backendsMu.RLock()
+	backend, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q (available: %s)", name, strings.Join(Backends(), ", "))
+	}
+	return backend, nil
+}
+
+// CheckBackend reports whether a backend called name is registered
+func CheckBackend(name string) error {
+	_, err := lookupBackend(name)
+	return err
+}
+
+// OpenBackend opens the stores of the backend called name, or of
+// SQLiteBackend if name is empty
+func OpenBackend(name, dataDir string, identity *user.MessengerID, logger *logrus.Logger) (*Stores, error) {
+	backend, err := lookupBackend(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stores, err := backend(dataDir, identity, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return stores, nil
+}
+
+// openSQLiteBackend opens the history in dataDir; without a data directory
+// there is no history
+func openSQLiteBackend(dataDir string, _ *user.MessengerID, logger *logrus.Logger) (*Stores, error) {
+	if dataDir == "" {
+		return &Stores{}, nil
+	}
+	history, err := OpenHistory(dataDir, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &Stores{History: history}, nil
+}
+
+// openMemoryBackend opens empty in-memory stores
+func openMemoryBackend(_ string, _ *user.MessengerID, logger *logrus.Logger) (*Stores, error) {
+	return &Stores{
+		History:  NewMemoryHistory(logger),
+		Offline:  message.NewMemoryOfflineStore(),
+		Contacts: user.NewMemoryContacts(),
+	}, nil
+}
@@ -0,0 +1,251 @@
+package interop
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/crypto"
+	"github.com/Xelvra/peerchat/internal/message"
+)
+
+// Generate computes the vectors with the node's own code from fixed inputs
+func Generate() (*Vectors, error) {
+	v := &Vectors{
+		X3DH: X3DHVectors{
+			Description: "X3DH as the initiator computes it: DH1 = X25519(identity, remote signed prekey), " +
+				"DH2 = X25519(ephemeral, remote identity), DH3 = X25519(ephemeral, remote signed prekey), " +
+				"shared secret = HKDF-SHA256(DH1 || DH2 || DH3, no salt, info \"XelvraX3DH\"), 32 bytes",
+		},
+		MessageKeys: MessageKeyVectors{
+			Description: "Message key = HKDF-SHA256(chain key, no salt, info \"XelvraMessageKey\"), 32 bytes. " +
+				"Ciphertext = 12-byte nonce || AES-256-GCM(message key, nonce, plaintext, no additional data)",
+		},
+		Envelopes: EnvelopeVectors{
+			Description: "Signed messages. The signature is Ed25519 over the signing payload, the JSON object " +
+				"{id, type, from, to, group_id (omitted when empty), content (base64), metadata (omitted when empty), " +
+				"timestamp (RFC 3339)} in that order with metadata keys sorted and <, >, & escaped. " +
+				"Frame = 4-byte big-endian length || JSON of the message with signature and is_encrypted; " +
+				"binary frame = the same length prefix || protobuf Message of internal/message/wire.proto",
+		},
+		FileFrames: FileFrameVectors{
+			Description: "File protocol frames: 4-byte big-endian length || JSON of the request, magic 0x58454C56; " +
+				"binary frame = the same length prefix || protobuf FileTransferRequest of internal/message/wire.proto",
+		},
+	}
+
+	for _, name := range []string{"basic", "second"} {
+		vector, err := generateX3DH(name)
+		if err != nil {
+			return nil, err
+		}
+		v.X3DH.Vectors = append(v.X3DH.Vectors, *vector)
+	}
+
+	for _, plaintext := range []struct{ name, text string }{
+		{"empty", ""},
+		{"short", "hello"},
+		{"unicode", "Ahoj, světe 👋"},
+	} {
+		vector, err := generateMessageKey(plaintext.name, []byte(plaintext.text))
+		if err != nil {
+			return nil, err
+		}
+		v.MessageKeys.Vectors = append(v.MessageKeys.Vectors, *vector)
+	}
+
+	notice, err := json.Marshal(message.NewNotice(message.NoticeGroupRenamed, "group", "<devs>", "name", "Devs & friends"))
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range []*message.Message{
+		{ID: "interop-text", Type: message.MessageTypeText, Content: []byte("hello")},
+		{ID: "interop-notice", Type: message.MessageTypeSystem, Content: notice,
+			Metadata: map[string]interface{}{message.MetadataKind: message.KindNotice}},
+		{ID: "interop-group", Type: message.MessageTypeText, GroupID: "interop-group-id", Content: []byte("Ahoj 👋")},
+	} {
+		vector, err := generateEnvelope(msg)
+		if err != nil {
+			return nil, err
+		}
+		v.Envelopes.Vectors = append(v.Envelopes.Vectors, *vector)
+	}
+
+	chunk := []byte("chunk data")
+	hash := sha256.Sum256(chunk)
+	metadata := message.FileMetadata{
+		ID:         "interop-file",
+		Name:       "notes.txt",
+		Size:       int64(len(chunk)),
+		Hash:       hex.EncodeToString(hash[:]),
+		MimeType:   "text/plain",
+		Timestamp:  vectorTime,
+		ChunkCount: 1,
+		ChunkSize:  message.FileChunkSize,
+	}
+	for _, frame := range []struct {
+		name    string
+		request message.FileTransferRequest
+	}{
+		{"request", message.FileTransferRequest{Type: "request", Metadata: metadata, Streams: 1}},
+		{"chunk", message.FileTransferRequest{Type: "chunk", Data: chunk}},
+		{"ack", message.FileTransferRequest{Type: "ack", Offset: int64(len(chunk))}},
+		{"complete", message.FileTransferRequest{Type: "complete"}},
+	} {
+		vector, err := generateFileFrame(frame.name, frame.request)
+		if err != nil {
+			return nil, err
+		}
+		v.FileFrames.Vectors = append(v.FileFrames.Vectors, *vector)
+	}
+
+	return v, nil
+}
+
+// generateX3DH agrees on a secret between keys derived from name
+func generateX3DH(name string) (*X3DHVector, error) {
+	keys := make(map[string]*crypto.KeyPair)
+	for _, role := range []string{"identity", "ephemeral", "remote_identity", "remote_signed_prekey"} {
+		key, err := crypto.KeyPairFromPrivateKey(seed("x3dh/" + name + "/" + role))
+		if err != nil {
+			return nil, err
+		}
+		keys[role] = key
+	}
+
+	secret, err := x3dh(keys["identity"], keys["ephemeral"], keys["remote_identity"], keys["remote_signed_prekey"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &X3DHVector{
+		Name:                      name,
+		IdentityPrivate:           hex.EncodeToString(keys["identity"].PrivateKey),
+		IdentityPublic:            hex.EncodeToString(keys["identity"].PublicKey),
+		EphemeralPrivate:          hex.EncodeToString(keys["ephemeral"].PrivateKey),
+		EphemeralPublic:           hex.EncodeToString(keys["ephemeral"].PublicKey),
+		RemoteIdentityPrivate:     hex.EncodeToString(keys["remote_identity"].PrivateKey),
+		RemoteIdentityPublic:      hex.EncodeToString(keys["remote_identity"].PublicKey),
+		RemoteSignedPreKeyPrivate: hex.EncodeToString(keys["remote_signed_prekey"].PrivateKey),
+		RemoteSignedPreKeyPublic:  hex.EncodeToString(keys["remote_signed_prekey"].PublicKey),
+		SharedSecret:              hex.EncodeToString(secret),
+	}, nil
+}
+
+// x3dh computes the initiator's shared secret
+func x3dh(identity, ephemeral, remoteIdentity, remoteSignedPreKey *crypto.KeyPair) ([]byte, error) {
+	sc := crypto.NewSignalCryptoWithIdentity(identity)
+	return sc.PerformX3DH(&crypto.X3DHBundle{
+		IdentityKey:  remoteIdentity,
+		SignedPreKey: remoteSignedPreKey,
+	}, ephemeral)
+}
+
+// generateMessageKey seals plaintext under a chain key derived from name
+func generateMessageKey(name string, plaintext []byte) (*MessageKeyVector, error) {
+	chainKey := seed("message_keys/" + name + "/chain_key")
+	messageKey, err := crypto.DeriveMessageKey(chainKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(messageKey, seed("message_keys/" + name + "/nonce")[:crypto.NonceSize], plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessageKeyVector{
+		Name:       name,
+		ChainKey:   hex.EncodeToString(chainKey),
+		MessageKey: hex.EncodeToString(messageKey),
+		Plaintext:  hex.EncodeToString(plaintext),
+		Ciphertext: hex.EncodeToString(ciphertext),
+	}, nil
+}
+
+// seal encrypts as SignalCrypto.EncryptMessage does, with a given nonce
+func seal(messageKey, nonce, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(messageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm.Seal(append([]byte{}, nonce...), nonce, plaintext, nil), nil
+}
+
+// generateEnvelope signs msg as sent from a key derived from its ID
+func generateEnvelope(msg *message.Message) (*EnvelopeVector, error) {
+	sender := signingKey("envelopes/" + msg.ID + "/sender")
+	recipient := signingKey("envelopes/" + msg.ID + "/recipient")
+
+	var err error
+	if msg.From, err = peerIDOf(sender.Public().(ed25519.PublicKey)); err != nil {
+		return nil, err
+	}
+	if msg.To, err = peerIDOf(recipient.Public().(ed25519.PublicKey)); err != nil {
+		return nil, err
+	}
+	msg.Timestamp = vectorTime
+
+	payload, err := message.SigningPayload(msg)
+	if err != nil {
+		return nil, err
+	}
+	msg.Signature = ed25519.Sign(sender, payload)
+	frame, err := message.EncodeFrame(msg)
+	if err != nil {
+		return nil, err
+	}
+	binaryFrame, err := message.EncodeBinaryFrame(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvelopeVector{
+		Name:           msg.ID,
+		SigningSeed:    hex.EncodeToString(sender.Seed()),
+		PublicKey:      hex.EncodeToString(sender.Public().(ed25519.PublicKey)),
+		ID:             msg.ID,
+		Type:           int(msg.Type),
+		From:           msg.From,
+		To:             msg.To,
+		GroupID:        msg.GroupID,
+		Content:        hex.EncodeToString(msg.Content),
+		Metadata:       msg.Metadata,
+		Timestamp:      msg.Timestamp.Format(time.RFC3339Nano),
+		SigningPayload: hex.EncodeToString(payload),
+		Signature:      hex.EncodeToString(msg.Signature),
+		Frame:          hex.EncodeToString(frame),
+		BinaryFrame:    hex.EncodeToString(binaryFrame),
+	}, nil
+}
+
+// generateFileFrame encodes a file protocol frame
+func generateFileFrame(name string, request message.FileTransferRequest) (*FileFrameVector, error) {
+	request.Magic = message.FileTransferMagic
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := message.EncodeFrame(request)
+	if err != nil {
+		return nil, err
+	}
+	binaryFrame, err := message.EncodeBinaryFrame(request)
+	if err != nil {
+		return nil, err
+	}
+	return &FileFrameVector{
+		Name:        name,
+		Request:     data,
+		Frame:       hex.EncodeToString(frame),
+		BinaryFrame: hex.EncodeToString(binaryFrame),
+	}, nil
+}
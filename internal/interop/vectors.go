@@ -0,0 +1,196 @@
+package interop
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Vector files, one per wire format
+const (
+	X3DHFile        = "x3dh.json"
+	MessageKeysFile = "message_keys.json"
+	EnvelopesFile   = "envelopes.json"
+	FileFramesFile  = "file_frames.json"
+)
+
+// DefaultDir is where the vectors live in the source tree
+const DefaultDir = "tests/vectors"
+
+// X3DHVector is an X3DH agreement as the initiator computes it. Keys are
+// hex; private keys are given clamped.
+type X3DHVector struct {
+	Name                      string `json:"name"`
+	IdentityPrivate           string `json:"identity_private"`
+	IdentityPublic            string `json:"identity_public"`
+	EphemeralPrivate          string `json:"ephemeral_private"`
+	EphemeralPublic           string `json:"ephemeral_public"`
+	RemoteIdentityPrivate     string `json:"remote_identity_private"`
+	RemoteIdentityPublic      string `json:"remote_identity_public"`
+	RemoteSignedPreKeyPrivate string `json:"remote_signed_prekey_private"`
+	RemoteSignedPreKeyPublic  string `json:"remote_signed_prekey_public"`
+	SharedSecret              string `json:"shared_secret"`
+}
+
+// MessageKeyVector is a message key derived from a chain key and a message
+// sealed with it. Ciphertext is the 12-byte nonce, then the AES-GCM output.
+type MessageKeyVector struct {
+	Name       string `json:"name"`
+	ChainKey   string `json:"chain_key"`
+	MessageKey string `json:"message_key"`
+	Plaintext  string `json:"plaintext"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// EnvelopeVector is a signed message: the fields the sender sets, the bytes
+// the Ed25519 signature covers, the signature and the frame on the wire in
+// JSON and in protobuf
+type EnvelopeVector struct {
+	Name           string                 `json:"name"`
+	SigningSeed    string                 `json:"signing_seed"` // Ed25519 seed of the sender
+	PublicKey      string                 `json:"public_key"`
+	ID             string                 `json:"id"`
+	Type           int                    `json:"type"`
+	From           string                 `json:"from"`
+	To             string                 `json:"to"`
+	GroupID        string                 `json:"group_id,omitempty"`
+	Content        string                 `json:"content"` // Hex
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+	Timestamp      string                 `json:"timestamp"` // RFC 3339 with nanoseconds
+	SigningPayload string                 `json:"signing_payload"`
+	Signature      string                 `json:"signature"`
+	Frame          string                 `json:"frame"`
+	BinaryFrame    string                 `json:"binary_frame"`
+}
+
+// FileFrameVector is a file protocol frame and its bytes on the wire, in
+// JSON and in protobuf
+type FileFrameVector struct {
+	Name        string          `json:"name"`
+	Request     json.RawMessage `json:"request"`
+	Frame       string          `json:"frame"`
+	BinaryFrame string          `json:"binary_frame"`
+}
+
+// X3DHVectors is the content of X3DHFile
+type X3DHVectors struct {
+	Description string       `json:"description"`
+	Vectors     []X3DHVector `json:"vectors"`
+}
+
+// MessageKeyVectors is the content of MessageKeysFile
+type MessageKeyVectors struct {
+	Description string             `json:"description"`
+	Vectors     []MessageKeyVector `json:"vectors"`
+}
+
+// EnvelopeVectors is the content of EnvelopesFile
+type EnvelopeVectors struct {
+	Description string           `json:"description"`
+	Vectors     []EnvelopeVector `json:"vectors"`
+}
+
+// FileFrameVectors is the content of FileFramesFile
+type FileFrameVectors struct {
+	Description string            `json:"description"`
+	Vectors     []FileFrameVector `json:"vectors"`
+}
+
+// Vectors holds every vector file
+type Vectors struct {
+	X3DH        X3DHVectors
+	MessageKeys MessageKeyVectors
+	Envelopes   EnvelopeVectors
+	FileFrames  FileFrameVectors
+}
+
+// files pairs each vector file with where it is loaded to
+func (v *Vectors) files() map[string]interface{} {
+	return map[string]interface{}{
+		X3DHFile:        &v.X3DH,
+		MessageKeysFile: &v.MessageKeys,
+		EnvelopesFile:   &v.Envelopes,
+		FileFramesFile:  &v.FileFrames,
+	}
+}
+
+// Load reads the vector files in dir
+func Load(dir string) (*Vectors, error) {
+	v := &Vectors{}
+	for name, dst := range v.files() {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vectors: %w", err)
+		}
+		if err := json.Unmarshal(data, dst); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return v, nil
+}
+
+// Save writes the vector files to dir
+func (v *Vectors) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	for name, src := range v.files() {
+		var data bytes.Buffer
+		encoder := json.NewEncoder(&data)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(src); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// seed derives the fixed input named label, so the vectors are the same
+// each time they are generated
+func seed(label string) []byte {
+	sum := sha256.Sum256([]byte("xelvra-interop/" + label))
+	return sum[:]
+}
+
+// signingKey returns the Ed25519 key generated from the seed named label
+func signingKey(label string) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(seed(label))
+}
+
+// peerIDOf returns the libp2p peer ID of an Ed25519 public key
+func peerIDOf(pub ed25519.PublicKey) (string, error) {
+	key, err := libp2pcrypto.UnmarshalEd25519PublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	id, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// vectorTime is the timestamp of every generated envelope and file
+var vectorTime = time.Date(2025, time.June, 1, 12, 30, 45, 123456789, time.UTC)
+
+// decodeHex decodes a hex field, naming it in the error
+func decodeHex(field, value string) ([]byte, error) {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not hex: %w", field, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,248 @@
+package interop
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/crypto"
+	"github.com/Xelvra/peerchat/internal/message"
+)
+
+// Verify checks every vector against the node's code and returns all
+// mismatches, each naming its file and vector
+func (v *Vectors) Verify() error {
+	var errs []error
+	check := func(file, name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", file, name, err))
+		}
+	}
+
+	for _, vector := range v.X3DH.Vectors {
+		check(X3DHFile, vector.Name, verifyX3DH(vector))
+	}
+	for _, vector := range v.MessageKeys.Vectors {
+		check(MessageKeysFile, vector.Name, verifyMessageKey(vector))
+	}
+	for _, vector := range v.Envelopes.Vectors {
+		check(EnvelopesFile, vector.Name, verifyEnvelope(vector))
+	}
+	for _, vector := range v.FileFrames.Vectors {
+		check(FileFramesFile, vector.Name, verifyFileFrame(vector))
+	}
+	return errors.Join(errs...)
+}
+
+// Count returns how many vectors there are in all files
+func (v *Vectors) Count() int {
+	return len(v.X3DH.Vectors) + len(v.MessageKeys.Vectors) + len(v.Envelopes.Vectors) + len(v.FileFrames.Vectors)
+}
+
+// expectHex compares computed bytes with a hex field
+func expectHex(field, want string, got []byte) error {
+	if hex.EncodeToString(got) != want {
+		return fmt.Errorf("%s is %x, vector has %s", field, got, want)
+	}
+	return nil
+}
+
+// keyPair loads a key pair, checking the public key matches the private one
+func keyPair(field, private, public string) (*crypto.KeyPair, error) {
+	priv, err := decodeHex(field+"_private", private)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.KeyPairFromPrivateKey(bytes.Clone(priv))
+	if err != nil {
+		return nil, err
+	}
+	if err := expectHex(field+"_private (clamped)", private, key.PrivateKey); err != nil {
+		return nil, err
+	}
+	if err := expectHex(field+"_public", public, key.PublicKey); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func verifyX3DH(v X3DHVector) error {
+	identity, err := keyPair("identity", v.IdentityPrivate, v.IdentityPublic)
+	if err != nil {
+		return err
+	}
+	ephemeral, err := keyPair("ephemeral", v.EphemeralPrivate, v.EphemeralPublic)
+	if err != nil {
+		return err
+	}
+	remoteIdentity, err := keyPair("remote_identity", v.RemoteIdentityPrivate, v.RemoteIdentityPublic)
+	if err != nil {
+		return err
+	}
+	remoteSignedPreKey, err := keyPair("remote_signed_prekey", v.RemoteSignedPreKeyPrivate, v.RemoteSignedPreKeyPublic)
+	if err != nil {
+		return err
+	}
+
+	secret, err := x3dh(identity, ephemeral, remoteIdentity, remoteSignedPreKey)
+	if err != nil {
+		return err
+	}
+	return expectHex("shared_secret", v.SharedSecret, secret)
+}
+
+func verifyMessageKey(v MessageKeyVector) error {
+	chainKey, err := decodeHex("chain_key", v.ChainKey)
+	if err != nil {
+		return err
+	}
+	plaintext, err := decodeHex("plaintext", v.Plaintext)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := decodeHex("ciphertext", v.Ciphertext)
+	if err != nil {
+		return err
+	}
+	if len(ciphertext) < crypto.NonceSize {
+		return fmt.Errorf("ciphertext has no nonce")
+	}
+
+	messageKey, err := crypto.DeriveMessageKey(chainKey)
+	if err != nil {
+		return err
+	}
+	if err := expectHex("message_key", v.MessageKey, messageKey); err != nil {
+		return err
+	}
+
+	sealed, err := seal(messageKey, ciphertext[:crypto.NonceSize], plaintext)
+	if err != nil {
+		return err
+	}
+	if err := expectHex("ciphertext", v.Ciphertext, sealed); err != nil {
+		return err
+	}
+
+	opened, err := crypto.NewSignalCryptoWithIdentity(nil).DecryptMessage(ciphertext, chainKey)
+	if err != nil {
+		return err
+	}
+	return expectHex("decrypted plaintext", v.Plaintext, opened)
+}
+
+func verifyEnvelope(v EnvelopeVector) error {
+	signingSeed, err := decodeHex("signing_seed", v.SigningSeed)
+	if err != nil {
+		return err
+	}
+	if len(signingSeed) != ed25519.SeedSize {
+		return fmt.Errorf("signing_seed is %d bytes, want %d", len(signingSeed), ed25519.SeedSize)
+	}
+	content, err := decodeHex("content", v.Content)
+	if err != nil {
+		return err
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, v.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	sender := ed25519.NewKeyFromSeed(signingSeed)
+	if err := expectHex("public_key", v.PublicKey, sender.Public().(ed25519.PublicKey)); err != nil {
+		return err
+	}
+
+	msg := &message.Message{
+		ID:        v.ID,
+		Type:      message.MessageType(v.Type),
+		From:      v.From,
+		To:        v.To,
+		GroupID:   v.GroupID,
+		Content:   content,
+		Metadata:  v.Metadata,
+		Timestamp: timestamp,
+	}
+	payload, err := message.SigningPayload(msg)
+	if err != nil {
+		return err
+	}
+	if err := expectHex("signing_payload", v.SigningPayload, payload); err != nil {
+		return err
+	}
+	msg.Signature = ed25519.Sign(sender, payload)
+	if err := expectHex("signature", v.Signature, msg.Signature); err != nil {
+		return err
+	}
+
+	frame, err := message.EncodeFrame(msg)
+	if err != nil {
+		return err
+	}
+	if err := expectHex("frame", v.Frame, frame); err != nil {
+		return err
+	}
+	binaryFrame, err := message.EncodeBinaryFrame(msg)
+	if err != nil {
+		return err
+	}
+	if err := expectHex("binary_frame", v.BinaryFrame, binaryFrame); err != nil {
+		return err
+	}
+
+	// Both frames must also read back to a message whose signature verifies
+	for _, received := range [][]byte{frame, binaryFrame} {
+		var msg message.Message
+		if err := message.DecodeFrame(received, &msg); err != nil {
+			return err
+		}
+		payload, err := message.SigningPayload(&msg)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(sender.Public().(ed25519.PublicKey), payload, msg.Signature) {
+			return fmt.Errorf("signature of the received frame does not verify")
+		}
+	}
+	return nil
+}
+
+func verifyFileFrame(v FileFrameVector) error {
+	var request message.FileTransferRequest
+	if err := json.Unmarshal(v.Request, &request); err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if request.Magic != message.FileTransferMagic {
+		return fmt.Errorf("magic is %x, want %x", request.Magic, message.FileTransferMagic)
+	}
+
+	frame, err := message.EncodeFrame(request)
+	if err != nil {
+		return err
+	}
+	if err := expectHex("frame", v.Frame, frame); err != nil {
+		return err
+	}
+	binaryFrame, err := message.EncodeBinaryFrame(request)
+	if err != nil {
+		return err
+	}
+	if err := expectHex("binary_frame", v.BinaryFrame, binaryFrame); err != nil {
+		return err
+	}
+
+	// The binary frame must read back to the same request
+	var received message.FileTransferRequest
+	if err := message.DecodeFrame(binaryFrame, &received); err != nil {
+		return err
+	}
+	reencoded, err := message.EncodeFrame(received)
+	if err != nil {
+		return err
+	}
+	return expectHex("frame of the decoded binary frame", v.Frame, reencoded)
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditLogFileName is the audit log in the data directory
+const AuditLogFileName = "audit.log"
+
+// Audit events
+const (
+	AuditSessionReset       = "session_reset"         // This node reset the session with a peer
+	AuditSessionResetByPeer = "session_reset_by_peer" // A peer reset its session with this node
+)
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Time   time.Time         `json:"time"`
+	Event  string            `json:"event"`
+	PeerID string            `json:"peer_id,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// AuditLog records security-relevant events as JSON lines, kept apart from
+// the debug log so rotation and log levels never drop them
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewAuditLog returns the audit log at path. An empty path records nothing.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record appends an event to the audit log
+func (a *AuditLog) Record(event, peerID string, fields map[string]string) error {
+	if a == nil || a.path == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(AuditEntry{Time: time.Now().UTC(), Event: event, PeerID: peerID, Fields: fields})
+	if err != nil {
+		return fmt.Errorf("failed to serialize audit entry: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.path), 0700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// Entries returns the events recorded in the audit log, oldest first
+func (a *AuditLog) Entries() ([]AuditEntry, error) {
+	if a == nil || a.path == "" {
+		return nil, nil
+	}
+
+	a.mu.Lock()
+	data, err := os.ReadFile(a.path)
+	a.mu.Unlock()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	var entries []AuditEntry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var entry AuditEntry
+		if err := decoder.Decode(&entry); err != nil {
+			return entries, fmt.Errorf("invalid audit log entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,376 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AttachmentsDirName holds the attachment store in the data directory
+	AttachmentsDirName = "attachments"
+
+	// attachmentIndexFileName lists the stored attachments and who sent them
+	attachmentIndexFileName = "index.json"
+
+	// attachmentObjectsDirName holds the files, named by content hash
+	attachmentObjectsDirName = "objects"
+)
+
+// AttachmentRef is one receipt of an attachment
+type AttachmentRef struct {
+	PeerID     string    `json:"peer_id"`
+	Name       string    `json:"name"`
+	Path       string    `json:"path"` // Download path linked to the stored file
+	TransferID string    `json:"transfer_id"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Attachment is a received file stored once however often it was received
+type Attachment struct {
+	Hash     string          `json:"hash"`
+	Size     int64           `json:"size"`
+	MimeType string          `json:"mime_type"`
+	Refs     []AttachmentRef `json:"refs"`
+}
+
+// LastReceived returns when the attachment was last received
+func (a *Attachment) LastReceived() time.Time {
+	var last time.Time
+	for _, ref := range a.Refs {
+		if ref.ReceivedAt.After(last) {
+			last = ref.ReceivedAt
+		}
+	}
+	return last
+}
+
+// AttachmentGCResult summarises a garbage collection of the store
+type AttachmentGCResult struct {
+	Removed    int   // Attachments deleted
+	BytesFreed int64 // Size of the deleted files
+}
+
+// AttachmentStore keeps received files under content-addressed paths, so a
+// file received several times, in any conversation, is stored once. The
+// download path of each receipt is a hard link to the stored file.
+type AttachmentStore struct {
+	dir    string
+	logger *logrus.Logger
+	mu     sync.Mutex
+}
+
+// NewAttachmentStore creates a store in dir
+func NewAttachmentStore(dir string, logger *logrus.Logger) *AttachmentStore {
+	return &AttachmentStore{dir: dir, logger: logger}
+}
+
+// ObjectPath returns where the file with the given hash is stored
+func (s *AttachmentStore) ObjectPath(hash string) string {
+	return filepath.Join(s.dir, attachmentObjectsDirName, hash[:2], hash)
+}
+
+// Add stores the verified file at srcPath, which is moved into the store or
+// removed if the store already has it, and links it at destPath. The boolean
+// reports whether the content was already stored.
+func (s *AttachmentStore) Add(srcPath, destPath string, metadata FileMetadata, peerID, transferID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(metadata.Hash) < 16 {
+		return false, fmt.Errorf("invalid attachment hash")
+	}
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return false, err
+	}
+
+	object := s.ObjectPath(metadata.Hash)
+	duplicate := false
+	if _, err := os.Stat(object); err == nil {
+		duplicate = true
+		if err := os.Remove(srcPath); err != nil {
+			return false, fmt.Errorf("failed to remove duplicate file: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(object), 0700); err != nil {
+			return false, fmt.Errorf("failed to create attachment directory: %w", err)
+		}
+		if err := moveFile(srcPath, object); err != nil {
+			return false, fmt.Errorf("failed to store attachment: %w", err)
+		}
+		// Stored files are shared by every receipt and must not change
+		_ = os.Chmod(object, 0444)
+	}
+
+	if err := linkAttachment(object, destPath); err != nil {
+		return duplicate, fmt.Errorf("failed to link attachment: %w", err)
+	}
+
+	attachment, ok := index[metadata.Hash]
+	if !ok {
+		attachment = &Attachment{Hash: metadata.Hash, Size: metadata.Size, MimeType: metadata.MimeType}
+		index[metadata.Hash] = attachment
+	}
+	attachment.Refs = append(attachment.Refs, AttachmentRef{
+		PeerID:     peerID,
+		Name:       metadata.Name,
+		Path:       destPath,
+		TransferID: transferID,
+		ReceivedAt: time.Now(),
+	})
+
+	return duplicate, s.saveIndex(index)
+}
+
+// List returns the stored attachments, most recently received first
+func (s *AttachmentStore) List() ([]*Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]*Attachment, 0, len(index))
+	for _, attachment := range index {
+		attachments = append(attachments, attachment)
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].LastReceived().After(attachments[j].LastReceived())
+	})
+	return attachments, nil
+}
+
+// GC deletes attachments last received before cutoff (none if cutoff is
+// zero) together with their download links, stored files no attachment
+// refers to, and index entries whose file is gone
+func (s *AttachmentStore) GC(cutoff time.Time) (*AttachmentGCResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	result := &AttachmentGCResult{}
+
+	for hash, attachment := range index {
+		object := s.ObjectPath(hash)
+		if _, err := os.Stat(object); err != nil {
+			delete(index, hash)
+			continue
+		}
+		if cutoff.IsZero() || !attachment.LastReceived().Before(cutoff) {
+			continue
+		}
+
+		if err := s.removeAttachment(attachment); err != nil {
+			return result, err
+		}
+		delete(index, hash)
+		result.Removed++
+		result.BytesFreed += attachment.Size
+	}
+
+	// Files without an index entry, e.g. left by an interrupted Add
+	objects := filepath.Join(s.dir, attachmentObjectsDirName)
+	err = filepath.Walk(objects, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if _, ok := index[info.Name()]; ok {
+			return nil
+		}
+		if err := removeObject(path); err != nil {
+			return err
+		}
+		result.Removed++
+		result.BytesFreed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to clean up attachments: %w", err)
+	}
+
+	return result, s.saveIndex(index)
+}
+
+// Remove deletes the attachment with the given hash together with its
+// download links, returning the space freed. Unknown hashes are ignored.
+func (s *AttachmentStore) Remove(hash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return 0, err
+	}
+	attachment, ok := index[hash]
+	if !ok {
+		return 0, nil
+	}
+
+	if err := s.removeAttachment(attachment); err != nil {
+		return 0, err
+	}
+	delete(index, hash)
+	return attachment.Size, s.saveIndex(index)
+}
+
+// ErasePeer forgets every file received from peerID and removes its download
+// links. Stored files that no other peer sent are deleted. It returns the
+// number of receipts forgotten and the space freed.
+func (s *AttachmentStore) ErasePeer(peerID string) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	receipts, freed := 0, int64(0)
+	for hash, attachment := range index {
+		object := s.ObjectPath(hash)
+		kept := attachment.Refs[:0]
+		erased := 0
+		for _, ref := range attachment.Refs {
+			if ref.PeerID != peerID {
+				kept = append(kept, ref)
+				continue
+			}
+			erased++
+			if sameFile(object, ref.Path) {
+				if err := os.Remove(ref.Path); err != nil {
+					s.logger.WithError(err).WithField("path", ref.Path).Warn("Failed to remove attachment download")
+				}
+			}
+		}
+		if erased == 0 {
+			continue
+		}
+		receipts += erased
+		attachment.Refs = kept
+
+		if len(kept) == 0 {
+			if err := removeObject(object); err != nil {
+				return receipts, freed, err
+			}
+			delete(index, hash)
+			freed += attachment.Size
+		}
+	}
+
+	if receipts == 0 {
+		return 0, 0, nil
+	}
+	return receipts, freed, s.saveIndex(index)
+}
+
+// removeAttachment deletes a stored file. Download links share its data;
+// they are removed too so the space is actually freed, unless the user
+// replaced them.
+func (s *AttachmentStore) removeAttachment(attachment *Attachment) error {
+	object := s.ObjectPath(attachment.Hash)
+	for _, ref := range attachment.Refs {
+		if sameFile(object, ref.Path) {
+			if err := os.Remove(ref.Path); err != nil {
+				s.logger.WithError(err).WithField("path", ref.Path).Warn("Failed to remove attachment download")
+			}
+		}
+	}
+	return removeObject(object)
+}
+
+// loadIndex reads the attachment index; the caller holds the lock
+func (s *AttachmentStore) loadIndex() (map[string]*Attachment, error) {
+	index := make(map[string]*Attachment)
+
+	data, err := os.ReadFile(filepath.Join(s.dir, attachmentIndexFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("failed to read attachment index: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse attachment index: %w", err)
+	}
+	return index, nil
+}
+
+// saveIndex writes the attachment index; the caller holds the lock
+func (s *AttachmentStore) saveIndex(index map[string]*Attachment) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, attachmentIndexFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write attachment index: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// linkAttachment makes destPath refer to the stored file, falling back to a
+// copy where hard links are not possible
+func linkAttachment(object, destPath string) error {
+	if sameFile(object, destPath) {
+		return nil
+	}
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(object, destPath); err == nil {
+		return nil
+	}
+	return copyFile(object, destPath)
+}
+
+// moveFile renames src to dst, copying across file systems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// removeObject deletes a read-only stored file
+func removeObject(path string) error {
+	_ = os.Chmod(path, 0600)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove attachment: %w", err)
+	}
+	return nil
+}
+
+// sameFile reports whether two paths refer to the same file
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
@@ -0,0 +1,405 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// BackupProtocolID stores and fetches encrypted backups held by a friend
+	BackupProtocolID = protocol.ID("/xelvra/backup/1.0.0")
+
+	// BackupFileName holds the backup settings in the data directory
+	BackupFileName = "backup.json"
+
+	// DefaultBackupInterval is how often a node backs itself up
+	DefaultBackupInterval = 24 * time.Hour
+
+	// MaxBackupSize limits a backup whatever the holder's quota
+	MaxBackupSize = 1 << 30
+
+	// Backup operations
+	BackupStore = "store"
+	BackupFetch = "fetch"
+
+	// Largest backup protocol frame; the backup itself follows the frames
+	maxBackupFrameSize = 4096
+)
+
+// backupAD binds backup ciphertexts to their use
+var backupAD = []byte("xelvra-backup-v1")
+
+// BackupSettings configure backups of this node to a friend's node. The
+// seed is the secret of the recovery phrase; everything needed to find,
+// fetch and decrypt a backup is derived from it.
+type BackupSettings struct {
+	Host     string   `json:"host"` // Peer ID of the friend holding the backups
+	Interval Duration `json:"interval"`
+	Seed     []byte   `json:"seed"`
+
+	LastBackup time.Time `json:"last_backup,omitempty"`
+	LastSize   int64     `json:"last_size,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// Due reports whether a backup should be made at now
+func (s *BackupSettings) Due(now time.Time) bool {
+	interval := time.Duration(s.Interval)
+	if interval <= 0 {
+		interval = DefaultBackupInterval
+	}
+	return now.Sub(s.LastBackup) >= interval
+}
+
+// LoadBackupSettings reads the backup settings at path. It returns nil if
+// backups are not set up.
+func LoadBackupSettings(path string) (*BackupSettings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var settings BackupSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse backup settings: %w", err)
+	}
+	if len(settings.Seed) != user.RecoverySeedSize {
+		return nil, fmt.Errorf("backup settings have no valid recovery seed")
+	}
+	return &settings, nil
+}
+
+// SaveBackupSettings writes the backup settings to path
+func SaveBackupSettings(path string, settings *BackupSettings) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// BackupSecrets are derived from a recovery seed. The blob ID names the
+// backup on the holder, the token proves the right to fetch it and the key
+// encrypts it; the holder learns none of the key.
+type BackupSecrets struct {
+	BlobID string
+	Token  []byte
+	key    []byte
+}
+
+// NewBackupSecrets derives the backup secrets from a recovery seed
+func NewBackupSecrets(seed []byte) (*BackupSecrets, error) {
+	if len(seed) != user.RecoverySeedSize {
+		return nil, fmt.Errorf("recovery seed must be %d bytes", user.RecoverySeedSize)
+	}
+
+	derive := func(purpose string) ([]byte, error) {
+		out := make([]byte, 32)
+		reader := hkdf.New(sha256.New, seed, []byte("xelvra-backup"), []byte(purpose))
+		if _, err := io.ReadFull(reader, out); err != nil {
+			return nil, fmt.Errorf("failed to derive backup %s: %w", purpose, err)
+		}
+		return out, nil
+	}
+
+	id, err := derive("blob-id")
+	if err != nil {
+		return nil, err
+	}
+	token, err := derive("access-token")
+	if err != nil {
+		return nil, err
+	}
+	key, err := derive("encryption-key")
+	if err != nil {
+		return nil, err
+	}
+	return &BackupSecrets{BlobID: hex.EncodeToString(id[:16]), Token: token, key: key}, nil
+}
+
+// TokenHash returns what the holder keeps to check the access token
+func (s *BackupSecrets) TokenHash() string {
+	return hashToken(s.Token)
+}
+
+// hashToken hashes a backup access token
+func hashToken(token []byte) string {
+	sum := sha256.Sum256(token)
+	return hex.EncodeToString(sum[:])
+}
+
+// Seal encrypts a backup with AES-GCM, prefixing the nonce
+func (s *BackupSecrets) Seal(data []byte) ([]byte, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, data, s.ad()), nil
+}
+
+// Open decrypts a backup sealed by Seal
+func (s *BackupSecrets) Open(data []byte) ([]byte, error) {
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("backup is truncated")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, s.ad())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup (wrong recovery phrase?): %w", err)
+	}
+	return plaintext, nil
+}
+
+// ad binds a ciphertext to its blob ID
+func (s *BackupSecrets) ad() []byte {
+	return append(append([]byte{}, backupAD...), s.BlobID...)
+}
+
+// gcm creates the AES-GCM cipher for the backup key
+func (s *BackupSecrets) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// BackupRequest opens a backup stream. A store is answered with a
+// BackupResponse before and after the backup is sent; a fetch is answered
+// with one followed by the backup.
+type BackupRequest struct {
+	Op        string `json:"op"`
+	BlobID    string `json:"blob_id"`
+	Size      int64  `json:"size,omitempty"`       // Store: size of the backup
+	Hash      string `json:"hash,omitempty"`       // Store: SHA-256 of the backup
+	TokenHash string `json:"token_hash,omitempty"` // Store: hash of the access token
+	Token     []byte `json:"token,omitempty"`      // Fetch: the access token
+}
+
+// BackupResponse answers a BackupRequest
+type BackupResponse struct {
+	Size  int64          `json:"size,omitempty"`
+	Hash  string         `json:"hash,omitempty"`
+	Error *ProtocolError `json:"error,omitempty"`
+}
+
+// handleBackupStream stores or returns a backup for a peer. Only peers the
+// user agreed to hold backups for may store, within their quota; anyone
+// with the access token may fetch, as a recovering owner has a new identity.
+func (mm *MessageManager) handleBackupStream(stream network.Stream) {
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close backup stream")
+		}
+	}()
+
+	remotePeer := stream.Conn().RemotePeer()
+	timeouts := mm.TimeoutsFor(remotePeer)
+	_ = stream.SetDeadline(time.Now().Add(timeouts.Message))
+
+	var request BackupRequest
+	if err := readFrame(stream, maxBackupFrameSize, &request); err != nil {
+		mm.logger.WithError(err).Debug("Failed to read backup request")
+		return
+	}
+	refuse := func(pe *ProtocolError) {
+		if err := writeFrame(stream, BackupResponse{Error: pe}); err != nil {
+			mm.logger.WithError(err).Debug("Failed to refuse backup request")
+		}
+	}
+
+	switch request.Op {
+	case BackupStore:
+		hosting, err := LoadBackupHosting(mm.backupHostingPath)
+		if err != nil {
+			mm.logger.WithError(err).Warn("Failed to load backup hosting settings")
+			refuse(NewProtocolError(ErrCodeInternal, "backups unavailable"))
+			return
+		}
+		quota, ok := hosting.Peers[remotePeer.String()]
+		if !ok {
+			refuse(NewProtocolError(ErrCodePolicy, "not holding backups for you"))
+			return
+		}
+		if request.Size <= 0 || request.Size > MaxBackupSize {
+			refuse(NewProtocolError(ErrCodeTooLarge, "backups are limited to %d MB", MaxBackupSize>>20))
+			return
+		}
+		if pe := mm.heldBackups.Check(remotePeer.String(), request.BlobID, request.Size, quota); pe != nil {
+			refuse(pe)
+			return
+		}
+		if err := writeFrame(stream, BackupResponse{}); err != nil {
+			return
+		}
+
+		_ = stream.SetDeadline(time.Now().Add(timeouts.File))
+		held := &HeldBackup{
+			BlobID:    request.BlobID,
+			Owner:     remotePeer.String(),
+			Size:      request.Size,
+			Hash:      request.Hash,
+			TokenHash: request.TokenHash,
+		}
+		response := BackupResponse{Size: request.Size, Hash: request.Hash}
+		if pe := mm.heldBackups.Put(held, io.LimitReader(stream, request.Size), quota); pe != nil {
+			response = BackupResponse{Error: pe}
+		} else {
+			mm.logger.WithField("peer", remotePeer.String()).Info("Stored backup for peer")
+		}
+		if err := writeFrame(stream, response); err != nil {
+			mm.logger.WithError(err).Debug("Failed to confirm backup")
+		}
+
+	case BackupFetch:
+		held, file, pe := mm.heldBackups.Open(request.BlobID, request.Token)
+		if pe != nil {
+			refuse(pe)
+			return
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		_ = stream.SetDeadline(time.Now().Add(timeouts.File))
+		if err := writeFrame(stream, BackupResponse{Size: held.Size, Hash: held.Hash}); err != nil {
+			return
+		}
+		if _, err := io.Copy(stream, file); err != nil {
+			mm.logger.WithError(err).Debug("Failed to send backup")
+		}
+
+	default:
+		refuse(NewProtocolError(ErrCodeUnsupported, "unknown backup operation %q", request.Op))
+	}
+}
+
+// StoreBackup sends a sealed backup to the peer holding backups, replacing
+// the previous one
+func (mm *MessageManager) StoreBackup(peerID peer.ID, secrets *BackupSecrets, sealed []byte) error {
+	timeouts := mm.TimeoutsFor(peerID)
+	ctx, cancel := context.WithTimeout(mm.ctx, timeouts.File)
+	defer cancel()
+
+	stream, err := mm.host.NewStream(ctx, peerID, BackupProtocolID)
+	if err != nil {
+		return fmt.Errorf("failed to open backup stream: %w", err)
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close backup stream")
+		}
+	}()
+
+	sum := sha256.Sum256(sealed)
+	_ = stream.SetDeadline(time.Now().Add(timeouts.Message))
+	if err := writeFrame(stream, BackupRequest{
+		Op:        BackupStore,
+		BlobID:    secrets.BlobID,
+		Size:      int64(len(sealed)),
+		Hash:      hex.EncodeToString(sum[:]),
+		TokenHash: secrets.TokenHash(),
+	}); err != nil {
+		return fmt.Errorf("failed to send backup request: %w", err)
+	}
+
+	var response BackupResponse
+	if err := readFrame(stream, maxBackupFrameSize, &response); err != nil {
+		return fmt.Errorf("no answer to backup request: %w", err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+
+	_ = stream.SetDeadline(time.Now().Add(timeouts.File))
+	if _, err := io.Copy(stream, bytes.NewReader(sealed)); err != nil {
+		return fmt.Errorf("failed to send backup: %w", err)
+	}
+	if err := readFrame(stream, maxBackupFrameSize, &response); err != nil {
+		return fmt.Errorf("backup not confirmed: %w", err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	return nil
+}
+
+// FetchBackup fetches the sealed backup named by secrets from the peer
+// holding it
+func (mm *MessageManager) FetchBackup(peerID peer.ID, secrets *BackupSecrets) ([]byte, error) {
+	timeouts := mm.TimeoutsFor(peerID)
+	ctx, cancel := context.WithTimeout(mm.ctx, timeouts.File)
+	defer cancel()
+
+	stream, err := mm.host.NewStream(ctx, peerID, BackupProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup stream: %w", err)
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close backup stream")
+		}
+	}()
+
+	_ = stream.SetDeadline(time.Now().Add(timeouts.Message))
+	if err := writeFrame(stream, BackupRequest{Op: BackupFetch, BlobID: secrets.BlobID, Token: secrets.Token}); err != nil {
+		return nil, fmt.Errorf("failed to send backup request: %w", err)
+	}
+
+	var response BackupResponse
+	if err := readFrame(stream, maxBackupFrameSize, &response); err != nil {
+		return nil, fmt.Errorf("no answer to backup request: %w", err)
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+	if response.Size <= 0 || response.Size > MaxBackupSize {
+		return nil, fmt.Errorf("peer announced a backup of %d bytes", response.Size)
+	}
+
+	_ = stream.SetDeadline(time.Now().Add(timeouts.File))
+	sealed := make([]byte, response.Size)
+	if _, err := io.ReadFull(stream, sealed); err != nil {
+		return nil, fmt.Errorf("failed to receive backup: %w", err)
+	}
+	sum := sha256.Sum256(sealed)
+	if hex.EncodeToString(sum[:]) != response.Hash {
+		return nil, NewProtocolError(ErrCodeIntegrity, "backup does not match its hash")
+	}
+	return sealed, nil
+}
@@ -0,0 +1,50 @@
+package message
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer kept for reuse; the rare bigger one
+// is left to the garbage collector rather than held on to
+const maxPooledBuffer = FileMaxFrameSize + FileMaxFrameSize/2
+
+// framePool holds buffers for encoding and reading frames, so a transfer
+// does not allocate one for every chunk
+var framePool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// chunkPool holds FileChunkSize buffers for reading files to send
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, FileChunkSize)
+		return &buffer
+	},
+}
+
+// getFrameBuffer returns an empty buffer from the pool
+func getFrameBuffer() *bytes.Buffer {
+	buf := framePool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putFrameBuffer returns a buffer to the pool. Nothing may refer to its
+// bytes afterwards.
+func putFrameBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	framePool.Put(buf)
+}
+
+// getChunkBuffer returns a FileChunkSize buffer from the pool
+func getChunkBuffer() *[]byte {
+	return chunkPool.Get().(*[]byte)
+}
+
+// putChunkBuffer returns a buffer from getChunkBuffer to the pool
+func putChunkBuffer(buffer *[]byte) {
+	chunkPool.Put(buffer)
+}
@@ -0,0 +1,227 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+const (
+	// CapabilitiesProtocolID exchanges the protocol versions and features
+	// two nodes support, so each sends only frames the other understands
+	CapabilitiesProtocolID = protocol.ID("/xelvra/capabilities/1.0.0")
+
+	// ProtocolVersion is the version of the Xelvra protocols this node
+	// speaks, MinProtocolVersion the oldest it still talks to
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+
+	// Largest capabilities frame
+	maxCapabilitiesFrameSize = 4 * 1024
+)
+
+// Optional features. Peers use the features both announce; a feature a node
+// does not know is ignored rather than refused.
+const (
+	FeatureReceipts      = "receipts"       // Messages are answered with a MessageReceipt
+	FeatureCompression   = "compression"    // File chunks may be zstd compressed
+	FeatureNotices       = "notices"        // System messages may carry a structured Notice
+	FeatureReadReceipts  = "read-receipts"  // Shown messages may be answered with a NoticeMessageRead
+	FeaturePQCrypto      = "pq-crypto"      // Post-quantum key exchange; reserved, no release offers it yet
+	FeatureBinaryFrames  = "wire-protobuf"  // Message and file frames may be protobuf, see wire.proto
+	FeatureMessageStream = "message-stream" // Messages may share a long-lived stream, see MessageStreamProtocolID
+	FeatureSessions      = "sessions"       // Text messages may be sealed in a per-peer session, see sessions.go
+	FeatureSharedBodies  = "shared-bodies"  // Text sent to many peers may share a crypto.SharedBody, its key sealed in each session
+)
+
+// Capabilities is what a node announces in the capability handshake
+type Capabilities struct {
+	Version    int      `json:"version"`
+	MinVersion int      `json:"min_version"`
+	Features   []string `json:"features,omitempty"`
+}
+
+// PeerCapabilities is what was agreed with a peer
+type PeerCapabilities struct {
+	// Version is the protocol version both speak, or 0 when their ranges
+	// do not overlap
+	Version int `json:"version"`
+
+	// Features are the optional features both support
+	Features []string `json:"features,omitempty"`
+
+	// Remote is what the peer announced
+	Remote Capabilities `json:"remote"`
+
+	// Legacy is set for peers that predate the handshake; they are assumed
+	// to speak version 1 without optional features
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+// Compatible reports whether the two nodes share a protocol version
+func (c *PeerCapabilities) Compatible() bool {
+	return c.Version > 0
+}
+
+// Supports reports whether both nodes support feature
+func (c *PeerCapabilities) Supports(feature string) bool {
+	return slices.Contains(c.Features, feature)
+}
+
+// IncompatibleError describes why the peer cannot be talked to
+func (c *PeerCapabilities) IncompatibleError() *ProtocolError {
+	return NewProtocolError(ErrCodeIncompatible, "peer speaks protocol versions %d to %d, this node %d to %d",
+		c.Remote.MinVersion, c.Remote.Version, MinProtocolVersion, ProtocolVersion)
+}
+
+// NegotiateCapabilities agrees on the highest version both nodes speak and
+// the features both support, in the order local lists them
+func NegotiateCapabilities(local, remote Capabilities) *PeerCapabilities {
+	agreed := &PeerCapabilities{Remote: remote}
+	version := min(local.Version, remote.Version)
+	if version < max(local.MinVersion, remote.MinVersion, 1) {
+		return agreed
+	}
+	agreed.Version = version
+	for _, feature := range local.Features {
+		if slices.Contains(remote.Features, feature) {
+			agreed.Features = append(agreed.Features, feature)
+		}
+	}
+	return agreed
+}
+
+// legacyCapabilities is what is assumed of a peer without the handshake
+func legacyCapabilities() *PeerCapabilities {
+	return &PeerCapabilities{
+		Version: 1,
+		Remote:  Capabilities{Version: 1, MinVersion: 1},
+		Legacy:  true,
+	}
+}
+
+// LocalCapabilities returns what this node announces
+func (mm *MessageManager) LocalCapabilities() Capabilities {
+	features := []string{FeatureReceipts, FeatureNotices, FeatureReadReceipts, FeatureBinaryFrames, FeatureMessageStream}
+	if mm.fileTransferManager.Compression {
+		features = append(features, FeatureCompression)
+	}
+	if mm.signal != nil {
+		features = append(features, FeatureSessions, FeatureSharedBodies)
+	}
+	return Capabilities{Version: ProtocolVersion, MinVersion: MinProtocolVersion, Features: features}
+}
+
+// handleCapabilitiesStream answers a peer's handshake with our own
+// capabilities and keeps what the two agree on
+func (mm *MessageManager) handleCapabilitiesStream(stream network.Stream) {
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close capabilities stream")
+		}
+	}()
+
+	remotePeer := stream.Conn().RemotePeer()
+	_ = stream.SetDeadline(time.Now().Add(mm.TimeoutsFor(remotePeer).Message))
+
+	var remote Capabilities
+	if err := readFrame(stream, maxCapabilitiesFrameSize, &remote); err != nil {
+		mm.logger.WithError(err).WithField("peer", remotePeer.String()).Debug("Failed to read capabilities")
+		return
+	}
+	local := mm.LocalCapabilities()
+	if err := writeFrame(stream, local); err != nil {
+		mm.logger.WithError(err).WithField("peer", remotePeer.String()).Debug("Failed to send capabilities")
+		return
+	}
+	mm.rememberCapabilities(remotePeer, NegotiateCapabilities(local, remote))
+}
+
+// PeerCapabilities returns what was agreed with a connected peer, running
+// the handshake if it has not run on the current connection. It does not
+// dial the peer.
+func (mm *MessageManager) PeerCapabilities(peerID peer.ID) (*PeerCapabilities, error) {
+	mm.capabilitiesMu.Lock()
+	agreed, ok := mm.capabilities[peerID]
+	mm.capabilitiesMu.Unlock()
+	if ok {
+		return agreed, nil
+	}
+
+	ctx, cancel := context.WithTimeout(mm.ctx, mm.TimeoutsFor(peerID).Message)
+	defer cancel()
+
+	stream, err := mm.host.NewStream(network.WithNoDial(ctx, "capabilities"), peerID, CapabilitiesProtocolID)
+	if errors.Is(err, msmux.ErrNotSupported[protocol.ID]{}) {
+		agreed = legacyCapabilities()
+		mm.rememberCapabilities(peerID, agreed)
+		return agreed, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capabilities stream: %w", err)
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close capabilities stream")
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	local := mm.LocalCapabilities()
+	if err := writeFrame(stream, local); err != nil {
+		return nil, fmt.Errorf("failed to send capabilities: %w", err)
+	}
+	var remote Capabilities
+	if err := readFrame(stream, maxCapabilitiesFrameSize, &remote); err != nil {
+		return nil, fmt.Errorf("no capabilities from peer: %w", err)
+	}
+	agreed = NegotiateCapabilities(local, remote)
+	mm.rememberCapabilities(peerID, agreed)
+	return agreed, nil
+}
+
+// rememberCapabilities keeps what was agreed with a peer until it
+// disconnects
+func (mm *MessageManager) rememberCapabilities(peerID peer.ID, agreed *PeerCapabilities) {
+	if !agreed.Compatible() {
+		mm.logger.WithField("peer", peerID.String()).Warn(agreed.IncompatibleError().Message)
+	}
+	mm.capabilitiesMu.Lock()
+	defer mm.capabilitiesMu.Unlock()
+	mm.capabilities[peerID] = agreed
+}
+
+// forgetCapabilities drops what was agreed with a peer that disconnected;
+// it may come back with another version
+func (mm *MessageManager) forgetCapabilities(peerID peer.ID) {
+	mm.capabilitiesMu.Lock()
+	defer mm.capabilitiesMu.Unlock()
+	delete(mm.capabilities, peerID)
+}
+
+// peerSupports reports whether a peer supports feature. A peer whose
+// capabilities cannot be learned, e.g. because it is not connected, is
+// assumed to support it.
+func (mm *MessageManager) peerSupports(peerID peer.ID, feature string) bool {
+	agreed, err := mm.PeerCapabilities(peerID)
+	if err != nil {
+		return true
+	}
+	return agreed.Supports(feature)
+}
+
+// peerAgrees reports whether a peer is known to support feature, for
+// features a peer that never said so could not handle
+func (mm *MessageManager) peerAgrees(peerID peer.ID, feature string) bool {
+	agreed, err := mm.PeerCapabilities(peerID)
+	return err == nil && agreed.Supports(feature)
+}
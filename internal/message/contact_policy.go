@@ -0,0 +1,110 @@
+package message
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+)
+
+// DisappearInterval is how often messages past the disappearing time of
+// their contact are deleted from the history
+const DisappearInterval = time.Minute
+
+// HistoryExpirer is a MessageRecorder that can delete the messages of a
+// conversation sent before a time, for disappearing messages
+type HistoryExpirer interface {
+	ExpireHistory(peerID string, before time.Time) (int, error)
+}
+
+// contactPolicy returns the policy of the contact pinned to peerID, or the
+// default policy
+func (mm *MessageManager) contactPolicy(peerID string) user.ContactPolicy {
+	if mm.contacts == nil {
+		return user.ContactPolicy{}
+	}
+	return mm.contacts.PolicyFor(peerID)
+}
+
+// notifies reports whether messages from peerID run the notification
+// hooks, which quiet and muted contacts do not
+func (mm *MessageManager) notifies(peerID string) bool {
+	return mm.contactPolicy(peerID).NotifyLevel() == user.NotifyAll
+}
+
+// autoAccepts reports whether the contact's policy accepts offer without
+// asking
+func (mm *MessageManager) autoAccepts(offer *FileOffer) bool {
+	limit := mm.contactPolicy(offer.PeerID.String()).AutoAcceptBytes()
+	return limit > 0 && offer.Size <= limit
+}
+
+// sendReadReceipt tells the sender of msg it was shown, if the policy of
+// the contact asks for read receipts and the peer understands them
+func (mm *MessageManager) sendReadReceipt(msg *Message) {
+	if msg.fromPeer == "" || !mm.contactPolicy(msg.fromPeer.String()).ReadReceipts {
+		return
+	}
+	if !mm.peerSupports(msg.fromPeer, FeatureReadReceipts) {
+		return
+	}
+	if err := mm.SendNotice(msg.fromPeer.String(), NewNotice(NoticeMessageRead, "message_id", msg.ID)); err != nil {
+		mm.logger.WithError(err).WithField("message_id", msg.ID).Debug("Failed to send read receipt")
+	}
+}
+
+// isReadReceipt reports whether msg is a read receipt, which is not kept
+// in the history
+func isReadReceipt(msg *Message) bool {
+	if msg.Type != MessageTypeSystem || msg.Metadata[MetadataKind] != KindNotice {
+		return false
+	}
+	var notice Notice
+	return json.Unmarshal(msg.Content, &notice) == nil && notice.Code == NoticeMessageRead
+}
+
+// runDisappearingMessages deletes the messages exchanged with contacts that
+// set a disappearing time once they are older than it, every
+// DisappearInterval until the manager stops. Only the history of this node
+// is affected; the peer keeps its copy unless its own policy removes it.
+func (mm *MessageManager) runDisappearingMessages() {
+	defer mm.wg.Done()
+
+	ticker := time.NewTicker(DisappearInterval)
+	defer ticker.Stop()
+	for {
+		mm.expireMessages(time.Now())
+		select {
+		case <-ticker.C:
+		case <-mm.ctx.Done():
+			return
+		}
+	}
+}
+
+// expireMessages deletes the messages past the disappearing time of their
+// contact as of now
+func (mm *MessageManager) expireMessages(now time.Time) {
+	expirer, ok := mm.historyRecorder().(HistoryExpirer)
+	if !ok || mm.contacts == nil {
+		return
+	}
+	for _, contact := range mm.contacts.List() {
+		ttl := contact.EffectivePolicy().DisappearAfter()
+		if ttl <= 0 {
+			continue
+		}
+		deleted, err := expirer.ExpireHistory(contact.PeerID, now.Add(-ttl))
+		if err != nil {
+			mm.logger.WithError(err).WithField("contact", contact.Name).Warn("Failed to delete disappearing messages")
+			continue
+		}
+		if deleted > 0 {
+			mm.logger.WithFields(logrus.Fields{
+				"contact":  contact.Name,
+				"messages": deleted,
+			}).Info("Disappearing messages deleted")
+		}
+	}
+}
@@ -0,0 +1,578 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Directory transfer protocol constants
+	DirMaxFiles        = 100000           // Maximum number of files in one manifest
+	DirMaxManifestSize = 32 * 1024 * 1024 // Maximum encoded manifest size
+	DirScanTimeout     = 10 * time.Minute // Time the receiver may spend hashing existing files
+)
+
+// ManifestEntry describes one file of a directory transfer
+type ManifestEntry struct {
+	Path    string    `json:"path"` // Slash-separated, relative to the directory
+	Size    int64     `json:"size"`
+	Hash    string    `json:"hash"` // SHA256 of the content
+	ModTime time.Time `json:"mod_time"`
+}
+
+// DirManifest lists the files of a directory with their hashes
+type DirManifest struct {
+	ID        string          `json:"id"`
+	Root      string          `json:"root"` // Base name of the directory
+	Files     []ManifestEntry `json:"files"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// TotalSize returns the combined size of all files in the manifest
+func (m *DirManifest) TotalSize() int64 {
+	var total int64
+	for _, f := range m.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// Validate checks that the manifest is safe to apply on the receiving side
+func (m *DirManifest) Validate() error {
+	root := filepath.Base(m.Root)
+	if m.ID == "" || root != m.Root || !filepath.IsLocal(root) {
+		return fmt.Errorf("invalid manifest root %q", m.Root)
+	}
+	if len(m.Files) > DirMaxFiles {
+		return fmt.Errorf("manifest lists too many files: %d", len(m.Files))
+	}
+
+	seen := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		if !validManifestPath(f.Path) {
+			return fmt.Errorf("invalid path in manifest: %q", f.Path)
+		}
+		if seen[f.Path] {
+			return fmt.Errorf("duplicate path in manifest: %q", f.Path)
+		}
+		seen[f.Path] = true
+
+		if f.Size < 0 || f.Size > MaxFileSize || len(f.Hash) != 64 {
+			return fmt.Errorf("invalid manifest entry for %q", f.Path)
+		}
+	}
+
+	return nil
+}
+
+// validManifestPath reports whether p is a clean relative path that stays
+// inside the directory
+func validManifestPath(p string) bool {
+	if p == "" || strings.Contains(p, "\\") || strings.HasSuffix(p, ".part") {
+		return false
+	}
+	return filepath.IsLocal(filepath.FromSlash(p)) && filepath.ToSlash(filepath.Clean(filepath.FromSlash(p))) == p
+}
+
+// BuildDirManifest hashes every regular file below dir. Symlinks and
+// partially received files are skipped.
+func BuildDirManifest(dir string) (*DirManifest, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &DirManifest{
+		ID:        uuid.New().String(),
+		Root:      filepath.Base(abs),
+		CreatedAt: time.Now(),
+	}
+
+	err = filepath.WalkDir(abs, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, ".part") {
+			return nil
+		}
+
+		if len(manifest.Files) >= DirMaxFiles {
+			return fmt.Errorf("directory has more than %d files", DirMaxFiles)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hash, err := CalculateFileHash(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(abs, path)
+		if err != nil {
+			return err
+		}
+
+		manifest.Files = append(manifest.Files, ManifestEntry{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			Hash:    hash,
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan directory: %w", err)
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	return manifest, nil
+}
+
+// DirSyncFrame is a message of the directory transfer protocol
+type DirSyncFrame struct {
+	Magic    uint32       `json:"magic"`
+	Type     string       `json:"type"` // "manifest", "pending", "need", "done", "complete", "error"
+	Manifest *DirManifest `json:"manifest,omitempty"`
+	Paths    []string     `json:"paths,omitempty"` // Files the receiver still needs
+	Skipped  int          `json:"skipped,omitempty"`
+	Copied   int          `json:"copied,omitempty"`
+	Received int          `json:"received,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Code     ErrorCode    `json:"code,omitempty"`
+}
+
+// DirSyncResult summarises a directory transfer
+type DirSyncResult struct {
+	Root      string
+	Files     int   // Files in the manifest
+	Sent      int   // Files transferred over the network
+	Skipped   int   // Files the receiver already had at the same path
+	Copied    int   // Files the receiver copied from identical local content
+	BytesSent int64 // Size of the transferred files
+}
+
+// writeDirFrame sends a directory protocol frame with a deadline
+func writeDirFrame(stream network.Stream, frame DirSyncFrame, timeout time.Duration) error {
+	frame.Magic = FileTransferMagic
+	_ = stream.SetWriteDeadline(time.Now().Add(timeout))
+	return writeFrame(stream, frame)
+}
+
+// readDirFrame receives a directory protocol frame; a zero timeout waits
+// without a deadline
+func readDirFrame(stream network.Stream, timeout time.Duration) (*DirSyncFrame, error) {
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	_ = stream.SetReadDeadline(deadline)
+
+	var frame DirSyncFrame
+	if err := readFrame(stream, DirMaxManifestSize, &frame); err != nil {
+		return nil, err
+	}
+	if frame.Magic != FileTransferMagic {
+		return nil, fmt.Errorf("invalid magic number: %x", frame.Magic)
+	}
+	return &frame, nil
+}
+
+// SendDirectory transfers a directory. The receiver first gets a manifest of
+// per-file hashes and answers with the files it is missing; only those are
+// sent, each as a resumable file transfer opened with openFile.
+func (ftm *FileTransferManager) SendDirectory(ctx context.Context, openDir, openFile StreamOpener, dir string, peerID peer.ID) (*DirSyncResult, error) {
+	manifest, err := BuildDirManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ftm.logger.WithFields(logrus.Fields{
+		"manifest_id": manifest.ID,
+		"root":        manifest.Root,
+		"files":       len(manifest.Files),
+		"total_size":  manifest.TotalSize(),
+		"peer_id":     peerID.String(),
+	}).Info("Starting directory transfer")
+
+	stream, err := openDir(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open directory stream: %w", err)
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	if err := writeDirFrame(stream, DirSyncFrame{Type: "manifest", Manifest: manifest}, ftm.StallTimeout); err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("failed to send manifest: %w", err)
+	}
+
+	// The receiver may ask its user, then hashes its existing files before
+	// answering
+	scanTimeout := max(DirScanTimeout, TimeoutsFrom(ctx).File)
+	response, err := readDirFrame(stream, scanTimeout)
+	for waiting := false; err == nil && response.Type == "pending"; response, err = readDirFrame(stream, scanTimeout) {
+		if !waiting {
+			waiting = true
+			ftm.logger.WithField("manifest_id", manifest.ID).Info("Waiting for the receiver to accept the directory")
+		}
+	}
+	if err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("no response to manifest: %w", err)
+	}
+	if response.Type != "need" {
+		return nil, fmt.Errorf("directory transfer rejected: %w", remoteError(response.Code, response.Error))
+	}
+
+	entries := make(map[string]ManifestEntry, len(manifest.Files))
+	for _, f := range manifest.Files {
+		entries[f.Path] = f
+	}
+
+	result := &DirSyncResult{
+		Root:    manifest.Root,
+		Files:   len(manifest.Files),
+		Skipped: response.Skipped,
+		Copied:  response.Copied,
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range response.Paths {
+		entry, ok := entries[p]
+		if !ok {
+			_ = stream.Reset()
+			return nil, fmt.Errorf("receiver asked for unknown file %q", p)
+		}
+
+		filePath := filepath.Join(abs, filepath.FromSlash(p))
+		metadata, err := CreateFileMetadata(filePath)
+		if err != nil {
+			_ = stream.Reset()
+			return nil, err
+		}
+		if metadata.Hash != entry.Hash {
+			_ = stream.Reset()
+			return nil, fmt.Errorf("%s changed during the transfer", p)
+		}
+		metadata.SyncID = manifest.ID
+		metadata.Path = p
+
+		if err := ftm.sendFile(ctx, openFile, metadata, filePath, peerID); err != nil {
+			_ = stream.Reset()
+			return nil, fmt.Errorf("failed to send %s: %w", p, err)
+		}
+
+		result.Sent++
+		result.BytesSent += entry.Size
+	}
+
+	if err := writeDirFrame(stream, DirSyncFrame{Type: "done"}, ftm.StallTimeout); err != nil {
+		return nil, fmt.Errorf("failed to finish directory transfer: %w", err)
+	}
+
+	final, err := readDirFrame(stream, ftm.StallTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("no confirmation of directory transfer: %w", err)
+	}
+	if final.Type != "complete" {
+		return nil, fmt.Errorf("directory transfer failed: %w", remoteError(final.Code, final.Error))
+	}
+
+	ftm.logger.WithFields(logrus.Fields{
+		"manifest_id": manifest.ID,
+		"sent":        result.Sent,
+		"skipped":     result.Skipped,
+		"copied":      result.Copied,
+	}).Info("Directory transfer completed")
+
+	return result, nil
+}
+
+// dirSession tracks an incoming directory transfer
+type dirSession struct {
+	peerID   peer.ID
+	root     string                   // Destination directory
+	needed   map[string]ManifestEntry // Files still to be received, by path
+	received map[string]bool
+}
+
+// ReceiveDirectory serves one incoming directory transfer into downloadDir.
+// Files already present with the same hash are kept, and files whose content
+// exists elsewhere in the destination are copied locally instead of sent.
+func (ftm *FileTransferManager) ReceiveDirectory(ctx context.Context, stream network.Stream, remotePeer peer.ID, downloadDir string) error {
+	frame, err := readDirFrame(stream, ftm.StallTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if frame.Type != "manifest" || frame.Manifest == nil {
+		return fmt.Errorf("unexpected directory frame: %s", frame.Type)
+	}
+
+	manifest := frame.Manifest
+	if err := manifest.Validate(); err != nil {
+		_ = writeDirFrame(stream, DirSyncFrame{Type: "error", Error: err.Error(), Code: ErrCodeInvalid}, ftm.StallTimeout)
+		return err
+	}
+
+	// Nothing is written or copied before the directory is accepted
+	offer := &FileOffer{
+		TransferID: manifest.ID,
+		PeerID:     remotePeer,
+		Name:       manifest.Root,
+		Size:       manifest.TotalSize(),
+		Directory:  true,
+		Files:      len(manifest.Files),
+		OfferedAt:  time.Now(),
+	}
+	pe, err := ftm.approve(ctx, offer, func() error {
+		return writeDirFrame(stream, DirSyncFrame{Type: "pending"}, ftm.StallTimeout)
+	})
+	if err != nil {
+		return err
+	}
+	if pe != nil {
+		_ = writeDirFrame(stream, DirSyncFrame{Type: "error", Error: pe.Message, Code: pe.Code}, ftm.StallTimeout)
+		return pe
+	}
+
+	root := filepath.Join(downloadDir, manifest.Root)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	need, skipped, copied, err := planDirReceive(root, manifest)
+	if err != nil {
+		_ = writeDirFrame(stream, DirSyncFrame{Type: "error", Error: "failed to scan destination", Code: ErrCodeInternal}, ftm.StallTimeout)
+		return err
+	}
+
+	var needBytes int64
+	for _, entry := range need {
+		needBytes += entry.Size
+	}
+	if pe := ftm.checkSpace(needBytes); pe != nil {
+		_ = writeDirFrame(stream, DirSyncFrame{Type: "error", Error: pe.Message, Code: pe.Code}, ftm.StallTimeout)
+		return pe
+	}
+
+	session := &dirSession{
+		peerID:   remotePeer,
+		root:     root,
+		needed:   make(map[string]ManifestEntry, len(need)),
+		received: make(map[string]bool, len(need)),
+	}
+	paths := make([]string, 0, len(need))
+	for _, entry := range need {
+		session.needed[entry.Path] = entry
+		paths = append(paths, entry.Path)
+	}
+
+	ftm.mu.Lock()
+	ftm.syncs[manifest.ID] = session
+	ftm.mu.Unlock()
+	defer func() {
+		ftm.mu.Lock()
+		delete(ftm.syncs, manifest.ID)
+		ftm.mu.Unlock()
+	}()
+
+	ftm.logger.WithFields(logrus.Fields{
+		"manifest_id": manifest.ID,
+		"root":        root,
+		"files":       len(manifest.Files),
+		"needed":      len(paths),
+		"skipped":     skipped,
+		"copied":      copied,
+		"peer":        remotePeer.String(),
+	}).Info("Directory transfer accepted")
+
+	response := DirSyncFrame{Type: "need", Paths: paths, Skipped: skipped, Copied: copied}
+	if err := writeDirFrame(stream, response, ftm.StallTimeout); err != nil {
+		return fmt.Errorf("failed to send needed files: %w", err)
+	}
+
+	// Files arrive on their own streams; wait for the sender to finish
+	done := make(chan error, 1)
+	go func() {
+		frame, err := readDirFrame(stream, 0)
+		if err == nil && frame.Type != "done" {
+			err = fmt.Errorf("unexpected directory frame: %s", frame.Type)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("directory transfer interrupted: %w", err)
+		}
+	case <-ctx.Done():
+		_ = stream.Reset()
+		return ctx.Err()
+	}
+
+	ftm.mu.RLock()
+	received := len(session.received)
+	ftm.mu.RUnlock()
+
+	if received < len(paths) {
+		msg := fmt.Sprintf("%d of %d files missing", len(paths)-received, len(paths))
+		_ = writeDirFrame(stream, DirSyncFrame{Type: "error", Error: msg, Code: ErrCodeIntegrity}, ftm.StallTimeout)
+		return fmt.Errorf("directory transfer incomplete: %s", msg)
+	}
+
+	return writeDirFrame(stream, DirSyncFrame{Type: "complete", Received: received, Skipped: skipped, Copied: copied}, ftm.StallTimeout)
+}
+
+// planDirReceive decides which manifest files must be sent. Files already at
+// their path with the same hash are skipped; files whose content exists at
+// another path below root are copied locally.
+func planDirReceive(root string, manifest *DirManifest) (need []ManifestEntry, skipped, copied int, err error) {
+	// Only files with a size that appears in the manifest can match
+	sizes := make(map[int64]bool, len(manifest.Files))
+	for _, f := range manifest.Files {
+		sizes[f.Size] = true
+	}
+
+	byHash := make(map[string]string) // content hash -> existing file
+	atPath := make(map[string]string) // relative path -> content hash
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, ".part") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !sizes[info.Size()] {
+			return err
+		}
+		hash, err := CalculateFileHash(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if _, exists := byHash[hash]; !exists {
+			byHash[hash] = path
+		}
+		atPath[filepath.ToSlash(rel)] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	for _, f := range manifest.Files {
+		if atPath[f.Path] == f.Hash {
+			skipped++
+			continue
+		}
+		if src, ok := byHash[f.Hash]; ok {
+			dest := filepath.Join(root, filepath.FromSlash(f.Path))
+			if err := copyFile(src, dest); err == nil {
+				copied++
+				continue
+			}
+		}
+		need = append(need, f)
+	}
+
+	return need, skipped, copied, nil
+}
+
+// copyFile copies src to dest, replacing dest atomically
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	tmp := dest + ".copy.part"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+// syncDestination returns where a file of an active directory transfer is
+// stored, rejecting files that were not requested
+func (ftm *FileTransferManager) syncDestination(remotePeer peer.ID, metadata FileMetadata) (string, error) {
+	ftm.mu.RLock()
+	defer ftm.mu.RUnlock()
+
+	session, ok := ftm.syncs[metadata.SyncID]
+	if !ok || session.peerID != remotePeer {
+		return "", fmt.Errorf("unknown directory transfer")
+	}
+
+	entry, ok := session.needed[metadata.Path]
+	if !ok || entry.Hash != metadata.Hash || entry.Size != metadata.Size {
+		return "", fmt.Errorf("file %q was not requested", metadata.Path)
+	}
+
+	return filepath.Join(session.root, filepath.FromSlash(metadata.Path)), nil
+}
+
+// markSyncReceived records a completed file of a directory transfer
+func (ftm *FileTransferManager) markSyncReceived(metadata FileMetadata) {
+	if metadata.SyncID == "" {
+		return
+	}
+
+	ftm.mu.Lock()
+	defer ftm.mu.Unlock()
+
+	if session, ok := ftm.syncs[metadata.SyncID]; ok {
+		session.received[metadata.Path] = true
+	}
+}
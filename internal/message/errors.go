@@ -0,0 +1,100 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is a machine-readable reason a peer refused a message or transfer
+type ErrorCode string
+
+const (
+	ErrCodeTooLarge     ErrorCode = "too_large"            // Message or file exceeds the receiver's limit
+	ErrCodeQuota        ErrorCode = "quota_exceeded"       // Receiver is out of storage
+	ErrCodePolicy       ErrorCode = "policy_rejected"      // Receiver does not accept this from the sender
+	ErrCodeUnsupported  ErrorCode = "unsupported_type"     // Receiver cannot handle this kind of message
+	ErrCodeInvalid      ErrorCode = "invalid"              // Malformed message or metadata
+	ErrCodeBusy         ErrorCode = "busy"                 // Receiver is overloaded; retry later
+	ErrCodeIntegrity    ErrorCode = "integrity"            // Received data did not match its hash
+	ErrCodeNotFound     ErrorCode = "not_found"            // Receiver holds nothing under the requested ID
+	ErrCodeInternal     ErrorCode = "internal"             // Unexpected failure on the receiver
+	ErrCodeIncompatible ErrorCode = "incompatible_version" // Peers share no protocol version
+	ErrCodeSession      ErrorCode = "session_failed"       // Receiver cannot decrypt the message in its session
+)
+
+// ProtocolError is an error reported by the remote peer. It travels in
+// protocol frames so the sender can tell why it was refused instead of
+// waiting for a timeout.
+type ProtocolError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message,omitempty"`
+}
+
+// NewProtocolError creates a protocol error with a formatted message
+func NewProtocolError(code ErrorCode, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Error implements the error interface
+func (e *ProtocolError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("peer refused: %s", e.Code)
+	}
+	return fmt.Sprintf("peer refused: %s (%s)", e.Message, e.Code)
+}
+
+// Retryable reports whether sending again later may succeed
+func (e *ProtocolError) Retryable() bool {
+	switch e.Code {
+	case ErrCodeBusy, ErrCodeQuota, ErrCodeIntegrity, ErrCodeInternal:
+		return true
+	default:
+		return false
+	}
+}
+
+// Hint suggests what the user can do about the error
+func (e *ProtocolError) Hint() string {
+	switch e.Code {
+	case ErrCodeTooLarge:
+		return "Split the content or send it as a file; files are limited to 100 MB"
+	case ErrCodeQuota:
+		return "A storage quota is full; free up space or raise it with 'peerchat-cli quota set'"
+	case ErrCodePolicy:
+		return "The peer does not accept this from you; check that they added you as a contact or shared the folder"
+	case ErrCodeUnsupported:
+		return "The peer's client does not support this; ask them to update"
+	case ErrCodeInvalid:
+		return "The peer could not read the data; check that both clients are up to date"
+	case ErrCodeBusy:
+		return "The peer is overloaded; the message will be retried"
+	case ErrCodeIntegrity:
+		return "The data was corrupted in transit; send it again"
+	case ErrCodeNotFound:
+		return "The peer holds nothing under this ID; check that you asked the right peer"
+	case ErrCodeIncompatible:
+		return "The peer's client speaks an incompatible protocol version; whoever runs the older client needs to update"
+	case ErrCodeSession:
+		return "The encrypted session with the peer is broken; reset it with /reset-session <peer>"
+	default:
+		return "Try again later"
+	}
+}
+
+// AsProtocolError returns the protocol error wrapped in err, if any
+func AsProtocolError(err error) (*ProtocolError, bool) {
+	var pe *ProtocolError
+	if errors.As(err, &pe) {
+		return pe, true
+	}
+	return nil, false
+}
+
+// remoteError turns an error frame from a peer into a protocol error. Peers
+// that predate error codes send only a message.
+func remoteError(code ErrorCode, message string) *ProtocolError {
+	if code == "" {
+		code = ErrCodeInternal
+	}
+	return &ProtocolError{Code: code, Message: message}
+}
@@ -0,0 +1,131 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// FileCompressionZstd compresses each chunk on its own with zstd, so
+	// chunks can travel on any stream and a transfer can resume anywhere
+	FileCompressionZstd = "zstd"
+
+	// fileCompressionMisses is how many chunks in a row may fail to shrink
+	// before the sender stops trying for the rest of the attempt
+	fileCompressionMisses = 8
+)
+
+// incompressibleTypes are already compressed; compressing them again only
+// costs time
+var incompressibleTypes = []string{"image/", "video/", "audio/", "application/zip", "application/gzip", "application/pdf"}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodecs returns the shared chunk encoder and decoder, created on first
+// use so nodes that never compress do not pay for them
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithWindowSize(FileChunkSize),
+			zstd.WithLowerEncoderMem(true))
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil,
+			zstd.WithDecoderMaxMemory(FileChunkSize),
+			zstd.WithDecoderLowmem(true))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// offerCompression returns the compression to offer for a file, or "" when
+// it is off or the file is already compressed
+func (ftm *FileTransferManager) offerCompression(metadata FileMetadata) string {
+	if !ftm.Compression {
+		return ""
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(metadata.MimeType, prefix) {
+			return ""
+		}
+	}
+	return FileCompressionZstd
+}
+
+// acceptCompression returns the compression to agree to when the sender
+// offers offered, or "" to receive chunks as they are
+func (ftm *FileTransferManager) acceptCompression(offered string) string {
+	if !ftm.Compression || offered != FileCompressionZstd {
+		return ""
+	}
+	if _, _, err := zstdCodecs(); err != nil {
+		ftm.logger.WithError(err).Warn("zstd unavailable, receiving files uncompressed")
+		return ""
+	}
+	return offered
+}
+
+// chunkCompressor compresses the chunks of one transfer attempt, sending
+// those that do not shrink as they are
+type chunkCompressor struct {
+	encoder *zstd.Encoder
+	misses  int
+}
+
+// newChunkCompressor returns a compressor for the agreed compression, or
+// nil to send chunks as they are
+func newChunkCompressor(agreed string) *chunkCompressor {
+	if agreed != FileCompressionZstd {
+		return nil
+	}
+	encoder, _, err := zstdCodecs()
+	if err != nil {
+		return nil
+	}
+	return &chunkCompressor{encoder: encoder}
+}
+
+// compress returns the chunk to send and the compression applied to it
+func (c *chunkCompressor) compress(data []byte) ([]byte, string) {
+	if c == nil || c.misses >= fileCompressionMisses {
+		return data, ""
+	}
+
+	compressed := c.encoder.EncodeAll(data, make([]byte, 0, len(data)))
+	if len(compressed) >= len(data)-len(data)/16 {
+		c.misses++
+		return data, ""
+	}
+	c.misses = 0
+	return compressed, FileCompressionZstd
+}
+
+// decodeChunk returns the data of a received chunk, decompressing it if the
+// sender compressed it with the agreed compression
+func decodeChunk(frame *FileTransferRequest, agreed string) ([]byte, error) {
+	if frame.Compression == "" {
+		return frame.Data, nil
+	}
+	if frame.Compression != agreed {
+		return nil, fmt.Errorf("chunk %d uses %q compression, which was not agreed", frame.ChunkID, frame.Compression)
+	}
+
+	_, decoder, err := zstdCodecs()
+	if err != nil {
+		return nil, err
+	}
+	data, err := decoder.DecodeAll(frame.Data, make([]byte, 0, FileChunkSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk %d: %w", frame.ChunkID, err)
+	}
+	return data, nil
+}
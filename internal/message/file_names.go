@@ -0,0 +1,110 @@
+package message
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxFileNameLength is the longest name a peer may send, the limit of
+	// common file systems
+	maxFileNameLength = 255
+
+	// maxStoredNameLength is the longest name a received file is stored
+	// under, leaving room for " (n)" and the partial file suffix
+	maxStoredNameLength = 200
+)
+
+// reservedNames are device names Windows will not create files under, with
+// or without an extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// ValidateFileName rejects a name offered for a single file that is not a
+// plain file name: empty, "." or "..", an absolute path, anything with a
+// path separator, or longer than file systems allow
+func ValidateFileName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("invalid file name %q", name)
+	case len(name) > maxFileNameLength:
+		return fmt.Errorf("file name is %d bytes, longer than %d", len(name), maxFileNameLength)
+	case filepath.IsAbs(name) || filepath.VolumeName(name) != "":
+		return fmt.Errorf("file name %q is an absolute path", name)
+	case strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("file name %q contains a path", name)
+	}
+	return nil
+}
+
+// ValidateFileHash rejects a file hash that is not a lowercase hex SHA-256
+// digest
+func ValidateFileHash(hash string) error {
+	if len(hash) != sha256.Size*2 {
+		return fmt.Errorf("file hash is %d characters, not %d", len(hash), sha256.Size*2)
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("file hash %q is not lowercase hex", hash)
+		}
+	}
+	return nil
+}
+
+// SanitizeFileName returns a name a received file can safely be stored
+// under on any platform: control and reserved characters become "_",
+// leading and trailing dots and spaces are dropped so nothing arrives
+// hidden, device names are prefixed and long names are shortened keeping
+// their extension
+func SanitizeFileName(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "file"
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if reservedNames[strings.ToUpper(base)] {
+		base = "_" + base
+	}
+	if len(ext) > maxStoredNameLength/4 {
+		base, ext = base+ext, ""
+	}
+	for len(base)+len(ext) > maxStoredNameLength {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	return base + ext
+}
+
+// UniqueFilePath returns path if nothing exists there, otherwise the first
+// free "name (n).ext" next to it
+func UniqueFilePath(path string) string {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
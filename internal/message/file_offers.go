@@ -0,0 +1,240 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FileAllowlistFileName lists the peers whose files are accepted
+	// without asking
+	FileAllowlistFileName = "file_allowlist.json"
+
+	// FileOfferTimeout is how long an offered file waits for the user
+	FileOfferTimeout = 2 * time.Minute
+)
+
+// FileOffer is a file or directory a peer wants to send
+type FileOffer struct {
+	Number     int // Local number to accept or reject the offer by
+	TransferID string
+	PeerID     peer.ID
+	Name       string // File name, or root of a directory
+	Size       int64
+	Directory  bool
+	Files      int // Files in a directory
+	OfferedAt  time.Time
+}
+
+// approve asks Approve whether to accept offer before anything is written.
+// keepAlive is called every AckInterval so the sender keeps waiting while
+// the user decides; the error reports a sender that went away.
+func (ftm *FileTransferManager) approve(ctx context.Context, offer *FileOffer, keepAlive func() error) (*ProtocolError, error) {
+	if ftm.Approve == nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	decision := make(chan *ProtocolError, 1)
+	go func() { decision <- ftm.Approve(ctx, offer) }()
+
+	ticker := time.NewTicker(ftm.AckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case pe := <-decision:
+			return pe, nil
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return nil, fmt.Errorf("sender left while the file waited for approval: %w", err)
+			}
+		}
+	}
+}
+
+// pendingOffer is an offer waiting for the user's answer
+type pendingOffer struct {
+	offer  *FileOffer
+	answer chan bool
+}
+
+// SetFileOfferHandler makes incoming files from peers not on the allowlist
+// wait for an answer through AnswerFileOffer, announcing each to handler.
+// Without a handler they are refused.
+func (mm *MessageManager) SetFileOfferHandler(handler func(offer *FileOffer)) {
+	mm.offersMu.Lock()
+	defer mm.offersMu.Unlock()
+	mm.onFileOffer = handler
+}
+
+// approveFileOffer accepts offers from allowlisted peers and files within
+// the auto-accept size of the contact's policy, and asks the user through
+// the file offer handler about the others. Without anyone to ask, offers are
+// refused.
+func (mm *MessageManager) approveFileOffer(ctx context.Context, offer *FileOffer) *ProtocolError {
+	mm.fireHooks(HookEvent{PeerID: offer.PeerID.String(), Class: MessageTypeFile.String()})
+	if mm.fileAllowlist.Allows(offer.PeerID.String()) || mm.autoAccepts(offer) {
+		return nil
+	}
+
+	mm.offersMu.Lock()
+	ask := mm.onFileOffer
+	if ask == nil {
+		mm.offersMu.Unlock()
+		return NewProtocolError(ErrCodePolicy, "receiver does not accept files from you automatically")
+	}
+	mm.offerSeq++
+	offer.Number = mm.offerSeq
+	pending := &pendingOffer{offer: offer, answer: make(chan bool, 1)}
+	mm.offers[offer.Number] = pending
+	mm.offersMu.Unlock()
+
+	defer func() {
+		mm.offersMu.Lock()
+		delete(mm.offers, offer.Number)
+		mm.offersMu.Unlock()
+	}()
+
+	mm.logger.WithFields(logrus.Fields{
+		"transfer_id": offer.TransferID,
+		"peer":        offer.PeerID.String(),
+		"name":        offer.Name,
+		"size":        offer.Size,
+	}).Info("Asking the user to accept an incoming file")
+	ask(offer)
+
+	timer := time.NewTimer(FileOfferTimeout)
+	defer timer.Stop()
+	select {
+	case accepted := <-pending.answer:
+		if accepted {
+			return nil
+		}
+		return NewProtocolError(ErrCodePolicy, "receiver declined the file")
+	case <-timer.C:
+		return NewProtocolError(ErrCodePolicy, "receiver did not accept the file within %s", FileOfferTimeout)
+	case <-ctx.Done():
+		return NewProtocolError(ErrCodeBusy, "receiver is shutting down")
+	}
+}
+
+// FileOffers returns the offers waiting for an answer, oldest first
+func (mm *MessageManager) FileOffers() []*FileOffer {
+	mm.offersMu.Lock()
+	defer mm.offersMu.Unlock()
+
+	offers := make([]*FileOffer, 0, len(mm.offers))
+	for _, pending := range mm.offers {
+		offers = append(offers, pending.offer)
+	}
+	sort.Slice(offers, func(i, j int) bool { return offers[i].Number < offers[j].Number })
+	return offers
+}
+
+// AnswerFileOffer accepts or rejects the waiting offer with the given number.
+// Number 0 answers the only waiting offer.
+func (mm *MessageManager) AnswerFileOffer(number int, accept bool) (*FileOffer, error) {
+	mm.offersMu.Lock()
+	defer mm.offersMu.Unlock()
+
+	if number == 0 {
+		if len(mm.offers) != 1 {
+			return nil, fmt.Errorf("%d files are waiting, give the number of one", len(mm.offers))
+		}
+		for n := range mm.offers {
+			number = n
+		}
+	}
+
+	pending, ok := mm.offers[number]
+	if !ok {
+		return nil, fmt.Errorf("no file %d is waiting", number)
+	}
+	delete(mm.offers, number)
+	pending.answer <- accept
+	return pending.offer, nil
+}
+
+// LoadFileAllowlist reads the peers whose files are accepted without asking.
+// A missing file means no peer.
+func LoadFileAllowlist(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var peers []string
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("failed to parse file allowlist: %w", err)
+	}
+	return peers, nil
+}
+
+// SaveFileAllowlist writes the peers whose files are accepted without asking
+func SaveFileAllowlist(path string, peers []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if peers == nil {
+		peers = []string{}
+	}
+	data, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// allowlistSource caches the file allowlist, reloading it when the file
+// changes so CLI edits apply to a running node
+type allowlistSource struct {
+	path   string
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	modTime time.Time
+	loaded  bool
+	peers   []string
+}
+
+// newAllowlistSource creates a source for the allowlist at path
+func newAllowlistSource(path string, logger *logrus.Logger) *allowlistSource {
+	return &allowlistSource{path: path, logger: logger}
+}
+
+// Allows reports whether files from peerID are accepted without asking
+func (s *allowlistSource) Allows(peerID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modTime time.Time
+	if info, err := os.Stat(s.path); err == nil {
+		modTime = info.ModTime()
+	}
+	if !s.loaded || !modTime.Equal(s.modTime) {
+		peers, err := LoadFileAllowlist(s.path)
+		if err != nil {
+			// Keep the previous list rather than accepting everything
+			s.logger.WithError(err).Warn("Failed to load file allowlist")
+			peers = s.peers
+		}
+		s.peers, s.modTime, s.loaded = peers, modTime, true
+	}
+	return slices.Contains(s.peers, peerID)
+}
@@ -0,0 +1,190 @@
+package message
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FileMaxStreams is the default number of streams a large file is split
+	// across, the first one included
+	FileMaxStreams = 4
+
+	// FileStreamsLimit caps the configured number of streams per file
+	FileStreamsLimit = 16
+
+	// FileParallelThreshold is the size from which files are split across
+	// streams; smaller files are not worth the extra streams
+	FileParallelThreshold = 8 * 1024 * 1024
+
+	// FileJoin attaches an extra stream to a transfer accepted on another one
+	FileJoin = "join"
+)
+
+// streamsFor returns the number of streams to offer for a file of size
+// bytes, or zero to use a single stream
+func (ftm *FileTransferManager) streamsFor(size int64) int {
+	if ftm.MaxStreams <= 1 || size < FileParallelThreshold {
+		return 0
+	}
+	return min(ftm.MaxStreams, FileStreamsLimit)
+}
+
+// acceptStreams returns the number of streams to allow when the sender
+// offers offered, or zero to use a single stream
+func (ftm *FileTransferManager) acceptStreams(offered int) int {
+	streams := min(offered, ftm.MaxStreams, FileStreamsLimit)
+	if streams <= 1 {
+		return 0
+	}
+	return streams
+}
+
+// openLanes opens up to n extra streams for an accepted transfer. A stream
+// the receiver does not take ends the search; the transfer continues on the
+// streams it has.
+func (ftm *FileTransferManager) openLanes(ctx context.Context, open StreamOpener, transfer *FileTransfer, n int) []*fileStream {
+	var lanes []*fileStream
+	for len(lanes) < n {
+		stream, err := open(ctx)
+		if err != nil {
+			ftm.logger.WithError(err).Debug("Failed to open extra file stream")
+			break
+		}
+
+		lane := ftm.newFileStream(stream)
+		err = lane.write(FileTransferRequest{Type: FileJoin, Metadata: FileMetadata{ID: transfer.ID}})
+		var response *FileTransferRequest
+		if err == nil {
+			response, err = lane.read()
+		}
+		if err != nil || response.Type != "accept" {
+			ftm.logger.WithError(err).Debug("Receiver did not take extra file stream")
+			_ = stream.Reset()
+			break
+		}
+		lanes = append(lanes, lane)
+	}
+
+	if len(lanes) > 0 {
+		ftm.logger.WithFields(logrus.Fields{
+			"transfer_id": transfer.ID,
+			"streams":     len(lanes) + 1,
+		}).Debug("Sending file over parallel streams")
+	}
+	return lanes
+}
+
+// chunkScheduler hands chunks to whichever stream is free, so faster
+// streams carry more of the file
+type chunkScheduler struct {
+	jobs  chan FileTransferRequest
+	errs  chan error
+	lanes []*fileStream
+	wg    sync.WaitGroup
+}
+
+// startChunkScheduler sends chunks over the main stream and the extra lanes
+func startChunkScheduler(main *fileStream, lanes []*fileStream) *chunkScheduler {
+	s := &chunkScheduler{
+		jobs:  make(chan FileTransferRequest),
+		errs:  make(chan error, len(lanes)+1),
+		lanes: lanes,
+	}
+
+	for _, fs := range append([]*fileStream{main}, lanes...) {
+		s.wg.Add(1)
+		go func(fs *fileStream) {
+			defer s.wg.Done()
+			for frame := range s.jobs {
+				if err := fs.write(frame); err != nil {
+					s.errs <- err
+					return
+				}
+			}
+		}(fs)
+	}
+	return s
+}
+
+// send queues a chunk, failing if a stream has failed
+func (s *chunkScheduler) send(ctx context.Context, frame FileTransferRequest) error {
+	select {
+	case s.jobs <- frame:
+		return nil
+	case err := <-s.errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop ends the workers and closes the extra lanes
+func (s *chunkScheduler) stop() {
+	close(s.jobs)
+	for _, lane := range s.lanes {
+		_ = lane.stream.Close()
+	}
+	s.wg.Wait()
+}
+
+// failed returns the channel reporting stream failures; nil without a
+// scheduler, so selecting on it never fires
+func (s *chunkScheduler) failed() <-chan error {
+	if s == nil {
+		return nil
+	}
+	return s.errs
+}
+
+// joinTransfer serves an extra stream of an incoming transfer accepted on
+// another stream, passing its chunks on until the transfer ends
+func (ftm *FileTransferManager) joinTransfer(fs *fileStream, remotePeer peer.ID, request *FileTransferRequest) error {
+	ftm.mu.RLock()
+	var frames chan<- *FileTransferRequest
+	var stop <-chan struct{}
+	if transfer, ok := ftm.transfers[request.Metadata.ID]; ok && !transfer.isOutgoing && transfer.PeerID == remotePeer {
+		frames, stop = transfer.lanes, transfer.laneStop
+	}
+	ftm.mu.RUnlock()
+
+	if frames == nil {
+		return fs.refuse("reject", NewProtocolError(ErrCodeNotFound, "no transfer to join"))
+	}
+	if err := fs.write(FileTransferRequest{Type: "accept"}); err != nil {
+		return err
+	}
+
+	// The lane stays quiet while the transfer is paused; it ends with the
+	// transfer rather than on a deadline
+	_ = fs.stream.SetReadDeadline(time.Time{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			_ = fs.stream.Reset()
+		case <-done:
+		}
+	}()
+
+	for {
+		frame, err := readFileFrame(fs.stream)
+		if err != nil {
+			// The sender closes its lanes when the file is complete
+			return nil
+		}
+		if frame.Type != "chunk" {
+			return fs.refuse("error", NewProtocolError(ErrCodeInvalid, "unexpected %s frame on extra stream", frame.Type))
+		}
+		select {
+		case frames <- frame:
+		case <-stop:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package message
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"lukechampine.com/blake3"
+)
+
+// fileReceiptDomain separates receipt signatures from other signatures made
+// with the peer key
+const fileReceiptDomain = "xelvra-file-receipt:"
+
+// FileReceipt is the receiver's signed acknowledgment that a file arrived
+// complete and matching its hashes
+type FileReceipt struct {
+	TransferID string    `json:"transfer_id"`
+	Hash       string    `json:"hash"`
+	BLAKE3     string    `json:"blake3,omitempty"`
+	Size       int64     `json:"size"`
+	Sender     string    `json:"sender"`
+	Receiver   string    `json:"receiver"`
+	ReceivedAt time.Time `json:"received_at"`
+	Signature  []byte    `json:"signature,omitempty"`
+}
+
+// newFileReceipt creates an unsigned receipt for a verified transfer
+func newFileReceipt(transfer *FileTransfer, receiver peer.ID) *FileReceipt {
+	return &FileReceipt{
+		TransferID: transfer.Metadata.ID,
+		Hash:       transfer.Metadata.Hash,
+		BLAKE3:     transfer.Metadata.BLAKE3,
+		Size:       transfer.Metadata.Size,
+		Sender:     transfer.PeerID.String(),
+		Receiver:   receiver.String(),
+		ReceivedAt: time.Now().UTC(),
+	}
+}
+
+// signedData returns the bytes the signature covers
+func (r *FileReceipt) signedData() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = nil
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(fileReceiptDomain), data...), nil
+}
+
+// Sign signs the receipt with sign, normally the receiver's peer key
+func (r *FileReceipt) Sign(sign func(data []byte) ([]byte, error)) error {
+	data, err := r.signedData()
+	if err != nil {
+		return fmt.Errorf("failed to encode receipt: %w", err)
+	}
+	signature, err := sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign receipt: %w", err)
+	}
+	r.Signature = signature
+	return nil
+}
+
+// Verify checks that the receipt acknowledges the file described by
+// metadata, sent by sender, and is signed by the key of receiver
+func (r *FileReceipt) Verify(metadata FileMetadata, sender, receiver peer.ID) error {
+	if r.TransferID != metadata.ID || r.Hash != metadata.Hash || r.BLAKE3 != metadata.BLAKE3 || r.Size != metadata.Size {
+		return fmt.Errorf("receipt does not match the file sent")
+	}
+	if r.Sender != sender.String() || r.Receiver != receiver.String() {
+		return fmt.Errorf("receipt names the wrong peers")
+	}
+	if len(r.Signature) == 0 {
+		return fmt.Errorf("receipt is not signed")
+	}
+
+	key, err := receiver.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get receiver key: %w", err)
+	}
+	data, err := r.signedData()
+	if err != nil {
+		return fmt.Errorf("failed to encode receipt: %w", err)
+	}
+	valid, err := key.Verify(data, r.Signature)
+	if err != nil || !valid {
+		return fmt.Errorf("invalid receipt signature")
+	}
+	return nil
+}
+
+// CalculateFileHashes calculates the SHA256 and BLAKE3 hashes of a file in
+// one pass
+func CalculateFileHashes(filePath string) (string, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	sha := sha256.New()
+	b3 := blake3.New(32, nil)
+	if _, err := io.Copy(io.MultiWriter(sha, b3), file); err != nil {
+		return "", "", fmt.Errorf("failed to calculate hash: %w", err)
+	}
+
+	return fmt.Sprintf("%x", sha.Sum(nil)), fmt.Sprintf("%x", b3.Sum(nil)), nil
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// FileDirectTimeout bounds trying to reach a peer directly, hole punching
+// included, before a file transfer goes through a relay instead
+const FileDirectTimeout = 15 * time.Second
+
+// isRelayed reports whether stream goes through a circuit relay
+func isRelayed(stream network.Stream) bool {
+	_, err := stream.Conn().RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// noteRelayed marks an outgoing transfer whose stream goes through a relay,
+// telling OnRelayed the first time
+func (ftm *FileTransferManager) noteRelayed(transfer *FileTransfer, stream network.Stream) {
+	if transfer.Relayed || !isRelayed(stream) {
+		return
+	}
+	transfer.mu.Lock()
+	transfer.Relayed = true
+	transfer.mu.Unlock()
+
+	ftm.logger.WithFields(logrus.Fields{
+		"transfer_id": transfer.ID,
+		"peer_id":     transfer.PeerID.String(),
+		"relay":       stream.Conn().RemoteMultiaddr().String(),
+	}).Warn("No direct connection, sending file through a relay")
+	if ftm.OnRelayed != nil {
+		ftm.OnRelayed(transfer)
+	}
+}
+
+// openFileStream opens a stream to peerID directly, or through a circuit
+// relay when no direct connection can be made within FileDirectTimeout
+func (mm *MessageManager) openFileStream(ctx context.Context, peerID peer.ID, protocolID protocol.ID) (network.Stream, error) {
+	directCtx, cancel := context.WithTimeout(ctx, FileDirectTimeout)
+	stream, err := mm.host.NewStream(directCtx, peerID, protocolID)
+	cancel()
+	if err == nil || ctx.Err() != nil {
+		return stream, err
+	}
+
+	mm.addRelayAddrs(peerID)
+	relayCtx := network.WithAllowLimitedConn(ctx, "file transfer")
+	stream, relayErr := mm.host.NewStream(relayCtx, peerID, protocolID)
+	if relayErr != nil {
+		return nil, fmt.Errorf("%w (through a relay: %v)", err, relayErr)
+	}
+	return stream, nil
+}
+
+// addRelayAddrs adds circuit addresses of peerID through the connected
+// peers that offer relaying, for when peerID holds a reservation there but
+// has not announced it
+func (mm *MessageManager) addRelayAddrs(peerID peer.ID) {
+	ps := mm.host.Peerstore()
+	for _, relay := range mm.host.Network().Peers() {
+		if relay == peerID {
+			continue
+		}
+		if supported, err := ps.SupportsProtocols(relay, proto.ProtoIDv2Hop); err != nil || len(supported) == 0 {
+			continue
+		}
+		circuit, err := ma.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit", relay))
+		if err != nil {
+			continue
+		}
+		for _, conn := range mm.host.Network().ConnsToPeer(relay) {
+			ps.AddAddr(peerID, conn.RemoteMultiaddr().Encapsulate(circuit), peerstore.TempAddrTTL)
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package message
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/sirupsen/logrus"
@@ -21,8 +26,21 @@ const (
 	FileChunkSize     = 32 * 1024  // 32KB chunks for optimal performance
 	FileHeaderSize    = 1024       // Maximum size for file metadata header
 	FileTransferMagic = 0x58454C56 // "XELV" magic number for file transfers
+	FileMaxFrameSize  = FileHeaderSize + 2*FileChunkSize
+
+	// Liveness and resume settings for long transfers
+	FileAckInterval       = time.Second      // Receiver reports progress at least this often
+	FileAckChunks         = 16               // ...and after this many chunks
+	FileKeepAliveInterval = 2 * time.Second  // Sender pings while waiting for acks
+	FileStallTimeout      = 10 * time.Second // No progress for this long is a stall
+	FileWindowSize        = 4 * 1024 * 1024  // Maximum unacknowledged bytes in flight
+	FileMaxResumes        = 10               // Automatic resume attempts per transfer
+	FileResumeDelay       = 2 * time.Second
 )
 
+// ErrTransferStalled is returned when a transfer stops making progress
+var ErrTransferStalled = errors.New("file transfer stalled")
+
 // FileTransferStatus represents the status of a file transfer
 type FileTransferStatus int
 
@@ -32,6 +50,8 @@ const (
 	FileTransferCompleted
 	FileTransferFailed
 	FileTransferCancelled
+	FileTransferStalled
+	FileTransferPaused
 )
 
 // String returns string representation of FileTransferStatus
@@ -47,6 +67,10 @@ func (fts FileTransferStatus) String() string {
 		return "failed"
 	case FileTransferCancelled:
 		return "cancelled"
+	case FileTransferStalled:
+		return "stalled"
+	case FileTransferPaused:
+		return "paused"
 	default:
 		return "unknown"
 	}
@@ -57,21 +81,33 @@ type FileMetadata struct {
 	ID         string    `json:"id"`
 	Name       string    `json:"name"`
 	Size       int64     `json:"size"`
-	Hash       string    `json:"hash"` // SHA256 hash for integrity verification
+	Hash       string    `json:"hash"`             // SHA256 hash for integrity verification
+	BLAKE3     string    `json:"blake3,omitempty"` // BLAKE3 hash, checked too when present
 	MimeType   string    `json:"mime_type"`
 	Timestamp  time.Time `json:"timestamp"`
 	ChunkCount int       `json:"chunk_count"`
 	ChunkSize  int       `json:"chunk_size"`
+
+	// Set when the file is part of a directory transfer
+	SyncID string `json:"sync_id,omitempty"`
+	Path   string `json:"path,omitempty"` // Slash-separated path within the directory
 }
 
 // FileTransferRequest represents a file transfer request
 type FileTransferRequest struct {
 	Magic    uint32       `json:"magic"`
-	Type     string       `json:"type"` // "request", "accept", "reject", "chunk", "complete"
+	Type     string       `json:"type"` // "request", "join", "pending", "accept", "reject", "chunk", "ack", "ping", "pause", "resume", "cancel", "complete", "done", "error"
 	Metadata FileMetadata `json:"metadata,omitempty"`
 	ChunkID  int          `json:"chunk_id,omitempty"`
+	Offset   int64        `json:"offset,omitempty"` // Resume offset (accept) or bytes received (ack)
 	Data     []byte       `json:"data,omitempty"`
 	Error    string       `json:"error,omitempty"`
+	Code     ErrorCode    `json:"code,omitempty"`    // Reason for "reject" and "error"
+	Receipt  *FileReceipt `json:"receipt,omitempty"` // Signed acknowledgment sent with "done"
+	Streams  int          `json:"streams,omitempty"` // Parallel streams offered (request) or allowed (accept)
+
+	// Compression offered (request), agreed (accept) or applied to Data (chunk)
+	Compression string `json:"compression,omitempty"`
 }
 
 // FileTransfer represents an active file transfer session
@@ -84,15 +120,43 @@ type FileTransfer struct {
 	BytesTotal    int64
 	BytesSent     int64
 	BytesReceived int64
+	BytesOnWire   int64  // Chunk data sent or received over the streams, after compression
+	Compression   string // Compression agreed with the peer, or ""
+	Relayed       bool   // Streams go through a circuit relay, which is slower
 	StartTime     time.Time
 	EndTime       time.Time
 	Error         error
+	LocalPath     string       // Source file when sending, stored file when receiving
+	Receipt       *FileReceipt // Receiver's signed acknowledgment of a completed transfer
+
+	// Liveness tracking
+	BytesAcked   int64     // Bytes confirmed by the receiver
+	LastActivity time.Time // Last time the peer showed progress
+	Stalls       int       // Number of stalls detected
+
+	// Pausing: data flows only while neither side has paused
+	PausedLocal  bool // Paused on this side
+	PausedRemote bool // Paused by the peer
+
+	// Guards the fields above and the control state below while the
+	// transfer runs. GetTransfer and ListTransfers hand out copies.
+	mu sync.Mutex
 
 	// File handling
 	file       *os.File
 	isOutgoing bool
-	chunks     map[int]bool // Track received chunks
 	logger     *logrus.Logger
+	partPath   string // Partial file of an incoming transfer
+
+	// Control requested by the user while a stream is attached
+	attached        bool
+	cancelRequested bool
+	control         chan struct{}
+
+	// Extra streams of an incoming transfer pass their chunks here until
+	// laneStop is closed; guarded by the manager's lock
+	lanes    chan<- *FileTransferRequest
+	laneStop <-chan struct{}
 }
 
 // NewFileTransfer creates a new file transfer session
@@ -106,8 +170,8 @@ func NewFileTransfer(id string, peerID peer.ID, metadata FileMetadata, isOutgoin
 		BytesTotal: metadata.Size,
 		StartTime:  time.Now(),
 		isOutgoing: isOutgoing,
-		chunks:     make(map[int]bool),
 		logger:     logger,
+		control:    make(chan struct{}, 1),
 	}
 }
 
@@ -139,7 +203,7 @@ func CreateFileMetadata(filePath string) (*FileMetadata, error) {
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	hash, err := CalculateFileHash(filePath)
+	hash, b3, err := CalculateFileHashes(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate file hash: %w", err)
 	}
@@ -148,9 +212,10 @@ func CreateFileMetadata(filePath string) (*FileMetadata, error) {
 
 	metadata := &FileMetadata{
 		ID:         fmt.Sprintf("file_%d", time.Now().UnixNano()),
-		Name:       filepath.Base(filePath),
+		Name:       SanitizeFileName(filepath.Base(filePath)),
 		Size:       fileInfo.Size(),
 		Hash:       hash,
+		BLAKE3:     b3,
 		MimeType:   detectMimeType(filePath),
 		Timestamp:  fileInfo.ModTime(),
 		ChunkCount: chunkCount,
@@ -220,27 +285,13 @@ func (ft *FileTransfer) SendFileComplete(stream network.Stream) error {
 
 // sendRequest sends a file transfer request over the stream
 func (ft *FileTransfer) sendRequest(stream network.Stream, request FileTransferRequest) error {
-	data, err := json.Marshal(request)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// Write length prefix (4 bytes)
-	length := uint32(len(data))
-	if err := binary.Write(stream, binary.BigEndian, length); err != nil {
-		return fmt.Errorf("failed to write length: %w", err)
-	}
-
-	// Write data
-	if _, err := stream.Write(data); err != nil {
-		return fmt.Errorf("failed to write data: %w", err)
-	}
-
-	return nil
+	return writeFileFrame(stream, request, false)
 }
 
 // UpdateProgress updates the transfer progress
 func (ft *FileTransfer) UpdateProgress() {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
 	if ft.BytesTotal > 0 {
 		if ft.isOutgoing {
 			ft.Progress = float64(ft.BytesSent) / float64(ft.BytesTotal)
@@ -263,31 +314,298 @@ func (ft *FileTransfer) IsOutgoing() bool {
 	return ft.isOutgoing
 }
 
+// Paused returns true if either side has paused the transfer
+func (ft *FileTransfer) Paused() bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.paused()
+}
+
+// paused is Paused with ft.mu held
+func (ft *FileTransfer) paused() bool {
+	return ft.PausedLocal || ft.PausedRemote
+}
+
+// updatePauseStatus moves an active transfer to paused and back
+func (ft *FileTransfer) updatePauseStatus() {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	switch {
+	case ft.paused() && ft.Status == FileTransferActive:
+		ft.Status = FileTransferPaused
+	case !ft.paused() && ft.Status == FileTransferPaused:
+		ft.Status = FileTransferActive
+	}
+}
+
+// setStatus moves the transfer to status, recording err if it ended it
+func (ft *FileTransfer) setStatus(status FileTransferStatus, err error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.Status = status
+	if err != nil {
+		ft.Error = err
+	}
+}
+
+// cancelled reports whether the user cancelled the transfer
+func (ft *FileTransfer) cancelled() bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.cancelRequested
+}
+
+// attach records that a stream carries the transfer, reporting false if
+// another stream already does
+func (ft *FileTransfer) attach() bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.attached {
+		return false
+	}
+	ft.attached = true
+	return true
+}
+
+// setAttached records whether a stream is carrying the transfer
+func (ft *FileTransfer) setAttached(attached bool) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.attached = attached
+}
+
+// snapshot returns a copy of the transfer's state that later progress
+// does not change
+func (ft *FileTransfer) snapshot() *FileTransfer {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return &FileTransfer{
+		ID:            ft.ID,
+		PeerID:        ft.PeerID,
+		Metadata:      ft.Metadata,
+		Status:        ft.Status,
+		Progress:      ft.Progress,
+		BytesTotal:    ft.BytesTotal,
+		BytesSent:     ft.BytesSent,
+		BytesReceived: ft.BytesReceived,
+		BytesOnWire:   ft.BytesOnWire,
+		Compression:   ft.Compression,
+		Relayed:       ft.Relayed,
+		StartTime:     ft.StartTime,
+		EndTime:       ft.EndTime,
+		Error:         ft.Error,
+		LocalPath:     ft.LocalPath,
+		Receipt:       ft.Receipt,
+		BytesAcked:    ft.BytesAcked,
+		LastActivity:  ft.LastActivity,
+		Stalls:        ft.Stalls,
+		PausedLocal:   ft.PausedLocal,
+		PausedRemote:  ft.PausedRemote,
+		isOutgoing:    ft.isOutgoing,
+		logger:        ft.logger,
+	}
+}
+
+// writeFrame writes a length-prefixed JSON frame
+func writeFrame(w io.Writer, v interface{}) error {
+	return writeFrameAs(w, v, false)
+}
+
+// EncodeFrame returns v as the message and file protocols put it on the
+// wire: a 4-byte big-endian length followed by the JSON encoding
+func EncodeFrame(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := appendFrame(&buf, v, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readFrame reads a length-prefixed frame of at most maxSize bytes. Frames
+// of types with a binary encoding may be protobuf as well as JSON.
+func readFrame(r io.Reader, maxSize uint32, v interface{}) error {
+	// Read length prefix
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return fmt.Errorf("failed to read length: %w", err)
+	}
+
+	if length > maxSize {
+		return fmt.Errorf("frame too large: %d bytes", length)
+	}
+
+	// Read data into a pooled buffer, which goes back to the pool unless
+	// the decoded frame keeps slices of it
+	buf := getFrameBuffer()
+	buf.Grow(int(length))
+	data := buf.Bytes()[:length]
+	if _, err := io.ReadFull(r, data); err != nil {
+		putFrameBuffer(buf)
+		return fmt.Errorf("failed to read frame data: %w", err)
+	}
+	if _, ok := v.(binaryFrame); !ok || !isBinaryFrame(data) {
+		defer putFrameBuffer(buf)
+	}
+
+	if err := unmarshalFrame(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal frame: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileFrame writes a length-prefixed file protocol frame, in protobuf
+// if binaryFrames is set
+func writeFileFrame(w io.Writer, request FileTransferRequest, binaryFrames bool) error {
+	return writeFrameAs(w, &request, binaryFrames)
+}
+
+// readFileFrame reads a length-prefixed file protocol frame
+func readFileFrame(r io.Reader) (*FileTransferRequest, error) {
+	var frame FileTransferRequest
+	if err := readFrame(r, FileMaxFrameSize, &frame); err != nil {
+		return nil, err
+	}
+
+	if frame.Magic != FileTransferMagic {
+		return nil, fmt.Errorf("invalid magic number: %x", frame.Magic)
+	}
+
+	return &frame, nil
+}
+
+// fileStream serialises frame writes and applies stall deadlines
+type fileStream struct {
+	stream  network.Stream
+	timeout time.Duration
+	binary  bool // Write protobuf frames; the peer agreed on FeatureBinaryFrames
+	mu      sync.Mutex
+}
+
+// newFileStream wraps a file protocol stream, writing the frame format the
+// peer agreed on
+func (ftm *FileTransferManager) newFileStream(stream network.Stream) *fileStream {
+	fs := &fileStream{stream: stream, timeout: ftm.StallTimeout}
+	if ftm.PeerAgrees != nil {
+		fs.binary = ftm.PeerAgrees(stream.Conn().RemotePeer(), FeatureBinaryFrames)
+	}
+	return fs
+}
+
+// write sends a frame, failing if the peer stops reading for too long
+func (fs *fileStream) write(frame FileTransferRequest) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	frame.Magic = FileTransferMagic
+	_ = fs.stream.SetWriteDeadline(time.Now().Add(fs.timeout))
+	return writeFileFrame(fs.stream, frame, fs.binary)
+}
+
+// refuse tells the peer why the transfer cannot continue and returns the
+// same error
+func (fs *fileStream) refuse(frameType string, pe *ProtocolError) error {
+	_ = fs.write(FileTransferRequest{Type: frameType, Error: pe.Message, Code: pe.Code})
+	return pe
+}
+
+// read receives a frame, failing if nothing arrives for too long
+func (fs *fileStream) read() (*FileTransferRequest, error) {
+	_ = fs.stream.SetReadDeadline(time.Now().Add(fs.timeout))
+	return readFileFrame(fs.stream)
+}
+
+// StreamOpener opens a new file protocol stream to the receiving peer
+type StreamOpener func(ctx context.Context) (network.Stream, error)
+
 // FileTransferManager manages file transfers
 type FileTransferManager struct {
+	mu        sync.RWMutex
 	transfers map[string]*FileTransfer
+	syncs     map[string]*dirSession // Incoming directory transfers by manifest ID
 	logger    *logrus.Logger
+
+	// Liveness settings, defaulting to the File* constants
+	AckInterval       time.Duration
+	KeepAliveInterval time.Duration
+	StallTimeout      time.Duration
+	MaxResumes        int
+	ResumeDelay       time.Duration
+
+	// MaxStreams is the number of streams a large file may be split across
+	// in either direction; 1 sends every file over a single stream
+	MaxStreams int
+
+	// Compression offers and accepts zstd-compressed chunks
+	Compression bool
+
+	// OnComplete, if set, is called after a transfer completes in either
+	// direction
+	OnComplete func(transfer *FileTransfer)
+
+	// OnRelayed, if set, is called when an outgoing transfer goes through a
+	// relay because the peer could not be reached directly
+	OnRelayed func(transfer *FileTransfer)
+
+	// Attachments, if set, stores received files other than directory
+	// transfers, keeping one copy of identical files
+	Attachments *AttachmentStore
+
+	// CheckSpace, if set, is asked before receiving the given number of bytes
+	// and refuses the transfer by returning an error
+	CheckSpace func(size int64) *ProtocolError
+
+	// Approve, if set, decides whether to accept a file or directory a peer
+	// offers, before anything is written. It may block while the user
+	// decides, and refuses the offer by returning an error.
+	Approve func(ctx context.Context, offer *FileOffer) *ProtocolError
+
+	// SignReceipt, if set, signs the acknowledgment of a received file with
+	// the key behind the local peer ID
+	SignReceipt func(data []byte) ([]byte, error)
+
+	// PeerSupports, if set, reports whether the receiver supports an
+	// optional feature; features it does not support are not offered
+	PeerSupports func(peerID peer.ID, feature string) bool
+
+	// PeerAgrees, if set, reports whether the peer is known to support an
+	// optional feature; it decides the frame format of file streams
+	PeerAgrees func(peerID peer.ID, feature string) bool
 }
 
 // NewFileTransferManager creates a new file transfer manager
 func NewFileTransferManager(logger *logrus.Logger) *FileTransferManager {
 	return &FileTransferManager{
-		transfers: make(map[string]*FileTransfer),
-		logger:    logger,
+		transfers:         make(map[string]*FileTransfer),
+		syncs:             make(map[string]*dirSession),
+		logger:            logger,
+		AckInterval:       FileAckInterval,
+		KeepAliveInterval: FileKeepAliveInterval,
+		StallTimeout:      FileStallTimeout,
+		MaxResumes:        FileMaxResumes,
+		ResumeDelay:       FileResumeDelay,
+		MaxStreams:        FileMaxStreams,
+		Compression:       true,
 	}
 }
 
-// StartFileTransfer initiates a file transfer
-func (ftm *FileTransferManager) StartFileTransfer(ctx context.Context, stream network.Stream, filePath string, peerID peer.ID) error {
-	// Create file metadata
+// SendFile sends a file over streams obtained from open. Stalls and dropped
+// streams are detected from missing acks and the transfer resumes from the
+// last acknowledged offset on a new stream.
+func (ftm *FileTransferManager) SendFile(ctx context.Context, open StreamOpener, filePath string, peerID peer.ID) error {
 	metadata, err := CreateFileMetadata(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to create file metadata: %w", err)
 	}
 
-	// Create file transfer session
+	return ftm.sendFile(ctx, open, metadata, filePath, peerID)
+}
+
+// sendFile sends a file described by metadata, resuming after stalls
+func (ftm *FileTransferManager) sendFile(ctx context.Context, open StreamOpener, metadata *FileMetadata, filePath string, peerID peer.ID) error {
 	transfer := NewFileTransfer(metadata.ID, peerID, *metadata, true, ftm.logger)
-	ftm.transfers[metadata.ID] = transfer
+	transfer.LocalPath = filePath
+	ftm.addTransfer(transfer)
 
 	ftm.logger.WithFields(logrus.Fields{
 		"transfer_id": metadata.ID,
@@ -296,42 +614,118 @@ func (ftm *FileTransferManager) StartFileTransfer(ctx context.Context, stream ne
 		"peer_id":     peerID.String(),
 	}).Info("Starting file transfer")
 
-	// Send file transfer request
-	if err := transfer.SendFileRequest(stream); err != nil {
-		transfer.Status = FileTransferFailed
-		transfer.Error = err
-		return fmt.Errorf("failed to send file request: %w", err)
+	for attempt := 0; ; attempt++ {
+		if transfer.cancelled() {
+			ftm.discardTransfer(transfer)
+			return ErrTransferCancelled
+		}
+
+		var retry bool
+		stream, err := open(ctx)
+		if err != nil {
+			retry, err = true, fmt.Errorf("failed to open file stream: %w", err)
+		} else {
+			ftm.noteRelayed(transfer, stream)
+			retry, err = ftm.sendAttempt(ctx, open, stream, transfer, filePath)
+			if err == nil {
+				_ = stream.Close()
+				return nil
+			}
+			if errors.Is(err, ErrTransferCancelled) {
+				// Let the cancel frame reach the receiver
+				_ = stream.Close()
+			} else {
+				_ = stream.Reset()
+			}
+		}
+
+		if errors.Is(err, ErrTransferCancelled) {
+			transfer.setStatus(FileTransferCancelled, err)
+			return err
+		}
+		if !retry || attempt >= ftm.MaxResumes || ctx.Err() != nil {
+			transfer.setStatus(FileTransferFailed, err)
+			return err
+		}
+
+		transfer.mu.Lock()
+		transfer.Status = FileTransferStalled
+		transfer.Stalls++
+		transfer.mu.Unlock()
+		ftm.logger.WithError(err).WithFields(logrus.Fields{
+			"transfer_id": transfer.ID,
+			"acked_bytes": transfer.BytesAcked,
+			"attempt":     attempt + 1,
+		}).Warn("File transfer stalled, resuming")
+
+		select {
+		case <-time.After(ftm.ResumeDelay):
+		case <-ctx.Done():
+			transfer.setStatus(FileTransferCancelled, nil)
+			return ctx.Err()
+		}
+	}
+}
+
+// sendAttempt runs one transfer attempt. Large files are spread over extra
+// streams from open when the receiver agrees. The boolean reports whether
+// the failure is transient and the transfer should be resumed.
+func (ftm *FileTransferManager) sendAttempt(ctx context.Context, open StreamOpener, stream network.Stream, transfer *FileTransfer, filePath string) (bool, error) {
+	fs := ftm.newFileStream(stream)
+
+	// Offer the file; the receiver answers with the offset to resume from.
+	// Extra streams through a relay would all share its bandwidth.
+	offered := ftm.streamsFor(transfer.BytesTotal)
+	if isRelayed(stream) {
+		offered = 0
+	}
+	compression := ftm.offerCompression(transfer.Metadata)
+	if ftm.PeerSupports != nil && !ftm.PeerSupports(stream.Conn().RemotePeer(), FeatureCompression) {
+		compression = ""
+	}
+	if err := fs.write(FileTransferRequest{Type: "request", Metadata: transfer.Metadata, Streams: offered, Compression: compression}); err != nil {
+		return true, fmt.Errorf("failed to send file request: %w", err)
 	}
 
-	// Wait for response (simplified - in production would be async)
-	response, err := ftm.readResponse(stream)
+	// The receiver keeps us waiting while its user decides
+	response, err := fs.read()
+	for waiting := false; err == nil && response.Type == "pending"; response, err = fs.read() {
+		if !waiting {
+			waiting = true
+			ftm.logger.WithField("transfer_id", transfer.ID).Info("Waiting for the receiver to accept the file")
+		}
+	}
 	if err != nil {
-		transfer.Status = FileTransferFailed
-		transfer.Error = err
-		return fmt.Errorf("failed to read response: %w", err)
+		return true, fmt.Errorf("%w: no response to file request: %v", ErrTransferStalled, err)
 	}
 
-	if response.Type == "reject" {
-		transfer.Status = FileTransferFailed
-		transfer.Error = fmt.Errorf("file transfer rejected: %s", response.Error)
-		return transfer.Error
+	switch response.Type {
+	case "accept":
+	case TransferCancel:
+		return false, fmt.Errorf("%w by the receiver", ErrTransferCancelled)
+	case "reject":
+		return false, fmt.Errorf("file transfer rejected: %w", remoteError(response.Code, response.Error))
+	default:
+		return false, fmt.Errorf("unexpected response type: %s", response.Type)
 	}
 
-	if response.Type != "accept" {
-		transfer.Status = FileTransferFailed
-		transfer.Error = fmt.Errorf("unexpected response type: %s", response.Type)
-		return transfer.Error
+	offset := response.Offset
+	if offset < 0 || offset > transfer.BytesTotal || offset%FileChunkSize != 0 {
+		return false, fmt.Errorf("invalid resume offset: %d", offset)
 	}
 
-	// Start sending file chunks
-	return ftm.sendFileChunks(ctx, stream, transfer, filePath)
-}
+	// Receivers that predate compression answer without agreeing to it
+	if response.Compression != compression {
+		compression = ""
+	}
+	transfer.mu.Lock()
+	transfer.Compression = compression
+	transfer.mu.Unlock()
+	compressor := newChunkCompressor(compression)
 
-// sendFileChunks sends file data in chunks
-func (ftm *FileTransferManager) sendFileChunks(ctx context.Context, stream network.Stream, transfer *FileTransfer, filePath string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return false, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer func() {
 		if err := file.Close(); err != nil {
@@ -339,119 +733,720 @@ func (ftm *FileTransferManager) sendFileChunks(ctx context.Context, stream netwo
 		}
 	}()
 
-	transfer.file = file
-	transfer.Status = FileTransferActive
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return false, fmt.Errorf("failed to seek to resume offset: %w", err)
+	}
 
-	buffer := make([]byte, FileChunkSize)
-	chunkID := 0
+	if offset > 0 {
+		ftm.logger.WithFields(logrus.Fields{
+			"transfer_id": transfer.ID,
+			"offset":      offset,
+		}).Info("Resuming file transfer")
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			transfer.Status = FileTransferCancelled
-			return ctx.Err()
-		default:
+	// The receiver repeats its pause on every stream; tell it about ours
+	transfer.mu.Lock()
+	transfer.Status = FileTransferActive
+	transfer.BytesSent = offset
+	transfer.BytesAcked = offset
+	transfer.LastActivity = time.Now()
+	transfer.PausedRemote = false
+	transfer.attached = true
+	transfer.mu.Unlock()
+	transfer.UpdateProgress()
+	defer transfer.setAttached(false)
+	told := false
+	control := func() (bool, error) {
+		err := ftm.syncControl(fs, transfer, &told)
+		return !errors.Is(err, ErrTransferCancelled), err
+	}
+	if retry, err := control(); err != nil {
+		return retry, err
+	}
+
+	// Chunks are scheduled over all streams; reassembly on the receiver
+	// needs a window as large as the streams carry together
+	var chunks *chunkScheduler
+	window := int64(FileWindowSize)
+	if streams := min(offered, response.Streams); streams > 1 {
+		if lanes := ftm.openLanes(ctx, open, transfer, streams-1); len(lanes) > 0 {
+			chunks = startChunkScheduler(fs, lanes)
+			defer chunks.stop()
+			window *= int64(len(lanes) + 1)
 		}
+	}
 
-		n, err := file.Read(buffer)
-		if err == io.EOF {
-			break
+	// Read acks in the background; a read timeout means the peer went silent
+	frames := make(chan *FileTransferRequest, 64)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			frame, err := fs.read()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-done:
+				return
+			}
 		}
-		if err != nil {
-			transfer.Status = FileTransferFailed
-			transfer.Error = err
-			return fmt.Errorf("failed to read file chunk: %w", err)
+	}()
+
+	keepAlive := time.NewTicker(ftm.KeepAliveInterval)
+	defer keepAlive.Stop()
+
+	pooled := getChunkBuffer()
+	defer putChunkBuffer(pooled)
+	buffer := *pooled
+	chunkID := int(offset / FileChunkSize)
+	sentAll := false
+
+	// handle applies a frame from the receiver
+	handle := func(frame *FileTransferRequest) (bool, error) {
+		switch frame.Type {
+		case "ack":
+			transfer.mu.Lock()
+			if frame.Offset > transfer.BytesAcked {
+				transfer.BytesAcked = frame.Offset
+				transfer.LastActivity = time.Now()
+			}
+			transfer.mu.Unlock()
+		case "done":
+			if err := ftm.checkReceipt(stream, transfer, frame.Receipt); err != nil {
+				return false, err
+			}
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("receiver failed: %w", remoteError(frame.Code, frame.Error))
+		case TransferPause, TransferResume, TransferCancel:
+			if err := ftm.applyRemoteControl(transfer, frame.Type); err != nil {
+				return false, err
+			}
 		}
+		return false, nil
+	}
 
-		// Send chunk
-		chunkData := buffer[:n]
-		if err := transfer.SendFileChunk(stream, chunkID, chunkData); err != nil {
-			transfer.Status = FileTransferFailed
-			transfer.Error = err
-			return fmt.Errorf("failed to send chunk %d: %w", chunkID, err)
+	for {
+		// Apply pending acks without blocking
+	drain:
+		for {
+			select {
+			case frame := <-frames:
+				finished, err := handle(frame)
+				if err != nil {
+					return false, err
+				}
+				if finished {
+					return true, ftm.finishSend(transfer)
+				}
+			case <-transfer.control:
+				if retry, err := control(); err != nil {
+					return retry, err
+				}
+			default:
+				break drain
+			}
 		}
 
-		transfer.BytesSent += int64(n)
-		transfer.UpdateProgress()
+		outstanding := transfer.BytesSent - transfer.BytesAcked
+		if outstanding == 0 {
+			transfer.mu.Lock()
+			transfer.LastActivity = time.Now()
+			transfer.mu.Unlock()
+		} else if time.Since(transfer.LastActivity) > ftm.StallTimeout {
+			return true, fmt.Errorf("%w: no progress for %s", ErrTransferStalled, ftm.StallTimeout)
+		}
 
-		ftm.logger.WithFields(logrus.Fields{
-			"transfer_id": transfer.ID,
-			"chunk_id":    chunkID,
-			"chunk_size":  n,
-			"progress":    fmt.Sprintf("%.1f%%", transfer.Progress*100),
-		}).Debug("Sent file chunk")
+		// Keep sending while the window allows and neither side has paused
+		if !sentAll && outstanding < window && !transfer.Paused() {
+			if err := ctx.Err(); err != nil {
+				transfer.setStatus(FileTransferCancelled, nil)
+				return false, err
+			}
+
+			n, err := io.ReadFull(file, buffer)
+			if err == io.EOF {
+				if err := fs.write(FileTransferRequest{Type: "complete"}); err != nil {
+					return true, fmt.Errorf("failed to send completion: %w", err)
+				}
+				sentAll = true
+				continue
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return false, fmt.Errorf("failed to read file chunk: %w", err)
+			}
+
+			data, compressed := compressor.compress(buffer[:n])
+			frame := FileTransferRequest{Type: "chunk", ChunkID: chunkID, Data: data, Compression: compressed}
+			if chunks != nil {
+				// The buffer is reused before a stream takes the chunk
+				if compressed == "" {
+					frame.Data = append([]byte(nil), data...)
+				}
+				err = chunks.send(ctx, frame)
+			} else {
+				err = fs.write(frame)
+			}
+			if err != nil {
+				return true, fmt.Errorf("failed to send chunk %d: %w", chunkID, err)
+			}
+
+			transfer.mu.Lock()
+			transfer.BytesSent += int64(n)
+			transfer.BytesOnWire += int64(len(data))
+			transfer.mu.Unlock()
+			transfer.UpdateProgress()
+			chunkID++
+			continue
+		}
 
-		chunkID++
+		// Window full, paused or everything sent: wait for the receiver
+		select {
+		case frame := <-frames:
+			finished, err := handle(frame)
+			if err != nil {
+				return false, err
+			}
+			if finished {
+				return true, ftm.finishSend(transfer)
+			}
+		case <-transfer.control:
+			if retry, err := control(); err != nil {
+				return retry, err
+			}
+		case err := <-readErr:
+			return true, fmt.Errorf("%w: %v", ErrTransferStalled, err)
+		case err := <-chunks.failed():
+			return true, fmt.Errorf("%w: file stream failed: %v", ErrTransferStalled, err)
+		case <-keepAlive.C:
+			if err := fs.write(FileTransferRequest{Type: "ping"}); err != nil {
+				return true, fmt.Errorf("%w: keep-alive failed: %v", ErrTransferStalled, err)
+			}
+		case <-ctx.Done():
+			transfer.setStatus(FileTransferCancelled, nil)
+			return false, ctx.Err()
+		}
 	}
+}
 
-	// Send completion notification
-	if err := transfer.SendFileComplete(stream); err != nil {
-		transfer.Status = FileTransferFailed
-		transfer.Error = err
-		return fmt.Errorf("failed to send completion: %w", err)
+// checkReceipt verifies the receiver's acknowledgment of a completed
+// transfer. Peers that predate receipts acknowledge without one.
+func (ftm *FileTransferManager) checkReceipt(stream network.Stream, transfer *FileTransfer, receipt *FileReceipt) error {
+	if receipt == nil {
+		ftm.logger.WithField("transfer_id", transfer.ID).Debug("Receiver acknowledged the file without a receipt")
+		return nil
+	}
+	if err := receipt.Verify(transfer.Metadata, stream.Conn().LocalPeer(), transfer.PeerID); err != nil {
+		return fmt.Errorf("completion acknowledgment rejected: %w", err)
 	}
+	transfer.mu.Lock()
+	transfer.Receipt = receipt
+	transfer.mu.Unlock()
+	return nil
+}
 
+// finishSend marks an outgoing transfer as completed
+func (ftm *FileTransferManager) finishSend(transfer *FileTransfer) error {
+	transfer.mu.Lock()
 	transfer.Status = FileTransferCompleted
 	transfer.EndTime = time.Now()
+	transfer.BytesAcked = transfer.BytesTotal
+	transfer.mu.Unlock()
+	transfer.UpdateProgress()
 
 	ftm.logger.WithFields(logrus.Fields{
 		"transfer_id": transfer.ID,
 		"file_name":   transfer.Metadata.Name,
 		"bytes_sent":  transfer.BytesSent,
+		"on_wire":     transfer.BytesOnWire,
+		"stalls":      transfer.Stalls,
 		"duration":    transfer.EndTime.Sub(transfer.StartTime),
 	}).Info("File transfer completed successfully")
 
+	if ftm.OnComplete != nil {
+		ftm.OnComplete(transfer)
+	}
 	return nil
 }
 
-// readResponse reads a file transfer response from the stream
-func (ftm *FileTransferManager) readResponse(stream network.Stream) (*FileTransferRequest, error) {
-	// Read length prefix
-	var length uint32
-	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
-		return nil, fmt.Errorf("failed to read length: %w", err)
+// ReceiveFile serves one incoming file stream. Data is written to a partial
+// file in downloadDir so an interrupted transfer resumes where it stopped.
+func (ftm *FileTransferManager) ReceiveFile(ctx context.Context, stream network.Stream, remotePeer peer.ID, downloadDir string) error {
+	fs := ftm.newFileStream(stream)
+
+	request, err := fs.read()
+	if err != nil {
+		return fmt.Errorf("failed to read file transfer request: %w", err)
+	}
+	if request.Type == FileJoin {
+		return ftm.joinTransfer(fs, remotePeer, request)
+	}
+	if request.Type != "request" {
+		return fmt.Errorf("unexpected file transfer frame: %s", request.Type)
+	}
+
+	// A single file is named by a plain file name, never a path; files of a
+	// directory transfer are placed by their checked manifest path
+	metadata := request.Metadata
+	if err := ValidateFileName(metadata.Name); err != nil {
+		return fs.refuse("reject", NewProtocolError(ErrCodeInvalid, "%v", err))
+	}
+	if err := ValidateFileHash(metadata.Hash); err != nil {
+		return fs.refuse("reject", NewProtocolError(ErrCodeInvalid, "%v", err))
+	}
+	name := SanitizeFileName(metadata.Name)
+	if metadata.Size < 0 || metadata.Size > MaxFileSize {
+		return fs.refuse("reject", NewProtocolError(ErrCodeTooLarge, "file too large: %d bytes", metadata.Size))
+	}
+
+	// Files of a directory transfer go to the path announced in its manifest
+	if metadata.SyncID != "" {
+		destPath, err := ftm.syncDestination(remotePeer, metadata)
+		if err != nil {
+			return fs.refuse("reject", NewProtocolError(ErrCodePolicy, "%v", err))
+		}
+		downloadDir, name = filepath.Dir(destPath), filepath.Base(destPath)
+	}
+
+	// Only a transfer accepted here earlier resumes, and only for the peer
+	// and file it was accepted for. Its partial file is named by an ID chosen
+	// here, never by anything the sender sent.
+	transfer, exists := ftm.lookupTransfer(metadata.ID)
+	if exists && (transfer.isOutgoing || transfer.PeerID != remotePeer || transfer.Metadata.Hash != metadata.Hash) {
+		return fs.refuse("reject", NewProtocolError(ErrCodeInvalid, "transfer %s is already in use", metadata.ID))
+	}
+	// One stream at a time writes the partial file of a known transfer
+	if exists {
+		if !transfer.attach() {
+			return fs.refuse("reject", NewProtocolError(ErrCodeInvalid, "transfer %s in progress", metadata.ID))
+		}
+		defer transfer.setAttached(false)
+	}
+	partPath := filepath.Join(downloadDir, fmt.Sprintf("%s.%s.part", name, uuid.New().String()[:8]))
+	if exists && transfer.partPath != "" {
+		partPath = transfer.partPath
+	}
+
+	// A transfer cancelled here while the sender was away stays cancelled
+	if exists && transfer.cancelled() {
+		_ = os.Remove(partPath)
+		_ = fs.write(FileTransferRequest{Type: TransferCancel})
+		return ErrTransferCancelled
+	}
+
+	// Files of a directory transfer were accounted for with its manifest
+	if metadata.SyncID == "" {
+		remaining := metadata.Size
+		if info, err := os.Stat(partPath); err == nil {
+			remaining -= info.Size()
+		}
+		if pe := ftm.checkSpace(remaining); pe != nil {
+			return fs.refuse("reject", pe)
+		}
+	}
+
+	// Nothing is written before a new file is accepted. Files of a directory
+	// transfer were accepted with it, and a known transfer was accepted
+	// before being interrupted.
+	if metadata.SyncID == "" && !exists {
+		offer := &FileOffer{
+			TransferID: metadata.ID,
+			PeerID:     remotePeer,
+			Name:       name,
+			Size:       metadata.Size,
+			OfferedAt:  time.Now(),
+		}
+		pe, err := ftm.approve(ctx, offer, func() error {
+			return fs.write(FileTransferRequest{Type: "pending"})
+		})
+		if err != nil {
+			return err
+		}
+		if pe != nil {
+			return fs.refuse("reject", pe)
+		}
+	}
+
+	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+		return fmt.Errorf("failed to create download directory: %w", err)
+	}
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			ftm.logger.WithError(err).Warn("Failed to close received file")
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat partial file: %w", err)
+	}
+	offset := info.Size() - info.Size()%FileChunkSize
+	if offset > metadata.Size {
+		offset = 0
+	}
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate partial file: %w", err)
 	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek partial file: %w", err)
+	}
+
+	if !exists {
+		transfer = NewFileTransfer(metadata.ID, remotePeer, metadata, false, ftm.logger)
+		transfer.attached = true
+		ftm.addTransfer(transfer)
+		defer transfer.setAttached(false)
+	}
+	transfer.mu.Lock()
+	transfer.partPath = partPath
+	transfer.Status = FileTransferActive
+	transfer.Relayed = isRelayed(stream)
+	transfer.BytesReceived = offset
+	transfer.LastActivity = time.Now()
+	transfer.mu.Unlock()
+	transfer.UpdateProgress()
+
+	// Frames arrive on this stream and on any extra streams the sender
+	// opens, which may join as soon as the file is accepted
+	streams := ftm.acceptStreams(request.Streams)
+	frames := make(chan *FileTransferRequest, 64)
+	stop := make(chan struct{})
+	defer close(stop)
+	if streams > 0 {
+		ftm.mu.Lock()
+		transfer.lanes, transfer.laneStop = frames, stop
+		ftm.mu.Unlock()
+		defer func() {
+			ftm.mu.Lock()
+			transfer.lanes, transfer.laneStop = nil, nil
+			ftm.mu.Unlock()
+		}()
+	}
+
+	compression := ftm.acceptCompression(request.Compression)
+	if err := fs.write(FileTransferRequest{Type: "accept", Offset: offset, Streams: streams, Compression: compression}); err != nil {
+		return fmt.Errorf("failed to send acceptance: %w", err)
+	}
+
+	// The sender repeats its pause on every stream; tell it about ours
+	transfer.mu.Lock()
+	transfer.Compression = compression
+	transfer.PausedRemote = false
+	transfer.mu.Unlock()
+	told := false
+	if err := ftm.syncControl(fs, transfer, &told); err != nil {
+		return err
+	}
+
+	ftm.logger.WithFields(logrus.Fields{
+		"transfer_id": transfer.ID,
+		"file_name":   name,
+		"offset":      offset,
+		"peer":        remotePeer.String(),
+	}).Info("File transfer accepted, ready to receive")
+
+	// Report progress periodically so the sender can tell we are alive
+	var received atomic.Int64
+	received.Store(offset)
+	go func() {
+		ticker := time.NewTicker(ftm.AckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := fs.write(FileTransferRequest{Type: "ack", Offset: received.Load()}); err != nil {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// Read frames in the background so pause and cancel requests are
+	// handled while the sender is quiet
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := fs.read()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	// write appends the next chunk to the partial file
+	chunksSinceAck := 0
+	write := func(data []byte) error {
+		if _, err := file.Write(data); err != nil {
+			transfer.setStatus(FileTransferFailed, err)
+			code := ErrCodeInternal
+			if errors.Is(err, syscall.ENOSPC) {
+				code = ErrCodeQuota
+			}
+			_ = fs.refuse("error", NewProtocolError(code, "write failed"))
+			return fmt.Errorf("failed to write chunk: %w", err)
+		}
+
+		received.Add(int64(len(data)))
+		transfer.mu.Lock()
+		transfer.BytesReceived = received.Load()
+		transfer.LastActivity = time.Now()
+		transfer.mu.Unlock()
+		transfer.UpdateProgress()
+
+		chunksSinceAck++
+		if chunksSinceAck >= FileAckChunks {
+			chunksSinceAck = 0
+			if err := fs.write(FileTransferRequest{Type: "ack", Offset: received.Load()}); err != nil {
+				return fmt.Errorf("failed to send ack: %w", err)
+			}
+		}
+		return nil
+	}
+
+	// Chunks ahead of the file, bounded by the sender's window over all
+	// streams; the file is complete once the sender says so and nothing is
+	// missing
+	pending := make(map[int][]byte)
+	window := int64(FileWindowSize*max(streams, 1)) + FileChunkSize
+	completed := false
+
+	for {
+		var frame *FileTransferRequest
+		select {
+		case frame = <-frames:
+		case <-transfer.control:
+			if err := ftm.syncControl(fs, transfer, &told); err != nil {
+				return err
+			}
+			continue
+		case err := <-readErr:
+			transfer.mu.Lock()
+			transfer.Status = FileTransferStalled
+			transfer.Stalls++
+			transfer.mu.Unlock()
+			ftm.logger.WithError(err).WithFields(logrus.Fields{
+				"transfer_id":    transfer.ID,
+				"bytes_received": received.Load(),
+			}).Warn("File transfer stalled, keeping partial file for resume")
+			return fmt.Errorf("%w: %v", ErrTransferStalled, err)
+		case <-ctx.Done():
+			transfer.setStatus(FileTransferCancelled, nil)
+			return ctx.Err()
+		}
+
+		switch frame.Type {
+		case "chunk":
+			data, err := decodeChunk(frame, compression)
+			if err != nil {
+				return fs.refuse("error", NewProtocolError(ErrCodeInvalid, "%v", err))
+			}
+			transfer.mu.Lock()
+			transfer.BytesOnWire += int64(len(frame.Data))
+			transfer.mu.Unlock()
+			frame.Data = data
+
+			offset := int64(frame.ChunkID) * FileChunkSize
+			end := offset + int64(len(frame.Data))
+			expected := received.Load()
+			if len(frame.Data) == 0 || end > metadata.Size || (len(frame.Data) != FileChunkSize && end != metadata.Size) {
+				return fmt.Errorf("invalid chunk %d of %d bytes", frame.ChunkID, len(frame.Data))
+			}
+
+			// Chunks from other streams may overtake each other; keep them
+			// until the file reaches them
+			if offset != expected {
+				if streams == 0 || offset < expected || end-expected > window {
+					return fmt.Errorf("unexpected chunk %d at offset %d", frame.ChunkID, expected)
+				}
+				pending[frame.ChunkID] = frame.Data
+				continue
+			}
+
+			if err := write(frame.Data); err != nil {
+				return err
+			}
+			for {
+				next := int(received.Load() / FileChunkSize)
+				data, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				if err := write(data); err != nil {
+					return err
+				}
+			}
+			if completed && received.Load() == metadata.Size {
+				return ftm.finishReceive(fs, file, transfer, partPath, filepath.Join(downloadDir, name))
+			}
+
+		case "ping":
+			if err := fs.write(FileTransferRequest{Type: "ack", Offset: received.Load()}); err != nil {
+				return fmt.Errorf("failed to answer ping: %w", err)
+			}
+
+		case TransferPause, TransferResume, TransferCancel:
+			if err := ftm.applyRemoteControl(transfer, frame.Type); err != nil {
+				return err
+			}
+
+		case "complete":
+			// Chunks on the extra streams may still be on their way
+			if streams > 0 && received.Load() < metadata.Size {
+				completed = true
+				continue
+			}
+			return ftm.finishReceive(fs, file, transfer, partPath, filepath.Join(downloadDir, name))
 
-	if length > FileHeaderSize {
-		return nil, fmt.Errorf("response too large: %d bytes", length)
+		default:
+			return fmt.Errorf("unexpected file transfer frame: %s", frame.Type)
+		}
+	}
+}
+
+// checkSpace asks CheckSpace whether size more bytes may be received
+func (ftm *FileTransferManager) checkSpace(size int64) *ProtocolError {
+	if ftm.CheckSpace == nil || size <= 0 {
+		return nil
+	}
+	return ftm.CheckSpace(size)
+}
+
+// finishReceive verifies the received file and moves it into place
+func (ftm *FileTransferManager) finishReceive(fs *fileStream, file *os.File, transfer *FileTransfer, partPath, destPath string) error {
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close received file: %w", err)
+	}
+
+	hash, b3, err := CalculateFileHashes(partPath)
+	if err != nil || hash != transfer.Metadata.Hash || (transfer.Metadata.BLAKE3 != "" && b3 != transfer.Metadata.BLAKE3) {
+		// A corrupt partial file must not be resumed
+		_ = os.Remove(partPath)
+		err := fmt.Errorf("file hash mismatch")
+		transfer.setStatus(FileTransferFailed, err)
+		_ = fs.refuse("error", NewProtocolError(ErrCodeIntegrity, "hash mismatch"))
+		return err
+	}
+
+	// A single file never replaces one received before
+	if transfer.Metadata.SyncID == "" {
+		destPath = UniqueFilePath(destPath)
+	}
+	if err := ftm.storeReceived(transfer, partPath, destPath); err != nil {
+		_ = fs.refuse("error", NewProtocolError(ErrCodeInternal, "failed to store file"))
+		return fmt.Errorf("failed to move received file: %w", err)
+	}
+
+	transfer.mu.Lock()
+	transfer.Status = FileTransferCompleted
+	transfer.EndTime = time.Now()
+	transfer.LocalPath = destPath
+	transfer.mu.Unlock()
+	ftm.markSyncReceived(transfer.Metadata)
+
+	done := FileTransferRequest{Type: "done", Offset: transfer.BytesReceived}
+	if ftm.SignReceipt != nil {
+		receipt := newFileReceipt(transfer, fs.stream.Conn().LocalPeer())
+		if err := receipt.Sign(ftm.SignReceipt); err != nil {
+			ftm.logger.WithError(err).Warn("Failed to sign file receipt")
+		} else {
+			transfer.mu.Lock()
+			transfer.Receipt = receipt
+			transfer.mu.Unlock()
+			done.Receipt = receipt
+		}
+	}
+	if err := fs.write(done); err != nil {
+		ftm.logger.WithError(err).Warn("Failed to acknowledge completed transfer")
 	}
 
-	// Read data
-	data := make([]byte, length)
-	if _, err := io.ReadFull(stream, data); err != nil {
-		return nil, fmt.Errorf("failed to read response data: %w", err)
+	ftm.logger.WithFields(logrus.Fields{
+		"transfer_id":    transfer.ID,
+		"file_name":      transfer.Metadata.Name,
+		"dest_path":      destPath,
+		"bytes_received": transfer.BytesReceived,
+		"on_wire":        transfer.BytesOnWire,
+		"duration":       transfer.EndTime.Sub(transfer.StartTime),
+	}).Info("File transfer completed successfully")
+
+	if ftm.OnComplete != nil {
+		ftm.OnComplete(transfer)
 	}
+	return nil
+}
 
-	// Parse response
-	var response FileTransferRequest
-	if err := json.Unmarshal(data, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+// storeReceived moves a verified file to destPath, through the attachment
+// store unless it belongs to a directory transfer
+func (ftm *FileTransferManager) storeReceived(transfer *FileTransfer, partPath, destPath string) error {
+	if ftm.Attachments == nil || transfer.Metadata.SyncID != "" {
+		return os.Rename(partPath, destPath)
 	}
 
-	if response.Magic != FileTransferMagic {
-		return nil, fmt.Errorf("invalid magic number: %x", response.Magic)
+	duplicate, err := ftm.Attachments.Add(partPath, destPath, transfer.Metadata, transfer.PeerID.String(), transfer.ID)
+	if err != nil {
+		return err
 	}
+	if duplicate {
+		ftm.logger.WithField("hash", transfer.Metadata.Hash).Debug("Received file already stored, deduplicated")
+	}
+	return nil
+}
 
-	return &response, nil
+// addTransfer registers a transfer session
+func (ftm *FileTransferManager) addTransfer(transfer *FileTransfer) {
+	ftm.mu.Lock()
+	defer ftm.mu.Unlock()
+	ftm.transfers[transfer.ID] = transfer
 }
 
-// GetTransfer returns a file transfer by ID
+// GetTransfer returns a copy of the state of a file transfer by ID
 func (ftm *FileTransferManager) GetTransfer(id string) (*FileTransfer, bool) {
+	transfer, exists := ftm.lookupTransfer(id)
+	if !exists {
+		return nil, false
+	}
+	return transfer.snapshot(), true
+}
+
+// lookupTransfer returns the running transfer with the given ID
+func (ftm *FileTransferManager) lookupTransfer(id string) (*FileTransfer, bool) {
+	ftm.mu.RLock()
+	defer ftm.mu.RUnlock()
 	transfer, exists := ftm.transfers[id]
 	return transfer, exists
 }
 
-// ListTransfers returns all active transfers
+// ListTransfers returns copies of the state of all transfers
 func (ftm *FileTransferManager) ListTransfers() []*FileTransfer {
+	ftm.mu.RLock()
+	defer ftm.mu.RUnlock()
 	transfers := make([]*FileTransfer, 0, len(ftm.transfers))
 	for _, transfer := range ftm.transfers {
-		transfers = append(transfers, transfer)
+		transfers = append(transfers, transfer.snapshot())
 	}
 	return transfers
 }
 
 // CleanupTransfer removes a completed or failed transfer
 func (ftm *FileTransferManager) CleanupTransfer(id string) {
+	ftm.mu.Lock()
+	defer ftm.mu.Unlock()
 	if transfer, exists := ftm.transfers[id]; exists {
 		if err := transfer.Close(); err != nil {
 			ftm.logger.WithError(err).Error("Failed to close transfer")
@@ -0,0 +1,231 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FiltersFileName holds the content filters in the data directory
+	FiltersFileName = "filters.json"
+
+	// MetadataFilter is the metadata key under which the outcome of the
+	// filters is attached to a received message. It is local to this node;
+	// a value sent by the peer is discarded.
+	MetadataFilter = "filter"
+)
+
+// FilterAction is what a filter does with a matching message
+type FilterAction string
+
+const (
+	FilterMute    FilterAction = "mute"    // Recorded but not shown
+	FilterTag     FilterAction = "tag"     // Shown and recorded with a label
+	FilterArchive FilterAction = "archive" // Recorded as archived, hidden from history by default
+)
+
+// Filter matches incoming text messages by keyword or regular expression
+type Filter struct {
+	ID      int          `json:"id"`
+	Pattern string       `json:"pattern"`
+	Regex   bool         `json:"regex,omitempty"`   // Pattern is a regular expression rather than a keyword
+	PeerID  string       `json:"peer_id,omitempty"` // Only this conversation; empty for all
+	Action  FilterAction `json:"action"`
+	Tag     string       `json:"tag,omitempty"` // Label for FilterTag
+
+	re *regexp.Regexp
+}
+
+// Compile checks the filter and prepares it for matching. Keywords match
+// case-insensitively anywhere in the message.
+func (f *Filter) Compile() error {
+	switch f.Action {
+	case FilterMute, FilterArchive:
+	case FilterTag:
+		if strings.TrimSpace(f.Tag) == "" {
+			return fmt.Errorf("tag filters need a tag")
+		}
+	default:
+		return fmt.Errorf("unknown filter action %q", f.Action)
+	}
+	if f.Pattern == "" {
+		return fmt.Errorf("filter pattern is empty")
+	}
+
+	expr := "(?i)" + regexp.QuoteMeta(f.Pattern)
+	if f.Regex {
+		expr = f.Pattern
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid filter pattern: %w", err)
+	}
+	f.re = re
+	return nil
+}
+
+// Matches reports whether the filter applies to content received from peerID
+func (f *Filter) Matches(peerID string, content []byte) bool {
+	if f.re == nil || (f.PeerID != "" && f.PeerID != peerID) {
+		return false
+	}
+	return f.re.Match(content)
+}
+
+// String describes the filter for display
+func (f *Filter) String() string {
+	pattern := fmt.Sprintf("%q", f.Pattern)
+	if f.Regex {
+		pattern = "/" + f.Pattern + "/"
+	}
+	if f.Action == FilterTag {
+		return fmt.Sprintf("%s → tag %q", pattern, f.Tag)
+	}
+	return fmt.Sprintf("%s → %s", pattern, f.Action)
+}
+
+// FilterMatch is the combined outcome of the filters matching a message
+type FilterMatch struct {
+	Muted    bool
+	Archived bool
+	Tags     []string
+}
+
+// IsZero reports whether no filter matched
+func (m FilterMatch) IsZero() bool {
+	return !m.Muted && !m.Archived && len(m.Tags) == 0
+}
+
+// metadata encodes the match for a message's metadata
+func (m FilterMatch) metadata() map[string]interface{} {
+	tags := make([]interface{}, len(m.Tags))
+	for i, tag := range m.Tags {
+		tags[i] = tag
+	}
+	return map[string]interface{}{"muted": m.Muted, "archived": m.Archived, "tags": tags}
+}
+
+// FilterMatchOf returns the filter outcome recorded in a message's metadata
+func FilterMatchOf(metadata map[string]interface{}) FilterMatch {
+	var match FilterMatch
+	value, ok := metadata[MetadataFilter].(map[string]interface{})
+	if !ok {
+		return match
+	}
+	match.Muted, _ = value["muted"].(bool)
+	match.Archived, _ = value["archived"].(bool)
+	tags, _ := value["tags"].([]interface{})
+	for _, tag := range tags {
+		if s, ok := tag.(string); ok {
+			match.Tags = append(match.Tags, s)
+		}
+	}
+	return match
+}
+
+// MatchFilters applies every filter to content received from peerID
+func MatchFilters(filters []*Filter, peerID string, content []byte) FilterMatch {
+	var match FilterMatch
+	for _, f := range filters {
+		if !f.Matches(peerID, content) {
+			continue
+		}
+		switch f.Action {
+		case FilterMute:
+			match.Muted = true
+		case FilterArchive:
+			match.Archived = true
+		case FilterTag:
+			match.Tags = append(match.Tags, f.Tag)
+		}
+	}
+	return match
+}
+
+// LoadFilters reads the filters stored at path. A missing file means no
+// filters.
+func LoadFilters(path string) ([]*Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var filters []*Filter
+	if err := json.Unmarshal(data, &filters); err != nil {
+		return nil, fmt.Errorf("failed to parse filters: %w", err)
+	}
+	for _, f := range filters {
+		if err := f.Compile(); err != nil {
+			return nil, fmt.Errorf("filter %d: %w", f.ID, err)
+		}
+	}
+	return filters, nil
+}
+
+// SaveFilters writes the filters to path
+func SaveFilters(path string, filters []*Filter) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if filters == nil {
+		filters = []*Filter{}
+	}
+	data, err := json.MarshalIndent(filters, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// filterSource caches the filters, reloading them when the file changes so
+// CLI edits apply to a running node
+type filterSource struct {
+	path   string
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	modTime time.Time
+	loaded  bool
+	filters []*Filter
+}
+
+// newFilterSource creates a source for the filters at path
+func newFilterSource(path string, logger *logrus.Logger) *filterSource {
+	return &filterSource{path: path, logger: logger}
+}
+
+// Filters returns the current filters
+func (s *filterSource) Filters() []*Filter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modTime time.Time
+	if info, err := os.Stat(s.path); err == nil {
+		modTime = info.ModTime()
+	}
+	if s.loaded && modTime.Equal(s.modTime) {
+		return s.filters
+	}
+
+	filters, err := LoadFilters(s.path)
+	if err != nil {
+		// Keep the previous filters rather than showing everything
+		s.logger.WithError(err).Warn("Failed to load content filters")
+		filters = s.filters
+	}
+	s.filters, s.modTime, s.loaded = filters, modTime, true
+	return filters
+}
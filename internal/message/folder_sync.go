@@ -0,0 +1,439 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// SyncFoldersFileName holds the configured sync folders in the data directory
+	SyncFoldersFileName = "sync_folders.json"
+
+	// SyncStateDirName holds per-folder file indexes in the data directory
+	SyncStateDirName = "sync_state"
+
+	// syncTempSuffix marks files being written by the synchroniser
+	syncTempSuffix = ".sync.part"
+)
+
+// VersionVector counts the changes each device made to a file. Comparing
+// vectors tells whether one version supersedes another or whether both
+// devices changed the file independently.
+type VersionVector map[string]uint64
+
+// Ordering is the result of comparing two version vectors
+type Ordering int
+
+const (
+	VersionEqual      Ordering = iota // Same history
+	VersionBefore                     // Superseded by the other version
+	VersionAfter                      // Supersedes the other version
+	VersionConcurrent                 // Changed independently on both sides
+)
+
+// Compare orders v relative to other
+func (v VersionVector) Compare(other VersionVector) Ordering {
+	less, greater := false, false
+	for device, n := range v {
+		if n > other[device] {
+			greater = true
+		} else if n < other[device] {
+			less = true
+		}
+	}
+	for device, n := range other {
+		if _, ok := v[device]; !ok && n > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return VersionConcurrent
+	case less:
+		return VersionBefore
+	case greater:
+		return VersionAfter
+	default:
+		return VersionEqual
+	}
+}
+
+// Merge returns the element-wise maximum of both vectors
+func (v VersionVector) Merge(other VersionVector) VersionVector {
+	merged := make(VersionVector, len(v)+len(other))
+	for device, n := range v {
+		merged[device] = n
+	}
+	for device, n := range other {
+		if n > merged[device] {
+			merged[device] = n
+		}
+	}
+	return merged
+}
+
+// Increment returns a copy of v with device's counter increased
+func (v VersionVector) Increment(device string) VersionVector {
+	next := v.Merge(nil)
+	next[device]++
+	return next
+}
+
+// SyncFolder pairs a local directory with a directory on another device.
+// Both devices must configure the pair before anything is exchanged.
+type SyncFolder struct {
+	ID      string    `json:"id"`
+	PeerID  string    `json:"peer_id"`
+	Local   string    `json:"local"`  // Absolute local path
+	Remote  string    `json:"remote"` // Path of the folder on the peer
+	AddedAt time.Time `json:"added_at"`
+}
+
+// LoadSyncFolders reads the sync folder configuration at path
+func LoadSyncFolders(path string) ([]*SyncFolder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var folders []*SyncFolder
+	if err := json.Unmarshal(data, &folders); err != nil {
+		return nil, fmt.Errorf("failed to parse sync folders: %w", err)
+	}
+	return folders, nil
+}
+
+// saveSyncFolders writes the sync folder configuration
+func saveSyncFolders(path string, folders []*SyncFolder) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(folders, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// AddSyncFolder configures two-way sync of local with remote on peerID
+func AddSyncFolder(path, peerID, local, remote string) (*SyncFolder, error) {
+	if _, err := peer.Decode(peerID); err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	local, err := filepath.Abs(local)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(local); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", local)
+	}
+	if remote == "" {
+		return nil, fmt.Errorf("remote path is required")
+	}
+	remote = filepath.Clean(remote)
+
+	folders, err := LoadSyncFolders(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range folders {
+		if f.Local == local && f.PeerID == peerID {
+			return nil, fmt.Errorf("%s is already synced with this peer (%s)", local, f.ID)
+		}
+	}
+
+	folder := &SyncFolder{
+		ID:      uuid.New().String()[:8],
+		PeerID:  peerID,
+		Local:   local,
+		Remote:  remote,
+		AddedAt: time.Now(),
+	}
+	folders = append(folders, folder)
+
+	return folder, saveSyncFolders(path, folders)
+}
+
+// RemoveSyncFolder stops syncing the folder with the given ID. Files are
+// left in place on both devices.
+func RemoveSyncFolder(path, id string) error {
+	folders, err := LoadSyncFolders(path)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range folders {
+		if f.ID == id {
+			return saveSyncFolders(path, append(folders[:i], folders[i+1:]...))
+		}
+	}
+	return fmt.Errorf("sync folder not found: %s", id)
+}
+
+// ErasePeerSyncFolders stops syncing every folder shared with peerID and
+// deletes their file indexes in stateDir. Files are left in place. It
+// returns the number of folders removed.
+func ErasePeerSyncFolders(path, stateDir, peerID string) (int, error) {
+	folders, err := LoadSyncFolders(path)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := folders[:0]
+	removed := 0
+	for _, f := range folders {
+		if f.PeerID != peerID {
+			kept = append(kept, f)
+			continue
+		}
+		removed++
+		if err := os.Remove(filepath.Join(stateDir, f.ID+".json")); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, saveSyncFolders(path, kept)
+}
+
+// SyncFileState is the last known state of one file in a sync folder
+type SyncFileState struct {
+	Path    string        `json:"path"` // Slash-separated, relative to the folder
+	Hash    string        `json:"hash,omitempty"`
+	Size    int64         `json:"size"`
+	ModTime time.Time     `json:"mod_time"`
+	Deleted bool          `json:"deleted,omitempty"`
+	Version VersionVector `json:"version"`
+}
+
+// FolderIndex tracks the files of a sync folder and their versions
+type FolderIndex struct {
+	Files map[string]*SyncFileState `json:"files"`
+}
+
+// loadFolderIndex reads a folder index, returning an empty one if none exists
+func loadFolderIndex(path string) (*FolderIndex, error) {
+	index := &FolderIndex{Files: make(map[string]*SyncFileState)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse folder index: %w", err)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]*SyncFileState)
+	}
+	return index, nil
+}
+
+// save writes the folder index to path
+func (fi *FolderIndex) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(fi)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// list returns the file states sorted by path
+func (fi *FolderIndex) list() []*SyncFileState {
+	files := make([]*SyncFileState, 0, len(fi.Files))
+	for _, f := range fi.Files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// scan updates the index from the files on disk. New, modified and deleted
+// files get a new version from device. Files whose size and modification
+// time are unchanged are not re-hashed.
+func (fi *FolderIndex) scan(root, device string) (bool, error) {
+	changed := false
+	seen := make(map[string]bool)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(path, ".part") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > MaxFileSize {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+
+		state, known := fi.Files[rel]
+		if known && !state.Deleted && state.Size == info.Size() && state.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+
+		hash, err := CalculateFileHash(path)
+		if err != nil {
+			return err
+		}
+
+		if known && !state.Deleted && state.Hash == hash {
+			// Touched but not modified
+			state.ModTime = info.ModTime()
+			changed = true
+			return nil
+		}
+
+		var version VersionVector
+		if known {
+			version = state.Version
+		}
+		fi.Files[rel] = &SyncFileState{
+			Path:    rel,
+			Hash:    hash,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Version: version.Increment(device),
+		}
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return changed, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+
+	for rel, state := range fi.Files {
+		if seen[rel] || state.Deleted {
+			continue
+		}
+		state.Deleted = true
+		state.Hash = ""
+		state.Size = 0
+		state.ModTime = time.Now()
+		state.Version = state.Version.Increment(device)
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// syncAction is a change to apply locally after comparing indexes
+type syncAction struct {
+	remote   *SyncFileState
+	local    *SyncFileState // nil if the file is unknown locally
+	conflict bool           // Local content must be kept as a conflict copy
+}
+
+// planSync compares the local index with a peer's and returns the changes
+// to apply locally. Concurrent changes are resolved the same way on both
+// devices: the newer modification wins and the other side keeps its version
+// as a conflict copy.
+func (fi *FolderIndex) planSync(remote []*SyncFileState) (actions []syncAction, adopted bool) {
+	for _, r := range remote {
+		if !validManifestPath(r.Path) {
+			continue
+		}
+
+		l := fi.Files[r.Path]
+		if l == nil {
+			if r.Deleted {
+				// Remember the deletion so a stale copy elsewhere is not revived
+				fi.Files[r.Path] = r
+				adopted = true
+				continue
+			}
+			actions = append(actions, syncAction{remote: r})
+			continue
+		}
+
+		switch r.Version.Compare(l.Version) {
+		case VersionAfter:
+			if r.Deleted == l.Deleted && r.Hash == l.Hash {
+				l.Version = l.Version.Merge(r.Version)
+				adopted = true
+				continue
+			}
+			actions = append(actions, syncAction{remote: r, local: l})
+
+		case VersionConcurrent:
+			if r.Deleted == l.Deleted && r.Hash == l.Hash {
+				l.Version = l.Version.Merge(r.Version)
+				adopted = true
+				continue
+			}
+			if !remoteWins(l, r) {
+				// Ours wins; the peer fetches it after merging versions
+				l.Version = l.Version.Merge(r.Version)
+				adopted = true
+				continue
+			}
+			actions = append(actions, syncAction{remote: r, local: l, conflict: !l.Deleted})
+		}
+	}
+
+	return actions, adopted
+}
+
+// remoteWins decides a conflict identically on both devices
+func remoteWins(local, remote *SyncFileState) bool {
+	if local.Deleted != remote.Deleted {
+		return local.Deleted // Content beats deletion
+	}
+	if !remote.ModTime.Equal(local.ModTime) {
+		return remote.ModTime.After(local.ModTime)
+	}
+	return remote.Hash > local.Hash
+}
+
+// conflictPath returns the name used to keep a losing version of path
+func conflictPath(path, device string, at time.Time) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	short := device
+	if len(short) > 8 {
+		short = short[len(short)-8:]
+	}
+	return fmt.Sprintf("%s.sync-conflict-%s-%s%s", base, at.Format("20060102-150405"), short, ext)
+}
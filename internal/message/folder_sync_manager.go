@@ -0,0 +1,538 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// FolderSyncProtocolID is the protocol for two-way folder sync
+	FolderSyncProtocolID = protocol.ID("/xelvra/folder/1.0.0")
+
+	// Folder sync timing
+	FolderSyncInterval     = 10 * time.Second // How often local folders are checked for changes
+	FolderSyncFullInterval = time.Minute      // Indexes are exchanged at least this often
+	FolderSyncTimeout      = 30 * time.Second // Per-frame timeout
+)
+
+// FolderSyncFrame is a message of the folder sync protocol
+type FolderSyncFrame struct {
+	Magic  uint32           `json:"magic"`
+	Type   string           `json:"type"`             // "index", "get", "chunk", "complete", "busy", "error"
+	Local  string           `json:"local,omitempty"`  // Sender's folder
+	Remote string           `json:"remote,omitempty"` // Receiver's folder
+	Files  []*SyncFileState `json:"files,omitempty"`
+	Path   string           `json:"path,omitempty"`
+	Hash   string           `json:"hash,omitempty"`
+	Data   []byte           `json:"data,omitempty"`
+	Error  string           `json:"error,omitempty"`
+	Code   ErrorCode        `json:"code,omitempty"`
+}
+
+// FolderSyncResult summarises the changes applied by one sync round
+type FolderSyncResult struct {
+	Fetched   int // Files downloaded from the peer
+	Deleted   int // Files deleted because the peer deleted them
+	Conflicts int // Local versions kept as conflict copies
+}
+
+// FolderSyncManager keeps configured folders in sync with other devices.
+// Local changes are detected by polling; each round exchanges file indexes
+// with the peer and both sides pull what they are missing.
+type FolderSyncManager struct {
+	host       host.Host
+	configPath string
+	stateDir   string
+	timeouts   *timeoutSource
+	logger     *logrus.Logger
+
+	// Timing settings, defaulting to the FolderSync* constants
+	Interval     time.Duration
+	FullInterval time.Duration
+	Timeout      time.Duration
+
+	mu       sync.Mutex
+	locks    map[string]*sync.Mutex // Per-folder locks
+	lastSync map[string]time.Time
+}
+
+// NewFolderSyncManager creates a folder sync manager using the configuration
+// and indexes stored in dataDir, and registers its stream handler
+func NewFolderSyncManager(h host.Host, dataDir string, logger *logrus.Logger) *FolderSyncManager {
+	m := &FolderSyncManager{
+		host:         h,
+		configPath:   filepath.Join(dataDir, SyncFoldersFileName),
+		stateDir:     filepath.Join(dataDir, SyncStateDirName),
+		timeouts:     newTimeoutSource(filepath.Join(dataDir, TimeoutsFileName), logger),
+		logger:       logger,
+		Interval:     FolderSyncInterval,
+		FullInterval: FolderSyncFullInterval,
+		Timeout:      FolderSyncTimeout,
+		locks:        make(map[string]*sync.Mutex),
+		lastSync:     make(map[string]time.Time),
+	}
+
+	h.SetStreamHandler(FolderSyncProtocolID, m.handleStream)
+	return m
+}
+
+// Run syncs all configured folders until ctx is done
+func (m *FolderSyncManager) Run(ctx context.Context) {
+	for {
+		// Jitter keeps two devices from starting rounds at the same moment
+		jitter := time.Duration(rand.Int63n(int64(m.Interval/4) + 1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.Interval + jitter):
+		}
+
+		folders, err := LoadSyncFolders(m.configPath)
+		if err != nil {
+			m.logger.WithError(err).Warn("Failed to load sync folders")
+			continue
+		}
+
+		for _, folder := range folders {
+			if _, err := m.syncFolder(ctx, folder, false); err != nil {
+				m.logger.WithError(err).WithField("folder", folder.Local).Debug("Folder sync round failed")
+			}
+		}
+	}
+}
+
+// SyncNow runs a sync round for a folder immediately
+func (m *FolderSyncManager) SyncNow(ctx context.Context, folder *SyncFolder) (*FolderSyncResult, error) {
+	return m.syncFolder(ctx, folder, true)
+}
+
+// folderLock returns the lock serialising rounds of one folder
+func (m *FolderSyncManager) folderLock(id string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[id] = lock
+	}
+	return lock
+}
+
+// indexPath returns where the index of a folder is stored
+func (m *FolderSyncManager) indexPath(folder *SyncFolder) string {
+	return filepath.Join(m.stateDir, folder.ID+".json")
+}
+
+// findFolder returns the folder shared with remotePeer under the given pair
+// of paths, or nil if this device has not agreed to sync it
+func (m *FolderSyncManager) findFolder(remotePeer peer.ID, local, remote string) *SyncFolder {
+	folders, err := LoadSyncFolders(m.configPath)
+	if err != nil {
+		return nil
+	}
+	for _, f := range folders {
+		if f.PeerID == remotePeer.String() && f.Local == local && f.Remote == remote {
+			return f
+		}
+	}
+	return nil
+}
+
+// syncFolder scans a folder and, if it changed or force is set, exchanges
+// indexes with the peer and applies the peer's changes
+func (m *FolderSyncManager) syncFolder(ctx context.Context, folder *SyncFolder, force bool) (*FolderSyncResult, error) {
+	peerID, err := peer.Decode(folder.PeerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	lock := m.folderLock(folder.ID)
+	if !lock.TryLock() {
+		return nil, fmt.Errorf("folder is already syncing")
+	}
+	defer lock.Unlock()
+
+	index, err := loadFolderIndex(m.indexPath(folder))
+	if err != nil {
+		return nil, err
+	}
+	changed, err := index.scan(folder.Local, m.host.ID().String())
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := index.save(m.indexPath(folder)); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	due := time.Since(m.lastSync[folder.ID]) >= m.FullInterval
+	m.mu.Unlock()
+
+	if !changed && !force && !due {
+		return &FolderSyncResult{}, nil
+	}
+	if !force && m.host.Network().Connectedness(peerID) != network.Connected {
+		return nil, fmt.Errorf("peer not connected")
+	}
+
+	remote, err := m.exchangeIndex(ctx, peerID, folder, index)
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		// The peer is busy with its own round, which pushes its index to us
+		return &FolderSyncResult{}, nil
+	}
+
+	result := m.apply(ctx, peerID, folder, index, remote)
+	if err := index.save(m.indexPath(folder)); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.lastSync[folder.ID] = time.Now()
+	m.mu.Unlock()
+
+	return result, nil
+}
+
+// exchangeIndex sends our index and returns the peer's. A nil index means
+// the peer was busy.
+func (m *FolderSyncManager) exchangeIndex(ctx context.Context, peerID peer.ID, folder *SyncFolder, index *FolderIndex) ([]*SyncFileState, error) {
+	openCtx, cancel := context.WithTimeout(ctx, m.frameTimeout(peerID))
+	defer cancel()
+
+	stream, err := m.host.NewStream(openCtx, peerID, FolderSyncProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open folder sync stream: %w", err)
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	frame := FolderSyncFrame{Type: "index", Local: folder.Local, Remote: folder.Remote, Files: index.list()}
+	if err := m.writeFrame(stream, frame); err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("failed to send index: %w", err)
+	}
+
+	reply, err := m.readFrame(stream)
+	if err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	switch reply.Type {
+	case "index":
+		return reply.Files, nil
+	case "busy":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("peer refused folder sync: %w", remoteError(reply.Code, reply.Error))
+	}
+}
+
+// apply downloads, deletes and renames files so the folder reflects the
+// peer's newer versions
+func (m *FolderSyncManager) apply(ctx context.Context, peerID peer.ID, folder *SyncFolder, index *FolderIndex, remote []*SyncFileState) *FolderSyncResult {
+	result := &FolderSyncResult{}
+	actions, _ := index.planSync(remote)
+
+	for _, action := range actions {
+		r := action.remote
+		localPath := filepath.Join(folder.Local, filepath.FromSlash(r.Path))
+		logger := m.logger.WithFields(logrus.Fields{"folder": folder.Local, "path": r.Path})
+
+		// Leave files alone that changed since the scan; the next round
+		// picks the change up
+		if l := action.local; l != nil && !l.Deleted {
+			info, err := os.Stat(localPath)
+			if err != nil || info.Size() != l.Size || !info.ModTime().Equal(l.ModTime) {
+				continue
+			}
+		}
+
+		if action.conflict {
+			copyPath := filepath.Join(folder.Local, filepath.FromSlash(conflictPath(r.Path, m.host.ID().String(), time.Now())))
+			if err := copyFile(localPath, copyPath); err != nil {
+				logger.WithError(err).Warn("Failed to keep conflicting version")
+				continue
+			}
+			logger.Info("Sync conflict, kept local version as a copy")
+			result.Conflicts++
+		}
+
+		var version VersionVector
+		if action.local != nil {
+			version = action.local.Version
+		}
+		version = version.Merge(r.Version)
+
+		if r.Deleted {
+			if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+				logger.WithError(err).Warn("Failed to delete synced file")
+				continue
+			}
+			index.Files[r.Path] = &SyncFileState{Path: r.Path, Deleted: true, ModTime: r.ModTime, Version: version}
+			result.Deleted++
+			continue
+		}
+
+		info, err := m.fetch(ctx, peerID, folder, r, localPath)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to fetch synced file")
+			continue
+		}
+		index.Files[r.Path] = &SyncFileState{
+			Path:    r.Path,
+			Hash:    r.Hash,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Version: version,
+		}
+		result.Fetched++
+	}
+
+	if result.Fetched+result.Deleted+result.Conflicts > 0 {
+		m.logger.WithFields(logrus.Fields{
+			"folder":    folder.Local,
+			"fetched":   result.Fetched,
+			"deleted":   result.Deleted,
+			"conflicts": result.Conflicts,
+		}).Info("Folder synced")
+	}
+
+	return result
+}
+
+// fetch downloads one file version from the peer into localPath
+func (m *FolderSyncManager) fetch(ctx context.Context, peerID peer.ID, folder *SyncFolder, r *SyncFileState, localPath string) (os.FileInfo, error) {
+	openCtx, cancel := context.WithTimeout(ctx, m.frameTimeout(peerID))
+	defer cancel()
+
+	stream, err := m.host.NewStream(openCtx, peerID, FolderSyncProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	request := FolderSyncFrame{Type: "get", Local: folder.Local, Remote: folder.Remote, Path: r.Path, Hash: r.Hash}
+	if err := m.writeFrame(stream, request); err != nil {
+		_ = stream.Reset()
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return nil, err
+	}
+	tmp := localPath + syncTempSuffix
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(tmp)
+	}()
+
+	var written int64
+	for {
+		frame, err := m.readFrame(stream)
+		if err != nil {
+			_ = stream.Reset()
+			return nil, err
+		}
+
+		if frame.Type == "complete" {
+			break
+		}
+		if frame.Type != "chunk" {
+			return nil, fmt.Errorf("peer could not send file: %w", remoteError(frame.Code, frame.Error))
+		}
+
+		written += int64(len(frame.Data))
+		if written > r.Size {
+			_ = stream.Reset()
+			return nil, fmt.Errorf("peer sent more data than announced")
+		}
+		if _, err := file.Write(frame.Data); err != nil {
+			_ = stream.Reset()
+			return nil, err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	hash, err := CalculateFileHash(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if hash != r.Hash {
+		return nil, fmt.Errorf("hash mismatch")
+	}
+
+	if err := os.Rename(tmp, localPath); err != nil {
+		return nil, err
+	}
+	_ = os.Chtimes(localPath, r.ModTime, r.ModTime)
+
+	return os.Stat(localPath)
+}
+
+// handleStream serves index exchanges and file requests from peers
+func (m *FolderSyncManager) handleStream(stream network.Stream) {
+	defer func() {
+		_ = stream.Close()
+	}()
+
+	remotePeer := stream.Conn().RemotePeer()
+	frame, err := m.readFrame(stream)
+	if err != nil {
+		m.logger.WithError(err).Debug("Failed to read folder sync request")
+		return
+	}
+
+	// The sender's local folder is our remote and vice versa
+	folder := m.findFolder(remotePeer, frame.Remote, frame.Local)
+	if folder == nil {
+		m.logger.WithFields(logrus.Fields{
+			"peer":   remotePeer.String(),
+			"folder": frame.Remote,
+		}).Warn("Rejected sync request for a folder that is not shared with this peer")
+		_ = m.writeFrame(stream, FolderSyncFrame{Type: "error", Error: "folder is not shared with you", Code: ErrCodePolicy})
+		return
+	}
+
+	switch frame.Type {
+	case "index":
+		m.handleIndex(stream, remotePeer, folder, frame.Files)
+	case "get":
+		m.serveFile(stream, folder, frame.Path, frame.Hash)
+	default:
+		_ = m.writeFrame(stream, FolderSyncFrame{Type: "error", Error: "unknown request", Code: ErrCodeUnsupported})
+	}
+}
+
+// handleIndex answers a peer's index with ours, then pulls the peer's changes
+func (m *FolderSyncManager) handleIndex(stream network.Stream, remotePeer peer.ID, folder *SyncFolder, remote []*SyncFileState) {
+	lock := m.folderLock(folder.ID)
+	if !lock.TryLock() {
+		_ = m.writeFrame(stream, FolderSyncFrame{Type: "busy"})
+		return
+	}
+	defer lock.Unlock()
+
+	index, err := loadFolderIndex(m.indexPath(folder))
+	if err == nil {
+		_, err = index.scan(folder.Local, m.host.ID().String())
+	}
+	if err != nil {
+		m.logger.WithError(err).Warn("Failed to scan sync folder")
+		_ = m.writeFrame(stream, FolderSyncFrame{Type: "error", Error: "failed to scan folder", Code: ErrCodeInternal})
+		return
+	}
+
+	if err := m.writeFrame(stream, FolderSyncFrame{Type: "index", Files: index.list()}); err != nil {
+		return
+	}
+	_ = stream.Close()
+
+	m.apply(context.Background(), remotePeer, folder, index, remote)
+	if err := index.save(m.indexPath(folder)); err != nil {
+		m.logger.WithError(err).Warn("Failed to save folder index")
+	}
+
+	m.mu.Lock()
+	m.lastSync[folder.ID] = time.Now()
+	m.mu.Unlock()
+}
+
+// serveFile sends the requested version of a file
+func (m *FolderSyncManager) serveFile(stream network.Stream, folder *SyncFolder, path, hash string) {
+	if !validManifestPath(path) {
+		_ = m.writeFrame(stream, FolderSyncFrame{Type: "error", Error: "invalid path", Code: ErrCodeInvalid})
+		return
+	}
+
+	fullPath := filepath.Join(folder.Local, filepath.FromSlash(path))
+	if current, err := CalculateFileHash(fullPath); err != nil || current != hash {
+		_ = m.writeFrame(stream, FolderSyncFrame{Type: "error", Error: "file changed", Code: ErrCodeBusy})
+		return
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		_ = m.writeFrame(stream, FolderSyncFrame{Type: "error", Error: "file unavailable", Code: ErrCodeInternal})
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	pooled := getChunkBuffer()
+	defer putChunkBuffer(pooled)
+	buffer := *pooled
+	for {
+		n, err := io.ReadFull(file, buffer)
+		if n > 0 {
+			if err := m.writeFrame(stream, FolderSyncFrame{Type: "chunk", Data: buffer[:n]}); err != nil {
+				return
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			_ = stream.Reset()
+			return
+		}
+	}
+
+	_ = m.writeFrame(stream, FolderSyncFrame{Type: "complete"})
+}
+
+// frameTimeout returns the per-frame timeout for a peer: Timeout, raised to
+// the configured message timeout and for slow links
+func (m *FolderSyncManager) frameTimeout(peerID peer.ID) time.Duration {
+	learned := m.timeouts.Config().For(peerID.String(), m.host.Peerstore().LatencyEWMA(peerID))
+	return max(m.Timeout, learned.Message)
+}
+
+// writeFrame sends a folder sync frame with a deadline
+func (m *FolderSyncManager) writeFrame(stream network.Stream, frame FolderSyncFrame) error {
+	frame.Magic = FileTransferMagic
+	_ = stream.SetWriteDeadline(time.Now().Add(m.frameTimeout(stream.Conn().RemotePeer())))
+	return writeFrame(stream, frame)
+}
+
+// readFrame receives a folder sync frame with a deadline
+func (m *FolderSyncManager) readFrame(stream network.Stream) (*FolderSyncFrame, error) {
+	_ = stream.SetReadDeadline(time.Now().Add(m.frameTimeout(stream.Conn().RemotePeer())))
+
+	var frame FolderSyncFrame
+	if err := readFrame(stream, DirMaxManifestSize, &frame); err != nil {
+		return nil, err
+	}
+	if frame.Magic != FileTransferMagic {
+		return nil, fmt.Errorf("invalid magic number: %x", frame.Magic)
+	}
+	return &frame, nil
+}
@@ -2,8 +2,11 @@ package message
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"strconv"
+	"strings"
 
+	"github.com/Xelvra/peerchat/internal/ui"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,21 +24,45 @@ func NewConsoleMessageHandler(logger *logrus.Logger) *ConsoleMessageHandler {
 
 // HandleMessage handles a message by printing it to console
 func (h *ConsoleMessageHandler) HandleMessage(ctx context.Context, msg *Message) error {
+	filter := FilterMatchOf(msg.Metadata)
+	if filter.Muted || filter.Archived {
+		h.logger.WithField("message_id", msg.ID).Debug("Message hidden by content filter")
+		return nil
+	}
+
 	switch msg.Type {
 	case MessageTypeText:
-		fmt.Printf("\n📨 Message from %s:\n", msg.From)
-		fmt.Printf("   %s\n", string(msg.Content))
-		fmt.Printf("   [%s]\n\n", msg.Timestamp.Format("15:04:05"))
+		ui.Printf("\n📨 Message from %s:\n", msg.From)
+		ui.Printf("   %s\n", string(msg.Content))
+		if len(filter.Tags) > 0 {
+			ui.Printf("   🏷️  %s\n", strings.Join(filter.Tags, ", "))
+		}
+		ui.Printf("   [%s]\n\n", msg.Timestamp.Format("15:04:05"))
 
 	case MessageTypeSystem:
-		fmt.Printf("\n🔧 System message from %s:\n", msg.From)
-		fmt.Printf("   %s\n", string(msg.Content))
-		fmt.Printf("   [%s]\n\n", msg.Timestamp.Format("15:04:05"))
+		if msg.Metadata[MetadataKind] == KindKeyChange {
+			ui.Println()
+			ui.Warn("================================================")
+			ui.Warn("SAFETY NUMBER CHANGED for contact '%v'", msg.Metadata["contact"])
+			ui.Warn("================================================")
+			ui.Printf("   Old Peer ID: %v\n", msg.Metadata["old_peer_id"])
+			ui.Printf("   New Peer ID: %v\n", msg.Metadata["new_peer_id"])
+			ui.Println("   Their key may have been rotated or reinstalled - or someone")
+			ui.Println("   may be intercepting the conversation.")
+			ui.Printf("💡 Compare safety numbers, then run '/verify %v' to continue sending\n\n", msg.Metadata["contact"])
+			break
+		}
+		notice, err := NoticeOf(msg.Content, msg.Metadata)
+		if err != nil {
+			return err
+		}
+		ui.Printf("\n🔧 %s: %s\n", msg.From, notice.Text(nil))
+		ui.Printf("   [%s]\n\n", msg.Timestamp.Format("15:04:05"))
 
 	default:
-		fmt.Printf("\n📦 %s message from %s:\n", msg.Type.String(), msg.From)
-		fmt.Printf("   Size: %d bytes\n", len(msg.Content))
-		fmt.Printf("   [%s]\n\n", msg.Timestamp.Format("15:04:05"))
+		ui.Printf("\n📦 %s message from %s:\n", msg.Type.String(), msg.From)
+		ui.Printf("   Size: %d bytes\n", len(msg.Content))
+		ui.Printf("   [%s]\n\n", msg.Timestamp.Format("15:04:05"))
 	}
 
 	h.logger.WithFields(logrus.Fields{
@@ -46,3 +73,71 @@ func (h *ConsoleMessageHandler) HandleMessage(ctx context.Context, msg *Message)
 
 	return nil
 }
+
+// HandleDeliveryError prints why a recipient refused a message
+func (h *ConsoleMessageHandler) HandleDeliveryError(msg *Message, err *ProtocolError) {
+	ui.Printf("\n❌ Message to %s was not delivered: %s\n", msg.To, err.Message)
+	ui.Printf("💡 %s\n\n", err.Hint())
+
+	h.logger.WithFields(logrus.Fields{
+		"message_id": msg.ID,
+		"to":         msg.To,
+		"code":       err.Code,
+	}).Debug("Delivery error handled by console handler")
+}
+
+// HandleOfflineBacklog announces messages a peer queued while this node was
+// offline
+func (h *ConsoleMessageHandler) HandleOfflineBacklog(peerID string, count int) {
+	ui.Printf("\n📬 %s has %s queued messages for you, fetching…\n\n", peerID, formatCount(count))
+}
+
+// HandleWake announces that a peer woke this node to fetch what it queued
+func (h *ConsoleMessageHandler) HandleWake(peerID string) {
+	ui.Printf("\n🔔 Woken by %s, fetching queued messages…\n\n", peerID)
+}
+
+// HandleReconnected announces that a dropped contact was reconnected
+func (h *ConsoleMessageHandler) HandleReconnected(peerID string) {
+	ui.Printf("\n🔄 Reconnected to %s\n\n", peerID)
+}
+
+// HandleTransferRelayed warns that a file goes through a relay because the
+// peer cannot be reached directly
+func (h *ConsoleMessageHandler) HandleTransferRelayed(transfer *FileTransfer) {
+	ui.Printf("\n🐢 No direct connection to %s: sending %s through a relay, which is slower\n\n", transfer.PeerID, transfer.Metadata.Name)
+}
+
+// HandleQueuedTransferStarted announces that a queued file is being sent
+func (h *ConsoleMessageHandler) HandleQueuedTransferStarted(q *QueuedTransfer) {
+	if q.BytesAcked > 0 {
+		ui.Printf("\n📤 Resuming %s to %s (ID %s)\n\n", q.Metadata.Name, q.PeerID, q.ID())
+	} else {
+		ui.Printf("\n📤 Sending %s to %s (ID %s)\n\n", q.Metadata.Name, q.PeerID, q.ID())
+	}
+}
+
+// HandleQueuedTransferDone prints how a queued file transfer ended
+func (h *ConsoleMessageHandler) HandleQueuedTransferDone(q *QueuedTransfer, err error) {
+	switch {
+	case err == nil:
+		ui.Printf("\n✅ Sent %s to %s\n\n", q.Metadata.Name, q.PeerID)
+	case errors.Is(err, ErrTransferCancelled):
+		ui.Printf("\n🚫 Sending %s to %s was cancelled\n\n", q.Metadata.Name, q.PeerID)
+	default:
+		ui.Printf("\n❌ Sending %s to %s failed: %v\n", q.Metadata.Name, q.PeerID, err)
+		if pe, ok := AsProtocolError(err); ok {
+			ui.Info("%s", pe.Hint())
+		}
+		ui.Println()
+	}
+}
+
+// formatCount formats n with thousands separators
+func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
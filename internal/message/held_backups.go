@@ -0,0 +1,298 @@
+package message
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// BackupHostingFileName lists the peers this node holds backups for
+	BackupHostingFileName = "backup_hosting.json"
+
+	// HeldBackupsDirName holds the backups of other peers in the data directory
+	HeldBackupsDirName = "held_backups"
+
+	// DefaultBackupQuota is the space a peer's backups may take by default
+	DefaultBackupQuota = 100 << 20
+
+	// heldBackupIndexFileName describes the held backups
+	heldBackupIndexFileName = "index.json"
+)
+
+// blobIDPattern restricts blob IDs to the hex IDs derived by NewBackupSecrets
+var blobIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// BackupHosting lists the peers allowed to store backups on this node, with
+// the space each may use. A quota of zero means no limit.
+type BackupHosting struct {
+	Peers map[string]int64 `json:"peers"`
+}
+
+// LoadBackupHosting reads the backup hosting settings at path. Without a
+// file no peer may store backups.
+func LoadBackupHosting(path string) (*BackupHosting, error) {
+	hosting := &BackupHosting{Peers: make(map[string]int64)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hosting, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, hosting); err != nil {
+		return nil, fmt.Errorf("failed to parse backup hosting settings: %w", err)
+	}
+	if hosting.Peers == nil {
+		hosting.Peers = make(map[string]int64)
+	}
+	return hosting, nil
+}
+
+// SaveBackupHosting writes the backup hosting settings to path
+func SaveBackupHosting(path string, hosting *BackupHosting) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(hosting, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// HeldBackup is an encrypted backup held for a peer
+type HeldBackup struct {
+	BlobID    string    `json:"blob_id"`
+	Owner     string    `json:"owner"` // Peer that stored it
+	Size      int64     `json:"size"`
+	Hash      string    `json:"hash"`
+	TokenHash string    `json:"token_hash"`
+	StoredAt  time.Time `json:"stored_at"`
+}
+
+// HeldBackupStore keeps the backups held for other peers. The backups are
+// opaque: they are encrypted with keys this node never sees.
+type HeldBackupStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewHeldBackupStore creates a store in dir
+func NewHeldBackupStore(dir string) *HeldBackupStore {
+	return &HeldBackupStore{dir: dir}
+}
+
+// List returns the held backups, most recently stored first
+func (s *HeldBackupStore) List() ([]*HeldBackup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]*HeldBackup, 0, len(index))
+	for _, held := range index {
+		backups = append(backups, held)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].StoredAt.After(backups[j].StoredAt)
+	})
+	return backups, nil
+}
+
+// Check reports whether owner may store a backup of size bytes under blobID
+// within quota. A backup replaces the one stored under the same ID.
+func (s *HeldBackupStore) Check(owner, blobID string, size, quota int64) *ProtocolError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return NewProtocolError(ErrCodeInternal, "backups unavailable")
+	}
+	return checkHeldBackup(index, owner, blobID, size, quota)
+}
+
+// checkHeldBackup checks a store against the index; the caller holds the lock
+func checkHeldBackup(index map[string]*HeldBackup, owner, blobID string, size, quota int64) *ProtocolError {
+	if !blobIDPattern.MatchString(blobID) {
+		return NewProtocolError(ErrCodeInvalid, "invalid backup ID")
+	}
+	if held, ok := index[blobID]; ok && held.Owner != owner {
+		return NewProtocolError(ErrCodePolicy, "backup ID belongs to another peer")
+	}
+	if quota <= 0 {
+		return nil
+	}
+
+	used := size
+	for id, held := range index {
+		if held.Owner == owner && id != blobID {
+			used += held.Size
+		}
+	}
+	if used > quota {
+		return NewProtocolError(ErrCodeQuota, "backup quota exceeded: %d MB of %d MB", used>>20, quota>>20)
+	}
+	return nil
+}
+
+// Put stores the backup described by held, read from r, replacing any
+// previous backup under its ID once the new one is complete and matches
+// its hash
+func (s *HeldBackupStore) Put(held *HeldBackup, r io.Reader, quota int64) *ProtocolError {
+	if pe := s.Check(held.Owner, held.BlobID, held.Size, quota); pe != nil {
+		return pe
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return NewProtocolError(ErrCodeInternal, "backups unavailable")
+	}
+
+	// Receive outside the lock; only the rename replaces the held backup
+	tmp, err := os.CreateTemp(s.dir, held.BlobID+".*.tmp")
+	if err != nil {
+		return NewProtocolError(ErrCodeInternal, "backups unavailable")
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || n != held.Size {
+		return NewProtocolError(ErrCodeIntegrity, "backup incomplete: %d of %d bytes", n, held.Size)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != held.Hash {
+		return NewProtocolError(ErrCodeIntegrity, "backup does not match its hash")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return NewProtocolError(ErrCodeInternal, "backups unavailable")
+	}
+	// Another store may have raced this one
+	if pe := checkHeldBackup(index, held.Owner, held.BlobID, held.Size, quota); pe != nil {
+		return pe
+	}
+	if err := os.Rename(tmp.Name(), s.path(held.BlobID)); err != nil {
+		return NewProtocolError(ErrCodeInternal, "failed to keep backup")
+	}
+
+	held.StoredAt = time.Now()
+	index[held.BlobID] = held
+	if err := s.saveIndex(index); err != nil {
+		return NewProtocolError(ErrCodeInternal, "failed to keep backup")
+	}
+	return nil
+}
+
+// Open returns the backup stored under blobID if token grants access to it
+func (s *HeldBackupStore) Open(blobID string, token []byte) (*HeldBackup, *os.File, *ProtocolError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return nil, nil, NewProtocolError(ErrCodeInternal, "backups unavailable")
+	}
+
+	// Unknown IDs and wrong tokens look the same to the requester
+	held, ok := index[blobID]
+	if !ok || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(held.TokenHash)) != 1 {
+		return nil, nil, NewProtocolError(ErrCodeNotFound, "no backup for this recovery phrase")
+	}
+
+	file, err := os.Open(s.path(blobID))
+	if err != nil {
+		return nil, nil, NewProtocolError(ErrCodeInternal, "backup unreadable")
+	}
+	return held, file, nil
+}
+
+// RemoveOwner deletes the backups held for a peer and returns how many were
+// deleted and their size
+func (s *HeldBackupStore) RemoveOwner(owner string) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.loadIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	removed, freed := 0, int64(0)
+	for id, held := range index {
+		if held.Owner != owner {
+			continue
+		}
+		if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		delete(index, id)
+		removed++
+		freed += held.Size
+	}
+	if removed == 0 {
+		return 0, 0, nil
+	}
+	return removed, freed, s.saveIndex(index)
+}
+
+// path returns where the backup with the given ID is kept
+func (s *HeldBackupStore) path(blobID string) string {
+	return filepath.Join(s.dir, blobID+".bin")
+}
+
+// loadIndex reads the held backup index; the caller holds the lock
+func (s *HeldBackupStore) loadIndex() (map[string]*HeldBackup, error) {
+	index := make(map[string]*HeldBackup)
+
+	data, err := os.ReadFile(filepath.Join(s.dir, heldBackupIndexFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("failed to read held backup index: %w", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse held backup index: %w", err)
+	}
+	return index, nil
+}
+
+// saveIndex writes the held backup index; the caller holds the lock
+func (s *HeldBackupStore) saveIndex(index map[string]*HeldBackup) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, heldBackupIndexFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write held backup index: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,271 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HooksFileName holds the wake hooks in the data directory
+	HooksFileName = "hooks.json"
+
+	// HookMinInterval is how often a hook fires at most, so a burst of
+	// messages wakes a machine once
+	HookMinInterval = time.Minute
+
+	// HookTimeout bounds how long a hook command may run
+	HookTimeout = 30 * time.Second
+
+	// DefaultWakeBroadcast is where Wake-on-LAN packets are sent unless a
+	// hook names another address
+	DefaultWakeBroadcast = "255.255.255.255:9"
+)
+
+// Hook wakes another machine, by a Wake-on-LAN packet or by running a
+// command, when a matching message arrives
+type Hook struct {
+	ID        int      `json:"id"`
+	PeerID    string   `json:"peer_id,omitempty"`   // Only messages from this peer; empty for all
+	Class     string   `json:"class,omitempty"`     // Only this message type, e.g. text or file; empty for all
+	Tag       string   `json:"tag,omitempty"`       // Only messages a content filter tagged with this
+	WakeMAC   string   `json:"wake_mac,omitempty"`  // Send a Wake-on-LAN packet to this machine
+	Broadcast string   `json:"broadcast,omitempty"` // Address for the packet; empty for DefaultWakeBroadcast
+	Command   []string `json:"command,omitempty"`   // Or run this program with its arguments
+}
+
+// HookEvent describes a received message to the hooks
+type HookEvent struct {
+	PeerID string
+	Class  string
+	Tags   []string
+}
+
+// Validate checks that the hook has exactly one action and valid conditions
+func (h *Hook) Validate() error {
+	if (h.WakeMAC == "") == (len(h.Command) == 0) {
+		return fmt.Errorf("hooks need either a Wake-on-LAN address or a command")
+	}
+	if h.WakeMAC != "" {
+		if _, err := net.ParseMAC(h.WakeMAC); err != nil {
+			return fmt.Errorf("invalid MAC address: %w", err)
+		}
+		if h.Broadcast != "" {
+			if _, _, err := net.SplitHostPort(h.Broadcast); err != nil {
+				return fmt.Errorf("invalid broadcast address: %w", err)
+			}
+		}
+	}
+	if h.Class != "" && !slices.Contains(messageClasses(), h.Class) {
+		return fmt.Errorf("unknown message class %q, expected one of %s", h.Class, strings.Join(messageClasses(), ", "))
+	}
+	return nil
+}
+
+// Matches reports whether the hook fires for event
+func (h *Hook) Matches(event HookEvent) bool {
+	if h.PeerID != "" && h.PeerID != event.PeerID {
+		return false
+	}
+	if h.Class != "" && h.Class != event.Class {
+		return false
+	}
+	return h.Tag == "" || slices.Contains(event.Tags, h.Tag)
+}
+
+// Run performs the hook's action. Commands get the sender and the message
+// class in XELVRA_PEER_ID and XELVRA_CLASS, but never the content.
+func (h *Hook) Run(ctx context.Context, event HookEvent) error {
+	if h.WakeMAC != "" {
+		return SendWakeOnLAN(h.WakeMAC, h.Broadcast)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, HookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"XELVRA_PEER_ID="+event.PeerID,
+		"XELVRA_CLASS="+event.Class,
+		"XELVRA_TAGS="+strings.Join(event.Tags, ","),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// String describes the hook for display
+func (h *Hook) String() string {
+	var when []string
+	if h.Class != "" {
+		when = append(when, h.Class)
+	}
+	if h.Tag != "" {
+		when = append(when, fmt.Sprintf("tagged %q", h.Tag))
+	}
+	if len(when) == 0 {
+		when = append(when, "any message")
+	}
+
+	action := "run " + strings.Join(h.Command, " ")
+	if h.WakeMAC != "" {
+		action = "wake " + h.WakeMAC
+		if h.Broadcast != "" {
+			action += " via " + h.Broadcast
+		}
+	}
+	return strings.Join(when, ", ") + " → " + action
+}
+
+// messageClasses returns the names of the message types
+func messageClasses() []string {
+	var classes []string
+	for mt := MessageTypeText; mt <= MessageTypeSystem; mt++ {
+		classes = append(classes, mt.String())
+	}
+	return classes
+}
+
+// SendWakeOnLAN sends a Wake-on-LAN magic packet for the machine with the
+// given MAC address to broadcast, or to DefaultWakeBroadcast if empty
+func SendWakeOnLAN(mac, broadcast string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address: %w", err)
+	}
+	if broadcast == "" {
+		broadcast = DefaultWakeBroadcast
+	}
+
+	// Six 0xFF bytes, then the address sixteen times
+	packet := make([]byte, 0, 6+16*len(hw))
+	for i := 0; i < 6; i++ {
+		packet = append(packet, 0xff)
+	}
+	for i := 0; i < 16; i++ {
+		packet = append(packet, hw...)
+	}
+
+	conn, err := net.Dial("udp", broadcast)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
+
+// LoadHooks reads the hooks stored at path. A missing file means no hooks.
+func LoadHooks(path string) ([]*Hook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var hooks []*Hook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("failed to parse hooks: %w", err)
+	}
+	for _, h := range hooks {
+		if err := h.Validate(); err != nil {
+			return nil, fmt.Errorf("hook %d: %w", h.ID, err)
+		}
+	}
+	return hooks, nil
+}
+
+// SaveHooks writes the hooks to path
+func SaveHooks(path string, hooks []*Hook) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if hooks == nil {
+		hooks = []*Hook{}
+	}
+	data, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// hookSource caches the hooks, reloading them when the file changes so CLI
+// edits apply to a running node, and remembers when each last fired
+type hookSource struct {
+	path   string
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	modTime time.Time
+	loaded  bool
+	hooks   []*Hook
+	fired   map[int]time.Time
+}
+
+// newHookSource creates a source for the hooks at path
+func newHookSource(path string, logger *logrus.Logger) *hookSource {
+	return &hookSource{path: path, logger: logger, fired: make(map[int]time.Time)}
+}
+
+// Due returns the hooks matching event that did not fire within
+// HookMinInterval of now, and marks them fired
+func (s *hookSource) Due(event HookEvent, now time.Time) []*Hook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modTime time.Time
+	if info, err := os.Stat(s.path); err == nil {
+		modTime = info.ModTime()
+	}
+	if !s.loaded || !modTime.Equal(s.modTime) {
+		hooks, err := LoadHooks(s.path)
+		if err != nil {
+			// Keep the previous hooks rather than none
+			s.logger.WithError(err).Warn("Failed to load wake hooks")
+			hooks = s.hooks
+		}
+		s.hooks, s.modTime, s.loaded = hooks, modTime, true
+	}
+
+	var due []*Hook
+	for _, h := range s.hooks {
+		if !h.Matches(event) || now.Sub(s.fired[h.ID]) < HookMinInterval {
+			continue
+		}
+		s.fired[h.ID] = now
+		due = append(due, h)
+	}
+	return due
+}
+
+// fireHooks runs the hooks due for a received message in the background
+func (mm *MessageManager) fireHooks(event HookEvent) {
+	if !mm.notifies(event.PeerID) {
+		return
+	}
+	for _, h := range mm.hooks.Due(event, time.Now()) {
+		go func(h *Hook) {
+			fields := logrus.Fields{"hook": h.ID, "peer": event.PeerID, "class": event.Class}
+			if err := h.Run(mm.ctx, event); err != nil {
+				mm.logger.WithError(err).WithFields(fields).Warn("Wake hook failed")
+				return
+			}
+			mm.logger.WithFields(fields).Info("Wake hook fired")
+		}(h)
+	}
+}
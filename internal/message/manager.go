@@ -4,13 +4,18 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Xelvra/peerchat/internal/crypto"
+	"github.com/Xelvra/peerchat/internal/logging"
+	"github.com/Xelvra/peerchat/internal/tracing"
 	"github.com/Xelvra/peerchat/internal/user"
 	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -24,15 +29,21 @@ const (
 	// Protocol IDs for different message types
 	MessageProtocolID = protocol.ID("/xelvra/message/1.0.0")
 	FileProtocolID    = protocol.ID("/xelvra/file/1.0.0")
+	DirProtocolID     = protocol.ID("/xelvra/dir/1.0.0")
 	GroupProtocolID   = protocol.ID("/xelvra/group/1.0.0")
 
 	// Message limits
 	MaxMessageSize = 64 * 1024         // 64KB max message size
 	MaxFileSize    = 100 * 1024 * 1024 // 100MB max file size
 
-	// Timeouts
+	// Default timeouts; see TimeoutConfig for configuring them
 	MessageTimeout = 30 * time.Second
+	ReceiptTimeout = 10 * time.Second // Wait for the receiver to accept or refuse a message
 	FileTimeout    = 5 * time.Minute
+
+	// Metadata keys and values for system messages
+	MetadataKind  = "kind"
+	KindKeyChange = "key_change"
 )
 
 // MessageType represents different types of messages
@@ -79,6 +90,21 @@ type Message struct {
 	Timestamp   time.Time              `json:"timestamp"`
 	Signature   []byte                 `json:"signature"`
 	IsEncrypted bool                   `json:"is_encrypted"`
+
+	// TraceParent links the receiver's span to the sender's trace; it is
+	// set only while the sender traces and is not signed
+	TraceParent string `json:"trace_parent,omitempty"`
+
+	// Spans of a traced send, handed to the outgoing queue with the message
+	span      *tracing.Span
+	queueSpan *tracing.Span
+
+	// Peer a received message came from
+	fromPeer peer.ID
+
+	// Body sealed once for a send to many peers, whose key is sealed in
+	// each recipient's session; see SendMessageToPeers
+	shared *crypto.SharedBody
 }
 
 // OfflineMessage represents a message stored for offline delivery
@@ -103,17 +129,153 @@ type MessageManager struct {
 	// Offline message storage
 	offlineMessages map[string][]*OfflineMessage // peer ID -> messages
 	offlineMutex    sync.RWMutex
-	offlineDir      string
+	offlineStore    OfflineStore  // Nil keeps the queue in memory only
+	offlineWake     chan struct{} // Runs offline delivery now, e.g. when a peer connects
+	connNotifiee    network.Notifiee
+
+	// Outbox of messages accepted by SendMessage and not yet delivered,
+	// persisted so they survive a restart
+	outbox        []*Message
+	outboxMutex   sync.Mutex
+	outboxDrained chan struct{} // Closed when the outbox empties, nil while no one waits
 
 	// File transfer management
 	fileTransferManager *FileTransferManager
 
+	// Files queued for sending, kept on disk; queueActive holds the IDs of
+	// those being sent and is guarded by queueMu
+	queuePath   string
+	queueMu     sync.Mutex
+	queueActive map[string]bool
+	queueWake   chan struct{} // Checks the queue now, e.g. when a peer connects
+
+	// Contacts with pinned identity keys
+	contacts *user.ContactBook
+
+	// Curve25519 identity of the host key and the sessions sealing text
+	// messages with each peer; signal is nil when the host key cannot be
+	// converted
+	signal   *crypto.SignalCrypto
+	sessions *sessionStore
+
+	// Log of security events such as session resets
+	audit *logging.AuditLog
+
+	// What was agreed with each connected peer in the capability handshake
+	capabilities   map[peer.ID]*PeerCapabilities
+	capabilitiesMu sync.Mutex
+
+	// Long-lived streams carrying the messages to peers that agreed on
+	// FeatureMessageStream
+	messageStreams   map[peer.ID]*messageStream
+	messageStreamsMu sync.Mutex
+
+	// Optional message history, which may be set while running
+	recorder   MessageRecorder
+	recorderMu sync.RWMutex
+
+	// Configured timeouts, disk quotas, content filters and the peers whose
+	// files are accepted without asking
+	timeouts      *timeoutSource
+	quotas        *quotaSource
+	filters       *filterSource
+	fileAllowlist *allowlistSource
+
+	// Hooks waking other machines when matching messages arrive
+	hooks *hookSource
+
+	// Optional program run for every text message received
+	onMessageHook   string
+	onMessageEvents chan *OnMessageEvent
+
+	// Profile facets and which peer is shown which
+	profilesPath string
+
+	// Peers allowed to back up to this node, and the backups held for them
+	backupHostingPath string
+	heldBackups       *HeldBackupStore
+
+	// Optional tracer timing each hop of a message
+	tracer *tracing.Tracer
+
+	// Incoming files waiting for the user, by number
+	offersMu    sync.Mutex
+	offers      map[int]*pendingOffer
+	offerSeq    int
+	onFileOffer func(offer *FileOffer)
+
+	// Listener taking received text messages instead of their handler
+	listenerMu sync.Mutex
+	onMessage  func(peerID string, msg *Message)
+
+	// OnDeliveryFailed, if set, is called when a recipient refuses a message
+	OnDeliveryFailed func(msg *Message, err *ProtocolError)
+
+	// OnDelivered, if set, is called when a recipient accepted a message,
+	// whether it was sent right away or from the offline queue
+	OnDelivered func(msg *Message)
+
+	// OnOfflineBacklog, if set, is called when a peer starts delivering the
+	// messages it queued while this node was offline
+	OnOfflineBacklog func(peerID string, count int)
+
+	// OnTransferRelayed, if set, is called when a file is sent through a
+	// relay because the peer cannot be reached directly
+	OnTransferRelayed func(transfer *FileTransfer)
+
+	// OnQueuedTransferStarted and OnQueuedTransferDone, if set, are called
+	// when a queued file starts being sent and when it leaves the queue,
+	// with a nil error once sent
+	OnQueuedTransferStarted func(q *QueuedTransfer)
+	OnQueuedTransferDone    func(q *QueuedTransfer, err error)
+
+	// OnNotice, if set, is called with the notice of every system message
+	// received, so bots can react to it
+	OnNotice func(from string, notice *Notice)
+
+	// OnStoredOffline, if set, is called when a message is queued for a
+	// peer that is not connected, so the peer can be woken to fetch it
+	OnStoredOffline func(peerID string)
+
+	// Messages written to peers and accepted from them, for throughput
+	messagesSent     atomic.Uint64
+	messagesReceived atomic.Uint64
+
 	// Context for cancellation
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
+// MessageCounts returns how many messages were written to peers and
+// accepted from them since the manager started
+func (mm *MessageManager) MessageCounts() (sent, received uint64) {
+	return mm.messagesSent.Load(), mm.messagesReceived.Load()
+}
+
+// MessageReceipt is the receiver's answer to a message. Refused messages
+// carry the reason so the sender can act on it.
+type MessageReceipt struct {
+	Accepted bool           `json:"accepted"`
+	Error    *ProtocolError `json:"error,omitempty"`
+}
+
+// err returns the refusal a receipt carries, or nil if it accepts
+func (r MessageReceipt) err() error {
+	if r.Accepted {
+		return nil
+	}
+	if r.Error == nil {
+		return remoteError("", "")
+	}
+	return remoteError(r.Error.Code, r.Error.Message)
+}
+
+// MessageRecorder stores a copy of every message sent or received
+type MessageRecorder interface {
+	RecordMessage(msg *Message, peerID string, outgoing bool) error
+}
+
 // MessageHandler defines the interface for handling different message types
 type MessageHandler interface {
 	HandleMessage(ctx context.Context, msg *Message) error
@@ -123,13 +285,20 @@ type MessageHandler interface {
 func NewMessageManager(h host.Host, identity *user.MessengerID, logger *logrus.Logger) *MessageManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Create offline messages directory
-	homeDir, _ := os.UserHomeDir()
-	offlineDir := filepath.Join(homeDir, ".xelvra", "offline_messages")
-	if err := os.MkdirAll(offlineDir, 0700); err != nil {
-		logger.WithError(err).Error("Failed to create offline messages directory")
-		// Continue with empty offline directory path
-		offlineDir = ""
+	// Offline messages are kept encrypted in the offline messages directory
+	dataDir, _ := user.DataDir()
+	var offlineStore OfflineStore
+	if store, err := NewFileOfflineStore(filepath.Join(dataDir, OfflineDirName), identity, logger); err != nil {
+		logger.WithError(err).Error("Offline messages are kept in memory only")
+	} else {
+		offlineStore = store
+	}
+
+	// Load contact book for key pinning
+	contacts, err := user.LoadContactBook(filepath.Join(dataDir, user.ContactsFileName))
+	if err != nil {
+		logger.WithError(err).Error("Failed to load contact book")
+		contacts, _ = user.LoadContactBook("")
 	}
 
 	mm := &MessageManager{
@@ -140,19 +309,77 @@ func NewMessageManager(h host.Host, identity *user.MessengerID, logger *logrus.L
 		outgoingMessages:    make(chan *Message, 100),
 		messageHandlers:     make(map[MessageType]MessageHandler),
 		offlineMessages:     make(map[string][]*OfflineMessage),
-		offlineDir:          offlineDir,
+		offlineStore:        offlineStore,
+		offlineWake:         make(chan struct{}, 1),
+		timeouts:            newTimeoutSource(filepath.Join(dataDir, TimeoutsFileName), logger),
+		quotas:              newQuotaSource(filepath.Join(dataDir, QuotasFileName), logger),
+		filters:             newFilterSource(filepath.Join(dataDir, FiltersFileName), logger),
+		fileAllowlist:       newAllowlistSource(filepath.Join(dataDir, FileAllowlistFileName), logger),
+		hooks:               newHookSource(filepath.Join(dataDir, HooksFileName), logger),
+		offers:              make(map[int]*pendingOffer),
+		profilesPath:        filepath.Join(dataDir, user.ProfilesFileName),
+		backupHostingPath:   filepath.Join(dataDir, BackupHostingFileName),
+		heldBackups:         NewHeldBackupStore(filepath.Join(dataDir, HeldBackupsDirName)),
 		fileTransferManager: NewFileTransferManager(logger),
+		queuePath:           filepath.Join(dataDir, TransferQueueFileName),
+		queueActive:         make(map[string]bool),
+		queueWake:           make(chan struct{}, 1),
+		contacts:            contacts,
+		signal:              sessionCrypto(h.Peerstore().PrivKey(h.ID())),
+		sessions:            newSessionStore(filepath.Join(dataDir, SessionsFileName), identity, logger),
+		audit:               logging.NewAuditLog(filepath.Join(dataDir, logging.AuditLogFileName)),
+		capabilities:        make(map[peer.ID]*PeerCapabilities),
+		messageStreams:      make(map[peer.ID]*messageStream),
 		ctx:                 ctx,
 		cancel:              cancel,
 	}
 
-	// Load offline messages from disk
+	mm.fileTransferManager.OnComplete = mm.recordTransfer
+	mm.fileTransferManager.OnRelayed = func(transfer *FileTransfer) {
+		if mm.OnTransferRelayed != nil {
+			mm.OnTransferRelayed(transfer)
+		}
+	}
+	mm.fileTransferManager.Attachments = NewAttachmentStore(filepath.Join(dataDir, AttachmentsDirName), logger)
+	mm.fileTransferManager.CheckSpace = mm.checkDownloadSpace
+	mm.fileTransferManager.Approve = mm.approveFileOffer
+	if key := h.Peerstore().PrivKey(h.ID()); key != nil {
+		mm.fileTransferManager.SignReceipt = key.Sign
+	}
+	mm.fileTransferManager.PeerSupports = mm.peerSupports
+	mm.fileTransferManager.PeerAgrees = mm.peerAgrees
+
+	// Load offline messages and unsent messages from disk
 	mm.loadOfflineMessages()
+	mm.loadOutbox()
 
 	// Set up stream handlers
 	h.SetStreamHandler(MessageProtocolID, mm.handleMessageStream)
+	h.SetStreamHandler(MessageStreamProtocolID, mm.handleMessageStreamBatch)
 	h.SetStreamHandler(FileProtocolID, mm.handleFileStream)
+	h.SetStreamHandler(DirProtocolID, mm.handleDirStream)
 	h.SetStreamHandler(GroupProtocolID, mm.handleGroupStream)
+	h.SetStreamHandler(OfflineProtocolID, mm.handleOfflineStream)
+	h.SetStreamHandler(ProfileProtocolID, mm.handleProfileStream)
+	h.SetStreamHandler(BackupProtocolID, mm.handleBackupStream)
+	h.SetStreamHandler(CapabilitiesProtocolID, mm.handleCapabilitiesStream)
+
+	// Deliver queued messages and files as soon as their recipient connects,
+	// and agree on capabilities with it before anything is sent
+	mm.connNotifiee = &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			go func() { _, _ = mm.PeerCapabilities(conn.RemotePeer()) }()
+			mm.wakeOfflineDelivery()
+			mm.wakeTransferQueue()
+		},
+		DisconnectedF: func(n network.Network, conn network.Conn) {
+			if n.Connectedness(conn.RemotePeer()) != network.Connected {
+				mm.forgetCapabilities(conn.RemotePeer())
+				mm.closeMessageStreams(conn.RemotePeer())
+			}
+		},
+	}
+	h.Network().Notify(mm.connNotifiee)
 
 	return mm
 }
@@ -163,13 +390,25 @@ func (mm *MessageManager) Start() error {
 
 	// Start message processing goroutines
 	mm.logger.Debug("Adding goroutines to wait group...")
-	mm.wg.Add(3)
+	mm.wg.Add(5)
 	mm.logger.Debug("Starting processIncomingMessages goroutine...")
 	go mm.processIncomingMessages()
 	mm.logger.Debug("Starting processOutgoingMessages goroutine...")
 	go mm.processOutgoingMessages()
 	mm.logger.Debug("Starting processOfflineMessages goroutine...")
 	go mm.processOfflineMessages()
+	mm.logger.Debug("Starting processTransferQueue goroutine...")
+	go mm.processTransferQueue()
+	mm.wakeTransferQueue()
+	mm.logger.Debug("Starting runDisappearingMessages goroutine...")
+	go mm.runDisappearingMessages()
+	if mm.onMessageHook != "" {
+		mm.wg.Add(1)
+		go mm.runOnMessageHook()
+	}
+
+	// Resume sending messages left in the outbox by the previous run
+	mm.resumeOutbox()
 
 	mm.logger.Info("MessageManager started successfully")
 	return nil
@@ -179,7 +418,9 @@ func (mm *MessageManager) Start() error {
 func (mm *MessageManager) Stop() error {
 	mm.logger.Info("Stopping MessageManager...")
 
+	mm.host.Network().StopNotify(mm.connNotifiee)
 	mm.cancel()
+	mm.closeMessageStreams("")
 	mm.wg.Wait()
 
 	// Close channels
@@ -192,6 +433,64 @@ func (mm *MessageManager) Stop() error {
 
 // SendMessage sends a message to a peer
 func (mm *MessageManager) SendMessage(to string, content []byte, msgType MessageType) error {
+	return mm.sendMessage(to, content, msgType, nil, nil)
+}
+
+// SendMessageToPeers sends a message to many peers. A text message is
+// encrypted once in a crypto.SharedBody and only its content key is sealed
+// in each peer's session, instead of the whole body; peers without
+// FeatureSharedBodies get the body in their session as usual. Every peer is
+// tried, and the error names those the message could not be queued for.
+func (mm *MessageManager) SendMessageToPeers(to []string, content []byte, msgType MessageType) error {
+	var errs []error
+	var valid []string
+	for _, peerIDStr := range to {
+		if _, err := peer.Decode(peerIDStr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid peer ID: %w", peerIDStr, err))
+			continue
+		}
+		valid = append(valid, peerIDStr)
+	}
+
+	var shared *crypto.SharedBody
+	if msgType == MessageTypeText && mm.signal != nil && len(valid) > 0 {
+		var err error
+		if shared, err = crypto.SealSharedBody(content); err != nil {
+			return fmt.Errorf("failed to seal message: %w", err)
+		}
+	}
+
+	for _, peerIDStr := range valid {
+		if err := mm.sendMessage(peerIDStr, content, msgType, nil, shared); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", peerIDStr, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendMessage signs a message with metadata and queues it for a peer. A
+// shared body, if given, is sent in place of the content to a peer that
+// supports FeatureSharedBodies, with only its key sealed in the session.
+func (mm *MessageManager) sendMessage(to string, content []byte, msgType MessageType, metadata map[string]interface{}, shared *crypto.SharedBody) (err error) {
+	// A queued message takes its span along; anything else ends it here
+	span := mm.tracer.Start(nil, SpanSend)
+	span.SetAttribute("message.type", msgType.String())
+	span.SetAttribute("peer", to)
+	queued := false
+	defer func() {
+		if !queued {
+			span.SetError(err)
+			span.Finish()
+		}
+	}()
+
+	// Hold back conversation messages until a changed key is re-verified
+	if msgType != MessageTypeSystem {
+		if err := mm.contacts.CheckSendAllowed(to); err != nil {
+			return err
+		}
+	}
+
 	// Create message
 	msg := &Message{
 		ID:          uuid.New().String(),
@@ -199,31 +498,240 @@ func (mm *MessageManager) SendMessage(to string, content []byte, msgType Message
 		From:        mm.identity.GetDID(),
 		To:          to,
 		Content:     content,
+		Metadata:    metadata,
 		Timestamp:   time.Now(),
 		IsEncrypted: false,
+		TraceParent: traceParentOf(span),
+		shared:      shared,
 	}
+	span.SetAttribute("message.id", msg.ID)
 
 	// Sign the message
-	if err := mm.signMessage(msg); err != nil {
+	sign := span.Child(SpanSign)
+	err = mm.signMessage(msg)
+	sign.SetError(err)
+	sign.Finish()
+	if err != nil {
 		return fmt.Errorf("failed to sign message: %w", err)
 	}
 
+	// Persist before queueing so the message survives a crash
+	mm.addToOutbox(msg)
+
 	// Queue for sending
+	msg.span, msg.queueSpan = span, span.Child(SpanQueue)
 	select {
 	case mm.outgoingMessages <- msg:
+		queued = true
 		return nil
 	case <-mm.ctx.Done():
+		msg.queueSpan.Finish()
 		return fmt.Errorf("message manager stopped")
 	default:
+		msg.queueSpan.Finish()
+		mm.removeFromOutbox(msg.ID)
 		return fmt.Errorf("outgoing message queue full")
 	}
 }
 
+// Contacts returns the contact book used for key pinning
+func (mm *MessageManager) Contacts() *user.ContactBook {
+	return mm.contacts
+}
+
+// AnnounceKeyChange sends a signed key-change announcement to every contact
+// except those in delivered, which already confirmed receiving it. Contacts
+// that are offline receive it through offline delivery; a contact that still
+// has the announcement in its offline queue is not sent another. It returns
+// how many announcements were queued.
+func (mm *MessageManager) AnnounceKeyChange(ann *user.KeyChangeAnnouncement, delivered []string) (int, error) {
+	content, err := json.Marshal(ann)
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize announcement: %w", err)
+	}
+
+	// One ID per announcement tells a queued copy from a new one
+	id := KindKeyChange + ":" + ann.NewPeerID
+	done := make(map[string]bool, len(delivered))
+	for _, peerID := range delivered {
+		done[peerID] = true
+	}
+
+	sent := 0
+	for _, contact := range mm.contacts.List() {
+		if done[contact.PeerID] || mm.queuedOffline(contact.PeerID, id) {
+			continue
+		}
+		msg := &Message{
+			ID:        id,
+			Type:      MessageTypeSystem,
+			From:      mm.identity.GetDID(),
+			To:        contact.PeerID,
+			Content:   content,
+			Metadata:  map[string]interface{}{MetadataKind: KindKeyChange},
+			Timestamp: time.Now(),
+		}
+		if err := mm.signMessage(msg); err != nil {
+			return sent, fmt.Errorf("failed to sign announcement: %w", err)
+		}
+
+		select {
+		case mm.outgoingMessages <- msg:
+			sent++
+		case <-mm.ctx.Done():
+			return sent, fmt.Errorf("message manager stopped")
+		}
+	}
+
+	mm.logger.WithField("contacts", sent).Info("Key change announcement queued for contacts")
+	return sent, nil
+}
+
+// queuedOffline reports whether the message with id waits in the offline
+// queue of a peer
+func (mm *MessageManager) queuedOffline(peerIDStr, id string) bool {
+	mm.offlineMutex.RLock()
+	defer mm.offlineMutex.RUnlock()
+
+	for _, offlineMsg := range mm.offlineMessages[peerIDStr] {
+		if offlineMsg.Message.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handleKeyChange applies a key-change announcement to the contact book and
+// annotates the message so handlers can warn the user
+func (mm *MessageManager) handleKeyChange(msg *Message) error {
+	var ann user.KeyChangeAnnouncement
+	if err := json.Unmarshal(msg.Content, &ann); err != nil {
+		return fmt.Errorf("failed to parse key change announcement: %w", err)
+	}
+
+	contact, err := mm.contacts.ApplyKeyChange(&ann)
+	if err != nil {
+		return fmt.Errorf("rejected key change announcement: %w", err)
+	}
+
+	msg.Metadata["contact"] = contact.Name
+	msg.Metadata["old_peer_id"] = ann.OldPeerID
+	msg.Metadata["new_peer_id"] = ann.NewPeerID
+
+	mm.logger.WithFields(logrus.Fields{
+		"contact":     contact.Name,
+		"old_peer_id": ann.OldPeerID,
+		"new_peer_id": ann.NewPeerID,
+	}).Warn("Contact identity key changed")
+
+	return nil
+}
+
+// SetFileStreams sets how many streams a large file may be split across in
+// either direction; 1 sends every file over a single stream
+func (mm *MessageManager) SetFileStreams(streams int) {
+	mm.fileTransferManager.MaxStreams = min(max(streams, 1), FileStreamsLimit)
+}
+
+// SetFileCompression turns zstd compression of file chunks on or off in
+// either direction
+func (mm *MessageManager) SetFileCompression(enabled bool) {
+	mm.fileTransferManager.Compression = enabled
+}
+
+// SetRecorder sets the history store that records sent and received messages
+func (mm *MessageManager) SetRecorder(recorder MessageRecorder) {
+	mm.recorderMu.Lock()
+	mm.recorder = recorder
+	mm.recorderMu.Unlock()
+}
+
+// historyRecorder returns the history store, or nil if none is set
+func (mm *MessageManager) historyRecorder() MessageRecorder {
+	mm.recorderMu.RLock()
+	defer mm.recorderMu.RUnlock()
+	return mm.recorder
+}
+
+// SetOfflineStore replaces the store of the offline queue and the outbox and
+// loads them from it. Messages loaded from the previous store are dropped,
+// so it is called right after NewMessageManager.
+func (mm *MessageManager) SetOfflineStore(store OfflineStore) {
+	mm.offlineMutex.Lock()
+	mm.offlineStore = store
+	mm.offlineMessages = make(map[string][]*OfflineMessage)
+	mm.offlineMutex.Unlock()
+
+	mm.outboxMutex.Lock()
+	mm.outbox = nil
+	mm.notifyOutboxDrained()
+	mm.outboxMutex.Unlock()
+
+	mm.loadOfflineMessages()
+	mm.loadOutbox()
+}
+
+// SetContactBook replaces the contact book used for key pinning; it is
+// called right after NewMessageManager
+func (mm *MessageManager) SetContactBook(contacts *user.ContactBook) {
+	mm.contacts = contacts
+}
+
+// record stores a message in the history if one is configured
+func (mm *MessageManager) record(msg *Message, peerID string, outgoing bool) {
+	recorder := mm.historyRecorder()
+	if recorder == nil || isReadReceipt(msg) {
+		return
+	}
+	if err := recorder.RecordMessage(msg, peerID, outgoing); err != nil {
+		mm.logger.WithError(err).WithField("message_id", msg.ID).Warn("Failed to record message in history")
+	}
+}
+
+// recordTransfer stores a completed file transfer in the history. Files of
+// directory transfers are summarised by the transfer itself and not recorded.
+func (mm *MessageManager) recordTransfer(transfer *FileTransfer) {
+	if transfer.Metadata.SyncID != "" {
+		return
+	}
+
+	msg := &Message{
+		ID:      transfer.ID,
+		Type:    MessageTypeFile,
+		Content: []byte(transfer.Metadata.Name),
+		Metadata: map[string]interface{}{
+			"file_name": transfer.Metadata.Name,
+			"file_size": transfer.Metadata.Size,
+			"file_hash": transfer.Metadata.Hash,
+			"mime_type": transfer.Metadata.MimeType,
+			"path":      transfer.LocalPath,
+		},
+		Timestamp: transfer.EndTime,
+	}
+	if transfer.isOutgoing {
+		msg.From = mm.identity.GetDID()
+	} else {
+		msg.To = mm.identity.GetDID()
+	}
+
+	mm.record(msg, transfer.PeerID.String(), transfer.isOutgoing)
+}
+
 // RegisterHandler registers a handler for a specific message type
 func (mm *MessageManager) RegisterHandler(msgType MessageType, handler MessageHandler) {
 	mm.messageHandlers[msgType] = handler
 }
 
+// SetMessageListener hands each text message received, once verified, to
+// listener with the peer that sent it, instead of the handler registered
+// for text messages. Muted and archived messages are not handed over; a
+// nil listener restores the handler.
+func (mm *MessageManager) SetMessageListener(listener func(peerID string, msg *Message)) {
+	mm.listenerMu.Lock()
+	defer mm.listenerMu.Unlock()
+	mm.onMessage = listener
+}
+
 // processIncomingMessages processes incoming messages
 func (mm *MessageManager) processIncomingMessages() {
 	defer mm.wg.Done()
@@ -247,9 +755,16 @@ func (mm *MessageManager) processOutgoingMessages() {
 	for {
 		select {
 		case msg := <-mm.outgoingMessages:
-			if err := mm.handleOutgoingMessage(msg); err != nil {
-				mm.logger.WithError(err).Error("Failed to handle outgoing message")
-			}
+			// Receipts are awaited in the background, so the messages
+			// that follow can share a batch on the peer's message stream
+			finish := mm.sendOutgoingMessage(msg)
+			mm.wg.Add(1)
+			go func() {
+				defer mm.wg.Done()
+				if err := finish(); err != nil {
+					mm.logger.WithError(err).Error("Failed to handle outgoing message")
+				}
+			}()
 		case <-mm.ctx.Done():
 			return
 		}
@@ -264,15 +779,50 @@ func (mm *MessageManager) handleIncomingMessage(msg *Message) error {
 		"type":       msg.Type.String(),
 	}).Debug("Processing incoming message")
 
-	// Verify message signature
-	if !mm.verifyMessage(msg) {
-		return fmt.Errorf("message signature verification failed")
+	mm.messagesReceived.Add(1)
+
+	// Key-change announcements update pinned contacts before display
+	if msg.Type == MessageTypeSystem && msg.Metadata[MetadataKind] == KindKeyChange {
+		if err := mm.handleKeyChange(msg); err != nil {
+			return err
+		}
+	}
+	if msg.Type == MessageTypeSystem {
+		notice, err := NoticeOf(msg.Content, msg.Metadata)
+		if err != nil {
+			return err
+		}
+		if notice.Code == NoticeSessionReset {
+			mm.handleSessionReset(msg.fromPeer, notice)
+		}
+		if mm.OnNotice != nil {
+			mm.OnNotice(msg.From, notice)
+		}
 	}
 
-	// Decrypt message if encrypted
-	if msg.IsEncrypted {
-		if err := mm.decryptMessage(msg); err != nil {
-			return fmt.Errorf("failed to decrypt message: %w", err)
+	if msg.Type == MessageTypeText {
+		mm.queueOnMessage(msg)
+
+		mm.listenerMu.Lock()
+		listener := mm.onMessage
+		mm.listenerMu.Unlock()
+		filter := FilterMatchOf(msg.Metadata)
+		shown := !filter.Muted && !filter.Archived
+		if listener != nil {
+			if shown {
+				listener(msg.fromPeer.String(), msg)
+				mm.sendReadReceipt(msg)
+			}
+			return nil
+		}
+		if handler, exists := mm.messageHandlers[msg.Type]; exists {
+			if err := handler.HandleMessage(mm.ctx, msg); err != nil {
+				return err
+			}
+			if shown {
+				mm.sendReadReceipt(msg)
+			}
+			return nil
 		}
 	}
 
@@ -286,8 +836,21 @@ func (mm *MessageManager) handleIncomingMessage(msg *Message) error {
 	return nil
 }
 
-// handleOutgoingMessage processes an outgoing message
-func (mm *MessageManager) handleOutgoingMessage(msg *Message) error {
+// sendOutgoingMessage sends an outgoing message and returns a function
+// that waits for the outcome. Once that returns the message was delivered,
+// refused or handed to the offline store, so it leaves the outbox.
+func (mm *MessageManager) sendOutgoingMessage(msg *Message) func() error {
+	// The send span ends with the outcome whatever happens to the message
+	span := msg.span
+	msg.queueSpan.Finish()
+	msg.span, msg.queueSpan = nil, nil
+	finish := func(err error) error {
+		span.SetError(err)
+		span.Finish()
+		mm.removeFromOutbox(msg.ID)
+		return err
+	}
+
 	mm.logger.WithFields(logrus.Fields{
 		"message_id": msg.ID,
 		"to":         msg.To,
@@ -299,59 +862,180 @@ func (mm *MessageManager) handleOutgoingMessage(msg *Message) error {
 	recipientPeerID, err := peer.Decode(msg.To)
 	if err != nil {
 		mm.logger.WithError(err).Error("Failed to decode recipient peer ID")
-		return fmt.Errorf("invalid recipient peer ID: %w", err)
+		err = fmt.Errorf("invalid recipient peer ID: %w", err)
+		return func() error { return finish(err) }
 	}
 
 	// Check if peer is connected
 	if mm.host.Network().Connectedness(recipientPeerID) != network.Connected {
 		mm.logger.WithField("peer_id", recipientPeerID.String()).Info("Peer not connected, storing message for offline delivery")
+		span.SetAttribute("message.outcome", outcomeOffline)
 		mm.storeOfflineMessage(msg)
-		return nil
+		return func() error { return finish(nil) }
 	}
 
-	// Open a stream to the recipient
-	stream, err := mm.host.NewStream(context.Background(), recipientPeerID, MessageProtocolID)
-	if err != nil {
-		mm.logger.WithError(err).Error("Failed to open stream to recipient, storing for offline delivery")
-		mm.storeOfflineMessage(msg)
-		return nil
+	wait, err := mm.sendTraced(recipientPeerID, msg, span)
+	if err == nil {
+		return func() error { return finish(mm.deliveryOutcome(msg, span, wait())) }
 	}
-	defer func() {
-		if err := stream.Close(); err != nil {
-			mm.logger.WithError(err).Error("Failed to close stream")
+	return func() error { return finish(mm.deliveryOutcome(msg, span, err)) }
+}
+
+// deliveryOutcome handles the outcome of delivering an outgoing message:
+// messages that did not reach the peer are kept for offline delivery
+func (mm *MessageManager) deliveryOutcome(msg *Message, span *tracing.Span, err error) error {
+	if err != nil {
+		pe, refused := AsProtocolError(err)
+		if !refused {
+			mm.logger.WithError(err).Error("Failed to deliver message, storing for offline delivery")
+			span.SetAttribute("message.outcome", outcomeOffline)
+			mm.storeOfflineMessage(msg)
+			return nil
 		}
-	}()
 
-	// Serialize and send the message
-	msgData, err := json.Marshal(msg)
+		span.SetAttribute("message.outcome", outcomeRefused)
+		mm.deliveryFailed(msg, pe)
+		if pe.Retryable() {
+			mm.storeOfflineMessage(msg)
+		}
+		return err
+	}
+
+	mm.logger.WithFields(logrus.Fields{
+		"message_id": msg.ID,
+		"to":         msg.To,
+	}).Info("Message sent successfully")
+	span.SetAttribute("message.outcome", outcomeDelivered)
+	mm.delivered(msg)
+
+	return nil
+}
+
+// delivered records a message the recipient accepted and reports it
+func (mm *MessageManager) delivered(msg *Message) {
+	mm.record(msg, msg.To, true)
+	if mm.OnDelivered != nil {
+		mm.OnDelivered(msg)
+	}
+}
+
+// deliver sends a message and waits for the receiver's receipt. A refusal
+// is returned as a *ProtocolError.
+func (mm *MessageManager) deliver(peerID peer.ID, msg *Message) error {
+	return mm.deliverTraced(peerID, msg, nil)
+}
+
+// deliverTraced delivers a message, timing each hop under span
+func (mm *MessageManager) deliverTraced(peerID peer.ID, msg *Message, span *tracing.Span) error {
+	wait, err := mm.sendTraced(peerID, msg, span)
 	if err != nil {
-		return fmt.Errorf("failed to serialize message: %w", err)
+		return err
+	}
+	return wait()
+}
+
+// sendTraced writes a message and returns a function that waits for the
+// receiver's receipt. Peers that agreed on FeatureMessageStream share one
+// stream for all messages; others get a new stream for each.
+func (mm *MessageManager) sendTraced(peerID peer.ID, msg *Message, span *tracing.Span) (func() error, error) {
+	// Peers that speak no common version would misread the message
+	capabilities, err := mm.PeerCapabilities(peerID)
+	if err == nil && !capabilities.Compatible() {
+		return nil, capabilities.IncompatibleError()
+	}
+	if err == nil {
+		sealed, sealErr := mm.encryptFor(peerID, msg, capabilities)
+		if sealErr != nil {
+			return nil, sealErr
+		}
+		msg = sealed
+	}
+	awaitReceipt := err != nil || capabilities.Legacy || capabilities.Supports(FeatureReceipts)
+	binaryFrames := err == nil && capabilities.Supports(FeatureBinaryFrames)
+	if err == nil && capabilities.Supports(FeatureMessageStream) {
+		return mm.sendOnMessageStream(peerID, msg, binaryFrames, span)
 	}
 
-	// Write message length first (4 bytes)
-	msgLen := uint32(len(msgData))
-	lenBytes := make([]byte, 4)
-	lenBytes[0] = byte(msgLen >> 24)
-	lenBytes[1] = byte(msgLen >> 16)
-	lenBytes[2] = byte(msgLen >> 8)
-	lenBytes[3] = byte(msgLen)
+	ctx, cancel := context.WithTimeout(mm.ctx, mm.TimeoutsFor(peerID).Message)
 
-	if _, err := stream.Write(lenBytes); err != nil {
-		return fmt.Errorf("failed to write message length: %w", err)
+	dial := span.Child(SpanDial)
+	stream, err := mm.host.NewStream(ctx, peerID, MessageProtocolID)
+	dial.SetError(err)
+	dial.Finish()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	closeStream := func() {
+		cancel()
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close stream")
+		}
 	}
 
-	// Write message data
-	if _, err := stream.Write(msgData); err != nil {
-		return fmt.Errorf("failed to write message data: %w", err)
+	// The whole exchange, receipt included, shares one deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
 	}
 
+	write := span.Child(SpanWrite)
+	if err := writeFrameAs(stream, msg, binaryFrames); err != nil {
+		write.SetError(err)
+		write.Finish()
+		_ = stream.Reset()
+		closeStream()
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	err = stream.CloseWrite()
+	write.SetError(err)
+	write.Finish()
+	if err != nil {
+		closeStream()
+		return nil, fmt.Errorf("failed to finish message: %w", err)
+	}
+	mm.messagesSent.Add(1)
+	if !awaitReceipt {
+		closeStream()
+		return func() error { return nil }, nil
+	}
+
+	return func() error {
+		defer closeStream()
+		ack := span.Child(SpanAck)
+		defer ack.Finish()
+		var receipt MessageReceipt
+		if err := readFrame(stream, MaxMessageSize, &receipt); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil // Peers that predate receipts close the stream without one
+			}
+			ack.SetError(err)
+			return fmt.Errorf("no receipt for message: %w", err)
+		}
+		err := receipt.err()
+		if pe, ok := AsProtocolError(err); ok {
+			ack.SetAttribute("error.code", pe.Code)
+		}
+		return err
+	}, nil
+}
+
+// TimeoutsFor returns the timeouts for operations with a peer: the
+// configured ones, adapted to the peer's measured round-trip time
+func (mm *MessageManager) TimeoutsFor(peerID peer.ID) Timeouts {
+	return mm.timeouts.Config().For(peerID.String(), mm.host.Peerstore().LatencyEWMA(peerID))
+}
+
+// deliveryFailed reports a message the receiver refused
+func (mm *MessageManager) deliveryFailed(msg *Message, pe *ProtocolError) {
 	mm.logger.WithFields(logrus.Fields{
 		"message_id": msg.ID,
 		"to":         msg.To,
-		"size":       len(msgData),
-	}).Info("Message sent successfully")
+		"code":       pe.Code,
+	}).Warn("Message refused by recipient")
 
-	return nil
+	if mm.OnDeliveryFailed != nil {
+		mm.OnDeliveryFailed(msg, pe)
+	}
 }
 
 // handleMessageStream handles incoming message streams
@@ -364,32 +1048,43 @@ func (mm *MessageManager) handleMessageStream(stream network.Stream) {
 
 	remotePeer := stream.Conn().RemotePeer()
 	mm.logger.WithField("peer", remotePeer.String()).Debug("Handling message stream")
+	_ = stream.SetReadDeadline(time.Now().Add(mm.TimeoutsFor(remotePeer).Message))
 
-	// Read message length (4 bytes)
-	lenBytes := make([]byte, 4)
-	if _, err := stream.Read(lenBytes); err != nil {
-		mm.logger.WithError(err).Error("Failed to read message length")
-		return
+	refusal, err := mm.receiveMessage(stream, remotePeer)
+	if err != nil {
+		mm.logger.WithError(err).Error("Failed to read message")
+	}
+	if err == nil || refusal != nil {
+		mm.replyReceipt(stream, refusal)
 	}
+}
 
-	msgLen := uint32(lenBytes[0])<<24 | uint32(lenBytes[1])<<16 | uint32(lenBytes[2])<<8 | uint32(lenBytes[3])
+// receiveMessage reads one message frame and accepts it. It returns the
+// refusal to answer with, if any, and an error if the stream cannot carry
+// another message.
+func (mm *MessageManager) receiveMessage(r io.Reader, remotePeer peer.ID) (*ProtocolError, error) {
+	// Read message length (4 bytes)
+	var msgLen uint32
+	if err := binary.Read(r, binary.BigEndian, &msgLen); err != nil {
+		return nil, fmt.Errorf("failed to read message length: %w", err)
+	}
+	started := time.Now()
 	if msgLen > MaxMessageSize {
-		mm.logger.WithField("size", msgLen).Error("Message too large")
-		return
+		pe := NewProtocolError(ErrCodeTooLarge, "message is %d bytes, limit is %d", msgLen, MaxMessageSize)
+		return pe, pe
 	}
 
 	// Read message data
 	msgData := make([]byte, msgLen)
-	if _, err := stream.Read(msgData); err != nil {
-		mm.logger.WithError(err).Error("Failed to read message data")
-		return
+	if _, err := io.ReadFull(r, msgData); err != nil {
+		return nil, fmt.Errorf("failed to read message data: %w", err)
 	}
 
 	// Parse message
 	var msg Message
-	if err := json.Unmarshal(msgData, &msg); err != nil {
+	if err := unmarshalFrame(msgData, &msg); err != nil {
 		mm.logger.WithError(err).Error("Failed to parse message")
-		return
+		return NewProtocolError(ErrCodeInvalid, "malformed message"), nil
 	}
 
 	mm.logger.WithFields(logrus.Fields{
@@ -399,14 +1094,108 @@ func (mm *MessageManager) handleMessageStream(stream network.Stream) {
 		"size":       len(msgData),
 	}).Info("Message received")
 
-	// Queue message for processing
-	select {
-	case mm.incomingMessages <- &msg:
-		// Message queued successfully
-	case <-mm.ctx.Done():
-		return
-	default:
-		mm.logger.Warn("Incoming message queue full, dropping message")
+	span := mm.startReceiveSpan(&msg)
+	if span != nil {
+		span.Start = started
+		span.SetAttribute("message.id", msg.ID)
+		span.SetAttribute("message.size", len(msgData))
+		span.SetAttribute("peer", remotePeer.String())
+		defer span.Finish()
+	}
+
+	refusal := mm.acceptMessage(&msg, remotePeer, 0)
+	if refusal != nil {
+		span.SetError(refusal)
+		span.SetAttribute("error.code", refusal.Code)
+	}
+	return refusal, nil
+}
+
+// acceptMessage records a received message and queues it for processing.
+// With a wait it waits that long for room in a full queue before refusing.
+func (mm *MessageManager) acceptMessage(msg *Message, remotePeer peer.ID, wait time.Duration) *ProtocolError {
+	if _, exists := mm.messageHandlers[msg.Type]; !exists {
+		mm.logger.WithField("type", msg.Type.String()).Warn("No handler registered for message type")
+		return NewProtocolError(ErrCodeUnsupported, "%s messages are not supported", msg.Type)
+	}
+
+	// Filters, history and handlers only ever see the plaintext, and only
+	// once its signature checked out
+	if msg.IsEncrypted {
+		if pe := mm.decryptMessage(msg, remotePeer); pe != nil {
+			return pe
+		}
+	}
+	if !mm.verifyMessage(msg, remotePeer) {
+		mm.logger.WithField("peer_id", remotePeer.String()).Warn("Message signature verification failed")
+		return NewProtocolError(ErrCodeInvalid, "invalid message signature")
+	}
+
+	// Record before queueing; handlers may annotate the message afterwards.
+	// A refused message that is sent again is recorded only once.
+	msg = mm.applyFilters(msg, remotePeer.String())
+	msg.fromPeer = remotePeer
+	mm.record(msg, remotePeer.String(), false)
+	if match := FilterMatchOf(msg.Metadata); !match.Muted {
+		mm.fireHooks(HookEvent{PeerID: remotePeer.String(), Class: msg.Type.String(), Tags: match.Tags})
+	}
+
+	if wait <= 0 {
+		select {
+		case mm.incomingMessages <- msg:
+			return nil
+		default:
+		}
+	} else {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case mm.incomingMessages <- msg:
+			return nil
+		case <-mm.ctx.Done():
+			return NewProtocolError(ErrCodeBusy, "shutting down")
+		case <-timer.C:
+		}
+	}
+
+	mm.logger.Warn("Incoming message queue full, dropping message")
+	return NewProtocolError(ErrCodeBusy, "too many pending messages")
+}
+
+// applyFilters attaches the outcome of the content filters to a received
+// text message. The message is copied rather than changed, and an outcome
+// set by the sender is dropped.
+func (mm *MessageManager) applyFilters(msg *Message, peerID string) *Message {
+	var match FilterMatch
+	if msg.Type == MessageTypeText {
+		match = MatchFilters(mm.filters.Filters(), peerID, msg.Content)
+		if mm.contactPolicy(peerID).NotifyLevel() == user.NotifyMuted {
+			match.Muted = true
+		}
+	}
+	if _, spoofed := msg.Metadata[MetadataFilter]; match.IsZero() && !spoofed {
+		return msg
+	}
+
+	filtered := *msg
+	filtered.Metadata = make(map[string]interface{}, len(msg.Metadata)+1)
+	for key, value := range msg.Metadata {
+		if key != MetadataFilter {
+			filtered.Metadata[key] = value
+		}
+	}
+	if !match.IsZero() {
+		filtered.Metadata[MetadataFilter] = match.metadata()
+	}
+	return &filtered
+}
+
+// replyReceipt tells the sender whether its message was accepted
+func (mm *MessageManager) replyReceipt(stream network.Stream, pe *ProtocolError) {
+	receipt := MessageReceipt{Accepted: pe == nil, Error: pe}
+	_ = stream.SetWriteDeadline(time.Now().Add(ReceiptTimeout))
+	if err := writeFrame(stream, receipt); err != nil {
+		mm.logger.WithError(err).Debug("Failed to send message receipt")
 	}
 }
 
@@ -443,7 +1232,30 @@ func (mm *MessageManager) handleGroupStream(stream network.Stream) {
 
 // signMessage signs a message with the identity key
 func (mm *MessageManager) signMessage(msg *Message) error {
-	// Serialize message for signing (excluding signature field)
+	return signMessageAs(mm.identity, msg)
+}
+
+// signMessageAs signs a message with identity
+func signMessageAs(identity *user.MessengerID, msg *Message) error {
+	msgData, err := SigningPayload(msg)
+	if err != nil {
+		return err
+	}
+
+	// Sign the message
+	signature, err := identity.Sign(msgData)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	msg.Signature = signature
+	return nil
+}
+
+// SigningPayload returns the bytes a message signature covers: the JSON
+// encoding of the message without its signature, encryption flag and trace
+// parent
+func SigningPayload(msg *Message) ([]byte, error) {
 	msgData, err := json.Marshal(struct {
 		ID        string                 `json:"id"`
 		Type      MessageType            `json:"type"`
@@ -464,359 +1276,311 @@ func (mm *MessageManager) signMessage(msg *Message) error {
 		Timestamp: msg.Timestamp,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to serialize message: %w", err)
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
 	}
+	return msgData, nil
+}
 
-	// Sign the message
-	signature, err := mm.identity.Sign(msgData)
+// verifyMessage verifies a message signature against the key of the peer
+// that sent it, which the connection has authenticated
+func (mm *MessageManager) verifyMessage(msg *Message, sender peer.ID) bool {
+	if len(msg.Signature) == 0 {
+		return false
+	}
+	pubKey, err := sender.ExtractPublicKey()
 	if err != nil {
-		return fmt.Errorf("failed to sign message: %w", err)
+		return false
 	}
-
-	msg.Signature = signature
-	return nil
-}
-
-// verifyMessage verifies a message signature
-func (mm *MessageManager) verifyMessage(msg *Message) bool {
-	// TODO: Get sender's public key from DID
-	// TODO: Verify signature
-	return true // Placeholder
+	payload, err := SigningPayload(msg)
+	if err != nil {
+		return false
+	}
+	ok, err := pubKey.Verify(payload, msg.Signature)
+	return err == nil && ok
 }
 
-// SendFile initiates a file transfer to a peer
+// SendFile initiates a file transfer to a peer. Stalled transfers are
+// resumed on a new stream from the last acknowledged offset.
 func (mm *MessageManager) SendFile(peerID peer.ID, filePath string) error {
 	mm.logger.WithFields(logrus.Fields{
 		"peer_id":   peerID.String(),
 		"file_path": filePath,
 	}).Info("Initiating file transfer")
 
-	// Open a stream to the peer for file transfer
-	stream, err := mm.host.NewStream(context.Background(), peerID, FileProtocolID)
-	if err != nil {
-		return fmt.Errorf("failed to open file stream to peer: %w", err)
+	ctx := WithTimeouts(mm.ctx, mm.TimeoutsFor(peerID))
+	return mm.fileTransferManager.SendFile(ctx, mm.streamOpener(peerID, FileProtocolID), filePath, peerID)
+}
+
+// SendDirectory transfers a directory to a peer, sending only the files the
+// peer does not already have
+func (mm *MessageManager) SendDirectory(peerID peer.ID, dir string) (*DirSyncResult, error) {
+	mm.logger.WithFields(logrus.Fields{
+		"peer_id": peerID.String(),
+		"dir":     dir,
+	}).Info("Initiating directory transfer")
+
+	ctx := WithTimeouts(mm.ctx, mm.TimeoutsFor(peerID))
+	return mm.fileTransferManager.SendDirectory(ctx, mm.streamOpener(peerID, DirProtocolID), mm.streamOpener(peerID, FileProtocolID), dir, peerID)
+}
+
+// ListTransfers returns the file transfers in either direction
+func (mm *MessageManager) ListTransfers() []*FileTransfer {
+	return mm.fileTransferManager.ListTransfers()
+}
+
+// ControlTransfer pauses, resumes or cancels a file transfer, telling the
+// peer
+func (mm *MessageManager) ControlTransfer(id, action string) (*FileTransfer, error) {
+	return mm.fileTransferManager.ControlTransfer(id, action)
+}
+
+// streamOpener opens streams to a peer within the file timeout carried by
+// the context, through a relay if the peer cannot be reached directly
+func (mm *MessageManager) streamOpener(peerID peer.ID, protocolID protocol.ID) StreamOpener {
+	return func(ctx context.Context) (network.Stream, error) {
+		openCtx, cancel := context.WithTimeout(ctx, TimeoutsFrom(ctx).File)
+		defer cancel()
+		return mm.openFileStream(openCtx, peerID, protocolID)
 	}
+}
+
+// handleDirStream handles incoming directory transfer streams
+func (mm *MessageManager) handleDirStream(stream network.Stream) {
 	defer func() {
 		if err := stream.Close(); err != nil {
-			mm.logger.WithError(err).Error("Failed to close file transfer stream")
+			mm.logger.WithError(err).Debug("Failed to close directory stream")
 		}
 	}()
 
-	// Start file transfer
-	return mm.fileTransferManager.StartFileTransfer(mm.ctx, stream, filePath, peerID)
+	remotePeer := stream.Conn().RemotePeer()
+	mm.logger.WithField("peer", remotePeer.String()).Debug("Handling directory stream")
+
+	dataDir, _ := user.DataDir()
+	downloadDir := filepath.Join(dataDir, "downloads")
+	if err := mm.fileTransferManager.ReceiveDirectory(mm.ctx, stream, remotePeer, downloadDir); err != nil {
+		mm.logger.WithError(err).Error("Failed to receive directory")
+	}
 }
 
 // processFileTransferStream processes incoming file transfer streams
 func (mm *MessageManager) processFileTransferStream(stream network.Stream, remotePeer peer.ID) error {
 	mm.logger.WithField("peer", remotePeer.String()).Debug("Processing file transfer stream")
 
-	// Read the initial request
-	request, err := mm.readFileTransferRequest(stream)
-	if err != nil {
-		return fmt.Errorf("failed to read file transfer request: %w", err)
-	}
-
-	switch request.Type {
-	case "request":
-		return mm.handleFileTransferRequest(stream, remotePeer, request)
-	case "chunk":
-		return mm.handleFileChunk(stream, remotePeer, request)
-	case "complete":
-		return mm.handleFileComplete(stream, remotePeer, request)
-	default:
-		return fmt.Errorf("unknown file transfer request type: %s", request.Type)
-	}
+	// Files are accepted from allowlisted peers or by the user
+	dataDir, _ := user.DataDir()
+	downloadDir := filepath.Join(dataDir, "downloads")
+	return mm.fileTransferManager.ReceiveFile(mm.ctx, stream, remotePeer, downloadDir)
 }
 
-// readFileTransferRequest reads a file transfer request from stream
-func (mm *MessageManager) readFileTransferRequest(stream network.Stream) (*FileTransferRequest, error) {
-	// Read length prefix
-	var length uint32
-	if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
-		return nil, fmt.Errorf("failed to read length: %w", err)
-	}
-
-	if length > FileHeaderSize {
-		return nil, fmt.Errorf("request too large: %d bytes", length)
-	}
-
-	// Read data
-	data := make([]byte, length)
-	if _, err := io.ReadFull(stream, data); err != nil {
-		return nil, fmt.Errorf("failed to read request data: %w", err)
-	}
+// processOfflineMessages periodically tries to deliver offline messages
+func (mm *MessageManager) processOfflineMessages() {
+	defer mm.wg.Done()
 
-	// Parse request
-	var request FileTransferRequest
-	if err := json.Unmarshal(data, &request); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal request: %w", err)
-	}
+	ticker := time.NewTicker(30 * time.Second) // Check every 30 seconds
+	defer ticker.Stop()
 
-	if request.Magic != FileTransferMagic {
-		return nil, fmt.Errorf("invalid magic number: %x", request.Magic)
+	for {
+		select {
+		case <-ticker.C:
+			mm.deliverOfflineMessages()
+		case <-mm.offlineWake:
+			mm.deliverOfflineMessages()
+		case <-mm.ctx.Done():
+			return
+		}
 	}
-
-	return &request, nil
 }
 
-// handleFileTransferRequest handles incoming file transfer requests
-func (mm *MessageManager) handleFileTransferRequest(stream network.Stream, remotePeer peer.ID, request *FileTransferRequest) error {
-	mm.logger.WithFields(logrus.Fields{
-		"peer":      remotePeer.String(),
-		"file_name": request.Metadata.Name,
-		"file_size": request.Metadata.Size,
-	}).Info("Received file transfer request")
+// OfflineMessageCount returns the number of messages waiting for delivery
+func (mm *MessageManager) OfflineMessageCount() int {
+	mm.offlineMutex.RLock()
+	defer mm.offlineMutex.RUnlock()
 
-	// For now, automatically accept all file transfers
-	// In production, this would prompt the user or check policies
-	response := FileTransferRequest{
-		Magic: FileTransferMagic,
-		Type:  "accept",
+	count := 0
+	for _, messages := range mm.offlineMessages {
+		count += len(messages)
 	}
+	return count
+}
 
-	// Send acceptance response
-	if err := mm.sendFileTransferResponse(stream, response); err != nil {
-		return fmt.Errorf("failed to send acceptance: %w", err)
+// StorageUsage returns the disk space used by received files and queued
+// messages, and their quotas
+func (mm *MessageManager) StorageUsage() StorageUsage {
+	quotas := mm.quotas.Quotas()
+	return StorageUsage{
+		DownloadsBytes: mm.downloadsUsage(),
+		DownloadsQuota: quotas.Downloads,
+		OfflineBytes:   mm.offlineUsage(),
+		OfflineQuota:   quotas.Offline,
 	}
+}
 
-	// Create download directory if it doesn't exist
-	downloadDir := filepath.Join(os.Getenv("HOME"), ".xelvra", "downloads")
-	if err := os.MkdirAll(downloadDir, 0755); err != nil {
-		return fmt.Errorf("failed to create download directory: %w", err)
+// checkDownloadSpace refuses files that would exceed the downloads quota
+func (mm *MessageManager) checkDownloadSpace(size int64) *ProtocolError {
+	quota := mm.quotas.Quotas().Downloads
+	if quota <= 0 {
+		return nil
 	}
-
-	// Create file transfer session for receiving
-	transfer := NewFileTransfer(request.Metadata.ID, remotePeer, request.Metadata, false, mm.logger)
-	mm.fileTransferManager.transfers[request.Metadata.ID] = transfer
-
-	// Create destination file
-	destPath := filepath.Join(downloadDir, request.Metadata.Name)
-	file, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
+	if used := mm.downloadsUsage(); used+size > quota {
+		return NewProtocolError(ErrCodeQuota, "downloads quota exceeded: %d MB of %d MB used", used>>20, quota>>20)
 	}
-
-	transfer.file = file
-	transfer.Status = FileTransferActive
-
-	mm.logger.WithFields(logrus.Fields{
-		"transfer_id": transfer.ID,
-		"dest_path":   destPath,
-	}).Info("File transfer accepted, ready to receive")
-
 	return nil
 }
 
-// handleFileChunk handles incoming file chunks
-func (mm *MessageManager) handleFileChunk(stream network.Stream, remotePeer peer.ID, request *FileTransferRequest) error {
-	// Find the active transfer (simplified - would need better lookup)
-	var transfer *FileTransfer
-	for _, t := range mm.fileTransferManager.transfers {
-		if t.PeerID == remotePeer && t.Status == FileTransferActive && !t.isOutgoing {
-			transfer = t
-			break
-		}
-	}
-
-	if transfer == nil {
-		return fmt.Errorf("no active file transfer found for peer %s", remotePeer.String())
+// checkOfflineSpace refuses messages that would exceed the offline queue quota
+func (mm *MessageManager) checkOfflineSpace(size int64) *ProtocolError {
+	quota := mm.quotas.Quotas().Offline
+	if quota <= 0 {
+		return nil
 	}
-
-	// Write chunk to file
-	if _, err := transfer.file.Write(request.Data); err != nil {
-		transfer.Status = FileTransferFailed
-		transfer.Error = err
-		return fmt.Errorf("failed to write chunk: %w", err)
+	if used := mm.offlineUsage(); used+size > quota {
+		return NewProtocolError(ErrCodeQuota, "offline queue on this device is full: %d MB of %d MB used",
+			used>>20, quota>>20)
 	}
-
-	transfer.BytesReceived += int64(len(request.Data))
-	transfer.UpdateProgress()
-	transfer.chunks[request.ChunkID] = true
-
-	mm.logger.WithFields(logrus.Fields{
-		"transfer_id": transfer.ID,
-		"chunk_id":    request.ChunkID,
-		"chunk_size":  len(request.Data),
-		"progress":    fmt.Sprintf("%.1f%%", transfer.Progress*100),
-	}).Debug("Received file chunk")
-
 	return nil
 }
 
-// handleFileComplete handles file transfer completion
-func (mm *MessageManager) handleFileComplete(stream network.Stream, remotePeer peer.ID, request *FileTransferRequest) error {
-	// Find the active transfer
-	var transfer *FileTransfer
-	for _, t := range mm.fileTransferManager.transfers {
-		if t.PeerID == remotePeer && t.Status == FileTransferActive && !t.isOutgoing {
-			transfer = t
-			break
-		}
-	}
-
-	if transfer == nil {
-		return fmt.Errorf("no active file transfer found for peer %s", remotePeer.String())
-	}
+// downloadsUsage returns the space used by received files
+func (mm *MessageManager) downloadsUsage() int64 {
+	dataDir, _ := user.DataDir()
+	return DirUsage(filepath.Join(dataDir, "downloads"), filepath.Join(dataDir, AttachmentsDirName))
+}
 
-	// Close the file
-	if err := transfer.file.Close(); err != nil {
-		mm.logger.WithError(err).Warn("Failed to close received file")
+// offlineUsage returns the space used by the offline message queue on disk
+func (mm *MessageManager) offlineUsage() int64 {
+	if mm.offlineStore == nil {
+		return 0
 	}
+	return mm.offlineStore.Size()
+}
 
-	transfer.Status = FileTransferCompleted
-	transfer.EndTime = time.Now()
-
-	mm.logger.WithFields(logrus.Fields{
-		"transfer_id":    transfer.ID,
-		"file_name":      transfer.Metadata.Name,
-		"bytes_received": transfer.BytesReceived,
-		"duration":       transfer.EndTime.Sub(transfer.StartTime),
-	}).Info("File transfer completed successfully")
-
-	// TODO: Verify file hash
-	// TODO: Send completion acknowledgment
+// OutboxCount returns the number of messages accepted for sending that were
+// not yet delivered or queued for offline delivery
+func (mm *MessageManager) OutboxCount() int {
+	mm.outboxMutex.Lock()
+	defer mm.outboxMutex.Unlock()
 
-	return nil
+	return len(mm.outbox)
 }
 
-// sendFileTransferResponse sends a file transfer response
-func (mm *MessageManager) sendFileTransferResponse(stream network.Stream, response FileTransferRequest) error {
-	data, err := json.Marshal(response)
-	if err != nil {
-		return fmt.Errorf("failed to marshal response: %w", err)
+// OutboxDrained returns a channel that is closed once every message accepted
+// for sending was delivered or queued for offline delivery. It is closed
+// already if the outbox is empty.
+func (mm *MessageManager) OutboxDrained() <-chan struct{} {
+	mm.outboxMutex.Lock()
+	defer mm.outboxMutex.Unlock()
+
+	if len(mm.outbox) == 0 {
+		drained := make(chan struct{})
+		close(drained)
+		return drained
 	}
-
-	// Write length prefix
-	length := uint32(len(data))
-	if err := binary.Write(stream, binary.BigEndian, length); err != nil {
-		return fmt.Errorf("failed to write length: %w", err)
+	if mm.outboxDrained == nil {
+		mm.outboxDrained = make(chan struct{})
 	}
+	return mm.outboxDrained
+}
 
-	// Write data
-	if _, err := stream.Write(data); err != nil {
-		return fmt.Errorf("failed to write response: %w", err)
+// notifyOutboxDrained wakes those waiting for the outbox to empty if it
+// did; the caller holds outboxMutex
+func (mm *MessageManager) notifyOutboxDrained() {
+	if len(mm.outbox) == 0 && mm.outboxDrained != nil {
+		close(mm.outboxDrained)
+		mm.outboxDrained = nil
 	}
-
-	return nil
 }
 
-// decryptMessage decrypts a message using Signal Protocol
-func (mm *MessageManager) decryptMessage(msg *Message) error {
-	// TODO: Implement Signal Protocol decryption
-	return nil
-}
+// addToOutbox persists a message that is about to be sent
+func (mm *MessageManager) addToOutbox(msg *Message) {
+	mm.outboxMutex.Lock()
+	defer mm.outboxMutex.Unlock()
 
-// processOfflineMessages periodically tries to deliver offline messages
-func (mm *MessageManager) processOfflineMessages() {
-	defer mm.wg.Done()
+	mm.outbox = append(mm.outbox, msg)
+	mm.saveOutbox()
+}
 
-	ticker := time.NewTicker(30 * time.Second) // Check every 30 seconds
-	defer ticker.Stop()
+// removeFromOutbox forgets a message that no longer needs sending
+func (mm *MessageManager) removeFromOutbox(id string) {
+	mm.outboxMutex.Lock()
+	defer mm.outboxMutex.Unlock()
 
-	for {
-		select {
-		case <-ticker.C:
-			mm.deliverOfflineMessages()
-		case <-mm.ctx.Done():
+	for i, msg := range mm.outbox {
+		if msg.ID == id {
+			mm.outbox = append(mm.outbox[:i], mm.outbox[i+1:]...)
+			mm.saveOutbox()
+			mm.notifyOutboxDrained()
 			return
 		}
 	}
 }
 
-// deliverOfflineMessages attempts to deliver stored offline messages
-func (mm *MessageManager) deliverOfflineMessages() {
-	mm.offlineMutex.Lock()
-	defer mm.offlineMutex.Unlock()
-
-	now := time.Now()
-
-	for peerIDStr, messages := range mm.offlineMessages {
-		peerID, err := peer.Decode(peerIDStr)
-		if err != nil {
-			mm.logger.WithError(err).Error("Invalid peer ID in offline messages")
-			continue
-		}
-
-		// Check if peer is connected
-		if mm.host.Network().Connectedness(peerID) != network.Connected {
-			continue // Peer not connected, skip for now
-		}
-
-		// Try to deliver messages
-		var remainingMessages []*OfflineMessage
-		for _, offlineMsg := range messages {
-			// Check if message has expired
-			if now.After(offlineMsg.ExpiresAt) {
-				mm.logger.WithField("message_id", offlineMsg.Message.ID).Info("Offline message expired")
-				continue
-			}
+// resumeOutbox queues the messages loaded from the outbox for sending
+func (mm *MessageManager) resumeOutbox() {
+	mm.outboxMutex.Lock()
+	pending := append([]*Message(nil), mm.outbox...)
+	mm.outboxMutex.Unlock()
+	if len(pending) == 0 {
+		return
+	}
 
-			// Try to deliver the message
-			if err := mm.deliverOfflineMessage(peerID, offlineMsg); err != nil {
-				offlineMsg.Attempts++
-				if offlineMsg.Attempts < 5 { // Max 5 attempts
-					remainingMessages = append(remainingMessages, offlineMsg)
-				} else {
-					mm.logger.WithField("message_id", offlineMsg.Message.ID).Warn("Offline message delivery failed after max attempts")
-				}
-			} else {
-				mm.logger.WithField("message_id", offlineMsg.Message.ID).Info("Offline message delivered successfully")
+	mm.logger.WithField("count", len(pending)).Info("Resuming delivery of unsent messages")
+
+	// The outbox may hold more messages than the queue; feed it in order
+	mm.wg.Add(1)
+	go func() {
+		defer mm.wg.Done()
+		for _, msg := range pending {
+			select {
+			case mm.outgoingMessages <- msg:
+			case <-mm.ctx.Done():
+				return
 			}
 		}
-
-		// Update the offline messages list
-		if len(remainingMessages) == 0 {
-			delete(mm.offlineMessages, peerIDStr)
-		} else {
-			mm.offlineMessages[peerIDStr] = remainingMessages
-		}
-	}
-
-	// Save updated offline messages to disk
-	mm.saveOfflineMessages()
+	}()
 }
 
-// deliverOfflineMessage delivers a single offline message
-func (mm *MessageManager) deliverOfflineMessage(peerID peer.ID, offlineMsg *OfflineMessage) error {
-	// Open a stream to the recipient
-	stream, err := mm.host.NewStream(context.Background(), peerID, MessageProtocolID)
-	if err != nil {
-		return fmt.Errorf("failed to open stream: %w", err)
+// loadOutbox loads the messages left unsent by the previous run
+func (mm *MessageManager) loadOutbox() {
+	mm.outboxMutex.Lock()
+	defer mm.outboxMutex.Unlock()
+
+	if mm.offlineStore == nil {
+		return
 	}
-	defer func() {
-		if err := stream.Close(); err != nil {
-			mm.logger.WithError(err).Error("Failed to close stream")
-		}
-	}()
 
-	// Serialize and send the message
-	msgData, err := json.Marshal(offlineMsg.Message)
+	messages, err := mm.offlineStore.LoadOutbox()
 	if err != nil {
-		return fmt.Errorf("failed to serialize message: %w", err)
+		mm.logger.WithError(err).Error("Failed to load unsent messages")
+		return
 	}
 
-	// Write message length first (4 bytes)
-	msgLen := uint32(len(msgData))
-	lenBytes := make([]byte, 4)
-	lenBytes[0] = byte(msgLen >> 24)
-	lenBytes[1] = byte(msgLen >> 16)
-	lenBytes[2] = byte(msgLen >> 8)
-	lenBytes[3] = byte(msgLen)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.Before(messages[j].Timestamp)
+	})
+	mm.outbox = messages
+}
 
-	if _, err := stream.Write(lenBytes); err != nil {
-		return fmt.Errorf("failed to write message length: %w", err)
+// saveOutbox writes the outbox to disk; the caller holds outboxMutex
+func (mm *MessageManager) saveOutbox() {
+	if mm.offlineStore == nil {
+		return
 	}
 
-	// Write message data
-	if _, err := stream.Write(msgData); err != nil {
-		return fmt.Errorf("failed to write message data: %w", err)
+	if err := mm.offlineStore.SaveOutbox(mm.outbox); err != nil {
+		mm.logger.WithError(err).Error("Failed to save unsent messages to disk")
 	}
-
-	return nil
 }
 
 // storeOfflineMessage stores a message for offline delivery
 func (mm *MessageManager) storeOfflineMessage(msg *Message) {
+	if pe := mm.checkOfflineSpace(int64(len(msg.Content))); pe != nil {
+		mm.logger.WithField("message_id", msg.ID).Warn("Offline queue quota exceeded, message not queued")
+		if mm.OnDeliveryFailed != nil {
+			mm.OnDeliveryFailed(msg, pe)
+		}
+		return
+	}
+
 	mm.offlineMutex.Lock()
 	defer mm.offlineMutex.Unlock()
 
@@ -836,26 +1600,27 @@ func (mm *MessageManager) storeOfflineMessage(msg *Message) {
 
 	// Save to disk
 	mm.saveOfflineMessages()
+
+	if mm.OnStoredOffline != nil {
+		go mm.OnStoredOffline(msg.To)
+	}
 }
 
-// loadOfflineMessages loads offline messages from disk
+// loadOfflineMessages loads offline messages from the offline store
 func (mm *MessageManager) loadOfflineMessages() {
 	mm.offlineMutex.Lock()
 	defer mm.offlineMutex.Unlock()
 
-	offlineFile := filepath.Join(mm.offlineDir, "messages.json")
-	data, err := os.ReadFile(offlineFile)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			mm.logger.WithError(err).Error("Failed to read offline messages file")
-		}
+	if mm.offlineStore == nil {
 		return
 	}
 
-	if err := json.Unmarshal(data, &mm.offlineMessages); err != nil {
-		mm.logger.WithError(err).Error("Failed to parse offline messages file")
+	messages, err := mm.offlineStore.LoadOffline()
+	if err != nil {
+		mm.logger.WithError(err).Error("Failed to load offline messages")
 		return
 	}
+	mm.offlineMessages = messages
 
 	// Count loaded messages
 	totalMessages := 0
@@ -863,20 +1628,16 @@ func (mm *MessageManager) loadOfflineMessages() {
 		totalMessages += len(messages)
 	}
 
-	mm.logger.WithField("count", totalMessages).Info("Loaded offline messages from disk")
+	mm.logger.WithField("count", totalMessages).Info("Loaded offline messages")
 }
 
-// saveOfflineMessages saves offline messages to disk
+// saveOfflineMessages saves offline messages to the offline store
 func (mm *MessageManager) saveOfflineMessages() {
-	offlineFile := filepath.Join(mm.offlineDir, "messages.json")
-	data, err := json.MarshalIndent(mm.offlineMessages, "", "  ")
-	if err != nil {
-		mm.logger.WithError(err).Error("Failed to serialize offline messages")
+	if mm.offlineStore == nil {
 		return
 	}
 
-	if err := os.WriteFile(offlineFile, data, 0600); err != nil {
+	if err := mm.offlineStore.SaveOffline(mm.offlineMessages); err != nil {
 		mm.logger.WithError(err).Error("Failed to save offline messages to disk")
-		return
 	}
 }
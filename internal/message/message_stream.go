@@ -0,0 +1,328 @@
+package message
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/tracing"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// MessageStreamProtocolID carries all messages to a peer over one
+// long-lived stream. Messages are the frames of MessageProtocolID; each is
+// answered with a MessageReceipt, in the order they were sent.
+const MessageStreamProtocolID = protocol.ID("/xelvra/message-stream/1.0.0")
+
+const (
+	// MessageBatchDelay is how long a message waits for others to share
+	// its write
+	MessageBatchDelay = 5 * time.Millisecond
+
+	// MessageBatchSize is the most that is buffered before a batch is
+	// written without waiting
+	MessageBatchSize = 64 * 1024
+
+	// MessageStreamIdleTimeout closes a message stream nothing was sent on
+	// for this long. The receiver waits twice as long before giving up.
+	MessageStreamIdleTimeout = 2 * time.Minute
+)
+
+// errMessageStreamClosed is returned for messages offered to a stream that
+// closed before they were written
+var errMessageStreamClosed = errors.New("message stream closed")
+
+// messageStream is the stream the messages to one peer share
+type messageStream struct {
+	stream  network.Stream
+	binary  bool          // Write protobuf frames
+	timeout time.Duration // For writing a batch
+	onClose func(*messageStream)
+
+	mu      sync.Mutex
+	w       *bufio.Writer
+	pending []chan error // Outcomes of the messages awaiting receipts, oldest first
+	flush   *time.Timer  // Writes the current batch; nil when nothing is buffered
+	idle    *time.Timer
+	err     error // Why the stream closed; nil while it is open
+}
+
+// newMessageStream starts reading receipts from stream. onClose is called
+// once the stream is closed.
+func newMessageStream(stream network.Stream, binaryFrames bool, timeout time.Duration, onClose func(*messageStream)) *messageStream {
+	ms := &messageStream{
+		stream:  stream,
+		binary:  binaryFrames,
+		timeout: timeout,
+		onClose: onClose,
+		w:       bufio.NewWriterSize(stream, MessageBatchSize),
+	}
+	ms.idle = time.AfterFunc(MessageStreamIdleTimeout, ms.closeIfIdle)
+	go ms.readReceipts()
+	return ms
+}
+
+// send adds a message to the current batch and returns the channel its
+// outcome arrives on. It fails with errMessageStreamClosed, having written
+// nothing, if the stream is closed.
+func (ms *messageStream) send(msg *Message) (<-chan error, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.err != nil {
+		return nil, errMessageStreamClosed
+	}
+
+	_ = ms.stream.SetWriteDeadline(time.Now().Add(ms.timeout))
+	if err := writeFrameAs(ms.w, msg, ms.binary); err != nil {
+		// Part of the frame may be out; the stream cannot be trusted
+		ms.closeLocked(err)
+		return nil, err
+	}
+	outcome := make(chan error, 1)
+	ms.pending = append(ms.pending, outcome)
+	ms.idle.Reset(MessageStreamIdleTimeout)
+	if ms.flush == nil {
+		ms.flush = time.AfterFunc(MessageBatchDelay, ms.writeBatch)
+	}
+	return outcome, nil
+}
+
+// writeBatch writes the buffered messages
+func (ms *messageStream) writeBatch() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.flush = nil
+	if ms.err != nil {
+		return
+	}
+	_ = ms.stream.SetWriteDeadline(time.Now().Add(ms.timeout))
+	if err := ms.w.Flush(); err != nil {
+		ms.closeLocked(fmt.Errorf("failed to send messages: %w", err))
+	}
+}
+
+// readReceipts hands each receipt to the oldest message awaiting one
+func (ms *messageStream) readReceipts() {
+	for {
+		var receipt MessageReceipt
+		if err := readFrame(ms.stream, MaxMessageSize, &receipt); err != nil {
+			ms.close(fmt.Errorf("no receipt for message: %w", err))
+			return
+		}
+
+		ms.mu.Lock()
+		if len(ms.pending) == 0 {
+			ms.closeLocked(fmt.Errorf("receipt for no message"))
+			ms.mu.Unlock()
+			return
+		}
+		outcome := ms.pending[0]
+		ms.pending = ms.pending[1:]
+		ms.mu.Unlock()
+		outcome <- receipt.err()
+	}
+}
+
+// closeIfIdle closes the stream unless messages still await receipts
+func (ms *messageStream) closeIfIdle() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if len(ms.pending) > 0 {
+		ms.idle.Reset(MessageStreamIdleTimeout)
+		return
+	}
+	ms.closeLocked(nil)
+}
+
+// close closes the stream; messages awaiting receipts fail with err
+func (ms *messageStream) close(err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.closeLocked(err)
+}
+
+// closeLocked closes the stream with ms.mu held. A nil err closes it
+// cleanly, anything else resets it.
+func (ms *messageStream) closeLocked(err error) {
+	if ms.err != nil {
+		return
+	}
+	ms.err = errMessageStreamClosed
+	if err != nil {
+		ms.err = err
+	}
+	ms.idle.Stop()
+	if ms.flush != nil {
+		ms.flush.Stop()
+		ms.flush = nil
+	}
+	if err == nil {
+		_ = ms.stream.Close()
+	} else {
+		_ = ms.stream.Reset()
+	}
+	for _, outcome := range ms.pending {
+		outcome <- ms.err
+	}
+	ms.pending = nil
+	go ms.onClose(ms)
+}
+
+// sendOnMessageStream writes a message to the peer's message stream,
+// opening it first if needed, and returns a function that waits for the
+// receipt
+func (mm *MessageManager) sendOnMessageStream(peerID peer.ID, msg *Message, binaryFrames bool, span *tracing.Span) (func() error, error) {
+	timeout := mm.TimeoutsFor(peerID).Message
+	ctx, cancel := context.WithTimeout(mm.ctx, timeout)
+
+	// A stream that closed as the message was offered is replaced once
+	var outcome <-chan error
+	var ms *messageStream
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		dial := span.Child(SpanDial)
+		ms, err = mm.messageStreamTo(ctx, peerID, binaryFrames, timeout)
+		dial.SetError(err)
+		dial.Finish()
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to open stream: %w", err)
+		}
+
+		write := span.Child(SpanWrite)
+		outcome, err = ms.send(msg)
+		write.SetError(err)
+		write.Finish()
+		if !errors.Is(err, errMessageStreamClosed) {
+			break
+		}
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	mm.messagesSent.Add(1)
+
+	return func() error {
+		defer cancel()
+		ack := span.Child(SpanAck)
+		defer ack.Finish()
+		select {
+		case err := <-outcome:
+			if pe, ok := AsProtocolError(err); ok {
+				ack.SetAttribute("error.code", pe.Code)
+			} else {
+				ack.SetError(err)
+			}
+			return err
+		case <-ctx.Done():
+			// A peer that stops answering gets a new stream next time
+			err := fmt.Errorf("no receipt for message: %w", ctx.Err())
+			ms.close(err)
+			ack.SetError(err)
+			return err
+		}
+	}, nil
+}
+
+// messageStreamTo returns the open message stream to a peer, opening one
+// if there is none
+func (mm *MessageManager) messageStreamTo(ctx context.Context, peerID peer.ID, binaryFrames bool, timeout time.Duration) (*messageStream, error) {
+	mm.messageStreamsMu.Lock()
+	ms := mm.messageStreams[peerID]
+	mm.messageStreamsMu.Unlock()
+	if ms != nil {
+		return ms, nil
+	}
+
+	stream, err := mm.host.NewStream(ctx, peerID, MessageStreamProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	ms = newMessageStream(stream, binaryFrames, timeout, mm.forgetMessageStream)
+
+	// Another sender may have opened one meanwhile
+	mm.messageStreamsMu.Lock()
+	defer mm.messageStreamsMu.Unlock()
+	if current := mm.messageStreams[peerID]; current != nil {
+		ms.close(nil)
+		return current, nil
+	}
+	mm.messageStreams[peerID] = ms
+	return ms, nil
+}
+
+// forgetMessageStream drops a closed stream so the next message opens a
+// new one
+func (mm *MessageManager) forgetMessageStream(ms *messageStream) {
+	peerID := ms.stream.Conn().RemotePeer()
+	mm.messageStreamsMu.Lock()
+	defer mm.messageStreamsMu.Unlock()
+	if mm.messageStreams[peerID] == ms {
+		delete(mm.messageStreams, peerID)
+	}
+}
+
+// closeMessageStreams closes the message streams to peerID, or to every
+// peer if it is empty
+func (mm *MessageManager) closeMessageStreams(peerID peer.ID) {
+	mm.messageStreamsMu.Lock()
+	var closing []*messageStream
+	for id, ms := range mm.messageStreams {
+		if peerID == "" || id == peerID {
+			closing = append(closing, ms)
+			delete(mm.messageStreams, id)
+		}
+	}
+	mm.messageStreamsMu.Unlock()
+
+	for _, ms := range closing {
+		ms.close(errMessageStreamClosed)
+	}
+}
+
+// handleMessageStreamBatch serves a peer's long-lived message stream,
+// answering each message with a receipt. Receipts are written together
+// when no more messages are waiting to be read.
+func (mm *MessageManager) handleMessageStreamBatch(stream network.Stream) {
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close message stream")
+		}
+	}()
+
+	remotePeer := stream.Conn().RemotePeer()
+	mm.logger.WithField("peer", remotePeer.String()).Debug("Handling long-lived message stream")
+	r := bufio.NewReader(stream)
+	w := bufio.NewWriter(stream)
+
+	for {
+		_ = stream.SetReadDeadline(time.Now().Add(2 * MessageStreamIdleTimeout))
+		refusal, err := mm.receiveMessage(r, remotePeer)
+		if err != nil && refusal == nil {
+			if !errors.Is(err, io.EOF) {
+				mm.logger.WithError(err).Debug("Message stream ended")
+			}
+			return
+		}
+
+		_ = stream.SetWriteDeadline(time.Now().Add(ReceiptTimeout))
+		writeErr := writeFrame(w, MessageReceipt{Accepted: refusal == nil, Error: refusal})
+		if writeErr == nil && (err != nil || r.Buffered() == 0) {
+			writeErr = w.Flush()
+		}
+		if writeErr != nil {
+			mm.logger.WithError(writeErr).Debug("Failed to send message receipts")
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// KindNotice marks a system message whose content is a Notice
+const KindNotice = "notice"
+
+// Notice codes. Codes are stable: clients translate them and bots match on
+// them, so they are never renamed.
+const (
+	NoticeText         = "text"                // Free text from a peer that does not send notices; param text
+	NoticeKeyRotated   = "peer.key_rotated"    // Params contact, old_peer_id, new_peer_id
+	NoticeMemberJoined = "group.member_joined" // Params group, member
+	NoticeMemberLeft   = "group.member_left"   // Params group, member
+	NoticeGroupRenamed = "group.renamed"       // Params group, name
+	NoticeMessageRead  = "message.read"        // Params message_id
+	NoticeSessionReset = "peer.session_reset"  // Params session, the ID of the sender's new session
+)
+
+// maxNoticeParams bounds the parameters of a received notice
+const maxNoticeParams = 16
+
+// NoticeTemplates are the English texts of known notices, with {param}
+// placeholders. Clients may replace them with translations keyed by
+// Notice.Key.
+var NoticeTemplates = map[string]string{
+	NoticeText:         "{text}",
+	NoticeKeyRotated:   "{contact} rotated their keys: {old_peer_id} is now {new_peer_id}",
+	NoticeMemberJoined: "{member} joined {group}",
+	NoticeMemberLeft:   "{member} left {group}",
+	NoticeGroupRenamed: "{group} was renamed to {name}",
+	NoticeMessageRead:  "read your message {message_id}",
+	NoticeSessionReset: "reset the encrypted session",
+}
+
+// Notice is a structured system notice: a code clients localize and render
+// as they see fit, with the parameters it refers to
+type Notice struct {
+	Code   string            `json:"code"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// NewNotice creates a notice from a code and alternating parameter names and
+// values
+func NewNotice(code string, params ...string) *Notice {
+	notice := &Notice{Code: code}
+	if len(params) > 0 {
+		notice.Params = make(map[string]string, len(params)/2)
+		for i := 0; i+1 < len(params); i += 2 {
+			notice.Params[params[i]] = params[i+1]
+		}
+	}
+	return notice
+}
+
+// Key returns the localization key of the notice
+func (n *Notice) Key() string {
+	return "notice." + n.Code
+}
+
+// Validate rejects a notice without a code or with too many parameters
+func (n *Notice) Validate() error {
+	if n.Code == "" {
+		return fmt.Errorf("notice has no code")
+	}
+	if len(n.Params) > maxNoticeParams {
+		return fmt.Errorf("notice has %d parameters, more than %d", len(n.Params), maxNoticeParams)
+	}
+	return nil
+}
+
+// Text renders the notice with templates, falling back to NoticeTemplates
+// when templates is nil or lacks the code. A notice no template knows is
+// shown as its code and parameters.
+func (n *Notice) Text(templates map[string]string) string {
+	template, ok := templates[n.Code]
+	if !ok {
+		template, ok = NoticeTemplates[n.Code]
+	}
+	if !ok {
+		names := make([]string, 0, len(n.Params))
+		for name := range n.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parts := []string{n.Code}
+		for _, name := range names {
+			parts = append(parts, fmt.Sprintf("%s=%s", name, n.Params[name]))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	replacements := make([]string, 0, 2*len(n.Params))
+	for name, value := range n.Params {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
+
+// NoticeOf returns the notice a system message carries. Key-change
+// announcements and free text from older peers are turned into notices too.
+func NoticeOf(content []byte, metadata map[string]interface{}) (*Notice, error) {
+	switch metadata[MetadataKind] {
+	case KindNotice:
+		var notice Notice
+		if err := json.Unmarshal(content, &notice); err != nil {
+			return nil, fmt.Errorf("invalid notice: %w", err)
+		}
+		if err := notice.Validate(); err != nil {
+			return nil, err
+		}
+		return &notice, nil
+	case KindKeyChange:
+		return NewNotice(NoticeKeyRotated,
+			"contact", fmt.Sprint(metadata["contact"]),
+			"old_peer_id", fmt.Sprint(metadata["old_peer_id"]),
+			"new_peer_id", fmt.Sprint(metadata["new_peer_id"])), nil
+	default:
+		return NewNotice(NoticeText, "text", string(content)), nil
+	}
+}
+
+// SendNotice sends a structured system notice to a peer
+func (mm *MessageManager) SendNotice(to string, notice *Notice) error {
+	if err := notice.Validate(); err != nil {
+		return err
+	}
+	// Peers without notices show the content of system messages as text
+	if peerID, err := peer.Decode(to); err == nil && !mm.peerSupports(peerID, FeatureNotices) {
+		return mm.sendMessage(to, []byte(notice.Text(nil)), MessageTypeSystem, nil, nil)
+	}
+	content, err := json.Marshal(notice)
+	if err != nil {
+		return fmt.Errorf("failed to serialize notice: %w", err)
+	}
+	return mm.sendMessage(to, content, MessageTypeSystem, map[string]interface{}{MetadataKind: KindNotice}, nil)
+}
@@ -0,0 +1,490 @@
+package message
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OfflineDirName is the offline messages directory in the data directory
+	OfflineDirName = "offline_messages"
+
+	// OfflineStoreFileName holds the encrypted offline message queue in the
+	// offline messages directory
+	OfflineStoreFileName = "messages.enc"
+
+	// OutboxFileName holds the encrypted messages accepted for sending but not
+	// yet delivered or queued for offline delivery
+	OutboxFileName = "outbox.enc"
+
+	// legacyOfflineFileName is the plaintext queue written by older versions
+	legacyOfflineFileName = "messages.json"
+
+	// offlineKeyPurpose selects the storage key derived from the identity
+	offlineKeyPurpose = "offline-messages"
+)
+
+// offlineStoreAD binds the ciphertext to its use
+var offlineStoreAD = []byte("xelvra-offline-messages-v1")
+
+// offlineStoreKey derives the key that encrypts the offline message queue
+func offlineStoreKey(identity *user.MessengerID) ([]byte, error) {
+	if identity == nil {
+		return nil, fmt.Errorf("no identity")
+	}
+	return identity.DeriveStorageKey(offlineKeyPurpose)
+}
+
+// OfflineStore keeps the offline message queue, keyed by recipient, and the
+// outbox of messages accepted for sending but not yet delivered or queued.
+// The message manager holds both in memory and hands the whole of either to
+// the store whenever it changes.
+type OfflineStore interface {
+	LoadOffline() (map[string][]*OfflineMessage, error)
+	SaveOffline(messages map[string][]*OfflineMessage) error
+	LoadOutbox() ([]*Message, error)
+	SaveOutbox(messages []*Message) error
+
+	// Size returns the bytes the offline queue takes up, checked against the
+	// offline queue quota
+	Size() int64
+}
+
+// FileOfflineStore keeps the offline queue and the outbox in files in a
+// directory, encrypted with a key derived from the identity
+type FileOfflineStore struct {
+	dir    string
+	key    []byte
+	logger *logrus.Logger
+}
+
+// NewFileOfflineStore creates the store in dir, creating the directory
+func NewFileOfflineStore(dir string, identity *user.MessengerID, logger *logrus.Logger) (*FileOfflineStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create offline messages directory: %w", err)
+	}
+	key, err := offlineStoreKey(identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive offline message key: %w", err)
+	}
+	return &FileOfflineStore{dir: dir, key: key, logger: logger}, nil
+}
+
+// LoadOffline implements OfflineStore. A plaintext queue written by an older
+// version is encrypted on first load; an unreadable queue is moved aside
+// instead of being overwritten on the next save.
+func (s *FileOfflineStore) LoadOffline() (map[string][]*OfflineMessage, error) {
+	messages, migrated, err := readOfflineStore(s.dir, s.key)
+	if err != nil {
+		s.moveAside(OfflineStoreFileName)
+		return nil, err
+	}
+
+	if migrated {
+		if err := writeOfflineStore(s.dir, s.key, messages); err != nil {
+			s.logger.WithError(err).Error("Failed to encrypt offline messages")
+		} else {
+			s.logger.Info("Offline messages migrated to encrypted storage")
+		}
+	}
+	return messages, nil
+}
+
+// SaveOffline implements OfflineStore
+func (s *FileOfflineStore) SaveOffline(messages map[string][]*OfflineMessage) error {
+	return writeOfflineStore(s.dir, s.key, messages)
+}
+
+// LoadOutbox implements OfflineStore. An unreadable outbox is moved aside.
+func (s *FileOfflineStore) LoadOutbox() ([]*Message, error) {
+	messages, err := readOutbox(s.dir, s.key)
+	if err != nil {
+		s.moveAside(OutboxFileName)
+		return nil, err
+	}
+	return messages, nil
+}
+
+// SaveOutbox implements OfflineStore
+func (s *FileOfflineStore) SaveOutbox(messages []*Message) error {
+	return writeOutbox(s.dir, s.key, messages)
+}
+
+// Size implements OfflineStore
+func (s *FileOfflineStore) Size() int64 {
+	info, err := os.Stat(filepath.Join(s.dir, OfflineStoreFileName))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// moveAside renames an unreadable file so it is kept for recovery
+func (s *FileOfflineStore) moveAside(name string) {
+	path := filepath.Join(s.dir, name)
+	if err := os.Rename(path, path+".unreadable"); err != nil && !os.IsNotExist(err) {
+		s.logger.WithError(err).WithField("file", name).Error("Failed to move unreadable file aside")
+	}
+}
+
+// MemoryOfflineStore keeps the offline queue and the outbox in memory only,
+// for tests and ephemeral nodes. Saved messages are serialized so later
+// changes by the caller do not reach the store.
+type MemoryOfflineStore struct {
+	mu      sync.Mutex
+	offline []byte
+	outbox  []byte
+}
+
+// NewMemoryOfflineStore creates an empty in-memory store
+func NewMemoryOfflineStore() *MemoryOfflineStore {
+	return &MemoryOfflineStore{}
+}
+
+// LoadOffline implements OfflineStore
+func (s *MemoryOfflineStore) LoadOffline() (map[string][]*OfflineMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make(map[string][]*OfflineMessage)
+	if s.offline == nil {
+		return messages, nil
+	}
+	if err := json.Unmarshal(s.offline, &messages); err != nil {
+		return nil, fmt.Errorf("failed to parse offline messages: %w", err)
+	}
+	return messages, nil
+}
+
+// SaveOffline implements OfflineStore
+func (s *MemoryOfflineStore) SaveOffline(messages map[string][]*OfflineMessage) error {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("failed to serialize offline messages: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline = data
+	return nil
+}
+
+// LoadOutbox implements OfflineStore
+func (s *MemoryOfflineStore) LoadOutbox() ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []*Message
+	if s.outbox == nil {
+		return messages, nil
+	}
+	if err := json.Unmarshal(s.outbox, &messages); err != nil {
+		return nil, fmt.Errorf("failed to parse outbox: %w", err)
+	}
+	return messages, nil
+}
+
+// SaveOutbox implements OfflineStore
+func (s *MemoryOfflineStore) SaveOutbox(messages []*Message) error {
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("failed to serialize outbox: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outbox = data
+	return nil
+}
+
+// Size implements OfflineStore
+func (s *MemoryOfflineStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.offline))
+}
+
+// readOfflineStore loads the offline message queue from dir. A plaintext
+// queue left by an older version is read instead if no encrypted one exists;
+// migrated reports that it should be re-saved encrypted.
+func readOfflineStore(dir string, key []byte) (messages map[string][]*OfflineMessage, migrated bool, err error) {
+	messages = make(map[string][]*OfflineMessage)
+
+	found, err := readSealedJSON(filepath.Join(dir, OfflineStoreFileName), key, &messages)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		return messages, false, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, legacyOfflineFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return messages, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read offline messages: %w", err)
+	}
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, false, fmt.Errorf("failed to parse offline messages: %w", err)
+	}
+	return messages, true, nil
+}
+
+// writeOfflineStore saves the offline message queue to dir encrypted with key
+// and removes a plaintext queue left by an older version
+func writeOfflineStore(dir string, key []byte, messages map[string][]*OfflineMessage) error {
+	if err := writeSealedJSON(filepath.Join(dir, OfflineStoreFileName), key, messages); err != nil {
+		return err
+	}
+	return wipeFile(filepath.Join(dir, legacyOfflineFileName))
+}
+
+// readOutbox loads the messages that were waiting to be sent from dir
+func readOutbox(dir string, key []byte) ([]*Message, error) {
+	var messages []*Message
+	if _, err := readSealedJSON(filepath.Join(dir, OutboxFileName), key, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// writeOutbox saves the messages waiting to be sent to dir
+func writeOutbox(dir string, key []byte, messages []*Message) error {
+	return writeSealedJSON(filepath.Join(dir, OutboxFileName), key, messages)
+}
+
+// readSealedJSON decrypts the file at path into v. The boolean reports
+// whether the file exists.
+func readSealedJSON(path string, key []byte, v interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+
+	plaintext, err := openOfflineData(key, data)
+	if err != nil {
+		return true, err
+	}
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return true, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+	return true, nil
+}
+
+// writeSealedJSON encrypts v with key and saves it at path
+func writeSealedJSON(path string, key []byte, v interface{}) error {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", filepath.Base(path), err)
+	}
+
+	data, err := sealOfflineData(key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to save %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// RekeyOfflineMessages re-encrypts the offline message queue and the outbox
+// in dir after the identity was rotated, and signs their messages again
+// with the new key, so messages queued before the rotation are still sent
+// and accepted
+func RekeyOfflineMessages(dir string, oldID, newID *user.MessengerID) error {
+	oldKey, err := offlineStoreKey(oldID)
+	if err != nil {
+		return err
+	}
+	newKey, err := offlineStoreKey(newID)
+	if err != nil {
+		return err
+	}
+
+	messages, _, err := readOfflineStore(dir, oldKey)
+	if err != nil {
+		return err
+	}
+	for _, queued := range messages {
+		for _, offlineMsg := range queued {
+			if err := resign(offlineMsg.Message, newID); err != nil {
+				return err
+			}
+		}
+	}
+	if len(messages) > 0 {
+		if err := writeOfflineStore(dir, newKey, messages); err != nil {
+			return err
+		}
+	}
+
+	outbox, err := readOutbox(dir, oldKey)
+	if err != nil {
+		return err
+	}
+	for _, msg := range outbox {
+		if err := resign(msg, newID); err != nil {
+			return err
+		}
+	}
+	if len(outbox) > 0 {
+		return writeOutbox(dir, newKey, outbox)
+	}
+	return nil
+}
+
+// resign makes identity the sender of a queued message
+func resign(msg *Message, identity *user.MessengerID) error {
+	msg.From = identity.GetDID()
+	return signMessageAs(identity, msg)
+}
+
+// ReadOfflineMessages decrypts the offline message queue, keyed by
+// recipient, and the outbox in dir
+func ReadOfflineMessages(dir string, identity *user.MessengerID) (map[string][]*OfflineMessage, []*Message, error) {
+	key, err := offlineStoreKey(identity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	queued, _, err := readOfflineStore(dir, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	outbox, err := readOutbox(dir, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return queued, outbox, nil
+}
+
+// EraseOfflineMessages removes the messages waiting for peerID from the
+// offline message queue and the outbox in dir and returns how many were
+// removed
+func EraseOfflineMessages(dir string, identity *user.MessengerID, peerID string) (int, error) {
+	key, err := offlineStoreKey(identity)
+	if err != nil {
+		return 0, err
+	}
+
+	queued, migrated, err := readOfflineStore(dir, key)
+	if err != nil {
+		return 0, err
+	}
+	removed := len(queued[peerID])
+	if removed > 0 || migrated {
+		delete(queued, peerID)
+		if err := writeOfflineStore(dir, key, queued); err != nil {
+			return 0, err
+		}
+	}
+
+	outbox, err := readOutbox(dir, key)
+	if err != nil {
+		return removed, err
+	}
+	kept := outbox[:0]
+	for _, msg := range outbox {
+		if msg.To != peerID {
+			kept = append(kept, msg)
+		}
+	}
+	if len(kept) == len(outbox) {
+		return removed, nil
+	}
+	removed += len(outbox) - len(kept)
+	return removed, writeOutbox(dir, key, kept)
+}
+
+// sealOfflineData encrypts data with AES-GCM, prefixing the nonce
+func sealOfflineData(key, data []byte) ([]byte, error) {
+	gcm, err := newOfflineGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, data, offlineStoreAD), nil
+}
+
+// openOfflineData decrypts data sealed by sealOfflineData
+func openOfflineData(key, data []byte) ([]byte, error) {
+	gcm, err := newOfflineGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("offline messages file is truncated")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, offlineStoreAD)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt offline messages (was the identity replaced?): %w", err)
+	}
+	return plaintext, nil
+}
+
+// newOfflineGCM creates the AES-GCM cipher for key
+func newOfflineGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// wipeFile overwrites a file with zeros before removing it, so plaintext
+// does not linger in its old blocks. Missing files are ignored.
+func wipeFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, writeErr := file.Write(make([]byte, info.Size()))
+	syncErr := file.Sync()
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if syncErr != nil {
+		return syncErr
+	}
+	return os.Remove(path)
+}
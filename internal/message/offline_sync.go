@@ -0,0 +1,394 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// OfflineProtocolID delivers queued messages in batches the receiver
+	// pulls at its own pace
+	OfflineProtocolID = protocol.ID("/xelvra/offline/1.0.0")
+
+	// Offline delivery limits
+	OfflineBatchSize    = 50                     // Messages the receiver pulls at once
+	OfflineBatchBytes   = 512 * 1024             // Messages stop being added to a batch past this size
+	OfflinePullInterval = 250 * time.Millisecond // Receiver pause between batches
+	OfflinePushBatch    = 20                     // Messages per round for peers without OfflineProtocolID
+	OfflineMaxAttempts  = 5
+	offlineFrameLimit   = OfflineBatchBytes + 4*MaxMessageSize
+)
+
+// errOfflineSyncUnavailable means the peer cannot pull queued messages and
+// they are pushed to it one by one instead
+var errOfflineSyncUnavailable = errors.New("offline sync unavailable")
+
+// OfflineSyncFrame is exchanged on OfflineProtocolID. The sender announces
+// its backlog with a "summary"; the receiver answers each "batch" with a
+// "pull" carrying the receipts of the previous batch, and ends the session
+// with a pull of zero messages.
+type OfflineSyncFrame struct {
+	Type      string                    `json:"type"`            // "summary", "pull" or "batch"
+	Count     int                       `json:"count,omitempty"` // Queued messages (summary) or messages wanted (pull)
+	Bytes     int64                     `json:"bytes,omitempty"` // Size of the queued messages (summary)
+	Messages  []*Message                `json:"messages,omitempty"`
+	Remaining int                       `json:"remaining,omitempty"` // Messages left after this batch
+	Accepted  []string                  `json:"accepted,omitempty"`
+	Refused   map[string]*ProtocolError `json:"refused,omitempty"`
+}
+
+// wakeOfflineDelivery makes the offline delivery loop run now, e.g. when a
+// peer connects, instead of at its next tick
+func (mm *MessageManager) wakeOfflineDelivery() {
+	select {
+	case mm.offlineWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverOfflineMessages attempts to deliver stored offline messages to the
+// connected peers. Peers that support it pull their backlog in batches; older
+// peers get a limited number of messages per round.
+func (mm *MessageManager) deliverOfflineMessages() {
+	for _, peerID := range mm.offlinePeers() {
+		err := mm.pushOfflineBacklog(peerID)
+		if errors.Is(err, errOfflineSyncUnavailable) {
+			mm.pushOfflineMessages(peerID)
+			continue
+		}
+		if err != nil {
+			mm.logger.WithError(err).WithField("peer_id", peerID.String()).Warn("Offline delivery interrupted")
+		}
+	}
+}
+
+// offlinePeers returns the connected peers with queued messages
+func (mm *MessageManager) offlinePeers() []peer.ID {
+	mm.offlineMutex.RLock()
+	defer mm.offlineMutex.RUnlock()
+
+	var peers []peer.ID
+	for peerIDStr, messages := range mm.offlineMessages {
+		if len(messages) == 0 {
+			continue
+		}
+		peerID, err := peer.Decode(peerIDStr)
+		if err != nil {
+			mm.logger.WithError(err).Error("Invalid peer ID in offline messages")
+			continue
+		}
+		if mm.host.Network().Connectedness(peerID) == network.Connected {
+			peers = append(peers, peerID)
+		}
+	}
+	return peers
+}
+
+// pushOfflineBacklog offers a peer its queued messages and sends the batches
+// it pulls. Messages leave the queue once the peer acknowledges them.
+func (mm *MessageManager) pushOfflineBacklog(peerID peer.ID) error {
+	timeout := mm.TimeoutsFor(peerID).Message
+
+	ctx, cancel := context.WithTimeout(mm.ctx, timeout)
+	stream, err := mm.host.NewStream(ctx, peerID, OfflineProtocolID)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errOfflineSyncUnavailable, err)
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close offline stream")
+		}
+	}()
+
+	count, size := mm.offlineBacklog(peerID.String())
+	_ = stream.SetWriteDeadline(time.Now().Add(timeout))
+	if err := writeFrame(stream, OfflineSyncFrame{Type: "summary", Count: count, Bytes: size}); err != nil {
+		_ = stream.Reset()
+		return fmt.Errorf("failed to send backlog summary: %w", err)
+	}
+
+	mm.logger.WithFields(logrus.Fields{
+		"peer_id": peerID.String(),
+		"count":   count,
+	}).Info("Offering queued messages to peer")
+
+	// Text messages in the batches are sealed like those sent directly
+	capabilities, capErr := mm.PeerCapabilities(peerID)
+
+	tried := make(map[string]bool)
+	var sent []string
+	for {
+		// The receiver handles a whole batch before pulling the next
+		_ = stream.SetDeadline(time.Now().Add(2*timeout + OfflinePullInterval))
+
+		var pull OfflineSyncFrame
+		if err := readFrame(stream, offlineFrameLimit, &pull); err != nil {
+			mm.settleOfflineBatch(peerID.String(), sent, nil, nil)
+			return fmt.Errorf("no pull from peer: %w", err)
+		}
+		if pull.Type != "pull" {
+			mm.settleOfflineBatch(peerID.String(), sent, nil, nil)
+			_ = stream.Reset()
+			return fmt.Errorf("unexpected offline frame: %s", pull.Type)
+		}
+		mm.settleOfflineBatch(peerID.String(), sent, pull.Accepted, pull.Refused)
+		if pull.Count <= 0 {
+			return nil
+		}
+
+		batch, remaining := mm.nextOfflineBatch(peerID.String(), min(pull.Count, OfflineBatchSize), tried)
+		sent = sent[:0]
+		for i, msg := range batch {
+			tried[msg.ID] = true
+			sent = append(sent, msg.ID)
+			if capErr != nil {
+				continue
+			}
+			sealed, err := mm.encryptFor(peerID, msg, capabilities)
+			if err != nil {
+				mm.settleOfflineBatch(peerID.String(), sent, nil, nil)
+				_ = stream.Reset()
+				return fmt.Errorf("failed to seal queued message: %w", err)
+			}
+			batch[i] = sealed
+		}
+		if err := writeFrame(stream, OfflineSyncFrame{Type: "batch", Messages: batch, Remaining: remaining}); err != nil {
+			mm.settleOfflineBatch(peerID.String(), sent, nil, nil)
+			_ = stream.Reset()
+			return fmt.Errorf("failed to send batch: %w", err)
+		}
+	}
+}
+
+// pushOfflineMessages delivers a limited number of queued messages one by one
+// to a peer that cannot pull them
+func (mm *MessageManager) pushOfflineMessages(peerID peer.ID) {
+	batch, _ := mm.nextOfflineBatch(peerID.String(), OfflinePushBatch, nil)
+
+	var sent, accepted []string
+	refused := make(map[string]*ProtocolError)
+	for _, msg := range batch {
+		sent = append(sent, msg.ID)
+		err := mm.deliver(peerID, msg)
+		if err == nil {
+			accepted = append(accepted, msg.ID)
+			continue
+		}
+		if pe, ok := AsProtocolError(err); ok {
+			refused[msg.ID] = pe
+			continue
+		}
+		// The peer is unreachable; try the rest in a later round
+		mm.logger.WithError(err).WithField("message_id", msg.ID).Debug("Offline message delivery failed")
+		break
+	}
+
+	mm.settleOfflineBatch(peerID.String(), sent, accepted, refused)
+}
+
+// offlineBacklog returns the number and size of the messages queued for a peer
+func (mm *MessageManager) offlineBacklog(peerIDStr string) (int, int64) {
+	mm.offlineMutex.RLock()
+	defer mm.offlineMutex.RUnlock()
+
+	var size int64
+	for _, offlineMsg := range mm.offlineMessages[peerIDStr] {
+		size += int64(len(offlineMsg.Message.Content))
+	}
+	return len(mm.offlineMessages[peerIDStr]), size
+}
+
+// nextOfflineBatch returns up to n queued messages for a peer, oldest first,
+// skipping those in tried and stopping at OfflineBatchBytes, and how many
+// untried messages are left after them. Expired messages are dropped.
+func (mm *MessageManager) nextOfflineBatch(peerIDStr string, n int, tried map[string]bool) ([]*Message, int) {
+	mm.offlineMutex.Lock()
+	defer mm.offlineMutex.Unlock()
+
+	now := time.Now()
+	var kept []*OfflineMessage
+	var batch []*Message
+	size, remaining := 0, 0
+	for _, offlineMsg := range mm.offlineMessages[peerIDStr] {
+		if now.After(offlineMsg.ExpiresAt) {
+			mm.logger.WithField("message_id", offlineMsg.Message.ID).Info("Offline message expired")
+			continue
+		}
+		kept = append(kept, offlineMsg)
+		if tried[offlineMsg.Message.ID] {
+			continue
+		}
+
+		msgSize := len(offlineMsg.Message.Content)
+		if data, err := json.Marshal(offlineMsg.Message); err == nil {
+			msgSize = len(data)
+		}
+		if len(batch) >= n || (len(batch) > 0 && size+msgSize > OfflineBatchBytes) {
+			remaining++
+			continue
+		}
+		batch = append(batch, offlineMsg.Message)
+		size += msgSize
+	}
+
+	if len(kept) != len(mm.offlineMessages[peerIDStr]) {
+		mm.setOfflineMessages(peerIDStr, kept)
+		mm.saveOfflineMessages()
+	}
+	return batch, remaining
+}
+
+// settleOfflineBatch applies the receipts for the messages sent to a peer.
+// Accepted messages and those refused for good leave the queue; the others
+// stay until they have been tried OfflineMaxAttempts times.
+func (mm *MessageManager) settleOfflineBatch(peerIDStr string, sent, accepted []string, refused map[string]*ProtocolError) {
+	if len(sent) == 0 {
+		return
+	}
+
+	isSent := make(map[string]bool, len(sent))
+	for _, id := range sent {
+		isSent[id] = true
+	}
+	isAccepted := make(map[string]bool, len(accepted))
+	for _, id := range accepted {
+		isAccepted[id] = true
+	}
+
+	type failure struct {
+		msg *Message
+		err *ProtocolError
+	}
+	var failures []failure
+	var delivered []*Message
+
+	mm.offlineMutex.Lock()
+	var remaining []*OfflineMessage
+	for _, offlineMsg := range mm.offlineMessages[peerIDStr] {
+		id := offlineMsg.Message.ID
+		switch {
+		case !isSent[id]:
+			remaining = append(remaining, offlineMsg)
+		case isAccepted[id]:
+			mm.logger.WithField("message_id", id).Debug("Offline message delivered successfully")
+			delivered = append(delivered, offlineMsg.Message)
+		case refused[id] != nil && !refused[id].Retryable():
+			failures = append(failures, failure{offlineMsg.Message, refused[id]})
+		default:
+			offlineMsg.Attempts++
+			if offlineMsg.Attempts < OfflineMaxAttempts {
+				remaining = append(remaining, offlineMsg)
+			} else {
+				mm.logger.WithField("message_id", id).Warn("Offline message delivery failed after max attempts")
+			}
+		}
+	}
+	mm.setOfflineMessages(peerIDStr, remaining)
+	mm.saveOfflineMessages()
+	mm.offlineMutex.Unlock()
+
+	// Report outside the lock; the callbacks may inspect the queue
+	for _, msg := range delivered {
+		mm.delivered(msg)
+	}
+	for _, f := range failures {
+		mm.deliveryFailed(f.msg, f.err)
+	}
+}
+
+// setOfflineMessages replaces a peer's queue; the caller holds offlineMutex
+func (mm *MessageManager) setOfflineMessages(peerIDStr string, messages []*OfflineMessage) {
+	if len(messages) == 0 {
+		delete(mm.offlineMessages, peerIDStr)
+		return
+	}
+	mm.offlineMessages[peerIDStr] = messages
+}
+
+// handleOfflineStream pulls the messages a peer queued while this node was
+// offline, one batch at a time, so a large backlog does not flood it
+func (mm *MessageManager) handleOfflineStream(stream network.Stream) {
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close offline stream")
+		}
+	}()
+
+	remotePeer := stream.Conn().RemotePeer()
+	timeout := mm.TimeoutsFor(remotePeer).Message
+
+	var summary OfflineSyncFrame
+	_ = stream.SetReadDeadline(time.Now().Add(timeout))
+	if err := readFrame(stream, offlineFrameLimit, &summary); err != nil || summary.Type != "summary" {
+		mm.logger.WithError(err).Warn("Invalid offline backlog summary")
+		_ = stream.Reset()
+		return
+	}
+
+	mm.logger.WithFields(logrus.Fields{
+		"peer":  remotePeer.String(),
+		"count": summary.Count,
+	}).Info("Fetching queued messages from peer")
+	if mm.OnOfflineBacklog != nil && summary.Count > 0 {
+		mm.OnOfflineBacklog(remotePeer.String(), summary.Count)
+	}
+
+	pull := OfflineSyncFrame{Type: "pull", Count: OfflineBatchSize}
+	received := 0
+	for {
+		_ = stream.SetDeadline(time.Now().Add(timeout))
+		if err := writeFrame(stream, pull); err != nil {
+			mm.logger.WithError(err).Warn("Failed to pull queued messages")
+			return
+		}
+		if pull.Count == 0 {
+			mm.logger.WithFields(logrus.Fields{
+				"peer":  remotePeer.String(),
+				"count": received,
+			}).Info("Queued messages fetched")
+			return
+		}
+
+		var batch OfflineSyncFrame
+		if err := readFrame(stream, offlineFrameLimit, &batch); err != nil || batch.Type != "batch" {
+			mm.logger.WithError(err).Warn("Failed to read queued messages")
+			_ = stream.Reset()
+			return
+		}
+
+		// Waiting for room in the queue, rather than refusing, paces the
+		// sender to the speed this node handles messages at
+		pull = OfflineSyncFrame{Type: "pull", Count: OfflineBatchSize, Refused: make(map[string]*ProtocolError)}
+		for _, msg := range batch.Messages {
+			if msg == nil {
+				continue
+			}
+			if pe := mm.acceptMessage(msg, remotePeer, timeout); pe != nil {
+				pull.Refused[msg.ID] = pe
+				continue
+			}
+			pull.Accepted = append(pull.Accepted, msg.ID)
+			received++
+		}
+
+		if batch.Remaining == 0 || len(batch.Messages) == 0 {
+			pull.Count = 0
+			continue
+		}
+
+		select {
+		case <-time.After(OfflinePullInterval):
+		case <-mm.ctx.Done():
+			pull.Count = 0
+		}
+	}
+}
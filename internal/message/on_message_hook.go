@@ -0,0 +1,127 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OnMessageQueueSize is how many received messages wait for the on-message
+// hook; further ones are dropped, with a warning, until it catches up
+const OnMessageQueueSize = 64
+
+// OnMessageEvent describes a received text message to the on-message hook,
+// which reads it as JSON on stdin
+type OnMessageEvent struct {
+	ID        string    `json:"id"`
+	PeerID    string    `json:"peer_id"`
+	From      string    `json:"from"`              // DID of the sender
+	Contact   string    `json:"contact,omitempty"` // Contact name of the sender, if any
+	Class     string    `json:"class"`
+	Tags      []string  `json:"tags,omitempty"` // Tags of the content filters that matched
+	Timestamp time.Time `json:"timestamp"`
+	Content   string    `json:"content"`
+}
+
+// Env returns the metadata of the message as environment variables. The
+// content is only given on stdin, as environment variables of a process can
+// be read by other processes of the user.
+func (e *OnMessageEvent) Env() []string {
+	return []string{
+		"XELVRA_MESSAGE_ID=" + e.ID,
+		"XELVRA_PEER_ID=" + e.PeerID,
+		"XELVRA_FROM=" + e.From,
+		"XELVRA_CONTACT=" + e.Contact,
+		"XELVRA_CLASS=" + e.Class,
+		"XELVRA_TAGS=" + strings.Join(e.Tags, ","),
+		"XELVRA_TIMESTAMP=" + e.Timestamp.UTC().Format(time.RFC3339),
+	}
+}
+
+// RunOnMessageHook runs the program at path for event, with the metadata in
+// its environment and the event as JSON on stdin, stopping it after
+// HookTimeout
+func RunOnMessageHook(ctx context.Context, path string, event *OnMessageEvent) error {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, HookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), event.Env()...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// SetOnMessageHook runs the program at path for every text message
+// received, one at a time in the order they arrive. Muted messages do not
+// run it. It is called before Start.
+func (mm *MessageManager) SetOnMessageHook(path string) {
+	mm.onMessageHook = path
+	mm.onMessageEvents = make(chan *OnMessageEvent, OnMessageQueueSize)
+}
+
+// queueOnMessage hands a received text message to the on-message hook, if
+// one is set
+func (mm *MessageManager) queueOnMessage(msg *Message) {
+	if mm.onMessageHook == "" {
+		return
+	}
+	match := FilterMatchOf(msg.Metadata)
+	if match.Muted || !mm.notifies(msg.fromPeer.String()) {
+		return
+	}
+
+	event := &OnMessageEvent{
+		ID:        msg.ID,
+		PeerID:    msg.fromPeer.String(),
+		From:      msg.From,
+		Class:     msg.Type.String(),
+		Tags:      match.Tags,
+		Timestamp: msg.Timestamp,
+		Content:   string(msg.Content),
+	}
+	if mm.contacts != nil {
+		if contact, ok := mm.contacts.FindByPeerID(event.PeerID); ok {
+			event.Contact = contact.Name
+		}
+	}
+
+	select {
+	case mm.onMessageEvents <- event:
+	default:
+		mm.logger.WithField("message_id", msg.ID).Warn("On-message hook is behind, message skipped")
+	}
+}
+
+// runOnMessageHook runs the on-message hook for each queued message until
+// the manager stops
+func (mm *MessageManager) runOnMessageHook() {
+	defer mm.wg.Done()
+
+	for {
+		select {
+		case event := <-mm.onMessageEvents:
+			fields := logrus.Fields{"hook": mm.onMessageHook, "message_id": event.ID, "peer": event.PeerID}
+			if err := RunOnMessageHook(mm.ctx, mm.onMessageHook, event); err != nil {
+				mm.logger.WithError(err).WithFields(fields).Warn("On-message hook failed")
+				continue
+			}
+			mm.logger.WithFields(fields).Debug("On-message hook ran")
+		case <-mm.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+	// ProfileProtocolID answers a peer with the profile facet disclosed to it
+	ProfileProtocolID = protocol.ID("/xelvra/profile/1.0.0")
+
+	// Largest profile answer: the avatar is base64 encoded in JSON
+	maxProfileFrameSize = 2*user.MaxAvatarSize + 64*1024
+)
+
+// ProfileResponse is the answer on ProfileProtocolID
+type ProfileResponse struct {
+	Profile *user.PublicProfile `json:"profile,omitempty"`
+	Error   *ProtocolError      `json:"error,omitempty"`
+}
+
+// handleProfileStream answers a peer with the facet chosen for it. Peers
+// without an assignment get the default facet, or an empty profile.
+func (mm *MessageManager) handleProfileStream(stream network.Stream) {
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close profile stream")
+		}
+	}()
+
+	remotePeer := stream.Conn().RemotePeer()
+	response := ProfileResponse{Profile: &user.PublicProfile{}}
+
+	settings, err := user.LoadProfileSettings(mm.profilesPath)
+	if err != nil {
+		mm.logger.WithError(err).Warn("Failed to load profile facets")
+		response = ProfileResponse{Error: NewProtocolError(ErrCodeInternal, "profile unavailable")}
+	} else if facet := settings.FacetFor(remotePeer.String()); facet != nil {
+		profile, err := facet.Disclose()
+		if err != nil {
+			// Share the rest of the facet rather than nothing
+			mm.logger.WithError(err).WithField("facet", facet.Name).Warn("Failed to read avatar")
+			withoutAvatar := *facet
+			withoutAvatar.Avatar = ""
+			profile, _ = withoutAvatar.Disclose()
+		}
+		response.Profile = profile
+	}
+
+	_ = stream.SetWriteDeadline(time.Now().Add(mm.TimeoutsFor(remotePeer).Message))
+	if err := writeFrame(stream, response); err != nil {
+		mm.logger.WithError(err).WithField("peer", remotePeer.String()).Debug("Failed to send profile")
+	}
+}
+
+// FetchProfile asks a peer for its profile. The peer decides which of its
+// facets to disclose.
+func (mm *MessageManager) FetchProfile(peerID peer.ID) (*user.PublicProfile, error) {
+	timeout := mm.TimeoutsFor(peerID).Message
+	ctx, cancel := context.WithTimeout(mm.ctx, timeout)
+	defer cancel()
+
+	stream, err := mm.host.NewStream(ctx, peerID, ProfileProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open profile stream: %w", err)
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			mm.logger.WithError(err).Debug("Failed to close profile stream")
+		}
+	}()
+
+	_ = stream.SetReadDeadline(time.Now().Add(timeout))
+	var response ProfileResponse
+	if err := readFrame(stream, maxProfileFrameSize, &response); err != nil {
+		return nil, fmt.Errorf("no profile from peer: %w", err)
+	}
+	if response.Error != nil {
+		return nil, response.Error
+	}
+	if response.Profile == nil {
+		return &user.PublicProfile{}, nil
+	}
+	return response.Profile, nil
+}
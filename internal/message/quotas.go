@@ -0,0 +1,177 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// QuotasFileName holds the configured disk quotas in the data directory
+	QuotasFileName = "quotas.json"
+
+	// Default quotas
+	DefaultDownloadsQuota = 2 << 30   // 2 GB of received files
+	DefaultOfflineQuota   = 200 << 20 // 200 MB of queued messages
+)
+
+// Quotas limit the disk space used by received files and by messages queued
+// for offline peers. Zero means no limit.
+type Quotas struct {
+	Downloads int64 `json:"downloads_bytes"`
+	Offline   int64 `json:"offline_bytes"`
+}
+
+// DefaultQuotas returns the built-in quotas
+func DefaultQuotas() Quotas {
+	return Quotas{Downloads: DefaultDownloadsQuota, Offline: DefaultOfflineQuota}
+}
+
+// LoadQuotas reads the quotas configured at path. Without a file, or for
+// quotas missing from it, the defaults apply.
+func LoadQuotas(path string) (Quotas, error) {
+	quotas := DefaultQuotas()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return quotas, nil
+		}
+		return quotas, err
+	}
+
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		return DefaultQuotas(), fmt.Errorf("failed to parse quotas: %w", err)
+	}
+	return quotas, nil
+}
+
+// SaveQuotas writes the quotas to path
+func SaveQuotas(path string, quotas Quotas) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(quotas, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// ParseSize parses a size such as "2GB", "200MB", "1.5G" or "4096". "0" and
+// "unlimited" mean no limit.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "UNLIMITED" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	scale := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, scale = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * scale), nil
+}
+
+// StorageUsage is the disk space used against the quotas
+type StorageUsage struct {
+	DownloadsBytes int64 `json:"downloads_bytes"`
+	DownloadsQuota int64 `json:"downloads_quota"`
+	OfflineBytes   int64 `json:"offline_bytes"`
+	OfflineQuota   int64 `json:"offline_quota"`
+}
+
+// DownloadsExceeded reports whether received files fill their quota
+func (u StorageUsage) DownloadsExceeded() bool {
+	return u.DownloadsQuota > 0 && u.DownloadsBytes >= u.DownloadsQuota
+}
+
+// OfflineExceeded reports whether queued messages fill their quota
+func (u StorageUsage) OfflineExceeded() bool {
+	return u.OfflineQuota > 0 && u.OfflineBytes >= u.OfflineQuota
+}
+
+// DirUsage returns the size of the files under dirs. Hard links, such as
+// downloads of stored attachments, are counted once.
+func DirUsage(dirs ...string) int64 {
+	seen := make(map[fileKey]bool)
+	var total int64
+	for _, dir := range dirs {
+		_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			if key, ok := fileKeyOf(info); ok {
+				if seen[key] {
+					return nil
+				}
+				seen[key] = true
+			}
+			total += info.Size()
+			return nil
+		})
+	}
+	return total
+}
+
+// quotaSource caches the quotas, reloading them when the file changes so CLI
+// edits apply to a running node
+type quotaSource struct {
+	path   string
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	modTime time.Time
+	loaded  bool
+	quotas  Quotas
+}
+
+// newQuotaSource creates a source for the quotas at path
+func newQuotaSource(path string, logger *logrus.Logger) *quotaSource {
+	return &quotaSource{path: path, logger: logger}
+}
+
+// Quotas returns the current quotas
+func (s *quotaSource) Quotas() Quotas {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modTime time.Time
+	if info, err := os.Stat(s.path); err == nil {
+		modTime = info.ModTime()
+	}
+	if s.loaded && modTime.Equal(s.modTime) {
+		return s.quotas
+	}
+
+	quotas, err := LoadQuotas(s.path)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to load quotas, using defaults")
+	}
+	s.quotas, s.modTime, s.loaded = quotas, modTime, true
+	return quotas
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package message
+
+import "os"
+
+// fileKey identifies a file independently of its path
+type fileKey struct{}
+
+// fileKeyOf is not available on this platform; hard links are counted for
+// each of their paths
+func fileKeyOf(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,416 @@
+package message
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/Xelvra/peerchat/internal/crypto"
+	"github.com/Xelvra/peerchat/internal/logging"
+	"github.com/Xelvra/peerchat/internal/user"
+	lcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SessionsFileName holds the session state with each peer, encrypted
+	// with a key derived from the identity
+	SessionsFileName = "sessions.enc"
+
+	// sessionsKeyPurpose selects the storage key derived from the identity
+	sessionsKeyPurpose = "sessions"
+
+	// Receiving sessions kept per peer, and IDs of retired ones remembered
+	maxReceivingSessions = 4
+	maxRetiredSessions   = 32
+)
+
+// encryptedContent is the content of a message sealed for its recipient in
+// a session. With Body set the message shares a crypto.SharedBody with other
+// recipients, and the session seals only its content key.
+type encryptedContent struct {
+	Session    *crypto.SessionHeader `json:"session,omitempty"`
+	Ciphertext []byte                `json:"ciphertext,omitempty"`
+	Body       []byte                `json:"body,omitempty"`
+}
+
+// peerSessions is the session state kept with one peer. Each direction has
+// its own session: the one this node started for sending, and those the
+// peer started for receiving.
+type peerSessions struct {
+	Sending   *crypto.Session            `json:"sending,omitempty"`
+	Receiving map[string]*crypto.Session `json:"receiving,omitempty"`
+
+	// Retired are receiving sessions closed by a reset; messages still
+	// sealed in them are refused
+	Retired []string `json:"retired,omitempty"`
+}
+
+// sessionStore keeps the sessions with every peer on disk
+type sessionStore struct {
+	mu    sync.Mutex
+	path  string
+	key   []byte // Nil keeps the sessions in memory only
+	peers map[string]*peerSessions
+}
+
+// newSessionStore loads the sessions saved at path
+func newSessionStore(path string, identity *user.MessengerID, logger *logrus.Logger) *sessionStore {
+	store := &sessionStore{path: path, peers: make(map[string]*peerSessions)}
+	if identity == nil {
+		return store
+	}
+	key, err := identity.DeriveStorageKey(sessionsKeyPurpose)
+	if err != nil {
+		logger.WithError(err).Warn("Sessions are kept in memory only")
+		return store
+	}
+	store.key = key
+
+	if _, err := readSealedJSON(path, key, &store.peers); err != nil {
+		// The sessions are rebuilt from the next messages either way
+		logger.WithError(err).Warn("Failed to load sessions, starting new ones")
+		store.peers = make(map[string]*peerSessions)
+	}
+	return store
+}
+
+// peer returns the sessions with a peer, creating an empty entry. Callers
+// hold mu.
+func (s *sessionStore) peer(peerID string) *peerSessions {
+	ps, ok := s.peers[peerID]
+	if !ok {
+		ps = &peerSessions{}
+		s.peers[peerID] = ps
+	}
+	if ps.Receiving == nil {
+		ps.Receiving = make(map[string]*crypto.Session)
+	}
+	return ps
+}
+
+// save writes the sessions to disk. Callers hold mu.
+func (s *sessionStore) save() error {
+	if s.key == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	return writeSealedJSON(s.path, s.key, s.peers)
+}
+
+// retire closes receiving sessions so they are never opened again
+func (ps *peerSessions) retire(ids ...string) {
+	for _, id := range ids {
+		if session, ok := ps.Receiving[id]; ok {
+			session.Destroy()
+			delete(ps.Receiving, id)
+		}
+		if !slices.Contains(ps.Retired, id) {
+			ps.Retired = append(ps.Retired, id)
+		}
+	}
+	if excess := len(ps.Retired) - maxRetiredSessions; excess > 0 {
+		ps.Retired = ps.Retired[excess:]
+	}
+}
+
+// addReceiving keeps a new receiving session, dropping the oldest beyond
+// maxReceivingSessions
+func (ps *peerSessions) addReceiving(session *crypto.Session) {
+	ps.Receiving[session.ID] = session
+	if len(ps.Receiving) <= maxReceivingSessions {
+		return
+	}
+	sessions := make([]*crypto.Session, 0, len(ps.Receiving))
+	for _, s := range ps.Receiving {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	for _, s := range sessions[:len(sessions)-maxReceivingSessions] {
+		s.Destroy()
+		delete(ps.Receiving, s.ID)
+	}
+}
+
+// sessionCrypto returns the Curve25519 identity of the host's key, or nil
+// when the host key is not Ed25519 and sessions cannot be used
+func sessionCrypto(privKey lcrypto.PrivKey) *crypto.SignalCrypto {
+	if privKey == nil || privKey.Type() != lcrypto.Ed25519 {
+		return nil
+	}
+	raw, err := privKey.Raw()
+	if err != nil {
+		return nil
+	}
+	keyPair, err := crypto.KeyPairFromEd25519(ed25519.PrivateKey(raw))
+	if err != nil {
+		return nil
+	}
+	return crypto.NewSignalCryptoWithIdentity(keyPair)
+}
+
+// peerSessionKey returns the Curve25519 identity key of a peer
+func peerSessionKey(peerID peer.ID) ([]byte, error) {
+	pubKey, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract key of %s: %w", peerID, err)
+	}
+	if pubKey.Type() != lcrypto.Ed25519 {
+		return nil, fmt.Errorf("peer %s has no Ed25519 key", peerID)
+	}
+	raw, err := pubKey.Raw()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.PublicKeyFromEd25519(ed25519.PublicKey(raw))
+}
+
+// encryptFor seals a text message in the sending session with a peer that
+// agreed on FeatureSessions, starting the session with X3DH if there is
+// none. A message sent to many peers seals only the key of its shared body
+// for peers that agreed on FeatureSharedBodies. Other messages, and messages
+// for other peers, are returned as they are. The message itself is left
+// unchanged, so the outbox and the offline queue keep its plaintext and each
+// attempt is sealed anew.
+func (mm *MessageManager) encryptFor(peerID peer.ID, msg *Message, capabilities *PeerCapabilities) (*Message, error) {
+	if msg.IsEncrypted || msg.Type != MessageTypeText || mm.signal == nil {
+		return msg, nil
+	}
+	if !capabilities.Supports(FeatureSessions) {
+		return msg, nil
+	}
+
+	mm.sessions.mu.Lock()
+	defer mm.sessions.mu.Unlock()
+
+	ps := mm.sessions.peer(peerID.String())
+	if ps.Sending == nil {
+		session, err := mm.startSession(peerID)
+		if err != nil {
+			return nil, err
+		}
+		ps.Sending = session
+	}
+
+	plaintext, body := msg.Content, []byte(nil)
+	if msg.shared != nil && capabilities.Supports(FeatureSharedBodies) {
+		plaintext, body = msg.shared.Key, msg.shared.Body
+	}
+	header, ciphertext, err := ps.Sending.Seal(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal message: %w", err)
+	}
+	if err := mm.sessions.save(); err != nil {
+		mm.logger.WithError(err).Warn("Failed to save sessions")
+	}
+
+	return sealedCopy(msg, encryptedContent{Session: header, Ciphertext: ciphertext, Body: body})
+}
+
+// sealedCopy returns a copy of msg carrying the sealed content
+func sealedCopy(msg *Message, sealed encryptedContent) (*Message, error) {
+	content, err := json.Marshal(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize sealed message: %w", err)
+	}
+	copied := *msg
+	copied.Content = content
+	copied.IsEncrypted = true
+	copied.shared = nil
+	return &copied, nil
+}
+
+// startSession runs X3DH with a peer for a new sending session
+func (mm *MessageManager) startSession(peerID peer.ID) (*crypto.Session, error) {
+	remoteKey, err := peerSessionKey(peerID)
+	if err != nil {
+		return nil, err
+	}
+	session, err := mm.signal.NewInitiatorSession(remoteKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %w", err)
+	}
+	mm.logger.WithFields(logrus.Fields{
+		"peer_id":    peerID.String(),
+		"session_id": session.ID,
+	}).Debug("Started session")
+	return session, nil
+}
+
+// decryptMessage opens a message sealed in a session the sender started,
+// and the shared body whose key it sealed if it has one, replacing its
+// content with the plaintext. A session this node has not seen is set up
+// from the X3DH keys in the message.
+func (mm *MessageManager) decryptMessage(msg *Message, remotePeer peer.ID) *ProtocolError {
+	var sealed encryptedContent
+	if err := json.Unmarshal(msg.Content, &sealed); err != nil || sealed.Session == nil {
+		return NewProtocolError(ErrCodeInvalid, "malformed encrypted message")
+	}
+	if mm.signal == nil {
+		return NewProtocolError(ErrCodeUnsupported, "encrypted messages are not supported")
+	}
+	header := sealed.Session
+
+	mm.sessions.mu.Lock()
+	defer mm.sessions.mu.Unlock()
+
+	ps := mm.sessions.peer(remotePeer.String())
+	if slices.Contains(ps.Retired, header.SessionID) {
+		return NewProtocolError(ErrCodeSession, "session %s was reset", header.SessionID)
+	}
+
+	session, known := ps.Receiving[header.SessionID]
+	if !known {
+		remoteKey, err := peerSessionKey(remotePeer)
+		if err != nil {
+			return NewProtocolError(ErrCodeSession, "cannot start a session: %v", err)
+		}
+		session, err = mm.signal.NewResponderSession(remoteKey, header.EphemeralKey)
+		if err != nil || session.ID != header.SessionID {
+			return NewProtocolError(ErrCodeSession, "cannot start session %s", header.SessionID)
+		}
+	}
+
+	plaintext, err := session.Open(header, sealed.Ciphertext)
+	if err != nil {
+		mm.logger.WithError(err).WithFields(logrus.Fields{
+			"peer_id":    remotePeer.String(),
+			"session_id": header.SessionID,
+		}).Warn("Failed to open message")
+		return NewProtocolError(ErrCodeSession, "cannot decrypt message in session %s", header.SessionID)
+	}
+	if sealed.Body != nil {
+		key := plaintext
+		plaintext, err = crypto.OpenSharedBody(key, sealed.Body)
+		clear(key)
+		if err != nil {
+			mm.logger.WithError(err).WithField("peer_id", remotePeer.String()).Warn("Failed to open shared body")
+			return NewProtocolError(ErrCodeInvalid, "cannot open shared body")
+		}
+	}
+	if !known {
+		ps.addReceiving(session)
+	}
+	if err := mm.sessions.save(); err != nil {
+		mm.logger.WithError(err).Warn("Failed to save sessions")
+	}
+
+	msg.Content = plaintext
+	msg.IsEncrypted = false
+	return nil
+}
+
+// ResetSession discards the sessions with a peer, runs a fresh X3DH for a
+// new sending session and tells the peer with a NoticeSessionReset, so it
+// drops its side too. It returns the ID of the new session.
+func (mm *MessageManager) ResetSession(peerIDStr string) (string, error) {
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer ID: %w", err)
+	}
+	if mm.signal == nil {
+		return "", fmt.Errorf("this node's key cannot be used for sessions")
+	}
+
+	session, err := mm.startSession(peerID)
+	if err != nil {
+		return "", err
+	}
+
+	mm.sessions.mu.Lock()
+	ps := mm.sessions.peer(peerIDStr)
+	var previous string
+	if ps.Sending != nil {
+		previous = ps.Sending.ID
+		ps.Sending.Destroy()
+	}
+	ps.Sending = session
+	ps.retire(slices.Collect(maps.Keys(ps.Receiving))...)
+	err = mm.sessions.save()
+	mm.sessions.mu.Unlock()
+	if err != nil {
+		return "", fmt.Errorf("failed to save sessions: %w", err)
+	}
+
+	if err := mm.audit.Record(logging.AuditSessionReset, peerIDStr, map[string]string{
+		"previous_session": previous,
+		"session":          session.ID,
+	}); err != nil {
+		mm.logger.WithError(err).Warn("Failed to record session reset in the audit log")
+	}
+	mm.logger.WithFields(logrus.Fields{
+		"peer_id":    peerIDStr,
+		"session_id": session.ID,
+	}).Info("Session reset")
+
+	if err := mm.SendNotice(peerIDStr, NewNotice(NoticeSessionReset, "session", session.ID)); err != nil {
+		return session.ID, fmt.Errorf("session reset, but the peer could not be told: %w", err)
+	}
+	return session.ID, nil
+}
+
+// handleSessionReset drops the sessions with a peer that reset them: its
+// old sessions are retired, keeping the one it started with the reset, and
+// the next message to it runs a fresh X3DH
+func (mm *MessageManager) handleSessionReset(remotePeer peer.ID, notice *Notice) {
+	keep := notice.Params["session"]
+
+	mm.sessions.mu.Lock()
+	ps := mm.sessions.peer(remotePeer.String())
+	var retired []string
+	for id := range ps.Receiving {
+		if id != keep {
+			retired = append(retired, id)
+		}
+	}
+	ps.retire(retired...)
+	if ps.Sending != nil {
+		ps.Sending.Destroy()
+		ps.Sending = nil
+	}
+	if err := mm.sessions.save(); err != nil {
+		mm.logger.WithError(err).Warn("Failed to save sessions")
+	}
+	mm.sessions.mu.Unlock()
+
+	if err := mm.audit.Record(logging.AuditSessionResetByPeer, remotePeer.String(), map[string]string{
+		"session": keep,
+	}); err != nil {
+		mm.logger.WithError(err).Warn("Failed to record session reset in the audit log")
+	}
+	mm.logger.WithField("peer_id", remotePeer.String()).Info("Peer reset its session")
+}
+
+// SessionIDs returns the IDs of the sending session with a peer and of the
+// receiving sessions it started, empty when there are none
+func (mm *MessageManager) SessionIDs(peerIDStr string) (sending string, receiving []string) {
+	mm.sessions.mu.Lock()
+	defer mm.sessions.mu.Unlock()
+
+	ps, ok := mm.sessions.peers[peerIDStr]
+	if !ok {
+		return "", nil
+	}
+	if ps.Sending != nil {
+		sending = ps.Sending.ID
+	}
+	for id := range ps.Receiving {
+		receiving = append(receiving, id)
+	}
+	sort.Strings(receiving)
+	return sending, receiving
+}
+
+// AuditLog returns the log security events are recorded in
+func (mm *MessageManager) AuditLog() *logging.AuditLog {
+	return mm.audit
+}
@@ -0,0 +1,203 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TimeoutsFileName holds the configured protocol timeouts in the data directory
+	TimeoutsFileName = "timeouts.json"
+
+	// Learned timeouts allow this many round trips, so slow links such as
+	// satellite or congested mobile connections are not cut off early
+	MessageTimeoutRTTs = 20
+	FileTimeoutRTTs    = 200
+)
+
+// Duration is a time.Duration stored as a string such as "45s" or "10m"
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"45s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Timeouts are the deadlines of protocol operations with one peer
+type Timeouts struct {
+	Message time.Duration // Deliver a message and receive its receipt
+	File    time.Duration // Open transfer streams and wait for the peer to compare a directory
+}
+
+// DefaultTimeouts returns the built-in timeouts
+func DefaultTimeouts() Timeouts {
+	return Timeouts{Message: MessageTimeout, File: FileTimeout}
+}
+
+// String describes the timeouts for display
+func (t Timeouts) String() string {
+	return fmt.Sprintf("messages %s, files %s", t.Message, t.File)
+}
+
+// TimeoutOverride replaces some timeouts. Zero values keep the timeout it
+// overrides.
+type TimeoutOverride struct {
+	Message Duration `json:"message,omitempty"`
+	File    Duration `json:"file,omitempty"`
+}
+
+// IsZero reports whether the override changes nothing
+func (o TimeoutOverride) IsZero() bool {
+	return o.Message <= 0 && o.File <= 0
+}
+
+// apply returns t with the override's values
+func (o TimeoutOverride) apply(t Timeouts) Timeouts {
+	if o.Message > 0 {
+		t.Message = time.Duration(o.Message)
+	}
+	if o.File > 0 {
+		t.File = time.Duration(o.File)
+	}
+	return t
+}
+
+// TimeoutConfig holds the global timeouts and per-peer overrides
+type TimeoutConfig struct {
+	TimeoutOverride
+	Peers map[string]TimeoutOverride `json:"peers,omitempty"`
+}
+
+// Global returns the timeouts of peers without an override, before they are
+// adapted to the peer's round-trip time
+func (c *TimeoutConfig) Global() Timeouts {
+	return c.TimeoutOverride.apply(DefaultTimeouts())
+}
+
+// For returns the timeouts for a peer. A configured override for the peer is
+// used as is; otherwise the global timeouts are raised, never lowered, to
+// allow for the measured round-trip time rtt (zero if unknown).
+func (c *TimeoutConfig) For(peerID string, rtt time.Duration) Timeouts {
+	t := c.Global()
+	if override, ok := c.Peers[peerID]; ok {
+		return override.apply(t)
+	}
+
+	if rtt > 0 {
+		t.Message = max(t.Message, rtt*MessageTimeoutRTTs)
+		t.File = max(t.File, rtt*FileTimeoutRTTs)
+	}
+	return t
+}
+
+// LoadTimeoutConfig reads the timeouts configured at path. Without a file
+// the built-in timeouts apply.
+func LoadTimeoutConfig(path string) (*TimeoutConfig, error) {
+	config := &TimeoutConfig{Peers: make(map[string]TimeoutOverride)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse timeouts: %w", err)
+	}
+	if config.Peers == nil {
+		config.Peers = make(map[string]TimeoutOverride)
+	}
+	return config, nil
+}
+
+// SaveTimeoutConfig writes the timeouts to path
+func SaveTimeoutConfig(path string, config *TimeoutConfig) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// timeoutSource caches the timeout configuration, reloading it when the file
+// changes so CLI edits apply to a running node
+type timeoutSource struct {
+	path   string
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	modTime time.Time
+	config  *TimeoutConfig
+}
+
+// newTimeoutSource creates a source for the configuration at path
+func newTimeoutSource(path string, logger *logrus.Logger) *timeoutSource {
+	return &timeoutSource{path: path, logger: logger}
+}
+
+// Config returns the current configuration
+func (s *timeoutSource) Config() *TimeoutConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var modTime time.Time
+	if info, err := os.Stat(s.path); err == nil {
+		modTime = info.ModTime()
+	}
+	if s.config != nil && modTime.Equal(s.modTime) {
+		return s.config
+	}
+
+	config, err := LoadTimeoutConfig(s.path)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to load timeouts, using defaults")
+		config, _ = LoadTimeoutConfig("")
+	}
+	s.config, s.modTime = config, modTime
+	return config
+}
+
+// timeoutsKey is the context key of the timeouts of an operation
+type timeoutsKey struct{}
+
+// WithTimeouts returns a context carrying the timeouts for the operations
+// run with it
+func WithTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, t)
+}
+
+// TimeoutsFrom returns the timeouts carried by ctx, or the defaults
+func TimeoutsFrom(ctx context.Context) Timeouts {
+	if t, ok := ctx.Value(timeoutsKey{}).(Timeouts); ok {
+		return t
+	}
+	return DefaultTimeouts()
+}
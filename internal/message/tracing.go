@@ -0,0 +1,48 @@
+package message
+
+import (
+	"github.com/Xelvra/peerchat/internal/tracing"
+)
+
+// Spans recorded for a message. A send is traced from the call to the
+// receiver's receipt; the receiver's span joins the sender's trace.
+const (
+	SpanSend    = "message.send"    // SendMessage until the message is delivered, queued offline or refused
+	SpanSign    = "message.sign"    // Signing with the identity key
+	SpanQueue   = "message.queue"   // Waiting in the outgoing queue
+	SpanDial    = "message.dial"    // Opening the message stream, connecting if needed
+	SpanWrite   = "message.write"   // Writing the message to the stream
+	SpanAck     = "message.ack"     // Waiting for the receiver's receipt
+	SpanReceive = "message.receive" // Receiving, checking and queueing a message, on the receiver
+)
+
+// Outcomes recorded on the send span
+const (
+	outcomeDelivered = "delivered"
+	outcomeOffline   = "offline"
+	outcomeRefused   = "refused"
+)
+
+// SetTracer records message spans with tracer; nil turns tracing off. It
+// must be called before messages are sent.
+func (mm *MessageManager) SetTracer(tracer *tracing.Tracer) {
+	mm.tracer = tracer
+}
+
+// traceParentOf returns the traceparent to send with a message, or "" when
+// the send is not traced
+func traceParentOf(span *tracing.Span) string {
+	if sc, ok := span.SpanContext(); ok {
+		return sc.TraceParent()
+	}
+	return ""
+}
+
+// startReceiveSpan begins the receiver's span for msg, joining the sender's
+// trace when the message carries one
+func (mm *MessageManager) startReceiveSpan(msg *Message) *tracing.Span {
+	if sc, ok := tracing.ParseTraceParent(msg.TraceParent); ok {
+		return mm.tracer.StartRemote(sc, SpanReceive)
+	}
+	return mm.tracer.Start(nil, SpanReceive)
+}
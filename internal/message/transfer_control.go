@@ -0,0 +1,153 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Actions the user can take on a running transfer. Each is also the type of
+// the frame that tells the peer about it.
+const (
+	TransferPause  = "pause"
+	TransferResume = "resume"
+	TransferCancel = "cancel"
+)
+
+// ErrTransferCancelled is returned when either side cancels a transfer
+var ErrTransferCancelled = errors.New("file transfer cancelled")
+
+// ControlTransfer pauses, resumes or cancels a transfer in either direction.
+// A running transfer tells the peer at once; one waiting to be resumed after
+// a stall tells it when it reconnects.
+func (ftm *FileTransferManager) ControlTransfer(id, action string) (*FileTransfer, error) {
+	transfer, exists := ftm.lookupTransfer(id)
+	if !exists {
+		return nil, fmt.Errorf("no transfer with ID %s", id)
+	}
+
+	attached, err := transfer.requestControl(action)
+	if err != nil {
+		return nil, err
+	}
+
+	if attached {
+		select {
+		case transfer.control <- struct{}{}:
+		default:
+		}
+	} else if action == TransferCancel {
+		ftm.discardTransfer(transfer)
+	} else {
+		transfer.updatePauseStatus()
+	}
+
+	ftm.logger.WithFields(logrus.Fields{
+		"transfer_id": id,
+		"action":      action,
+	}).Info("File transfer control requested")
+
+	return transfer.snapshot(), nil
+}
+
+// requestControl records a pause, resume or cancel by the user and reports
+// whether a stream is attached to carry it to the peer
+func (ft *FileTransfer) requestControl(action string) (bool, error) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	switch ft.Status {
+	case FileTransferCompleted, FileTransferFailed, FileTransferCancelled:
+		return false, fmt.Errorf("transfer %s is already %s", ft.ID, ft.Status)
+	}
+
+	switch action {
+	case TransferPause:
+		if ft.PausedLocal {
+			return false, fmt.Errorf("transfer %s is already paused", ft.ID)
+		}
+		ft.PausedLocal = true
+	case TransferResume:
+		if !ft.PausedLocal {
+			if ft.PausedRemote {
+				return false, fmt.Errorf("transfer %s was paused by the peer, only they can resume it", ft.ID)
+			}
+			return false, fmt.Errorf("transfer %s is not paused", ft.ID)
+		}
+		ft.PausedLocal = false
+	case TransferCancel:
+		ft.cancelRequested = true
+	default:
+		return false, fmt.Errorf("unknown transfer action %q", action)
+	}
+	return ft.attached, nil
+}
+
+// syncControl tells the peer about a local pause or resume it has not heard
+// of yet. told tracks what the peer was last told on this stream. It returns
+// ErrTransferCancelled once the transfer is cancelled locally.
+func (ftm *FileTransferManager) syncControl(fs *fileStream, transfer *FileTransfer, told *bool) error {
+	transfer.mu.Lock()
+	cancelled, paused := transfer.cancelRequested, transfer.PausedLocal
+	transfer.mu.Unlock()
+
+	if cancelled {
+		_ = fs.write(FileTransferRequest{Type: TransferCancel})
+		ftm.discardTransfer(transfer)
+		return ErrTransferCancelled
+	}
+
+	if paused != *told {
+		frameType := TransferResume
+		if paused {
+			frameType = TransferPause
+		}
+		if err := fs.write(FileTransferRequest{Type: frameType}); err != nil {
+			return fmt.Errorf("%w: failed to send %s: %v", ErrTransferStalled, frameType, err)
+		}
+		*told = paused
+		ftm.logger.WithField("transfer_id", transfer.ID).Infof("File transfer %sd", frameType)
+	}
+
+	transfer.updatePauseStatus()
+	return nil
+}
+
+// applyRemoteControl applies a pause, resume or cancel frame from the peer
+func (ftm *FileTransferManager) applyRemoteControl(transfer *FileTransfer, frameType string) error {
+	switch frameType {
+	case TransferPause, TransferResume:
+		transfer.mu.Lock()
+		transfer.PausedRemote = frameType == TransferPause
+		transfer.mu.Unlock()
+	case TransferCancel:
+		ftm.discardTransfer(transfer)
+		ftm.logger.WithField("transfer_id", transfer.ID).Info("File transfer cancelled by the peer")
+		return fmt.Errorf("%w by the peer", ErrTransferCancelled)
+	}
+
+	transfer.updatePauseStatus()
+	ftm.logger.WithFields(logrus.Fields{
+		"transfer_id": transfer.ID,
+		"action":      frameType,
+	}).Info("File transfer control from the peer")
+	return nil
+}
+
+// discardTransfer marks a transfer cancelled and deletes what was received
+// of it, so a new offer of the same file starts from scratch
+func (ftm *FileTransferManager) discardTransfer(transfer *FileTransfer) {
+	transfer.setStatus(FileTransferCancelled, ErrTransferCancelled)
+
+	transfer.mu.Lock()
+	partPath := transfer.partPath
+	transfer.mu.Unlock()
+	if partPath == "" {
+		return
+	}
+	if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+		ftm.logger.WithError(err).Warn("Failed to remove partial file of cancelled transfer")
+	}
+}
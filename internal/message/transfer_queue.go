@@ -0,0 +1,350 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TransferQueueFileName holds files waiting to be sent
+	TransferQueueFileName = "transfer_queue.json"
+
+	// transferQueueInterval is how often the queue is checked for files
+	// whose peer is connected, and progress of running ones is saved
+	transferQueueInterval = 30 * time.Second
+
+	// TransferQueueRetryDelay is how long a queued transfer that failed
+	// waits before it is tried again, unless the peer reconnects sooner
+	TransferQueueRetryDelay = 5 * time.Minute
+)
+
+// QueuedTransfer is a file waiting to be sent to a peer. It stays queued
+// until sent, refused or cancelled, across restarts; an interrupted send
+// resumes from what the receiver already has.
+type QueuedTransfer struct {
+	PeerID   string       `json:"peer_id"`
+	Path     string       `json:"path"`
+	Metadata FileMetadata `json:"metadata"` // Metadata.ID identifies the transfer
+	QueuedAt time.Time    `json:"queued_at"`
+
+	// Progress of the previous attempts
+	BytesAcked  int64     `json:"bytes_acked,omitempty"`
+	Attempts    int       `json:"attempts,omitempty"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// ID returns the ID of the transfer
+func (q *QueuedTransfer) ID() string {
+	return q.Metadata.ID
+}
+
+// NewQueuedTransfer prepares the file at path for sending to peerID
+func NewQueuedTransfer(peerID, path string) (*QueuedTransfer, error) {
+	if _, err := peer.Decode(peerID); err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(abs); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	metadata, err := CreateFileMetadata(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file metadata: %w", err)
+	}
+	return &QueuedTransfer{PeerID: peerID, Path: abs, Metadata: *metadata, QueuedAt: time.Now()}, nil
+}
+
+// LoadTransferQueue reads the transfer queue at path, oldest first
+func LoadTransferQueue(path string) ([]*QueuedTransfer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var queue []*QueuedTransfer
+	if err := json.Unmarshal(data, &queue); err != nil {
+		return nil, fmt.Errorf("failed to parse transfer queue: %w", err)
+	}
+	return queue, nil
+}
+
+// SaveTransferQueue writes the transfer queue to path
+func SaveTransferQueue(path string, queue []*QueuedTransfer) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(queue, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// EnqueueTransfer adds q to the transfer queue at path
+func EnqueueTransfer(path string, q *QueuedTransfer) error {
+	queue, err := LoadTransferQueue(path)
+	if err != nil {
+		return err
+	}
+	return SaveTransferQueue(path, append(queue, q))
+}
+
+// RemoveQueuedTransfer removes the transfer with id from the queue at path,
+// reporting whether it was queued
+func RemoveQueuedTransfer(path, id string) (bool, error) {
+	removed := false
+	err := updateTransferQueue(path, id, func(*QueuedTransfer) bool {
+		removed = true
+		return false
+	})
+	return removed, err
+}
+
+// updateTransferQueue applies update to the transfer with id in the queue at
+// path, removing it when update returns false. Others may add to the file
+// at any time, so every change rereads it.
+func updateTransferQueue(path, id string, update func(q *QueuedTransfer) bool) error {
+	queue, err := LoadTransferQueue(path)
+	if err != nil {
+		return err
+	}
+	for i, q := range queue {
+		if q.ID() != id {
+			continue
+		}
+		if !update(q) {
+			queue = append(queue[:i], queue[i+1:]...)
+		}
+		return SaveTransferQueue(path, queue)
+	}
+	return nil
+}
+
+// QueueFile queues a file for sending to peerID. It is sent as soon as the
+// peer is connected, now or after it reconnects, even if the node restarts
+// in between.
+func (mm *MessageManager) QueueFile(peerID peer.ID, filePath string) (*QueuedTransfer, error) {
+	q, err := NewQueuedTransfer(peerID.String(), filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	mm.queueMu.Lock()
+	err = EnqueueTransfer(mm.queuePath, q)
+	mm.queueMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue file: %w", err)
+	}
+
+	mm.logger.WithFields(logrus.Fields{
+		"transfer_id": q.ID(),
+		"peer_id":     q.PeerID,
+		"file_name":   q.Metadata.Name,
+	}).Info("File queued for sending")
+	mm.wakeTransferQueue()
+	return q, nil
+}
+
+// QueuedTransfers returns the files waiting to be sent, including those
+// being sent now
+func (mm *MessageManager) QueuedTransfers() []*QueuedTransfer {
+	mm.queueMu.Lock()
+	defer mm.queueMu.Unlock()
+
+	queue, err := LoadTransferQueue(mm.queuePath)
+	if err != nil {
+		mm.logger.WithError(err).Warn("Failed to load transfer queue")
+	}
+	return queue
+}
+
+// wakeTransferQueue checks the transfer queue now
+func (mm *MessageManager) wakeTransferQueue() {
+	select {
+	case mm.queueWake <- struct{}{}:
+	default:
+	}
+}
+
+// processTransferQueue starts queued transfers whose peer is connected
+func (mm *MessageManager) processTransferQueue() {
+	defer mm.wg.Done()
+
+	ticker := time.NewTicker(transferQueueInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mm.saveQueueProgress()
+			mm.startQueuedTransfers()
+		case <-mm.queueWake:
+			mm.startQueuedTransfers()
+		case <-mm.ctx.Done():
+			return
+		}
+	}
+}
+
+// startQueuedTransfers starts the queued transfers that are ready
+func (mm *MessageManager) startQueuedTransfers() {
+	mm.queueMu.Lock()
+	defer mm.queueMu.Unlock()
+
+	queue, err := LoadTransferQueue(mm.queuePath)
+	if err != nil {
+		mm.logger.WithError(err).Warn("Failed to load transfer queue")
+		return
+	}
+	for _, q := range queue {
+		if mm.queueActive[q.ID()] || !mm.queuedTransferReady(q) {
+			continue
+		}
+		mm.queueActive[q.ID()] = true
+		mm.wg.Add(1)
+		go mm.runQueuedTransfer(q)
+	}
+}
+
+// queuedTransferReady reports whether the peer of q is connected, and q
+// has not failed since the peer last connected unless that was long ago
+func (mm *MessageManager) queuedTransferReady(q *QueuedTransfer) bool {
+	peerID, err := peer.Decode(q.PeerID)
+	if err != nil || mm.host.Network().Connectedness(peerID) != network.Connected {
+		return false
+	}
+	if q.LastAttempt.IsZero() || time.Since(q.LastAttempt) > TransferQueueRetryDelay {
+		return true
+	}
+	for _, conn := range mm.host.Network().ConnsToPeer(peerID) {
+		if conn.Stat().Opened.After(q.LastAttempt) {
+			return true
+		}
+	}
+	return false
+}
+
+// runQueuedTransfer sends a queued file, taking it off the queue once it is
+// sent or cannot be
+func (mm *MessageManager) runQueuedTransfer(q *QueuedTransfer) {
+	defer mm.wg.Done()
+	defer func() {
+		mm.queueMu.Lock()
+		delete(mm.queueActive, q.ID())
+		mm.queueMu.Unlock()
+	}()
+
+	peerID, _ := peer.Decode(q.PeerID)
+	metadata := q.Metadata
+	info, err := os.Stat(q.Path)
+	if err == nil && (info.Size() != metadata.Size || !info.ModTime().Equal(metadata.Timestamp)) {
+		// The file changed since it was queued; send it as it is now
+		var changed *FileMetadata
+		if changed, err = CreateFileMetadata(q.Path); err == nil {
+			changed.ID = metadata.ID
+			metadata = *changed
+		}
+	}
+	if err != nil {
+		mm.finishQueuedTransfer(q, fmt.Errorf("failed to read %s: %w", q.Path, err))
+		return
+	}
+
+	if mm.OnQueuedTransferStarted != nil {
+		mm.OnQueuedTransferStarted(q)
+	}
+	ctx := WithTimeouts(mm.ctx, mm.TimeoutsFor(peerID))
+	err = mm.fileTransferManager.sendFile(ctx, mm.streamOpener(peerID, FileProtocolID), &metadata, q.Path, peerID)
+
+	_, refused := AsProtocolError(err)
+	switch {
+	case err == nil, refused, errors.Is(err, ErrTransferCancelled):
+		mm.finishQueuedTransfer(q, err)
+	default:
+		// Try again when the peer is back; the receiver keeps what it got
+		mm.updateQueuedTransfer(q.ID(), func(stored *QueuedTransfer) {
+			stored.Metadata = metadata
+			stored.BytesAcked = mm.transferAcked(q.ID())
+			if mm.ctx.Err() != nil {
+				return
+			}
+			stored.Attempts++
+			stored.LastAttempt = time.Now()
+			stored.LastError = err.Error()
+		})
+		if mm.ctx.Err() == nil {
+			mm.logger.WithError(err).WithField("transfer_id", q.ID()).Warn("Queued file transfer failed, will retry")
+		}
+	}
+}
+
+// finishQueuedTransfer takes q off the queue and reports the outcome
+func (mm *MessageManager) finishQueuedTransfer(q *QueuedTransfer, err error) {
+	mm.queueMu.Lock()
+	removeErr := updateTransferQueue(mm.queuePath, q.ID(), func(*QueuedTransfer) bool { return false })
+	mm.queueMu.Unlock()
+	if removeErr != nil {
+		mm.logger.WithError(removeErr).Warn("Failed to update transfer queue")
+	}
+
+	if mm.OnQueuedTransferDone != nil {
+		mm.OnQueuedTransferDone(q, err)
+	}
+}
+
+// updateQueuedTransfer applies update to the queued transfer with id
+func (mm *MessageManager) updateQueuedTransfer(id string, update func(q *QueuedTransfer)) {
+	mm.queueMu.Lock()
+	defer mm.queueMu.Unlock()
+
+	err := updateTransferQueue(mm.queuePath, id, func(q *QueuedTransfer) bool {
+		update(q)
+		return true
+	})
+	if err != nil {
+		mm.logger.WithError(err).Warn("Failed to update transfer queue")
+	}
+}
+
+// saveQueueProgress records how far the running queued transfers got
+func (mm *MessageManager) saveQueueProgress() {
+	mm.queueMu.Lock()
+	active := make([]string, 0, len(mm.queueActive))
+	for id := range mm.queueActive {
+		active = append(active, id)
+	}
+	mm.queueMu.Unlock()
+
+	for _, id := range active {
+		acked := mm.transferAcked(id)
+		mm.updateQueuedTransfer(id, func(q *QueuedTransfer) { q.BytesAcked = acked })
+	}
+}
+
+// transferAcked returns the bytes the receiver confirmed for transfer id
+func (mm *MessageManager) transferAcked(id string) int64 {
+	if transfer, ok := mm.fileTransferManager.GetTransfer(id); ok {
+		return transfer.BytesAcked
+	}
+	return 0
+}
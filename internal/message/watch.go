@@ -0,0 +1,241 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Watch timing
+const (
+	WatchDebounce       = 2 * time.Second  // A file must be unchanged this long before it is sent
+	WatchPollInterval   = 2 * time.Second  // Scan interval when change notifications are unavailable
+	WatchRescanInterval = time.Minute      // Full scan interval to catch missed notifications and retry failures
+	WatchMaxDelay       = 30 * time.Second // A file that never settles is sent after this long
+)
+
+// DefaultWatchExcludes skips hidden, temporary and partially written files
+var DefaultWatchExcludes = []string{".*", "*.part", "*.tmp", "*.swp", "*~"}
+
+// WatchOptions configures a DirWatcher
+type WatchOptions struct {
+	Include  []string      // Glob patterns of files to send; empty means all
+	Exclude  []string      // Glob patterns of files to skip
+	Debounce time.Duration // Defaults to WatchDebounce
+	Existing bool          // Also report files present when watching starts
+}
+
+// fileStamp identifies a version of a file without reading it
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// pendingFile is a changed file waiting to settle
+type pendingFile struct {
+	stamp     fileStamp
+	changedAt time.Time // Last time the stamp changed
+	firstSeen time.Time
+}
+
+// DirWatcher reports files in a directory tree that were created or changed,
+// once they stopped changing. Change notifications from the operating system
+// are used where available, with periodic scans as a fallback.
+type DirWatcher struct {
+	root   string
+	opts   WatchOptions
+	logger *logrus.Logger
+
+	PollInterval   time.Duration
+	RescanInterval time.Duration
+	MaxDelay       time.Duration
+
+	known   map[string]fileStamp // Last reported version of each file
+	pending map[string]*pendingFile
+}
+
+// NewDirWatcher creates a watcher for the directory tree at root
+func NewDirWatcher(root string, opts WatchOptions, logger *logrus.Logger) (*DirWatcher, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	for _, pattern := range append(opts.Include, opts.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = WatchDebounce
+	}
+
+	return &DirWatcher{
+		root:           root,
+		opts:           opts,
+		logger:         logger,
+		PollInterval:   WatchPollInterval,
+		RescanInterval: WatchRescanInterval,
+		MaxDelay:       WatchMaxDelay,
+		known:          make(map[string]fileStamp),
+		pending:        make(map[string]*pendingFile),
+	}, nil
+}
+
+// Matches reports whether a file, given relative to the watched directory,
+// passes the include and exclude filters. Patterns are matched against the
+// file name and against the relative path.
+func (w *DirWatcher) Matches(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+				return true
+			}
+			if ok, _ := filepath.Match(pattern, rel); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	dirs := strings.Split(rel, "/")
+	for _, dir := range dirs[:len(dirs)-1] {
+		if strings.HasPrefix(dir, ".") {
+			return false // Inside a hidden directory
+		}
+	}
+	if match(DefaultWatchExcludes) || match(w.opts.Exclude) {
+		return false
+	}
+	return len(w.opts.Include) == 0 || match(w.opts.Include)
+}
+
+// Run watches until ctx is done, calling send for every new or changed file.
+// A file whose send fails is retried on the next full scan.
+func (w *DirWatcher) Run(ctx context.Context, send func(path string) error) error {
+	events, err := newChangeNotifier(ctx, w.root, w.logger)
+	if err != nil {
+		w.logger.WithError(err).Info("Change notifications unavailable, polling for changes")
+		events = nil
+	}
+
+	// Files present at start are sent only if asked for
+	w.scan(!w.opts.Existing)
+
+	rescanInterval := w.RescanInterval
+	if events == nil {
+		rescanInterval = w.PollInterval
+	}
+	rescan := time.NewTicker(rescanInterval)
+	defer rescan.Stop()
+	settle := time.NewTicker(w.opts.Debounce / 4)
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case path, ok := <-events:
+			if !ok {
+				// Notifications stopped (e.g. the watch limit was hit)
+				events = nil
+				rescan.Reset(w.PollInterval)
+				continue
+			}
+			w.observe(path, false)
+		case <-rescan.C:
+			w.scan(false)
+		case <-settle.C:
+			w.flush(send)
+		}
+	}
+}
+
+// scan walks the tree and observes every file. With baseline set, files are
+// recorded as already reported.
+func (w *DirWatcher) scan(baseline bool) {
+	err := filepath.WalkDir(w.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip unreadable entries
+		}
+		if d.IsDir() && path != w.root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			w.observe(path, baseline)
+		}
+		return nil
+	})
+	if err != nil {
+		w.logger.WithError(err).Warn("Failed to scan watched directory")
+	}
+}
+
+// observe records the current state of a file
+func (w *DirWatcher) observe(path string, baseline bool) {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || !w.Matches(rel) {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		delete(w.pending, path)
+		delete(w.known, path)
+		return
+	}
+	stamp := fileStamp{size: info.Size(), modTime: info.ModTime()}
+
+	if baseline {
+		w.known[path] = stamp
+		return
+	}
+	if known, ok := w.known[path]; ok && known == stamp {
+		delete(w.pending, path)
+		return
+	}
+
+	now := time.Now()
+	p, ok := w.pending[path]
+	if !ok {
+		w.pending[path] = &pendingFile{stamp: stamp, changedAt: now, firstSeen: now}
+		return
+	}
+	if p.stamp != stamp {
+		p.stamp = stamp
+		p.changedAt = now
+	}
+}
+
+// flush sends pending files that have settled
+func (w *DirWatcher) flush(send func(path string) error) {
+	now := time.Now()
+	for path, p := range w.pending {
+		// Notifications may not arrive for every write, so check the file
+		// itself before deciding it settled
+		w.observe(path, false)
+		if w.pending[path] != p {
+			continue
+		}
+		if now.Sub(p.changedAt) < w.opts.Debounce && now.Sub(p.firstSeen) < w.MaxDelay {
+			continue
+		}
+
+		delete(w.pending, path)
+		if err := send(path); err != nil {
+			w.logger.WithError(err).WithField("path", path).Warn("Failed to send watched file")
+			continue
+		}
+		w.known[path] = p.stamp
+	}
+}
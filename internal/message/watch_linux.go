@@ -0,0 +1,140 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// inotifyMask selects the events that indicate new or changed files
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// inotifyWatcher reports changed paths in a directory tree using inotify
+type inotifyWatcher struct {
+	fd     int
+	file   *os.File
+	dirs   map[int32]string // Watched directories by watch descriptor
+	events chan string
+	logger *logrus.Logger
+}
+
+// newChangeNotifier returns a channel of paths that changed below root. The
+// channel is closed when ctx is done or notifications fail.
+func newChangeNotifier(ctx context.Context, root string, logger *logrus.Logger) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	// A non-blocking descriptor is handled by the runtime poller, so closing
+	// the file interrupts a pending read
+	w := &inotifyWatcher{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+		events: make(chan string, 256),
+		logger: logger,
+	}
+	if err := w.addTree(ctx, root, false); err != nil {
+		_ = w.file.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = w.file.Close()
+	}()
+	go w.read(ctx)
+
+	return w.events, nil
+}
+
+// addTree watches dir and its subdirectories, skipping hidden ones. With
+// report set, files already inside are reported as changed; they may have
+// been written before the watch was added.
+func (w *inotifyWatcher) addTree(ctx context.Context, dir string, report bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if report {
+				w.emit(ctx, path)
+			}
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// emit reports a changed path unless ctx is done
+func (w *inotifyWatcher) emit(ctx context.Context, path string) {
+	select {
+	case w.events <- path:
+	case <-ctx.Done():
+	}
+}
+
+// read decodes inotify events until the descriptor is closed
+func (w *inotifyWatcher) read(ctx context.Context) {
+	defer close(w.events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.WithError(err).Warn("Change notifications stopped")
+			}
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:start+int(event.Len)]), "\x00")
+			offset = start + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// The periodic scan picks up what was lost
+				w.logger.Debug("Change notification queue overflowed")
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, event.Wd) // Directory removed
+				continue
+			}
+			dir, ok := w.dirs[event.Wd]
+			if !ok || name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && !strings.HasPrefix(name, ".") {
+					if err := w.addTree(ctx, path, true); err != nil {
+						w.logger.WithError(err).Warn("Failed to watch new directory")
+					}
+				}
+				continue
+			}
+			w.emit(ctx, path)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package message
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newChangeNotifier is not available on this platform; watchers poll
+func newChangeNotifier(ctx context.Context, root string, logger *logrus.Logger) (<-chan string, error) {
+	return nil, fmt.Errorf("change notifications are not supported on this platform")
+}
@@ -0,0 +1,472 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// binaryFrame is a frame that has a protobuf encoding besides JSON, as
+// described in wire.proto
+type binaryFrame interface {
+	appendWire(b []byte) ([]byte, error)
+	unmarshalWire(data []byte) error
+}
+
+// isBinaryFrame reports whether a frame body is protobuf rather than JSON,
+// which always starts with '{'
+func isBinaryFrame(data []byte) bool {
+	return len(data) > 0 && data[0] != '{'
+}
+
+// unmarshalFrame decodes the body of a frame, which is protobuf if v has a
+// binary encoding and the body is not JSON
+func unmarshalFrame(data []byte, v interface{}) error {
+	if bf, ok := v.(binaryFrame); ok && isBinaryFrame(data) {
+		return bf.unmarshalWire(data)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// DecodeFrame decodes a whole length-prefixed frame as EncodeFrame or
+// EncodeBinaryFrame produce it
+func DecodeFrame(frame []byte, v interface{}) error {
+	if len(frame) < 4 {
+		return fmt.Errorf("frame has no length prefix")
+	}
+	if length := binary.BigEndian.Uint32(frame); int(length) != len(frame)-4 {
+		return fmt.Errorf("frame length prefix is %d, body is %d bytes", length, len(frame)-4)
+	}
+	if err := unmarshalFrame(frame[4:], v); err != nil {
+		return fmt.Errorf("invalid frame: %w", err)
+	}
+	return nil
+}
+
+// EncodeBinaryFrame returns v as peers that agreed on FeatureBinaryFrames
+// put it on the wire: a 4-byte big-endian length followed by the protobuf
+// encoding. Only messages and file protocol frames have one.
+func EncodeBinaryFrame(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := appendFrame(&buf, v, true); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFrame writes v as a length-prefixed frame, in protobuf if
+// binaryFrames is set, in JSON otherwise. The bytes are those EncodeFrame or
+// EncodeBinaryFrame return, encoded into a pooled buffer.
+func WriteFrame(w io.Writer, v interface{}, binaryFrames bool) error {
+	return writeFrameAs(w, v, binaryFrames)
+}
+
+// ReadFrame reads a length-prefixed frame of at most maxSize bytes in
+// either format, reading it into a pooled buffer
+func ReadFrame(r io.Reader, maxSize uint32, v interface{}) error {
+	return readFrame(r, maxSize, v)
+}
+
+// writeFrameAs writes a length-prefixed frame, in protobuf if binaryFrames
+// is set and v has a binary encoding, in JSON otherwise
+func writeFrameAs(w io.Writer, v interface{}, binaryFrames bool) error {
+	buf := getFrameBuffer()
+	defer putFrameBuffer(buf)
+	if err := appendFrame(buf, v, binaryFrames); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write data: %w", err)
+	}
+	return nil
+}
+
+// appendFrame appends v to buf as a length-prefixed frame
+func appendFrame(buf *bytes.Buffer, v interface{}, binaryFrames bool) error {
+	start := buf.Len()
+	buf.Write([]byte{0, 0, 0, 0})
+
+	if binaryFrames {
+		if request, ok := v.(FileTransferRequest); ok {
+			v = &request
+		}
+		bf, ok := v.(binaryFrame)
+		if !ok {
+			return fmt.Errorf("no binary encoding for %T", v)
+		}
+		// Encode straight into the buffer's free space
+		data, err := bf.appendWire(buf.AvailableBuffer())
+		if err != nil {
+			return fmt.Errorf("failed to marshal frame: %w", err)
+		}
+		buf.Write(data)
+	} else {
+		if err := json.NewEncoder(buf).Encode(v); err != nil {
+			return fmt.Errorf("failed to marshal frame: %w", err)
+		}
+		buf.Truncate(buf.Len() - 1) // Encode ends the value with a newline
+	}
+
+	binary.BigEndian.PutUint32(buf.Bytes()[start:], uint32(buf.Len()-start-4))
+	return nil
+}
+
+// wireEncoder appends protobuf fields, leaving out those with zero values
+// as proto3 does
+type wireEncoder struct {
+	buf []byte
+}
+
+func (e *wireEncoder) string(num protowire.Number, s string) {
+	if s == "" {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.BytesType)
+	e.buf = protowire.AppendString(e.buf, s)
+}
+
+func (e *wireEncoder) bytes(num protowire.Number, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.BytesType)
+	e.buf = protowire.AppendBytes(e.buf, b)
+}
+
+// message appends a nested message; nil leaves it out, while an empty one
+// is kept to tell it from an unset field
+func (e *wireEncoder) message(num protowire.Number, b []byte) {
+	if b == nil {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.BytesType)
+	e.buf = protowire.AppendBytes(e.buf, b)
+}
+
+func (e *wireEncoder) int(num protowire.Number, v int64) {
+	if v == 0 {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.VarintType)
+	e.buf = protowire.AppendVarint(e.buf, uint64(v))
+}
+
+func (e *wireEncoder) sint(num protowire.Number, v int64) {
+	if v == 0 {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.VarintType)
+	e.buf = protowire.AppendVarint(e.buf, protowire.EncodeZigZag(v))
+}
+
+func (e *wireEncoder) bool(num protowire.Number, v bool) {
+	if !v {
+		return
+	}
+	e.buf = protowire.AppendTag(e.buf, num, protowire.VarintType)
+	e.buf = protowire.AppendVarint(e.buf, 1)
+}
+
+// wireField is one field read from a protobuf message. Fields of the wrong
+// wire type read as zero values.
+type wireField struct {
+	num    protowire.Number
+	varint uint64
+	bytes  []byte
+}
+
+func (f wireField) string() string { return string(f.bytes) }
+func (f wireField) int() int64     { return int64(f.varint) }
+func (f wireField) sint() int64    { return protowire.DecodeZigZag(f.varint) }
+func (f wireField) bool() bool     { return f.varint != 0 }
+
+// readWireFields calls fn for each varint and length-delimited field of the
+// protobuf message in data; fields of other wire types are skipped
+func readWireFields(data []byte, fn func(f wireField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		f := wireField{num: num}
+		known := true
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			known = false
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if !known {
+			continue
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalWireTime encodes t with its UTC offset, or returns nil for the
+// zero time
+func marshalWireTime(t time.Time) []byte {
+	if t.IsZero() {
+		return nil
+	}
+	_, offset := t.Zone()
+	var e wireEncoder
+	e.int(1, t.Unix())
+	e.int(2, int64(t.Nanosecond()))
+	e.sint(3, int64(offset))
+	if e.buf == nil {
+		e.buf = []byte{}
+	}
+	return e.buf
+}
+
+// unmarshalWireTime decodes a time at the UTC offset it was taken at, so it
+// formats as it did on the sender
+func unmarshalWireTime(data []byte) (time.Time, error) {
+	var seconds, nanos, offset int64
+	err := readWireFields(data, func(f wireField) error {
+		switch f.num {
+		case 1:
+			seconds = f.int()
+		case 2:
+			nanos = f.int()
+		case 3:
+			offset = f.sint()
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	zone := time.UTC
+	if offset != 0 {
+		zone = time.FixedZone("", int(offset))
+	}
+	return time.Unix(seconds, nanos).In(zone), nil
+}
+
+// appendWire implements binaryFrame
+func (msg *Message) appendWire(b []byte) ([]byte, error) {
+	e := wireEncoder{buf: b}
+	e.string(1, msg.ID)
+	e.int(2, int64(msg.Type))
+	e.string(3, msg.From)
+	e.string(4, msg.To)
+	e.string(5, msg.GroupID)
+	if msg.Content != nil {
+		// Empty content is kept: the signature tells it from none
+		e.message(6, msg.Content)
+	}
+	if len(msg.Metadata) > 0 {
+		metadata, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		e.bytes(7, metadata)
+	}
+	e.message(8, marshalWireTime(msg.Timestamp))
+	e.bytes(9, msg.Signature)
+	e.bool(10, msg.IsEncrypted)
+	e.string(11, msg.TraceParent)
+	return e.buf, nil
+}
+
+// unmarshalWire implements binaryFrame
+func (msg *Message) unmarshalWire(data []byte) error {
+	*msg = Message{}
+	return readWireFields(data, func(f wireField) error {
+		var err error
+		switch f.num {
+		case 1:
+			msg.ID = f.string()
+		case 2:
+			msg.Type = MessageType(f.int())
+		case 3:
+			msg.From = f.string()
+		case 4:
+			msg.To = f.string()
+		case 5:
+			msg.GroupID = f.string()
+		case 6:
+			msg.Content = f.bytes
+			if msg.Content == nil {
+				msg.Content = []byte{}
+			}
+		case 7:
+			err = json.Unmarshal(f.bytes, &msg.Metadata)
+		case 8:
+			msg.Timestamp, err = unmarshalWireTime(f.bytes)
+		case 9:
+			msg.Signature = f.bytes
+		case 10:
+			msg.IsEncrypted = f.bool()
+		case 11:
+			msg.TraceParent = f.string()
+		}
+		return err
+	})
+}
+
+// marshalWire encodes the metadata of a file
+func (m *FileMetadata) marshalWire() []byte {
+	var e wireEncoder
+	e.string(1, m.ID)
+	e.string(2, m.Name)
+	e.int(3, m.Size)
+	e.string(4, m.Hash)
+	e.string(5, m.BLAKE3)
+	e.string(6, m.MimeType)
+	e.message(7, marshalWireTime(m.Timestamp))
+	e.int(8, int64(m.ChunkCount))
+	e.int(9, int64(m.ChunkSize))
+	e.string(10, m.SyncID)
+	e.string(11, m.Path)
+	return e.buf
+}
+
+// unmarshalWire decodes the metadata of a file
+func (m *FileMetadata) unmarshalWire(data []byte) error {
+	*m = FileMetadata{}
+	return readWireFields(data, func(f wireField) error {
+		var err error
+		switch f.num {
+		case 1:
+			m.ID = f.string()
+		case 2:
+			m.Name = f.string()
+		case 3:
+			m.Size = f.int()
+		case 4:
+			m.Hash = f.string()
+		case 5:
+			m.BLAKE3 = f.string()
+		case 6:
+			m.MimeType = f.string()
+		case 7:
+			m.Timestamp, err = unmarshalWireTime(f.bytes)
+		case 8:
+			m.ChunkCount = int(f.int())
+		case 9:
+			m.ChunkSize = int(f.int())
+		case 10:
+			m.SyncID = f.string()
+		case 11:
+			m.Path = f.string()
+		}
+		return err
+	})
+}
+
+// marshalWire encodes a file receipt
+func (r *FileReceipt) marshalWire() []byte {
+	var e wireEncoder
+	e.string(1, r.TransferID)
+	e.string(2, r.Hash)
+	e.string(3, r.BLAKE3)
+	e.int(4, r.Size)
+	e.string(5, r.Sender)
+	e.string(6, r.Receiver)
+	e.message(7, marshalWireTime(r.ReceivedAt))
+	e.bytes(8, r.Signature)
+	if e.buf == nil {
+		e.buf = []byte{}
+	}
+	return e.buf
+}
+
+// unmarshalWire decodes a file receipt
+func (r *FileReceipt) unmarshalWire(data []byte) error {
+	*r = FileReceipt{}
+	return readWireFields(data, func(f wireField) error {
+		var err error
+		switch f.num {
+		case 1:
+			r.TransferID = f.string()
+		case 2:
+			r.Hash = f.string()
+		case 3:
+			r.BLAKE3 = f.string()
+		case 4:
+			r.Size = f.int()
+		case 5:
+			r.Sender = f.string()
+		case 6:
+			r.Receiver = f.string()
+		case 7:
+			r.ReceivedAt, err = unmarshalWireTime(f.bytes)
+		case 8:
+			r.Signature = f.bytes
+		}
+		return err
+	})
+}
+
+// appendWire implements binaryFrame
+func (request *FileTransferRequest) appendWire(b []byte) ([]byte, error) {
+	e := wireEncoder{buf: b}
+	e.int(1, int64(request.Magic))
+	e.string(2, request.Type)
+	e.bytes(3, request.Metadata.marshalWire())
+	e.int(4, int64(request.ChunkID))
+	e.int(5, request.Offset)
+	e.bytes(6, request.Data)
+	e.string(7, request.Error)
+	e.string(8, string(request.Code))
+	if request.Receipt != nil {
+		e.message(9, request.Receipt.marshalWire())
+	}
+	e.int(10, int64(request.Streams))
+	e.string(11, request.Compression)
+	return e.buf, nil
+}
+
+// unmarshalWire implements binaryFrame
+func (request *FileTransferRequest) unmarshalWire(data []byte) error {
+	*request = FileTransferRequest{}
+	return readWireFields(data, func(f wireField) error {
+		var err error
+		switch f.num {
+		case 1:
+			request.Magic = uint32(f.varint)
+		case 2:
+			request.Type = f.string()
+		case 3:
+			err = request.Metadata.unmarshalWire(f.bytes)
+		case 4:
+			request.ChunkID = int(f.int())
+		case 5:
+			request.Offset = f.int()
+		case 6:
+			request.Data = f.bytes
+		case 7:
+			request.Error = f.string()
+		case 8:
+			request.Code = ErrorCode(f.string())
+		case 9:
+			request.Receipt = &FileReceipt{}
+			err = request.Receipt.unmarshalWire(f.bytes)
+		case 10:
+			request.Streams = int(f.int())
+		case 11:
+			request.Compression = f.string()
+		}
+		return err
+	})
+}
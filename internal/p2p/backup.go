@@ -0,0 +1,293 @@
+package p2p
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/tracing"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// BackupCheckInterval is how often the node checks whether a backup is due.
+// A failed backup is retried at the next check.
+const BackupCheckInterval = 10 * time.Minute
+
+// backupExcluded reports whether a path in the data directory is left out
+// of backups. Received files are too large to back up to a friend, the
+// database is copied separately, held backups belong to other peers and
+// logs and traces are only of use on this device.
+func backupExcluded(rel string) bool {
+	switch strings.SplitN(rel, string(filepath.Separator), 2)[0] {
+	case "downloads", message.AttachmentsDirName, message.HeldBackupsDirName,
+		"node_status.json", tracing.TraceFileName, db.DatabaseName, db.DatabaseName + "-wal", db.DatabaseName + "-shm":
+		return true
+	}
+	name := filepath.Base(rel)
+	return strings.HasPrefix(name, "peerchat") && strings.Contains(name, ".log") ||
+		strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part")
+}
+
+// WriteBackupSnapshot writes the state in dataDir to w as a gzipped tar
+// archive. The history is copied from the open database, which stays in use;
+// a history store that cannot be copied is left out.
+func WriteBackupSnapshot(dataDir string, history db.HistoryStore, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	add := func(name, path string, info fs.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		return err
+	}
+
+	err := filepath.WalkDir(dataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if backupExcluded(rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return add(rel, path, info)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive data directory: %w", err)
+	}
+
+	if history, ok := history.(db.HistoryBackup); ok {
+		tmpDir, err := os.MkdirTemp("", "xelvra-backup-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.RemoveAll(tmpDir)
+		}()
+
+		copyPath := filepath.Join(tmpDir, db.DatabaseName)
+		if err := history.BackupTo(copyPath); err != nil {
+			return err
+		}
+		info, err := os.Stat(copyPath)
+		if err != nil {
+			return err
+		}
+		if err := add(db.DatabaseName, copyPath, info); err != nil {
+			return fmt.Errorf("failed to archive history: %w", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// RestoreBackupSnapshot unpacks a snapshot written by WriteBackupSnapshot
+// into dataDir, replacing the files it contains, and returns how many files
+// were restored
+func RestoreBackupSnapshot(dataDir string, r io.Reader) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("backup is not a snapshot: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	// A stale write-ahead log would be replayed over the restored history
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(filepath.Join(dataDir, db.DatabaseName+suffix)); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+
+	restored := 0
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return restored, fmt.Errorf("backup is damaged: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return restored, fmt.Errorf("backup contains an unsafe path %q", header.Name)
+		}
+		path := filepath.Join(dataDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return restored, err
+		}
+
+		tmp := path + ".tmp"
+		file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return restored, err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp, path)
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			return restored, fmt.Errorf("failed to restore %s: %w", header.Name, err)
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// BackupNow snapshots this node's state, encrypts it and stores it on the
+// friend's node named in the backup settings
+func (n *PeerChatNode) BackupNow() (*message.BackupSettings, error) {
+	if n.config.DataDir == "" {
+		return nil, fmt.Errorf("backups need a data directory")
+	}
+	path := filepath.Join(n.config.DataDir, message.BackupFileName)
+	settings, err := message.LoadBackupSettings(path)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("backups are not set up")
+	}
+
+	size, backupErr := n.storeBackup(settings)
+
+	// The settings may have changed while the backup was made
+	if current, err := message.LoadBackupSettings(path); err == nil && current != nil {
+		settings = current
+	}
+	if backupErr != nil {
+		settings.LastError = backupErr.Error()
+	} else {
+		settings.LastBackup = time.Now()
+		settings.LastSize = size
+		settings.LastError = ""
+	}
+	if err := message.SaveBackupSettings(path, settings); err != nil {
+		n.logger.WithError(err).Warn("Failed to save backup status")
+	}
+	return settings, backupErr
+}
+
+// storeBackup sends one sealed snapshot and returns its size
+func (n *PeerChatNode) storeBackup(settings *message.BackupSettings) (int64, error) {
+	host, err := peer.Decode(settings.Host)
+	if err != nil {
+		return 0, fmt.Errorf("invalid backup peer: %w", err)
+	}
+	secrets, err := message.NewBackupSecrets(settings.Seed)
+	if err != nil {
+		return 0, err
+	}
+
+	var snapshot bytes.Buffer
+	if err := WriteBackupSnapshot(n.config.DataDir, n.history, &snapshot); err != nil {
+		return 0, err
+	}
+	sealed, err := secrets.Seal(snapshot.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if len(sealed) > message.MaxBackupSize {
+		return 0, fmt.Errorf("backup of %d MB is over the %d MB limit", len(sealed)>>20, message.MaxBackupSize>>20)
+	}
+
+	if err := n.messageManager.StoreBackup(host, secrets, sealed); err != nil {
+		return 0, err
+	}
+	return int64(len(sealed)), nil
+}
+
+// FetchBackup fetches the backup a recovery seed names from the peer
+// holding it and returns the decrypted snapshot
+func (n *PeerChatNode) FetchBackup(host peer.ID, seed []byte) ([]byte, error) {
+	secrets, err := message.NewBackupSecrets(seed)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := n.messageManager.FetchBackup(host, secrets)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Open(sealed)
+}
+
+// runBackups backs the node up to a friend whenever a backup is due. The
+// first check waits an interval so short-lived nodes started by CLI
+// commands do not back up; the settings are re-read on every check so the
+// CLI can change them.
+func (n *PeerChatNode) runBackups() {
+	ticker := time.NewTicker(BackupCheckInterval)
+	defer ticker.Stop()
+
+	path := filepath.Join(n.config.DataDir, message.BackupFileName)
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		settings, err := message.LoadBackupSettings(path)
+		if err != nil {
+			n.logger.WithError(err).Warn("Failed to load backup settings")
+			continue
+		}
+		if settings == nil || !settings.Due(time.Now()) {
+			continue
+		}
+		if settings, err := n.BackupNow(); err != nil {
+			n.logger.WithError(err).Warn("Backup failed, will retry")
+		} else {
+			n.logger.WithField("size", settings.LastSize).Info("Backup stored")
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// BandwidthFileName holds the traffic exchanged with each peer, totalled
+	// across runs
+	BandwidthFileName = "bandwidth.json"
+
+	// bandwidthSaveInterval is how often the running node saves the totals
+	bandwidthSaveInterval = 30 * time.Second
+)
+
+// PeerTraffic is the traffic exchanged with a peer. Rates are bytes per
+// second averaged over the last few seconds, as of when they were read.
+type PeerTraffic struct {
+	PeerID   string    `json:"peer_id"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	RateIn   float64   `json:"rate_in,omitempty"`
+	RateOut  float64   `json:"rate_out,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Total returns the bytes exchanged in both directions
+func (t PeerTraffic) Total() int64 {
+	return t.BytesIn + t.BytesOut
+}
+
+// BandwidthBook meters the traffic of every peer through libp2p and adds it
+// to the totals of earlier runs
+type BandwidthBook struct {
+	path    string
+	counter *metrics.BandwidthCounter
+
+	// Totals saved by earlier runs, never changed; the counter holds this
+	// run's traffic
+	base map[string]PeerTraffic
+}
+
+// NewBandwidthBook creates a bandwidth book adding to the totals saved at
+// path; an empty path keeps them for this run only
+func NewBandwidthBook(path string) (*BandwidthBook, error) {
+	base := make(map[string]PeerTraffic)
+	if path != "" {
+		saved, err := LoadBandwidth(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range saved {
+			base[t.PeerID] = t
+		}
+	}
+	return &BandwidthBook{path: path, counter: metrics.NewBandwidthCounter(), base: base}, nil
+}
+
+// Counter returns the reporter to hand to libp2p
+func (b *BandwidthBook) Counter() *metrics.BandwidthCounter {
+	return b.counter
+}
+
+// Traffic returns the traffic of every peer, the busiest first
+func (b *BandwidthBook) Traffic() []PeerTraffic {
+	merged := make(map[string]PeerTraffic, len(b.base))
+	for id, t := range b.base {
+		merged[id] = t
+	}
+
+	now := time.Now()
+	for id, stats := range b.counter.GetBandwidthByPeer() {
+		t := merged[id.String()]
+		t.PeerID = id.String()
+		t.BytesIn += stats.TotalIn
+		t.BytesOut += stats.TotalOut
+		t.RateIn, t.RateOut = stats.RateIn, stats.RateOut
+		if stats.TotalIn > 0 || stats.TotalOut > 0 {
+			t.Updated = now
+		}
+		merged[id.String()] = t
+	}
+	return sortTraffic(merged)
+}
+
+// Peer returns the traffic exchanged with one peer
+func (b *BandwidthBook) Peer(id peer.ID) PeerTraffic {
+	t := b.base[id.String()]
+	t.PeerID = id.String()
+	stats := b.counter.GetBandwidthForPeer(id)
+	t.BytesIn += stats.TotalIn
+	t.BytesOut += stats.TotalOut
+	t.RateIn, t.RateOut = stats.RateIn, stats.RateOut
+	if stats.TotalIn > 0 || stats.TotalOut > 0 {
+		t.Updated = time.Now()
+	}
+	return t
+}
+
+// Save writes the totals, with this run's traffic, to the book's path
+func (b *BandwidthBook) Save() error {
+	if b.path == "" {
+		return nil
+	}
+	return SaveBandwidth(b.path, b.Traffic())
+}
+
+// sortTraffic returns the traffic of each peer, the busiest first
+func sortTraffic(traffic map[string]PeerTraffic) []PeerTraffic {
+	sorted := make([]PeerTraffic, 0, len(traffic))
+	for _, t := range traffic {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Total() != sorted[j].Total() {
+			return sorted[i].Total() > sorted[j].Total()
+		}
+		return sorted[i].PeerID < sorted[j].PeerID
+	})
+	return sorted
+}
+
+// LoadBandwidth reads the traffic totals saved at path, the busiest peer
+// first
+func LoadBandwidth(path string) ([]PeerTraffic, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var traffic []PeerTraffic
+	if err := json.Unmarshal(data, &traffic); err != nil {
+		return nil, fmt.Errorf("failed to parse bandwidth totals: %w", err)
+	}
+	byPeer := make(map[string]PeerTraffic, len(traffic))
+	for _, t := range traffic {
+		byPeer[t.PeerID] = t
+	}
+	return sortTraffic(byPeer), nil
+}
+
+// SaveBandwidth writes traffic totals to path
+func SaveBandwidth(path string, traffic []PeerTraffic) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(traffic, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// saveBandwidth stores the traffic totals
+func (n *PeerChatNode) saveBandwidth() {
+	if err := n.bandwidth.Save(); err != nil {
+		n.logger.WithError(err).Warn("Failed to save bandwidth totals")
+	}
+}
+
+// runBandwidthSaver saves the traffic totals regularly, so status can show
+// them and a crash loses little
+func (n *PeerChatNode) runBandwidthSaver() {
+	ticker := time.NewTicker(bandwidthSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.saveBandwidth()
+		}
+	}
+}
+
+// PeerTraffic returns the traffic exchanged with a peer, across runs
+func (n *PeerChatNode) PeerTraffic(id peer.ID) PeerTraffic {
+	return n.bandwidth.Peer(id)
+}
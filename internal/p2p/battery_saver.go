@@ -0,0 +1,39 @@
+package p2p
+
+// runDHTRefresh refreshes the DHT routing tables every DHTRefreshInterval,
+// the DHTs being created without refreshes of their own. In battery saver
+// mode the refreshes come with the other periodic work and are skipped
+// while the node is idle.
+func (n *PeerChatNode) runDHTRefresh() {
+	// The routing DHT has no refresh of its own at start
+	if n.routingDHT != nil {
+		if err := n.routingDHT.Bootstrap(n.ctx); err != nil {
+			n.logger.WithError(err).Debug("Failed to refresh the routing DHT")
+		}
+	}
+
+	for n.energyManager.Wait(n.ctx, DHTRefreshInterval) {
+		if n.energyManager.SuspendRefreshes() {
+			n.logger.Debug("Node idle in battery saver mode, skipping DHT refresh")
+			continue
+		}
+		if n.routingDHT != nil {
+			if err := n.routingDHT.Bootstrap(n.ctx); err != nil {
+				n.logger.WithError(err).Debug("Failed to refresh the routing DHT")
+			}
+		}
+		n.discoveryManager.refreshDHTs()
+	}
+}
+
+// SetBatterySaver turns battery saver mode on or off. It lengthens
+// discovery intervals, moves periodic work and keep-alives to shared
+// wake-ups, and suspends DHT refreshes while the node is idle.
+func (n *PeerChatNode) SetBatterySaver(on bool) {
+	n.energyManager.SetBatterySaver(on)
+}
+
+// BatterySaver reports whether battery saver mode is on
+func (n *PeerChatNode) BatterySaver() bool {
+	return n.energyManager.BatterySaver()
+}
@@ -0,0 +1,157 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// BeaconPrefix marks a signed presence beacon on the LAN broadcast port
+	BeaconPrefix = "XELVRA_BEACON:"
+
+	// Beacon timing. A beacon older than BeaconMaxAge, or dated further than
+	// that in the future, is rejected.
+	BeaconInterval = 30 * time.Second
+	BeaconMaxAge   = 2 * time.Minute
+)
+
+// PresenceBeacon announces a peer on the local network. It is signed by the
+// peer's key and carries a timestamp and a sequence number, so a recorded
+// beacon cannot be replayed later to fake presence.
+type PresenceBeacon struct {
+	PeerID    string    `json:"peer_id"`
+	Sequence  uint64    `json:"seq"`
+	Timestamp time.Time `json:"ts"`
+	Signature []byte    `json:"sig"`
+}
+
+// NewPresenceBeacon creates a beacon signed with key
+func NewPresenceBeacon(key crypto.PrivKey, sequence uint64, now time.Time) (*PresenceBeacon, error) {
+	peerID, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &PresenceBeacon{
+		PeerID:    peerID.String(),
+		Sequence:  sequence,
+		Timestamp: now.UTC(),
+	}
+	if b.Signature, err = key.Sign(b.payload()); err != nil {
+		return nil, fmt.Errorf("failed to sign beacon: %w", err)
+	}
+
+	return b, nil
+}
+
+// payload returns the bytes covered by the signature
+func (b *PresenceBeacon) payload() []byte {
+	return []byte(strings.Join([]string{
+		"xelvra-beacon",
+		b.PeerID,
+		strconv.FormatUint(b.Sequence, 10),
+		b.Timestamp.UTC().Format(time.RFC3339Nano),
+	}, "|"))
+}
+
+// Marshal encodes the beacon for broadcasting
+func (b *PresenceBeacon) Marshal() ([]byte, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(BeaconPrefix), data...), nil
+}
+
+// ParsePresenceBeacon decodes a broadcast beacon without verifying it
+func ParsePresenceBeacon(data []byte) (*PresenceBeacon, error) {
+	if !strings.HasPrefix(string(data), BeaconPrefix) {
+		return nil, fmt.Errorf("not a presence beacon")
+	}
+
+	var b PresenceBeacon
+	if err := json.Unmarshal(data[len(BeaconPrefix):], &b); err != nil {
+		return nil, fmt.Errorf("failed to parse beacon: %w", err)
+	}
+	return &b, nil
+}
+
+// Verify checks the signature and that the beacon is fresh at now
+func (b *PresenceBeacon) Verify(now time.Time) (peer.ID, error) {
+	peerID, err := peer.Decode(b.PeerID)
+	if err != nil {
+		return "", fmt.Errorf("invalid peer ID: %w", err)
+	}
+	pubKey, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return "", fmt.Errorf("beacon peer ID does not embed a public key")
+	}
+
+	ok, err := pubKey.Verify(b.payload(), b.Signature)
+	if err != nil || !ok {
+		return "", fmt.Errorf("beacon signature verification failed")
+	}
+
+	age := now.Sub(b.Timestamp)
+	if age > BeaconMaxAge || age < -BeaconMaxAge {
+		return "", fmt.Errorf("beacon timestamp out of range (%s)", age.Round(time.Second))
+	}
+
+	return peerID, nil
+}
+
+// BeaconTracker accepts each peer's beacons only in increasing sequence
+// order. Senders seed their sequence from the clock, so it keeps increasing
+// across restarts.
+type BeaconTracker struct {
+	mu   sync.Mutex
+	seen map[peer.ID]beaconSeen
+}
+
+type beaconSeen struct {
+	sequence uint64
+	at       time.Time
+}
+
+// NewBeaconTracker creates an empty tracker
+func NewBeaconTracker() *BeaconTracker {
+	return &BeaconTracker{seen: make(map[peer.ID]beaconSeen)}
+}
+
+// Accept parses and verifies a broadcast beacon and rejects replays of one
+// already seen. It returns the announced peer.
+func (t *BeaconTracker) Accept(data []byte, now time.Time) (peer.ID, error) {
+	b, err := ParsePresenceBeacon(data)
+	if err != nil {
+		return "", err
+	}
+	peerID, err := b.Verify(now)
+	if err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Entries older than the freshness window cannot be replayed against
+	// anyway, so forget them
+	for id, last := range t.seen {
+		if now.Sub(last.at) > 2*BeaconMaxAge {
+			delete(t.seen, id)
+		}
+	}
+
+	if last, ok := t.seen[peerID]; ok && b.Sequence <= last.sequence {
+		return "", fmt.Errorf("replayed beacon (sequence %d, last %d)", b.Sequence, last.sequence)
+	}
+	t.seen[peerID] = beaconSeen{sequence: b.Sequence, at: now}
+
+	return peerID, nil
+}
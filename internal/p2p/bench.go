@@ -0,0 +1,326 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// Self-benchmark settings
+const (
+	DefaultBenchMessages = 200
+	MaxBenchMessages     = 100000
+	DefaultBenchFileSize = 16 << 20
+	MaxBenchFileSize     = 1 << 30
+
+	benchMessageSize = 256              // Bytes of text in each message
+	benchWindow      = 50               // Messages in flight in the throughput run
+	benchTimeout     = 30 * time.Second // For one message to arrive
+)
+
+// BenchTransports are the loopback transports RunBench can use
+var BenchTransports = []string{"tcp", "quic"}
+
+// BenchOptions configures RunBench
+type BenchOptions struct {
+	Messages  int    // Messages in each of the latency and throughput runs
+	FileSize  int64  // Size of the file transferred; zero skips the transfer
+	Transport string // One of BenchTransports
+
+	// Progress, if set, is told about each stage as it starts
+	Progress func(stage string)
+}
+
+// BenchReport is what RunBench measured
+type BenchReport struct {
+	Transport string `json:"transport"`
+
+	// One message at a time, from sending to the receiver's handler
+	Messages   int           `json:"messages"`
+	LatencyMin time.Duration `json:"latency_min_ns"`
+	LatencyAvg time.Duration `json:"latency_avg_ns"`
+	LatencyP95 time.Duration `json:"latency_p95_ns"`
+	LatencyMax time.Duration `json:"latency_max_ns"`
+
+	// benchWindow messages in flight at a time
+	MessagesPerSecond float64 `json:"messages_per_second"`
+
+	FileSize           int64         `json:"file_size,omitempty"`
+	FileDuration       time.Duration `json:"file_duration_ns,omitempty"`
+	FileBytesPerSecond float64       `json:"file_bytes_per_second,omitempty"`
+
+	// Resident memory of the process with both nodes up, before any traffic
+	// and after the runs; RSSApprox is set where the runtime's figure stands
+	// in for it
+	IdleRSSBytes uint64 `json:"idle_rss_bytes"`
+	PeakRSSBytes uint64 `json:"peak_rss_bytes"`
+	RSSApprox    bool   `json:"rss_approx,omitempty"`
+}
+
+// MeetsLatencyTarget reports whether the average latency is within
+// MaxLatencyMs
+func (r *BenchReport) MeetsLatencyTarget() bool {
+	return r.Messages > 0 && r.LatencyAvg <= MaxLatencyMs*time.Millisecond
+}
+
+// MeetsMemoryTarget reports whether the idle process stayed within
+// MaxIdleMemoryMB for each of its two nodes
+func (r *BenchReport) MeetsMemoryTarget() bool {
+	return r.IdleRSSBytes <= 2*MaxIdleMemoryMB<<20
+}
+
+// benchNode is one side of the benchmark
+type benchNode struct {
+	host    host.Host
+	manager *message.MessageManager
+}
+
+// benchHandler passes the sequence numbers of received messages on
+type benchHandler struct {
+	arrived chan int
+}
+
+// HandleMessage implements message.MessageHandler
+func (h *benchHandler) HandleMessage(_ context.Context, msg *message.Message) error {
+	seq, _, _ := strings.Cut(string(msg.Content), " ")
+	if n, err := strconv.Atoi(seq); err == nil {
+		h.arrived <- n
+	}
+	return nil
+}
+
+// RunBench starts two nodes in this process, connects them over loopback
+// and measures message latency, message throughput and file transfer
+// speed. The nodes keep their queues and the received file in
+// user.DataDir(), which callers point at a scratch directory.
+func RunBench(ctx context.Context, opts BenchOptions) (*BenchReport, error) {
+	if opts.Messages < 1 || opts.Messages > MaxBenchMessages {
+		return nil, fmt.Errorf("messages must be between 1 and %d", MaxBenchMessages)
+	}
+	if opts.FileSize < 0 || opts.FileSize > MaxBenchFileSize {
+		return nil, fmt.Errorf("file size must be at most %d bytes", int64(MaxBenchFileSize))
+	}
+	if opts.Transport == "" {
+		opts.Transport = "tcp"
+	}
+	listen := map[string]string{"tcp": "/ip4/127.0.0.1/tcp/0", "quic": "/ip4/127.0.0.1/udp/0/quic-v1"}[opts.Transport]
+	if listen == "" {
+		return nil, fmt.Errorf("unknown transport %q, use %s", opts.Transport, strings.Join(BenchTransports, " or "))
+	}
+	progress := func(stage string) {
+		if opts.Progress != nil {
+			opts.Progress(stage)
+		}
+	}
+
+	progress("Starting two nodes over loopback " + opts.Transport)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	sender, err := newBenchNode(listen, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer sender.close()
+	receiver, err := newBenchNode(listen, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer receiver.close()
+
+	handler := &benchHandler{arrived: make(chan int, benchWindow)}
+	receiver.manager.RegisterHandler(message.MessageTypeText, handler)
+	receiver.manager.SetFileOfferHandler(func(offer *message.FileOffer) {
+		go func() { _, _ = receiver.manager.AnswerFileOffer(offer.Number, true) }()
+	})
+	if err := sender.host.Connect(ctx, peer.AddrInfo{ID: receiver.host.ID(), Addrs: receiver.host.Addrs()}); err != nil {
+		return nil, fmt.Errorf("failed to connect the nodes: %w", err)
+	}
+	to := receiver.host.ID().String()
+
+	// Agree on capabilities and open the message stream before measuring
+	if _, err := sender.manager.PeerCapabilities(receiver.host.ID()); err != nil {
+		return nil, fmt.Errorf("capability handshake failed: %w", err)
+	}
+	if err := benchSend(ctx, sender.manager, to, -1); err != nil {
+		return nil, err
+	}
+	if err := benchAwait(ctx, handler.arrived, -1); err != nil {
+		return nil, err
+	}
+
+	report := &BenchReport{Transport: opts.Transport, Messages: opts.Messages}
+	report.IdleRSSBytes, report.RSSApprox = ProcessMemory()
+
+	progress(fmt.Sprintf("Measuring latency over %d messages", opts.Messages))
+	latencies := make([]time.Duration, 0, opts.Messages)
+	for i := 0; i < opts.Messages; i++ {
+		started := time.Now()
+		if err := benchSend(ctx, sender.manager, to, i); err != nil {
+			return nil, err
+		}
+		if err := benchAwait(ctx, handler.arrived, i); err != nil {
+			return nil, err
+		}
+		latencies = append(latencies, time.Since(started))
+	}
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	report.LatencyMin = latencies[0]
+	report.LatencyAvg = total / time.Duration(len(latencies))
+	report.LatencyP95 = latencies[(len(latencies)*95+99)/100-1]
+	report.LatencyMax = latencies[len(latencies)-1]
+
+	progress(fmt.Sprintf("Measuring throughput over %d messages", opts.Messages))
+	started := time.Now()
+	sent, received := 0, 0
+	for received < opts.Messages {
+		for sent < opts.Messages && sent-received < benchWindow {
+			if err := benchSend(ctx, sender.manager, to, sent); err != nil {
+				return nil, err
+			}
+			sent++
+		}
+		select {
+		case <-handler.arrived:
+			received++
+		case <-time.After(benchTimeout):
+			return nil, fmt.Errorf("only %d of %d messages arrived", received, opts.Messages)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	report.MessagesPerSecond = float64(opts.Messages) / time.Since(started).Seconds()
+
+	if opts.FileSize > 0 {
+		progress(fmt.Sprintf("Transferring a file of %d bytes", opts.FileSize))
+		path, err := writeBenchFile(opts.FileSize)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = os.Remove(path) }()
+
+		started := time.Now()
+		if err := sender.manager.SendFile(receiver.host.ID(), path); err != nil {
+			return nil, fmt.Errorf("file transfer failed: %w", err)
+		}
+		report.FileSize = opts.FileSize
+		report.FileDuration = time.Since(started)
+		report.FileBytesPerSecond = float64(opts.FileSize) / report.FileDuration.Seconds()
+	}
+
+	report.PeakRSSBytes, _ = ProcessMemory()
+	return report, nil
+}
+
+// newBenchNode starts a host with a new identity listening on listen and a
+// message manager on it
+func newBenchNode(listen string, logger *logrus.Logger) (*benchNode, error) {
+	identity, err := user.GenerateMessengerID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity: %w", err)
+	}
+	key, err := crypto.UnmarshalEd25519PrivateKey(identity.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert identity: %w", err)
+	}
+	h, err := libp2p.New(libp2p.Identity(key), libp2p.ListenAddrStrings(listen))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start node: %w", err)
+	}
+
+	manager := message.NewMessageManager(h, identity, logger)
+	if err := manager.Start(); err != nil {
+		_ = h.Close()
+		return nil, fmt.Errorf("failed to start message manager: %w", err)
+	}
+	return &benchNode{host: h, manager: manager}, nil
+}
+
+// close stops the node
+func (n *benchNode) close() {
+	_ = n.manager.Stop()
+	_ = n.host.Close()
+}
+
+// benchSend queues message seq, padded to benchMessageSize
+func benchSend(ctx context.Context, manager *message.MessageManager, to string, seq int) error {
+	text := strconv.Itoa(seq) + " "
+	text += strings.Repeat("x", max(0, benchMessageSize-len(text)))
+	if err := manager.SendMessage(to, []byte(text), message.MessageTypeText); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return ctx.Err()
+}
+
+// benchAwait waits for message seq to arrive
+func benchAwait(ctx context.Context, arrived <-chan int, seq int) error {
+	timer := time.NewTimer(benchTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case n := <-arrived:
+			if n == seq {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("message %d did not arrive within %s", seq, benchTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// writeBenchFile writes size random bytes to a file in the data directory
+func writeBenchFile(size int64) (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dataDir, "bench-*.bin")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file to send: %w", err)
+	}
+	_, err = io.CopyN(file, rand.Reader, size)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write file to send: %w", err)
+	}
+	return filepath.Clean(file.Name()), nil
+}
+
+// ProcessMemory returns the resident memory of the process, or the memory
+// the runtime obtained from the system where that is unknown, reporting
+// whether it is the latter
+func ProcessMemory() (uint64, bool) {
+	if rss, ok := processRSS(); ok {
+		return rss, false
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return mem.Sys, true
+}
@@ -0,0 +1,198 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// bootstrapCheckInterval is how often the bootstrap peers are checked
+	bootstrapCheckInterval = 5 * time.Minute
+
+	// bootstrapDialTimeout bounds connecting to one bootstrap peer
+	bootstrapDialTimeout = 10 * time.Second
+)
+
+// defaultBootstrapAddrs are the IPFS bootstrap peers, which run compatible
+// DHT and relay services
+var defaultBootstrapAddrs = []string{
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zp9FCS47PpbUANZBTokb6BPWjkp8Bk",
+	"/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+	// Additional relay servers for better NAT traversal
+	"/ip4/147.75.77.187/tcp/4001/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
+	"/ip4/147.75.195.153/tcp/4001/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+}
+
+// DefaultBootstrapAddrs returns the bootstrap peers used when none are
+// configured
+func DefaultBootstrapAddrs() []string {
+	return append([]string(nil), defaultBootstrapAddrs...)
+}
+
+// getBootstrapPeers returns the default bootstrap peers for DHT
+func getBootstrapPeers() []peer.AddrInfo {
+	peers, _ := ParseBootstrapPeers(defaultBootstrapAddrs)
+	return peers
+}
+
+// ParseBootstrapPeers parses bootstrap addresses, each ending in the
+// /p2p/ ID of the peer; addresses of one peer are merged
+func ParseBootstrapPeers(addrs []string) ([]peer.AddrInfo, error) {
+	parsed := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		m, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap address %q: %w", addr, err)
+		}
+		if _, err := m.ValueForProtocol(ma.P_P2P); err != nil {
+			return nil, fmt.Errorf("bootstrap address %q does not end in /p2p/<peer ID>", addr)
+		}
+		parsed = append(parsed, m)
+	}
+	return peer.AddrInfosFromP2pAddrs(parsed...)
+}
+
+// peerIDStrings returns the IDs of peers
+func peerIDStrings(peers []peer.AddrInfo) []string {
+	ids := make([]string, len(peers))
+	for i, info := range peers {
+		ids[i] = info.ID.String()
+	}
+	return ids
+}
+
+// BootstrapHealth is the outcome of the last attempt to reach a bootstrap
+// peer
+type BootstrapHealth struct {
+	PeerID      string        `json:"peer_id"`
+	Reachable   bool          `json:"reachable"`
+	RTT         time.Duration `json:"rtt,omitempty"` // Time to connect, when measured
+	Error       string        `json:"error,omitempty"`
+	LastChecked time.Time     `json:"last_checked"`
+}
+
+// CheckBootstrapPeers connects to each bootstrap peer from a temporary host
+// and reports which can be reached and how fast. With a swarm key the
+// peers are dialed as members of its private network.
+func CheckBootstrapPeers(ctx context.Context, peers []peer.AddrInfo, psk pnet.PSK) ([]BootstrapHealth, error) {
+	opts := []libp2p.Option{libp2p.NoListenAddrs, libp2p.DisableRelay()}
+	if len(psk) > 0 {
+		opts = append(opts, libp2p.Transport(tcp.NewTCPTransport), libp2p.PrivateNetwork(psk))
+	}
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host: %w", err)
+	}
+	defer func() { _ = h.Close() }()
+
+	health := make([]BootstrapHealth, len(peers))
+	var wg sync.WaitGroup
+	for i, info := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialCtx, cancel := context.WithTimeout(ctx, bootstrapDialTimeout)
+			defer cancel()
+
+			started := time.Now()
+			err := h.Connect(dialCtx, info)
+			health[i] = BootstrapHealth{PeerID: info.ID.String(), Reachable: err == nil, LastChecked: time.Now()}
+			if err != nil {
+				health[i].Error = err.Error()
+			} else {
+				health[i].RTT = time.Since(started)
+			}
+		}()
+	}
+	wg.Wait()
+	return health, nil
+}
+
+// SetBootstrapFallback sets the peers the DHT joins through when none of
+// the bootstrap peers can be reached. It must be called before Start.
+func (dm *DiscoveryManager) SetBootstrapFallback(peers []peer.AddrInfo) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.bootstrapFallback = peers
+}
+
+// maintainBootstrap connects to the bootstrap peers and checks them again
+// periodically. When none can be reached the fallback peers are used until
+// one of the bootstrap peers comes back.
+func (dm *DiscoveryManager) maintainBootstrap() {
+	for {
+		dm.checkBootstrap()
+		if !dm.energy.Wait(dm.ctx, bootstrapCheckInterval) {
+			return
+		}
+	}
+}
+
+// checkBootstrap connects to every bootstrap peer not connected yet and
+// records which are reachable, falling back when none is
+func (dm *DiscoveryManager) checkBootstrap() {
+	dm.mu.RLock()
+	peers, fallback := dm.bootstrapPeers, dm.bootstrapFallback
+	dm.mu.RUnlock()
+
+	health, reachable := dm.connectToBootstrapPeers(peers)
+	usingFallback := reachable == 0 && len(peers) > 0 && len(fallback) > 0
+	if usingFallback {
+		dm.logger.WithField("configured", len(peers)).Warn("No configured bootstrap peer is reachable, falling back to the default ones")
+		dm.connectToBootstrapPeers(fallback)
+	}
+
+	dm.mu.Lock()
+	if dm.status.BootstrapFallback && !usingFallback {
+		dm.logger.Info("Configured bootstrap peers reachable again")
+	}
+	dm.status.BootstrapHealth = health
+	dm.status.BootstrapFallback = usingFallback
+	dm.mu.Unlock()
+}
+
+// connectToBootstrapPeers connects the DHT host to each bootstrap peer and
+// reports which could be reached, and how many
+func (dm *DiscoveryManager) connectToBootstrapPeers(peers []peer.AddrInfo) ([]BootstrapHealth, int) {
+	health := make([]BootstrapHealth, 0, len(peers))
+	reachable := 0
+	for _, info := range peers {
+		h := BootstrapHealth{PeerID: info.ID.String(), Reachable: true}
+		if dm.dhtHost.Network().Connectedness(info.ID) != network.Connected {
+			ctx, cancel := context.WithTimeout(dm.ctx, bootstrapDialTimeout)
+			started := time.Now()
+			err := dm.dhtHost.Connect(ctx, info)
+			cancel()
+			if err != nil {
+				h.Reachable, h.Error = false, err.Error()
+				dm.logger.WithError(err).WithField("peer_id", info.ID.String()).Debug("Failed to connect to bootstrap peer")
+			} else {
+				h.RTT = time.Since(started)
+				dm.logger.WithField("peer_id", info.ID.String()).Info("Connected to bootstrap peer")
+			}
+		}
+		h.LastChecked = time.Now()
+		if h.Reachable {
+			reachable++
+		}
+		health = append(health, h)
+	}
+
+	dm.logger.WithFields(logrus.Fields{
+		"connected": reachable,
+		"total":     len(peers),
+	}).Info("Bootstrap peer connection completed")
+	return health, reachable
+}
@@ -0,0 +1,92 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const (
+	// ConfigEnvPrefix starts the environment variables that override the
+	// settings of the configuration file: discovery.mdns is read from
+	// XELVRA_DISCOVERY_MDNS, listen from XELVRA_LISTEN
+	ConfigEnvPrefix = "XELVRA_"
+
+	// LogLevelEnv is a shorter name for XELVRA_LOGGING_LEVEL, which wins
+	// when both are set
+	LogLevelEnv = "XELVRA_LOG_LEVEL"
+)
+
+// ErrConfigEnvironment is wrapped by the errors of settings the
+// environment overrides
+var ErrConfigEnvironment = errors.New("environment")
+
+// configEnvAliases are the variables also read for a setting
+var configEnvAliases = map[string]string{
+	"logging.level": LogLevelEnv,
+}
+
+// ConfigEnvName returns the environment variable overriding the setting
+// key, or "" for swarm_key, which SwarmKeyEnv already covers as a path or
+// the key itself
+func ConfigEnvName(key string) string {
+	if key == "swarm_key" {
+		return ""
+	}
+	return ConfigEnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ConfigEnvOverride returns the environment variable that overrides the
+// setting key right now, or "" if none is set
+func ConfigEnvOverride(key string) string {
+	names := []string{ConfigEnvName(key)}
+	if alias, ok := configEnvAliases[key]; ok {
+		names = append(names, alias)
+	}
+	for _, name := range names {
+		if name != "" && strings.TrimSpace(os.Getenv(name)) != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// applyEnvironment sets the settings the environment overrides, noting
+// them in Overrides. The entries of a list are separated by commas or
+// spaces.
+func (c *ConfigFile) applyEnvironment() error {
+	for _, key := range ConfigKeys() {
+		name := ConfigEnvOverride(key)
+		if name == "" {
+			continue
+		}
+		field, err := configField(c, key)
+		if err != nil {
+			return err
+		}
+		raw := strings.TrimSpace(os.Getenv(name))
+		args := []string{raw}
+		if field.Kind() == reflect.Slice {
+			args = strings.FieldsFunc(raw, func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})
+		}
+		value, err := ParseConfigValue(key, args)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		field.Set(reflect.ValueOf(value))
+
+		if c.Overrides == nil {
+			c.Overrides = make(map[string]string)
+		}
+		c.Overrides[key] = name
+	}
+	if len(c.Overrides) == 0 {
+		return nil
+	}
+	return c.validate()
+}
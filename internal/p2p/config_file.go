@@ -0,0 +1,433 @@
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileName is the configuration file in the data directory
+const ConfigFileName = "config.yaml"
+
+// Log formats of the logging section
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// ConfigFile holds the node settings read from the configuration file:
+//
+//	version: 2
+//	listen:
+//	  - /ip4/0.0.0.0/tcp/4001
+//	  - /ip4/0.0.0.0/udp/4001/quic-v1
+//	announce:
+//	  - /ip4/203.0.113.7/tcp/4001
+//	swarm_key: /etc/xelvra/swarm.key
+//	bootstrap_peers:
+//	  - /dns4/boot.example.org/tcp/4001/p2p/12D3KooW...
+//	relays:
+//	  - /dns4/relay.example.org/tcp/4001/p2p/12D3KooW...
+//	hooks:
+//	  on_message: /usr/local/bin/on-message
+//	network:
+//	  enable_quic: true
+//	  enable_tcp: true
+//	discovery:
+//	  mdns: true
+//	  udp_broadcast: true
+//	logging:
+//	  level: info
+//	  format: json
+//	profile: work
+//	profiles:
+//	  work:
+//	    match:
+//	      ssid: [CorpWiFi]
+//	    discovery:
+//	      mdns: false
+//
+// Settings left out take the defaults of DefaultConfigFile, and
+// environment variables override any setting but swarm_key, see
+// ConfigEnvName. Files of an older version are brought up to date by
+// MigrateConfigFile.
+type ConfigFile struct {
+	// Version is the format version of the file, see ConfigVersion; it is
+	// not a setting
+	Version int `yaml:"-"`
+
+	// Listen replaces the default listen addresses, which take a random
+	// port on every start
+	Listen []string `yaml:"listen,omitempty"`
+
+	// Announce is advertised to peers besides the listen addresses, e.g.
+	// the public address a router forwards to a pinned port
+	Announce []string `yaml:"announce,omitempty"`
+
+	// SwarmKey is the path of the pre-shared key of a private network
+	SwarmKey string `yaml:"swarm_key,omitempty"`
+
+	// BootstrapPeers replace the default bootstrap peers, which are used
+	// only while none of these can be reached
+	BootstrapPeers []string `yaml:"bootstrap_peers,omitempty"`
+
+	// Rendezvous are keys the node announces and looks for peers under in
+	// the DHT, e.g. "xelvra/v1" or the ID of a group
+	Rendezvous []string `yaml:"rendezvous,omitempty"`
+
+	// Relays are circuit relays the node reserves a slot with before any
+	// other relay candidate, when it is not reachable directly
+	Relays []string `yaml:"relays,omitempty"`
+
+	// Hooks are programs the node runs on events
+	Hooks ConfigHooks `yaml:"hooks,omitempty"`
+
+	// Network turns transports on and off
+	Network ConfigNetwork `yaml:"network"`
+
+	// Discovery turns LAN discovery methods on and off
+	Discovery ConfigDiscovery `yaml:"discovery"`
+
+	// Logging sets what goes into the log file
+	Logging ConfigLogging `yaml:"logging"`
+
+	// Profile names the profile of Profiles to use; empty selects the one
+	// matching the current network, if any
+	Profile string `yaml:"profile,omitempty"`
+
+	// Profiles are named sets of settings for the networks the user moves
+	// between, see ConfigProfile
+	Profiles map[string]ConfigProfile `yaml:"profiles,omitempty"`
+
+	// Unknown lists the settings in the file that are not part of the
+	// configuration, e.g. misspelled ones; they are ignored
+	Unknown []string `yaml:"-"`
+
+	// Overrides maps the settings set by the environment to the variable
+	// each was read from
+	Overrides map[string]string `yaml:"-"`
+}
+
+// ConfigNetwork is the network section of the configuration file
+type ConfigNetwork struct {
+	// EnableQUIC listens and dials over QUIC, besides TCP
+	EnableQUIC bool `yaml:"enable_quic"`
+
+	// EnableTCP listens and dials over TCP, besides QUIC
+	EnableTCP bool `yaml:"enable_tcp"`
+}
+
+// ConfigDiscovery is the discovery section of the configuration file
+type ConfigDiscovery struct {
+	// MDNS finds peers on the LAN with multicast DNS
+	MDNS bool `yaml:"mdns"`
+
+	// UDPBroadcast finds peers on the LAN with signed UDP beacons
+	UDPBroadcast bool `yaml:"udp_broadcast"`
+}
+
+// ConfigLogging is the logging section of the configuration file
+type ConfigLogging struct {
+	// Level is the least severe level logged: debug, info, warn or error
+	Level string `yaml:"level"`
+
+	// Format is LogFormatJSON, which the logs command reads, or
+	// LogFormatText
+	Format string `yaml:"format"`
+}
+
+// DefaultConfigFile returns the settings used when there is no
+// configuration file
+func DefaultConfigFile() *ConfigFile {
+	return &ConfigFile{
+		Network:   ConfigNetwork{EnableQUIC: true, EnableTCP: true},
+		Discovery: ConfigDiscovery{MDNS: true, UDPBroadcast: true},
+		Logging:   ConfigLogging{Level: logrus.InfoLevel.String(), Format: LogFormatJSON},
+		Version:   ConfigVersion,
+	}
+}
+
+// ConfigHooks are the hooks set in the configuration file
+type ConfigHooks struct {
+	// OnMessage is run for every text message received, relative to the
+	// directory of the configuration file unless absolute
+	OnMessage string `yaml:"on_message,omitempty"`
+}
+
+// LoadConfigFile reads the configuration file at path over the defaults,
+// then applies the environment variables overriding settings; a missing
+// file sets nothing. A file of an older version is migrated in memory,
+// keeping the version it has in Version.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	config := DefaultConfigFile()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if config, err = parseMigratedConfigFile(data); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	if err := config.applyEnvironment(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfigEnvironment, err)
+	}
+	return config, nil
+}
+
+// parseMigratedConfigFile decodes the YAML configuration in data once it
+// is brought up to ConfigVersion
+func parseMigratedConfigFile(data []byte) (*ConfigFile, error) {
+	migrated, migration, err := migrateConfigData(data)
+	if err != nil || migration == nil {
+		// An unparsable file is reported by parseConfigFile
+		return parseConfigFile(data)
+	}
+	config, err := parseConfigFile(migrated)
+	if err != nil {
+		return nil, err
+	}
+	config.Version = migration.From
+	return config, nil
+}
+
+// parseConfigFile decodes the YAML configuration in data over the
+// defaults, noting the settings it does not know
+func parseConfigFile(data []byte) (*ConfigFile, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	defaults := DefaultConfigFile()
+	v.SetDefault("network.enable_quic", defaults.Network.EnableQUIC)
+	v.SetDefault("network.enable_tcp", defaults.Network.EnableTCP)
+	v.SetDefault("discovery.mdns", defaults.Discovery.MDNS)
+	v.SetDefault("discovery.udp_broadcast", defaults.Discovery.UDPBroadcast)
+	v.SetDefault("logging.level", defaults.Logging.Level)
+	v.SetDefault("logging.format", defaults.Logging.Format)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		var parseErr viper.ConfigParseError
+		if errors.As(err, &parseErr) {
+			return nil, errors.Unwrap(parseErr)
+		}
+		return nil, err
+	}
+
+	var (
+		config   ConfigFile
+		metadata mapstructure.Metadata
+	)
+	err := v.Unmarshal(&config, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+		dc.Metadata = &metadata
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range metadata.Unused {
+		if key == configVersionKey {
+			continue
+		}
+		// Settings of a profile are reported as profiles[work].listen
+		key = strings.ReplaceAll(strings.ReplaceAll(key, "[", "."), "]", "")
+		config.Unknown = append(config.Unknown, key)
+	}
+	sort.Strings(config.Unknown)
+
+	config.Version = ConfigVersion
+	var doc yaml.Node
+	if yaml.Unmarshal(data, &doc) == nil && len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		if config.Version, err = configFileVersion(doc.Content[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Profiles without settings have no keys for viper to find
+	var names struct {
+		Profiles map[string]yaml.Node `yaml:"profiles"`
+	}
+	if yaml.Unmarshal(data, &names) == nil {
+		for name := range names.Profiles {
+			name = strings.ToLower(name)
+			if _, ok := config.Profiles[name]; !ok {
+				if config.Profiles == nil {
+					config.Profiles = make(map[string]ConfigProfile)
+				}
+				config.Profiles[name] = ConfigProfile{}
+			}
+		}
+	}
+	return &config, nil
+}
+
+// validate checks the addresses, rendezvous keys, transports and logging
+// settings in the configuration
+func (c *ConfigFile) validate() error {
+	if !c.Network.EnableQUIC && !c.Network.EnableTCP {
+		return errors.New("network: enable_quic and enable_tcp cannot both be false")
+	}
+	if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+		return fmt.Errorf("logging: invalid level %q, expected debug, info, warn or error", c.Logging.Level)
+	}
+	switch strings.ToLower(c.Logging.Format) {
+	case LogFormatJSON, LogFormatText:
+	default:
+		return fmt.Errorf("logging: invalid format %q, expected %s or %s", c.Logging.Format, LogFormatJSON, LogFormatText)
+	}
+	if err := validateAddrs("listen", c.Listen); err != nil {
+		return err
+	}
+	if err := validateAddrs("announce", c.Announce); err != nil {
+		return err
+	}
+	if len(c.Rendezvous) > MaxRendezvousKeys {
+		return fmt.Errorf("rendezvous: at most %d keys", MaxRendezvousKeys)
+	}
+	for _, key := range c.Rendezvous {
+		if err := ValidateRendezvousKey(key); err != nil {
+			return fmt.Errorf("rendezvous: %w", err)
+		}
+	}
+	if err := ValidateBootstrapAddrs(c.BootstrapPeers); err != nil {
+		return err
+	}
+	if err := validateRelayAddrs("relays", c.Relays); err != nil {
+		return err
+	}
+	return c.validateProfiles()
+}
+
+// SetConfigValue sets key in the configuration file at path to value,
+// keeping the rest of the file, comments included. The result must still
+// be a valid configuration.
+func SetConfigValue(path, key string, value interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	return editConfigFile(path, key, &node)
+}
+
+// UnsetConfigValue removes key from the configuration file at path, so its
+// default applies again
+func UnsetConfigValue(path, key string) error {
+	return editConfigFile(path, key, nil)
+}
+
+// editConfigFile replaces the value of key, e.g. "bootstrap_peers" or
+// "discovery.mdns", with value, or removes the key when value is nil, and
+// writes the file readable by the owner only
+func editConfigFile(path, key string, value *yaml.Node) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+		setConfigFileVersion(doc.Content[0], ConfigVersion)
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s does not hold a mapping of settings", path)
+	}
+
+	if err := setMappingValue(root, strings.Split(key, "."), value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	out := buf.Bytes()
+	check, err := parseConfigFile(out)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if err := check.validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0600)
+}
+
+// setMappingValue sets the value at the path of keys below mapping,
+// creating the sections on the way, or removes it when value is nil along
+// with the sections it leaves empty
+func setMappingValue(mapping *yaml.Node, keys []string, value *yaml.Node) error {
+	index := -1
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == keys[0] {
+			index = i
+			break
+		}
+	}
+
+	if len(keys) == 1 {
+		switch {
+		case index >= 0 && value == nil:
+			mapping.Content = append(mapping.Content[:index], mapping.Content[index+2:]...)
+		case index >= 0:
+			// Keep the comments of the old value
+			value.HeadComment = mapping.Content[index+1].HeadComment
+			value.LineComment = mapping.Content[index+1].LineComment
+			mapping.Content[index+1] = value
+		case value != nil:
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[0]}, value)
+		}
+		return nil
+	}
+
+	if index < 0 {
+		if value == nil {
+			return nil
+		}
+		section := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[0]}, section)
+		index = len(mapping.Content) - 2
+	}
+	section := mapping.Content[index+1]
+	if section.Kind == yaml.ScalarNode && section.Tag == "!!null" {
+		*section = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	if section.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a section", keys[0])
+	}
+	if err := setMappingValue(section, keys[1:], value); err != nil {
+		return err
+	}
+	if len(section.Content) == 0 {
+		mapping.Content = append(mapping.Content[:index], mapping.Content[index+2:]...)
+	}
+	return nil
+}
+
+// validateAddrs checks that every entry of a configuration key is a
+// multiaddr
+func validateAddrs(key string, addrs []string) error {
+	for _, addr := range addrs {
+		if _, err := ma.NewMultiaddr(addr); err != nil {
+			return fmt.Errorf("invalid %s address %q: %w", key, addr, err)
+		}
+	}
+	return nil
+}
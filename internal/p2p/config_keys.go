@@ -0,0 +1,131 @@
+package p2p
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ConfigKeys lists the settings of the configuration file, with the
+// settings of a section written as "discovery.mdns"
+func ConfigKeys() []string {
+	return configKeys(reflect.TypeOf(ConfigFile{}), "")
+}
+
+// configKeys lists the settings of the struct type t below prefix
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := configFieldName(field)
+		if name == "" || field.Type.Kind() == reflect.Map {
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, configKeys(field.Type, prefix+name+".")...)
+			continue
+		}
+		keys = append(keys, prefix+name)
+	}
+	return keys
+}
+
+// configFieldName returns the name of a field in the configuration file,
+// empty for fields not read from it
+func configFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// configField returns the field of config holding the setting key
+func configField(config *ConfigFile, key string) (reflect.Value, error) {
+	value := reflect.ValueOf(config).Elem()
+	for _, name := range strings.Split(key, ".") {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("unknown setting %q", key)
+		}
+		found := false
+		for i := 0; i < value.NumField(); i++ {
+			if configFieldName(value.Type().Field(i)) == name {
+				value, found = value.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		return reflect.Value{}, fmt.Errorf("%s is a section, not a setting", key)
+	case reflect.Map:
+		return reflect.Value{}, fmt.Errorf("%s can only be edited in the configuration file", key)
+	}
+	return value, nil
+}
+
+// Get returns the value of the setting key: a string, a bool or a list of
+// strings
+func (c *ConfigFile) Get(key string) (interface{}, error) {
+	field, err := configField(c, key)
+	if err != nil {
+		return nil, err
+	}
+	return field.Interface(), nil
+}
+
+// ParseConfigValue converts the arguments given for the setting key on the
+// command line to its value. A list takes one or more arguments, any other
+// setting exactly one.
+func ParseConfigValue(key string, args []string) (interface{}, error) {
+	field, err := configField(DefaultConfigFile(), key)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s needs a value", key)
+	}
+
+	switch field.Kind() {
+	case reflect.Slice:
+		return append([]string(nil), args...), nil
+	case reflect.Bool:
+		if len(args) > 1 {
+			return nil, fmt.Errorf("%s takes one value", key)
+		}
+		value, err := strconv.ParseBool(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s takes true or false, not %q", key, args[0])
+		}
+		return value, nil
+	default:
+		if len(args) > 1 {
+			return nil, fmt.Errorf("%s takes one value", key)
+		}
+		return args[0], nil
+	}
+}
+
+// ChangedConfigKeys lists the settings that differ between two
+// configurations
+func ChangedConfigKeys(old, updated *ConfigFile) []string {
+	var changed []string
+	for _, key := range ConfigKeys() {
+		before, _ := old.Get(key)
+		after, _ := updated.Get(key)
+		if !reflect.DeepEqual(before, after) && !(isEmptyList(before) && isEmptyList(after)) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// isEmptyList reports whether value is a list without entries, nil or not
+func isEmptyList(value interface{}) bool {
+	list, ok := value.([]string)
+	return ok && len(list) == 0
+}
@@ -0,0 +1,199 @@
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigVersion is the version of the configuration file format this
+	// release reads and writes. Files without a version are version 1, the
+	// format documented before versions were recorded.
+	ConfigVersion = 2
+
+	// ConfigBackupSuffix is appended to the path of a configuration file
+	// for the copy kept when it is migrated
+	ConfigBackupSuffix = ".bak"
+
+	// configVersionKey holds the version in the file
+	configVersionKey = "version"
+)
+
+// ConfigMigration describes how a configuration file was brought up to
+// ConfigVersion
+type ConfigMigration struct {
+	From int
+	To   int
+
+	// Backup is the copy of the file as it was
+	Backup string
+
+	// Changes describe each setting that was renamed or removed, e.g.
+	// "log_level → logging.level"
+	Changes []string
+}
+
+// configMigrations turn the file of a version, by its root mapping, into
+// the next version, describing the changes they make
+var configMigrations = map[int]func(root *yaml.Node) []string{
+	1: migrateConfigV1,
+}
+
+// MigrateConfigFile brings the configuration file at path up to
+// ConfigVersion, keeping the file as it was next to it with
+// ConfigBackupSuffix. It returns nil when there is nothing to migrate: no
+// file, an empty one, or one of the current version or a newer one. A file
+// that cannot be parsed is left for LoadConfigFile to report.
+func MigrateConfigFile(path string) (*ConfigMigration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	migrated, migration, err := migrateConfigData(data)
+	if err != nil || migration == nil {
+		return nil, nil
+	}
+	migration.Backup = path + ConfigBackupSuffix
+	if err := os.WriteFile(migration.Backup, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, migrated, 0600); err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+// migrateConfigData brings the YAML configuration in data up to
+// ConfigVersion, returning the migrated configuration and what changed, or
+// nil for both when there is nothing to migrate
+func migrateConfigData(data []byte) ([]byte, *ConfigMigration, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, nil
+	}
+	root := doc.Content[0]
+	version, err := configFileVersion(root)
+	if err != nil || version >= ConfigVersion {
+		return nil, nil, err
+	}
+
+	migration := &ConfigMigration{From: version, To: ConfigVersion}
+	for v := version; v < ConfigVersion; v++ {
+		migration.Changes = append(migration.Changes, configMigrations[v](root)...)
+	}
+	setConfigFileVersion(root, ConfigVersion)
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return buf.Bytes(), migration, nil
+}
+
+// configFileVersion returns the version recorded in the root mapping of a
+// configuration file, 1 if there is none
+func configFileVersion(root *yaml.Node) (int, error) {
+	node := mappingValue(root, []string{configVersionKey})
+	if node == nil {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(node.Value)
+	if err != nil || node.Kind != yaml.ScalarNode || version < 1 {
+		return 0, fmt.Errorf("%s: expected a whole number of at least 1, got %q", configVersionKey, node.Value)
+	}
+	return version, nil
+}
+
+// setConfigFileVersion records version at the top of the root mapping
+func setConfigFileVersion(root *yaml.Node, version int) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	if node := mappingValue(root, []string{configVersionKey}); node != nil {
+		*node = *value
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: configVersionKey}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// mappingValue returns the value at the path of keys below mapping, or nil
+func mappingValue(mapping *yaml.Node, keys []string) *yaml.Node {
+	_, value := mappingEntry(mapping, keys)
+	return value
+}
+
+// mappingEntry returns the key and the value at the path of keys below
+// mapping, or nil for both
+func mappingEntry(mapping *yaml.Node, keys []string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != keys[0] {
+			continue
+		}
+		value := mapping.Content[i+1]
+		if len(keys) == 1 {
+			return mapping.Content[i], value
+		}
+		if value.Kind != yaml.MappingNode {
+			return nil, nil
+		}
+		return mappingEntry(value, keys[1:])
+	}
+	return nil, nil
+}
+
+// renameConfigKey moves the value of the setting from, with the comment
+// above it, to the setting to, unless to is already set, in which case from
+// is dropped. It returns the change made, or nothing if from is not set.
+func renameConfigKey(root *yaml.Node, from, to string) []string {
+	key, value := mappingEntry(root, strings.Split(from, "."))
+	if value == nil {
+		return nil
+	}
+	_ = setMappingValue(root, strings.Split(from, "."), nil)
+	if mappingValue(root, strings.Split(to, ".")) != nil {
+		return []string{fmt.Sprintf("%s removed: %s is already set", from, to)}
+	}
+	if err := setMappingValue(root, strings.Split(to, "."), value); err != nil {
+		return []string{fmt.Sprintf("%s removed: %v", from, err)}
+	}
+	if renamed, _ := mappingEntry(root, strings.Split(to, ".")); renamed != nil && renamed.HeadComment == "" {
+		renamed.HeadComment = key.HeadComment
+	}
+	return []string{fmt.Sprintf("%s → %s", from, to)}
+}
+
+// removeConfigKey drops the setting key, giving why in the change made
+func removeConfigKey(root *yaml.Node, key, why string) []string {
+	if mappingValue(root, strings.Split(key, ".")) == nil {
+		return nil
+	}
+	_ = setMappingValue(root, strings.Split(key, "."), nil)
+	return []string{fmt.Sprintf("%s removed: %s", key, why)}
+}
+
+// migrateConfigV1 renames the settings of the format documented before
+// versions were recorded, and drops those that are no longer settings
+func migrateConfigV1(root *yaml.Node) []string {
+	var changes []string
+	changes = append(changes, renameConfigKey(root, "listen_addrs", "listen")...)
+	changes = append(changes, renameConfigKey(root, "log_level", "logging.level")...)
+	changes = append(changes, renameConfigKey(root, "discovery.mdns_enabled", "discovery.mdns")...)
+	changes = append(changes, renameConfigKey(root, "discovery.udp_broadcast_enabled", "discovery.udp_broadcast")...)
+	changes = append(changes, removeConfigKey(root, "log_file", "the log is always peerchat.log in the data directory")...)
+	changes = append(changes, removeConfigKey(root, "discovery.dht_enabled", "the DHT is always used")...)
+	changes = append(changes, removeConfigKey(root, "identity", "the identity is kept in identity.key")...)
+	changes = append(changes, removeConfigKey(root, "database", "the history is kept in the data directory")...)
+	return changes
+}
@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ConfigProfile is a named set of settings for a network the user moves
+// to, e.g. a work network blocking LAN discovery and UDP:
+//
+//	profiles:
+//	  work:
+//	    match:
+//	      ssid: [CorpWiFi]
+//	      subnet: [10.20.0.0/16]
+//	    listen: [/ip4/0.0.0.0/tcp/443]
+//	    relays: [/dns4/relay.example.org/tcp/443/p2p/12D3KooW...]
+//	    network:
+//	      enable_quic: false
+//	    discovery:
+//	      mdns: false
+//	      udp_broadcast: false
+//
+// The settings a profile sets replace those at the top of the file while
+// it is in use; the others are kept.
+type ConfigProfile struct {
+	// Match selects the profile on the networks it lists
+	Match ConfigProfileMatch `yaml:"match,omitempty"`
+
+	Listen         []string `yaml:"listen,omitempty"`
+	Relays         []string `yaml:"relays,omitempty"`
+	BootstrapPeers []string `yaml:"bootstrap_peers,omitempty"`
+
+	Network   ConfigProfileNetwork   `yaml:"network,omitempty"`
+	Discovery ConfigProfileDiscovery `yaml:"discovery,omitempty"`
+}
+
+// ConfigProfileMatch lists the networks a profile is selected on: Wi-Fi
+// networks by SSID, and subnets in CIDR notation the local address falls in
+type ConfigProfileMatch struct {
+	SSID   []string `yaml:"ssid,omitempty"`
+	Subnet []string `yaml:"subnet,omitempty"`
+}
+
+// ConfigProfileNetwork is the network section of a profile, nil keeping
+// the setting of the file
+type ConfigProfileNetwork struct {
+	EnableQUIC *bool `yaml:"enable_quic,omitempty"`
+	EnableTCP  *bool `yaml:"enable_tcp,omitempty"`
+}
+
+// ConfigProfileDiscovery is the discovery section of a profile, nil
+// keeping the setting of the file
+type ConfigProfileDiscovery struct {
+	MDNS         *bool `yaml:"mdns,omitempty"`
+	UDPBroadcast *bool `yaml:"udp_broadcast,omitempty"`
+}
+
+// ProfileNames returns the names of the profiles, sorted. The names are
+// lower case, since keys in the file are not case sensitive.
+func (c *ConfigFile) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MatchProfile returns the first profile, by name, whose match lists the
+// network id: its SSID, or a subnet holding its address range. It returns
+// "" when none does.
+func (c *ConfigFile) MatchProfile(id NetworkID) string {
+	for _, name := range c.ProfileNames() {
+		if c.Profiles[name].Match.matches(id) {
+			return name
+		}
+	}
+	return ""
+}
+
+// matches reports whether the network id is one of those listed
+func (m ConfigProfileMatch) matches(id NetworkID) bool {
+	if id.SSID != "" && slices.Contains(m.SSID, id.SSID) {
+		return true
+	}
+	ip, _, err := net.ParseCIDR(id.Subnet)
+	if err != nil {
+		return false
+	}
+	for _, subnet := range m.Subnet {
+		if _, ipNet, err := net.ParseCIDR(subnet); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithProfile returns a copy of the configuration with the settings of the
+// profile name applied, except those the environment overrides. Profile
+// names are not case sensitive.
+func (c *ConfigFile) WithProfile(name string) (*ConfigFile, error) {
+	name = strings.ToLower(name)
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("no profile %q in the configuration", name)
+	}
+	applied := *c
+	applies := func(key string, set bool) bool {
+		_, fromEnv := c.Overrides[key]
+		return set && !fromEnv
+	}
+	if applies("listen", profile.Listen != nil) {
+		applied.Listen = profile.Listen
+	}
+	if applies("relays", profile.Relays != nil) {
+		applied.Relays = profile.Relays
+	}
+	if applies("bootstrap_peers", profile.BootstrapPeers != nil) {
+		applied.BootstrapPeers = profile.BootstrapPeers
+	}
+	if applies("network.enable_quic", profile.Network.EnableQUIC != nil) {
+		applied.Network.EnableQUIC = *profile.Network.EnableQUIC
+	}
+	if applies("network.enable_tcp", profile.Network.EnableTCP != nil) {
+		applied.Network.EnableTCP = *profile.Network.EnableTCP
+	}
+	if applies("discovery.mdns", profile.Discovery.MDNS != nil) {
+		applied.Discovery.MDNS = *profile.Discovery.MDNS
+	}
+	if applies("discovery.udp_broadcast", profile.Discovery.UDPBroadcast != nil) {
+		applied.Discovery.UDPBroadcast = *profile.Discovery.UDPBroadcast
+	}
+	applied.Profile = name
+	return &applied, nil
+}
+
+// validateProfiles checks the profile in use exists and the settings of
+// every profile are valid once applied
+func (c *ConfigFile) validateProfiles() error {
+	if c.Profile != "" {
+		if _, ok := c.Profiles[strings.ToLower(c.Profile)]; !ok {
+			return fmt.Errorf("profile: no profile %q in profiles", c.Profile)
+		}
+	}
+	for _, name := range c.ProfileNames() {
+		profile := c.Profiles[name]
+		prefix := "profiles." + name
+		for _, subnet := range profile.Match.Subnet {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				return fmt.Errorf("%s.match: invalid subnet %q, expected e.g. 192.168.1.0/24", prefix, subnet)
+			}
+		}
+		if err := validateAddrs(prefix+".listen", profile.Listen); err != nil {
+			return err
+		}
+		if err := validateRelayAddrs(prefix+".relays", profile.Relays); err != nil {
+			return err
+		}
+		if err := ValidateBootstrapAddrs(profile.BootstrapPeers); err != nil {
+			return fmt.Errorf("%s: %w", prefix, err)
+		}
+		applied, _ := c.WithProfile(name)
+		if !applied.Network.EnableQUIC && !applied.Network.EnableTCP {
+			return fmt.Errorf("%s.network: enable_quic and enable_tcp cannot both be false", prefix)
+		}
+	}
+	return nil
+}
+
+// validateRelayAddrs checks the relay addresses set under key
+func validateRelayAddrs(key string, addrs []string) error {
+	if _, err := ParseRelayAddrs(addrs); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/peer"
+	basicconnmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+const (
+	// DefaultConnHighWater and DefaultConnLowWater bound the open
+	// connections: above the high watermark the least useful are closed
+	// until the low watermark is reached, which keeps an idle node within
+	// its memory target
+	DefaultConnHighWater = 64
+	DefaultConnLowWater  = 32
+
+	// ConnGracePeriod is how long a new connection is kept before it may
+	// be pruned, so it has time to become useful
+	ConnGracePeriod = 30 * time.Second
+
+	// connProtectInterval is how often the protected peers are brought up
+	// to date with the contacts and transfers
+	connProtectInterval = 10 * time.Second
+
+	// Tags under which peers are protected from pruning
+	contactProtectTag  = "contact"
+	transferProtectTag = "transfer"
+)
+
+// ConnLimits is the connection manager configuration shown in the status
+type ConnLimits struct {
+	LowWater  int `json:"low_water"`
+	HighWater int `json:"high_water"`
+	Protected int `json:"protected"` // Peers never pruned: contacts and peers with transfers in progress
+}
+
+// connWatermarks returns the low and high watermarks, filling in defaults
+// for zero values. A high watermark alone keeps half as many on pruning.
+func connWatermarks(low, high int) (int, int, error) {
+	if high == 0 {
+		high = DefaultConnHighWater
+		if low >= high {
+			high = 2 * low
+		}
+	}
+	if low == 0 {
+		low = min(DefaultConnLowWater, high/2)
+	}
+	if low < 0 || low >= high {
+		return 0, 0, fmt.Errorf("connection low watermark %d must be below the high watermark %d", low, high)
+	}
+	return low, high, nil
+}
+
+// NewConnManager creates a connection manager pruning connections to low
+// once there are more than high. Idle connections to peers nobody tagged
+// go first; opts are applied after the defaults.
+func NewConnManager(low, high int, opts ...basicconnmgr.Option) (*basicconnmgr.BasicConnMgr, error) {
+	low, high, err := connWatermarks(low, high)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]basicconnmgr.Option{basicconnmgr.WithGracePeriod(ConnGracePeriod)}, opts...)
+	return basicconnmgr.NewConnManager(low, high, opts...)
+}
+
+// ConnProtector keeps sets of peers protected from pruning, one set per tag
+type ConnProtector struct {
+	cm connmgr.ConnManager
+
+	mu        sync.Mutex
+	protected map[string]map[peer.ID]bool
+}
+
+// NewConnProtector creates a protector for the connections of cm
+func NewConnProtector(cm connmgr.ConnManager) *ConnProtector {
+	return &ConnProtector{cm: cm, protected: make(map[string]map[peer.ID]bool)}
+}
+
+// Update protects peers under tag, and stops protecting under tag the
+// peers it protected before that are no longer listed
+func (p *ConnProtector) Update(tag string, peers []peer.ID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[peer.ID]bool, len(peers))
+	for _, id := range peers {
+		current[id] = true
+		if !p.protected[tag][id] {
+			p.cm.Protect(id, tag)
+		}
+	}
+	for id := range p.protected[tag] {
+		if !current[id] {
+			p.cm.Unprotect(id, tag)
+		}
+	}
+	p.protected[tag] = current
+}
+
+// Count returns how many distinct peers are protected
+func (p *ConnProtector) Count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers := make(map[peer.ID]bool)
+	for _, ids := range p.protected {
+		for id := range ids {
+			peers[id] = true
+		}
+	}
+	return len(peers)
+}
+
+// transferPeerIDs returns the peers with a file transfer in progress in
+// either direction
+func (n *PeerChatNode) transferPeerIDs() []peer.ID {
+	var ids []peer.ID
+	for _, transfer := range n.messageManager.ListTransfers() {
+		switch transfer.Status {
+		case message.FileTransferPending, message.FileTransferActive, message.FileTransferStalled, message.FileTransferPaused:
+			ids = append(ids, transfer.PeerID)
+		}
+	}
+	return ids
+}
+
+// protectConnections protects contacts and peers with transfers in
+// progress from pruning
+func (n *PeerChatNode) protectConnections() {
+	n.connProtector.Update(contactProtectTag, n.contactPeerIDs())
+	n.connProtector.Update(transferProtectTag, n.transferPeerIDs())
+}
+
+// runConnProtector keeps the protected peers up to date
+func (n *PeerChatNode) runConnProtector() {
+	n.protectConnections()
+
+	ticker := time.NewTicker(connProtectInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.protectConnections()
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package p2p
+
+import "time"
+
+// processCPUTime is not available on this platform; the energy manager
+// estimates CPU use instead
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
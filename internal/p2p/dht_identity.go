@@ -0,0 +1,50 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/sirupsen/logrus"
+)
+
+// newDHTHost creates a host with a fresh identity to take part in the DHT in
+// place of the messaging host. The identity is never stored, so DHT routing
+// activity cannot be linked to the long-term peer ID and DID.
+func newDHTHost(listenAddrs []string, proxy ProxyConfig, psk pnet.PSK, logger *logrus.Logger) (host.Host, error) {
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate DHT identity: %w", err)
+	}
+
+	h, err := libp2p.New(
+		libp2p.Identity(privKey),
+		libp2p.ListenAddrStrings(TCPListenAddrs(listenAddrs)...),
+		tcpTransport(proxy, logger),
+		libp2p.PrivateNetwork(psk),
+		libp2p.Ping(false),
+		libp2p.DisableRelay(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DHT host: %w", err)
+	}
+
+	logger.WithField("dht_peer_id", h.ID().String()).Info("DHT runs on an ephemeral identity")
+	return h, nil
+}
+
+// TCPListenAddrs returns the TCP addresses among addrs, the only ones the
+// DHT host listens on
+func TCPListenAddrs(addrs []string) []string {
+	var tcp []string
+	for _, addr := range addrs {
+		if strings.Contains(addr, "/tcp/") {
+			tcp = append(tcp, addr)
+		}
+	}
+	return tcp
+}
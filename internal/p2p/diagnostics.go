@@ -0,0 +1,209 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	// DiagnosticsFileName holds whether the diagnostics endpoint is served
+	DiagnosticsFileName = "diagnostics.json"
+
+	// DefaultDiagnosticsPort is the local port of the diagnostics endpoint
+	DefaultDiagnosticsPort = 6060
+
+	// diagnosticsShutdown bounds waiting for profiles in progress on stop
+	diagnosticsShutdown = 2 * time.Second
+)
+
+// DiagnosticsSettings control the diagnostics endpoint. It serves pprof
+// profiles and runtime statistics on 127.0.0.1 only, and only when enabled.
+type DiagnosticsSettings struct {
+	Enabled bool `json:"enabled"`
+	Port    int  `json:"port"`
+}
+
+// LoadDiagnosticsSettings reads the diagnostics settings at path. Without a
+// file the endpoint is off.
+func LoadDiagnosticsSettings(path string) (*DiagnosticsSettings, error) {
+	settings := &DiagnosticsSettings{Port: DefaultDiagnosticsPort}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse diagnostics settings: %w", err)
+	}
+	if settings.Port <= 0 || settings.Port > 65535 {
+		return nil, fmt.Errorf("invalid diagnostics port %d", settings.Port)
+	}
+	return settings, nil
+}
+
+// SaveDiagnosticsSettings writes the diagnostics settings to path
+func SaveDiagnosticsSettings(path string, settings *DiagnosticsSettings) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// RuntimeStats is a snapshot of the node's resource use
+type RuntimeStats struct {
+	Time           time.Time `json:"time"`
+	UptimeSeconds  float64   `json:"uptime_seconds"`
+	GoVersion      string    `json:"go_version"`
+	NumCPU         int       `json:"num_cpu"`
+	GOMAXPROCS     int       `json:"gomaxprocs"`
+	Goroutines     int       `json:"goroutines"`
+	HeapAllocBytes uint64    `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64    `json:"heap_inuse_bytes"`
+	HeapObjects    uint64    `json:"heap_objects"`
+	SysBytes       uint64    `json:"sys_bytes"`
+	NumGC          uint32    `json:"num_gc"`
+	GCPauseTotalMS float64   `json:"gc_pause_total_ms"`
+	LastGCPauseMS  float64   `json:"last_gc_pause_ms"`
+	ConnectedPeers int       `json:"connected_peers"`
+	OpenStreams    int       `json:"open_streams"`
+	MemoryTargetMB int       `json:"memory_target_mb"`
+}
+
+// ReadRuntimeStats returns the resource use of this process
+func ReadRuntimeStats(start time.Time) RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		Time:           time.Now(),
+		UptimeSeconds:  time.Since(start).Seconds(),
+		GoVersion:      runtime.Version(),
+		NumCPU:         runtime.NumCPU(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapInuseBytes: mem.HeapInuse,
+		HeapObjects:    mem.HeapObjects,
+		SysBytes:       mem.Sys,
+		NumGC:          mem.NumGC,
+		GCPauseTotalMS: float64(mem.PauseTotalNs) / 1e6,
+		MemoryTargetMB: MaxIdleMemoryMB,
+	}
+	if mem.NumGC > 0 {
+		stats.LastGCPauseMS = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+	}
+	return stats
+}
+
+// NewDiagnosticsHandler serves pprof under /debug/pprof/ and the result of
+// stats as JSON under /debug/runtime. Requests naming another host are
+// refused, so web pages cannot reach the endpoint through DNS rebinding.
+func NewDiagnosticsHandler(stats func() RuntimeStats) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(stats())
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if host != "localhost" && !net.ParseIP(host).IsLoopback() {
+			http.Error(w, "diagnostics are served to localhost only", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// startDiagnostics serves the diagnostics endpoint if it is enabled, or
+// asked for with DiagnosticsPort
+func (n *PeerChatNode) startDiagnostics() {
+	settings := &DiagnosticsSettings{Enabled: true, Port: n.config.DiagnosticsPort}
+	if settings.Port == 0 {
+		var err error
+		settings, err = LoadDiagnosticsSettings(filepath.Join(n.config.DataDir, DiagnosticsFileName))
+		if err != nil {
+			n.logger.WithError(err).Warn("Diagnostics endpoint disabled")
+			return
+		}
+	}
+	if !settings.Enabled {
+		return
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", settings.Port))
+	if err != nil {
+		n.logger.WithError(err).Warn("Failed to serve diagnostics endpoint")
+		return
+	}
+
+	server := &http.Server{
+		Handler:           NewDiagnosticsHandler(n.RuntimeStats),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	n.mu.Lock()
+	n.diagnostics = server
+	n.diagnosticsAddr = listener.Addr().String()
+	n.mu.Unlock()
+
+	n.logger.WithField("addr", listener.Addr().String()).Info("Serving diagnostics endpoint")
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			n.logger.WithError(err).Warn("Diagnostics endpoint stopped")
+		}
+	}()
+}
+
+// stopDiagnostics stops the diagnostics endpoint
+func (n *PeerChatNode) stopDiagnostics() {
+	n.mu.Lock()
+	server := n.diagnostics
+	n.diagnostics, n.diagnosticsAddr = nil, ""
+	n.mu.Unlock()
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsShutdown)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		_ = server.Close()
+	}
+}
+
+// RuntimeStats returns the node's resource use
+func (n *PeerChatNode) RuntimeStats() RuntimeStats {
+	stats := ReadRuntimeStats(n.startTime)
+	stats.ConnectedPeers = len(n.host.Network().Peers())
+	for _, conn := range n.host.Network().Conns() {
+		stats.OpenStreams += len(conn.GetStreams())
+	}
+	return stats
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p-kad-dht/dual"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -15,26 +19,42 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	multiaddr "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// beaconPort is where presence beacons are broadcast over IPv4 and
+	// multicast to beaconIPv6Group over IPv6
+	beaconPort      = 42424
+	beaconIPv6Group = "ff02::1"
+)
+
 // DiscoveryManager handles peer discovery using hierarchical approach
 // Implements the hierarchical discovery from tmp/Návrhy.md:
 // 1. Local Discovery (BLE, Wi-Fi Direct, mDNS) - fastest and most efficient
 // 2. Global Discovery (DHT) - for distributed peer finding
 type DiscoveryManager struct {
-	host   host.Host
-	logger *logrus.Logger
-	ctx    context.Context
-	cancel context.CancelFunc
-
-	// Discovery methods
+	host    host.Host
+	dhtHost host.Host // Host taking part in the DHT, normally host
+	logger  *logrus.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// Discovery methods; mdnsMu guards mdnsService, which starts and stops
+	// with the visibility
+	mdnsMu           sync.Mutex
 	mdnsService      mdns.Service
 	dht              *dual.DHT
 	routingDiscovery *drouting.RoutingDiscovery
 
-	// Bootstrap peers for DHT
-	bootstrapPeers []peer.AddrInfo
+	// DHT of Xelvra nodes holding name records
+	nameDHT *dht.IpfsDHT
+
+	// Bootstrap peers for DHT, and those used when none of them can be
+	// reached; both are guarded by mu
+	bootstrapPeers    []peer.AddrInfo
+	bootstrapFallback []peer.AddrInfo
 
 	// Local discovery cache (LRU)
 	localPeerCache map[peer.ID]*peer.AddrInfo
@@ -49,6 +69,47 @@ type DiscoveryManager struct {
 	// Hierarchical discovery priorities
 	localDiscoveryActive  bool
 	globalDiscoveryActive bool
+
+	// LAN presence beacons: the last sequence number sent, and the replay
+	// check for received ones
+	beaconSeq uint64
+	beacons   *BeaconTracker
+
+	// probes limits the answers to subnet scans
+	probes probeLimiter
+
+	// localDisabled turns off the phases that reach peers directly: LAN
+	// discovery and hole punching
+	localDisabled bool
+
+	// mdnsOff and broadcastOff turn mDNS and UDP broadcast discovery off
+	// by configuration; guarded by mu
+	mdnsOff      bool
+	broadcastOff bool
+
+	// visibility decides what is announced, to the peers contacts returns;
+	// both are guarded by mu, as is started. reannounce wakes the DHT
+	// announcer when the level changes and announces spaces its rounds.
+	visibility Visibility
+	contacts   func() []peer.ID
+	started    bool
+	reannounce chan struct{}
+	announces  *AnnounceLimiter
+
+	// rendezvous are the application keys announced and looked up in the
+	// DHT besides the visibility namespaces; guarded by mu
+	rendezvous []string
+
+	// subscribers are told about peers found for the first time, at other
+	// addresses or lost, and lastSeen is when and how each discovered peer
+	// was last found; guarded by mu
+	subscribers    map[int]func(DiscoveryEvent)
+	nextSubscriber int
+	lastSeen       map[peer.ID]sighting
+
+	// energy spaces the periodic discovery rounds; nil keeps the normal
+	// intervals
+	energy *EnergyManager
 }
 
 // NewDiscoveryManager creates a new discovery manager
@@ -60,11 +121,13 @@ func NewDiscoveryManager(h host.Host, logger *logrus.Logger) *DiscoveryManager {
 
 	return &DiscoveryManager{
 		host:            h,
+		dhtHost:         h,
 		logger:          logger,
 		ctx:             ctx,
 		cancel:          cancel,
 		bootstrapPeers:  bootstrapPeers,
 		discoveredPeers: make(map[peer.ID]*peer.AddrInfo),
+		lastSeen:        make(map[peer.ID]sighting),
 		localPeerCache:  make(map[peer.ID]*peer.AddrInfo),
 		cacheMaxSize:    100, // LRU cache for 100 local peers
 		cacheOrder:      make([]peer.ID, 0),
@@ -72,38 +135,160 @@ func NewDiscoveryManager(h host.Host, logger *logrus.Logger) *DiscoveryManager {
 			MDNSActive:     false,
 			DHTActive:      false,
 			UDPBroadcast:   false,
-			BootstrapPeers: make([]string, len(bootstrapPeers)),
+			BootstrapPeers: peerIDStrings(bootstrapPeers),
 			KnownPeers:     0,
 			LastDiscovery:  time.Now(),
+			Visibility:     string(VisibilityEveryone),
 		},
 		localDiscoveryActive:  false,
 		globalDiscoveryActive: false,
+		beaconSeq:             uint64(time.Now().UnixNano()),
+		beacons:               NewBeaconTracker(),
+		visibility:            VisibilityEveryone,
+		reannounce:            make(chan struct{}, 1),
+		announces:             NewAnnounceLimiter(AnnounceMinInterval),
+	}
+}
+
+// DisableLocalDiscovery turns off IPv6 link-local, mDNS and UDP broadcast
+// discovery and hole punching, which reveal the local address. It must be
+// called before Start.
+func (dm *DiscoveryManager) DisableLocalDiscovery() {
+	dm.localDisabled = true
+}
+
+// SetLANMethods turns mDNS and UDP broadcast discovery on or off. Once
+// started, mDNS starts or stops at once and beacons are no longer sent or
+// accepted.
+func (dm *DiscoveryManager) SetLANMethods(mdns, broadcast bool) {
+	dm.mu.Lock()
+	dm.mdnsOff, dm.broadcastOff = !mdns, !broadcast
+	started := dm.started
+	if started && !dm.localDisabled {
+		dm.status.UDPBroadcast = broadcast
+	}
+	dm.mu.Unlock()
+
+	if started {
+		dm.updateMDNS()
+	}
+}
+
+// lanMethods reports whether mDNS and UDP broadcast discovery are on
+func (dm *DiscoveryManager) lanMethods() (bool, bool) {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return !dm.mdnsOff, !dm.broadcastOff
+}
+
+// SetBootstrapPeers replaces the bootstrap peers the DHT joins through; none
+// leaves the DHT to the peers found otherwise. It must be called before
+// Start.
+func (dm *DiscoveryManager) SetBootstrapPeers(peers []peer.AddrInfo) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.bootstrapPeers = peers
+	dm.status.BootstrapPeers = peerIDStrings(peers)
+}
+
+// UseDHTHost runs the DHT on h, a host with an identity of its own, instead
+// of the messaging host. Presence is then not advertised, since that would
+// link the two identities. It must be called before Start.
+func (dm *DiscoveryManager) UseDHTHost(h host.Host) {
+	dm.dhtHost = h
+}
+
+// refreshDHTs refreshes the routing tables of the discovery and name
+// DHTs
+func (dm *DiscoveryManager) refreshDHTs() {
+	if dm.dht != nil {
+		if err := dm.dht.Bootstrap(dm.ctx); err != nil {
+			dm.logger.WithError(err).Debug("Failed to refresh the discovery DHT")
+		}
+	}
+	if dm.nameDHT != nil {
+		if err := dm.nameDHT.Bootstrap(dm.ctx); err != nil {
+			dm.logger.WithError(err).Debug("Failed to refresh the name DHT")
+		}
+	}
+}
+
+// SetEnergyManager has em lengthen and batch the periodic discovery rounds
+// in battery saver mode, and suspend DHT lookups while idle. It must be
+// called before Start.
+func (dm *DiscoveryManager) SetEnergyManager(em *EnergyManager) {
+	dm.energy = em
+}
+
+// SetContactSource sets where the peers announced to at the contact levels
+// come from. It must be called before Start.
+func (dm *DiscoveryManager) SetContactSource(contacts func() []peer.ID) {
+	dm.mu.Lock()
+	dm.contacts = contacts
+	dm.mu.Unlock()
+}
+
+// SetVisibility changes who discovery announces the node to. Once started,
+// mDNS starts or stops with it and the DHT announcements are redone for the
+// new level, no sooner than AnnounceMinInterval after the last round.
+// Provider records already in the DHT stay until they expire.
+func (dm *DiscoveryManager) SetVisibility(v Visibility) {
+	dm.mu.Lock()
+	dm.visibility = v
+	dm.status.Visibility = string(v)
+	started := dm.started
+	dm.mu.Unlock()
+
+	if !started {
+		return
 	}
+	dm.updateMDNS()
+	dm.wakeAnnouncer()
+}
+
+// Visibility returns who discovery announces the node to
+func (dm *DiscoveryManager) Visibility() Visibility {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return dm.visibility
+}
+
+// contactIDs returns the peers announced to at the contact levels
+func (dm *DiscoveryManager) contactIDs() []peer.ID {
+	dm.mu.RLock()
+	contacts := dm.contacts
+	dm.mu.RUnlock()
+
+	if contacts == nil {
+		return nil
+	}
+	return contacts()
 }
 
 // Start begins hierarchical peer discovery: IPv6 → mDNS → hole punching → relay
 func (dm *DiscoveryManager) Start() error {
+	dm.mu.Lock()
+	dm.started = true
+	dm.mu.Unlock()
+
+	if dm.localDisabled {
+		return dm.startGlobalOnly()
+	}
+
 	dm.logger.Info("Starting hierarchical peer discovery: IPv6 → mDNS → UDP → DHT → Hole Punching → Relay...")
 
 	// Phase 1: IPv6 Link-Local Discovery (highest priority, immediate)
 	go dm.startIPv6LinkLocalDiscovery()
 	dm.logger.Info("Phase 1: IPv6 link-local discovery started")
 
-	// Phase 2: mDNS discovery (local network, fast)
-	if err := dm.startMDNS(); err != nil {
-		dm.logger.WithError(err).Warn("Failed to start mDNS discovery")
-	} else {
-		dm.mu.Lock()
-		dm.status.MDNSActive = true
-		dm.localDiscoveryActive = true
-		dm.mu.Unlock()
-		dm.logger.Info("Phase 2: mDNS discovery started")
-	}
+	// Phase 2: mDNS discovery (local network, fast), while visible to everyone
+	dm.updateMDNS()
 
-	// Phase 3: UDP broadcast discovery (local network fallback)
+	// Phase 3: UDP broadcast discovery (local network fallback), which
+	// keeps listening while turned off so it can be turned on again
 	go dm.startUDPBroadcast()
 	dm.mu.Lock()
-	dm.status.UDPBroadcast = true
+	dm.status.UDPBroadcast = !dm.broadcastOff
 	dm.mu.Unlock()
 	dm.logger.Info("Phase 3: UDP broadcast discovery started")
 
@@ -126,31 +311,61 @@ func (dm *DiscoveryManager) Start() error {
 	go dm.startRelayServerManagement()
 	dm.logger.Info("Phase 6: Relay server management started")
 
+	go dm.expireDiscoveredPeers()
+
 	dm.logger.Info("Hierarchical peer discovery started successfully - all 6 phases active")
 	return nil
 }
 
+// startGlobalOnly starts DHT discovery and relay management, the phases
+// that work through a proxy
+func (dm *DiscoveryManager) startGlobalOnly() error {
+	dm.logger.Info("Starting peer discovery through the proxy: DHT → Relay (LAN discovery and hole punching disabled)")
+
+	if err := dm.startDHT(); err != nil {
+		dm.logger.WithError(err).Warn("Failed to start DHT discovery")
+	} else {
+		dm.mu.Lock()
+		dm.status.DHTActive = true
+		dm.globalDiscoveryActive = true
+		dm.mu.Unlock()
+	}
+
+	go dm.startRelayServerManagement()
+	go dm.expireDiscoveredPeers()
+	return nil
+}
+
 // Stop stops peer discovery
 func (dm *DiscoveryManager) Stop() error {
 	dm.logger.Info("Stopping peer discovery...")
 
 	dm.cancel()
 
+	dm.mdnsMu.Lock()
 	if dm.mdnsService != nil {
 		if err := dm.mdnsService.Close(); err != nil {
 			dm.logger.WithError(err).Warn("Failed to close mDNS service")
 		}
+		dm.mdnsService = nil
 	}
+	dm.mdnsMu.Unlock()
 
 	if dm.dht != nil {
 		if err := dm.dht.Close(); err != nil {
 			dm.logger.WithError(err).Warn("Failed to close DHT")
 		}
 	}
+	if dm.nameDHT != nil {
+		if err := dm.nameDHT.Close(); err != nil {
+			dm.logger.WithError(err).Warn("Failed to close name DHT")
+		}
+	}
 
 	dm.mu.Lock()
 	dm.status.MDNSActive = false
 	dm.status.DHTActive = false
+	dm.status.PresenceAdvertised = false
 	dm.status.UDPBroadcast = false
 	dm.mu.Unlock()
 
@@ -168,6 +383,7 @@ func (dm *DiscoveryManager) GetStatus() *DiscoveryStatus {
 
 	// Copy status to avoid race conditions
 	status := *dm.status
+	status.Rendezvous = slices.Clone(dm.rendezvous)
 	return &status
 }
 
@@ -194,6 +410,58 @@ func (dm *DiscoveryManager) GetPeerAddresses(peerID peer.ID) []multiaddr.Multiad
 	return nil
 }
 
+// AddDiscoveredPeer records a peer learned through an out-of-band mechanism,
+// such as an invite, as found through method
+func (dm *DiscoveryManager) AddDiscoveredPeer(info peer.AddrInfo, method string) {
+	if info.ID == dm.host.ID() {
+		return
+	}
+	dm.recordDiscoveredPeer(info, method)
+
+	dm.logger.WithFields(logrus.Fields{
+		"peer_id": info.ID.String(),
+		"method":  method,
+	}).Info("Added discovered peer")
+}
+
+// recordDiscoveredPeer stores a peer found through method and reports it
+// when it is new or at other addresses. Methods that carry no addresses,
+// as UDP broadcast, keep those found by others.
+func (dm *DiscoveryManager) recordDiscoveredPeer(info peer.AddrInfo, method string) {
+	dm.mu.Lock()
+	known := dm.discoveredPeers[info.ID]
+	if known != nil && len(info.Addrs) == 0 {
+		info.Addrs = known.Addrs
+	}
+	dm.discoveredPeers[info.ID] = &info
+	dm.lastSeen[info.ID] = sighting{at: time.Now(), method: method}
+	dm.status.LastDiscovery = time.Now()
+	dm.mu.Unlock()
+
+	switch {
+	case known == nil:
+		dm.emit(newDiscoveryEvent(DiscoveryFound, info, method))
+	case !sameAddrs(known.Addrs, info.Addrs):
+		dm.emit(newDiscoveryEvent(DiscoveryUpdated, info, method))
+	}
+}
+
+// putValue stores a record in the DHT
+func (dm *DiscoveryManager) putValue(ctx context.Context, key string, value []byte) error {
+	if dm.nameDHT == nil {
+		return fmt.Errorf("DHT not started")
+	}
+	return dm.nameDHT.PutValue(ctx, key, value)
+}
+
+// getValue retrieves the best record for key from the DHT
+func (dm *DiscoveryManager) getValue(ctx context.Context, key string) ([]byte, error) {
+	if dm.nameDHT == nil {
+		return nil, fmt.Errorf("DHT not started")
+	}
+	return dm.nameDHT.GetValue(ctx, key)
+}
+
 // startMDNS starts mDNS peer discovery
 func (dm *DiscoveryManager) startMDNS() error {
 	dm.logger.Info("Starting mDNS service with service name: xelvra-p2p")
@@ -212,6 +480,49 @@ func (dm *DiscoveryManager) startMDNS() error {
 	return nil
 }
 
+// updateMDNS runs mDNS while the visibility announces on the LAN and it is
+// not turned off, and stops it otherwise. mDNS answers every query on the
+// LAN, so it cannot be limited to contacts.
+func (dm *DiscoveryManager) updateMDNS() {
+	if dm.localDisabled || dm.ctx.Err() != nil {
+		return
+	}
+
+	dm.mdnsMu.Lock()
+	defer dm.mdnsMu.Unlock()
+
+	visibility := dm.Visibility()
+	mdnsOn, _ := dm.lanMethods()
+	switch {
+	case visibility.AnnouncesOnLAN() && mdnsOn && dm.mdnsService == nil:
+		if err := dm.startMDNS(); err != nil {
+			dm.logger.WithError(err).Warn("Failed to start mDNS discovery")
+			return
+		}
+		dm.mu.Lock()
+		dm.status.MDNSActive = true
+		dm.localDiscoveryActive = true
+		dm.mu.Unlock()
+		dm.logger.Info("mDNS discovery started")
+
+	case (!visibility.AnnouncesOnLAN() || !mdnsOn) && dm.mdnsService != nil:
+		if err := dm.mdnsService.Close(); err != nil {
+			dm.logger.WithError(err).Warn("Failed to close mDNS service")
+		}
+		dm.mdnsService = nil
+		dm.mu.Lock()
+		dm.status.MDNSActive = false
+		dm.mu.Unlock()
+		dm.logger.WithFields(logrus.Fields{"visibility": visibility, "mdns": mdnsOn}).Info("mDNS discovery stopped")
+
+	case !mdnsOn:
+		dm.logger.Info("mDNS discovery turned off by configuration")
+
+	case !visibility.AnnouncesOnLAN():
+		dm.logger.WithField("visibility", visibility).Info("mDNS discovery off, the node is not visible to everyone")
+	}
+}
+
 // startUDPBroadcast starts UDP broadcast discovery for local network
 func (dm *DiscoveryManager) startUDPBroadcast() {
 	dm.logger.Info("Starting UDP broadcast discovery...")
@@ -220,22 +531,15 @@ func (dm *DiscoveryManager) startUDPBroadcast() {
 	go dm.listenUDPBroadcast()
 
 	// Send periodic broadcasts
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-dm.ctx.Done():
-			return
-		case <-ticker.C:
-			dm.sendUDPBroadcast()
-		}
+	for dm.energy.Wait(dm.ctx, BeaconInterval) {
+		dm.sendUDPBroadcast()
 	}
 }
 
-// listenUDPBroadcast listens for UDP broadcast messages
+// listenUDPBroadcast listens for UDP broadcast messages, for beacons sent
+// to the IPv6 all-nodes group on the same port, and for subnet scans
 func (dm *DiscoveryManager) listenUDPBroadcast() {
-	addr, err := net.ResolveUDPAddr("udp", ":42424")
+	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", beaconPort))
 	if err != nil {
 		dm.logger.WithError(err).Error("Failed to resolve UDP broadcast address")
 		return
@@ -252,7 +556,7 @@ func (dm *DiscoveryManager) listenUDPBroadcast() {
 		}
 	}()
 
-	dm.logger.Info("Listening for UDP broadcasts on :42424")
+	dm.logger.WithField("port", beaconPort).Info("Listening for UDP broadcasts and IPv6 multicast beacons")
 
 	buffer := make([]byte, 1024)
 	for {
@@ -273,16 +577,50 @@ func (dm *DiscoveryManager) listenUDPBroadcast() {
 				continue
 			}
 
+			if IsSubnetProbe(buffer[:n]) {
+				dm.answerSubnetProbe(conn, buffer[:n], remoteAddr)
+				continue
+			}
 			dm.handleUDPBroadcast(buffer[:n], remoteAddr)
 		}
 	}
 }
 
-// sendUDPBroadcast sends UDP broadcast message
+// presenceBeacon signs a new presence beacon, or returns nil while the node
+// is not visible to everyone or UDP broadcast is turned off
+func (dm *DiscoveryManager) presenceBeacon() []byte {
+	if _, broadcastOn := dm.lanMethods(); !broadcastOn || !dm.Visibility().AnnouncesOnLAN() {
+		return nil
+	}
+
+	key := dm.host.Peerstore().PrivKey(dm.host.ID())
+	if key == nil {
+		dm.logger.Warn("No host key available to sign the presence beacon")
+		return nil
+	}
+	beacon, err := NewPresenceBeacon(key, atomic.AddUint64(&dm.beaconSeq, 1), time.Now())
+	if err != nil {
+		dm.logger.WithError(err).Warn("Failed to create presence beacon")
+		return nil
+	}
+	message, err := beacon.Marshal()
+	if err != nil {
+		dm.logger.WithError(err).Warn("Failed to encode presence beacon")
+		return nil
+	}
+	return message
+}
+
+// sendUDPBroadcast broadcasts a signed presence beacon over IPv4, while
+// visible to everyone
 func (dm *DiscoveryManager) sendUDPBroadcast() {
-	message := fmt.Sprintf("XELVRA_PEER:%s", dm.host.ID().String())
+	message := dm.presenceBeacon()
+	if message == nil {
+		return
+	}
 
-	conn, err := net.Dial("udp", "255.255.255.255:42424")
+	target := fmt.Sprintf("255.255.255.255:%d", beaconPort)
+	conn, err := net.Dial("udp4", target)
 	if err != nil {
 		dm.logger.WithError(err).Warn("Failed to create UDP broadcast connection - check firewall/network")
 		return
@@ -293,30 +631,26 @@ func (dm *DiscoveryManager) sendUDPBroadcast() {
 		}
 	}()
 
-	_, err = conn.Write([]byte(message))
+	_, err = conn.Write(message)
 	if err != nil {
 		dm.logger.WithError(err).Warn("Failed to send UDP broadcast")
 		return
 	}
 
 	dm.logger.WithFields(logrus.Fields{
-		"message": message,
-		"target":  "255.255.255.255:42424",
+		"target":  target,
 		"peer_id": dm.host.ID().String(),
 	}).Info("Sent UDP broadcast for peer discovery")
 }
 
 // handleUDPBroadcast handles received UDP broadcast messages
 func (dm *DiscoveryManager) handleUDPBroadcast(data []byte, remoteAddr *net.UDPAddr) {
-	message := string(data)
-	if len(message) < 12 || message[:12] != "XELVRA_PEER:" {
+	if _, broadcastOn := dm.lanMethods(); !broadcastOn {
 		return
 	}
-
-	peerIDStr := message[12:]
-	peerID, err := peer.Decode(peerIDStr)
+	peerID, err := dm.beacons.Accept(data, time.Now())
 	if err != nil {
-		dm.logger.WithError(err).Debug("Invalid peer ID in UDP broadcast")
+		dm.logger.WithError(err).WithField("remote_addr", remoteAddr.String()).Debug("Ignoring UDP broadcast")
 		return
 	}
 
@@ -330,16 +664,8 @@ func (dm *DiscoveryManager) handleUDPBroadcast(data []byte, remoteAddr *net.UDPA
 		"remote_addr": remoteAddr.String(),
 	}).Info("Discovered peer via UDP broadcast")
 
-	// Create basic peer info (UDP broadcast doesn't provide addresses)
-	peerInfo := &peer.AddrInfo{
-		ID:    peerID,
-		Addrs: []multiaddr.Multiaddr{}, // No addresses from UDP broadcast
-	}
-
-	dm.mu.Lock()
-	dm.discoveredPeers[peerID] = peerInfo
-	dm.status.LastDiscovery = time.Now()
-	dm.mu.Unlock()
+	// UDP broadcast doesn't provide addresses
+	dm.recordDiscoveredPeer(peer.AddrInfo{ID: peerID}, "udp")
 }
 
 // discoveryNotifee handles mDNS discovery notifications
@@ -353,10 +679,7 @@ func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
 		"addrs":   pi.Addrs,
 	}).Info("Discovered peer via mDNS")
 
-	n.dm.mu.Lock()
-	n.dm.discoveredPeers[pi.ID] = &pi
-	n.dm.status.LastDiscovery = time.Now()
-	n.dm.mu.Unlock()
+	n.dm.recordDiscoveredPeer(pi, "mdns")
 
 	// Try to connect to the discovered peer
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -369,43 +692,12 @@ func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
 	}
 }
 
-// getBootstrapPeers returns a list of bootstrap peers for DHT
-func getBootstrapPeers() []peer.AddrInfo {
-	// Use IPFS bootstrap peers as they run compatible DHT and relay services
-	bootstrapAddrs := []string{
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmbLHAnMoJPWSCR5Zp9FCS47PpbUANZBTokb6BPWjkp8Bk",
-		"/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
-		// Additional relay servers for better NAT traversal
-		"/ip4/147.75.77.187/tcp/4001/p2p/QmQCU2EcMqAqQPR2i9bChDtGNJchTbq5TbXJJ16u19uLTa",
-		"/ip4/147.75.195.153/tcp/4001/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
-	}
-
-	var bootstrapPeers []peer.AddrInfo
-	for _, addrStr := range bootstrapAddrs {
-		addr, err := multiaddr.NewMultiaddr(addrStr)
-		if err != nil {
-			continue
-		}
-
-		peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
-		if err != nil {
-			continue
-		}
-
-		bootstrapPeers = append(bootstrapPeers, *peerInfo)
-	}
-
-	return bootstrapPeers
-}
-
 // startDHT initializes and starts the DHT for peer discovery
 func (dm *DiscoveryManager) startDHT() error {
 	dm.logger.Info("Starting DHT for global peer discovery...")
 
 	// Create DHT with bootstrap peers
-	dht, err := dual.New(dm.ctx, dm.host)
+	dht, err := dual.New(dm.ctx, dm.dhtHost, dhtOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to create DHT: %w", err)
 	}
@@ -417,14 +709,32 @@ func (dm *DiscoveryManager) startDHT() error {
 		dm.logger.WithError(err).Warn("DHT bootstrap failed, continuing anyway")
 	}
 
-	// Connect to bootstrap peers
-	go dm.connectToBootstrapPeers()
+	// Names are found among the Xelvra nodes connected to, and their peers
+	if nameDHT, err := newNameDHT(dm.ctx, dm.dhtHost); err != nil {
+		dm.logger.WithError(err).Warn("Failed to create name DHT, names cannot be claimed or resolved")
+	} else {
+		dm.nameDHT = nameDHT
+		if err := nameDHT.Bootstrap(dm.ctx); err != nil {
+			dm.logger.WithError(err).Debug("Name DHT bootstrap failed")
+		}
+	}
+
+	// Connect to bootstrap peers, and keep checking them
+	go dm.maintainBootstrap()
 
 	// Create routing discovery
 	dm.routingDiscovery = drouting.NewRoutingDiscovery(dm.dht)
 
-	// Start advertising our presence
-	go dm.advertisePresence()
+	// Start advertising our presence, unless the DHT identity must stay
+	// unlinked from ours
+	if dm.dhtHost == dm.host {
+		dm.mu.Lock()
+		dm.status.PresenceAdvertised = true
+		dm.mu.Unlock()
+		go dm.advertisePresence()
+	} else {
+		dm.logger.Info("DHT uses an ephemeral identity, presence is not advertised")
+	}
 
 	// Start discovering peers via DHT
 	go dm.discoverViaDHT()
@@ -436,73 +746,70 @@ func (dm *DiscoveryManager) startDHT() error {
 	return nil
 }
 
-// connectToBootstrapPeers connects to bootstrap peers for DHT
-func (dm *DiscoveryManager) connectToBootstrapPeers() {
-	dm.logger.Info("Connecting to bootstrap peers...")
-
-	connected := 0
-	for _, peerInfo := range dm.bootstrapPeers {
-		ctx, cancel := context.WithTimeout(dm.ctx, 10*time.Second)
-
-		if err := dm.host.Connect(ctx, peerInfo); err != nil {
-			dm.logger.WithError(err).WithField("peer_id", peerInfo.ID.String()).Debug("Failed to connect to bootstrap peer")
-		} else {
-			dm.logger.WithField("peer_id", peerInfo.ID.String()).Info("Connected to bootstrap peer")
-			connected++
-		}
-
-		cancel()
-	}
-
-	dm.logger.WithFields(logrus.Fields{
-		"connected": connected,
-		"total":     len(dm.bootstrapPeers),
-	}).Info("Bootstrap peer connection completed")
-
-	// Update status
-	dm.mu.Lock()
-	for i, peer := range dm.bootstrapPeers {
-		if i < len(dm.status.BootstrapPeers) {
-			dm.status.BootstrapPeers[i] = peer.ID.String()
-		}
-	}
-	dm.mu.Unlock()
-}
-
-// advertisePresence advertises our presence in the DHT
+// advertisePresence advertises our presence in the DHT, again whenever the
+// visibility changes
 func (dm *DiscoveryManager) advertisePresence() {
 	if dm.routingDiscovery == nil {
 		return
 	}
 
-	ticker := time.NewTicker(5 * time.Minute) // Advertise every 5 minutes
-	defer ticker.Stop()
-
 	// Advertise immediately
 	dm.doAdvertise()
 
 	for {
+		waitCtx, stopWait := context.WithCancel(dm.ctx)
 		select {
 		case <-dm.ctx.Done():
+			stopWait()
 			return
-		case <-ticker.C:
-			dm.doAdvertise()
+		case <-dm.energy.After(waitCtx, 5*time.Minute): // Advertise every 5 minutes
+		case <-dm.reannounce:
+			if wait := dm.announces.Delay(time.Now()); wait > 0 {
+				select {
+				case <-dm.ctx.Done():
+					stopWait()
+					return
+				case <-time.After(wait):
+				}
+			}
 		}
+		stopWait()
+		dm.doAdvertise()
 	}
 }
 
-// doAdvertise performs the actual advertisement
+// doAdvertise performs the actual advertisement under the namespaces of the
+// current visibility and, unless invisible, the joined rendezvous keys
 func (dm *DiscoveryManager) doAdvertise() {
-	ctx, cancel := context.WithTimeout(dm.ctx, 30*time.Second)
-	defer cancel()
+	dm.announces.Mark(time.Now())
 
-	// Advertise with Xelvra namespace
-	_, err := dm.routingDiscovery.Advertise(ctx, "xelvra-p2p")
-	if err != nil {
-		dm.logger.WithError(err).Debug("Failed to advertise presence in DHT")
-	} else {
-		dm.logger.Debug("Successfully advertised presence in DHT")
+	visibility := dm.Visibility()
+	namespaces := AnnounceNamespaces(visibility, dm.host.ID(), dm.contactIDs())
+	if visibility != VisibilityInvisible {
+		namespaces = append(namespaces, dm.rendezvousNamespaces()...)
+	}
+	if len(namespaces) == 0 {
+		dm.logger.WithField("visibility", visibility).Debug("Nothing to advertise in DHT")
+		return
+	}
+
+	advertised := 0
+	for _, namespace := range namespaces {
+		ctx, cancel := context.WithTimeout(dm.ctx, 30*time.Second)
+		_, err := dm.routingDiscovery.Advertise(ctx, namespace)
+		cancel()
+		if err != nil {
+			dm.logger.WithError(err).WithField("namespace", namespace).Debug("Failed to advertise presence in DHT")
+			continue
+		}
+		advertised++
 	}
+
+	dm.logger.WithFields(logrus.Fields{
+		"visibility": visibility,
+		"namespaces": len(namespaces),
+		"advertised": advertised,
+	}).Debug("Advertised presence in DHT")
 }
 
 // discoverViaDHT discovers peers using DHT
@@ -511,38 +818,57 @@ func (dm *DiscoveryManager) discoverViaDHT() {
 		return
 	}
 
-	ticker := time.NewTicker(2 * time.Minute) // Discover every 2 minutes
-	defer ticker.Stop()
-
 	// Discover immediately after a short delay to allow DHT to bootstrap
-	time.Sleep(30 * time.Second)
+	select {
+	case <-dm.ctx.Done():
+		return
+	case <-time.After(30 * time.Second):
+	}
 	dm.doDHTDiscovery()
 
-	for {
-		select {
-		case <-dm.ctx.Done():
-			return
-		case <-ticker.C:
-			dm.doDHTDiscovery()
+	// Discover every 2 minutes, but not while idle in battery saver mode
+	for dm.energy.Wait(dm.ctx, 2*time.Minute) {
+		if dm.energy.SuspendRefreshes() {
+			dm.logger.Debug("Skipping DHT discovery while idle in battery saver mode")
+			continue
 		}
+		dm.doDHTDiscovery()
 	}
 }
 
-// doDHTDiscovery performs the actual DHT peer discovery
+// doDHTDiscovery performs the actual DHT peer discovery, under the public
+// namespace, those contacts announce under and the joined rendezvous keys
 func (dm *DiscoveryManager) doDHTDiscovery() {
-	ctx, cancel := context.WithTimeout(dm.ctx, 60*time.Second)
-	defer cancel()
-
 	dm.logger.Debug("Discovering peers via DHT...")
 
-	// Find peers advertising the Xelvra namespace
-	peerChan, err := dm.routingDiscovery.FindPeers(ctx, "xelvra-p2p")
+	namespaces := append(LookupNamespaces(dm.host.ID(), dm.contactIDs()), dm.rendezvousNamespaces()...)
+	discovered := 0
+	for _, namespace := range namespaces {
+		if dm.ctx.Err() != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(dm.ctx, 60*time.Second)
+		discovered += len(dm.findPeers(ctx, namespace))
+		cancel()
+	}
+
+	if discovered > 0 {
+		dm.logger.WithField("discovered", discovered).Info("DHT peer discovery completed")
+	} else {
+		dm.logger.Debug("No new peers discovered via DHT")
+	}
+}
+
+// findPeers connects to the peers advertising namespace and returns those
+// found
+func (dm *DiscoveryManager) findPeers(ctx context.Context, namespace string) []peer.AddrInfo {
+	peerChan, err := dm.routingDiscovery.FindPeers(ctx, namespace)
 	if err != nil {
-		dm.logger.WithError(err).Debug("Failed to start DHT peer discovery")
-		return
+		dm.logger.WithError(err).WithField("namespace", namespace).Debug("Failed to start DHT peer discovery")
+		return nil
 	}
 
-	discovered := 0
+	var discovered []peer.AddrInfo
 	for peerInfo := range peerChan {
 		// Skip ourselves
 		if peerInfo.ID == dm.host.ID() {
@@ -555,10 +881,7 @@ func (dm *DiscoveryManager) doDHTDiscovery() {
 		}).Info("Discovered peer via DHT")
 
 		// Store discovered peer
-		dm.mu.Lock()
-		dm.discoveredPeers[peerInfo.ID] = &peerInfo
-		dm.status.LastDiscovery = time.Now()
-		dm.mu.Unlock()
+		dm.recordDiscoveredPeer(peerInfo, "dht")
 
 		// Try to connect to the discovered peer
 		go func(pi peer.AddrInfo) {
@@ -572,14 +895,9 @@ func (dm *DiscoveryManager) doDHTDiscovery() {
 			}
 		}(peerInfo)
 
-		discovered++
-	}
-
-	if discovered > 0 {
-		dm.logger.WithField("discovered", discovered).Info("DHT peer discovery completed")
-	} else {
-		dm.logger.Debug("No new peers discovered via DHT")
+		discovered = append(discovered, peerInfo)
 	}
+	return discovered
 }
 
 // addToLocalCache adds a peer to the local LRU cache
@@ -625,22 +943,14 @@ func (dm *DiscoveryManager) getFromLocalCache(peerID peer.ID) (*peer.AddrInfo, b
 	return nil, false
 }
 
-// isLocalPeer checks if a peer is in the local network (same subnet)
+// isLocalPeer checks if a peer is in the local network: a private IPv4 or
+// unique local IPv6 address, loopback or IPv6 link-local
 func (dm *DiscoveryManager) isLocalPeer(peerInfo *peer.AddrInfo) bool {
 	for _, addr := range peerInfo.Addrs {
-		addrStr := addr.String()
-		// Check for local network addresses
-		if strings.Contains(addrStr, "192.168.") ||
-			strings.Contains(addrStr, "10.") ||
-			strings.Contains(addrStr, "172.16.") ||
-			strings.Contains(addrStr, "172.17.") ||
-			strings.Contains(addrStr, "172.18.") ||
-			strings.Contains(addrStr, "172.19.") ||
-			strings.Contains(addrStr, "172.2") ||
-			strings.Contains(addrStr, "172.30.") ||
-			strings.Contains(addrStr, "172.31.") ||
-			strings.Contains(addrStr, "127.0.0.1") ||
-			strings.Contains(addrStr, "fe80::") { // IPv6 link-local
+		if manet.IsPrivateAddr(addr) || manet.IsIPLoopback(addr) {
+			return true
+		}
+		if ip, err := manet.ToIP(addr); err == nil && ip.IsLinkLocalUnicast() {
 			return true
 		}
 	}
@@ -651,21 +961,19 @@ func (dm *DiscoveryManager) isLocalPeer(peerInfo *peer.AddrInfo) bool {
 func (dm *DiscoveryManager) startIPv6LinkLocalDiscovery() {
 	dm.logger.Info("Starting IPv6 link-local discovery (Phase 1 - highest priority)...")
 
-	ticker := time.NewTicker(15 * time.Second) // Check every 15 seconds
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-dm.ctx.Done():
-			return
-		case <-ticker.C:
-			dm.discoverIPv6LinkLocal()
-		}
+	for dm.energy.Wait(dm.ctx, 15*time.Second) { // Check every 15 seconds
+		dm.discoverIPv6LinkLocal()
 	}
 }
 
-// discoverIPv6LinkLocal discovers peers on IPv6 link-local network
+// discoverIPv6LinkLocal announces the node on every IPv6 link, while
+// visible to everyone
 func (dm *DiscoveryManager) discoverIPv6LinkLocal() {
+	message := dm.presenceBeacon()
+	if message == nil {
+		return
+	}
+
 	// Get all network interfaces
 	interfaces, err := net.Interfaces()
 	if err != nil {
@@ -675,8 +983,8 @@ func (dm *DiscoveryManager) discoverIPv6LinkLocal() {
 
 	discovered := 0
 	for _, iface := range interfaces {
-		// Skip loopback and down interfaces
-		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+		// Skip loopback, down and non-multicast interfaces
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
 			continue
 		}
 
@@ -694,9 +1002,10 @@ func (dm *DiscoveryManager) discoverIPv6LinkLocal() {
 					}).Debug("Found IPv6 link-local address")
 
 					// Try to discover peers on this link-local network
-					if dm.scanIPv6LinkLocalNetwork(ipnet, iface.Name) {
+					if dm.scanIPv6LinkLocalNetwork(iface.Name, message) {
 						discovered++
 					}
+					break // One beacon per link
 				}
 			}
 		}
@@ -707,30 +1016,26 @@ func (dm *DiscoveryManager) discoverIPv6LinkLocal() {
 	}
 }
 
-// scanIPv6LinkLocalNetwork scans for peers on IPv6 link-local network
-func (dm *DiscoveryManager) scanIPv6LinkLocalNetwork(network *net.IPNet, interfaceName string) bool {
-	// For IPv6 link-local, we use multicast discovery
-	// Send to all-nodes multicast address ff02::1
-	multicastAddr := "ff02::1"
-
-	conn, err := net.Dial("udp6", fmt.Sprintf("[%s%%25%s]:42425", multicastAddr, interfaceName))
+// scanIPv6LinkLocalNetwork sends a presence beacon to the all-nodes
+// multicast group of one interface, which peers listening on the broadcast
+// port receive like an IPv4 broadcast
+func (dm *DiscoveryManager) scanIPv6LinkLocalNetwork(interfaceName string, message []byte) bool {
+	target := net.JoinHostPort(beaconIPv6Group+"%"+interfaceName, strconv.Itoa(beaconPort))
+	conn, err := net.Dial("udp6", target)
 	if err != nil {
 		dm.logger.WithError(err).Debug("Failed to create IPv6 multicast connection")
 		return false
 	}
 	defer conn.Close()
 
-	message := fmt.Sprintf("XELVRA_IPV6:%s", dm.host.ID().String())
-	_, err = conn.Write([]byte(message))
-	if err != nil {
+	if _, err := conn.Write(message); err != nil {
 		dm.logger.WithError(err).Debug("Failed to send IPv6 multicast discovery")
 		return false
 	}
 
 	dm.logger.WithFields(logrus.Fields{
 		"interface": interfaceName,
-		"target":    multicastAddr,
-		"message":   message,
+		"target":    target,
 	}).Debug("Sent IPv6 link-local discovery multicast")
 
 	return true
@@ -740,16 +1045,8 @@ func (dm *DiscoveryManager) scanIPv6LinkLocalNetwork(network *net.IPNet, interfa
 func (dm *DiscoveryManager) startHolePunchingService() {
 	dm.logger.Info("Starting NAT hole punching service (Phase 5)...")
 
-	ticker := time.NewTicker(1 * time.Minute) // Check every minute
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-dm.ctx.Done():
-			return
-		case <-ticker.C:
-			dm.attemptHolePunching()
-		}
+	for dm.energy.Wait(dm.ctx, time.Minute) { // Check every minute
+		dm.attemptHolePunching()
 	}
 }
 
@@ -831,16 +1128,8 @@ func (dm *DiscoveryManager) attemptSimultaneousOpen(ctx context.Context, peerID
 func (dm *DiscoveryManager) startRelayServerManagement() {
 	dm.logger.Info("Starting relay server management (Phase 6 - final fallback)...")
 
-	ticker := time.NewTicker(2 * time.Minute) // Check every 2 minutes
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-dm.ctx.Done():
-			return
-		case <-ticker.C:
-			dm.manageRelayServers()
-		}
+	for dm.energy.Wait(dm.ctx, 2*time.Minute) { // Check every 2 minutes
+		dm.manageRelayServers()
 	}
 }
 
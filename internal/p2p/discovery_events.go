@@ -0,0 +1,289 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// DiscoveryEventsFileName is where the running node appends what
+	// discovery finds, for 'discover --watch' to follow
+	DiscoveryEventsFileName = "discovery.jsonl"
+
+	// maxDiscoveryEventsSize is the size beyond which the events file is
+	// started over
+	maxDiscoveryEventsSize = 1 << 20
+
+	// discoveryWatchInterval is how often a watcher checks the events file
+	discoveryWatchInterval = 500 * time.Millisecond
+
+	// DiscoveredPeerTTL is how long a discovered peer that is not connected
+	// is kept without being found again, and discoveryExpiryInterval how
+	// often that is checked. LAN beacons come every 30 seconds and DHT
+	// lookups every 2 minutes.
+	DiscoveredPeerTTL       = 10 * time.Minute
+	discoveryExpiryInterval = time.Minute
+)
+
+// DiscoveryEventType says what discovery learned about a peer
+type DiscoveryEventType string
+
+const (
+	// DiscoveryFound is a peer discovery had not found before
+	DiscoveryFound DiscoveryEventType = "found"
+
+	// DiscoveryUpdated is a known peer found at other addresses
+	DiscoveryUpdated DiscoveryEventType = "updated"
+
+	// DiscoveryLost is a peer neither connected nor found again for
+	// DiscoveredPeerTTL; Method is how it was last found
+	DiscoveryLost DiscoveryEventType = "lost"
+)
+
+// sighting is when and how discovery last found a peer
+type sighting struct {
+	at     time.Time
+	method string
+}
+
+// DiscoveryEvent is a peer found by discovery, written as one JSON object
+// per line
+type DiscoveryEvent struct {
+	Time   time.Time          `json:"time"`
+	Type   DiscoveryEventType `json:"type"`
+	PeerID string             `json:"peer_id"`
+	Method string             `json:"method"` // mdns, udp, dht, ipv6, invite...
+	Addrs  []string           `json:"addrs,omitempty"`
+}
+
+// newDiscoveryEvent describes a peer found through method
+func newDiscoveryEvent(eventType DiscoveryEventType, info peer.AddrInfo, method string) DiscoveryEvent {
+	event := DiscoveryEvent{Time: time.Now(), Type: eventType, PeerID: info.ID.String(), Method: method}
+	for _, addr := range info.Addrs {
+		event.Addrs = append(event.Addrs, addr.String())
+	}
+	slices.Sort(event.Addrs)
+	return event
+}
+
+// key identifies what an event says, to drop repeats
+func (e DiscoveryEvent) key() string {
+	return string(e.Type) + " " + e.PeerID + " " + e.Method + " " + strings.Join(e.Addrs, " ")
+}
+
+// sameAddrs reports whether two address lists hold the same addresses
+func sameAddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, addr := range a {
+		if !slices.ContainsFunc(b, addr.Equal) {
+			return false
+		}
+	}
+	return true
+}
+
+// OnDiscoveryEvent calls fn with each peer discovery finds for the first
+// time or at other addresses, and each peer it loses, until the returned
+// function is called. fn is called from the discovery goroutines and should
+// return quickly.
+func (dm *DiscoveryManager) OnDiscoveryEvent(fn func(DiscoveryEvent)) (unsubscribe func()) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.subscribers == nil {
+		dm.subscribers = make(map[int]func(DiscoveryEvent))
+	}
+	id := dm.nextSubscriber
+	dm.nextSubscriber++
+	dm.subscribers[id] = fn
+
+	return func() {
+		dm.mu.Lock()
+		defer dm.mu.Unlock()
+		delete(dm.subscribers, id)
+	}
+}
+
+// emit tells the subscribers about an event
+func (dm *DiscoveryManager) emit(event DiscoveryEvent) {
+	dm.mu.RLock()
+	subscribers := make([]func(DiscoveryEvent), 0, len(dm.subscribers))
+	for _, fn := range dm.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	dm.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// expireDiscoveredPeers drops the discovered peers that have been neither
+// connected nor found again for DiscoveredPeerTTL, until discovery stops
+func (dm *DiscoveryManager) expireDiscoveredPeers() {
+	ticker := time.NewTicker(discoveryExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			dm.expireDiscoveredPeersAt(now)
+		}
+	}
+}
+
+// expireDiscoveredPeersAt drops the peers last found before now less
+// DiscoveredPeerTTL that are not connected, and reports them lost
+func (dm *DiscoveryManager) expireDiscoveredPeersAt(now time.Time) {
+	var lost []DiscoveryEvent
+	dm.mu.Lock()
+	for id, seen := range dm.lastSeen {
+		if now.Sub(seen.at) < DiscoveredPeerTTL || dm.host.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+		info := dm.discoveredPeers[id]
+		if info == nil {
+			info = &peer.AddrInfo{ID: id}
+		}
+		lost = append(lost, newDiscoveryEvent(DiscoveryLost, *info, seen.method))
+		delete(dm.discoveredPeers, id)
+		delete(dm.lastSeen, id)
+	}
+	dm.mu.Unlock()
+
+	for _, event := range lost {
+		dm.logger.WithField("peer_id", event.PeerID).Debug("Lost discovered peer")
+		dm.emit(event)
+	}
+}
+
+// discoveryEventLog appends discovery events to a file
+type discoveryEventLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// append writes an event, starting the file over once it is too large
+func (l *discoveryEventLog) append(event DiscoveryEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if info, err := os.Stat(l.path); err == nil && info.Size() > maxDiscoveryEventsSize {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(l.path, flags, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WatchDiscoveryEvents calls fn with each event appended to the events file
+// at path from now on, until ctx ends. An event that repeats one already
+// seen since the peer was last lost is skipped.
+func WatchDiscoveryEvents(ctx context.Context, path string, fn func(DiscoveryEvent)) error {
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+	seen := make(map[string]map[string]bool)
+
+	ticker := time.NewTicker(discoveryWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				offset = 0
+				continue
+			}
+			return err
+		}
+		if info.Size() < offset {
+			// Started over by the node
+			offset = 0
+		}
+		if info.Size() == offset {
+			continue
+		}
+
+		read, err := readDiscoveryEvents(path, offset, func(event DiscoveryEvent) {
+			if event.Type == DiscoveryLost {
+				delete(seen, event.PeerID)
+				fn(event)
+				return
+			}
+			if seen[event.PeerID] == nil {
+				seen[event.PeerID] = make(map[string]bool)
+			}
+			if key := event.key(); !seen[event.PeerID][key] {
+				seen[event.PeerID][key] = true
+				fn(event)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		offset += read
+	}
+}
+
+// readDiscoveryEvents reads the complete lines of the events file from
+// offset and returns how many bytes they took
+func readDiscoveryEvents(path string, offset int64, fn func(DiscoveryEvent)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var read int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line is read again once complete
+			return read, nil
+		}
+		if err != nil {
+			return read, err
+		}
+		read += int64(len(line))
+		var event DiscoveryEvent
+		if json.Unmarshal(bytes.TrimSpace(line), &event) == nil && event.PeerID != "" {
+			fn(event)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// dnsaddrResolveTimeout bounds looking up the peers a DNS name lists
+const dnsaddrResolveTimeout = 10 * time.Second
+
+// isBareDNSAddr reports whether addr is /dnsaddr/<domain> alone, naming the
+// peers listed in the TXT records of _dnsaddr.<domain> rather than one peer
+func isBareDNSAddr(addr ma.Multiaddr) bool {
+	first, rest := ma.SplitFirst(addr)
+	return first != nil && first.Protocol().Code == ma.P_DNSADDR && len(rest) == 0
+}
+
+// ResolveDNSAddr looks up the peers a bare /dnsaddr/<domain> lists. Each
+// peer gets /dnsaddr/<domain>/p2p/<peer ID>, which is resolved again on every
+// dial and so follows the records as the operator changes addresses, and
+// the addresses the records hold now.
+func ResolveDNSAddr(ctx context.Context, resolver *madns.Resolver, addr ma.Multiaddr) ([]ma.Multiaddr, error) {
+	if !isBareDNSAddr(addr) {
+		return nil, fmt.Errorf("%s is not a /dnsaddr/<domain> address", addr)
+	}
+	records, err := resolver.Resolve(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", addr, err)
+	}
+
+	var resolved []ma.Multiaddr
+	for _, record := range records {
+		id, err := record.ValueForProtocol(ma.P_P2P)
+		if err != nil {
+			continue
+		}
+		named, err := ma.NewMultiaddr(addr.String() + "/p2p/" + id)
+		if err != nil {
+			continue
+		}
+		resolved = append(resolved, named, record)
+	}
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("%s lists no peers: publish TXT records \"dnsaddr=<multiaddr>/p2p/<peer ID>\" at _dnsaddr.%s", addr, dnsaddrDomain(addr))
+	}
+	return ma.Unique(resolved), nil
+}
+
+// dnsaddrDomain returns the domain of a /dnsaddr/ address
+func dnsaddrDomain(addr ma.Multiaddr) string {
+	domain, _ := addr.ValueForProtocol(ma.P_DNSADDR)
+	return domain
+}
+
+// ValidateBootstrapAddrs checks bootstrap addresses: each must end in the
+// /p2p/ ID of the peer, or be a bare /dnsaddr/<domain> listing peers
+func ValidateBootstrapAddrs(addrs []string) error {
+	for _, addr := range addrs {
+		m, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return fmt.Errorf("invalid bootstrap address %q: %w", addr, err)
+		}
+		if isBareDNSAddr(m) {
+			continue
+		}
+		if _, err := ParseBootstrapPeers([]string{addr}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveBootstrapPeers parses bootstrap addresses like ParseBootstrapPeers,
+// first looking up the peers each bare /dnsaddr/<domain> lists. Names that
+// cannot be resolved are reported in the error, alongside the peers found
+// from the other addresses.
+func ResolveBootstrapPeers(ctx context.Context, addrs []string) ([]peer.AddrInfo, error) {
+	if err := ValidateBootstrapAddrs(addrs); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsaddrResolveTimeout)
+	defer cancel()
+
+	var expanded []string
+	var errs []error
+	for _, addr := range addrs {
+		m := ma.StringCast(addr)
+		if !isBareDNSAddr(m) {
+			expanded = append(expanded, addr)
+			continue
+		}
+		resolved, err := ResolveDNSAddr(ctx, madns.DefaultResolver, m)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, r := range resolved {
+			expanded = append(expanded, r.String())
+		}
+	}
+
+	peers, err := ParseBootstrapPeers(expanded)
+	if err != nil {
+		return nil, err
+	}
+	return peers, errors.Join(errs...)
+}
+
+// ResolvePeerAddr reads the address of one peer, ending in /p2p/<peer ID>
+// or a bare /dnsaddr/<domain> that lists a single peer, as an identity link
+// without DID
+func ResolvePeerAddr(ctx context.Context, s string) (*IdentityURI, error) {
+	addr, err := ma.NewMultiaddr(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+
+	addrs := []ma.Multiaddr{addr}
+	if isBareDNSAddr(addr) {
+		ctx, cancel := context.WithTimeout(ctx, dnsaddrResolveTimeout)
+		defer cancel()
+		if addrs, err = ResolveDNSAddr(ctx, madns.DefaultResolver, addr); err != nil {
+			return nil, err
+		}
+	} else if _, err := addr.ValueForProtocol(ma.P_P2P); err != nil {
+		return nil, fmt.Errorf("address %s does not end in /p2p/<peer ID>", addr)
+	}
+
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) != 1 {
+		return nil, fmt.Errorf("%s lists %d peers, add /p2p/<peer ID> to pick one", addr, len(infos))
+	}
+	return &IdentityURI{PeerID: infos[0].ID, Addrs: infos[0].Addrs}, nil
+}
@@ -9,6 +9,41 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Battery saver settings
+const (
+	// BatterySaverFactor lengthens discovery intervals in battery saver
+	// mode, and DeepSleepFactor in deep sleep
+	BatterySaverFactor = 4
+	DeepSleepFactor    = 8
+
+	// BatterySaverWakeInterval is the duty cycle of battery saver mode:
+	// periodic work is moved to multiples of it, so discovery and
+	// keep-alives share one wake-up of the radio instead of each having
+	// their own
+	BatterySaverWakeInterval = time.Minute
+
+	// BatterySaverIdleAfter is how long without messages sent or received
+	// before the node counts as idle, and battery saver mode suspends DHT
+	// refreshes and lookups
+	BatterySaverIdleAfter = 5 * time.Minute
+
+	// DHTRefreshInterval is how often the DHT routing tables are refreshed,
+	// as the DHT library would do on its own
+	DHTRefreshInterval = 10 * time.Minute
+
+	// IdleEnergyBudgetMW is the idle energy budget for laptops and mobile
+	// devices
+	IdleEnergyBudgetMW = 20
+)
+
+// Rough power model of EstimatedPowerMW
+const (
+	basePowerMW        = 5.0  // Process running at all
+	cpuPowerMWPerPct   = 2.0  // Per percent of one core
+	memoryPowerMWPerMB = 0.1  // Per MB of heap
+	wakePowerMW        = 0.25 // Per radio wake-up a minute
+)
+
 // EnergyManager handles energy optimization strategies
 // Implements energy optimization from tmp/Energetická Optimalizace.md
 type EnergyManager struct {
@@ -22,6 +57,7 @@ type EnergyManager struct {
 	memoryUsage     int64
 	networkActivity int64
 	lastMeasurement time.Time
+	lastCPUTime     time.Duration
 	energyProfile   *EnergyProfile
 
 	// Adaptive polling
@@ -33,6 +69,16 @@ type EnergyManager struct {
 	deepSleepMode      bool
 	deepSleepThreshold float64 // Battery level threshold for deep sleep
 
+	// Battery saver mode
+	batterySaver  bool
+	changed       chan struct{} // Closed when the mode changes, so waits are recomputed
+	epoch         time.Time     // Wake-ups are aligned to multiples of BatterySaverWakeInterval from here
+	activity      func() int64  // Messages sent and received so far
+	activityCount int64
+	lastActivity  time.Time
+	lastWake      time.Time
+	wakeups       int // Since the last measurement
+
 	// Performance targets from README
 	targetIdleMemoryMB   int
 	targetIdleCPUPercent float64
@@ -53,13 +99,23 @@ type EnergyProfile struct {
 	AdaptivePollingActive bool   `json:"adaptive_polling_active"`
 	DHTPollInterval       string `json:"dht_poll_interval"`
 	HeartbeatInterval     string `json:"heartbeat_interval"`
+
+	// Battery saver status: whether it is on, whether the node is idle and
+	// DHT refreshes are suspended, and how often the radio is woken
+	BatterySaver       bool    `json:"battery_saver"`
+	Idle               bool    `json:"idle"`
+	RefreshSuspended   bool    `json:"refresh_suspended"`
+	WakeupsPerMinute   float64 `json:"wakeups_per_minute"`
+	EnergyBudgetMW     float64 `json:"energy_budget_mw"`
+	WithinEnergyBudget bool    `json:"within_energy_budget"`
 }
 
 // NewEnergyManager creates a new energy manager
 func NewEnergyManager(ctx context.Context, logger *logrus.Logger) *EnergyManager {
 	energyCtx, cancel := context.WithCancel(ctx)
 
-	return &EnergyManager{
+	now := time.Now()
+	em := &EnergyManager{
 		logger:               logger,
 		ctx:                  energyCtx,
 		cancel:               cancel,
@@ -67,13 +123,22 @@ func NewEnergyManager(ctx context.Context, logger *logrus.Logger) *EnergyManager
 		heartbeatInterval:    30 * time.Second, // Default heartbeat
 		batteryLevel:         1.0,              // Assume full battery initially
 		deepSleepThreshold:   0.15,             // 15% battery threshold
-		targetIdleMemoryMB:   20,               // From README
-		targetIdleCPUPercent: 1.0,              // From README
-		targetLatencyMs:      50,               // From README
+		changed:              make(chan struct{}),
+		epoch:                now,
+		lastActivity:         now,
+		lastMeasurement:      now,
+		targetIdleMemoryMB:   20,  // From README
+		targetIdleCPUPercent: 1.0, // From README
+		targetLatencyMs:      50,  // From README
 		energyProfile: &EnergyProfile{
-			LastUpdated: time.Now(),
+			BatteryLevel:   1.0,
+			EnergyBudgetMW: IdleEnergyBudgetMW,
+			LastUpdated:    now,
 		},
 	}
+	em.lastCPUTime, _ = processCPUTime()
+	em.updatePollingIntervalsLocked()
+	return em
 }
 
 // Start begins energy monitoring and optimization
@@ -91,6 +156,7 @@ func (em *EnergyManager) Start() error {
 		"target_idle_cpu_percent": em.targetIdleCPUPercent,
 		"target_latency_ms":       em.targetLatencyMs,
 		"deep_sleep_threshold":    em.deepSleepThreshold,
+		"battery_saver":           em.BatterySaver(),
 	}).Info("Energy optimization started with performance targets")
 
 	return nil
@@ -110,6 +176,8 @@ func (em *EnergyManager) GetEnergyProfile() *EnergyProfile {
 
 	// Create a copy to avoid race conditions
 	profile := *em.energyProfile
+	profile.Idle = em.idleLocked()
+	profile.RefreshSuspended = em.suspendRefreshesLocked()
 	return &profile
 }
 
@@ -126,7 +194,198 @@ func (em *EnergyManager) SetBatteryLevel(level float64) {
 		em.enterDeepSleepMode()
 	} else if level > em.deepSleepThreshold && em.deepSleepMode {
 		em.exitDeepSleepMode()
+	} else {
+		em.updatePollingIntervalsLocked()
+	}
+}
+
+// SetBatterySaver turns battery saver mode on or off. Waits in progress
+// are recomputed, so turning it off does not leave discovery waiting for
+// the longer interval.
+func (em *EnergyManager) SetBatterySaver(on bool) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	if em.batterySaver == on {
+		return
+	}
+	em.batterySaver = on
+	em.energyProfile.BatterySaver = on
+	em.updatePollingIntervalsLocked()
+	em.notifyChangeLocked()
+
+	em.logger.WithField("battery_saver", on).Info("Battery saver mode changed")
+}
+
+// BatterySaver reports whether battery saver mode is on
+func (em *EnergyManager) BatterySaver() bool {
+	if em == nil {
+		return false
+	}
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return em.batterySaver
+}
+
+// SetActivitySource gives the count of messages sent and received, from
+// which the manager tells whether the node is idle
+func (em *EnergyManager) SetActivitySource(activity func() int64) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.activity = activity
+	em.activityCount = activity()
+}
+
+// NoteActivity marks the node as in use now, ending idleness
+func (em *EnergyManager) NoteActivity() {
+	if em == nil {
+		return
 	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	wasSuspended := em.suspendRefreshesLocked()
+	em.lastActivity = time.Now()
+	if wasSuspended {
+		em.notifyChangeLocked()
+	}
+}
+
+// IsIdle reports whether no messages were sent or received for
+// BatterySaverIdleAfter
+func (em *EnergyManager) IsIdle() bool {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return em.idleLocked()
+}
+
+// SuspendRefreshes reports whether DHT refreshes and lookups are to be
+// skipped: in battery saver mode or deep sleep, while the node is idle
+func (em *EnergyManager) SuspendRefreshes() bool {
+	if em == nil {
+		return false
+	}
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return em.suspendRefreshesLocked()
+}
+
+// After returns a channel closed once periodic work with interval base is
+// due: after base, lengthened by BatterySaverFactor or DeepSleepFactor and
+// aligned to the next wake-up while saving energy. It is never closed if
+// ctx ends first. A nil manager waits for base.
+func (em *EnergyManager) After(ctx context.Context, base time.Duration) <-chan struct{} {
+	return em.after(ctx, base, false)
+}
+
+// KeepAliveAfter is After for keep-alives, which must not be late: the
+// interval is not lengthened, and while saving energy the keep-alive is
+// sent early, at the last wake-up before it is due, with the other work
+// done then.
+func (em *EnergyManager) KeepAliveAfter(ctx context.Context, interval time.Duration) <-chan struct{} {
+	return em.after(ctx, interval, true)
+}
+
+// Wait waits as After does, returning false if ctx ended first
+func (em *EnergyManager) Wait(ctx context.Context, base time.Duration) bool {
+	select {
+	case <-em.After(ctx, base):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// after implements After and KeepAliveAfter
+func (em *EnergyManager) after(ctx context.Context, base time.Duration, keepAlive bool) <-chan struct{} {
+	due := make(chan struct{})
+	started := time.Now()
+	go func() {
+		for {
+			var changed chan struct{}
+			wait := base
+			if em != nil {
+				em.mu.RLock()
+				changed = em.changed
+				wait = time.Until(em.deadlineLocked(started, base, keepAlive))
+				em.mu.RUnlock()
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+				em.noteWake()
+				close(due)
+				return
+			case <-changed:
+				timer.Stop()
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return due
+}
+
+// deadlineLocked returns when work with interval base, waited for since
+// started, is due in the current mode
+func (em *EnergyManager) deadlineLocked(started time.Time, base time.Duration, keepAlive bool) time.Time {
+	factor := time.Duration(1)
+	if em.batterySaver {
+		factor = BatterySaverFactor
+	}
+	if em.deepSleepMode {
+		factor = DeepSleepFactor
+	}
+	if factor == 1 {
+		return started.Add(base)
+	}
+	if keepAlive {
+		// The last wake-up before it is due, unless that has passed
+		due := started.Add(base)
+		aligned := due.Add(-(due.Sub(em.epoch) % BatterySaverWakeInterval))
+		if aligned.After(time.Now()) {
+			return aligned
+		}
+		return due
+	}
+
+	due := started.Add(base * factor)
+	if offset := due.Sub(em.epoch) % BatterySaverWakeInterval; offset > 0 {
+		due = due.Add(BatterySaverWakeInterval - offset)
+	}
+	return due
+}
+
+// noteWake counts a wake-up for periodic work; work due within a second
+// of other work shares its wake-up
+func (em *EnergyManager) noteWake() {
+	if em == nil {
+		return
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	now := time.Now()
+	if now.Sub(em.lastWake) > time.Second {
+		em.wakeups++
+		em.lastWake = now
+	}
+}
+
+// notifyChangeLocked wakes the waits in progress to recompute when they
+// are due
+func (em *EnergyManager) notifyChangeLocked() {
+	close(em.changed)
+	em.changed = make(chan struct{})
+}
+
+// idleLocked reports whether the node is idle, with em.mu held
+func (em *EnergyManager) idleLocked() bool {
+	return time.Since(em.lastActivity) >= BatterySaverIdleAfter
+}
+
+// suspendRefreshesLocked implements SuspendRefreshes with em.mu held
+func (em *EnergyManager) suspendRefreshesLocked() bool {
+	return (em.batterySaver || em.deepSleepMode) && em.idleLocked()
 }
 
 // monitorEnergyUsage continuously monitors energy usage
@@ -148,30 +407,53 @@ func (em *EnergyManager) monitorEnergyUsage() {
 func (em *EnergyManager) measureEnergyUsage() {
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
+	cpuTime, cpuKnown := processCPUTime()
 
 	em.mu.Lock()
 	defer em.mu.Unlock()
 
+	now := time.Now()
+	elapsed := now.Sub(em.lastMeasurement)
+	if elapsed <= 0 {
+		return
+	}
+
+	// Messages sent or received since the last measurement end idleness
+	if em.activity != nil {
+		if count := em.activity(); count != em.activityCount {
+			em.activityCount = count
+			em.lastActivity = now
+		}
+	}
+
 	// Update memory usage (convert bytes to MB)
 	em.memoryUsage = int64(memStats.Alloc / 1024 / 1024)
 
-	// Estimate CPU usage (simplified - would need more sophisticated monitoring in production)
-	// For now, we'll use a basic heuristic based on goroutines and memory allocation rate
-	numGoroutines := runtime.NumGoroutine()
-	em.cpuUsage = float64(numGoroutines) * 0.1 // Rough estimate
+	// CPU time used since the last measurement, in percent of one core;
+	// without it, a rough estimate from the number of goroutines
+	if cpuKnown {
+		em.cpuUsage = float64(cpuTime-em.lastCPUTime) / float64(elapsed) * 100
+		em.lastCPUTime = cpuTime
+	} else {
+		em.cpuUsage = float64(runtime.NumGoroutine()) * 0.1
+	}
+	wakeupsPerMinute := float64(em.wakeups) / elapsed.Minutes()
+	em.wakeups = 0
+	em.lastMeasurement = now
 
 	// Update energy profile
 	em.energyProfile.CPUUsagePercent = em.cpuUsage
 	em.energyProfile.MemoryUsageMB = em.memoryUsage
-	em.energyProfile.LastUpdated = time.Now()
+	em.energyProfile.WakeupsPerMinute = wakeupsPerMinute
+	em.energyProfile.LastUpdated = now
 
 	// Estimate power consumption (simplified model)
-	// Base consumption + CPU factor + Memory factor
-	basePowerMW := 5.0                             // Base power consumption
-	cpuPowerMW := em.cpuUsage * 2.0                // CPU contribution
-	memoryPowerMW := float64(em.memoryUsage) * 0.1 // Memory contribution
-
-	em.energyProfile.EstimatedPowerMW = basePowerMW + cpuPowerMW + memoryPowerMW
+	// Base consumption + CPU factor + Memory factor + radio wake-ups
+	em.energyProfile.EstimatedPowerMW = basePowerMW +
+		em.cpuUsage*cpuPowerMWPerPct +
+		float64(em.memoryUsage)*memoryPowerMWPerMB +
+		wakeupsPerMinute*wakePowerMW
+	em.energyProfile.WithinEnergyBudget = em.energyProfile.EstimatedPowerMW <= IdleEnergyBudgetMW
 
 	// Log if usage exceeds targets
 	if em.memoryUsage > int64(em.targetIdleMemoryMB) {
@@ -181,7 +463,7 @@ func (em *EnergyManager) measureEnergyUsage() {
 		}).Warn("Memory usage exceeds target")
 	}
 
-	if em.cpuUsage > em.targetIdleCPUPercent {
+	if em.cpuUsage > em.targetIdleCPUPercent && em.idleLocked() {
 		em.logger.WithFields(logrus.Fields{
 			"current_cpu_percent": em.cpuUsage,
 			"target_cpu_percent":  em.targetIdleCPUPercent,
@@ -208,7 +490,12 @@ func (em *EnergyManager) adaptivePollingOptimization() {
 func (em *EnergyManager) optimizePollingIntervals() {
 	em.mu.Lock()
 	defer em.mu.Unlock()
+	em.updatePollingIntervalsLocked()
+}
 
+// updatePollingIntervalsLocked implements optimizePollingIntervals with
+// em.mu held
+func (em *EnergyManager) updatePollingIntervalsLocked() {
 	// Adjust intervals based on battery level
 	batteryFactor := em.batteryLevel
 
@@ -217,13 +504,17 @@ func (em *EnergyManager) optimizePollingIntervals() {
 	baseHeartbeatInterval := 30 * time.Second
 
 	// Adjust based on battery level
-	if batteryFactor < 0.2 { // Low battery
+	switch {
+	case em.deepSleepMode:
+		em.dhtPollInterval = 10 * time.Minute  // Very infrequent DHT queries
+		em.heartbeatInterval = 5 * time.Minute // Very infrequent heartbeats
+	case batteryFactor < 0.2 || em.batterySaver: // Low battery or saving it
 		em.dhtPollInterval = baseDHTInterval * 5         // 10 minutes
 		em.heartbeatInterval = baseHeartbeatInterval * 4 // 2 minutes
-	} else if batteryFactor < 0.5 { // Medium battery
+	case batteryFactor < 0.5: // Medium battery
 		em.dhtPollInterval = baseDHTInterval * 2         // 4 minutes
 		em.heartbeatInterval = baseHeartbeatInterval * 2 // 1 minute
-	} else { // Good battery
+	default: // Good battery
 		em.dhtPollInterval = baseDHTInterval
 		em.heartbeatInterval = baseHeartbeatInterval
 	}
@@ -235,19 +526,21 @@ func (em *EnergyManager) optimizePollingIntervals() {
 
 	em.logger.WithFields(logrus.Fields{
 		"battery_level":      em.batteryLevel,
+		"battery_saver":      em.batterySaver,
 		"dht_poll_interval":  em.dhtPollInterval,
 		"heartbeat_interval": em.heartbeatInterval,
 	}).Debug("Adaptive polling intervals updated")
 }
 
-// enterDeepSleepMode activates deep sleep mode for energy conservation
+// enterDeepSleepMode activates deep sleep mode for energy conservation,
+// with em.mu held
 func (em *EnergyManager) enterDeepSleepMode() {
 	em.deepSleepMode = true
 	em.energyProfile.DeepSleepActive = true
 
 	// Drastically reduce polling intervals
-	em.dhtPollInterval = 10 * time.Minute  // Very infrequent DHT queries
-	em.heartbeatInterval = 5 * time.Minute // Very infrequent heartbeats
+	em.updatePollingIntervalsLocked()
+	em.notifyChangeLocked()
 
 	em.logger.WithFields(logrus.Fields{
 		"battery_level": em.batteryLevel,
@@ -255,13 +548,14 @@ func (em *EnergyManager) enterDeepSleepMode() {
 	}).Warn("Entering deep sleep mode for energy conservation")
 }
 
-// exitDeepSleepMode deactivates deep sleep mode
+// exitDeepSleepMode deactivates deep sleep mode, with em.mu held
 func (em *EnergyManager) exitDeepSleepMode() {
 	em.deepSleepMode = false
 	em.energyProfile.DeepSleepActive = false
 
 	// Restore normal polling intervals
-	em.optimizePollingIntervals()
+	em.updatePollingIntervalsLocked()
+	em.notifyChangeLocked()
 
 	em.logger.WithField("battery_level", em.batteryLevel).Info("Exiting deep sleep mode")
 }
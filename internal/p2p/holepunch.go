@@ -0,0 +1,108 @@
+package p2p
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/sirupsen/logrus"
+)
+
+// HolePunchStatus counts how relayed connections were upgraded to direct
+// ones since the node started
+type HolePunchStatus struct {
+	Attempts    int       `json:"attempts"` // Hole punches tried
+	Upgrades    int       `json:"upgrades"` // Relayed connections that became direct
+	LastPeer    string    `json:"last_peer,omitempty"`
+	LastUpgrade time.Time `json:"last_upgrade,omitempty"`
+	LastError   string    `json:"last_error,omitempty"` // Why the last failed hole punch failed
+}
+
+// HolePunchTracer follows DCUtR, which the relayed side starts to replace a
+// relayed connection with a direct one: first by dialing the peer's public
+// addresses, then by both sides dialing each other at once
+type HolePunchTracer struct {
+	logger *logrus.Logger
+
+	mu     sync.Mutex
+	status HolePunchStatus
+}
+
+// NewHolePunchTracer creates a tracer that logs upgrades to logger
+func NewHolePunchTracer(logger *logrus.Logger) *HolePunchTracer {
+	return &HolePunchTracer{logger: logger}
+}
+
+// Trace implements holepunch.EventTracer
+func (t *HolePunchTracer) Trace(evt *holepunch.Event) {
+	fields := logrus.Fields{"peer_id": evt.Remote.String()}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e := evt.Evt.(type) {
+	case *holepunch.DirectDialEvt:
+		if !e.Success {
+			return
+		}
+		t.upgraded(evt)
+		t.logger.WithFields(fields).Info("Relayed connection upgraded: peer dialed directly")
+	case *holepunch.EndHolePunchEvt:
+		t.status.Attempts++
+		if !e.Success {
+			t.status.LastError = e.Error
+			t.logger.WithFields(fields).WithField("error", e.Error).Debug("Hole punch failed, staying on the relay")
+			return
+		}
+		t.upgraded(evt)
+		t.logger.WithFields(fields).WithField("took", e.EllapsedTime).Info("Relayed connection upgraded: hole punch succeeded")
+	}
+}
+
+// upgraded records an upgrade to a direct connection; t.mu must be held
+func (t *HolePunchTracer) upgraded(evt *holepunch.Event) {
+	t.status.Upgrades++
+	t.status.LastPeer = evt.Remote.String()
+	t.status.LastUpgrade = time.Unix(0, evt.Timestamp)
+}
+
+// Status returns the hole punching counts
+func (t *HolePunchTracer) Status() HolePunchStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// runReachability follows what AutoNAT finds out about whether peers can
+// dial this node, which decides whether relay slots are reserved
+func (n *PeerChatNode) runReachability() {
+	sub, err := n.host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		n.logger.WithError(err).Warn("Failed to follow reachability")
+		return
+	}
+	defer func() { _ = sub.Close() }()
+
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			reachability := e.(event.EvtLocalReachabilityChanged).Reachability
+			n.mu.Lock()
+			n.reachability = reachability
+			n.mu.Unlock()
+			n.logger.WithField("reachability", reachabilityName(reachability)).Info("AutoNAT reachability changed")
+		case <-n.ctx.Done():
+			return
+		}
+	}
+}
+
+// reachabilityName returns "public", "private" or "unknown"
+func reachabilityName(r network.Reachability) string {
+	return strings.ToLower(r.String())
+}
@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// URIScheme is the scheme of Xelvra links
+	URIScheme = "xelvra"
+
+	// maxURIAddrs caps the addresses in an identity link, which keeps its QR
+	// code small enough to scan from a terminal
+	maxURIAddrs = 4
+)
+
+// IdentityURI is what a node shares to be reached by scanning or pasting
+// it: its peer ID, DID and the addresses it listens on. It reads
+// xelvra://peer/<peer ID>?did=<DID>&addr=<multiaddr>...
+type IdentityURI struct {
+	PeerID peer.ID
+	DID    string
+	Addrs  []ma.Multiaddr
+}
+
+// NewIdentityURI builds the identity link of a node, keeping the addresses
+// others can dial: public ones first, then LAN ones, never loopback
+func NewIdentityURI(peerID peer.ID, did string, addrs []ma.Multiaddr) *IdentityURI {
+	var public, private []ma.Multiaddr
+	for _, addr := range addrs {
+		switch {
+		case manet.IsIPLoopback(addr):
+		case manet.IsPublicAddr(addr):
+			public = append(public, addr)
+		default:
+			private = append(private, addr)
+		}
+	}
+	shared := append(public, private...)
+	if len(shared) > maxURIAddrs {
+		shared = shared[:maxURIAddrs]
+	}
+	return &IdentityURI{PeerID: peerID, DID: did, Addrs: shared}
+}
+
+// String encodes the link
+func (u *IdentityURI) String() string {
+	query := url.Values{}
+	if u.DID != "" {
+		query.Set("did", u.DID)
+	}
+	for _, addr := range u.Addrs {
+		query.Add("addr", addr.String())
+	}
+	link := url.URL{Scheme: URIScheme, Host: "peer", Path: "/" + u.PeerID.String(), RawQuery: encodeLinkQuery(query)}
+	return link.String()
+}
+
+// encodeLinkQuery encodes the query of a link. Slashes and colons are valid
+// in a query; leaving them unescaped keeps multiaddrs readable and QR codes
+// smaller.
+func encodeLinkQuery(query url.Values) string {
+	return strings.NewReplacer("%2F", "/", "%3A", ":").Replace(query.Encode())
+}
+
+// parseLinkAddrs parses the multiaddrs of a link parameter
+func parseLinkAddrs(values []string) ([]ma.Multiaddr, error) {
+	var addrs []ma.Multiaddr
+	for _, s := range values {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address in link %q: %w", s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// AddrInfo returns the peer ID and addresses to dial
+func (u *IdentityURI) AddrInfo() peer.AddrInfo {
+	return peer.AddrInfo{ID: u.PeerID, Addrs: u.Addrs}
+}
+
+// ParseIdentityURI parses an identity link
+func ParseIdentityURI(s string) (*IdentityURI, error) {
+	link, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid link: %w", err)
+	}
+	if link.Scheme != URIScheme || link.Host != "peer" {
+		return nil, fmt.Errorf("not a %s://peer/ link", URIScheme)
+	}
+
+	peerID, err := peer.Decode(strings.TrimPrefix(link.Path, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID in link: %w", err)
+	}
+	u := &IdentityURI{PeerID: peerID}
+
+	query := link.Query()
+	if did := query.Get("did"); did != "" {
+		if !user.ValidateDID(did) {
+			return nil, fmt.Errorf("invalid DID in link: %s", did)
+		}
+		u.DID = did
+	}
+	if u.Addrs, err = parseLinkAddrs(query["addr"]); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,763 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	libp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/mr-tron/base58"
+	multiaddr "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// InviteProtocolID is served by temporary invite identities
+	InviteProtocolID = protocol.ID("/xelvra/invite/1.0.0")
+
+	// InviteCodePrefix marks an encoded invite code
+	InviteCodePrefix = "xinv1"
+
+	// Invite limits
+	DefaultInviteTTL  = time.Hour
+	MaxInviteTTL      = 7 * 24 * time.Hour
+	inviteTokenSize   = 16
+	inviteMaxFrame    = 4096
+	inviteHandshakeTO = 15 * time.Second
+)
+
+// Invite is a one-time, expiring invite backed by a temporary listen identity.
+// The temporary peer ID is the only identifier published in the invite code;
+// the real peer ID is revealed only to the peer that redeems the invite.
+type Invite struct {
+	ID         string    `json:"id"`
+	Token      string    `json:"token"`
+	PrivateKey []byte    `json:"private_key"` // Marshalled libp2p key of the temporary identity
+	Port       int       `json:"port"`
+	Addrs      []string  `json:"addrs"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Used       bool      `json:"used"`
+	UsedBy     string    `json:"used_by,omitempty"`
+}
+
+// InviteCode is the public part of an invite that is shared with other users
+type InviteCode struct {
+	PeerID    string   `json:"p"` // Temporary peer ID
+	Addrs     []string `json:"a"`
+	Token     string   `json:"t"`
+	ExpiresAt int64    `json:"e"`
+}
+
+// IsExpired returns true if the invite can no longer be redeemed
+func (inv *Invite) IsExpired() bool {
+	return time.Now().After(inv.ExpiresAt)
+}
+
+// IsActive returns true if the invite is unused and not expired
+func (inv *Invite) IsActive() bool {
+	return !inv.Used && !inv.IsExpired()
+}
+
+// Code returns the shareable invite code
+func (inv *Invite) Code() (string, error) {
+	privKey, err := crypto.UnmarshalPrivateKey(inv.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to load invite key: %w", err)
+	}
+	tempID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive invite peer ID: %w", err)
+	}
+
+	return EncodeInviteCode(&InviteCode{
+		PeerID:    tempID.String(),
+		Addrs:     inv.Addrs,
+		Token:     inv.Token,
+		ExpiresAt: inv.ExpiresAt.Unix(),
+	})
+}
+
+// EncodeInviteCode encodes an invite code into its shareable string form
+func EncodeInviteCode(code *InviteCode) (string, error) {
+	data, err := json.Marshal(code)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode invite: %w", err)
+	}
+	return InviteCodePrefix + base58.Encode(data), nil
+}
+
+// DecodeInviteCode parses a shareable invite string
+func DecodeInviteCode(s string) (*InviteCode, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, InviteCodePrefix) {
+		return nil, fmt.Errorf("invalid invite code prefix")
+	}
+
+	data, err := base58.Decode(s[len(InviteCodePrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode invite code: %w", err)
+	}
+
+	var code InviteCode
+	if err := json.Unmarshal(data, &code); err != nil {
+		return nil, fmt.Errorf("failed to parse invite code: %w", err)
+	}
+
+	if code.PeerID == "" || code.Token == "" || len(code.Addrs) == 0 {
+		return nil, fmt.Errorf("incomplete invite code")
+	}
+
+	return &code, nil
+}
+
+// getInvitesFilePath returns the path to the invites file
+func getInvitesFilePath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "invites.json"), nil
+}
+
+// loadInvites reads all stored invites
+func loadInvites() (map[string]*Invite, error) {
+	invites := make(map[string]*Invite)
+
+	path, err := getInvitesFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return invites, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &invites); err != nil {
+		return nil, fmt.Errorf("failed to parse invites file: %w", err)
+	}
+
+	return invites, nil
+}
+
+// invitesMu serializes changes to the invites file within this process;
+// lockInvitesFile does so across processes, such as the CLI and the node
+var invitesMu sync.Mutex
+
+// updateInvites reloads the invites under a lock and lets fn change them.
+// The file is written only when fn reports a change, so invites created or
+// revoked elsewhere in the meantime are kept.
+func updateInvites(fn func(invites map[string]*Invite) (bool, error)) error {
+	invitesMu.Lock()
+	defer invitesMu.Unlock()
+
+	path, err := getInvitesFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockInvitesFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock invites file: %w", err)
+	}
+	defer unlock()
+
+	invites, err := loadInvites()
+	if err != nil {
+		return err
+	}
+	changed, err := fn(invites)
+	if err != nil || !changed {
+		return err
+	}
+	return saveInvites(invites)
+}
+
+// saveInvites writes all invites to disk. Callers go through updateInvites.
+func saveInvites(invites map[string]*Invite) error {
+	path, err := getInvitesFilePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(invites, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// CreateInvite creates a new one-time invite valid for the given duration.
+// The invite is served by the node the next time it starts (or immediately if
+// it is already running and picks it up during its refresh cycle).
+func CreateInvite(ttl time.Duration) (*Invite, error) {
+	if ttl <= 0 {
+		ttl = DefaultInviteTTL
+	}
+	if ttl > MaxInviteTTL {
+		return nil, fmt.Errorf("invite TTL %v exceeds maximum of %v", ttl, MaxInviteTTL)
+	}
+
+	// Temporary identity that is never linked to the real one in public
+	privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invite key: %w", err)
+	}
+	keyBytes, err := crypto.MarshalPrivateKey(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal invite key: %w", err)
+	}
+
+	token := make([]byte, inviteTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate invite token: %w", err)
+	}
+
+	port, err := pickFreeTCPPort()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate invite port: %w", err)
+	}
+
+	now := time.Now()
+	invite := &Invite{
+		ID:         hex.EncodeToString(token[:4]),
+		Token:      hex.EncodeToString(token),
+		PrivateKey: keyBytes,
+		Port:       port,
+		Addrs:      inviteAddrs(port),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	err = updateInvites(func(invites map[string]*Invite) (bool, error) {
+		invites[invite.ID] = invite
+		return true, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+// ListInvites returns all stored invites sorted by creation time
+func ListInvites() ([]*Invite, error) {
+	invites, err := loadInvites()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Invite, 0, len(invites))
+	for _, inv := range invites {
+		result = append(result, inv)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// RevokeInvite removes an invite so it can no longer be redeemed
+func RevokeInvite(id string) error {
+	return updateInvites(func(invites map[string]*Invite) (bool, error) {
+		if _, exists := invites[id]; !exists {
+			return false, fmt.Errorf("invite not found: %s", id)
+		}
+		delete(invites, id)
+		return true, nil
+	})
+}
+
+// ForgetInvitesUsedBy removes the invites redeemed by peerID and returns
+// how many were removed
+func ForgetInvitesUsedBy(peerID string) (int, error) {
+	removed := 0
+	err := updateInvites(func(invites map[string]*Invite) (bool, error) {
+		for id, inv := range invites {
+			if inv.Used && inv.UsedBy == peerID {
+				delete(invites, id)
+				removed++
+			}
+		}
+		return removed > 0, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// pickFreeTCPPort asks the OS for a currently unused TCP port
+func pickFreeTCPPort() (int, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	if err := l.Close(); err != nil {
+		return 0, err
+	}
+	return port, nil
+}
+
+// inviteAddrs builds the multiaddrs published in an invite code, global
+// IPv6 addresses first as they reach the host without NAT
+func inviteAddrs(port int) []string {
+	var addrs, ip6Addrs []string
+
+	// Prefer the public address learned by a running node
+	if status, err := ReadNodeStatus(); err == nil && status != nil && status.NATInfo != nil && status.NATInfo.PublicIP != "" {
+		addrs = append(addrs, fmt.Sprintf("/ip4/%s/tcp/%d", status.NATInfo.PublicIP, port))
+	}
+
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return addrs
+	}
+	for _, addr := range ifaceAddrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			addrs = append(addrs, fmt.Sprintf("/ip4/%s/tcp/%d", ip4, port))
+		} else if ipnet.IP.IsGlobalUnicast() {
+			ip6Addrs = append(ip6Addrs, fmt.Sprintf("/ip6/%s/tcp/%d", ipnet.IP, port))
+		}
+	}
+	addrs = append(ip6Addrs, addrs...)
+
+	if len(addrs) == 0 {
+		addrs = append(addrs, fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port))
+	}
+	return addrs
+}
+
+// inviteHello is sent by the redeeming peer
+type inviteHello struct {
+	Token string `json:"token"`
+}
+
+// inviteWelcome reveals the real identity to the redeeming peer
+type inviteWelcome struct {
+	PeerID    string   `json:"peer_id,omitempty"`
+	DID       string   `json:"did,omitempty"`
+	Addrs     []string `json:"addrs,omitempty"`
+	Signature []byte   `json:"signature,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// inviteSigningPayload binds the real identity to a specific invite and redeemer
+func inviteSigningPayload(token string, tempID, redeemer, realID peer.ID) []byte {
+	return []byte(strings.Join([]string{"xelvra-invite", token, tempID.String(), redeemer.String(), realID.String()}, "|"))
+}
+
+// writeInviteFrame writes a length-prefixed JSON frame
+func writeInviteFrame(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal frame: %w", err)
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return fmt.Errorf("failed to write length: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write frame: %w", err)
+	}
+	return nil
+}
+
+// readInviteFrame reads a length-prefixed JSON frame
+func readInviteFrame(r io.Reader, v interface{}) error {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return fmt.Errorf("failed to read length: %w", err)
+	}
+	if length > inviteMaxFrame {
+		return fmt.Errorf("frame too large: %d bytes", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("failed to read frame: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// InviteManager serves active invites from temporary identities and
+// redeems invites created by other users
+type InviteManager struct {
+	host     host.Host
+	identity *user.MessengerID
+	logger   *logrus.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// psk keeps invite hosts in the node's private network, if any
+	psk pnet.PSK
+
+	mu    sync.Mutex
+	hosts map[string]host.Host // invite ID -> temporary host
+}
+
+// NewInviteManager creates a new invite manager for the real node host
+func NewInviteManager(h host.Host, identity *user.MessengerID, logger *logrus.Logger) *InviteManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &InviteManager{
+		host:     h,
+		identity: identity,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+		hosts:    make(map[string]host.Host),
+	}
+}
+
+// UsePrivateNetwork serves invites in the private network of psk. It must
+// be called before invites are served.
+func (im *InviteManager) UsePrivateNetwork(psk pnet.PSK) {
+	im.psk = psk
+}
+
+// Start begins serving stored invites
+func (im *InviteManager) Start() error {
+	im.refresh()
+	go im.maintenanceLoop()
+	return nil
+}
+
+// Stop shuts down all temporary invite hosts
+func (im *InviteManager) Stop() error {
+	im.cancel()
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for id, h := range im.hosts {
+		if err := h.Close(); err != nil {
+			im.logger.WithError(err).WithField("invite_id", id).Warn("Failed to close invite host")
+		}
+	}
+	im.hosts = make(map[string]host.Host)
+	return nil
+}
+
+// maintenanceLoop picks up new invites and retires expired ones
+func (im *InviteManager) maintenanceLoop() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-im.ctx.Done():
+			return
+		case <-ticker.C:
+			im.refresh()
+		}
+	}
+}
+
+// refresh reloads invites from disk, serving new ones and retiring stale ones
+func (im *InviteManager) refresh() {
+	var invites map[string]*Invite
+	err := updateInvites(func(stored map[string]*Invite) (bool, error) {
+		pruned := false
+		for id, inv := range stored {
+			if inv.IsExpired() {
+				delete(stored, id)
+				pruned = true
+			}
+		}
+		invites = stored
+		return pruned, nil
+	})
+	if err != nil {
+		im.logger.WithError(err).Warn("Failed to load invites")
+		return
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	// Retire hosts whose invites were used, revoked or expired
+	for id, h := range im.hosts {
+		if inv, ok := invites[id]; ok && inv.IsActive() {
+			continue
+		}
+		if err := h.Close(); err != nil {
+			im.logger.WithError(err).WithField("invite_id", id).Warn("Failed to close invite host")
+		}
+		delete(im.hosts, id)
+		im.logger.WithField("invite_id", id).Info("Invite retired")
+	}
+
+	// Serve newly created invites
+	for id, inv := range invites {
+		if !inv.IsActive() {
+			continue
+		}
+		if _, serving := im.hosts[id]; serving {
+			continue
+		}
+		h, err := im.serveInvite(inv)
+		if err != nil {
+			im.logger.WithError(err).WithField("invite_id", id).Warn("Failed to serve invite")
+			continue
+		}
+		im.hosts[id] = h
+	}
+}
+
+// serveInvite starts a temporary host for an invite
+func (im *InviteManager) serveInvite(inv *Invite) (host.Host, error) {
+	privKey, err := crypto.UnmarshalPrivateKey(inv.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load invite key: %w", err)
+	}
+
+	h, err := libp2p.New(
+		libp2p.Identity(privKey),
+		libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", inv.Port), fmt.Sprintf("/ip6/::/tcp/%d", inv.Port)),
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.PrivateNetwork(im.psk),
+		libp2p.Ping(false),
+		libp2p.DisableRelay(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invite host: %w", err)
+	}
+
+	inviteID := inv.ID
+	h.SetStreamHandler(InviteProtocolID, func(s network.Stream) {
+		im.handleInviteStream(inviteID, s)
+	})
+
+	im.logger.WithFields(logrus.Fields{
+		"invite_id":  inv.ID,
+		"temp_peer":  h.ID().String(),
+		"port":       inv.Port,
+		"expires_at": inv.ExpiresAt,
+	}).Info("Serving invite from temporary identity")
+
+	return h, nil
+}
+
+// handleInviteStream performs the invite handshake on a temporary host
+func (im *InviteManager) handleInviteStream(inviteID string, stream network.Stream) {
+	defer func() {
+		if err := stream.Close(); err != nil {
+			im.logger.WithError(err).Debug("Failed to close invite stream")
+		}
+	}()
+
+	if err := stream.SetDeadline(time.Now().Add(inviteHandshakeTO)); err != nil {
+		im.logger.WithError(err).Debug("Failed to set invite stream deadline")
+	}
+
+	// The remote peer is authenticated by the transport security handshake
+	redeemer := stream.Conn().RemotePeer()
+	tempID := stream.Conn().LocalPeer()
+
+	var hello inviteHello
+	if err := readInviteFrame(stream, &hello); err != nil {
+		im.logger.WithError(err).Warn("Failed to read invite hello")
+		return
+	}
+
+	// One-time: consume before revealing anything. The invite is checked
+	// against the file, as it may have been revoked since the last refresh.
+	valid := false
+	err := updateInvites(func(invites map[string]*Invite) (bool, error) {
+		inv, ok := invites[inviteID]
+		valid = ok && inv.IsActive() &&
+			subtle.ConstantTimeCompare([]byte(inv.Token), []byte(hello.Token)) == 1
+		if !valid {
+			return false, nil
+		}
+		inv.Used = true
+		inv.UsedBy = redeemer.String()
+		return true, nil
+	})
+	if err != nil {
+		im.logger.WithError(err).Warn("Failed to consume invite")
+		valid = false
+	}
+
+	if !valid {
+		im.logger.WithFields(logrus.Fields{
+			"invite_id": inviteID,
+			"redeemer":  redeemer.String(),
+		}).Warn("Rejected invalid or used invite")
+		if err := writeInviteFrame(stream, inviteWelcome{Error: "invite is invalid, used or expired"}); err != nil {
+			im.logger.WithError(err).Debug("Failed to send invite rejection")
+		}
+		return
+	}
+
+	realID := im.host.ID()
+	signature, err := im.identity.Sign(inviteSigningPayload(hello.Token, tempID, redeemer, realID))
+	if err != nil {
+		im.logger.WithError(err).Error("Failed to sign invite welcome")
+		return
+	}
+
+	addrs := make([]string, 0, len(im.host.Addrs()))
+	for _, addr := range im.host.Addrs() {
+		addrs = append(addrs, addr.String())
+	}
+
+	welcome := inviteWelcome{
+		PeerID:    realID.String(),
+		DID:       im.identity.GetDID(),
+		Addrs:     addrs,
+		Signature: signature,
+	}
+	if err := writeInviteFrame(stream, welcome); err != nil {
+		im.logger.WithError(err).Warn("Failed to send invite welcome")
+		return
+	}
+
+	im.logger.WithFields(logrus.Fields{
+		"invite_id": inviteID,
+		"redeemer":  redeemer.String(),
+	}).Info("Invite redeemed")
+
+	// Retiring the temporary identity closes its connections, which could
+	// drop the welcome in flight; the redeemer closes the stream once read
+	if err := stream.CloseWrite(); err == nil {
+		_, _ = io.Copy(io.Discard, stream)
+	}
+
+	// Retire the temporary identity right away
+	go im.refresh()
+}
+
+// Redeem contacts the temporary identity of an invite and returns the
+// verified real address information of the inviting peer
+func (im *InviteManager) Redeem(ctx context.Context, codeStr string) (*peer.AddrInfo, string, error) {
+	code, err := DecodeInviteCode(codeStr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if time.Now().Unix() > code.ExpiresAt {
+		return nil, "", fmt.Errorf("invite expired at %s", time.Unix(code.ExpiresAt, 0).Format(time.RFC3339))
+	}
+
+	tempID, err := peer.Decode(code.PeerID)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid invite peer ID: %w", err)
+	}
+
+	tempInfo := peer.AddrInfo{ID: tempID}
+	for _, a := range code.Addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			continue
+		}
+		tempInfo.Addrs = append(tempInfo.Addrs, ma)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, inviteHandshakeTO)
+	defer cancel()
+
+	if err := im.host.Connect(ctx, tempInfo); err != nil {
+		return nil, "", fmt.Errorf("failed to reach invite address: %w", err)
+	}
+	defer func() {
+		// The temporary identity has no further use
+		if err := im.host.Network().ClosePeer(tempID); err != nil {
+			im.logger.WithError(err).Debug("Failed to close invite connection")
+		}
+	}()
+
+	stream, err := im.host.NewStream(ctx, tempID, InviteProtocolID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open invite stream: %w", err)
+	}
+	defer func() {
+		if err := stream.Close(); err != nil {
+			im.logger.WithError(err).Debug("Failed to close invite stream")
+		}
+	}()
+
+	if err := writeInviteFrame(stream, inviteHello{Token: code.Token}); err != nil {
+		return nil, "", err
+	}
+
+	var welcome inviteWelcome
+	if err := readInviteFrame(stream, &welcome); err != nil {
+		return nil, "", fmt.Errorf("failed to read invite response: %w", err)
+	}
+	if welcome.Error != "" {
+		return nil, "", fmt.Errorf("invite rejected: %s", welcome.Error)
+	}
+
+	realID, err := peer.Decode(welcome.PeerID)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid peer ID in invite response: %w", err)
+	}
+
+	// Verify that the revealed identity really holds this invite
+	pubKey, err := realID.ExtractPublicKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to extract inviter public key: %w", err)
+	}
+	ok, err := pubKey.Verify(inviteSigningPayload(code.Token, tempID, im.host.ID(), realID), welcome.Signature)
+	if err != nil || !ok {
+		return nil, "", fmt.Errorf("invite response signature verification failed")
+	}
+
+	info := &peer.AddrInfo{ID: realID}
+	for _, a := range welcome.Addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			continue
+		}
+		info.Addrs = append(info.Addrs, ma)
+	}
+
+	im.logger.WithFields(logrus.Fields{
+		"real_peer": realID.String(),
+		"did":       welcome.DID,
+	}).Info("Invite redeemed successfully")
+
+	return info, welcome.DID, nil
+}
+
+// ActiveInvites returns the number of invites currently being served
+func (im *InviteManager) ActiveInvites() int {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	return len(im.hosts)
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package p2p
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockInvitesFile takes an exclusive lock on path, waiting for other
+// processes to release it
+func lockInvitesFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
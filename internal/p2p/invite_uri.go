@@ -0,0 +1,177 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// InviteURI is a shareable invitation: the DID of the inviter, relays it
+// can be reached through and either the code of a one-time invite or, for a
+// reusable invite, its peer ID and addresses. It reads
+// xelvra://invite?did=<DID>&name=<nickname>&relay=<circuit address>&token=<invite code>
+// with peer=<peer ID>&addr=<multiaddr> in place of the token when reusable.
+type InviteURI struct {
+	DID    string
+	Name   string // Nickname claimed by the inviter, suggested as contact name
+	Relays []ma.Multiaddr
+	Token  string // Code of a one-time invite, empty for reusable invites
+
+	// Set for reusable invites only; one-time invites reveal the peer ID
+	// to whoever redeems them first
+	PeerID peer.ID
+	Addrs  []ma.Multiaddr
+}
+
+// NewInviteURI builds the link of a one-time invite or, when invite is nil,
+// a reusable one. The identity and nickname come from the data directory;
+// relays and addresses from the running node, if any.
+func NewInviteURI(invite *Invite) (*InviteURI, error) {
+	identity, err := user.LoadMessengerID(defaultIdentityPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity, run 'peerchat-cli init' first: %w", err)
+	}
+	u := &InviteURI{DID: identity.GetDID()}
+	if claim, err := LoadNameClaim(); err == nil && claim != nil {
+		u.Name = claim.Name
+	}
+
+	var listenAddrs []ma.Multiaddr
+	if status, err := ReadNodeStatus(); err == nil && status != nil && status.IsRunning {
+		u.Relays, _ = parseLinkAddrs(status.RelayAddrs)
+		listenAddrs, _ = parseLinkAddrs(status.ListenAddrs)
+	}
+
+	if invite != nil {
+		if u.Token, err = invite.Code(); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	identityURI := NewIdentityURI(identity.GetPeerID(), u.DID, listenAddrs)
+	u.PeerID, u.Addrs = identityURI.PeerID, identityURI.Addrs
+	return u, nil
+}
+
+// String encodes the link
+func (u *InviteURI) String() string {
+	query := url.Values{}
+	if u.DID != "" {
+		query.Set("did", u.DID)
+	}
+	if u.Name != "" {
+		query.Set("name", u.Name)
+	}
+	if u.PeerID != "" {
+		query.Set("peer", u.PeerID.String())
+	}
+	for _, addr := range u.Addrs {
+		query.Add("addr", addr.String())
+	}
+	for _, addr := range u.Relays {
+		query.Add("relay", addr.String())
+	}
+	if u.Token != "" {
+		query.Set("token", u.Token)
+	}
+	link := url.URL{Scheme: URIScheme, Host: "invite", RawQuery: encodeLinkQuery(query)}
+	return link.String()
+}
+
+// ParseInviteURI parses an invite link; a bare invite code is read as a
+// one-time invite without hints
+func ParseInviteURI(s string) (*InviteURI, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, InviteCodePrefix) {
+		if _, err := DecodeInviteCode(s); err != nil {
+			return nil, err
+		}
+		return &InviteURI{Token: s}, nil
+	}
+
+	link, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link: %w", err)
+	}
+	if link.Scheme != URIScheme || link.Host != "invite" {
+		return nil, fmt.Errorf("not a %s://invite link or invite code", URIScheme)
+	}
+
+	query := link.Query()
+	u := &InviteURI{Name: query.Get("name"), Token: query.Get("token")}
+	if did := query.Get("did"); did != "" {
+		if !user.ValidateDID(did) {
+			return nil, fmt.Errorf("invalid DID in link: %s", did)
+		}
+		u.DID = did
+	}
+	if u.Token != "" {
+		if _, err := DecodeInviteCode(u.Token); err != nil {
+			return nil, fmt.Errorf("invalid invite token in link: %w", err)
+		}
+	}
+	if id := query.Get("peer"); id != "" {
+		if u.PeerID, err = peer.Decode(id); err != nil {
+			return nil, fmt.Errorf("invalid peer ID in link: %w", err)
+		}
+	}
+	if u.Token == "" && u.PeerID == "" {
+		return nil, fmt.Errorf("invite link has neither a token nor a peer ID")
+	}
+	if u.Addrs, err = parseLinkAddrs(query["addr"]); err != nil {
+		return nil, err
+	}
+	if u.Relays, err = parseLinkAddrs(query["relay"]); err != nil {
+		return nil, err
+	}
+	for _, addr := range u.Relays {
+		if !isRelayAddr(addr) {
+			return nil, fmt.Errorf("relay hint %s is not a /p2p-circuit address", addr)
+		}
+	}
+	return u, nil
+}
+
+// JoinInvite connects to the inviter of an invite link, redeeming its
+// one-time token if it has one, and returns the inviter's peer ID and DID.
+// The relays in the link are tried when the inviter cannot be reached
+// directly, and a token answered by another DID than the link names is
+// refused.
+func (n *PeerChatNode) JoinInvite(ctx context.Context, u *InviteURI) (peer.ID, string, error) {
+	if u.Token == "" {
+		info := peer.AddrInfo{ID: u.PeerID, Addrs: append(append([]ma.Multiaddr(nil), u.Addrs...), u.Relays...)}
+		if len(info.Addrs) == 0 {
+			info.Addrs = n.discoveryManager.GetPeerAddresses(info.ID)
+		}
+		if err := n.Connect(ctx, info); err != nil {
+			return "", "", err
+		}
+		return info.ID, u.DID, nil
+	}
+
+	if n.inviteManager == nil {
+		return "", "", fmt.Errorf("invite manager not initialized")
+	}
+	info, did, err := n.inviteManager.Redeem(ctx, u.Token)
+	if err != nil {
+		return "", "", err
+	}
+	if u.DID != "" && did != u.DID {
+		return "", "", fmt.Errorf("invite answered by %s, but the link is from %s", did, u.DID)
+	}
+
+	n.discoveryManager.AddDiscoveredPeer(*info, "invite")
+	err = n.Connect(ctx, *info)
+	if err != nil && len(u.Relays) > 0 {
+		err = n.Connect(ctx, peer.AddrInfo{ID: info.ID, Addrs: u.Relays})
+	}
+	if err != nil {
+		return info.ID, did, fmt.Errorf("invite verified but connection failed: %w", err)
+	}
+	return info.ID, did, nil
+}
@@ -0,0 +1,68 @@
+package p2p
+
+import (
+	"slices"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// randomPortAddrs returns addrs with every TCP and UDP port replaced by 0,
+// so the system picks a free one
+func randomPortAddrs(addrs []string) []string {
+	random := make([]string, len(addrs))
+	for i, addr := range addrs {
+		parts := strings.Split(addr, "/")
+		for j := 0; j+1 < len(parts); j++ {
+			if parts[j] == "tcp" || parts[j] == "udp" {
+				parts[j+1] = "0"
+			}
+		}
+		random[i] = strings.Join(parts, "/")
+	}
+	return random
+}
+
+// pinnedAddrs returns the addresses among addrs with a fixed port
+func pinnedAddrs(addrs []string) []string {
+	var pinned []string
+	for _, addr := range addrs {
+		if randomPortAddrs([]string{addr})[0] != addr {
+			pinned = append(pinned, addr)
+		}
+	}
+	return pinned
+}
+
+// announceFactory returns an AddrsFactory advertising announce before the
+// addresses the node listens on
+func announceFactory(announce []ma.Multiaddr) func([]ma.Multiaddr) []ma.Multiaddr {
+	return func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		return ma.Unique(slices.Concat(announce, addrs))
+	}
+}
+
+// listenOnFreePorts listens on a random port in place of each pinned
+// address the node could not listen on, e.g. because another program holds
+// the port
+func (n *PeerChatNode) listenOnFreePorts(requested []string) {
+	listening := n.host.Network().ListenAddresses()
+	for _, addr := range pinnedAddrs(requested) {
+		pinned, err := ma.NewMultiaddr(addr)
+		if err != nil || slices.ContainsFunc(listening, pinned.Equal) {
+			continue
+		}
+
+		fallback := randomPortAddrs([]string{addr})[0]
+		logger := n.logger.WithField("address", addr)
+		free, err := ma.NewMultiaddr(fallback)
+		if err == nil {
+			err = n.host.Network().Listen(free)
+		}
+		if err != nil {
+			logger.WithError(err).Warn("Could not listen on the configured address or a free port instead")
+			continue
+		}
+		logger.Warn("Configured listen address is in use, listening on a free port instead")
+	}
+}
@@ -0,0 +1,205 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
+	"github.com/pion/stun"
+	"github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+const (
+	// QUICMTUAuto lets QUIC discover the path MTU, except on networks
+	// recorded as dropping large packets
+	QUICMTUAuto = "auto"
+
+	// QUICMTUSafe keeps QUIC at the smallest packets it allows
+	QUICMTUSafe = "safe"
+
+	// QUICMinPacketSize is the smallest UDP payload QUIC may use
+	QUICMinPacketSize = 1200
+
+	// quicDefaultPacketSize is the UDP payload QUIC starts with before the
+	// path MTU is discovered
+	quicDefaultPacketSize = 1280
+
+	// mtuProbeAttempts is how often each probe size is sent
+	mtuProbeAttempts = 3
+
+	// mtuProbeTimeout bounds waiting for the answer to one probe
+	mtuProbeTimeout = time.Second
+
+	// stunProbePadding is a comprehension-optional attribute that pads STUN
+	// probes to size; servers ignore it
+	stunProbePadding stun.AttrType = 0xFFEE
+)
+
+// MTUProbeSizes are the UDP payload sizes probed: the QUIC minimum, the size
+// QUIC starts with, a common tunnel MTU and the Ethernet MTU
+var MTUProbeSizes = []int{QUICMinPacketSize, quicDefaultPacketSize, 1360, 1452}
+
+// PathMTUProbe is the result of probing which UDP packet sizes get through
+// on a network
+type PathMTUProbe struct {
+	Server string    `json:"server"`
+	Probed time.Time `json:"probed"`
+
+	// Delivered and Dropped are the probe sizes that were and were not
+	// answered
+	Delivered []int `json:"delivered"`
+	Dropped   []int `json:"dropped,omitempty"`
+}
+
+// LargestSize returns the largest probe size that got through
+func (p *PathMTUProbe) LargestSize() int {
+	largest := 0
+	for _, size := range p.Delivered {
+		largest = max(largest, size)
+	}
+	return largest
+}
+
+// BlackHole reports whether the network drops packets of the size QUIC
+// starts with while small ones get through. Path MTU discovery cannot
+// recover there, so QUIC keeps to its smallest packets.
+func (p *PathMTUProbe) BlackHole() bool {
+	largest := p.LargestSize()
+	return largest > 0 && largest < quicDefaultPacketSize
+}
+
+// ProbePathMTU sends STUN binding requests padded to each of sizes to the
+// first server that answers, with fragmentation forbidden where the platform
+// allows it, and reports which sizes got through
+func ProbePathMTU(ctx context.Context, servers []string, sizes []int) (*PathMTUProbe, error) {
+	var lastErr error
+	for _, server := range servers {
+		probe, err := probePathMTU(ctx, server, sizes)
+		if err == nil {
+			return probe, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no STUN servers")
+	}
+	return nil, lastErr
+}
+
+// probePathMTU probes sizes against a single STUN server
+func probePathMTU(ctx context.Context, server string, sizes []int) (*PathMTUProbe, error) {
+	addr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if err := setDontFragment(conn); err != nil {
+		return nil, fmt.Errorf("failed to forbid fragmentation: %w", err)
+	}
+
+	probe := &PathMTUProbe{Server: server, Probed: time.Now()}
+	for _, size := range sizes {
+		delivered, err := sendProbe(ctx, conn, size)
+		if err != nil {
+			return nil, err
+		}
+		if delivered {
+			probe.Delivered = append(probe.Delivered, size)
+		} else {
+			probe.Dropped = append(probe.Dropped, size)
+		}
+	}
+	if len(probe.Delivered) == 0 {
+		return nil, fmt.Errorf("%s did not answer", server)
+	}
+	return probe, nil
+}
+
+// sendProbe sends a binding request of size bytes until it is answered or
+// the attempts run out
+func sendProbe(ctx context.Context, conn *net.UDPConn, size int) (bool, error) {
+	request, err := paddedBindingRequest(size)
+	if err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < mtuProbeAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if _, err := conn.Write(request.Raw); err != nil {
+			// Larger than the local interface allows without fragmenting
+			return false, nil
+		}
+
+		deadline := time.Now().Add(mtuProbeTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return false, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			response := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if response.Decode() == nil && response.TransactionID == request.TransactionID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// paddedBindingRequest builds a STUN binding request of exactly size bytes
+func paddedBindingRequest(size int) (*stun.Message, error) {
+	const overhead = 20 + 4 // message and attribute headers
+	if size < overhead || size%4 != 0 {
+		return nil, fmt.Errorf("invalid probe size %d", size)
+	}
+	return stun.Build(stun.TransactionID, stun.BindingRequest,
+		stun.RawAttribute{Type: stunProbePadding, Value: make([]byte, size-overhead)})
+}
+
+// quicPacketOption sizes QUIC packets for mode, or for the network profile
+// when it recorded large packets being dropped. In safe mode dials start at
+// the smallest packet size and never probe for larger ones; connections
+// accepted from others follow the sizes the dialer uses.
+func quicPacketOption(mode string, profile *NetworkProfile, logger *logrus.Logger) libp2p.Option {
+	if mode != QUICMTUSafe && profile != nil && profile.PathMTU != nil && profile.PathMTU.BlackHole() {
+		logger.WithField("network", profile.Network).Warn("This network drops large UDP packets, QUIC keeps to small packets")
+		mode = QUICMTUSafe
+	}
+	if mode != QUICMTUSafe {
+		return nil
+	}
+
+	logger.WithField("packet_size", QUICMinPacketSize).Info("QUIC path MTU discovery disabled")
+	return libp2p.QUICReuse(func(key quic.StatelessResetKey, token quic.TokenGeneratorKey, lifecycle fx.Lifecycle) (*quicreuse.ConnManager, error) {
+		cm, err := quicreuse.NewConnManager(key, token)
+		if err != nil {
+			return nil, err
+		}
+		config := cm.ClientConfig()
+		config.InitialPacketSize = QUICMinPacketSize
+		config.DisablePathMTUDiscovery = true
+		lifecycle.Append(fx.StopHook(cm.Close))
+		return cm, nil
+	})
+}
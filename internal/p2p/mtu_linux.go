@@ -0,0 +1,29 @@
+//go:build linux
+
+package p2p
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the DF bit on packets sent through conn, so probes
+// larger than the path allows are dropped instead of fragmented
+func setDontFragment(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if conn.LocalAddr().(*net.UDPAddr).IP.To4() != nil {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package p2p
+
+import "net"
+
+// setDontFragment is not available on this platform; large probes may be
+// fragmented, so the probe can miss networks that drop them
+func setDontFragment(conn *net.UDPConn) error {
+	return nil
+}
@@ -0,0 +1,429 @@
+package p2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/dual"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// NameNamespace is the DHT record namespace for nickname claims
+	NameNamespace = "xelvra-name"
+
+	// NameDHTPrefix is the protocol prefix of the DHT holding name records.
+	// The public DHT stores only /pk and /ipns records, so names live on a
+	// DHT that only Xelvra nodes run.
+	NameDHTPrefix = protocol.ID("/xelvra")
+
+	// Name record timing
+	NameRecordMaxAge      = 36 * time.Hour // Matches the DHT's own record expiry
+	NameRepublishInterval = 12 * time.Hour
+	NameRetryInterval     = time.Minute
+	nameClockSkew         = 5 * time.Minute
+)
+
+// namePattern restricts nicknames to short lowercase handles
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,31}$`)
+
+// NameRecord binds a nickname to a DID. It is signed by the owning identity
+// and carries the identity's proof-of-work, which weights competing claims.
+type NameRecord struct {
+	Name        string            `json:"name"`
+	PeerID      string            `json:"peer_id"`
+	DID         string            `json:"did"`
+	ProofOfWork *user.ProofOfWork `json:"proof_of_work"`
+	ClaimedAt   time.Time         `json:"claimed_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Signature   []byte            `json:"signature"`
+}
+
+// NormalizeName lowercases a nickname, strips a leading '@' and validates it
+func NormalizeName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "@"))
+	if !namePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid name %q: use 3-32 characters a-z, 0-9, '_' or '-'", name)
+	}
+	return name, nil
+}
+
+// NewNameRecord creates a signed claim of name for the given identity
+func NewNameRecord(identity *user.MessengerID, name string, claimedAt time.Time) (*NameRecord, error) {
+	name, err := NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &NameRecord{
+		Name:        name,
+		PeerID:      identity.GetPeerID().String(),
+		DID:         identity.GetDID(),
+		ProofOfWork: identity.ProofOfWork,
+		ClaimedAt:   claimedAt.UTC(),
+		UpdatedAt:   time.Now().UTC(),
+	}
+
+	if rec.Signature, err = identity.Sign(rec.payload()); err != nil {
+		return nil, fmt.Errorf("failed to sign name record: %w", err)
+	}
+
+	return rec, nil
+}
+
+// payload returns the bytes covered by the signature
+func (r *NameRecord) payload() []byte {
+	return []byte(strings.Join([]string{
+		"xelvra-name",
+		r.Name,
+		r.PeerID,
+		r.DID,
+		r.ClaimedAt.UTC().Format(time.RFC3339Nano),
+		r.UpdatedAt.UTC().Format(time.RFC3339Nano),
+	}, "|"))
+}
+
+// Verify checks the signature, the DID binding and the record's age
+func (r *NameRecord) Verify() error {
+	if _, err := NormalizeName(r.Name); err != nil {
+		return err
+	}
+
+	pubKey, err := publicKeyFromPeerIDString(r.PeerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+	raw, err := pubKey.Raw()
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return fmt.Errorf("name records require an Ed25519 identity")
+	}
+
+	if !user.VerifyDIDProof(r.DID, ed25519.PublicKey(raw), r.ProofOfWork) {
+		return fmt.Errorf("DID does not match key and proof-of-work")
+	}
+
+	ok, err := pubKey.Verify(r.payload(), r.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("name record signature verification failed")
+	}
+
+	now := time.Now()
+	if r.ClaimedAt.After(r.UpdatedAt) || r.UpdatedAt.After(now.Add(nameClockSkew)) {
+		return fmt.Errorf("name record has invalid timestamps")
+	}
+	if now.Sub(r.UpdatedAt) > NameRecordMaxAge {
+		return fmt.Errorf("name record expired")
+	}
+
+	return nil
+}
+
+// Weight returns the proof-of-work difficulty backing the claim
+func (r *NameRecord) Weight() int {
+	if r.ProofOfWork == nil {
+		return 0
+	}
+	return r.ProofOfWork.Difficulty
+}
+
+// outranks reports whether r wins over other for the same name. Refreshes by
+// the same owner replace older copies; between different owners only a claim
+// backed by more proof-of-work wins. ClaimedAt is signed by the claimant
+// alone, so it cannot settle a tie: a squatter could backdate it.
+func (r *NameRecord) outranks(other *NameRecord) bool {
+	if r.PeerID == other.PeerID {
+		return r.UpdatedAt.After(other.UpdatedAt)
+	}
+	return r.Weight() > other.Weight()
+}
+
+// NameRecordKey returns the DHT key for a nickname
+func NameRecordKey(name string) string {
+	return "/" + NameNamespace + "/" + name
+}
+
+// NameValidator validates and orders name records stored in the DHT
+type NameValidator struct{}
+
+// Validate implements record.Validator
+func (NameValidator) Validate(key string, value []byte) error {
+	_, err := parseNameRecord(key, value)
+	return err
+}
+
+// Select implements record.Validator. The DHT passes the incoming record
+// first and the one it stores after it, so values are walked from the end
+// and a record only replaces one it outranks: on a tie the stored claim is
+// kept and the name stays with whoever a node saw first.
+func (NameValidator) Select(key string, values [][]byte) (int, error) {
+	best := -1
+	var bestRec *NameRecord
+
+	for i := len(values) - 1; i >= 0; i-- {
+		rec, err := parseNameRecord(key, values[i])
+		if err != nil {
+			continue
+		}
+		if bestRec == nil || rec.outranks(bestRec) {
+			best, bestRec = i, rec
+		}
+	}
+
+	if best < 0 {
+		return 0, fmt.Errorf("no valid name record found")
+	}
+	return best, nil
+}
+
+// parseNameRecord decodes and verifies a record stored under key
+func parseNameRecord(key string, value []byte) (*NameRecord, error) {
+	ns, name, err := record.SplitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if ns != NameNamespace {
+		return nil, fmt.Errorf("unexpected namespace: %s", ns)
+	}
+
+	var rec NameRecord
+	if err := json.Unmarshal(value, &rec); err != nil {
+		return nil, fmt.Errorf("failed to parse name record: %w", err)
+	}
+	if rec.Name != name {
+		return nil, fmt.Errorf("name record does not match key")
+	}
+	if err := rec.Verify(); err != nil {
+		return nil, err
+	}
+
+	return &rec, nil
+}
+
+// publicKeyFromPeerIDString extracts the public key embedded in a peer ID
+func publicKeyFromPeerIDString(id string) (crypto.PubKey, error) {
+	peerID, err := peer.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+	return peerID.ExtractPublicKey()
+}
+
+// dhtOptions returns the options shared by every public DHT the node
+// creates
+func dhtOptions() []dual.Option {
+	return []dual.Option{
+		// runDHTRefresh refreshes the routing tables, skipping refreshes
+		// while the node idles in battery saver mode
+		dual.DHTOption(dht.DisableAutoRefresh()),
+		// Onion addresses are as public as any, for nodes running over Tor
+		dual.WanDHTOption(dht.AddressFilter(func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			return ma.FilterAddrs(addrs, func(addr ma.Multiaddr) bool {
+				return manet.IsPublicAddr(addr) || isOnionAddr(addr)
+			})
+		})),
+	}
+}
+
+// newNameDHT creates the DHT holding name records on h. Every node serves
+// records, as the network of Xelvra nodes is too small to leave it to the
+// publicly reachable ones.
+func newNameDHT(ctx context.Context, h host.Host) (*dht.IpfsDHT, error) {
+	return dht.New(ctx, h,
+		dht.ProtocolPrefix(NameDHTPrefix),
+		dht.Mode(dht.ModeServer),
+		dht.DisableAutoRefresh(),
+		dht.NamespacedValidator(NameNamespace, NameValidator{}))
+}
+
+// NameClaim is the nickname this node keeps published in the DHT
+type NameClaim struct {
+	Name      string    `json:"name"`
+	ClaimedAt time.Time `json:"claimed_at"`
+}
+
+// getNameClaimPath returns the path of the stored name claim
+func getNameClaimPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "name.json"), nil
+}
+
+// LoadNameClaim returns the stored name claim, or nil if none is set
+func LoadNameClaim() (*NameClaim, error) {
+	path, err := getNameClaimPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var claim NameClaim
+	if err := json.Unmarshal(data, &claim); err != nil {
+		return nil, fmt.Errorf("failed to parse name claim: %w", err)
+	}
+	return &claim, nil
+}
+
+// SaveNameClaim stores the nickname to publish. Re-claiming the current name
+// keeps its original claim time.
+func SaveNameClaim(name string) (*NameClaim, error) {
+	name, err := NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := &NameClaim{Name: name, ClaimedAt: time.Now().UTC()}
+	if existing, err := LoadNameClaim(); err == nil && existing != nil && existing.Name == name {
+		claim.ClaimedAt = existing.ClaimedAt
+	}
+
+	path, err := getNameClaimPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(claim, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return claim, nil
+}
+
+// ReleaseNameClaim stops publishing the stored nickname. The DHT record
+// expires on its own after NameRecordMaxAge.
+func ReleaseNameClaim() error {
+	path, err := getNameClaimPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// publishName signs the stored name claim and puts it in the DHT
+func (n *PeerChatNode) publishName(ctx context.Context) (*NameRecord, error) {
+	claim, err := LoadNameClaim()
+	if err != nil {
+		return nil, err
+	}
+	if claim == nil {
+		return nil, nil
+	}
+
+	rec, err := NewNameRecord(n.identity, claim.Name, claim.ClaimedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := n.discoveryManager.putValue(ctx, NameRecordKey(rec.Name), value); err != nil {
+		return nil, fmt.Errorf("failed to publish name: %w", err)
+	}
+
+	return rec, nil
+}
+
+// runNamePublisher keeps the claimed name published in the DHT
+func (n *PeerChatNode) runNamePublisher() {
+	timer := time.NewTimer(NameRetryInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-timer.C:
+			ctx, cancel := context.WithTimeout(n.ctx, time.Minute)
+			rec, err := n.publishName(ctx)
+			cancel()
+
+			switch {
+			case err != nil:
+				n.logger.WithError(err).Debug("Name publish failed, will retry")
+				timer.Reset(NameRetryInterval)
+			case rec != nil:
+				n.logger.WithField("name", rec.Name).Info("Name record published")
+				timer.Reset(NameRepublishInterval)
+			default:
+				timer.Reset(NameRetryInterval)
+			}
+		}
+	}
+}
+
+// RegisterName claims a nickname for this node's identity. It fails if the
+// name is already held by a claim with as much proof-of-work or more.
+func (n *PeerChatNode) RegisterName(ctx context.Context, name string) (*NameRecord, error) {
+	name, err := NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if current, err := n.ResolveName(ctx, name); err == nil && current.PeerID != n.host.ID().String() {
+		ours, err := NewNameRecord(n.identity, name, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !ours.outranks(current) {
+			return nil, fmt.Errorf("name @%s is already taken by %s", name, current.PeerID)
+		}
+	}
+
+	if _, err := SaveNameClaim(name); err != nil {
+		return nil, err
+	}
+
+	return n.publishName(ctx)
+}
+
+// ResolveName looks up the current owner of a nickname in the DHT
+func (n *PeerChatNode) ResolveName(ctx context.Context, name string) (*NameRecord, error) {
+	name, err := NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key := NameRecordKey(name)
+	value, err := n.discoveryManager.getValue(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("name @%s not found: %w", name, err)
+	}
+
+	return parseNameRecord(key, value)
+}
@@ -442,9 +442,13 @@ func (ant *AdvancedNATTraversal) executeStrategy(ctx context.Context, strategy H
 func (ant *AdvancedNATTraversal) executeDirect(ctx context.Context, peerID peer.ID, peerAddrs []string) error {
 	ant.logger.WithField("peer_id", peerID.String()).Debug("Executing direct connection strategy")
 
-	// Try direct connection to peer addresses
+	// Try direct connection to peer addresses, through the proxy from the
+	// environment if any
+	dialer := NewProxyDialer(ProxyFromEnvironment())
 	for _, addr := range peerAddrs {
-		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		conn, err := dialer.DialContext(dialCtx, "tcp", addr)
+		cancel()
 		if err == nil {
 			conn.Close()
 			return nil
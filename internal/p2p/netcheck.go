@@ -0,0 +1,396 @@
+package p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pion/stun"
+)
+
+// Settings of the network checks run by doctor
+const (
+	// ClockSkewWarn is the clock offset from NTP time above which the
+	// clock is worth fixing; at MaxClockSkew peers reject name records
+	ClockSkewWarn = 30 * time.Second
+	MaxClockSkew  = nameClockSkew
+
+	netCheckTimeout = 3 * time.Second // For one STUN or NTP server to answer
+)
+
+// DefaultNTPServers are asked for the time when checking the clock
+var DefaultNTPServers = []string{
+	"time.cloudflare.com:123",
+	"time.google.com:123",
+	"pool.ntp.org:123",
+}
+
+// NAT classes found by ClassifyNAT
+const (
+	NATClassNone      = "none"      // Public address, no NAT
+	NATClassCone      = "cone"      // The same mapping to every peer; hole punching works
+	NATClassSymmetric = "symmetric" // A new mapping per peer; connections need a relay
+	NATClassUnknown   = "unknown"   // Only one STUN server answered
+)
+
+// DefaultRoute returns the local address traffic to the Internet leaves
+// from over network, "udp4" or "udp6". No packet is sent.
+func DefaultRoute(network string) (net.IP, error) {
+	target := "8.8.8.8:53"
+	if network == "udp6" {
+		target = "[2001:4860:4860::8888]:53"
+	}
+	conn, err := net.Dial(network, target)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// ResolveBootstrapDNS looks up the dnsaddr records listing the default
+// bootstrap peers, returning the domain and how many records it has
+func ResolveBootstrapDNS(ctx context.Context) (string, int, error) {
+	var domain string
+	for _, s := range DefaultBootstrapAddrs() {
+		if addr, err := ma.NewMultiaddr(s); err == nil {
+			if domain = dnsaddrDomain(addr); domain != "" {
+				break
+			}
+		}
+	}
+	if domain == "" {
+		return "", 0, errors.New("no bootstrap peer is given by a DNS name")
+	}
+	records, err := net.DefaultResolver.LookupTXT(ctx, "_dnsaddr."+domain)
+	if err != nil {
+		return domain, 0, err
+	}
+	return domain, len(records), nil
+}
+
+// PortBind is the result of binding a port
+type PortBind struct {
+	Network string `json:"network"`        // "tcp4", "tcp6", "udp4" or "udp6"
+	Port    int    `json:"port,omitempty"` // Zero for any free port
+	Addr    string `json:"addr,omitempty"` // Address bound
+	Error   string `json:"error,omitempty"`
+}
+
+// CheckPortBinds binds, and closes again, a free port for each of TCP and
+// UDP over IPv4 and IPv6, and the UDP port LAN beacons are heard on
+func CheckPortBinds() []PortBind {
+	binds := []PortBind{
+		{Network: "tcp4"}, {Network: "tcp6"}, {Network: "udp4"}, {Network: "udp6"},
+		{Network: "udp4", Port: beaconPort},
+	}
+	for i := range binds {
+		bind := &binds[i]
+		host := "0.0.0.0"
+		if bind.Network == "tcp6" || bind.Network == "udp6" {
+			host = "::"
+		}
+		address := net.JoinHostPort(host, fmt.Sprint(bind.Port))
+
+		var addr net.Addr
+		var closer interface{ Close() error }
+		if bind.Network == "tcp4" || bind.Network == "tcp6" {
+			listener, err := net.Listen(bind.Network, address)
+			if err != nil {
+				bind.Error = err.Error()
+				continue
+			}
+			addr, closer = listener.Addr(), listener
+		} else {
+			conn, err := net.ListenPacket(bind.Network, address)
+			if err != nil {
+				bind.Error = err.Error()
+				continue
+			}
+			addr, closer = conn.LocalAddr(), conn
+		}
+		bind.Addr = addr.String()
+		_ = closer.Close()
+	}
+	return binds
+}
+
+// IsBeaconPort reports whether bind is the port of LAN beacons
+func (b PortBind) IsBeaconPort() bool {
+	return b.Port == beaconPort
+}
+
+// STUNMapping is the public address a STUN server saw a request come from
+type STUNMapping struct {
+	Server string `json:"server"`
+	Addr   string `json:"addr"`
+}
+
+// NATClassification is how the NAT in front of the node maps UDP ports
+type NATClassification struct {
+	Class         string        `json:"class"` // One of the NATClass constants
+	LocalAddr     string        `json:"local_addr"`
+	Mappings      []STUNMapping `json:"mappings"`
+	PortPreserved bool          `json:"port_preserved,omitempty"` // The NAT kept the local port
+}
+
+// ClassifyNAT sends STUN binding requests from one UDP socket to servers
+// at two different addresses. A NAT that maps the socket to the same
+// public address for both is a cone NAT, which hole punching gets
+// through; one that maps it anew for each is symmetric.
+func ClassifyNAT(ctx context.Context, servers []string) (*NATClassification, error) {
+	local, err := DefaultRoute("udp4")
+	if err != nil {
+		return nil, fmt.Errorf("no IPv4 route: %w", err)
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	localAddr := &net.UDPAddr{IP: local, Port: conn.LocalAddr().(*net.UDPAddr).Port}
+
+	result := &NATClassification{Class: NATClassUnknown, LocalAddr: localAddr.String()}
+	var asked []string
+	var lastErr error
+	for _, server := range servers {
+		if len(result.Mappings) == 2 {
+			break
+		}
+		addr, err := net.ResolveUDPAddr("udp4", server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// A second address of the same server tells nothing new
+		if slices.Contains(asked, addr.IP.String()) {
+			continue
+		}
+		mapped, err := stunBinding(ctx, conn, addr)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", server, err)
+			continue
+		}
+		asked = append(asked, addr.IP.String())
+		result.Mappings = append(result.Mappings, STUNMapping{Server: server, Addr: mapped.String()})
+	}
+	if len(result.Mappings) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no STUN servers")
+		}
+		return nil, fmt.Errorf("no STUN server answered: %w", lastErr)
+	}
+
+	first := result.Mappings[0].Addr
+	mapped, _ := net.ResolveUDPAddr("udp4", first)
+	result.PortPreserved = mapped != nil && mapped.Port == localAddr.Port
+	switch {
+	case first == localAddr.String():
+		result.Class = NATClassNone
+	case len(result.Mappings) < 2:
+		result.Class = NATClassUnknown
+	case result.Mappings[1].Addr == first:
+		result.Class = NATClassCone
+	default:
+		result.Class = NATClassSymmetric
+	}
+	return result, nil
+}
+
+// stunBinding asks server for the address conn's requests come from
+func stunBinding(ctx context.Context, conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, error) {
+	request, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(netCheckTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(request.Raw, server); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, err
+		}
+		if !from.IP.Equal(server.IP) {
+			continue
+		}
+		response := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if response.Decode() != nil || response.TransactionID != request.TransactionID {
+			continue
+		}
+		var xor stun.XORMappedAddress
+		if err := xor.GetFrom(response); err == nil {
+			return &net.UDPAddr{IP: xor.IP, Port: xor.Port}, nil
+		}
+		var plain stun.MappedAddress
+		if err := plain.GetFrom(response); err != nil {
+			return nil, errors.New("no mapped address in STUN response")
+		}
+		return &net.UDPAddr{IP: plain.IP, Port: plain.Port}, nil
+	}
+}
+
+// MulticastCheck is whether the host can take part in mDNS discovery
+type MulticastCheck struct {
+	Interfaces []string `json:"interfaces,omitempty"` // Up, with IPv4 and multicast
+	Joined     bool     `json:"joined"`               // A multicast group could be joined
+	Looped     bool     `json:"looped"`               // A packet sent to the group came back
+}
+
+// multicastCheckGroup is an administratively scoped group, so the check
+// does not bother mDNS responders
+var multicastCheckGroup = net.IPv4(239, 255, 77, 77)
+
+// CheckMulticast lists the interfaces mDNS can use, joins a multicast
+// group and sends a packet to it, which the host receives itself when
+// multicast works
+func CheckMulticast(ctx context.Context) (*MulticastCheck, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	result := &MulticastCheck{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				result.Interfaces = append(result.Interfaces, iface.Name)
+				break
+			}
+		}
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, &net.UDPAddr{IP: multicastCheckGroup})
+	if err != nil {
+		return result, fmt.Errorf("failed to join a multicast group: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	result.Joined = true
+
+	group := &net.UDPAddr{IP: multicastCheckGroup, Port: conn.LocalAddr().(*net.UDPAddr).Port}
+	sender, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		return result, fmt.Errorf("failed to send to the multicast group: %w", err)
+	}
+	defer func() { _ = sender.Close() }()
+	token := fmt.Sprintf("xelvra-doctor-%d", time.Now().UnixNano())
+	if _, err := sender.Write([]byte(token)); err != nil {
+		return result, fmt.Errorf("failed to send to the multicast group: %w", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+	buf := make([]byte, 64)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return result, nil
+		}
+		if string(buf[:n]) == token {
+			result.Looped = true
+			return result, nil
+		}
+	}
+}
+
+// ntpEpoch is where NTP timestamps count from
+var ntpEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ClockOffset asks the NTP servers in turn how far the local clock is off,
+// returning the offset, positive when the local clock is behind, and the
+// server that answered
+func ClockOffset(ctx context.Context, servers []string) (time.Duration, string, error) {
+	var lastErr error
+	for _, server := range servers {
+		if err := ctx.Err(); err != nil {
+			return 0, "", err
+		}
+		offset, err := sntpOffset(ctx, server)
+		if err == nil {
+			return offset, server, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", server, err)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no NTP servers")
+	}
+	return 0, "", fmt.Errorf("no NTP server answered: %w", lastErr)
+}
+
+// sntpOffset asks one server for the time with a SNTPv4 request
+func sntpOffset(ctx context.Context, server string) (time.Duration, error) {
+	dialer := net.Dialer{Timeout: netCheckTimeout}
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+	deadline := time.Now().Add(netCheckTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	request := make([]byte, 48)
+	request[0] = 0x23 // No leap second warning, version 4, client
+	sent := time.Now()
+	binary.BigEndian.PutUint64(request[40:], ntpTimestamp(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, 48)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			return 0, err
+		}
+		received := time.Now()
+		// The origin timestamp echoes ours; stratum 0 is a refusal
+		if n < 48 || response[0]&0x07 != 4 || binary.BigEndian.Uint64(response[24:]) != ntpTimestamp(sent) {
+			continue
+		}
+		if response[1] == 0 {
+			return 0, errors.New("server refused the request")
+		}
+		serverReceived := ntpTime(binary.BigEndian.Uint64(response[32:]))
+		serverSent := ntpTime(binary.BigEndian.Uint64(response[40:]))
+		return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+	}
+}
+
+// ntpTimestamp encodes t as an NTP timestamp
+func ntpTimestamp(t time.Time) uint64 {
+	d := t.Sub(ntpEpoch)
+	seconds := uint64(d / time.Second)
+	fraction := uint64(d%time.Second) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// ntpTime decodes an NTP timestamp
+func ntpTime(ts uint64) time.Time {
+	seconds := time.Duration(ts>>32) * time.Second
+	fraction := time.Duration((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return ntpEpoch.Add(seconds + fraction)
+}
@@ -0,0 +1,373 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// NetworkProfilesFileName holds what was learned about each network
+	NetworkProfilesFileName = "networks.json"
+
+	// NetworkFactsTTL is how long learned facts are trusted before the
+	// network is probed again
+	NetworkFactsTTL = 7 * 24 * time.Hour
+
+	// networkCheckInterval is how often the node checks for a network change
+	networkCheckInterval = 30 * time.Second
+
+	// quicBlockedAfter is how many connections over TCP, and none over
+	// QUIC, mark QUIC as blocked on a network
+	quicBlockedAfter = 5
+)
+
+// NetworkID identifies a network by the interface the default route uses,
+// the Wi-Fi SSID when there is one, and the subnet
+type NetworkID struct {
+	Interface string
+	SSID      string
+	Subnet    string
+}
+
+// Key returns the key profiles are stored under, e.g.
+// `wlan0 "Home" 192.168.1.0/24`
+func (id NetworkID) Key() string {
+	if id.SSID != "" {
+		return id.Interface + " " + strconv.Quote(id.SSID) + " " + id.Subnet
+	}
+	return id.Interface + " " + id.Subnet
+}
+
+// CurrentNetwork identifies the network the default route leads to
+func CurrentNetwork() (NetworkID, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return NetworkID{}, fmt.Errorf("no default route: %w", err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	_ = conn.Close()
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return NetworkID{}, err
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && ipNet.IP.Equal(local) {
+				subnet := net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}
+				return NetworkID{Interface: iface.Name, SSID: wifiSSID(iface.Name), Subnet: subnet.String()}, nil
+			}
+		}
+	}
+	return NetworkID{}, fmt.Errorf("no interface holds %s", local)
+}
+
+// NetworkProfile is what was learned about a network, so that joining it
+// again applies it at once instead of probing everything again
+type NetworkProfile struct {
+	Network   string    `json:"network"`
+	Interface string    `json:"interface"`
+	SSID      string    `json:"ssid,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// NAT as found by STUN or by peer reports
+	NAT        *NATInfo  `json:"nat,omitempty"`
+	NATUpdated time.Time `json:"nat_updated,omitempty"`
+
+	// Connections made to public addresses, by transport ("tcp", "quic",
+	// "relay"), counted since ConnectionsSince
+	Connections      map[string]int `json:"connections,omitempty"`
+	ConnectionsSince time.Time      `json:"connections_since,omitempty"`
+
+	// PathMTU is the latest packet size probe by doctor
+	PathMTU *PathMTUProbe `json:"path_mtu,omitempty"`
+}
+
+// newNetworkProfile creates the profile of a network seen for the first time
+func newNetworkProfile(id NetworkID) *NetworkProfile {
+	now := time.Now()
+	return &NetworkProfile{
+		Network:   id.Key(),
+		Interface: id.Interface,
+		SSID:      id.SSID,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+}
+
+// KnownNAT returns the NAT learned within NetworkFactsTTL, or nil
+func (p *NetworkProfile) KnownNAT() *NATInfo {
+	if p.NAT == nil || p.NAT.Type == "" || time.Since(p.NATUpdated) > NetworkFactsTTL {
+		return nil
+	}
+	nat := *p.NAT
+	return &nat
+}
+
+// RememberNAT records the NAT found on the network
+func (p *NetworkProfile) RememberNAT(nat *NATInfo) {
+	copied := *nat
+	p.NAT = &copied
+	p.NATUpdated = time.Now()
+}
+
+// CountConnection counts a connection made over transport, starting over
+// when the counts are older than NetworkFactsTTL
+func (p *NetworkProfile) CountConnection(transport string) {
+	if p.Connections == nil || time.Since(p.ConnectionsSince) > NetworkFactsTTL {
+		p.Connections = make(map[string]int)
+		p.ConnectionsSince = time.Now()
+	}
+	p.Connections[transport]++
+}
+
+// BestTransport returns the transport most connections were made over
+func (p *NetworkProfile) BestTransport() string {
+	best := ""
+	for transport, count := range p.Connections {
+		if best == "" || count > p.Connections[best] || (count == p.Connections[best] && transport < best) {
+			best = transport
+		}
+	}
+	return best
+}
+
+// QUICBlocked reports whether connections work over TCP but never over
+// QUIC, as when a network blocks outgoing UDP
+func (p *NetworkProfile) QUICBlocked() bool {
+	return p.Connections["tcp"] >= quicBlockedAfter && p.Connections["quic"] == 0
+}
+
+// LoadNetworkProfiles reads the network profiles at path, by key
+func LoadNetworkProfiles(path string) (map[string]*NetworkProfile, error) {
+	profiles := make(map[string]*NetworkProfile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse network profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// SaveNetworkProfiles writes the network profiles to path
+func SaveNetworkProfiles(path string, profiles map[string]*NetworkProfile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// UpdateNetworkProfile applies update to the profile of network id at path,
+// creating it if the network is new
+func UpdateNetworkProfile(path string, id NetworkID, update func(*NetworkProfile)) error {
+	profiles, err := LoadNetworkProfiles(path)
+	if err != nil {
+		return err
+	}
+	profile := profiles[id.Key()]
+	if profile == nil {
+		profile = newNetworkProfile(id)
+		profiles[id.Key()] = profile
+	}
+	update(profile)
+	return SaveNetworkProfiles(path, profiles)
+}
+
+// SortedNetworkProfiles returns profiles, the most recently seen first
+func SortedNetworkProfiles(profiles map[string]*NetworkProfile) []*NetworkProfile {
+	sorted := make([]*NetworkProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		sorted = append(sorted, profile)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].LastSeen.After(sorted[j].LastSeen)
+	})
+	return sorted
+}
+
+// connectionTransport names the transport of a connection to addr for the
+// network profile, or "" for connections that say nothing about the
+// network, such as those on the LAN
+func connectionTransport(addr ma.Multiaddr) string {
+	if !isRelayAddr(addr) && !manet.IsPublicAddr(addr) {
+		return ""
+	}
+	return addrTransport(addr)
+}
+
+// networkProfilesPath returns where the node keeps network profiles
+func (n *PeerChatNode) networkProfilesPath() string {
+	return filepath.Join(n.config.DataDir, NetworkProfilesFileName)
+}
+
+// loadNetworkProfile returns the profile of the current network stored in
+// dataDir, new if the network was not seen before, or nil when the network
+// cannot be identified
+func loadNetworkProfile(dataDir string, logger *logrus.Logger) *NetworkProfile {
+	if dataDir == "" {
+		return nil
+	}
+	id, err := CurrentNetwork()
+	if err != nil {
+		logger.WithError(err).Debug("Failed to identify the network")
+		return nil
+	}
+	profiles, err := LoadNetworkProfiles(filepath.Join(dataDir, NetworkProfilesFileName))
+	if err != nil {
+		logger.WithError(err).Warn("Failed to load network profiles")
+	}
+	profile := profiles[id.Key()]
+	if profile == nil {
+		return newNetworkProfile(id)
+	}
+	profile.LastSeen = time.Now()
+	logger.WithField("network", profile.Network).Info("Joined a known network")
+	return profile
+}
+
+// saveNetworkProfile stores the current network profile, keeping what
+// doctor recorded in the meantime
+func (n *PeerChatNode) saveNetworkProfile() {
+	n.mu.RLock()
+	profile := n.networkProfile
+	var saved NetworkProfile
+	if profile != nil {
+		saved = *profile
+		saved.Connections = make(map[string]int, len(profile.Connections))
+		for transport, count := range profile.Connections {
+			saved.Connections[transport] = count
+		}
+	}
+	n.mu.RUnlock()
+	if profile == nil {
+		return
+	}
+
+	profiles, err := LoadNetworkProfiles(n.networkProfilesPath())
+	if err != nil {
+		n.logger.WithError(err).Warn("Failed to load network profiles")
+		return
+	}
+	if stored := profiles[saved.Network]; stored != nil && stored.PathMTU != nil &&
+		(saved.PathMTU == nil || stored.PathMTU.Probed.After(saved.PathMTU.Probed)) {
+		saved.PathMTU = stored.PathMTU
+	}
+	profiles[saved.Network] = &saved
+	if err := SaveNetworkProfiles(n.networkProfilesPath(), profiles); err != nil {
+		n.logger.WithError(err).Warn("Failed to save network profile")
+	}
+}
+
+// countConnection records a new connection in the network profile
+func (n *PeerChatNode) countConnection(conn network.Conn) {
+	transport := connectionTransport(conn.RemoteMultiaddr())
+	if transport == "" {
+		return
+	}
+	n.mu.Lock()
+	if n.networkProfile != nil {
+		n.networkProfile.CountConnection(transport)
+	}
+	n.mu.Unlock()
+}
+
+// rememberNAT records the NAT found on the current network
+func (n *PeerChatNode) rememberNAT(nat *NATInfo) {
+	n.mu.Lock()
+	if n.networkProfile != nil {
+		n.networkProfile.RememberNAT(nat)
+	}
+	n.mu.Unlock()
+	n.saveNetworkProfile()
+}
+
+// applyNetworkProfile uses the NAT remembered for the current network, or
+// discovers it when there is none
+func (n *PeerChatNode) applyNetworkProfile() {
+	n.mu.Lock()
+	var nat *NATInfo
+	if n.networkProfile != nil {
+		nat = n.networkProfile.KnownNAT()
+	}
+	n.natInfo = nat
+	n.mu.Unlock()
+
+	if nat != nil {
+		n.logger.WithFields(logrus.Fields{
+			"nat_type":  nat.Type,
+			"public_ip": nat.PublicIP,
+		}).Info("Using the NAT remembered for this network")
+		return
+	}
+	if !n.proxyOnly {
+		go n.discoverNAT()
+	}
+}
+
+// runNetworkProfiles keeps the profile of the current network up to date
+// and switches profiles when the node moves to another network
+func (n *PeerChatNode) runNetworkProfiles() {
+	ticker := time.NewTicker(networkCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n.saveNetworkProfile()
+		id, err := CurrentNetwork()
+		if err != nil {
+			continue
+		}
+		n.mu.RLock()
+		current := n.networkProfile
+		n.mu.RUnlock()
+		if current != nil && current.Network == id.Key() {
+			continue
+		}
+
+		profile := loadNetworkProfile(n.config.DataDir, n.logger)
+		n.mu.Lock()
+		n.networkProfile = profile
+		n.mu.Unlock()
+		n.observations.Retain(func(peer.ID, string) bool { return false })
+		n.logger.WithField("network", id.Key()).Info("Network changed")
+		n.applyNetworkProfile()
+		if err := n.writeStatusFile(); err != nil {
+			n.logger.WithError(err).Warn("Failed to update status file after network change")
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package p2p
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// wifiSSID returns the SSID of the Wi-Fi network iface is joined to, or ""
+// for wired interfaces and when wireless tools are not installed
+func wifiSSID(iface string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "iwgetid", iface, "--raw").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package p2p
+
+// wifiSSID is not available on this platform; networks are told apart by
+// interface and subnet only
+func wifiSSID(iface string) string {
+	return ""
+}
@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Xelvra/peerchat/internal/db"
 	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/tracing"
 	"github.com/Xelvra/peerchat/internal/user"
 	libp2p "github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-kad-dht/dual"
@@ -20,10 +24,15 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
-	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	libp2pwebrtc "github.com/libp2p/go-libp2p/p2p/transport/webrtc"
+	libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/sirupsen/logrus"
 )
 
@@ -39,7 +48,7 @@ const (
 
 // NetworkTransport represents active network transport information
 type NetworkTransport struct {
-	Type       string  `json:"type"` // "tcp", "quic", "relay"
+	Type       string  `json:"type"` // "tcp", "quic", "relay", "webtransport", "webrtc"
 	LocalAddr  string  `json:"local_addr"`
 	RemoteAddr string  `json:"remote_addr,omitempty"`
 	IsActive   bool    `json:"is_active"`
@@ -57,6 +66,15 @@ type NATInfo struct {
 	STUNServers []string `json:"stun_servers"`
 	UsingRelay  bool     `json:"using_relay"`
 	RelayAddr   string   `json:"relay_addr,omitempty"`
+
+	// ObservedByPeers is set when Type was classified from the addresses
+	// connected peers see us at rather than from STUN
+	ObservedByPeers bool `json:"observed_by_peers,omitempty"`
+
+	// PublicIPv6 is the IPv6 address seen from the Internet, and IPv6Direct
+	// is set when it is the host's own, so peers reach it without NAT
+	PublicIPv6 string `json:"public_ipv6,omitempty"`
+	IPv6Direct bool   `json:"ipv6_direct,omitempty"`
 }
 
 // DiscoveryStatus represents peer discovery status
@@ -67,6 +85,19 @@ type DiscoveryStatus struct {
 	BootstrapPeers []string  `json:"bootstrap_peers"`
 	KnownPeers     int       `json:"known_peers"`
 	LastDiscovery  time.Time `json:"last_discovery"`
+	Visibility     string    `json:"visibility,omitempty"` // Who the node is announced to
+
+	// PresenceAdvertised is whether the node announces itself in the DHT;
+	// never while the DHT runs on an ephemeral identity
+	PresenceAdvertised bool `json:"presence_advertised"`
+
+	// Outcome of the last check of each bootstrap peer, and whether the
+	// default ones are used because none of the configured ones answered
+	BootstrapHealth   []BootstrapHealth `json:"bootstrap_health,omitempty"`
+	BootstrapFallback bool              `json:"bootstrap_fallback,omitempty"`
+
+	// Rendezvous are the keys the node announces and looks for peers under
+	Rendezvous []string `json:"rendezvous,omitempty"`
 }
 
 // NodeStatus represents the current status of a running node
@@ -83,11 +114,74 @@ type NodeStatus struct {
 
 	// Extended network information
 	Transports     []NetworkTransport `json:"transports"`
+	QUICDisabled   string             `json:"quic_disabled,omitempty"` // Why QUIC is off, empty when it is on
 	NATInfo        *NATInfo           `json:"nat_info,omitempty"`
+	ObservedAddrs  []ObservedAddr     `json:"observed_addrs,omitempty"`
+	Network        string             `json:"network,omitempty"` // Key of the network profile in use
 	Discovery      *DiscoveryStatus   `json:"discovery,omitempty"`
 	NetworkQuality string             `json:"network_quality"` // "excellent", "good", "poor", "offline"
+
+	// Disk space used against the quotas
+	Storage *message.StorageUsage `json:"storage,omitempty"`
+
+	// Storage backend of history, offline queue and contacts
+	StorageBackend string `json:"storage_backend,omitempty"`
+
+	// Local address of the pprof and runtime statistics endpoint, when served
+	DiagnosticsAddr string `json:"diagnostics_addr,omitempty"`
+
+	// Wake host this node is registered with, and clients registered here
+	WakeRelay      string `json:"wake_relay,omitempty"`
+	WakeRegistered bool   `json:"wake_registered,omitempty"`
+	WakeClients    int    `json:"wake_clients,omitempty"`
+
+	// Circuit addresses through the relays holding a reservation
+	RelayAddrs []string `json:"relay_addrs,omitempty"`
+
+	// Whether peers can dial this node as AutoNAT found ("public",
+	// "private" or "unknown"), and how relayed connections were upgraded
+	Reachability string           `json:"reachability,omitempty"`
+	HolePunch    *HolePunchStatus `json:"hole_punch,omitempty"`
+
+	// Whether connections go through Tor, and the onion address peers reach
+	// the node at, empty if the onion service could not be published
+	Tor       bool   `json:"tor,omitempty"`
+	OnionAddr string `json:"onion_addr,omitempty"`
+
+	// Addresses, with certificate hashes and peer ID, that browser clients
+	// dial, or why the browser transports asked for are off
+	BrowserAddrs    []string `json:"browser_addrs,omitempty"`
+	BrowserDisabled string   `json:"browser_disabled,omitempty"`
+
+	// Connection watermarks and the peers protected from pruning
+	ConnLimits *ConnLimits `json:"conn_limits,omitempty"`
+
+	// Fingerprint of the swarm key of the private network the node is part
+	// of, empty on the public network
+	PrivateNetwork string `json:"private_network,omitempty"`
+
+	// Name of the configuration profile in use, empty without one
+	Profile string `json:"profile,omitempty"`
+
+	// Estimated power use and battery saver state
+	Energy *EnergyProfile `json:"energy,omitempty"`
+
+	// Peers connected when the status was written
+	Peers []PeerStatus `json:"peers,omitempty"`
 }
 
+// PeerStatus is a connected peer as listed in the status file
+type PeerStatus struct {
+	PeerID   string  `json:"peer_id"`
+	Addr     string  `json:"addr"` // Remote address of the first connection
+	ViaRelay bool    `json:"via_relay,omitempty"`
+	Quality  float64 `json:"quality,omitempty"` // Connection quality score, 0-100
+}
+
+// statusRefreshInterval is how often the running node rewrites the status
+// file, so commands reading it see current peers
+const statusRefreshInterval = 10 * time.Second
+
 // PeerChatNode represents the main P2P node for Xelvra messenger
 type PeerChatNode struct {
 	host   host.Host
@@ -111,17 +205,191 @@ type PeerChatNode struct {
 	stunClient       *LegacySTUNClient
 	discoveryManager *DiscoveryManager
 	energyManager    *EnergyManager
+	routingDHT       *dual.DHT
+	inviteManager    *InviteManager
+	transportGater   *TransportGater
+	history          db.HistoryStore
+	folderSync       *message.FolderSyncManager
 	natInfo          *NATInfo
+	observations     *ObservationBook
+
+	// networkProfile holds what was learned about the current network, or
+	// is nil when it cannot be identified; guarded by mu
+	networkProfile *NetworkProfile
+
+	// proxyOnly is set when every connection must go through a SOCKS proxy
+	proxyOnly bool
+
+	// quicDisabled says why QUIC is off, or is empty when it is on
+	quicDisabled string
+
+	// browserDisabled says why the browser transports asked for are off
+	browserDisabled string
+
+	// Keeps contacts and transfers from being pruned by the connection
+	// manager
+	connProtector *ConnProtector
+
+	// Meters the traffic exchanged with each peer
+	bandwidth *BandwidthBook
+
+	// Rates the connections to each peer and picks the path to dial first
+	quality *QualityBook
+
+	// Redials contacts whose connection dropped
+	reconnector *Reconnector
+
+	// Addresses and protocols of peers from earlier runs, nil without a
+	// data directory
+	peerCache *peerCache
+
+	// holePunches follows upgrades of relayed connections, or is nil behind
+	// a proxy; reachability is what AutoNAT found, guarded by mu
+	holePunches  *HolePunchTracer
+	reachability network.Reachability
+
+	// dhtHost carries the DHT under an ephemeral identity, or is nil when
+	// the DHT runs on the messaging host
+	dhtHost host.Host
+
+	// tracer times message hops, or is nil when tracing is off
+	tracer *tracing.Tracer
+
+	// wakeHost holds peers registered to be woken, or is nil; wakeClient
+	// keeps this node registered with a wake host, or is nil
+	wakeHost   *WakeHost
+	wakeClient *WakeClient
+
+	// wakeSent is when each peer was last asked to be woken; guarded by mu
+	wakeSent map[peer.ID]time.Time
+
+	// diagnostics serves pprof on localhost when enabled; guarded by mu
+	diagnostics     *http.Server
+	diagnosticsAddr string
+
+	// onionAddrs advertises the onion service over Tor, or is nil; the
+	// service itself is guarded by mu
+	onionAddrs   *onionAddrs
+	onionService *OnionService
 }
 
 // NodeConfig holds configuration for the P2P node
 type NodeConfig struct {
 	ListenAddrs    []string
-	BootstrapPeers []peer.AddrInfo
+	BootstrapPeers []peer.AddrInfo // Replace the default bootstrap peers, which remain a fallback
 	EnableQUIC     bool
 	EnableTCP      bool
 	LogLevel       logrus.Level
 	Logger         *logrus.Logger // External logger to use
+	IdentityPath   string         // Persistent identity file; empty generates an ephemeral identity
+	DataDir        string         // Directory for the encrypted message history; empty disables history
+
+	// Storage names the storage backend of history, offline queue and
+	// contacts: db.SQLiteBackend (or empty), db.MemoryBackend or one added
+	// with db.RegisterBackend
+	Storage string
+
+	// Visibility is who discovery announces the node to, a Visibility level
+	// name. Empty keeps the level last saved with SaveVisibility.
+	Visibility string
+
+	// DisableMDNS and DisableUDPBroadcast turn those LAN discovery methods
+	// off; SetLANDiscovery turns them on and off while running
+	DisableMDNS         bool
+	DisableUDPBroadcast bool
+
+	// AllowDirectWithProxy keeps LAN discovery and direct connections when a
+	// SOCKS proxy such as Tor is configured, at the risk of revealing the
+	// local IP address
+	AllowDirectWithProxy bool
+
+	// EphemeralDHTIdentity runs the DHT on a separate host whose identity is
+	// generated on every start, so DHT participation is not linked to the
+	// messaging identity
+	EphemeralDHTIdentity bool
+
+	// FileStreams is how many streams a large file may be split across;
+	// zero keeps the default and 1 sends every file over a single stream
+	FileStreams int
+
+	// DisableFileCompression sends and receives file chunks uncompressed
+	DisableFileCompression bool
+
+	// QUICMTU sizes QUIC packets: QUICMTUAuto (or empty) discovers the path
+	// MTU except on networks recorded as dropping large packets, QUICMTUSafe
+	// always keeps to the smallest packets
+	QUICMTU string
+
+	// Trace records timing spans of every message hop: a file path for a
+	// JSON-lines trace file, or an http(s) URL of an OTLP collector. Empty
+	// disables tracing.
+	Trace string
+
+	// WakeRelay is the multiaddr, with /p2p/ peer ID, of a wake host this
+	// node stays registered with so peers can wake it while it sleeps
+	// behind a NAT. Empty disables registering.
+	WakeRelay string
+
+	// ServeWake lets other peers register with this node to be woken
+	ServeWake bool
+
+	// BatterySaver starts the node in battery saver mode, see
+	// SetBatterySaver
+	BatterySaver bool
+
+	// DiagnosticsPort serves the diagnostics endpoint on this local port
+	// for this run, whatever diagnostics.json says; zero follows it
+	DiagnosticsPort int
+
+	// OnMessageHook is a program run for every text message received, with
+	// the message metadata in its environment and the message as JSON on
+	// stdin. Empty runs nothing.
+	OnMessageHook string
+
+	// Tor sends every connection through a local Tor daemon: TCP dials go
+	// through its SOCKS port TorSOCKS, and peers reach the node at an onion
+	// service published through its control port TorControl (empty uses
+	// DefaultTorSOCKS and DefaultTorControl). LAN discovery, QUIC and direct
+	// connections are off, as with any SOCKS proxy.
+	Tor        bool
+	TorSOCKS   string
+	TorControl string
+
+	// ConnLowWater and ConnHighWater bound the open connections: above the
+	// high watermark idle connections to strangers are closed until the low
+	// one is reached. Contacts and peers with transfers in progress are never
+	// pruned. Zero keeps DefaultConnLowWater and DefaultConnHighWater.
+	ConnLowWater  int
+	ConnHighWater int
+
+	// BrowserTransports listens for WebTransport and WebRTC-direct
+	// connections, which browsers can open without a gateway. Both run over
+	// UDP, so they are off whenever QUIC is.
+	BrowserTransports bool
+
+	// SwarmKey, when set, makes the node part of a private network: only
+	// nodes holding the same pre-shared key can connect to it. QUIC and the
+	// browser transports cannot encrypt with it and are off, and the public
+	// bootstrap peers are not used.
+	SwarmKey pnet.PSK
+
+	// AnnounceAddrs are advertised to peers besides the listen addresses,
+	// e.g. a public address forwarded to a pinned listen port. They are not
+	// advertised over Tor or a SOCKS proxy.
+	AnnounceAddrs []string
+
+	// Rendezvous are keys, e.g. "xelvra/v1" or the ID of a group, under
+	// which the node finds and is found by the peers that use the same key
+	// through the DHT
+	Rendezvous []string
+
+	// Relays are circuit relays offered to autorelay before any other
+	// candidate
+	Relays []peer.AddrInfo
+
+	// Profile is the name of the configuration profile in use, shown in
+	// the status
+	Profile string
 }
 
 // DefaultNodeConfig returns a default configuration optimized for performance
@@ -130,18 +398,58 @@ func DefaultNodeConfig() *NodeConfig {
 		ListenAddrs: []string{
 			"/ip4/0.0.0.0/tcp/0",
 			"/ip4/0.0.0.0/udp/0/quic-v1",
+			"/ip6/::/tcp/0",
+			"/ip6/::/udp/0/quic-v1",
 		},
-		EnableQUIC: true,
-		EnableTCP:  true,
-		LogLevel:   logrus.InfoLevel,
+		EnableQUIC:   true,
+		EnableTCP:    true,
+		LogLevel:     logrus.InfoLevel,
+		IdentityPath: defaultIdentityPath(),
+		DataDir:      defaultDataDir(),
 	}
 }
 
+// defaultDataDir returns the directory holding the user database
+func defaultDataDir() string {
+	dataDir, _ := user.DataDir()
+	return dataDir
+}
+
+// defaultIdentityPath returns the path of the persistent identity file
+func defaultIdentityPath() string {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dataDir, user.IdentityFileName)
+}
+
 // NewPeerChatNode creates a new P2P node with optimized settings
 func NewPeerChatNode(ctx context.Context, config *NodeConfig) (*PeerChatNode, error) {
 	if config == nil {
 		config = DefaultNodeConfig()
 	}
+	if err := db.CheckBackend(config.Storage); err != nil {
+		return nil, err
+	}
+	if config.Visibility != "" {
+		if _, err := ParseVisibility(config.Visibility); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range config.Rendezvous {
+		if err := ValidateRendezvousKey(key); err != nil {
+			return nil, err
+		}
+	}
+	connManager, err := NewConnManager(config.ConnLowWater, config.ConnHighWater)
+	if err != nil {
+		return nil, err
+	}
+	bandwidthPath := ""
+	if config.DataDir != "" {
+		bandwidthPath = filepath.Join(config.DataDir, BandwidthFileName)
+	}
 
 	// Use provided logger or create default one
 	var logger *logrus.Logger
@@ -155,10 +463,22 @@ func NewPeerChatNode(ctx context.Context, config *NodeConfig) (*PeerChatNode, er
 		})
 	}
 
-	// Generate MessengerID (which includes Ed25519 keys)
-	identity, err := user.GenerateMessengerID()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate messenger identity: %w", err)
+	// Load or generate MessengerID (which includes Ed25519 keys)
+	var identity *user.MessengerID
+	if config.IdentityPath != "" {
+		var created bool
+		identity, created, err = user.LoadOrCreateMessengerID(config.IdentityPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load messenger identity: %w", err)
+		}
+		if created {
+			logger.WithField("path", config.IdentityPath).Info("Created new persistent identity")
+		}
+	} else {
+		identity, err = user.GenerateMessengerID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate messenger identity: %w", err)
+		}
 	}
 
 	// Convert to libp2p private key
@@ -167,33 +487,174 @@ func NewPeerChatNode(ctx context.Context, config *NodeConfig) (*PeerChatNode, er
 		return nil, fmt.Errorf("failed to convert private key: %w", err)
 	}
 
+	// Meter traffic per peer, adding to the totals of earlier runs
+	bandwidth, err := NewBandwidthBook(bandwidthPath)
+	if err != nil {
+		logger.WithError(err).Warn("Bandwidth totals of earlier runs are ignored")
+		bandwidth, _ = NewBandwidthBook("")
+	}
+
+	// Dial first the address that worked best for a peer
+	quality := NewQualityBook()
+
 	// Create context with cancellation
 	nodeCtx, cancel := context.WithCancel(ctx)
 
+	// Enforce per-conversation transport pins on every connection
+	gater := NewTransportGater(logger)
+
+	// Over Tor or another SOCKS proxy, anything not going through the proxy
+	// would reveal the local IP address
+	proxyConfig := ProxyFromEnvironment()
+	proxyOnly := proxyConfig.IsSOCKS() && !config.AllowDirectWithProxy
+	listenAddrs, enableQUIC := config.ListenAddrs, config.EnableQUIC
+	if config.Tor {
+		proxyConfig, proxyOnly = TorProxyConfig(config.TorSOCKS), true
+		listenAddrs, enableQUIC = proxyOnlyListenAddrs, false
+		logger.Warn("Tor mode: LAN discovery, QUIC, STUN and direct connections are disabled so the local IP address is not revealed")
+	} else if proxyOnly {
+		listenAddrs, enableQUIC = proxyOnlyListenAddrs, false
+		logger.Warn("SOCKS proxy configured: LAN discovery, QUIC, STUN and direct connections are disabled so the local IP address is not revealed")
+	} else if proxyConfig.IsSOCKS() {
+		proxyConfig.Strict = false
+		logger.Warn("SOCKS proxy configured but direct connections are allowed: LAN discovery and direct connections may reveal the local IP address")
+	}
+
+	// Apply what was learned on this network before; over a proxy nothing
+	// about the local network is used
+	var profile *NetworkProfile
+	if !proxyOnly {
+		profile = loadNetworkProfile(config.DataDir, logger)
+	}
+	quicDisabled := ""
+	switch {
+	case config.Tor:
+		quicDisabled = "Tor"
+	case proxyOnly:
+		quicDisabled = "SOCKS proxy"
+	case !enableQUIC:
+		quicDisabled = "configuration"
+	case len(config.SwarmKey) > 0:
+		enableQUIC, quicDisabled = false, "private network"
+		logger.Info("Private network: QUIC cannot use the swarm key, using TCP only")
+	case QUICDisabledByEnvironment():
+		enableQUIC, quicDisabled = false, DisableQUICEnv
+		logger.WithField("variable", DisableQUICEnv).Warn("QUIC disabled by the environment, using TCP only")
+	case profile != nil && profile.QUICBlocked():
+		enableQUIC, quicDisabled = false, "blocked on this network"
+		logger.WithField("network", profile.Network).Warn("QUIC never connected on this network, using TCP only")
+	}
+	// Without QUIC there must be TCP; over a proxy there is only TCP
+	enableTCP := config.EnableTCP || proxyOnly || config.Tor || !enableQUIC
+	if enableTCP && !config.EnableTCP {
+		logger.WithField("reason", quicDisabled).Warn("TCP is turned off but QUIC is not available, using TCP")
+	}
+	if !enableQUIC {
+		listenAddrs = withoutQUICAddrs(listenAddrs)
+	}
+	if !enableTCP {
+		listenAddrs = withoutTCPAddrs(listenAddrs)
+	}
+	browserDisabled := ""
+	if config.BrowserTransports {
+		if enableQUIC {
+			listenAddrs = append(slices.Clone(listenAddrs), browserListenAddrs...)
+		} else {
+			browserDisabled = "QUIC is off: " + quicDisabled
+			logger.WithField("reason", quicDisabled).Warn("Browser transports need UDP and are disabled with QUIC")
+		}
+	}
+
+	// The DHT routing through, refreshed by runDHTRefresh
+	var routingDHT *dual.DHT
+
+	// Keep the long-term peer ID out of the DHT if asked to
+	var dhtHost host.Host
+	if config.EphemeralDHTIdentity {
+		dhtHost, err = newDHTHost(randomPortAddrs(listenAddrs), proxyConfig, config.SwarmKey, logger)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
 	// Configure libp2p options for optimal performance
 	opts := []libp2p.Option{
 		libp2p.Identity(privKey),
-		libp2p.ListenAddrStrings(config.ListenAddrs...),
+		libp2p.ConnectionGater(gater),
+		libp2p.ConnectionManager(connManager),
+		libp2p.BandwidthReporter(bandwidth.Counter()),
+		libp2p.PrivateNetwork(config.SwarmKey),
 		libp2p.Ping(false),   // Disable built-in ping to save resources
 		libp2p.EnableRelay(), // Enable relay for NAT traversal (basic relay support)
+		// Dial the best known path first, QUIC before TCP, and TCP only
+		// where QUIC does not connect
+		libp2p.SwarmOpts(swarm.WithDialRanker(quality.DialRanker)),
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			// Create DHT for routing
-			dht, err := dual.New(nodeCtx, h)
+			// Create DHT for routing, on the ephemeral DHT host if any
+			if dhtHost != nil {
+				h = dhtHost
+			}
+			dht, err := dual.New(nodeCtx, h, dhtOptions()...)
 			if err != nil {
 				return nil, err
 			}
+			routingDHT = dht
 			return dht, nil
 		}),
 	}
 
+	// Reserve relay slots while not reachable directly, and upgrade relayed
+	// connections by hole punching (DCUtR). Hole punching and dialing back
+	// for other peers' AutoNAT checks cannot go through a proxy.
+	bootstrapPeers := config.BootstrapPeers
+	if len(bootstrapPeers) == 0 && len(config.SwarmKey) == 0 {
+		bootstrapPeers = getBootstrapPeers()
+	}
+	relays := &relayFinder{static: config.Relays, bootstrap: bootstrapPeers}
+	opts = append(opts, relayOptions(relays, profile)...)
+	var holePunches *HolePunchTracer
+	if !proxyOnly {
+		holePunches = NewHolePunchTracer(logger)
+		opts = append(opts,
+			libp2p.EnableHolePunching(holepunch.WithTracer(holePunches)),
+			libp2p.EnableNATService())
+	}
+
 	// Add TCP transport
-	if config.EnableTCP {
-		opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+	if enableTCP {
+		opts = append(opts, tcpTransport(proxyConfig, logger))
 		logger.Info("TCP transport enabled")
 	}
 
+	// Over Tor, dial onion addresses and advertise the onion service
+	// instead of the loopback listener
+	var onion *onionAddrs
+	if config.Tor {
+		onion = &onionAddrs{}
+		opts = append(opts,
+			libp2p.Transport(NewOnionTransport, WithTorSOCKS(config.TorSOCKS)),
+			libp2p.AddrsFactory(onion.factory))
+		logger.Info("Onion transport enabled")
+	} else if len(config.AnnounceAddrs) > 0 && !proxyOnly {
+		announce := make([]ma.Multiaddr, 0, len(config.AnnounceAddrs))
+		for _, addr := range config.AnnounceAddrs {
+			a, err := ma.NewMultiaddr(addr)
+			if err != nil {
+				if dhtHost != nil {
+					_ = dhtHost.Close()
+				}
+				cancel()
+				return nil, fmt.Errorf("invalid announce address %q: %w", addr, err)
+			}
+			announce = append(announce, a)
+		}
+		opts = append(opts, libp2p.AddrsFactory(announceFactory(announce)))
+		logger.WithField("addresses", config.AnnounceAddrs).Info("Announcing configured addresses")
+	}
+
 	// Add QUIC transport with buffer size configuration
-	if config.EnableQUIC {
+	if enableQUIC {
 		// Try to increase UDP buffer sizes for QUIC
 		if err := increaseUDPBufferSizes(logger); err != nil {
 			logger.WithError(err).Warn("Failed to increase UDP buffer sizes, QUIC performance may be reduced")
@@ -205,15 +666,35 @@ func NewPeerChatNode(ctx context.Context, config *NodeConfig) (*PeerChatNode, er
 		}
 
 		opts = append(opts, libp2p.Transport(libp2pquic.NewTransport))
+		if option := quicPacketOption(config.QUICMTU, profile, logger); option != nil {
+			opts = append(opts, option)
+		}
 		logger.Info("QUIC transport enabled")
+
+		// Let browsers connect directly
+		if config.BrowserTransports {
+			opts = append(opts,
+				libp2p.Transport(libp2pwebtransport.New),
+				libp2p.Transport(libp2pwebrtc.New))
+			logger.Info("WebTransport and WebRTC transports enabled")
+		}
 	}
 
-	// Create the libp2p host
-	h, err := libp2p.New(opts...)
+	// Create the libp2p host. If none of the pinned ports is free, listen
+	// on random ones rather than not at all.
+	h, err := libp2p.New(append(opts, libp2p.ListenAddrStrings(listenAddrs...))...)
+	if err != nil && len(pinnedAddrs(listenAddrs)) > 0 {
+		logger.WithError(err).Warn("Could not listen on the configured addresses, listening on free ports instead")
+		h, err = libp2p.New(append(opts, libp2p.ListenAddrStrings(randomPortAddrs(listenAddrs)...))...)
+	}
 	if err != nil {
+		if dhtHost != nil {
+			_ = dhtHost.Close()
+		}
 		cancel()
 		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
 	}
+	relays.setHost(h)
 
 	node := &PeerChatNode{
 		host:      h,
@@ -223,19 +704,155 @@ func NewPeerChatNode(ctx context.Context, config *NodeConfig) (*PeerChatNode, er
 		startTime: time.Now(),
 		config:    config,
 		identity:  identity,
+		proxyOnly: proxyOnly,
+		dhtHost:   dhtHost,
+
+		quicDisabled:    quicDisabled,
+		browserDisabled: browserDisabled,
+		holePunches:     holePunches,
+		transportGater:  gater,
+		networkProfile:  profile,
+		onionAddrs:      onion,
+		connProtector:   NewConnProtector(connManager),
+		bandwidth:       bandwidth,
+		quality:         quality,
 	}
+	if config.DataDir != "" {
+		node.peerCache = newPeerCache(filepath.Join(config.DataDir, PeerCacheFileName), h, logger)
+		if restored := node.peerCache.restore(); len(restored) > 0 {
+			logger.WithField("peers", len(restored)).Info("Restored cached peer addresses")
+		}
+	}
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			node.countConnection(conn)
+			quality.RecordConnection(conn.RemotePeer(), conn.RemoteMultiaddr())
+		},
+	})
+	node.listenOnFreePorts(listenAddrs)
 
 	// Create network components
 	node.stunClient = NewLegacySTUNClient(logger)
 	node.discoveryManager = NewDiscoveryManager(h, logger)
+	node.discoveryManager.SetBootstrapPeers(bootstrapPeers)
+	if len(config.BootstrapPeers) > 0 && len(config.SwarmKey) == 0 {
+		node.discoveryManager.SetBootstrapFallback(getBootstrapPeers())
+	}
+	if proxyOnly {
+		node.discoveryManager.DisableLocalDiscovery()
+	}
+	node.discoveryManager.SetLANMethods(!config.DisableMDNS, !config.DisableUDPBroadcast)
+	if dhtHost != nil {
+		node.discoveryManager.UseDHTHost(dhtHost)
+	}
 	node.energyManager = NewEnergyManager(nodeCtx, logger)
+	node.energyManager.SetBatterySaver(config.BatterySaver)
+	node.discoveryManager.SetEnergyManager(node.energyManager)
+	node.routingDHT = routingDHT
+	node.inviteManager = NewInviteManager(h, identity, logger)
+	node.inviteManager.UsePrivateNetwork(config.SwarmKey)
 
 	// Create message manager
 	node.messageManager = message.NewMessageManager(h, identity, logger)
+	node.energyManager.SetActivitySource(func() int64 {
+		sent, received := node.messageManager.MessageCounts()
+		return int64(sent + received)
+	})
+	if config.FileStreams > 0 {
+		node.messageManager.SetFileStreams(config.FileStreams)
+	}
+	if config.DisableFileCompression {
+		node.messageManager.SetFileCompression(false)
+	}
+	if config.OnMessageHook != "" {
+		node.messageManager.SetOnMessageHook(config.OnMessageHook)
+		logger.WithField("hook", config.OnMessageHook).Info("Running the on-message hook for received messages")
+	}
+	if config.Trace != "" {
+		if exporter, err := tracing.NewExporter(config.Trace); err != nil {
+			logger.WithError(err).Warn("Message tracing disabled")
+		} else {
+			node.tracer = tracing.New(exporter, logger)
+			node.messageManager.SetTracer(node.tracer)
+			logger.WithField("target", config.Trace).Info("Tracing message hops")
+		}
+	}
+
+	// Record sent and received messages in the history, and keep the
+	// offline queue and contacts, in the configured storage backend
+	stores, err := db.OpenBackend(config.Storage, config.DataDir, identity, logger)
+	if err != nil {
+		logger.WithError(err).Warn("Message history disabled")
+	} else {
+		if stores.History != nil {
+			node.history = stores.History
+			node.messageManager.SetRecorder(stores.History)
+		}
+		if stores.Offline != nil {
+			node.messageManager.SetOfflineStore(stores.Offline)
+		}
+		if stores.Contacts != nil {
+			if contacts, err := user.OpenContactBook(stores.Contacts); err != nil {
+				logger.WithError(err).Error("Failed to load contact book")
+			} else {
+				node.messageManager.SetContactBook(contacts)
+			}
+		}
+	}
+	// Announce the node only to whom the user chose, to contacts from the
+	// contact book as it is at each round
+	visibility := Visibility(config.Visibility)
+	if visibility == "" {
+		if visibility, err = LoadVisibility(); err != nil {
+			logger.WithError(err).WithField("visibility", visibility).Warn("Failed to read the saved visibility")
+		}
+	}
+	node.discoveryManager.SetVisibility(visibility)
+	node.discoveryManager.SetContactSource(node.contactPeerIDs)
+	if config.DataDir != "" {
+		// Keep a log of what discovery finds for 'discover --watch'
+		events := &discoveryEventLog{path: filepath.Join(config.DataDir, DiscoveryEventsFileName)}
+		node.discoveryManager.OnDiscoveryEvent(func(event DiscoveryEvent) {
+			if err := events.append(event); err != nil {
+				logger.WithError(err).Debug("Failed to log discovery event")
+			}
+		})
+	}
+	for _, key := range config.Rendezvous {
+		if err := node.discoveryManager.JoinRendezvous(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.DataDir != "" {
+		// Two-way sync of folders shared with the user's other devices
+		node.folderSync = message.NewFolderSyncManager(h, config.DataDir, logger)
+	}
 
 	// Set up stream handler for Xelvra protocol
 	h.SetStreamHandler(XelvraProtocolID, node.handleStream)
 
+	// Tell peers which address their connections come from
+	node.observations = NewObservationBook()
+	ServeObservedAddr(h)
+
+	// Echo the probes of peers measuring their round trip time
+	ServePing(h)
+
+	// Wake peers that sleep behind a NAT, and be woken
+	node.wakeSent = make(map[peer.ID]time.Time)
+	if config.ServeWake {
+		node.wakeHost = ServeWake(h, logger)
+	}
+	if config.WakeRelay != "" {
+		if client, err := NewWakeClient(h, config.WakeRelay, logger); err != nil {
+			logger.WithError(err).Warn("Wake registration disabled")
+		} else {
+			client.Energy = node.energyManager
+			node.wakeClient = client
+		}
+	}
+
 	logger.WithFields(logrus.Fields{
 		"peer_id": h.ID().String(),
 		"addrs":   h.Addrs(),
@@ -267,11 +884,37 @@ func (n *PeerChatNode) Start() error {
 	consoleHandler := message.NewConsoleMessageHandler(n.logger)
 	n.messageManager.RegisterHandler(message.MessageTypeText, consoleHandler)
 	n.messageManager.RegisterHandler(message.MessageTypeSystem, consoleHandler)
+	n.messageManager.OnDeliveryFailed = consoleHandler.HandleDeliveryError
+	n.messageManager.OnOfflineBacklog = consoleHandler.HandleOfflineBacklog
+	n.messageManager.OnTransferRelayed = consoleHandler.HandleTransferRelayed
+	n.messageManager.OnQueuedTransferStarted = consoleHandler.HandleQueuedTransferStarted
+	n.messageManager.OnQueuedTransferDone = consoleHandler.HandleQueuedTransferDone
+	n.messageManager.OnStoredOffline = n.wakePeer
+	n.messageManager.OnDelivered = n.keyChangeDelivered
 	n.logger.Debug("Message handlers registered, writing status file...")
 
-	// Start NAT discovery
-	n.logger.Debug("Starting NAT discovery...")
-	go n.discoverNAT()
+	// Tell contacts about a key rotation performed since the last run
+	n.sendPendingKeyChange()
+
+	// Over Tor, become reachable at the onion service
+	if n.config.Tor {
+		n.publishOnionService()
+	}
+
+	// Use the NAT remembered for this network or discover it; STUN queries
+	// would bypass the proxy
+	n.applyNetworkProfile()
+	if n.config.DataDir != "" && !n.proxyOnly {
+		go n.runNetworkProfiles()
+	}
+
+	// Follow whether peers can dial us, as AutoNAT finds out
+	go n.runReachability()
+
+	// Ask peers which address they see us at; over a proxy they see the proxy
+	if !n.proxyOnly {
+		go n.runObservedAddrs()
+	}
 
 	// Start energy management
 	n.logger.Debug("Starting energy management...")
@@ -285,11 +928,80 @@ func (n *PeerChatNode) Start() error {
 		n.logger.WithError(err).Warn("Failed to start peer discovery")
 	}
 
-	// Write initial status file
+	// Serve one-time invites from temporary identities
+	if err := n.inviteManager.Start(); err != nil {
+		n.logger.WithError(err).Warn("Failed to start invite manager")
+	}
+
+	// Stay registered with the wake host, and come online when woken
+	if n.wakeClient != nil {
+		n.wakeClient.OnWake = func(from peer.ID) {
+			consoleHandler.HandleWake(from.String())
+			n.answerWake(from)
+		}
+		go n.wakeClient.Run(n.ctx)
+	}
+
+	// Keep the claimed nickname published in the DHT
+	go n.runNamePublisher()
+
+	// Refresh the DHT routing tables, less often in battery saver mode
+	go n.runDHTRefresh()
+
+	// Drop connections that violate transport pins set while connected
+	go n.runTransportPinEnforcer()
+
+	// Redial contacts when their connection drops; queued messages and
+	// files follow as soon as they are connected again
+	n.reconnector = NewReconnector(n.redial, func(id peer.ID) bool {
+		return n.host.Network().Connectedness(id) == network.Connected
+	}, n.logger)
+	n.reconnector.OnReconnected = func(id peer.ID, _ int) {
+		consoleHandler.HandleReconnected(id.String())
+	}
+	n.watchDisconnects()
+
+	// Dial contacts at the addresses cached by the last run, and keep the
+	// cache up to date
+	if n.peerCache != nil {
+		n.dialCachedContacts()
+		go n.runPeerCache()
+	}
+
+	// Never prune connections to contacts or transfers in progress
+	go n.runConnProtector()
+
+	// Keep the traffic totals on disk for status
+	if n.config.DataDir != "" {
+		go n.runBandwidthSaver()
+	}
+
+	// Keep shared folders in sync with the user's other devices
+	if n.folderSync != nil {
+		go n.folderSync.Run(n.ctx)
+	}
+
+	// Delete history that the retention policy no longer keeps
+	if n.history != nil {
+		go n.history.RunRetention(n.ctx, filepath.Join(n.config.DataDir, db.RetentionFileName))
+	}
+
+	// Back up to a friend's node when backups are set up
+	if n.config.DataDir != "" {
+		go n.runBackups()
+	}
+
+	// Serve profiles on localhost when diagnostics are enabled
+	if n.config.DataDir != "" || n.config.DiagnosticsPort > 0 {
+		n.startDiagnostics()
+	}
+
+	// Write initial status file, and keep it current
 	if err := n.writeStatusFile(); err != nil {
 		n.logger.WithError(err).Warn("Failed to write status file")
 	}
 	n.logger.Debug("Status file written successfully")
+	go n.runStatusRefresher()
 
 	n.logger.Info("PeerChatNode started successfully")
 	return nil
@@ -306,6 +1018,24 @@ func (n *PeerChatNode) Stop() error {
 		}
 	}
 
+	// Stop invite manager
+	if n.inviteManager != nil {
+		if err := n.inviteManager.Stop(); err != nil {
+			n.logger.WithError(err).Error("Failed to stop invite manager")
+		}
+	}
+
+	// Remove the onion service
+	n.mu.Lock()
+	onionService := n.onionService
+	n.onionService = nil
+	n.mu.Unlock()
+	if onionService != nil {
+		if err := onionService.Close(); err != nil {
+			n.logger.WithError(err).Warn("Failed to remove onion service")
+		}
+	}
+
 	// Stop discovery manager
 	if n.discoveryManager != nil {
 		if err := n.discoveryManager.Stop(); err != nil {
@@ -320,6 +1050,26 @@ func (n *PeerChatNode) Stop() error {
 		}
 	}
 
+	// Stop serving profiles
+	n.stopDiagnostics()
+	n.saveNetworkProfile()
+	n.saveBandwidth()
+	if n.peerCache != nil {
+		n.savePeerCache()
+	}
+
+	// Export the remaining spans
+	if err := n.tracer.Close(); err != nil {
+		n.logger.WithError(err).Warn("Failed to close trace exporter")
+	}
+
+	// Close message history
+	if n.history != nil {
+		if err := n.history.Close(); err != nil {
+			n.logger.WithError(err).Error("Failed to close message history")
+		}
+	}
+
 	// Remove status file
 	if err := n.removeStatusFile(); err != nil {
 		n.logger.WithError(err).Warn("Failed to remove status file")
@@ -333,6 +1083,11 @@ func (n *PeerChatNode) Stop() error {
 		n.logger.WithError(err).Error("Error closing libp2p host")
 		return err
 	}
+	if n.dhtHost != nil {
+		if err := n.dhtHost.Close(); err != nil {
+			n.logger.WithError(err).Error("Error closing DHT host")
+		}
+	}
 
 	uptime := time.Since(n.startTime)
 	n.mu.RLock()
@@ -362,17 +1117,281 @@ func (n *PeerChatNode) SendMessage(to string, content []byte, msgType message.Me
 	if n.messageManager == nil {
 		return fmt.Errorf("message manager not initialized")
 	}
+	n.energyManager.NoteActivity()
 	return n.messageManager.SendMessage(to, content, msgType)
 }
 
+// SendMessageToPeers sends one message to many peers, sealing its body once
+func (n *PeerChatNode) SendMessageToPeers(to []string, content []byte, msgType message.MessageType) error {
+	if n.messageManager == nil {
+		return fmt.Errorf("message manager not initialized")
+	}
+	n.energyManager.NoteActivity()
+	return n.messageManager.SendMessageToPeers(to, content, msgType)
+}
+
+// SendNotice sends a structured system notice to a peer
+func (n *PeerChatNode) SendNotice(to string, notice *message.Notice) error {
+	if n.messageManager == nil {
+		return fmt.Errorf("message manager not initialized")
+	}
+	return n.messageManager.SendNotice(to, notice)
+}
+
+// ResetSession discards the encrypted sessions with a peer, starts a new
+// one and tells the peer
+func (n *PeerChatNode) ResetSession(peerID string) (string, error) {
+	if n.messageManager == nil {
+		return "", fmt.Errorf("message manager not initialized")
+	}
+	return n.messageManager.ResetSession(peerID)
+}
+
 // SendFile sends a file to a peer
 func (n *PeerChatNode) SendFile(peerID peer.ID, filePath string) error {
 	if n.messageManager == nil {
 		return fmt.Errorf("message manager not initialized")
 	}
+	n.energyManager.NoteActivity()
 	return n.messageManager.SendFile(peerID, filePath)
 }
 
+// QueueFile queues a file for a peer, sent whenever the peer is connected
+func (n *PeerChatNode) QueueFile(peerID peer.ID, filePath string) (*message.QueuedTransfer, error) {
+	if n.messageManager == nil {
+		return nil, fmt.Errorf("message manager not initialized")
+	}
+	return n.messageManager.QueueFile(peerID, filePath)
+}
+
+// SendDirectory transfers a directory to a peer, skipping files it already has
+func (n *PeerChatNode) SendDirectory(peerID peer.ID, dir string) (*message.DirSyncResult, error) {
+	if n.messageManager == nil {
+		return nil, fmt.Errorf("message manager not initialized")
+	}
+	return n.messageManager.SendDirectory(peerID, dir)
+}
+
+// RedeemInvite redeems a one-time invite and connects to the inviting peer
+func (n *PeerChatNode) RedeemInvite(ctx context.Context, code string) (*peer.AddrInfo, string, error) {
+	if n.inviteManager == nil {
+		return nil, "", fmt.Errorf("invite manager not initialized")
+	}
+
+	info, did, err := n.inviteManager.Redeem(ctx, code)
+	if err != nil {
+		return nil, "", err
+	}
+
+	n.discoveryManager.AddDiscoveredPeer(*info, "invite")
+
+	if err := n.Connect(ctx, *info); err != nil {
+		return info, did, fmt.Errorf("invite verified but connection failed: %w", err)
+	}
+
+	return info, did, nil
+}
+
+// SetVisibility changes who discovery announces the node to, and saves the
+// level for later starts
+func (n *PeerChatNode) SetVisibility(v Visibility) error {
+	if err := SaveVisibility(v); err != nil {
+		return fmt.Errorf("failed to save visibility: %w", err)
+	}
+	n.discoveryManager.SetVisibility(v)
+	n.logger.WithField("visibility", v).Info("Discovery visibility changed")
+	return nil
+}
+
+// SetLANDiscovery turns mDNS and UDP broadcast discovery on or off
+func (n *PeerChatNode) SetLANDiscovery(mdns, broadcast bool) {
+	n.discoveryManager.SetLANMethods(mdns, broadcast)
+	n.logger.WithFields(logrus.Fields{"mdns": mdns, "udp_broadcast": broadcast}).Info("LAN discovery methods changed")
+}
+
+// Visibility returns who discovery announces the node to
+func (n *PeerChatNode) Visibility() Visibility {
+	return n.discoveryManager.Visibility()
+}
+
+// JoinRendezvous announces the node under the rendezvous key and looks for
+// the peers announced under it
+func (n *PeerChatNode) JoinRendezvous(key string) error {
+	if err := n.discoveryManager.JoinRendezvous(key); err != nil {
+		return err
+	}
+	n.logger.WithField("rendezvous", key).Info("Joined rendezvous")
+	return nil
+}
+
+// LeaveRendezvous stops announcing the node under the rendezvous key,
+// reporting whether it had joined it
+func (n *PeerChatNode) LeaveRendezvous(key string) bool {
+	return n.discoveryManager.LeaveRendezvous(key)
+}
+
+// Rendezvous returns the rendezvous keys the node has joined
+func (n *PeerChatNode) Rendezvous() []string {
+	return n.discoveryManager.Rendezvous()
+}
+
+// FindRendezvous looks up the peers announced under the rendezvous key and
+// connects to them
+func (n *PeerChatNode) FindRendezvous(key string) ([]peer.AddrInfo, error) {
+	return n.discoveryManager.FindRendezvous(key)
+}
+
+// contactPeerIDs returns the peer IDs in the contact book
+func (n *PeerChatNode) contactPeerIDs() []peer.ID {
+	contacts := n.messageManager.Contacts()
+	if contacts == nil {
+		return nil
+	}
+
+	var ids []peer.ID
+	for _, c := range contacts.List() {
+		if id, err := peer.Decode(c.PeerID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SetTransportPin pins the conversation with a peer to a transport policy and
+// closes any existing connection that violates it
+func (n *PeerChatNode) SetTransportPin(peerID peer.ID, policy TransportPolicy) error {
+	if err := SetTransportPin(peerID.String(), policy); err != nil {
+		return err
+	}
+	n.transportGater.Reload()
+	n.enforceTransportPins()
+	return nil
+}
+
+// GetTransportPin returns the transport policy pinned for a peer
+func (n *PeerChatNode) GetTransportPin(peerID peer.ID) TransportPolicy {
+	return n.transportGater.PolicyFor(peerID)
+}
+
+// enforceTransportPins closes connections not permitted by their peer's pin
+func (n *PeerChatNode) enforceTransportPins() {
+	for _, conn := range n.host.Network().Conns() {
+		policy := n.transportGater.PolicyFor(conn.RemotePeer())
+		if policy.Allows(conn.RemoteMultiaddr()) {
+			continue
+		}
+
+		n.logger.WithFields(logrus.Fields{
+			"peer_id": conn.RemotePeer().String(),
+			"addr":    conn.RemoteMultiaddr().String(),
+			"policy":  policy,
+		}).Info("Closing connection that violates transport pin")
+		if err := conn.Close(); err != nil {
+			n.logger.WithError(err).Debug("Failed to close pinned connection")
+		}
+	}
+}
+
+// runTransportPinEnforcer periodically applies pins changed by other processes
+func (n *PeerChatNode) runTransportPinEnforcer() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.transportGater.Reload()
+			n.enforceTransportPins()
+		}
+	}
+}
+
+// sendPendingKeyChange announces a pending key rotation to the contacts
+// that have not confirmed receiving it yet. The announcement stays pending
+// until all of them have, so it is sent again on the next start.
+func (n *PeerChatNode) sendPendingKeyChange() {
+	if n.config.IdentityPath == "" {
+		return
+	}
+
+	ann, delivered, err := user.LoadPendingAnnouncement(n.config.IdentityPath)
+	if err != nil || ann == nil {
+		return
+	}
+	if n.announcedToAllContacts(delivered) {
+		n.clearPendingKeyChange()
+		return
+	}
+
+	sent, err := n.messageManager.AnnounceKeyChange(ann, delivered)
+	if err != nil {
+		n.logger.WithError(err).Warn("Failed to announce key change")
+		return
+	}
+
+	n.logger.WithField("contacts", sent).Info("Key change announced to contacts")
+}
+
+// keyChangeDelivered records a contact that received the pending key-change
+// announcement, and clears it once all contacts have
+func (n *PeerChatNode) keyChangeDelivered(msg *message.Message) {
+	if msg.Type != message.MessageTypeSystem || msg.Metadata[message.MetadataKind] != message.KindKeyChange {
+		return
+	}
+	if n.config.IdentityPath == "" {
+		return
+	}
+
+	delivered, err := user.AnnouncementDelivered(n.config.IdentityPath, msg.To)
+	if err != nil {
+		n.logger.WithError(err).Warn("Failed to record delivered key change announcement")
+		return
+	}
+	n.logger.WithField("peer_id", msg.To).Debug("Key change announcement delivered")
+	if n.announcedToAllContacts(delivered) {
+		n.clearPendingKeyChange()
+	}
+}
+
+// announcedToAllContacts reports whether every contact is in delivered
+func (n *PeerChatNode) announcedToAllContacts(delivered []string) bool {
+	done := make(map[string]bool, len(delivered))
+	for _, peerID := range delivered {
+		done[peerID] = true
+	}
+	for _, contact := range n.messageManager.Contacts().List() {
+		if !done[contact.PeerID] {
+			return false
+		}
+	}
+	return true
+}
+
+// clearPendingKeyChange forgets the key-change announcement once every
+// contact received it
+func (n *PeerChatNode) clearPendingKeyChange() {
+	if err := user.ClearPendingAnnouncement(n.config.IdentityPath); err != nil {
+		n.logger.WithError(err).Warn("Failed to clear pending key change announcement")
+		return
+	}
+	n.logger.Info("Key change announcement delivered to all contacts")
+}
+
+// GetHistory returns the message history store, or nil if disabled
+func (n *PeerChatNode) GetHistory() db.HistoryStore {
+	return n.history
+}
+
+// GetContactBook returns the contact book with pinned identity keys
+func (n *PeerChatNode) GetContactBook() *user.ContactBook {
+	if n.messageManager == nil {
+		return nil
+	}
+	return n.messageManager.Contacts()
+}
+
 // GetIdentity returns the node's identity
 func (n *PeerChatNode) GetIdentity() *user.MessengerID {
 	return n.identity
@@ -398,13 +1417,17 @@ func (n *PeerChatNode) GetStats() map[string]interface{} {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 
-	return map[string]interface{}{
+	stats := map[string]interface{}{
 		"peer_id":            n.host.ID().String(),
 		"uptime_seconds":     time.Since(n.startTime).Seconds(),
 		"messages_processed": n.messageCount,
 		"connected_peers":    len(n.host.Network().Peers()),
 		"listen_addrs":       n.host.Addrs(),
 	}
+	if n.dhtHost != nil {
+		stats["dht_peer_id"] = n.dhtHost.ID().String()
+	}
+	return stats
 }
 
 // handleStream handles incoming streams on the Xelvra protocol
@@ -435,11 +1458,11 @@ func (n *PeerChatNode) handleStream(stream network.Stream) {
 
 // getStatusFilePath returns the path to the status file
 func getStatusFilePath() (string, error) {
-	home, err := os.UserHomeDir()
+	dataDir, err := user.DataDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".xelvra", "node_status.json"), nil
+	return filepath.Join(dataDir, "node_status.json"), nil
 }
 
 // writeStatusFile writes the current node status to a file
@@ -460,17 +1483,6 @@ func (n *PeerChatNode) writeStatusFile() error {
 		addrs[i] = addr.String()
 	}
 
-	// Collect transport information
-	transports := []NetworkTransport{}
-	for _, addr := range n.host.Addrs() {
-		transport := NetworkTransport{
-			Type:      "tcp", // Simplified - would need to detect actual transport
-			LocalAddr: addr.String(),
-			IsActive:  true,
-		}
-		transports = append(transports, transport)
-	}
-
 	// Get discovery status
 	var discoveryStatus *DiscoveryStatus
 	if n.discoveryManager != nil {
@@ -479,6 +1491,13 @@ func (n *PeerChatNode) writeStatusFile() error {
 
 	n.mu.RLock()
 	natInfo := n.natInfo
+	diagnosticsAddr := n.diagnosticsAddr
+	reachability := n.reachability
+	onionService := n.onionService
+	network := ""
+	if n.networkProfile != nil {
+		network = n.networkProfile.Network
+	}
 	n.mu.RUnlock()
 
 	status := NodeStatus{
@@ -491,11 +1510,61 @@ func (n *PeerChatNode) writeStatusFile() error {
 		LastUpdate:        time.Now(),
 		ProcessID:         os.Getpid(),
 		IsRunning:         true,
-		Transports:        transports,
+		Transports:        n.transportStatus(),
+		QUICDisabled:      n.quicDisabled,
 		NATInfo:           natInfo,
+		Network:           network,
+		ObservedAddrs:     n.observations.Addrs(),
 		Discovery:         discoveryStatus,
 		NetworkQuality:    n.GetNetworkQuality(),
+		DiagnosticsAddr:   diagnosticsAddr,
+		StorageBackend:    n.config.Storage,
+		Profile:           n.config.Profile,
+	}
+	if status.StorageBackend == "" {
+		status.StorageBackend = db.SQLiteBackend
+	}
+	status.Tor = n.config.Tor
+	if onionService != nil {
+		status.OnionAddr = onionService.Addr.String()
+	}
+	status.BrowserDisabled = n.browserDisabled
+	low, high, _ := connWatermarks(n.config.ConnLowWater, n.config.ConnHighWater)
+	status.ConnLimits = &ConnLimits{LowWater: low, HighWater: high, Protected: n.connProtector.Count()}
+	if len(n.config.SwarmKey) > 0 {
+		status.PrivateNetwork = SwarmKeyFingerprint(n.config.SwarmKey)
+	}
+	for _, addr := range n.host.Addrs() {
+		if IsBrowserAddr(addr) {
+			status.BrowserAddrs = append(status.BrowserAddrs, fmt.Sprintf("%s/p2p/%s", addr, n.host.ID()))
+		}
+	}
+	status.Reachability = reachabilityName(reachability)
+	if n.holePunches != nil {
+		holePunch := n.holePunches.Status()
+		status.HolePunch = &holePunch
 	}
+	for _, addr := range RelayAddrs(n.host) {
+		status.RelayAddrs = append(status.RelayAddrs, addr.String())
+	}
+	if natInfo != nil && len(status.RelayAddrs) > 0 {
+		withRelay := *natInfo
+		withRelay.UsingRelay = true
+		status.NATInfo = &withRelay
+	}
+	if n.wakeClient != nil {
+		status.WakeRelay = n.wakeClient.Relay().String()
+		status.WakeRegistered = n.wakeClient.Registered()
+	}
+	if n.wakeHost != nil {
+		status.WakeClients = n.wakeHost.Clients()
+	}
+	if n.messageManager != nil {
+		usage := n.messageManager.StorageUsage()
+		status.Storage = &usage
+	}
+	status.Energy = n.GetEnergyProfile()
+	status.Peers = n.peerStatuses()
 
 	// Write to file
 	data, err := json.MarshalIndent(status, "", "  ")
@@ -506,6 +1575,46 @@ func (n *PeerChatNode) writeStatusFile() error {
 	return os.WriteFile(statusPath, data, 0600)
 }
 
+// peerStatuses lists the connected peers for the status file
+func (n *PeerChatNode) peerStatuses() []PeerStatus {
+	var peers []PeerStatus
+	for _, id := range n.host.Network().Peers() {
+		conns := n.host.Network().ConnsToPeer(id)
+		if len(conns) == 0 {
+			continue
+		}
+		entry := PeerStatus{
+			PeerID:   id.String(),
+			Addr:     conns[0].RemoteMultiaddr().String(),
+			ViaRelay: onlyRelayed(n.host, id),
+		}
+		if n.quality != nil {
+			entry.Quality = n.quality.Peer(id).Score
+		}
+		peers = append(peers, entry)
+	}
+	slices.SortFunc(peers, func(a, b PeerStatus) int { return strings.Compare(a.PeerID, b.PeerID) })
+	return peers
+}
+
+// runStatusRefresher rewrites the status file every statusRefreshInterval
+// until the node stops
+func (n *PeerChatNode) runStatusRefresher() {
+	ticker := time.NewTicker(statusRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.writeStatusFile(); err != nil {
+				n.logger.WithError(err).Debug("Failed to refresh status file")
+			}
+		}
+	}
+}
+
 // removeStatusFile removes the status file when node stops
 func (n *PeerChatNode) removeStatusFile() error {
 	statusPath, err := getStatusFilePath()
@@ -575,6 +1684,13 @@ func ReadNodeStatus() (*NodeStatus, error) {
 	return &status, nil
 }
 
+// Fresh reports whether the status was written by a node still running at
+// now: one that did not stop, and rewrote the file recently enough. A node
+// that crashed leaves a status that soon goes stale.
+func (s *NodeStatus) Fresh(now time.Time) bool {
+	return s.IsRunning && now.Sub(s.LastUpdate) <= 3*statusRefreshInterval
+}
+
 // discoverNAT performs NAT discovery in background
 func (n *PeerChatNode) discoverNAT() {
 	ctx, cancel := context.WithTimeout(n.ctx, 30*time.Second)
@@ -596,8 +1712,13 @@ func (n *PeerChatNode) discoverNAT() {
 	}
 
 	n.mu.Lock()
+	if n.natInfo != nil && n.natInfo.ObservedByPeers {
+		// Peers see more of the NAT than a single STUN server
+		natInfo.Type, natInfo.ObservedByPeers = n.natInfo.Type, true
+	}
 	n.natInfo = natInfo
 	n.mu.Unlock()
+	n.rememberNAT(natInfo)
 
 	n.logger.WithFields(logrus.Fields{
 		"nat_type":    natInfo.Type,
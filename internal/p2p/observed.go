@@ -0,0 +1,374 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// ObservedProtocolID answers with the address a peer sees us connect from
+	ObservedProtocolID = protocol.ID("/xelvra/observed/1.0.0")
+
+	// observedMaxFrame bounds an observed address report
+	observedMaxFrame = 1024
+
+	// observedTimeout bounds asking one peer
+	observedTimeout = 10 * time.Second
+
+	// observedInterval is how often new connections are asked
+	observedInterval = 30 * time.Second
+
+	// observedMinPeers is how many peers must report before their reports
+	// classify the NAT
+	observedMinPeers = 2
+)
+
+// observedReport is the answer of the observed address protocol
+type observedReport struct {
+	Addr string `json:"addr"`
+}
+
+// ServeObservedAddr answers peers asking which address their connection
+// comes from
+func ServeObservedAddr(h host.Host) {
+	h.SetStreamHandler(ObservedProtocolID, func(s network.Stream) {
+		defer func() { _ = s.Close() }()
+		_ = s.SetDeadline(time.Now().Add(observedTimeout))
+		report := observedReport{Addr: s.Conn().RemoteMultiaddr().String()}
+		_ = json.NewEncoder(s).Encode(report)
+	})
+}
+
+// QueryObservedAddr asks p which address it sees our connection come from,
+// and returns the connection it was asked over
+func QueryObservedAddr(ctx context.Context, h host.Host, p peer.ID) (ma.Multiaddr, network.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, observedTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(network.WithNoDial(ctx, "observed address"), p, ObservedProtocolID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = s.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+
+	var report observedReport
+	if err := json.NewDecoder(io.LimitReader(s, observedMaxFrame)).Decode(&report); err != nil {
+		return nil, nil, fmt.Errorf("failed to read observed address: %w", err)
+	}
+	observed, err := ma.NewMultiaddr(report.Addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid observed address: %w", err)
+	}
+	return observed, s.Conn(), nil
+}
+
+// ObservedAddr is an address peers see us at
+type ObservedAddr struct {
+	Addr      string `json:"addr"` // host:port
+	Transport string `json:"transport"`
+	Peers     int    `json:"peers"`
+}
+
+// observation is what one peer reported
+type observation struct {
+	conn     string // ID of the connection asked over
+	local    ma.Multiaddr
+	observed ma.Multiaddr
+}
+
+// ObservationBook keeps the address each connected peer sees us at
+type ObservationBook struct {
+	mu           sync.Mutex
+	observations map[peer.ID]observation
+}
+
+// NewObservationBook creates an empty observation book
+func NewObservationBook() *ObservationBook {
+	return &ObservationBook{observations: make(map[peer.ID]observation)}
+}
+
+// Record stores the address p sees our connection from local at
+func (b *ObservationBook) Record(p peer.ID, connID string, local, observed ma.Multiaddr) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.observations[p] = observation{conn: connID, local: local, observed: observed}
+}
+
+// Retain forgets peers whose report came over a connection for which open
+// returns false
+func (b *ObservationBook) Retain(open func(p peer.ID, connID string) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for p, o := range b.observations {
+		if !open(p, o.conn) {
+			delete(b.observations, p)
+		}
+	}
+}
+
+// Has reports whether p reported an address
+func (b *ObservationBook) Has(p peer.ID) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.observations[p]
+	return ok
+}
+
+// Addrs returns the addresses peers see us at, the most observed first
+func (b *ObservationBook) Addrs() []ObservedAddr {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := make(map[ObservedAddr]int)
+	for _, o := range b.observations {
+		addr, transport, ok := hostPort(o.observed)
+		if !ok {
+			continue
+		}
+		counts[ObservedAddr{Addr: addr, Transport: transport}]++
+	}
+
+	addrs := make([]ObservedAddr, 0, len(counts))
+	for addr, peers := range counts {
+		addr.Peers = peers
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i].Peers != addrs[j].Peers {
+			return addrs[i].Peers > addrs[j].Peers
+		}
+		return addrs[i].Addr < addrs[j].Addr
+	})
+	return addrs
+}
+
+// NATType classifies the NAT from what peers report, using the same names
+// as STUN discovery. Connections from one local socket that peers see at
+// one external port are mapped independently of the destination; different
+// ports mean a symmetric NAT. It returns "" until enough peers reported
+// public addresses. IPv4 reports decide, as IPv6 rarely goes through NAT;
+// IPv6 reports classify only a network without IPv4.
+func (b *ObservationBook) NATType() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if natType, reports := b.classify(ma.P_IP4); reports > 0 {
+		return natType
+	}
+	natType, _ := b.classify(ma.P_IP6)
+	return natType
+}
+
+// classify classifies the NAT from the reports of public addresses of one
+// family, ma.P_IP4 or ma.P_IP6, and returns how many there were; mu must
+// be held
+func (b *ObservationBook) classify(family int) (string, int) {
+	// External ports seen for each local socket
+	ports := make(map[string]map[string]bool)
+	reports, preserved, direct := 0, 0, 0
+	for _, o := range b.observations {
+		if !manet.IsPublicAddr(o.observed) || addrFamily(o.observed) != family {
+			continue
+		}
+		observed, _, ok := hostPort(o.observed)
+		local, transport, ok2 := hostPort(o.local)
+		if !ok || !ok2 {
+			continue
+		}
+		reports++
+		if observed == local {
+			direct++
+		}
+		_, observedPort, _ := net.SplitHostPort(observed)
+		_, localPort, _ := net.SplitHostPort(local)
+		if observedPort == localPort {
+			preserved++
+		}
+		key := transport + " " + local
+		if ports[key] == nil {
+			ports[key] = make(map[string]bool)
+		}
+		ports[key][observedPort] = true
+	}
+
+	switch {
+	case reports < observedMinPeers:
+		return "", reports
+	case direct == reports:
+		return "none", reports
+	}
+	for _, seen := range ports {
+		if len(seen) > 1 {
+			return "symmetric", reports
+		}
+	}
+	if preserved == reports {
+		return "full_cone", reports
+	}
+	return "port_restricted", reports
+}
+
+// IPv6Direct returns the public IPv6 address peers see us at when it is
+// the host's own, so IPv6 reaches it without NAT, or ""
+func (b *ObservationBook) IPv6Direct() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, o := range b.observations {
+		if !manet.IsPublicAddr(o.observed) || addrFamily(o.observed) != ma.P_IP6 {
+			continue
+		}
+		observedIP, err := manet.ToIP(o.observed)
+		if err != nil {
+			continue
+		}
+		if localIP, err := manet.ToIP(o.local); err == nil && observedIP.Equal(localIP) {
+			return observedIP.String()
+		}
+	}
+	return ""
+}
+
+// addrFamily returns ma.P_IP4 or ma.P_IP6 for an IP address, or 0
+func addrFamily(addr ma.Multiaddr) int {
+	if _, err := addr.ValueForProtocol(ma.P_IP4); err == nil {
+		return ma.P_IP4
+	}
+	if _, err := addr.ValueForProtocol(ma.P_IP6); err == nil {
+		return ma.P_IP6
+	}
+	return 0
+}
+
+// hostPort returns the host:port and transport of a TCP or QUIC address
+func hostPort(addr ma.Multiaddr) (string, string, bool) {
+	if addr == nil || isRelayAddr(addr) {
+		return "", "", false
+	}
+	var ip, port, transport string
+	ma.ForEach(addr, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_IP6:
+			ip = c.Value()
+		case ma.P_TCP:
+			port, transport = c.Value(), "tcp"
+		case ma.P_UDP:
+			port, transport = c.Value(), "udp"
+		case ma.P_QUIC_V1:
+			transport = "quic"
+		}
+		return true
+	})
+	if ip == "" || port == "" {
+		return "", "", false
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", "", false
+	}
+	return net.JoinHostPort(ip, port), transport, true
+}
+
+// runObservedAddrs asks newly connected peers which address they see us at
+// and refines the NAT classification with their answers
+func (n *PeerChatNode) runObservedAddrs() {
+	ticker := time.NewTicker(observedInterval)
+	defer ticker.Stop()
+
+	for {
+		n.askObservedAddrs()
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// askObservedAddrs asks peers connected directly that have not reported
+// over one of their current connections
+func (n *PeerChatNode) askObservedAddrs() {
+	n.observations.Retain(func(p peer.ID, connID string) bool {
+		for _, conn := range n.host.Network().ConnsToPeer(p) {
+			if conn.ID() == connID {
+				return true
+			}
+		}
+		return false
+	})
+
+	asked := 0
+	for _, p := range n.host.Network().Peers() {
+		if n.observations.Has(p) || !hasDirectConn(n.host, p) {
+			continue
+		}
+		observed, conn, err := QueryObservedAddr(n.ctx, n.host, p)
+		if err != nil {
+			n.logger.WithError(err).WithField("peer_id", p.String()).Debug("Peer did not report our address")
+			continue
+		}
+		n.observations.Record(p, conn.ID(), conn.LocalMultiaddr(), observed)
+		asked++
+	}
+	if asked == 0 {
+		return
+	}
+
+	natType := n.observations.NATType()
+	if natType == "" {
+		return
+	}
+	addrs := n.observations.Addrs()
+	n.mu.Lock()
+	if n.natInfo == nil {
+		n.natInfo = &NATInfo{}
+	}
+	if n.natInfo.Type != natType {
+		n.logger.WithField("nat_type", natType).Info("NAT classified from peer reports")
+	}
+	n.natInfo.Type = natType
+	n.natInfo.ObservedByPeers = true
+	for _, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr.Addr)
+		if n.natInfo.PublicIP == "" && net.ParseIP(host).To4() != nil {
+			n.natInfo.PublicIP = host
+			n.natInfo.PublicPort, _ = strconv.Atoi(port)
+		}
+	}
+	if ip6 := n.observations.IPv6Direct(); ip6 != "" {
+		n.natInfo.PublicIPv6, n.natInfo.IPv6Direct = ip6, true
+	}
+	natInfo := *n.natInfo
+	n.mu.Unlock()
+	n.rememberNAT(&natInfo)
+
+	if err := n.writeStatusFile(); err != nil {
+		n.logger.WithError(err).Warn("Failed to update status file with observed addresses")
+	}
+}
+
+// hasDirectConn reports whether p is connected other than through a relay
+func hasDirectConn(h host.Host, p peer.ID) bool {
+	for _, conn := range h.Network().ConnsToPeer(p) {
+		if !isRelayAddr(conn.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
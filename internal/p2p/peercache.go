@@ -0,0 +1,254 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PeerCacheFileName holds the addresses and protocols of peers the node
+	// was connected to, for the next start
+	PeerCacheFileName = "peers.json"
+
+	// PeerCacheTTL is how long a peer not seen again stays in the cache
+	PeerCacheTTL = 30 * 24 * time.Hour
+
+	// MaxCachedPeers caps the cache; the peers seen longest ago go first
+	MaxCachedPeers = 512
+
+	// maxCachedAddrs caps the addresses kept per peer
+	maxCachedAddrs = 8
+
+	// peerCacheSaveInterval is how often the running node saves the cache
+	peerCacheSaveInterval = 5 * time.Minute
+)
+
+// CachedPeer is what the node remembers about a peer between runs
+type CachedPeer struct {
+	PeerID    string    `json:"peer_id"`
+	Addrs     []string  `json:"addrs"`
+	Protocols []string  `json:"protocols,omitempty"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// LoadPeerCache reads the peer cache at path, by peer ID
+func LoadPeerCache(path string) (map[string]*CachedPeer, error) {
+	peers := make(map[string]*CachedPeer)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return peers, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, fmt.Errorf("failed to parse peer cache: %w", err)
+	}
+	return peers, nil
+}
+
+// SavePeerCache writes the peer cache to path, leaving out peers not seen
+// within PeerCacheTTL and the oldest beyond MaxCachedPeers
+func SavePeerCache(path string, peers map[string]*CachedPeer) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(prunePeerCache(peers), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// prunePeerCache returns the peers worth keeping
+func prunePeerCache(peers map[string]*CachedPeer) map[string]*CachedPeer {
+	kept := make([]*CachedPeer, 0, len(peers))
+	for _, cached := range peers {
+		if time.Since(cached.LastSeen) <= PeerCacheTTL && len(cached.Addrs) > 0 {
+			kept = append(kept, cached)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].LastSeen.After(kept[j].LastSeen)
+	})
+	if len(kept) > MaxCachedPeers {
+		kept = kept[:MaxCachedPeers]
+	}
+
+	pruned := make(map[string]*CachedPeer, len(kept))
+	for _, cached := range kept {
+		pruned[cached.PeerID] = cached
+	}
+	return pruned
+}
+
+// peerCache keeps what the running node learns about the peers it connects
+// to, and restores it into the peerstore on start
+type peerCache struct {
+	path string
+	host host.Host
+
+	mu    sync.Mutex
+	peers map[string]*CachedPeer
+}
+
+// newPeerCache loads the cache at path
+func newPeerCache(path string, h host.Host, logger *logrus.Logger) *peerCache {
+	peers, err := LoadPeerCache(path)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to load peer cache")
+		peers = make(map[string]*CachedPeer)
+	}
+	return &peerCache{path: path, host: h, peers: peers}
+}
+
+// restore adds the cached addresses and protocols to the peerstore and
+// returns the peers restored
+func (c *peerCache) restore() []peer.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ps := c.host.Peerstore()
+	var restored []peer.ID
+	for _, cached := range prunePeerCache(c.peers) {
+		id, err := peer.Decode(cached.PeerID)
+		if err != nil || id == c.host.ID() {
+			continue
+		}
+		addrs, _ := parseLinkAddrs(cached.Addrs)
+		if len(addrs) == 0 {
+			continue
+		}
+		// An hour, long enough for the first dials and for discovery to
+		// confirm the addresses
+		ps.AddAddrs(id, addrs, peerstore.AddressTTL)
+		if len(cached.Protocols) > 0 {
+			protocols := make([]protocol.ID, len(cached.Protocols))
+			for i, p := range cached.Protocols {
+				protocols[i] = protocol.ID(p)
+			}
+			_ = ps.AddProtocols(id, protocols...)
+		}
+		restored = append(restored, id)
+	}
+	return restored
+}
+
+// has reports whether a peer is in the cache
+func (c *peerCache) has(id peer.ID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peers[id.String()] != nil
+}
+
+// remember records the addresses and protocols the peerstore holds for the
+// peer of a connection, the address it was dialed at first. The address of
+// an inbound connection is left out, as its port is rarely one to dial.
+func (c *peerCache) remember(conn network.Conn) {
+	id := conn.RemotePeer()
+	if id == c.host.ID() {
+		return
+	}
+	ps := c.host.Peerstore()
+	addrs := ps.Addrs(id)
+	if conn.Stat().Direction == network.DirOutbound {
+		addrs = append([]ma.Multiaddr{conn.RemoteMultiaddr()}, addrs...)
+	}
+	addrs = ma.Unique(addrs)
+	if len(addrs) == 0 {
+		return
+	}
+	if len(addrs) > maxCachedAddrs {
+		addrs = addrs[:maxCachedAddrs]
+	}
+
+	cached := &CachedPeer{PeerID: id.String(), LastSeen: time.Now()}
+	for _, addr := range addrs {
+		cached.Addrs = append(cached.Addrs, addr.String())
+	}
+	if protocols, err := ps.GetProtocols(id); err == nil {
+		for _, p := range protocols {
+			cached.Protocols = append(cached.Protocols, string(p))
+		}
+		sort.Strings(cached.Protocols)
+	}
+
+	c.mu.Lock()
+	c.peers[cached.PeerID] = cached
+	c.mu.Unlock()
+}
+
+// save records the connected peers and writes the cache
+func (c *peerCache) save() error {
+	for _, conn := range c.host.Network().Conns() {
+		c.remember(conn)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peers = prunePeerCache(c.peers)
+	return SavePeerCache(c.path, c.peers)
+}
+
+// dialCachedContacts dials the contacts in the cache at once, without
+// waiting for discovery to find them again
+func (n *PeerChatNode) dialCachedContacts() {
+	for _, id := range n.contactPeerIDs() {
+		if !n.peerCache.has(id) || n.host.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+		go func(id peer.ID) {
+			ctx, cancel := context.WithTimeout(n.ctx, reconnectDialTimeout)
+			defer cancel()
+			if err := n.redial(ctx, id); err != nil {
+				n.logger.WithError(err).WithField("peer_id", id.String()).Debug("Failed to dial cached contact")
+			}
+		}(id)
+	}
+}
+
+// savePeerCache stores the peer cache
+func (n *PeerChatNode) savePeerCache() {
+	if err := n.peerCache.save(); err != nil {
+		n.logger.WithError(err).Warn("Failed to save peer cache")
+	}
+}
+
+// runPeerCache records peers as their connections close and saves the cache
+// regularly, so a crash loses little
+func (n *PeerChatNode) runPeerCache() {
+	n.host.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(_ network.Network, conn network.Conn) {
+			n.peerCache.remember(conn)
+		},
+	})
+
+	ticker := time.NewTicker(peerCacheSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			n.savePeerCache()
+		}
+	}
+}
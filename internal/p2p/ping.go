@@ -0,0 +1,218 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// PingProtocolID echoes every probe written to it, to measure the
+	// round trip time to a peer
+	PingProtocolID = protocol.ID("/xelvra/ping/1.0.0")
+
+	// PingTimeout bounds waiting for the echo of one probe
+	PingTimeout = 5 * time.Second
+
+	// DefaultPingCount and DefaultPingInterval are how many probes a ping
+	// sends and how far apart
+	DefaultPingCount    = 4
+	DefaultPingInterval = time.Second
+
+	// pingProbeSize is the size of a probe
+	pingProbeSize = 32
+
+	// pingIdleTimeout closes a ping stream that stopped sending probes
+	pingIdleTimeout = time.Minute
+
+	// MaxPingCount bounds the probes of one ping
+	MaxPingCount = 1000
+)
+
+// PingReply is the outcome of one probe; Err is set for a probe that was
+// not echoed in time
+type PingReply struct {
+	Seq int           `json:"seq"`
+	RTT time.Duration `json:"rtt_ns,omitempty"`
+	Err error         `json:"-"`
+}
+
+// PingStats sums up a ping
+type PingStats struct {
+	PeerID   string        `json:"peer_id"`
+	Addr     string        `json:"addr"`            // Remote address of the connection pinged over
+	Relay    string        `json:"relay,omitempty"` // Relay the connection goes through, if any
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Min      time.Duration `json:"min_ns"`
+	Avg      time.Duration `json:"avg_ns"`
+	Max      time.Duration `json:"max_ns"`
+}
+
+// Relayed reports whether the peer was reached through a circuit relay
+func (s *PingStats) Relayed() bool {
+	return s.Relay != ""
+}
+
+// Loss returns the share of probes that were not echoed, from 0 to 1
+func (s *PingStats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Received) / float64(s.Sent)
+}
+
+// MeetsLatencyTarget reports whether the average round trip is within
+// MaxLatencyMs, which applies to direct connections
+func (s *PingStats) MeetsLatencyTarget() bool {
+	return s.Received > 0 && s.Avg <= MaxLatencyMs*time.Millisecond
+}
+
+// add counts the outcome of a probe
+func (s *PingStats) add(reply PingReply) {
+	s.Sent++
+	if reply.Err != nil {
+		return
+	}
+	if s.Received == 0 || reply.RTT < s.Min {
+		s.Min = reply.RTT
+	}
+	if reply.RTT > s.Max {
+		s.Max = reply.RTT
+	}
+	s.Avg = (s.Avg*time.Duration(s.Received) + reply.RTT) / time.Duration(s.Received+1)
+	s.Received++
+}
+
+// ServePing echoes the probes of peers measuring their round trip time,
+// over relayed connections too
+func ServePing(h host.Host) {
+	h.SetStreamHandler(PingProtocolID, func(s network.Stream) {
+		defer func() { _ = s.Close() }()
+		probe := make([]byte, pingProbeSize)
+		for {
+			_ = s.SetDeadline(time.Now().Add(pingIdleTimeout))
+			if _, err := io.ReadFull(s, probe); err != nil {
+				return
+			}
+			if _, err := s.Write(probe); err != nil {
+				_ = s.Reset()
+				return
+			}
+		}
+	})
+}
+
+// Ping sends count probes to the connected peer p, interval apart, over
+// one stream, and calls onReply, if set, with the outcome of each. It stops
+// early when ctx is done. A stream the peer refuses fails the ping; probes
+// that time out are counted as lost, and the stream is opened again for the
+// next one.
+func Ping(ctx context.Context, h host.Host, p peer.ID, count int, interval time.Duration, onReply func(PingReply)) (*PingStats, error) {
+	if count <= 0 || count > MaxPingCount {
+		return nil, fmt.Errorf("count must be between 1 and %d", MaxPingCount)
+	}
+	stats := &PingStats{PeerID: p.String()}
+	var s network.Stream
+	defer func() {
+		if s != nil {
+			_ = s.Close()
+		}
+	}()
+
+	for seq := 1; seq <= count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+				return stats, nil
+			case <-time.After(interval):
+			}
+		}
+		if s == nil {
+			var err error
+			if s, err = openPingStream(ctx, h, p); err != nil {
+				if stats.Sent == 0 {
+					return nil, err
+				}
+				reply := PingReply{Seq: seq, Err: err}
+				stats.add(reply)
+				if onReply != nil {
+					onReply(reply)
+				}
+				continue
+			}
+			stats.Addr = s.Conn().RemoteMultiaddr().String()
+			stats.Relay = relayOf(s.Conn().RemoteMultiaddr())
+		}
+
+		reply := PingReply{Seq: seq}
+		reply.RTT, reply.Err = pingOnce(s)
+		if reply.Err != nil {
+			_ = s.Reset()
+			s = nil
+		}
+		stats.add(reply)
+		if onReply != nil {
+			onReply(reply)
+		}
+	}
+	return stats, nil
+}
+
+// openPingStream opens a ping stream to p, allowing relayed connections so
+// their path can be measured too
+func openPingStream(ctx context.Context, h host.Host, p peer.ID) (network.Stream, error) {
+	ctx, cancel := context.WithTimeout(ctx, PingTimeout)
+	defer cancel()
+	ctx = network.WithAllowLimitedConn(network.WithNoDial(ctx, "ping"), "ping")
+	s, err := h.NewStream(ctx, p, PingProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("peer does not answer pings: %w", err)
+	}
+	return s, nil
+}
+
+// pingOnce writes a random probe and times its echo
+func pingOnce(s network.Stream) (time.Duration, error) {
+	probe := make([]byte, pingProbeSize)
+	if _, err := rand.Read(probe); err != nil {
+		return 0, err
+	}
+	echo := make([]byte, pingProbeSize)
+
+	_ = s.SetDeadline(time.Now().Add(PingTimeout))
+	start := time.Now()
+	if _, err := s.Write(probe); err != nil {
+		return 0, fmt.Errorf("failed to send probe: %w", err)
+	}
+	if _, err := io.ReadFull(s, echo); err != nil {
+		return 0, fmt.Errorf("no echo within %s: %w", PingTimeout, err)
+	}
+	rtt := time.Since(start)
+	if !bytes.Equal(probe, echo) {
+		return 0, fmt.Errorf("echo does not match the probe")
+	}
+	return rtt, nil
+}
+
+// relayOf returns the relay a /p2p-circuit address goes through, "" for a
+// direct address
+func relayOf(addr ma.Multiaddr) string {
+	if !isRelayAddr(addr) {
+		return ""
+	}
+	relay, err := addr.ValueForProtocol(ma.P_P2P)
+	if err != nil {
+		return "unknown relay"
+	}
+	return relay
+}
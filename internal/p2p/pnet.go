@@ -0,0 +1,98 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+const (
+	// SwarmKeyFileName is the pre-shared key of a private network in the
+	// data directory, used when no other key is configured
+	SwarmKeyFileName = "swarm.key"
+
+	// SwarmKeyEnv names a swarm key file, or holds the key itself, and takes
+	// precedence over the configuration file
+	SwarmKeyEnv = "XELVRA_SWARM_KEY"
+
+	// swarmKeyHeader starts a swarm key in the format IPFS uses
+	swarmKeyHeader = "/key/swarm/psk/1.0.0/"
+)
+
+// GenerateSwarmKey returns a new random swarm key, encoded for a swarm key
+// file
+func GenerateSwarmKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate swarm key: %w", err)
+	}
+	return []byte(swarmKeyHeader + "\n/base16/\n" + hex.EncodeToString(key) + "\n"), nil
+}
+
+// ParseSwarmKey decodes an encoded swarm key
+func ParseSwarmKey(data []byte) (pnet.PSK, error) {
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid swarm key: %w", err)
+	}
+	return psk, nil
+}
+
+// LoadSwarmKey reads the swarm key file at path
+func LoadSwarmKey(path string) (pnet.PSK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	psk, err := ParseSwarmKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return psk, nil
+}
+
+// SaveSwarmKey writes an encoded swarm key to path, readable by the owner
+// only
+func SaveSwarmKey(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ResolveSwarmKey finds the swarm key of the private network to join:
+// SwarmKeyEnv, then configured (a path from the configuration file), then
+// SwarmKeyFileName in dataDir. It returns nil to join the public network.
+func ResolveSwarmKey(configured, dataDir string) (pnet.PSK, error) {
+	if value := strings.TrimSpace(os.Getenv(SwarmKeyEnv)); value != "" {
+		if strings.HasPrefix(value, swarmKeyHeader) {
+			return ParseSwarmKey([]byte(value + "\n"))
+		}
+		return LoadSwarmKey(value)
+	}
+	if configured != "" {
+		return LoadSwarmKey(configured)
+	}
+	if dataDir == "" {
+		return nil, nil
+	}
+	psk, err := LoadSwarmKey(filepath.Join(dataDir, SwarmKeyFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return psk, err
+}
+
+// SwarmKeyFingerprint identifies a swarm key without revealing it, so the
+// members of a private network can check they hold the same one
+func SwarmKeyFingerprint(psk pnet.PSK) string {
+	sum := sha256.Sum256(append([]byte("xelvra-swarm-key|"), psk...))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,277 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyConfig is the outbound proxy configuration taken from the usual
+// environment variables. Only TCP can be proxied; QUIC, mDNS and STUN use
+// UDP and always connect directly.
+type ProxyConfig struct {
+	AllProxy   string // ALL_PROXY: socks5://, socks5h:// or http:// proxy for any connection
+	HTTPSProxy string // HTTPS_PROXY: http:// proxy used with CONNECT
+	HTTPProxy  string // HTTP_PROXY: used when neither of the above is set
+	NoProxy    string // NO_PROXY: hosts and domains to reach directly
+
+	// Strict sends LAN and NO_PROXY addresses through the proxy too, so no
+	// connection but loopback reveals the local address. It is set for SOCKS
+	// proxies such as Tor.
+	Strict bool
+}
+
+// ProxyFromEnvironment reads the proxy configuration from the environment.
+// Upper-case variables take precedence over lower-case ones.
+func ProxyFromEnvironment() ProxyConfig {
+	config := ProxyConfig{
+		AllProxy:   getEnvAny("ALL_PROXY", "all_proxy"),
+		HTTPSProxy: getEnvAny("HTTPS_PROXY", "https_proxy"),
+		HTTPProxy:  getEnvAny("HTTP_PROXY", "http_proxy"),
+		NoProxy:    getEnvAny("NO_PROXY", "no_proxy"),
+	}
+	config.Strict = config.IsSOCKS()
+	return config
+}
+
+// getEnvAny returns the first non-empty environment variable of names
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// IsZero reports whether no proxy is configured
+func (c ProxyConfig) IsZero() bool {
+	return c.proxy() == ""
+}
+
+// IsSOCKS reports whether connections go through a SOCKS proxy, as when
+// running over Tor
+func (c ProxyConfig) IsSOCKS() bool {
+	proxyURL, err := url.Parse(c.proxy())
+	return err == nil && (proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h")
+}
+
+// proxy returns the proxy TCP connections go through: ALL_PROXY, which is
+// meant for any protocol, then HTTPS_PROXY and HTTP_PROXY
+func (c ProxyConfig) proxy() string {
+	for _, p := range []string{c.AllProxy, c.HTTPSProxy, c.HTTPProxy} {
+		if p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+// ProxyFor returns the proxy to reach addr (host:port) through, or nil to
+// connect directly. Loopback addresses are reached directly, and unless the
+// configuration is strict so are private and link-local addresses and hosts
+// listed in NO_PROXY.
+func (c ProxyConfig) ProxyFor(addr string) (*url.URL, error) {
+	raw := c.proxy()
+	if raw == "" {
+		return nil, nil
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := httpproxy.Config{HTTPSProxy: raw}
+	if !c.Strict {
+		if ip := net.ParseIP(host); ip != nil && (ip.IsPrivate() || ip.IsLinkLocalUnicast()) {
+			return nil, nil
+		}
+		config.NoProxy = c.NoProxy
+	}
+
+	// httpproxy applies NO_PROXY and skips loopback addresses
+	proxyURL, err := config.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+	}
+	return proxyURL, nil
+}
+
+// ProxyDialer dials TCP connections through the configured proxy
+type ProxyDialer struct {
+	Config ProxyConfig
+	direct net.Dialer
+}
+
+// NewProxyDialer creates a dialer for the given proxy configuration
+func NewProxyDialer(config ProxyConfig) *ProxyDialer {
+	return &ProxyDialer{Config: config, direct: net.Dialer{KeepAlive: 15 * time.Second}}
+}
+
+// DialContext connects to addr, through the proxy unless it is reached
+// directly
+func (d *ProxyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return d.direct.DialContext(ctx, network, addr)
+	}
+
+	proxyURL, err := d.Config.ProxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return d.direct.DialContext(ctx, network, addr)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, &d.direct)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		return conn, nil
+	case "http":
+		return d.dialConnect(ctx, proxyURL, addr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use socks5, socks5h or http)", proxyURL.Scheme)
+	}
+}
+
+// dialConnect opens a tunnel to addr through an HTTP proxy
+func (d *ProxyDialer) dialConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+	}
+	conn, err := d.direct.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+
+	// The handshake must not outlive the dial
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy %s refused the connection to %s: %s", proxyURL.Redacted(), addr, resp.Status)
+	}
+
+	if !stop() || ctx.Err() != nil {
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// The peer may already have sent data the reader buffered
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection with data already read into a buffer
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads buffered data first
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// proxyTransportOptions returns the TCP transport options that send
+// outgoing connections through the proxy
+func proxyTransportOptions(config ProxyConfig, logger *logrus.Logger) []interface{} {
+	if config.IsZero() {
+		return nil
+	}
+
+	if proxyURL, err := url.Parse(config.proxy()); err == nil {
+		logger.WithField("proxy", proxyURL.Redacted()).Info("Outgoing TCP connections use the proxy from the environment")
+	}
+	dialer := NewProxyDialer(config)
+	return []interface{}{tcp.WithDialerForAddr(func(ma.Multiaddr) (tcp.ContextDialer, error) {
+		return dialer, nil
+	})}
+}
+
+// tcpTransport returns the libp2p TCP transport option, proxied when a
+// proxy is configured
+func tcpTransport(config ProxyConfig, logger *logrus.Logger) libp2p.Option {
+	return libp2p.Transport(tcp.NewTCPTransport, proxyTransportOptions(config, logger)...)
+}
+
+// proxyOnlyListenAddrs is where a node that must not reveal its address
+// listens: loopback, over TCP only
+var proxyOnlyListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+
+// CheckProxy connects to target (host:port) the way the node would,
+// through the proxy if one applies
+func CheckProxy(ctx context.Context, config ProxyConfig, target string) error {
+	conn, err := NewProxyDialer(config).DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// BootstrapTCPTarget returns the host:port of a bootstrap peer reachable
+// over plain TCP, to check connectivity through a proxy
+func BootstrapTCPTarget() string {
+	for _, info := range getBootstrapPeers() {
+		for _, addr := range info.Addrs {
+			network, hostPort, err := manet.DialArgs(addr)
+			if err == nil && network == "tcp4" {
+				return hostPort
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,287 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// bestPathHeadStart is how long the address with the best record gets
+	// before the other addresses of a peer are dialed
+	bestPathHeadStart = 300 * time.Millisecond
+
+	// rttSmoothing weighs a new round-trip sample against the average
+	rttSmoothing = 0.3
+
+	// referenceRTT is the round trip at which latency halves the score
+	referenceRTT = 200 * time.Millisecond
+
+	// relayPenalty is how much of the score a peer reached only through
+	// relays loses
+	relayPenalty = 0.3
+)
+
+// PathQuality is what is known about reaching a peer at one address
+type PathQuality struct {
+	Addr     string        `json:"addr"`
+	Dials    int           `json:"dials"`
+	Failures int           `json:"failures"`
+	RTT      time.Duration `json:"rtt,omitempty"`
+	LastUsed time.Time     `json:"last_used,omitempty"`
+}
+
+// Score rates the path from 0 to 100 by how often dialing it worked and
+// how long it took
+func (p PathQuality) Score() float64 {
+	return qualityScore(p.Dials, p.Failures, p.RTT, 0)
+}
+
+// PeerQuality rates the connections to a peer. RTT is a moving average of
+// the time connections took to establish, a few round trips.
+type PeerQuality struct {
+	PeerID   string        `json:"peer_id"`
+	Dials    int           `json:"dials"`
+	Failures int           `json:"failures"`
+	RTT      time.Duration `json:"rtt,omitempty"`
+	Direct   int           `json:"direct"`  // Connections made without a relay
+	Relayed  int           `json:"relayed"` // Connections through a circuit relay
+	Score    float64       `json:"score"`
+	Paths    []PathQuality `json:"paths,omitempty"` // Best first
+}
+
+// FailureRate returns the share of dials that failed
+func (q PeerQuality) FailureRate() float64 {
+	if q.Dials == 0 {
+		return 0
+	}
+	return float64(q.Failures) / float64(q.Dials)
+}
+
+// qualityScore rates a record of dials from 0 to 100: reliability, counted
+// so that an unknown peer starts in the middle, scaled down by latency and
+// by the share of relayed connections
+func qualityScore(dials, failures int, rtt time.Duration, relayShare float64) float64 {
+	reliability := float64(dials-failures+1) / float64(dials+2)
+	latency := 0.75 // Unknown round trip
+	if rtt > 0 {
+		latency = 1 / (1 + float64(rtt)/float64(referenceRTT))
+	}
+	return math.Round(100*reliability*latency*(1-relayPenalty*relayShare)*10) / 10
+}
+
+// peerRecord holds the counts behind a PeerQuality
+type peerRecord struct {
+	dials, failures int
+	rtt             time.Duration
+	direct, relayed int
+	paths           map[string]*PathQuality
+}
+
+// QualityBook tracks round trips, failed dials and relay use per peer and
+// per address
+type QualityBook struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*peerRecord
+	addrs map[string]*PathQuality // The same records as in peers, by address
+}
+
+// NewQualityBook creates an empty quality book
+func NewQualityBook() *QualityBook {
+	return &QualityBook{
+		peers: make(map[peer.ID]*peerRecord),
+		addrs: make(map[string]*PathQuality),
+	}
+}
+
+// record returns the record of a peer, creating it; mu must be held
+func (b *QualityBook) record(id peer.ID) *peerRecord {
+	r := b.peers[id]
+	if r == nil {
+		r = &peerRecord{paths: make(map[string]*PathQuality)}
+		b.peers[id] = r
+	}
+	return r
+}
+
+// path returns the record of an address of a peer, creating it; mu must be
+// held
+func (b *QualityBook) path(r *peerRecord, addr ma.Multiaddr) *PathQuality {
+	key := addr.String()
+	p := r.paths[key]
+	if p == nil {
+		p = &PathQuality{Addr: key}
+		r.paths[key] = p
+		b.addrs[key] = p
+	}
+	return p
+}
+
+// RecordDial records a successful dial of a peer at addr that took rtt
+func (b *QualityBook) RecordDial(id peer.ID, addr ma.Multiaddr, rtt time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.record(id)
+	r.dials++
+	r.rtt = smoothRTT(r.rtt, rtt)
+	p := b.path(r, addr)
+	p.Dials++
+	p.RTT = smoothRTT(p.RTT, rtt)
+	p.LastUsed = time.Now()
+}
+
+// RecordFailure records a failed dial of a peer at addr
+func (b *QualityBook) RecordFailure(id peer.ID, addr ma.Multiaddr) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.record(id)
+	r.dials++
+	r.failures++
+	p := b.path(r, addr)
+	p.Dials++
+	p.Failures++
+}
+
+// RecordConnection counts a connection to a peer as direct or relayed
+func (b *QualityBook) RecordConnection(id peer.ID, addr ma.Multiaddr) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.record(id)
+	if isRelayAddr(addr) {
+		r.relayed++
+	} else {
+		r.direct++
+	}
+}
+
+// smoothRTT folds a sample into a moving average
+func smoothRTT(avg, sample time.Duration) time.Duration {
+	if avg == 0 {
+		return sample
+	}
+	return time.Duration((1-rttSmoothing)*float64(avg) + rttSmoothing*float64(sample))
+}
+
+// Peer returns the quality of the connections to a peer
+func (b *QualityBook) Peer(id peer.ID) PeerQuality {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := PeerQuality{PeerID: id.String()}
+	r := b.peers[id]
+	if r == nil {
+		q.Score = qualityScore(0, 0, 0, 0)
+		return q
+	}
+
+	q.Dials, q.Failures, q.RTT = r.dials, r.failures, r.rtt
+	q.Direct, q.Relayed = r.direct, r.relayed
+	relayShare := 0.0
+	if r.direct+r.relayed > 0 {
+		relayShare = float64(r.relayed) / float64(r.direct+r.relayed)
+	}
+	q.Score = qualityScore(r.dials, r.failures, r.rtt, relayShare)
+	for _, p := range r.paths {
+		q.Paths = append(q.Paths, *p)
+	}
+	sort.Slice(q.Paths, func(i, j int) bool {
+		return q.Paths[i].Score() > q.Paths[j].Score()
+	})
+	return q
+}
+
+// bestAddr returns the address among addrs with the best record, if it
+// worked at least once and is rated above every other one
+func (b *QualityBook) bestAddr(addrs []ma.Multiaddr) (ma.Multiaddr, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best ma.Multiaddr
+	bestScore := -1.0
+	scores := make([]float64, len(addrs))
+	for i, addr := range addrs {
+		scores[i] = qualityScore(0, 0, 0, 0)
+		p := b.addrs[addr.String()]
+		if p == nil {
+			continue
+		}
+		scores[i] = p.Score()
+		if p.Dials > p.Failures && scores[i] > bestScore {
+			best, bestScore = addr, scores[i]
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	for i, addr := range addrs {
+		if !addr.Equal(best) && scores[i] >= bestScore {
+			return nil, false
+		}
+	}
+	return best, true
+}
+
+// DialRanker ranks addresses as QUICFirstDialRanker does, then dials the
+// address that worked best before first, giving it bestPathHeadStart
+// before the others
+func (b *QualityBook) DialRanker(addrs []ma.Multiaddr) []network.AddrDelay {
+	ranked := QUICFirstDialRanker(addrs)
+	best, ok := b.bestAddr(addrs)
+	if !ok {
+		return ranked
+	}
+
+	for i, a := range ranked {
+		if a.Addr.Equal(best) {
+			ranked[i].Delay = 0
+		} else {
+			ranked[i].Delay += bestPathHeadStart
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Delay < ranked[j].Delay
+	})
+	return ranked
+}
+
+// Connect dials a peer, recording in the quality book which addresses
+// failed and how long the connection took
+func (n *PeerChatNode) Connect(ctx context.Context, info peer.AddrInfo) error {
+	start := time.Now()
+	err := n.host.Connect(ctx, info)
+
+	var dialErr *swarm.DialError
+	if errors.As(err, &dialErr) {
+		for _, failed := range dialErr.DialErrors {
+			n.quality.RecordFailure(info.ID, failed.Address)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// A connection that existed already says nothing about the dial
+	for _, conn := range n.host.Network().ConnsToPeer(info.ID) {
+		if conn.Stat().Opened.After(start) {
+			n.quality.RecordDial(info.ID, conn.RemoteMultiaddr(), conn.Stat().Opened.Sub(start))
+			break
+		}
+	}
+	return nil
+}
+
+// PeerQuality returns the quality of the connections to a peer
+func (n *PeerChatNode) PeerQuality(id peer.ID) PeerQuality {
+	return n.quality.Peer(id)
+}
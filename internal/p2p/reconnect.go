@@ -0,0 +1,177 @@
+package p2p
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ReconnectInitialDelay and ReconnectMaxDelay bound the wait between
+	// attempts to reconnect to a contact; the wait doubles after each
+	// failure
+	ReconnectInitialDelay = 2 * time.Second
+	ReconnectMaxDelay     = 5 * time.Minute
+
+	// ReconnectGiveUp is how long a dropped contact is redialed before the
+	// node waits for discovery or the user to find it again
+	ReconnectGiveUp = time.Hour
+
+	// reconnectJitter spreads attempts by up to this share of the delay, so
+	// both sides of a dropped connection do not redial in lockstep
+	reconnectJitter = 0.2
+
+	// reconnectDialTimeout bounds one attempt
+	reconnectDialTimeout = 15 * time.Second
+)
+
+// ReconnectDelay returns how long to wait before reconnect attempt number
+// attempt (from 0): initial doubled per attempt up to maxDelay, give or take
+// reconnectJitter
+func ReconnectDelay(attempt int, initial, maxDelay time.Duration) time.Duration {
+	delay := initial
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	jitter := (rand.Float64()*2 - 1) * reconnectJitter * float64(delay)
+	return delay + time.Duration(jitter)
+}
+
+// Reconnector redials peers whose connections dropped until they are
+// connected again or it gives up
+type Reconnector struct {
+	// Dial connects to a peer; Connected reports whether a peer is
+	// connected, by any means
+	Dial      func(ctx context.Context, id peer.ID) error
+	Connected func(id peer.ID) bool
+
+	// InitialDelay, MaxDelay and GiveUp default to ReconnectInitialDelay,
+	// ReconnectMaxDelay and ReconnectGiveUp
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	GiveUp       time.Duration
+
+	// OnReconnected, if set, is called after a dropped peer is connected
+	// again by the reconnector, with the number of attempts it took
+	OnReconnected func(id peer.ID, attempts int)
+
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	pending map[peer.ID]bool
+}
+
+// NewReconnector creates a reconnector dialing peers with dial
+func NewReconnector(dial func(ctx context.Context, id peer.ID) error, connected func(id peer.ID) bool, logger *logrus.Logger) *Reconnector {
+	return &Reconnector{
+		Dial:         dial,
+		Connected:    connected,
+		InitialDelay: ReconnectInitialDelay,
+		MaxDelay:     ReconnectMaxDelay,
+		GiveUp:       ReconnectGiveUp,
+		logger:       logger,
+		pending:      make(map[peer.ID]bool),
+	}
+}
+
+// PeerDropped starts redialing a peer in the background until ctx ends,
+// unless it is being redialed already
+func (r *Reconnector) PeerDropped(ctx context.Context, id peer.ID) {
+	if ctx.Err() != nil {
+		return
+	}
+	r.mu.Lock()
+	if r.pending[id] {
+		r.mu.Unlock()
+		return
+	}
+	r.pending[id] = true
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.pending, id)
+			r.mu.Unlock()
+		}()
+		r.reconnect(ctx, id)
+	}()
+}
+
+// Pending returns how many peers are being redialed
+func (r *Reconnector) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+// reconnect redials a peer with backoff
+func (r *Reconnector) reconnect(ctx context.Context, id peer.ID) {
+	logger := r.logger.WithField("peer_id", id.String())
+	deadline := time.Now().Add(r.GiveUp)
+
+	for attempt := 0; time.Now().Before(deadline); attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ReconnectDelay(attempt, r.InitialDelay, r.MaxDelay)):
+		}
+
+		// The peer may have dialed us, or discovery found it, meanwhile
+		if r.Connected(id) {
+			logger.Debug("Dropped peer connected again")
+			return
+		}
+
+		dialCtx, cancel := context.WithTimeout(ctx, reconnectDialTimeout)
+		err := r.Dial(dialCtx, id)
+		cancel()
+		if err == nil {
+			logger.WithField("attempts", attempt+1).Info("Reconnected to dropped peer")
+			if r.OnReconnected != nil {
+				r.OnReconnected(id, attempt+1)
+			}
+			return
+		}
+		logger.WithError(err).WithField("attempt", attempt+1).Debug("Failed to reconnect to dropped peer")
+	}
+	logger.Info("Gave up reconnecting to dropped peer")
+}
+
+// watchDisconnects redials contacts whose last connection closed
+func (n *PeerChatNode) watchDisconnects() {
+	n.host.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(net network.Network, conn network.Conn) {
+			id := conn.RemotePeer()
+			if net.Connectedness(id) == network.Connected || !n.isContact(id) {
+				return
+			}
+			n.logger.WithField("peer_id", id.String()).Info("Connection to contact dropped, reconnecting")
+			n.reconnector.PeerDropped(n.ctx, id)
+		},
+	})
+}
+
+// isContact reports whether a peer is in the contact book
+func (n *PeerChatNode) isContact(id peer.ID) bool {
+	for _, contact := range n.contactPeerIDs() {
+		if contact == id {
+			return true
+		}
+	}
+	return false
+}
+
+// redial connects to a dropped peer at the addresses known for it
+func (n *PeerChatNode) redial(ctx context.Context, id peer.ID) error {
+	addrs := slices.Concat(n.discoveryManager.GetPeerAddresses(id), n.host.Peerstore().Addrs(id))
+	return n.Connect(ctx, peer.AddrInfo{ID: id, Addrs: ma.Unique(addrs)})
+}
@@ -0,0 +1,173 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// relayCount is how many relays the node keeps a reservation with while
+	// it cannot be reached directly
+	relayCount = 2
+
+	// relayCandidateInterval is the least time between two searches for
+	// relay candidates
+	relayCandidateInterval = time.Minute
+)
+
+// relayFinder offers relay candidates to autorelay, the configured relays
+// first. The host is set once created, since autorelay is configured
+// before.
+type relayFinder struct {
+	mu        sync.Mutex
+	host      host.Host
+	static    []peer.AddrInfo
+	bootstrap []peer.AddrInfo
+}
+
+// setHost sets the host whose peers are offered as candidates
+func (f *relayFinder) setHost(h host.Host) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.host = h
+}
+
+// candidates implements autorelay.PeerSource
+func (f *relayFinder) candidates(ctx context.Context, num int) <-chan peer.AddrInfo {
+	f.mu.Lock()
+	h := f.host
+	f.mu.Unlock()
+
+	found := f.static
+	if len(found) > num {
+		found = found[:num]
+	}
+	if h != nil && len(found) < num {
+		for _, info := range RelayCandidates(h, f.bootstrap, num) {
+			if len(found) < num && !containsPeer(found, info.ID) {
+				found = append(found, info)
+			}
+		}
+	}
+	ch := make(chan peer.AddrInfo, len(found))
+	for _, info := range found {
+		ch <- info
+	}
+	close(ch)
+	return ch
+}
+
+// relayOptions reserves slots on public circuit relay v2 servers whenever
+// the node is not reachable directly, and advertises the relay addresses.
+// With a symmetric NAT remembered on the network the node knows it is not
+// reachable and reserves right away.
+func relayOptions(finder *relayFinder, profile *NetworkProfile) []libp2p.Option {
+	opts := []libp2p.Option{
+		libp2p.EnableAutoRelayWithPeerSource(finder.candidates,
+			autorelay.WithNumRelays(relayCount),
+			autorelay.WithMinInterval(relayCandidateInterval),
+			autorelay.WithBootDelay(10*time.Second)),
+	}
+	if profile != nil {
+		if nat := profile.KnownNAT(); nat != nil && nat.Type == "symmetric" {
+			opts = append(opts, libp2p.ForceReachabilityPrivate())
+		}
+	}
+	return opts
+}
+
+// ParseRelayAddrs parses the addresses of circuit relays, each ending in
+// /p2p/<peer ID>
+func ParseRelayAddrs(addrs []string) ([]peer.AddrInfo, error) {
+	parsed := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		m, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relay address %q: %w", addr, err)
+		}
+		if _, err := m.ValueForProtocol(ma.P_P2P); err != nil {
+			return nil, fmt.Errorf("relay address %q does not end in /p2p/<peer ID>", addr)
+		}
+		parsed = append(parsed, m)
+	}
+	return peer.AddrInfosFromP2pAddrs(parsed...)
+}
+
+// RelayCandidates returns up to num peers that may relay for h: connected
+// peers offering circuit relay v2 first, then other known peers offering it,
+// then bootstrap peers, which autorelay checks on connecting
+func RelayCandidates(h host.Host, bootstrap []peer.AddrInfo, num int) []peer.AddrInfo {
+	var connected, known []peer.AddrInfo
+	ps := h.Peerstore()
+	for _, p := range ps.PeersWithAddrs() {
+		if p == h.ID() {
+			continue
+		}
+		if supported, err := ps.SupportsProtocols(p, proto.ProtoIDv2Hop); err != nil || len(supported) == 0 {
+			continue
+		}
+		info := ps.PeerInfo(p)
+		if h.Network().Connectedness(p) == network.Connected {
+			connected = append(connected, info)
+		} else {
+			known = append(known, info)
+		}
+	}
+
+	seen := make(map[peer.ID]bool)
+	var candidates []peer.AddrInfo
+	for _, info := range append(append(connected, known...), bootstrap...) {
+		if len(candidates) >= num {
+			break
+		}
+		if info.ID == h.ID() || seen[info.ID] {
+			continue
+		}
+		seen[info.ID] = true
+		candidates = append(candidates, info)
+	}
+	return candidates
+}
+
+// RelayAddrs returns the circuit addresses h is reachable at through the
+// relays it holds a reservation with
+func RelayAddrs(h host.Host) []ma.Multiaddr {
+	var addrs []ma.Multiaddr
+	for _, addr := range h.Addrs() {
+		if isRelayAddr(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// containsPeer reports whether peers holds id
+func containsPeer(peers []peer.AddrInfo, id peer.ID) bool {
+	for _, info := range peers {
+		if info.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyRelayed reports whether every connection to p goes through a relay
+func onlyRelayed(h host.Host, p peer.ID) bool {
+	conns := h.Network().ConnsToPeer(p)
+	for _, conn := range conns {
+		if !isRelayAddr(conn.RemoteMultiaddr()) {
+			return false
+		}
+	}
+	return len(conns) > 0
+}
@@ -0,0 +1,122 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// MaxRendezvousKeys caps the rendezvous keys a node announces under
+	MaxRendezvousKeys = 16
+
+	// maxRendezvousKeyLength bounds one rendezvous key
+	maxRendezvousKeyLength = 256
+
+	// rendezvousFindTimeout bounds one search of a rendezvous key
+	rendezvousFindTimeout = 60 * time.Second
+)
+
+// RendezvousNamespace is the DHT namespace peers that agreed on key, such as
+// "xelvra/v1" or the ID of a group, announce and look each other up under.
+// It is hashed so the DHT nodes storing the records do not learn the key.
+func RendezvousNamespace(key string) string {
+	return hashedNamespace("rendezvous", key)
+}
+
+// ValidateRendezvousKey rejects empty and overlong keys and keys with
+// whitespace, which are easy to mistype when shared
+func ValidateRendezvousKey(key string) error {
+	switch {
+	case key == "":
+		return fmt.Errorf("rendezvous key is empty")
+	case len(key) > maxRendezvousKeyLength:
+		return fmt.Errorf("rendezvous key is longer than %d bytes", maxRendezvousKeyLength)
+	case strings.ContainsFunc(key, func(r rune) bool { return r <= ' ' }):
+		return fmt.Errorf("rendezvous key %q contains whitespace", key)
+	}
+	return nil
+}
+
+// JoinRendezvous announces the node under key, unless it is invisible, and
+// looks for peers under it in every DHT discovery round
+func (dm *DiscoveryManager) JoinRendezvous(key string) error {
+	if err := ValidateRendezvousKey(key); err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	if slices.Contains(dm.rendezvous, key) {
+		dm.mu.Unlock()
+		return nil
+	}
+	if len(dm.rendezvous) >= MaxRendezvousKeys {
+		dm.mu.Unlock()
+		return fmt.Errorf("already at %d rendezvous keys, leave one first", MaxRendezvousKeys)
+	}
+	dm.rendezvous = append(dm.rendezvous, key)
+	started := dm.started
+	dm.mu.Unlock()
+
+	if started {
+		dm.wakeAnnouncer()
+	}
+	return nil
+}
+
+// LeaveRendezvous stops announcing under key and looking for peers under
+// it; records already in the DHT expire on their own. It reports whether
+// the node had joined key.
+func (dm *DiscoveryManager) LeaveRendezvous(key string) bool {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	i := slices.Index(dm.rendezvous, key)
+	if i < 0 {
+		return false
+	}
+	dm.rendezvous = slices.Delete(dm.rendezvous, i, i+1)
+	return true
+}
+
+// Rendezvous returns the rendezvous keys the node has joined
+func (dm *DiscoveryManager) Rendezvous() []string {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return slices.Clone(dm.rendezvous)
+}
+
+// rendezvousNamespaces returns the namespaces of the joined rendezvous keys
+func (dm *DiscoveryManager) rendezvousNamespaces() []string {
+	keys := dm.Rendezvous()
+	namespaces := make([]string, len(keys))
+	for i, key := range keys {
+		namespaces[i] = RendezvousNamespace(key)
+	}
+	return namespaces
+}
+
+// FindRendezvous looks up the peers announced under key once, connecting to
+// those found, whether or not the node joined key
+func (dm *DiscoveryManager) FindRendezvous(key string) ([]peer.AddrInfo, error) {
+	if err := ValidateRendezvousKey(key); err != nil {
+		return nil, err
+	}
+	if dm.routingDiscovery == nil {
+		return nil, fmt.Errorf("DHT is not running")
+	}
+	ctx, cancel := context.WithTimeout(dm.ctx, rendezvousFindTimeout)
+	defer cancel()
+	return dm.findPeers(ctx, RendezvousNamespace(key)), nil
+}
+
+// wakeAnnouncer makes the DHT announcer run a round soon
+func (dm *DiscoveryManager) wakeAnnouncer() {
+	select {
+	case dm.reannounce <- struct{}{}:
+	default:
+	}
+}
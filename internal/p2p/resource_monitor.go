@@ -0,0 +1,164 @@
+package p2p
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ResourceSampleInterval is how often the resource monitor samples the node
+const ResourceSampleInterval = 5 * time.Second
+
+// ResourceSample is the node's resource use at one moment, with throughput
+// over the time since the sample before it
+type ResourceSample struct {
+	Time time.Time `json:"time"`
+
+	// RSSBytes is the resident set size of the process; where it cannot be
+	// read, the memory the Go runtime obtained from the system, and
+	// RSSApprox is set
+	RSSBytes   uint64 `json:"rss_bytes"`
+	RSSApprox  bool   `json:"rss_approx,omitempty"`
+	HeapBytes  uint64 `json:"heap_bytes"`
+	Goroutines int    `json:"goroutines"`
+
+	OpenStreams int `json:"open_streams"`
+	// Connections counts open connections by transport: quic, tcp, relay,
+	// webtransport, webrtc, or other
+	Connections map[string]int `json:"connections"`
+
+	// Messages written to and accepted from peers since the node started
+	MessagesSent     uint64 `json:"messages_sent"`
+	MessagesReceived uint64 `json:"messages_received"`
+	BytesIn          int64  `json:"bytes_in"`
+	BytesOut         int64  `json:"bytes_out"`
+
+	// Throughput since the previous sample, zero for the first one
+	Window            time.Duration `json:"window_ns,omitempty"`
+	SentPerSecond     float64       `json:"sent_per_second"`
+	ReceivedPerSecond float64       `json:"received_per_second"`
+	BytesInPerSecond  float64       `json:"bytes_in_per_second"`
+	BytesOutPerSecond float64       `json:"bytes_out_per_second"`
+}
+
+// ResourceMonitor samples the resource use of a node every
+// ResourceSampleInterval and keeps the latest sample
+type ResourceMonitor struct {
+	read func() ResourceSample
+
+	mu     sync.Mutex
+	latest *ResourceSample
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewResourceMonitor creates a monitor taking samples with read, which
+// leaves the throughput fields to the monitor
+func NewResourceMonitor(read func() ResourceSample) *ResourceMonitor {
+	return &ResourceMonitor{read: read}
+}
+
+// Start samples until ctx is done or Stop is called
+func (m *ResourceMonitor) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	done := m.done
+	m.mu.Unlock()
+
+	m.Sample()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(ResourceSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Sample()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends sampling
+func (m *ResourceMonitor) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Sample takes a sample now, computing throughput against the latest one,
+// and keeps it as the latest
+func (m *ResourceMonitor) Sample() ResourceSample {
+	sample := m.read()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if prev := m.latest; prev != nil {
+		if window := sample.Time.Sub(prev.Time); window > 0 {
+			seconds := window.Seconds()
+			sample.Window = window
+			sample.SentPerSecond = float64(sample.MessagesSent-prev.MessagesSent) / seconds
+			sample.ReceivedPerSecond = float64(sample.MessagesReceived-prev.MessagesReceived) / seconds
+			sample.BytesInPerSecond = float64(sample.BytesIn-prev.BytesIn) / seconds
+			sample.BytesOutPerSecond = float64(sample.BytesOut-prev.BytesOut) / seconds
+		}
+	}
+	m.latest = &sample
+	return sample
+}
+
+// Latest returns the latest sample, or false if none was taken yet
+func (m *ResourceMonitor) Latest() (ResourceSample, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latest == nil {
+		return ResourceSample{}, false
+	}
+	return *m.latest, true
+}
+
+// ReadResourceSample returns the node's resource use now, without
+// throughput
+func (n *PeerChatNode) ReadResourceSample() ResourceSample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	sample := ResourceSample{
+		Time:        time.Now(),
+		HeapBytes:   mem.HeapInuse,
+		Goroutines:  runtime.NumGoroutine(),
+		Connections: make(map[string]int),
+	}
+	if rss, ok := processRSS(); ok {
+		sample.RSSBytes = rss
+	} else {
+		sample.RSSBytes, sample.RSSApprox = mem.Sys, true
+	}
+
+	for _, conn := range n.host.Network().Conns() {
+		sample.OpenStreams += len(conn.GetStreams())
+		transport := addrTransport(conn.RemoteMultiaddr())
+		if transport == "" {
+			transport = "other"
+		}
+		sample.Connections[transport]++
+	}
+	if n.messageManager != nil {
+		sample.MessagesSent, sample.MessagesReceived = n.messageManager.MessageCounts()
+	}
+	if n.bandwidth != nil {
+		totals := n.bandwidth.Counter().GetBandwidthTotals()
+		sample.BytesIn, sample.BytesOut = totals.TotalIn, totals.TotalOut
+	}
+	return sample
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package p2p
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processRSS returns the resident set size of this process, from the
+// second field of /proc/self/statm, in pages
+func processRSS() (uint64, bool) {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * uint64(os.Getpagesize()), true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package p2p
+
+// processRSS is not available on this platform; the memory obtained from
+// the system by the Go runtime is shown instead
+func processRSS() (uint64, bool) {
+	return 0, false
+}
@@ -10,6 +10,15 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultSTUNServers are the public STUN servers queried for NAT discovery
+var DefaultSTUNServers = []string{
+	"stun.l.google.com:19302",
+	"stun1.l.google.com:19302",
+	"stun2.l.google.com:19302",
+	"stun.cloudflare.com:3478",
+	"stun.nextcloud.com:443",
+}
+
 // LegacySTUNClient handles STUN server communication for NAT discovery
 type LegacySTUNClient struct {
 	servers []string
@@ -19,14 +28,8 @@ type LegacySTUNClient struct {
 // NewLegacySTUNClient creates a new STUN client
 func NewLegacySTUNClient(logger *logrus.Logger) *LegacySTUNClient {
 	return &LegacySTUNClient{
-		servers: []string{
-			"stun.l.google.com:19302",
-			"stun1.l.google.com:19302",
-			"stun2.l.google.com:19302",
-			"stun.cloudflare.com:3478",
-			"stun.nextcloud.com:443",
-		},
-		logger: logger,
+		servers: DefaultSTUNServers,
+		logger:  logger,
 	}
 }
 
@@ -42,7 +45,7 @@ func (s *LegacySTUNClient) DiscoverNAT(ctx context.Context, localPort int) (*NAT
 	}
 
 	// Get local IP
-	localIP, err := s.getLocalIP()
+	localIP, err := s.getLocalIP("udp4")
 	if err != nil {
 		s.logger.WithError(err).Warn("Failed to get local IP")
 		natInfo.LocalIP = "127.0.0.1"
@@ -50,39 +53,69 @@ func (s *LegacySTUNClient) DiscoverNAT(ctx context.Context, localPort int) (*NAT
 		natInfo.LocalIP = localIP
 	}
 
-	// Try each STUN server
+	// IPv6 usually reaches the host without NAT, whatever IPv4 goes
+	// through; both families are asked at once so one that is blackholed
+	// does not hold up the other
+	var ip6, ip6Type string
+	var ip6Err error
+	ip6Done := make(chan struct{})
+	go func() {
+		defer close(ip6Done)
+		ip6, _, ip6Type, ip6Err = s.discoverFamily(ctx, "udp6", localPort)
+	}()
+	publicIP, publicPort, natType, err := s.discoverFamily(ctx, "udp4", localPort)
+	<-ip6Done
+	if ip6Err == nil {
+		natInfo.PublicIPv6 = ip6
+		natInfo.IPv6Direct = ip6Type == "none"
+	}
+
+	if err == nil {
+		natInfo.PublicIP = publicIP
+		natInfo.PublicPort = publicPort
+		natInfo.Type = natType
+		return natInfo, nil
+	}
+	if ip6Err == nil {
+		// An IPv6-only network
+		natInfo.Type = ip6Type
+		return natInfo, nil
+	}
+
+	s.logger.Warn("All STUN servers failed, assuming symmetric NAT")
+	natInfo.Type = "symmetric"
+	return natInfo, fmt.Errorf("all STUN servers failed")
+}
+
+// discoverFamily asks the STUN servers in turn for the public address over
+// network, "udp4" or "udp6", until one answers
+func (s *LegacySTUNClient) discoverFamily(ctx context.Context, network string, localPort int) (string, int, string, error) {
 	for _, server := range s.servers {
-		s.logger.WithField("server", server).Debug("Trying STUN server")
+		s.logger.WithFields(logrus.Fields{"server": server, "network": network}).Debug("Trying STUN server")
 
-		publicIP, publicPort, natType, err := s.querySTUNServer(ctx, server, localPort)
+		publicIP, publicPort, natType, err := s.querySTUNServer(ctx, network, server, localPort)
 		if err != nil {
-			s.logger.WithError(err).WithField("server", server).Debug("STUN query failed")
+			s.logger.WithError(err).WithFields(logrus.Fields{"server": server, "network": network}).Debug("STUN query failed")
 			continue
 		}
 
-		natInfo.PublicIP = publicIP
-		natInfo.PublicPort = publicPort
-		natInfo.Type = natType
-
 		s.logger.WithFields(logrus.Fields{
 			"public_ip":   publicIP,
 			"public_port": publicPort,
 			"nat_type":    natType,
 			"server":      server,
+			"network":     network,
 		}).Info("NAT discovery successful")
-
-		return natInfo, nil
+		return publicIP, publicPort, natType, nil
 	}
-
-	s.logger.Warn("All STUN servers failed, assuming symmetric NAT")
-	natInfo.Type = "symmetric"
-	return natInfo, fmt.Errorf("all STUN servers failed")
+	return "", 0, "", fmt.Errorf("no STUN server answered over %s", network)
 }
 
-// querySTUNServer queries a single STUN server
-func (s *LegacySTUNClient) querySTUNServer(ctx context.Context, server string, localPort int) (string, int, string, error) {
+// querySTUNServer queries a single STUN server over network, "udp4" or
+// "udp6"
+func (s *LegacySTUNClient) querySTUNServer(ctx context.Context, network, server string, localPort int) (string, int, string, error) {
 	// Create UDP connection
-	conn, err := net.DialTimeout("udp", server, 5*time.Second)
+	conn, err := net.DialTimeout(network, server, 5*time.Second)
 	if err != nil {
 		return "", 0, "", fmt.Errorf("failed to connect to STUN server: %w", err)
 	}
@@ -93,16 +126,14 @@ func (s *LegacySTUNClient) querySTUNServer(ctx context.Context, server string, l
 		}
 	}()
 
-	// Set deadline
-	deadline, ok := ctx.Deadline()
-	if ok {
-		if err := conn.SetDeadline(deadline); err != nil {
-			return "", 0, "", fmt.Errorf("failed to set deadline: %w", err)
-		}
-	} else {
-		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
-			return "", 0, "", fmt.Errorf("failed to set deadline: %w", err)
-		}
+	// Set deadline, so a server or address family that does not answer
+	// leaves time for the others
+	deadline := time.Now().Add(5 * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", 0, "", fmt.Errorf("failed to set deadline: %w", err)
 	}
 
 	// Create STUN binding request
@@ -143,15 +174,20 @@ func (s *LegacySTUNClient) querySTUNServer(ctx context.Context, server string, l
 	publicPort := mappedAddr.Port
 
 	// Determine NAT type (simplified)
-	localIP, _ := s.getLocalIP()
+	localIP, _ := s.getLocalIP(network)
 	natType := s.determineNATType(localIP, localPort, publicIP, publicPort)
 
 	return publicIP, publicPort, natType, nil
 }
 
-// getLocalIP gets the local IP address
-func (s *LegacySTUNClient) getLocalIP() (string, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// getLocalIP gets the local IP address used to reach the Internet over
+// network, "udp4" or "udp6"
+func (s *LegacySTUNClient) getLocalIP(network string) (string, error) {
+	target := "8.8.8.8:80"
+	if network == "udp6" {
+		target = "[2001:4860:4860::8888]:80"
+	}
+	conn, err := net.Dial(network, target)
 	if err != nil {
 		return "", err
 	}
@@ -194,6 +230,8 @@ func (s *LegacySTUNClient) isPrivateIP(ip string) bool {
 		"10.0.0.0/8",
 		"172.16.0.0/12",
 		"192.168.0.0/16",
+		"fc00::/7",  // IPv6 unique local
+		"fe80::/10", // IPv6 link-local
 	}
 
 	for _, cidr := range privateRanges {
@@ -0,0 +1,323 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SubnetProbePrefix marks a probe asking the nodes of a subnet to
+	// answer, and SubnetProbeReplyPrefix their answer, on the beacon port
+	SubnetProbePrefix      = "XELVRA_PROBE:"
+	SubnetProbeReplyPrefix = "XELVRA_PROBE_REPLY:"
+
+	// MaxScanHosts caps the addresses one scan probes, a /22
+	MaxScanHosts = 1024
+
+	// subnetProbeSize pads probes so that no reply is larger than the probe
+	// that asked for it, and the probe port cannot amplify spoofed traffic
+	subnetProbeSize = 900
+
+	// maxProbeReplyAddrs caps the addresses a reply offers
+	maxProbeReplyAddrs = 4
+
+	// scanProbeInterval paces the probes, and scanReplyWait is how long
+	// replies are awaited after the last one
+	scanProbeInterval = time.Millisecond
+	scanReplyWait     = 2 * time.Second
+
+	// probeReplyInterval is how often one address is answered at most
+	probeReplyInterval = time.Second
+)
+
+// SubnetProbeReply answers a subnet probe with a signed presence beacon and
+// the addresses to dial the node at. The addresses are hints, not signed:
+// the connection handshake proves the peer.
+type SubnetProbeReply struct {
+	Beacon *PresenceBeacon `json:"beacon"`
+	Addrs  []string        `json:"addrs,omitempty"`
+}
+
+// NewSubnetProbe returns a probe asking the nodes of a subnet to answer
+func NewSubnetProbe() []byte {
+	probe := make([]byte, subnetProbeSize)
+	copy(probe, SubnetProbePrefix)
+	binary.BigEndian.PutUint64(probe[len(SubnetProbePrefix):], uint64(time.Now().UnixNano()))
+	return probe
+}
+
+// IsSubnetProbe reports whether data is a subnet probe
+func IsSubnetProbe(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(SubnetProbePrefix))
+}
+
+// NewSubnetProbeReply answers probe with a beacon signed by key and addrs,
+// leaving out addresses that would make the reply larger than the probe
+func NewSubnetProbeReply(probe []byte, key crypto.PrivKey, sequence uint64, addrs []ma.Multiaddr) ([]byte, error) {
+	if !IsSubnetProbe(probe) || len(probe) < subnetProbeSize {
+		return nil, fmt.Errorf("not a subnet probe")
+	}
+	beacon, err := NewPresenceBeacon(key, sequence, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	reply := SubnetProbeReply{Beacon: beacon}
+	for _, addr := range addrs {
+		reply.Addrs = append(reply.Addrs, addr.String())
+	}
+	for {
+		data, err := json.Marshal(reply)
+		if err != nil {
+			return nil, err
+		}
+		data = append([]byte(SubnetProbeReplyPrefix), data...)
+		if len(data) <= len(probe) {
+			return data, nil
+		}
+		if len(reply.Addrs) == 0 {
+			return nil, fmt.Errorf("reply larger than the probe")
+		}
+		reply.Addrs = reply.Addrs[:len(reply.Addrs)-1]
+	}
+}
+
+// ParseSubnetProbeReply verifies a reply to a probe sent from ip and
+// returns the peer that answered. The peer is dialed at the addresses it
+// offered on the address that answered, and otherwise at those.
+func ParseSubnetProbeReply(data []byte, ip net.IP, now time.Time) (peer.AddrInfo, error) {
+	if !bytes.HasPrefix(data, []byte(SubnetProbeReplyPrefix)) {
+		return peer.AddrInfo{}, fmt.Errorf("not a subnet probe reply")
+	}
+	var reply SubnetProbeReply
+	if err := json.Unmarshal(data[len(SubnetProbeReplyPrefix):], &reply); err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("failed to parse subnet probe reply: %w", err)
+	}
+	if reply.Beacon == nil {
+		return peer.AddrInfo{}, fmt.Errorf("subnet probe reply without beacon")
+	}
+	id, err := reply.Beacon.Verify(now)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+
+	info := peer.AddrInfo{ID: id}
+	var elsewhere []ma.Multiaddr
+	for _, s := range reply.Addrs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			continue
+		}
+		if addrIP, err := manet.ToIP(addr); err == nil && addrIP.Equal(ip) {
+			info.Addrs = append(info.Addrs, addr)
+		} else {
+			elsewhere = append(elsewhere, addr)
+		}
+	}
+	if len(info.Addrs) == 0 {
+		info.Addrs = elsewhere
+	}
+	return info, nil
+}
+
+// ParseScanSubnet parses the IPv4 subnet to scan, at most MaxScanHosts
+// addresses
+func ParseScanSubnet(cidr string) (*net.IPNet, error) {
+	_, subnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet: %w", err)
+	}
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 subnets can be scanned; IPv6 peers are found through the all-nodes group")
+	}
+	ones, bits := subnet.Mask.Size()
+	if hosts := 1 << (bits - ones); hosts > MaxScanHosts {
+		return nil, fmt.Errorf("%s has %d addresses, at most %d (a /22) can be scanned", subnet, hosts, MaxScanHosts)
+	}
+	return subnet, nil
+}
+
+// scanHosts returns the addresses of a subnet to probe, leaving out the
+// network and broadcast addresses of subnets that have them
+func scanHosts(subnet *net.IPNet) []net.IP {
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	ones, bits := subnet.Mask.Size()
+	size := uint32(1) << (bits - ones)
+
+	var hosts []net.IP
+	for i := uint32(0); i < size; i++ {
+		if size > 2 && (i == 0 || i == size-1) {
+			continue
+		}
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+i)
+		hosts = append(hosts, ip)
+	}
+	return hosts
+}
+
+// ScanSubnet probes every address of an IPv4 subnet on port, the beacon
+// port when 0, and returns the Xelvra nodes that answered, visible to
+// everyone, in the order they answered
+func ScanSubnet(ctx context.Context, subnet *net.IPNet, port int) ([]peer.AddrInfo, error) {
+	if port == 0 {
+		port = beaconPort
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	var (
+		mu    sync.Mutex
+		found []peer.AddrInfo
+		seen  = make(map[peer.ID]bool)
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buffer := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			info, err := ParseSubnetProbeReply(buffer[:n], from.IP, time.Now())
+			if err != nil {
+				continue
+			}
+			mu.Lock()
+			if !seen[info.ID] {
+				seen[info.ID] = true
+				found = append(found, info)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	probe := NewSubnetProbe()
+	for _, ip := range scanHosts(subnet) {
+		if ctx.Err() != nil {
+			break
+		}
+		// Unreachable hosts make writes fail now and then; scan on
+		_, _ = conn.WriteToUDP(probe, &net.UDPAddr{IP: ip, Port: port})
+		time.Sleep(scanProbeInterval)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(scanReplyWait):
+	}
+	_ = conn.Close()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	return found, nil
+}
+
+// probeLimiter answers each address at most once per probeReplyInterval
+type probeLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// allow reports whether ip may be answered now
+func (l *probeLimiter) allow(ip net.IP, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last == nil {
+		l.last = make(map[string]time.Time)
+	}
+	for addr, at := range l.last {
+		if now.Sub(at) > probeReplyInterval {
+			delete(l.last, addr)
+		}
+	}
+	if _, ok := l.last[ip.String()]; ok {
+		return false
+	}
+	l.last[ip.String()] = now
+	return true
+}
+
+// answerSubnetProbe answers a probe from the LAN, while visible to
+// everyone, with the node's addresses on the LAN
+func (dm *DiscoveryManager) answerSubnetProbe(conn *net.UDPConn, probe []byte, from *net.UDPAddr) {
+	if !from.IP.IsPrivate() && !from.IP.IsLoopback() && !from.IP.IsLinkLocalUnicast() {
+		return
+	}
+	if !dm.Visibility().AnnouncesOnLAN() || !dm.probes.allow(from.IP, time.Now()) {
+		return
+	}
+	key := dm.host.Peerstore().PrivKey(dm.host.ID())
+	if key == nil {
+		return
+	}
+
+	var addrs []ma.Multiaddr
+	for _, addr := range dm.host.Addrs() {
+		if manet.IsIPLoopback(addr) != from.IP.IsLoopback() || manet.IsPublicAddr(addr) {
+			continue
+		}
+		if len(addrs) < maxProbeReplyAddrs {
+			addrs = append(addrs, addr)
+		}
+	}
+	reply, err := NewSubnetProbeReply(probe, key, atomic.AddUint64(&dm.beaconSeq, 1), addrs)
+	if err != nil {
+		dm.logger.WithError(err).Debug("Failed to answer subnet probe")
+		return
+	}
+	if _, err := conn.WriteToUDP(reply, from); err != nil {
+		dm.logger.WithError(err).Debug("Failed to answer subnet probe")
+		return
+	}
+	dm.logger.WithField("from", from.String()).Debug("Answered subnet probe")
+}
+
+// ScanSubnet probes a subnet for nodes the LAN multicast does not reach,
+// adds those that answered to the discovered peers and connects to them
+func (dm *DiscoveryManager) ScanSubnet(ctx context.Context, subnet *net.IPNet) ([]peer.AddrInfo, error) {
+	found, err := ScanSubnet(ctx, subnet, 0)
+	if err != nil {
+		return nil, err
+	}
+	var peers []peer.AddrInfo
+	for _, info := range found {
+		if info.ID == dm.host.ID() {
+			continue
+		}
+		dm.logger.WithFields(logrus.Fields{
+			"peer_id": info.ID.String(),
+			"addrs":   info.Addrs,
+		}).Info("Discovered peer via subnet scan")
+		dm.recordDiscoveredPeer(info, "scan")
+		peers = append(peers, info)
+
+		go func(info peer.AddrInfo) {
+			ctx, cancel := context.WithTimeout(dm.ctx, 10*time.Second)
+			defer cancel()
+			if err := dm.host.Connect(ctx, info); err != nil {
+				dm.logger.WithError(err).WithField("peer_id", info.ID.String()).Debug("Failed to connect to scanned peer")
+			}
+		}(info)
+	}
+	return peers, nil
+}
@@ -0,0 +1,408 @@
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// DefaultTorSOCKS is where a local Tor daemon takes SOCKS connections
+	DefaultTorSOCKS = "127.0.0.1:9050"
+
+	// DefaultTorControl is the control port of a local Tor daemon
+	DefaultTorControl = "127.0.0.1:9051"
+
+	// TorControlPasswordEnv holds the control port password, for a Tor
+	// configured with HashedControlPassword instead of cookie authentication
+	TorControlPasswordEnv = "TOR_CONTROL_PASSWORD"
+
+	// OnionPort is the port peers reach the node's onion service on
+	OnionPort = 4001
+
+	// OnionKeyFileName keeps the onion service key in the data directory,
+	// so the onion address stays the same across restarts
+	OnionKeyFileName = "tor_onion_key"
+)
+
+// TorProxyConfig returns the proxy configuration sending every TCP
+// connection through the Tor SOCKS port at socksAddr, DefaultTorSOCKS if
+// empty. Host names are resolved by Tor.
+func TorProxyConfig(socksAddr string) ProxyConfig {
+	if socksAddr == "" {
+		socksAddr = DefaultTorSOCKS
+	}
+	return ProxyConfig{AllProxy: "socks5h://" + socksAddr, Strict: true}
+}
+
+// OnionDialTarget returns the host:port of an /onion3 multiaddr, to dial
+// through Tor
+func OnionDialTarget(addr ma.Multiaddr) (string, error) {
+	value, err := addr.ValueForProtocol(ma.P_ONION3)
+	if err != nil {
+		return "", fmt.Errorf("not an onion address: %s", addr)
+	}
+	service, port, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", fmt.Errorf("onion address %s has no port", addr)
+	}
+	return net.JoinHostPort(service+".onion", port), nil
+}
+
+// OnionAddr returns the /onion3 multiaddr of an onion service ID
+func OnionAddr(serviceID string, port int) (ma.Multiaddr, error) {
+	return ma.NewMultiaddr(fmt.Sprintf("/onion3/%s:%d", serviceID, port))
+}
+
+// OnionTransport dials /onion3 addresses through the Tor SOCKS port. It
+// does not listen: Tor forwards the node's onion service to its loopback
+// TCP listener (see PublishOnionService).
+type OnionTransport struct {
+	upgrader transport.Upgrader
+	rcmgr    network.ResourceManager
+	dialer   *ProxyDialer
+}
+
+var _ transport.Transport = (*OnionTransport)(nil)
+
+// OnionOption configures an OnionTransport
+type OnionOption func(*OnionTransport)
+
+// WithTorSOCKS dials through the Tor SOCKS port at addr instead of
+// DefaultTorSOCKS
+func WithTorSOCKS(addr string) OnionOption {
+	return func(t *OnionTransport) {
+		t.dialer = NewProxyDialer(TorProxyConfig(addr))
+	}
+}
+
+// NewOnionTransport creates the onion transport, for libp2p.Transport
+func NewOnionTransport(upgrader transport.Upgrader, rcmgr network.ResourceManager, opts ...OnionOption) (*OnionTransport, error) {
+	if rcmgr == nil {
+		rcmgr = &network.NullResourceManager{}
+	}
+	t := &OnionTransport{
+		upgrader: upgrader,
+		rcmgr:    rcmgr,
+		dialer:   NewProxyDialer(TorProxyConfig("")),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t, nil
+}
+
+// Dial implements transport.Transport
+func (t *OnionTransport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	target, err := OnionDialTarget(raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := t.rcmgr.OpenConnection(network.DirOutbound, true, raddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := scope.SetPeer(p); err != nil {
+		scope.Done()
+		return nil, err
+	}
+
+	conn, err := t.dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	local, err := manet.FromNetAddr(conn.LocalAddr())
+	if err != nil {
+		_ = conn.Close()
+		scope.Done()
+		return nil, err
+	}
+
+	// The upgrader releases the scope if the upgrade fails
+	return t.upgrader.Upgrade(ctx, t, &onionConn{Conn: conn, local: local, remote: raddr}, network.DirOutbound, p, scope)
+}
+
+// CanDial implements transport.Transport
+func (t *OnionTransport) CanDial(addr ma.Multiaddr) bool {
+	_, err := OnionDialTarget(addr)
+	return err == nil
+}
+
+// Listen implements transport.Transport; onion services are published with
+// PublishOnionService instead
+func (t *OnionTransport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	return nil, fmt.Errorf("cannot listen on %s: onion services are published through the Tor control port", laddr)
+}
+
+// Protocols implements transport.Transport
+func (t *OnionTransport) Protocols() []int {
+	return []int{ma.P_ONION3}
+}
+
+// Proxy implements transport.Transport
+func (t *OnionTransport) Proxy() bool {
+	return false
+}
+
+// onionConn is a connection to an onion service through the SOCKS port
+type onionConn struct {
+	net.Conn
+	local  ma.Multiaddr
+	remote ma.Multiaddr
+}
+
+// LocalMultiaddr implements manet.Conn
+func (c *onionConn) LocalMultiaddr() ma.Multiaddr {
+	return c.local
+}
+
+// RemoteMultiaddr implements manet.Conn
+func (c *onionConn) RemoteMultiaddr() ma.Multiaddr {
+	return c.remote
+}
+
+// onionAddrs is the address factory of a node over Tor: it advertises the
+// onion service and relay circuits, never the loopback listener
+type onionAddrs struct {
+	mu   sync.RWMutex
+	addr ma.Multiaddr
+}
+
+// set advertises addr as the onion service address
+func (o *onionAddrs) set(addr ma.Multiaddr) {
+	o.mu.Lock()
+	o.addr = addr
+	o.mu.Unlock()
+}
+
+// factory implements libp2p's AddrsFactory
+func (o *onionAddrs) factory(addrs []ma.Multiaddr) []ma.Multiaddr {
+	var advertised []ma.Multiaddr
+	for _, addr := range addrs {
+		if isRelayAddr(addr) {
+			advertised = append(advertised, addr)
+		}
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.addr != nil {
+		advertised = append(advertised, o.addr)
+	}
+	return advertised
+}
+
+// publishOnionService asks Tor to forward an onion service to the loopback
+// TCP listener. Without it the node is still reachable through relays.
+func (n *PeerChatNode) publishOnionService() {
+	target := ""
+	for _, addr := range n.host.Network().ListenAddresses() {
+		if network, hostPort, err := manet.DialArgs(addr); err == nil && strings.HasPrefix(network, "tcp") && manet.IsIPLoopback(addr) {
+			target = hostPort
+			break
+		}
+	}
+	if target == "" {
+		n.logger.Warn("No loopback TCP listener for the onion service, peers can reach this node only through relays")
+		return
+	}
+
+	keyPath := ""
+	if n.config.DataDir != "" {
+		keyPath = filepath.Join(n.config.DataDir, OnionKeyFileName)
+	}
+	service, err := PublishOnionService(n.config.TorControl, target, keyPath)
+	if err != nil {
+		n.logger.WithError(err).Warn("Onion service not published, peers can reach this node only through relays")
+		return
+	}
+
+	n.mu.Lock()
+	n.onionService = service
+	n.mu.Unlock()
+	n.onionAddrs.set(service.Addr)
+	n.logger.WithField("addr", service.Addr.String()).Info("Reachable over Tor at the onion service")
+}
+
+// OnionService is an onion service published through the Tor control port.
+// It lasts as long as the control connection; Close removes it.
+type OnionService struct {
+	ServiceID string
+	Addr      ma.Multiaddr
+
+	conn *textproto.Conn
+}
+
+// PublishOnionService asks the Tor daemon at controlAddr (DefaultTorControl
+// if empty) to forward an onion service on OnionPort to target, a loopback
+// host:port. The service key is read from keyPath, or created and saved
+// there if the file does not exist; an empty keyPath gives a new address
+// every time.
+func PublishOnionService(controlAddr, target, keyPath string) (*OnionService, error) {
+	if controlAddr == "" {
+		controlAddr = DefaultTorControl
+	}
+	raw, err := net.DialTimeout("tcp", controlAddr, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the Tor control port: %w", err)
+	}
+	conn := textproto.NewConn(raw)
+
+	service, err := publishOnionService(conn, target, keyPath)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return service, nil
+}
+
+// publishOnionService authenticates on conn and adds the onion service
+func publishOnionService(conn *textproto.Conn, target, keyPath string) (*OnionService, error) {
+	if err := torAuthenticate(conn); err != nil {
+		return nil, err
+	}
+
+	key := "NEW:ED25519-V3"
+	flags := ""
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		switch {
+		case err == nil:
+			key = strings.TrimSpace(string(data))
+			flags = " Flags=DiscardPK"
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("failed to read onion service key: %w", err)
+		}
+	} else {
+		flags = " Flags=DiscardPK"
+	}
+
+	reply, err := torCommand(conn, fmt.Sprintf("ADD_ONION %s%s Port=%d,%s", key, flags, OnionPort, target))
+	if err != nil {
+		return nil, fmt.Errorf("tor refused the onion service: %w", err)
+	}
+	fields := torReplyFields(reply)
+	serviceID := fields["ServiceID"]
+	if serviceID == "" {
+		return nil, fmt.Errorf("tor did not return the onion service ID")
+	}
+	if privateKey := fields["PrivateKey"]; privateKey != "" && keyPath != "" {
+		if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(keyPath, []byte(privateKey+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to save onion service key: %w", err)
+		}
+	}
+
+	addr, err := OnionAddr(serviceID, OnionPort)
+	if err != nil {
+		return nil, err
+	}
+	return &OnionService{ServiceID: serviceID, Addr: addr, conn: conn}, nil
+}
+
+// Close removes the onion service
+func (s *OnionService) Close() error {
+	_, _ = torCommand(s.conn, "DEL_ONION "+s.ServiceID)
+	return s.conn.Close()
+}
+
+// torAuthenticate authenticates with the first method the daemon offers
+// that needs no user input: none, the cookie file, or the password from
+// TorControlPasswordEnv
+func torAuthenticate(conn *textproto.Conn) error {
+	reply, err := torCommand(conn, "PROTOCOLINFO 1")
+	if err != nil {
+		return fmt.Errorf("tor control port: %w", err)
+	}
+
+	var methods, cookieFile string
+	for _, line := range strings.Split(reply, "\n") {
+		if rest, ok := strings.CutPrefix(line, "AUTH "); ok {
+			fields := torReplyFields(rest)
+			methods, cookieFile = fields["METHODS"], fields["COOKIEFILE"]
+		}
+	}
+	offered := make(map[string]bool)
+	for _, method := range strings.Split(methods, ",") {
+		offered[method] = true
+	}
+
+	var command string
+	switch password := os.Getenv(TorControlPasswordEnv); {
+	case offered["NULL"]:
+		command = "AUTHENTICATE"
+	case offered["HASHEDPASSWORD"] && password != "":
+		command = "AUTHENTICATE " + strconv.Quote(password)
+	case offered["COOKIE"] && cookieFile != "":
+		cookie, err := os.ReadFile(cookieFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the Tor control cookie: %w", err)
+		}
+		command = "AUTHENTICATE " + hex.EncodeToString(cookie)
+	default:
+		return fmt.Errorf("no usable Tor control authentication among %q (set %s for password authentication)", methods, TorControlPasswordEnv)
+	}
+
+	if _, err := torCommand(conn, command); err != nil {
+		return fmt.Errorf("tor control authentication failed: %w", err)
+	}
+	return nil
+}
+
+// torCommand sends a control command and returns the lines of its 250
+// reply
+func torCommand(conn *textproto.Conn, command string) (string, error) {
+	if err := conn.PrintfLine("%s", command); err != nil {
+		return "", err
+	}
+	_, message, err := conn.ReadResponse(250)
+	return message, err
+}
+
+// torReplyFields parses the KEY=value and KEY="value" pairs of a reply
+func torReplyFields(reply string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(reply, "\n") {
+		for len(line) > 0 {
+			line = strings.TrimLeft(line, " ")
+			key, rest, ok := strings.Cut(line, "=")
+			if !ok || strings.Contains(key, " ") {
+				// A word without a value, e.g. PROTOCOLINFO's version
+				_, line, _ = strings.Cut(line, " ")
+				continue
+			}
+			var value string
+			if strings.HasPrefix(rest, `"`) {
+				quoted, err := strconv.QuotedPrefix(rest)
+				if err != nil {
+					break
+				}
+				value, _ = strconv.Unquote(quoted)
+				rest = rest[len(quoted):]
+			} else {
+				value, rest, _ = strings.Cut(rest, " ")
+			}
+			fields[key] = value
+			line = rest
+		}
+	}
+	return fields
+}
@@ -0,0 +1,297 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+// TransportPolicy restricts which network paths a conversation may use
+type TransportPolicy string
+
+const (
+	TransportAny     TransportPolicy = "any"      // No restriction (default)
+	TransportLAN     TransportPolicy = "lan"      // Direct connections on private/local networks only
+	TransportNoRelay TransportPolicy = "no-relay" // Any direct connection, never through a relay
+	TransportOnion   TransportPolicy = "onion"    // Onion (Tor) addresses only
+)
+
+// TransportPolicies lists all supported policies
+var TransportPolicies = []TransportPolicy{TransportAny, TransportLAN, TransportNoRelay, TransportOnion}
+
+// ParseTransportPolicy parses a policy name
+func ParseTransportPolicy(s string) (TransportPolicy, error) {
+	for _, p := range TransportPolicies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown transport policy %q (use any, lan, no-relay or onion)", s)
+}
+
+// Description returns a human-readable explanation of the policy
+func (p TransportPolicy) Description() string {
+	switch p {
+	case TransportLAN:
+		return "LAN only"
+	case TransportNoRelay:
+		return "never relay"
+	case TransportOnion:
+		return "onion only"
+	default:
+		return "any transport"
+	}
+}
+
+// Allows reports whether a connection over addr is permitted by the policy
+func (p TransportPolicy) Allows(addr ma.Multiaddr) bool {
+	if addr == nil {
+		return p == TransportAny
+	}
+
+	relayed := isRelayAddr(addr)
+	switch p {
+	case TransportLAN:
+		return !relayed && manet.IsPrivateAddr(addr)
+	case TransportNoRelay:
+		return !relayed
+	case TransportOnion:
+		return !relayed && isOnionAddr(addr)
+	default:
+		return true
+	}
+}
+
+// isRelayAddr returns true if the address goes through a circuit relay
+func isRelayAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// isOnionAddr returns true for Tor onion service addresses
+func isOnionAddr(addr ma.Multiaddr) bool {
+	if _, err := addr.ValueForProtocol(ma.P_ONION3); err == nil {
+		return true
+	}
+	_, err := addr.ValueForProtocol(ma.P_ONION)
+	return err == nil
+}
+
+// getTransportPinsPath returns the path of the transport pin file
+func getTransportPinsPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "transport_pins.json"), nil
+}
+
+// loadTransportPins reads all pinned policies keyed by peer ID
+func loadTransportPins() (map[string]TransportPolicy, error) {
+	pins := make(map[string]TransportPolicy)
+
+	path, err := getTransportPinsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pins, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("failed to parse transport pins: %w", err)
+	}
+	return pins, nil
+}
+
+// saveTransportPins writes all pinned policies
+func saveTransportPins(pins map[string]TransportPolicy) error {
+	path, err := getTransportPinsPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// SetTransportPin pins the conversation with peerID to a policy.
+// Pinning to TransportAny removes the pin.
+func SetTransportPin(peerID string, policy TransportPolicy) error {
+	if _, err := peer.Decode(peerID); err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	pins, err := loadTransportPins()
+	if err != nil {
+		return err
+	}
+
+	if policy == TransportAny {
+		delete(pins, peerID)
+	} else {
+		pins[peerID] = policy
+	}
+
+	return saveTransportPins(pins)
+}
+
+// TransportPin is a pinned policy for one peer
+type TransportPin struct {
+	PeerID string
+	Policy TransportPolicy
+}
+
+// ListTransportPins returns all pinned policies sorted by peer ID
+func ListTransportPins() ([]TransportPin, error) {
+	pins, err := loadTransportPins()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]TransportPin, 0, len(pins))
+	for id, policy := range pins {
+		result = append(result, TransportPin{PeerID: id, Policy: policy})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].PeerID < result[j].PeerID
+	})
+	return result, nil
+}
+
+// TransportGater enforces pinned transport policies on every dial and on
+// inbound connections. Checks use the pins cached by the last Reload; the
+// node reloads them on a timer, so pins set from another process apply to
+// the running node.
+type TransportGater struct {
+	mu      sync.RWMutex
+	pins    map[string]TransportPolicy
+	modTime time.Time
+	size    int64
+	logger  *logrus.Logger
+}
+
+// NewTransportGater creates a gater backed by the transport pin file
+func NewTransportGater(logger *logrus.Logger) *TransportGater {
+	g := &TransportGater{
+		pins:   make(map[string]TransportPolicy),
+		logger: logger,
+	}
+	g.Reload()
+	return g
+}
+
+// Reload re-reads the pin file if it changed since the last read
+func (g *TransportGater) Reload() {
+	path, err := getTransportPinsPath()
+	if err != nil {
+		return
+	}
+
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+
+	g.mu.RLock()
+	unchanged := modTime.Equal(g.modTime) && size == g.size
+	g.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	pins, err := loadTransportPins()
+	if err != nil {
+		g.logger.WithError(err).Warn("Failed to load transport pins, keeping previous pins")
+		return
+	}
+
+	g.mu.Lock()
+	g.pins = pins
+	g.modTime = modTime
+	g.size = size
+	g.mu.Unlock()
+}
+
+// PolicyFor returns the policy pinned for a peer
+func (g *TransportGater) PolicyFor(p peer.ID) TransportPolicy {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if policy, ok := g.pins[p.String()]; ok {
+		return policy
+	}
+	return TransportAny
+}
+
+// InterceptPeerDial implements connmgr.ConnectionGater
+func (g *TransportGater) InterceptPeerDial(p peer.ID) bool {
+	return true
+}
+
+// InterceptAddrDial implements connmgr.ConnectionGater
+func (g *TransportGater) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) bool {
+	policy := g.PolicyFor(p)
+	if policy.Allows(addr) {
+		return true
+	}
+
+	g.logger.WithFields(logrus.Fields{
+		"peer_id": p.String(),
+		"addr":    addr.String(),
+		"policy":  policy,
+	}).Debug("Dial blocked by transport pin")
+	return false
+}
+
+// InterceptAccept implements connmgr.ConnectionGater
+func (g *TransportGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptSecured implements connmgr.ConnectionGater
+func (g *TransportGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	policy := g.PolicyFor(p)
+	if policy.Allows(addrs.RemoteMultiaddr()) {
+		return true
+	}
+
+	g.logger.WithFields(logrus.Fields{
+		"peer_id":   p.String(),
+		"addr":      addrs.RemoteMultiaddr().String(),
+		"policy":    policy,
+		"direction": dir.String(),
+	}).Info("Connection rejected by transport pin")
+	return false
+}
+
+// InterceptUpgraded implements connmgr.ConnectionGater
+func (g *TransportGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,146 @@
+package p2p
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// DisableQUICEnv turns QUIC off when set to a true value such as 1,
+	// for networks that mangle UDP
+	DisableQUICEnv = "XELVRA_DISABLE_QUIC"
+
+	// quicHeadStart is how long QUIC dials get before TCP is tried, long
+	// enough for a QUIC handshake on most paths
+	quicHeadStart = 500 * time.Millisecond
+)
+
+// browserListenAddrs are the WebTransport and WebRTC-direct listeners a
+// browser can dial without a gateway
+var browserListenAddrs = []string{
+	"/ip4/0.0.0.0/udp/0/quic-v1/webtransport",
+	"/ip4/0.0.0.0/udp/0/webrtc-direct",
+	"/ip6/::/udp/0/quic-v1/webtransport",
+	"/ip6/::/udp/0/webrtc-direct",
+}
+
+// QUICDisabledByEnvironment reports whether DisableQUICEnv turns QUIC off
+func QUICDisabledByEnvironment() bool {
+	value := strings.TrimSpace(os.Getenv(DisableQUICEnv))
+	if strings.EqualFold(value, "yes") {
+		return true
+	}
+	disabled, err := strconv.ParseBool(value)
+	return err == nil && disabled
+}
+
+// withoutQUICAddrs returns the listen addresses that do not use QUIC
+func withoutQUICAddrs(addrs []string) []string {
+	var kept []string
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/quic") {
+			kept = append(kept, addr)
+		}
+	}
+	return kept
+}
+
+// withoutTCPAddrs drops the TCP listen addresses, when TCP is turned off
+func withoutTCPAddrs(addrs []string) []string {
+	var kept []string
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/tcp/") {
+			kept = append(kept, addr)
+		}
+	}
+	return kept
+}
+
+// addrTransport names the transport of an address: "quic", "tcp",
+// "relay", "webtransport" or "webrtc", or "" for anything else
+func addrTransport(addr ma.Multiaddr) string {
+	if isRelayAddr(addr) {
+		return "relay"
+	}
+	if IsBrowserAddr(addr) {
+		if _, err := addr.ValueForProtocol(ma.P_WEBTRANSPORT); err == nil {
+			return "webtransport"
+		}
+		return "webrtc"
+	}
+	if _, err := addr.ValueForProtocol(ma.P_QUIC_V1); err == nil {
+		return "quic"
+	}
+	if _, err := addr.ValueForProtocol(ma.P_TCP); err == nil {
+		return "tcp"
+	}
+	return ""
+}
+
+// IsBrowserAddr reports whether a browser can dial addr directly: a
+// WebTransport or WebRTC-direct address that is not relayed
+func IsBrowserAddr(addr ma.Multiaddr) bool {
+	if isRelayAddr(addr) {
+		return false
+	}
+	for _, code := range []int{ma.P_WEBTRANSPORT, ma.P_WEBRTC_DIRECT} {
+		if _, err := addr.ValueForProtocol(code); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// QUICFirstDialRanker ranks addresses as libp2p does, then holds TCP back
+// until QUIC has had quicHeadStart, so connections end up on QUIC wherever
+// it works and on TCP only where UDP is blocked
+func QUICFirstDialRanker(addrs []ma.Multiaddr) []network.AddrDelay {
+	ranked := swarm.DefaultDialRanker(addrs)
+
+	var lastQUIC time.Duration
+	hasQUIC := false
+	for _, a := range ranked {
+		if addrTransport(a.Addr) == "quic" {
+			hasQUIC = true
+			lastQUIC = max(lastQUIC, a.Delay)
+		}
+	}
+	if !hasQUIC {
+		return ranked
+	}
+
+	for i, a := range ranked {
+		if addrTransport(a.Addr) == "tcp" {
+			ranked[i].Delay = max(a.Delay, lastQUIC+quicHeadStart)
+		}
+	}
+	return ranked
+}
+
+// transportStatus lists the addresses the node listens on and its open
+// connections, each with its transport
+func (n *PeerChatNode) transportStatus() []NetworkTransport {
+	transports := []NetworkTransport{}
+	for _, addr := range n.host.Addrs() {
+		transports = append(transports, NetworkTransport{
+			Type:      addrTransport(addr),
+			LocalAddr: addr.String(),
+			IsActive:  true,
+		})
+	}
+	for _, conn := range n.host.Network().Conns() {
+		transports = append(transports, NetworkTransport{
+			Type:       addrTransport(conn.RemoteMultiaddr()),
+			LocalAddr:  conn.LocalMultiaddr().String(),
+			RemoteAddr: conn.RemoteMultiaddr().String(),
+			IsActive:   true,
+		})
+	}
+	return transports
+}
@@ -0,0 +1,233 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Visibility controls who discovery announces this node to. It decides what
+// goes into mDNS, UDP presence beacons and DHT provider records; looking
+// for peers works the same at every level.
+type Visibility string
+
+const (
+	// VisibilityEveryone announces on the LAN (mDNS and UDP beacons) and
+	// under the public DHT namespace (default)
+	VisibilityEveryone Visibility = "everyone"
+
+	// VisibilityContactsOfContacts announces only in the DHT, under a
+	// namespace per contact and one per contact's circle, so contacts and
+	// people who share a contact find the node
+	VisibilityContactsOfContacts Visibility = "contacts-of-contacts"
+
+	// VisibilityContacts announces only in the DHT, under a namespace per
+	// contact that only the two of them look up
+	VisibilityContacts Visibility = "contacts"
+
+	// VisibilityInvisible announces nothing; the node can still find and
+	// dial peers, and be dialed by those who know its addresses
+	VisibilityInvisible Visibility = "invisible"
+)
+
+// Visibilities lists all visibility levels, most visible first
+var Visibilities = []Visibility{VisibilityEveryone, VisibilityContactsOfContacts, VisibilityContacts, VisibilityInvisible}
+
+const (
+	// PublicNamespace is the DHT namespace every visible node announces under
+	PublicNamespace = "xelvra-p2p"
+
+	// AnnounceMinInterval is the least time between two rounds of DHT
+	// announcements. Changing the visibility re-announces at once unless a
+	// round ran within the interval; then it waits for the interval to pass.
+	AnnounceMinInterval = time.Minute
+
+	// MaxAnnounceNamespaces caps the DHT namespaces announced under, or
+	// looked up, in one round, so a large contact book does not flood the
+	// DHT. Contacts past the cap are not announced to.
+	MaxAnnounceNamespaces = 64
+)
+
+// ParseVisibility parses a visibility level name
+func ParseVisibility(s string) (Visibility, error) {
+	for _, v := range Visibilities {
+		if string(v) == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("unknown visibility %q (use everyone, contacts-of-contacts, contacts or invisible)", s)
+}
+
+// Description returns a human-readable explanation of the level
+func (v Visibility) Description() string {
+	switch v {
+	case VisibilityContactsOfContacts:
+		return "contacts and their contacts, DHT only"
+	case VisibilityContacts:
+		return "contacts only, DHT only"
+	case VisibilityInvisible:
+		return "nobody, nothing is announced"
+	default:
+		return "everyone, on the LAN and in the DHT"
+	}
+}
+
+// AnnouncesOnLAN reports whether the level sends mDNS responses and UDP
+// presence beacons, which everyone on the LAN receives
+func (v Visibility) AnnouncesOnLAN() bool {
+	return v == VisibilityEveryone || v == ""
+}
+
+// AnnounceNamespaces returns the DHT namespaces a node with peer ID self and
+// contacts announces under at level v
+func AnnounceNamespaces(v Visibility, self peer.ID, contacts []peer.ID) []string {
+	var namespaces []string
+	switch v {
+	case VisibilityEveryone, "":
+		namespaces = append(namespaces, PublicNamespace)
+	case VisibilityContactsOfContacts:
+		for _, c := range contacts {
+			namespaces = append(namespaces, ContactNamespace(self, c))
+		}
+		for _, c := range contacts {
+			namespaces = append(namespaces, CircleNamespace(c))
+		}
+	case VisibilityContacts:
+		for _, c := range contacts {
+			namespaces = append(namespaces, ContactNamespace(self, c))
+		}
+	}
+	return capNamespaces(namespaces)
+}
+
+// LookupNamespaces returns the DHT namespaces a node with peer ID self and
+// contacts looks for peers under, whatever its own level
+func LookupNamespaces(self peer.ID, contacts []peer.ID) []string {
+	namespaces := []string{PublicNamespace}
+	for _, c := range contacts {
+		namespaces = append(namespaces, ContactNamespace(self, c))
+	}
+	for _, c := range contacts {
+		namespaces = append(namespaces, CircleNamespace(c))
+	}
+	return capNamespaces(namespaces)
+}
+
+// ContactNamespace is the DHT namespace two contacts announce to each other
+// under. It is the same from either side and hides both peer IDs, though
+// anyone who knows both can compute it.
+func ContactNamespace(a, b peer.ID) string {
+	first, second := a.String(), b.String()
+	if second < first {
+		first, second = second, first
+	}
+	return hashedNamespace("contact", first+"/"+second)
+}
+
+// CircleNamespace is the DHT namespace the contacts of contact announce
+// under, so they find each other. Anyone who knows the contact's peer ID
+// can look it up.
+func CircleNamespace(contact peer.ID) string {
+	return hashedNamespace("circle", contact.String())
+}
+
+// hashedNamespace derives a namespace of kind from value
+func hashedNamespace(kind, value string) string {
+	sum := sha256.Sum256([]byte(PublicNamespace + "/" + kind + "/" + value))
+	return PublicNamespace + "/" + kind + "/" + hex.EncodeToString(sum[:16])
+}
+
+// capNamespaces keeps the first MaxAnnounceNamespaces namespaces
+func capNamespaces(namespaces []string) []string {
+	if len(namespaces) > MaxAnnounceNamespaces {
+		return namespaces[:MaxAnnounceNamespaces]
+	}
+	return namespaces
+}
+
+// AnnounceLimiter spaces rounds of announcements at least an interval apart
+type AnnounceLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+// NewAnnounceLimiter creates a limiter allowing a round every interval
+func NewAnnounceLimiter(interval time.Duration) *AnnounceLimiter {
+	return &AnnounceLimiter{interval: interval}
+}
+
+// Delay returns how long a round starting at now must wait, zero if it may
+// run at once
+func (l *AnnounceLimiter) Delay(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.last.IsZero() {
+		return 0
+	}
+	if wait := l.last.Add(l.interval).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Mark records that a round ran at now
+func (l *AnnounceLimiter) Mark(now time.Time) {
+	l.mu.Lock()
+	l.last = now
+	l.mu.Unlock()
+}
+
+// getVisibilityPath returns the path of the file keeping the level set
+// with /visibility
+func getVisibilityPath() (string, error) {
+	dataDir, err := user.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "visibility"), nil
+}
+
+// LoadVisibility returns the level last saved, or VisibilityEveryone if none
+// was. A saved level that cannot be read gives VisibilityInvisible with the
+// error, so a node that was hidden does not reappear.
+func LoadVisibility() (Visibility, error) {
+	path, err := getVisibilityPath()
+	if err != nil {
+		return VisibilityEveryone, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return VisibilityEveryone, nil
+		}
+		return VisibilityInvisible, err
+	}
+	v, err := ParseVisibility(strings.TrimSpace(string(data)))
+	if err != nil {
+		return VisibilityInvisible, err
+	}
+	return v, nil
+}
+
+// SaveVisibility keeps v as the level for later starts
+func SaveVisibility(v Visibility) error {
+	path, err := getVisibilityPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(string(v)+"\n"), 0600)
+}
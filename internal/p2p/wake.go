@@ -0,0 +1,472 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WakeProtocolID carries wake registrations and wake requests
+	WakeProtocolID = protocol.ID("/xelvra/wake/1.0.0")
+
+	// WakeKeepAliveInterval is how often a registered client pings its wake
+	// host, rarely enough for a phone radio to sleep in between while NAT
+	// mappings of TCP connections stay open
+	WakeKeepAliveInterval = 4 * time.Minute
+
+	// wakeIdleTimeout is how long a wake host keeps a registration that
+	// sends nothing
+	wakeIdleTimeout = 3 * WakeKeepAliveInterval
+
+	// wakeTimeout bounds a wake request and each answer
+	wakeTimeout = 10 * time.Second
+
+	// wakeMinInterval is the least time between two wakes of a peer
+	wakeMinInterval = 30 * time.Second
+
+	// wakeMaxFrame bounds a wake frame
+	wakeMaxFrame = 512
+
+	// wakeMaxClients bounds the registrations a wake host holds
+	wakeMaxClients = 1024
+
+	// wakeRetryDelay and wakeMaxRetryDelay bound waiting before registering
+	// again after the registration was lost
+	wakeRetryDelay    = 30 * time.Second
+	wakeMaxRetryDelay = 10 * time.Minute
+)
+
+// Wake frame types
+const (
+	wakeRegister   = "register"   // Client asks to be woken
+	wakeRegistered = "registered" // Host holds the registration
+	wakeRefused    = "refused"    // Host holds too many registrations
+	wakePing       = "ping"       // Client keeps the registration alive
+	wakePong       = "pong"       // Host answers a ping
+	wakeRequest    = "wake"       // Sender asks to wake Peer; host wakes client From
+	wakeWoken      = "woken"      // Host passed the wake on
+	wakeUnknown    = "unknown"    // Peer is not registered at the host
+)
+
+// ErrWakeRefused is returned when a wake host holds too many registrations
+var ErrWakeRefused = errors.New("wake host refused the registration")
+
+// wakeFrame is one newline-delimited JSON frame of the wake protocol
+type wakeFrame struct {
+	Type string `json:"type"`
+	Peer string `json:"peer,omitempty"` // Peer to wake, in a wake request
+	From string `json:"from,omitempty"` // Peer that asked, in a wake sent to a client
+}
+
+// writeWakeFrame writes one frame
+func writeWakeFrame(s network.Stream, frame wakeFrame) error {
+	return json.NewEncoder(s).Encode(frame)
+}
+
+// readWakeFrame reads one frame from a reader of at most wakeMaxFrame bytes
+func readWakeFrame(r *bufio.Reader) (*wakeFrame, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("wake frame larger than %d bytes", wakeMaxFrame)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var frame wakeFrame
+	if err := json.Unmarshal(line, &frame); err != nil {
+		return nil, fmt.Errorf("invalid wake frame: %w", err)
+	}
+	return &frame, nil
+}
+
+// wakeClient is a registration held by a wake host
+type wakeClient struct {
+	mu     sync.Mutex // Serializes writes to the stream
+	stream network.Stream
+}
+
+// write sends a frame to the client
+func (c *wakeClient) write(frame wakeFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.stream.SetWriteDeadline(time.Now().Add(wakeTimeout))
+	return writeWakeFrame(c.stream, frame)
+}
+
+// WakeHost holds a stream from each registered client so that peers can
+// wake clients that sleep behind a NAT, and passes wake requests on to them
+type WakeHost struct {
+	host   host.Host
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	clients  map[peer.ID]*wakeClient
+	lastWake map[peer.ID]time.Time
+}
+
+// ServeWake lets peers register with h to be woken and others wake them
+func ServeWake(h host.Host, logger *logrus.Logger) *WakeHost {
+	w := &WakeHost{
+		host:     h,
+		logger:   logger,
+		clients:  make(map[peer.ID]*wakeClient),
+		lastWake: make(map[peer.ID]time.Time),
+	}
+	h.SetStreamHandler(WakeProtocolID, w.handleStream)
+	return w
+}
+
+// Clients returns the number of registered clients
+func (w *WakeHost) Clients() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.clients)
+}
+
+// handleStream serves a registration or a wake request
+func (w *WakeHost) handleStream(s network.Stream) {
+	r := bufio.NewReaderSize(s, wakeMaxFrame)
+	_ = s.SetReadDeadline(time.Now().Add(wakeTimeout))
+	frame, err := readWakeFrame(r)
+	if err != nil {
+		_ = s.Reset()
+		return
+	}
+
+	switch frame.Type {
+	case wakeRegister:
+		w.serveClient(s, r)
+	case wakeRequest:
+		w.serveWake(s, frame)
+	default:
+		_ = s.Reset()
+	}
+}
+
+// serveClient holds a registration until the client goes silent or leaves
+func (w *WakeHost) serveClient(s network.Stream, r *bufio.Reader) {
+	p := s.Conn().RemotePeer()
+	client := &wakeClient{stream: s}
+
+	w.mu.Lock()
+	previous, replaced := w.clients[p]
+	if !replaced && len(w.clients) >= wakeMaxClients {
+		w.mu.Unlock()
+		_ = client.write(wakeFrame{Type: wakeRefused})
+		_ = s.Close()
+		return
+	}
+	w.clients[p] = client
+	w.mu.Unlock()
+	if replaced {
+		_ = previous.stream.Reset()
+	}
+
+	// The connection is what the client is woken over
+	w.host.ConnManager().Protect(p, "wake-client")
+	defer func() {
+		w.mu.Lock()
+		if w.clients[p] == client {
+			delete(w.clients, p)
+			delete(w.lastWake, p)
+			w.host.ConnManager().Unprotect(p, "wake-client")
+		}
+		w.mu.Unlock()
+		_ = s.Reset()
+	}()
+
+	if err := client.write(wakeFrame{Type: wakeRegistered}); err != nil {
+		return
+	}
+	w.logger.WithField("peer_id", p.String()).Debug("Wake client registered")
+
+	for {
+		_ = s.SetReadDeadline(time.Now().Add(wakeIdleTimeout))
+		frame, err := readWakeFrame(r)
+		if err != nil || frame.Type != wakePing {
+			return
+		}
+		if err := client.write(wakeFrame{Type: wakePong}); err != nil {
+			return
+		}
+	}
+}
+
+// serveWake passes a wake request on to the registered client, at most
+// once per wakeMinInterval
+func (w *WakeHost) serveWake(s network.Stream, frame *wakeFrame) {
+	defer func() { _ = s.Close() }()
+	_ = s.SetWriteDeadline(time.Now().Add(wakeTimeout))
+
+	target, err := peer.Decode(frame.Peer)
+	if err != nil {
+		_ = s.Reset()
+		return
+	}
+
+	w.mu.Lock()
+	client := w.clients[target]
+	recent := time.Since(w.lastWake[target]) < wakeMinInterval
+	if client != nil && !recent {
+		w.lastWake[target] = time.Now()
+	}
+	w.mu.Unlock()
+
+	if client == nil {
+		_ = writeWakeFrame(s, wakeFrame{Type: wakeUnknown})
+		return
+	}
+	if !recent {
+		from := s.Conn().RemotePeer()
+		if err := client.write(wakeFrame{Type: wakeRequest, From: from.String()}); err != nil {
+			_ = writeWakeFrame(s, wakeFrame{Type: wakeUnknown})
+			return
+		}
+		w.logger.WithFields(logrus.Fields{
+			"peer_id": target.String(),
+			"from":    from.String(),
+		}).Debug("Woke client")
+	}
+	_ = writeWakeFrame(s, wakeFrame{Type: wakeWoken})
+}
+
+// SendWake asks the wake host relay to wake target, and reports whether
+// target is registered there
+func SendWake(ctx context.Context, h host.Host, relay, target peer.ID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, wakeTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(ctx, relay, WakeProtocolID)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = s.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+
+	if err := writeWakeFrame(s, wakeFrame{Type: wakeRequest, Peer: target.String()}); err != nil {
+		return false, err
+	}
+	answer, err := readWakeFrame(bufio.NewReaderSize(s, wakeMaxFrame))
+	if err != nil {
+		return false, fmt.Errorf("failed to read wake answer: %w", err)
+	}
+	return answer.Type == wakeWoken, nil
+}
+
+// WakeClient keeps a registration with a wake host, so that peers can wake
+// this node while it sleeps behind a NAT
+type WakeClient struct {
+	host   host.Host
+	relay  peer.AddrInfo
+	logger *logrus.Logger
+
+	// OnWake is called with the peer that asked to wake this node
+	OnWake func(from peer.ID)
+
+	// Energy, if set, sends the keep-alives together with the other
+	// periodic work in battery saver mode
+	Energy *EnergyManager
+
+	mu         sync.Mutex
+	registered time.Time // When the current registration began, or zero
+}
+
+// NewWakeClient creates a client of the wake host at relay, a multiaddr
+// ending in /p2p/<peer ID>
+func NewWakeClient(h host.Host, relay string, logger *logrus.Logger) (*WakeClient, error) {
+	addr, err := ma.NewMultiaddr(relay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wake relay address: %w", err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("wake relay address needs a /p2p/ peer ID: %w", err)
+	}
+	return &WakeClient{host: h, relay: *info, logger: logger}, nil
+}
+
+// Relay returns the peer ID of the wake host
+func (c *WakeClient) Relay() peer.ID {
+	return c.relay.ID
+}
+
+// Registered reports whether the wake host holds the registration now
+func (c *WakeClient) Registered() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.registered.IsZero()
+}
+
+// Run registers with the wake host and registers again whenever the
+// registration is lost, backing off while the host cannot be reached,
+// until ctx ends
+func (c *WakeClient) Run(ctx context.Context) {
+	delay := wakeRetryDelay
+	for {
+		started := time.Now()
+		err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > WakeKeepAliveInterval {
+			delay = wakeRetryDelay
+		}
+		c.logger.WithError(err).WithField("retry_in", delay).Warn("Wake registration lost")
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, wakeMaxRetryDelay)
+	}
+}
+
+// session holds one registration until it is lost
+func (c *WakeClient) session(ctx context.Context) error {
+	connectCtx, cancel := context.WithTimeout(ctx, wakeTimeout)
+	defer cancel()
+	if err := c.host.Connect(connectCtx, c.relay); err != nil {
+		return fmt.Errorf("failed to reach wake host: %w", err)
+	}
+	c.host.ConnManager().Protect(c.relay.ID, "wake-relay")
+	defer c.host.ConnManager().Unprotect(c.relay.ID, "wake-relay")
+
+	s, err := c.host.NewStream(connectCtx, c.relay.ID, WakeProtocolID)
+	if err != nil {
+		return fmt.Errorf("failed to open wake stream: %w", err)
+	}
+	defer func() { _ = s.Reset() }()
+
+	r := bufio.NewReaderSize(s, wakeMaxFrame)
+	_ = s.SetDeadline(time.Now().Add(wakeTimeout))
+	if err := writeWakeFrame(s, wakeFrame{Type: wakeRegister}); err != nil {
+		return err
+	}
+	answer, err := readWakeFrame(r)
+	if err != nil {
+		return fmt.Errorf("failed to read wake registration answer: %w", err)
+	}
+	if answer.Type != wakeRegistered {
+		return ErrWakeRefused
+	}
+	_ = s.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	c.registered = time.Now()
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.registered = time.Time{}
+		c.mu.Unlock()
+	}()
+	c.logger.WithField("relay", c.relay.ID.String()).Info("Registered with wake host")
+
+	// Pings keep NAT mappings open; the pongs show the host is still there
+	done := make(chan struct{})
+	defer close(done)
+	pingCtx, stopPings := context.WithCancel(ctx)
+	defer stopPings()
+	go func() {
+		for {
+			select {
+			case <-c.Energy.KeepAliveAfter(pingCtx, WakeKeepAliveInterval):
+				_ = s.SetWriteDeadline(time.Now().Add(wakeTimeout))
+				if writeWakeFrame(s, wakeFrame{Type: wakePing}) != nil {
+					_ = s.Reset()
+					return
+				}
+			case <-ctx.Done():
+				_ = s.Reset()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		_ = s.SetReadDeadline(time.Now().Add(WakeKeepAliveInterval + wakeTimeout))
+		frame, err := readWakeFrame(r)
+		if err != nil {
+			return err
+		}
+		if frame.Type != wakeRequest {
+			continue
+		}
+		from, err := peer.Decode(frame.From)
+		if err != nil {
+			continue
+		}
+		c.logger.WithField("from", from.String()).Info("Woken by peer")
+		if c.OnWake != nil {
+			go c.OnWake(from)
+		}
+	}
+}
+
+// wakePeer asks the wake hosts this node is connected to to wake p, so it
+// comes online and fetches the messages queued for it. Peers are asked at
+// most once per wakeMinInterval.
+func (n *PeerChatNode) wakePeer(peerID string) {
+	target, err := peer.Decode(peerID)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	if time.Since(n.wakeSent[target]) < wakeMinInterval {
+		n.mu.Unlock()
+		return
+	}
+	n.wakeSent[target] = time.Now()
+	n.mu.Unlock()
+
+	for _, relay := range n.host.Network().Peers() {
+		if relay == target {
+			continue
+		}
+		if supported, err := n.host.Peerstore().SupportsProtocols(relay, WakeProtocolID); err != nil || len(supported) == 0 {
+			continue
+		}
+		woken, err := SendWake(n.ctx, n.host, relay, target)
+		if err != nil {
+			n.logger.WithError(err).WithField("relay", relay.String()).Debug("Wake request failed")
+			continue
+		}
+		if woken {
+			n.logger.WithFields(logrus.Fields{
+				"peer_id": peerID,
+				"relay":   relay.String(),
+			}).Info("Asked wake host to wake peer")
+			return
+		}
+	}
+}
+
+// answerWake connects to the peer that woke this node, which then delivers
+// the messages it queued
+func (n *PeerChatNode) answerWake(from peer.ID) {
+	if n.host.Network().Connectedness(from) == network.Connected {
+		return
+	}
+	ctx, cancel := context.WithTimeout(n.ctx, 30*time.Second)
+	defer cancel()
+	if err := n.host.Connect(ctx, peer.AddrInfo{ID: from}); err != nil {
+		n.logger.WithError(err).WithField("peer_id", from.String()).Warn("Failed to reach the peer that woke us")
+	}
+}
@@ -7,20 +7,67 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Xelvra/peerchat/internal/db"
 	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/user"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// LogFileName is the JSON log file in the data directory
+	LogFileName = "peerchat.log"
+
+	// LogBackups is how many rotated log files are kept, as LogFileName.1
+	// (the newest) to LogFileName.3
+	LogBackups = 3
+
+	// logTimestampFormat is how times are written to the log file
+	logTimestampFormat = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
 // P2PWrapper provides a safe interface to P2P functionality
 // It can fallback to simulation if real P2P fails
 type P2PWrapper struct {
 	useSimulation bool
 	realNode      *PeerChatNode
+	monitor       *ResourceMonitor
 	ctx           context.Context
 	logger        *logrus.Logger
+
+	allowDirectWithProxy bool
+	ephemeralDHTIdentity bool
+	fileStreams          int
+	noFileCompression    bool
+	noQUIC               bool
+	noTCP                bool
+	noMDNS               bool
+	noUDPBroadcast       bool
+	trace                string
+	quicMTU              string
+	wakeRelay            string
+	onMessageHook        string
+	serveWake            bool
+	diagnosticsPort      int
+	batterySaver         bool
+	storage              string
+	visibility           string
+	tor                  bool
+	torSOCKS             string
+	torControl           string
+	browserTransports    bool
+	maxConnections       int
+	listenAddrs          []string
+	announceAddrs        []string
+	swarmKey             pnet.PSK
+	bootstrapPeers       []peer.AddrInfo
+	rendezvous           []string
+	relays               []peer.AddrInfo
+	profile              string
 }
 
 // NodeInfo contains basic node information
@@ -46,7 +93,7 @@ func setupLogger() *logrus.Logger {
 	logger := logrus.New()
 
 	// Get home directory
-	home, err := os.UserHomeDir()
+	dataDir, err := user.DataDir()
 	if err != nil {
 		// Fallback to current directory if home not available
 		logger.SetOutput(os.Stderr)
@@ -54,14 +101,14 @@ func setupLogger() *logrus.Logger {
 	}
 
 	// Create .xelvra directory if it doesn't exist
-	xelvraDir := filepath.Join(home, ".xelvra")
+	xelvraDir := dataDir
 	if err := os.MkdirAll(xelvraDir, 0700); err != nil {
 		logger.SetOutput(os.Stderr)
 		return logger
 	}
 
 	// Setup log rotation
-	logFile := filepath.Join(xelvraDir, "peerchat.log")
+	logFile := filepath.Join(xelvraDir, LogFileName)
 	if err := rotateLogIfNeeded(logFile); err != nil {
 		// If rotation fails, continue with stderr
 		logger.SetOutput(os.Stderr)
@@ -77,7 +124,7 @@ func setupLogger() *logrus.Logger {
 
 	// Set JSON formatter for structured logging
 	logger.SetFormatter(&logrus.JSONFormatter{
-		TimestampFormat: "2006-01-02T15:04:05.000000000Z07:00",
+		TimestampFormat: logTimestampFormat,
 	})
 
 	logger.SetOutput(file)
@@ -86,6 +133,231 @@ func setupLogger() *logrus.Logger {
 	return logger
 }
 
+// AllowDirectWithProxy keeps LAN discovery and direct connections when a
+// SOCKS proxy is configured. It must be called before Start.
+func (w *P2PWrapper) AllowDirectWithProxy() {
+	w.allowDirectWithProxy = true
+}
+
+// UseEphemeralDHTIdentity runs the DHT under an identity of its own that
+// changes on every start. It must be called before Start.
+func (w *P2PWrapper) UseEphemeralDHTIdentity() {
+	w.ephemeralDHTIdentity = true
+}
+
+// SetFileStreams sets how many streams a large file may be split across;
+// 1 sends every file over a single stream. It must be called before Start.
+func (w *P2PWrapper) SetFileStreams(streams int) {
+	w.fileStreams = streams
+}
+
+// DisableFileCompression sends and receives file chunks uncompressed. It
+// must be called before Start.
+func (w *P2PWrapper) DisableFileCompression() {
+	w.noFileCompression = true
+}
+
+// DisableQUIC listens and dials over TCP only. It must be called before
+// Start.
+func (w *P2PWrapper) DisableQUIC() {
+	w.noQUIC = true
+}
+
+// DisableTCP listens and dials over QUIC only, where nothing else needs
+// TCP. It must be called before Start.
+func (w *P2PWrapper) DisableTCP() {
+	w.noTCP = true
+}
+
+// SetLANDiscovery turns mDNS and UDP broadcast discovery on or off, at once
+// if the node is running
+func (w *P2PWrapper) SetLANDiscovery(mdns, broadcast bool) {
+	w.noMDNS, w.noUDPBroadcast = !mdns, !broadcast
+	if w.realNode != nil {
+		w.realNode.SetLANDiscovery(mdns, broadcast)
+	}
+}
+
+// SetLogLevel logs entries of level and more severe ones only
+func (w *P2PWrapper) SetLogLevel(level logrus.Level) {
+	w.logger.SetLevel(level)
+}
+
+// SetLogFormat writes the log as LogFormatJSON or LogFormatText
+func (w *P2PWrapper) SetLogFormat(format string) {
+	if strings.EqualFold(format, LogFormatText) {
+		w.logger.SetFormatter(&logrus.TextFormatter{
+			DisableColors:   true,
+			FullTimestamp:   true,
+			TimestampFormat: logTimestampFormat,
+		})
+		return
+	}
+	w.logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: logTimestampFormat})
+}
+
+// logStreamHook passes each log entry, formatted as written to the log, to
+// a channel
+type logStreamHook struct {
+	ch chan<- string
+}
+
+// Levels implements logrus.Hook
+func (h *logStreamHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook. Entries are dropped while the channel is
+// full, so a slow reader never holds up the node.
+func (h *logStreamHook) Fire(entry *logrus.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	select {
+	case h.ch <- strings.TrimRight(line, "\n"):
+	default:
+	}
+	return nil
+}
+
+// StreamLog sends each entry written to the log to ch as it is written,
+// until the returned function is called
+func (w *P2PWrapper) StreamLog(ch chan<- string) func() {
+	hook := &logStreamHook{ch: ch}
+	w.logger.AddHook(hook)
+	return func() {
+		hooks := make(logrus.LevelHooks)
+		for level, levelHooks := range w.logger.Hooks {
+			for _, h := range levelHooks {
+				if h != hook {
+					hooks[level] = append(hooks[level], h)
+				}
+			}
+		}
+		w.logger.ReplaceHooks(hooks)
+	}
+}
+
+// SetQUICMTU sizes QUIC packets, QUICMTUAuto or QUICMTUSafe. It must be
+// called before Start.
+func (w *P2PWrapper) SetQUICMTU(mode string) {
+	w.quicMTU = mode
+}
+
+// SetTrace records timing spans of message hops to target, a trace file
+// path or an OTLP collector URL. It must be called before Start.
+func (w *P2PWrapper) SetTrace(target string) {
+	w.trace = target
+}
+
+// SetWakeRelay keeps the node registered with the wake host at addr, a
+// multiaddr ending in /p2p/<peer ID>. It must be called before Start.
+func (w *P2PWrapper) SetWakeRelay(addr string) {
+	w.wakeRelay = addr
+}
+
+// SetOnMessageHook runs the program at path for every text message
+// received. It must be called before Start.
+func (w *P2PWrapper) SetOnMessageHook(path string) {
+	w.onMessageHook = path
+}
+
+// ServeWake lets peers register with the node to be woken. It must be
+// called before Start.
+func (w *P2PWrapper) ServeWake() {
+	w.serveWake = true
+}
+
+// ServeDiagnostics serves the diagnostics endpoint on port for this run,
+// even if it is not enabled. It must be called before Start.
+func (w *P2PWrapper) ServeDiagnostics(port int) {
+	w.diagnosticsPort = port
+}
+
+// SetBatterySaver starts the node in battery saver mode. It must be
+// called before Start; ChangeBatterySaver changes it on a running node.
+func (w *P2PWrapper) SetBatterySaver(on bool) {
+	w.batterySaver = on
+}
+
+// SetStorage selects the storage backend by name, e.g. db.MemoryBackend to
+// keep nothing on disk. It must be called before Start.
+func (w *P2PWrapper) SetStorage(backend string) {
+	w.storage = backend
+}
+
+// SetVisibility sets who discovery announces the node to for this run,
+// instead of the level last saved. It must be called before Start.
+func (w *P2PWrapper) SetVisibility(level string) {
+	w.visibility = level
+}
+
+// EnableBrowserTransports listens for WebTransport and WebRTC connections
+// from browser clients. It must be called before Start.
+func (w *P2PWrapper) EnableBrowserTransports() {
+	w.browserTransports = true
+}
+
+// SetMaxConnections sets the high watermark of open connections, above
+// which idle connections to strangers are closed down to half as many. It
+// must be called before Start.
+func (w *P2PWrapper) SetMaxConnections(n int) {
+	w.maxConnections = n
+}
+
+// SetListenAddrs listens on addrs instead of random ports. It must be
+// called before Start.
+func (w *P2PWrapper) SetListenAddrs(addrs []string) {
+	w.listenAddrs = addrs
+}
+
+// SetAnnounceAddrs advertises addrs to peers besides the listen addresses.
+// It must be called before Start.
+func (w *P2PWrapper) SetAnnounceAddrs(addrs []string) {
+	w.announceAddrs = addrs
+}
+
+// SetBootstrapPeers joins the DHT through peers instead of the default
+// bootstrap peers, which are used only when none of peers can be reached.
+// It must be called before Start.
+func (w *P2PWrapper) SetBootstrapPeers(peers []peer.AddrInfo) {
+	w.bootstrapPeers = peers
+}
+
+// SetRendezvous sets the rendezvous keys the node announces and looks for
+// peers under. It must be called before Start.
+func (w *P2PWrapper) SetRendezvous(keys []string) {
+	w.rendezvous = keys
+}
+
+// SetRelays offers relays to reserve a slot with before any other relay
+// candidate. It must be called before Start.
+func (w *P2PWrapper) SetRelays(relays []peer.AddrInfo) {
+	w.relays = relays
+}
+
+// SetProfile records the name of the configuration profile in use, for the
+// status. It must be called before Start.
+func (w *P2PWrapper) SetProfile(name string) {
+	w.profile = name
+}
+
+// UsePrivateNetwork joins the private network of the swarm key psk instead
+// of the public one. It must be called before Start.
+func (w *P2PWrapper) UsePrivateNetwork(psk pnet.PSK) {
+	w.swarmKey = psk
+}
+
+// UseTor sends every connection through the Tor daemon with the SOCKS port
+// socksAddr and control port controlAddr, and makes the node reachable at
+// an onion service. It must be called before Start.
+func (w *P2PWrapper) UseTor(socksAddr, controlAddr string) {
+	w.tor = true
+	w.torSOCKS = socksAddr
+	w.torControl = controlAddr
+}
+
 // Start starts the P2P node (real or simulated)
 func (w *P2PWrapper) Start() error {
 	if w.useSimulation {
@@ -99,6 +371,9 @@ func (w *P2PWrapper) Stop() error {
 	if w.useSimulation {
 		return nil // Nothing to stop in simulation
 	}
+	if w.monitor != nil {
+		w.monitor.Stop()
+	}
 	if w.realNode != nil {
 		return w.realNode.Stop()
 	}
@@ -162,6 +437,29 @@ func (w *P2PWrapper) SendMessage(peerID, messageText string) error {
 	return w.realNode.SendMessage(peerID, []byte(messageText), message.MessageTypeText)
 }
 
+// SendNotice sends a structured system notice to a peer
+func (w *P2PWrapper) SendNotice(peerID string, notice *message.Notice) error {
+	if w.useSimulation {
+		return fmt.Errorf("notices cannot be sent in simulation mode")
+	}
+	if w.realNode == nil {
+		return fmt.Errorf("node not started")
+	}
+	return w.realNode.SendNotice(peerID, notice)
+}
+
+// ResetSession discards the encrypted sessions with a peer, runs a fresh
+// key agreement and notifies the peer. It returns the new session's ID.
+func (w *P2PWrapper) ResetSession(peerID string) (string, error) {
+	if w.useSimulation {
+		return "", fmt.Errorf("sessions cannot be reset in simulation mode")
+	}
+	if w.realNode == nil {
+		return "", fmt.Errorf("node not started")
+	}
+	return w.realNode.ResetSession(peerID)
+}
+
 // startSimulation starts simulation mode
 func (w *P2PWrapper) startSimulation() error {
 	// Simulate startup delay
@@ -173,7 +471,8 @@ func (w *P2PWrapper) startSimulation() error {
 func (w *P2PWrapper) startReal() error {
 	w.logger.Info("Attempting to start real P2P node...")
 
-	// Create context with timeout to prevent hanging
+	// Wait no longer than this for the node to start; the node itself lives
+	// on w.ctx, as its background tasks run until it stops
 	ctx, cancel := context.WithTimeout(w.ctx, 2*time.Second)
 	defer cancel()
 
@@ -181,6 +480,37 @@ func (w *P2PWrapper) startReal() error {
 	config := DefaultNodeConfig()
 	config.LogLevel = w.logger.Level // Use our log level
 	config.Logger = w.logger         // Use our file logger
+	config.AllowDirectWithProxy = w.allowDirectWithProxy
+	config.EphemeralDHTIdentity = w.ephemeralDHTIdentity
+	config.FileStreams = w.fileStreams
+	config.DisableFileCompression = w.noFileCompression
+	config.EnableQUIC = !w.noQUIC
+	config.EnableTCP = !w.noTCP
+	config.DisableMDNS = w.noMDNS
+	config.DisableUDPBroadcast = w.noUDPBroadcast
+	config.Trace = w.trace
+	config.QUICMTU = w.quicMTU
+	config.WakeRelay = w.wakeRelay
+	config.OnMessageHook = w.onMessageHook
+	config.ServeWake = w.serveWake
+	config.DiagnosticsPort = w.diagnosticsPort
+	config.BatterySaver = w.batterySaver
+	config.Storage = w.storage
+	config.Visibility = w.visibility
+	config.Tor = w.tor
+	config.TorSOCKS = w.torSOCKS
+	config.TorControl = w.torControl
+	config.BrowserTransports = w.browserTransports
+	config.ConnHighWater = w.maxConnections
+	if len(w.listenAddrs) > 0 {
+		config.ListenAddrs = w.listenAddrs
+	}
+	config.AnnounceAddrs = w.announceAddrs
+	config.SwarmKey = w.swarmKey
+	config.BootstrapPeers = w.bootstrapPeers
+	config.Rendezvous = w.rendezvous
+	config.Relays = w.relays
+	config.Profile = w.profile
 
 	// Use a channel to handle timeout
 	type result struct {
@@ -191,7 +521,7 @@ func (w *P2PWrapper) startReal() error {
 	resultChan := make(chan result, 1)
 
 	go func() {
-		node, err := NewPeerChatNode(ctx, config)
+		node, err := NewPeerChatNode(w.ctx, config)
 		resultChan <- result{node: node, err: err}
 	}()
 
@@ -220,6 +550,8 @@ func (w *P2PWrapper) startReal() error {
 			}
 			w.logger.Info("Real P2P node started successfully")
 			w.realNode = res.node
+			w.monitor = NewResourceMonitor(res.node.ReadResourceSample)
+			w.monitor.Start(w.ctx)
 			return nil
 		case <-ctx.Done():
 			w.logger.Warn("P2P node start timed out, falling back to simulation")
@@ -257,6 +589,127 @@ func (w *P2PWrapper) GetDiscoveredPeers() []string {
 	return result
 }
 
+// OnPeerDiscovered calls fn with each peer discovery finds for the first
+// time or at other addresses, until the returned function is called. fn is
+// called from the discovery goroutines and should return quickly. Nothing is
+// found in simulation mode or before the node starts.
+func (w *P2PWrapper) OnPeerDiscovered(fn func(DiscoveryEvent)) (cancel func()) {
+	return w.onDiscoveryEvent(func(event DiscoveryEvent) {
+		if event.Type != DiscoveryLost {
+			fn(event)
+		}
+	})
+}
+
+// OnPeerLost calls fn with each discovered peer neither connected nor found
+// again for DiscoveredPeerTTL, until the returned function is called
+func (w *P2PWrapper) OnPeerLost(fn func(DiscoveryEvent)) (cancel func()) {
+	return w.onDiscoveryEvent(func(event DiscoveryEvent) {
+		if event.Type == DiscoveryLost {
+			fn(event)
+		}
+	})
+}
+
+// onDiscoveryEvent subscribes fn to the discovery events of the running node
+func (w *P2PWrapper) onDiscoveryEvent(fn func(DiscoveryEvent)) func() {
+	if w.useSimulation || w.realNode == nil || w.realNode.discoveryManager == nil {
+		return func() {}
+	}
+	return w.realNode.discoveryManager.OnDiscoveryEvent(fn)
+}
+
+// ScanSubnet probes an IPv4 subnet such as 192.168.50.0/24 on the discovery
+// port, for networks that filter multicast, and connects to the nodes found
+func (w *P2PWrapper) ScanSubnet(ctx context.Context, cidr string) ([]peer.AddrInfo, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot scan subnets in simulation mode")
+	}
+	if w.realNode == nil || w.realNode.discoveryManager == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+	subnet, err := ParseScanSubnet(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return w.realNode.discoveryManager.ScanSubnet(ctx, subnet)
+}
+
+// PeerViaRelay reports whether every connection to a peer goes through a
+// circuit relay
+func (w *P2PWrapper) PeerViaRelay(peerID string) bool {
+	if w.useSimulation || w.realNode == nil {
+		return false
+	}
+	p, err := peer.Decode(peerID)
+	if err != nil {
+		return false
+	}
+	return onlyRelayed(w.realNode.host, p)
+}
+
+// PeerTraffic returns the traffic exchanged with a peer, across runs
+func (w *P2PWrapper) PeerTraffic(peerIDStr string) (PeerTraffic, error) {
+	if w.useSimulation || w.realNode == nil {
+		return PeerTraffic{}, fmt.Errorf("node not started")
+	}
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return PeerTraffic{}, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	return w.realNode.PeerTraffic(peerID), nil
+}
+
+// PeerQuality returns the quality of the connections to a peer
+func (w *P2PWrapper) PeerQuality(peerIDStr string) (PeerQuality, error) {
+	if w.useSimulation || w.realNode == nil {
+		return PeerQuality{}, fmt.Errorf("node not started")
+	}
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return PeerQuality{}, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	return w.realNode.PeerQuality(peerID), nil
+}
+
+// PeerCapabilities returns the protocol version and features agreed with a
+// connected peer
+func (w *P2PWrapper) PeerCapabilities(peerIDStr string) (*message.PeerCapabilities, error) {
+	if w.useSimulation || w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	return w.realNode.messageManager.PeerCapabilities(peerID)
+}
+
+// ResourceStats returns the latest sample of the node's resource use taken
+// by the wrapper's monitor, with throughput since the sample before it
+func (w *P2PWrapper) ResourceStats() (ResourceSample, error) {
+	if w.useSimulation || w.monitor == nil {
+		return ResourceSample{}, fmt.Errorf("node not started")
+	}
+	if sample, ok := w.monitor.Latest(); ok {
+		return sample, nil
+	}
+	return w.monitor.Sample(), nil
+}
+
+// Ping measures the round trip time to a connected peer with count probes
+// sent interval apart, calling onReply with the outcome of each
+func (w *P2PWrapper) Ping(peerIDStr string, count int, interval time.Duration, onReply func(PingReply)) (*PingStats, error) {
+	if w.useSimulation || w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	return Ping(w.ctx, w.realNode.host, peerID, count, interval, onReply)
+}
+
 // GetConnectedPeers returns list of currently connected peers
 func (w *P2PWrapper) GetConnectedPeers() []string {
 	if w.useSimulation {
@@ -310,7 +763,7 @@ func (w *P2PWrapper) ConnectToPeer(peerIDStr string) bool {
 	ctx, cancel := context.WithTimeout(w.ctx, 10*time.Second)
 	defer cancel()
 
-	if err := w.realNode.host.Connect(ctx, peerInfo); err != nil {
+	if err := w.realNode.Connect(ctx, peerInfo); err != nil {
 		w.logger.WithError(err).WithField("peer_id", peerIDStr).Error("Failed to connect to peer")
 		return false
 	}
@@ -319,28 +772,597 @@ func (w *P2PWrapper) ConnectToPeer(peerIDStr string) bool {
 	return true
 }
 
-// SendMessageToMultiplePeers sends a message to specified peers
-func (w *P2PWrapper) SendMessageToMultiplePeers(message string, peerIDs []string) bool {
+// IdentityURI returns the link others scan or paste to reach the running
+// node
+func (w *P2PWrapper) IdentityURI() (*IdentityURI, error) {
 	if w.useSimulation {
-		return false // Cannot send in simulation
+		return nil, fmt.Errorf("no identity link in simulation mode")
+	}
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+	return NewIdentityURI(w.realNode.GetPeerID(), w.realNode.GetIdentity().GetDID(), w.realNode.GetHost().Addrs()), nil
+}
+
+// ConnectURI connects to the peer of an identity link, at the addresses in
+// the link or, without any, those discovery found
+func (w *P2PWrapper) ConnectURI(u *IdentityURI) error {
+	if w.useSimulation {
+		return fmt.Errorf("cannot connect in simulation mode")
+	}
+	if w.realNode == nil {
+		return fmt.Errorf("node not started")
+	}
+
+	info := u.AddrInfo()
+	if len(info.Addrs) == 0 {
+		info.Addrs = w.realNode.discoveryManager.GetPeerAddresses(info.ID)
+	}
+	ctx, cancel := context.WithTimeout(w.ctx, 30*time.Second)
+	defer cancel()
+	if err := w.realNode.Connect(ctx, info); err != nil {
+		return err
+	}
+
+	w.logger.WithField("peer_id", info.ID.String()).Info("Connected to peer via identity link")
+	return nil
+}
+
+// RedeemInvite redeems a one-time invite code and connects to the inviting peer.
+// It returns the revealed peer ID on success.
+func (w *P2PWrapper) RedeemInvite(code string) (string, error) {
+	if w.useSimulation {
+		return "", fmt.Errorf("cannot redeem invites in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return "", fmt.Errorf("node not started")
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, 30*time.Second)
+	defer cancel()
+
+	info, did, err := w.realNode.RedeemInvite(ctx, code)
+	if err != nil {
+		w.logger.WithError(err).Warn("Failed to redeem invite")
+		return "", err
+	}
+
+	w.logger.WithFields(logrus.Fields{
+		"peer_id": info.ID.String(),
+		"did":     did,
+	}).Info("Connected to peer via invite")
+	return info.ID.String(), nil
+}
+
+// JoinInvite connects to the inviter of an invite link, redeeming its
+// one-time token if it has one, and returns the inviter's peer ID and DID
+func (w *P2PWrapper) JoinInvite(u *InviteURI) (string, string, error) {
+	if w.useSimulation {
+		return "", "", fmt.Errorf("cannot join invites in simulation mode")
+	}
+	if w.realNode == nil {
+		return "", "", fmt.Errorf("node not started")
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, 30*time.Second)
+	defer cancel()
+
+	peerID, did, err := w.realNode.JoinInvite(ctx, u)
+	if err != nil {
+		w.logger.WithError(err).Warn("Failed to join invite")
+		return "", "", err
+	}
+
+	w.logger.WithFields(logrus.Fields{
+		"peer_id": peerID.String(),
+		"did":     did,
+	}).Info("Connected to peer via invite link")
+	return peerID.String(), did, nil
+}
+
+// RegisterName claims a nickname for this node in the DHT
+func (w *P2PWrapper) RegisterName(name string) (*NameRecord, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot register names in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, time.Minute)
+	defer cancel()
+
+	return w.realNode.RegisterName(ctx, name)
+}
+
+// ResolveName looks up the owner of a nickname in the DHT
+func (w *P2PWrapper) ResolveName(name string) (*NameRecord, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot resolve names in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, 30*time.Second)
+	defer cancel()
+
+	return w.realNode.ResolveName(ctx, name)
+}
+
+// ResolvePeer turns a message target into a peer ID. Targets starting with
+// '@' are resolved as nicknames; anything else is treated as a peer ID.
+func (w *P2PWrapper) ResolvePeer(target string) (string, error) {
+	if !strings.HasPrefix(target, "@") {
+		return target, nil
+	}
+
+	rec, err := w.ResolveName(target)
+	if err != nil {
+		return "", err
+	}
+	return rec.PeerID, nil
+}
+
+// PinTransport restricts the conversation with a peer to a transport policy
+func (w *P2PWrapper) PinTransport(peerIDStr string, policy TransportPolicy) error {
+	if w.useSimulation {
+		return fmt.Errorf("cannot pin transports in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return fmt.Errorf("node not started")
+	}
+
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	return w.realNode.SetTransportPin(peerID, policy)
+}
+
+// ChangeVisibility changes who discovery announces the running node to, and
+// keeps the level for later starts
+func (w *P2PWrapper) ChangeVisibility(v Visibility) error {
+	if w.useSimulation {
+		return fmt.Errorf("cannot change visibility in simulation mode")
 	}
 
 	if w.realNode == nil {
+		return fmt.Errorf("node not started")
+	}
+
+	return w.realNode.SetVisibility(v)
+}
+
+// Visibility returns who discovery announces the node to, empty when no
+// node is running
+func (w *P2PWrapper) Visibility() Visibility {
+	if w.useSimulation || w.realNode == nil {
+		return ""
+	}
+	return w.realNode.Visibility()
+}
+
+// ChangeBatterySaver turns battery saver mode of the running node on or off
+func (w *P2PWrapper) ChangeBatterySaver(on bool) error {
+	if w.useSimulation {
+		return fmt.Errorf("cannot change battery saver mode in simulation mode")
+	}
+	if w.realNode == nil {
+		return fmt.Errorf("node not started")
+	}
+	w.realNode.SetBatterySaver(on)
+	return nil
+}
+
+// EnergyProfile returns the estimated power use and battery saver state of
+// the running node, or nil when no node is running
+func (w *P2PWrapper) EnergyProfile() *EnergyProfile {
+	if w.useSimulation || w.realNode == nil {
+		return nil
+	}
+	return w.realNode.GetEnergyProfile()
+}
+
+// JoinRendezvous announces the running node under a rendezvous key and
+// looks for the peers announced under it
+func (w *P2PWrapper) JoinRendezvous(key string) error {
+	if w.useSimulation {
+		return fmt.Errorf("cannot join rendezvous in simulation mode")
+	}
+	if w.realNode == nil {
+		return fmt.Errorf("node not started")
+	}
+	return w.realNode.JoinRendezvous(key)
+}
+
+// LeaveRendezvous stops announcing the running node under a rendezvous key,
+// reporting whether it had joined it
+func (w *P2PWrapper) LeaveRendezvous(key string) bool {
+	if w.useSimulation || w.realNode == nil {
 		return false
 	}
+	return w.realNode.LeaveRendezvous(key)
+}
 
-	success := true
-	for _, peerIDStr := range peerIDs {
-		// Use the existing SendMessage method
-		if err := w.SendMessage(peerIDStr, message); err != nil {
-			w.logger.WithError(err).WithField("peer_id", peerIDStr).Error("Failed to send message")
-			success = false
-		} else {
-			w.logger.WithField("peer_id", peerIDStr).WithField("message", message).Info("Message sent successfully")
-		}
+// Rendezvous returns the rendezvous keys the running node has joined
+func (w *P2PWrapper) Rendezvous() []string {
+	if w.useSimulation || w.realNode == nil {
+		return nil
+	}
+	return w.realNode.Rendezvous()
+}
+
+// FindRendezvous looks up the peers announced under a rendezvous key and
+// connects to them
+func (w *P2PWrapper) FindRendezvous(key string) ([]peer.AddrInfo, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot search rendezvous in simulation mode")
+	}
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+	return w.realNode.FindRendezvous(key)
+}
+
+// SyncDirectory sends a directory to a peer, transferring only files the
+// peer does not already have
+func (w *P2PWrapper) SyncDirectory(peerIDStr, dir string) (*message.DirSyncResult, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot transfer directories in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	return w.realNode.SendDirectory(peerID, dir)
+}
+
+// SendFile sends a single file to a peer
+func (w *P2PWrapper) SendFile(peerIDStr, filePath string) error {
+	if w.useSimulation {
+		return fmt.Errorf("cannot transfer files in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return fmt.Errorf("node not started")
+	}
+
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	return w.realNode.SendFile(peerID, filePath)
+}
+
+// QueueFile queues a file for a peer. It is sent now if the peer is
+// connected, otherwise once it connects, and survives restarts.
+func (w *P2PWrapper) QueueFile(peerIDStr, filePath string) (*message.QueuedTransfer, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot transfer files in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	return w.realNode.QueueFile(peerID, filePath)
+}
+
+// QueuedTransfers returns the files waiting to be sent
+func (w *P2PWrapper) QueuedTransfers() []*message.QueuedTransfer {
+	if w.useSimulation || w.realNode == nil {
+		return nil
+	}
+	return w.realNode.messageManager.QueuedTransfers()
+}
+
+// OutboxDrained returns a channel that is closed once every message sent
+// was delivered or queued for offline delivery
+func (w *P2PWrapper) OutboxDrained() <-chan struct{} {
+	if w.useSimulation || w.realNode == nil {
+		drained := make(chan struct{})
+		close(drained)
+		return drained
+	}
+	return w.realNode.messageManager.OutboxDrained()
+}
+
+// SetFileOfferHandler announces files offered by peers that are not on the
+// auto-accept allowlist to handler, and keeps them waiting for
+// AnswerFileOffer instead of refusing them
+func (w *P2PWrapper) SetFileOfferHandler(handler func(offer *message.FileOffer)) {
+	if w.useSimulation || w.realNode == nil {
+		return
+	}
+	w.realNode.messageManager.SetFileOfferHandler(handler)
+}
+
+// SetMessageListener hands the text messages received to listener with the
+// peer that sent them, instead of printing them
+func (w *P2PWrapper) SetMessageListener(listener func(peerID string, msg *message.Message)) {
+	if w.useSimulation || w.realNode == nil {
+		return
+	}
+	w.realNode.messageManager.SetMessageListener(listener)
+}
+
+// AnswerFileOffer accepts or rejects a waiting file offer by number; 0
+// answers the only one
+func (w *P2PWrapper) AnswerFileOffer(number int, accept bool) (*message.FileOffer, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot receive files in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	return w.realNode.messageManager.AnswerFileOffer(number, accept)
+}
+
+// FileOffers returns the file offers waiting for an answer
+func (w *P2PWrapper) FileOffers() []*message.FileOffer {
+	if w.useSimulation || w.realNode == nil {
+		return nil
+	}
+	return w.realNode.messageManager.FileOffers()
+}
+
+// Transfers returns the node's file transfers in either direction
+func (w *P2PWrapper) Transfers() []*message.FileTransfer {
+	if w.useSimulation || w.realNode == nil {
+		return nil
+	}
+	return w.realNode.messageManager.ListTransfers()
+}
+
+// ControlTransfer pauses, resumes or cancels a file transfer by ID
+func (w *P2PWrapper) ControlTransfer(id, action string) (*message.FileTransfer, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("cannot transfer files in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	return w.realNode.messageManager.ControlTransfer(id, action)
+}
+
+// FetchProfile asks a peer for the profile facet it discloses to us
+func (w *P2PWrapper) FetchProfile(peerIDStr string) (*user.PublicProfile, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("profiles are not available in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	return w.realNode.messageManager.FetchProfile(peerID)
+}
+
+// BackupNow backs the node up to the friend named in the backup settings
+func (w *P2PWrapper) BackupNow() (*message.BackupSettings, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("backups are not available in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	return w.realNode.BackupNow()
+}
+
+// FetchBackup fetches and decrypts the backup a recovery seed names from
+// the peer holding it
+func (w *P2PWrapper) FetchBackup(peerIDStr string, seed []byte) ([]byte, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("backups are not available in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	peerID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	return w.realNode.FetchBackup(peerID, seed)
+}
+
+// QueryHistory returns messages from the node's encrypted history
+func (w *P2PWrapper) QueryHistory(query db.HistoryQuery) ([]*db.HistoryEntry, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("message history is not available in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	history := w.realNode.GetHistory()
+	if history == nil {
+		return nil, fmt.Errorf("message history is disabled")
+	}
+
+	return history.QueryHistory(query)
+}
+
+// StarMessage stars or unstars a message in the node's encrypted history,
+// returning its full ID
+func (w *P2PWrapper) StarMessage(id string, starred bool) (string, error) {
+	if w.useSimulation {
+		return "", fmt.Errorf("message history is not available in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return "", fmt.Errorf("node not started")
+	}
+
+	history := w.realNode.GetHistory()
+	if history == nil {
+		return "", fmt.Errorf("message history is disabled")
+	}
+
+	return history.StarMessage(id, starred)
+}
+
+// SearchHistory searches text messages in the node's encrypted history
+func (w *P2PWrapper) SearchHistory(query db.SearchQuery) ([]*db.SearchResult, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("message history is not available in simulation mode")
+	}
+
+	if w.realNode == nil {
+		return nil, fmt.Errorf("node not started")
 	}
 
-	return success
+	history := w.realNode.GetHistory()
+	if history == nil {
+		return nil, fmt.Errorf("message history is disabled")
+	}
+
+	return history.SearchHistory(query)
+}
+
+// contactBook returns the contact book of the running node
+func (w *P2PWrapper) contactBook() (*user.ContactBook, error) {
+	if w.useSimulation {
+		return nil, fmt.Errorf("contacts are not available in simulation mode")
+	}
+	if w.realNode == nil || w.realNode.GetContactBook() == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+	return w.realNode.GetContactBook(), nil
+}
+
+// AddContact pins a peer's current identity key under a name. The
+// returned contact needs verification if the name was pinned to another key.
+func (w *P2PWrapper) AddContact(name, peerID string) (*user.Contact, error) {
+	return w.AddContactWithDID(name, peerID, "")
+}
+
+// AddContactWithDID pins a peer's current identity key under a name,
+// recording the DID it shared
+func (w *P2PWrapper) AddContactWithDID(name, peerID, did string) (*user.Contact, error) {
+	contacts, err := w.contactBook()
+	if err != nil {
+		return nil, err
+	}
+	return contacts.Add(name, peerID, did)
+}
+
+// ListContacts returns all saved contacts
+func (w *P2PWrapper) ListContacts() []*user.Contact {
+	contacts, err := w.contactBook()
+	if err != nil {
+		return nil
+	}
+	return contacts.List()
+}
+
+// SafetyNumber returns the safety number for the conversation with a contact
+func (w *P2PWrapper) SafetyNumber(name string) (string, error) {
+	contacts, err := w.contactBook()
+	if err != nil {
+		return "", err
+	}
+
+	contact, ok := contacts.Get(name)
+	if !ok {
+		return "", fmt.Errorf("contact not found: %s", name)
+	}
+
+	return user.SafetyNumber(w.realNode.GetPeerID().String(), contact.PeerID)
+}
+
+// VerifyContact marks a contact's current key as verified
+func (w *P2PWrapper) VerifyContact(name string) error {
+	contacts, err := w.contactBook()
+	if err != nil {
+		return err
+	}
+	return contacts.Verify(name)
+}
+
+// ContactPolicy returns the policy of a contact
+func (w *P2PWrapper) ContactPolicy(name string) (user.ContactPolicy, error) {
+	contacts, err := w.contactBook()
+	if err != nil {
+		return user.ContactPolicy{}, err
+	}
+	contact, ok := contacts.Get(name)
+	if !ok {
+		return user.ContactPolicy{}, fmt.Errorf("contact not found: %s", name)
+	}
+	return contact.EffectivePolicy(), nil
+}
+
+// SetContactPolicy replaces the policy of a contact; the message manager
+// applies it to the next message or file
+func (w *P2PWrapper) SetContactPolicy(name string, policy user.ContactPolicy) error {
+	contacts, err := w.contactBook()
+	if err != nil {
+		return err
+	}
+	_, err = contacts.SetPolicy(name, policy)
+	return err
+}
+
+// CheckSendAllowed returns an error if a contact's key changed and must be
+// re-verified before messages can be sent to the peer
+func (w *P2PWrapper) CheckSendAllowed(peerID string) error {
+	contacts, err := w.contactBook()
+	if err != nil {
+		return nil
+	}
+	return contacts.CheckSendAllowed(peerID)
+}
+
+// SendMessageToMultiplePeers sends a message to specified peers. The body
+// is encrypted once for all of them; see MessageManager.SendMessageToPeers.
+func (w *P2PWrapper) SendMessageToMultiplePeers(messageText string, peerIDs []string) bool {
+	if w.useSimulation {
+		return false // Cannot send in simulation
+	}
+
+	if w.realNode == nil {
+		return false
+	}
+
+	if err := w.realNode.SendMessageToPeers(peerIDs, []byte(messageText), message.MessageTypeText); err != nil {
+		w.logger.WithError(err).Error("Failed to send message")
+		return false
+	}
+	w.logger.WithField("peers", len(peerIDs)).WithField("message", messageText).Info("Message sent successfully")
+	return true
 }
 
 // rotateLogIfNeeded checks if log rotation is needed and performs it
@@ -400,7 +1422,7 @@ func countLines(filename string) (int, error) {
 
 // performLogRotation rotates log files (keeps 3 old versions)
 func performLogRotation(logFile string) error {
-	const maxBackups = 3
+	const maxBackups = LogBackups
 
 	// Remove oldest backup if it exists
 	oldestBackup := logFile + "." + strconv.Itoa(maxBackups)
@@ -419,7 +1441,7 @@ func performLogRotation(logFile string) error {
 		if _, err := os.Stat(oldName); err == nil {
 			if err := os.Rename(oldName, newName); err != nil {
 				// Log error but continue with rotation
-				fmt.Printf("Warning: Failed to rotate log backup %s to %s: %v\n", oldName, newName, err)
+				fmt.Fprintf(os.Stderr, "Warning: Failed to rotate log backup %s to %s: %v\n", oldName, newName, err)
 			}
 		}
 	}
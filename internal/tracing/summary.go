@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"sort"
+	"time"
+)
+
+// SpanStats summarizes the spans of one name
+type SpanStats struct {
+	Name   string
+	Count  int
+	Errors int
+	Mean   time.Duration
+	P50    time.Duration
+	P95    time.Duration
+	Max    time.Duration
+
+	offset time.Duration // Mean start relative to the start of its trace
+}
+
+// Summarize returns timing statistics per span name, in the order the
+// spans usually start within their traces, so the hops of a message read
+// top to bottom
+func Summarize(records []SpanRecord) []*SpanStats {
+	traceStart := make(map[string]time.Time)
+	for _, record := range records {
+		if start, ok := traceStart[record.TraceID]; !ok || record.Start.Before(start) {
+			traceStart[record.TraceID] = record.Start
+		}
+	}
+
+	byName := make(map[string]*SpanStats)
+	durations := make(map[string][]time.Duration)
+	offsets := make(map[string]time.Duration)
+	for _, record := range records {
+		stats, ok := byName[record.Name]
+		if !ok {
+			stats = &SpanStats{Name: record.Name}
+			byName[record.Name] = stats
+		}
+		stats.Count++
+		if record.Error != "" {
+			stats.Errors++
+		}
+		durations[record.Name] = append(durations[record.Name], time.Duration(record.DurationMS*float64(time.Millisecond)))
+		offsets[record.Name] += record.Start.Sub(traceStart[record.TraceID])
+	}
+
+	summary := make([]*SpanStats, 0, len(byName))
+	for name, stats := range byName {
+		d := durations[name]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		var total time.Duration
+		for _, v := range d {
+			total += v
+		}
+		stats.Mean = total / time.Duration(len(d))
+		stats.P50 = percentile(d, 50)
+		stats.P95 = percentile(d, 95)
+		stats.Max = d[len(d)-1]
+		stats.offset = offsets[name] / time.Duration(stats.Count)
+		summary = append(summary, stats)
+	}
+
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].offset != summary[j].offset {
+			return summary[i].offset < summary[j].offset
+		}
+		return summary[i].Name < summary[j].Name
+	})
+	return summary
+}
+
+// percentile returns the p-th percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	index := (len(sorted)*p + 99) / 100
+	return sorted[max(index-1, 0)]
+}
@@ -0,0 +1,312 @@
+// Package tracing records timing spans for work done inside the node, such
+// as the hops of a message send, and exports them to a local trace file or
+// an OTLP collector. Spans follow the OpenTelemetry model: every span
+// belongs to a trace, has a parent unless it is the root, and carries a
+// start, an end and attributes.
+//
+// A nil *Tracer and a nil *Span are valid and do nothing, so instrumented
+// code costs next to nothing while tracing is off.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ServiceName identifies the node in exported traces
+	ServiceName = "xelvra-peerchat"
+
+	// queueSize is how many finished spans wait for export before new ones
+	// are dropped; tracing never slows down the traced work
+	queueSize = 4096
+
+	// batchSize is the most spans exported at once
+	batchSize = 256
+
+	// flushInterval is how often finished spans are exported
+	flushInterval = time.Second
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the ID as lowercase hex
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// String returns the ID as lowercase hex
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsZero reports whether the ID is unset
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+// SpanContext is the part of a span carried to other nodes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// TraceParent returns the context as a W3C traceparent value
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceParent parses a W3C traceparent value
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return sc, false
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, false
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	if sc.TraceID == (TraceID{}) || sc.SpanID.IsZero() {
+		return sc, false
+	}
+	return sc, true
+}
+
+// Span is one timed step of a trace
+type Span struct {
+	tracer *Tracer
+
+	Name    string
+	Context SpanContext
+	Parent  SpanID
+	Remote  bool // The parent span is on another node
+	Start   time.Time
+	End     time.Time
+
+	mu         sync.Mutex
+	attributes map[string]interface{}
+	err        string
+	ended      bool
+}
+
+// Tracer creates spans and exports them once they end
+type Tracer struct {
+	exporter Exporter
+	logger   *logrus.Logger
+
+	queue   chan *Span
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	droppedMu sync.Mutex
+	dropped   int
+}
+
+// New creates a tracer that exports finished spans in the background
+func New(exporter Exporter, logger *logrus.Logger) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		logger:   logger,
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start begins a span. With a nil parent the span starts a new trace.
+func (t *Tracer) Start(parent *Span, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{tracer: t, Name: name, Start: time.Now()}
+	if parent != nil {
+		span.Context.TraceID = parent.Context.TraceID
+		span.Parent = parent.Context.SpanID
+	} else {
+		_, _ = rand.Read(span.Context.TraceID[:])
+	}
+	_, _ = rand.Read(span.Context.SpanID[:])
+	return span
+}
+
+// StartRemote begins a span whose parent is on another node
+func (t *Tracer) StartRemote(parent SpanContext, name string) *Span {
+	if t == nil {
+		return nil
+	}
+	span := t.Start(nil, name)
+	span.Context.TraceID = parent.TraceID
+	span.Parent = parent.SpanID
+	span.Remote = true
+	return span
+}
+
+// Close exports the spans that have ended and closes the exporter
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.once.Do(func() { close(t.done) })
+	<-t.stopped
+	return t.exporter.Close()
+}
+
+// Dropped returns how many spans were dropped because the export queue was
+// full
+func (t *Tracer) Dropped() int {
+	if t == nil {
+		return 0
+	}
+	t.droppedMu.Lock()
+	defer t.droppedMu.Unlock()
+	return t.dropped
+}
+
+// finish queues an ended span for export
+func (t *Tracer) finish(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		t.droppedMu.Lock()
+		t.dropped++
+		t.droppedMu.Unlock()
+	}
+}
+
+// run exports queued spans in batches until the tracer is closed
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil && t.logger != nil {
+			t.logger.WithError(err).WithField("spans", len(batch)).Warn("Failed to export trace spans")
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Child begins a span under s
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(s, name)
+}
+
+// SetAttribute records a string, integer, float or boolean attribute
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// Attributes returns a copy of the span's attributes
+func (s *Span) Attributes() map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attributes := make(map[string]interface{}, len(s.attributes))
+	for key, value := range s.attributes {
+		attributes[key] = value
+	}
+	return attributes
+}
+
+// SetError marks the span as failed; a nil error does nothing
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// Err returns the error the span failed with, or ""
+func (s *Span) Err() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Duration returns how long the span took
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// SpanContext returns the context to carry to other nodes
+func (s *Span) SpanContext() (SpanContext, bool) {
+	if s == nil {
+		return SpanContext{}, false
+	}
+	return s.Context, true
+}
+
+// Finish ends the span and queues it for export. Only the first call counts.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	s.tracer.finish(s)
+}
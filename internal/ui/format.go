@@ -0,0 +1,148 @@
+package ui
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind is what a line of output reports, told by the emoji it starts with
+type Kind int
+
+// Kinds of lines
+const (
+	KindPlain Kind = iota
+	KindError
+	KindWarning
+	KindSuccess
+	KindHint
+	KindHeading
+)
+
+const (
+	// variationSelector asks for the emoji form of the symbol before it,
+	// as in ⚠️
+	variationSelector = '\uFE0F'
+
+	// zeroWidthJoiner joins emoji into one, as in 🏳️‍🌈
+	zeroWidthJoiner = '\u200D'
+)
+
+// markers are the emoji of the kinds of lines, and the words shown for them
+// without emoji
+var markers = []struct {
+	emoji string
+	kind  Kind
+	word  string
+}{
+	{"❌", KindError, "Error:"},
+	{"⚠", KindWarning, "Warning:"},
+	{"✅", KindSuccess, ""},
+	{"💡", KindHint, "Hint:"},
+}
+
+// Formatter rewrites lines of output
+type Formatter struct {
+	Theme Theme
+	Color bool // Color lines by kind
+	Emoji bool // Keep emoji; without, status emoji become words and others are dropped
+}
+
+// Active reports whether the formatter changes any output
+func (f Formatter) Active() bool {
+	return f.Color || !f.Emoji
+}
+
+// Line formats one line of output, with or without its newline
+func (f Formatter) Line(line string) string {
+	body, newline := strings.CutSuffix(line, "\n")
+	trimmed := strings.TrimLeft(body, " \t")
+	indent := body[:len(body)-len(trimmed)]
+
+	emoji, rest := leadingEmoji(trimmed)
+	if emoji == "" {
+		return line
+	}
+	kind := classify(emoji, rest)
+
+	if !f.Emoji {
+		rest = strings.TrimLeft(rest, " ")
+		if word := markerWord(emoji); word != "" {
+			rest = word + " " + rest
+		}
+		emoji = ""
+	}
+	body = indent + emoji + rest
+
+	if style := f.Theme.style(kind); f.Color && style != "" {
+		body = "\033[" + style + "m" + body + "\033[0m"
+	}
+	if newline {
+		body += "\n"
+	}
+	return body
+}
+
+// classify tells the kind of a line from its leading emoji and the rest.
+// Lines of other emoji ending in a colon introduce a list.
+func classify(emoji, rest string) Kind {
+	for _, m := range markers {
+		if strings.HasPrefix(emoji, m.emoji) {
+			return m.kind
+		}
+	}
+	if strings.HasSuffix(strings.TrimSpace(rest), ":") {
+		return KindHeading
+	}
+	return KindPlain
+}
+
+// markerWord returns the word shown for emoji without emoji, if any
+func markerWord(emoji string) string {
+	for _, m := range markers {
+		if strings.HasPrefix(emoji, m.emoji) {
+			return m.word
+		}
+	}
+	return ""
+}
+
+// leadingEmoji splits an emoji, with its variation selectors and joined
+// emoji, off the start of s
+func leadingEmoji(s string) (string, string) {
+	r, size := utf8.DecodeRuneInString(s)
+	if !isEmoji(r) {
+		return "", s
+	}
+	end := size
+	for end < len(s) {
+		r, size = utf8.DecodeRuneInString(s[end:])
+		switch {
+		case r == variationSelector:
+			end += size
+		case r == zeroWidthJoiner:
+			end += size
+			if next, n := utf8.DecodeRuneInString(s[end:]); isEmoji(next) {
+				end += n
+			}
+		default:
+			return s[:end], s[end:]
+		}
+	}
+	return s[:end], s[end:]
+}
+
+// isEmoji reports whether r is a pictograph the CLI starts lines with.
+// Geometric shapes such as ● are left alone, as they carry meaning in lists.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		return true
+	case r >= 0x2300 && r <= 0x23FF: // ⏰ ⏱
+		return true
+	case r >= 0x2600 && r <= 0x27BF: // ⚠ ✅ ❌
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF: // ⭐
+		return true
+	}
+	return false
+}
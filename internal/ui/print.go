@@ -0,0 +1,183 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	mu sync.Mutex
+
+	// current formats everything the helpers print; by default output is
+	// left as it is
+	current = Formatter{Emoji: true}
+
+	// output receives what the helpers print instead of stdout, unformatted
+	output io.Writer
+
+	// stdout, if set, receives what the helpers print in place of stdout
+	// when nothing captures it
+	stdout io.Writer
+)
+
+// Configure sets how the helpers format what they print, following the
+// theme and the color and emoji settings of the command
+func Configure(format Formatter) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = format
+}
+
+// Current returns how the helpers format what they print
+func Current() Formatter {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// SetStdout sends what the helpers print to w instead of stdout, formatted
+// as usual, so a command can keep stdout for its results. nil goes back to
+// stdout.
+func SetStdout(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	stdout = w
+}
+
+// Capture sends what the helpers print to w, unformatted, until the
+// returned function is called, so a caller can show it elsewhere or
+// format it for another terminal. Writes to w are serialized.
+func Capture(w io.Writer) (restore func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := output
+	output = w
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		output = previous
+	}
+}
+
+// Printer prints like the helpers. The zero Printer prints where the
+// helpers do; one from To keeps what it prints apart from everything else.
+type Printer struct {
+	w io.Writer
+}
+
+// To returns a Printer sending what it prints to w, unformatted, as Capture
+// does but for its callers only. Writes to w are serialized.
+func To(w io.Writer) Printer {
+	return Printer{w: w}
+}
+
+// Error prints a line reporting a failure
+func Error(format string, args ...any) {
+	Printer{}.Error(format, args...)
+}
+
+// Warn prints a line warning about something that did not stop the command
+func Warn(format string, args ...any) {
+	Printer{}.Warn(format, args...)
+}
+
+// Success prints a line reporting that something worked
+func Success(format string, args ...any) {
+	Printer{}.Success(format, args...)
+}
+
+// Info prints a hint on what to do next
+func Info(format string, args ...any) {
+	Printer{}.Info(format, args...)
+}
+
+// Printf prints like fmt.Printf, formatting every line that starts with an
+// emoji. Text without a newline is printed as it is, so prompts show up.
+func Printf(format string, args ...any) {
+	Printer{}.Printf(format, args...)
+}
+
+// Println prints like fmt.Println, formatting every line that starts with
+// an emoji
+func Println(args ...any) {
+	Printer{}.Println(args...)
+}
+
+// Print prints like fmt.Print, formatting every line that starts with an
+// emoji
+func Print(args ...any) {
+	Printer{}.Print(args...)
+}
+
+// Error prints a line reporting a failure
+func (p Printer) Error(format string, args ...any) {
+	p.status("❌ ", format, args)
+}
+
+// Warn prints a line warning about something that did not stop the command
+func (p Printer) Warn(format string, args ...any) {
+	p.status("⚠️  ", format, args)
+}
+
+// Success prints a line reporting that something worked
+func (p Printer) Success(format string, args ...any) {
+	p.status("✅ ", format, args)
+}
+
+// Info prints a hint on what to do next
+func (p Printer) Info(format string, args ...any) {
+	p.status("💡 ", format, args)
+}
+
+// Printf prints like the package's Printf
+func (p Printer) Printf(format string, args ...any) {
+	p.write(fmt.Sprintf(format, args...))
+}
+
+// Println prints like the package's Println
+func (p Printer) Println(args ...any) {
+	p.write(fmt.Sprintln(args...))
+}
+
+// Print prints like the package's Print
+func (p Printer) Print(args ...any) {
+	p.write(fmt.Sprint(args...))
+}
+
+// status prints one line starting with the emoji of its kind
+func (p Printer) status(emoji, format string, args []any) {
+	p.write(emoji + fmt.Sprintf(format, args...) + "\n")
+}
+
+// write prints text to the Printer's own writer. Otherwise it formats text
+// line by line and prints it to stdout, or passes it on to the capturing
+// writer.
+func (p Printer) write(text string) {
+	mu.Lock()
+	if p.w != nil {
+		_, _ = io.WriteString(p.w, text)
+		mu.Unlock()
+		return
+	}
+	if output != nil {
+		_, _ = io.WriteString(output, text)
+		mu.Unlock()
+		return
+	}
+	format, w := current, stdout
+	mu.Unlock()
+	if format.Active() {
+		var b strings.Builder
+		for _, line := range strings.SplitAfter(text, "\n") {
+			b.WriteString(format.Line(line))
+		}
+		text = b.String()
+	}
+	if w == nil {
+		w = os.Stdout
+	}
+	_, _ = io.WriteString(w, text)
+}
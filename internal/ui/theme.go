@@ -0,0 +1,75 @@
+// Package ui prints what the CLI reports. Error, Warn, Success and Info
+// print status lines colored following a theme, and can swap their emoji for
+// words on terminals that cannot show them; Printf and Println format other
+// lines starting with an emoji alike.
+package ui
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultTheme is the theme used unless another is chosen
+const DefaultTheme = "dark"
+
+// Theme holds the SGR parameters, such as "31" for red or "1" for bold, each
+// kind of line is shown with; empty leaves the line as it is
+type Theme struct {
+	Name    string
+	Error   string
+	Warning string
+	Success string
+	Hint    string
+	Heading string
+}
+
+// themes are the built-in themes by name
+var themes = map[string]Theme{
+	"dark":  {Name: "dark", Error: "91", Warning: "93", Success: "92", Hint: "36", Heading: "1"},
+	"light": {Name: "light", Error: "31", Warning: "35", Success: "32", Hint: "34", Heading: "1"},
+	"mono":  {Name: "mono", Error: "1", Warning: "1", Hint: "2", Heading: "4"},
+}
+
+// LookupTheme returns the built-in theme called name
+func LookupTheme(name string) (Theme, error) {
+	theme, ok := themes[strings.ToLower(name)]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q, expected one of %s", name, strings.Join(ThemeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// ThemeNames lists the built-in themes
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// style returns the SGR parameters for lines of kind
+func (t Theme) style(kind Kind) string {
+	switch kind {
+	case KindError:
+		return t.Error
+	case KindWarning:
+		return t.Warning
+	case KindSuccess:
+		return t.Success
+	case KindHint:
+		return t.Hint
+	case KindHeading:
+		return t.Heading
+	}
+	return ""
+}
+
+// ColorAllowed reports whether the environment allows colors: NO_COLOR
+// (https://no-color.org) set to anything and TERM=dumb turn them off
+func ColorAllowed() bool {
+	return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
@@ -0,0 +1,230 @@
+package user
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification levels of a contact policy
+const (
+	NotifyAll   = "all"   // Messages are shown and the notification hooks run
+	NotifyQuiet = "quiet" // Messages are shown, the hooks do not run
+	NotifyMuted = "muted" // Messages are kept in the history only
+)
+
+// Keys of the settings in a contact policy
+const (
+	PolicyAutoAccept   = "auto-accept"
+	PolicyNotify       = "notify"
+	PolicyReadReceipts = "read-receipts"
+	PolicyDisappear    = "disappear"
+)
+
+// PolicyKeys lists the settings of a contact policy
+var PolicyKeys = []string{PolicyAutoAccept, PolicyNotify, PolicyReadReceipts, PolicyDisappear}
+
+// MinDisappearAfter is the shortest time messages can be set to disappear
+// after; expired messages are removed once a minute
+const MinDisappearAfter = time.Minute
+
+// ContactPolicy holds the settings that apply to one contact. The zero
+// policy is what applies to everyone else: files are asked about,
+// notifications are on, no read receipts are sent and messages are kept.
+type ContactPolicy struct {
+	// AutoAcceptMB accepts files from the contact up to this many megabytes
+	// without asking; 0 asks about every file
+	AutoAcceptMB int `json:"auto_accept_mb,omitempty"`
+
+	// Notify is NotifyAll, NotifyQuiet or NotifyMuted; empty is NotifyAll
+	Notify string `json:"notify,omitempty"`
+
+	// ReadReceipts tells the contact when their messages were shown
+	ReadReceipts bool `json:"read_receipts,omitempty"`
+
+	// DisappearSeconds removes messages exchanged with the contact from the
+	// history this long after they were sent; 0 keeps them
+	DisappearSeconds int64 `json:"disappear_seconds,omitempty"`
+}
+
+// AutoAcceptBytes returns the largest file accepted without asking, or 0
+func (p ContactPolicy) AutoAcceptBytes() int64 {
+	return int64(p.AutoAcceptMB) << 20
+}
+
+// NotifyLevel returns the notification level, NotifyAll if unset
+func (p ContactPolicy) NotifyLevel() string {
+	if p.Notify == "" {
+		return NotifyAll
+	}
+	return p.Notify
+}
+
+// DisappearAfter returns how long messages are kept, or 0 for ever
+func (p ContactPolicy) DisappearAfter() time.Duration {
+	return time.Duration(p.DisappearSeconds) * time.Second
+}
+
+// IsZero reports whether the policy is the default one
+func (p ContactPolicy) IsZero() bool {
+	return p == ContactPolicy{} || p == ContactPolicy{Notify: NotifyAll}
+}
+
+// Validate checks the settings of the policy
+func (p ContactPolicy) Validate() error {
+	if p.AutoAcceptMB < 0 {
+		return fmt.Errorf("%s: size cannot be negative", PolicyAutoAccept)
+	}
+	switch p.NotifyLevel() {
+	case NotifyAll, NotifyQuiet, NotifyMuted:
+	default:
+		return fmt.Errorf("%s: unknown level %q, expected %s, %s or %s", PolicyNotify, p.Notify, NotifyAll, NotifyQuiet, NotifyMuted)
+	}
+	if p.DisappearSeconds < 0 {
+		return fmt.Errorf("%s: time cannot be negative", PolicyDisappear)
+	}
+	if p.DisappearSeconds > 0 && p.DisappearAfter() < MinDisappearAfter {
+		return fmt.Errorf("%s: shortest time is %s", PolicyDisappear, MinDisappearAfter)
+	}
+	return nil
+}
+
+// Set changes the setting key to value as the user typed it: a number of
+// megabytes or "off" for auto-accept, a level for notify, "on" or "off" for
+// read-receipts and a duration such as 24h or "off" for disappear
+func (p *ContactPolicy) Set(key, value string) error {
+	value = strings.ToLower(strings.TrimSpace(value))
+	off := value == "off" || value == "0"
+	switch key {
+	case PolicyAutoAccept:
+		if off {
+			p.AutoAcceptMB = 0
+			break
+		}
+		mb, err := strconv.Atoi(strings.TrimSuffix(value, "mb"))
+		if err != nil || mb < 0 {
+			return fmt.Errorf("%s: expected a number of megabytes or off, got %q", key, value)
+		}
+		p.AutoAcceptMB = mb
+	case PolicyNotify:
+		p.Notify = value
+	case PolicyReadReceipts:
+		switch value {
+		case "on":
+			p.ReadReceipts = true
+		case "off":
+			p.ReadReceipts = false
+		default:
+			return fmt.Errorf("%s: expected on or off, got %q", key, value)
+		}
+	case PolicyDisappear:
+		if off {
+			p.DisappearSeconds = 0
+			break
+		}
+		ttl, err := parseTTL(value)
+		if err != nil {
+			return fmt.Errorf("%s: expected a duration such as 30m, 24h or 7d, or off, got %q", key, value)
+		}
+		p.DisappearSeconds = int64(ttl / time.Second)
+	default:
+		return fmt.Errorf("unknown policy setting %q, expected one of %s", key, strings.Join(PolicyKeys, ", "))
+	}
+	return p.Validate()
+}
+
+// parseTTL parses a duration, also accepting a number of days such as 7d
+func parseTTL(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// String describes the settings that differ from the default policy
+func (p ContactPolicy) String() string {
+	var parts []string
+	if p.AutoAcceptMB > 0 {
+		parts = append(parts, fmt.Sprintf("%s %d MB", PolicyAutoAccept, p.AutoAcceptMB))
+	}
+	if level := p.NotifyLevel(); level != NotifyAll {
+		parts = append(parts, PolicyNotify+" "+level)
+	}
+	if p.ReadReceipts {
+		parts = append(parts, PolicyReadReceipts+" on")
+	}
+	if ttl := p.DisappearAfter(); ttl > 0 {
+		parts = append(parts, PolicyDisappear+" "+FormatTTL(ttl))
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatTTL shows a disappearing time, whole days as such: 7d rather than
+// 168h0m0s
+func FormatTTL(ttl time.Duration) string {
+	if ttl%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", ttl/(24*time.Hour))
+	}
+	text := ttl.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+// SetPolicy replaces the policy of the named contact; the zero policy
+// removes it
+func (cb *ContactBook) SetPolicy(name string, policy ContactPolicy) (*Contact, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.contacts[name]
+	if !ok {
+		return nil, fmt.Errorf("contact not found: %s", name)
+	}
+	if policy.IsZero() {
+		c.Policy = nil
+	} else {
+		c.Policy = &policy
+	}
+	contact := *c
+	return &contact, cb.save()
+}
+
+// PolicyFor returns the policy of the contact pinned to peerID, or the
+// default policy if the peer is not a contact
+func (cb *ContactBook) PolicyFor(peerID string) ContactPolicy {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	for _, c := range cb.contacts {
+		if c.PeerID == peerID && c.Policy != nil {
+			return *c.Policy
+		}
+	}
+	return ContactPolicy{}
+}
+
+// EffectivePolicy returns the contact's policy, or the default policy if
+// none is set
+func (c *Contact) EffectivePolicy() ContactPolicy {
+	if c.Policy == nil {
+		return ContactPolicy{}
+	}
+	return *c.Policy
+}
@@ -0,0 +1,442 @@
+package user
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ContactsFileName is the file holding the contact book in the data directory
+const ContactsFileName = "contacts.json"
+
+// Contact is a named peer whose identity key is pinned on first use
+type Contact struct {
+	Name           string    `json:"name"`
+	PeerID         string    `json:"peer_id"`
+	DID            string    `json:"did,omitempty"`
+	Verified       bool      `json:"verified"`
+	KeyChanged     bool      `json:"key_changed"`
+	PreviousPeerID string    `json:"previous_peer_id,omitempty"`
+	KeyChangedAt   time.Time `json:"key_changed_at,omitempty"`
+	AddedAt        time.Time `json:"added_at"`
+
+	// Policy holds the settings for this contact, nil for the defaults
+	Policy *ContactPolicy `json:"policy,omitempty"`
+}
+
+// NeedsVerification returns true if sending must wait for re-verification
+func (c *Contact) NeedsVerification() bool {
+	return c.KeyChanged && !c.Verified
+}
+
+// KeyChangeAnnouncement announces that an identity moved to a new key.
+// It is signed by the old key (proving the owner authorised the change) and
+// by the new key (proving possession).
+type KeyChangeAnnouncement struct {
+	OldPeerID    string    `json:"old_peer_id"`
+	NewPeerID    string    `json:"new_peer_id"`
+	NewDID       string    `json:"new_did"`
+	Timestamp    time.Time `json:"timestamp"`
+	OldSignature []byte    `json:"old_signature"`
+	NewSignature []byte    `json:"new_signature"`
+}
+
+// NewKeyChangeAnnouncement creates an announcement signed by both identities
+func NewKeyChangeAnnouncement(oldID, newID *MessengerID) (*KeyChangeAnnouncement, error) {
+	ann := &KeyChangeAnnouncement{
+		OldPeerID: oldID.GetPeerID().String(),
+		NewPeerID: newID.GetPeerID().String(),
+		NewDID:    newID.GetDID(),
+		Timestamp: time.Now().UTC(),
+	}
+
+	var err error
+	if ann.OldSignature, err = oldID.Sign(ann.payload()); err != nil {
+		return nil, fmt.Errorf("failed to sign with old key: %w", err)
+	}
+	if ann.NewSignature, err = newID.Sign(ann.payload()); err != nil {
+		return nil, fmt.Errorf("failed to sign with new key: %w", err)
+	}
+
+	return ann, nil
+}
+
+// payload returns the bytes covered by both signatures
+func (a *KeyChangeAnnouncement) payload() []byte {
+	return []byte(strings.Join([]string{
+		"xelvra-key-change",
+		a.OldPeerID,
+		a.NewPeerID,
+		a.NewDID,
+		a.Timestamp.UTC().Format(time.RFC3339Nano),
+	}, "|"))
+}
+
+// Verify checks both signatures of the announcement
+func (a *KeyChangeAnnouncement) Verify() error {
+	if a.OldPeerID == a.NewPeerID {
+		return fmt.Errorf("announcement does not change the key")
+	}
+
+	for _, check := range []struct {
+		peerID    string
+		signature []byte
+		label     string
+	}{
+		{a.OldPeerID, a.OldSignature, "old"},
+		{a.NewPeerID, a.NewSignature, "new"},
+	} {
+		pubKey, err := publicKeyFromPeerID(check.peerID)
+		if err != nil {
+			return fmt.Errorf("invalid %s peer ID: %w", check.label, err)
+		}
+		ok, err := pubKey.Verify(a.payload(), check.signature)
+		if err != nil || !ok {
+			return fmt.Errorf("%s key signature verification failed", check.label)
+		}
+	}
+
+	return nil
+}
+
+// SafetyNumber returns a 60-digit number identifying the pair of keys used in
+// a conversation. Both sides compute the same value, so it can be compared
+// out of band to detect interception.
+func SafetyNumber(localPeerID, remotePeerID string) (string, error) {
+	keys := make([][]byte, 0, 2)
+	for _, id := range []string{localPeerID, remotePeerID} {
+		pubKey, err := publicKeyFromPeerID(id)
+		if err != nil {
+			return "", err
+		}
+		raw, err := pubKey.Raw()
+		if err != nil {
+			return "", fmt.Errorf("failed to read public key: %w", err)
+		}
+		keys = append(keys, raw)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return string(keys[i]) < string(keys[j])
+	})
+	hash := sha512.Sum512(append(append([]byte{}, keys[0]...), keys[1]...))
+	return digitGroups(hash[:], 12), nil
+}
+
+// KeyFingerprint returns a 30-digit number identifying the key of one peer,
+// to be read out when verifying it. Anyone holding the peer ID computes the
+// same value.
+func KeyFingerprint(peerID string) (string, error) {
+	pubKey, err := publicKeyFromPeerID(peerID)
+	if err != nil {
+		return "", err
+	}
+	raw, err := pubKey.Raw()
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key: %w", err)
+	}
+	hash := sha512.Sum512(raw)
+	return digitGroups(hash[:], 6), nil
+}
+
+// digitGroups turns the first 5 bytes of hash per group into groups of five
+// digits
+func digitGroups(hash []byte, n int) string {
+	groups := make([]string, n)
+	for i := range groups {
+		chunk := make([]byte, 8)
+		copy(chunk[3:], hash[i*5:i*5+5])
+		groups[i] = fmt.Sprintf("%05d", binary.BigEndian.Uint64(chunk)%100000)
+	}
+	return strings.Join(groups, " ")
+}
+
+// publicKeyFromPeerID extracts the public key embedded in a peer ID
+func publicKeyFromPeerID(id string) (crypto.PubKey, error) {
+	peerID, err := peer.Decode(id)
+	if err != nil {
+		return nil, err
+	}
+	return peerID.ExtractPublicKey()
+}
+
+// ContactStore persists a contact book, keyed by contact name
+type ContactStore interface {
+	LoadContacts() (map[string]*Contact, error)
+	SaveContacts(contacts map[string]*Contact) error
+}
+
+// ContactFile stores contacts as JSON in the file at its path. The empty
+// path stores nothing.
+type ContactFile string
+
+// LoadContacts implements ContactStore
+func (f ContactFile) LoadContacts() (map[string]*Contact, error) {
+	contacts := make(map[string]*Contact)
+	if f == "" {
+		return contacts, nil
+	}
+
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return contacts, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &contacts); err != nil {
+		return nil, fmt.Errorf("failed to parse contacts file: %w", err)
+	}
+	return contacts, nil
+}
+
+// SaveContacts implements ContactStore
+func (f ContactFile) SaveContacts(contacts map[string]*Contact) error {
+	if f == "" {
+		return nil
+	}
+
+	path := string(f)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(contacts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// MemoryContacts keeps contacts in memory only, for tests and ephemeral
+// nodes. Contacts are copied in and out so the store is not changed behind
+// its back.
+type MemoryContacts struct {
+	mu       sync.Mutex
+	contacts map[string]Contact
+}
+
+// NewMemoryContacts creates an empty in-memory contact store
+func NewMemoryContacts() *MemoryContacts {
+	return &MemoryContacts{contacts: make(map[string]Contact)}
+}
+
+// LoadContacts implements ContactStore
+func (m *MemoryContacts) LoadContacts() (map[string]*Contact, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	contacts := make(map[string]*Contact, len(m.contacts))
+	for name, contact := range m.contacts {
+		contacts[name] = &contact
+	}
+	return contacts, nil
+}
+
+// SaveContacts implements ContactStore
+func (m *MemoryContacts) SaveContacts(contacts map[string]*Contact) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.contacts = make(map[string]Contact, len(contacts))
+	for name, contact := range contacts {
+		m.contacts[name] = *contact
+	}
+	return nil
+}
+
+// ContactBook stores contacts and their pinned identity keys
+type ContactBook struct {
+	mu       sync.RWMutex
+	store    ContactStore
+	contacts map[string]*Contact // name -> contact
+}
+
+// LoadContactBook loads the contact book stored at path
+func LoadContactBook(path string) (*ContactBook, error) {
+	return OpenContactBook(ContactFile(path))
+}
+
+// OpenContactBook loads the contact book kept in store
+func OpenContactBook(store ContactStore) (*ContactBook, error) {
+	contacts, err := store.LoadContacts()
+	if err != nil {
+		return nil, err
+	}
+	return &ContactBook{store: store, contacts: contacts}, nil
+}
+
+// Add pins a new contact to the given peer ID. Adding a saved contact again
+// with another peer ID is treated as a key change the peer did not
+// announce: the contact moves to the new key and is flagged for
+// re-verification, just as for an announced change.
+func (cb *ContactBook) Add(name, peerID, did string) (*Contact, error) {
+	if name == "" {
+		return nil, fmt.Errorf("contact name is required")
+	}
+	if _, err := publicKeyFromPeerID(peerID); err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	for _, c := range cb.contacts {
+		if c.PeerID == peerID {
+			if c.Name == name {
+				return nil, fmt.Errorf("contact already exists: %s", name)
+			}
+			return nil, fmt.Errorf("peer is already saved as %s", c.Name)
+		}
+	}
+	if c, exists := cb.contacts[name]; exists {
+		c.PreviousPeerID = c.PeerID
+		c.PeerID = peerID
+		c.DID = did
+		c.KeyChanged = true
+		c.Verified = false
+		c.KeyChangedAt = time.Now()
+
+		contact := *c
+		return &contact, cb.save()
+	}
+
+	contact := &Contact{
+		Name:    name,
+		PeerID:  peerID,
+		DID:     did,
+		AddedAt: time.Now(),
+	}
+	cb.contacts[name] = contact
+
+	return contact, cb.save()
+}
+
+// Remove deletes a contact
+func (cb *ContactBook) Remove(name string) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if _, exists := cb.contacts[name]; !exists {
+		return fmt.Errorf("contact not found: %s", name)
+	}
+	delete(cb.contacts, name)
+	return cb.save()
+}
+
+// Get returns a copy of the named contact
+func (cb *ContactBook) Get(name string) (*Contact, bool) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	c, ok := cb.contacts[name]
+	if !ok {
+		return nil, false
+	}
+	contact := *c
+	return &contact, true
+}
+
+// FindByPeerID returns a copy of the contact pinned to peerID
+func (cb *ContactBook) FindByPeerID(peerID string) (*Contact, bool) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	for _, c := range cb.contacts {
+		if c.PeerID == peerID {
+			contact := *c
+			return &contact, true
+		}
+	}
+	return nil, false
+}
+
+// List returns copies of all contacts sorted by name
+func (cb *ContactBook) List() []*Contact {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	result := make([]*Contact, 0, len(cb.contacts))
+	for _, c := range cb.contacts {
+		contact := *c
+		result = append(result, &contact)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Verify marks a contact's current key as verified by the user
+func (cb *ContactBook) Verify(name string) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.contacts[name]
+	if !ok {
+		return fmt.Errorf("contact not found: %s", name)
+	}
+	c.Verified = true
+	c.KeyChanged = false
+	return cb.save()
+}
+
+// ApplyKeyChange verifies an announcement and moves the matching contact to
+// the new key. The contact is flagged for re-verification.
+func (cb *ContactBook) ApplyKeyChange(ann *KeyChangeAnnouncement) (*Contact, error) {
+	if err := ann.Verify(); err != nil {
+		return nil, err
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	for _, c := range cb.contacts {
+		if c.PeerID != ann.OldPeerID {
+			continue
+		}
+
+		c.PreviousPeerID = c.PeerID
+		c.PeerID = ann.NewPeerID
+		c.DID = ann.NewDID
+		c.KeyChanged = true
+		c.Verified = false
+		c.KeyChangedAt = time.Now()
+
+		contact := *c
+		return &contact, cb.save()
+	}
+
+	return nil, fmt.Errorf("no contact pinned to %s", ann.OldPeerID)
+}
+
+// CheckSendAllowed returns an error if messages to peerID must be held back
+// until the user re-verifies the contact's changed key
+func (cb *ContactBook) CheckSendAllowed(peerID string) error {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	for _, c := range cb.contacts {
+		if c.PeerID == peerID && c.NeedsVerification() {
+			return fmt.Errorf("safety number with %s changed - verify it with /verify %s before sending", c.Name, c.Name)
+		}
+	}
+	return nil
+}
+
+// save writes the contact book to its store; callers must hold the lock
+func (cb *ContactBook) save() error {
+	return cb.store.SaveContacts(cb.contacts)
+}
@@ -0,0 +1,37 @@
+package user
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DataDirEnv replaces the data directory, e.g. for a container that
+	// keeps it on a volume
+	DataDirEnv = "XELVRA_CONFIG_DIR"
+
+	// dataDirName is the data directory in the home directory
+	dataDirName = ".xelvra"
+)
+
+// DataDir returns the directory holding the identity, the configuration
+// and everything else the node keeps: DataDirEnv if set, where a leading ~
+// stands for the home directory, or ~/.xelvra
+func DataDir() (string, error) {
+	if dir := strings.TrimSpace(os.Getenv(DataDirEnv)); dir != "" {
+		if dir != "~" && !strings.HasPrefix(dir, "~/") {
+			return filepath.Abs(dir)
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, strings.TrimPrefix(dir, "~")), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, dataDirName), nil
+}
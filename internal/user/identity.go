@@ -233,6 +233,11 @@ func ValidateDID(did string) bool {
 	return err == nil
 }
 
+// VerifyDIDProof checks that a DID was derived from the public key and a valid proof-of-work
+func VerifyDIDProof(did string, publicKey ed25519.PublicKey, pow *ProofOfWork) bool {
+	return ValidateProofOfWork(publicKey, pow) && generateDIDWithPOW(publicKey, pow) == did
+}
+
 // CreateUserProfile creates a new user profile with default settings
 func CreateUserProfile(messengerID *MessengerID, displayName string) *UserProfile {
 	return &UserProfile{
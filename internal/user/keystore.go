@@ -0,0 +1,252 @@
+package user
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// IdentityFileName is the file holding the persistent identity in the data directory
+	IdentityFileName = "identity.json"
+
+	// StorageKeySize is the size of keys derived for local storage
+	StorageKeySize = 32
+)
+
+// storedIdentity is the on-disk representation of a MessengerID
+type storedIdentity struct {
+	PrivateKey  []byte       `json:"private_key"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ProofOfWork *ProofOfWork `json:"proof_of_work"`
+
+	// Announcement for contacts after a key rotation, cleared once every
+	// contact confirmed receiving it
+	PendingAnnouncement *KeyChangeAnnouncement `json:"pending_announcement,omitempty"`
+
+	// Peer IDs of the contacts that confirmed receiving the announcement
+	AnnouncedTo []string `json:"announced_to,omitempty"`
+}
+
+// announcementMu serializes updates of the pending announcement, which are
+// made as contacts confirm receiving it
+var announcementMu sync.Mutex
+
+// LoadOrCreateMessengerID loads the identity stored at path, generating and
+// saving a new one if none exists yet. The boolean reports whether a new
+// identity was created.
+func LoadOrCreateMessengerID(path string) (*MessengerID, bool, error) {
+	stored, err := readStoredIdentity(path)
+	if err == nil {
+		id, err := stored.messengerID()
+		return id, false, err
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	id, err := GenerateMessengerID()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := writeStoredIdentity(path, &storedIdentity{
+		PrivateKey:  id.PrivateKey,
+		CreatedAt:   id.CreatedAt,
+		ProofOfWork: id.ProofOfWork,
+	}); err != nil {
+		return nil, false, fmt.Errorf("failed to save identity: %w", err)
+	}
+
+	return id, true, nil
+}
+
+// LoadMessengerID loads the identity stored at path without creating one
+func LoadMessengerID(path string) (*MessengerID, error) {
+	stored, err := readStoredIdentity(path)
+	if err != nil {
+		return nil, err
+	}
+	return stored.messengerID()
+}
+
+// RotateMessengerID replaces the identity stored at path with a freshly
+// generated one. The returned announcement is signed by both keys and is kept
+// pending in the identity file until all contacts confirmed receiving it.
+func RotateMessengerID(path string) (*MessengerID, *KeyChangeAnnouncement, error) {
+	stored, err := readStoredIdentity(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load current identity: %w", err)
+	}
+
+	oldID, err := stored.messengerID()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer oldID.Destroy()
+
+	newID, err := GenerateMessengerID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	announcement, err := NewKeyChangeAnnouncement(oldID, newID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := writeStoredIdentity(path, &storedIdentity{
+		PrivateKey:          newID.PrivateKey,
+		CreatedAt:           newID.CreatedAt,
+		ProofOfWork:         newID.ProofOfWork,
+		PendingAnnouncement: announcement,
+	}); err != nil {
+		return nil, nil, fmt.Errorf("failed to save rotated identity: %w", err)
+	}
+
+	return newID, announcement, nil
+}
+
+// LoadPendingAnnouncement returns the key-change announcement waiting to be
+// delivered, or nil if there is none, with the peer IDs of the contacts that
+// confirmed receiving it
+func LoadPendingAnnouncement(path string) (*KeyChangeAnnouncement, []string, error) {
+	announcementMu.Lock()
+	defer announcementMu.Unlock()
+
+	stored, err := readStoredIdentity(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stored.PendingAnnouncement, stored.AnnouncedTo, nil
+}
+
+// AnnouncementDelivered records that the contact with peerID confirmed
+// receiving the pending key-change announcement. It returns the peer IDs of
+// all contacts that have.
+func AnnouncementDelivered(path, peerID string) ([]string, error) {
+	announcementMu.Lock()
+	defer announcementMu.Unlock()
+
+	stored, err := readStoredIdentity(path)
+	if err != nil {
+		return nil, err
+	}
+	if stored.PendingAnnouncement == nil {
+		return nil, nil
+	}
+	for _, id := range stored.AnnouncedTo {
+		if id == peerID {
+			return stored.AnnouncedTo, nil
+		}
+	}
+	stored.AnnouncedTo = append(stored.AnnouncedTo, peerID)
+	return stored.AnnouncedTo, writeStoredIdentity(path, stored)
+}
+
+// ClearPendingAnnouncement removes the pending key-change announcement
+func ClearPendingAnnouncement(path string) error {
+	announcementMu.Lock()
+	defer announcementMu.Unlock()
+
+	stored, err := readStoredIdentity(path)
+	if err != nil {
+		return err
+	}
+	if stored.PendingAnnouncement == nil {
+		return nil
+	}
+	stored.PendingAnnouncement = nil
+	stored.AnnouncedTo = nil
+	return writeStoredIdentity(path, stored)
+}
+
+// DeriveStorageKey derives a key for encrypting local data from the identity's
+// private key. Each purpose gets an independent key; keys change when the
+// identity is rotated.
+func (mid *MessengerID) DeriveStorageKey(purpose string) ([]byte, error) {
+	if len(mid.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("identity has no private key")
+	}
+
+	kdf := hkdf.New(sha256.New, mid.PrivateKey.Seed(), nil, []byte("XelvraStorage/"+purpose))
+	key := make([]byte, StorageKeySize)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, fmt.Errorf("failed to derive storage key: %w", err)
+	}
+	return key, nil
+}
+
+// messengerID reconstructs the MessengerID from its stored form
+func (s *storedIdentity) messengerID() (*MessengerID, error) {
+	if len(s.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid stored private key size: %d", len(s.PrivateKey))
+	}
+	if s.ProofOfWork == nil {
+		return nil, fmt.Errorf("stored identity is missing proof-of-work")
+	}
+
+	privateKey := ed25519.PrivateKey(s.PrivateKey)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	if !ValidateProofOfWork(publicKey, s.ProofOfWork) {
+		return nil, fmt.Errorf("stored identity has invalid proof-of-work")
+	}
+
+	libp2pPrivKey, err := crypto.UnmarshalEd25519PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create libp2p private key: %w", err)
+	}
+
+	peerID, err := peer.IDFromPrivateKey(libp2pPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer ID: %w", err)
+	}
+
+	return &MessengerID{
+		DID:         generateDIDWithPOW(publicKey, s.ProofOfWork),
+		PublicKey:   publicKey,
+		PrivateKey:  privateKey,
+		PeerID:      peerID,
+		CreatedAt:   s.CreatedAt,
+		ProofOfWork: s.ProofOfWork,
+	}, nil
+}
+
+// readStoredIdentity reads the identity file
+func readStoredIdentity(path string) (*storedIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored storedIdentity
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse identity file: %w", err)
+	}
+	return &stored, nil
+}
+
+// writeStoredIdentity writes the identity file with owner-only permissions
+func writeStoredIdentity(path string, stored *storedIdentity) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,237 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ProfilesFileName holds the profile facets in the data directory
+	ProfilesFileName = "profiles.json"
+
+	// Limits on what a facet discloses
+	MaxDisplayNameLength = 64
+	MaxProfileFields     = 16
+	MaxFieldValueLength  = 256
+	MaxAvatarSize        = 256 * 1024
+)
+
+// facetPattern restricts facet names and field keys to short identifiers
+var facetPattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// ProfileFacet is one face of the user's profile, such as "work" or
+// "personal". Its name is a local label and is never disclosed.
+type ProfileFacet struct {
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Avatar      string            `json:"avatar,omitempty"` // Path of an image file
+	Fields      map[string]string `json:"fields,omitempty"` // Visible fields, e.g. "email" or "status"
+}
+
+// Validate checks the facet's name and limits
+func (f *ProfileFacet) Validate() error {
+	if !facetPattern.MatchString(f.Name) {
+		return fmt.Errorf("invalid facet name %q: use up to 32 characters a-z, 0-9, '_' or '-'", f.Name)
+	}
+	if utf8.RuneCountInString(f.DisplayName) > MaxDisplayNameLength {
+		return fmt.Errorf("display name is longer than %d characters", MaxDisplayNameLength)
+	}
+	if len(f.Fields) > MaxProfileFields {
+		return fmt.Errorf("a facet has at most %d fields", MaxProfileFields)
+	}
+	for key, value := range f.Fields {
+		if !facetPattern.MatchString(key) {
+			return fmt.Errorf("invalid field name %q", key)
+		}
+		if utf8.RuneCountInString(value) > MaxFieldValueLength {
+			return fmt.Errorf("field %s is longer than %d characters", key, MaxFieldValueLength)
+		}
+	}
+	return nil
+}
+
+// Disclose returns what a peer is shown of the facet, reading the avatar
+func (f *ProfileFacet) Disclose() (*PublicProfile, error) {
+	profile := &PublicProfile{
+		DisplayName: f.DisplayName,
+		Fields:      f.Fields,
+	}
+
+	if f.Avatar != "" {
+		data, err := readAvatar(f.Avatar)
+		if err != nil {
+			return nil, err
+		}
+		profile.Avatar = data
+		profile.AvatarType = http.DetectContentType(data)
+	}
+
+	return profile, nil
+}
+
+// readAvatar reads an avatar image within MaxAvatarSize
+func readAvatar(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("avatar not readable: %w", err)
+	}
+	if info.Size() > MaxAvatarSize {
+		return nil, fmt.Errorf("avatar is larger than %d KB", MaxAvatarSize/1024)
+	}
+	return os.ReadFile(path)
+}
+
+// PublicProfile is the facet of a profile disclosed to one peer
+type PublicProfile struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Avatar      []byte            `json:"avatar,omitempty"`
+	AvatarType  string            `json:"avatar_type,omitempty"`
+}
+
+// IsEmpty returns true if nothing is disclosed
+func (p *PublicProfile) IsEmpty() bool {
+	return p.DisplayName == "" && len(p.Fields) == 0 && len(p.Avatar) == 0
+}
+
+// ProfileSettings holds the profile facets and which peer is shown which.
+// Peers without an assignment see the default facet, or nothing if there
+// is none.
+type ProfileSettings struct {
+	Facets   map[string]*ProfileFacet `json:"facets"`
+	Default  string                   `json:"default,omitempty"`
+	Assigned map[string]string        `json:"assigned,omitempty"` // Peer ID -> facet name
+}
+
+// LoadProfileSettings loads the profile settings stored at path
+func LoadProfileSettings(path string) (*ProfileSettings, error) {
+	settings := &ProfileSettings{
+		Facets:   make(map[string]*ProfileFacet),
+		Assigned: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles file: %w", err)
+	}
+	if settings.Facets == nil {
+		settings.Facets = make(map[string]*ProfileFacet)
+	}
+	if settings.Assigned == nil {
+		settings.Assigned = make(map[string]string)
+	}
+	return settings, nil
+}
+
+// Save writes the profile settings to path
+func (s *ProfileSettings) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// NormalizeFacetName lowercases and validates a facet name
+func NormalizeFacetName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !facetPattern.MatchString(name) {
+		return "", fmt.Errorf("invalid facet name %q: use up to 32 characters a-z, 0-9, '_' or '-'", name)
+	}
+	return name, nil
+}
+
+// SetFacet adds or replaces a facet. The first facet becomes the default.
+func (s *ProfileSettings) SetFacet(facet *ProfileFacet) error {
+	if err := facet.Validate(); err != nil {
+		return err
+	}
+	s.Facets[facet.Name] = facet
+	if s.Default == "" && len(s.Facets) == 1 {
+		s.Default = facet.Name
+	}
+	return nil
+}
+
+// RemoveFacet deletes a facet. Peers it was assigned to see the default
+// facet from then on.
+func (s *ProfileSettings) RemoveFacet(name string) error {
+	if _, ok := s.Facets[name]; !ok {
+		return fmt.Errorf("no facet named %s", name)
+	}
+	delete(s.Facets, name)
+	if s.Default == name {
+		s.Default = ""
+	}
+	for peerID, assigned := range s.Assigned {
+		if assigned == name {
+			delete(s.Assigned, peerID)
+		}
+	}
+	return nil
+}
+
+// SetDefault chooses the facet shown to peers without an assignment; an
+// empty name shows them nothing
+func (s *ProfileSettings) SetDefault(name string) error {
+	if _, ok := s.Facets[name]; name != "" && !ok {
+		return fmt.Errorf("no facet named %s", name)
+	}
+	s.Default = name
+	return nil
+}
+
+// Assign chooses the facet disclosed to a peer; an empty name returns the
+// peer to the default
+func (s *ProfileSettings) Assign(peerID, name string) error {
+	if name == "" {
+		delete(s.Assigned, peerID)
+		return nil
+	}
+	if _, ok := s.Facets[name]; !ok {
+		return fmt.Errorf("no facet named %s", name)
+	}
+	if _, err := publicKeyFromPeerID(peerID); err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+	s.Assigned[peerID] = name
+	return nil
+}
+
+// FacetFor returns the facet disclosed to a peer, or nil if none is
+func (s *ProfileSettings) FacetFor(peerID string) *ProfileFacet {
+	if name, ok := s.Assigned[peerID]; ok {
+		if facet, ok := s.Facets[name]; ok {
+			return facet
+		}
+	}
+	return s.Facets[s.Default]
+}
+
+// FacetNames returns the facet names in order
+func (s *ProfileSettings) FacetNames() []string {
+	names := make([]string, 0, len(s.Facets))
+	for name := range s.Facets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,123 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+const (
+	// RecoverySeedSize is the size of the secret a recovery phrase encodes
+	RecoverySeedSize = 16
+
+	// recoveryChecksumWords are appended to catch mistyped phrases
+	recoveryChecksumWords = 2
+
+	// RecoveryPhraseWords is the number of words in a recovery phrase
+	RecoveryPhraseWords = RecoverySeedSize + recoveryChecksumWords
+
+	// recoveryPrefixLength is how much of a word identifies it
+	recoveryPrefixLength = 4
+)
+
+// recoveryWords encodes one byte per word. The list is sorted and no two
+// words share their first four letters, so words may be abbreviated.
+var recoveryWords = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alley",
+	"amber", "angle", "ankle", "apple", "april", "arena", "armor", "arrow",
+	"atlas", "attic", "autumn", "bacon", "badge", "bagel", "baker", "bamboo",
+	"banana", "banjo", "barrel", "basket", "beach", "beaver", "berry", "bicycle",
+	"bishop", "blanket", "blossom", "border", "bottle", "breeze", "brick", "bridge",
+	"bronze", "bucket", "buffalo", "butter", "cabin", "cactus", "camel", "candle",
+	"canoe", "canvas", "carbon", "carpet", "castle", "cattle", "cellar", "cement",
+	"cherry", "chimney", "circle", "citrus", "clover", "cobalt", "coconut", "coffee",
+	"comet", "copper", "coral", "cotton", "cousin", "coyote", "crater", "cricket",
+	"crystal", "daisy", "dancer", "denim", "desert", "diamond", "dinner", "doctor",
+	"dolphin", "donkey", "dragon", "drawer", "dune", "eagle", "easel", "echo",
+	"elbow", "ember", "engine", "fabric", "falcon", "feather", "fence", "ferry",
+	"finger", "fossil", "fox", "frost", "galaxy", "garden", "garlic", "gazelle",
+	"ginger", "giraffe", "glacier", "glove", "goblet", "gold", "gravel", "guitar",
+	"hammer", "harbor", "harvest", "hazel", "helmet", "honey", "hornet", "hotel",
+	"husky", "island", "ivory", "jacket", "jaguar", "jasmine", "jelly", "jewel",
+	"jungle", "kayak", "kernel", "kettle", "kitten", "koala", "ladder", "lagoon",
+	"lantern", "lemon", "leopard", "lettuce", "lily", "linen", "lizard", "lobster",
+	"locket", "lotus", "magnet", "mango", "maple", "marble", "meadow", "melon",
+	"mirror", "mitten", "monkey", "mosaic", "muffin", "museum", "mustard", "napkin",
+	"nectar", "needle", "nickel", "noodle", "nutmeg", "oasis", "oatmeal", "ocean",
+	"olive", "onion", "opera", "orange", "orbit", "orchid", "otter", "oven",
+	"oyster", "paddle", "palace", "panda", "papaya", "parrot", "pastry", "peanut",
+	"pebble", "pelican", "pepper", "piano", "pigeon", "pillow", "pilot", "pine",
+	"planet", "plum", "pocket", "potato", "prism", "pumpkin", "puzzle", "quartz",
+	"quilt", "rabbit", "radar", "radish", "raven", "ribbon", "river", "robin",
+	"rocket", "rose", "ruby", "saddle", "salmon", "sandal", "satin", "scarf",
+	"shadow", "silver", "skate", "sparrow", "spider", "spinach", "spoon", "stable",
+	"statue", "summit", "sunset", "swan", "table", "tango", "teapot", "temple",
+	"tiger", "timber", "tomato", "topaz", "tower", "tulip", "tunnel", "turtle",
+	"valley", "velvet", "violin", "volcano", "wagon", "walnut", "walrus", "whale",
+	"willow", "window", "winter", "wizard", "yacht", "yogurt", "zebra", "zipper",
+}
+
+// NewRecoverySeed generates a random secret for a recovery phrase
+func NewRecoverySeed() ([]byte, error) {
+	seed := make([]byte, RecoverySeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("failed to generate recovery seed: %w", err)
+	}
+	return seed, nil
+}
+
+// RecoveryPhrase encodes a seed as words, followed by checksum words
+func RecoveryPhrase(seed []byte) (string, error) {
+	if len(seed) != RecoverySeedSize {
+		return "", fmt.Errorf("recovery seed must be %d bytes", RecoverySeedSize)
+	}
+
+	sum := sha256.Sum256(seed)
+	words := make([]string, 0, RecoveryPhraseWords)
+	for _, b := range append(append([]byte{}, seed...), sum[:recoveryChecksumWords]...) {
+		words = append(words, recoveryWords[b])
+	}
+	return strings.Join(words, " "), nil
+}
+
+// ParseRecoveryPhrase decodes a recovery phrase into its seed. Words are
+// matched case-insensitively and by their first four letters.
+func ParseRecoveryPhrase(phrase string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words) != RecoveryPhraseWords {
+		return nil, fmt.Errorf("a recovery phrase has %d words, not %d", RecoveryPhraseWords, len(words))
+	}
+
+	data := make([]byte, len(words))
+	for i, word := range words {
+		b, ok := recoveryWordIndex(word)
+		if !ok {
+			return nil, fmt.Errorf("word %d (%q) is not in the recovery word list", i+1, word)
+		}
+		data[i] = b
+	}
+
+	seed := data[:RecoverySeedSize]
+	sum := sha256.Sum256(seed)
+	for i := 0; i < recoveryChecksumWords; i++ {
+		if data[RecoverySeedSize+i] != sum[i] {
+			return nil, fmt.Errorf("recovery phrase checksum does not match: check for a mistyped or swapped word")
+		}
+	}
+	return seed, nil
+}
+
+// recoveryWordIndex finds a word, or an abbreviation of at least four
+// letters, in the word list
+func recoveryWordIndex(word string) (byte, bool) {
+	for i, candidate := range recoveryWords {
+		if candidate == word {
+			return byte(i), true
+		}
+		if len(word) >= recoveryPrefixLength && strings.HasPrefix(candidate, word) {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
@@ -58,7 +58,7 @@ for platform in "${PLATFORMS[@]}"; do
     
     # Build CLI
     output_name="${DIST_DIR}/peerchat-cli-${VERSION}-${GOOS}-${GOARCH}${binary_ext}"
-    env GOOS=$GOOS GOARCH=$GOARCH go build -ldflags "${LDFLAGS}" -o "$output_name" ./cmd/peerchat-cli
+    env GOOS=$GOOS GOARCH=$GOARCH go build -tags sqlite_fts5 -ldflags "${LDFLAGS}" -o "$output_name" ./cmd/peerchat-cli
     
     if [ $? -eq 0 ]; then
         echo -e "${GREEN}✓ Built: $output_name${NC}"
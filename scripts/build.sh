@@ -32,11 +32,12 @@ mkdir -p ${BIN_DIR} ${DIST_DIR}
 
 # Build flags
 LDFLAGS="-X main.version=${VERSION} -X main.buildTime=${BUILD_TIME} -X main.gitCommit=${GIT_COMMIT}"
+BUILD_TAGS="sqlite_fts5" # Full-text message search
 
 echo -e "${YELLOW}📦 Building CLI application...${NC}"
 
 # Build CLI for current platform
-go build -ldflags "${LDFLAGS}" -o ${BIN_DIR}/peerchat-cli ./cmd/peerchat-cli
+go build -tags "${BUILD_TAGS}" -ldflags "${LDFLAGS}" -o ${BIN_DIR}/peerchat-cli ./cmd/peerchat-cli
 if [ $? -eq 0 ]; then
     echo -e "${GREEN}✓ CLI built successfully: ${BIN_DIR}/peerchat-cli${NC}"
 else
@@ -47,7 +48,7 @@ fi
 # Build API server
 echo -e "${YELLOW}📦 Building API server...${NC}"
 if [ -d "cmd/peerchat-api" ] && [ -n "$(find cmd/peerchat-api -name '*.go' 2>/dev/null)" ]; then
-    go build -ldflags "${LDFLAGS}" -o ${BIN_DIR}/peerchat-api ./cmd/peerchat-api
+    go build -tags "${BUILD_TAGS}" -ldflags "${LDFLAGS}" -o ${BIN_DIR}/peerchat-api ./cmd/peerchat-api
     if [ $? -eq 0 ]; then
         echo -e "${GREEN}✓ API server built successfully: ${BIN_DIR}/peerchat-api${NC}"
     else
@@ -38,7 +38,7 @@ fi
 
 # Run unit tests
 echo -e "${YELLOW}🧪 Running unit tests...${NC}"
-go test -v -race -coverprofile=coverage.out ./...
+go test -v -race -tags sqlite_fts5 -coverprofile=coverage.out ./...
 if [ $? -eq 0 ]; then
     echo -e "${GREEN}✓ Unit tests passed${NC}"
 else
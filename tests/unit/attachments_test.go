@@ -0,0 +1,98 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receivedFile copies src to a new partial download as a transfer would
+func receivedFile(t *testing.T, src, name string) (string, message.FileMetadata) {
+	data, err := os.ReadFile(src)
+	require.NoError(t, err)
+	part := filepath.Join(t.TempDir(), name+".part")
+	require.NoError(t, os.WriteFile(part, data, 0600))
+
+	metadata, err := message.CreateFileMetadata(src)
+	require.NoError(t, err)
+	metadata.Name = name
+	return part, *metadata
+}
+
+func TestAttachmentStoreDeduplicates(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	store := message.NewAttachmentStore(t.TempDir(), logger)
+	downloads := t.TempDir()
+	payload := writeRandomFile(t, 4096)
+
+	// The same file arrives from two peers under different names
+	part, metadata := receivedFile(t, payload, "report.pdf")
+	first := filepath.Join(downloads, "report.pdf")
+	duplicate, err := store.Add(part, first, metadata, "peer-a", "t1")
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+
+	part, metadata = receivedFile(t, payload, "report-final.pdf")
+	second := filepath.Join(downloads, "report-final.pdf")
+	duplicate, err = store.Add(part, second, metadata, "peer-b", "t2")
+	require.NoError(t, err)
+	assert.True(t, duplicate)
+	assert.NoFileExists(t, part, "duplicate partial file is removed")
+
+	object, err := os.Stat(store.ObjectPath(metadata.Hash))
+	require.NoError(t, err)
+	for _, path := range []string{first, second} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(object, info), "%s shares the stored file", path)
+	}
+
+	attachments, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, attachments, 1)
+	require.Len(t, attachments[0].Refs, 2)
+	assert.Equal(t, "peer-a", attachments[0].Refs[0].PeerID)
+	assert.Equal(t, "report-final.pdf", attachments[0].Refs[1].Name)
+}
+
+func TestAttachmentStoreGC(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dir := t.TempDir()
+	store := message.NewAttachmentStore(dir, logger)
+	downloads := t.TempDir()
+
+	part, metadata := receivedFile(t, writeRandomFile(t, 2048), "photo.jpg")
+	dest := filepath.Join(downloads, "photo.jpg")
+	_, err := store.Add(part, dest, metadata, "peer-a", "t1")
+	require.NoError(t, err)
+
+	// A stored file missing from the index, as left by an interrupted receive
+	orphan := filepath.Join(dir, "objects", "ff", "ff00")
+	require.NoError(t, os.MkdirAll(filepath.Dir(orphan), 0700))
+	require.NoError(t, os.WriteFile(orphan, []byte("orphan"), 0400))
+
+	result, err := store.GC(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Removed)
+	assert.NoFileExists(t, orphan)
+	assert.FileExists(t, dest, "recent attachments are kept")
+
+	result, err = store.GC(time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Removed)
+	assert.Equal(t, metadata.Size, result.BytesFreed)
+	assert.NoFileExists(t, dest, "download link is removed with the attachment")
+	assert.NoFileExists(t, store.ObjectPath(metadata.Hash))
+
+	attachments, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, attachments)
+}
@@ -0,0 +1,176 @@
+package unit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryPhrase(t *testing.T) {
+	seed, err := user.NewRecoverySeed()
+	require.NoError(t, err)
+
+	phrase, err := user.RecoveryPhrase(seed)
+	require.NoError(t, err)
+	words := strings.Fields(phrase)
+	require.Len(t, words, user.RecoveryPhraseWords)
+
+	parsed, err := user.ParseRecoveryPhrase(phrase)
+	require.NoError(t, err)
+	assert.Equal(t, seed, parsed)
+
+	// Words may be abbreviated and typed in any case
+	short := make([]string, len(words))
+	for i, word := range words {
+		short[i] = strings.ToUpper(word[:min(4, len(word))])
+	}
+	parsed, err = user.ParseRecoveryPhrase(strings.Join(short, "  "))
+	require.NoError(t, err)
+	assert.Equal(t, seed, parsed)
+
+	// Swapped words fail the checksum
+	swapped := append([]string{}, words...)
+	for i := 1; i < len(swapped); i++ {
+		if swapped[i] != swapped[0] {
+			swapped[0], swapped[i] = swapped[i], swapped[0]
+			break
+		}
+	}
+	_, err = user.ParseRecoveryPhrase(strings.Join(swapped, " "))
+	assert.ErrorContains(t, err, "checksum")
+
+	_, err = user.ParseRecoveryPhrase(strings.Join(words[1:], " "))
+	assert.Error(t, err)
+	words[0] = "xylophone"
+	_, err = user.ParseRecoveryPhrase(strings.Join(words, " "))
+	assert.ErrorContains(t, err, "word 1")
+}
+
+func TestFriendBackup(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	ownerHost, holderHost := newConnectedHosts(t)
+	owner := newTestMessageManager(t, ownerHost)
+	newTestMessageManager(t, holderHost)
+
+	seed, err := user.NewRecoverySeed()
+	require.NoError(t, err)
+	secrets, err := message.NewBackupSecrets(seed)
+	require.NoError(t, err)
+	sealed, err := secrets.Seal([]byte("snapshot"))
+	require.NoError(t, err)
+
+	// The holder must agree first
+	err = owner.StoreBackup(holderHost.ID(), secrets, sealed)
+	pe, ok := message.AsProtocolError(err)
+	require.True(t, ok, "%v", err)
+	assert.Equal(t, message.ErrCodePolicy, pe.Code)
+
+	hostingPath := filepath.Join(home, ".xelvra", message.BackupHostingFileName)
+	require.NoError(t, message.SaveBackupHosting(hostingPath, &message.BackupHosting{
+		Peers: map[string]int64{ownerHost.ID().String(): 1024},
+	}))
+	require.NoError(t, owner.StoreBackup(holderHost.ID(), secrets, sealed))
+
+	// A new backup replaces the previous one
+	sealed, err = secrets.Seal([]byte("newer snapshot"))
+	require.NoError(t, err)
+	require.NoError(t, owner.StoreBackup(holderHost.ID(), secrets, sealed))
+
+	store := message.NewHeldBackupStore(filepath.Join(home, ".xelvra", message.HeldBackupsDirName))
+	held, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, held, 1)
+	assert.Equal(t, ownerHost.ID().String(), held[0].Owner)
+	assert.Equal(t, int64(len(sealed)), held[0].Size)
+
+	// The holder cannot read it; anyone with the phrase can fetch it
+	data, err := os.ReadFile(filepath.Join(home, ".xelvra", message.HeldBackupsDirName, held[0].BlobID+".bin"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "snapshot")
+
+	fetched, err := owner.FetchBackup(holderHost.ID(), secrets)
+	require.NoError(t, err)
+	plaintext, err := secrets.Open(fetched)
+	require.NoError(t, err)
+	assert.Equal(t, "newer snapshot", string(plaintext))
+
+	otherSeed, err := user.NewRecoverySeed()
+	require.NoError(t, err)
+	other, err := message.NewBackupSecrets(otherSeed)
+	require.NoError(t, err)
+	_, err = owner.FetchBackup(holderHost.ID(), other)
+	pe, ok = message.AsProtocolError(err)
+	require.True(t, ok, "%v", err)
+	assert.Equal(t, message.ErrCodeNotFound, pe.Code)
+	_, err = other.Open(fetched)
+	assert.Error(t, err, "another phrase cannot decrypt the backup")
+
+	// Backups under other phrases count against the same quota
+	large, err := other.Seal(make([]byte, 1024))
+	require.NoError(t, err)
+	err = owner.StoreBackup(holderHost.ID(), other, large)
+	pe, ok = message.AsProtocolError(err)
+	require.True(t, ok, "%v", err)
+	assert.Equal(t, message.ErrCodeQuota, pe.Code)
+
+	removed, freed, err := store.RemoveOwner(ownerHost.ID().String())
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, int64(len(sealed)), freed)
+	_, err = owner.FetchBackup(holderHost.ID(), secrets)
+	assert.Error(t, err)
+}
+
+func TestBackupSnapshotRestore(t *testing.T) {
+	dataDir, alice, bob := newDataDir(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(dataDir, message.HeldBackupsDirName), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, message.HeldBackupsDirName, "index.json"), []byte("{}"), 0600))
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	history, err := db.OpenHistory(dataDir, logger)
+	require.NoError(t, err)
+	var snapshot bytes.Buffer
+	require.NoError(t, p2p.WriteBackupSnapshot(dataDir, history, &snapshot))
+	require.NoError(t, history.Close())
+
+	restoredDir := filepath.Join(t.TempDir(), ".xelvra")
+	restored, err := p2p.RestoreBackupSnapshot(restoredDir, &snapshot)
+	require.NoError(t, err)
+	assert.Positive(t, restored)
+
+	// The identity and settings come back, received files do not
+	original, err := os.ReadFile(filepath.Join(dataDir, user.IdentityFileName))
+	require.NoError(t, err)
+	copied, err := os.ReadFile(filepath.Join(restoredDir, user.IdentityFileName))
+	require.NoError(t, err)
+	assert.Equal(t, original, copied)
+	assert.FileExists(t, filepath.Join(restoredDir, user.ContactsFileName))
+	assert.NoDirExists(t, filepath.Join(restoredDir, "downloads"))
+	assert.NoDirExists(t, filepath.Join(restoredDir, message.AttachmentsDirName))
+	assert.NoDirExists(t, filepath.Join(restoredDir, message.HeldBackupsDirName))
+
+	// The history opens with the restored key
+	history, err = db.OpenHistory(restoredDir, logger)
+	require.NoError(t, err)
+	defer func() { _ = history.Close() }()
+	entries, err := history.QueryHistory(db.HistoryQuery{})
+	require.NoError(t, err)
+	peers := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		peers = append(peers, entry.PeerID)
+		assert.Contains(t, string(entry.Content), "hello number")
+	}
+	assert.ElementsMatch(t, []string{alice, bob}, peers)
+}
@@ -0,0 +1,68 @@
+package unit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthBookTotalsAcrossRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.BandwidthFileName)
+	busy, quiet := peer.ID("busy-peer"), peer.ID("quiet-peer")
+
+	book, err := p2p.NewBandwidthBook(path)
+	require.NoError(t, err)
+	book.Counter().LogRecvMessageStream(5000, protocol.ID("/xelvra/file/1.0.0"), busy)
+	book.Counter().LogSentMessageStream(300, protocol.ID("/xelvra/message/1.0.0"), busy)
+	book.Counter().LogSentMessageStream(100, protocol.ID("/xelvra/message/1.0.0"), quiet)
+	waitForTraffic(t, book, quiet, 100)
+	require.NoError(t, book.Save())
+
+	// The next run adds to the saved totals
+	book, err = p2p.NewBandwidthBook(path)
+	require.NoError(t, err)
+	book.Counter().LogRecvMessageStream(1000, protocol.ID("/xelvra/file/1.0.0"), busy)
+	waitForTraffic(t, book, busy, 6300)
+
+	got := book.Peer(busy)
+	assert.Equal(t, int64(6000), got.BytesIn)
+	assert.Equal(t, int64(300), got.BytesOut)
+
+	traffic := book.Traffic()
+	require.Len(t, traffic, 2)
+	assert.Equal(t, busy.String(), traffic[0].PeerID, "busiest peer first")
+	assert.Equal(t, int64(100), traffic[1].BytesOut)
+
+	require.NoError(t, book.Save())
+	saved, err := p2p.LoadBandwidth(path)
+	require.NoError(t, err)
+	require.Len(t, saved, 2)
+	assert.Equal(t, int64(6300), saved[0].Total())
+}
+
+func TestBandwidthBookWithoutPath(t *testing.T) {
+	book, err := p2p.NewBandwidthBook("")
+	require.NoError(t, err)
+	book.Counter().LogSentMessageStream(10, protocol.ID("/xelvra/message/1.0.0"), peer.ID("p"))
+	waitForTraffic(t, book, peer.ID("p"), 10)
+	assert.NoError(t, book.Save())
+
+	traffic, err := p2p.LoadBandwidth(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(t, err)
+	assert.Empty(t, traffic)
+}
+
+// waitForTraffic waits for the meters, which libp2p updates every second, to
+// count total bytes for a peer
+func waitForTraffic(t *testing.T, book *p2p.BandwidthBook, id peer.ID, total int64) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return book.Peer(id).Total() == total
+	}, 5*time.Second, 50*time.Millisecond)
+}
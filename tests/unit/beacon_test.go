@@ -0,0 +1,70 @@
+package unit
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBeacon(t *testing.T, key crypto.PrivKey, seq uint64, at time.Time) []byte {
+	beacon, err := p2p.NewPresenceBeacon(key, seq, at)
+	require.NoError(t, err)
+	data, err := beacon.Marshal()
+	require.NoError(t, err)
+	return data
+}
+
+func TestPresenceBeaconReplay(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	expected, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	now := time.Now()
+	tracker := p2p.NewBeaconTracker()
+
+	first := newBeacon(t, key, 10, now)
+	peerID, err := tracker.Accept(first, now)
+	require.NoError(t, err)
+	assert.Equal(t, expected, peerID)
+
+	// The same beacon, or an older one, is a replay
+	_, err = tracker.Accept(first, now.Add(time.Second))
+	assert.Error(t, err)
+	_, err = tracker.Accept(newBeacon(t, key, 9, now), now)
+	assert.Error(t, err)
+
+	_, err = tracker.Accept(newBeacon(t, key, 11, now.Add(p2p.BeaconInterval)), now.Add(p2p.BeaconInterval))
+	assert.NoError(t, err)
+
+	// A recorded beacon replayed hours later is stale even to a fresh tracker
+	later := now.Add(3 * time.Hour)
+	_, err = p2p.NewBeaconTracker().Accept(first, later)
+	assert.Error(t, err)
+	_, err = p2p.NewBeaconTracker().Accept(newBeacon(t, key, 12, later), now)
+	assert.Error(t, err, "beacons from the future are rejected")
+}
+
+func TestPresenceBeaconForgery(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	now := time.Now()
+
+	beacon, err := p2p.NewPresenceBeacon(key, 1, now)
+	require.NoError(t, err)
+	beacon.Sequence = 2
+	forged, err := beacon.Marshal()
+	require.NoError(t, err)
+
+	tracker := p2p.NewBeaconTracker()
+	_, err = tracker.Accept(forged, now)
+	assert.Error(t, err)
+	_, err = tracker.Accept([]byte("XELVRA_PEER:"+beacon.PeerID), now)
+	assert.Error(t, err, "unsigned beacons are ignored")
+}
@@ -0,0 +1,52 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunBench(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv(user.DataDirEnv, dataDir)
+
+	var stages []string
+	report, err := p2p.RunBench(context.Background(), p2p.BenchOptions{
+		Messages: 20,
+		FileSize: 256 * 1024,
+		Progress: func(stage string) { stages = append(stages, stage) },
+	})
+	require.NoError(t, err)
+	assert.Len(t, stages, 4)
+
+	assert.Equal(t, "tcp", report.Transport)
+	assert.Equal(t, 20, report.Messages)
+	assert.Positive(t, report.LatencyMin)
+	assert.LessOrEqual(t, report.LatencyMin, report.LatencyAvg)
+	assert.LessOrEqual(t, report.LatencyP95, report.LatencyMax)
+	assert.Positive(t, report.MessagesPerSecond)
+	assert.Equal(t, int64(256*1024), report.FileSize)
+	assert.Positive(t, report.FileBytesPerSecond)
+	assert.Positive(t, report.IdleRSSBytes)
+
+	// The received file is in the data directory and the sent one is gone
+	received, err := filepath.Glob(filepath.Join(dataDir, "downloads", "bench-*.bin"))
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	info, err := os.Stat(received[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(256*1024), info.Size())
+	sent, _ := filepath.Glob(filepath.Join(dataDir, "bench-*.bin"))
+	assert.Empty(t, sent)
+
+	_, err = p2p.RunBench(context.Background(), p2p.BenchOptions{Messages: 1, Transport: "carrier-pigeon"})
+	assert.Error(t, err)
+	_, err = p2p.RunBench(context.Background(), p2p.BenchOptions{Messages: 0})
+	assert.Error(t, err)
+}
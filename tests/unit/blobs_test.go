@@ -0,0 +1,123 @@
+package unit
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := db.NewSQLiteDB(dir, "blob-test-key", logrus.New())
+	require.NoError(t, err)
+	defer store.Close()
+
+	// A second connection to inspect the tables directly
+	raw, err := sql.Open("sqlite3", filepath.Join(dir, db.DatabaseName))
+	require.NoError(t, err)
+	defer raw.Close()
+	countBlobs := func(kind string) int {
+		var n int
+		require.NoError(t, raw.QueryRow("SELECT COUNT(*) FROM blobs WHERE kind = ?", kind).Scan(&n))
+		return n
+	}
+
+	now := time.Now()
+	body := bytes.Repeat([]byte("forwarded report "), db.BlobThreshold/8)
+	for _, peerID := range []string{"peer-a", "peer-b"} {
+		require.NoError(t, store.RecordMessage(&message.Message{
+			ID: peerID + "-body", Type: message.MessageTypeText, Content: body,
+			Timestamp: now.AddDate(0, 0, -10),
+		}, peerID, false))
+		require.NoError(t, store.RecordMessage(&message.Message{
+			ID: peerID + "-file", Type: message.MessageTypeFile, Content: []byte("report.pdf"),
+			Metadata:  map[string]interface{}{"file_hash": "abc123"},
+			Timestamp: now.AddDate(0, 0, -10),
+		}, peerID, false))
+	}
+	// Recording the same message again adds no reference
+	require.NoError(t, store.RecordMessage(&message.Message{
+		ID: "peer-a-body", Type: message.MessageTypeText, Content: body,
+		Timestamp: now.AddDate(0, 0, -10),
+	}, "peer-a", false))
+
+	assert.Equal(t, 1, countBlobs("body"))
+	assert.Equal(t, 1, countBlobs("file"))
+
+	entries, err := store.QueryHistory(db.HistoryQuery{PeerID: "peer-b", Types: []message.MessageType{message.MessageTypeText}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, body, entries[0].Content)
+
+	// Pruning one conversation keeps the shared content
+	policy := &db.RetentionPolicy{Peers: map[string]db.RetentionRule{"peer-a": {KeepDays: 1}}}
+	deleted, err := store.PruneHistory(policy, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, 1, countBlobs("body"))
+	released, err := store.ReleasedFiles()
+	require.NoError(t, err)
+	assert.Empty(t, released)
+
+	// Pruning the other drops the body and releases the attachment
+	policy.Default = db.RetentionRule{KeepDays: 1}
+	deleted, err = store.PruneHistory(policy, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, 0, countBlobs("body"))
+	released, err = store.ReleasedFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc123"}, released)
+
+	require.NoError(t, store.ForgetReleasedFiles(released))
+	assert.Equal(t, 0, countBlobs("file"))
+}
+
+func TestHistoryCompact(t *testing.T) {
+	dir := t.TempDir()
+	store, err := db.NewSQLiteDB(dir, "compact-test-key", logrus.New())
+	require.NoError(t, err)
+	defer store.Close()
+
+	body := bytes.Repeat([]byte("legacy body "), db.BlobThreshold/8)
+	for _, id := range []string{"m1", "m2"} {
+		require.NoError(t, store.RecordMessage(&message.Message{
+			ID: id, Type: message.MessageTypeText, Content: body, Timestamp: time.Now(),
+		}, "peer-a", false))
+	}
+
+	// Put the bodies back inline, as recorded before the blob store existed
+	raw, err := sql.Open("sqlite3", filepath.Join(dir, db.DatabaseName))
+	require.NoError(t, err)
+	defer raw.Close()
+	_, err = raw.Exec(`UPDATE history SET content = (SELECT data FROM blobs WHERE hash = history.blob_hash), blob_hash = NULL`)
+	require.NoError(t, err)
+	_, err = raw.Exec("DELETE FROM blobs")
+	require.NoError(t, err)
+
+	result, err := store.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Moved)
+	assert.Zero(t, result.BlobsRemoved)
+
+	var blobs, refs int
+	require.NoError(t, raw.QueryRow("SELECT COUNT(*), SUM(refs) FROM blobs").Scan(&blobs, &refs))
+	assert.Equal(t, 1, blobs)
+	assert.Equal(t, 2, refs)
+
+	entries, err := store.QueryHistory(db.HistoryQuery{PeerID: "peer-a"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, body, entry.Content)
+	}
+}
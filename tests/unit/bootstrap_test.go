@@ -0,0 +1,122 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBootstrapPeers(t *testing.T) {
+	id := "12D3KooWLRPJAA5o6Z6gKYoU2mN3cXBe5H4Qs1kYyP7TUxvVYkdZ"
+	peers, err := p2p.ParseBootstrapPeers([]string{
+		"/ip4/203.0.113.7/tcp/4001/p2p/" + id,
+		"/ip4/203.0.113.7/udp/4001/quic-v1/p2p/" + id,
+	})
+	require.NoError(t, err)
+	require.Len(t, peers, 1, "addresses of one peer are merged")
+	assert.Equal(t, id, peers[0].ID.String())
+	assert.Len(t, peers[0].Addrs, 2)
+
+	_, err = p2p.ParseBootstrapPeers([]string{"/ip4/203.0.113.7/tcp/4001"})
+	assert.ErrorContains(t, err, "/p2p/")
+
+	defaults, err := p2p.ParseBootstrapPeers(p2p.DefaultBootstrapAddrs())
+	require.NoError(t, err)
+	assert.NotEmpty(t, defaults)
+}
+
+func TestSetConfigValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte("# pinned for the router\nlisten:\n  - /ip4/0.0.0.0/tcp/4001\n"), 0600))
+
+	addr := "/dns4/boot.example.org/tcp/4001/p2p/12D3KooWLRPJAA5o6Z6gKYoU2mN3cXBe5H4Qs1kYyP7TUxvVYkdZ"
+	require.NoError(t, p2p.SetConfigValue(path, "bootstrap_peers", []string{addr}))
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{addr}, config.BootstrapPeers)
+	assert.Equal(t, []string{"/ip4/0.0.0.0/tcp/4001"}, config.Listen)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# pinned for the router", "comments are kept")
+
+	// Values that would not load again are refused
+	assert.Error(t, p2p.SetConfigValue(path, "bootstrap_peers", []string{"/ip4/203.0.113.7/tcp/4001"}))
+
+	require.NoError(t, p2p.UnsetConfigValue(path, "bootstrap_peers"))
+	config, err = p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, config.BootstrapPeers)
+	assert.NotEmpty(t, config.Listen)
+}
+
+func TestCheckBootstrapPeers(t *testing.T) {
+	boot, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer func() { _ = boot.Close() }()
+
+	gone, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	goneInfo := peer.AddrInfo{ID: gone.ID(), Addrs: gone.Addrs()}
+	require.NoError(t, gone.Close())
+
+	health, err := p2p.CheckBootstrapPeers(context.Background(), []peer.AddrInfo{
+		{ID: boot.ID(), Addrs: boot.Addrs()},
+		goneInfo,
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, health, 2)
+	assert.True(t, health[0].Reachable)
+	assert.Positive(t, health[0].RTT)
+	assert.False(t, health[1].Reachable)
+	assert.NotEmpty(t, health[1].Error)
+}
+
+func TestResolveDNSAddr(t *testing.T) {
+	ids := testPeerIDs(t, 2)
+	resolver, err := madns.NewResolver(madns.WithDefaultResolver(&madns.MockResolver{
+		TXT: map[string][]string{
+			"_dnsaddr.boot.example.org": {
+				"dnsaddr=/ip4/203.0.113.7/tcp/4001/p2p/" + ids[0].String(),
+				"dnsaddr=/ip4/203.0.113.8/tcp/4001/p2p/" + ids[1].String(),
+			},
+		},
+	}))
+	require.NoError(t, err)
+
+	addrs, err := p2p.ResolveDNSAddr(context.Background(), resolver, ma.StringCast("/dnsaddr/boot.example.org"))
+	require.NoError(t, err)
+	peers, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	require.NoError(t, err)
+	require.Len(t, peers, 2)
+	for _, info := range peers {
+		// The name is dialed again on each connection, following the records
+		assert.Contains(t, info.Addrs, ma.StringCast("/dnsaddr/boot.example.org"))
+	}
+
+	_, err = p2p.ResolveDNSAddr(context.Background(), resolver, ma.StringCast("/dnsaddr/empty.example.org"))
+	assert.ErrorContains(t, err, "_dnsaddr.empty.example.org")
+	_, err = p2p.ResolveDNSAddr(context.Background(), resolver, ma.StringCast("/dnsaddr/boot.example.org/p2p/"+ids[0].String()))
+	assert.Error(t, err, "only bare names list peers")
+
+	// Bare names are valid bootstrap addresses, other addresses still name
+	// their peer
+	assert.NoError(t, p2p.ValidateBootstrapAddrs([]string{"/dnsaddr/boot.example.org", "/dns4/boot.example.org/tcp/4001/p2p/" + ids[0].String()}))
+	assert.Error(t, p2p.ValidateBootstrapAddrs([]string{"/dns4/boot.example.org/tcp/4001"}))
+
+	uri, err := p2p.ResolvePeerAddr(context.Background(), "/dnsaddr/chat.example.org/p2p/"+ids[0].String())
+	require.NoError(t, err)
+	assert.Equal(t, ids[0], uri.PeerID)
+	assert.Equal(t, []ma.Multiaddr{ma.StringCast("/dnsaddr/chat.example.org")}, uri.Addrs)
+	_, err = p2p.ResolvePeerAddr(context.Background(), "/ip4/203.0.113.7/tcp/4001")
+	assert.ErrorContains(t, err, "/p2p/")
+}
@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	local := message.Capabilities{Version: 2, MinVersion: 1, Features: []string{message.FeatureReceipts, message.FeatureCompression}}
+
+	// The highest common version and the shared features are agreed;
+	// features the node does not know are ignored
+	agreed := message.NegotiateCapabilities(local, message.Capabilities{
+		Version: 3, MinVersion: 2, Features: []string{"future-feature", message.FeatureCompression},
+	})
+	assert.True(t, agreed.Compatible())
+	assert.Equal(t, 2, agreed.Version)
+	assert.Equal(t, []string{message.FeatureCompression}, agreed.Features)
+	assert.False(t, agreed.Supports(message.FeatureReceipts))
+
+	// Version ranges that do not overlap share nothing
+	agreed = message.NegotiateCapabilities(local, message.Capabilities{Version: 5, MinVersion: 4})
+	assert.False(t, agreed.Compatible())
+	pe := agreed.IncompatibleError()
+	assert.Equal(t, message.ErrCodeIncompatible, pe.Code)
+	assert.Contains(t, pe.Message, "4 to 5")
+	assert.False(t, pe.Retryable())
+}
+
+func TestCapabilityHandshake(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	sending := newTestMessageManager(t, senderHost)
+	receiving := newTestMessageManager(t, receiverHost)
+	receiving.SetFileCompression(false)
+
+	agreed, err := sending.PeerCapabilities(receiverHost.ID())
+	require.NoError(t, err)
+	assert.Equal(t, message.ProtocolVersion, agreed.Version)
+	assert.False(t, agreed.Legacy)
+	assert.True(t, agreed.Supports(message.FeatureReceipts))
+	assert.True(t, agreed.Supports(message.FeatureNotices))
+	assert.False(t, agreed.Supports(message.FeatureCompression))
+
+	// The answering side keeps what was agreed too
+	require.Eventually(t, func() bool {
+		agreed, err := receiving.PeerCapabilities(senderHost.ID())
+		return err == nil && agreed.Supports(message.FeatureNotices)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestNoticeToLegacyPeer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+	sending := newTestMessageManager(t, senderHost)
+
+	// A peer that predates the handshake only reads messages
+	received := make(chan *message.Message, 1)
+	receiverHost.SetStreamHandler(message.MessageProtocolID, func(s network.Stream) {
+		defer func() { _ = s.Close() }()
+		var length uint32
+		if err := binary.Read(s, binary.BigEndian, &length); err != nil {
+			return
+		}
+		data := make([]byte, length)
+		var msg message.Message
+		if _, err := io.ReadFull(s, data); err == nil && json.Unmarshal(data, &msg) == nil {
+			received <- &msg
+		}
+	})
+
+	agreed, err := sending.PeerCapabilities(receiverHost.ID())
+	require.NoError(t, err)
+	assert.True(t, agreed.Legacy)
+	assert.True(t, agreed.Compatible())
+
+	notice := message.NewNotice(message.NoticeMemberLeft, "group", "Friends", "member", "carol")
+	require.NoError(t, sending.SendNotice(receiverHost.ID().String(), notice))
+	select {
+	case msg := <-received:
+		assert.Equal(t, "carol left Friends", string(msg.Content))
+		assert.Nil(t, msg.Metadata[message.MetadataKind])
+	case <-time.After(10 * time.Second):
+		t.Fatal("notice was not delivered")
+	}
+}
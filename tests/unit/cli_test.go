@@ -1,8 +1,11 @@
 package unit
 
 import (
+	"encoding/json"
+	"errors"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -56,15 +59,57 @@ func TestCLIStatus(t *testing.T) {
 	}
 }
 
+// TestCLIStatusJSON tests that --json keeps stdout to JSON
+func TestCLIStatusJSON(t *testing.T) {
+	cmd := exec.Command("../../bin/peerchat-cli", "status", "--json")
+	cmd.Env = append(os.Environ(), "HOME="+t.TempDir())
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Failed to run status command: %v", err)
+	}
+
+	var status map[string]any
+	if err := json.Unmarshal(output, &status); err != nil {
+		t.Fatalf("Status output is not JSON: %v. Got: %s", err, output)
+	}
+	if running, ok := status["is_running"]; !ok || running != false {
+		t.Errorf("Status should report no running node. Got: %s", output)
+	}
+
+	// Commands without JSON output refuse the flag
+	if err := exec.Command("../../bin/peerchat-cli", "version", "--json").Run(); err == nil {
+		t.Errorf("Version should refuse --json")
+	}
+}
+
+// TestCLIMissingConfig tests that a --config file that does not exist is a
+// configuration error
+func TestCLIMissingConfig(t *testing.T) {
+	home := t.TempDir()
+	cmd := exec.Command("../../bin/peerchat-cli", "status", "--config", filepath.Join(home, "missing.yaml"))
+	cmd.Env = append(os.Environ(), "HOME="+home)
+	output, err := cmd.CombinedOutput()
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Fatalf("Status should exit with 3 for a missing config file, got %v: %s", err, output)
+	}
+	if !strings.Contains(string(output), "does not exist") {
+		t.Errorf("Status should name the missing file. Got: %s", output)
+	}
+}
+
 // TestCLIDoctor tests the doctor command with timeout
 func TestCLIDoctor(t *testing.T) {
 	cmd := exec.Command("timeout", "5", "../../bin/peerchat-cli", "doctor")
 	output, err := cmd.Output()
 
-	// timeout command returns exit code 124 on timeout, which is expected
+	// timeout command returns exit code 124 on timeout, which is expected;
+	// failed checks, such as DNS in a sandbox, exit with the network error
+	// code 2
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
-			if exitError.ExitCode() != 124 && exitError.ExitCode() != 0 {
+			if code := exitError.ExitCode(); code != 124 && code != 0 && code != 2 {
 				t.Fatalf("Doctor command failed with unexpected exit code: %v", err)
 			}
 		} else {
@@ -83,10 +128,11 @@ func TestCLIDiscover(t *testing.T) {
 	cmd := exec.Command("timeout", "5", "../../bin/peerchat-cli", "discover")
 	output, err := cmd.Output()
 
-	// timeout command returns exit code 124 on timeout, which is expected
+	// timeout command returns exit code 124 on timeout, which is expected;
+	// without a running node discover exits with the network error code 2
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
-			if exitError.ExitCode() != 124 && exitError.ExitCode() != 0 {
+			if code := exitError.ExitCode(); code != 124 && code != 0 && code != 2 {
 				t.Fatalf("Discover command failed with unexpected exit code: %v", err)
 			}
 		} else {
@@ -114,14 +160,106 @@ func TestCLISendFileValidation(t *testing.T) {
 		}
 	}()
 
-	// Test with invalid multiaddr
+	// Test with a recipient that is neither a contact nor a peer ID
 	cmd := exec.Command("../../bin/peerchat-cli", "send-file", "invalid-multiaddr", testFile)
 	output, _ := cmd.Output() // Error is expected for invalid input
 
 	outputStr := string(output)
-	// Currently file transfer is not implemented, so we expect the "not yet implemented" message
-	if !strings.Contains(outputStr, "File transfer not yet implemented") {
-		t.Errorf("Send-file should show not implemented message. Got: %s", outputStr)
+	if !strings.Contains(outputStr, "neither a contact nor a peer ID") {
+		t.Errorf("Send-file should reject an unknown recipient. Got: %s", outputStr)
+	}
+}
+
+// TestCLISend tests that send hands messages to the running node and exits
+// with the documented codes
+func TestCLISend(t *testing.T) {
+	env := append(os.Environ(), "HOME="+t.TempDir())
+	send := func(args ...string) (string, int) {
+		cmd := exec.Command("../../bin/peerchat-cli", append([]string{"send"}, args...)...)
+		cmd.Env = env
+		output, err := cmd.Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(output), exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatalf("Failed to run send command: %v", err)
+		}
+		return string(output), 0
+	}
+	const peerID = "12D3KooWGRUVh7CA3ENe7K2oxXY6ozJ9r5pNcyPQxJ2VJzGqGpdX"
+
+	// Without a running node nothing is queued
+	output, code := send(peerID, "hello", "--json")
+	if code != 2 || !strings.Contains(output, `"queued":false`) {
+		t.Fatalf("Send without a node should exit 2 without queueing. Got %d: %s", code, output)
+	}
+
+	startCLIDaemon(t, env)
+	if output, code := send("nobody", "hello"); code != 5 {
+		t.Errorf("Send to an unknown contact should exit 5. Got %d: %s", code, output)
+	}
+	output, code = send(peerID, "hello", "--json")
+	if code != 0 || !strings.Contains(output, `"queued":true`) {
+		t.Errorf("Send through the running node should queue the message. Got %d: %s", code, output)
+	}
+}
+
+// TestCLIScript tests that scripts run on the running node and exit with
+// the status of the command that failed
+func TestCLIScript(t *testing.T) {
+	env := append(os.Environ(), "HOME="+t.TempDir())
+	script := func(commands string) (string, int) {
+		cmd := exec.Command("../../bin/peerchat-cli", "script", "--no-emoji", "--exec", commands)
+		cmd.Env = env
+		output, err := cmd.Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return string(output), exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatalf("Failed to run script command: %v", err)
+		}
+		return string(output), 0
+	}
+
+	startCLIDaemon(t, env)
+	output, code := script("/whoami; /switch all")
+	if code != 0 || !strings.Contains(output, "Peer ID:") || strings.Contains(output, "Initializing P2P node") {
+		t.Errorf("Script should run on the running node. Got %d: %s", code, output)
+	}
+	output, code = script("/msg nobody hello; /whoami")
+	if code != 5 || !strings.Contains(output, "Error: Failed to resolve nobody") || strings.Contains(output, "Peer ID:") {
+		t.Errorf("Script should stop at the unknown peer and exit 5. Got %d: %s", code, output)
+	}
+	if output, code := script("/nonsense"); code != 1 {
+		t.Errorf("Script with an unknown command should exit 1. Got %d: %s", code, output)
+	}
+}
+
+// startCLIDaemon starts a node in the background with env and waits for its
+// control socket
+func startCLIDaemon(t *testing.T, env []string) {
+	t.Helper()
+	daemon := exec.Command("../../bin/peerchat-cli", "start", "--daemon")
+	daemon.Env = env
+	if err := daemon.Start(); err != nil {
+		t.Fatalf("Failed to start daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = daemon.Process.Signal(os.Interrupt)
+		_ = daemon.Wait()
+	})
+	socket := filepath.Join(strings.TrimPrefix(env[len(env)-1], "HOME="), ".xelvra", "control.sock")
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The daemon did not open its control socket")
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
 }
 
@@ -0,0 +1,56 @@
+package unit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandStatsRanking(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cli.CommandStatsFileName)
+	stats, err := cli.LoadCommandStats(path)
+	require.NoError(t, err)
+
+	commands := []string{"/help", "/history", "/peers", "/send"}
+	assert.Equal(t, commands, stats.Rank(commands), "unused commands keep their order")
+
+	require.NoError(t, stats.Record("/send"))
+	require.NoError(t, stats.Record("/send"))
+	require.NoError(t, stats.Record("/peers"))
+	assert.Equal(t, []string{"/send", "/peers", "/help", "/history"}, stats.Rank(commands))
+
+	// Counts survive a restart
+	reloaded, err := cli.LoadCommandStats(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, reloaded.Count("/send"))
+	usage := reloaded.Usage()
+	require.Len(t, usage, 2)
+	assert.Equal(t, "/send", usage[0].Command)
+	assert.Equal(t, 2, usage[0].Count)
+
+	require.NoError(t, reloaded.Reset())
+	reloaded, err = cli.LoadCommandStats(path)
+	require.NoError(t, err)
+	assert.Empty(t, reloaded.Usage())
+}
+
+func TestCommandStatsSuggest(t *testing.T) {
+	stats, err := cli.LoadCommandStats("")
+	require.NoError(t, err)
+	commands := []string{"/help", "/history", "/peers", "/pin", "/pins", "/search", "/send", "/status"}
+
+	assert.Equal(t, "/help", stats.Suggest("/hlep", commands), "swapped letters")
+	assert.Equal(t, "/history", stats.Suggest("/histroy", commands))
+	assert.Equal(t, "/search", stats.Suggest("/serach", commands))
+	assert.Equal(t, "/history", stats.Suggest("/hist", commands), "unique prefix")
+	assert.Empty(t, stats.Suggest("/xyzzy", commands))
+	assert.Empty(t, stats.Suggest("/", commands))
+
+	// Equally close commands are resolved by usage
+	assert.Equal(t, "/send", stats.Suggest("/sesd", commands))
+	require.NoError(t, stats.Record("/pins"))
+	assert.Equal(t, "/pins", stats.Suggest("/pinx", commands))
+}
@@ -0,0 +1,369 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFileDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, p2p.DefaultConfigFile(), config, "a missing file takes the defaults")
+
+	// Sections left out, or only partly set, take the defaults too
+	require.NoError(t, os.WriteFile(path, []byte("# nothing set yet\nnetwork:\n  enable_tcp: false\n"), 0600))
+	config, err = p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.True(t, config.Network.EnableQUIC)
+	assert.False(t, config.Network.EnableTCP)
+	assert.Equal(t, "info", config.Logging.Level)
+	assert.Equal(t, p2p.LogFormatJSON, config.Logging.Format)
+	assert.Empty(t, config.Unknown)
+}
+
+func TestConfigFileSections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte(`
+network:
+  enable_quic: false
+logging:
+  level: debug
+  format: text
+hooks:
+  on_message: notify.sh
+`), 0600))
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.False(t, config.Network.EnableQUIC)
+	assert.True(t, config.Network.EnableTCP)
+	assert.Equal(t, "debug", config.Logging.Level)
+	assert.Equal(t, p2p.LogFormatText, config.Logging.Format)
+	assert.Equal(t, "notify.sh", config.Hooks.OnMessage)
+}
+
+func TestConfigFileUnknownSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte(`
+listen: []
+rendevous: [xelvra/v1]
+network:
+  enable_quic: true
+  enable_udp: true
+`), 0600))
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err, "unknown settings are ignored")
+	assert.Equal(t, []string{"network.enable_udp", "rendevous"}, config.Unknown)
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"malformed YAML", "listen:\n  - /ip4/0.0.0.0/tcp/4001\n bad indent: [\n", "failed to parse " + path},
+		{"not a mapping", "- listen\n", "failed to parse"},
+		{"wrong type", "network:\n  enable_quic: sometimes\n", "enable_quic"},
+		{"no transport", "network:\n  enable_quic: false\n  enable_tcp: false\n", "cannot both be false"},
+		{"bad level", "logging:\n  level: loud\n", "logging: invalid level"},
+		{"bad format", "logging:\n  format: xml\n", "logging: invalid format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+			_, err := p2p.LoadConfigFile(path)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestSetConfigValueKeepsDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte("# transports\nnetwork:\n  enable_tcp: false\n"), 0600))
+
+	// Editing another setting validates the result with the defaults applied
+	require.NoError(t, p2p.SetConfigValue(path, "rendezvous", []string{"xelvra/v1"}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# transports")
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"xelvra/v1"}, config.Rendezvous)
+	assert.False(t, config.Network.EnableTCP)
+}
+
+func TestConfigKeys(t *testing.T) {
+	keys := p2p.ConfigKeys()
+	assert.Contains(t, keys, "listen")
+	assert.Contains(t, keys, "hooks.on_message")
+	assert.Contains(t, keys, "discovery.mdns")
+	assert.NotContains(t, keys, "unknown")
+
+	config := p2p.DefaultConfigFile()
+	value, err := config.Get("discovery.mdns")
+	require.NoError(t, err)
+	assert.Equal(t, true, value)
+	_, err = config.Get("discovery.bluetooth")
+	assert.ErrorContains(t, err, "unknown setting")
+	_, err = config.Get("discovery")
+	assert.ErrorContains(t, err, "section")
+
+	value, err = p2p.ParseConfigValue("discovery.mdns", []string{"false"})
+	require.NoError(t, err)
+	assert.Equal(t, false, value)
+	_, err = p2p.ParseConfigValue("discovery.mdns", []string{"maybe"})
+	assert.Error(t, err)
+	value, err = p2p.ParseConfigValue("rendezvous", []string{"xelvra/v1", "team"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"xelvra/v1", "team"}, value)
+	_, err = p2p.ParseConfigValue("logging.level", []string{"debug", "info"})
+	assert.Error(t, err, "one value only")
+
+	updated := p2p.DefaultConfigFile()
+	updated.Discovery.MDNS = false
+	updated.Listen = []string{}
+	assert.Equal(t, []string{"discovery.mdns"}, p2p.ChangedConfigKeys(config, updated))
+}
+
+func TestSetNestedConfigValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte("# my node\nlogging:\n  level: info # quiet\n"), 0600))
+
+	require.NoError(t, p2p.SetConfigValue(path, "discovery.mdns", false))
+	require.NoError(t, p2p.SetConfigValue(path, "logging.level", "debug"))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# my node")
+	assert.Contains(t, string(data), "level: debug # quiet")
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.False(t, config.Discovery.MDNS)
+	assert.Equal(t, "debug", config.Logging.Level)
+
+	// An invalid value is refused and leaves the file alone
+	assert.Error(t, p2p.SetConfigValue(path, "logging.level", "loud"))
+	config, err = p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "debug", config.Logging.Level)
+
+	// Removing the last setting of a section removes the section
+	require.NoError(t, p2p.UnsetConfigValue(path, "discovery.mdns"))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "discovery")
+}
+
+func TestConfigFileEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte("logging:\n  level: warn\ndiscovery:\n  mdns: true\n"), 0600))
+
+	t.Setenv("XELVRA_DISCOVERY_MDNS", "false")
+	t.Setenv("XELVRA_LISTEN", "/ip4/0.0.0.0/tcp/4001, /ip4/0.0.0.0/udp/4001/quic-v1")
+	t.Setenv(p2p.LogLevelEnv, "debug")
+	t.Setenv("XELVRA_LOGGING_FORMAT", "")
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.False(t, config.Discovery.MDNS)
+	assert.Equal(t, []string{"/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4001/quic-v1"}, config.Listen)
+	assert.Equal(t, "debug", config.Logging.Level)
+	assert.Equal(t, p2p.LogFormatJSON, config.Logging.Format, "an empty variable is ignored")
+	assert.Equal(t, map[string]string{
+		"discovery.mdns": "XELVRA_DISCOVERY_MDNS",
+		"listen":         "XELVRA_LISTEN",
+		"logging.level":  p2p.LogLevelEnv,
+	}, config.Overrides)
+
+	// The full name wins over the alias, and a missing file still applies
+	t.Setenv("XELVRA_LOGGING_LEVEL", "error")
+	config, err = p2p.LoadConfigFile(filepath.Join(t.TempDir(), p2p.ConfigFileName))
+	require.NoError(t, err)
+	assert.Equal(t, "error", config.Logging.Level)
+	assert.Equal(t, "XELVRA_LOGGING_LEVEL", p2p.ConfigEnvOverride("logging.level"))
+	assert.Empty(t, p2p.ConfigEnvName("swarm_key"), "XELVRA_SWARM_KEY has its own meaning")
+
+	t.Setenv("XELVRA_DISCOVERY_MDNS", "maybe")
+	_, err = p2p.LoadConfigFile(path)
+	assert.ErrorIs(t, err, p2p.ErrConfigEnvironment)
+	assert.ErrorContains(t, err, "XELVRA_DISCOVERY_MDNS")
+
+	t.Setenv("XELVRA_DISCOVERY_MDNS", "")
+	t.Setenv("XELVRA_NETWORK_ENABLE_QUIC", "0")
+	t.Setenv("XELVRA_NETWORK_ENABLE_TCP", "false")
+	_, err = p2p.LoadConfigFile(path)
+	assert.ErrorContains(t, err, "cannot both be false")
+}
+
+func TestDataDirEnvironment(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	t.Setenv(user.DataDirEnv, "")
+	dir, err := user.DataDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".xelvra"), dir)
+
+	t.Setenv(user.DataDirEnv, "~/containers/xelvra")
+	dir, err = user.DataDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "containers", "xelvra"), dir)
+
+	t.Setenv(user.DataDirEnv, "/data/xelvra")
+	dir, err = user.DataDir()
+	require.NoError(t, err)
+	assert.Equal(t, "/data/xelvra", dir)
+}
+
+func TestConfigProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte(`
+listen: [/ip4/0.0.0.0/tcp/4001]
+profiles:
+  Work:
+    match:
+      ssid: [CorpWiFi]
+      subnet: [10.20.0.0/16]
+    relays: [/ip4/203.0.113.9/tcp/443/p2p/12D3KooWGRYZDHBembyGJQqQ6WgLqJWkw5mq4eMV7Br6W6aXm3Dq]
+    network:
+      enable_quic: false
+    discovery:
+      mdns: false
+      udp_broadcst: false
+  home: {}
+`), 0600))
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"home", "work"}, config.ProfileNames(), "names are lower case")
+	assert.Equal(t, []string{"profiles.work.discovery.udp_broadcst"}, config.Unknown)
+	assert.NotContains(t, p2p.ConfigKeys(), "profiles")
+
+	assert.Equal(t, "work", config.MatchProfile(p2p.NetworkID{Interface: "wlan0", SSID: "CorpWiFi", Subnet: "192.168.1.0/24"}))
+	assert.Equal(t, "work", config.MatchProfile(p2p.NetworkID{Interface: "eth0", Subnet: "10.20.4.0/24"}))
+	assert.Empty(t, config.MatchProfile(p2p.NetworkID{Interface: "eth0", Subnet: "10.21.0.0/24"}))
+
+	work, err := config.WithProfile("WORK")
+	require.NoError(t, err)
+	assert.Equal(t, "work", work.Profile)
+	assert.False(t, work.Network.EnableQUIC)
+	assert.False(t, work.Discovery.MDNS)
+	assert.True(t, work.Discovery.UDPBroadcast, "settings the profile leaves out are kept")
+	assert.Equal(t, []string{"/ip4/0.0.0.0/tcp/4001"}, work.Listen)
+	assert.Len(t, work.Relays, 1)
+	assert.True(t, config.Network.EnableQUIC, "the configuration itself is unchanged")
+
+	// The environment wins over a profile
+	t.Setenv("XELVRA_DISCOVERY_MDNS", "true")
+	config, err = p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	work, err = config.WithProfile("work")
+	require.NoError(t, err)
+	assert.True(t, work.Discovery.MDNS)
+
+	_, err = config.WithProfile("cafe")
+	assert.ErrorContains(t, err, `no profile "cafe"`)
+}
+
+func TestConfigProfilesInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown profile", "profile: cafe\nprofiles:\n  work: {}\n", `no profile "cafe"`},
+		{"bad subnet", "profiles:\n  work:\n    match:\n      subnet: [10.20.0.0]\n", "profiles.work.match: invalid subnet"},
+		{"relay without peer ID", "profiles:\n  work:\n    relays: [/ip4/203.0.113.9/tcp/443]\n", "profiles.work.relays"},
+		{"no transport", "network:\n  enable_tcp: false\nprofiles:\n  work:\n    network:\n      enable_quic: false\n", "profiles.work.network"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+			_, err := p2p.LoadConfigFile(path)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestConfigFileMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	legacy := []byte(`# Network configuration
+listen_addrs:
+  - /ip4/0.0.0.0/tcp/4001
+log_level: debug
+log_file: ~/.xelvra/peerchat.log
+discovery:
+  mdns_enabled: false
+  dht_enabled: true
+`)
+	require.NoError(t, os.WriteFile(path, legacy, 0600))
+
+	// An old file is read as migrated even before it is rewritten
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, config.Version)
+	assert.Equal(t, []string{"/ip4/0.0.0.0/tcp/4001"}, config.Listen)
+	assert.Equal(t, "debug", config.Logging.Level)
+	assert.False(t, config.Discovery.MDNS)
+	assert.Empty(t, config.Unknown)
+
+	migration, err := p2p.MigrateConfigFile(path)
+	require.NoError(t, err)
+	require.NotNil(t, migration)
+	assert.Equal(t, 1, migration.From)
+	assert.Equal(t, p2p.ConfigVersion, migration.To)
+	assert.Equal(t, []string{
+		"listen_addrs → listen",
+		"log_level → logging.level",
+		"discovery.mdns_enabled → discovery.mdns",
+		"log_file removed: the log is always peerchat.log in the data directory",
+		"discovery.dht_enabled removed: the DHT is always used",
+	}, migration.Changes)
+
+	backup, err := os.ReadFile(path + p2p.ConfigBackupSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, legacy, backup)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# Network configuration\nlisten:")
+
+	config, err = p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, p2p.ConfigVersion, config.Version)
+	assert.False(t, config.Discovery.MDNS)
+
+	// Nothing is left to migrate
+	migration, err = p2p.MigrateConfigFile(path)
+	require.NoError(t, err)
+	assert.Nil(t, migration)
+
+	// Newer files are read as they are, invalid versions refused
+	require.NoError(t, os.WriteFile(path, []byte("version: 7\nlogging:\n  level: warn\n"), 0600))
+	config, err = p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 7, config.Version)
+	require.NoError(t, os.WriteFile(path, []byte("version: two\n"), 0600))
+	_, err = p2p.LoadConfigFile(path)
+	assert.Error(t, err)
+
+	// New files record the version
+	fresh := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, p2p.SetConfigValue(fresh, "logging.level", "debug"))
+	config, err = p2p.LoadConfigFile(fresh)
+	require.NoError(t, err)
+	assert.Equal(t, p2p.ConfigVersion, config.Version)
+}
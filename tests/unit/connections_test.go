@@ -0,0 +1,61 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnManagerWatermarks(t *testing.T) {
+	_, err := p2p.NewConnManager(0, 0)
+	assert.NoError(t, err)
+	_, err = p2p.NewConnManager(0, 10)
+	assert.NoError(t, err, "a high watermark alone keeps half")
+	_, err = p2p.NewConnManager(100, 0)
+	assert.NoError(t, err, "a low watermark alone raises the default high one")
+	_, err = p2p.NewConnManager(20, 10)
+	assert.Error(t, err)
+}
+
+func TestConnManagerKeepsProtectedPeers(t *testing.T) {
+	cm, err := p2p.NewConnManager(1, 2, connmgr.WithGracePeriod(0))
+	require.NoError(t, err)
+	node, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.ConnectionManager(cm))
+	require.NoError(t, err)
+	defer node.Close()
+
+	var strangers []host.Host
+	for i := 0; i < 4; i++ {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		defer h.Close()
+		require.NoError(t, node.Connect(context.Background(), peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}))
+		strangers = append(strangers, h)
+	}
+	contact, transfer := strangers[0].ID(), strangers[1].ID()
+
+	protector := p2p.NewConnProtector(cm)
+	protector.Update("contact", []peer.ID{contact})
+	protector.Update("transfer", []peer.ID{transfer, contact})
+	assert.Equal(t, 2, protector.Count())
+
+	cm.TrimOpenConns(context.Background())
+	assert.Equal(t, network.Connected, node.Network().Connectedness(contact))
+	assert.Equal(t, network.Connected, node.Network().Connectedness(transfer))
+	assert.LessOrEqual(t, len(node.Network().Peers()), 3, "strangers are pruned")
+
+	// Once the transfer is over the peer may be pruned; the contact is kept
+	// while it is still listed under another tag
+	protector.Update("transfer", nil)
+	assert.Equal(t, 1, protector.Count())
+	assert.True(t, cm.IsProtected(contact, "contact"))
+	assert.False(t, cm.IsProtected(transfer, "transfer"))
+}
@@ -0,0 +1,196 @@
+package unit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyChangeAnnouncement(t *testing.T) {
+	oldID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	newID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	ann, err := user.NewKeyChangeAnnouncement(oldID, newID)
+	require.NoError(t, err)
+	assert.NoError(t, ann.Verify())
+
+	// Tampering with the new key must invalidate the old key's signature
+	other, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	ann.NewPeerID = other.GetPeerID().String()
+	assert.Error(t, ann.Verify())
+}
+
+func TestContactKeyChangeRequiresVerification(t *testing.T) {
+	local, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	oldID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	newID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), user.ContactsFileName)
+	contacts, err := user.LoadContactBook(path)
+	require.NoError(t, err)
+
+	_, err = contacts.Add("alice", oldID.GetPeerID().String(), oldID.GetDID())
+	require.NoError(t, err)
+	assert.NoError(t, contacts.CheckSendAllowed(oldID.GetPeerID().String()))
+
+	ann, err := user.NewKeyChangeAnnouncement(oldID, newID)
+	require.NoError(t, err)
+
+	contact, err := contacts.ApplyKeyChange(ann)
+	require.NoError(t, err)
+	assert.True(t, contact.NeedsVerification())
+	assert.Equal(t, newID.GetPeerID().String(), contact.PeerID)
+	assert.Error(t, contacts.CheckSendAllowed(newID.GetPeerID().String()))
+
+	// The warning state survives a reload
+	reloaded, err := user.LoadContactBook(path)
+	require.NoError(t, err)
+	assert.Error(t, reloaded.CheckSendAllowed(newID.GetPeerID().String()))
+
+	require.NoError(t, reloaded.Verify("alice"))
+	assert.NoError(t, reloaded.CheckSendAllowed(newID.GetPeerID().String()))
+
+	// Safety numbers are the same from both sides
+	a, err := user.SafetyNumber(local.GetPeerID().String(), newID.GetPeerID().String())
+	require.NoError(t, err)
+	b, err := user.SafetyNumber(newID.GetPeerID().String(), local.GetPeerID().String())
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.Len(t, a, 12*5+11)
+}
+
+func TestUnannouncedKeyChangeRequiresVerification(t *testing.T) {
+	oldID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	newID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	contacts, err := user.OpenContactBook(user.NewMemoryContacts())
+	require.NoError(t, err)
+	_, err = contacts.Add("alice", oldID.GetPeerID().String(), oldID.GetDID())
+	require.NoError(t, err)
+	_, err = contacts.Add("alice", oldID.GetPeerID().String(), oldID.GetDID())
+	assert.Error(t, err, "the same key is already pinned")
+
+	// A new invite or link from alice brings a key she never announced
+	contact, err := contacts.Add("alice", newID.GetPeerID().String(), newID.GetDID())
+	require.NoError(t, err)
+	assert.True(t, contact.NeedsVerification())
+	assert.Equal(t, oldID.GetPeerID().String(), contact.PreviousPeerID)
+	assert.Error(t, contacts.CheckSendAllowed(newID.GetPeerID().String()))
+}
+
+func TestIdentityRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), user.IdentityFileName)
+
+	id, created, err := user.LoadOrCreateMessengerID(path)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	loaded, created, err := user.LoadOrCreateMessengerID(path)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, id.GetPeerID(), loaded.GetPeerID())
+
+	newID, ann, err := user.RotateMessengerID(path)
+	require.NoError(t, err)
+	assert.Equal(t, id.GetPeerID().String(), ann.OldPeerID)
+	assert.NotEqual(t, id.GetPeerID(), newID.GetPeerID())
+
+	pending, delivered, err := user.LoadPendingAnnouncement(path)
+	require.NoError(t, err)
+	require.NotNil(t, pending)
+	assert.NoError(t, pending.Verify())
+	assert.Empty(t, delivered)
+
+	// Deliveries are kept with the announcement until it is cleared
+	contact, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	delivered, err = user.AnnouncementDelivered(path, contact.GetPeerID().String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{contact.GetPeerID().String()}, delivered)
+	pending, delivered, err = user.LoadPendingAnnouncement(path)
+	require.NoError(t, err)
+	require.NotNil(t, pending)
+	assert.Len(t, delivered, 1)
+
+	require.NoError(t, user.ClearPendingAnnouncement(path))
+	pending, delivered, err = user.LoadPendingAnnouncement(path)
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+	assert.Empty(t, delivered)
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	a, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	b, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	fingerprint, err := user.KeyFingerprint(a.GetPeerID().String())
+	require.NoError(t, err)
+	assert.Regexp(t, `^\d{5}( \d{5}){5}$`, fingerprint)
+
+	again, err := user.KeyFingerprint(a.GetPeerID().String())
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, again)
+	other, err := user.KeyFingerprint(b.GetPeerID().String())
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, other)
+
+	_, err = user.KeyFingerprint("not-a-peer-id")
+	assert.Error(t, err)
+}
+
+func TestContactPolicy(t *testing.T) {
+	var policy user.ContactPolicy
+	require.NoError(t, policy.Set(user.PolicyAutoAccept, "10"))
+	require.NoError(t, policy.Set(user.PolicyNotify, "Quiet"))
+	require.NoError(t, policy.Set(user.PolicyReadReceipts, "on"))
+	require.NoError(t, policy.Set(user.PolicyDisappear, "7d"))
+	assert.Equal(t, int64(10<<20), policy.AutoAcceptBytes())
+	assert.Equal(t, user.NotifyQuiet, policy.NotifyLevel())
+	assert.Equal(t, 7*24*time.Hour, policy.DisappearAfter())
+	assert.Equal(t, "auto-accept 10 MB, notify quiet, read-receipts on, disappear 7d", policy.String())
+
+	assert.Error(t, policy.Set(user.PolicyNotify, "loud"))
+	assert.Error(t, policy.Set(user.PolicyDisappear, "10s"), "shorter than a minute")
+	assert.Error(t, policy.Set(user.PolicyAutoAccept, "lots"))
+	assert.Error(t, policy.Set("colour", "blue"))
+
+	id, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), user.ContactsFileName)
+	contacts, err := user.LoadContactBook(path)
+	require.NoError(t, err)
+	_, err = contacts.Add("alice", id.GetPeerID().String(), id.GetDID())
+	require.NoError(t, err)
+	assert.True(t, contacts.PolicyFor(id.GetPeerID().String()).IsZero())
+
+	policy = user.ContactPolicy{AutoAcceptMB: 5, Notify: user.NotifyMuted, DisappearSeconds: 3600}
+	_, err = contacts.SetPolicy("alice", policy)
+	require.NoError(t, err)
+	_, err = contacts.SetPolicy("bob", policy)
+	assert.Error(t, err)
+
+	// The policy is stored with the contact
+	reloaded, err := user.LoadContactBook(path)
+	require.NoError(t, err)
+	assert.Equal(t, policy, reloaded.PolicyFor(id.GetPeerID().String()))
+
+	// The default policy is not stored
+	contact, err := reloaded.SetPolicy("alice", user.ContactPolicy{})
+	require.NoError(t, err)
+	assert.Nil(t, contact.Policy)
+	assert.True(t, contact.EffectivePolicy().IsZero())
+}
@@ -0,0 +1,145 @@
+package unit
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/cli"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationTabs(t *testing.T) {
+	tabs := cli.NewConversationTabs()
+	label := func(peerID string) string { return peerID }
+
+	assert.Equal(t, "> ", tabs.Prompt(label))
+	assert.Empty(t, tabs.Next(), "nothing to cycle through yet")
+
+	// Messages arriving without a current conversation are all unread
+	tabs.Received("alice")
+	tabs.Received("alice")
+	tabs.Received("bob")
+	assert.Equal(t, "[all (3)] > ", tabs.Prompt(label))
+
+	tabs.Switch("alice")
+	assert.Equal(t, 0, tabs.Unread("alice"))
+	assert.Equal(t, "[alice (1)] > ", tabs.Prompt(label))
+
+	// Messages of the current conversation are read as they arrive
+	tabs.Received("alice")
+	assert.Equal(t, 0, tabs.Unread("alice"))
+
+	assert.Equal(t, "bob", tabs.Next())
+	assert.Equal(t, "[bob] > ", tabs.Prompt(label))
+	assert.Equal(t, "alice", tabs.Next(), "cycling wraps around")
+	assert.Equal(t, "bob", tabs.Prev())
+
+	tabs.Switch("carol")
+	assert.Equal(t, []cli.ConversationTab{
+		{PeerID: "alice"},
+		{PeerID: "bob"},
+		{PeerID: "carol", Current: true},
+	}, tabs.Tabs())
+
+	tabs.Switch("")
+	assert.Empty(t, tabs.Current())
+	assert.Equal(t, "> ", tabs.Prompt(label))
+	assert.Equal(t, "carol", tabs.Prev(), "from all peers, /prev goes to the last conversation")
+}
+
+// fakeChatPeers is a node with fixed peers and contacts, resolving
+// @nicknames from a map
+type fakeChatPeers struct {
+	connected []string
+	contacts  []*user.Contact
+	names     map[string]string
+}
+
+func (f *fakeChatPeers) GetConnectedPeers() []string   { return f.connected }
+func (f *fakeChatPeers) ListContacts() []*user.Contact { return f.contacts }
+func (f *fakeChatPeers) ResolvePeer(target string) (string, error) {
+	if peerID, ok := f.names[target]; ok {
+		return peerID, nil
+	}
+	return "", fmt.Errorf("name %s not found", target)
+}
+
+func newTestPeerID(t *testing.T) string {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	return id.String()
+}
+
+func TestResolveChatPeer(t *testing.T) {
+	first, second, alice, offline := newTestPeerID(t), newTestPeerID(t), newTestPeerID(t), newTestPeerID(t)
+	peers := &fakeChatPeers{
+		connected: []string{first, second},
+		contacts:  []*user.Contact{{Name: "Alice", PeerID: alice}},
+		names:     map[string]string{"@bob": second},
+	}
+
+	// Contact names match whatever their case
+	resolved, err := cli.ResolveChatPeer(peers, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, alice, resolved)
+
+	// @names go to the name system, even when a contact has the same name
+	peers.contacts = append(peers.contacts, &user.Contact{Name: "@bob", PeerID: alice})
+	resolved, err = cli.ResolveChatPeer(peers, "@bob")
+	require.NoError(t, err)
+	assert.Equal(t, second, resolved)
+	_, err = cli.ResolveChatPeer(peers, "@nobody")
+	assert.Error(t, err)
+
+	// A prefix picks the one connected peer it starts, and is refused when
+	// it starts more than one
+	resolved, err = cli.ResolveChatPeer(peers, first[:len(first)-2])
+	require.NoError(t, err)
+	assert.Equal(t, first, resolved)
+	_, err = cli.ResolveChatPeer(peers, "12D3KooW")
+	require.Error(t, err)
+	assert.Equal(t, "12D3KooW matches 2 connected peers, type more of the peer ID", err.Error())
+
+	// Anything else must be a whole peer ID
+	resolved, err = cli.ResolveChatPeer(peers, offline)
+	require.NoError(t, err)
+	assert.Equal(t, offline, resolved)
+	_, err = cli.ResolveChatPeer(peers, "carol")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a contact, a connected peer or a peer ID")
+}
+
+func TestCompleterContactNames(t *testing.T) {
+	connected := newTestPeerID(t)
+	completer := &cli.InteractiveCompleter{}
+	completer.UpdatePeers(&fakeChatPeers{
+		connected: []string{connected},
+		contacts:  []*user.Contact{{Name: "alice"}, {Name: "albert"}, {Name: "bob"}},
+	})
+	complete := func(line string) []string {
+		completions, _ := completer.Do([]rune(line), len([]rune(line)))
+		var words []string
+		for _, c := range completions {
+			words = append(words, string(c))
+		}
+		return words
+	}
+
+	for _, command := range []string{"/msg", "/send", "/switch", "/reset-session"} {
+		assert.ElementsMatch(t, []string{"ice", "bert"}, complete(command+" al"), command)
+		assert.Contains(t, complete(command+" "), "bob", command)
+		assert.Contains(t, complete(command+" "), connected, command)
+	}
+
+	// /connect takes peer IDs only
+	assert.Empty(t, complete("/connect al"))
+	assert.Equal(t, []string{connected}, complete("/connect "))
+	assert.Equal(t, []string{connected[4:]}, complete("/connect "+connected[:4]))
+}
@@ -142,3 +142,77 @@ func TestDecryptInvalidCiphertext(t *testing.T) {
 	_, err = sc.DecryptMessage(corruptedCiphertext, chainKey)
 	assert.Error(t, err)
 }
+
+func TestSharedBody(t *testing.T) {
+	plaintext := []byte("Announcement for the whole list")
+
+	shared, err := crypto.SealSharedBody(plaintext)
+	require.NoError(t, err)
+	assert.Len(t, shared.Key, crypto.AESKeySize)
+	assert.NotContains(t, string(shared.Body), string(plaintext))
+
+	opened, err := crypto.OpenSharedBody(shared.Key, shared.Body)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Every send gets its own content key
+	other, err := crypto.SealSharedBody(plaintext)
+	require.NoError(t, err)
+	assert.NotEqual(t, shared.Key, other.Key)
+	_, err = crypto.OpenSharedBody(other.Key, shared.Body)
+	assert.Error(t, err)
+
+	_, err = crypto.OpenSharedBody(shared.Key[:16], shared.Body)
+	assert.Error(t, err)
+
+	shared.Body[len(shared.Body)-1] ^= 1
+	_, err = crypto.OpenSharedBody(shared.Key, shared.Body)
+	assert.Error(t, err)
+}
+
+// The shared body benchmarks compare sealing one body for a list and its key
+// in each recipient's session against sealing the body in every session
+func benchmarkSessions(b *testing.B, n int) []*crypto.Session {
+	alice, err := crypto.NewSignalCrypto()
+	require.NoError(b, err)
+	sessions := make([]*crypto.Session, n)
+	for i := range sessions {
+		bob, err := crypto.NewSignalCrypto()
+		require.NoError(b, err)
+		sessions[i], err = alice.NewInitiatorSession(bob.GetIdentityKey())
+		require.NoError(b, err)
+	}
+	return sessions
+}
+
+func BenchmarkSharedBody(b *testing.B) {
+	body := make([]byte, 64*1024)
+	sessions := benchmarkSessions(b, 100)
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		shared, err := crypto.SealSharedBody(body)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, session := range sessions {
+			if _, _, err := session.Seal(shared.Key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkSharedBodyPerRecipient(b *testing.B) {
+	body := make([]byte, 64*1024)
+	sessions := benchmarkSessions(b, 100)
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, session := range sessions {
+			if _, _, err := session.Seal(body); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
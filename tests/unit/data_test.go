@@ -0,0 +1,225 @@
+package unit
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/cli"
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDataDir fills a data directory with a conversation, files and settings
+// for two contacts and returns it with their peer IDs
+func newDataDir(t *testing.T) (string, string, string) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dataDir := filepath.Join(home, ".xelvra")
+	require.NoError(t, os.MkdirAll(dataDir, 0700))
+
+	identity, _, err := user.LoadOrCreateMessengerID(filepath.Join(dataDir, user.IdentityFileName))
+	require.NoError(t, err)
+	identity.Destroy()
+
+	peers := make([]string, 2)
+	for i := range peers {
+		id, err := user.GenerateMessengerID()
+		require.NoError(t, err)
+		peers[i] = id.GetPeerID().String()
+	}
+	alice, bob := peers[0], peers[1]
+
+	contacts, err := user.LoadContactBook(filepath.Join(dataDir, user.ContactsFileName))
+	require.NoError(t, err)
+	_, err = contacts.Add("alice", alice, "")
+	require.NoError(t, err)
+	_, err = contacts.Add("bob", bob, "")
+	require.NoError(t, err)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	history, err := db.OpenHistory(dataDir, logger)
+	require.NoError(t, err)
+	for i, peerID := range peers {
+		msg := &message.Message{
+			ID:        "message-" + peerID[len(peerID)-6:],
+			Type:      message.MessageTypeText,
+			Content:   []byte("hello number " + string(rune('1'+i))),
+			Timestamp: time.Now(),
+		}
+		require.NoError(t, history.RecordMessage(msg, peerID, false))
+	}
+	_, err = history.StarMessage("message-"+alice[len(alice)-6:], true)
+	require.NoError(t, err)
+	require.NoError(t, history.Close())
+
+	// One file only alice sent, one both sent
+	store := message.NewAttachmentStore(filepath.Join(dataDir, message.AttachmentsDirName), logger)
+	downloads := filepath.Join(dataDir, "downloads")
+	require.NoError(t, os.MkdirAll(downloads, 0700))
+	own, shared := writeRandomFile(t, 2048), writeRandomFile(t, 4096)
+	for _, receipt := range []struct{ src, name, peerID string }{
+		{own, "alice.bin", alice}, {shared, "shared-a.bin", alice}, {shared, "shared-b.bin", bob},
+	} {
+		part, metadata := receivedFile(t, receipt.src, receipt.name)
+		_, err := store.Add(part, filepath.Join(downloads, receipt.name), metadata, receipt.peerID, receipt.name)
+		require.NoError(t, err)
+	}
+
+	profilesPath := filepath.Join(dataDir, user.ProfilesFileName)
+	profiles, err := user.LoadProfileSettings(profilesPath)
+	require.NoError(t, err)
+	require.NoError(t, profiles.SetFacet(&user.ProfileFacet{Name: "work", DisplayName: "Jane"}))
+	require.NoError(t, profiles.Assign(alice, "work"))
+	require.NoError(t, profiles.Save(profilesPath))
+
+	require.NoError(t, db.SaveRetentionPolicy(filepath.Join(dataDir, db.RetentionFileName), &db.RetentionPolicy{
+		Peers: map[string]db.RetentionRule{alice: {KeepDays: 7}, bob: {KeepDays: 30}},
+	}))
+	require.NoError(t, message.SaveFileAllowlist(filepath.Join(dataDir, message.FileAllowlistFileName), peers))
+	require.NoError(t, p2p.SetTransportPin(alice, p2p.TransportNoRelay))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "chat_history"),
+		[]byte("/peers\n/send alice hi\n/history @alice\n/send bob hi\n"), 0600))
+
+	return dataDir, alice, bob
+}
+
+func TestDataExport(t *testing.T) {
+	dataDir, alice, bob := newDataDir(t)
+
+	var archive bytes.Buffer
+	manifest, err := cli.ExportUserData(dataDir, &archive, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, manifest.Contacts)
+	assert.Equal(t, 2, manifest.Messages)
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		_ = rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	var stored cli.DataManifest
+	require.NoError(t, json.Unmarshal([]byte(files[cli.DataManifestName]), &stored))
+	assert.Equal(t, manifest.PeerID, stored.PeerID)
+
+	// History is included decrypted, the database and keys are not
+	assert.Contains(t, files["history.json"], alice)
+	assert.Contains(t, files["history.json"], bob)
+	assert.Contains(t, files["identity.json"], manifest.PeerID)
+	assert.NotContains(t, files["identity.json"], "private_key")
+	for name := range files {
+		assert.NotEqual(t, "data/"+user.IdentityFileName, name)
+		assert.False(t, strings.HasPrefix(name, "data/"+db.DatabaseName), name)
+		assert.NotEqual(t, "data/"+db.KeyFileName, name)
+	}
+	assert.Contains(t, files["data/"+user.ContactsFileName], alice)
+	assert.Contains(t, files["data/chat_history"], "/send bob hi")
+
+	// Downloads linked to stored attachments are archived once
+	linked := 0
+	for _, f := range stored.Files {
+		if strings.HasPrefix(f.Path, "data/downloads/") {
+			assert.NotEmpty(t, f.SameAs, f.Path)
+			assert.NotContains(t, files, f.Path)
+			linked++
+		}
+	}
+	assert.Equal(t, 3, linked)
+
+	// Without received files the archive holds no attachments
+	archive.Reset()
+	manifest, err = cli.ExportUserData(dataDir, &archive, false)
+	require.NoError(t, err)
+	for _, f := range manifest.Files {
+		assert.False(t, strings.HasPrefix(f.Path, "data/attachments/objects/"), f.Path)
+	}
+}
+
+func TestDataErasePeer(t *testing.T) {
+	dataDir, alice, bob := newDataDir(t)
+
+	report, err := cli.ErasePeerData(dataDir, alice)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", report.Contact)
+	erased := make(map[string]int)
+	for _, e := range report.Erased {
+		erased[e.What] = e.Count
+	}
+	assert.Equal(t, map[string]int{
+		"contact":                    1,
+		"messages in the history":    1,
+		"received files":             2,
+		"profile assignment":         1,
+		"retention rule":             1,
+		"auto-accept entry":          1,
+		"transport pin":              1,
+		"chat command history lines": 2,
+	}, erased)
+	assert.Equal(t, int64(2048), report.Freed, "the file bob sent too is kept")
+
+	contacts, err := user.LoadContactBook(filepath.Join(dataDir, user.ContactsFileName))
+	require.NoError(t, err)
+	_, ok := contacts.FindByPeerID(alice)
+	assert.False(t, ok)
+	_, ok = contacts.FindByPeerID(bob)
+	assert.True(t, ok)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	history, err := db.OpenHistory(dataDir, logger)
+	require.NoError(t, err)
+	entries, err := history.QueryHistory(db.HistoryQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "starred messages are erased too")
+	assert.Equal(t, bob, entries[0].PeerID)
+	require.NoError(t, history.Close())
+
+	store := message.NewAttachmentStore(filepath.Join(dataDir, message.AttachmentsDirName), logger)
+	attachments, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, attachments, 1)
+	require.Len(t, attachments[0].Refs, 1)
+	assert.Equal(t, bob, attachments[0].Refs[0].PeerID)
+	assert.NoFileExists(t, filepath.Join(dataDir, "downloads", "alice.bin"))
+	assert.NoFileExists(t, filepath.Join(dataDir, "downloads", "shared-a.bin"))
+	assert.FileExists(t, filepath.Join(dataDir, "downloads", "shared-b.bin"))
+
+	allowlist, err := message.LoadFileAllowlist(filepath.Join(dataDir, message.FileAllowlistFileName))
+	require.NoError(t, err)
+	assert.Equal(t, []string{bob}, allowlist)
+	policy, err := db.LoadRetentionPolicy(filepath.Join(dataDir, db.RetentionFileName))
+	require.NoError(t, err)
+	assert.NotContains(t, policy.Peers, alice)
+	assert.Contains(t, policy.Peers, bob)
+	pins, err := p2p.ListTransportPins()
+	require.NoError(t, err)
+	assert.Empty(t, pins)
+
+	chat, err := os.ReadFile(filepath.Join(dataDir, "chat_history"))
+	require.NoError(t, err)
+	assert.Equal(t, "/peers\n/send bob hi\n", string(chat))
+
+	// Erasing again finds nothing
+	report, err = cli.ErasePeerData(dataDir, alice)
+	require.NoError(t, err)
+	assert.Zero(t, report.Total())
+}
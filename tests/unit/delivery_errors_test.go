@@ -0,0 +1,193 @@
+package unit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureHandler collects the messages it handles
+type captureHandler struct {
+	messages chan *message.Message
+}
+
+func (h *captureHandler) HandleMessage(ctx context.Context, msg *message.Message) error {
+	h.messages <- msg
+	return nil
+}
+
+// newTestMessageManager starts a message manager on h with the identity of
+// its host key
+func newTestMessageManager(t *testing.T, h host.Host) *message.MessageManager {
+	identity := hostIdentity(t, h)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	mm := message.NewMessageManager(h, identity, logger)
+	require.NoError(t, mm.Start())
+	t.Cleanup(func() { _ = mm.Stop() })
+	return mm
+}
+
+// hostIdentity returns an identity holding the key of h. Peers check message
+// signatures against the key of the host they receive them from, which the
+// node derives from its identity.
+func hostIdentity(t *testing.T, h host.Host) *user.MessengerID {
+	raw, err := h.Peerstore().PrivKey(h.ID()).Raw()
+	require.NoError(t, err)
+	privateKey := ed25519.PrivateKey(raw)
+	return &user.MessengerID{
+		DID:        "did:xelvra:" + h.ID().String(),
+		PublicKey:  privateKey.Public().(ed25519.PublicKey),
+		PrivateKey: privateKey,
+		PeerID:     h.ID(),
+		CreatedAt:  time.Now(),
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	pe := message.NewProtocolError(message.ErrCodeTooLarge, "message is %d bytes", 70000)
+	assert.Equal(t, "peer refused: message is 70000 bytes (too_large)", pe.Error())
+	assert.False(t, pe.Retryable())
+	assert.NotEmpty(t, pe.Hint())
+
+	assert.True(t, message.NewProtocolError(message.ErrCodeBusy, "queue full").Retryable())
+	assert.False(t, message.NewProtocolError(message.ErrCodePolicy, "not shared").Retryable())
+
+	// Wrapped protocol errors are found again
+	wrapped := fmt.Errorf("directory transfer rejected: %w", pe)
+	found, ok := message.AsProtocolError(wrapped)
+	require.True(t, ok)
+	assert.Equal(t, message.ErrCodeTooLarge, found.Code)
+
+	_, ok = message.AsProtocolError(fmt.Errorf("connection reset"))
+	assert.False(t, ok)
+}
+
+func TestMessageRefusalReachesSender(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	sending := newTestMessageManager(t, senderHost)
+	refused := make(chan *message.ProtocolError, 1)
+	sending.OnDeliveryFailed = func(msg *message.Message, err *message.ProtocolError) { refused <- err }
+
+	receiving := newTestMessageManager(t, receiverHost)
+	handler := &captureHandler{messages: make(chan *message.Message, 1)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	to := receiverHost.ID().String()
+
+	// The receiver has no handler for images and says so
+	require.NoError(t, sending.SendMessage(to, []byte("picture"), message.MessageTypeImage))
+	select {
+	case err := <-refused:
+		assert.Equal(t, message.ErrCodeUnsupported, err.Code)
+		assert.Contains(t, err.Message, "image")
+	case <-time.After(10 * time.Second):
+		t.Fatal("sender was not told about the refused message")
+	}
+
+	// Accepted messages are handled without a delivery error
+	require.NoError(t, sending.SendMessage(to, []byte("hello"), message.MessageTypeText))
+	select {
+	case msg := <-handler.messages:
+		assert.Equal(t, "hello", string(msg.Content))
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not delivered")
+	}
+	select {
+	case err := <-refused:
+		t.Fatalf("accepted message reported as refused: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestMessageSignedByAnotherKeyIsRefused(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	// The sender signs with a key other than the one its host proves
+	impostor, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	sending := message.NewMessageManager(senderHost, impostor, logger)
+	refused := make(chan *message.ProtocolError, 1)
+	sending.OnDeliveryFailed = func(msg *message.Message, err *message.ProtocolError) { refused <- err }
+	require.NoError(t, sending.Start())
+	t.Cleanup(func() { _ = sending.Stop() })
+
+	receiving := newTestMessageManager(t, receiverHost)
+	handler := &captureHandler{messages: make(chan *message.Message, 1)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	require.NoError(t, sending.SendMessage(receiverHost.ID().String(), []byte("forged"), message.MessageTypeText))
+	select {
+	case err := <-refused:
+		assert.Equal(t, message.ErrCodeInvalid, err.Code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("message with a bad signature was not refused")
+	}
+	select {
+	case msg := <-handler.messages:
+		t.Fatalf("message with a bad signature was handled: %q", msg.Content)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// recordedMessage is a message handed to a channelRecorder
+type recordedMessage struct {
+	content  string
+	outgoing bool
+}
+
+// channelRecorder passes the messages recorded in the history to a channel
+type channelRecorder chan recordedMessage
+
+func (r channelRecorder) RecordMessage(msg *message.Message, peerID string, outgoing bool) error {
+	r <- recordedMessage{content: string(msg.Content), outgoing: outgoing}
+	return nil
+}
+
+func TestSentMessagesRecordedOnDelivery(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	sending := message.NewMessageManager(senderHost, hostIdentity(t, senderHost), logger)
+	recorded := make(channelRecorder, 4)
+	sending.SetRecorder(recorded)
+	require.NoError(t, sending.Start())
+	t.Cleanup(func() { _ = sending.Stop() })
+
+	receiving := newTestMessageManager(t, receiverHost)
+	receiving.RegisterHandler(message.MessageTypeText, &captureHandler{messages: make(chan *message.Message, 1)})
+
+	to := receiverHost.ID().String()
+
+	// Refused messages never reach the history
+	require.NoError(t, sending.SendMessage(to, []byte("picture"), message.MessageTypeImage))
+	require.NoError(t, sending.SendMessage(to, []byte("hello"), message.MessageTypeText))
+	select {
+	case rec := <-recorded:
+		assert.Equal(t, recordedMessage{content: "hello", outgoing: true}, rec)
+	case <-time.After(10 * time.Second):
+		t.Fatal("delivered message was not recorded")
+	}
+	select {
+	case rec := <-recorded:
+		t.Fatalf("unexpected history entry: %+v", rec)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
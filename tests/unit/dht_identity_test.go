@@ -0,0 +1,79 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCPListenAddrs(t *testing.T) {
+	addrs := []string{
+		"/ip4/0.0.0.0/tcp/4001",
+		"/ip4/0.0.0.0/udp/4001/quic-v1",
+		"/ip4/0.0.0.0/udp/4001/quic-v1/webtransport",
+		"/ip4/0.0.0.0/udp/4002/webrtc-direct",
+		"/ip6/::/tcp/4001",
+	}
+	assert.Equal(t, []string{"/ip4/0.0.0.0/tcp/4001", "/ip6/::/tcp/4001"}, p2p.TCPListenAddrs(addrs))
+	assert.Empty(t, p2p.TCPListenAddrs([]string{"/ip4/0.0.0.0/udp/4001/quic-v1"}))
+}
+
+func TestEphemeralDHTIdentity(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	config := p2p.DefaultNodeConfig()
+	config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"}
+	config.IdentityPath, config.DataDir = "", ""
+
+	node, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	defer node.Stop()
+	assert.NotContains(t, node.GetStats(), "dht_peer_id", "the DHT runs on the messaging host")
+
+	// The DHT runs under a peer ID of its own
+	config.EphemeralDHTIdentity = true
+	ephemeral, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	defer ephemeral.Stop()
+	stats := ephemeral.GetStats()
+	require.Contains(t, stats, "dht_peer_id")
+	assert.NotEmpty(t, stats["dht_peer_id"])
+	assert.NotEqual(t, ephemeral.GetHost().ID().String(), stats["dht_peer_id"])
+}
+
+func TestDiscoveryWithDHTHostDoesNotAdvertise(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	start := func(separate bool) *p2p.DiscoveryStatus {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		defer h.Close()
+
+		dm := p2p.NewDiscoveryManager(h, logger)
+		dm.DisableLocalDiscovery()
+		dm.SetBootstrapPeers(nil)
+		if separate {
+			dhtHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+			require.NoError(t, err)
+			defer dhtHost.Close()
+			dm.UseDHTHost(dhtHost)
+		}
+		require.NoError(t, dm.Start())
+		defer func() { _ = dm.Stop() }()
+		return dm.GetStatus()
+	}
+
+	status := start(false)
+	assert.True(t, status.DHTActive)
+	assert.True(t, status.PresenceAdvertised)
+
+	// Announcing under the messaging identity would link it to the DHT one
+	status = start(true)
+	assert.True(t, status.DHTActive)
+	assert.False(t, status.PresenceAdvertised)
+}
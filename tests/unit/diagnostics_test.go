@@ -0,0 +1,77 @@
+package unit
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnosticsSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.DiagnosticsFileName)
+
+	// Off until enabled
+	settings, err := p2p.LoadDiagnosticsSettings(path)
+	require.NoError(t, err)
+	assert.False(t, settings.Enabled)
+	assert.Equal(t, p2p.DefaultDiagnosticsPort, settings.Port)
+
+	require.NoError(t, p2p.SaveDiagnosticsSettings(path, &p2p.DiagnosticsSettings{Enabled: true, Port: 7070}))
+	settings, err = p2p.LoadDiagnosticsSettings(path)
+	require.NoError(t, err)
+	assert.True(t, settings.Enabled)
+	assert.Equal(t, 7070, settings.Port)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"enabled": true, "port": 70000}`), 0600))
+	_, err = p2p.LoadDiagnosticsSettings(path)
+	assert.Error(t, err)
+}
+
+func TestDiagnosticsHandler(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	server := httptest.NewServer(p2p.NewDiagnosticsHandler(func() p2p.RuntimeStats {
+		return p2p.ReadRuntimeStats(start)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/runtime")
+	require.NoError(t, err)
+	var stats p2p.RuntimeStats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Positive(t, stats.Goroutines)
+	assert.Positive(t, stats.HeapAllocBytes)
+	assert.GreaterOrEqual(t, stats.UptimeSeconds, 60.0)
+
+	resp, err = http.Get(server.URL + "/debug/pprof/heap")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// debug dump --runtime-trace asks for fractions of a second too
+	resp, err = http.Get(server.URL + "/debug/pprof/trace?seconds=0.1")
+	require.NoError(t, err)
+	trace, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, trace)
+
+	// Pages on other sites cannot reach it by rebinding a name to 127.0.0.1
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/debug/pprof/heap", nil)
+	require.NoError(t, err)
+	req.Host = "attacker.example:6060"
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,119 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// protocolOpener opens streams of the given protocol to target
+func protocolOpener(h host.Host, target peer.ID, id protocol.ID) message.StreamOpener {
+	return func(ctx context.Context) (network.Stream, error) {
+		return h.NewStream(ctx, target, id)
+	}
+}
+
+// writeTree creates files below dir from a path -> content map
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for path, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0644))
+	}
+}
+
+func TestDirManifest(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "photos")
+	writeTree(t, dir, map[string]string{
+		"a.txt":          "alpha",
+		"sub/b.txt":      "beta",
+		"sub/c.txt.part": "partial",
+	})
+
+	manifest, err := message.BuildDirManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "photos", manifest.Root)
+	require.Len(t, manifest.Files, 2)
+	assert.Equal(t, "a.txt", manifest.Files[0].Path)
+	assert.Equal(t, "sub/b.txt", manifest.Files[1].Path)
+	assert.Equal(t, int64(9), manifest.TotalSize())
+	assert.NoError(t, manifest.Validate())
+
+	for _, bad := range []string{"../escape", "/etc/passwd", "sub/../../x", "./a", "a\\b", ""} {
+		m := *manifest
+		m.Files = []message.ManifestEntry{{Path: bad, Size: 1, Hash: manifest.Files[0].Hash}}
+		assert.Error(t, m.Validate(), bad)
+	}
+
+	m := *manifest
+	m.Root = "../up"
+	assert.Error(t, m.Validate())
+}
+
+func TestDirTransferSkipsExistingFiles(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	receiving := newFileTestManager()
+	receiver.SetStreamHandler(message.DirProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveDirectory(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	dir := filepath.Join(t.TempDir(), "project")
+	writeTree(t, dir, map[string]string{
+		"README.md":    "readme",
+		"src/main.go":  "package main",
+		"docs/big.txt": string(make([]byte, 3*message.FileChunkSize)),
+	})
+
+	sending := newFileTestManager()
+	sync := func() *message.DirSyncResult {
+		result, err := sending.SendDirectory(context.Background(),
+			protocolOpener(sender, receiver.ID(), message.DirProtocolID),
+			protocolOpener(sender, receiver.ID(), message.FileProtocolID),
+			dir, receiver.ID())
+		require.NoError(t, err)
+		return result
+	}
+
+	// First sync transfers everything
+	result := sync()
+	assert.Equal(t, 3, result.Files)
+	assert.Equal(t, 3, result.Sent)
+	assert.Equal(t, 0, result.Skipped)
+
+	received, err := os.ReadFile(filepath.Join(downloads, "project", "src", "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package main", string(received))
+
+	// Unchanged files are skipped; a moved file is copied locally
+	require.NoError(t, os.Rename(filepath.Join(dir, "README.md"), filepath.Join(dir, "README.txt")))
+	writeTree(t, dir, map[string]string{"src/util.go": "package util"})
+
+	result = sync()
+	assert.Equal(t, 4, result.Files)
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, 2, result.Skipped)
+	assert.Equal(t, 1, result.Copied)
+	assert.FileExists(t, filepath.Join(downloads, "project", "README.txt"))
+	assert.FileExists(t, filepath.Join(downloads, "project", "src", "util.go"))
+
+	// Nothing changed: nothing is sent
+	result = sync()
+	assert.Equal(t, 0, result.Sent)
+	assert.Equal(t, 4, result.Skipped)
+}
@@ -0,0 +1,113 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchDiscoveryEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.DiscoveryEventsFileName)
+	ids := testPeerIDs(t, 3)
+
+	appendEvents := func(flags int, events ...p2p.DiscoveryEvent) {
+		f, err := os.OpenFile(path, flags, 0600)
+		require.NoError(t, err)
+		defer f.Close()
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			require.NoError(t, err)
+			_, err = f.Write(append(data, '\n'))
+			require.NoError(t, err)
+		}
+	}
+	found := func(i int, method string) p2p.DiscoveryEvent {
+		return p2p.DiscoveryEvent{Time: time.Now(), Type: p2p.DiscoveryFound, PeerID: ids[i].String(), Method: method, Addrs: []string{"/ip4/192.168.1.20/tcp/4001"}}
+	}
+
+	// Events from before the watch started are not shown
+	appendEvents(os.O_CREATE|os.O_WRONLY|os.O_APPEND, found(0, "mdns"))
+
+	var mu sync.Mutex
+	var seen []string
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- p2p.WatchDiscoveryEvents(ctx, path, func(event p2p.DiscoveryEvent) {
+			mu.Lock()
+			seen = append(seen, event.PeerID+" "+event.Method)
+			mu.Unlock()
+		})
+	}()
+	seenNow := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+
+	time.Sleep(700 * time.Millisecond)
+	appendEvents(os.O_WRONLY|os.O_APPEND, found(1, "mdns"), found(1, "mdns"), found(1, "dht"))
+	assert.Eventually(t, func() bool { return len(seenNow()) == 2 }, 3*time.Second, 100*time.Millisecond)
+	assert.Equal(t, []string{ids[1].String() + " mdns", ids[1].String() + " dht"}, seenNow(), "repeats are dropped")
+
+	// The file started over by the node is read from its start
+	appendEvents(os.O_WRONLY|os.O_TRUNC, found(2, "udp"))
+	assert.Eventually(t, func() bool { return len(seenNow()) == 3 }, 3*time.Second, 100*time.Millisecond)
+
+	// A peer found again once lost is shown again
+	lost := found(2, "udp")
+	lost.Type = p2p.DiscoveryLost
+	appendEvents(os.O_WRONLY|os.O_APPEND, lost, found(2, "udp"))
+	assert.Eventually(t, func() bool { return len(seenNow()) == 5 }, 3*time.Second, 100*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestDiscoverySubscriptions(t *testing.T) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	dm := p2p.NewDiscoveryManager(h, logger)
+
+	var events []p2p.DiscoveryEvent
+	unsubscribe := dm.OnDiscoveryEvent(func(event p2p.DiscoveryEvent) {
+		events = append(events, event)
+	})
+
+	id := testPeerIDs(t, 1)[0]
+	lan := ma.StringCast("/ip4/192.168.1.20/tcp/4001")
+	dm.AddDiscoveredPeer(peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{lan}}, "mdns")
+	dm.AddDiscoveredPeer(peer.AddrInfo{ID: id}, "udp")
+	dm.AddDiscoveredPeer(peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{lan}}, "mdns")
+	dm.AddDiscoveredPeer(peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}, "mdns")
+	require.Len(t, events, 1, "peers found again at the same addresses and this node are not reported")
+	assert.Equal(t, p2p.DiscoveryFound, events[0].Type)
+	assert.Equal(t, id.String(), events[0].PeerID)
+	assert.Equal(t, []string{lan.String()}, events[0].Addrs)
+
+	dm.AddDiscoveredPeer(peer.AddrInfo{ID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/10.0.0.5/tcp/4001")}}, "dht")
+	require.Len(t, events, 2)
+	assert.Equal(t, p2p.DiscoveryUpdated, events[1].Type)
+	assert.Equal(t, "dht", events[1].Method)
+
+	unsubscribe()
+	dm.AddDiscoveredPeer(peer.AddrInfo{ID: testPeerIDs(t, 1)[0]}, "mdns")
+	assert.Len(t, events, 2, "no events after unsubscribing")
+	assert.Len(t, dm.GetDiscoveredPeers(), 2)
+}
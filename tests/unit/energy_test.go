@@ -291,3 +291,68 @@ func BenchmarkBatteryLevelUpdate(b *testing.B) {
 		em.SetBatteryLevel(level)
 	}
 }
+
+// TestBatterySaverWaits tests that battery saver mode lengthens periodic
+// work but not keep-alives, and that turning it off ends longer waits
+func TestBatterySaverWaits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	em := p2p.NewEnergyManager(ctx, logger)
+	em.SetBatterySaver(true)
+	if !em.BatterySaver() {
+		t.Fatal("Battery saver should be on")
+	}
+
+	work := em.After(ctx, 50*time.Millisecond)
+	keepAlive := em.KeepAliveAfter(ctx, 50*time.Millisecond)
+
+	select {
+	case <-keepAlive:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Keep-alive should not be delayed by battery saver mode")
+	}
+	select {
+	case <-work:
+		t.Fatal("Periodic work should wait for the next wake-up in battery saver mode")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	em.SetBatterySaver(false)
+	select {
+	case <-work:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Turning battery saver off should end the longer wait")
+	}
+
+	// Refreshes are only suspended once the node is idle
+	em.SetBatterySaver(true)
+	if em.SuspendRefreshes() {
+		t.Error("DHT refreshes should not be suspended right after activity")
+	}
+}
+
+// TestEnergyManagerNil tests that a missing energy manager waits for the
+// plain interval
+func TestEnergyManagerNil(t *testing.T) {
+	var em *p2p.EnergyManager
+
+	started := time.Now()
+	if !em.Wait(context.Background(), 20*time.Millisecond) {
+		t.Fatal("Wait should succeed without an energy manager")
+	}
+	if elapsed := time.Since(started); elapsed < 20*time.Millisecond {
+		t.Errorf("Wait returned after %s, before the interval", elapsed)
+	}
+	if em.BatterySaver() || em.SuspendRefreshes() {
+		t.Error("A missing energy manager should not save energy")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if em.Wait(ctx, time.Hour) {
+		t.Error("Wait should fail once the context ends")
+	}
+}
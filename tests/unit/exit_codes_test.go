@@ -0,0 +1,28 @@
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, cli.ExitSuccess, cli.ExitCode(nil))
+	assert.Equal(t, cli.ExitGeneral, cli.ExitCode(errors.New("unknown flag")))
+
+	network := &cli.ExitError{Code: cli.ExitNetwork, Err: errors.New("no running node")}
+	assert.Equal(t, cli.ExitNetwork, cli.ExitCode(network))
+	assert.Equal(t, cli.ExitNetwork, cli.ExitCode(fmt.Errorf("send: %w", network)))
+
+	// Permission errors map to their code even without a more specific one
+	assert.Equal(t, cli.ExitPermission, cli.ExitCode(fs.ErrPermission))
+	general := &cli.ExitError{Code: cli.ExitGeneral, Err: fmt.Errorf("open: %w", fs.ErrPermission)}
+	assert.Equal(t, cli.ExitPermission, cli.ExitCode(general))
+
+	notFound := &cli.ExitError{Code: cli.ExitPeerNotFound, Err: fs.ErrPermission}
+	assert.Equal(t, cli.ExitPeerNotFound, cli.ExitCode(notFound), "a specific code wins")
+}
@@ -0,0 +1,85 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compressedTransfer sends path and returns both ends of the transfer
+func compressedTransfer(t *testing.T, path string, sending, receiving *message.FileTransferManager) (*message.FileTransfer, *message.FileTransfer) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	completed := make(chan *message.FileTransfer, 1)
+	receiving.OnComplete = func(transfer *message.FileTransfer) { completed <- transfer }
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID()))
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	received, err := os.ReadFile(filepath.Join(downloads, filepath.Base(path)))
+	require.NoError(t, err)
+	assert.Equal(t, expected, received)
+
+	transfers := sending.ListTransfers()
+	require.Len(t, transfers, 1)
+	return transfers[0], <-completed
+}
+
+// writeTextFile creates a compressible file of about size bytes
+func writeTextFile(t *testing.T, size int) string {
+	line := []byte("2024-01-31T12:00:00Z INFO peer connected over QUIC, latency 12ms\n")
+	path := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(path, bytes.Repeat(line, size/len(line)+1), 0600))
+	return path
+}
+
+func TestFileTransferCompressed(t *testing.T) {
+	path := writeTextFile(t, 20*message.FileChunkSize+123)
+	sent, received := compressedTransfer(t, path, newFileTestManager(), newFileTestManager())
+
+	assert.Equal(t, message.FileCompressionZstd, sent.Compression)
+	assert.Equal(t, message.FileCompressionZstd, received.Compression)
+	assert.Equal(t, sent.BytesTotal, sent.BytesSent)
+	assert.Less(t, sent.BytesOnWire, sent.BytesTotal/4)
+	assert.Equal(t, sent.BytesOnWire, received.BytesOnWire)
+}
+
+func TestFileTransferIncompressible(t *testing.T) {
+	// Random data does not shrink and is sent as it is
+	path := writeRandomFile(t, 20*message.FileChunkSize)
+	sent, _ := compressedTransfer(t, path, newFileTestManager(), newFileTestManager())
+	assert.Equal(t, message.FileCompressionZstd, sent.Compression)
+	assert.Equal(t, sent.BytesTotal, sent.BytesOnWire)
+}
+
+func TestFileTransferCompressionRefused(t *testing.T) {
+	receiving := newFileTestManager()
+	receiving.Compression = false
+	path := writeTextFile(t, 10*message.FileChunkSize)
+	sent, received := compressedTransfer(t, path, newFileTestManager(), receiving)
+
+	assert.Empty(t, sent.Compression)
+	assert.Empty(t, received.Compression)
+	assert.Equal(t, sent.BytesTotal, sent.BytesOnWire)
+}
+
+func TestFileTransferCompressedParallel(t *testing.T) {
+	path := writeTextFile(t, message.FileParallelThreshold+7*message.FileChunkSize)
+	sent, received := compressedTransfer(t, path, newFileTestManager(), newFileTestManager())
+
+	assert.Equal(t, message.FileCompressionZstd, sent.Compression)
+	assert.Less(t, sent.BytesOnWire, sent.BytesTotal/4)
+	assert.Equal(t, sent.BytesOnWire, received.BytesOnWire)
+}
@@ -0,0 +1,140 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFileName(t *testing.T) {
+	for _, name := range []string{"report.pdf", ".bashrc", "two words.txt", "日本語.txt", "a..b"} {
+		assert.NoError(t, message.ValidateFileName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "../evil.sh", "/etc/passwd", "dir/file.txt", `..\evil.bat`, "nul\x00byte", strings.Repeat("a", 256)} {
+		assert.Error(t, message.ValidateFileName(name), name)
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":       "report.pdf",
+		".bashrc":          "bashrc",
+		"  notes.txt. ":    "notes.txt",
+		"a<b>c:d|e?f*.txt": "a_b_c_d_e_f_.txt",
+		"bell\a\nname.txt": "bell__name.txt",
+		"CON.txt":          "_CON.txt",
+		"con":              "_con",
+		"...":              "file",
+		"bad\xffutf8.txt":  "bad_utf8.txt",
+		"résumé 2024.docx": "résumé 2024.docx",
+		"console.log":      "console.log",
+	}
+	for name, expected := range tests {
+		assert.Equal(t, expected, message.SanitizeFileName(name), "%q", name)
+	}
+
+	// Long names are shortened on a rune boundary, keeping the extension
+	long := message.SanitizeFileName(strings.Repeat("é", 150) + ".tar.gz")
+	assert.LessOrEqual(t, len(long), 200)
+	assert.True(t, strings.HasSuffix(long, ".gz"))
+	assert.True(t, strings.HasPrefix(long, "éé"))
+	assert.NoError(t, message.ValidateFileName(long))
+}
+
+func TestUniqueFilePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.jpg")
+	assert.Equal(t, path, message.UniqueFilePath(path))
+
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	assert.Equal(t, filepath.Join(dir, "photo (1).jpg"), message.UniqueFilePath(path))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "photo (1).jpg"), nil, 0600))
+	assert.Equal(t, filepath.Join(dir, "photo (2).jpg"), message.UniqueFilePath(path))
+
+	assert.Equal(t, filepath.Join(dir, "README"), message.UniqueFilePath(filepath.Join(dir, "README")))
+}
+
+func TestFileTransferRejectsPathNames(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := filepath.Join(t.TempDir(), "downloads")
+
+	receiving := newFileTestManager()
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	metadata, err := message.CreateFileMetadata(writeRandomFile(t, 100))
+	require.NoError(t, err)
+	for _, name := range []string{"../evil.sh", "/tmp/evil.sh", "..", "sub/../../evil.sh"} {
+		metadata.Name = name
+		stream, err := sender.NewStream(context.Background(), receiver.ID(), message.FileProtocolID)
+		require.NoError(t, err)
+
+		writeTestFileFrame(t, stream, message.FileTransferRequest{Type: "request", Metadata: *metadata})
+		frame := readTestFileFrame(t, stream)
+		assert.Equal(t, "reject", frame.Type, name)
+		assert.Equal(t, message.ErrCodeInvalid, frame.Code, name)
+		_ = stream.Close()
+	}
+	assert.NoDirExists(t, downloads, "nothing may be written for a rejected name")
+}
+
+func TestFileTransferRejectsInvalidHashes(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := filepath.Join(t.TempDir(), "downloads")
+
+	receiving := newFileTestManager()
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	metadata, err := message.CreateFileMetadata(writeRandomFile(t, 100))
+	require.NoError(t, err)
+	assert.NoError(t, message.ValidateFileHash(metadata.Hash))
+	for _, hash := range []string{"/../../../../tmp/x", "", "abc", strings.ToUpper(metadata.Hash), metadata.Hash + "00"} {
+		metadata.Hash = hash
+		stream, err := sender.NewStream(context.Background(), receiver.ID(), message.FileProtocolID)
+		require.NoError(t, err)
+
+		writeTestFileFrame(t, stream, message.FileTransferRequest{Type: "request", Metadata: *metadata})
+		frame := readTestFileFrame(t, stream)
+		assert.Equal(t, "reject", frame.Type, hash)
+		assert.Equal(t, message.ErrCodeInvalid, frame.Code, hash)
+		_ = stream.Close()
+	}
+	assert.NoDirExists(t, downloads, "nothing may be written for a rejected hash")
+}
+
+func TestFileTransferKeepsEarlierDownload(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	receiving := newFileTestManager()
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	sending := newFileTestManager()
+	first := writeRandomFile(t, 100)
+	second := writeRandomFile(t, 200)
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), first, receiver.ID()))
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), second, receiver.ID()))
+
+	for path, received := range map[string]string{first: "payload.bin", second: "payload (1).bin"} {
+		expected, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data, err := os.ReadFile(filepath.Join(downloads, received))
+		require.NoError(t, err)
+		assert.Equal(t, expected, data, received)
+	}
+}
@@ -0,0 +1,112 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileOfferRefusedWithoutPrompt(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	newTestMessageManager(t, receiverHost)
+	sending := newTestMessageManager(t, senderHost)
+
+	err := sending.SendFile(receiverHost.ID(), writeRandomFile(t, 4<<10))
+	pe, ok := message.AsProtocolError(err)
+	require.True(t, ok, "refusal carries a protocol error: %v", err)
+	assert.Equal(t, message.ErrCodePolicy, pe.Code)
+	assert.NoDirExists(t, filepath.Join(home, ".xelvra", "downloads"), "nothing is written for a refused file")
+
+	// Peers on the allowlist are accepted without asking
+	require.NoError(t, message.SaveFileAllowlist(filepath.Join(home, ".xelvra", message.FileAllowlistFileName),
+		[]string{senderHost.ID().String()}))
+	require.NoError(t, sending.SendFile(receiverHost.ID(), writeRandomFile(t, 4<<10)))
+	assert.FileExists(t, filepath.Join(home, ".xelvra", "downloads", "payload.bin"))
+}
+
+func TestFileOfferAcceptAndReject(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	receiving := newTestMessageManager(t, receiverHost)
+	sending := newTestMessageManager(t, senderHost)
+	offers := make(chan *message.FileOffer, 1)
+	receiving.SetFileOfferHandler(func(offer *message.FileOffer) { offers <- offer })
+
+	send := func() <-chan error {
+		result := make(chan error, 1)
+		path := writeRandomFile(t, 4<<10)
+		go func() { result <- sending.SendFile(receiverHost.ID(), path) }()
+		return result
+	}
+	nextOffer := func() *message.FileOffer {
+		select {
+		case offer := <-offers:
+			return offer
+		case <-time.After(5 * time.Second):
+			t.Fatal("no file offer")
+			return nil
+		}
+	}
+
+	// The sender waits past an ack interval without anything being written
+	result := send()
+	offer := nextOffer()
+	assert.Equal(t, "payload.bin", offer.Name)
+	assert.Equal(t, int64(4<<10), offer.Size)
+	assert.Equal(t, senderHost.ID(), offer.PeerID)
+	time.Sleep(message.FileAckInterval + 500*time.Millisecond)
+	assert.NoDirExists(t, filepath.Join(home, ".xelvra", "downloads"))
+	assert.Len(t, receiving.FileOffers(), 1)
+
+	_, err := receiving.AnswerFileOffer(0, true)
+	require.NoError(t, err)
+	require.NoError(t, <-result)
+	_, err = os.Stat(filepath.Join(home, ".xelvra", "downloads", "payload.bin"))
+	assert.NoError(t, err)
+
+	// A declined file is refused
+	result = send()
+	offer = nextOffer()
+	_, err = receiving.AnswerFileOffer(offer.Number+1, false)
+	assert.Error(t, err, "unknown offer number")
+	_, err = receiving.AnswerFileOffer(offer.Number, false)
+	require.NoError(t, err)
+	pe, ok := message.AsProtocolError(<-result)
+	require.True(t, ok)
+	assert.Equal(t, message.ErrCodePolicy, pe.Code)
+	assert.Empty(t, receiving.FileOffers())
+}
+
+func TestFileOfferAutoAcceptedByPolicy(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	receiving := newTestMessageManager(t, receiverHost)
+	sending := newTestMessageManager(t, senderHost)
+	_, err := receiving.Contacts().Add("sender", senderHost.ID().String(), "")
+	require.NoError(t, err)
+	_, err = receiving.Contacts().SetPolicy("sender", user.ContactPolicy{AutoAcceptMB: 1})
+	require.NoError(t, err)
+
+	// Files within the limit are accepted without asking
+	require.NoError(t, sending.SendFile(receiverHost.ID(), writeRandomFile(t, 4<<10)))
+	assert.FileExists(t, filepath.Join(home, ".xelvra", "downloads", "payload.bin"))
+
+	// Larger ones still need the user, and nobody is asking
+	err = sending.SendFile(receiverHost.ID(), writeRandomFile(t, 2<<20))
+	pe, ok := message.AsProtocolError(err)
+	require.True(t, ok, "refusal carries a protocol error: %v", err)
+	assert.Equal(t, message.ErrCodePolicy, pe.Code)
+}
@@ -0,0 +1,56 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parallelTransfer sends a file too large for one stream and returns how
+// many streams the receiver served
+func parallelTransfer(t *testing.T, sendStreams, receiveStreams int) int64 {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	receiving := newFileTestManager()
+	receiving.MaxStreams = receiveStreams
+	var streams atomic.Int64
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		streams.Add(1)
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, message.FileParallelThreshold+5*message.FileChunkSize+321)
+	sending := newFileTestManager()
+	sending.MaxStreams = sendStreams
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID()))
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	received, err := os.ReadFile(filepath.Join(downloads, "payload.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, received)
+
+	transfers := sending.ListTransfers()
+	require.Len(t, transfers, 1)
+	assert.Equal(t, message.FileTransferCompleted, transfers[0].Status)
+	assert.Zero(t, transfers[0].Stalls)
+	return streams.Load()
+}
+
+func TestFileTransferParallelStreams(t *testing.T) {
+	assert.Equal(t, int64(4), parallelTransfer(t, 4, 8), "the sender's limit applies")
+}
+
+func TestFileTransferParallelLimitedByReceiver(t *testing.T) {
+	assert.Equal(t, int64(2), parallelTransfer(t, 4, 2))
+	assert.Equal(t, int64(1), parallelTransfer(t, 4, 1), "a receiver may refuse extra streams")
+}
@@ -0,0 +1,161 @@
+package unit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestFileFrame writes a file protocol frame the way a peer would
+func writeTestFileFrame(t *testing.T, w io.Writer, frame message.FileTransferRequest) {
+	frame.Magic = message.FileTransferMagic
+	data, err := json.Marshal(frame)
+	require.NoError(t, err)
+	require.NoError(t, binary.Write(w, binary.BigEndian, uint32(len(data))))
+	_, err = w.Write(data)
+	require.NoError(t, err)
+}
+
+// readTestFileFrame reads a file protocol frame
+func readTestFileFrame(t *testing.T, r io.Reader) message.FileTransferRequest {
+	var length uint32
+	require.NoError(t, binary.Read(r, binary.BigEndian, &length))
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	require.NoError(t, err)
+
+	var frame message.FileTransferRequest
+	require.NoError(t, json.Unmarshal(data, &frame))
+	return frame
+}
+
+func TestFileHashesIncludeBLAKE3(t *testing.T) {
+	path := writeRandomFile(t, 3*message.FileChunkSize)
+
+	metadata, err := message.CreateFileMetadata(path)
+	require.NoError(t, err)
+	hash, err := message.CalculateFileHash(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, hash, metadata.Hash)
+	assert.Len(t, metadata.BLAKE3, 64)
+	assert.NotEqual(t, metadata.Hash, metadata.BLAKE3)
+}
+
+func TestFileTransferSignedReceipt(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	receiving := newFileTestManager()
+	receiving.SignReceipt = receiver.Peerstore().PrivKey(receiver.ID()).Sign
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, 4*message.FileChunkSize+7)
+	sending := newFileTestManager()
+	var sent *message.FileTransfer
+	sending.OnComplete = func(transfer *message.FileTransfer) { sent = transfer }
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID()))
+
+	require.NotNil(t, sent)
+	require.NotNil(t, sent.Receipt)
+	assert.Equal(t, sent.Metadata.Hash, sent.Receipt.Hash)
+	assert.Equal(t, sent.Metadata.BLAKE3, sent.Receipt.BLAKE3)
+	assert.Equal(t, receiver.ID().String(), sent.Receipt.Receiver)
+	assert.NoError(t, sent.Receipt.Verify(sent.Metadata, sender.ID(), receiver.ID()))
+
+	// The receipt is bound to the file it acknowledges
+	other := sent.Metadata
+	other.Hash = sent.Metadata.BLAKE3
+	assert.Error(t, sent.Receipt.Verify(other, sender.ID(), receiver.ID()))
+}
+
+func TestFileTransferRejectsForgedReceipt(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+
+	// The receiver signs with a key other than the one behind its peer ID
+	forger, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	receiving := newFileTestManager()
+	receiving.SignReceipt = forger.Sign
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), t.TempDir())
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, message.FileChunkSize)
+	sending := newFileTestManager()
+	err = sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid receipt signature")
+
+	transfers := sending.ListTransfers()
+	require.Len(t, transfers, 1)
+	assert.Equal(t, message.FileTransferFailed, transfers[0].Status)
+}
+
+func TestFileTransferBLAKE3Mismatch(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	receiving := newFileTestManager()
+	result := make(chan error, 1)
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		result <- receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, 2*message.FileChunkSize)
+	metadata, err := message.CreateFileMetadata(path)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// The SHA256 hash matches but the BLAKE3 hash does not
+	metadata.BLAKE3 = metadata.Hash
+
+	stream, err := sender.NewStream(context.Background(), receiver.ID(), message.FileProtocolID)
+	require.NoError(t, err)
+	defer func() { _ = stream.Close() }()
+
+	writeTestFileFrame(t, stream, message.FileTransferRequest{Type: "request", Metadata: *metadata})
+	require.Equal(t, "accept", readTestFileFrame(t, stream).Type)
+	for chunk := 0; chunk < 2; chunk++ {
+		writeTestFileFrame(t, stream, message.FileTransferRequest{
+			Type:    "chunk",
+			ChunkID: chunk,
+			Data:    data[chunk*message.FileChunkSize : (chunk+1)*message.FileChunkSize],
+		})
+	}
+	writeTestFileFrame(t, stream, message.FileTransferRequest{Type: "complete"})
+
+	// Acks may arrive before the verdict
+	for {
+		frame := readTestFileFrame(t, stream)
+		if frame.Type == "ack" {
+			continue
+		}
+		assert.Equal(t, "error", frame.Type)
+		assert.Equal(t, message.ErrCodeIntegrity, frame.Code)
+		break
+	}
+	require.Error(t, <-result)
+
+	entries, err := os.ReadDir(downloads)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the corrupt file must be deleted")
+	assert.NoFileExists(t, filepath.Join(downloads, "payload.bin"))
+}
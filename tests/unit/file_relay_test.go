@@ -0,0 +1,80 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTransferRelayFallback(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ctx := context.Background()
+
+	relayHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = relayHost.Close() })
+	service, err := relay.New(relayHost, relay.WithInfiniteLimits())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = service.Close() })
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	// The receiver is reachable only through its reservation on the relay
+	receiver, err := libp2p.New(libp2p.NoListenAddrs, libp2p.EnableRelay())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = receiver.Close() })
+	require.NoError(t, receiver.Connect(ctx, relayInfo))
+	_, err = client.Reserve(ctx, receiver, relayInfo)
+	require.NoError(t, err)
+
+	sender, err := libp2p.New(libp2p.NoListenAddrs, libp2p.EnableRelay())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sender.Close() })
+	require.NoError(t, sender.Connect(ctx, relayInfo))
+	require.Eventually(t, func() bool {
+		supported, _ := sender.Peerstore().SupportsProtocols(relayHost.ID(), proto.ProtoIDv2Hop)
+		return len(supported) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	downloads := t.TempDir()
+	receiving := newFileTestManager()
+	completed := make(chan *message.FileTransfer, 1)
+	receiving.OnComplete = func(transfer *message.FileTransfer) { completed <- transfer }
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(ctx, s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	mm := newTestMessageManager(t, sender)
+	relayed := make(chan *message.FileTransfer, 1)
+	mm.OnTransferRelayed = func(transfer *message.FileTransfer) { relayed <- transfer }
+
+	path := writeRandomFile(t, 3*message.FileChunkSize+17)
+	require.NoError(t, mm.SendFile(receiver.ID(), path))
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	received, err := os.ReadFile(filepath.Join(downloads, filepath.Base(path)))
+	require.NoError(t, err)
+	assert.Equal(t, expected, received)
+
+	select {
+	case transfer := <-relayed:
+		assert.True(t, transfer.Relayed)
+		assert.Equal(t, receiver.ID(), transfer.PeerID)
+	default:
+		t.Fatal("sender was not told about the relay")
+	}
+	assert.True(t, (<-completed).Relayed)
+}
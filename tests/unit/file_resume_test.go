@@ -0,0 +1,260 @@
+package unit
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFileTestManager returns a manager with short liveness timeouts
+func newFileTestManager() *message.FileTransferManager {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	ftm := message.NewFileTransferManager(logger)
+	ftm.AckInterval = 50 * time.Millisecond
+	ftm.KeepAliveInterval = 100 * time.Millisecond
+	ftm.StallTimeout = 500 * time.Millisecond
+	ftm.ResumeDelay = 50 * time.Millisecond
+	return ftm
+}
+
+// newConnectedHosts returns two local hosts connected to each other
+func newConnectedHosts(t *testing.T) (host.Host, host.Host) {
+	sender, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sender.Close() })
+
+	receiver, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = receiver.Close() })
+
+	err = sender.Connect(context.Background(), peer.AddrInfo{ID: receiver.ID(), Addrs: receiver.Addrs()})
+	require.NoError(t, err)
+	return sender, receiver
+}
+
+// writeRandomFile creates a file of the given size with random content
+func writeRandomFile(t *testing.T, size int) string {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "payload.bin")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func opener(h host.Host, target peer.ID) message.StreamOpener {
+	return func(ctx context.Context) (network.Stream, error) {
+		return h.NewStream(ctx, target, message.FileProtocolID)
+	}
+}
+
+func TestFileTransferEndToEnd(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	receiving := newFileTestManager()
+	completed := make(chan *message.FileTransfer, 1)
+	receiving.OnComplete = func(transfer *message.FileTransfer) { completed <- transfer }
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, 10*message.FileChunkSize+123)
+	sending := newFileTestManager()
+	var sent *message.FileTransfer
+	sending.OnComplete = func(transfer *message.FileTransfer) { sent = transfer }
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID()))
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	received, err := os.ReadFile(filepath.Join(downloads, "payload.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, received)
+
+	transfers := sending.ListTransfers()
+	require.Len(t, transfers, 1)
+	assert.Equal(t, message.FileTransferCompleted, transfers[0].Status)
+	assert.Equal(t, transfers[0].BytesTotal, transfers[0].BytesAcked)
+
+	// Both sides report the completed transfer for the history
+	require.NotNil(t, sent)
+	assert.Equal(t, path, sent.LocalPath)
+	select {
+	case transfer := <-completed:
+		assert.Equal(t, filepath.Join(downloads, "payload.bin"), transfer.LocalPath)
+		assert.Equal(t, sender.ID(), transfer.PeerID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver did not report the completed transfer")
+	}
+}
+
+func TestFileTransferIgnoresUnknownPartialFile(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	path := writeRandomFile(t, 8*message.FileChunkSize)
+	metadata, err := message.CreateFileMetadata(path)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// A partial file named after the hash was not accepted here, so it is
+	// neither resumed nor touched
+	partPath := filepath.Join(downloads, "payload.bin."+metadata.Hash[:16]+".part")
+	planted := make([]byte, 3*message.FileChunkSize+100)
+	require.NoError(t, os.WriteFile(partPath, planted, 0600))
+
+	receiving := newFileTestManager()
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	sending := newFileTestManager()
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID()))
+
+	received, err := os.ReadFile(filepath.Join(downloads, "payload.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+	leftover, err := os.ReadFile(partPath)
+	require.NoError(t, err)
+	assert.Equal(t, planted, leftover)
+}
+
+func TestFileTransferDetectsStallAndResumes(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	// The first stream goes silent; later ones are served normally
+	var streams atomic.Int32
+	receiving := newFileTestManager()
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		if streams.Add(1) == 1 {
+			time.Sleep(2 * time.Second)
+			_ = s.Reset()
+			return
+		}
+		_ = receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, 4*message.FileChunkSize)
+	sending := newFileTestManager()
+
+	start := time.Now()
+	require.NoError(t, sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID()))
+	assert.Less(t, time.Since(start), 2*time.Second, "stall should be detected before the silent peer gives up")
+
+	transfers := sending.ListTransfers()
+	require.Len(t, transfers, 1)
+	assert.Equal(t, 1, transfers[0].Stalls)
+	assert.Equal(t, message.FileTransferCompleted, transfers[0].Status)
+	assert.FileExists(t, filepath.Join(downloads, "payload.bin"))
+}
+
+func TestFileTransferStallWithoutResumeFails(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		time.Sleep(2 * time.Second)
+		_ = s.Reset()
+	})
+
+	path := writeRandomFile(t, message.FileChunkSize)
+	sending := newFileTestManager()
+	sending.MaxResumes = 0
+
+	err := sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, message.ErrTransferStalled))
+}
+
+func TestFileTransferRefusesSecondResumeStream(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	downloads := t.TempDir()
+
+	receiving := newFileTestManager()
+	results := make(chan error, 3)
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		results <- receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, 8*message.FileChunkSize)
+	metadata, err := message.CreateFileMetadata(path)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	chunk := func(id int) message.FileTransferRequest {
+		return message.FileTransferRequest{Type: "chunk", ChunkID: id, Data: data[id*message.FileChunkSize : (id+1)*message.FileChunkSize]}
+	}
+
+	// The first stream is accepted and interrupted after two chunks
+	first, err := sender.NewStream(context.Background(), receiver.ID(), message.FileProtocolID)
+	require.NoError(t, err)
+	writeTestFileFrame(t, first, message.FileTransferRequest{Type: "request", Metadata: *metadata})
+	require.Equal(t, "accept", readTestFileFrame(t, first).Type)
+	writeTestFileFrame(t, first, chunk(0))
+	writeTestFileFrame(t, first, chunk(1))
+	require.Eventually(t, func() bool {
+		transfer, ok := receiving.GetTransfer(metadata.ID)
+		return ok && transfer.BytesReceived == 2*message.FileChunkSize
+	}, 5*time.Second, 10*time.Millisecond)
+	_ = first.Reset()
+	require.Error(t, <-results)
+
+	// Two streams resume it at once; only one may write the partial file
+	var streams []network.Stream
+	for i := 0; i < 2; i++ {
+		stream, err := sender.NewStream(context.Background(), receiver.ID(), message.FileProtocolID)
+		require.NoError(t, err)
+		writeTestFileFrame(t, stream, message.FileTransferRequest{Type: "request", Metadata: *metadata})
+		streams = append(streams, stream)
+	}
+	var stream network.Stream
+	var refused []message.FileTransferRequest
+	for _, s := range streams {
+		frame := readTestFileFrame(t, s)
+		if frame.Type == "accept" {
+			stream = s
+			continue
+		}
+		refused = append(refused, frame)
+		_ = s.Close()
+	}
+	require.NotNil(t, stream, "neither stream resumed the transfer")
+	require.Len(t, refused, 1)
+	assert.Equal(t, "reject", refused[0].Type)
+	assert.Equal(t, message.ErrCodeInvalid, refused[0].Code)
+	assert.Contains(t, refused[0].Error, "in progress")
+	require.Error(t, <-results)
+
+	defer func() { _ = stream.Close() }()
+	for id := 2; id < 8; id++ {
+		writeTestFileFrame(t, stream, chunk(id))
+	}
+	writeTestFileFrame(t, stream, message.FileTransferRequest{Type: "complete"})
+	require.NoError(t, <-results)
+
+	received, err := os.ReadFile(filepath.Join(downloads, "payload.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, data, received)
+}
@@ -160,6 +160,7 @@ func TestFileTransferStatus(t *testing.T) {
 		{message.FileTransferCompleted, "completed"},
 		{message.FileTransferFailed, "failed"},
 		{message.FileTransferCancelled, "cancelled"},
+		{message.FileTransferStalled, "stalled"},
 	}
 
 	for _, test := range tests {
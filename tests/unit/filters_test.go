@@ -0,0 +1,106 @@
+package unit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentFilters(t *testing.T) {
+	filters := []*message.Filter{
+		{ID: 1, Pattern: "Standup", Action: message.FilterMute},
+		{ID: 2, Pattern: `^\[bot\]`, Regex: true, PeerID: "peer-a", Action: message.FilterArchive},
+		{ID: 3, Pattern: "release", Action: message.FilterTag, Tag: "work"},
+	}
+	for _, f := range filters {
+		require.NoError(t, f.Compile())
+	}
+
+	match := message.MatchFilters(filters, "peer-b", []byte("daily STANDUP about the release"))
+	assert.True(t, match.Muted)
+	assert.False(t, match.Archived)
+	assert.Equal(t, []string{"work"}, match.Tags)
+
+	// Scoped filters only apply to their conversation
+	assert.True(t, message.MatchFilters(filters, "peer-a", []byte("[bot] build passed")).Archived)
+	assert.True(t, message.MatchFilters(filters, "peer-b", []byte("[bot] build passed")).IsZero())
+
+	invalid := []*message.Filter{
+		{Pattern: "(", Regex: true, Action: message.FilterMute},
+		{Pattern: "x", Action: message.FilterTag},
+		{Pattern: "x", Action: "delete"},
+		{Pattern: "", Action: message.FilterMute},
+	}
+	for _, f := range invalid {
+		assert.Error(t, f.Compile(), f.Pattern)
+	}
+
+	// Filters round-trip through the filters file
+	path := filepath.Join(t.TempDir(), message.FiltersFileName)
+	loaded, err := message.LoadFilters(path)
+	require.NoError(t, err)
+	assert.Empty(t, loaded)
+	require.NoError(t, message.SaveFilters(path, filters))
+	loaded, err = message.LoadFilters(path)
+	require.NoError(t, err)
+	require.Len(t, loaded, 3)
+	assert.Equal(t, "peer-a", loaded[1].PeerID)
+	assert.True(t, message.MatchFilters(loaded, "peer-a", []byte("[bot] deploy")).Archived)
+}
+
+func TestContentFiltersOnReceivedMessages(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	sending := newTestMessageManager(t, senderHost)
+	receiving := newTestMessageManager(t, receiverHost)
+	handler := &captureHandler{messages: make(chan *message.Message, 3)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	history, err := db.NewSQLiteDB(t.TempDir(), "filter-test-key", logrus.New())
+	require.NoError(t, err)
+	defer history.Close()
+	receiving.SetRecorder(history)
+
+	// Filters written after start are picked up by the running node
+	require.NoError(t, message.SaveFilters(filepath.Join(home, ".xelvra", message.FiltersFileName), []*message.Filter{
+		{ID: 1, Pattern: "lunch", Action: message.FilterArchive},
+		{ID: 2, Pattern: "urgent", Action: message.FilterTag, Tag: "important"},
+	}))
+
+	to := receiverHost.ID().String()
+	for _, text := range []string{"lunch at noon?", "urgent: server down", "hello"} {
+		require.NoError(t, sending.SendMessage(to, []byte(text), message.MessageTypeText))
+		select {
+		case msg := <-handler.messages:
+			match := message.FilterMatchOf(msg.Metadata)
+			switch text {
+			case "lunch at noon?":
+				assert.True(t, match.Archived)
+			case "urgent: server down":
+				assert.Equal(t, []string{"important"}, match.Tags)
+			default:
+				assert.True(t, match.IsZero())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message %q was not delivered", text)
+		}
+	}
+
+	all, err := history.QueryHistory(db.HistoryQuery{})
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+	visible, err := history.QueryHistory(db.HistoryQuery{HideArchived: true})
+	require.NoError(t, err)
+	require.Len(t, visible, 2)
+	for _, entry := range visible {
+		assert.NotEqual(t, "lunch at noon?", string(entry.Content))
+	}
+}
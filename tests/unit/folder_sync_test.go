@@ -0,0 +1,159 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionVectorCompare(t *testing.T) {
+	a := message.VersionVector{"a": 1}
+	b := message.VersionVector{"b": 1}
+
+	assert.Equal(t, message.VersionEqual, a.Compare(message.VersionVector{"a": 1}))
+	assert.Equal(t, message.VersionConcurrent, a.Compare(b))
+	assert.Equal(t, message.VersionAfter, a.Increment("a").Compare(a))
+	assert.Equal(t, message.VersionBefore, a.Compare(a.Merge(b)))
+	assert.Equal(t, message.VersionEqual, message.VersionVector(nil).Compare(message.VersionVector{}))
+
+	merged := a.Increment("a").Merge(b)
+	assert.Equal(t, message.VersionVector{"a": 2, "b": 1}, merged)
+	assert.Equal(t, uint64(1), a["a"], "Increment must not modify the receiver")
+}
+
+func TestSyncFolderConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), message.SyncFoldersFileName)
+	local := t.TempDir()
+	peerID := "12D3KooWBhSxema2VqCGWW3dBkNQjzuUoTAozK9XP6y8JZtQZtjJ"
+
+	folder, err := message.AddSyncFolder(path, peerID, local, "/home/me/docs/")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/me/docs", folder.Remote)
+
+	_, err = message.AddSyncFolder(path, peerID, local, "/elsewhere")
+	assert.Error(t, err, "the same folder cannot be paired twice with one peer")
+	_, err = message.AddSyncFolder(path, "not-a-peer", local, "/x")
+	assert.Error(t, err)
+	_, err = message.AddSyncFolder(path, peerID, filepath.Join(local, "missing"), "/x")
+	assert.Error(t, err)
+
+	folders, err := message.LoadSyncFolders(path)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+
+	require.NoError(t, message.RemoveSyncFolder(path, folder.ID))
+	folders, err = message.LoadSyncFolders(path)
+	require.NoError(t, err)
+	assert.Empty(t, folders)
+}
+
+func TestFolderSyncTwoWay(t *testing.T) {
+	hostA, hostB := newConnectedHosts(t)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	dataA, dataB := t.TempDir(), t.TempDir()
+	dirA, dirB := t.TempDir(), t.TempDir()
+
+	folderA, err := message.AddSyncFolder(filepath.Join(dataA, message.SyncFoldersFileName), hostB.ID().String(), dirA, dirB)
+	require.NoError(t, err)
+	_, err = message.AddSyncFolder(filepath.Join(dataB, message.SyncFoldersFileName), hostA.ID().String(), dirB, dirA)
+	require.NoError(t, err)
+
+	syncA := message.NewFolderSyncManager(hostA, dataA, logger)
+	message.NewFolderSyncManager(hostB, dataB, logger)
+
+	sync := func() *message.FolderSyncResult {
+		result, err := syncA.SyncNow(context.Background(), folderA)
+		require.NoError(t, err)
+		return result
+	}
+	read := func(dir, name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+	// The peer pulls our changes after answering, so wait for it
+	eventually := func(condition func() bool) {
+		require.Eventually(t, condition, 5*time.Second, 20*time.Millisecond)
+	}
+	hasContent := func(dir, name, content string) func() bool {
+		return func() bool {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			return err == nil && string(data) == content
+		}
+	}
+	conflictCopies := func(dir string) []string {
+		entries, _ := os.ReadDir(filepath.Join(dir, "notes"))
+		var copies []string
+		for _, e := range entries {
+			if strings.Contains(e.Name(), ".sync-conflict-") && !strings.HasSuffix(e.Name(), ".part") {
+				copies = append(copies, e.Name())
+			}
+		}
+		return copies
+	}
+
+	// Files flow in both directions in one round
+	writeTree(t, dirA, map[string]string{"notes/a.txt": "from A"})
+	writeTree(t, dirB, map[string]string{"b.txt": "from B"})
+	assert.Equal(t, 1, sync().Fetched)
+	assert.Equal(t, "from B", read(dirA, "b.txt"))
+	eventually(hasContent(dirB, "notes/a.txt", "from A"))
+
+	// Edits and deletions propagate
+	writeTree(t, dirB, map[string]string{"notes/a.txt": "edited on B"})
+	require.NoError(t, os.Remove(filepath.Join(dirA, "b.txt")))
+	sync()
+	assert.Equal(t, "edited on B", read(dirA, "notes/a.txt"))
+	eventually(func() bool {
+		_, err := os.Stat(filepath.Join(dirB, "b.txt"))
+		return os.IsNotExist(err)
+	})
+
+	// Concurrent edits keep both versions: the newer wins, the other is a copy
+	writeTree(t, dirA, map[string]string{"notes/a.txt": "older edit on A"})
+	writeTree(t, dirB, map[string]string{"notes/a.txt": "newer edit on B"})
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dirA, "notes/a.txt"), past, past))
+	assert.Equal(t, 1, sync().Conflicts)
+	assert.Equal(t, "newer edit on B", read(dirA, "notes/a.txt"))
+	assert.Equal(t, "newer edit on B", read(dirB, "notes/a.txt"))
+
+	// The conflict copy reaches the other device on the next round
+	copies := conflictCopies(dirA)
+	require.Len(t, copies, 1)
+	assert.Equal(t, "older edit on A", read(dirA, filepath.Join("notes", copies[0])))
+	sync()
+	eventually(hasContent(dirB, filepath.Join("notes", copies[0]), "older edit on A"))
+	assert.Len(t, conflictCopies(dirB), 1)
+
+	// Nothing changed: nothing to do
+	assert.Equal(t, message.FolderSyncResult{}, *sync())
+}
+
+func TestFolderSyncRequiresMutualConsent(t *testing.T) {
+	hostA, hostB := newConnectedHosts(t)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	dataA, dirA, dirB := t.TempDir(), t.TempDir(), t.TempDir()
+	folderA, err := message.AddSyncFolder(filepath.Join(dataA, message.SyncFoldersFileName), hostB.ID().String(), dirA, dirB)
+	require.NoError(t, err)
+
+	syncA := message.NewFolderSyncManager(hostA, dataA, logger)
+	message.NewFolderSyncManager(hostB, t.TempDir(), logger)
+
+	writeTree(t, dirA, map[string]string{"secret.txt": "data"})
+	_, err = syncA.SyncNow(context.Background(), folderA)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dirB, "secret.txt"))
+}
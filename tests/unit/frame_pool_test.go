@@ -0,0 +1,144 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkFrame returns a file protocol frame carrying a full chunk
+func chunkFrame(id int) message.FileTransferRequest {
+	data := make([]byte, message.FileChunkSize)
+	for i := range data {
+		data[i] = byte(i * id)
+	}
+	return message.FileTransferRequest{Magic: message.FileTransferMagic, Type: "chunk", ChunkID: id, Data: data}
+}
+
+func TestPooledFrames(t *testing.T) {
+	for _, binaryFrames := range []bool{false, true} {
+		// Written frames are the bytes the encoders return
+		var stream bytes.Buffer
+		var want []byte
+		for id := 1; id <= 3; id++ {
+			frame := chunkFrame(id)
+			require.NoError(t, message.WriteFrame(&stream, frame, binaryFrames))
+			encoded, err := message.EncodeFrame(frame)
+			if binaryFrames {
+				encoded, err = message.EncodeBinaryFrame(frame)
+			}
+			require.NoError(t, err)
+			want = append(want, encoded...)
+		}
+		assert.Equal(t, want, stream.Bytes())
+
+		// Frames read earlier keep their data while the buffers are reused
+		var read []*message.FileTransferRequest
+		for id := 1; id <= 3; id++ {
+			var frame message.FileTransferRequest
+			require.NoError(t, message.ReadFrame(&stream, message.FileMaxFrameSize, &frame))
+			read = append(read, &frame)
+		}
+		for i, frame := range read {
+			assert.Equal(t, chunkFrame(i+1).Data, frame.Data, "binary=%v chunk %d", binaryFrames, i+1)
+		}
+	}
+}
+
+func benchmarkWriteFrame(b *testing.B, binaryFrames bool) {
+	frame := chunkFrame(1)
+	b.SetBytes(message.FileChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := message.WriteFrame(io.Discard, frame, binaryFrames); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The Encode benchmarks allocate every frame, as writing did before frames
+// were encoded into pooled buffers
+func BenchmarkChunkFrameEncode(b *testing.B) {
+	frame := chunkFrame(1)
+	b.SetBytes(message.FileChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded, err := message.EncodeFrame(frame)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, _ = io.Discard.Write(encoded)
+	}
+}
+
+func BenchmarkChunkFrameWrite(b *testing.B) {
+	benchmarkWriteFrame(b, false)
+}
+
+func BenchmarkChunkFrameEncodeBinary(b *testing.B) {
+	frame := chunkFrame(1)
+	b.SetBytes(message.FileChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoded, err := message.EncodeBinaryFrame(frame)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, _ = io.Discard.Write(encoded)
+	}
+}
+
+func BenchmarkChunkFrameWriteBinary(b *testing.B) {
+	benchmarkWriteFrame(b, true)
+}
+
+// BenchmarkChunkFrameReadUnpooled reads frames as before buffers were
+// pooled, allocating each frame body
+func BenchmarkChunkFrameReadUnpooled(b *testing.B) {
+	encoded, err := message.EncodeFrame(chunkFrame(1))
+	require.NoError(b, err)
+	r := bytes.NewReader(encoded)
+	b.SetBytes(message.FileChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(encoded)
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			b.Fatal(err)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			b.Fatal(err)
+		}
+		var frame message.FileTransferRequest
+		if err := json.Unmarshal(data, &frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkChunkFrameRead(b *testing.B) {
+	encoded, err := message.EncodeFrame(chunkFrame(1))
+	require.NoError(b, err)
+	r := bytes.NewReader(encoded)
+	b.SetBytes(message.FileChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(encoded)
+		var frame message.FileTransferRequest
+		if err := message.ReadFrame(r, message.FileMaxFrameSize, &frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRecordAndQuery(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := db.LoadOrCreateKey(dir)
+	require.NoError(t, err)
+	again, err := db.LoadOrCreateKey(dir)
+	require.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	store, err := db.NewSQLiteDB(dir, key, logrus.New())
+	require.NoError(t, err)
+	defer store.Close()
+
+	base := time.Now().Add(-time.Hour)
+	record := func(id, peerID string, msgType message.MessageType, offset time.Duration, outgoing bool) {
+		msg := &message.Message{
+			ID:        id,
+			Type:      msgType,
+			Content:   []byte("content " + id),
+			Metadata:  map[string]interface{}{"kind": id},
+			Timestamp: base.Add(offset),
+		}
+		require.NoError(t, store.RecordMessage(msg, peerID, outgoing))
+	}
+
+	record("m1", "peer-a", message.MessageTypeText, 0, true)
+	record("m2", "peer-a", message.MessageTypeText, time.Minute, false)
+	record("m3", "peer-b", message.MessageTypeText, 2*time.Minute, false)
+	record("m4", "peer-a", message.MessageTypeSystem, 3*time.Minute, false)
+	record("m2", "peer-a", message.MessageTypeText, time.Minute, false) // duplicate
+
+	entries, err := store.QueryHistory(db.HistoryQuery{PeerID: "peer-a"})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "m4", entries[0].ID)
+	assert.Equal(t, "m1", entries[2].ID)
+	assert.True(t, entries[2].Outgoing)
+	assert.Equal(t, []byte("content m1"), entries[2].Content)
+	assert.Equal(t, "m1", entries[2].Metadata["kind"])
+
+	entries, err = store.QueryHistory(db.HistoryQuery{
+		PeerID: "peer-a",
+		Types:  []message.MessageType{message.MessageTypeText},
+		Since:  base.Add(30 * time.Second),
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "m2", entries[0].ID)
+
+	entries, err = store.QueryHistory(db.HistoryQuery{Limit: 2, Offset: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "m3", entries[0].ID)
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"12h", now.Add(-12 * time.Hour)},
+		{"2d", now.Add(-48 * time.Hour)},
+		{"1w", now.Add(-7 * 24 * time.Hour)},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			since, err := db.ParseSince(test.input, now)
+			require.NoError(t, err)
+			assert.True(t, test.expected.Equal(since), "got %s", since)
+		})
+	}
+
+	for _, invalid := range []string{"yesterday", "-2d", "2x", "d"} {
+		_, err := db.ParseSince(invalid, now)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := db.OpenHistory(dir, logrus.New())
+	require.NoError(t, err)
+
+	base := time.Now().Add(-time.Hour)
+	record := func(id, peerID, content string, offset time.Duration) {
+		msg := &message.Message{
+			ID:        id,
+			Type:      message.MessageTypeText,
+			Content:   []byte(content),
+			Timestamp: base.Add(offset),
+		}
+		require.NoError(t, store.RecordMessage(msg, peerID, false))
+	}
+
+	record("s1", "peer-a", "Meeting notes are in the shared folder", 0)
+	record("s2", "peer-b", "Can we move the meeting to Friday?", time.Minute)
+	record("s3", "peer-a", "Notes from yesterday's MEETING attached", 2*time.Minute)
+	record("s4", "peer-a", "Lunch?", 3*time.Minute)
+
+	results, err := store.SearchHistory(db.SearchQuery{Text: "meeting notes"})
+	require.NoError(t, err)
+	ids := make([]string, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.Entry.ID)
+	}
+	assert.ElementsMatch(t, []string{"s1", "s3"}, ids)
+	assert.Contains(t, results[0].Snippet, "[")
+
+	results, err = store.SearchHistory(db.SearchQuery{Text: "meeting", PeerID: "peer-b"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "s2", results[0].Entry.ID)
+	assert.Equal(t, "Can we move the [meeting] to Friday?", results[0].Snippet)
+
+	_, err = store.SearchHistory(db.SearchQuery{Text: "?!"})
+	assert.Error(t, err)
+
+	// Messages recorded before reopening stay searchable
+	require.NoError(t, store.Close())
+	store, err = db.OpenHistory(dir, logrus.New())
+	require.NoError(t, err)
+	defer store.Close()
+
+	results, err = store.SearchHistory(db.SearchQuery{Text: "lunch"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "s4", results[0].Entry.ID)
+}
+
+func TestHistoryExport(t *testing.T) {
+	dir := t.TempDir()
+	store, err := db.NewSQLiteDB(dir, "export-test-key", logrus.New())
+	require.NoError(t, err)
+	defer store.Close()
+
+	base := time.Date(2024, 3, 10, 9, 30, 0, 0, time.Local)
+	messages := []struct {
+		msg      *message.Message
+		peerID   string
+		outgoing bool
+	}{
+		{&message.Message{ID: "m1", Type: message.MessageTypeText, Content: []byte("Hi <b>Alice</b>"), Timestamp: base}, "peer-a", true},
+		{&message.Message{ID: "m2", Type: message.MessageTypeText, Content: []byte("Hello!\nHow are you?"), Timestamp: base.Add(time.Minute)}, "peer-a", false},
+		{&message.Message{ID: "f1", Type: message.MessageTypeFile, Content: []byte("photo.jpg"), Timestamp: base.Add(25 * time.Hour), Metadata: map[string]interface{}{
+			"file_name": "photo.jpg", "file_size": 2048, "file_hash": "abc", "path": "/tmp/photo.jpg",
+		}}, "peer-a", false},
+		{&message.Message{ID: "m3", Type: message.MessageTypeText, Content: []byte("other chat"), Timestamp: base}, "peer-b", false},
+	}
+	for _, m := range messages {
+		require.NoError(t, store.RecordMessage(m.msg, m.peerID, m.outgoing))
+	}
+
+	names := map[string]string{"peer-a": "alice"}
+	export := func(format db.ExportFormat) (string, int) {
+		var buf bytes.Buffer
+		count, err := store.ExportHistory(&buf, db.ExportOptions{PeerID: "peer-a", Format: format, Names: names})
+		require.NoError(t, err)
+		return buf.String(), count
+	}
+
+	// JSON is a complete document, oldest message first
+	out, count := export(db.ExportJSON)
+	assert.Equal(t, 3, count)
+	var doc struct {
+		Title    string               `json:"title"`
+		Messages []db.ExportedMessage `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &doc))
+	assert.Equal(t, "Chat with alice", doc.Title)
+	require.Len(t, doc.Messages, 3)
+	assert.Equal(t, "m1", doc.Messages[0].ID)
+	assert.Equal(t, "out", doc.Messages[0].Direction)
+	assert.Equal(t, "alice", doc.Messages[1].PeerName)
+	require.NotNil(t, doc.Messages[2].File)
+	assert.Equal(t, db.ExportedFile{Name: "photo.jpg", Size: 2048, Hash: "abc", Path: "/tmp/photo.jpg"}, *doc.Messages[2].File)
+
+	// Markdown groups messages by day and keeps multi-line text in its item
+	out, _ = export(db.ExportMarkdown)
+	assert.Contains(t, out, "# Chat with alice")
+	assert.Contains(t, out, "## 2024-03-10")
+	assert.Contains(t, out, "## 2024-03-11")
+	assert.Contains(t, out, "- **09:30 You:** Hi <b>Alice</b>")
+	assert.Contains(t, out, "- **09:31 alice:** Hello!  \n  How are you?")
+	assert.Contains(t, out, "photo.jpg (2.0 KiB)")
+	assert.NotContains(t, out, "other chat")
+	assert.Less(t, strings.Index(out, "Hi <b>"), strings.Index(out, "Hello!"))
+
+	// HTML escapes message content
+	out, _ = export(db.ExportHTML)
+	assert.Contains(t, out, "Hi &lt;b&gt;Alice&lt;/b&gt;")
+	assert.NotContains(t, out, "<b>Alice</b>")
+	assert.True(t, strings.HasSuffix(out, "</html>\n"))
+
+	// Without a peer every conversation is exported
+	var all bytes.Buffer
+	count, err = store.ExportHistory(&all, db.ExportOptions{Format: db.ExportJSON})
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	_, err = db.ParseExportFormat("markdown")
+	assert.NoError(t, err)
+	_, err = db.ParseExportFormat("pdf")
+	assert.Error(t, err)
+}
+
+func TestHistoryRetention(t *testing.T) {
+	dir := t.TempDir()
+	store, err := db.NewSQLiteDB(dir, "retention-test-key", logrus.New())
+	require.NoError(t, err)
+	defer store.Close()
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		for _, peerID := range []string{"peer-a", "peer-b", "peer-c"} {
+			msg := &message.Message{
+				ID:        fmt.Sprintf("%s-%d", peerID, i),
+				Type:      message.MessageTypeText,
+				Content:   []byte(fmt.Sprintf("secret number %d", i)),
+				Timestamp: now.AddDate(0, 0, -i*10).Add(-time.Hour), // 0, 10, ..., 90 days old
+			}
+			require.NoError(t, store.RecordMessage(msg, peerID, false))
+		}
+	}
+
+	policy := &db.RetentionPolicy{
+		Default: db.RetentionRule{KeepDays: 30},
+		Peers: map[string]db.RetentionRule{
+			"peer-b": {KeepMessages: 2},
+			"peer-c": {},
+		},
+	}
+	assert.Equal(t, "keep 30 day(s)", policy.RuleFor("peer-a").String())
+	assert.True(t, policy.RuleFor("peer-c").Forever())
+
+	deleted, err := store.PruneHistory(policy, now)
+	require.NoError(t, err)
+	assert.Equal(t, 7+8, deleted)
+
+	count := func(peerID string) int {
+		entries, err := store.QueryHistory(db.HistoryQuery{PeerID: peerID, Limit: 100})
+		require.NoError(t, err)
+		return len(entries)
+	}
+	assert.Equal(t, 3, count("peer-a")) // 0, 10 and 20 days old
+	assert.Equal(t, 2, count("peer-b"))
+	assert.Equal(t, 10, count("peer-c"))
+
+	// Pruned messages are gone from search too
+	results, err := store.SearchHistory(db.SearchQuery{Text: "secret number 9"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "peer-c", results[0].Entry.PeerID)
+
+	// Nothing more to delete
+	deleted, err = store.PruneHistory(policy, now)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	// Policies round-trip through the policy file
+	path := filepath.Join(dir, db.RetentionFileName)
+	loaded, err := db.LoadRetentionPolicy(path)
+	require.NoError(t, err)
+	assert.True(t, loaded.Default.Forever())
+	require.NoError(t, db.SaveRetentionPolicy(path, policy))
+	loaded, err = db.LoadRetentionPolicy(path)
+	require.NoError(t, err)
+	assert.Equal(t, policy, loaded)
+}
+
+func TestHistoryStars(t *testing.T) {
+	dir := t.TempDir()
+	store, err := db.NewSQLiteDB(dir, "stars-test-key", logrus.New())
+	require.NoError(t, err)
+	defer store.Close()
+
+	now := time.Now()
+	ids := []string{"5f1c2a90-0000-4000-8000-000000000001", "5f1c2a90-0000-4000-8000-000000000002", "a0b1c2d3-0000-4000-8000-000000000003"}
+	for i, id := range ids {
+		require.NoError(t, store.RecordMessage(&message.Message{
+			ID:        id,
+			Type:      message.MessageTypeText,
+			Content:   []byte(fmt.Sprintf("address %d", i)),
+			Timestamp: now.AddDate(0, 0, -10*i),
+		}, "peer-a", false))
+	}
+
+	// Full IDs and unique prefixes are accepted
+	starred, err := store.StarMessage("a0b1c2", true)
+	require.NoError(t, err)
+	assert.Equal(t, ids[2], starred)
+	_, err = store.StarMessage(ids[1], true)
+	require.NoError(t, err)
+
+	_, err = store.StarMessage("5f1c2a90", true)
+	assert.ErrorContains(t, err, "ambiguous")
+	_, err = store.StarMessage("a0b1", true)
+	assert.Error(t, err, "prefix too short")
+	_, err = store.StarMessage("ffffffff", true)
+	assert.Error(t, err)
+
+	entries, err := store.QueryHistory(db.HistoryQuery{Starred: true, Ascending: true})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ids[2], entries[0].ID)
+	assert.False(t, entries[0].StarredAt.IsZero())
+
+	// Retention keeps starred messages
+	deleted, err := store.PruneHistory(&db.RetentionPolicy{Default: db.RetentionRule{KeepDays: 5}}, now)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	_, err = store.StarMessage(ids[2], false)
+	require.NoError(t, err)
+	deleted, err = store.PruneHistory(&db.RetentionPolicy{Default: db.RetentionRule{KeepDays: 5}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	entries, err = store.QueryHistory(db.HistoryQuery{Starred: true})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, ids[1], entries[0].ID)
+}
@@ -0,0 +1,42 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHolePunchTracer(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	tracer := p2p.NewHolePunchTracer(logger)
+
+	remote := newWakeTestHost(t).ID()
+	now := time.Now()
+	trace := func(evt interface{}) {
+		tracer.Trace(&holepunch.Event{Timestamp: now.UnixNano(), Remote: remote, Evt: evt})
+	}
+
+	// Failed direct dials and attempts in progress change nothing
+	trace(&holepunch.DirectDialEvt{Success: false, Error: "no public address"})
+	trace(&holepunch.HolePunchAttemptEvt{Attempt: 1})
+	assert.Equal(t, p2p.HolePunchStatus{}, tracer.Status())
+
+	trace(&holepunch.EndHolePunchEvt{Success: false, Error: "all retries failed"})
+	status := tracer.Status()
+	assert.Equal(t, 1, status.Attempts)
+	assert.Zero(t, status.Upgrades)
+	assert.Equal(t, "all retries failed", status.LastError)
+
+	trace(&holepunch.EndHolePunchEvt{Success: true, EllapsedTime: 80 * time.Millisecond})
+	trace(&holepunch.DirectDialEvt{Success: true})
+	status = tracer.Status()
+	assert.Equal(t, 2, status.Attempts)
+	assert.Equal(t, 2, status.Upgrades)
+	assert.Equal(t, remote.String(), status.LastPeer)
+	assert.True(t, status.LastUpgrade.Equal(time.Unix(0, now.UnixNano())))
+}
@@ -0,0 +1,174 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWakeHooks(t *testing.T) {
+	hooks := []*message.Hook{
+		{ID: 1, PeerID: "peer-a", WakeMAC: "00:11:22:33:44:55"},
+		{ID: 2, Class: "file", Command: []string{"true"}},
+		{ID: 3, Tag: "important", WakeMAC: "00:11:22:33:44:55", Broadcast: "192.168.1.255:9"},
+	}
+	for _, h := range hooks {
+		require.NoError(t, h.Validate())
+	}
+
+	assert.True(t, hooks[0].Matches(message.HookEvent{PeerID: "peer-a", Class: "text"}))
+	assert.False(t, hooks[0].Matches(message.HookEvent{PeerID: "peer-b", Class: "text"}))
+	assert.True(t, hooks[1].Matches(message.HookEvent{PeerID: "peer-b", Class: "file"}))
+	assert.False(t, hooks[1].Matches(message.HookEvent{PeerID: "peer-b", Class: "text"}))
+	assert.True(t, hooks[2].Matches(message.HookEvent{Class: "text", Tags: []string{"work", "important"}}))
+	assert.False(t, hooks[2].Matches(message.HookEvent{Class: "text"}))
+
+	invalid := []*message.Hook{
+		{},
+		{WakeMAC: "00:11:22:33:44:55", Command: []string{"true"}},
+		{WakeMAC: "not-a-mac"},
+		{WakeMAC: "00:11:22:33:44:55", Broadcast: "192.168.1.255"},
+		{Class: "email", Command: []string{"true"}},
+	}
+	for _, h := range invalid {
+		assert.Error(t, h.Validate(), h.String())
+	}
+
+	// Hooks round-trip through the hooks file
+	path := filepath.Join(t.TempDir(), message.HooksFileName)
+	loaded, err := message.LoadHooks(path)
+	require.NoError(t, err)
+	assert.Empty(t, loaded)
+	require.NoError(t, message.SaveHooks(path, hooks))
+	loaded, err = message.LoadHooks(path)
+	require.NoError(t, err)
+	require.Len(t, loaded, 3)
+	assert.Equal(t, hooks[2], loaded[2])
+}
+
+func TestSendWakeOnLAN(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	hook := &message.Hook{WakeMAC: "00:11:22:33:44:55", Broadcast: conn.LocalAddr().String()}
+	require.NoError(t, hook.Run(context.Background(), message.HookEvent{}))
+
+	packet := make([]byte, 256)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(packet)
+	require.NoError(t, err)
+	mac := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	assert.Equal(t, append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...), packet[:n])
+}
+
+func TestWakeHooksOnReceivedMessages(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	sending := newTestMessageManager(t, senderHost)
+	receiving := newTestMessageManager(t, receiverHost)
+	handler := &captureHandler{messages: make(chan *message.Message, 3)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	// Hooks written after start are picked up by the running node
+	out := filepath.Join(t.TempDir(), "fired")
+	require.NoError(t, message.SaveHooks(filepath.Join(home, ".xelvra", message.HooksFileName), []*message.Hook{
+		{ID: 1, PeerID: senderHost.ID().String(), Class: "text", Command: []string{"sh", "-c", `echo "$XELVRA_PEER_ID $XELVRA_CLASS" >> ` + out}},
+		{ID: 2, PeerID: receiverHost.ID().String(), Command: []string{"sh", "-c", "echo wrong >> " + out}},
+	}))
+
+	to := receiverHost.ID().String()
+	for _, text := range []string{"are you there?", "hello?"} {
+		require.NoError(t, sending.SendMessage(to, []byte(text), message.MessageTypeText))
+		select {
+		case <-handler.messages:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message %q was not delivered", text)
+		}
+	}
+
+	// The second message arrives within the minute, so the hook fired once
+	want := senderHost.ID().String() + " text\n"
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(out)
+		return string(data) == want
+	}, 5*time.Second, 50*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+}
+
+func TestOnMessageHook(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	// The hook gets the metadata in its environment and the message on stdin
+	dir := t.TempDir()
+	out := filepath.Join(dir, "received")
+	script := filepath.Join(dir, "on-message")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\n{ echo \"$XELVRA_PEER_ID $XELVRA_CLASS\"; cat; } >> "+out+"\n"), 0700))
+
+	identity, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	receiving := message.NewMessageManager(receiverHost, identity, logger)
+	receiving.SetOnMessageHook(script)
+	require.NoError(t, receiving.Start())
+	t.Cleanup(func() { _ = receiving.Stop() })
+	handler := &captureHandler{messages: make(chan *message.Message, 2)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	// Unlike wake hooks it runs for every message, in order
+	sending := newTestMessageManager(t, senderHost)
+	texts := []string{"first", "second"}
+	for _, text := range texts {
+		require.NoError(t, sending.SendMessage(receiverHost.ID().String(), []byte(text), message.MessageTypeText))
+		select {
+		case <-handler.messages:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message %q was not delivered", text)
+		}
+	}
+
+	var lines []string
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(out)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+		return len(lines) == 4
+	}, 10*time.Second, 50*time.Millisecond)
+	for i, text := range texts {
+		assert.Equal(t, senderHost.ID().String()+" text", lines[2*i])
+		var event message.OnMessageEvent
+		require.NoError(t, json.Unmarshal([]byte(lines[2*i+1]), &event))
+		assert.Equal(t, text, event.Content)
+		assert.Equal(t, senderHost.ID().String(), event.PeerID)
+		assert.Equal(t, "text", event.Class)
+		assert.NotEmpty(t, event.ID)
+	}
+}
+
+func TestOnMessageHookConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte("hooks:\n  on_message: /usr/local/bin/on-message\n"), 0600))
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/local/bin/on-message", config.Hooks.OnMessage)
+}
@@ -0,0 +1,76 @@
+package unit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/qrcode"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQRCodeEncode(t *testing.T) {
+	code, err := qrcode.Encode([]byte("xelvra://peer/"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, code.Version, "14 bytes fit version 1 at level M")
+	assert.Equal(t, 21, code.Size)
+
+	// Finder patterns: dark outer ring, light ring, dark centre
+	for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+		x, y := corner[0], corner[1]
+		assert.True(t, code.Dark(x, y))
+		assert.False(t, code.Dark(x+1, y+1))
+		assert.True(t, code.Dark(x+3, y+3))
+	}
+	assert.True(t, code.Dark(8, code.Size-8), "the dark module is always set")
+
+	code, err = qrcode.Encode(make([]byte, 15))
+	require.NoError(t, err)
+	assert.Equal(t, 2, code.Version)
+
+	code, err = qrcode.Encode(make([]byte, 2331))
+	require.NoError(t, err)
+	assert.Equal(t, 40, code.Version)
+	_, err = qrcode.Encode(make([]byte, 2332))
+	assert.Error(t, err)
+
+	// Two rows of modules per line, with the quiet zone
+	code, err = qrcode.Encode([]byte("hello"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(code.Terminal(), "\n"), "\n")
+	assert.Len(t, lines, (code.Size+8+1)/2)
+}
+
+func TestIdentityURI(t *testing.T) {
+	id := testPeerIDs(t, 1)[0]
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		ma.StringCast("/ip4/192.168.1.20/tcp/4001"),
+		ma.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1"),
+	}
+	did := "did:xelvra:5B5CDn5SvTvYHnyuShbAoLGxRzrcGQthUNYHz61TjCei"
+
+	uri := p2p.NewIdentityURI(id, did, addrs)
+	require.Len(t, uri.Addrs, 2, "loopback is not shared")
+	assert.Equal(t, "/ip4/93.184.216.34/udp/4001/quic-v1", uri.Addrs[0].String(), "public addresses come first")
+
+	link := uri.String()
+	assert.True(t, strings.HasPrefix(link, "xelvra://peer/"+id.String()+"?"))
+	assert.Contains(t, link, "addr=/ip4/93.184.216.34/udp/4001/quic-v1", "multiaddrs stay readable")
+
+	parsed, err := p2p.ParseIdentityURI(link)
+	require.NoError(t, err)
+	assert.Equal(t, id, parsed.PeerID)
+	assert.Equal(t, did, parsed.DID)
+	assert.Equal(t, uri.Addrs, parsed.Addrs)
+	assert.Equal(t, id, parsed.AddrInfo().ID)
+
+	_, err = p2p.ParseIdentityURI("https://peer/" + id.String())
+	assert.Error(t, err)
+	_, err = p2p.ParseIdentityURI("xelvra://peer/not-a-peer")
+	assert.ErrorContains(t, err, "peer ID")
+	_, err = p2p.ParseIdentityURI("xelvra://peer/" + id.String() + "?addr=/ip4/nonsense")
+	assert.ErrorContains(t, err, "address")
+}
@@ -0,0 +1,50 @@
+package unit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/interop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// vectorsDir is the in-tree vector directory, relative to this package
+var vectorsDir = filepath.Join("..", "vectors")
+
+func TestInteropVectorsMatchNode(t *testing.T) {
+	vectors, err := interop.Load(vectorsDir)
+	require.NoError(t, err)
+	assert.Positive(t, vectors.Count())
+	require.NoError(t, vectors.Verify())
+}
+
+func TestInteropVectorsAreUpToDate(t *testing.T) {
+	inTree, err := interop.Load(vectorsDir)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	generated, err := interop.Generate()
+	require.NoError(t, err)
+	require.NoError(t, generated.Save(dir))
+	reloaded, err := interop.Load(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, inTree, reloaded, "run go run ./cmd/interop -generate and commit the vectors")
+}
+
+func TestInteropReportsMismatches(t *testing.T) {
+	vectors, err := interop.Generate()
+	require.NoError(t, err)
+
+	vectors.X3DH.Vectors[0].SharedSecret = "00"
+	vectors.Envelopes.Vectors[1].Signature = "00"
+	vectors.FileFrames.Vectors[0].Frame = "00"
+
+	err = vectors.Verify()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), interop.X3DHFile+" basic: shared_secret")
+	assert.Contains(t, err.Error(), interop.EnvelopesFile+" interop-notice: signature")
+	assert.Contains(t, err.Error(), interop.FileFramesFile+" request: frame")
+	assert.NotContains(t, err.Error(), interop.MessageKeysFile)
+}
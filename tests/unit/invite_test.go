@@ -0,0 +1,154 @@
+package unit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInviteCodeRoundTrip(t *testing.T) {
+	code := &p2p.InviteCode{
+		PeerID:    "12D3KooWTemporaryInvitePeer",
+		Addrs:     []string{"/ip4/192.168.1.10/tcp/40123"},
+		Token:     "00112233445566778899aabbccddeeff",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+
+	encoded, err := p2p.EncodeInviteCode(code)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, p2p.InviteCodePrefix))
+	assert.NotContains(t, encoded, code.Token, "token must not appear in clear text")
+
+	decoded, err := p2p.DecodeInviteCode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, code, decoded)
+}
+
+func TestInviteCodeRejectsInvalid(t *testing.T) {
+	_, err := p2p.DecodeInviteCode("not-an-invite")
+	assert.Error(t, err)
+
+	_, err = p2p.DecodeInviteCode(p2p.InviteCodePrefix + "0OIl")
+	assert.Error(t, err)
+
+	incomplete, err := p2p.EncodeInviteCode(&p2p.InviteCode{PeerID: "x"})
+	require.NoError(t, err)
+	_, err = p2p.DecodeInviteCode(incomplete)
+	assert.Error(t, err)
+}
+
+func TestInviteLifecycle(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	_, err := p2p.CreateInvite(p2p.MaxInviteTTL + time.Hour)
+	assert.Error(t, err)
+
+	invite, err := p2p.CreateInvite(30 * time.Minute)
+	require.NoError(t, err)
+	assert.True(t, invite.IsActive())
+	assert.NotEmpty(t, invite.Addrs)
+
+	code, err := invite.Code()
+	require.NoError(t, err)
+	decoded, err := p2p.DecodeInviteCode(code)
+	require.NoError(t, err)
+	assert.Equal(t, invite.Token, decoded.Token)
+
+	invites, err := p2p.ListInvites()
+	require.NoError(t, err)
+	require.Len(t, invites, 1)
+	assert.Equal(t, invite.ID, invites[0].ID)
+
+	require.NoError(t, p2p.RevokeInvite(invite.ID))
+	assert.Error(t, p2p.RevokeInvite(invite.ID))
+
+	invites, err = p2p.ListInvites()
+	require.NoError(t, err)
+	assert.Empty(t, invites)
+}
+
+func TestInviteRevokedBeforeRefreshIsRefused(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	inviterHost, redeemerHost := newConnectedHosts(t)
+
+	inviterID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	redeemerID, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	invite, err := p2p.CreateInvite(30 * time.Minute)
+	require.NoError(t, err)
+	code, err := invite.Code()
+	require.NoError(t, err)
+
+	inviter := p2p.NewInviteManager(inviterHost, inviterID, logrus.New())
+	require.NoError(t, inviter.Start())
+	t.Cleanup(func() { _ = inviter.Stop() })
+
+	// Revoked and created while the inviter still serves its last refresh
+	require.NoError(t, p2p.RevokeInvite(invite.ID))
+	other, err := p2p.CreateInvite(30 * time.Minute)
+	require.NoError(t, err)
+
+	redeemer := p2p.NewInviteManager(redeemerHost, redeemerID, logrus.New())
+	_, _, err = redeemer.Redeem(context.Background(), code)
+	assert.Error(t, err)
+
+	invites, err := p2p.ListInvites()
+	require.NoError(t, err)
+	require.Len(t, invites, 1, "the revoked invite must stay revoked")
+	assert.Equal(t, other.ID, invites[0].ID)
+}
+
+func TestInviteURI(t *testing.T) {
+	code, err := p2p.EncodeInviteCode(&p2p.InviteCode{
+		PeerID:    "12D3KooWTemporaryInvitePeer",
+		Addrs:     []string{"/ip4/192.168.1.10/tcp/40123"},
+		Token:     "00112233445566778899aabbccddeeff",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	require.NoError(t, err)
+
+	relay := ma.StringCast("/ip4/93.184.216.34/tcp/4001/p2p/12D3KooWLmL8p8wSoD4Jv8kC6oYcCJ3gCmrcoBr9bnW1sXFGAH3k/p2p-circuit")
+	uri := &p2p.InviteURI{
+		DID:    "did:xelvra:5B5CDn5SvTvYHnyuShbAoLGxRzrcGQthUNYHz61TjCei",
+		Name:   "alice",
+		Relays: []ma.Multiaddr{relay},
+		Token:  code,
+	}
+	link := uri.String()
+	assert.True(t, strings.HasPrefix(link, "xelvra://invite?"))
+
+	parsed, err := p2p.ParseInviteURI(link)
+	require.NoError(t, err)
+	assert.Equal(t, uri, parsed)
+
+	// A bare code is a link without hints
+	parsed, err = p2p.ParseInviteURI(code)
+	require.NoError(t, err)
+	assert.Equal(t, &p2p.InviteURI{Token: code}, parsed)
+
+	// Reusable links name the peer instead of a token
+	id := testPeerIDs(t, 1)[0]
+	reusable := &p2p.InviteURI{PeerID: id, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/93.184.216.34/tcp/4001")}}
+	parsed, err = p2p.ParseInviteURI(reusable.String())
+	require.NoError(t, err)
+	assert.Equal(t, reusable, parsed)
+
+	_, err = p2p.ParseInviteURI("xelvra://invite?name=alice")
+	assert.ErrorContains(t, err, "neither")
+	_, err = p2p.ParseInviteURI("xelvra://invite?token=xinv1garbage")
+	assert.ErrorContains(t, err, "token")
+	_, err = p2p.ParseInviteURI("xelvra://invite?token=" + code + "&relay=/ip4/93.184.216.34/tcp/4001")
+	assert.ErrorContains(t, err, "p2p-circuit")
+	_, err = p2p.ParseInviteURI("xelvra://peer/" + id.String())
+	assert.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, p2p.ConfigFileName)
+
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err, "a missing file pins nothing")
+	assert.Empty(t, config.Listen)
+	assert.Empty(t, config.Announce)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+listen:
+  - /ip4/0.0.0.0/tcp/4001
+  - /ip4/0.0.0.0/udp/4001/quic-v1
+announce:
+  - /ip4/203.0.113.7/tcp/4001
+logging:
+  level: info
+`), 0600))
+	config, err = p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4001/quic-v1"}, config.Listen)
+	assert.Equal(t, []string{"/ip4/203.0.113.7/tcp/4001"}, config.Announce)
+
+	require.NoError(t, os.WriteFile(path, []byte("announce: [\"203.0.113.7:4001\"]\n"), 0600))
+	_, err = p2p.LoadConfigFile(path)
+	assert.ErrorContains(t, err, "announce")
+}
+
+func TestPinnedListenAddress(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// Find a free port to pin
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+	pinned := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port))
+	announce := ma.StringCast("/ip4/203.0.113.7/tcp/4001")
+
+	config := p2p.DefaultNodeConfig()
+	config.ListenAddrs = []string{pinned.String()}
+	config.AnnounceAddrs = []string{announce.String()}
+	config.IdentityPath, config.DataDir = "", ""
+	config.EnableQUIC = false
+
+	node, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	assert.Contains(t, node.GetHost().Network().ListenAddresses(), pinned)
+	addrs := node.GetHost().Addrs()
+	assert.Contains(t, addrs, announce)
+	assert.Contains(t, addrs, pinned, "the listen addresses are still advertised")
+
+	// With the port held by another program the node listens on a free one
+	// rather than failing
+	require.NoError(t, node.Stop())
+	l, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer l.Close()
+	second, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	defer second.Stop()
+	listening := second.GetHost().Network().ListenAddresses()
+	require.NotEmpty(t, listening)
+	assert.NotContains(t, listening, pinned)
+}
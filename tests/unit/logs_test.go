@@ -0,0 +1,52 @@
+package unit
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/cli"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogEntry(t *testing.T) {
+	entry, ok := cli.ParseLogEntry(`{"level":"warning","msg":"Bootstrap failed","peer":"abc","error":"dial backoff","time":"2026-10-16T10:00:01.5Z"}`)
+	require.True(t, ok)
+	assert.Equal(t, logrus.WarnLevel, entry.Level)
+	assert.Equal(t, "Bootstrap failed", entry.Message)
+	assert.Equal(t, time.Date(2026, 10, 16, 10, 0, 1, 500000000, time.UTC), entry.Time)
+	assert.Equal(t, `error="dial backoff" peer=abc`, entry.FieldsText())
+
+	_, ok = cli.ParseLogEntry("panic: runtime error")
+	assert.False(t, ok)
+}
+
+func TestLogFilter(t *testing.T) {
+	info := `{"level":"info","msg":"Starting DHT","time":"2026-10-16T10:00:00Z"}`
+	warn := `{"level":"warning","msg":"Bootstrap failed","time":"2026-10-16T11:00:00Z"}`
+	dhtError := `{"level":"error","msg":"Failed to open stream","component":"dht","time":"2026-10-16T12:00:00Z"}`
+	crash := "panic: runtime error"
+
+	all := &cli.LogFilter{Level: logrus.TraceLevel}
+	for _, line := range []string{info, warn, dhtError, crash} {
+		assert.True(t, all.Matches(line), line)
+	}
+
+	warnings := &cli.LogFilter{Level: logrus.WarnLevel}
+	assert.False(t, warnings.Matches(info))
+	assert.True(t, warnings.Matches(warn))
+	assert.True(t, warnings.Matches(dhtError))
+	assert.False(t, warnings.Matches(crash), "lines without a level fail a level filter")
+
+	recent := &cli.LogFilter{Level: logrus.TraceLevel, Since: time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC)}
+	assert.False(t, recent.Matches(info))
+	assert.True(t, recent.Matches(warn))
+
+	// Both the message and the fields are searched
+	dht := &cli.LogFilter{Level: logrus.TraceLevel, Grep: regexp.MustCompile("(?i)dht")}
+	assert.True(t, dht.Matches(info))
+	assert.False(t, dht.Matches(warn))
+	assert.True(t, dht.Matches(dhtError))
+}
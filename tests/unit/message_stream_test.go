@@ -0,0 +1,63 @@
+package unit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageStreamReuse(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	sending := newTestMessageManager(t, senderHost)
+	refused := make(chan *message.ProtocolError, 1)
+	sending.OnDeliveryFailed = func(msg *message.Message, err *message.ProtocolError) { refused <- err }
+
+	receiving := newTestMessageManager(t, receiverHost)
+	handler := &captureHandler{messages: make(chan *message.Message, 20)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	agreed, err := sending.PeerCapabilities(receiverHost.ID())
+	require.NoError(t, err)
+	require.True(t, agreed.Supports(message.FeatureMessageStream))
+
+	// A burst of messages shares one stream; the refused one in the middle
+	// gets its own receipt without upsetting the others
+	to := receiverHost.ID().String()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sending.SendMessage(to, []byte(fmt.Sprintf("message %d", i)), message.MessageTypeText))
+	}
+	require.NoError(t, sending.SendMessage(to, []byte("picture"), message.MessageTypeImage))
+	for i := 10; i < 20; i++ {
+		require.NoError(t, sending.SendMessage(to, []byte(fmt.Sprintf("message %d", i)), message.MessageTypeText))
+	}
+
+	for i := 0; i < 20; i++ {
+		select {
+		case msg := <-handler.messages:
+			assert.Equal(t, fmt.Sprintf("message %d", i), string(msg.Content))
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message %d was not delivered", i)
+		}
+	}
+	select {
+	case err := <-refused:
+		assert.Equal(t, message.ErrCodeUnsupported, err.Code)
+	case <-time.After(10 * time.Second):
+		t.Fatal("sender was not told about the refused message")
+	}
+
+	protocols := make(map[string]int)
+	for _, conn := range senderHost.Network().ConnsToPeer(receiverHost.ID()) {
+		for _, stream := range conn.GetStreams() {
+			protocols[string(stream.Protocol())]++
+		}
+	}
+	assert.Equal(t, 1, protocols[string(message.MessageStreamProtocolID)])
+	assert.Zero(t, protocols[string(message.MessageProtocolID)])
+}
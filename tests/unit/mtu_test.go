@@ -0,0 +1,77 @@
+package unit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSTUNResponder answers binding requests of at most limit bytes, dropping
+// larger ones like a network that black-holes large packets
+func newSTUNResponder(t *testing.T, limit int) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n > limit {
+				continue
+			}
+			request := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if request.Decode() != nil {
+				continue
+			}
+			udpAddr := addr.(*net.UDPAddr)
+			response, err := stun.Build(request, stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port})
+			if err == nil {
+				_, _ = conn.WriteTo(response.Raw, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestProbePathMTU(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	probe, err := p2p.ProbePathMTU(ctx, []string{newSTUNResponder(t, 1500)}, p2p.MTUProbeSizes)
+	require.NoError(t, err)
+	assert.Equal(t, p2p.MTUProbeSizes, probe.Delivered)
+	assert.Empty(t, probe.Dropped)
+	assert.False(t, probe.BlackHole())
+
+	// Only the smallest QUIC packets get through
+	probe, err = p2p.ProbePathMTU(ctx, []string{newSTUNResponder(t, 1250)}, p2p.MTUProbeSizes)
+	require.NoError(t, err)
+	assert.Equal(t, []int{p2p.QUICMinPacketSize}, probe.Delivered)
+	assert.Equal(t, p2p.QUICMinPacketSize, probe.LargestSize())
+	assert.True(t, probe.BlackHole())
+
+	// A tunnel with a smaller MTU is left to path MTU discovery
+	probe, err = p2p.ProbePathMTU(ctx, []string{newSTUNResponder(t, 1400)}, p2p.MTUProbeSizes)
+	require.NoError(t, err)
+	assert.Equal(t, 1360, probe.LargestSize())
+	assert.False(t, probe.BlackHole())
+}
+
+func TestProbePathMTUNoAnswer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err := p2p.ProbePathMTU(ctx, []string{newSTUNResponder(t, 100)}, []int{p2p.QUICMinPacketSize})
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeName(t *testing.T) {
+	name, err := p2p.NormalizeName("@Alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", name)
+
+	for _, invalid := range []string{"", "ab", "-alice", "alice smith", "a/b"} {
+		_, err := p2p.NormalizeName(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNameRecordValidation(t *testing.T) {
+	id, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	rec, err := p2p.NewNameRecord(id, "alice", time.Now())
+	require.NoError(t, err)
+	value, err := json.Marshal(rec)
+	require.NoError(t, err)
+
+	validator := p2p.NameValidator{}
+	assert.NoError(t, validator.Validate(p2p.NameRecordKey("alice"), value))
+	assert.Error(t, validator.Validate(p2p.NameRecordKey("bob"), value))
+
+	rec.DID = "did:xelvra:forged"
+	forged, err := json.Marshal(rec)
+	require.NoError(t, err)
+	assert.Error(t, validator.Validate(p2p.NameRecordKey("alice"), forged))
+}
+
+func TestNameRecordSelection(t *testing.T) {
+	first, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	second, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	heavy, err := user.GenerateMessengerIDWithDifficulty(user.DefaultPOWDifficulty + 4)
+	require.NoError(t, err)
+
+	encode := func(id *user.MessengerID, claimedAt time.Time) []byte {
+		rec, err := p2p.NewNameRecord(id, "alice", claimedAt)
+		require.NoError(t, err)
+		value, err := json.Marshal(rec)
+		require.NoError(t, err)
+		return value
+	}
+
+	key := p2p.NameRecordKey("alice")
+	validator := p2p.NameValidator{}
+	early := encode(first, time.Now().Add(-time.Hour))
+	late := encode(second, time.Now())
+
+	// Equal work: the stored record, passed last, is kept, even against a
+	// claim that states an earlier time
+	best, err := validator.Select(key, [][]byte{early, late})
+	require.NoError(t, err)
+	assert.Equal(t, 1, best)
+	best, err = validator.Select(key, [][]byte{late, early})
+	require.NoError(t, err)
+	assert.Equal(t, 1, best)
+
+	// More proof-of-work outranks an earlier claim
+	best, err = validator.Select(key, [][]byte{early, encode(heavy, time.Now())})
+	require.NoError(t, err)
+	assert.Equal(t, 1, best)
+
+	// Invalid records are ignored
+	best, err = validator.Select(key, [][]byte{[]byte("garbage"), late})
+	require.NoError(t, err)
+	assert.Equal(t, 1, best)
+}
+
+func TestNodeCreatedWithNameValidator(t *testing.T) {
+	// The public DHT refuses extra record validators; names have their own
+	t.Setenv("HOME", t.TempDir())
+	config := p2p.DefaultNodeConfig()
+	config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	config.IdentityPath, config.DataDir = "", ""
+
+	node, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	assert.NoError(t, node.Stop())
+}
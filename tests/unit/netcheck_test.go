@@ -0,0 +1,135 @@
+package unit
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMappingSTUNResponder answers binding requests on ip, reporting the
+// port requests come from moved by shift, like a NAT mapping each
+// destination anew when shift is not zero
+func newMappingSTUNResponder(t *testing.T, ip string, shift int) string {
+	conn, err := net.ListenPacket("udp4", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", ip, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if request.Decode() != nil {
+				continue
+			}
+			from := addr.(*net.UDPAddr)
+			response, err := stun.Build(request, stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: from.IP, Port: from.Port + shift})
+			if err == nil {
+				_, _ = conn.WriteTo(response.Raw, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestClassifyNAT(t *testing.T) {
+	if _, err := p2p.DefaultRoute("udp4"); err != nil {
+		t.Skipf("no IPv4 route: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The same mapping for both servers
+	cone := []string{newMappingSTUNResponder(t, "127.0.0.1", 0), newMappingSTUNResponder(t, "127.0.0.2", 0)}
+	nat, err := p2p.ClassifyNAT(ctx, cone)
+	require.NoError(t, err)
+	assert.Equal(t, p2p.NATClassCone, nat.Class)
+	require.Len(t, nat.Mappings, 2)
+	assert.Equal(t, nat.Mappings[0].Addr, nat.Mappings[1].Addr)
+
+	// A new port for the second server
+	symmetric := []string{newMappingSTUNResponder(t, "127.0.0.1", 0), newMappingSTUNResponder(t, "127.0.0.2", 1)}
+	nat, err = p2p.ClassifyNAT(ctx, symmetric)
+	require.NoError(t, err)
+	assert.Equal(t, p2p.NATClassSymmetric, nat.Class)
+
+	// Two addresses of one server cannot tell the mapping apart
+	one := newMappingSTUNResponder(t, "127.0.0.1", 0)
+	nat, err = p2p.ClassifyNAT(ctx, []string{one, one})
+	require.NoError(t, err)
+	assert.Equal(t, p2p.NATClassUnknown, nat.Class)
+	assert.Len(t, nat.Mappings, 1)
+}
+
+// newNTPResponder answers SNTP requests with its clock moved by offset
+func newNTPResponder(t *testing.T, offset time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	epoch := time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+	timestamp := func(t time.Time) uint64 {
+		d := t.Add(offset).Sub(epoch)
+		return uint64(d/time.Second)<<32 | uint64(d%time.Second)<<32/uint64(time.Second)
+	}
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			received := time.Now()
+			response := make([]byte, 48)
+			response[0] = 0x24 // Version 4, server
+			response[1] = 2    // Stratum
+			copy(response[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(response[32:], timestamp(received))
+			binary.BigEndian.PutUint64(response[40:], timestamp(time.Now()))
+			_, _ = conn.WriteTo(response, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestClockOffset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server := newNTPResponder(t, 42*time.Second)
+	offset, answered, err := p2p.ClockOffset(ctx, []string{server})
+	require.NoError(t, err)
+	assert.Equal(t, server, answered)
+	assert.InDelta(t, float64(42*time.Second), float64(offset), float64(100*time.Millisecond))
+
+	offset, _, err = p2p.ClockOffset(ctx, []string{newNTPResponder(t, -10*time.Minute)})
+	require.NoError(t, err)
+	assert.Greater(t, offset.Abs(), p2p.MaxClockSkew)
+}
+
+func TestCheckPortBinds(t *testing.T) {
+	binds := p2p.CheckPortBinds()
+	require.NotEmpty(t, binds)
+	assert.Equal(t, "tcp4", binds[0].Network)
+	assert.Empty(t, binds[0].Error)
+	assert.NotEmpty(t, binds[0].Addr)
+
+	beacon := binds[len(binds)-1]
+	assert.True(t, beacon.IsBeaconPort())
+}
@@ -0,0 +1,84 @@
+package unit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkIDKey(t *testing.T) {
+	assert.Equal(t, `wlan0 "Home" 192.168.1.0/24`, p2p.NetworkID{Interface: "wlan0", SSID: "Home", Subnet: "192.168.1.0/24"}.Key())
+	assert.Equal(t, "eth0 10.0.0.0/8", p2p.NetworkID{Interface: "eth0", Subnet: "10.0.0.0/8"}.Key())
+}
+
+func TestUpdateNetworkProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.NetworkProfilesFileName)
+
+	profiles, err := p2p.LoadNetworkProfiles(path)
+	require.NoError(t, err)
+	assert.Empty(t, profiles)
+
+	home := p2p.NetworkID{Interface: "wlan0", SSID: "Home", Subnet: "192.168.1.0/24"}
+	hotel := p2p.NetworkID{Interface: "wlan0", SSID: "Hotel", Subnet: "10.20.0.0/16"}
+	require.NoError(t, p2p.UpdateNetworkProfile(path, home, func(profile *p2p.NetworkProfile) {
+		profile.PathMTU = &p2p.PathMTUProbe{Delivered: []int{1200, 1280, 1360, 1452}}
+	}))
+	require.NoError(t, p2p.UpdateNetworkProfile(path, hotel, func(profile *p2p.NetworkProfile) {
+		profile.PathMTU = &p2p.PathMTUProbe{Delivered: []int{1200}, Dropped: []int{1280, 1360, 1452}}
+	}))
+
+	profiles, err = p2p.LoadNetworkProfiles(path)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	assert.Equal(t, "Hotel", profiles[hotel.Key()].SSID)
+	assert.False(t, profiles[home.Key()].PathMTU.BlackHole())
+	assert.True(t, profiles[hotel.Key()].PathMTU.BlackHole())
+
+	// Updating keeps what else was learned about the network
+	require.NoError(t, p2p.UpdateNetworkProfile(path, hotel, func(profile *p2p.NetworkProfile) {
+		profile.RememberNAT(&p2p.NATInfo{Type: "symmetric"})
+	}))
+	profiles, err = p2p.LoadNetworkProfiles(path)
+	require.NoError(t, err)
+	assert.True(t, profiles[hotel.Key()].PathMTU.BlackHole())
+	assert.Equal(t, "symmetric", profiles[hotel.Key()].KnownNAT().Type)
+}
+
+func TestNetworkProfileKnownNAT(t *testing.T) {
+	profile := &p2p.NetworkProfile{}
+	assert.Nil(t, profile.KnownNAT())
+
+	profile.RememberNAT(&p2p.NATInfo{Type: "full_cone", PublicIP: "81.2.69.160"})
+	require.NotNil(t, profile.KnownNAT())
+	assert.Equal(t, "81.2.69.160", profile.KnownNAT().PublicIP)
+
+	// Old facts are probed again
+	profile.NATUpdated = time.Now().Add(-p2p.NetworkFactsTTL - time.Hour)
+	assert.Nil(t, profile.KnownNAT())
+}
+
+func TestNetworkProfileTransports(t *testing.T) {
+	profile := &p2p.NetworkProfile{}
+	assert.Empty(t, profile.BestTransport())
+	assert.False(t, profile.QUICBlocked())
+
+	for i := 0; i < 5; i++ {
+		profile.CountConnection("tcp")
+	}
+	profile.CountConnection("relay")
+	assert.Equal(t, "tcp", profile.BestTransport())
+	assert.True(t, profile.QUICBlocked(), "only TCP ever connected")
+
+	profile.CountConnection("quic")
+	assert.False(t, profile.QUICBlocked())
+
+	// Counts older than the facts TTL start over
+	profile.ConnectionsSince = time.Now().Add(-p2p.NetworkFactsTTL - time.Hour)
+	profile.CountConnection("quic")
+	assert.Equal(t, map[string]int{"quic": 1}, profile.Connections)
+	assert.Equal(t, "quic", profile.BestTransport())
+}
@@ -0,0 +1,77 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoticeText(t *testing.T) {
+	notice := message.NewNotice(message.NoticeMemberJoined, "group", "Friends", "member", "alice")
+	assert.Equal(t, "notice.group.member_joined", notice.Key())
+	assert.Equal(t, "alice joined Friends", notice.Text(nil))
+
+	// Client translations take precedence over the English templates
+	german := map[string]string{message.NoticeMemberJoined: "{member} ist {group} beigetreten"}
+	assert.Equal(t, "alice ist Friends beigetreten", notice.Text(german))
+
+	// Notices no template knows show their code and parameters
+	unknown := message.NewNotice("bot.reminder", "when", "tomorrow", "about", "lunch")
+	assert.Equal(t, "bot.reminder about=lunch when=tomorrow", unknown.Text(nil))
+
+	assert.Error(t, message.NewNotice("").Validate())
+}
+
+func TestNoticeOf(t *testing.T) {
+	notice, err := message.NoticeOf([]byte(`{"code":"group.renamed","params":{"group":"Friends","name":"Family"}}`),
+		map[string]interface{}{message.MetadataKind: message.KindNotice})
+	require.NoError(t, err)
+	assert.Equal(t, message.NoticeGroupRenamed, notice.Code)
+	assert.Equal(t, "Friends was renamed to Family", notice.Text(nil))
+
+	_, err = message.NoticeOf([]byte(`{"params":{}}`), map[string]interface{}{message.MetadataKind: message.KindNotice})
+	assert.Error(t, err)
+
+	// Key-change announcements and free text from older peers become notices
+	notice, err = message.NoticeOf([]byte(`{}`), map[string]interface{}{
+		message.MetadataKind: message.KindKeyChange,
+		"contact":            "bob",
+		"old_peer_id":        "12D3KooWOld",
+		"new_peer_id":        "12D3KooWNew",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, message.NoticeKeyRotated, notice.Code)
+	assert.Equal(t, "bob", notice.Params["contact"])
+
+	notice, err = message.NoticeOf([]byte("maintenance at noon"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, message.NoticeText, notice.Code)
+	assert.Equal(t, "maintenance at noon", notice.Text(nil))
+}
+
+func TestSendNotice(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	sending := newTestMessageManager(t, senderHost)
+	receiving := newTestMessageManager(t, receiverHost)
+	handler := &captureHandler{messages: make(chan *message.Message, 1)}
+	receiving.RegisterHandler(message.MessageTypeSystem, handler)
+	notices := make(chan *message.Notice, 1)
+	receiving.OnNotice = func(from string, notice *message.Notice) { notices <- notice }
+
+	sent := message.NewNotice(message.NoticeMemberLeft, "group", "Friends", "member", "carol")
+	require.NoError(t, sending.SendNotice(receiverHost.ID().String(), sent))
+
+	select {
+	case notice := <-notices:
+		assert.Equal(t, sent, notice)
+	case <-time.After(10 * time.Second):
+		t.Fatal("notice was not received")
+	}
+	msg := <-handler.messages
+	assert.Equal(t, message.KindNotice, msg.Metadata[message.MetadataKind])
+}
@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryObservedAddr(t *testing.T) {
+	asker, answerer := newConnectedHosts(t)
+	p2p.ServeObservedAddr(answerer)
+
+	observed, conn, err := p2p.QueryObservedAddr(context.Background(), asker, answerer.ID())
+	require.NoError(t, err)
+	assert.True(t, observed.Equal(conn.LocalMultiaddr()), "observed %s, local %s", observed, conn.LocalMultiaddr())
+
+	// A peer that does not serve the protocol cannot answer
+	_, _, err = p2p.QueryObservedAddr(context.Background(), answerer, asker.ID())
+	assert.Error(t, err)
+}
+
+// observe records that peer n sees the connection from local at observed
+func observe(t *testing.T, book *p2p.ObservationBook, n int, local, observed string) {
+	p, err := peer.Decode([]string{
+		"12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN",
+		"12D3KooWRBhwfeP2Y4TCx1SM6s9rUoHhR5STiGwxBhgFRcw3UERE",
+		"12D3KooWLDZrg4B9GZ4TrnKHgFiqtNbxafGaZE2UGBwK2axW3bqt",
+	}[n])
+	require.NoError(t, err)
+	book.Record(p, observed, ma.StringCast(local), ma.StringCast(observed))
+}
+
+func TestObservationBookNATType(t *testing.T) {
+	book := p2p.NewObservationBook()
+	observe(t, book, 0, "/ip4/192.168.1.10/udp/4001/quic-v1", "/ip4/81.2.69.160/udp/51820/quic-v1")
+	assert.Empty(t, book.NATType(), "one report does not classify")
+
+	observe(t, book, 1, "/ip4/192.168.1.10/udp/4001/quic-v1", "/ip4/81.2.69.160/udp/51820/quic-v1")
+	observe(t, book, 2, "/ip4/192.168.1.10/udp/4001/quic-v1", "/ip4/81.2.69.160/udp/51820/quic-v1")
+	assert.Equal(t, "port_restricted", book.NATType())
+	assert.Equal(t, []p2p.ObservedAddr{{Addr: "81.2.69.160:51820", Transport: "quic", Peers: 3}}, book.Addrs())
+
+	// A different port for the same local socket is a symmetric NAT
+	observe(t, book, 2, "/ip4/192.168.1.10/udp/4001/quic-v1", "/ip4/81.2.69.160/udp/62011/quic-v1")
+	assert.Equal(t, "symmetric", book.NATType())
+	assert.Equal(t, []p2p.ObservedAddr{
+		{Addr: "81.2.69.160:51820", Transport: "quic", Peers: 2},
+		{Addr: "81.2.69.160:62011", Transport: "quic", Peers: 1},
+	}, book.Addrs())
+
+	// Disconnected peers are forgotten
+	book.Retain(func(p peer.ID, connID string) bool { return connID != "/ip4/81.2.69.160/udp/62011/quic-v1" })
+	assert.Equal(t, "port_restricted", book.NATType())
+}
+
+func TestObservationBookNoNAT(t *testing.T) {
+	book := p2p.NewObservationBook()
+	observe(t, book, 0, "/ip4/93.184.216.34/tcp/4001", "/ip4/93.184.216.34/tcp/4001")
+	observe(t, book, 1, "/ip4/93.184.216.34/tcp/4001", "/ip4/93.184.216.34/tcp/4001")
+	assert.Equal(t, "none", book.NATType())
+
+	// Reports of private addresses come from the LAN and say nothing of the NAT
+	book = p2p.NewObservationBook()
+	observe(t, book, 0, "/ip4/192.168.1.10/tcp/4001", "/ip4/192.168.1.10/tcp/4001")
+	observe(t, book, 1, "/ip4/192.168.1.10/tcp/4001", "/ip4/192.168.1.10/tcp/4001")
+	assert.Empty(t, book.NATType())
+	assert.Len(t, book.Addrs(), 1)
+}
+
+func TestObservationBookIPv6(t *testing.T) {
+	// IPv6 reaches the host directly while IPv4 goes through NAT; the NAT
+	// type is that of IPv4
+	book := p2p.NewObservationBook()
+	observe(t, book, 0, "/ip6/2606:4700:1::10/udp/4001/quic-v1", "/ip6/2606:4700:1::10/udp/4001/quic-v1")
+	assert.Equal(t, "2606:4700:1::10", book.IPv6Direct())
+	observe(t, book, 1, "/ip4/192.168.1.10/udp/4001/quic-v1", "/ip4/81.2.69.160/udp/51820/quic-v1")
+	observe(t, book, 2, "/ip4/192.168.1.10/udp/4001/quic-v1", "/ip4/81.2.69.160/udp/51820/quic-v1")
+	assert.Equal(t, "port_restricted", book.NATType())
+
+	// Without IPv4 the IPv6 reports classify
+	book = p2p.NewObservationBook()
+	observe(t, book, 0, "/ip6/2606:4700:1::10/tcp/4001", "/ip6/2606:4700:1::10/tcp/4001")
+	observe(t, book, 1, "/ip6/2606:4700:1::10/tcp/4001", "/ip6/2606:4700:1::10/tcp/4001")
+	assert.Equal(t, "none", book.NATType())
+
+	// Translated IPv6 is not direct
+	book = p2p.NewObservationBook()
+	observe(t, book, 0, "/ip6/fd00::10/tcp/4001", "/ip6/2606:4700:1::99/tcp/4001")
+	assert.Empty(t, book.IPv6Direct())
+}
@@ -0,0 +1,106 @@
+package unit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineMessagesEncryptedAtRest(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".xelvra", message.OfflineDirName)
+	require.NoError(t, os.MkdirAll(dir, 0700))
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	identity, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	// A plaintext queue written by an older version
+	queued := &message.Message{ID: "queued-message-id", To: "peer-a", Content: []byte("hello"), Timestamp: time.Now()}
+	legacy, err := json.Marshal(map[string][]*message.OfflineMessage{
+		"peer-a": {{Message: queued, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages.json"), legacy, 0600))
+
+	// The queue is migrated on first load and the plaintext removed
+	mm := message.NewMessageManager(h, identity, logger)
+	assert.Equal(t, 1, mm.OfflineMessageCount())
+	assert.NoFileExists(t, filepath.Join(dir, "messages.json"))
+	stored, err := os.ReadFile(filepath.Join(dir, message.OfflineStoreFileName))
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), queued.ID)
+
+	// The same identity reads it back
+	mm = message.NewMessageManager(h, identity, logger)
+	assert.Equal(t, 1, mm.OfflineMessageCount())
+
+	// After a key rotation the queue is re-encrypted for the new identity
+	rotated, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	require.NoError(t, message.RekeyOfflineMessages(dir, identity, rotated))
+	mm = message.NewMessageManager(h, rotated, logger)
+	assert.Equal(t, 1, mm.OfflineMessageCount())
+
+	// Another identity cannot read it, and the queue is kept aside rather
+	// than overwritten
+	mm = message.NewMessageManager(h, identity, logger)
+	assert.Equal(t, 0, mm.OfflineMessageCount())
+	assert.FileExists(t, filepath.Join(dir, message.OfflineStoreFileName+".unreadable"))
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	// The receiver starts first; both share the test's data directory
+	receiving := newTestMessageManager(t, receiverHost)
+	handler := &captureHandler{messages: make(chan *message.Message, 1)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	identity := hostIdentity(t, senderHost)
+
+	// The message is accepted but the process dies before sending it
+	crashed := message.NewMessageManager(senderHost, identity, logger)
+	require.NoError(t, crashed.SendMessage(receiverHost.ID().String(), []byte("survives"), message.MessageTypeText))
+	outbox := filepath.Join(home, ".xelvra", message.OfflineDirName, message.OutboxFileName)
+	require.FileExists(t, outbox)
+	assert.Equal(t, 1, crashed.OutboxCount())
+
+	// After the restart the message is delivered and leaves the outbox
+	restarted := message.NewMessageManager(senderHost, identity, logger)
+	require.NoError(t, restarted.Start())
+	t.Cleanup(func() { _ = restarted.Stop() })
+
+	select {
+	case msg := <-handler.messages:
+		assert.Equal(t, "survives", string(msg.Content))
+	case <-time.After(10 * time.Second):
+		t.Fatal("unsent message was not delivered after restart")
+	}
+	select {
+	case <-restarted.OutboxDrained():
+		assert.Equal(t, 0, restarted.OutboxCount())
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivered message did not leave the outbox")
+	}
+	assert.Equal(t, 0, restarted.OfflineMessageCount())
+}
@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineBacklogIsPulledInBatches(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	senderHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = senderHost.Close() })
+	receiverHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = receiverHost.Close() })
+
+	// Both managers exist before anything is queued; they share HOME
+	receiving := newTestMessageManager(t, receiverHost)
+	sending := newTestMessageManager(t, senderHost)
+
+	// More than one batch, but within the outgoing queue
+	const backlog = message.OfflineBatchSize + 30
+	handler := &captureHandler{messages: make(chan *message.Message, backlog)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+	announced := make(chan int, 1)
+	receiving.OnOfflineBacklog = func(peerID string, count int) { announced <- count }
+
+	// The receiver is offline while the messages are sent
+	to := receiverHost.ID().String()
+	for i := 0; i < backlog; i++ {
+		require.NoError(t, sending.SendMessage(to, []byte(fmt.Sprintf("queued %d", i)), message.MessageTypeText))
+	}
+	require.Eventually(t, func() bool { return sending.OfflineMessageCount() == backlog },
+		5*time.Second, 20*time.Millisecond)
+
+	// Coming online starts delivery without waiting for the next round
+	err = senderHost.Connect(context.Background(), peer.AddrInfo{ID: receiverHost.ID(), Addrs: receiverHost.Addrs()})
+	require.NoError(t, err)
+
+	select {
+	case count := <-announced:
+		assert.Equal(t, backlog, count)
+	case <-time.After(10 * time.Second):
+		t.Fatal("backlog was not announced")
+	}
+
+	for i := 0; i < backlog; i++ {
+		select {
+		case msg := <-handler.messages:
+			assert.Equal(t, fmt.Sprintf("queued %d", i), string(msg.Content), "messages arrive in order")
+		case <-time.After(10 * time.Second):
+			t.Fatalf("only %d of %d queued messages arrived", i, backlog)
+		}
+	}
+	require.Eventually(t, func() bool { return sending.OfflineMessageCount() == 0 },
+		5*time.Second, 20*time.Millisecond)
+}
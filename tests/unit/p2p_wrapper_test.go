@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -253,3 +254,34 @@ func TestP2PWrapper_NodeInfoConsistency(t *testing.T) {
 		t.Error("Running status should be consistent between calls")
 	}
 }
+
+func TestP2PWrapper_StreamLog(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	wrapper := p2p.NewP2PWrapper(context.Background(), true)
+
+	logChan := make(chan string, 10)
+	stop := wrapper.StreamLog(logChan)
+	if err := wrapper.SendMessage("test-peer", "Hello World"); err != nil {
+		t.Fatalf("Failed to send message in simulation mode: %v", err)
+	}
+
+	select {
+	case line := <-logChan:
+		if !strings.Contains(line, "Simulated message sent") {
+			t.Errorf("Expected the entry just logged, got: %s", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the log entry to be streamed")
+	}
+
+	// Nothing is streamed once stopped
+	stop()
+	if err := wrapper.SendMessage("test-peer", "Hello again"); err != nil {
+		t.Fatalf("Failed to send message in simulation mode: %v", err)
+	}
+	select {
+	case line := <-logChan:
+		t.Errorf("Expected no entry after stopping, got: %s", line)
+	default:
+	}
+}
@@ -0,0 +1,69 @@
+package unit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerCacheFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.PeerCacheFileName)
+
+	peers, err := p2p.LoadPeerCache(path)
+	require.NoError(t, err)
+	assert.Empty(t, peers)
+
+	ids := testPeerIDs(t, 3)
+	peers = map[string]*p2p.CachedPeer{
+		ids[0].String(): {PeerID: ids[0].String(), Addrs: []string{"/ip4/192.168.1.20/tcp/4001"}, Protocols: []string{"/xelvra/chat/1.0.0"}, LastSeen: time.Now()},
+		ids[1].String(): {PeerID: ids[1].String(), Addrs: []string{"/ip4/192.168.1.21/tcp/4001"}, LastSeen: time.Now().Add(-p2p.PeerCacheTTL - time.Hour)},
+		ids[2].String(): {PeerID: ids[2].String(), LastSeen: time.Now()},
+	}
+	require.NoError(t, p2p.SavePeerCache(path, peers))
+
+	loaded, err := p2p.LoadPeerCache(path)
+	require.NoError(t, err)
+	require.Len(t, loaded, 1, "peers not seen within the TTL or without addresses are dropped")
+	assert.Equal(t, []string{"/xelvra/chat/1.0.0"}, loaded[ids[0].String()].Protocols)
+}
+
+func TestPeerCacheRestoresAddresses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dataDir := t.TempDir()
+
+	newNode := func(dataDir string) *p2p.PeerChatNode {
+		config := p2p.DefaultNodeConfig()
+		config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		config.IdentityPath, config.DataDir = "", dataDir
+		config.EnableQUIC = false
+		node, err := p2p.NewPeerChatNode(context.Background(), config)
+		require.NoError(t, err)
+		return node
+	}
+
+	remote := newNode("")
+	defer remote.Stop()
+	info := peer.AddrInfo{ID: remote.GetHost().ID(), Addrs: remote.GetHost().Addrs()}
+
+	node := newNode(dataDir)
+	require.NoError(t, node.GetHost().Connect(context.Background(), info))
+	require.NoError(t, node.Stop())
+
+	peers, err := p2p.LoadPeerCache(filepath.Join(dataDir, p2p.PeerCacheFileName))
+	require.NoError(t, err)
+	require.Contains(t, peers, info.ID.String())
+	assert.Contains(t, peers[info.ID.String()].Addrs, info.Addrs[0].String(), "the dialed address is cached")
+	assert.NotEmpty(t, peers[info.ID.String()].Protocols, "protocols learned through identify are cached")
+
+	// The next run knows where to dial before discovery finds the peer
+	restarted := newNode(dataDir)
+	defer restarted.Stop()
+	assert.Contains(t, restarted.GetHost().Peerstore().Addrs(info.ID), info.Addrs[0])
+	require.NoError(t, restarted.GetHost().Connect(context.Background(), peer.AddrInfo{ID: info.ID}))
+}
@@ -0,0 +1,44 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	pinger, echoer := newConnectedHosts(t)
+	p2p.ServePing(echoer)
+
+	var replies []p2p.PingReply
+	stats, err := p2p.Ping(context.Background(), pinger, echoer.ID(), 3, 10*time.Millisecond, func(reply p2p.PingReply) {
+		replies = append(replies, reply)
+	})
+	require.NoError(t, err)
+	require.Len(t, replies, 3)
+	for i, reply := range replies {
+		assert.Equal(t, i+1, reply.Seq)
+		assert.NoError(t, reply.Err)
+		assert.Positive(t, reply.RTT)
+	}
+
+	assert.Equal(t, 3, stats.Sent)
+	assert.Equal(t, 3, stats.Received)
+	assert.Zero(t, stats.Loss())
+	assert.LessOrEqual(t, stats.Min, stats.Avg)
+	assert.LessOrEqual(t, stats.Avg, stats.Max)
+	assert.False(t, stats.Relayed(), "hosts on loopback are connected directly")
+	assert.NotEmpty(t, stats.Addr)
+	assert.True(t, stats.MeetsLatencyTarget())
+
+	// A peer that does not serve the protocol fails the ping
+	_, err = p2p.Ping(context.Background(), echoer, pinger.ID(), 1, time.Second, nil)
+	assert.Error(t, err)
+
+	_, err = p2p.Ping(context.Background(), pinger, echoer.ID(), 0, time.Second, nil)
+	assert.Error(t, err)
+}
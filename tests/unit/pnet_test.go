@@ -0,0 +1,93 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSwarmKey(t *testing.T) {
+	t.Setenv(p2p.SwarmKeyEnv, "")
+	dir := t.TempDir()
+
+	psk, err := p2p.ResolveSwarmKey("", dir)
+	require.NoError(t, err)
+	assert.Nil(t, psk, "without a key the node joins the public network")
+
+	data, err := p2p.GenerateSwarmKey()
+	require.NoError(t, err)
+	require.NoError(t, p2p.SaveSwarmKey(filepath.Join(dir, p2p.SwarmKeyFileName), data))
+	psk, err = p2p.ResolveSwarmKey("", dir)
+	require.NoError(t, err)
+	assert.Len(t, psk, 32)
+
+	// A configured path comes before the data directory, the environment
+	// before both
+	other, err := p2p.GenerateSwarmKey()
+	require.NoError(t, err)
+	otherPath := filepath.Join(t.TempDir(), "org.key")
+	require.NoError(t, p2p.SaveSwarmKey(otherPath, other))
+	configured, err := p2p.ResolveSwarmKey(otherPath, dir)
+	require.NoError(t, err)
+	assert.NotEqual(t, p2p.SwarmKeyFingerprint(psk), p2p.SwarmKeyFingerprint(configured))
+
+	t.Setenv(p2p.SwarmKeyEnv, string(data))
+	fromEnv, err := p2p.ResolveSwarmKey(otherPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, psk, fromEnv)
+
+	// A configured key that cannot be read is an error, not the public network
+	t.Setenv(p2p.SwarmKeyEnv, "")
+	_, err = p2p.ResolveSwarmKey(filepath.Join(dir, "missing.key"), dir)
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(otherPath, []byte("not a key"), 0600))
+	_, err = p2p.ResolveSwarmKey(otherPath, dir)
+	assert.Error(t, err)
+}
+
+// newPrivateNode starts a node in the private network of psk, or the
+// public one when psk is nil
+func newPrivateNode(t *testing.T, psk pnet.PSK) *p2p.PeerChatNode {
+	config := p2p.DefaultNodeConfig()
+	config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"}
+	config.IdentityPath, config.DataDir = "", ""
+	config.SwarmKey = psk
+	node, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = node.Stop() })
+	return node
+}
+
+func TestPrivateNetwork(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key := func() pnet.PSK {
+		data, err := p2p.GenerateSwarmKey()
+		require.NoError(t, err)
+		psk, err := p2p.ParseSwarmKey(data)
+		require.NoError(t, err)
+		return psk
+	}
+	psk := key()
+
+	member := newPrivateNode(t, psk)
+	for _, addr := range member.GetHost().Addrs() {
+		assert.NotContains(t, addr.String(), "/quic", "QUIC cannot use the swarm key")
+	}
+
+	dial := func(from *p2p.PeerChatNode) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		return from.Connect(ctx, peer.AddrInfo{ID: member.GetHost().ID(), Addrs: member.GetHost().Addrs()})
+	}
+	assert.NoError(t, dial(newPrivateNode(t, psk)), "nodes with the same key connect")
+	assert.Error(t, dial(newPrivateNode(t, key())), "a node with another key cannot connect")
+	assert.Error(t, dial(newPrivateNode(t, nil)), "a public node cannot connect")
+}
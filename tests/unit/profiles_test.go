@@ -0,0 +1,96 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileSettings(t *testing.T) {
+	alice, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	bob, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), user.ProfilesFileName)
+
+	settings, err := user.LoadProfileSettings(path)
+	require.NoError(t, err)
+	assert.Nil(t, settings.FacetFor(alice.GetPeerID().String()), "nothing is disclosed without facets")
+
+	require.NoError(t, settings.SetFacet(&user.ProfileFacet{Name: "personal", DisplayName: "Jane"}))
+	require.NoError(t, settings.SetFacet(&user.ProfileFacet{
+		Name:        "work",
+		DisplayName: "Jane Doe",
+		Fields:      map[string]string{"email": "jane@example.com"},
+	}))
+	assert.Equal(t, "personal", settings.Default, "the first facet is the default")
+	assert.Error(t, settings.SetFacet(&user.ProfileFacet{Name: "Bad Name"}))
+	assert.Error(t, settings.SetFacet(&user.ProfileFacet{Name: "x", Fields: map[string]string{"a b": "c"}}))
+
+	require.NoError(t, settings.Assign(alice.GetPeerID().String(), "work"))
+	assert.Error(t, settings.Assign(bob.GetPeerID().String(), "missing"))
+	assert.Error(t, settings.Assign("not-a-peer", "work"))
+
+	require.NoError(t, settings.Save(path))
+	loaded, err := user.LoadProfileSettings(path)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe", loaded.FacetFor(alice.GetPeerID().String()).DisplayName)
+	assert.Equal(t, "Jane", loaded.FacetFor(bob.GetPeerID().String()).DisplayName)
+
+	// Removing a facet returns its peers to the default
+	require.NoError(t, loaded.RemoveFacet("work"))
+	assert.Equal(t, "Jane", loaded.FacetFor(alice.GetPeerID().String()).DisplayName)
+	require.NoError(t, loaded.RemoveFacet("personal"))
+	assert.Empty(t, loaded.Default)
+	assert.Nil(t, loaded.FacetFor(bob.GetPeerID().String()))
+}
+
+func TestProfileExchangeDisclosesAssignedFacet(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	aliceHost, bobHost := newConnectedHosts(t)
+
+	avatar := filepath.Join(t.TempDir(), "avatar.png")
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	require.NoError(t, os.WriteFile(avatar, png, 0600))
+
+	// Both managers share HOME, so each peer is answered by the same settings
+	settings, err := user.LoadProfileSettings(filepath.Join(home, ".xelvra", user.ProfilesFileName))
+	require.NoError(t, err)
+	require.NoError(t, settings.SetFacet(&user.ProfileFacet{Name: "personal", DisplayName: "Jane"}))
+	require.NoError(t, settings.SetFacet(&user.ProfileFacet{
+		Name:        "work",
+		DisplayName: "Jane Doe",
+		Avatar:      avatar,
+		Fields:      map[string]string{"email": "jane@example.com"},
+	}))
+	require.NoError(t, settings.Assign(aliceHost.ID().String(), "work"))
+	require.NoError(t, settings.Save(filepath.Join(home, ".xelvra", user.ProfilesFileName)))
+
+	alice := newTestMessageManager(t, aliceHost)
+	bob := newTestMessageManager(t, bobHost)
+
+	profile, err := alice.FetchProfile(bobHost.ID())
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe", profile.DisplayName)
+	assert.Equal(t, "jane@example.com", profile.Fields["email"])
+	assert.Equal(t, png, profile.Avatar)
+	assert.Equal(t, "image/png", profile.AvatarType)
+
+	profile, err = bob.FetchProfile(aliceHost.ID())
+	require.NoError(t, err)
+	assert.Equal(t, "Jane", profile.DisplayName)
+	assert.Empty(t, profile.Fields, "the work fields are not disclosed")
+	assert.Empty(t, profile.Avatar)
+
+	// Without a default facet unassigned peers see nothing
+	require.NoError(t, settings.SetDefault(""))
+	require.NoError(t, settings.Save(filepath.Join(home, ".xelvra", user.ProfilesFileName)))
+	profile, err = bob.FetchProfile(aliceHost.ID())
+	require.NoError(t, err)
+	assert.True(t, profile.IsEmpty())
+}
@@ -0,0 +1,170 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("https_proxy", "http://lower:3128")
+	t.Setenv("ALL_PROXY", "")
+	t.Setenv("all_proxy", "")
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("http_proxy", "")
+	t.Setenv("NO_PROXY", "internal.example.com")
+	t.Setenv("no_proxy", "")
+
+	config := p2p.ProxyFromEnvironment()
+	assert.Equal(t, "http://lower:3128", config.HTTPSProxy)
+	assert.False(t, config.IsZero())
+
+	// ALL_PROXY takes precedence for raw TCP connections
+	t.Setenv("ALL_PROXY", "socks5://127.0.0.1:9050")
+	config = p2p.ProxyFromEnvironment()
+	proxyURL, err := config.ProxyFor("203.0.113.7:4001")
+	require.NoError(t, err)
+	require.NotNil(t, proxyURL)
+	assert.Equal(t, "socks5", proxyURL.Scheme)
+
+	// A SOCKS proxy is strict: only loopback is reached directly
+	assert.True(t, config.IsSOCKS())
+	assert.True(t, config.Strict)
+	for _, addr := range []string{"192.168.1.20:4001", "[fe80::1]:4001", "internal.example.com:4001"} {
+		proxyURL, err := config.ProxyFor(addr)
+		require.NoError(t, err)
+		assert.NotNil(t, proxyURL, addr)
+	}
+	proxyURL, err = config.ProxyFor("127.0.0.1:4001")
+	require.NoError(t, err)
+	assert.Nil(t, proxyURL)
+
+	// Otherwise LAN, loopback and NO_PROXY hosts are reached directly
+	config.Strict = false
+	for _, addr := range []string{"192.168.1.20:4001", "127.0.0.1:4001", "[fe80::1]:4001", "internal.example.com:4001"} {
+		proxyURL, err := config.ProxyFor(addr)
+		require.NoError(t, err)
+		assert.Nil(t, proxyURL, addr)
+	}
+
+	// HTTP proxies are not strict
+	t.Setenv("ALL_PROXY", "")
+	config = p2p.ProxyFromEnvironment()
+	assert.False(t, config.IsSOCKS())
+	assert.False(t, config.Strict)
+
+	assert.True(t, p2p.ProxyConfig{}.IsZero())
+}
+
+func TestProxyDialerHTTPConnect(t *testing.T) {
+	// Target greets as soon as a connection arrives, so the greeting may
+	// come with the proxy's response
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = conn.Write([]byte("hello"))
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// Minimal CONNECT proxy that requires credentials and tunnels every
+	// request to the target
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer proxyListener.Close()
+	requested := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := proxyListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				requested <- req.Host
+				if req.Header.Get("Proxy-Authorization") == "" {
+					_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				upstream, err := net.Dial("tcp", target.Addr().String())
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	// A public address, which goes through the proxy
+	const remote = "203.0.113.7:4001"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dialer := p2p.NewProxyDialer(p2p.ProxyConfig{HTTPSProxy: "http://user:secret@" + proxyListener.Addr().String()})
+	conn, err := dialer.DialContext(ctx, "tcp", remote)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, remote, <-requested)
+
+	greeting := make([]byte, 5)
+	_, err = io.ReadFull(conn, greeting)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(greeting))
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(conn, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(echo))
+
+	// Refusals are reported without leaking credentials
+	err = p2p.CheckProxy(ctx, p2p.ProxyConfig{HTTPSProxy: "http://" + proxyListener.Addr().String()}, remote)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "407")
+	<-requested
+}
+
+func TestDiscoveryWithoutLocalDiscovery(t *testing.T) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	dm := p2p.NewDiscoveryManager(h, logger)
+	dm.DisableLocalDiscovery()
+	require.NoError(t, dm.Start())
+	defer func() { _ = dm.Stop() }()
+
+	// Over a SOCKS proxy nothing is announced on the LAN
+	status := dm.GetStatus()
+	assert.False(t, status.MDNSActive)
+	assert.False(t, status.UDPBroadcast)
+}
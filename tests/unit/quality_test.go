@@ -0,0 +1,74 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQualityBookScoresPeers(t *testing.T) {
+	book := p2p.NewQualityBook()
+	good, flaky, relayed := peer.ID("good"), peer.ID("flaky"), peer.ID("relayed")
+	addr := ma.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1")
+	circuit := ma.StringCast("/ip4/93.184.216.34/tcp/4001/p2p/12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK/p2p-circuit")
+
+	unknown := book.Peer(peer.ID("unknown"))
+	assert.Zero(t, unknown.Dials)
+
+	for i := 0; i < 3; i++ {
+		book.RecordDial(good, addr, 20*time.Millisecond)
+		book.RecordConnection(good, addr)
+	}
+	book.RecordDial(flaky, addr, 20*time.Millisecond)
+	book.RecordFailure(flaky, addr)
+	book.RecordFailure(flaky, addr)
+	for i := 0; i < 3; i++ {
+		book.RecordDial(relayed, circuit, 20*time.Millisecond)
+		book.RecordConnection(relayed, circuit)
+	}
+
+	q := book.Peer(good)
+	assert.Equal(t, 3, q.Dials)
+	assert.Equal(t, 20*time.Millisecond, q.RTT)
+	assert.Greater(t, q.Score, unknown.Score)
+
+	f := book.Peer(flaky)
+	assert.InDelta(t, 2.0/3, f.FailureRate(), 0.001)
+	assert.Less(t, f.Score, q.Score)
+
+	r := book.Peer(relayed)
+	assert.Equal(t, 3, r.Relayed)
+	assert.Less(t, r.Score, q.Score, "relayed connections lower the score")
+}
+
+func TestQualityDialRankerPrefersBestPath(t *testing.T) {
+	book := p2p.NewQualityBook()
+	id := peer.ID("peer")
+	quic := ma.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1")
+	tcp := ma.StringCast("/ip4/93.184.216.34/tcp/4001")
+
+	// Without a record QUIC goes first as usual
+	ranked := book.DialRanker([]ma.Multiaddr{tcp, quic})
+	require.Len(t, ranked, 2)
+	assert.True(t, ranked[0].Addr.Equal(quic))
+
+	// QUIC keeps failing on this path while TCP works
+	book.RecordFailure(id, quic)
+	book.RecordFailure(id, quic)
+	book.RecordDial(id, tcp, 40*time.Millisecond)
+
+	ranked = book.DialRanker([]ma.Multiaddr{tcp, quic})
+	require.Len(t, ranked, 2)
+	assert.True(t, ranked[0].Addr.Equal(tcp))
+	assert.Zero(t, ranked[0].Delay)
+	assert.Greater(t, ranked[1].Delay, time.Duration(0))
+
+	q := book.Peer(id)
+	require.Len(t, q.Paths, 2)
+	assert.Equal(t, tcp.String(), q.Paths[0].Addr, "best path first")
+}
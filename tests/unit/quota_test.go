@@ -0,0 +1,84 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"2GB":       2 << 30,
+		"200MB":     200 << 20,
+		"1.5g":      3 << 29,
+		"512 KB":    512 << 10,
+		"4096":      4096,
+		"0":         0,
+		"unlimited": 0,
+	}
+	for input, expected := range cases {
+		size, err := message.ParseSize(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+
+	for _, input := range []string{"", "lots", "-1GB", "2XB"} {
+		_, err := message.ParseSize(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestQuotasDefaultAndPartialConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), message.QuotasFileName)
+
+	quotas, err := message.LoadQuotas(path)
+	require.NoError(t, err)
+	assert.Equal(t, message.DefaultQuotas(), quotas)
+
+	// Quotas missing from the file keep their defaults
+	require.NoError(t, os.WriteFile(path, []byte(`{"downloads_bytes": 0}`), 0600))
+	quotas, err = message.LoadQuotas(path)
+	require.NoError(t, err)
+	assert.Zero(t, quotas.Downloads)
+	assert.Equal(t, int64(message.DefaultOfflineQuota), quotas.Offline)
+}
+
+func TestDirUsageCountsHardLinksOnce(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), make([]byte, 1000), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b"), make([]byte, 500), 0600))
+	other := t.TempDir()
+	require.NoError(t, os.Link(filepath.Join(dir, "a"), filepath.Join(other, "a-link")))
+
+	assert.Equal(t, int64(1500), message.DirUsage(dir, other))
+}
+
+func TestFileRefusedOverDownloadsQuota(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	receiving := newTestMessageManager(t, receiverHost)
+	sending := newTestMessageManager(t, senderHost)
+	require.NoError(t, message.SaveQuotas(filepath.Join(home, ".xelvra", message.QuotasFileName),
+		message.Quotas{Downloads: 16 << 10}))
+	require.NoError(t, message.SaveFileAllowlist(filepath.Join(home, ".xelvra", message.FileAllowlistFileName),
+		[]string{senderHost.ID().String()}))
+
+	err := sending.SendFile(receiverHost.ID(), writeRandomFile(t, 8<<10))
+	require.NoError(t, err, "a file within the quota is received")
+
+	err = sending.SendFile(receiverHost.ID(), writeRandomFile(t, 12<<10))
+	pe, ok := message.AsProtocolError(err)
+	require.True(t, ok, "refusal carries a protocol error: %v", err)
+	assert.Equal(t, message.ErrCodeQuota, pe.Code)
+
+	usage := receiving.StorageUsage()
+	assert.GreaterOrEqual(t, usage.DownloadsBytes, int64(8<<10), "stored once despite the download link")
+	assert.Less(t, usage.DownloadsBytes, int64(16<<10))
+	assert.False(t, usage.DownloadsExceeded())
+}
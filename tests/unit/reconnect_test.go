@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnectDelayBackoff(t *testing.T) {
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		delay := p2p.ReconnectDelay(attempt, time.Second, 10*time.Second)
+		assert.InDelta(t, float64(want), float64(delay), 0.2*float64(want), "attempt %d", attempt)
+	}
+	// Very late attempts stay at the maximum
+	assert.LessOrEqual(t, p2p.ReconnectDelay(100, time.Second, 10*time.Second), 12*time.Second)
+}
+
+func TestReconnectorRedialsUntilConnected(t *testing.T) {
+	var dials atomic.Int32
+	dial := func(ctx context.Context, id peer.ID) error {
+		if dials.Add(1) < 3 {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	r := p2p.NewReconnector(dial, func(peer.ID) bool { return false }, logrus.New())
+	r.InitialDelay, r.MaxDelay = time.Millisecond, 5*time.Millisecond
+
+	reconnected := make(chan int, 1)
+	r.OnReconnected = func(_ peer.ID, attempts int) { reconnected <- attempts }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.PeerDropped(ctx, peer.ID("contact"))
+	r.PeerDropped(ctx, peer.ID("contact")) // Already being redialed
+
+	select {
+	case attempts := <-reconnected:
+		assert.Equal(t, 3, attempts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer was not reconnected")
+	}
+	assert.Equal(t, int32(3), dials.Load())
+	assert.Eventually(t, func() bool { return r.Pending() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestReconnectorStopsWhenPeerReturns(t *testing.T) {
+	var dials atomic.Int32
+	dial := func(ctx context.Context, id peer.ID) error {
+		dials.Add(1)
+		return errors.New("unreachable")
+	}
+	r := p2p.NewReconnector(dial, func(peer.ID) bool { return dials.Load() >= 2 }, logrus.New())
+	r.InitialDelay, r.MaxDelay = time.Millisecond, 5*time.Millisecond
+
+	r.PeerDropped(context.Background(), peer.ID("contact"))
+	assert.Eventually(t, func() bool { return r.Pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), dials.Load(), "no dial once the peer connected by itself")
+}
@@ -0,0 +1,38 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayCandidates(t *testing.T) {
+	relay, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	require.NoError(t, err)
+	defer relay.Close()
+	plain := newWakeTestHost(t)
+	client := newWakeTestHost(t)
+
+	ctx := context.Background()
+	require.NoError(t, client.Connect(ctx, peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}))
+	require.NoError(t, client.Connect(ctx, peer.AddrInfo{ID: plain.ID(), Addrs: plain.Addrs()}))
+
+	bootstrap := newWakeTestHost(t)
+	bootstrapInfo := peer.AddrInfo{ID: bootstrap.ID(), Addrs: bootstrap.Addrs()}
+
+	// The relay is offered once identify learned it relays; peers that do not
+	// relay are left out and bootstrap peers come last
+	require.Eventually(t, func() bool {
+		candidates := p2p.RelayCandidates(client, []peer.AddrInfo{bootstrapInfo}, 5)
+		return len(candidates) == 2 && candidates[0].ID == relay.ID() && candidates[1].ID == bootstrap.ID()
+	}, 5*time.Second, 20*time.Millisecond)
+
+	assert.Len(t, p2p.RelayCandidates(client, []peer.AddrInfo{bootstrapInfo}, 1), 1)
+	assert.Empty(t, p2p.RelayAddrs(client))
+}
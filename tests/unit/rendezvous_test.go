@@ -0,0 +1,69 @@
+package unit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRendezvousKeys(t *testing.T) {
+	assert.NoError(t, p2p.ValidateRendezvousKey("xelvra/v1"))
+	assert.Error(t, p2p.ValidateRendezvousKey(""))
+	assert.ErrorContains(t, p2p.ValidateRendezvousKey("book club"), "whitespace")
+	assert.Error(t, p2p.ValidateRendezvousKey(strings.Repeat("k", 257)))
+
+	namespace := p2p.RendezvousNamespace("xelvra/v1")
+	assert.Equal(t, namespace, p2p.RendezvousNamespace("xelvra/v1"))
+	assert.NotEqual(t, namespace, p2p.RendezvousNamespace("xelvra/v2"))
+	assert.NotContains(t, namespace, "xelvra/v1", "the key is not revealed to the DHT")
+	assert.NotEqual(t, p2p.PublicNamespace, namespace)
+}
+
+func TestDiscoveryManagerRendezvous(t *testing.T) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	dm := p2p.NewDiscoveryManager(h, logger)
+
+	require.NoError(t, dm.JoinRendezvous("xelvra/v1"))
+	require.NoError(t, dm.JoinRendezvous("xelvra/v1"), "joining twice is harmless")
+	require.NoError(t, dm.JoinRendezvous("group-42"))
+	assert.Equal(t, []string{"xelvra/v1", "group-42"}, dm.Rendezvous())
+	assert.Equal(t, []string{"xelvra/v1", "group-42"}, dm.GetStatus().Rendezvous)
+	assert.Error(t, dm.JoinRendezvous("has space"))
+
+	assert.True(t, dm.LeaveRendezvous("group-42"))
+	assert.False(t, dm.LeaveRendezvous("group-42"))
+	assert.Equal(t, []string{"xelvra/v1"}, dm.Rendezvous())
+
+	for i := len(dm.Rendezvous()); i < p2p.MaxRendezvousKeys; i++ {
+		require.NoError(t, dm.JoinRendezvous(fmt.Sprintf("group-%d", i)))
+	}
+	assert.Error(t, dm.JoinRendezvous("one-too-many"))
+
+	_, err = dm.FindRendezvous("xelvra/v1")
+	assert.ErrorContains(t, err, "DHT is not running")
+}
+
+func TestConfigFileRendezvous(t *testing.T) {
+	path := filepath.Join(t.TempDir(), p2p.ConfigFileName)
+	require.NoError(t, os.WriteFile(path, []byte("rendezvous:\n  - xelvra/v1\n"), 0600))
+	config, err := p2p.LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"xelvra/v1"}, config.Rendezvous)
+
+	require.NoError(t, os.WriteFile(path, []byte("rendezvous:\n  - \"book club\"\n"), 0600))
+	_, err = p2p.LoadConfigFile(path)
+	assert.ErrorContains(t, err, "rendezvous")
+}
@@ -0,0 +1,59 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceMonitorThroughput(t *testing.T) {
+	start := time.Now()
+	samples := []p2p.ResourceSample{
+		{Time: start, MessagesSent: 10, MessagesReceived: 4, BytesIn: 1000, BytesOut: 2000},
+		{Time: start.Add(2 * time.Second), MessagesSent: 20, MessagesReceived: 8, BytesIn: 3000, BytesOut: 2000},
+	}
+	next := 0
+	monitor := p2p.NewResourceMonitor(func() p2p.ResourceSample {
+		sample := samples[next]
+		next++
+		return sample
+	})
+
+	_, ok := monitor.Latest()
+	assert.False(t, ok, "no sample before the first one is taken")
+
+	first := monitor.Sample()
+	assert.Zero(t, first.Window, "the first sample has no throughput")
+	assert.Zero(t, first.SentPerSecond)
+
+	second := monitor.Sample()
+	assert.Equal(t, 2*time.Second, second.Window)
+	assert.InDelta(t, 5, second.SentPerSecond, 0.001)
+	assert.InDelta(t, 2, second.ReceivedPerSecond, 0.001)
+	assert.InDelta(t, 1000, second.BytesInPerSecond, 0.001)
+	assert.Zero(t, second.BytesOutPerSecond)
+
+	latest, ok := monitor.Latest()
+	require.True(t, ok)
+	assert.Equal(t, second, latest)
+}
+
+func TestResourceMonitorStartStop(t *testing.T) {
+	monitor := p2p.NewResourceMonitor(func() p2p.ResourceSample {
+		return p2p.ResourceSample{Time: time.Now(), Goroutines: 3}
+	})
+	monitor.Start(context.Background())
+	defer monitor.Stop()
+
+	// Starting takes a sample right away
+	sample, ok := monitor.Latest()
+	require.True(t, ok)
+	assert.Equal(t, 3, sample.Goroutines)
+
+	monitor.Stop()
+	monitor.Stop() // Stopping twice is harmless
+}
@@ -0,0 +1,33 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseScript(t *testing.T) {
+	script := `# Nightly report
+/switch alice
+
+build finished
+/msg bob see you at 5; bring snacks
+   # indented comment
+/peers
+`
+	assert.Equal(t, []string{
+		"/switch alice",
+		"build finished",
+		"/msg bob see you at 5; bring snacks",
+		"/peers",
+	}, cli.ParseScript(script))
+	assert.Empty(t, cli.ParseScript("\n# nothing\n  \n"))
+}
+
+func TestParseExec(t *testing.T) {
+	assert.Equal(t, []string{"/msg bob hi", "/list"}, cli.ParseExec("/msg bob hi; /list"))
+	assert.Equal(t, []string{"/switch alice", "build finished", "/history alice 5", "/peers"},
+		cli.ParseExec("/switch alice\nbuild finished; /history alice 5;;\n/peers"))
+	assert.Empty(t, cli.ParseExec("\n# nothing\n ; \n"))
+}
@@ -0,0 +1,186 @@
+package unit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/crypto"
+	"github.com/Xelvra/peerchat/internal/logging"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEd25519KeyConversion(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keyPair, err := crypto.KeyPairFromEd25519(priv)
+	require.NoError(t, err)
+	converted, err := crypto.PublicKeyFromEd25519(pub)
+	require.NoError(t, err)
+	assert.Equal(t, keyPair.PublicKey, converted)
+}
+
+func TestSessionOutOfOrder(t *testing.T) {
+	alice, err := crypto.NewSignalCrypto()
+	require.NoError(t, err)
+	bob, err := crypto.NewSignalCrypto()
+	require.NoError(t, err)
+
+	sending, err := alice.NewInitiatorSession(bob.GetIdentityKey())
+	require.NoError(t, err)
+
+	type sealed struct {
+		header     *crypto.SessionHeader
+		ciphertext []byte
+	}
+	var messages []sealed
+	for _, text := range []string{"zero", "one", "two"} {
+		header, ciphertext, err := sending.Seal([]byte(text))
+		require.NoError(t, err)
+		messages = append(messages, sealed{header, ciphertext})
+	}
+
+	// Bob sets up his side from whichever message comes first
+	receiving, err := bob.NewResponderSession(alice.GetIdentityKey(), messages[2].header.EphemeralKey)
+	require.NoError(t, err)
+	assert.Equal(t, sending.ID, receiving.ID)
+
+	for _, i := range []int{2, 0, 1} {
+		plaintext, err := receiving.Open(messages[i].header, messages[i].ciphertext)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"zero", "one", "two"}[i], string(plaintext))
+	}
+
+	// Each message opens once, and a tampered one not at all
+	_, err = receiving.Open(messages[0].header, messages[0].ciphertext)
+	assert.Error(t, err)
+	header, ciphertext, err := sending.Seal([]byte("three"))
+	require.NoError(t, err)
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = receiving.Open(header, ciphertext)
+	assert.Error(t, err)
+}
+
+func TestResetSession(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+
+	sending := newTestMessageManager(t, senderHost)
+	receiving := newTestMessageManager(t, receiverHost)
+	texts := &captureHandler{messages: make(chan *message.Message, 2)}
+	receiving.RegisterHandler(message.MessageTypeText, texts)
+	receiving.RegisterHandler(message.MessageTypeSystem, &captureHandler{messages: make(chan *message.Message, 1)})
+	notices := make(chan *message.Notice, 1)
+	receiving.OnNotice = func(from string, notice *message.Notice) { notices <- notice }
+
+	senderID, receiverID := senderHost.ID().String(), receiverHost.ID().String()
+	receive := func(text string) {
+		t.Helper()
+		require.NoError(t, sending.SendMessage(receiverID, []byte(text), message.MessageTypeText))
+		select {
+		case msg := <-texts.messages:
+			assert.Equal(t, text, string(msg.Content))
+			assert.False(t, msg.IsEncrypted)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message %q was not delivered", text)
+		}
+	}
+
+	// The first message starts a session both sides know
+	receive("before the reset")
+	oldSession, _ := sending.SessionIDs(receiverID)
+	require.NotEmpty(t, oldSession)
+	_, receivingSessions := receiving.SessionIDs(senderID)
+	assert.Equal(t, []string{oldSession}, receivingSessions)
+
+	newSession, err := sending.ResetSession(receiverID)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldSession, newSession)
+
+	select {
+	case notice := <-notices:
+		assert.Equal(t, message.NoticeSessionReset, notice.Code)
+		assert.Equal(t, newSession, notice.Params["session"])
+	case <-time.After(10 * time.Second):
+		t.Fatal("session reset notice was not received")
+	}
+	require.Eventually(t, func() bool {
+		_, ids := receiving.SessionIDs(senderID)
+		return len(ids) == 0
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// Messages after the reset are sealed in the new session
+	receive("after the reset")
+	_, receivingSessions = receiving.SessionIDs(senderID)
+	assert.Equal(t, []string{newSession}, receivingSessions)
+
+	// Both sides recorded the reset; the test nodes share one data directory
+	entries, err := sending.AuditLog().Entries()
+	require.NoError(t, err)
+	var events []string
+	for _, entry := range entries {
+		events = append(events, entry.Event)
+	}
+	assert.ElementsMatch(t, []string{logging.AuditSessionReset, logging.AuditSessionResetByPeer}, events)
+}
+
+func TestSendMessageToPeers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, firstHost := newConnectedHosts(t)
+	secondHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = secondHost.Close() })
+	require.NoError(t, senderHost.Connect(context.Background(), peer.AddrInfo{ID: secondHost.ID(), Addrs: secondHost.Addrs()}))
+
+	sending := newTestMessageManager(t, senderHost)
+	var recipients []string
+	var receivers []*message.MessageManager
+	var texts []*captureHandler
+	for _, receiverHost := range []host.Host{firstHost, secondHost} {
+		receiving := newTestMessageManager(t, receiverHost)
+		handler := &captureHandler{messages: make(chan *message.Message, 1)}
+		receiving.RegisterHandler(message.MessageTypeText, handler)
+		recipients = append(recipients, receiverHost.ID().String())
+		receivers = append(receivers, receiving)
+		texts = append(texts, handler)
+	}
+
+	require.NoError(t, sending.SendMessageToPeers(recipients, []byte("to the whole list"), message.MessageTypeText))
+	for i, handler := range texts {
+		select {
+		case msg := <-handler.messages:
+			assert.Equal(t, "to the whole list", string(msg.Content))
+			assert.False(t, msg.IsEncrypted)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("message was not delivered to %s", recipients[i])
+		}
+	}
+
+	// The key of the shared body travels in each recipient's session
+	senderID := senderHost.ID().String()
+	for i, receiving := range receivers {
+		sendingSession, _ := sending.SessionIDs(recipients[i])
+		require.NotEmpty(t, sendingSession)
+		_, receivingSessions := receiving.SessionIDs(senderID)
+		assert.Equal(t, []string{sendingSession}, receivingSessions)
+	}
+
+	// Invalid recipients are named, the others still get the message
+	err = sending.SendMessageToPeers([]string{"not-a-peer", recipients[0]}, []byte("again"), message.MessageTypeText)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not-a-peer")
+	select {
+	case msg := <-texts[0].messages:
+		assert.Equal(t, "again", string(msg.Content))
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not delivered to the valid recipient")
+	}
+}
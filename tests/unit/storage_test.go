@@ -0,0 +1,200 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/db"
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/user"
+	"github.com/libp2p/go-libp2p"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historyStores opens each built-in history store
+func historyStores(t *testing.T) map[string]db.HistoryStore {
+	sqlite, err := db.NewSQLiteDB(t.TempDir(), "store-test-key", logrus.New())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sqlite.Close() })
+
+	return map[string]db.HistoryStore{
+		db.SQLiteBackend: sqlite,
+		db.MemoryBackend: db.NewMemoryHistory(logrus.New()),
+	}
+}
+
+func TestHistoryStoresAgree(t *testing.T) {
+	for name, store := range historyStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			record := func(id, peerID, text string, age time.Duration) {
+				msg := &message.Message{
+					ID:        id,
+					Type:      message.MessageTypeText,
+					Content:   []byte(text),
+					Timestamp: now.Add(-age),
+				}
+				require.NoError(t, store.RecordMessage(msg, peerID, false))
+			}
+			record("alpha-message-1", "peer-a", "lunch at noon", 10*24*time.Hour)
+			record("alpha-message-2", "peer-a", "noon works", 2*time.Hour)
+			record("bravo-message-1", "peer-b", "see you at noon", time.Hour)
+			record("alpha-message-2", "peer-a", "recorded twice", 0)
+
+			entries, err := store.QueryHistory(db.HistoryQuery{PeerID: "peer-a"})
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			assert.Equal(t, "alpha-message-2", entries[0].ID)
+			assert.Equal(t, []byte("noon works"), entries[0].Content)
+
+			entries, err = store.QueryHistory(db.HistoryQuery{Ascending: true, Limit: 2, Offset: 1})
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			assert.Equal(t, "alpha-message-2", entries[0].ID)
+			assert.Equal(t, "bravo-message-1", entries[1].ID)
+
+			results, err := store.SearchHistory(db.SearchQuery{Text: "noon", PeerID: "peer-a"})
+			require.NoError(t, err)
+			assert.Len(t, results, 2)
+
+			_, err = store.StarMessage("alpha-", true)
+			assert.Error(t, err, "prefix matches two messages")
+			id, err := store.StarMessage("alpha-message-1", true)
+			require.NoError(t, err)
+			assert.Equal(t, "alpha-message-1", id)
+			starred, err := store.QueryHistory(db.HistoryQuery{Starred: true})
+			require.NoError(t, err)
+			require.Len(t, starred, 1)
+			assert.False(t, starred[0].StarredAt.IsZero())
+
+			// The starred message outlives the rule
+			policy := &db.RetentionPolicy{Default: db.RetentionRule{KeepMessages: 1}}
+			deleted, err := store.PruneHistory(policy, now)
+			require.NoError(t, err)
+			assert.Equal(t, 0, deleted)
+
+			_, err = store.StarMessage("alpha-message-1", false)
+			require.NoError(t, err)
+			deleted, err = store.PruneHistory(policy, now)
+			require.NoError(t, err)
+			assert.Equal(t, 1, deleted)
+
+			entries, err = store.QueryHistory(db.HistoryQuery{})
+			require.NoError(t, err)
+			assert.Len(t, entries, 2)
+		})
+	}
+}
+
+func TestMemoryOfflineStoreKeepsQueue(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	identity, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+
+	store := message.NewMemoryOfflineStore()
+	queued := &message.Message{ID: "queued-message-id", To: "peer-a", Content: []byte("hello"), Timestamp: time.Now()}
+	require.NoError(t, store.SaveOffline(map[string][]*message.OfflineMessage{
+		"peer-a": {{Message: queued, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}},
+	}))
+	assert.Positive(t, store.Size())
+
+	mm := message.NewMessageManager(h, identity, logger)
+	mm.SetOfflineStore(store)
+	assert.Equal(t, 1, mm.OfflineMessageCount())
+
+	// Changing what was loaded does not change the store
+	loaded, err := store.LoadOffline()
+	require.NoError(t, err)
+	loaded["peer-a"][0].Message.ID = "changed"
+	again, err := store.LoadOffline()
+	require.NoError(t, err)
+	assert.Equal(t, queued.ID, again["peer-a"][0].Message.ID)
+}
+
+func TestMemoryContactsReopen(t *testing.T) {
+	store := user.NewMemoryContacts()
+	contacts, err := user.OpenContactBook(store)
+	require.NoError(t, err)
+	_, err = contacts.Add("alice", "12D3KooWArmHNvLByCRC52Bpi1acahRULkFVeJ7yN7JX7gwpwTaf", "")
+	require.NoError(t, err)
+
+	reopened, err := user.OpenContactBook(store)
+	require.NoError(t, err)
+	contact, ok := reopened.Get("alice")
+	require.True(t, ok)
+	assert.Equal(t, "12D3KooWArmHNvLByCRC52Bpi1acahRULkFVeJ7yN7JX7gwpwTaf", contact.PeerID)
+}
+
+func TestStorageBackends(t *testing.T) {
+	logger := logrus.New()
+
+	stores, err := db.OpenBackend(db.MemoryBackend, "", nil, logger)
+	require.NoError(t, err)
+	assert.IsType(t, &db.MemoryHistory{}, stores.History)
+	assert.NotNil(t, stores.Offline)
+	assert.NotNil(t, stores.Contacts)
+
+	stores, err = db.OpenBackend("", "", nil, logger)
+	require.NoError(t, err)
+	assert.Nil(t, stores.History, "no data directory, no history")
+
+	_, err = db.OpenBackend("badger", "", nil, logger)
+	assert.ErrorContains(t, err, "unknown storage backend")
+
+	history := db.NewMemoryHistory(logger)
+	db.RegisterBackend("test-plugged", func(dataDir string, _ *user.MessengerID, _ *logrus.Logger) (*db.Stores, error) {
+		return &db.Stores{History: history}, nil
+	})
+	assert.Contains(t, db.Backends(), "test-plugged")
+	require.NoError(t, db.CheckBackend("test-plugged"))
+	stores, err = db.OpenBackend("test-plugged", "", nil, logger)
+	require.NoError(t, err)
+	assert.Same(t, history, stores.History)
+
+	assert.Panics(t, func() {
+		db.RegisterBackend(db.MemoryBackend, nil)
+	})
+}
+
+func TestHistoryStoresExpire(t *testing.T) {
+	for name, store := range historyStores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			record := func(id, peerID string, age time.Duration) {
+				msg := &message.Message{
+					ID:        id,
+					Type:      message.MessageTypeText,
+					Content:   []byte(id),
+					Timestamp: now.Add(-age),
+				}
+				require.NoError(t, store.RecordMessage(msg, peerID, false))
+			}
+			record("old-message", "peer-a", 2*time.Hour)
+			record("starred-message", "peer-a", 2*time.Hour)
+			record("new-message", "peer-a", time.Minute)
+			record("other-message", "peer-b", 2*time.Hour)
+			_, err := store.StarMessage("starred-message", true)
+			require.NoError(t, err)
+
+			deleted, err := store.ExpireHistory("peer-a", now.Add(-time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, 1, deleted)
+
+			entries, err := store.QueryHistory(db.HistoryQuery{Ascending: true})
+			require.NoError(t, err)
+			var ids []string
+			for _, entry := range entries {
+				ids = append(ids, entry.ID)
+			}
+			assert.ElementsMatch(t, []string{"starred-message", "new-message", "other-message"}, ids)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package unit
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScanSubnet(t *testing.T) {
+	subnet, err := p2p.ParseScanSubnet("192.168.50.7/24")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.50.0/24", subnet.String())
+
+	_, err = p2p.ParseScanSubnet("10.0.0.0/22")
+	assert.NoError(t, err)
+
+	for _, cidr := range []string{"10.0.0.0/21", "fe80::/64", "192.168.50.0"} {
+		_, err := p2p.ParseScanSubnet(cidr)
+		assert.Error(t, err, cidr)
+	}
+}
+
+func TestScanSubnet(t *testing.T) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		ma.StringCast("/ip4/192.168.50.20/tcp/4001"),
+	}
+	go func() {
+		buffer := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			probe := buffer[:n]
+			if !p2p.IsSubnetProbe(probe) {
+				continue
+			}
+			reply, err := p2p.NewSubnetProbeReply(probe, key, 1, addrs)
+			if err != nil || len(reply) > len(probe) {
+				continue
+			}
+			_, _ = conn.WriteToUDP(reply, from)
+		}
+	}()
+
+	subnet, err := p2p.ParseScanSubnet("127.0.0.1/32")
+	require.NoError(t, err)
+	found, err := p2p.ScanSubnet(context.Background(), subnet, port)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, id, found[0].ID)
+	assert.Equal(t, []ma.Multiaddr{addrs[0]}, found[0].Addrs, "the address that answered is preferred")
+
+	// A reply never outgrows the probe, whatever the addresses offered
+	var many []ma.Multiaddr
+	for i := 0; i < 40; i++ {
+		many = append(many, ma.StringCast("/ip4/192.168.50.20/tcp/4001/p2p/"+id.String()))
+	}
+	probe := p2p.NewSubnetProbe()
+	reply, err := p2p.NewSubnetProbeReply(probe, key, 2, many)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(reply), len(probe))
+
+	// Datagrams that are not probes, or too short to answer, get no reply
+	_, err = p2p.NewSubnetProbeReply([]byte(p2p.SubnetProbePrefix), key, 3, nil)
+	assert.Error(t, err)
+	_, err = p2p.NewSubnetProbeReply([]byte(strings.Repeat("x", 1000)), key, 3, nil)
+	assert.Error(t, err)
+
+	// Replies whose beacon does not verify are ignored
+	_, err = p2p.ParseSubnetProbeReply([]byte(p2p.SubnetProbeReplyPrefix+`{"beacon":{"peer_id":"`+id.String()+`"}}`), net.IPv4(127, 0, 0, 1), time.Now())
+	assert.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), message.TimeoutsFileName)
+
+	// Without a file the built-in timeouts apply
+	config, err := message.LoadTimeoutConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, message.DefaultTimeouts(), config.For("peer-a", 0))
+
+	config.Message = message.Duration(time.Minute)
+	config.Peers["peer-b"] = message.TimeoutOverride{File: message.Duration(time.Hour)}
+	require.NoError(t, message.SaveTimeoutConfig(path, config))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"message": "1m0s"`)
+
+	config, err = message.LoadTimeoutConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, message.Timeouts{Message: time.Minute, File: message.FileTimeout}, config.For("peer-a", 0))
+
+	// Slow links raise the timeouts but never lower them
+	slow := config.For("peer-a", 5*time.Second)
+	assert.Equal(t, 5*time.Second*message.MessageTimeoutRTTs, slow.Message)
+	assert.Equal(t, 5*time.Second*message.FileTimeoutRTTs, slow.File)
+	assert.Equal(t, time.Minute, config.For("peer-a", 10*time.Millisecond).Message)
+
+	// A peer's own timeouts are used as is
+	own := config.For("peer-b", 5*time.Second)
+	assert.Equal(t, message.Timeouts{Message: time.Minute, File: time.Hour}, own)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"message": 30}`), 0600))
+	_, err = message.LoadTimeoutConfig(path)
+	assert.Error(t, err, "durations need a unit")
+}
+
+func TestTimeoutsContext(t *testing.T) {
+	assert.Equal(t, message.DefaultTimeouts(), message.TimeoutsFrom(context.Background()))
+
+	custom := message.Timeouts{Message: 2 * time.Minute, File: 20 * time.Minute}
+	ctx := message.WithTimeouts(context.Background(), custom)
+	assert.Equal(t, custom, message.TimeoutsFrom(ctx))
+}
@@ -0,0 +1,200 @@
+package unit
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServiceID is a well-formed onion service ID
+var testServiceID = strings.Repeat("a", 56)
+
+// fakeTorControl answers the control commands publishing an onion service
+// and sends every command it got on commands
+func fakeTorControl(t *testing.T, cookieFile string) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	commands := make(chan string, 16)
+	go func() {
+		for {
+			raw, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn := textproto.NewConn(raw)
+				defer conn.Close()
+				for {
+					line, err := conn.ReadLine()
+					if err != nil {
+						return
+					}
+					commands <- line
+					switch word, _, _ := strings.Cut(line, " "); word {
+					case "PROTOCOLINFO":
+						_ = conn.PrintfLine("250-PROTOCOLINFO 1")
+						_ = conn.PrintfLine(`250-AUTH METHODS=COOKIE,SAFECOOKIE COOKIEFILE="%s"`, cookieFile)
+						_ = conn.PrintfLine(`250-VERSION Tor="0.4.8.9"`)
+						_ = conn.PrintfLine("250 OK")
+					case "ADD_ONION":
+						_ = conn.PrintfLine("250-ServiceID=%s", testServiceID)
+						if strings.Contains(line, "NEW:") {
+							_ = conn.PrintfLine("250-PrivateKey=ED25519-V3:c2VjcmV0==")
+						}
+						_ = conn.PrintfLine("250 OK")
+					default:
+						_ = conn.PrintfLine("250 OK")
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), commands
+}
+
+func TestOnionDialTarget(t *testing.T) {
+	addr, err := p2p.OnionAddr(testServiceID, p2p.OnionPort)
+	require.NoError(t, err)
+	target, err := p2p.OnionDialTarget(addr)
+	require.NoError(t, err)
+	assert.Equal(t, testServiceID+".onion:4001", target)
+
+	_, err = p2p.OnionDialTarget(ma.StringCast("/ip4/127.0.0.1/tcp/4001"))
+	assert.Error(t, err)
+
+	config := p2p.TorProxyConfig("")
+	assert.True(t, config.IsSOCKS())
+	assert.True(t, config.Strict)
+	assert.Equal(t, "socks5h://"+p2p.DefaultTorSOCKS, config.AllProxy)
+}
+
+func TestPublishOnionService(t *testing.T) {
+	dir := t.TempDir()
+	cookieFile := filepath.Join(dir, "control.authcookie")
+	require.NoError(t, os.WriteFile(cookieFile, []byte{0xde, 0xad, 0xbe, 0xef}, 0600))
+	control, commands := fakeTorControl(t, cookieFile)
+	keyPath := filepath.Join(dir, p2p.OnionKeyFileName)
+
+	service, err := p2p.PublishOnionService(control, "127.0.0.1:40123", keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, testServiceID, service.ServiceID)
+	assert.Equal(t, fmt.Sprintf("/onion3/%s:%d", testServiceID, p2p.OnionPort), service.Addr.String())
+	assert.Equal(t, "PROTOCOLINFO 1", <-commands)
+	assert.Equal(t, "AUTHENTICATE "+hex.EncodeToString([]byte{0xde, 0xad, 0xbe, 0xef}), <-commands)
+	assert.Equal(t, "ADD_ONION NEW:ED25519-V3 Port=4001,127.0.0.1:40123", <-commands)
+	require.NoError(t, service.Close())
+	assert.Equal(t, "DEL_ONION "+testServiceID, <-commands)
+
+	// The key is kept, so the address survives restarts
+	key, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, "ED25519-V3:c2VjcmV0==\n", string(key))
+
+	service, err = p2p.PublishOnionService(control, "127.0.0.1:40124", keyPath)
+	require.NoError(t, err)
+	defer service.Close()
+	<-commands
+	<-commands
+	assert.Equal(t, "ADD_ONION ED25519-V3:c2VjcmV0== Flags=DiscardPK Port=4001,127.0.0.1:40124", <-commands)
+}
+
+// fakeSOCKS5 is a SOCKS5 proxy that connects every request to target and
+// sends the requested host:port on requested
+func fakeSOCKS5(t *testing.T, target string) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	requested := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Greeting: version, methods; answer with no authentication
+				header := make([]byte, 2)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte{5, 0})
+
+				// CONNECT to a domain name
+				request := make([]byte, 5)
+				if _, err := io.ReadFull(conn, request); err != nil || request[3] != 3 {
+					return
+				}
+				host := make([]byte, request[4]+2)
+				if _, err := io.ReadFull(conn, host); err != nil {
+					return
+				}
+				port := binary.BigEndian.Uint16(host[len(host)-2:])
+				requested <- fmt.Sprintf("%s:%d", host[:len(host)-2], port)
+
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return listener.Addr().String(), requested
+}
+
+func TestOnionTransportDialsThroughTor(t *testing.T) {
+	service, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer service.Close()
+
+	_, target, err := manet.DialArgs(service.Addrs()[0])
+	require.NoError(t, err)
+	socks, requested := fakeSOCKS5(t, target)
+
+	client, err := libp2p.New(
+		libp2p.NoListenAddrs,
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(p2p.NewOnionTransport, p2p.WithTorSOCKS(socks)),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	onion, err := p2p.OnionAddr(testServiceID, p2p.OnionPort)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, client.Connect(ctx, peer.AddrInfo{ID: service.ID(), Addrs: []ma.Multiaddr{onion}}))
+
+	assert.Equal(t, testServiceID+".onion:4001", <-requested)
+	conns := client.Network().ConnsToPeer(service.ID())
+	require.NotEmpty(t, conns)
+	assert.Equal(t, onion.String(), conns[0].RemoteMultiaddr().String())
+}
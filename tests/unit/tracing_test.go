@@ -0,0 +1,214 @@
+package unit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/Xelvra/peerchat/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryExporter keeps exported spans
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (e *memoryExporter) Export(spans []*tracing.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Close() error { return nil }
+
+func (e *memoryExporter) byName() map[string]*tracing.Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make(map[string]*tracing.Span)
+	for _, span := range e.spans {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+func TestTraceParent(t *testing.T) {
+	tracer := tracing.New(&memoryExporter{}, nil)
+	defer func() { _ = tracer.Close() }()
+
+	span := tracer.Start(nil, "root")
+	sc, ok := span.SpanContext()
+	require.True(t, ok)
+	parsed, ok := tracing.ParseTraceParent(sc.TraceParent())
+	require.True(t, ok)
+	assert.Equal(t, sc, parsed)
+
+	for _, bad := range []string{"", "00-abc-def-01", "01-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01",
+		"00-00000000000000000000000000000000-" + sc.SpanID.String() + "-01"} {
+		_, ok := tracing.ParseTraceParent(bad)
+		assert.False(t, ok, bad)
+	}
+
+	// Without a tracer nothing is recorded and nothing breaks
+	var off *tracing.Tracer
+	child := off.Start(nil, "root").Child("child")
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("failed"))
+	child.Finish()
+	_, ok = child.SpanContext()
+	assert.False(t, ok)
+}
+
+func TestMessageSendTrace(t *testing.T) {
+	senderHost, receiverHost := newConnectedHosts(t)
+	sending := newTestMessageManager(t, senderHost)
+	sent := &memoryExporter{}
+	sendTracer := tracing.New(sent, nil)
+	sending.SetTracer(sendTracer)
+
+	receiving := newTestMessageManager(t, receiverHost)
+	received := &memoryExporter{}
+	receiveTracer := tracing.New(received, nil)
+	receiving.SetTracer(receiveTracer)
+	handler := &captureHandler{messages: make(chan *message.Message, 1)}
+	receiving.RegisterHandler(message.MessageTypeText, handler)
+
+	require.NoError(t, sending.SendMessage(receiverHost.ID().String(), []byte("hello"), message.MessageTypeText))
+	select {
+	case <-handler.messages:
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not delivered")
+	}
+
+	require.Eventually(t, func() bool {
+		_, ok := sent.byName()[message.SpanSend]
+		return ok
+	}, 10*time.Second, 50*time.Millisecond)
+	require.NoError(t, sendTracer.Close())
+	require.NoError(t, receiveTracer.Close())
+
+	spans := sent.byName()
+	root := spans[message.SpanSend]
+	assert.True(t, root.Parent.IsZero())
+	assert.Equal(t, "delivered", root.Attributes()["message.outcome"])
+	assert.Empty(t, root.Err())
+
+	// Every hop is a child of the send, inside it and in order
+	var previous time.Time
+	for _, name := range []string{message.SpanSign, message.SpanQueue, message.SpanDial, message.SpanWrite, message.SpanAck} {
+		span, ok := spans[name]
+		require.True(t, ok, name)
+		assert.Equal(t, root.Context.TraceID, span.Context.TraceID, name)
+		assert.Equal(t, root.Context.SpanID, span.Parent, name)
+		assert.False(t, span.Start.Before(previous), name)
+		assert.False(t, span.End.After(root.End), name)
+		previous = span.Start
+	}
+
+	// The receiver's span joins the sender's trace
+	receive, ok := received.byName()[message.SpanReceive]
+	require.True(t, ok)
+	assert.Equal(t, root.Context.TraceID, receive.Context.TraceID)
+	assert.Equal(t, root.Context.SpanID, receive.Parent)
+	assert.True(t, receive.Remote)
+	assert.Equal(t, root.Attributes()["message.id"], receive.Attributes()["message.id"])
+}
+
+func TestTraceFileSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), tracing.TraceFileName)
+	exporter, err := tracing.NewFileExporter(path)
+	require.NoError(t, err)
+	tracer := tracing.New(exporter, nil)
+
+	for i := 0; i < 3; i++ {
+		root := tracer.Start(nil, "send")
+		dial := root.Child("dial")
+		time.Sleep(time.Millisecond)
+		dial.Finish()
+		ack := root.Child("ack")
+		if i == 0 {
+			ack.SetError(errors.New("no receipt"))
+		}
+		ack.Finish()
+		root.Finish()
+	}
+	require.NoError(t, tracer.Close())
+
+	records, err := tracing.ReadTraceFile(path)
+	require.NoError(t, err)
+	require.Len(t, records, 9)
+
+	summary := tracing.Summarize(records)
+	require.Len(t, summary, 3)
+	assert.Equal(t, []string{"send", "dial", "ack"}, []string{summary[0].Name, summary[1].Name, summary[2].Name})
+	assert.Equal(t, 3, summary[1].Count)
+	assert.GreaterOrEqual(t, summary[1].P50, time.Millisecond)
+	assert.GreaterOrEqual(t, summary[0].Max, summary[1].Max)
+	assert.Equal(t, 1, summary[2].Errors)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	exporter, err := tracing.NewExporter(server.URL)
+	require.NoError(t, err)
+	tracer := tracing.New(exporter, nil)
+	root := tracer.Start(nil, "send")
+	root.SetAttribute("message.size", 42)
+	child := root.Child("ack")
+	child.SetError(errors.New("no receipt"))
+	child.Finish()
+	root.Finish()
+	require.NoError(t, tracer.Close())
+
+	var request struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Attributes   []struct {
+						Key   string                 `json:"key"`
+						Value map[string]interface{} `json:"value"`
+					} `json:"attributes"`
+					Status *struct {
+						Code int `json:"code"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	require.NoError(t, json.Unmarshal(<-bodies, &request))
+	require.Len(t, request.ResourceSpans, 1)
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	ack, send := spans[0], spans[1]
+	assert.Equal(t, "ack", ack.Name)
+	assert.Equal(t, send.TraceID, ack.TraceID)
+	assert.Equal(t, send.SpanID, ack.ParentSpanID)
+	assert.Len(t, send.TraceID, 32)
+	require.NotNil(t, ack.Status)
+	assert.Equal(t, 2, ack.Status.Code)
+	require.Len(t, send.Attributes, 1)
+	assert.Equal(t, "42", send.Attributes[0].Value["intValue"])
+}
@@ -0,0 +1,144 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// controlledTransfer is a transfer whose receiver holds the offer until
+// accept is closed, so the test can act on it before any data flows
+type controlledTransfer struct {
+	sending, receiving *message.FileTransferManager
+	downloads          string
+	accept             chan struct{}
+	sent, received     chan error
+	id                 string
+}
+
+func startControlledTransfer(t *testing.T, sender, receiver host.Host) *controlledTransfer {
+	ct := &controlledTransfer{
+		sending:   newFileTestManager(),
+		receiving: newFileTestManager(),
+		downloads: t.TempDir(),
+		accept:    make(chan struct{}),
+		sent:      make(chan error, 1),
+		received:  make(chan error, 1),
+	}
+	offered := make(chan string, 1)
+	ct.receiving.Approve = func(ctx context.Context, offer *message.FileOffer) *message.ProtocolError {
+		offered <- offer.TransferID
+		<-ct.accept
+		return nil
+	}
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		ct.received <- ct.receiving.ReceiveFile(context.Background(), s, s.Conn().RemotePeer(), ct.downloads)
+		_ = s.Close()
+	})
+
+	path := writeRandomFile(t, 64*message.FileChunkSize)
+	go func() {
+		ct.sent <- ct.sending.SendFile(context.Background(), opener(sender, receiver.ID()), path, receiver.ID())
+	}()
+
+	select {
+	case ct.id = <-offered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("file was not offered")
+	}
+	return ct
+}
+
+// waitFor polls until cond holds
+func waitFor(t *testing.T, cond func() bool) {
+	require.Eventually(t, cond, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestTransferPauseAndResume(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	ct := startControlledTransfer(t, sender, receiver)
+
+	_, err := ct.sending.ControlTransfer(ct.id, message.TransferPause)
+	require.NoError(t, err)
+	_, err = ct.sending.ControlTransfer(ct.id, message.TransferPause)
+	assert.Error(t, err, "already paused")
+	close(ct.accept)
+
+	// The receiver learns of the pause and nothing is sent, past the stall timeout
+	waitFor(t, func() bool {
+		incoming, ok := ct.receiving.GetTransfer(ct.id)
+		return ok && incoming.Status == message.FileTransferPaused
+	})
+	time.Sleep(2 * ct.sending.StallTimeout)
+	outgoing, _ := ct.sending.GetTransfer(ct.id)
+	incoming, _ := ct.receiving.GetTransfer(ct.id)
+	assert.Equal(t, message.FileTransferPaused, outgoing.Status)
+	assert.Zero(t, outgoing.BytesSent)
+	assert.True(t, incoming.PausedRemote)
+
+	_, err = ct.receiving.ControlTransfer(ct.id, message.TransferResume)
+	assert.Error(t, err, "only the side that paused can resume")
+
+	_, err = ct.sending.ControlTransfer(ct.id, message.TransferResume)
+	require.NoError(t, err)
+	require.NoError(t, <-ct.sent)
+	require.NoError(t, <-ct.received)
+	assert.FileExists(t, filepath.Join(ct.downloads, "payload.bin"))
+}
+
+func TestTransferCancelledByReceiver(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	ct := startControlledTransfer(t, sender, receiver)
+
+	_, err := ct.sending.ControlTransfer(ct.id, message.TransferPause)
+	require.NoError(t, err)
+	close(ct.accept)
+	waitFor(t, func() bool {
+		incoming, ok := ct.receiving.GetTransfer(ct.id)
+		return ok && incoming.PausedRemote
+	})
+
+	_, err = ct.receiving.ControlTransfer(ct.id, message.TransferCancel)
+	require.NoError(t, err)
+
+	err = <-ct.sent
+	assert.True(t, errors.Is(err, message.ErrTransferCancelled), err)
+	assert.True(t, errors.Is(<-ct.received, message.ErrTransferCancelled))
+
+	outgoing, _ := ct.sending.GetTransfer(ct.id)
+	assert.Equal(t, message.FileTransferCancelled, outgoing.Status)
+	_, err = ct.sending.ControlTransfer(ct.id, message.TransferResume)
+	assert.Error(t, err, "a cancelled transfer cannot be controlled")
+
+	parts, _ := filepath.Glob(filepath.Join(ct.downloads, "*.part"))
+	assert.Empty(t, parts, "the partial file is removed")
+}
+
+func TestTransferCancelledBySender(t *testing.T) {
+	sender, receiver := newConnectedHosts(t)
+	ct := startControlledTransfer(t, sender, receiver)
+
+	_, err := ct.sending.ControlTransfer(ct.id, message.TransferCancel)
+	require.NoError(t, err)
+	close(ct.accept)
+
+	assert.True(t, errors.Is(<-ct.sent, message.ErrTransferCancelled))
+	assert.True(t, errors.Is(<-ct.received, message.ErrTransferCancelled))
+
+	incoming, ok := ct.receiving.GetTransfer(ct.id)
+	require.True(t, ok)
+	assert.Equal(t, message.FileTransferCancelled, incoming.Status)
+	_, err = os.Stat(filepath.Join(ct.downloads, "payload.bin"))
+	assert.True(t, os.IsNotExist(err))
+	parts, _ := filepath.Glob(filepath.Join(ct.downloads, "*.part"))
+	assert.Empty(t, parts)
+}
@@ -0,0 +1,108 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferQueueFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), message.TransferQueueFileName)
+	queue, err := message.LoadTransferQueue(path)
+	require.NoError(t, err)
+	assert.Empty(t, queue)
+
+	_, err = message.NewQueuedTransfer("not-a-peer", writeRandomFile(t, 10))
+	assert.Error(t, err)
+	_, err = message.NewQueuedTransfer("12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN", t.TempDir())
+	assert.Error(t, err, "directories are sent with sync-dir")
+
+	q, err := message.NewQueuedTransfer("12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN", writeRandomFile(t, 100))
+	require.NoError(t, err)
+	assert.True(t, filepath.IsAbs(q.Path))
+	assert.Equal(t, int64(100), q.Metadata.Size)
+	require.NoError(t, message.EnqueueTransfer(path, q))
+
+	queue, err = message.LoadTransferQueue(path)
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, q.ID(), queue[0].ID())
+
+	removed, err := message.RemoveQueuedTransfer(path, "file_0")
+	require.NoError(t, err)
+	assert.False(t, removed)
+	removed, err = message.RemoveQueuedTransfer(path, q.ID())
+	require.NoError(t, err)
+	assert.True(t, removed)
+	queue, err = message.LoadTransferQueue(path)
+	require.NoError(t, err)
+	assert.Empty(t, queue)
+}
+
+func TestTransferQueueSendsOnConnect(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	ctx := context.Background()
+
+	sender, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sender.Close() })
+	receiver, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = receiver.Close() })
+
+	downloads := t.TempDir()
+	receiving := newFileTestManager()
+	receiver.SetStreamHandler(message.FileProtocolID, func(s network.Stream) {
+		_ = receiving.ReceiveFile(ctx, s, s.Conn().RemotePeer(), downloads)
+		_ = s.Close()
+	})
+
+	// Queued from the shell while the node was not running
+	path := writeRandomFile(t, 2*message.FileChunkSize+5)
+	queuePath := filepath.Join(home, ".xelvra", message.TransferQueueFileName)
+	q, err := message.NewQueuedTransfer(receiver.ID().String(), path)
+	require.NoError(t, err)
+	require.NoError(t, message.EnqueueTransfer(queuePath, q))
+
+	mm := newTestMessageManager(t, sender)
+	done := make(chan error, 1)
+	mm.OnQueuedTransferDone = func(_ *message.QueuedTransfer, err error) { done <- err }
+
+	// Nothing happens while the peer is offline
+	time.Sleep(200 * time.Millisecond)
+	require.Len(t, mm.QueuedTransfers(), 1)
+	select {
+	case err := <-done:
+		t.Fatalf("transfer ended without the peer: %v", err)
+	default:
+	}
+
+	require.NoError(t, sender.Connect(ctx, peer.AddrInfo{ID: receiver.ID(), Addrs: receiver.Addrs()}))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(20 * time.Second):
+		t.Fatal("queued file was not sent after the peer connected")
+	}
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err)
+	received, err := os.ReadFile(filepath.Join(downloads, filepath.Base(path)))
+	require.NoError(t, err)
+	assert.Equal(t, expected, received)
+	assert.Empty(t, mm.QueuedTransfers())
+
+	transfers := mm.ListTransfers()
+	require.Len(t, transfers, 1)
+	assert.Equal(t, q.ID(), transfers[0].ID, "the queued ID identifies the transfer")
+}
@@ -0,0 +1,68 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/Xelvra/peerchat/internal/user"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportPolicyAllows(t *testing.T) {
+	lan := ma.StringCast("/ip4/192.168.1.10/tcp/4001")
+	public := ma.StringCast("/ip4/8.8.8.8/udp/4001/quic-v1")
+	relayed := ma.StringCast("/ip4/192.168.1.1/tcp/4001/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN/p2p-circuit")
+	onion := ma.StringCast("/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:1234")
+
+	tests := []struct {
+		policy  p2p.TransportPolicy
+		allowed []ma.Multiaddr
+		blocked []ma.Multiaddr
+	}{
+		{p2p.TransportAny, []ma.Multiaddr{lan, public, relayed, onion}, nil},
+		{p2p.TransportLAN, []ma.Multiaddr{lan}, []ma.Multiaddr{public, relayed, onion}},
+		{p2p.TransportNoRelay, []ma.Multiaddr{lan, public, onion}, []ma.Multiaddr{relayed}},
+		{p2p.TransportOnion, []ma.Multiaddr{onion}, []ma.Multiaddr{lan, public, relayed}},
+	}
+
+	for _, tt := range tests {
+		for _, addr := range tt.allowed {
+			assert.True(t, tt.policy.Allows(addr), "%s should allow %s", tt.policy, addr)
+		}
+		for _, addr := range tt.blocked {
+			assert.False(t, tt.policy.Allows(addr), "%s should block %s", tt.policy, addr)
+		}
+	}
+}
+
+func TestTransportGater(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	id, err := user.GenerateMessengerID()
+	require.NoError(t, err)
+	peerID := id.GetPeerID()
+
+	gater := p2p.NewTransportGater(logrus.New())
+	public := ma.StringCast("/ip4/8.8.8.8/tcp/4001")
+	assert.True(t, gater.InterceptAddrDial(peerID, public))
+
+	// Pins apply once the gater reloads them
+	require.NoError(t, p2p.SetTransportPin(peerID.String(), p2p.TransportLAN))
+	assert.Equal(t, p2p.TransportAny, gater.PolicyFor(peerID))
+	gater.Reload()
+	assert.Equal(t, p2p.TransportLAN, gater.PolicyFor(peerID))
+	assert.False(t, gater.InterceptAddrDial(peerID, public))
+	assert.True(t, gater.InterceptAddrDial(peerID, ma.StringCast("/ip4/10.0.0.5/tcp/4001")))
+
+	pins, err := p2p.ListTransportPins()
+	require.NoError(t, err)
+	assert.Len(t, pins, 1)
+
+	require.NoError(t, p2p.SetTransportPin(peerID.String(), p2p.TransportAny))
+	pins, err = p2p.ListTransportPins()
+	require.NoError(t, err)
+	assert.Empty(t, pins)
+}
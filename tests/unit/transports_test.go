@@ -0,0 +1,119 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2pwebrtc "github.com/libp2p/go-libp2p/p2p/transport/webrtc"
+	libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICDisabledByEnvironment(t *testing.T) {
+	for value, disabled := range map[string]bool{
+		"": false, "0": false, "false": false, "no": false,
+		"1": true, "true": true, "yes": true, " YES ": true,
+	} {
+		t.Setenv(p2p.DisableQUICEnv, value)
+		assert.Equal(t, disabled, p2p.QUICDisabledByEnvironment(), "value %q", value)
+	}
+}
+
+func TestQUICFirstDialRanker(t *testing.T) {
+	quic := ma.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1")
+	tcp := ma.StringCast("/ip4/93.184.216.34/tcp/4001")
+
+	delays := make(map[string]time.Duration)
+	for _, a := range p2p.QUICFirstDialRanker([]ma.Multiaddr{tcp, quic}) {
+		delays[a.Addr.String()] = a.Delay
+	}
+	require.Len(t, delays, 2)
+	assert.Zero(t, delays[quic.String()])
+	assert.GreaterOrEqual(t, delays[tcp.String()], 500*time.Millisecond)
+
+	// Without QUIC addresses TCP is dialed right away
+	ranked := p2p.QUICFirstDialRanker([]ma.Multiaddr{tcp})
+	require.Len(t, ranked, 1)
+	assert.Zero(t, ranked[0].Delay)
+}
+
+func TestDialRankerPrefersIPv6(t *testing.T) {
+	quic4 := ma.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1")
+	quic6 := ma.StringCast("/ip6/2606:2800:220:1::34/udp/4001/quic-v1")
+	tcp4 := ma.StringCast("/ip4/93.184.216.34/tcp/4001")
+	tcp6 := ma.StringCast("/ip6/2606:2800:220:1::34/tcp/4001")
+
+	delays := make(map[string]time.Duration)
+	for _, a := range p2p.NewQualityBook().DialRanker([]ma.Multiaddr{tcp4, quic4, tcp6, quic6}) {
+		delays[a.Addr.String()] = a.Delay
+	}
+	require.Len(t, delays, 4)
+	assert.Zero(t, delays[quic6.String()], "a public IPv6 address is a direct path")
+	assert.Greater(t, delays[quic4.String()], delays[quic6.String()])
+	assert.Greater(t, delays[tcp6.String()], delays[quic4.String()], "QUIC over either family before TCP")
+	assert.GreaterOrEqual(t, delays[tcp4.String()], delays[tcp6.String()])
+}
+
+func TestIsBrowserAddr(t *testing.T) {
+	assert.True(t, p2p.IsBrowserAddr(ma.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1/webtransport")))
+	assert.True(t, p2p.IsBrowserAddr(ma.StringCast("/ip4/93.184.216.34/udp/4001/webrtc-direct")))
+	assert.False(t, p2p.IsBrowserAddr(ma.StringCast("/ip4/93.184.216.34/udp/4001/quic-v1")))
+	assert.False(t, p2p.IsBrowserAddr(ma.StringCast("/ip4/93.184.216.34/tcp/4001")))
+}
+
+func TestBrowserTransports(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	config := p2p.DefaultNodeConfig()
+	config.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"}
+	config.IdentityPath, config.DataDir = "", ""
+	config.BrowserTransports = true
+
+	node, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	defer node.Stop()
+
+	// A client that speaks WebTransport or WebRTC only, as a browser does;
+	// the same listeners run over IPv6 where the host has it
+	var browserAddrs []ma.Multiaddr
+	for _, addr := range node.GetHost().Addrs() {
+		if _, err := addr.ValueForProtocol(ma.P_IP4); err != nil {
+			continue
+		}
+		if p2p.IsBrowserAddr(addr) && manet.IsIPLoopback(addr) {
+			_, err := addr.ValueForProtocol(ma.P_CERTHASH)
+			assert.NoError(t, err, "browsers check the certificate by its hash")
+			browserAddrs = append(browserAddrs, addr)
+		}
+	}
+	require.Len(t, browserAddrs, 2)
+	for _, addr := range browserAddrs {
+		client, err := libp2p.New(
+			libp2p.NoListenAddrs,
+			libp2p.Transport(libp2pwebtransport.New),
+			libp2p.Transport(libp2pwebrtc.New),
+		)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = client.Connect(ctx, peer.AddrInfo{ID: node.GetHost().ID(), Addrs: []ma.Multiaddr{addr}})
+		cancel()
+		assert.NoError(t, err, addr.String())
+		_ = client.Close()
+	}
+
+	// Without QUIC there is no UDP to run them over
+	t.Setenv(p2p.DisableQUICEnv, "1")
+	tcpOnly, err := p2p.NewPeerChatNode(context.Background(), config)
+	require.NoError(t, err)
+	defer tcpOnly.Stop()
+	for _, addr := range tcpOnly.GetHost().Addrs() {
+		assert.False(t, p2p.IsBrowserAddr(addr), addr.String())
+	}
+}
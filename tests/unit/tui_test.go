@@ -0,0 +1,120 @@
+package unit
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/cli"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// typeTUI types text into the full-screen chat and presses Enter, returning
+// what the model asked to run
+func typeTUI(m *cli.TUIModel, text string) tea.Cmd {
+	for _, r := range text {
+		m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	return pressTUI(m, tea.KeyEnter)
+}
+
+// pressTUI presses one key in the full-screen chat
+func pressTUI(m *cli.TUIModel, key tea.KeyType) tea.Cmd {
+	_, cmd := m.Update(tea.KeyMsg{Type: key})
+	return cmd
+}
+
+// isQuit reports whether cmd ends the full-screen chat
+func isQuit(cmd tea.Cmd) bool {
+	if cmd == nil {
+		return false
+	}
+	_, ok := cmd().(tea.QuitMsg)
+	return ok
+}
+
+func TestTUIModel(t *testing.T) {
+	alice := "12D3KooWAlice000000000000000000000000000000000000001"
+	bob := "12D3KooWBob00000000000000000000000000000000000000002"
+	type sent struct{ peerID, text string }
+	var sends []sent
+	m := cli.NewTUIModel(map[string]string{alice: "alice"}, func(peerID, text string) error {
+		sends = append(sends, sent{peerID, text})
+		return fmt.Errorf("%s is offline", peerID[:8])
+	})
+	m.Update(tea.WindowSizeMsg{Width: 80, Height: 12})
+
+	// Nothing to send to before a peer connects
+	assert.Nil(t, typeTUI(m, "hello"))
+	assert.Contains(t, m.View(), "No peer to send to yet")
+
+	m.Update(cli.TUIPeersMsg{Peers: []string{alice, bob}})
+	assert.Equal(t, alice, m.Selected(), "the first peer is selected")
+
+	// Sending runs in the background and reports failures on the status line
+	cmd := typeTUI(m, "  hi alice ")
+	require.NotNil(t, cmd)
+	m.Update(cmd())
+	assert.Equal(t, []sent{{alice, "hi alice"}}, sends)
+	assert.Contains(t, m.View(), "12D3KooW is offline")
+
+	// Messages for the peer not shown are counted as unread until selected
+	now := time.Date(2026, 10, 16, 9, 30, 0, 0, time.Local)
+	m.Update(cli.TUIMessageMsg{PeerID: bob, Text: "ping", Time: now})
+	m.Update(cli.TUIMessageMsg{PeerID: bob, Text: "pong", Time: now})
+	m.Update(cli.TUIMessageMsg{PeerID: alice, Text: "hey", Time: now})
+	assert.Equal(t, 2, m.Unread(bob))
+	assert.Equal(t, 0, m.Unread(alice))
+	view := m.View()
+	assert.Contains(t, view, "(2)")
+	assert.Contains(t, view, "you: hi alice")
+	assert.Contains(t, view, "09:30 alice: hey")
+
+	pressTUI(m, tea.KeyTab)
+	assert.Equal(t, bob, m.Selected())
+	assert.Equal(t, 0, m.Unread(bob))
+	assert.Contains(t, m.View(), "pong")
+	pressTUI(m, tea.KeyDown)
+	assert.Equal(t, alice, m.Selected(), "selection wraps around")
+	pressTUI(m, tea.KeyShiftTab)
+	assert.Equal(t, bob, m.Selected())
+	pressTUI(m, tea.KeyUp)
+
+	// A peer that drops stays in the sidebar, marked as not connected
+	m.Update(cli.TUIPeersMsg{Peers: []string{alice}})
+	assert.Contains(t, m.View(), "○ 12D3KooW")
+
+	// The screen is exactly the terminal size
+	rows := strings.Split(m.View(), "\n")
+	require.Len(t, rows, 12)
+	assert.True(t, strings.HasPrefix(rows[11], "> "))
+
+	// Paging up shows older lines, paging down returns to the newest
+	for i := 0; i < 20; i++ {
+		m.Update(cli.TUIMessageMsg{PeerID: alice, Text: fmt.Sprintf("line %d", i), Time: now})
+	}
+	assert.Contains(t, m.View(), "line 19")
+	pressTUI(m, tea.KeyPgUp)
+	view = m.View()
+	assert.NotContains(t, view, "line 19")
+	assert.Contains(t, view, "more below")
+	pressTUI(m, tea.KeyPgDown)
+	assert.Contains(t, m.View(), "line 19")
+
+	// New lines are followed, unless the conversation was scrolled back
+	m.Update(cli.TUIMessageMsg{PeerID: alice, Text: "line 20", Time: now})
+	assert.Contains(t, m.View(), "line 20")
+	pressTUI(m, tea.KeyPgUp)
+	m.Update(cli.TUIMessageMsg{PeerID: alice, Text: "line 21", Time: now})
+	assert.NotContains(t, m.View(), "line 21")
+
+	// Editing the input box, and quitting
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}})
+	pressTUI(m, tea.KeyBackspace)
+	assert.Nil(t, pressTUI(m, tea.KeyEnter))
+	assert.True(t, isQuit(typeTUI(m, "/quit")))
+	assert.True(t, isQuit(pressTUI(m, tea.KeyCtrlC)))
+}
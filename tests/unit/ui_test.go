@@ -0,0 +1,110 @@
+package unit
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Xelvra/peerchat/internal/ui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatterLine(t *testing.T) {
+	theme, err := ui.LookupTheme("dark")
+	require.NoError(t, err)
+	_, err = ui.LookupTheme("neon")
+	assert.Error(t, err)
+
+	color := ui.Formatter{Theme: theme, Color: true, Emoji: true}
+	assert.Equal(t, "\033[91m❌ Failed to start\033[0m\n", color.Line("❌ Failed to start\n"))
+	assert.Equal(t, "\033[93m⚠️  Ignoring config\033[0m\n", color.Line("⚠️  Ignoring config\n"))
+	assert.Equal(t, "\033[1m💬 Conversations:\033[0m\n", color.Line("💬 Conversations:\n"))
+	assert.Equal(t, "🔧 Initializing P2P node...\n", color.Line("🔧 Initializing P2P node...\n"))
+	assert.Equal(t, "  ● alice\n", color.Line("  ● alice\n"), "list markers are not emoji")
+
+	plain := ui.Formatter{Theme: theme, Emoji: false}
+	assert.Equal(t, "Error: Failed to start\n", plain.Line("❌ Failed to start\n"))
+	assert.Equal(t, "Warning: Ignoring config\n", plain.Line("⚠️  Ignoring config\n"))
+	assert.Equal(t, "Sent\n", plain.Line("✅ Sent\n"))
+	assert.Equal(t, "   tags\n", plain.Line("   🏷️  tags\n"), "indentation is kept")
+	assert.Equal(t, "no emoji here", plain.Line("no emoji here"))
+
+	assert.False(t, ui.Formatter{Theme: theme, Emoji: true}.Active())
+}
+
+func TestHelpers(t *testing.T) {
+	capture := func(format ui.Formatter, print func()) string {
+		t.Helper()
+		reader, writer, err := os.Pipe()
+		require.NoError(t, err)
+		stdout := os.Stdout
+		os.Stdout = writer
+		ui.Configure(format)
+		defer func() {
+			os.Stdout = stdout
+			ui.Configure(ui.Formatter{Emoji: true})
+		}()
+
+		print()
+		require.NoError(t, writer.Close())
+		out, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		return string(out)
+	}
+	all := func() {
+		ui.Error("Failed to start: %v", "port in use")
+		ui.Warn("Ignoring config")
+		ui.Success("Sent to %d peer(s)", 2)
+		ui.Info("Try again")
+		ui.Printf("\n📨 Message from %s:\n", "alice")
+		ui.Print("> ")
+	}
+
+	// Output is left as it is by default
+	assert.Equal(t, "❌ Failed to start: port in use\n⚠️  Ignoring config\n✅ Sent to 2 peer(s)\n💡 Try again\n\n📨 Message from alice:\n> ",
+		capture(ui.Formatter{Emoji: true}, all))
+
+	// Without emoji status lines get words, and every line is formatted
+	assert.Equal(t, "Error: Failed to start: port in use\nWarning: Ignoring config\nSent to 2 peer(s)\nHint: Try again\n\nMessage from alice:\n> ",
+		capture(ui.Formatter{Emoji: false}, all))
+
+	theme, err := ui.LookupTheme("mono")
+	require.NoError(t, err)
+	assert.Equal(t, "\033[1m❌ Failed\033[0m\n", capture(ui.Formatter{Theme: theme, Color: true, Emoji: true}, func() {
+		ui.Error("Failed")
+	}))
+
+	// Captured output is passed on unformatted, and stdout left alone
+	var captured strings.Builder
+	assert.Empty(t, capture(ui.Formatter{Emoji: false}, func() {
+		restore := ui.Capture(&captured)
+		defer restore()
+		all()
+	}))
+	assert.Equal(t, "❌ Failed to start: port in use\n⚠️  Ignoring config\n✅ Sent to 2 peer(s)\n💡 Try again\n\n📨 Message from alice:\n> ",
+		captured.String())
+
+	// Output moved off stdout is still formatted
+	var moved strings.Builder
+	assert.Empty(t, capture(ui.Formatter{Emoji: false}, func() {
+		ui.SetStdout(&moved)
+		defer ui.SetStdout(nil)
+		ui.Warn("Ignoring config")
+	}))
+	assert.Equal(t, "Warning: Ignoring config\n", moved.String())
+
+	// A Printer of its own keeps its output apart from what others print
+	var own, others strings.Builder
+	assert.Empty(t, capture(ui.Formatter{Emoji: false}, func() {
+		restore := ui.Capture(&others)
+		defer restore()
+		out := ui.To(&own)
+		out.Success("Sent to %d peer(s)", 2)
+		ui.Printf("\n📨 Message from %s:\n", "alice")
+		out.Info("Try again")
+	}))
+	assert.Equal(t, "✅ Sent to 2 peer(s)\n💡 Try again\n", own.String())
+	assert.Equal(t, "\n📨 Message from alice:\n", others.String())
+}
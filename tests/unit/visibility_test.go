@@ -0,0 +1,124 @@
+package unit
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPeerIDs returns the peer IDs of n fresh keys
+func testPeerIDs(t *testing.T, n int) []peer.ID {
+	ids := make([]peer.ID, n)
+	for i := range ids {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		ids[i], err = peer.IDFromPrivateKey(key)
+		require.NoError(t, err)
+	}
+	return ids
+}
+
+func TestParseVisibility(t *testing.T) {
+	for _, v := range p2p.Visibilities {
+		parsed, err := p2p.ParseVisibility(string(v))
+		require.NoError(t, err)
+		assert.Equal(t, v, parsed)
+	}
+	_, err := p2p.ParseVisibility("friends")
+	assert.ErrorContains(t, err, "unknown visibility")
+
+	assert.True(t, p2p.VisibilityEveryone.AnnouncesOnLAN())
+	assert.False(t, p2p.VisibilityContacts.AnnouncesOnLAN())
+	assert.False(t, p2p.VisibilityInvisible.AnnouncesOnLAN())
+}
+
+func TestVisibilityNamespaces(t *testing.T) {
+	ids := testPeerIDs(t, 3)
+	alice, bob, carol := ids[0], ids[1], ids[2]
+
+	assert.Equal(t, p2p.ContactNamespace(alice, bob), p2p.ContactNamespace(bob, alice))
+	assert.NotEqual(t, p2p.ContactNamespace(alice, bob), p2p.ContactNamespace(alice, carol))
+	assert.NotContains(t, p2p.ContactNamespace(alice, bob), alice.String())
+
+	assert.Equal(t, []string{p2p.PublicNamespace}, p2p.AnnounceNamespaces(p2p.VisibilityEveryone, alice, []peer.ID{bob}))
+	assert.Empty(t, p2p.AnnounceNamespaces(p2p.VisibilityInvisible, alice, []peer.ID{bob}))
+
+	// Alice knows Bob; Carol knows Bob but not Alice
+	contacts := p2p.AnnounceNamespaces(p2p.VisibilityContacts, alice, []peer.ID{bob})
+	circle := p2p.AnnounceNamespaces(p2p.VisibilityContactsOfContacts, alice, []peer.ID{bob})
+	bobLooks := p2p.LookupNamespaces(bob, []peer.ID{alice})
+	carolLooks := p2p.LookupNamespaces(carol, []peer.ID{bob})
+
+	assert.Subset(t, bobLooks, contacts, "a contact finds a contacts-only node")
+	assert.NotContains(t, contacts, p2p.PublicNamespace)
+	for _, namespace := range contacts {
+		assert.NotContains(t, carolLooks, namespace, "a contact of a contact does not")
+	}
+	assert.Contains(t, circle, p2p.ContactNamespace(alice, bob), "contacts find a contacts-of-contacts node too")
+	assert.Contains(t, carolLooks, p2p.CircleNamespace(bob), "a contact of a contact finds a contacts-of-contacts node")
+	assert.Contains(t, circle, p2p.CircleNamespace(bob))
+
+	many := make([]peer.ID, p2p.MaxAnnounceNamespaces+10)
+	for i := range many {
+		many[i] = bob
+	}
+	assert.Len(t, p2p.AnnounceNamespaces(p2p.VisibilityContacts, alice, many), p2p.MaxAnnounceNamespaces)
+	assert.Len(t, p2p.LookupNamespaces(alice, many), p2p.MaxAnnounceNamespaces)
+}
+
+func TestAnnounceLimiter(t *testing.T) {
+	limiter := p2p.NewAnnounceLimiter(time.Minute)
+	now := time.Now()
+	assert.Zero(t, limiter.Delay(now), "the first round runs at once")
+
+	limiter.Mark(now)
+	assert.Equal(t, 45*time.Second, limiter.Delay(now.Add(15*time.Second)))
+	assert.Zero(t, limiter.Delay(now.Add(time.Minute)))
+}
+
+func TestVisibilitySaved(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	v, err := p2p.LoadVisibility()
+	require.NoError(t, err)
+	assert.Equal(t, p2p.VisibilityEveryone, v)
+
+	require.NoError(t, p2p.SaveVisibility(p2p.VisibilityContacts))
+	v, err = p2p.LoadVisibility()
+	require.NoError(t, err)
+	assert.Equal(t, p2p.VisibilityContacts, v)
+
+	// An unreadable level keeps the node hidden
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".xelvra", "visibility"), []byte("garbage"), 0600))
+	v, err = p2p.LoadVisibility()
+	assert.Error(t, err)
+	assert.Equal(t, p2p.VisibilityInvisible, v)
+}
+
+func TestDiscoveryManagerVisibility(t *testing.T) {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	dm := p2p.NewDiscoveryManager(h, logger)
+	assert.Equal(t, p2p.VisibilityEveryone, dm.Visibility())
+	assert.Equal(t, string(p2p.VisibilityEveryone), dm.GetStatus().Visibility)
+
+	dm.SetVisibility(p2p.VisibilityInvisible)
+	assert.Equal(t, p2p.VisibilityInvisible, dm.Visibility())
+	assert.Equal(t, string(p2p.VisibilityInvisible), dm.GetStatus().Visibility)
+	assert.False(t, dm.GetStatus().MDNSActive)
+}
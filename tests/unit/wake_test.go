@@ -0,0 +1,95 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/p2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newWakeTestHost creates a host listening on localhost
+func newWakeTestHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+func TestWakeThroughHost(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	relay := newWakeTestHost(t)
+	wakeHost := p2p.ServeWake(relay, logger)
+	sleeper := newWakeTestHost(t)
+	sender := newWakeTestHost(t)
+
+	client, err := p2p.NewWakeClient(sleeper, fmt.Sprintf("%s/p2p/%s", relay.Addrs()[0], relay.ID()), logger)
+	require.NoError(t, err)
+	woken := make(chan peer.ID, 1)
+	client.OnWake = func(from peer.ID) { woken <- from }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+	require.Eventually(t, client.Registered, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 1, wakeHost.Clients())
+
+	require.NoError(t, sender.Connect(ctx, peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}))
+	ok, err := p2p.SendWake(ctx, sender, relay.ID(), sleeper.ID())
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	select {
+	case from := <-woken:
+		assert.Equal(t, sender.ID(), from)
+	case <-time.After(5 * time.Second):
+		t.Fatal("client was not woken")
+	}
+
+	// A second wake within the minimum interval is not passed on
+	ok, err = p2p.SendWake(ctx, sender, relay.ID(), sleeper.ID())
+	require.NoError(t, err)
+	assert.True(t, ok)
+	select {
+	case <-woken:
+		t.Fatal("client was woken twice")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Leaving drops the registration
+	cancel()
+	require.Eventually(t, func() bool { return wakeHost.Clients() == 0 }, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestWakeUnknownPeer(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	relay := newWakeTestHost(t)
+	p2p.ServeWake(relay, logger)
+	sender := newWakeTestHost(t)
+	stranger := newWakeTestHost(t)
+
+	ctx := context.Background()
+	require.NoError(t, sender.Connect(ctx, peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}))
+	ok, err := p2p.SendWake(ctx, sender, relay.ID(), stranger.ID())
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestNewWakeClientNeedsPeerID(t *testing.T) {
+	h := newWakeTestHost(t)
+	_, err := p2p.NewWakeClient(h, "/ip4/127.0.0.1/tcp/4001", logrus.New())
+	assert.Error(t, err)
+	_, err = p2p.NewWakeClient(h, "not an address", logrus.New())
+	assert.Error(t, err)
+}
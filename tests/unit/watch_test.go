@@ -0,0 +1,127 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// watchRecorder collects the files a watcher sends
+type watchRecorder struct {
+	mu    sync.Mutex
+	sent  []string
+	fails int // Number of sends to fail first
+}
+
+func (r *watchRecorder) send(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fails > 0 {
+		r.fails--
+		return errors.New("peer unreachable")
+	}
+	r.sent = append(r.sent, filepath.Base(path))
+	return nil
+}
+
+func (r *watchRecorder) files() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.sent...)
+}
+
+// startWatcher runs a watcher with short timings until the test ends
+func startWatcher(t *testing.T, dir string, opts message.WatchOptions, recorder *watchRecorder) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	opts.Debounce = 100 * time.Millisecond
+	watcher, err := message.NewDirWatcher(dir, opts, logger)
+	require.NoError(t, err)
+	watcher.PollInterval = 50 * time.Millisecond
+	watcher.RescanInterval = 200 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = watcher.Run(ctx, recorder.send)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Let the watcher record the initial state
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestDirWatcherFilters(t *testing.T) {
+	watcher, err := message.NewDirWatcher(t.TempDir(), message.WatchOptions{
+		Include: []string{"*.jpg", "logs/*.log"},
+		Exclude: []string{"skip-*"},
+	}, logrus.New())
+	require.NoError(t, err)
+
+	assert.True(t, watcher.Matches("photo.jpg"))
+	assert.True(t, watcher.Matches("2024/photo.jpg"))
+	assert.True(t, watcher.Matches("logs/app.log"))
+	assert.False(t, watcher.Matches("notes.txt"))
+	assert.False(t, watcher.Matches("skip-me.jpg"))
+	assert.False(t, watcher.Matches(".hidden.jpg"))
+	assert.False(t, watcher.Matches(".thumbnails/photo.jpg"))
+	assert.False(t, watcher.Matches("photo.jpg.part"))
+
+	_, err = message.NewDirWatcher(t.TempDir(), message.WatchOptions{Include: []string{"[bad"}}, logrus.New())
+	assert.Error(t, err)
+	_, err = message.NewDirWatcher(filepath.Join(t.TempDir(), "missing"), message.WatchOptions{}, logrus.New())
+	assert.Error(t, err)
+}
+
+func TestDirWatcherSendsSettledChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"old.txt": "already here"})
+
+	recorder := &watchRecorder{}
+	startWatcher(t, dir, message.WatchOptions{}, recorder)
+
+	// New files, including in new subdirectories, are sent once
+	writeTree(t, dir, map[string]string{"new.txt": "hello", "sub/deep.txt": "nested", "tmp.part": "partial"})
+	require.Eventually(t, func() bool { return len(recorder.files()) == 2 }, 5*time.Second, 20*time.Millisecond)
+	assert.ElementsMatch(t, []string{"new.txt", "deep.txt"}, recorder.files())
+
+	// A file that keeps changing is sent after it settles
+	path := filepath.Join(dir, "grow.log")
+	for i := 0; i < 5; i++ {
+		require.NoError(t, os.WriteFile(path, []byte(time.Now().String()), 0600))
+		time.Sleep(30 * time.Millisecond)
+	}
+	require.Eventually(t, func() bool { return len(recorder.files()) == 3 }, 5*time.Second, 20*time.Millisecond)
+
+	// Changing an existing file sends it; unchanged files are not resent
+	writeTree(t, dir, map[string]string{"old.txt": "changed"})
+	require.Eventually(t, func() bool { return len(recorder.files()) == 4 }, 5*time.Second, 20*time.Millisecond)
+	time.Sleep(400 * time.Millisecond)
+	assert.Equal(t, []string{"grow.log", "old.txt"}, recorder.files()[2:])
+}
+
+func TestDirWatcherExistingAndRetry(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.jpg": "a", "b.txt": "b"})
+
+	// The first attempt fails and is retried on the next scan
+	recorder := &watchRecorder{fails: 1}
+	startWatcher(t, dir, message.WatchOptions{Existing: true, Include: []string{"*.jpg"}}, recorder)
+
+	require.Eventually(t, func() bool { return len(recorder.files()) == 1 }, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, []string{"a.jpg"}, recorder.files())
+}
@@ -0,0 +1,128 @@
+package unit
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Xelvra/peerchat/internal/message"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryMessageFrame(t *testing.T) {
+	msg := &message.Message{
+		ID:        "wire-1",
+		Type:      message.MessageTypeText,
+		From:      "alice",
+		To:        "bob",
+		Content:   []byte("hello over protobuf"),
+		Metadata:  map[string]interface{}{"kind": "note", "count": 3},
+		Timestamp: time.Date(2025, time.March, 9, 18, 4, 5, 987654321, time.FixedZone("CET", 3600)),
+		Signature: []byte{1, 2, 3, 4},
+	}
+	payload, err := message.SigningPayload(msg)
+	require.NoError(t, err)
+
+	frame, err := message.EncodeBinaryFrame(msg)
+	require.NoError(t, err)
+	jsonFrame, err := message.EncodeFrame(msg)
+	require.NoError(t, err)
+	assert.NotEqual(t, byte('{'), frame[4])
+	assert.Less(t, len(frame), len(jsonFrame))
+
+	// The decoded message signs to the same bytes, offset included
+	var received message.Message
+	require.NoError(t, message.DecodeFrame(frame, &received))
+	receivedPayload, err := message.SigningPayload(&received)
+	require.NoError(t, err)
+	assert.Equal(t, string(payload), string(receivedPayload))
+	assert.Equal(t, msg.Signature, received.Signature)
+
+	// JSON frames still read
+	var legacy message.Message
+	require.NoError(t, message.DecodeFrame(jsonFrame, &legacy))
+	assert.Equal(t, msg.ID, legacy.ID)
+
+	// Empty content is not the same as none
+	msg.Content = []byte{}
+	frame, err = message.EncodeBinaryFrame(msg)
+	require.NoError(t, err)
+	require.NoError(t, message.DecodeFrame(frame, &received))
+	assert.NotNil(t, received.Content)
+
+	_, err = message.EncodeBinaryFrame(message.MessageReceipt{})
+	assert.Error(t, err)
+	assert.Error(t, message.DecodeFrame(append([]byte{0, 0, 0, 2}, 0x0a, 0x05), &received))
+}
+
+func TestBinaryFileFrame(t *testing.T) {
+	request := message.FileTransferRequest{
+		Magic: message.FileTransferMagic,
+		Type:  "request",
+		Metadata: message.FileMetadata{
+			ID:         "transfer-1",
+			Name:       "photo.jpg",
+			Size:       1 << 20,
+			Hash:       "abc",
+			MimeType:   "image/jpeg",
+			Timestamp:  time.Date(2025, time.March, 9, 18, 4, 5, 0, time.UTC),
+			ChunkCount: 16,
+			ChunkSize:  64 * 1024,
+		},
+		ChunkID: 7,
+		Offset:  7 * 64 * 1024,
+		Data:    []byte("chunk data"),
+		Receipt: &message.FileReceipt{TransferID: "transfer-1", Size: 1 << 20, ReceivedAt: time.Date(2025, time.March, 9, 18, 5, 0, 0, time.UTC)},
+		Streams: 4,
+	}
+
+	frame, err := message.EncodeBinaryFrame(request)
+	require.NoError(t, err)
+	var received message.FileTransferRequest
+	require.NoError(t, message.DecodeFrame(frame, &received))
+	assert.Equal(t, request, received)
+}
+
+func TestBinaryFramesNegotiated(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	senderHost, receiverHost := newConnectedHosts(t)
+	sending := newTestMessageManager(t, senderHost)
+	newTestMessageManager(t, receiverHost)
+
+	agreed, err := sending.PeerCapabilities(receiverHost.ID())
+	require.NoError(t, err)
+	require.True(t, agreed.Supports(message.FeatureBinaryFrames))
+
+	// A peer that agreed gets protobuf frames
+	bodies := make(chan []byte, 1)
+	record := func(s network.Stream) {
+		defer func() { _ = s.Close() }()
+		var length uint32
+		if err := binary.Read(s, binary.BigEndian, &length); err != nil {
+			return
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(s, data); err == nil {
+			bodies <- data
+		}
+	}
+	receiverHost.SetStreamHandler(message.MessageProtocolID, record)
+	receiverHost.SetStreamHandler(message.MessageStreamProtocolID, record)
+
+	notice := message.NewNotice(message.NoticeMemberLeft, "group", "Friends", "member", "carol")
+	_ = sending.SendNotice(receiverHost.ID().String(), notice)
+	select {
+	case body := <-bodies:
+		assert.NotEqual(t, byte('{'), body[0])
+		frame := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+		var msg message.Message
+		require.NoError(t, message.DecodeFrame(append(frame, body...), &msg))
+		assert.Contains(t, string(msg.Content), "carol")
+		assert.NotEmpty(t, msg.Signature)
+	case <-time.After(10 * time.Second):
+		t.Fatal("notice was not delivered")
+	}
+}